@@ -11,13 +11,18 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
@@ -26,11 +31,16 @@ import (
 
 type Agent struct {
 	leadConn connect.Client
-	nodeConn *mongo.Client
+	// nodeConn is the direct connection to the mongod of the agent.
+	// It's replaced on reconnect (see ConnWatchdog), use nodeClient.
+	nodeConn atomic.Pointer[mongo.Client]
 	bcp      *currentBackup
 	pitrjob  *currentPitr
 	slicerMx sync.Mutex
 	bcpMx    sync.Mutex
+	// rebalance cancels the running rebalance
+	rebalance   context.CancelFunc
+	rebalanceMx sync.Mutex
 
 	brief topo.NodeBrief
 
@@ -39,6 +49,23 @@ type Agent struct {
 	closeCMD chan struct{}
 	pauseHB  int32
 
+	// draining is set on the agent shutdown
+	draining int32
+	// jobs is the number of in-flight jobs (backups, oplog slicing)
+	// the shutdown waits for
+	jobs int32
+
+	health agentHealth
+	privs  privilegesCache
+	clock  clockSkew
+	// wcEpoch is the config epoch the write concern is set by
+	wcEpoch primitive.Timestamp
+	// started is when the agent has been started
+	started time.Time
+
+	// connGen is incremented each time the connections are rebuilt
+	connGen atomic.Int64
+
 	monMx sync.Mutex
 	// signal for stopping pitr monitor jobs and flag that jobs are started/stopped
 	monStopSig chan struct{}
@@ -68,7 +95,6 @@ func newAgent(
 	a := &Agent{
 		leadConn: leadConn,
 		closeCMD: make(chan struct{}),
-		nodeConn: nodeConn,
 		brief: topo.NodeBrief{
 			URI:       uri,
 			SetName:   info.SetName,
@@ -78,7 +104,9 @@ func newAgent(
 			Version:   mongoVersion,
 		},
 		numParallelColls: numParallelColls,
+		started:          time.Now(),
 	}
+	a.nodeConn.Store(nodeConn)
 	return a, nil
 }
 
@@ -88,7 +116,7 @@ var (
 )
 
 func (a *Agent) CanStart(ctx context.Context) error {
-	info, err := topo.GetNodeInfo(ctx, a.nodeConn)
+	info, err := topo.GetNodeInfo(ctx, a.nodeClient())
 	if err != nil {
 		return errors.Wrap(err, "get node info")
 	}
@@ -151,9 +179,11 @@ func (a *Agent) Start(ctx context.Context) error {
 	logger := log.FromContext(ctx)
 	logger.Printf("pbm-agent:\n%s", version.Current().All(""))
 	logger.Printf("node: %s/%s", a.brief.SetName, a.brief.Me)
+	ep, _ := config.GetEpoch(ctx, a.leadConn)
+	a.setWriteConcern(ctx, ep)
 	logger.Printf("conn level ReadConcern: %v; WriteConcern: %v",
 		a.leadConn.MongoOptions().ReadConcern.Level,
-		a.leadConn.MongoOptions().WriteConcern.W)
+		a.leadConn.WriteConcern().W)
 
 	c, cerr := ctrl.ListenCmd(ctx, a.leadConn, a.closeCMD)
 
@@ -176,11 +206,16 @@ func (a *Agent) Start(ctx context.Context) error {
 			}
 
 			logger.Printf("got epoch %v", ep)
+			a.setWriteConcern(ctx, ep)
 
 			switch cmd.Cmd {
 			case ctrl.CmdBackup:
 				// backup runs in the go-routine so it can be canceled
-				go a.Backup(ctx, cmd.Backup, cmd.OPID, ep)
+				a.jobStarted()
+				go func() {
+					defer a.jobDone()
+					a.Backup(ctx, cmd.Backup, cmd.OPID, ep)
+				}()
 			case ctrl.CmdCancelBackup:
 				a.CancelBackup()
 			case ctrl.CmdRestore:
@@ -199,6 +234,39 @@ func (a *Agent) Start(ctx context.Context) error {
 				a.DeletePITR(ctx, cmd.DeletePITR, cmd.OPID, ep)
 			case ctrl.CmdCleanup:
 				a.Cleanup(ctx, cmd.Cleanup, cmd.OPID, ep)
+			case ctrl.CmdPITRCompact:
+				a.PITRCompact(ctx, cmd.PITRCompact, cmd.OPID, ep)
+			case ctrl.CmdRebalance:
+				// rebalance runs in the go-routine so it can be canceled
+				a.jobStarted()
+				go func() {
+					defer a.jobDone()
+					a.Rebalance(ctx, cmd.Rebalance, cmd.OPID, ep)
+				}()
+			case ctrl.CmdCancelRebalance:
+				a.CancelRebalance()
+			case ctrl.CmdStorageMigrate:
+				a.jobStarted()
+				go func() {
+					defer a.jobDone()
+					a.StorageMigrate(ctx, cmd.Migrate, cmd.OPID, ep)
+				}()
+			case ctrl.CmdCancelRestore:
+				a.CancelRestore(ctx, cmd.CancelRestore, cmd.OPID, ep)
+			case ctrl.CmdVerifyRestore:
+				a.jobStarted()
+				go func() {
+					defer a.jobDone()
+					a.VerifyRestore(ctx, cmd.VerifyRestore, cmd.OPID, ep)
+				}()
+			case ctrl.CmdPITRVerify:
+				a.jobStarted()
+				go func() {
+					defer a.jobDone()
+					a.PITRVerify(ctx, cmd.PITRVerify, cmd.OPID, ep)
+				}()
+			case ctrl.CmdDumpState:
+				go a.DumpState(ctx, "command", cmd.OPID)
 			}
 		case err, ok := <-cerr:
 			if !ok {
@@ -206,12 +274,11 @@ func (a *Agent) Start(ctx context.Context) error {
 				return nil
 			}
 
-			if errors.Is(err, ctrl.CursorClosedError{}) {
-				return errors.Wrap(err, "stop listening")
-			}
-
 			ep, _ := config.GetEpoch(ctx, a.leadConn)
 			logger.Error("", "", "", ep.TS(), "listening commands: %v", err)
+
+			// the listening goes on from the same point. If the connection
+			// is dead, it's rebuilt by ConnWatchdog
 		}
 	}
 }
@@ -219,6 +286,16 @@ func (a *Agent) Start(ctx context.Context) error {
 // acquireLock tries to acquire the lock. If there is a stale lock
 // it tries to mark op that held the lock (backup, [pitr]restore) as failed.
 func (a *Agent) acquireLock(ctx context.Context, l *lock.Lock, lg log.LogEvent) (bool, error) {
+	got, err := a.tryAcquireLock(ctx, l, lg)
+	if err != nil {
+		metrics.LockAcquireFailures.Inc(string(l.Type), "error")
+	}
+	return got, err
+}
+
+func (a *Agent) tryAcquireLock(ctx context.Context, l *lock.Lock, lg log.LogEvent) (bool, error) {
+	l.SetStaleSec(lock.StaleSec(ctx, a.leadConn))
+
 	got, err := l.Acquire(ctx)
 	if err == nil {
 		return got, nil
@@ -226,6 +303,11 @@ func (a *Agent) acquireLock(ctx context.Context, l *lock.Lock, lg log.LogEvent)
 
 	if errors.Is(err, lock.DuplicatedOpError{}) || errors.Is(err, lock.ConcurrentOpError{}) {
 		lg.Debug("get lock: %v", err)
+		reason := "concurrent"
+		if errors.Is(err, lock.DuplicatedOpError{}) {
+			reason = "duplicated"
+		}
+		metrics.LockAcquireFailures.Inc(string(l.Type), reason)
 		return false, nil
 	}
 
@@ -234,20 +316,16 @@ func (a *Agent) acquireLock(ctx context.Context, l *lock.Lock, lg log.LogEvent)
 		return false, err
 	}
 
+	// the stale lock is already deleted, take over its operation
 	lck := er.Lock
-	lg.Debug("stale lock: %v", lck)
-	var fn func(context.Context, *lock.Lock, string) error
-	switch lck.Type {
-	case ctrl.CmdBackup:
-		fn = markBcpStale
-	case ctrl.CmdRestore:
-		fn = markRestoreStale
-	default:
-		return l.Acquire(ctx)
-	}
+	lg.Warning("reclaimed stale lock of %s [opid: %s, replset: %s, node: %s], last heartbeat at %s",
+		lck.Type, lck.OPID, lck.Replset, lck.Node,
+		time.Unix(int64(er.Heartbeat.T), 0).UTC().Format(time.RFC3339))
 
-	if err := fn(ctx, l, lck.OPID); err != nil {
-		lg.Warning("failed to mark stale op '%s' as failed: %v", lck.OPID, err)
+	if mark := staleOpMarker(lck.Type); mark != nil {
+		if err := mark(ctx, a.leadConn, er, a.brief.Me, lg); err != nil {
+			lg.Warning("failed to clean up stale op '%s': %v", lck.OPID, err)
+		}
 	}
 
 	return l.Acquire(ctx)
@@ -270,21 +348,31 @@ func (a *Agent) HbStatus(ctx context.Context) {
 	l := logger.NewEvent("agentCheckup", "", "", primitive.Timestamp{})
 	ctx = log.SetLogEventToContext(ctx, l)
 
-	nodeVersion, err := version.GetMongoVersion(ctx, a.nodeConn)
-	if err != nil {
-		l.Error("get mongo version: %v", err)
+	hb := topo.AgentStat{
+		Node:     a.brief.Me,
+		RS:       a.brief.SetName,
+		AgentVer: version.Current().Version,
 	}
 
-	hb := topo.AgentStat{
-		Node:       a.brief.Me,
-		RS:         a.brief.SetName,
-		AgentVer:   version.Current().Version,
-		MongoVer:   nodeVersion.VersionString,
-		PerconaVer: nodeVersion.PSMDBVersion,
+	// register makes the full status of the agent. The mongod could be
+	// upgraded in between if it's done again after a reconnect.
+	register := func() error {
+		nodeVersion, err := version.GetMongoVersion(ctx, a.nodeClient())
+		if err != nil {
+			l.Error("get mongo version: %v", err)
+		}
+		hb.MongoVer = nodeVersion.VersionString
+		hb.PerconaVer = nodeVersion.PSMDBVersion
+
+		updateAgentStat(ctx, a, l, true, &hb)
+		a.updateOplogWindow(ctx, l, &hb)
+		a.updatePrivileges(ctx, l, &hb)
+		a.health.setStatus(&hb, true, time.Now())
+		return topo.SetAgentStatus(ctx, a.leadConn, &hb)
 	}
 
-	updateAgentStat(ctx, a, l, true, &hb)
-	err = topo.SetAgentStatus(ctx, a.leadConn, &hb)
+	connGen := a.connGen.Load()
+	err := register()
 	if err != nil {
 		l.Error("set status: %v", err)
 	}
@@ -303,10 +391,12 @@ func (a *Agent) HbStatus(ctx context.Context) {
 
 	storageCheckTime := time.Now()
 	parallelAgentCheckTime := time.Now()
+	oplogWindowCheckTime := time.Now()
 
 	// check storage once in a while if all is ok (see https://jira.percona.com/browse/PBM-647)
 	const storageCheckInterval = 15 * time.Second
 	const parallelAgentCheckInternval = time.Minute
+	const oplogWindowCheckInterval = time.Minute
 
 	for {
 		select {
@@ -315,23 +405,47 @@ func (a *Agent) HbStatus(ctx context.Context) {
 		case <-tk.C:
 			// don't check if on pause (e.g. physical restore)
 			if !a.HbIsRun() {
+				a.health.setTick(time.Now())
 				continue
 			}
 
 			now := time.Now()
+			if gen := a.connGen.Load(); gen != connGen {
+				// the connection is rebuilt: the node could be restarted
+				// (or upgraded) while the status wasn't updated
+				err := register()
+				if err != nil {
+					l.Error("set status: %v", err)
+					continue
+				}
+				l.Info("agent status registered again after reconnect")
+				connGen = gen
+				storageCheckTime = now
+				oplogWindowCheckTime = now
+				continue
+			}
 			if now.Sub(parallelAgentCheckTime) >= parallelAgentCheckInternval {
 				a.warnIfParallelAgentDetected(ctx, l, hb.Heartbeat)
 				parallelAgentCheckTime = now
 			}
+			if now.Sub(oplogWindowCheckTime) >= oplogWindowCheckInterval {
+				a.updateOplogWindow(ctx, l, &hb)
+				a.updatePrivileges(ctx, l, &hb)
+				oplogWindowCheckTime = now
+			}
 
 			if now.Sub(storageCheckTime) >= storageCheckInterval {
 				updateAgentStat(ctx, a, l, true, &hb)
+				a.health.setStatus(&hb, true, now)
 				err = topo.SetAgentStatus(ctx, a.leadConn, &hb)
 				if err == nil {
 					storageCheckTime = now
 				}
 			} else {
+				// a failed storage is checked on every tick
+				stgChecked := !hb.StorageStatus.OK
 				updateAgentStat(ctx, a, l, false, &hb)
+				a.health.setStatus(&hb, stgChecked, now)
 				err = topo.SetAgentStatus(ctx, a.leadConn, &hb)
 			}
 			if err != nil {
@@ -341,6 +455,22 @@ func (a *Agent) HbStatus(ctx context.Context) {
 	}
 }
 
+// updateOplogWindow reads the oplog window of the node. The last read
+// window is kept on errors.
+func (a *Agent) updateOplogWindow(ctx context.Context, l log.LogEvent, hb *topo.AgentStat) {
+	if hb.Arbiter {
+		hb.OplogWindow = nil
+		return
+	}
+
+	w, err := oplog.GetNodeWindow(ctx, a.nodeClient())
+	if err != nil {
+		l.Warning("get oplog window: %v", err)
+		return
+	}
+	hb.OplogWindow = w
+}
+
 func updateAgentStat(
 	ctx context.Context,
 	agent *Agent,
@@ -360,8 +490,11 @@ func updateAgentStat(
 	hb.Err = ""
 	hb.Hidden = false
 	hb.Passive = false
+	hb.Tags = nil
 
-	inf, err := topo.GetNodeInfo(ctx, agent.nodeConn)
+	sent := time.Now()
+	inf, err := topo.GetNodeInfo(ctx, agent.nodeClient())
+	at := sent.Add(time.Since(sent) / 2)
 	if err != nil {
 		l.Error("get NodeInfo: %v", err)
 		hb.Err += fmt.Sprintf("get NodeInfo: %v", err)
@@ -369,6 +502,7 @@ func updateAgentStat(
 		hb.Hidden = inf.Hidden
 		hb.Passive = inf.Passive
 		hb.Arbiter = inf.ArbiterOnly
+		hb.Tags = inf.Tags
 		if inf.SecondaryDelayOld != 0 {
 			hb.DelaySecs = inf.SecondaryDelayOld
 		} else {
@@ -378,6 +512,9 @@ func updateAgentStat(
 		hb.Heartbeat, err = topo.ClusterTimeFromNodeInfo(inf)
 		if err != nil {
 			hb.Err += fmt.Sprintf("get cluster time: %v", err)
+		} else {
+			off := agent.clock.add(at, hb.Heartbeat)
+			hb.ClockOffsetSec = &off
 		}
 	}
 
@@ -385,7 +522,7 @@ func updateAgentStat(
 		hb.State = defs.NodeStateArbiter
 		hb.StateStr = "ARBITER"
 	} else {
-		n, err := topo.GetNodeStatus(ctx, agent.nodeConn, agent.brief.Me)
+		n, err := topo.GetNodeStatus(ctx, agent.nodeClient(), agent.brief.Me)
 		if err != nil {
 			l.Error("get replSetGetStatus: %v", err)
 			hb.Err += fmt.Sprintf("get replSetGetStatus: %v", err)
@@ -395,7 +532,7 @@ func updateAgentStat(
 			hb.State = n.State
 			hb.StateStr = n.StateStr
 
-			rLag, err := topo.ReplicationLag(ctx, agent.nodeConn, agent.brief.Me)
+			rLag, err := topo.ReplicationLag(ctx, agent.nodeClient(), agent.brief.Me)
 			if err != nil {
 				l.Error("get replication lag: %v", err)
 				hb.Err += fmt.Sprintf("get replication lag: %v", err)
@@ -426,6 +563,37 @@ func (a *Agent) warnIfParallelAgentDetected(
 	}
 }
 
+// setMemoryBudget applies `agent.maxMemoryMB` to the buffers of the agent.
+// The operations started before keep their buffers.
+func setMemoryBudget(cfg *config.Config) {
+	memory.Default.SetLimit(cfg.Agent.MaxMemory())
+}
+
+// setDecompression applies `restore.strictCompression` to the files read by
+// the restore.
+func setDecompression(cfg *config.Config) {
+	compress.SetDetection(cfg.Restore.IsStrictCompression())
+}
+
+// setWriteConcern applies `cluster.writeConcern` of the config epoch
+// to the writes of the agent to the PBM collections.
+func (a *Agent) setWriteConcern(ctx context.Context, ep config.Epoch) {
+	if a.wcEpoch.Equal(ep.TS()) {
+		return
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.FromContext(ctx).Warning("", "", "", ep.TS(), "set write concern: get config: %v", err)
+		}
+		return
+	}
+
+	a.leadConn.SetWriteConcern(cfg.WriteConcern())
+	a.wcEpoch = ep.TS()
+}
+
 func (a *Agent) pbmStatus(ctx context.Context) topo.SubsysStatus {
 	err := a.leadConn.MongoClient().Ping(ctx, nil)
 	if err != nil {
@@ -436,7 +604,7 @@ func (a *Agent) pbmStatus(ctx context.Context) topo.SubsysStatus {
 }
 
 func (a *Agent) nodeStatus(ctx context.Context) topo.SubsysStatus {
-	err := a.nodeConn.Ping(ctx, nil)
+	err := a.nodeClient().Ping(ctx, nil)
 	if err != nil {
 		return topo.SubsysStatus{Err: err.Error()}
 	}
@@ -457,6 +625,7 @@ func (a *Agent) storStatus(
 	}
 
 	stg, err := util.GetStorage(ctx, a.leadConn, a.brief.Me, log)
+	a.health.setConfigErr(err)
 	if err != nil {
 		return topo.SubsysStatus{Err: fmt.Sprintf("unable to get storage: %v", err)}
 	}
@@ -470,6 +639,11 @@ func (a *Agent) storStatus(
 		log.Warning("storage is not initialized")
 	}
 
+	err = encrypt.Check(ctx, stg)
+	if err != nil {
+		return topo.SubsysStatus{Err: fmt.Sprintf("encryption keys check failed with: %v", err)}
+	}
+
 	return topo.SubsysStatus{OK: true}
 }
 
@@ -478,3 +652,51 @@ func logHbStatus(name string, st topo.SubsysStatus, l log.LogEvent) {
 		l.Error("check %s: %s", name, st.Err)
 	}
 }
+
+// nodeClient returns the current connection to the mongod of the agent
+func (a *Agent) nodeClient() *mongo.Client {
+	return a.nodeConn.Load()
+}
+
+// setConfigVar sets the config key as config.SetConfigVar does and records
+// the change into the config history with the source. The config is set
+// already if the history fails, so it's only logged.
+func (a *Agent) setConfigVar(ctx context.Context, key, val, source string, l log.LogEvent) error {
+	oldCfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		l.Warning("record config history: get config: %v", err)
+		oldCfg = nil
+	}
+
+	err = config.SetConfigVar(ctx, a.leadConn, key, val)
+	if err != nil || oldCfg == nil {
+		return err
+	}
+
+	newCfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		l.Warning("record config history: get applied config: %v", err)
+		return nil
+	}
+	changes, err := config.Diff(oldCfg, newCfg)
+	if err != nil {
+		l.Warning("record config history: diff: %v", err)
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	err = config.AddHistory(ctx, a.leadConn, &config.HistoryEntry{
+		Time:    time.Now().Unix(),
+		User:    ctrl.NewInitiator(ctx, a.leadConn).String(),
+		Source:  source,
+		Changes: changes,
+		Config:  newCfg,
+	})
+	if err != nil {
+		l.Warning("record config history: %v", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
@@ -11,6 +13,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
 	"github.com/percona/percona-backup-mongodb/pbm/prio"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
@@ -18,7 +21,8 @@ import (
 )
 
 type currentBackup struct {
-	cancel context.CancelFunc
+	name   string
+	cancel context.CancelCauseFunc
 }
 
 func (a *Agent) setBcp(b *currentBackup) {
@@ -28,8 +32,21 @@ func (a *Agent) setBcp(b *currentBackup) {
 	a.bcp = b
 }
 
+func (a *Agent) getBcp() *currentBackup {
+	a.bcpMx.Lock()
+	defer a.bcpMx.Unlock()
+
+	return a.bcp
+}
+
 // CancelBackup cancels current backup
 func (a *Agent) CancelBackup() {
+	a.cancelBackup(nil)
+}
+
+// cancelBackup cancels current backup with the cause.
+// The cause is saved as the backup error.
+func (a *Agent) cancelBackup(cause error) {
 	a.bcpMx.Lock()
 	defer a.bcpMx.Unlock()
 
@@ -37,7 +54,7 @@ func (a *Agent) CancelBackup() {
 		return
 	}
 
-	a.bcp.cancel()
+	a.bcp.cancel(cause)
 	a.bcp = nil
 }
 
@@ -54,7 +71,7 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 	l := logger.NewEvent(string(ctrl.CmdBackup), cmd.Name, opid.String(), ep.TS())
 	ctx = log.SetLogEventToContext(ctx, l)
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info: %v", err)
 		return
@@ -65,9 +82,16 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 	}
 
 	isClusterLeader := nodeInfo.IsClusterLeader()
+	// the cluster leader inits the backup of a single replset
+	// but only the nodes of the replset take part in it
+	inBackup := cmd.Replset == "" || cmd.Replset == nodeInfo.SetName
+	if !inBackup && !isClusterLeader {
+		l.Debug("replset %s isn't in the backup. skip", nodeInfo.SetName)
+		return
+	}
 
 	if isClusterLeader {
-		moveOn, err := a.startBcpLockCheck(ctx)
+		moveOn, err := a.startBcpLockCheck(ctx, &lock.LockHeader{Type: ctrl.CmdBackup, Storage: cmd.Profile})
 		if err != nil {
 			l.Error("start backup lock check: %v", err)
 			return
@@ -78,13 +102,19 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		}
 	}
 
-	canRunBackup, err := topo.NodeSuitsExt(ctx, a.nodeConn, nodeInfo, cmd.Type)
+	canRunBackup, err := topo.NodeSuitsExt(ctx, a.nodeClient(), nodeInfo, cmd.Type)
 	if err != nil {
 		l.Error("node check: %v", err)
 		if errors.Is(err, context.Canceled) || !isClusterLeader {
 			return
 		}
 	}
+	if canRunBackup {
+		if err := a.checkPrivileges(ctx, topo.PrivilegeOpBackup); err != nil {
+			l.Warning("%v", err)
+			canRunBackup = false
+		}
+	}
 	if !canRunBackup {
 		l.Info("node is not suitable for backup")
 		if !isClusterLeader {
@@ -102,15 +132,20 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		l.Error("get profiled config: %v", err)
 		return
 	}
+	setMemoryBudget(cfg)
+
+	if cmd.Type == defs.LogicalBackup && cfg.Backup.Quiesce.IsEnabled() {
+		l.Warning("backup.quiesce is applicable only to physical backups. ignored")
+	}
 
 	var bcp *backup.Backup
 	switch cmd.Type {
 	case defs.PhysicalBackup:
-		bcp = backup.NewPhysical(a.leadConn, a.nodeConn, a.brief)
+		bcp = backup.NewPhysical(a.leadConn, a.nodeClient(), a.brief)
 	case defs.ExternalBackup:
-		bcp = backup.NewExternal(a.leadConn, a.nodeConn, a.brief)
+		bcp = backup.NewExternal(a.leadConn, a.nodeClient(), a.brief)
 	case defs.IncrementalBackup:
-		bcp = backup.NewIncremental(a.leadConn, a.nodeConn, a.brief, cmd.IncrBase)
+		bcp = backup.NewIncremental(a.leadConn, a.nodeClient(), a.brief, cmd.IncrBase)
 	case defs.LogicalBackup:
 		fallthrough
 	default:
@@ -118,13 +153,14 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		if cfg.Backup != nil && cfg.Backup.NumParallelCollections > 0 {
 			numParallelColls = cfg.Backup.NumParallelCollections
 		}
-		bcp = backup.New(a.leadConn, a.nodeConn, a.brief, numParallelColls)
+		bcp = backup.New(a.leadConn, a.nodeClient(), a.brief, numParallelColls)
 	}
 
 	bcp.SetConfig(cfg)
 	bcp.SetMongoVersion(a.brief.Version.VersionString)
 	bcp.SetSlicerInterval(cfg.BackupSlicerInterval())
 	bcp.SetTimeouts(cfg.Backup.Timeouts)
+	bcp.SetSingleReplset(cmd.Replset)
 
 	if isClusterLeader {
 		balancer := topo.BalancerModeOff
@@ -138,19 +174,39 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 				balancer = topo.BalancerModeOn
 			}
 		}
-		err = bcp.Init(ctx, cmd, opid, balancer)
+		err = bcp.Init(ctx, cmd, opid, balancer, l)
 		if err != nil {
 			l.Error("init meta: %v", err)
 			return
 		}
 		l.Debug("init backup meta")
 
-		if err = topo.CheckTopoForBackup(ctx, a.leadConn, cmd.Type); err != nil {
-			ferr := backup.ChangeBackupState(a.leadConn, cmd.Name, defs.StatusError, err.Error())
-			l.Info("mark backup as %s `%v`: %v", defs.StatusError, err, ferr)
+		err = topo.CheckTopoForBackup(ctx, a.leadConn, cmd.Type, cmd.Replset)
+		if err == nil {
+			// the replsets data must be restorable together
+			err = config.CheckReplsetStorages(ctx, a.leadConn, cfg)
+		}
+		if err == nil {
+			err = a.checkOplogWindows(ctx, cfg, cmd)
+		}
+		if err == nil {
+			err = a.checkBackupPrivileges(ctx, cmd)
+		}
+		if err == nil {
+			err = a.checkClockSkew(ctx, cfg, cmd.Replset)
+		}
+		if err != nil {
+			a.failBackup(ctx, cfg, cmd, opid, err)
 			return
 		}
 
+		a.notifyWith(ctx, cfg, &notify.Payload{
+			Event: notify.BackupStarted,
+			Name:  cmd.Name,
+			Type:  string(cmd.Type),
+			OPID:  opid.String(),
+		})
+
 		// Incremental backup history is stored by WiredTiger on the node
 		// not replset. So an `incremental && not_base` backup should land on
 		// the agent that made a previous (src) backup.
@@ -175,9 +231,7 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 			return
 		}
 
-		candidates := a.getValidCandidates(agents, cmd.Type)
-
-		nodes := prio.CalcNodesPriority(c, cfg.Backup.Priority, candidates)
+		candidates := clockSynced(cfg, a.getValidCandidates(agents, cmd.Type), l)
 
 		shards, err := topo.ClusterMembers(ctx, a.leadConn.MongoClient())
 		if err != nil {
@@ -185,7 +239,20 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 			return
 		}
 
+		if cmd.Type == defs.LogicalBackup {
+			candidates, err = dumpCandidates(cfg, shards, cmd.Replset, candidates)
+			if err != nil {
+				a.failBackup(ctx, cfg, cmd, opid, err)
+				return
+			}
+		}
+
+		nodes := prio.CalcNodesPriority(c, cfg.Backup.Priority, candidates)
+
 		for _, sh := range shards {
+			if cmd.Replset != "" && sh.RS != cmd.Replset {
+				continue
+			}
 			go func(rs string) {
 				if err := a.nominateRS(ctx, cmd.Name, rs, nodes.RS(rs)); err != nil {
 					l.Error("nodes nomination error for %s: %v", rs, err)
@@ -194,6 +261,11 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		}
 	}
 
+	if !inBackup {
+		l.Debug("replset %s isn't in the backup. skip", nodeInfo.SetName)
+		return
+	}
+
 	nominated, err := a.waitNomination(ctx, cmd.Name)
 	if err != nil {
 		l.Error("wait for nomination: %v", err)
@@ -210,6 +282,7 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		Node:    a.brief.Me,
 		OPID:    opid.String(),
 		Epoch:   &epoch,
+		Storage: cmd.Profile,
 	})
 
 	got, err := a.acquireLock(ctx, lck, l)
@@ -234,14 +307,21 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 		l.Warning("set nominee ack: %v", err)
 	}
 
-	bcpCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	bcpCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	a.setBcp(&currentBackup{cancel: cancel})
+	a.setBcp(&currentBackup{name: cmd.Name, cancel: cancel})
 	defer a.setBcp(nil)
 
+	// the backup leader aborts the whole backup running out of its time
+	watchCtx, stopWatch := context.WithCancel(bcpCtx)
+	if bcp.IsLeader(nodeInfo) {
+		go a.watchBackupWindow(watchCtx, ctx, cmd.Name)
+	}
+
 	l.Info("backup started")
 	err = bcp.Run(bcpCtx, cmd, opid, l)
+	stopWatch()
 	if err != nil {
 		if errors.Is(err, storage.ErrCancelled) || errors.Is(err, context.Canceled) {
 			l.Info("backup was canceled")
@@ -251,6 +331,179 @@ func (a *Agent) Backup(ctx context.Context, cmd *ctrl.BackupCmd, opid ctrl.OPID,
 	} else {
 		l.Info("backup finished")
 	}
+
+	// the backup of the leader replset defines the cluster backup status
+	if bcp.IsLeader(nodeInfo) {
+		a.notifyBackupDone(ctx, cfg, cmd.Name, err)
+	}
+}
+
+// watchBackupWindow cancels the backup once it runs longer than its max
+// duration and sends the cancel command to the rest of the cluster. The
+// command goes with the agent context (ctx) as the backup one is canceled.
+func (a *Agent) watchBackupWindow(watchCtx, ctx context.Context, name string) {
+	l := log.LogEventFromContext(ctx)
+
+	meta, err := backup.NewDBManager(a.leadConn).GetBackupByName(watchCtx, name)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			l.Warning("backup window: get backup meta: %v", err)
+		}
+		return
+	}
+	deadline, ok := meta.Deadline()
+	if !ok {
+		return
+	}
+	l.Debug("backup window: abort at %s", deadline.UTC().Format(time.RFC3339))
+
+	tmr := time.NewTimer(time.Until(deadline))
+	defer tmr.Stop()
+
+	select {
+	case <-watchCtx.Done():
+		return
+	case <-tmr.C:
+	}
+
+	l.Warning("backup has run longer than %v, aborting", meta.MaxDuration())
+	a.cancelBackup(backup.WindowError{MaxDuration: meta.MaxDuration()})
+
+	_, err = ctrl.SendCancelBackup(ctx, a.leadConn)
+	if err != nil {
+		l.Error("backup window: send cancel backup: %v", err)
+	}
+}
+
+func (a *Agent) notifyBackupDone(ctx context.Context, cfg *config.Config, name string, runErr error) {
+	meta, err := backup.NewDBManager(a.leadConn).GetBackupByName(ctx, name)
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("notify: get backup meta: %v", err)
+		return
+	}
+	err = backup.LoadNSStats(meta, a.brief.Me, log.LogEventFromContext(ctx))
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("notify: read namespaces stats: %v", err)
+	}
+	p := backupPayload(meta)
+	if p.Event == notify.BackupFailed && runErr != nil && meta.Err == "" {
+		p.Error = runErr.Error()
+	}
+
+	a.notifyWith(ctx, cfg, p)
+}
+
+// checkOplogWindows warns if the estimated backup duration doesn't fit into
+// the oplog window of a replset (see `backup.oplogWindowCheck`). The error is
+// returned only if the check is set to fail the backup.
+func (a *Agent) checkOplogWindows(ctx context.Context, cfg *config.Config, cmd *ctrl.BackupCmd) error {
+	wc := cfg.Backup.OplogWindowCheck
+	if !wc.IsEnabled() || cmd.Type == defs.ExternalBackup {
+		return nil
+	}
+
+	l := log.LogEventFromContext(ctx)
+
+	agents, err := topo.ListSteadyAgents(ctx, a.leadConn)
+	if err != nil {
+		l.Warning("oplog window check: get agents list: %v", err)
+		return nil
+	}
+	fits, err := backup.CheckOplogWindows(ctx, a.leadConn, cfg, cmd.Type, agents)
+	if err != nil {
+		l.Warning("oplog window check: %v", err)
+		return nil
+	}
+
+	var short []string
+	for _, f := range fits {
+		if f.Fits || (cmd.Replset != "" && f.RS != cmd.Replset) {
+			continue
+		}
+		short = append(short, fmt.Sprintf("%s (window %v, estimated backup %v)",
+			f.RS, f.Window.Round(time.Second), f.Estimate.Round(time.Second)))
+	}
+	if len(short) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("oplog window is shorter than the estimated backup duration with %v%% margin: %s",
+		wc.Margin(), strings.Join(short, ", "))
+	if wc != nil && wc.FailOnShort {
+		return errors.New(msg)
+	}
+	l.Warning("%s", msg)
+	return nil
+}
+
+// failBackup marks the backup as failed before any replset has started it
+func (a *Agent) failBackup(
+	ctx context.Context,
+	cfg *config.Config,
+	cmd *ctrl.BackupCmd,
+	opid ctrl.OPID,
+	err error,
+) {
+	l := log.LogEventFromContext(ctx)
+
+	if code := errors.CodeOf(err); code != "" {
+		if ferr := backup.SetErrorCode(a.leadConn, cmd.Name, code); ferr != nil {
+			l.Warning("set backup error code %s: %v", code, ferr)
+		}
+	}
+	ferr := backup.ChangeBackupState(a.leadConn, cmd.Name, defs.StatusError, err.Error())
+	l.Info("mark backup as %s `%v`: %v", defs.StatusError, err, ferr)
+	a.notifyWith(ctx, cfg, &notify.Payload{
+		Event: notify.BackupFailed,
+		Name:  cmd.Name,
+		Type:  string(cmd.Type),
+		OPID:  opid.String(),
+		Error: err.Error(),
+	})
+}
+
+// dumpCandidates keeps the agents matching the read preference of the
+// logical backup dump of their replset (see `backup.readPreference`).
+// It fails if the read preference of a replset in the backup matches
+// no healthy node.
+func dumpCandidates(
+	cfg *config.Config,
+	shards []topo.Shard,
+	replset string,
+	agents []topo.AgentStat,
+) ([]topo.AgentStat, error) {
+	byRS := make(map[string][]topo.AgentStat)
+	for _, a := range agents {
+		byRS[a.RS] = append(byRS[a.RS], a)
+	}
+
+	rv := make([]topo.AgentStat, 0, len(agents))
+	var errs []error
+	for _, sh := range shards {
+		if replset != "" && sh.RS != replset {
+			continue
+		}
+
+		rp := cfg.BackupReadPrefRS(sh.RS)
+		if rp == nil {
+			rv = append(rv, byRS[sh.RS]...)
+			continue
+		}
+
+		nodes, err := prio.MatchReadPref(byRS[sh.RS], rp)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "replset %s: read preference", sh.RS))
+			continue
+		}
+		if len(nodes) == 0 {
+			errs = append(errs, errors.Errorf("replset %s: read preference %s matches no healthy node",
+				sh.RS, rp))
+			continue
+		}
+		rv = append(rv, nodes...)
+	}
+
+	return rv, errors.Join(errs...)
 }
 
 // getValidCandidates filters out all agents that are not suitable for the backup.
@@ -338,13 +591,13 @@ func (a *Agent) waitNomination(ctx context.Context, bcp string) (bool, error) {
 	}
 }
 
-// startBcpLockCheck checks if there is any active lock.
+// startBcpLockCheck checks if there is any active lock incompatible with the backup.
 // It fetches all existing pbm locks, and if any exists, it is also
 // checked for staleness.
-// false is returned in case a single active lock exists or error happens.
-// true means that there's no active locks.
-func (a *Agent) startBcpLockCheck(ctx context.Context) (bool, error) {
-	locks, err := lock.GetLocks(ctx, a.leadConn, &lock.LockHeader{})
+// false is returned in case a single active incompatible lock exists or error happens.
+// true means that there's no such locks.
+func (a *Agent) startBcpLockCheck(ctx context.Context, bcp *lock.LockHeader) (bool, error) {
+	locks, err := lock.GetOperationLocks(ctx, a.leadConn, &lock.LockHeader{})
 	if err != nil {
 		return false, errors.Wrap(err, "get all locks for backup start")
 	}
@@ -358,8 +611,9 @@ func (a *Agent) startBcpLockCheck(ctx context.Context) (bool, error) {
 		return false, errors.Wrap(err, "read cluster time")
 	}
 
+	staleSec := lock.StaleSec(ctx, a.leadConn)
 	for _, l := range locks {
-		if l.Heartbeat.T+defs.StaleFrameSec >= ts.T {
+		if !l.IsStale(ts, staleSec) && !lock.Compatible(bcp, &l.LockHeader) {
 			return false, nil
 		}
 	}
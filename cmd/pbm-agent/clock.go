@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// clockSkewWindow is the period of the samples the clock offset is
+// estimated by
+const clockSkewWindow = time.Minute
+
+type clockSample struct {
+	at     time.Time
+	offset int64
+}
+
+// clockSkew estimates the offset of the agent wall clock from the cluster
+// time. The cluster time moves with the writes of the cluster (at least
+// the no-op writes of the primaries), so it lags behind up to the no-op
+// interval on idle clusters. The lag only adds to the measured offset,
+// hence the estimate is the smallest sample over clockSkewWindow.
+type clockSkew struct {
+	mx      sync.Mutex
+	samples []clockSample
+}
+
+// add records the cluster time read at `at` and returns the estimate
+func (c *clockSkew) add(at time.Time, ct primitive.Timestamp) int64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	i := 0
+	for i < len(c.samples) && at.Sub(c.samples[i].at) > clockSkewWindow {
+		i++
+	}
+	c.samples = append(c.samples[i:], clockSample{at: at, offset: at.Unix() - int64(ct.T)})
+
+	return c.offsetLocked()
+}
+
+// offset returns the estimate and false if there are no samples yet
+func (c *clockSkew) offset() (int64, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if len(c.samples) == 0 {
+		return 0, false
+	}
+	return c.offsetLocked(), true
+}
+
+func (c *clockSkew) offsetLocked() int64 {
+	rv := c.samples[0].offset
+	for _, s := range c.samples[1:] {
+		rv = min(rv, s.offset)
+	}
+	return rv
+}
+
+// checkClockSkew warns about the agents with the clock skewed beyond
+// `agent.clockSkew.maxSec` (of the replset if it's set). If
+// `agent.clockSkew.failOnSkew` is set, it returns an error when the clock
+// of this node is skewed, so it doesn't lead the operation.
+func (a *Agent) checkClockSkew(ctx context.Context, cfg *config.Config, replset string) error {
+	l := log.LogEventFromContext(ctx)
+	limit := cfg.Agent.MaxClockSkew()
+
+	if off, ok := a.clock.offset(); ok {
+		d := time.Duration(off) * time.Second
+		if (d > limit || d < -limit) && cfg.Agent.FailOnClockSkew() {
+			return errors.Errorf("the clock of the node %s is off the cluster time by %v (max %v)",
+				a.brief.Me, d, limit)
+		}
+	}
+
+	agents, err := topo.ListSteadyAgents(ctx, a.leadConn)
+	if err != nil {
+		l.Warning("clock skew check: get agents list: %v", err)
+		return nil
+	}
+
+	var skewed []string
+	for i := range agents {
+		ag := &agents[i]
+		if replset != "" && ag.RS != replset {
+			continue
+		}
+		if d, ok := ag.ClockSkew(limit); ok {
+			skewed = append(skewed, fmt.Sprintf("%s/%s (%v)", ag.RS, ag.Node, d))
+		}
+	}
+	if len(skewed) != 0 {
+		l.Warning("clock is off the cluster time by more than %v: %s", limit, strings.Join(skewed, ", "))
+	}
+
+	return nil
+}
+
+// clockSynced filters out the agents with the skewed clock if
+// `agent.clockSkew.failOnSkew` is set.
+func clockSynced(cfg *config.Config, agents []topo.AgentStat, l log.LogEvent) []topo.AgentStat {
+	if !cfg.Agent.FailOnClockSkew() {
+		return agents
+	}
+
+	limit := cfg.Agent.MaxClockSkew()
+	rv := make([]topo.AgentStat, 0, len(agents))
+	for i := range agents {
+		if d, ok := agents[i].ClockSkew(limit); ok {
+			l.Warning("%s/%s isn't nominated: the clock is off the cluster time by %v",
+				agents[i].RS, agents[i].Node, d)
+			continue
+		}
+		rv = append(rv, agents[i])
+	}
+
+	return rv
+}
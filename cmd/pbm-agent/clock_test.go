@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestClockSkew(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	ct := func(sec int64) primitive.Timestamp { return primitive.Timestamp{T: uint32(sec)} }
+
+	var c clockSkew
+	if _, ok := c.offset(); ok {
+		t.Fatal("offset without samples")
+	}
+
+	// the agent is 240s ahead, the cluster time lags up to 10s
+	// behind between the no-op writes
+	for i, lag := range []int64{7, 2, 9, 0, 4} {
+		at := t0.Add(time.Duration(i) * 5 * time.Second)
+		c.add(at, ct(at.Unix()-240-lag))
+	}
+	if off, _ := c.offset(); off != 240 {
+		t.Errorf("ahead: want 240, got %d", off)
+	}
+
+	// the agent clock is corrected, the old samples age out of the window
+	t1 := t0.Add(time.Hour)
+	for i, lag := range []int64{3, 8, 1} {
+		at := t1.Add(time.Duration(i) * 5 * time.Second)
+		c.add(at, ct(at.Unix()+30-lag))
+	}
+	if off, _ := c.offset(); off != -29 {
+		t.Errorf("behind: want -29, got %d", off)
+	}
+	if len(c.samples) != 3 {
+		t.Errorf("samples out of the window are kept: %d", len(c.samples))
+	}
+}
+
+func TestClockSynced(t *testing.T) {
+	off := func(v int64) *int64 { return &v }
+	agents := []topo.AgentStat{
+		{RS: "rs1", Node: "n1", ClockOffsetSec: off(3)},
+		{RS: "rs1", Node: "n2", ClockOffsetSec: off(-240)},
+		{RS: "rs1", Node: "n3"},
+		{RS: "rs2", Node: "n4", ClockOffsetSec: off(45)},
+	}
+
+	cfg := &config.Config{}
+	if got := clockSynced(cfg, agents, log.DiscardEvent); len(got) != len(agents) {
+		t.Errorf("warn only: want all agents, got %d", len(got))
+	}
+
+	cfg.Agent = &config.AgentConf{ClockSkew: &config.ClockSkewConf{FailOnSkew: true}}
+	got := clockSynced(cfg, agents, log.DiscardEvent)
+	if len(got) != 2 || got[0].Node != "n1" || got[1].Node != "n3" {
+		t.Errorf("default max: %+v", got)
+	}
+
+	cfg.Agent.ClockSkew.MaxSec = 60
+	got = clockSynced(cfg, agents, log.DiscardEvent)
+	if len(got) != 3 || got[2].Node != "n4" {
+		t.Errorf("max 60s: %+v", got)
+	}
+}
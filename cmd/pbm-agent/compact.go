@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// PITRCompact merges old PITR chunks into bigger ones
+func (a *Agent) PITRCompact(ctx context.Context, d *ctrl.PITRCompactCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	l := logger.NewEvent(string(ctrl.CmdPITRCompact), "", opid.String(), ep.TS())
+
+	if d == nil {
+		l.Error("missed command")
+		return
+	}
+
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		l.Error("get node info data: %v", err)
+		return
+	}
+	if !nodeInfo.IsLeader() {
+		l.Info("not a member of the leader rs, skipping")
+		return
+	}
+
+	epts := ep.TS()
+	lock := lock.NewLock(a.leadConn, lock.LockHeader{
+		Replset: a.brief.SetName,
+		Node:    a.brief.Me,
+		Type:    ctrl.CmdPITRCompact,
+		OPID:    opid.String(),
+		Epoch:   &epts,
+	})
+
+	got, err := a.acquireLock(ctx, lock, l)
+	if err != nil {
+		l.Error("acquire lock: %v", err)
+		return
+	}
+	if !got {
+		l.Debug("skip: lock not acquired")
+		return
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	ct, err := topo.ClusterTimeFromNodeInfo(nodeInfo)
+	if err != nil {
+		l.Error("get cluster time: %v", err)
+		return
+	}
+	if d.OlderThan.T > ct.T {
+		providedTime := time.Unix(int64(d.OlderThan.T), 0).UTC().Format(time.RFC3339)
+		realTime := time.Unix(int64(ct.T), 0).UTC().Format(time.RFC3339)
+		l.Error("provided time %q is after now %q", providedTime, realTime)
+		return
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		l.Error("get config: %v", err)
+		return
+	}
+
+	opts := oplog.CompactOptions{
+		Before:  d.OlderThan,
+		MaxSize: d.MaxSize,
+		MaxSpan: time.Duration(d.MaxSpanSec) * time.Second,
+	}
+
+	rss, err := oplog.AllOplogRSNames(ctx, a.leadConn, primitive.Timestamp{}, d.OlderThan)
+	if err != nil {
+		l.Error("get replsets: %v", err)
+		return
+	}
+
+	l.Info("compacting pitr chunks older than %v", time.Unix(int64(d.OlderThan.T), 0).UTC())
+	for _, rs := range rss {
+		name, stgConf, err := config.GetReplsetStorage(ctx, a.leadConn, cfg, rs)
+		if err != nil {
+			l.Error("get %s storage: %v", rs, err)
+			return
+		}
+		stg, err := util.StorageFromConfig(stgConf, a.brief.Me, l)
+		if err != nil {
+			l.Error("get %s storage: %v", rs, err)
+			return
+		}
+
+		opts.Storage = name
+		if p := cfg.PITRConfRS(rs); p != nil {
+			opts.Compression = p.Compression
+			opts.Level = p.CompressionLevel
+		}
+
+		stat, err := oplog.CompactChunks(ctx, a.leadConn, stg, rs, opts)
+		if err != nil {
+			l.Error("compact %s: %v", rs, err)
+			return
+		}
+
+		l.Info("%s: %d chunks merged into %d, %d leftovers removed",
+			rs, stat.Merged, stat.Created, stat.Removed)
+	}
+
+	l.Info("done")
+}
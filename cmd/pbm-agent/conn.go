@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const (
+	// connCheckPeriod is how often the connection of the agent is pinged
+	connCheckPeriod = 10 * time.Second
+	// connPingTimeout is the time limit of the ping of the connection
+	connPingTimeout = 5 * time.Second
+	// connFailures is the number of the failed pings in a row the
+	// connection is rebuilt after
+	connFailures = 3
+	// reconnectMaxBackoff is the max pause between the reconnect attempts
+	reconnectMaxBackoff = time.Minute
+)
+
+// reconnectBackoff returns the pause before the n-th (from 0) reconnect attempt
+func reconnectBackoff(n int) time.Duration {
+	return min(time.Second<<min(n, 6), reconnectMaxBackoff)
+}
+
+// ConnWatchdog rebuilds the connections of the agent to the cluster leader
+// and to its mongod once the first one is dead (e.g. the mongod is restarted
+// and the driver hasn't recovered the pool). It's the only place the
+// connections are rebuilt at. The routines of the agent pick up the new
+// connections on their next operation, the old ones are disconnected after
+// connect.ReplacedClientGrace.
+func (a *Agent) ConnWatchdog(ctx context.Context) {
+	ping := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, connPingTimeout)
+		defer cancel()
+		return a.leadConn.MongoClient().Ping(ctx, readpref.Primary())
+	}
+
+	a.watchConn(ctx, ping, a.rebuildConns, connCheckPeriod)
+}
+
+func (a *Agent) watchConn(
+	ctx context.Context,
+	ping func(context.Context) error,
+	rebuild func(context.Context) error,
+	period time.Duration,
+) {
+	l := log.FromContext(ctx).NewEvent("connCheckup", "", "", primitive.Timestamp{})
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	tk := time.NewTicker(period)
+	defer tk.Stop()
+
+	failed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := ping(ctx)
+		if err == nil {
+			failed = 0
+			continue
+		}
+
+		failed++
+		l.Warning("ping (%d/%d): %v", failed, connFailures, err)
+		if failed < connFailures {
+			continue
+		}
+
+		a.reconnect(ctx, rebuild, l)
+		failed = 0
+	}
+}
+
+// reconnect rebuilds the connections with a backoff until it succeeds
+// or ctx is done.
+func (a *Agent) reconnect(ctx context.Context, rebuild func(context.Context) error, l log.LogEvent) {
+	for n := 0; ; n++ {
+		err := rebuild(ctx)
+		if err == nil {
+			a.connGen.Add(1)
+			l.Info("connection is rebuilt")
+			return
+		}
+
+		pause := reconnectBackoff(n)
+		l.Error("reconnect: %v. next attempt in %v", err, pause)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pause):
+		}
+	}
+}
+
+// rebuildConns replaces the connection to the mongod of the agent and
+// then the one to the cluster leader.
+func (a *Agent) rebuildConns(ctx context.Context) error {
+	node, err := connect.MongoConnect(ctx, a.brief.URI, connect.Direct(true))
+	if err != nil {
+		return errors.Wrap(err, "connect to the node")
+	}
+	connect.DisconnectAfter(a.nodeConn.Swap(node), connect.ReplacedClientGrace)
+
+	err = a.leadConn.Reconnect(ctx)
+	return errors.Wrap(err, "connect to the cluster leader")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i, n := range []int{0, 1, 2, 5, 6, 100} {
+		if got := reconnectBackoff(n); got != want[i] {
+			t.Errorf("%d: want %v, got %v", n, want[i], got)
+		}
+	}
+}
+
+func TestWatchConn(t *testing.T) {
+	a := &Agent{}
+
+	// two failures are followed by a success: no reconnect
+	// until there are connFailures in a row
+	var pings atomic.Int32
+	ping := func(context.Context) error {
+		n := pings.Add(1)
+		if n == 3 || n > 6 {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+
+	// the first rebuild fails and is retried
+	var rebuilds atomic.Int32
+	rebuild := func(context.Context) error {
+		if rebuilds.Add(1) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.watchConn(ctx, ping, rebuild, time.Millisecond)
+
+	for i := 0; i < 400 && a.connGen.Load() < 1; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if a.connGen.Load() != 1 {
+		t.Fatalf("want conn gen 1, got %d", a.connGen.Load())
+	}
+	if pings.Load() < 6 {
+		t.Errorf("reconnected after %d pings", pings.Load())
+	}
+	time.Sleep(20 * time.Millisecond)
+	if rebuilds.Load() != 2 {
+		t.Errorf("want 2 rebuilds, got %d", rebuilds.Load())
+	}
+	if a.connGen.Load() != 1 {
+		t.Errorf("want conn gen 1, got %d", a.connGen.Load())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	controlCheckPeriod = time.Minute
+	logPurgePeriod     = time.Hour
+
+	controlEvent = "controlCollections"
+)
+
+// controlState is what the cluster leader has done with the control
+// collections so far
+type controlState struct {
+	// idxEpoch is the config epoch the indexes are ensured by
+	idxEpoch primitive.Timestamp
+	// purgeEpoch is the config epoch of the last purge of the log
+	purgeEpoch primitive.Timestamp
+	lastPurge  time.Time
+	// ttl is true if the log entries expire by the TTL index
+	ttl bool
+}
+
+// ControlCollections maintains the PBM control collections. Only the
+// cluster leader does it: it ensures the indexes of the control
+// collections (see connect.ControlIndexes) on start and on each new
+// config epoch, and makes the log entries older than `log.retentionDays`
+// expire by the TTL index (see connect.EnsureLogTTL). The capped log
+// collection doesn't support it, the entries are deleted hourly then.
+func (a *Agent) ControlCollections(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting control collections maintenance")
+
+	tk := time.NewTicker(controlCheckPeriod)
+	defer tk.Stop()
+
+	st := &controlState{}
+	for {
+		err := a.controlCollections(ctx, st, time.Now().UTC())
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(controlEvent, "", "", ep.TS(), "%v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+func (a *Agent) controlCollections(ctx context.Context, st *controlState, now time.Time) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		// the next leader ensures the indexes on its own
+		*st = controlState{}
+		return nil
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get config")
+	}
+
+	l := log.FromContext(ctx).NewEvent(controlEvent, "", "", cfg.Epoch)
+	db := a.leadConn.MongoClient().Database(defs.DB)
+
+	if !st.idxEpoch.Equal(cfg.Epoch) {
+		changed, err := connect.EnsureControlIndexes(ctx, db)
+		for _, idx := range changed {
+			l.Info("index %s is created", idx)
+		}
+		if err != nil {
+			return errors.Wrap(err, "ensure indexes")
+		}
+		st.idxEpoch = cfg.Epoch
+	}
+
+	retention := cfg.Log.Retention()
+	if !st.purgeEpoch.Equal(cfg.Epoch) {
+		st.ttl, err = connect.EnsureLogTTL(ctx, db, retention)
+		if err != nil {
+			return errors.Wrap(err, "ensure log ttl")
+		}
+	}
+	if retention == 0 {
+		st.purgeEpoch = cfg.Epoch
+		return nil
+	}
+	// a new retention applies right away. with the TTL index, only
+	// the entries made before it (without the date) are purged then
+	if st.purgeEpoch.Equal(cfg.Epoch) && (st.ttl || now.Sub(st.lastPurge) < logPurgePeriod) {
+		return nil
+	}
+
+	n, err := purgeLog(ctx, db, now.Add(-retention))
+	if err != nil {
+		return errors.Wrap(err, "purge log")
+	}
+	if n != 0 {
+		l.Info("deleted %d log entries older than %d days", n, cfg.Log.RetentionDays)
+	}
+	st.purgeEpoch = cfg.Epoch
+	st.lastPurge = now
+
+	return nil
+}
+
+// purgeLog deletes the log entries made before the time
+func purgeLog(ctx context.Context, db *mongo.Database, before time.Time) (int64, error) {
+	res, err := db.Collection(defs.LogCollection).DeleteMany(ctx,
+		bson.D{{"ts", bson.M{"$lt": before.Unix()}}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
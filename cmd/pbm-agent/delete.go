@@ -15,6 +15,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/resync"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
@@ -34,7 +35,7 @@ func (a *Agent) Delete(ctx context.Context, d *ctrl.DeleteBackupCmd, opid ctrl.O
 
 	ctx = log.SetLogEventToContext(ctx, l)
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info data: %v", err)
 		return
@@ -52,6 +53,8 @@ func (a *Agent) Delete(ctx context.Context, d *ctrl.DeleteBackupCmd, opid ctrl.O
 		Type:    ctrl.CmdDeleteBackup,
 		OPID:    opid.String(),
 		Epoch:   &epts,
+		// the backup can be on any storage profile
+		Storage: lock.AnyStorage,
 	})
 
 	got, err := a.acquireLock(ctx, lock, l)
@@ -96,7 +99,9 @@ func (a *Agent) Delete(ctx context.Context, d *ctrl.DeleteBackupCmd, opid ctrl.O
 		}
 
 		l.Info("deleting backups older than %v", t)
+		start := time.Now()
 		err = backup.DeleteBackupBefore(ctx, a.leadConn, t, bcpType, nodeInfo.Me)
+		a.notify(ctx, retentionPayload(ctrl.CmdDeleteBackup, opid, t, start, err))
 		if err != nil {
 			l.Error("deleting: %v", err)
 			return
@@ -105,8 +110,12 @@ func (a *Agent) Delete(ctx context.Context, d *ctrl.DeleteBackupCmd, opid ctrl.O
 		l = logger.NewEvent(string(ctrl.CmdDeleteBackup), d.Backup, opid.String(), ep.TS())
 		ctx := log.SetLogEventToContext(ctx, l)
 
-		l.Info("deleting backup")
-		err := backup.DeleteBackup(ctx, a.leadConn, d.Backup, nodeInfo.Me)
+		if d.OverrideHold {
+			l.Warning("deleting backup overriding its hold")
+		} else {
+			l.Info("deleting backup")
+		}
+		err := backup.DeleteBackup(ctx, a.leadConn, d.Backup, nodeInfo.Me, d.OverrideHold)
 		if err != nil {
 			l.Error("deleting: %v", err)
 			return
@@ -131,7 +140,7 @@ func (a *Agent) DeletePITR(ctx context.Context, d *ctrl.DeletePITRCmd, opid ctrl
 
 	ctx = log.SetLogEventToContext(ctx, l)
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info data: %v", err)
 		return
@@ -172,8 +181,37 @@ func (a *Agent) DeletePITR(ctx context.Context, d *ctrl.DeletePITRCmd, opid ctrl
 		return
 	}
 
+	if d.IsRange() {
+		const f = "2006-01-02T15:04:05Z"
+		obj := time.Unix(int64(d.From.T), 0).UTC().Format(f) + "-" + time.Unix(int64(d.To.T), 0).UTC().Format(f)
+		if d.RS != "" {
+			obj = d.RS + "/" + obj
+		}
+
+		l = logger.NewEvent(string(ctrl.CmdDeletePITR), obj, opid.String(), ep.TS())
+		ctx = log.SetLogEventToContext(ctx, l)
+
+		l.Info("deleting pitr chunks in range %v - %v", d.From, d.To)
+		chunks, err := backup.ListPITRChunksInRange(ctx, a.leadConn, d.RS, d.From, d.To)
+		if err != nil {
+			err = errors.Wrap(err, "get pitr chunks")
+		} else {
+			err = a.deletePITRImpl(ctx, chunks, opid)
+		}
+		if err != nil {
+			l.Error("deleting: %v", err)
+			return
+		}
+
+		l.Info("done")
+		return
+	}
+
 	t := time.Unix(d.OlderThan, 0).UTC()
 	obj := t.Format("2006-01-02T15:04:05Z")
+	if d.RS != "" {
+		obj = d.RS + "/" + obj
+	}
 
 	l = logger.NewEvent(string(ctrl.CmdDeletePITR), obj, opid.String(), ep.TS())
 	ctx = log.SetLogEventToContext(ctx, l)
@@ -187,7 +225,12 @@ func (a *Agent) DeletePITR(ctx context.Context, d *ctrl.DeletePITRCmd, opid ctrl
 
 	ts := primitive.Timestamp{T: uint32(t.Unix())}
 	l.Info("deleting pitr chunks older than %v", t)
-	err = a.deletePITRImpl(ctx, ts)
+	chunks, err := backup.ListPITRChunksToDelete(ctx, a.leadConn, d.RS, ts)
+	if err != nil {
+		err = errors.Wrap(err, "get pitr chunks")
+	} else {
+		err = a.deletePITRImpl(ctx, chunks, opid)
+	}
 	if err != nil {
 		l.Error("deleting: %v", err)
 		return
@@ -208,7 +251,7 @@ func (a *Agent) Cleanup(ctx context.Context, d *ctrl.CleanupCmd, opid ctrl.OPID,
 
 	ctx = log.SetLogEventToContext(ctx, l)
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info data: %v", err)
 		return
@@ -247,6 +290,10 @@ func (a *Agent) Cleanup(ctx context.Context, d *ctrl.CleanupCmd, opid ctrl.OPID,
 		l.Error("get cluster time: %v", err)
 		return
 	}
+	if d.Orphaned {
+		a.cleanupOrphans(ctx, ct, time.Duration(d.GraceSec)*time.Second)
+		return
+	}
 	if d.OlderThan.T > ct.T {
 		providedTime := time.Unix(int64(ct.T), 0).UTC().Format(time.RFC3339)
 		realTime := time.Unix(int64(ct.T), 0).UTC().Format(time.RFC3339)
@@ -259,6 +306,13 @@ func (a *Agent) Cleanup(ctx context.Context, d *ctrl.CleanupCmd, opid ctrl.OPID,
 		l.Error("get config: %v", err)
 	}
 
+	start := time.Now()
+	var errs []error
+	defer func() {
+		t := time.Unix(int64(d.OlderThan.T), 0).UTC()
+		a.notifyWith(ctx, cfg, retentionPayload(ctrl.CmdCleanup, opid, t, start, errors.Join(errs...)))
+	}()
+
 	stg, err := util.StorageFromConfig(&cfg.Storage, a.brief.Me, l)
 	if err != nil {
 		l.Error("get storage: " + err.Error())
@@ -270,47 +324,118 @@ func (a *Agent) Cleanup(ctx context.Context, d *ctrl.CleanupCmd, opid ctrl.OPID,
 	cr, err := backup.MakeCleanupInfo(ctx, a.leadConn, d.OlderThan)
 	if err != nil {
 		l.Error("make cleanup report: " + err.Error())
+		errs = append(errs, errors.Wrap(err, "make cleanup report"))
 		return
 	}
+	for i := range cr.Held {
+		l.Info("skip backup %q: on hold %s", cr.Held[i].Name, cr.Held[i].Hold)
+	}
 
+	stgs := map[string]storage.Storage{"": stg}
 	for i := range cr.Chunks {
 		name := cr.Chunks[i].FName
+		cstg, err := a.chunkStorage(ctx, stgs, &cr.Chunks[i])
+		if err != nil {
+			l.Error(err.Error())
+			errs = append(errs, err)
+			continue
+		}
 
 		eg.Go(func() error {
-			err := stg.Delete(name)
+			err := cstg.Delete(name)
 			return errors.Wrapf(err, "delete chunk file %q", name)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		errs = append(errs, err)
 	}
 
 	for i := range cr.Backups {
 		bcp := &cr.Backups[i]
 
 		eg.Go(func() error {
-			err := backup.DeleteBackupFiles(stg, bcp.Name)
+			err := backup.DeleteAllBackupFiles(ctx, stg, bcp, a.brief.Me)
 			return errors.Wrapf(err, "delete backup files %q", bcp.Name)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		errs = append(errs, err)
 	}
 
-	err = resync.Resync(ctx, a.leadConn, &cfg.Storage, a.brief.Me)
+	err = resync.Resync(ctx, a.leadConn, &cfg.Storage, a.brief.Me, false)
 	if err != nil {
 		l.Error("storage resync: " + err.Error())
+		errs = append(errs, errors.Wrap(err, "storage resync"))
 	}
 }
 
-func (a *Agent) deletePITRImpl(ctx context.Context, ts primitive.Timestamp) error {
+// retentionPayload makes the payload of the finished deletion of backups
+// (and PITR chunks) older than the time.
+func retentionPayload(
+	cmd ctrl.Command,
+	opid ctrl.OPID,
+	olderThan time.Time,
+	start time.Time,
+	err error,
+) *notify.Payload {
+	end := time.Now()
+	p := &notify.Payload{
+		Event:       notify.RetentionFinished,
+		Type:        string(cmd),
+		OPID:        opid.String(),
+		Start:       start.Unix(),
+		End:         end.Unix(),
+		DurationSec: int64(end.Sub(start).Seconds()),
+		Message:     "deleted data older than " + olderThan.Format(time.RFC3339),
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	return p
+}
+
+// cleanupOrphans deletes storage files no metadata refers to and
+// marks metadata of missed files. Files newer than grace are skipped.
+func (a *Agent) cleanupOrphans(ctx context.Context, ct primitive.Timestamp, grace time.Duration) {
 	l := log.LogEventFromContext(ctx)
 
-	r, err := backup.MakeCleanupInfo(ctx, a.leadConn, ts)
+	stg, err := util.GetStorage(ctx, a.leadConn, a.brief.Me, l)
+	if err != nil {
+		l.Error("get storage: %v", err)
+		return
+	}
+
+	before := time.Unix(int64(ct.T), 0).Add(-grace)
+	r, err := backup.FindOrphans(ctx, a.leadConn, stg, before)
 	if err != nil {
-		return errors.Wrap(err, "get pitr chunks")
+		l.Error("find orphans: %v", err)
+		return
 	}
-	if len(r.Chunks) == 0 {
+	if r.IsEmpty() {
+		l.Info("nothing to clean up")
+		return
+	}
+
+	l.Info("orphaned files: %d, dangling backups: %d, dangling chunks: %d, incomplete uploads: %d",
+		len(r.Files), len(r.Backups), len(r.Chunks), len(r.Uploads))
+	err = backup.CleanupOrphans(ctx, a.leadConn, stg, r)
+	if err != nil {
+		l.Error("cleanup orphans: %v", err)
+		return
+	}
+
+	l.Info("done")
+}
+
+// deletePITRImpl deletes the chunks and records the valid ranges of
+// their replsets after the deletion
+func (a *Agent) deletePITRImpl(ctx context.Context, chunks []oplog.OplogChunk, opid ctrl.OPID) error {
+	l := log.LogEventFromContext(ctx)
+
+	if len(chunks) == 0 {
 		l.Debug("nothing to delete")
 		return nil
 	}
@@ -320,14 +445,65 @@ func (a *Agent) deletePITRImpl(ctx context.Context, ts primitive.Timestamp) erro
 		return errors.Wrap(err, "get storage")
 	}
 
-	return a.deleteChunks(ctx, stg, r.Chunks)
+	err = a.deleteChunks(ctx, stg, chunks)
+	if err != nil {
+		return err
+	}
+
+	// valid ranges are derived from the chunks metadata.
+	// record them as they are after the deletion
+	rss := make(map[string]struct{})
+	for _, c := range chunks {
+		rss[c.RS] = struct{}{}
+	}
+	for rs := range rss {
+		tlns, err := oplog.PITRGetValidTimelines(ctx, a.leadConn, rs, primitive.Timestamp{})
+		if err != nil {
+			l.Warning("get valid timelines for %s: %v", rs, err)
+			continue
+		}
+		l.Info("valid pitr ranges of %s: %v", rs, tlns)
+
+		err = oplog.SetPITRRanges(ctx, a.leadConn, rs, oplog.PITRRanges{Timelines: tlns, OPID: opid.String()})
+		if err != nil {
+			l.Warning("record valid pitr ranges of %s: %v", rs, err)
+		}
+	}
+
+	return nil
+}
+
+// chunkStorage returns the storage of the chunk. stgs caches storages
+// by the config profile name, "" is the main storage.
+func (a *Agent) chunkStorage(
+	ctx context.Context,
+	stgs map[string]storage.Storage,
+	chnk *oplog.OplogChunk,
+) (storage.Storage, error) {
+	if stg, ok := stgs[chnk.Storage]; ok {
+		return stg, nil
+	}
+
+	stg, err := util.GetProfileStorage(ctx, a.leadConn, chnk.Storage, a.brief.Me, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get storage of chunk %q", chnk.FName)
+	}
+	stgs[chnk.Storage] = stg
+	return stg, nil
 }
 
 func (a *Agent) deleteChunks(ctx context.Context, stg storage.Storage, chunks []oplog.OplogChunk) error {
 	l := log.LogEventFromContext(ctx)
 
-	for _, chnk := range chunks {
-		err := stg.Delete(chnk.FName)
+	stgs := map[string]storage.Storage{"": stg}
+	for i := range chunks {
+		chnk := &chunks[i]
+		cstg, err := a.chunkStorage(ctx, stgs, chnk)
+		if err != nil {
+			return err
+		}
+
+		err = cstg.Delete(chnk.FName)
 		if err != nil && !errors.Is(err, storage.ErrNotExist) {
 			return errors.Wrapf(err, "delete pitr chunk '%s' (%v) from storage", chnk.FName, chnk)
 		}
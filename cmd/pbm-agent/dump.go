@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+// stateVersion is the version of the state dump format. Support tooling
+// relies on the format, so fields may be added but not changed or removed
+// without increasing the version.
+const stateVersion = 1
+
+// stateTimeout limits the queries to the PBM collections, so the dump
+// doesn't hang if the cluster is unreachable.
+const stateTimeout = 5 * time.Second
+
+// agentState is the self-diagnostics dump of the agent.
+type agentState struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// Reason is what triggered the dump (signal or command).
+	Reason string `json:"reason"`
+
+	Replset      string `json:"replset"`
+	Node         string `json:"node"`
+	AgentVersion string `json:"agentVersion"`
+	UptimeSec    int64  `json:"uptimeSec"`
+	Goroutines   int    `json:"goroutines"`
+
+	// Jobs is the number of in-flight backups and oplog slicing.
+	Jobs            int32 `json:"jobs"`
+	Draining        bool  `json:"draining"`
+	HeartbeatPaused bool  `json:"heartbeatPaused"`
+	BackupRunning   bool  `json:"backupRunning"`
+	PITRRunning     bool  `json:"pitrRunning"`
+
+	ConfigEpoch *primitive.Timestamp `json:"configEpoch,omitempty"`
+	// ClockOffsetSec is the estimated offset of the agent clock from
+	// the cluster time.
+	ClockOffsetSec *int64 `json:"clockOffsetSec,omitempty"`
+
+	Health  stateHealth  `json:"health"`
+	Locks   []stateLock  `json:"locks"`
+	Storage stateStorage `json:"storage"`
+	Memory  memory.Usage `json:"memory"`
+
+	// Errors are failures of collecting parts of the state.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type stateHealth struct {
+	// LastTick is the last tick of the status loop.
+	LastTick time.Time `json:"lastTick"`
+	// StorageOK is the last successful storage check.
+	StorageOK *time.Time  `json:"storageOK,omitempty"`
+	PBM       healthCheck `json:"pbm"`
+	Node      healthCheck `json:"node"`
+	Storage   healthCheck `json:"storage"`
+	ConfigErr string      `json:"configError,omitempty"`
+}
+
+// stateLock is a lock held by the agent and the state of its operation.
+type stateLock struct {
+	lock.LockHeader
+
+	// Op is true for the op lock (e.g. oplog slicing).
+	Op        bool      `json:"op"`
+	Heartbeat time.Time `json:"heartbeat"`
+	// Phase is the status of the operation on the replset.
+	Phase    string             `json:"phase,omitempty"`
+	Progress *backup.RSProgress `json:"progress,omitempty"`
+}
+
+type stateStorage struct {
+	Bytes  []metrics.Series `json:"bytes"`
+	Errors []metrics.Series `json:"errors"`
+}
+
+func (a *Agent) state(ctx context.Context, reason string) *agentState {
+	ctx, cancel := context.WithTimeout(ctx, stateTimeout)
+	defer cancel()
+
+	now := time.Now()
+	st := &agentState{
+		Version:         stateVersion,
+		Time:            now.UTC(),
+		Reason:          reason,
+		Replset:         a.brief.SetName,
+		Node:            a.brief.Me,
+		AgentVersion:    version.Current().Version,
+		UptimeSec:       int64(now.Sub(a.started).Seconds()),
+		Goroutines:      runtime.NumGoroutine(),
+		Jobs:            atomic.LoadInt32(&a.jobs),
+		Draining:        a.isDraining(),
+		HeartbeatPaused: !a.HbIsRun(),
+		BackupRunning:   a.getBcp() != nil,
+		PITRRunning:     a.getPitr() != nil,
+		Locks:           []stateLock{},
+		Storage: stateStorage{
+			Bytes:  metrics.StorageBytes.Series(),
+			Errors: metrics.StorageErrors.Series(),
+		},
+		Memory: memory.Default.Usage(),
+	}
+
+	a.health.mx.RLock()
+	h := &a.health
+	st.Health = stateHealth{
+		LastTick:  h.tick.UTC(),
+		PBM:       subsysCheck(h.pbm),
+		Node:      subsysCheck(h.node),
+		Storage:   subsysCheck(h.storage),
+		ConfigErr: h.configErr,
+	}
+	if !h.storageOK.IsZero() {
+		t := h.storageOK.UTC()
+		st.Health.StorageOK = &t
+	}
+	a.health.mx.RUnlock()
+
+	if off, ok := a.clock.offset(); ok {
+		st.ClockOffsetSec = &off
+	}
+
+	ep, err := config.GetEpoch(ctx, a.leadConn)
+	if err != nil {
+		st.Errors = append(st.Errors, "get config epoch: "+err.Error())
+	} else {
+		ts := ep.TS()
+		st.ConfigEpoch = &ts
+	}
+
+	for _, op := range []bool{false, true} {
+		get := lock.GetLocks
+		if op {
+			get = lock.GetOpLocks
+		}
+		locks, err := get(ctx, a.leadConn, &lock.LockHeader{Replset: a.brief.SetName, Node: a.brief.Me})
+		if err != nil {
+			st.Errors = append(st.Errors, fmt.Sprintf("get locks (op: %t): %v", op, err))
+			continue
+		}
+		for i := range locks {
+			l := stateLock{
+				LockHeader: locks[i].LockHeader,
+				Op:         op,
+				Heartbeat:  time.Unix(int64(locks[i].Heartbeat.T), 0).UTC(),
+			}
+			if err := a.lockPhase(ctx, &l); err != nil {
+				st.Errors = append(st.Errors, fmt.Sprintf("get %s [opid: %s] phase: %v", l.Type, l.OPID, err))
+			}
+			st.Locks = append(st.Locks, l)
+		}
+	}
+
+	return st
+}
+
+// lockPhase sets the phase (and progress) of the lock's operation
+// on the agent's replset.
+func (a *Agent) lockPhase(ctx context.Context, l *stateLock) error {
+	switch l.Type {
+	case ctrl.CmdBackup:
+		bcp, err := backup.GetBackupByOPID(ctx, a.leadConn, l.OPID)
+		if err != nil {
+			return err
+		}
+		l.Phase = string(bcp.Status)
+		for i := range bcp.Replsets {
+			if rs := &bcp.Replsets[i]; rs.Name == a.brief.SetName {
+				l.Phase = string(rs.Status)
+				l.Progress = rs.Progress
+			}
+		}
+	case ctrl.CmdRestore:
+		meta, err := restore.GetRestoreMetaByOPID(ctx, a.leadConn, l.OPID)
+		if err != nil {
+			return err
+		}
+		l.Phase = string(meta.Status)
+		for i := range meta.Replsets {
+			if rs := &meta.Replsets[i]; rs.Name == a.brief.SetName {
+				l.Phase = string(rs.Status)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DumpState writes the agent state and goroutine stacks to files in
+// the temp dir and the state to the log.
+func (a *Agent) DumpState(ctx context.Context, reason string, opid ctrl.OPID) {
+	l := log.FromContext(ctx).NewEvent(string(ctrl.CmdDumpState), "", opid.String(), primitive.Timestamp{})
+
+	st := a.state(ctx, reason)
+	data, err := json.Marshal(st)
+	if err != nil {
+		l.Error("encode state: %v", err)
+		return
+	}
+	l.Info("agent state: %s", data)
+
+	path, err := writeState(os.TempDir(), st)
+	if err != nil {
+		l.Error("write state: %v", err)
+		return
+	}
+	l.Info("agent state and goroutines are written to %s", strings.TrimSuffix(path, ".json")+".*")
+}
+
+// writeState writes the state to <dir>/pbm-agent-state-<replset>-<node>-<time>.json
+// and the goroutine stacks to the file with .goroutines.txt extension.
+// It returns the path of the state file.
+func writeState(dir string, st *agentState) (string, error) {
+	name := fmt.Sprintf("pbm-agent-state-%s-%s-%s",
+		st.Replset, st.Node, st.Time.Format("20060102T150405Z"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	base := filepath.Join(dir, name)
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "encode")
+	}
+	path := base + ".json"
+	err = os.WriteFile(path, append(data, '\n'), 0o600)
+	if err != nil {
+		return "", errors.Wrap(err, "write state file")
+	}
+
+	f, err := os.OpenFile(base+".goroutines.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return path, errors.Wrap(err, "create goroutines file")
+	}
+	defer f.Close()
+
+	err = pprof.Lookup("goroutine").WriteTo(f, 1)
+	if err != nil {
+		return path, errors.Wrap(err, "write goroutines")
+	}
+
+	return path, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+)
+
+func TestWriteState(t *testing.T) {
+	dir := t.TempDir()
+	st := &agentState{
+		Version: stateVersion,
+		Time:    time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC),
+		Reason:  "signal",
+		Replset: "rs0",
+		Node:    "rs101:27017",
+		Locks: []stateLock{{
+			LockHeader: lock.LockHeader{Type: ctrl.CmdBackup, OPID: "opid1"},
+			Phase:      "running",
+		}},
+	}
+
+	path, err := writeState(dir, st)
+	if err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	if want := filepath.Join(dir, "pbm-agent-state-rs0-rs101_27017-20261014T100000Z.json"); path != want {
+		t.Errorf("path: got %s, want %s", path, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read state: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode state: %v", err)
+	}
+	if got["version"] != float64(stateVersion) || got["node"] != "rs101:27017" {
+		t.Errorf("unexpected state: %s", data)
+	}
+	locks, _ := got["locks"].([]any)
+	if len(locks) != 1 || locks[0].(map[string]any)["type"] != "backup" {
+		t.Errorf("unexpected locks: %s", data)
+	}
+
+	stacks, err := os.ReadFile(strings.TrimSuffix(path, ".json") + ".goroutines.txt")
+	if err != nil {
+		t.Fatalf("read goroutines: %v", err)
+	}
+	if !strings.Contains(string(stacks), "TestWriteState") {
+		t.Errorf("goroutines dump doesn't have the test goroutine")
+	}
+}
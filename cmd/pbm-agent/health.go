@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	defaultStorageMaxAge = 5 * time.Minute
+	// liveMaxAge is how long the status loop may not tick
+	// before the agent is considered hung
+	liveMaxAge = time.Minute
+)
+
+// agentHealth is the last known state of the agent's dependencies.
+// It is updated by the status loop (HbStatus) and read by the status server.
+type agentHealth struct {
+	mx sync.RWMutex
+
+	tick      time.Time
+	pbm       topo.SubsysStatus
+	node      topo.SubsysStatus
+	storage   topo.SubsysStatus
+	storageOK time.Time
+	configErr string
+}
+
+func (h *agentHealth) setTick(t time.Time) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.tick = t
+}
+
+// setStatus records the heartbeat checks. storageChecked is false if the
+// storage status is taken from the previous check.
+func (h *agentHealth) setStatus(hb *topo.AgentStat, storageChecked bool, t time.Time) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.tick = t
+	h.pbm = hb.PBMStatus
+	h.node = hb.NodeStatus
+	h.storage = hb.StorageStatus
+	if storageChecked && hb.StorageStatus.OK {
+		h.storageOK = t
+	}
+}
+
+func (h *agentHealth) setConfigErr(err error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.configErr = ""
+	if err != nil {
+		h.configErr = err.Error()
+	}
+}
+
+type statusOpts struct {
+	addr          string
+	storageMaxAge time.Duration
+	// pprof enables /debug/pprof/ endpoints
+	pprof bool
+}
+
+type healthCheck struct {
+	OK   bool       `json:"ok"`
+	Err  string     `json:"error,omitempty"`
+	Last *time.Time `json:"last,omitempty"`
+}
+
+type healthReport struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+func (r *healthReport) add(name string, c healthCheck) {
+	r.Checks[name] = c
+	r.OK = r.OK && c.OK
+}
+
+type statusServer struct {
+	health        *agentHealth
+	storageMaxAge time.Duration
+	now           func() time.Time
+	cluster       func(context.Context) (*clusterStat, error)
+	log           log.Logger
+	pprof         bool
+}
+
+func (s *statusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealthReport(w, s.live())
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealthReport(w, s.ready())
+	})
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	if s.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+func (s *statusServer) live() *healthReport {
+	s.health.mx.RLock()
+	defer s.health.mx.RUnlock()
+
+	r := &healthReport{OK: true, Checks: make(map[string]healthCheck)}
+	r.add("loop", s.loopCheck())
+	return r
+}
+
+func (s *statusServer) ready() *healthReport {
+	s.health.mx.RLock()
+	defer s.health.mx.RUnlock()
+
+	h := s.health
+	r := &healthReport{OK: true, Checks: make(map[string]healthCheck)}
+	r.add("loop", s.loopCheck())
+	r.add("pbm", subsysCheck(h.pbm))
+	r.add("node", subsysCheck(h.node))
+
+	stg := healthCheck{OK: true}
+	if !h.storageOK.IsZero() {
+		t := h.storageOK
+		stg.Last = &t
+	}
+	switch {
+	case !h.storage.OK && h.storage.Err != "":
+		stg.OK, stg.Err = false, h.storage.Err
+	case h.storageOK.IsZero():
+		stg.OK, stg.Err = false, "storage hasn't been checked yet"
+	case s.now().Sub(h.storageOK) > s.storageMaxAge:
+		stg.OK, stg.Err = false, "no successful storage check for more than "+s.storageMaxAge.String()
+	}
+	r.add("storage", stg)
+
+	conf := healthCheck{OK: true}
+	if h.configErr != "" {
+		conf.OK, conf.Err = false, h.configErr
+	}
+	r.add("config", conf)
+
+	return r
+}
+
+// loopCheck expects the health lock to be held
+func (s *statusServer) loopCheck() healthCheck {
+	t := s.health.tick
+	c := healthCheck{OK: true, Last: &t}
+	if s.now().Sub(t) > liveMaxAge {
+		c.OK, c.Err = false, "status loop hasn't ticked for more than "+liveMaxAge.String()
+	}
+	return c
+}
+
+func subsysCheck(st topo.SubsysStatus) healthCheck {
+	if !st.OK {
+		if st.Err == "" {
+			st.Err = "not checked yet"
+		}
+		return healthCheck{Err: st.Err}
+	}
+	return healthCheck{OK: true}
+}
+
+func writeHealthReport(w http.ResponseWriter, r *healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.OK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(r)
+}
+
+// ServeStatus starts the HTTP status server with liveness (/live),
+// readiness (/ready) and metrics (/metrics) endpoints and optionally the profiling
+// data (/debug/pprof/). It returns once the address is bound,
+// the server stops on the context cancel.
+func (a *Agent) ServeStatus(ctx context.Context, opts statusOpts) error {
+	ln, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return errors.Wrap(err, "listen")
+	}
+
+	// don't fail liveness before the first status loop tick
+	a.health.setTick(time.Now())
+
+	l := log.FromContext(ctx)
+	s := &statusServer{
+		health:        &a.health,
+		storageMaxAge: opts.storageMaxAge,
+		now:           time.Now,
+		cluster:       a.clusterStat,
+		log:           l,
+		pprof:         opts.pprof,
+	}
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	l.Printf("status server is listening on %s", ln.Addr())
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Printf("status server: %v", err)
+		}
+	}()
+
+	return nil
+}
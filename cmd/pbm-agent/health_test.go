@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestStatusServer(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	healthy := func() *agentHealth {
+		h := &agentHealth{}
+		h.setStatus(&topo.AgentStat{
+			PBMStatus:     topo.SubsysStatus{OK: true},
+			NodeStatus:    topo.SubsysStatus{OK: true},
+			StorageStatus: topo.SubsysStatus{OK: true},
+		}, true, now.Add(-10*time.Second))
+		return h
+	}
+
+	get := func(t *testing.T, h *agentHealth, path string) (int, healthReport) {
+		t.Helper()
+
+		s := &statusServer{
+			health:        h,
+			storageMaxAge: 5 * time.Minute,
+			now:           func() time.Time { return now },
+		}
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var r healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("decode %s response %q: %v", path, rec.Body.String(), err)
+		}
+		return rec.Code, r
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		h := healthy()
+		for _, p := range []string{"/live", "/ready"} {
+			code, r := get(t, h, p)
+			if code != http.StatusOK || !r.OK {
+				t.Errorf("%s: got %d %+v", p, code, r)
+			}
+		}
+	})
+
+	cases := []struct {
+		name  string
+		path  string
+		check string
+		flip  func(h *agentHealth)
+	}{
+		{"loop hung", "/live", "loop", func(h *agentHealth) { h.tick = now.Add(-2 * liveMaxAge) }},
+		{"loop hung", "/ready", "loop", func(h *agentHealth) { h.tick = now.Add(-2 * liveMaxAge) }},
+		{"pbm connection", "/ready", "pbm", func(h *agentHealth) {
+			h.pbm = topo.SubsysStatus{Err: "server selection timeout"}
+		}},
+		{"node connection", "/ready", "node", func(h *agentHealth) {
+			h.node = topo.SubsysStatus{Err: "connection refused"}
+		}},
+		{"storage failed", "/ready", "storage", func(h *agentHealth) {
+			h.storage = topo.SubsysStatus{Err: "storage check failed with: access denied"}
+		}},
+		{"storage check outdated", "/ready", "storage", func(h *agentHealth) {
+			h.storageOK = now.Add(-10 * time.Minute)
+		}},
+		{"storage never checked", "/ready", "storage", func(h *agentHealth) { h.storageOK = time.Time{} }},
+		{"config error", "/ready", "config", func(h *agentHealth) {
+			h.setConfigErr(errors.New("get config: mongo: no documents in result"))
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name+" "+c.path, func(t *testing.T) {
+			h := healthy()
+			c.flip(h)
+
+			code, r := get(t, h, c.path)
+			if code != http.StatusServiceUnavailable || r.OK {
+				t.Errorf("expected unhealthy, got %d %+v", code, r)
+			}
+			ch, ok := r.Checks[c.check]
+			if !ok || ch.OK || ch.Err == "" {
+				t.Errorf("check %q: %+v", c.check, ch)
+			}
+			for name, ch := range r.Checks {
+				if name != c.check && !ch.OK {
+					t.Errorf("unexpected failed check %q: %+v", name, ch)
+				}
+			}
+		})
+	}
+
+	t.Run("live ignores dependencies", func(t *testing.T) {
+		h := healthy()
+		h.node = topo.SubsysStatus{Err: "connection refused"}
+		h.setConfigErr(errors.New("invalid storage config"))
+
+		if code, r := get(t, h, "/live"); code != http.StatusOK || !r.OK {
+			t.Errorf("got %d %+v", code, r)
+		}
+	})
+}
+
+func TestServeStatusOffByDefault(t *testing.T) {
+	cmd, _ := setupTestCmd()
+	f := cmd.Flags().Lookup("status-addr")
+	if f == nil || f.DefValue != "" {
+		t.Fatalf("status-addr flag: %+v", f)
+	}
+}
+
+func TestStatusServerPprof(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := &statusServer{health: &agentHealth{}, now: time.Now, pprof: enabled}
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+
+		if got := rec.Code == http.StatusOK; got != enabled {
+			t.Errorf("pprof enabled: %v, got %d", enabled, rec.Code)
+		}
+	}
+}
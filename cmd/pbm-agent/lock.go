@@ -2,48 +2,154 @@ package main
 
 import (
 	"context"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"fmt"
+	"time"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
 )
 
-func markBcpStale(ctx context.Context, l *lock.Lock, opid string) error {
-	bcp, err := backup.GetBackupByOPID(ctx, l.Connect(), opid)
+// staleOpMarker returns the function marking the operation of the reclaimed
+// lock of the type as failed. Nil if there is nothing to mark.
+func staleOpMarker(
+	t ctrl.Command,
+) func(context.Context, connect.Client, lock.StaleLockError, string, log.LogEvent) error {
+	switch t {
+	case ctrl.CmdBackup:
+		return markBcpStale
+	case ctrl.CmdRestore:
+		return markRestoreStale
+	}
+
+	return nil
+}
+
+// staleMarkable returns false if the operation is already finished.
+// Not to rewrite its status or an error emitted by the agent.
+func staleMarkable(s defs.Status) bool {
+	switch s {
+	case defs.StatusError, defs.StatusDone, defs.StatusCancelled, defs.StatusAborted:
+		return false
+	}
+
+	return true
+}
+
+// lostAgentMsg is the error of the operation which lock has been reclaimed.
+func lostAgentMsg(stale lock.StaleLockError) string {
+	return fmt.Sprintf("agent lost: %s/%s has stopped the lock heartbeat at %s",
+		stale.Lock.Replset, stale.Lock.Node,
+		time.Unix(int64(stale.Heartbeat.T), 0).UTC().Format(time.RFC3339))
+}
+
+// markBcpStale marks the backup of the reclaimed lock as failed and
+// deletes its leftovers: incomplete uploads and files.
+func markBcpStale(
+	ctx context.Context,
+	conn connect.Client,
+	stale lock.StaleLockError,
+	node string,
+	l log.LogEvent,
+) error {
+	bcp, err := backup.GetBackupByOPID(ctx, conn, stale.Lock.OPID)
 	if err != nil {
 		return errors.Wrap(err, "get backup meta")
 	}
 
-	// not to rewrite an error emitted by the agent
-	if bcp.Status == defs.StatusError || bcp.Status == defs.StatusDone {
+	if !staleMarkable(bcp.Status) {
 		return nil
 	}
 
-	log.FromContext(ctx).Debug(string(ctrl.CmdBackup), "", opid, primitive.Timestamp{}, "mark stale meta")
+	msg := lostAgentMsg(stale)
+	l.Warning("mark backup %q as failed: %s", bcp.Name, msg)
+	err = backup.ChangeBackupStateOPID(conn, stale.Lock.OPID, defs.StatusError, msg)
+	if err != nil {
+		return errors.Wrap(err, "mark backup")
+	}
 
-	return backup.ChangeBackupStateOPID(l.Connect(), opid, defs.StatusError,
-		"some of pbm-agents were lost during the backup")
+	st := bcp.RSStorage(stale.Lock.Replset)
+	stg, err := util.StorageFromConfig(&st.StorageConf, node, l)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	if ul, ok := stg.(storage.UploadsLister); ok {
+		uploads, err := ul.ListUploads(bcp.Name)
+		if err != nil {
+			return errors.Wrap(err, "list incomplete uploads")
+		}
+		for _, u := range uploads {
+			l.Info("abort incomplete upload %s", u.Name)
+			if err := ul.AbortUpload(u); err != nil {
+				l.Warning("abort upload %s: %v", u.Name, err)
+			}
+		}
+	}
+
+	l.Info("delete leftover files of backup %q", bcp.Name)
+	err = backup.DeleteBackupFiles(stg, bcp.Name)
+	return errors.Wrap(err, "delete leftover files")
 }
 
-func markRestoreStale(ctx context.Context, l *lock.Lock, opid string) error {
-	r, err := restore.GetRestoreMetaByOPID(ctx, l.Connect(), opid)
+// markRestoreStale marks the restore of the reclaimed lock as failed.
+// Partially restored data can't be cleaned up.
+func markRestoreStale(
+	ctx context.Context,
+	conn connect.Client,
+	stale lock.StaleLockError,
+	_ string,
+	l log.LogEvent,
+) error {
+	r, err := restore.GetRestoreMetaByOPID(ctx, conn, stale.Lock.OPID)
 	if err != nil {
 		return errors.Wrap(err, "get retore meta")
 	}
 
-	// not to rewrite an error emitted by the agent
-	if r.Status == defs.StatusError || r.Status == defs.StatusDone {
+	if !staleMarkable(r.Status) {
 		return nil
 	}
 
-	log.FromContext(ctx).Debug(string(ctrl.CmdRestore), "", opid, primitive.Timestamp{}, "mark stale meta")
+	msg := lostAgentMsg(stale)
+	l.Warning("mark restore %q as failed: %s", r.Name, msg)
+	return restore.ChangeRestoreStateOPID(ctx, conn, stale.Lock.OPID, defs.StatusError, msg)
+}
+
+// clusterLockCheck returns lock.ConcurrentOpError if any other
+// operation holds an active lock on any replset.
+func (a *Agent) clusterLockCheck(ctx context.Context, h *lock.LockHeader) error {
+	ts, err := topo.GetClusterTime(ctx, a.leadConn)
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+
+	staleSec := lock.StaleSec(ctx, a.leadConn)
+	for _, get := range []func(context.Context, connect.Client, *lock.LockHeader) ([]lock.LockData, error){
+		lock.GetLocks,
+		lock.GetOpLocks,
+	} {
+		locks, err := get(ctx, a.leadConn, &lock.LockHeader{})
+		if err != nil {
+			return errors.Wrap(err, "get locks")
+		}
+
+		for _, l := range locks {
+			if l.OPID == h.OPID || l.IsStale(ts, staleSec) {
+				continue
+			}
+			if !lock.Compatible(h, &l.LockHeader) {
+				return lock.ConcurrentOpError{l.LockHeader}
+			}
+		}
+	}
 
-	return restore.ChangeRestoreStateOPID(ctx, l.Connect(), opid, defs.StatusError,
-		"some of pbm-agents were lost during the restore")
+	return nil
 }
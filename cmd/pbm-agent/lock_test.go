@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+)
+
+func TestStaleOpMarker(t *testing.T) {
+	cases := []struct {
+		cmd  ctrl.Command
+		want any
+	}{
+		{ctrl.CmdBackup, markBcpStale},
+		{ctrl.CmdRestore, markRestoreStale},
+		{ctrl.CmdPITR, nil},
+		{ctrl.CmdResync, nil},
+		{ctrl.CmdDeleteBackup, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.cmd), func(t *testing.T) {
+			got := staleOpMarker(c.cmd)
+			if c.want == nil {
+				if got != nil {
+					t.Error("expected no marker")
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected a marker")
+			}
+			if reflect.ValueOf(got).Pointer() != reflect.ValueOf(c.want).Pointer() {
+				t.Error("unexpected marker")
+			}
+		})
+	}
+}
+
+func TestStaleMarkable(t *testing.T) {
+	cases := []struct {
+		status defs.Status
+		want   bool
+	}{
+		{defs.StatusStarting, true},
+		{defs.StatusRunning, true},
+		{defs.StatusDumpDone, true},
+		{defs.StatusCopyDone, true},
+		{defs.StatusDone, false},
+		{defs.StatusError, false},
+		{defs.StatusCancelled, false},
+		{defs.StatusAborted, false},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.status), func(t *testing.T) {
+			if got := staleMarkable(c.status); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLostAgentMsg(t *testing.T) {
+	stale := lock.StaleLockError{
+		Lock:      lock.LockHeader{Type: ctrl.CmdBackup, Replset: "rs1", Node: "n1:27017", OPID: "op1"},
+		Heartbeat: primitive.Timestamp{T: 1760400000},
+	}
+
+	got := lostAgentMsg(stale)
+	want := "agent lost: rs1/n1:27017 has stopped the lock heartbeat at 2025-10-14T00:00:00Z"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	mtLog "github.com/mongodb/mongo-tools/common/log"
@@ -15,8 +17,11 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/secret"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
 	"github.com/percona/percona-backup-mongodb/pbm/version"
 )
 
@@ -42,15 +47,27 @@ func rootCommand() *cobra.Command {
 			return validateRootCommand()
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			url := "mongodb://" + strings.Replace(viper.GetString(mongoConnFlag), "mongodb://", "", 1)
-
 			hidecreds()
 
 			logOpts := buildLogOpts()
 
 			l := log.NewWithOpts(nil, "", "", logOpts).NewDefaultEvent()
 
-			err := runAgent(url, viper.GetInt("backup.dump-parallel-collections"), logOpts)
+			// the URI may refer to an env var or a file with the password
+			uri, err := secret.Resolve(viper.GetString(mongoConnFlag))
+			if err != nil {
+				l.Error("Exit: %s: %v", mongoConnFlag, err)
+				os.Exit(1)
+			}
+			err = runAgent(connect.NormalizeURI(uri),
+				viper.GetInt("backup.dump-parallel-collections"),
+				viper.GetDuration("shutdown.drain-timeout"),
+				statusOpts{
+					addr:          viper.GetString("status.addr"),
+					storageMaxAge: viper.GetDuration("status.storage-max-age"),
+					pprof:         viper.GetBool("status.pprof"),
+				},
+				logOpts)
 			if err != nil {
 				l.Error("Exit: %v", err)
 				os.Exit(1)
@@ -86,6 +103,17 @@ func validateRootCommand() error {
 	if !isValidLogLevel(viper.GetString("log.level")) {
 		return errors.New("invalid log level")
 	}
+	for c, l := range viper.GetStringMapString("log.components") {
+		if !isValidLogLevel(l) {
+			return errors.New("invalid log level of " + c + ": " + l)
+		}
+	}
+	if viper.GetInt("log.maxSizeMB") < 0 {
+		return errors.New("invalid log max size")
+	}
+	if viper.GetInt("log.maxFiles") < 0 {
+		return errors.New("invalid log max files")
+	}
 
 	return nil
 }
@@ -103,6 +131,30 @@ func setRootFlags(rootCmd *cobra.Command) {
 	_ = viper.BindEnv("backup.dump-parallel-collections", "PBM_DUMP_PARALLEL_COLLECTIONS")
 	viper.SetDefault("backup.dump-parallel-collections", runtime.NumCPU()/2)
 
+	rootCmd.Flags().Duration("drain-timeout", defaultDrainTimeout,
+		"How long to wait for a running backup to finish on shutdown (SIGTERM) before canceling it")
+	_ = viper.BindPFlag("shutdown.drain-timeout", rootCmd.Flags().Lookup("drain-timeout"))
+	_ = viper.BindEnv("shutdown.drain-timeout", "PBM_DRAIN_TIMEOUT")
+	viper.SetDefault("shutdown.drain-timeout", defaultDrainTimeout)
+
+	rootCmd.Flags().String("status-addr", "",
+		"Address of the HTTP status server with /live and /ready endpoints (e.g. 127.0.0.1:8091). "+
+			"Disabled if empty")
+	_ = viper.BindPFlag("status.addr", rootCmd.Flags().Lookup("status-addr"))
+	_ = viper.BindEnv("status.addr", "PBM_STATUS_ADDR")
+
+	rootCmd.Flags().Duration("status-storage-max-age", defaultStorageMaxAge,
+		"The agent isn't ready if there was no successful storage check for this long")
+	_ = viper.BindPFlag("status.storage-max-age", rootCmd.Flags().Lookup("status-storage-max-age"))
+	_ = viper.BindEnv("status.storage-max-age", "PBM_STATUS_STORAGE_MAX_AGE")
+	viper.SetDefault("status.storage-max-age", defaultStorageMaxAge)
+
+	rootCmd.Flags().Bool("status-pprof", false,
+		"Serve the Go profiling data (/debug/pprof/) on the status server. "+
+			"For troubleshooting only, the endpoint isn't authenticated")
+	_ = viper.BindPFlag("status.pprof", rootCmd.Flags().Lookup("status-pprof"))
+	_ = viper.BindEnv("status.pprof", "PBM_STATUS_PPROF")
+
 	rootCmd.Flags().String("log-path", "", "Path to file")
 	_ = viper.BindPFlag("log.path", rootCmd.Flags().Lookup("log-path"))
 	_ = viper.BindEnv("log.path", "LOG_PATH")
@@ -113,9 +165,22 @@ func setRootFlags(rootCmd *cobra.Command) {
 	_ = viper.BindEnv("log.json", "LOG_JSON")
 	viper.SetDefault("log.json", false)
 
+	rootCmd.Flags().String("log-format", "",
+		"Log format: text, json (the same as --log-json) or json-full (JSON with all the log collection fields)")
+	_ = viper.BindPFlag("log.format", rootCmd.Flags().Lookup("log-format"))
+	_ = viper.BindEnv("log.format", "LOG_FORMAT")
+
+	rootCmd.Flags().Int("log-max-size-mb", 0, "Rotate the log file once it reaches the size. 0 disables rotation")
+	_ = viper.BindPFlag("log.maxSizeMB", rootCmd.Flags().Lookup("log-max-size-mb"))
+	_ = viper.BindEnv("log.maxSizeMB", "LOG_MAX_SIZE_MB")
+
+	rootCmd.Flags().Int("log-max-files", 0, "Number of rotated log files to keep (default 5)")
+	_ = viper.BindPFlag("log.maxFiles", rootCmd.Flags().Lookup("log-max-files"))
+	_ = viper.BindEnv("log.maxFiles", "LOG_MAX_FILES")
+
 	rootCmd.Flags().String("log-level", "",
-		"Minimal log level based on severity level: D, I, W, E or F, low to high."+
-			"Choosing one includes higher levels too.")
+		"Minimal log level based on severity level: D, I, W, E or F (or debug, info, warning, error, fatal), "+
+			"low to high. Choosing one includes higher levels too.")
 	_ = viper.BindPFlag("log.level", rootCmd.Flags().Lookup("log-level"))
 	_ = viper.BindEnv("log.level", "LOG_LEVEL")
 	viper.SetDefault("log.level", log.D)
@@ -151,15 +216,8 @@ func versionCommand() *cobra.Command {
 }
 
 func isValidLogLevel(logLevel string) bool {
-	validLogLevels := []string{log.D, log.I, log.W, log.E, log.F}
-
-	for _, validLevel := range validLogLevels {
-		if logLevel == validLevel {
-			return true
-		}
-	}
-
-	return false
+	_, ok := log.ParseSeverity(logLevel)
+	return ok
 }
 
 func buildLogOpts() *log.Opts {
@@ -169,21 +227,51 @@ func buildLogOpts() *log.Opts {
 		logLevel = log.D
 	}
 
+	comps := viper.GetStringMapString("log.components")
+	for c, l := range comps {
+		if !isValidLogLevel(l) {
+			fmt.Printf("Invalid log level of %s: %s. Falling back to the log level.\n", c, l)
+			delete(comps, c)
+		}
+	}
+
+	logJSON := viper.GetBool("log.json")
+	jsonFull := false
+	switch f := strings.ToLower(viper.GetString("log.format")); f {
+	case "", "text":
+	case "json":
+		logJSON = true
+	case log.FormatJSONFull:
+		logJSON, jsonFull = true, true
+	default:
+		fmt.Printf("Invalid log format: %s. Falling back to default.\n", f)
+	}
+
 	return &log.Opts{
-		LogPath:  viper.GetString("log.path"),
-		LogLevel: logLevel,
-		LogJSON:  viper.GetBool("log.json"),
+		LogPath:     viper.GetString("log.path"),
+		LogLevel:    logLevel,
+		LogJSON:     logJSON,
+		LogJSONFull: jsonFull,
+		MaxSizeMB:   viper.GetInt("log.maxSizeMB"),
+		MaxFiles:    viper.GetInt("log.maxFiles"),
+		Components:  comps,
 	}
 }
 
 func runAgent(
 	mongoURI string,
 	dumpConns int,
+	drainTimeout time.Duration,
+	status statusOpts,
 	logOpts *log.Opts,
 ) error {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+
 	leadConn, err := connect.Connect(ctx, mongoURI, "pbm-agent")
 	if err != nil {
 		return errors.Wrap(err, "connect to PBM")
@@ -209,19 +297,43 @@ func runAgent(
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		logger.SetLogLevelAndJSON(buildLogOpts())
 		newOpts := logger.Opts()
-		logger.Printf("log options updated: log-path=%s, log-level:%s, log-json:%t",
-			newOpts.LogPath, newOpts.LogLevel, newOpts.LogJSON)
+		logger.Printf("log options updated: log-path=%s, log-level:%s, log-json:%t, log-components:%v",
+			newOpts.LogPath, newOpts.LogLevel, newOpts.LogJSON, newOpts.Components)
 	})
 
 	ctx = log.SetLoggerToContext(ctx, logger)
 
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+	defer signal.Stop(hupC)
+	usr1C := make(chan os.Signal, 1)
+	signal.Notify(usr1C, syscall.SIGUSR1)
+	defer signal.Stop(usr1C)
+	go func() {
+		for {
+			select {
+			case <-hupC:
+				if err := logger.Reopen(); err != nil {
+					logger.Printf("[ERROR] reopen log file: %v", err)
+				}
+			case <-usr1C:
+				agent.DumpState(ctx, "signal", ctrl.NilOPID)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	mtLog.SetDateFormat(log.LogTimeFormat)
 	mtLog.SetVerbosity(&options.Verbosity{VLevel: mtLog.DebugLow})
-	mtLog.SetWriter(logger)
+	// the restores take their failed writes from mongo-tools log
+	mtLog.SetWriter(snapshot.ToolLogWriter(logger))
 
 	logger.Printf(perconaSquadNotice)
-	logger.Printf("log options: log-path=%s, log-level:%s, log-json:%t",
-		logOpts.LogPath, logOpts.LogLevel, logOpts.LogJSON)
+	logger.Printf("log options: log-path=%s, log-level:%s, log-json:%t, "+
+		"log-max-size-mb:%d, log-max-files:%d, log-components:%v",
+		logOpts.LogPath, logOpts.LogLevel, logOpts.LogJSON,
+		logOpts.MaxSizeMB, logOpts.MaxFiles, logOpts.Components)
 
 	canRunSlicer := true
 	if err := agent.CanStart(ctx); err != nil {
@@ -234,10 +346,55 @@ func runAgent(
 
 	agent.showIncompatibilityWarning(ctx)
 
+	if status.addr != "" {
+		err = agent.ServeStatus(ctx, status)
+		if err != nil {
+			return errors.Wrap(err, "start status server")
+		}
+	}
+
 	if canRunSlicer {
 		go agent.PITR(ctx)
 	}
 	go agent.HbStatus(ctx)
+	go agent.ConnWatchdog(ctx)
+	go agent.Scheduler(ctx)
+	go agent.Queue(ctx)
+	go agent.Reconciler(ctx)
+	go agent.RestoreWatchdog(ctx)
+	go agent.PITRRestarter(ctx)
+	go agent.ControlCollections(ctx)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		select {
+		case sig := <-sigC:
+			logger.Printf("got %s, shutting down (drain timeout %v)", sig, drainTimeout)
+		case <-ctx.Done():
+			return
+		}
+
+		go func() {
+			sig := <-sigC
+			logger.Printf("got %s, exiting immediately", sig)
+			cancel()
+		}()
+
+		agent.Drain(ctx, drainTimeout)
+		cancel()
+
+		// let canceled jobs mark their metadata and release locks
+		if !agent.waitJobs(context.Background(), shutdownCleanupGrace) {
+			logger.Printf("jobs are not finished in %v after cancel", shutdownCleanupGrace)
+		}
+	}()
+
+	err = agent.Start(ctx)
+	if agent.isDraining() {
+		<-stopped
+	}
 
-	return errors.Wrap(agent.Start(ctx), "listen the commands stream")
+	return errors.Wrap(err, "listen the commands stream")
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const clusterMetricsTimeout = 10 * time.Second
+
+// Cluster-wide metrics. They are exported only by the cluster leader agent,
+// other agents would export the same series.
+const (
+	// pbm_backup_last_success_timestamp_seconds{type} is the unix time
+	// the most recent successful backup of the type has finished.
+	metricBackupLastSuccess = "pbm_backup_last_success_timestamp_seconds"
+	// pbm_backup_last_duration_seconds{type} is the duration of the most
+	// recent successful backup of the type.
+	metricBackupLastDuration = "pbm_backup_last_duration_seconds"
+	// pbm_pitr_lag_seconds{rs} is how far the last uploaded oplog chunk of
+	// the replset is behind the cluster time. Exported if PITR is enabled.
+	metricPITRLag = "pbm_pitr_lag_seconds"
+	// pbm_operation_phase{operation,phase} is 1 for every running operation.
+	// phase is the backup status for backups and "running" for others.
+	metricOperationPhase = "pbm_operation_phase"
+)
+
+var metricsBackupTypes = []defs.BackupType{
+	defs.LogicalBackup,
+	defs.PhysicalBackup,
+	defs.IncrementalBackup,
+	defs.ExternalBackup,
+}
+
+type opPhase struct {
+	op    ctrl.Command
+	phase string
+}
+
+type clusterStat struct {
+	backups []*backup.BackupMeta
+	pitrLag map[string]int64
+	ops     []opPhase
+}
+
+func (st *clusterStat) registry() *metrics.Registry {
+	r := metrics.NewRegistry()
+
+	lastSuccess := r.NewGauge(metricBackupLastSuccess,
+		"Unix time of the last successful backup finish", "type")
+	lastDuration := r.NewGauge(metricBackupLastDuration,
+		"Duration of the last successful backup", "type")
+	for _, b := range st.backups {
+		lastSuccess.Set(float64(b.LastTransitionTS), string(b.Type))
+		lastDuration.Set(float64(b.LastTransitionTS-b.StartTS), string(b.Type))
+	}
+
+	lag := r.NewGauge(metricPITRLag,
+		"Lag of the last oplog chunk behind the cluster time", "rs")
+	for rs, l := range st.pitrLag {
+		lag.Set(float64(l), rs)
+	}
+
+	phase := r.NewGauge(metricOperationPhase,
+		"Running operations and their phase", "operation", "phase")
+	for _, o := range st.ops {
+		phase.Set(1, string(o.op), o.phase)
+	}
+
+	return r
+}
+
+// clusterStat collects the cluster-wide stat. It returns nil
+// if the agent isn't the cluster leader.
+func (a *Agent) clusterStat(ctx context.Context) (*clusterStat, error) {
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil, nil
+	}
+
+	st := &clusterStat{pitrLag: make(map[string]int64)}
+
+	for _, t := range metricsBackupTypes {
+		b, err := backup.LastBackupOfType(ctx, a.leadConn, t)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "get last %s backup", t)
+		}
+		st.backups = append(st.backups, b)
+	}
+
+	ts, err := topo.GetClusterTime(ctx, a.leadConn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read cluster time")
+	}
+
+	enabled, _, err := config.IsPITREnabled(ctx, a.leadConn)
+	if err != nil && !errors.Is(err, config.ErrMissedConfig) {
+		return nil, errors.Wrap(err, "check pitr")
+	}
+	if enabled {
+		shards, err := topo.ClusterMembers(ctx, a.leadConn.MongoClient())
+		if err != nil {
+			return nil, errors.Wrap(err, "get cluster members")
+		}
+		for _, s := range shards {
+			chnk, err := oplog.PITRLastChunkMeta(ctx, a.leadConn, s.RS)
+			if err != nil {
+				if errors.Is(err, errors.ErrNotFound) {
+					continue
+				}
+				return nil, errors.Wrapf(err, "get last chunk of %s", s.RS)
+			}
+			st.pitrLag[s.RS] = int64(ts.T) - int64(chnk.EndTS.T)
+		}
+	}
+
+	st.ops, err = runningOps(ctx, a, ts.T)
+	if err != nil {
+		return nil, errors.Wrap(err, "get running operations")
+	}
+
+	return st, nil
+}
+
+func runningOps(ctx context.Context, a *Agent, now uint32) ([]opPhase, error) {
+	locks, err := lock.GetLocks(ctx, a.leadConn, &lock.LockHeader{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get locks")
+	}
+	opLocks, err := lock.GetOpLocks(ctx, a.leadConn, &lock.LockHeader{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get op locks")
+	}
+
+	staleSec := lock.StaleSec(ctx, a.leadConn)
+	var rv []opPhase
+	seen := make(map[opPhase]bool)
+	for _, l := range append(locks, opLocks...) {
+		if l.IsStale(primitive.Timestamp{T: now}, staleSec) {
+			continue
+		}
+
+		p := opPhase{op: l.Type, phase: "running"}
+		if l.Type == ctrl.CmdBackup {
+			bcp, err := backup.GetBackupByOPID(ctx, a.leadConn, l.OPID)
+			if err != nil && !errors.Is(err, errors.ErrNotFound) {
+				return nil, errors.Wrapf(err, "get backup %s", l.OPID)
+			}
+			if bcp != nil {
+				p.phase = string(bcp.Status)
+			}
+		}
+
+		if !seen[p] {
+			seen[p] = true
+			rv = append(rv, p)
+		}
+	}
+
+	return rv, nil
+}
+
+// serveMetrics writes the agent metrics and, for the cluster leader,
+// the cluster-wide ones.
+func (s *statusServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var st *clusterStat
+	if s.cluster != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), clusterMetricsTimeout)
+		defer cancel()
+
+		var err error
+		st, err = s.cluster(ctx)
+		if err != nil {
+			s.log.Printf("metrics: get cluster stat: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.SetMemory(memory.Default.Usage())
+	_ = metrics.Default.Write(w)
+	if st != nil {
+		_ = st.registry().Write(w)
+	}
+}
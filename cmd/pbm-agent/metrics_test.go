@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
+)
+
+var sampleRe = regexp.MustCompile(`^([a-z_]+)(\{[^}]*\})? ([0-9.e+-]+)$`)
+
+// series parses exposition text into "name{labels}" -> value
+func series(t *testing.T, text string) map[string]string {
+	t.Helper()
+
+	rv := make(map[string]string)
+	for _, ln := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(ln, "# HELP ") || strings.HasPrefix(ln, "# TYPE ") {
+			continue
+		}
+		m := sampleRe.FindStringSubmatch(ln)
+		if m == nil {
+			t.Fatalf("malformed line %q", ln)
+		}
+		rv[m[1]+m[2]] = m[3]
+	}
+	return rv
+}
+
+func TestClusterMetrics(t *testing.T) {
+	st := &clusterStat{
+		backups: []*backup.BackupMeta{
+			{Type: defs.LogicalBackup, StartTS: 1000, LastTransitionTS: 1300},
+			{Type: defs.PhysicalBackup, StartTS: 2000, LastTransitionTS: 2060},
+		},
+		pitrLag: map[string]int64{"rs0": 12, "cfg": 3},
+		ops:     []opPhase{{op: ctrl.CmdBackup, phase: string(defs.StatusRunning)}},
+	}
+
+	buf := &strings.Builder{}
+	if err := st.registry().Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	got := series(t, buf.String())
+	want := map[string]string{
+		`pbm_backup_last_success_timestamp_seconds{type="logical"}`:  "1300",
+		`pbm_backup_last_success_timestamp_seconds{type="physical"}`: "2060",
+		`pbm_backup_last_duration_seconds{type="logical"}`:           "300",
+		`pbm_backup_last_duration_seconds{type="physical"}`:          "60",
+		`pbm_pitr_lag_seconds{rs="cfg"}`:                             "3",
+		`pbm_pitr_lag_seconds{rs="rs0"}`:                             "12",
+		`pbm_operation_phase{operation="backup",phase="running"}`:    "1",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got series %v", keys(got))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+
+	for _, name := range []string{metricBackupLastSuccess, metricBackupLastDuration, metricPITRLag, metricOperationPhase} {
+		if !strings.Contains(buf.String(), "# TYPE "+name+" gauge\n") {
+			t.Errorf("no TYPE line for %s", name)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	metrics.LockAcquireFailures.Inc(string(ctrl.CmdBackup), "concurrent")
+
+	scrape := func(t *testing.T, leader bool) map[string]string {
+		t.Helper()
+
+		s := &statusServer{
+			health: &agentHealth{},
+			cluster: func(context.Context) (*clusterStat, error) {
+				if !leader {
+					return nil, nil
+				}
+				return &clusterStat{pitrLag: map[string]int64{"rs0": 5}}, nil
+			},
+		}
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("content type %q", ct)
+		}
+		return series(t, rec.Body.String())
+	}
+
+	t.Run("leader", func(t *testing.T) {
+		got := scrape(t, true)
+		if _, ok := got[`pbm_pitr_lag_seconds{rs="rs0"}`]; !ok {
+			t.Errorf("no cluster series: %v", keys(got))
+		}
+		if _, ok := got[`pbm_lock_acquire_failures_total{operation="backup",reason="concurrent"}`]; !ok {
+			t.Errorf("no agent series: %v", keys(got))
+		}
+		if _, ok := got[`pbm_agent_memory_bytes{state="used"}`]; !ok {
+			t.Errorf("no memory series: %v", keys(got))
+		}
+	})
+
+	t.Run("not leader", func(t *testing.T) {
+		got := scrape(t, false)
+		for k := range got {
+			if strings.HasPrefix(k, "pbm_pitr_") || strings.HasPrefix(k, "pbm_backup_") ||
+				strings.HasPrefix(k, "pbm_operation_") {
+				t.Errorf("cluster series %s exported by a not leader agent", k)
+			}
+		}
+		if _, ok := got[`pbm_lock_acquire_failures_total{operation="backup",reason="concurrent"}`]; !ok {
+			t.Errorf("no agent series: %v", keys(got))
+		}
+	})
+}
+
+func keys(m map[string]string) []string {
+	rv := make([]string, 0, len(m))
+	for k := range m {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
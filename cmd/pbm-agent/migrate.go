@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// StorageMigrate moves backups and PITR chunks between the storages
+func (a *Agent) StorageMigrate(ctx context.Context, d *ctrl.MigrateCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	l := logger.NewEvent(string(ctrl.CmdStorageMigrate), "", opid.String(), ep.TS())
+
+	if d == nil {
+		l.Error("missed command")
+		return
+	}
+
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		l.Error("get node info data: %v", err)
+		return
+	}
+	if !nodeInfo.IsLeader() {
+		l.Info("not a member of the leader rs, skipping")
+		return
+	}
+
+	epts := ep.TS()
+	lck := lock.NewLock(a.leadConn, lock.LockHeader{
+		Replset: a.brief.SetName,
+		Node:    a.brief.Me,
+		Type:    ctrl.CmdStorageMigrate,
+		OPID:    opid.String(),
+		Epoch:   &epts,
+	})
+
+	got, err := a.acquireLock(ctx, lck, l)
+	if err != nil {
+		l.Error("acquire lock: %v", err)
+		return
+	}
+	if !got {
+		l.Debug("skip: lock not acquired")
+		return
+	}
+	defer func() {
+		if err := lck.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	// backups and PITR of other replsets write to the storages as well
+	if err := a.clusterLockCheck(ctx, &lck.LockHeader); err != nil {
+		l.Error("%v", err)
+		return
+	}
+
+	o := &backup.MigrateOptions{
+		From:         d.From,
+		To:           d.To,
+		Backup:       d.Backup,
+		DeleteSource: d.DeleteSource,
+	}
+	plan, err := backup.PlanMigration(ctx, a.leadConn, o, a.brief.Me, l)
+	if err != nil {
+		l.Error("plan: %v", err)
+		return
+	}
+
+	l.Info("migrating %d backups and %d PITR chunks: %d files, %d bytes",
+		len(plan.Backups), len(plan.Chunks), plan.Objects(), plan.Size())
+	err = backup.Migrate(ctx, a.leadConn, plan, o, a.brief.Me, l)
+	if err != nil {
+		l.Error("migrate: %v", err)
+		return
+	}
+
+	l.Info("done")
+}
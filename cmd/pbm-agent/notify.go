@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
+)
+
+// notify sends the event to the webhooks of the current config.
+func (a *Agent) notify(ctx context.Context, p *notify.Payload) {
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("notify %s: get config: %v", p.Event, err)
+		return
+	}
+
+	a.notifyWith(ctx, cfg, p)
+}
+
+// notifyWith sends the event to the webhooks of cfg in background,
+// so the delivery retries don't hold the operation.
+func (a *Agent) notifyWith(ctx context.Context, cfg *config.Config, p *notify.Payload) {
+	// the operation context may be canceled once it's finished
+	go a.notifyWait(context.WithoutCancel(ctx), cfg, p)
+}
+
+// notifyWait sends the event to the webhooks of cfg and waits for the delivery.
+// It's used if the agent may exit right after the operation (restore).
+func (a *Agent) notifyWait(ctx context.Context, cfg *config.Config, p *notify.Payload) {
+	if cfg == nil || cfg.Notifications == nil || len(cfg.Notifications.Webhooks) == 0 {
+		return
+	}
+
+	if p.Time == 0 {
+		p.Time = time.Now().Unix()
+	}
+	if p.Replset == "" {
+		p.Replset = a.brief.SetName
+	}
+	p.Node = a.brief.Me
+
+	l := log.FromContext(ctx).NewEvent("notify", string(p.Event), p.OPID, primitive.Timestamp{})
+	notify.Send(ctx, cfg.Notifications.Webhooks, p, l)
+}
+
+// backupPayload makes the payload of the finished (or failed) backup.
+func backupPayload(bcp *backup.BackupMeta) *notify.Payload {
+	p := &notify.Payload{
+		Event:       notify.BackupFinished,
+		Name:        bcp.Name,
+		Type:        string(bcp.Type),
+		OPID:        bcp.OPID,
+		Start:       bcp.StartTS,
+		End:         bcp.LastTransitionTS,
+		DurationSec: bcp.LastTransitionTS - bcp.StartTS,
+		Size:        bcp.Size,
+	}
+	for _, rs := range bcp.Replsets {
+		for _, ns := range rs.NSStats {
+			p.SizeUncompressed += ns.Size
+		}
+	}
+	if bcp.Status != defs.StatusDone {
+		p.Event = notify.BackupFailed
+		if err := bcp.Error(); err != nil {
+			p.Error = err.Error()
+		} else {
+			p.Error = "backup is " + string(bcp.Status)
+		}
+	}
+
+	return p
+}
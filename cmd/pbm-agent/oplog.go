@@ -31,7 +31,7 @@ func (a *Agent) OplogReplay(ctx context.Context, r *ctrl.ReplayCmd, opID ctrl.OP
 		time.Unix(int64(r.End.T), 0).UTC().Format(time.RFC3339),
 	)
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info: %s", err.Error())
 		return
@@ -70,14 +70,20 @@ func (a *Agent) OplogReplay(ctx context.Context, r *ctrl.ReplayCmd, opID ctrl.OP
 		}
 	}()
 
+	if err := a.checkPrivileges(ctx, topo.PrivilegeOpReplay); err != nil {
+		l.Error("oplog replay: %v", err)
+		return
+	}
+
 	cfg, err := config.GetConfig(ctx, a.leadConn)
 	if err != nil {
 		l.Error("get PBM config: %v", err)
 		return
 	}
+	setDecompression(cfg)
 
 	l.Info("oplog replay started")
-	rr := restore.New(a.leadConn, a.nodeConn, a.brief, cfg, r.RSMap, 0, 1)
+	rr := restore.New(a.leadConn, a.nodeClient(), a.brief, cfg, r.RSMap, 0, 1)
 	err = rr.ReplayOplog(ctx, r, opID, l)
 	if err != nil {
 		if errors.Is(err, restore.ErrNoDataForShard) {
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"maps"
+	"slices"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
@@ -15,9 +19,11 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/prio"
 	"github.com/percona/percona-backup-mongodb/pbm/slicer"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
 )
@@ -32,7 +38,12 @@ const (
 	pitrWatchMonitorPollingCycle    = 15 * time.Second
 	pitrTopoMonitorPollingCycle     = 2 * time.Minute
 	pitrActivityMonitorPollingCycle = 2 * time.Minute
+	pitrGapMonitorPollingCycle      = 30 * time.Second
 	pitrHb                          = 5 * time.Second
+
+	// pitrGapNodeCoeff is a priority coefficient for the node that was
+	// slicing when a gap has been detected
+	pitrGapNodeCoeff = 0.1
 )
 
 type currentPitr struct {
@@ -77,6 +88,7 @@ func (a *Agent) startMon(ctx context.Context, cfg *config.Config) {
 	go a.pitrErrorMonitor(ctx)
 	go a.pitrTopoMonitor(ctx)
 	go a.pitrActivityMonitor(ctx)
+	go a.pitrGapMonitor(ctx)
 
 	go a.pitrHb(ctx)
 }
@@ -110,6 +122,11 @@ func (a *Agent) PITR(ctx context.Context) {
 	l.Printf("starting PITR routine")
 
 	for {
+		if a.isDraining() {
+			l.Printf("stopping PITR routine")
+			return
+		}
+
 		err := a.pitr(ctx)
 		if err != nil {
 			// we need epoch just to log pitr err with an extra context
@@ -123,27 +140,32 @@ func (a *Agent) PITR(ctx context.Context) {
 }
 
 // canSlicingNow returns lock.ConcurrentOpError if there is a parallel operation.
-// Only physical backups (full, incremental, external) is allowed.
+// Only physical backups (full, incremental, external), logical backups to another
+// storage and operations compatible with the slicing (see lock.Compatible) are allowed.
 func canSlicingNow(ctx context.Context, conn connect.Client, stgCfg *config.StorageConf) error {
 	ts, err := topo.GetClusterTime(ctx, conn)
 	if err != nil {
 		return errors.Wrap(err, "read cluster time")
 	}
 
-	locks, err := lock.GetLocks(ctx, conn, &lock.LockHeader{})
+	locks, err := lock.GetOperationLocks(ctx, conn, &lock.LockHeader{})
 	if err != nil {
 		return errors.Wrap(err, "get locks data")
 	}
 
+	staleSec := lock.StaleSec(ctx, conn)
 	for i := range locks {
 		l := &locks[i]
 
-		if l.Heartbeat.T+defs.StaleFrameSec < ts.T {
+		if l.IsStale(ts, staleSec) {
 			// lock is stale, PITR can ignore it
 			continue
 		}
 
 		if l.Type != ctrl.CmdBackup {
+			if lock.Compatible(&lock.LockHeader{Type: ctrl.CmdPITR}, &l.LockHeader) {
+				continue
+			}
 			return lock.ConcurrentOpError{l.LockHeader}
 		}
 
@@ -170,8 +192,9 @@ func (a *Agent) pitr(ctx context.Context) error {
 			PITR: &config.PITRConf{},
 		}
 	}
+	setMemoryBudget(cfg)
 
-	slicerInterval := cfg.OplogSlicerInterval()
+	slicerInterval := cfg.OplogSlicerIntervalRS(a.brief.SetName)
 
 	ep := config.Epoch(cfg.Epoch)
 	l := log.FromContext(ctx).NewEvent(string(ctrl.CmdPITR), "", "", ep.TS())
@@ -199,6 +222,7 @@ func (a *Agent) pitr(ctx context.Context) error {
 		currInterval := p.slicer.GetSpan()
 		if currInterval != slicerInterval {
 			p.slicer.SetSpan(slicerInterval)
+			a.checkOplogWindow(ctx, slicerInterval)
 
 			// wake up slicer only if a new interval is smaller
 			if currInterval > slicerInterval {
@@ -206,6 +230,14 @@ func (a *Agent) pitr(ctx context.Context) error {
 			}
 		}
 
+		pitrConf := cfg.PITRConfRS(a.brief.SetName)
+		c, level := p.slicer.GetCompression()
+		if c != pitrConf.Compression || !equalCompressionLevel(level, pitrConf.CompressionLevel) {
+			p.slicer.SetCompression(pitrConf.Compression, pitrConf.CompressionLevel)
+			l.Info("pitr compression has changed to %s. it will be applied to the next chunk",
+				fmtCompression(pitrConf.Compression, pitrConf.CompressionLevel))
+		}
+
 		return nil
 	}
 
@@ -223,12 +255,12 @@ func (a *Agent) pitr(ctx context.Context) error {
 	// if node failing, then some other agent with healthy node will hopefully catch up
 	// so this code won't be reached and will not pollute log with "pitr" errors while
 	// the other node does successfully slice
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		return errors.Wrap(err, "get node info")
 	}
 
-	q, err := topo.NodeSuits(ctx, a.nodeConn, nodeInfo)
+	q, err := topo.NodeSuits(ctx, a.nodeClient(), nodeInfo)
 	if err != nil {
 		return errors.Wrap(err, "node check")
 	}
@@ -242,7 +274,7 @@ func (a *Agent) pitr(ctx context.Context) error {
 		a.startMon(ctx, cfg)
 
 		// start nomination process on cluster leader
-		go a.leadNomination(ctx, cfg.PITR.Priority)
+		go a.leadNomination(ctx, cfg)
 	}
 
 	nominated, err := a.waitNominationForPITR(ctx, nodeInfo.SetName, nodeInfo.Me)
@@ -284,7 +316,11 @@ func (a *Agent) pitr(ctx context.Context) error {
 		}
 	}()
 
-	stg, err := util.StorageFromConfig(&cfg.Storage, a.brief.Me, l)
+	var stg storage.Storage
+	stgName, stgConf, err := config.GetReplsetStorage(ctx, a.leadConn, cfg, a.brief.SetName)
+	if err == nil {
+		stg, err = util.StorageFromConfig(stgConf, a.brief.Me, l)
+	}
 	if err != nil {
 		if err := lck.Release(); err != nil {
 			l.Error("release lock: %v", err)
@@ -293,8 +329,10 @@ func (a *Agent) pitr(ctx context.Context) error {
 		return err
 	}
 
-	s := slicer.NewSlicer(a.brief.SetName, a.leadConn, a.nodeConn, stg, cfg, log.FromContext(ctx))
+	s := slicer.NewSlicer(a.brief.SetName, a.leadConn, a.nodeClient(), stg, cfg, log.FromContext(ctx))
+	s.SetStorageName(stgName)
 	s.SetSpan(slicerInterval)
+	a.checkOplogWindow(ctx, slicerInterval)
 
 	if cfg.PITR.OplogOnly {
 		err = s.OplogOnlyCatchup(ctx)
@@ -309,7 +347,10 @@ func (a *Agent) pitr(ctx context.Context) error {
 		return err
 	}
 
+	a.jobStarted()
 	go func() {
+		defer a.jobDone()
+
 		stopSlicingCtx, stopSlicing := context.WithCancel(ctx)
 		defer stopSlicing()
 		stopC := make(chan struct{})
@@ -320,6 +361,11 @@ func (a *Agent) pitr(ctx context.Context) error {
 			cancel: stopSlicing,
 			w:      w,
 		})
+		if a.isDraining() {
+			// the shutdown has begun during the catchup.
+			// make the last chunk and stop
+			stopSlicing()
+		}
 
 		go func() {
 			<-stopSlicingCtx.Done()
@@ -365,8 +411,6 @@ func (a *Agent) pitr(ctx context.Context) error {
 			nodeInfo,
 			stopC,
 			w,
-			cfg.PITR.Compression,
-			cfg.PITR.CompressionLevel,
 			cfg.Backup.Timeouts,
 			monitorPrio,
 		)
@@ -390,7 +434,7 @@ func (a *Agent) pitr(ctx context.Context) error {
 // It requires to be run in separate go routine on cluster leader.
 func (a *Agent) leadNomination(
 	ctx context.Context,
-	cfgPrio config.Priority,
+	cfg *config.Config,
 ) {
 	l := log.LogEventFromContext(ctx)
 
@@ -418,14 +462,15 @@ func (a *Agent) leadNomination(
 		return
 	}
 
-	nodes := prio.CalcNodesPriority(nil, cfgPrio, candidates)
-
 	shards, err := topo.ClusterMembers(ctx, a.leadConn.MongoClient())
 	if err != nil {
 		l.Error("get cluster members: %v", err)
 		return
 	}
 
+	nodes := prio.CalcNodesPriority(a.gapNodesCoeff(ctx, shards), cfg.PITR.Priority,
+		clockSynced(cfg, pitrCapable(candidates, l), l))
+
 	l.Debug("cluster is ready for nomination")
 	err = oplog.SetClusterStatus(ctx, a.leadConn, oplog.StatusReady)
 	if err != nil {
@@ -455,6 +500,33 @@ func (a *Agent) leadNomination(
 	}
 }
 
+// gapNodesCoeff returns priority coefficients that move nodes, which were
+// slicing when a gap has been detected, to the end of the nomination list.
+// So slicing is restarted on another eligible node if there is any.
+func (a *Agent) gapNodesCoeff(ctx context.Context, shards []topo.Shard) map[string]float64 {
+	l := log.LogEventFromContext(ctx)
+
+	var c map[string]float64
+	for _, sh := range shards {
+		gap, err := oplog.GetOpenPITRGap(ctx, a.leadConn, sh.RS)
+		if err != nil {
+			if !errors.Is(err, errors.ErrNotFound) {
+				l.Warning("get open pitr gap for %s: %v", sh.RS, err)
+			}
+			continue
+		}
+		if gap.Node == "" {
+			continue
+		}
+		if c == nil {
+			c = make(map[string]float64)
+		}
+		c[gap.Node] = pitrGapNodeCoeff
+	}
+
+	return c
+}
+
 func (a *Agent) nominateRSForPITR(ctx context.Context, rs string, nodes [][]string) error {
 	l := log.LogEventFromContext(ctx)
 	l.Debug("pitr nomination list for %s: %v", rs, nodes)
@@ -505,7 +577,7 @@ func (a *Agent) pitrLockCheck(ctx context.Context) (bool, error) {
 	}
 
 	// stale lock means we should move on and clean it up during the lock.Acquire
-	return tl.Heartbeat.T+defs.StaleFrameSec < ts.T, nil
+	return tl.IsStale(ts, lock.StaleSec(ctx, a.leadConn)), nil
 }
 
 // waitAllOpLockRelease waits to not have any live OpLock and in such a case returns true.
@@ -729,6 +801,38 @@ func (a *Agent) pitrConfigMonitor(ctx context.Context, firstConf *config.Config)
 	}
 }
 
+// checkOplogWindow warns if the oplog slicing span is too long compared to
+// the oplog window on the node. So oplog records may be rotated out before
+// they are saved into the chunk.
+func (a *Agent) checkOplogWindow(ctx context.Context, span time.Duration) {
+	l := log.LogEventFromContext(ctx)
+
+	w, err := oplog.GetOplogWindow(ctx, a.nodeClient())
+	if err != nil {
+		l.Warning("unable to check oplog window: %v", err)
+		return
+	}
+
+	if span*2 > w {
+		l.Warning("oplog span %v risks exceeding the oplog window on %s (%v at the current churn). "+
+			"Consider lower pitr.oplogSpanMin or bigger oplog", span, a.brief.SetName, w)
+	}
+}
+
+func equalCompressionLevel(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func fmtCompression(c compress.CompressionType, level *int) string {
+	if level == nil {
+		return string(c)
+	}
+	return fmt.Sprintf("%s (level %d)", c, *level)
+}
+
 func isPITRConfigChanged(c1, c2 *config.PITRConf) bool {
 	if c1 == nil || c2 == nil {
 		return c1 == c2
@@ -743,10 +847,206 @@ func isPITRConfigChanged(c1, c2 *config.PITRConf) bool {
 	if !maps.Equal(c1.Priority, c2.Priority) {
 		return false
 	}
+	if !slices.Equal(c1.CaptureExcludeNS(), c2.CaptureExcludeNS()) {
+		return false
+	}
 
 	return true
 }
 
+// pitrGapThreshold returns the max allowed lag of the last chunk behind
+// the cluster time for the given slicing span.
+func pitrGapThreshold(span time.Duration) time.Duration {
+	return span*2 + time.Duration(defs.StaleFrameSec)*time.Second
+}
+
+// pitrGapMonitor watches PITR chunks of each replset. If the last chunk lags
+// behind the cluster time for longer than pitrGapThreshold, the gap is
+// recorded and slicing is restarted (preferably on another node).
+// Once slicing resumes, the gap is closed with the start of the first new chunk.
+func (a *Agent) pitrGapMonitor(ctx context.Context) {
+	l := log.LogEventFromContext(ctx)
+	l.Debug("start pitr gap monitor")
+	defer l.Debug("stop pitr gap monitor")
+
+	tk := time.NewTicker(pitrGapMonitorPollingCycle)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			restart, err := a.checkPITRGaps(ctx)
+			if err != nil {
+				l.Error("check pitr gaps: %v", err)
+				continue
+			}
+			if !restart {
+				continue
+			}
+
+			l.Info("pitr gap detected, re-configuring pitr members")
+			err = oplog.SetClusterStatus(ctx, a.leadConn, oplog.StatusReconfig)
+			if err != nil {
+				l.Error("gap monitor reconfig status set: %v", err)
+			}
+
+		case <-ctx.Done():
+			return
+
+		case <-a.monStopSig:
+			return
+		}
+	}
+}
+
+// checkPITRGaps opens and closes gaps for each replset.
+// It returns true if a new gap has been opened and slicing should be restarted.
+func (a *Agent) checkPITRGaps(ctx context.Context) (bool, error) {
+	l := log.LogEventFromContext(ctx)
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		return false, errors.Wrap(err, "get config")
+	}
+	if !cfg.PITR.Enabled {
+		return false, nil
+	}
+
+	// slicing is paused on purpose during some operations (e.g. logical backup)
+	paused := canSlicingNow(ctx, a.leadConn, &cfg.Storage) != nil
+
+	ts, err := topo.GetClusterTime(ctx, a.leadConn)
+	if err != nil {
+		return false, errors.Wrap(err, "read cluster time")
+	}
+
+	shards, err := topo.ClusterMembers(ctx, a.leadConn.MongoClient())
+	if err != nil {
+		return false, errors.Wrap(err, "get cluster members")
+	}
+
+	restart := false
+	for _, sh := range shards {
+		last, err := oplog.PITRLastChunkMeta(ctx, a.leadConn, sh.RS)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				continue
+			}
+			return false, errors.Wrapf(err, "get last chunk for %s", sh.RS)
+		}
+
+		gap, err := oplog.GetOpenPITRGap(ctx, a.leadConn, sh.RS)
+		if err != nil && !errors.Is(err, errors.ErrNotFound) {
+			return false, errors.Wrapf(err, "get open gap for %s", sh.RS)
+		}
+
+		threshold := pitrGapThreshold(cfg.OplogSlicerIntervalRS(sh.RS))
+		switch pitrGapStepFor(gap, last, ts, threshold, paused) {
+		case pitrGapNone:
+			continue
+		case pitrGapClose:
+			end, err := oplog.GapEndTS(ctx, a.leadConn, sh.RS, gap.StartTS)
+			if err != nil {
+				return false, errors.Wrapf(err, "define gap end for %s", sh.RS)
+			}
+			err = oplog.ClosePITRGap(ctx, a.leadConn, sh.RS, end)
+			if err != nil {
+				return false, errors.Wrapf(err, "close gap for %s", sh.RS)
+			}
+
+			p := &notify.Payload{
+				Event:   notify.PITRGapClosed,
+				Replset: sh.RS,
+				Start:   int64(gap.StartTS.T),
+				End:     int64(end.T),
+			}
+			if end.After(gap.StartTS) {
+				l.Warning("pitr gap on %s closed. no oplog for [%s - %s]",
+					sh.RS, fmtPITRTS(gap.StartTS), fmtPITRTS(end))
+				p.DurationSec = int64(end.T) - int64(gap.StartTS.T)
+				p.Message = "no oplog for the gap"
+			} else {
+				l.Info("pitr gap on %s closed. slicing caught up from %s", sh.RS, fmtPITRTS(gap.StartTS))
+				p.Message = "slicing caught up"
+			}
+			a.notifyWith(ctx, cfg, p)
+			continue
+		}
+
+		lag := pitrLag(ts, last.EndTS)
+		node := ""
+		lck, err := lock.GetOpLockData(ctx, a.leadConn, &lock.LockHeader{
+			Replset: sh.RS,
+			Type:    ctrl.CmdPITR,
+		})
+		if err == nil {
+			node = lck.Node
+		}
+
+		opened, err := oplog.OpenPITRGap(ctx, a.leadConn, sh.RS, node, last.EndTS)
+		if err != nil {
+			return false, errors.Wrapf(err, "open gap for %s", sh.RS)
+		}
+		if opened {
+			l.Warning("pitr gap opened on %s: no oplog chunks since %s (%v behind the cluster time, threshold %v)",
+				sh.RS, fmtPITRTS(last.EndTS), lag, threshold)
+			restart = true
+			a.notifyWith(ctx, cfg, &notify.Payload{
+				Event:   notify.PITRGapOpened,
+				Replset: sh.RS,
+				Start:   int64(last.EndTS.T),
+				Message: fmt.Sprintf("no oplog chunks for %v (threshold %v), last slicing node %q", lag, threshold, node),
+			})
+		}
+	}
+
+	return restart, nil
+}
+
+// pitrGapStep is what the gap monitor does for the replset
+type pitrGapStep int
+
+const (
+	// pitrGapNone leaves the gap as is (or there is none)
+	pitrGapNone pitrGapStep = iota
+	// pitrGapOpen opens the gap, slicing lags for too long
+	pitrGapOpen
+	// pitrGapClose closes the open gap, slicing has resumed
+	pitrGapClose
+)
+
+// pitrGapStepFor returns the step for the replset with the open gap
+// (nil if none) and the last chunk at the cluster time now. No gap is
+// opened while slicing is paused.
+func pitrGapStepFor(
+	gap *oplog.PITRGap,
+	last *oplog.OplogChunk,
+	now primitive.Timestamp,
+	threshold time.Duration,
+	paused bool,
+) pitrGapStep {
+	if gap != nil {
+		if last.EndTS.After(gap.StartTS) {
+			return pitrGapClose
+		}
+		return pitrGapNone
+	}
+	if paused || pitrLag(now, last.EndTS) <= threshold {
+		return pitrGapNone
+	}
+
+	return pitrGapOpen
+}
+
+// pitrLag returns how long the chunks end ts lags behind the cluster time now
+func pitrLag(now, ts primitive.Timestamp) time.Duration {
+	return time.Duration(int64(now.T)-int64(ts.T)) * time.Second
+}
+
+func fmtPITRTS(ts primitive.Timestamp) string {
+	return time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339)
+}
+
 func (a *Agent) pitrTopoMonitor(ctx context.Context) {
 	l := log.LogEventFromContext(ctx)
 	l.Debug("start pitr topo monitor")
@@ -758,7 +1058,7 @@ func (a *Agent) pitrTopoMonitor(ctx context.Context) {
 	for {
 		select {
 		case <-tk.C:
-			nodeInfo, err := topo.GetNodeInfo(ctx, a.nodeConn)
+			nodeInfo, err := topo.GetNodeInfo(ctx, a.nodeClient())
 			if err != nil {
 				l.Error("topo monitor node info error", err)
 				continue
@@ -916,3 +1216,17 @@ func (a *Agent) pitrHb(ctx context.Context) {
 		}
 	}
 }
+
+// pitrCapable filters out the agents which user misses the PITR privileges
+func pitrCapable(agents []topo.AgentStat, l log.LogEvent) []topo.AgentStat {
+	rv := make([]topo.AgentStat, 0, len(agents))
+	for i := range agents {
+		if err := agents[i].Privileges.Check(topo.PrivilegeOpPITR); err != nil {
+			l.Warning("%s/%s isn't nominated: %v", agents[i].RS, agents[i].Node, err)
+			continue
+		}
+		rv = append(rv, agents[i])
+	}
+
+	return rv
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	pitrRestartPeriod = 30 * time.Second
+
+	pitrRestartEvent = "pitrRestart"
+)
+
+// PITRRestarter enables PITR back after the restore which has disabled it.
+// Only the cluster leader primary does it, once there is a base backup made
+// after the restore. With `pitr.autoRestartAfterRestore` the leader starts
+// the backup itself.
+func (a *Agent) PITRRestarter(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting pitr restarter")
+
+	tk := time.NewTicker(pitrRestartPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := a.restartPITR(ctx, time.Now().UTC())
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(pitrRestartEvent, "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) restartPITR(ctx context.Context, now time.Time) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil
+	}
+
+	// only the most recent restore defines the state of the data
+	rs, err := restore.RestoreList(ctx, a.leadConn, 1)
+	if err != nil {
+		return errors.Wrap(err, "get last restore")
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	meta := &rs[0]
+	p := meta.PITRRestart
+	if p == nil || p.Status != restore.PITRRestartPending {
+		return nil
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get config")
+	}
+
+	l := log.FromContext(ctx).NewEvent(pitrRestartEvent, "", "", cfg.Epoch)
+
+	bcp, err := backup.GetLastBackup(ctx, a.leadConn, nil)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return errors.Wrap(err, "get last backup")
+	}
+
+	switch nextPITRRestartStep(meta, cfg, bcp) {
+	case pitrRestartWait:
+		return nil
+	case pitrRestartFail:
+		l.Warning("restore %s has failed. PITR is left disabled", meta.Name)
+		return setPITRRestart(ctx, a.leadConn, meta.Name, p, restore.PITRRestartFailed, "the restore has failed")
+	case pitrRestartEnabled:
+		l.Info("PITR is already enabled after restore %s", meta.Name)
+		return setPITRRestart(ctx, a.leadConn, meta.Name, p, restore.PITRRestartDone, "")
+	case pitrRestartEnable:
+		err = a.setConfigVar(ctx, "pitr.enabled", "true", "pitr-restart", l)
+		if err != nil {
+			return errors.Wrap(err, "enable pitr")
+		}
+
+		l.Info("PITR is enabled after restore %s with base backup %s", meta.Name, bcp.Name)
+		p.Backup = bcp.Name
+		return setPITRRestart(ctx, a.leadConn, meta.Name, p, restore.PITRRestartDone, "")
+	case pitrRestartCheckBackup:
+		return a.checkPITRBaseBackup(ctx, meta.Name, p, now)
+	}
+
+	busy, err := conflictingOp(ctx, a.leadConn, &lock.LockHeader{Type: ctrl.CmdBackup})
+	if err != nil {
+		return errors.Wrap(err, "check running operations")
+	}
+	if busy != nil {
+		l.Debug("base backup is postponed by [%s, opid: %s]", busy.Type, busy.OPID)
+		return nil
+	}
+
+	cmd := ctrl.BackupCmd{
+		Type:             defs.LogicalBackup,
+		Name:             now.Format(time.RFC3339),
+		Compression:      cfg.Backup.Compression,
+		CompressionLevel: cfg.Backup.CompressionLevel,
+		Labels:           map[string]string{"restore": meta.Name},
+	}
+	if meta.Type != defs.LogicalBackup {
+		cmd.Type = defs.PhysicalBackup
+	}
+
+	// the backup is recorded first, so it isn't started twice
+	// if the leader changes
+	p.Backup = cmd.Name
+	err = setPITRRestart(ctx, a.leadConn, meta.Name, p, restore.PITRRestartPending, "")
+	if err != nil {
+		return err
+	}
+
+	opid, err := ctrl.SendBackup(ctx, a.leadConn, cmd)
+	if err != nil {
+		return errors.Wrap(err, "send backup command")
+	}
+
+	l.Info("base backup %q is started after restore %s [opid: %s]", cmd.Name, meta.Name, opid)
+	return nil
+}
+
+// pitrRestartStep is what the leader does next for the pending restart
+type pitrRestartStep int
+
+const (
+	// pitrRestartWait waits for the restore or the base backup
+	pitrRestartWait pitrRestartStep = iota
+	// pitrRestartFail leaves PITR disabled since the restore has failed
+	pitrRestartFail
+	// pitrRestartEnabled finishes the restart, PITR is enabled by the user
+	pitrRestartEnabled
+	// pitrRestartEnable enables PITR, there is a base backup
+	pitrRestartEnable
+	// pitrRestartCheckBackup checks the base backup started by the leader
+	pitrRestartCheckBackup
+	// pitrRestartStartBackup starts the base backup
+	pitrRestartStartBackup
+)
+
+// nextPITRRestartStep returns the next step of the pending restart after
+// the restore meta. last is the last base backup, nil if there is none.
+func nextPITRRestartStep(
+	meta *restore.RestoreMeta,
+	cfg *config.Config,
+	last *backup.BackupMeta,
+) pitrRestartStep {
+	switch meta.Status {
+	case defs.StatusDone, defs.StatusPartlyDone:
+	case defs.StatusError, defs.StatusCancelled, defs.StatusAborted:
+		return pitrRestartFail
+	default:
+		return pitrRestartWait
+	}
+
+	if cfg.PITR != nil && cfg.PITR.Enabled {
+		return pitrRestartEnabled
+	}
+	if last != nil && last.StartTS >= meta.LastTransitionTS {
+		return pitrRestartEnable
+	}
+	if cfg.PITR == nil || !cfg.PITR.AutoRestartAfterRestore {
+		return pitrRestartWait
+	}
+	if meta.PITRRestart.Backup != "" {
+		return pitrRestartCheckBackup
+	}
+	return pitrRestartStartBackup
+}
+
+// checkPITRBaseBackup fails the restart if the base backup started
+// by the leader hasn't succeeded.
+func (a *Agent) checkPITRBaseBackup(
+	ctx context.Context,
+	name string,
+	p *restore.PITRRestart,
+	now time.Time,
+) error {
+	bcp, err := backup.NewDBManager(a.leadConn).GetBackupByName(ctx, p.Backup)
+	if err != nil {
+		if !errors.Is(err, errors.ErrNotFound) {
+			return errors.Wrapf(err, "get backup %q", p.Backup)
+		}
+		bcp = nil
+	}
+
+	msg := baseBackupFailure(p, bcp, now)
+	if msg == "" {
+		return nil
+	}
+	return setPITRRestart(ctx, a.leadConn, name, p, restore.PITRRestartFailed, msg)
+}
+
+// baseBackupFailure returns why the base backup of the restart has failed.
+// Empty if it's running or done. bcp is nil if the backup isn't found.
+func baseBackupFailure(p *restore.PITRRestart, bcp *backup.BackupMeta, now time.Time) string {
+	switch {
+	case bcp == nil:
+		if now.Sub(time.Unix(p.UpdatedAt, 0)) < defs.WaitBackupStart*2 {
+			return ""
+		}
+		return "base backup " + p.Backup + " hasn't started"
+	case bcp.Status == defs.StatusError || bcp.Status == defs.StatusCancelled:
+		msg := "base backup " + p.Backup + " " + string(bcp.Status)
+		if bcp.Err != "" {
+			msg += ": " + bcp.Err
+		}
+		return msg
+	}
+
+	return ""
+}
+
+func setPITRRestart(
+	ctx context.Context,
+	conn connect.Client,
+	name string,
+	p *restore.PITRRestart,
+	s restore.PITRRestartStatus,
+	msg string,
+) error {
+	p.Status = s
+	p.Error = msg
+	p.UpdatedAt = time.Now().Unix()
+
+	err := restore.SetPITRRestart(ctx, conn, name, p)
+	return errors.Wrapf(err, "set pitr restart of restore %s", name)
+}
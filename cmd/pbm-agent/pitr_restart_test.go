@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+func TestNextPITRRestartStep(t *testing.T) {
+	restored := func(s defs.Status, bcp string) *restore.RestoreMeta {
+		return &restore.RestoreMeta{
+			Status:           s,
+			LastTransitionTS: 100,
+			PITRRestart:      &restore.PITRRestart{Status: restore.PITRRestartPending, Backup: bcp},
+		}
+	}
+	manual := &config.Config{PITR: &config.PITRConf{}}
+	auto := &config.Config{PITR: &config.PITRConf{AutoRestartAfterRestore: true}}
+	before := &backup.BackupMeta{Name: "before", StartTS: 99}
+	after := &backup.BackupMeta{Name: "after", StartTS: 100}
+
+	tests := []struct {
+		name string
+		meta *restore.RestoreMeta
+		cfg  *config.Config
+		last *backup.BackupMeta
+		want pitrRestartStep
+	}{
+		{"restore running", restored(defs.StatusRunning, ""), auto, nil, pitrRestartWait},
+		{"restore failed", restored(defs.StatusError, ""), auto, after, pitrRestartFail},
+		{"restore canceled", restored(defs.StatusCancelled, ""), auto, nil, pitrRestartFail},
+		{"enabled by user", restored(defs.StatusDone, ""), &config.Config{PITR: &config.PITRConf{Enabled: true}}, nil, pitrRestartEnabled},
+		{"base backup after restore", restored(defs.StatusPartlyDone, ""), manual, after, pitrRestartEnable},
+		{"no pitr config", restored(defs.StatusDone, ""), &config.Config{}, before, pitrRestartWait},
+		{"waits for user backup", restored(defs.StatusDone, ""), manual, before, pitrRestartWait},
+		{"starts base backup", restored(defs.StatusDone, ""), auto, before, pitrRestartStartBackup},
+		{"checks started backup", restored(defs.StatusDone, "b1"), auto, nil, pitrRestartCheckBackup},
+		{"started backup is done", restored(defs.StatusDone, "after"), auto, after, pitrRestartEnable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPITRRestartStep(tt.meta, tt.cfg, tt.last); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseBackupFailure(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	sent := &restore.PITRRestart{Backup: "b1", UpdatedAt: now.Unix()}
+	stale := &restore.PITRRestart{Backup: "b1", UpdatedAt: now.Add(-defs.WaitBackupStart * 2).Unix()}
+
+	tests := []struct {
+		name string
+		p    *restore.PITRRestart
+		bcp  *backup.BackupMeta
+		want string
+	}{
+		{"not started yet", sent, nil, ""},
+		{"never started", stale, nil, "base backup b1 hasn't started"},
+		{"running", stale, &backup.BackupMeta{Status: defs.StatusRunning}, ""},
+		{"done", stale, &backup.BackupMeta{Status: defs.StatusDone}, ""},
+		{"canceled", sent, &backup.BackupMeta{Status: defs.StatusCancelled}, "base backup b1 canceled"},
+		{"failed", sent, &backup.BackupMeta{Status: defs.StatusError, Err: "no space"}, "base backup b1 error: no space"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := baseBackupFailure(tt.p, tt.bcp, now); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+func TestPITRGapStepFor(t *testing.T) {
+	threshold := pitrGapThreshold(10 * time.Minute)
+	now := primitive.Timestamp{T: 100000}
+	chunk := func(end uint32) *oplog.OplogChunk {
+		return &oplog.OplogChunk{EndTS: primitive.Timestamp{T: end}}
+	}
+	open := &oplog.PITRGap{StartTS: primitive.Timestamp{T: 90000}}
+	lagging := now.T - uint32(threshold.Seconds()) - 1
+
+	tests := []struct {
+		name   string
+		gap    *oplog.PITRGap
+		last   *oplog.OplogChunk
+		paused bool
+		want   pitrGapStep
+	}{
+		{"in time", nil, chunk(now.T - 60), false, pitrGapNone},
+		{"at threshold", nil, chunk(now.T - uint32(threshold.Seconds())), false, pitrGapNone},
+		{"lags", nil, chunk(lagging), false, pitrGapOpen},
+		{"lags while paused", nil, chunk(lagging), true, pitrGapNone},
+		{"chunk ahead of cluster time", nil, chunk(now.T + 5), false, pitrGapNone},
+		{"open gap no new chunks", open, chunk(open.StartTS.T), false, pitrGapNone},
+		{"open gap resumed", open, chunk(open.StartTS.T + 1), false, pitrGapClose},
+		{"open gap resumed while paused", open, chunk(open.StartTS.T + 1), true, pitrGapClose},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pitrGapStepFor(tt.gap, tt.last, now, threshold, tt.paused); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPITRGapThreshold(t *testing.T) {
+	want := 20*time.Minute + time.Duration(defs.StaleFrameSec)*time.Second
+	if got := pitrGapThreshold(10 * time.Minute); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
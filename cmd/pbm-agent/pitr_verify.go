@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// PITRVerify checks integrity of the PITR chunks of every replset and saves
+// the report. It only reads the storage, so any agent of the cluster may
+// run it, including the one of a non-data-bearing node.
+func (a *Agent) PITRVerify(ctx context.Context, d *ctrl.PITRVerifyCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	l := logger.NewEvent(string(ctrl.CmdPITRVerify), "", opid.String(), ep.TS())
+
+	if d == nil {
+		l.Error("missed command")
+		return
+	}
+
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	// the lock is taken on the leader replset by any agent,
+	// so the only one of the cluster runs the verification
+	leader, err := topo.GetNodeInfo(ctx, a.leadConn.MongoClient())
+	if err != nil {
+		l.Error("get leader info: %v", err)
+		return
+	}
+	epts := ep.TS()
+	lck := lock.NewLock(a.leadConn, lock.LockHeader{
+		Replset: leader.SetName,
+		Node:    a.brief.Me,
+		Type:    ctrl.CmdPITRVerify,
+		OPID:    opid.String(),
+		Epoch:   &epts,
+		Scope:   lock.ScopeRead,
+	})
+
+	got, err := a.acquireLock(ctx, lck, l)
+	if err != nil {
+		l.Error("acquire lock: %v", err)
+		return
+	}
+	if !got {
+		l.Debug("skip: lock not acquired")
+		return
+	}
+	defer func() {
+		if err := lck.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	// another agent may have done it before we've got the lock
+	r, err := oplog.GetVerifyReport(ctx, a.leadConn)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		l.Error("get verification report: %v", err)
+		return
+	}
+	if r != nil && r.OPID == opid.String() {
+		l.Debug("skip: already verified by %s", r.Node)
+		return
+	}
+
+	r = &oplog.VerifyReport{
+		OPID:     opid.String(),
+		Node:     a.brief.Me,
+		Status:   defs.StatusRunning,
+		StartTS:  time.Now().Unix(),
+		Replsets: []oplog.RSVerifyResult{},
+	}
+	if err := oplog.SetVerifyReport(ctx, a.leadConn, r); err != nil {
+		l.Error("set verification status: %v", err)
+		return
+	}
+
+	l.Info("verifying pitr chunks")
+	err = a.verifyPITRChunks(ctx, d, r, l)
+	r.FinishTS = time.Now().Unix()
+	if err != nil {
+		r.Status = defs.StatusError
+		r.Error = err.Error()
+		l.Error("verify: %v", err)
+	} else {
+		r.Status = defs.StatusDone
+		l.Info("verified %d replsets", len(r.Replsets))
+	}
+
+	if err := oplog.SetVerifyReport(ctx, a.leadConn, r); err != nil {
+		l.Error("set verification report: %v", err)
+	}
+}
+
+func (a *Agent) verifyPITRChunks(
+	ctx context.Context,
+	d *ctrl.PITRVerifyCmd,
+	r *oplog.VerifyReport,
+	l log.LogEvent,
+) error {
+	stg, err := util.GetStorage(ctx, a.leadConn, a.brief.Me, l)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+	// the chunks of a replset with the storage override are on the profile
+	// storage. stgs caches storages by the profile name, "" is the main storage.
+	stgs := map[string]storage.Storage{"": stg}
+	chunkStorage := func(c *oplog.OplogChunk) (storage.Storage, error) {
+		if s, ok := stgs[c.Storage]; ok {
+			return s, nil
+		}
+		s, err := util.GetProfileStorage(ctx, a.leadConn, c.Storage, a.brief.Me, l)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get storage of chunk %q", c.FName)
+		}
+		stgs[c.Storage] = s
+		return s, nil
+	}
+
+	shards, err := topo.ClusterMembers(ctx, a.leadConn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+
+	for _, sh := range shards {
+		res, err := oplog.VerifyReplset(ctx, a.leadConn, chunkStorage, sh.RS, d.From, d.To)
+		if err != nil {
+			return errors.Wrapf(err, "verify %s", sh.RS)
+		}
+		if res.Chunks == 0 && res.Watermark.IsZero() {
+			continue
+		}
+		r.Replsets = append(r.Replsets, *res)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// privilegesCache keeps the privileges check of the PBM user for
+// the config epoch. Grants on a new epoch (e.g. after `pbm config`)
+// are checked again, and so is a check with missing privileges.
+type privilegesCache struct {
+	mx    sync.Mutex
+	epoch primitive.Timestamp
+	p     *topo.Privileges
+}
+
+// privileges returns the privileges check of the PBM user of the agent.
+func (a *Agent) privileges(ctx context.Context) (*topo.Privileges, error) {
+	ep, _ := config.GetEpoch(ctx, a.leadConn)
+
+	c := &a.privs
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.p != nil && len(c.p.Missing) == 0 && c.epoch.Equal(ep.TS()) {
+		return c.p, nil
+	}
+
+	p, err := topo.CheckPrivileges(ctx, a.nodeClient(), a.leadConn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "check user privileges")
+	}
+
+	c.p = p
+	c.epoch = ep.TS()
+	return p, nil
+}
+
+// checkPrivileges returns an error if the PBM user of the agent misses
+// privileges needed for the ops. A failure of the check itself is only
+// logged, the operation would fail anyway if the user can't do it.
+func (a *Agent) checkPrivileges(ctx context.Context, ops ...topo.PrivilegeOp) error {
+	p, err := a.privileges(ctx)
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("%v", err)
+		return nil
+	}
+
+	return p.Check(ops...)
+}
+
+// updatePrivileges sets the privileges check of the heartbeat and warns
+// about the missing privileges when they change.
+func (a *Agent) updatePrivileges(ctx context.Context, l log.LogEvent, hb *topo.AgentStat) {
+	if hb.Arbiter {
+		hb.Privileges = nil
+		return
+	}
+
+	p, err := a.privileges(ctx)
+	if err != nil {
+		l.Warning("%v", err)
+		return
+	}
+
+	if hb.Privileges == nil || !reflect.DeepEqual(hb.Privileges.Missing, p.Missing) {
+		err := p.Check(topo.PrivilegeOpBackup, topo.PrivilegeOpRestore,
+			topo.PrivilegeOpReplay, topo.PrivilegeOpPITR)
+		if err != nil {
+			l.Warning("%v", err)
+		}
+	}
+	hb.Privileges = p
+}
+
+// checkBackupPrivileges fails the backup if a replset in it has no agent
+// which user has the backup privileges. Older agents don't report it and
+// are taken as sufficient.
+func (a *Agent) checkBackupPrivileges(ctx context.Context, cmd *ctrl.BackupCmd) error {
+	agents, err := topo.ListSteadyAgents(ctx, a.leadConn)
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("privileges check: get agents list: %v", err)
+		return nil
+	}
+
+	ok := make(map[string]bool)
+	missing := make(map[string][]string)
+	var rss []string
+	for i := range agents {
+		ag := &agents[i]
+		if ag.Arbiter || (cmd.Replset != "" && ag.RS != cmd.Replset) {
+			continue
+		}
+		if _, seen := ok[ag.RS]; !seen {
+			ok[ag.RS] = false
+			rss = append(rss, ag.RS)
+		}
+
+		err := ag.Privileges.Check(topo.PrivilegeOpBackup)
+		if err == nil {
+			ok[ag.RS] = true
+			continue
+		}
+		missing[ag.RS] = append(missing[ag.RS], fmt.Sprintf("%s: %v", ag.Node, err))
+	}
+
+	var errs []string
+	for _, rs := range rss {
+		if !ok[rs] {
+			errs = append(errs, strings.Join(missing[rs], "; "))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.Errorf("no agent with sufficient privileges: %s", strings.Join(errs, "; "))
+}
@@ -45,7 +45,7 @@ func (a *Agent) handleAddConfigProfile(
 		}
 	}()
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		err = errors.Wrap(err, "get node info")
 		return
@@ -61,6 +61,7 @@ func (a *Agent) handleAddConfigProfile(
 		Node:    a.brief.Me,
 		OPID:    opid.String(),
 		Epoch:   util.Ref(epoch.TS()),
+		Storage: cmd.Name,
 	})
 
 	got, err := a.acquireLock(ctx, lck, l)
@@ -94,7 +95,7 @@ func (a *Agent) handleAddConfigProfile(
 
 	err = storage.HasReadAccess(ctx, stg)
 	if err != nil {
-		if !errors.Is(err, storage.ErrUninitialized) {
+		if !errors.Is(err, storage.ErrUninitialized) || cmd.Storage.ReadOnly {
 			err = errors.Wrap(err, "check read access")
 			return
 		}
@@ -106,6 +107,17 @@ func (a *Agent) handleAddConfigProfile(
 		}
 	}
 
+	err = resync.CheckStorageOwner(ctx, a.leadConn, stg, false)
+	if err != nil {
+		if !errors.Is(err, util.ErrStorageOwned) {
+			return
+		}
+		// the profile is still usable to read the backups
+		l.Warning("%v. Backups to the profile and its resync will fail until the storage is claimed: "+
+			"`pbm profile sync %s --force-claim-storage`", err, cmd.Name)
+		err = nil
+	}
+
 	profile := &config.Config{
 		Name:      cmd.Name,
 		IsProfile: true,
@@ -149,7 +161,7 @@ func (a *Agent) handleRemoveConfigProfile(
 		}
 	}()
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		err = errors.Wrap(err, "get node info")
 		return
@@ -165,6 +177,7 @@ func (a *Agent) handleRemoveConfigProfile(
 		Node:    a.brief.Me,
 		OPID:    opid.String(),
 		Epoch:   util.Ref(epoch.TS()),
+		Storage: cmd.Name,
 	})
 
 	got, err := a.acquireLock(ctx, lck, l)
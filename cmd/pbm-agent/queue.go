@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/queue"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	queueCheckPeriod = 5 * time.Second
+	// queueKeep is how long the finished entries are kept for `pbm status`
+	queueKeep = 24 * time.Hour
+
+	queueEvent = "queue"
+)
+
+// Queue starts the queued backups once the running operation is done.
+// The queue is processed only by the cluster leader primary.
+func (a *Agent) Queue(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting backup queue")
+
+	tk := time.NewTicker(queueCheckPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := a.dispatchQueue(ctx, time.Now())
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(queueEvent, "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) dispatchQueue(ctx context.Context, now time.Time) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil
+	}
+
+	pending, err := queue.Pending(ctx, a.leadConn)
+	if err != nil {
+		return errors.Wrap(err, "get pending")
+	}
+
+	ep, _ := config.GetEpoch(ctx, a.leadConn)
+	l := log.FromContext(ctx).NewEvent(queueEvent, "", "", ep.TS())
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	err = queue.DeleteFinished(ctx, a.leadConn, now.Add(-queueKeep))
+	if err != nil {
+		l.Warning("delete finished: %v", err)
+	}
+
+	expired, next := splitExpired(pending, now)
+	for _, e := range expired {
+		ok, err := queue.SetStatus(ctx, a.leadConn, e.ID,
+			queue.StatusPending, queue.StatusExpired, "not started within TTL")
+		if err != nil {
+			return errors.Wrapf(err, "expire %s", e.ID.Hex())
+		}
+		if ok {
+			l.Warning("%s backup [opid: %s] has expired in the queue", e.Backup.Type, e.ID.Hex())
+		}
+	}
+	if next == nil {
+		return nil
+	}
+
+	busy, err := conflictingOp(ctx, a.leadConn, &lock.LockHeader{Type: ctrl.CmdBackup})
+	if err != nil {
+		return errors.Wrap(err, "check running operations")
+	}
+	if busy != nil {
+		l.Debug("waiting for [%s, opid: %s]", busy.Type, busy.OPID)
+		return nil
+	}
+
+	starting, err := a.queuedStarting(ctx, now)
+	if err != nil {
+		return err
+	}
+	if starting != "" {
+		l.Debug("waiting for the queued backup %q to start", starting)
+		return nil
+	}
+
+	return a.startQueued(ctx, next, now)
+}
+
+// splitExpired returns the pending entries expired by now up to the first
+// unexpired one and that entry. The next is nil if all are expired.
+func splitExpired(pending []queue.Entry, now time.Time) ([]*queue.Entry, *queue.Entry) {
+	var expired []*queue.Entry
+	for i := range pending {
+		e := &pending[i]
+		if e.ExpireAt > now.Unix() {
+			return expired, e
+		}
+		expired = append(expired, e)
+	}
+
+	return expired, nil
+}
+
+// queuedStarting returns the name of the last started queued backup if
+// it's still being started. Its lock may be not acquired yet, so the next
+// backup would fail on the lock.
+func (a *Agent) queuedStarting(ctx context.Context, now time.Time) (string, error) {
+	last, err := queue.LastStarted(ctx, a.leadConn)
+	if err != nil {
+		return "", errors.Wrap(err, "get last started")
+	}
+	if last == nil || now.Sub(time.Unix(last.Started, 0)) > defs.WaitBackupStart*2 {
+		return "", nil
+	}
+
+	bcp, err := backup.NewDBManager(a.leadConn).GetBackupByName(ctx, last.Name)
+	switch {
+	case errors.Is(err, errors.ErrNotFound):
+		return last.Name, nil
+	case err != nil:
+		return "", errors.Wrapf(err, "get backup %q", last.Name)
+	case bcp.Status == defs.StatusStarting:
+		return last.Name, nil
+	}
+
+	return "", nil
+}
+
+func (a *Agent) startQueued(ctx context.Context, e *queue.Entry, now time.Time) error {
+	l := log.LogEventFromContext(ctx)
+
+	cmd := e.Backup
+	cmd.Name = now.UTC().Format(time.RFC3339)
+
+	ok, err := queue.Start(ctx, a.leadConn, e.ID, cmd.Name)
+	if err != nil {
+		return errors.Wrapf(err, "start %s", e.ID.Hex())
+	}
+	if !ok {
+		// cancelled or started by another agent
+		return nil
+	}
+
+	opid, err := ctrl.SendBackupAs(ctx, a.leadConn, cmd, e.Initiator)
+	if err != nil {
+		err = errors.Wrap(err, "send backup command")
+		_, e2 := queue.SetStatus(ctx, a.leadConn, e.ID, queue.StatusStarted, queue.StatusFailed, err.Error())
+		if e2 != nil {
+			l.Warning("set status of %s: %v", e.ID.Hex(), e2)
+		}
+		return err
+	}
+
+	err = queue.SetOPID(ctx, a.leadConn, e.ID, opid.String())
+	if err != nil {
+		l.Warning("set opid of %s: %v", e.ID.Hex(), err)
+	}
+
+	l.Info("queued backup [opid: %s] is started as %q [opid: %s]", e.ID.Hex(), cmd.Name, opid)
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/queue"
+)
+
+func queueAgent(mt *mtest.T) *Agent {
+	a := &Agent{leadConn: connect.UnsafeClient(mt.Client)}
+	a.nodeConn.Store(mt.Client)
+	return a
+}
+
+func mockCursor(t testing.TB, docs ...any) bson.D {
+	t.Helper()
+
+	batch := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := bson.D{}
+		if err := bson.Unmarshal(raw, &d); err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, d)
+	}
+	return mtest.CreateCursorResponse(0, "admin.c", mtest.FirstBatch, batch...)
+}
+
+func mockCommands(mt *mtest.T) []string {
+	rv := []string{}
+	for _, ev := range mt.GetAllStartedEvents() {
+		rv = append(rv, ev.CommandName)
+	}
+	return rv
+}
+
+func queued(expireAt int64) queue.Entry {
+	return queue.Entry{
+		ID:        primitive.NewObjectID(),
+		Backup:    ctrl.BackupCmd{Type: defs.LogicalBackup},
+		Initiator: &ctrl.Initiator{Host: "h"},
+		Status:    queue.StatusPending,
+		ExpireAt:  expireAt,
+	}
+}
+
+func TestSplitExpired(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a, b, c := queued(900), queued(1000), queued(1100)
+
+	tests := []struct {
+		name    string
+		pending []queue.Entry
+		expired []primitive.ObjectID
+		next    *primitive.ObjectID
+	}{
+		{"empty", nil, nil, nil},
+		{"none expired", []queue.Entry{c}, nil, &c.ID},
+		{"all expired", []queue.Entry{a, b}, []primitive.ObjectID{a.ID, b.ID}, nil},
+		{"expired before next", []queue.Entry{a, b, c}, []primitive.ObjectID{a.ID, b.ID}, &c.ID},
+		// the entries after the next are left till it's started
+		{"expired after next", []queue.Entry{c, a}, nil, &c.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired, next := splitExpired(tt.pending, now)
+
+			ids := []primitive.ObjectID{}
+			for _, e := range expired {
+				ids = append(ids, e.ID)
+			}
+			if !slices.Equal(ids, append([]primitive.ObjectID{}, tt.expired...)) {
+				t.Errorf("expired: want %v, got %v", tt.expired, ids)
+			}
+
+			switch {
+			case tt.next == nil && next != nil:
+				t.Errorf("unexpected next %s", next.ID.Hex())
+			case tt.next != nil && (next == nil || next.ID != *tt.next):
+				t.Errorf("next: want %s, got %v", tt.next.Hex(), next)
+			}
+		})
+	}
+}
+
+func TestQueuedStarting(t *testing.T) {
+	now := time.Now()
+	started := func(at time.Time) bson.D {
+		e := queued(0)
+		e.Status = queue.StatusStarted
+		e.Name = "2026-10-14T10:00:00Z"
+		e.Started = at.Unix()
+		return mockCursor(t, &e)
+	}
+	backup := func(s defs.Status) bson.D {
+		return mockCursor(t, bson.D{{"name", "2026-10-14T10:00:00Z"}, {"status", s}})
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		want      string
+		cmds      []string
+	}{
+		{"nothing started", []bson.D{mockCursor(t)}, "", []string{"find"}},
+		{"started long ago", []bson.D{started(now.Add(-3 * defs.WaitBackupStart))}, "", []string{"find"}},
+		{"no backup yet", []bson.D{started(now), mockCursor(t)}, "2026-10-14T10:00:00Z", []string{"find", "find"}},
+		{"backup starting", []bson.D{started(now), backup(defs.StatusStarting)},
+			"2026-10-14T10:00:00Z", []string{"find", "find"}},
+		{"backup running", []bson.D{started(now), backup(defs.StatusRunning)}, "", []string{"find", "find"}},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			got, err := queueAgent(mt).queuedStarting(context.Background(), now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+			if cmds := mockCommands(mt); !slices.Equal(cmds, tt.cmds) {
+				t.Errorf("commands: want %v, got %v", tt.cmds, cmds)
+			}
+		})
+	}
+}
+
+func TestStartQueued(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	updated := func(n int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{"n", n}, bson.E{"nModified", n})
+	}
+	inserted := mtest.CreateSuccessResponse(bson.E{"n", 1})
+	insertErr := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    13,
+		Name:    "Unauthorized",
+		Message: "not authorized",
+	})
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		cmds      []string
+		fail      bool
+	}{
+		{"started", []bson.D{updated(1), inserted, updated(1)}, []string{"update", "insert", "update"}, false},
+		{"not pending", []bson.D{updated(0)}, []string{"update"}, false},
+		{"send failed", []bson.D{updated(1), insertErr, updated(1)}, []string{"update", "insert", "update"}, true},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			e := queued(now.Add(time.Hour).Unix())
+			ctx := log.SetLogEventToContext(context.Background(), log.DiscardEvent)
+			err := queueAgent(mt).startQueued(ctx, &e, now)
+			if (err != nil) != tt.fail {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			evs := mt.GetAllStartedEvents()
+			if cmds := mockCommands(mt); !slices.Equal(cmds, tt.cmds) {
+				t.Fatalf("commands: want %v, got %v", tt.cmds, cmds)
+			}
+			if len(evs) < 2 {
+				return
+			}
+
+			name, _ := evs[1].Command.Lookup("documents", "0", "backup", "name").StringValueOK()
+			if name != "2026-10-14T10:00:00Z" {
+				t.Errorf("backup is sent with name %q", name)
+			}
+			status, _ := evs[2].Command.Lookup("updates", "0", "u", "$set", "status").StringValueOK()
+			if tt.fail && status != string(queue.StatusFailed) {
+				t.Errorf("expected the entry to fail, got status %q", status)
+			}
+		})
+	}
+}
+
+func TestDispatchQueue(t *testing.T) {
+	now := time.Now()
+	leader := mtest.CreateSuccessResponse(
+		bson.E{"ismaster", true},
+		bson.E{"me", "rs0:27017"},
+		bson.E{"primary", "rs0:27017"},
+		bson.E{"setName", "rs0"},
+		bson.E{"$clusterTime", bson.D{{"clusterTime", primitive.Timestamp{T: uint32(now.Unix())}}}},
+	)
+	secondary := mtest.CreateSuccessResponse(
+		bson.E{"ismaster", false},
+		bson.E{"me", "rs1:27017"},
+		bson.E{"primary", "rs0:27017"},
+		bson.E{"setName", "rs0"},
+	)
+	cmdLineOpts := mtest.CreateSuccessResponse(bson.E{"parsed", bson.D{}})
+	ok := mtest.CreateSuccessResponse(bson.E{"n", 1}, bson.E{"nModified", 1})
+	expired, next := queued(now.Add(-time.Minute).Unix()), queued(now.Add(time.Hour).Unix())
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		draining  bool
+		responses []bson.D
+		cmds      []string
+	}{
+		{"draining", true, nil, []string{}},
+		{"not leader", false, []bson.D{secondary, cmdLineOpts}, []string{"isMaster", "getCmdLineOpts"}},
+		{"all expired", false,
+			[]bson.D{leader, cmdLineOpts, mockCursor(t, &expired), mockCursor(t), ok, ok},
+			[]string{"isMaster", "getCmdLineOpts", "find", "find", "delete", "update"}},
+		{"start next", false,
+			[]bson.D{
+				leader, cmdLineOpts, mockCursor(t, &expired, &next), mockCursor(t), ok, ok,
+				// conflictingOp
+				mockCursor(t), leader, mockCursor(t), mockCursor(t), mockCursor(t),
+				// queuedStarting
+				mockCursor(t),
+				// startQueued
+				ok, ok, ok,
+			},
+			[]string{
+				"isMaster", "getCmdLineOpts", "find", "find", "delete", "update",
+				"find", "isMaster", "find", "find", "find",
+				"find",
+				"update", "insert", "update",
+			}},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			a := queueAgent(mt)
+			if tt.draining {
+				a.draining = 1
+			}
+			if err := a.dispatchQueue(context.Background(), now); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cmds := mockCommands(mt); !slices.Equal(cmds, tt.cmds) {
+				t.Errorf("commands: want %v, got %v", tt.cmds, cmds)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// mongosPingFrame is how recent the ping of the mongos has to be
+// to connect to it
+const mongosPingFrame = time.Minute
+
+// CancelRebalance cancels current rebalance
+func (a *Agent) CancelRebalance() {
+	a.rebalanceMx.Lock()
+	defer a.rebalanceMx.Unlock()
+
+	if a.rebalance == nil {
+		return
+	}
+
+	a.rebalance()
+	a.rebalance = nil
+}
+
+func (a *Agent) setRebalance(cancel context.CancelFunc) {
+	a.rebalanceMx.Lock()
+	defer a.rebalanceMx.Unlock()
+
+	a.rebalance = cancel
+}
+
+// Rebalance distributes the restored chunks over the shards
+func (a *Agent) Rebalance(ctx context.Context, d *ctrl.RebalanceCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	l := logger.NewEvent(string(ctrl.CmdRebalance), "", opid.String(), ep.TS())
+
+	if d == nil {
+		l.Error("missed command")
+		return
+	}
+
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		l.Error("get node info data: %v", err)
+		return
+	}
+	if !nodeInfo.IsLeader() {
+		l.Info("not a member of the leader rs, skipping")
+		return
+	}
+
+	epts := ep.TS()
+	lck := lock.NewLock(a.leadConn, lock.LockHeader{
+		Replset: a.brief.SetName,
+		Node:    a.brief.Me,
+		Type:    ctrl.CmdRebalance,
+		OPID:    opid.String(),
+		Epoch:   &epts,
+	})
+
+	got, err := a.acquireLock(ctx, lck, l)
+	if err != nil {
+		l.Error("acquire lock: %v", err)
+		return
+	}
+	if !got {
+		l.Debug("skip: lock not acquired")
+		return
+	}
+	defer func() {
+		if err := lck.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	// the lock is checked against the peers on the same replset only.
+	// migrations touch all shards, so operations on other replsets
+	// are not allowed either.
+	if err := a.clusterLockCheck(ctx, &lck.LockHeader); err != nil {
+		l.Error("%v", err)
+		return
+	}
+
+	hosts, err := topo.ActiveMongos(ctx, a.leadConn, mongosPingFrame)
+	if err != nil {
+		l.Error("get mongos: %v", err)
+		return
+	}
+	if len(hosts) == 0 {
+		l.Error("no active mongos found")
+		return
+	}
+	mongos, err := connect.MongosConnect(ctx, a.brief.URI, hosts, connect.AppName("pbm-agent"))
+	if err != nil {
+		l.Error("connect to mongos: %v", err)
+		return
+	}
+	defer func() {
+		if err := mongos.Disconnect(context.Background()); err != nil {
+			l.Warning("disconnect mongos: %v", err)
+		}
+	}()
+
+	bal, err := topo.GetBalancerStatus(ctx, a.leadConn)
+	if err != nil {
+		l.Warning("get balancer status: %v", err)
+	} else if bal.IsOn() {
+		l.Info("the balancer is on, it may move chunks along with the rebalance")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.setRebalance(cancel)
+	defer a.setRebalance(nil)
+
+	l.Info("rebalancing up to %d collections to %.1f%% data skew", d.Collections, d.Skew)
+	err = restore.Rebalance(ctx, a.leadConn, mongos, d, opid.String(), a.brief.Me, l)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			l.Info("canceled")
+			return
+		}
+		l.Error("rebalance: %v", err)
+		return
+	}
+
+	l.Info("done")
+}
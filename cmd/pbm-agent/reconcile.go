@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/resync"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+const (
+	reconcileCheckPeriod = time.Minute
+
+	reconcileEvent = "reconcile"
+)
+
+// Reconciler periodically compares the backups metadata with the main
+// storage contents according to the resync config and marks the backups
+// of the changed storages as orphaned (see resync.CheckOrphaned). It runs
+// only on the cluster leader primary and never deletes storage data.
+func (a *Agent) Reconciler(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting storage reconciler")
+
+	tk := time.NewTicker(reconcileCheckPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := a.reconcile(ctx, time.Now())
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(reconcileEvent, "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) reconcile(ctx context.Context, now time.Time) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get config")
+	}
+
+	mode := cfg.Resync.ResyncMode()
+	if mode == config.ResyncOff {
+		return nil
+	}
+
+	last, err := resync.GetDrift(ctx, a.leadConn)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.Wrap(err, "get last drift")
+	}
+	if last != nil && now.Sub(time.Unix(last.Checked, 0)) < cfg.Resync.Interval() {
+		return nil
+	}
+
+	// only reading in the warn mode, so it can run along with backups
+	h := lock.LockHeader{
+		Type:    ctrl.CmdResync,
+		Replset: nodeInfo.SetName,
+		Node:    nodeInfo.Me,
+		Epoch:   util.Ref(cfg.Epoch),
+		Scope:   lock.ScopeRead,
+	}
+	if mode == config.ResyncApply {
+		h.Scope = lock.ScopeReplset
+	}
+
+	// don't race with backups and deletes, try on the next tick
+	busy, err := conflictingOp(ctx, a.leadConn, &h)
+	if err != nil {
+		return errors.Wrap(err, "check running operations")
+	}
+	if busy != nil {
+		return nil
+	}
+
+	opid := ctrl.OPID(primitive.NewObjectID())
+	h.OPID = opid.String()
+	l := log.FromContext(ctx).NewEvent(reconcileEvent, "", opid.String(), cfg.Epoch)
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	lck := lock.NewLock(a.leadConn, h)
+	got, err := a.acquireLock(ctx, lck, l)
+	if err != nil {
+		return errors.Wrap(err, "acquire lock")
+	}
+	if !got {
+		l.Debug("lock not acquired")
+		return nil
+	}
+	defer func() {
+		if err := lck.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	a.jobStarted()
+	defer a.jobDone()
+
+	d := &resync.Drift{
+		Mode:    mode,
+		Node:    nodeInfo.Me,
+		Checked: now.Unix(),
+	}
+	err = a.checkDrift(ctx, cfg, d, now)
+	if err != nil {
+		d.Err = err.Error()
+		l.Error("check drift: %v", err)
+	}
+
+	_, _, err = resync.CheckOrphaned(ctx, a.leadConn, a.brief.Me, now)
+	if err != nil {
+		l.Error("check orphaned backups: %v", err)
+	}
+
+	err = resync.SaveDrift(ctx, a.leadConn, d)
+	return errors.Wrap(err, "save drift")
+}
+
+func (a *Agent) checkDrift(ctx context.Context, cfg *config.Config, d *resync.Drift, now time.Time) error {
+	l := log.LogEventFromContext(ctx)
+
+	stg, err := util.StorageFromConfig(&cfg.Storage, a.brief.Me, l)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	d.Added, d.Missing, err = resync.DetectDrift(ctx, a.leadConn, stg, now)
+	if err != nil {
+		return err
+	}
+	if !d.Detected() {
+		l.Debug("no metadata drift")
+		return nil
+	}
+
+	l.Warning("metadata drift detected: %d backup(s) only on the storage %v, %d backup(s) missing on the storage %v",
+		len(d.Added), d.Added, len(d.Missing), d.Missing)
+
+	if d.Mode == config.ResyncApply {
+		resync.ApplyDrift(ctx, a.leadConn, stg, &cfg.Storage, d)
+	}
+
+	return nil
+}
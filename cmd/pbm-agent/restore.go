@@ -13,8 +13,10 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
 )
 
 const (
@@ -31,12 +33,13 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 
 	l := logger.NewEvent(string(ctrl.CmdRestore), r.Name, opid.String(), ep.TS())
 	ctx = log.SetLogEventToContext(ctx, l)
+	start := time.Now()
 
 	if !r.OplogTS.IsZero() {
 		l.Info("to time: %s", time.Unix(int64(r.OplogTS.T), 0).UTC().Format(time.RFC3339))
 	}
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info: %v", err)
 		return
@@ -45,6 +48,15 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 		l.Debug("arbiter node. skip")
 		return
 	}
+	// the nodes of the replset lead the restore of the single replset
+	isLeader := nodeInfo.IsLeader()
+	if r.Replset != "" {
+		if nodeInfo.SetName != r.Replset {
+			l.Debug("replset %s isn't in the restore. skip", nodeInfo.SetName)
+			return
+		}
+		isLeader = true
+	}
 
 	var lck *lock.Lock
 	if nodeInfo.IsPrimary {
@@ -78,13 +90,16 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 			}
 		}()
 
-		err = config.SetConfigVar(ctx, a.leadConn, "pitr.enabled", "false")
-		if err != nil {
-			l.Error("disable oplog slicer: %v", err)
-		} else {
-			l.Info("oplog slicer disabled")
+		// the users and roles only restore doesn't touch the data
+		if !r.UsersAndRolesOnly {
+			err = a.setConfigVar(ctx, "pitr.enabled", "false", "restore", l)
+			if err != nil {
+				l.Error("disable oplog slicer: %v", err)
+			} else {
+				l.Info("oplog slicer disabled")
+			}
+			a.removePitr()
 		}
-		a.removePitr()
 	}
 
 	var bcpType defs.BackupType
@@ -103,6 +118,9 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 			if err1 != nil {
 				l.Error("failed to save meta: %v", err1)
 			}
+			if nodeInfo.IsPrimary && isLeader {
+				a.notify(ctx, restorePayload(r, opid, nil, start, errors.Wrap(err, "define base backup")))
+			}
 			return
 		}
 
@@ -116,15 +134,98 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 			}
 			return
 		}
+		// the same check as the client's, the chunks may be gone since
+		if !r.OplogTS.IsZero() && !r.External {
+			if err := restore.CheckPITRTime(ctx, a.leadConn, r.OplogTS, bcp.Name); err != nil {
+				err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName, "%v", err)
+				if err1 != nil {
+					l.Error("failed to save meta: %v", err1)
+				}
+				if nodeInfo.IsPrimary && isLeader {
+					a.notify(ctx, restorePayload(r, opid, nil, start, err))
+				}
+				return
+			}
+		}
+		if r.UsersAndRolesOnly && bcp.Type != defs.LogicalBackup {
+			err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName,
+				"users and roles only restore is supported from logical backups only")
+			if err1 != nil {
+				l.Error("failed to save meta: %v", err1)
+			}
+			return
+		}
+		if r.NoDrop && bcp.Type != defs.LogicalBackup {
+			err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName,
+				"restore without drop is supported from logical backups only")
+			if err1 != nil {
+				l.Error("failed to save meta: %v", err1)
+			}
+			return
+		}
+		if r.SkipCollOptions && bcp.Type != defs.LogicalBackup {
+			err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName,
+				"skipping collection options is supported from logical backups only")
+			if err1 != nil {
+				l.Error("failed to save meta: %v", err1)
+			}
+			return
+		}
+		if r.KeepProfiling && bcp.Type != defs.LogicalBackup {
+			err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName,
+				"keeping profiling levels is supported from logical backups only")
+			if err1 != nil {
+				l.Error("failed to save meta: %v", err1)
+			}
+			return
+		}
 		bcpType = bcp.Type
 		r.BackupName = bcp.Name
 	}
 
+	ops := []topo.PrivilegeOp{topo.PrivilegeOpAgent}
+	if bcpType == defs.LogicalBackup {
+		ops = append(ops, topo.PrivilegeOpRestore)
+		if !r.OplogTS.IsZero() {
+			ops = append(ops, topo.PrivilegeOpReplay)
+		}
+	}
+	if err := a.checkPrivileges(ctx, ops...); err != nil {
+		err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName, err.Error())
+		if err1 != nil {
+			l.Error("failed to save meta: %v", err1)
+		}
+		if nodeInfo.IsPrimary && isLeader {
+			a.notify(ctx, restorePayload(r, opid, nil, start, err))
+		}
+		return
+	}
+
 	cfg, err := config.GetConfig(ctx, a.leadConn)
 	if err != nil {
 		l.Error("get PBM configuration: %v", err)
 		return
 	}
+	setMemoryBudget(cfg)
+	setDecompression(cfg)
+
+	// the primaries coordinate the restore of their replsets
+	if nodeInfo.IsPrimary {
+		rs := nodeInfo.SetName
+		if isLeader {
+			rs = r.Replset
+		}
+		if err := a.checkClockSkew(ctx, cfg, rs); err != nil {
+			err1 := addRestoreMetaWithError(ctx, a.leadConn, l, opid, r, nodeInfo.SetName, "%v", err)
+			if err1 != nil {
+				l.Error("failed to save meta: %v", err1)
+			}
+			if isLeader {
+				a.notify(ctx, restorePayload(r, opid, nil, start, err))
+			}
+			return
+		}
+	}
 
 	l.Info("recovery started")
 
@@ -138,12 +239,22 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 		numParallelColls := getNumParallelCollsConfig(r.NumParallelColls, cfg.Restore)
 		numInsertionWorkersPerCol := getNumInsertionWorkersConfig(r.NumInsertionWorkers, cfg.Restore)
 
-		rr := restore.New(a.leadConn, a.nodeConn, a.brief, cfg, r.RSMap, numParallelColls, numInsertionWorkersPerCol)
-		if r.OplogTS.IsZero() {
-			err = rr.Snapshot(ctx, r, opid, bcp)
-		} else {
-			err = rr.PITR(ctx, r, opid, bcp)
+		rctx, cancel := context.WithCancelCause(ctx)
+		go a.cancelOnAbort(rctx, r.Name, cancel)
+
+		rr := restore.New(a.leadConn, a.nodeClient(), a.brief, cfg, r.RSMap, numParallelColls, numInsertionWorkersPerCol)
+		switch {
+		case r.UsersAndRolesOnly:
+			err = rr.UsersAndRoles(rctx, r, opid, bcp)
+		case r.OplogTS.IsZero():
+			err = rr.Snapshot(rctx, r, opid, bcp)
+		default:
+			err = rr.PITR(rctx, r, opid, bcp)
+		}
+		if err != nil && context.Cause(rctx) != nil && ctx.Err() == nil {
+			err = context.Cause(rctx)
 		}
+		cancel(nil)
 	case defs.PhysicalBackup, defs.IncrementalBackup, defs.ExternalBackup:
 		if lck != nil {
 			// Don't care about errors. Anyway, the lock gonna disappear after the
@@ -155,7 +266,7 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 		}
 
 		var rstr *restore.PhysRestore
-		rstr, err = restore.NewPhysical(ctx, a.leadConn, a.nodeConn, nodeInfo, r.RSMap)
+		rstr, err = restore.NewPhysical(ctx, a.leadConn, a.nodeClient(), nodeInfo, r.RSMap)
 		if err != nil {
 			l.Error("init physical backup: %v", err)
 			return
@@ -163,16 +274,25 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 
 		err = rstr.Snapshot(ctx, r, r.OplogTS, opid, l, a.closeCMD, a.HbPause)
 	}
+	if errors.Is(err, restore.ErrNoDataForShard) {
+		l.Info("no data for the shard in backup, skipping")
+		return
+	}
+	// one node of the leader replset reports the cluster restore. The config
+	// is read before the physical restore shuts mongod down. The delivery is
+	// awaited as the agent exits after the physical restore.
+	if nodeInfo.IsPrimary && isLeader {
+		a.notifyWait(ctx, cfg, restorePayload(r, opid, bcp, start, err))
+	}
+	if bcpType == defs.LogicalBackup && nodeInfo.IsPrimary && isLeader {
+		a.deleteOldRestores(ctx, cfg, l)
+	}
 	if err != nil {
-		if errors.Is(err, restore.ErrNoDataForShard) {
-			l.Info("no data for the shard in backup, skipping")
-		} else {
-			l.Error("restore: %v", err)
-		}
+		l.Error("restore: %v", err)
 		return
 	}
 
-	if bcpType == defs.LogicalBackup && nodeInfo.IsLeader() {
+	if bcpType == defs.LogicalBackup && isLeader && !r.UsersAndRolesOnly {
 		epch, err := config.ResetEpoch(ctx, a.leadConn)
 		if err != nil {
 			l.Error("reset epoch: %v", err)
@@ -183,6 +303,24 @@ func (a *Agent) Restore(ctx context.Context, r *ctrl.RestoreCmd, opid ctrl.OPID,
 	l.Info("recovery successfully finished")
 }
 
+// deleteOldRestores deletes the restore records beyond restore.keepLast.
+// Failures are only logged.
+func (a *Agent) deleteOldRestores(ctx context.Context, cfg *config.Config, l log.LogEvent) {
+	if cfg.Restore == nil || cfg.Restore.KeepLast <= 0 {
+		return
+	}
+
+	stg, err := util.StorageFromConfig(&cfg.Storage, a.brief.Me, l)
+	if err != nil {
+		l.Warning("delete old restores: get storage: %v", err)
+		return
+	}
+	err = restore.DeleteOldRestores(ctx, a.leadConn, stg, cfg.Restore.KeepLast)
+	if err != nil {
+		l.Warning("delete old restores: %v", err)
+	}
+}
+
 func getNumParallelCollsConfig(rParallelColls *int32, restoreConf *config.RestoreConf) int {
 	numParallelColls := max(runtime.NumCPU()/2, 1)
 	if rParallelColls != nil && *rParallelColls > 0 {
@@ -245,3 +383,35 @@ func addRestoreMetaWithError(
 
 	return nil
 }
+
+func restorePayload(
+	cmd *ctrl.RestoreCmd,
+	opid ctrl.OPID,
+	bcp *backup.BackupMeta,
+	start time.Time,
+	err error,
+) *notify.Payload {
+	end := time.Now()
+	p := &notify.Payload{
+		Event:       notify.RestoreFinished,
+		Name:        cmd.Name,
+		OPID:        opid.String(),
+		Backup:      cmd.BackupName,
+		Start:       start.Unix(),
+		End:         end.Unix(),
+		DurationSec: int64(end.Sub(start).Seconds()),
+	}
+	if bcp != nil {
+		p.Type = string(bcp.Type)
+		p.Size = bcp.Size
+	}
+	if !cmd.OplogTS.IsZero() {
+		p.Message = "point-in-time " + time.Unix(int64(cmd.OplogTS.T), 0).UTC().Format(time.RFC3339)
+	}
+	if err != nil {
+		p.Event = notify.RestoreFailed
+		p.Error = err.Error()
+	}
+
+	return p
+}
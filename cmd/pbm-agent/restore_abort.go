@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	restoreWatchdogPeriod = time.Minute
+	// restoreAbortCheckPeriod is how often the running logical restore
+	// checks if it's aborted
+	restoreAbortCheckPeriod = 5 * time.Second
+
+	restoreWatchdogEvent = "restoreWatchdog"
+)
+
+// CancelRestore cleans up the node after the aborted restore.
+// Only replsets primaries do it as the temporary collections
+// are replicated.
+func (a *Agent) CancelRestore(ctx context.Context, d *ctrl.CancelRestoreCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	l := logger.NewEvent(string(ctrl.CmdCancelRestore), "", opid.String(), ep.TS())
+
+	if d == nil {
+		l.Error("missed command")
+		return
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		l.Error("get node info data: %v", err)
+		return
+	}
+	if !nodeInfo.IsPrimary {
+		l.Debug("not a primary, skipping")
+		return
+	}
+
+	c, err := restore.CleanupAborted(ctx, a.leadConn, a.nodeClient(), nodeInfo, d.Restore)
+	if err != nil {
+		l.Error("clean up restore %s: %v", d.Restore, err)
+		return
+	}
+	if c.Error != "" {
+		l.Warning("clean up restore %s: %s", d.Restore, c.Error)
+	}
+	l.Info("restore %s is cleaned up. dropped: %v, balancer started: %v",
+		d.Restore, c.Dropped, c.BalancerRestarted)
+}
+
+// RestoreWatchdog periodically aborts the logical restore which agents
+// stopped sending heartbeats (on the cluster leader primary) and cleans up
+// after the aborted restore on primaries which missed the cleanup command
+// (e.g. the agent was down).
+func (a *Agent) RestoreWatchdog(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting restore watchdog")
+
+	tk := time.NewTicker(restoreWatchdogPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := a.watchRestore(ctx)
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(restoreWatchdogEvent, "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) watchRestore(ctx context.Context) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsPrimary {
+		return nil
+	}
+
+	meta, err := restore.GetLastRestore(ctx, a.leadConn)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil
+		}
+		return errors.Wrap(err, "get last restore")
+	}
+	if meta.Type != defs.LogicalBackup {
+		return nil
+	}
+
+	switch {
+	case meta.Status == defs.StatusAborted && !isCleanedUp(meta, nodeInfo.SetName):
+		_, err = restore.CleanupAborted(ctx, a.leadConn, a.nodeClient(), nodeInfo, meta.Name)
+		return errors.Wrapf(err, "clean up restore %s", meta.Name)
+	case meta.Status.IsRunning() && nodeInfo.IsClusterLeader():
+		return a.abortLostRestore(ctx, meta)
+	}
+
+	return nil
+}
+
+// isCleanedUp returns true if the replset has been cleaned up
+// after the aborted restore
+func isCleanedUp(meta *restore.RestoreMeta, rs string) bool {
+	if meta.Abort == nil {
+		return false
+	}
+	for _, c := range meta.Abort.Cleanup {
+		if c.RS == rs && c.Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Agent) abortLostRestore(ctx context.Context, meta *restore.RestoreMeta) error {
+	alive, err := restore.IsAlive(ctx, a.leadConn, meta)
+	if err != nil || alive {
+		return err
+	}
+
+	ep, _ := config.GetEpoch(ctx, a.leadConn)
+	l := log.FromContext(ctx).NewEvent(restoreWatchdogEvent, "", meta.OPID, ep.TS())
+	reason := fmt.Sprintf("restore agents are lost, last beat ts: %d", meta.Hb.T)
+	info, err := restore.Abort(ctx, a.leadConn, meta.Name, reason, true)
+	if err != nil {
+		if errors.Is(err, restore.ErrNotAbortable) {
+			return nil
+		}
+		return errors.Wrapf(err, "abort restore %s", meta.Name)
+	}
+	l.Warning("restore %s is aborted: %s", meta.Name, info)
+
+	_, err = ctrl.SendCancelRestore(ctx, a.leadConn, ctrl.CancelRestoreCmd{Restore: meta.Name})
+	return errors.Wrap(err, "send cleanup command")
+}
+
+// cancelOnAbort cancels the running logical restore once its state
+// is aborted (e.g. by `pbm cancel-restore --force`), so the agent stops
+// restoring right away instead of at the next state change of the restore.
+// It returns when ctx is done.
+func (a *Agent) cancelOnAbort(ctx context.Context, name string, cancel context.CancelCauseFunc) {
+	tk := time.NewTicker(restoreAbortCheckPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		meta, err := restore.GetRestoreMeta(ctx, a.leadConn, name)
+		if err != nil {
+			// the meta may not be created yet
+			continue
+		}
+		if meta.Status == defs.StatusAborted {
+			cancel(errors.Errorf("restore aborted: %s", meta.Error))
+			return
+		}
+	}
+}
@@ -29,7 +29,7 @@ func (a *Agent) Resync(ctx context.Context, cmd *ctrl.ResyncCmd, opid ctrl.OPID,
 	a.HbResume()
 	logger.ResumeMgo()
 
-	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeConn)
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
 	if err != nil {
 		l.Error("get node info data: %v", err)
 		return
@@ -40,13 +40,19 @@ func (a *Agent) Resync(ctx context.Context, cmd *ctrl.ResyncCmd, opid ctrl.OPID,
 		return
 	}
 
-	lock := lock.NewLock(a.leadConn, lock.LockHeader{
+	h := lock.LockHeader{
 		Type:    ctrl.CmdResync,
 		Replset: nodeInfo.SetName,
 		Node:    nodeInfo.Me,
 		OPID:    opid.String(),
 		Epoch:   util.Ref(ep.TS()),
-	})
+	}
+	if cmd.Name != "" && !cmd.All {
+		// touches only the profile's backups metadata
+		h.Scope = lock.ScopeReplset
+		h.Storage = cmd.Name
+	}
+	lock := lock.NewLock(a.leadConn, h)
 
 	got, err := a.acquireLock(ctx, lock, l)
 	if err != nil {
@@ -69,9 +75,9 @@ func (a *Agent) Resync(ctx context.Context, cmd *ctrl.ResyncCmd, opid ctrl.OPID,
 	if cmd.All {
 		err = a.handleSyncAllProfiles(ctx, cmd.Clear)
 	} else if cmd.Name != "" {
-		err = a.handleSyncProfile(ctx, cmd.Name, cmd.Clear)
+		err = a.handleSyncProfile(ctx, cmd.Name, cmd.Clear, cmd.ForceClaim)
 	} else {
-		err = a.handleSyncMainStorage(ctx)
+		err = a.handleSyncMainStorage(ctx, cmd.ForceClaim)
 	}
 	if err != nil {
 		l.Error(err.Error())
@@ -97,7 +103,7 @@ func (a *Agent) handleSyncAllProfiles(ctx context.Context, clearProfile bool) er
 	} else {
 		for i := range profiles {
 			eg.Go(func() error {
-				return a.helpSyncProfileBackups(ctx, &profiles[i])
+				return a.helpSyncProfileBackups(ctx, &profiles[i], false)
 			})
 		}
 	}
@@ -105,7 +111,7 @@ func (a *Agent) handleSyncAllProfiles(ctx context.Context, clearProfile bool) er
 	return eg.Wait()
 }
 
-func (a *Agent) handleSyncProfile(ctx context.Context, name string, clearProfile bool) error {
+func (a *Agent) handleSyncProfile(ctx context.Context, name string, clearProfile, forceClaim bool) error {
 	profile, err := config.GetProfile(ctx, a.leadConn, name)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -118,7 +124,7 @@ func (a *Agent) handleSyncProfile(ctx context.Context, name string, clearProfile
 	if clearProfile {
 		err = a.helpClearProfileBackups(ctx, profile.Name)
 	} else {
-		err = a.helpSyncProfileBackups(ctx, profile)
+		err = a.helpSyncProfileBackups(ctx, profile, forceClaim)
 	}
 
 	return err
@@ -129,18 +135,27 @@ func (a *Agent) helpClearProfileBackups(ctx context.Context, profileName string)
 	return errors.Wrapf(err, "clear backup list for %q", profileName)
 }
 
-func (a *Agent) helpSyncProfileBackups(ctx context.Context, profile *config.Config) error {
-	err := resync.SyncBackupList(ctx, a.leadConn, &profile.Storage, profile.Name, a.brief.Me)
+func (a *Agent) helpSyncProfileBackups(ctx context.Context, profile *config.Config, forceClaim bool) error {
+	stg, err := util.StorageFromConfig(&profile.Storage, a.brief.Me, log.LogEventFromContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "get storage for %q", profile.Name)
+	}
+	err = resync.CheckStorageOwner(ctx, a.leadConn, stg, forceClaim)
+	if err != nil {
+		return errors.Wrapf(err, "profile %q", profile.Name)
+	}
+
+	err = resync.SyncBackupList(ctx, a.leadConn, &profile.Storage, profile.Name, a.brief.Me)
 	return errors.Wrapf(err, "sync backup list for %q", profile.Name)
 }
 
-func (a *Agent) handleSyncMainStorage(ctx context.Context) error {
+func (a *Agent) handleSyncMainStorage(ctx context.Context, forceClaim bool) error {
 	cfg, err := config.GetConfig(ctx, a.leadConn)
 	if err != nil {
 		return errors.Wrap(err, "get config")
 	}
 
-	err = resync.Resync(ctx, a.leadConn, &cfg.Storage, a.brief.Me)
+	err = resync.Resync(ctx, a.leadConn, &cfg.Storage, a.brief.Me, forceClaim)
 	if err != nil {
 		return errors.Wrap(err, "resync")
 	}
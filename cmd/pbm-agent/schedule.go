@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/schedule"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+const (
+	scheduleCheckPeriod = 30 * time.Second
+	// scheduleGrace is how late a slot still can be started.
+	// The retry policy extends it by the catch-up window.
+	scheduleGrace = 2 * time.Minute
+
+	scheduleEvent = "schedule"
+)
+
+// Scheduler starts scheduled backups. Schedules are evaluated only by the
+// cluster leader primary. The config is read on every check, so schedule
+// changes take effect without agents restart.
+func (a *Agent) Scheduler(ctx context.Context) {
+	l := log.FromContext(ctx)
+	l.Printf("starting backup scheduler")
+
+	tk := time.NewTicker(scheduleCheckPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		err := a.schedule(ctx, time.Now().UTC())
+		if err != nil {
+			ep, _ := config.GetEpoch(ctx, a.leadConn)
+			l.Error(scheduleEvent, "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) schedule(ctx context.Context, now time.Time) error {
+	if a.isDraining() {
+		return nil
+	}
+
+	nodeInfo, err := topo.GetNodeInfoExt(ctx, a.nodeClient())
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get config")
+	}
+
+	l := log.FromContext(ctx).NewEvent(scheduleEvent, "", "", cfg.Epoch)
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	err = updateScheduledRuns(ctx, a.leadConn, now)
+	if err != nil {
+		l.Warning("update runs status: %v", err)
+	}
+
+	if len(cfg.Schedule) == 0 {
+		return nil
+	}
+
+	busy, err := conflictingOp(ctx, a.leadConn, &lock.LockHeader{Type: ctrl.CmdBackup})
+	if err != nil {
+		return errors.Wrap(err, "check running operations")
+	}
+
+	for _, name := range cfg.ScheduleNames() {
+		s := cfg.Schedule[name]
+		if s.Disabled {
+			continue
+		}
+
+		started, err := a.runSchedule(ctx, cfg, name, s, now, busy)
+		if err != nil {
+			l.Error("%s: %v", name, err)
+			continue
+		}
+		if started != nil {
+			busy = started
+		}
+	}
+
+	return nil
+}
+
+// runSchedule processes the most recent slot of the schedule if it hasn't
+// been yet. The slot is claimed by the unique run record, so it can't be
+// fired twice. It returns the lock header of the started backup, if any.
+func (a *Agent) runSchedule(
+	ctx context.Context,
+	cfg *config.Config,
+	name string,
+	s *config.ScheduleConf,
+	now time.Time,
+	busy *lock.LockHeader,
+) (*lock.LockHeader, error) {
+	l := log.LogEventFromContext(ctx)
+
+	c, err := schedule.ParseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := scheduleGrace + s.Window()
+	slot := c.Last(now.Add(-limit), now)
+	if slot.IsZero() {
+		return nil, nil
+	}
+
+	ok, err := schedule.RunExists(ctx, a.leadConn, name, slot)
+	if err != nil {
+		return nil, errors.Wrap(err, "check run")
+	}
+	if ok {
+		return nil, nil
+	}
+
+	run := &schedule.Run{
+		Schedule: name,
+		Slot:     slot.Unix(),
+		Node:     a.brief.Me,
+		Epoch:    cfg.Epoch,
+	}
+
+	if busy != nil {
+		// wait for the next check if the slot is still within the window
+		if s.Policy() == config.CatchUpRetry && now.Add(scheduleCheckPeriod).Before(slot.Add(limit)) {
+			l.Debug("%s: slot %s is postponed by [%s, opid: %s]",
+				name, slot.Format(time.RFC3339), busy.Type, busy.OPID)
+			return nil, nil
+		}
+
+		run.Status = schedule.RunSkipped
+		run.Reason = fmt.Sprintf("another operation is running [%s, opid: %s]", busy.Type, busy.OPID)
+		ok, err := schedule.ClaimRun(ctx, a.leadConn, run)
+		if err != nil {
+			return nil, errors.Wrap(err, "claim run")
+		}
+		if ok {
+			l.Warning("%s: slot %s is skipped: %s", name, slot.Format(time.RFC3339), run.Reason)
+		}
+		return nil, nil
+	}
+
+	cmd := ctrl.BackupCmd{
+		Type:             s.BackupType(),
+		Name:             time.Now().UTC().Format(time.RFC3339),
+		Compression:      cfg.Backup.Compression,
+		CompressionLevel: cfg.Backup.CompressionLevel,
+		Labels:           maps.Clone(s.Labels),
+		MaxDurationSec:   int64(s.MaxRunTime().Seconds()),
+	}
+	if s.Compression != "" {
+		cmd.Compression = s.Compression
+		cmd.CompressionLevel = s.CompressionLevel
+	}
+	if cmd.Labels == nil {
+		cmd.Labels = make(map[string]string)
+	}
+	cmd.Labels["schedule"] = name
+
+	run.Status = schedule.RunStarted
+	run.Backup = cmd.Name
+	ok, err = schedule.ClaimRun(ctx, a.leadConn, run)
+	if err != nil {
+		return nil, errors.Wrap(err, "claim run")
+	}
+	if !ok {
+		// another agent has got the slot
+		return nil, nil
+	}
+
+	opid, err := ctrl.SendBackup(ctx, a.leadConn, cmd)
+	if err != nil {
+		err = errors.Wrap(err, "send backup command")
+		if e := schedule.SetRunStatus(ctx, a.leadConn, run.ID, schedule.RunFailed, err.Error()); e != nil {
+			l.Warning("%s: set run status: %v", name, e)
+		}
+		return nil, err
+	}
+
+	err = schedule.SetRunBackup(ctx, a.leadConn, run.ID, cmd.Name, opid.String())
+	if err != nil {
+		l.Warning("%s: set run backup: %v", name, err)
+	}
+
+	l.Info("%s: backup %q is started for slot %s [opid: %s]",
+		name, cmd.Name, slot.Format(time.RFC3339), opid)
+
+	return &lock.LockHeader{Type: ctrl.CmdBackup, OPID: opid.String()}, nil
+}
+
+// conflictingOp returns the header of a not stale lock of a running operation
+// which is incompatible with op.
+func conflictingOp(ctx context.Context, conn connect.Client, op *lock.LockHeader) (*lock.LockHeader, error) {
+	ts, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read cluster time")
+	}
+
+	locks, err := lock.GetOperationLocks(ctx, conn, &lock.LockHeader{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get locks data")
+	}
+
+	staleSec := lock.StaleSec(ctx, conn)
+	for i := range locks {
+		l := &locks[i]
+		if l.IsStale(ts, staleSec) || lock.Compatible(op, &l.LockHeader) {
+			continue
+		}
+
+		return &l.LockHeader, nil
+	}
+
+	return nil, nil
+}
+
+// updateScheduledRuns sets the final status of started runs
+// according to their backups.
+func updateScheduledRuns(ctx context.Context, conn connect.Client, now time.Time) error {
+	runs, err := schedule.GetRuns(ctx, conn, 0, schedule.RunStarted)
+	if err != nil {
+		return errors.Wrap(err, "get runs")
+	}
+
+	for i := range runs {
+		r := &runs[i]
+		if r.Backup == "" {
+			continue
+		}
+
+		var status schedule.RunStatus
+		var reason string
+
+		bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, r.Backup)
+		switch {
+		case errors.Is(err, errors.ErrNotFound):
+			if now.Sub(time.Unix(r.Time, 0)) < defs.WaitBackupStart*2 {
+				continue
+			}
+			status, reason = schedule.RunFailed, "backup hasn't started"
+		case err != nil:
+			return errors.Wrapf(err, "get backup %q", r.Backup)
+		case bcp.Status == defs.StatusDone:
+			status = schedule.RunDone
+		case bcp.Status == defs.StatusError || bcp.Status == defs.StatusCancelled:
+			status, reason = schedule.RunFailed, string(bcp.Status)
+			if bcp.Err != "" {
+				reason += ": " + bcp.Err
+			}
+		default:
+			continue
+		}
+
+		err = schedule.SetRunStatus(ctx, conn, r.ID, status, reason)
+		if err != nil {
+			return errors.Wrapf(err, "set status of %q", r.ID)
+		}
+	}
+
+	return nil
+}
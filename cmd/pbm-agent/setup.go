@@ -11,6 +11,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/queue"
 )
 
 const (
@@ -71,6 +72,38 @@ func setupNewDB(ctx context.Context, conn connect.Client) error {
 		return errors.Wrapf(err, "ensure lock index on %s", defs.LockOpCollection)
 	}
 
+	// the config history versions are unique (see config.AddHistory).
+	// Sparse for the records made before versioning
+	_, err = conn.ConfigHistoryCollection().Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys: bson.D{{"version", 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetSparse(true),
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return errors.Wrapf(err, "ensure version index on %s", defs.ConfigHistoryCollection)
+	}
+
+	// only one pending entry is the tail of the queue (see queue.Enqueue)
+	_, err = conn.QueueCollection().Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys: bson.D{{"tail", 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{
+					{"tail", true},
+					{"status", queue.StatusPending},
+				}),
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return errors.Wrapf(err, "ensure tail index on %s", defs.QueueCollection)
+	}
+
 	err = conn.AdminCommand(
 		ctx,
 		bson.D{{"create", defs.PBMOpLogCollection}, {"capped", true}, {"size", pbmOplogCollectionSizeBytes}},
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const (
+	defaultDrainTimeout = time.Minute
+	// shutdownCleanupGrace is how long canceled jobs are given
+	// to mark their metadata and release locks
+	shutdownCleanupGrace = 15 * time.Second
+	drainPollingCycle    = 500 * time.Millisecond
+)
+
+func (a *Agent) isDraining() bool {
+	return atomic.LoadInt32(&a.draining) == 1
+}
+
+func (a *Agent) jobStarted() {
+	atomic.AddInt32(&a.jobs, 1)
+}
+
+func (a *Agent) jobDone() {
+	atomic.AddInt32(&a.jobs, -1)
+}
+
+// Drain prepares the agent to exit. It stops accepting new commands,
+// makes the slicer upload the oplog up to the current time and waits for
+// the running backup to finish. The backup isn't canceled: if it doesn't
+// finish within the timeout, it's reported every timeout till it's finished
+// or ctx is canceled (e.g. by the second signal).
+//
+// The caller is supposed to cancel the agent context afterwards and give jobs
+// shutdownCleanupGrace to mark their metadata and release locks.
+func (a *Agent) Drain(ctx context.Context, timeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&a.draining, 0, 1) {
+		return
+	}
+
+	l := log.FromContext(ctx).NewEvent("shutdown", "", "", primitive.Timestamp{})
+
+	l.Info("stop accepting new commands")
+	close(a.closeCMD)
+
+	if a.getPitr() != nil {
+		l.Info("stopping oplog slicing, uploading the last chunk")
+		a.removePitr()
+	}
+
+	for !a.waitJobs(ctx, timeout) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		running := fmt.Sprintf("%d jobs are", atomic.LoadInt32(&a.jobs))
+		if b := a.getBcp(); b != nil {
+			running = fmt.Sprintf("backup %q is", b.name)
+		}
+		l.Warning("drain timeout %v exceeded: %s still running, waiting. "+
+			"Send the signal again to exit now", timeout, running)
+	}
+
+	l.Info("all jobs are finished")
+}
+
+// waitJobs returns false if there are running jobs after the timeout.
+func (a *Agent) waitJobs(ctx context.Context, timeout time.Duration) bool {
+	tk := time.NewTicker(drainPollingCycle)
+	defer tk.Stop()
+
+	stop := time.NewTimer(timeout)
+	defer stop.Stop()
+
+	for atomic.LoadInt32(&a.jobs) > 0 {
+		select {
+		case <-tk.C:
+		case <-stop.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	t.Run("finished in time", func(t *testing.T) {
+		a := &Agent{closeCMD: make(chan struct{})}
+		a.jobStarted()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			a.jobDone()
+		}()
+
+		a.Drain(context.Background(), time.Minute)
+		if !a.isDraining() || atomic.LoadInt32(&a.jobs) != 0 {
+			t.Errorf("draining %v, jobs %d", a.isDraining(), a.jobs)
+		}
+		select {
+		case <-a.closeCMD:
+		default:
+			t.Error("commands listening is not stopped")
+		}
+
+		// repeated signal must not panic on closed channel
+		a.Drain(context.Background(), time.Minute)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		a := &Agent{closeCMD: make(chan struct{})}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		a.setBcp(&currentBackup{name: "bcp", cancel: cancel})
+		a.jobStarted()
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			a.jobDone()
+		}()
+
+		// the backup is left to finish after the timeout
+		a.Drain(context.Background(), 100*time.Millisecond)
+		if atomic.LoadInt32(&a.jobs) != 0 {
+			t.Error("drain is finished before the job")
+		}
+		if ctx.Err() != nil {
+			t.Errorf("backup is canceled: %v", context.Cause(ctx))
+		}
+	})
+
+	t.Run("exit now", func(t *testing.T) {
+		a := &Agent{closeCMD: make(chan struct{})}
+		a.jobStarted()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(300*time.Millisecond, cancel)
+		a.Drain(ctx, 100*time.Millisecond)
+		if atomic.LoadInt32(&a.jobs) != 1 {
+			t.Errorf("jobs %d", a.jobs)
+		}
+	})
+}
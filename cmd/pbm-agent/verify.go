@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// VerifyRestore restores the backup into a temporary mongod and checks
+// the restored data against the backup metadata. Any agent of the cluster
+// with the mongod binary and enough disk space may run it.
+func (a *Agent) VerifyRestore(ctx context.Context, d *ctrl.VerifyRestoreCmd, opid ctrl.OPID, ep config.Epoch) {
+	logger := log.FromContext(ctx)
+	if d == nil {
+		l := logger.NewEvent(string(ctrl.CmdVerifyRestore), "", opid.String(), ep.TS())
+		l.Error("missed command")
+		return
+	}
+
+	l := logger.NewEvent(string(ctrl.CmdVerifyRestore), d.Backup, opid.String(), ep.TS())
+	ctx = log.SetLogEventToContext(ctx, l)
+
+	bcp, err := backup.NewDBManager(a.leadConn).GetBackupByName(ctx, d.Backup)
+	if err != nil {
+		l.Error("get backup metadata: %v", err)
+		return
+	}
+	if err := restore.CheckVerifiable(bcp); err != nil {
+		l.Error("%v", err)
+		return
+	}
+
+	cfg, err := config.GetConfig(ctx, a.leadConn)
+	if err != nil {
+		l.Error("get config: %v", err)
+		return
+	}
+	o := restore.NewVerifyOptions(cfg.Restore, a.brief.Me, d.SampleNS)
+	if err := o.CheckNode(); err != nil {
+		l.Info("skip: %v", err)
+		return
+	}
+
+	// the lock is taken on the leader replset by any agent,
+	// so the only one of the cluster runs the verification
+	leader, err := topo.GetNodeInfo(ctx, a.leadConn.MongoClient())
+	if err != nil {
+		l.Error("get leader info: %v", err)
+		return
+	}
+	epts := ep.TS()
+	lck := lock.NewLock(a.leadConn, lock.LockHeader{
+		Replset: leader.SetName,
+		Node:    a.brief.Me,
+		Type:    ctrl.CmdVerifyRestore,
+		OPID:    opid.String(),
+		Epoch:   &epts,
+		Scope:   lock.ScopeRead,
+	})
+
+	got, err := a.acquireLock(ctx, lck, l)
+	if err != nil {
+		l.Error("acquire lock: %v", err)
+		return
+	}
+	if !got {
+		l.Debug("skip: lock not acquired")
+		return
+	}
+	defer func() {
+		if err := lck.Release(); err != nil {
+			l.Error("release lock: %v", err)
+		}
+	}()
+
+	// another agent may have done it before we've got the lock
+	bcp, err = backup.NewDBManager(a.leadConn).GetBackupByName(ctx, d.Backup)
+	if err != nil {
+		l.Error("get backup metadata: %v", err)
+		return
+	}
+	if bcp.Verification != nil && bcp.Verification.OPID == opid.String() {
+		l.Debug("skip: already verified by %s", bcp.Verification.Node)
+		return
+	}
+
+	v := &backup.RestoreVerification{
+		OPID:    opid.String(),
+		Node:    a.brief.Me,
+		Status:  defs.StatusRunning,
+		StartTS: time.Now().Unix(),
+		Sample:  d.SampleNS,
+	}
+	if err := backup.SetRestoreVerification(ctx, a.leadConn, bcp.Name, v); err != nil {
+		l.Error("set verification status: %v", err)
+		return
+	}
+
+	l.Info("verifying in %s (limits: %d MB, %v)", o.Dir, o.MaxDisk>>20, o.MaxDuration)
+	vctx, cancel := context.WithTimeoutCause(ctx, o.MaxDuration,
+		errors.Errorf("restore.verify.maxDuration %v exceeded", o.MaxDuration))
+	defer cancel()
+	v.Collections, v.Issues, err = restore.Verify(vctx, cfg, bcp, o, l)
+	v.FinishTS = time.Now().Unix()
+	if err != nil {
+		v.Status = defs.StatusError
+		v.Error = err.Error()
+		l.Error("verify: %v", err)
+	} else {
+		v.Status = defs.StatusDone
+		l.Info("verified %d collections, %d issues", v.Collections, len(v.Issues))
+	}
+
+	if err := backup.SetRestoreVerification(ctx, a.leadConn, bcp.Name, v); err != nil {
+		l.Error("set verification result: %v", err)
+	}
+}
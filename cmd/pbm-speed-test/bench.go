@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+type benchOpts struct {
+	storage    bool
+	profile    string
+	allowWrite bool
+	objectMB   []int
+	objects    int
+	parallel   int
+	sampleMB   int
+	output     string
+}
+
+// benchLevels are compression levels tested by default. nil is the default
+// level of the compression.
+var benchLevels = map[compress.CompressionType][]*int{
+	compress.CompressionTypeNone:      {nil},
+	compress.CompressionTypeGZIP:      {intRef(1), nil, intRef(9)},
+	compress.CompressionTypePGZIP:     {intRef(1), nil, intRef(9)},
+	compress.CompressionTypeSNAPPY:    {nil},
+	compress.CompressionTypeLZ4:       {nil, intRef(9)},
+	compress.CompressionTypeS2:        {nil, intRef(3), intRef(4)},
+	compress.CompressionTypeZstandard: {intRef(1), intRef(3), intRef(6), intRef(10)},
+}
+
+func intRef(i int) *int { return &i }
+
+type benchResult struct {
+	// Sample is the source of the data: the collection or "synthetic"
+	Sample      string           `json:"sample"`
+	SampleSize  int64            `json:"sample_size"`
+	Compression []compressResult `json:"compression"`
+	Storage     []storageResult  `json:"storage,omitempty"`
+}
+
+type compressResult struct {
+	Compression compress.CompressionType `json:"compression"`
+	Level       *int                     `json:"level,omitempty"`
+	MBps        float64                  `json:"mb_per_sec"`
+	// Ratio is the size of the compressed data to the original one
+	Ratio float64 `json:"ratio"`
+}
+
+type storageResult struct {
+	Op         string  `json:"op"`
+	Mode       string  `json:"mode"`
+	ObjectSize int64   `json:"object_size"`
+	Objects    int     `json:"objects"`
+	MBps       float64 `json:"mb_per_sec"`
+}
+
+func (r *benchResult) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Sample: %s, %v\n\n", r.Sample, Byte(r.SampleSize))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPRESSION\tLEVEL\tCOMPRESS MB/s\tRATIO")
+	for _, c := range r.Compression {
+		level := "default"
+		if c.Level != nil {
+			level = strconv.Itoa(*c.Level)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\n", c.Compression, level, c.MBps, c.Ratio)
+	}
+	w.Flush()
+
+	if len(r.Storage) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tMODE\tOBJECT SIZE\tOBJECTS\tMB/s")
+	for _, s := range r.Storage {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%d\t%.2f\n", s.Op, s.Mode, Byte(s.ObjectSize), s.Objects, s.MBps)
+	}
+	w.Flush()
+
+	return b.String()
+}
+
+func (r *benchResult) JSON() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	return string(b), err
+}
+
+// readSample reads up to size bytes of documents of the collection.
+// Synthetic documents are used if the collection is not set.
+func readSample(ctx context.Context, cn *mongo.Client, collection string, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if collection == "" {
+		_, err := NewRand(Byte(size)).WriteTo(&buf)
+		return buf.Bytes(), err
+	}
+
+	db, coll, ok := strings.Cut(collection, ".")
+	if !ok {
+		return nil, errors.New("namespace should be in format `database.collection`")
+	}
+
+	cur, err := cn.Database(db).Collection(coll).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "create cursor")
+	}
+	defer cur.Close(ctx)
+
+	for int64(buf.Len()) < size && cur.Next(ctx) {
+		buf.Write(cur.Current)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, errors.Wrap(err, "cursor")
+	}
+	if buf.Len() == 0 {
+		return nil, errors.Errorf("collection %s is empty", collection)
+	}
+
+	return buf.Bytes(), nil
+}
+
+type countWriter struct{ n int64 }
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// benchCompression compresses the sample with each compression and level
+func benchCompression(sample []byte, matrix map[compress.CompressionType][]*int) ([]compressResult, error) {
+	var rv []compressResult
+	for _, c := range validCompressions {
+		ct := compress.CompressionType(c)
+		for _, level := range matrix[ct] {
+			w := &countWriter{}
+			ts := time.Now()
+			cw, err := compress.Compress(w, ct, level)
+			if err != nil {
+				return nil, errors.Wrapf(err, "create %s writer", ct)
+			}
+			if _, err := cw.Write(sample); err != nil {
+				return nil, errors.Wrapf(err, "compress %s", ct)
+			}
+			if err := cw.Close(); err != nil {
+				return nil, errors.Wrapf(err, "compress %s", ct)
+			}
+
+			rv = append(rv, compressResult{
+				Compression: ct,
+				Level:       level,
+				MBps:        mbps(int64(len(sample)), time.Since(ts)),
+				Ratio:       float64(w.n) / float64(len(sample)),
+			})
+		}
+	}
+
+	return rv, nil
+}
+
+// benchStorage uploads, downloads and deletes temporary objects of each
+// size one by one and then in parallel.
+func benchStorage(stg storage.Storage, sample []byte, o benchOpts) ([]storageResult, error) {
+	prefix := path.Join(fileName, time.Now().UTC().Format("20060102150405"))
+
+	var rv []storageResult
+	for _, mb := range o.objectMB {
+		size := int64(mb) << 20
+		payload := bytes.Repeat(sample, int(size/int64(len(sample)))+1)[:size]
+
+		modes := []int{1}
+		if o.parallel > 1 {
+			modes = append(modes, o.parallel)
+		}
+		for _, parallel := range modes {
+			mode := "sequential"
+			if parallel > 1 {
+				mode = fmt.Sprintf("parallel %d", parallel)
+			}
+
+			names := make([]string, o.objects)
+			for i := range names {
+				names[i] = path.Join(prefix, fmt.Sprintf("%dmb-%d-%d", mb, parallel, i))
+			}
+
+			up, err := runParallel(names, parallel, func(name string) error {
+				return stg.Save(name, bytes.NewReader(payload), size)
+			})
+			if err == nil {
+				var down time.Duration
+				down, err = runParallel(names, parallel, func(name string) error {
+					r, err := stg.SourceReader(name)
+					if err != nil {
+						return err
+					}
+					defer r.Close()
+
+					_, err = io.Copy(io.Discard, r)
+					return err
+				})
+
+				total := size * int64(len(names))
+				rv = append(rv,
+					storageResult{"upload", mode, size, len(names), mbps(total, up)},
+					storageResult{"download", mode, size, len(names), mbps(total, down)})
+			}
+
+			for _, name := range names {
+				if derr := stg.Delete(name); derr != nil && !errors.Is(derr, storage.ErrNotExist) {
+					return nil, errors.Wrapf(derr, "delete %s", name)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return rv, nil
+}
+
+// runParallel runs fn for each name with up to parallel of them at once
+// and returns the time taken
+func runParallel(names []string, parallel int, fn func(name string) error) (time.Duration, error) {
+	eg := &errgroup.Group{}
+	eg.SetLimit(parallel)
+
+	ts := time.Now()
+	for _, name := range names {
+		eg.Go(func() error {
+			return errors.Wrap(fn(name), name)
+		})
+	}
+	err := eg.Wait()
+
+	return time.Since(ts), err
+}
+
+func mbps(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(Byte(size)/MB) / d.Seconds()
+}
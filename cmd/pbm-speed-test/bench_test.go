@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestReadSampleSynthetic(t *testing.T) {
+	sample, err := readSample(context.Background(), nil, "", 1<<20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sample) < 1<<20 {
+		t.Errorf("sample size %d is less than requested", len(sample))
+	}
+}
+
+func TestReadSampleBadNS(t *testing.T) {
+	_, err := readSample(context.Background(), nil, "nodot", 1<<20)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBenchCompression(t *testing.T) {
+	sample, err := readSample(context.Background(), nil, "", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix := map[compress.CompressionType][]*int{
+		compress.CompressionTypeNone:      {nil},
+		compress.CompressionTypeGZIP:      {intRef(1), nil},
+		compress.CompressionTypeZstandard: {intRef(3)},
+	}
+	rv, err := benchCompression(sample, matrix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rv) != 4 {
+		t.Fatalf("expected 4 results, got %d: %+v", len(rv), rv)
+	}
+
+	for _, r := range rv {
+		if _, ok := matrix[r.Compression]; !ok {
+			t.Errorf("unexpected compression %s", r.Compression)
+		}
+		switch r.Compression {
+		case compress.CompressionTypeNone:
+			if r.Ratio != 1 {
+				t.Errorf("none: expected ratio 1, got %.2f", r.Ratio)
+			}
+		default:
+			if r.Ratio <= 0 || r.Ratio >= 1 {
+				t.Errorf("%s: expected ratio in (0, 1), got %.2f", r.Compression, r.Ratio)
+			}
+		}
+	}
+}
+
+func TestBenchStorage(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []byte("sample data ")
+	rv, err := benchStorage(stg, sample, benchOpts{objectMB: []int{1, 2}, objects: 3, parallel: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct {
+		op   string
+		mode string
+		size int64
+	}{
+		{"upload", "sequential", 1 << 20},
+		{"download", "sequential", 1 << 20},
+		{"upload", "parallel 2", 1 << 20},
+		{"download", "parallel 2", 1 << 20},
+		{"upload", "sequential", 2 << 20},
+		{"download", "sequential", 2 << 20},
+		{"upload", "parallel 2", 2 << 20},
+		{"download", "parallel 2", 2 << 20},
+	}
+	if len(rv) != len(want) {
+		t.Fatalf("expected %d results, got %d: %+v", len(want), len(rv), rv)
+	}
+	for i, w := range want {
+		r := rv[i]
+		if r.Op != w.op || r.Mode != w.mode || r.ObjectSize != w.size || r.Objects != 3 {
+			t.Errorf("#%d: want %s %s %d x3, got %+v", i, w.op, w.mode, w.size, r)
+		}
+	}
+
+	assertNoObjects(t, stg)
+}
+
+type failingStorage struct {
+	storage.Storage
+	fail string
+}
+
+func (s *failingStorage) Save(name string, data io.Reader, size int64) error {
+	if strings.HasSuffix(name, s.fail) {
+		return errors.New("save failed")
+	}
+	return s.Storage.Save(name, data, size)
+}
+
+func TestBenchStorageCleanup(t *testing.T) {
+	fsStg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stg := &failingStorage{Storage: fsStg, fail: "1mb-1-2"}
+
+	_, err = benchStorage(stg, []byte("sample data "), benchOpts{objectMB: []int{1}, objects: 3, parallel: 1})
+	if err == nil || !strings.Contains(err.Error(), "save failed") {
+		t.Fatalf("expected the save error, got %v", err)
+	}
+
+	assertNoObjects(t, fsStg)
+}
+
+func assertNoObjects(t *testing.T, stg storage.Storage) {
+	t.Helper()
+
+	files, err := stg.List(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected the objects to be deleted, got %v", files)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+
+	running, peak := 0, 0
+	ch := make(chan int, len(names)*2)
+	_, err := runParallel(names, 2, func(string) error {
+		ch <- 1
+		time.Sleep(10 * time.Millisecond)
+		ch <- -1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(ch)
+	for d := range ch {
+		running += d
+		peak = max(peak, running)
+	}
+	if peak > 2 {
+		t.Errorf("expected up to 2 running at once, got %d", peak)
+	}
+
+	_, err = runParallel(names, 2, func(name string) error {
+		if name == "c" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "c: failed" {
+		t.Errorf("expected the error of c, got %v", err)
+	}
+}
+
+func TestMBps(t *testing.T) {
+	tests := []struct {
+		size int64
+		d    time.Duration
+		want float64
+	}{
+		{10 << 20, time.Second, 10},
+		{10 << 20, 2 * time.Second, 5},
+		{10 << 20, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := mbps(tt.size, tt.d); got != tt.want {
+			t.Errorf("mbps(%d, %v): want %v, got %v", tt.size, tt.d, tt.want, got)
+		}
+	}
+}
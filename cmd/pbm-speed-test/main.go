@@ -15,6 +15,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/blackhole"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
 	"github.com/percona/percona-backup-mongodb/pbm/version"
@@ -132,6 +133,55 @@ func main() {
 
 	rootCmd.AddCommand(storageCmd)
 
+	benchOptions := benchOpts{}
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run compression matrix and, optionally, the configured storage upload/download test",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if rootOptions.mURL == "" {
+				rootOptions.mURL = viper.GetString("mongodb-uri")
+			}
+
+			if err := validateEnum("compression", rootOptions.compression, validCompressions); err != nil {
+				return err
+			}
+			if err := validateEnum("out", benchOptions.output, []string{"text", "json"}); err != nil {
+				return err
+			}
+
+			return runBench(rootOptions, benchOptions)
+		},
+	}
+
+	benchCmd.Flags().BoolVar(
+		&benchOptions.storage, "storage", false,
+		"Upload, download and delete temporary objects on the storage of the PBM config",
+	)
+	benchCmd.Flags().StringVar(
+		&benchOptions.profile, "profile", "", "Use the storage of the config profile",
+	)
+	benchCmd.Flags().BoolVar(
+		&benchOptions.allowWrite, "allow-write", false,
+		"Allow writing temporary objects to the storage with PBM backups",
+	)
+	benchCmd.Flags().IntSliceVar(
+		&benchOptions.objectMB, "object-size-mb", []int{16, 128}, "Sizes of the test objects in MB",
+	)
+	benchCmd.Flags().IntVar(
+		&benchOptions.objects, "objects", 4, "Number of the test objects of each size",
+	)
+	benchCmd.Flags().IntVar(
+		&benchOptions.parallel, "parallel", 4, "Number of objects uploaded/downloaded at once in the parallel test",
+	)
+	benchCmd.Flags().IntVar(
+		&benchOptions.sampleMB, "sample-mb", 64, "Max size of the sample data read from the collection in MB",
+	)
+	benchCmd.Flags().StringVarP(
+		&benchOptions.output, "out", "o", "text", "Output format <text>/<json>",
+	)
+
+	rootCmd.AddCommand(benchCmd)
+
 	var (
 		versionShort  bool
 		versionCommit bool
@@ -170,7 +220,8 @@ func testCompression(mURL string, compression compress.CompressionType, level *i
 	var cn *mongo.Client
 
 	if collection != "" {
-		cn, err := connect.MongoConnect(ctx, mURL, connect.Direct(true))
+		var err error
+		cn, err = connect.MongoConnect(ctx, mURL, connect.Direct(true))
 		if err != nil {
 			stdlog.Fatalln("Error: connect to mongodb-node:", err)
 		}
@@ -220,6 +271,109 @@ func testStorage(mURL string, compression compress.CompressionType, level *int,
 	fmt.Println(r)
 }
 
+func runBench(ro rootOpts, o benchOpts) error {
+	ctx := context.Background()
+
+	if o.objects <= 0 || o.parallel <= 0 || o.sampleMB <= 0 {
+		return errors.New("--objects, --parallel and --sample-mb should be positive")
+	}
+	for _, mb := range o.objectMB {
+		if mb <= 0 {
+			return errors.New("--object-size-mb should be positive")
+		}
+	}
+
+	matrix := benchLevels
+	if ro.compression != "" {
+		ct := compress.CompressionType(ro.compression)
+		levels := benchLevels[ct]
+		if len(ro.compressLevelArg) != 0 {
+			levels = nil
+			for _, l := range ro.compressLevelArg {
+				levels = append(levels, intRef(l))
+			}
+		}
+		matrix = map[compress.CompressionType][]*int{ct: levels}
+	}
+
+	var cn *mongo.Client
+	if ro.sampleColF != "" {
+		var err error
+		cn, err = connect.MongoConnect(ctx, ro.mURL, connect.Direct(true))
+		if err != nil {
+			return errors.Wrap(err, "connect to mongodb-node")
+		}
+		defer cn.Disconnect(ctx) //nolint:errcheck
+	}
+
+	var stg storage.Storage
+	if o.storage {
+		client, err := connect.Connect(ctx, ro.mURL, "pbm-speed-test")
+		if err != nil {
+			return errors.Wrap(err, "connect to mongodb-pbm")
+		}
+		defer client.Disconnect(ctx) //nolint:errcheck
+
+		stg, err = util.GetProfileStorage(ctx, client, o.profile, "", log.DiscardEvent)
+		if err != nil {
+			return errors.Wrap(err, "get storage")
+		}
+
+		// the storage with the PBM init file keeps backups
+		inUse, err := storage.IsInitialized(ctx, stg)
+		if err != nil {
+			return errors.Wrap(err, "check storage")
+		}
+		if inUse && !o.allowWrite {
+			return errors.New("the storage has PBM backups. " +
+				"Set --allow-write to write temporary objects to it")
+		}
+	}
+
+	text := o.output != "json"
+	stopProgress := func() {}
+	if text {
+		fmt.Print("Test started ")
+		done := make(chan struct{})
+		go printw(done)
+		stopProgress = func() { done <- struct{}{} }
+	}
+
+	sample, err := readSample(ctx, cn, ro.sampleColF, int64(o.sampleMB)<<20)
+	if err != nil {
+		return errors.Wrap(err, "read sample")
+	}
+
+	r := &benchResult{Sample: "synthetic", SampleSize: int64(len(sample))}
+	if ro.sampleColF != "" {
+		r.Sample = ro.sampleColF
+	}
+
+	r.Compression, err = benchCompression(sample, matrix)
+	if err != nil {
+		return err
+	}
+	if stg != nil {
+		r.Storage, err = benchStorage(stg, sample, o)
+		if err != nil {
+			return errors.Wrap(err, "storage")
+		}
+	}
+
+	stopProgress()
+	if !text {
+		s, err := r.JSON()
+		if err != nil {
+			return errors.Wrap(err, "marshal result")
+		}
+		fmt.Println(s)
+		return nil
+	}
+
+	fmt.Print("\n\n", r)
+	return nil
+}
+
 func printw(done <-chan struct{}) {
 	tk := time.NewTicker(time.Second * 2)
 	defer tk.Stop()
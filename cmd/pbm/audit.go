@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+// auditConfigCmd is the operation of the config change. Config changes
+// are applied by the client, so they come from the config history
+// and not from the commands stream.
+const auditConfigCmd ctrl.Command = "config"
+
+type auditOpts struct {
+	since string
+}
+
+// auditEntry is an operation started by a client
+type auditEntry struct {
+	OPID    string       `json:"opid,omitempty"`
+	Time    int64        `json:"time"`
+	Command ctrl.Command `json:"command"`
+	// Initiator is nil for the commands sent by older clients
+	// and for config changes (see By)
+	Initiator *ctrl.Initiator `json:"initiator,omitempty"`
+	By        string          `json:"by"`
+	Params    string          `json:"params,omitempty"`
+	// Outcome is the status of the operation (e.g. done, error, running)
+	// or unknown if the operation has left no trace
+	Outcome     string `json:"outcome"`
+	Err         string `json:"error,omitempty"`
+	DurationSec int64  `json:"durationSec,omitempty"`
+}
+
+type auditOut []auditEntry
+
+func (a auditOut) String() string {
+	if len(a) == 0 {
+		return "No operations found"
+	}
+
+	s := ""
+	for i := range a {
+		e := &a[i]
+		s += fmtTS(e.Time) + " " + string(e.Command)
+		if e.OPID != "" {
+			s += " [opid: " + e.OPID + "]"
+		}
+		s += " " + e.Outcome
+		if e.DurationSec > 0 {
+			s += " in " + (time.Duration(e.DurationSec) * time.Second).String()
+		}
+		s += "\n"
+		s += "  by: " + e.By + "\n"
+		if e.Params != "" {
+			s += "  params: " + e.Params + "\n"
+		}
+		if e.Err != "" {
+			s += "  error: " + e.Err + "\n"
+		}
+	}
+
+	return s
+}
+
+func (a auditOut) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]auditEntry(a))
+}
+
+// runAudit lists operations sent since the time, the newest first. The commands
+// stream is capped, so the oldest commands may be gone.
+func runAudit(ctx context.Context, conn connect.Client, o *auditOpts) (fmt.Stringer, error) {
+	dur, err := parseDuration(o.since)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --since")
+	}
+	since := time.Now().Add(-dur).Unix()
+
+	rv := auditOut{}
+	cmds, err := listCommands(ctx, conn, since)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cmds {
+		e, err := auditCommand(ctx, conn, &cmds[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "command %s", cmds[i].OPID)
+		}
+		rv = append(rv, e)
+	}
+
+	history, err := config.GetHistory(ctx, conn, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "get config history")
+	}
+	for i := range history {
+		if history[i].Time >= since {
+			rv = append(rv, auditConfigChange(&history[i]))
+		}
+	}
+
+	sort.SliceStable(rv, func(i, j int) bool { return rv[i].Time > rv[j].Time })
+	return rv, nil
+}
+
+func listCommands(ctx context.Context, conn connect.Client, since int64) ([]ctrl.Cmd, error) {
+	cur, err := conn.CmdStreamCollection().Find(ctx,
+		bson.D{{"ts", bson.M{"$gte": since}}},
+		options.Find().SetSort(bson.D{{"ts", 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query commands")
+	}
+	defer cur.Close(ctx)
+
+	rv := []ctrl.Cmd{}
+	for cur.Next(ctx) {
+		var c ctrl.Cmd
+		if err := cur.Decode(&c); err != nil {
+			return nil, errors.Wrap(err, "decode command")
+		}
+		if id, ok := cur.Current.Lookup("_id").ObjectIDOK(); ok {
+			c.OPID = ctrl.OPID(id)
+		}
+		rv = append(rv, c)
+	}
+
+	return rv, errors.Wrap(cur.Err(), "cursor")
+}
+
+// auditCommand gets the outcome of the command from the backup or restore
+// metadata. Other operations have only the logs: the errors logged with
+// the opid and the time between the first and the last log entry.
+func auditCommand(ctx context.Context, conn connect.Client, c *ctrl.Cmd) (auditEntry, error) {
+	e := auditEntry{
+		OPID:      c.OPID.String(),
+		Time:      c.TS,
+		Command:   c.Cmd,
+		Initiator: c.Initiator,
+		By:        c.Initiator.String(),
+		Params:    auditParams(c),
+		Outcome:   "unknown",
+	}
+	if c.Initiator == nil && c.Restore != nil && c.Restore.Initiator != "" {
+		e.By = c.Restore.Initiator
+	}
+
+	switch c.Cmd {
+	case ctrl.CmdBackup:
+		bcp, err := backup.GetBackupByOPID(ctx, conn, e.OPID)
+		if err == nil {
+			e.Outcome = string(bcp.Status)
+			e.Err = bcp.Err
+			e.DurationSec = duration(bcp.StartTS, bcp.LastTransitionTS)
+			return e, nil
+		}
+		if !errors.Is(err, errors.ErrNotFound) {
+			return e, errors.Wrap(err, "get backup")
+		}
+	case ctrl.CmdRestore, ctrl.CmdReplay:
+		// physical restores keep the metadata on the storage
+		// and have no record in the database
+		rst, err := restore.GetRestoreMetaByOPID(ctx, conn, e.OPID)
+		if err == nil {
+			e.Outcome = string(rst.Status)
+			e.Err = rst.Error
+			e.DurationSec = duration(rst.StartTS, rst.LastTransitionTS)
+			return e, nil
+		}
+		if !errors.Is(err, errors.ErrNotFound) {
+			return e, errors.Wrap(err, "get restore")
+		}
+	}
+
+	first, err := log.GetFirstTSForOPID(ctx, conn, e.OPID)
+	if err != nil {
+		// no logs, the agents haven't got the command
+		return e, nil //nolint:nilerr
+	}
+	last, _ := log.GetLastTSForOPID(ctx, conn, e.OPID)
+	e.DurationSec = duration(first, last)
+
+	e.Err, err = log.CommandLastError(ctx, conn, e.OPID)
+	if err != nil {
+		return e, errors.Wrap(err, "get command error")
+	}
+	e.Outcome = "done"
+	if e.Err != "" {
+		e.Outcome = "error"
+	}
+
+	return e, nil
+}
+
+func auditConfigChange(h *config.HistoryEntry) auditEntry {
+	changes := make([]string, len(h.Changes))
+	for i, c := range h.Changes {
+		changes[i] = c.String()
+	}
+
+	params := h.Source
+	if h.RollbackOf != 0 {
+		params += fmt.Sprintf(" to v%d", h.RollbackOf)
+	}
+	if len(changes) != 0 {
+		params += ": " + strings.Join(changes, "; ")
+	}
+
+	return auditEntry{
+		Time:    h.Time,
+		Command: auditConfigCmd,
+		By:      h.User,
+		Params:  params,
+		Outcome: "done",
+	}
+}
+
+// auditParams returns the parameters of the command. Storage credentials
+// of config profiles are left out.
+func auditParams(c *ctrl.Cmd) string {
+	switch {
+	case c.Backup != nil:
+		return c.Backup.String()
+	case c.Restore != nil:
+		return c.Restore.String()
+	case c.Replay != nil:
+		return fmt.Sprintf("name: %s, %d,%d - %d,%d",
+			c.Replay.Name, c.Replay.Start.T, c.Replay.Start.I, c.Replay.End.T, c.Replay.End.I)
+	case c.Delete != nil:
+		if c.Delete.Backup != "" {
+			return "backup: " + c.Delete.Backup
+		}
+		s := "older than: " + fmtTS(c.Delete.OlderThan)
+		if c.Delete.Type != "" {
+			s += ", type: " + string(c.Delete.Type)
+		}
+		return s
+	case c.DeletePITR != nil:
+		if c.DeletePITR.OlderThan != 0 {
+			return "older than: " + fmtTS(c.DeletePITR.OlderThan)
+		}
+		return fmt.Sprintf("from: %d,%d, to: %d,%d",
+			c.DeletePITR.From.T, c.DeletePITR.From.I, c.DeletePITR.To.T, c.DeletePITR.To.I)
+	case c.Cleanup != nil:
+		if c.Cleanup.Orphaned {
+			return "orphaned"
+		}
+		return "older than: " + fmtTS(int64(c.Cleanup.OlderThan.T))
+	case c.Profile != nil:
+		if c.Cmd == ctrl.CmdRemoveConfigProfile {
+			return "name: " + c.Profile.Name
+		}
+		return fmt.Sprintf("name: %s, storage: %s %s",
+			c.Profile.Name, c.Profile.Storage.Typ(), c.Profile.Storage.Path())
+	case c.Resync != nil:
+		s := ""
+		switch {
+		case c.Resync.All:
+			s = "all profiles"
+		case c.Resync.Name != "":
+			s = "profile: " + c.Resync.Name
+		}
+		if c.Resync.ForceClaim {
+			s = strings.TrimPrefix(s+", force claim storage", ", ")
+		}
+		return s
+	case c.Migrate != nil:
+		s := fmt.Sprintf("from: %q, to: %q", c.Migrate.From, c.Migrate.To)
+		if c.Migrate.Backup != "" {
+			s += ", backup: " + c.Migrate.Backup
+		}
+		return s
+	case c.Rebalance != nil:
+		return "restore: " + c.Rebalance.Restore
+	case c.CancelRestore != nil:
+		return "restore: " + c.CancelRestore.Restore
+	}
+
+	return ""
+}
+
+func duration(start, end int64) int64 {
+	if start == 0 || end < start {
+		return 0
+	}
+	return end - start
+}
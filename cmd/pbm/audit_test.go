@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestAuditParams(t *testing.T) {
+	profile := &ctrl.Cmd{
+		Cmd: ctrl.CmdAddConfigProfile,
+		Profile: &ctrl.ProfileCmd{
+			Name: "eu",
+			Storage: config.StorageConf{
+				Type: storage.S3,
+				S3: &s3.Config{
+					Bucket:      "bcp",
+					EndpointURL: "https://s3.example.com",
+					Credentials: s3.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"},
+				},
+			},
+		},
+	}
+
+	got := auditParams(profile)
+	if got != "name: eu, storage: S3 https://s3.example.com/bcp" {
+		t.Errorf("unexpected params %q", got)
+	}
+	if strings.Contains(got, "secret") || strings.Contains(got, "key,") {
+		t.Errorf("credentials in params %q", got)
+	}
+
+	del := &ctrl.Cmd{Cmd: ctrl.CmdDeleteBackup, Delete: &ctrl.DeleteBackupCmd{Backup: "b1"}}
+	if got := auditParams(del); got != "backup: b1" {
+		t.Errorf("unexpected params %q", got)
+	}
+}
+
+func TestAuditOut(t *testing.T) {
+	out := auditOut{
+		{
+			OPID:    "65a0",
+			Time:    1704067200,
+			Command: ctrl.CmdRestore,
+			Initiator: &ctrl.Initiator{
+				User: "admin@admin", OSUser: "ops", Host: "h1", App: "pbm", Version: "2.5.0",
+			},
+			Params:      "name: r1, snapshot: b1",
+			Outcome:     "error",
+			Err:         "boom",
+			DurationSec: 90,
+		},
+		auditConfigChange(&config.HistoryEntry{
+			Time:    1704060000,
+			User:    "ops@h1",
+			Source:  "set",
+			Changes: []config.Change{{Path: "pitr.enabled", Old: "false", New: "true"}},
+		}),
+	}
+	out[0].By = out[0].Initiator.String()
+
+	want := "2024-01-01T00:00:00Z restore [opid: 65a0] error in 1m30s\n" +
+		"  by: admin@admin (ops@h1, pbm 2.5.0)\n" +
+		"  params: name: r1, snapshot: b1\n" +
+		"  error: boom\n" +
+		"2023-12-31T22:00:00Z config done\n" +
+		"  by: ops@h1\n" +
+		"  params: set: ~ pitr.enabled: false -> true\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
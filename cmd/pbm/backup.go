@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	stdlog "log"
+	"os"
+	"os/signal"
+	"path"
+	"runtime"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"golang.org/x/mod/semver"
@@ -19,6 +26,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
@@ -35,11 +43,23 @@ type backupOpts struct {
 	compressionLevel []int
 	profile          string
 	ns               string
+	replset          string
 	wait             bool
 	waitTime         time.Duration
 	externList       bool
 
+	cancelOnInterrupt bool
+
 	numParallelColls int32
+
+	clusterTime        string
+	clusterTimeTimeout time.Duration
+
+	maxDuration time.Duration
+
+	forceClaim bool
+
+	queue bool
 }
 
 type backupOut struct {
@@ -78,8 +98,40 @@ func (b externBcpOut) String() string {
 }
 
 type descBcp struct {
-	name string
-	coll bool
+	name    string
+	coll    bool
+	files   bool
+	verify  bool
+	timings bool
+}
+
+// parseClusterTimeTarget validates `--wait-for-cluster-time`. The target
+// has to be reachable from the current cluster time within the timeout.
+func parseClusterTimeTarget(ctx context.Context, conn connect.Client, b *backupOpts) (*ctrl.ClusterTimeTarget, error) {
+	if b.typ != string(defs.LogicalBackup) {
+		return nil, errors.New("--wait-for-cluster-time is only allowed for logical backup")
+	}
+	if b.clusterTimeTimeout < time.Second {
+		return nil, errors.New("--wait-for-cluster-time-timeout should be at least 1s")
+	}
+
+	ts, err := parseTS(b.clusterTime)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --wait-for-cluster-time")
+	}
+
+	now, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read cluster time")
+	}
+	timeout := int64(b.clusterTimeTimeout.Seconds())
+	if int64(ts.T) > int64(now.T)+timeout {
+		return nil, errors.Errorf("--wait-for-cluster-time %d,%d can't be reached within "+
+			"--wait-for-cluster-time-timeout %v from the cluster time %d,%d",
+			ts.T, ts.I, b.clusterTimeTimeout, now.T, now.I)
+	}
+
+	return &ctrl.ClusterTimeTarget{TS: ts, TimeoutSec: timeout}, nil
 }
 
 func runBackup(
@@ -91,22 +143,39 @@ func runBackup(
 ) (fmt.Stringer, error) {
 	numParallelColls, err := parseCLINumParallelCollsOption(b.numParallelColls)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse --num-parallel-collections option")
+		return nil, invalidArg(errors.Wrap(err, "parse --num-parallel-collections option"))
 	}
 	nss, err := parseCLINSOption(b.ns)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse --ns option")
+		return nil, invalidArg(errors.Wrap(err, "parse --ns option"))
 	}
 	if len(nss) != 0 && b.typ != string(defs.LogicalBackup) {
-		return nil, errors.New("--ns flag is only allowed for logical backup")
+		return nil, invalidArg(errors.New("--ns flag is only allowed for logical backup"))
+	}
+	if b.replset != "" {
+		if err := validateBackupReplset(ctx, conn, b, nss); err != nil {
+			return nil, err
+		}
+	}
+	if b.maxDuration != 0 && b.maxDuration < config.MinMaxDuration {
+		return nil, invalidArg(errors.Errorf("--max-duration should be at least %v", config.MinMaxDuration))
 	}
 
-	if err := topo.CheckTopoForBackup(ctx, conn, defs.BackupType(b.typ)); err != nil {
+	var clusterTime *ctrl.ClusterTimeTarget
+	if b.clusterTime != "" {
+		clusterTime, err = parseClusterTimeTarget(ctx, conn, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := topo.CheckTopoForBackup(ctx, conn, defs.BackupType(b.typ), b.replset); err != nil {
 		return nil, errors.Wrap(err, "backup pre-check")
 	}
 
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
-		return nil, err
+	busy := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdBackup, Storage: b.profile})
+	if busy != nil && !errors.As(busy, new(*concurrentOpError)) {
+		return nil, busy
 	}
 
 	cfg, err := config.GetProfiledConfig(ctx, conn, b.profile)
@@ -120,6 +189,10 @@ func runBackup(
 		return nil, errors.Wrap(err, "get config")
 	}
 
+	if b.typ == string(defs.LogicalBackup) && cfg.Backup.Quiesce.IsEnabled() {
+		fmt.Fprintln(os.Stderr, "WARNING: backup.quiesce is applicable only to physical backups. ignored")
+	}
+
 	compression := cfg.Backup.Compression
 	if b.compression != "" {
 		compression = compress.CompressionType(b.compression)
@@ -129,20 +202,47 @@ func runBackup(
 	if len(b.compressionLevel) != 0 {
 		level = &b.compressionLevel[0]
 	}
+	if compression == compress.CompressionTypeAuto {
+		if len(b.compressionLevel) != 0 {
+			return nil, errors.New("--compression-level is not applicable to the auto compression")
+		}
+		// the configured level is of another compression
+		level = nil
+	}
+
+	bcmd := ctrl.BackupCmd{
+		Type:             defs.BackupType(b.typ),
+		IncrBase:         b.base,
+		Name:             b.name,
+		Namespaces:       nss,
+		Compression:      compression,
+		CompressionLevel: level,
+		NumParallelColls: numParallelColls,
+		Filelist:         b.externList,
+		Profile:          b.profile,
+		Replset:          b.replset,
+		ClusterTime:      clusterTime,
+		MaxDurationSec:   int64(b.maxDuration.Seconds()),
+		ForceClaim:       b.forceClaim,
+	}
+
+	queued := b.queue || (cfg.Backup.Queue.IsEnabled() && b.typ != string(defs.ExternalBackup))
+	if queued {
+		if b.typ == string(defs.ExternalBackup) {
+			return nil, errors.New("--queue isn't applicable to external backups")
+		}
+		out, err := queueBackup(ctx, conn, cfg, bcmd, busy != nil)
+		if err != nil || out != nil {
+			return out, err
+		}
+	}
+	if busy != nil {
+		return nil, busy
+	}
 
 	err = sendCmd(ctx, conn, ctrl.Cmd{
-		Cmd: ctrl.CmdBackup,
-		Backup: &ctrl.BackupCmd{
-			Type:             defs.BackupType(b.typ),
-			IncrBase:         b.base,
-			Name:             b.name,
-			Namespaces:       nss,
-			Compression:      compression,
-			CompressionLevel: level,
-			NumParallelColls: numParallelColls,
-			Filelist:         b.externList,
-			Profile:          b.profile,
-		},
+		Cmd:    ctrl.CmdBackup,
+		Backup: &bcmd,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "send command")
@@ -189,23 +289,34 @@ func runBackup(
 	}
 
 	if b.wait {
+		waitCtx := ctx
 		if b.waitTime > time.Second {
 			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, b.waitTime)
+			waitCtx, cancel = context.WithTimeout(waitCtx, b.waitTime)
 			defer cancel()
 		}
+		// Ctrl-C only detaches from the backup unless --cancel-on-interrupt
+		waitCtx, stop := signal.NotifyContext(waitCtx, os.Interrupt)
+		defer stop()
 
 		if showProgress {
-			fmt.Printf("\nWaiting for '%s' backup...", b.name)
-		}
-		s, err := waitBackup(ctx, conn, b.name, defs.StatusDone, showProgress)
-		if s != nil && showProgress {
-			fmt.Printf(" %s\n", *s)
-		}
-		if errors.Is(err, context.DeadlineExceeded) {
-			err = errWaitTimeout
+			fmt.Printf("\nWaiting for '%s' backup:\n", b.name)
 		}
+		_, err := followBackup(waitCtx, conn, b.name, outf)
 		if err != nil {
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				err = errWaitTimeout
+			case errors.Is(err, context.Canceled) && ctx.Err() == nil:
+				if !b.cancelOnInterrupt {
+					return nil, errors.Errorf("interrupted. Backup '%s' is still running. "+
+						"Check `pbm status` for the progress", b.name)
+				}
+				if _, cerr := pbm.CancelBackup(ctx); cerr != nil {
+					return nil, errors.Wrap(cerr, "interrupted. send backup canceling")
+				}
+				return nil, errors.Errorf("interrupted. Backup '%s' cancellation has started", b.name)
+			}
 			return nil, err
 		}
 	}
@@ -213,11 +324,33 @@ func runBackup(
 	return backupOut{b.name, cfg.Storage.Path()}, nil
 }
 
+// validateBackupReplset checks the options of the backup of the single replset.
+func validateBackupReplset(ctx context.Context, conn connect.Client, b *backupOpts, nss []string) error {
+	if b.typ == string(defs.IncrementalBackup) {
+		return errors.New("--rs flag isn't allowed for incremental backup")
+	}
+	if len(nss) != 0 {
+		return errors.New("--rs and --ns flags can't be used together")
+	}
+
+	inf, err := topo.GetNodeInfo(ctx, conn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster info")
+	}
+	if !inf.IsSharded() {
+		return errors.New("--rs flag is only allowed for sharded cluster")
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: the backup of the single replset %q isn't consistent with "+
+		"the rest of the cluster. It can't be a base for point-in-time recovery.\n", b.replset)
+	return nil
+}
+
 func runFinishBcp(ctx context.Context, conn connect.Client, bcp string) (fmt.Stringer, error) {
 	meta, err := backup.NewDBManager(conn).GetBackupByName(ctx, bcp)
 	if err != nil {
 		if errors.Is(err, errors.ErrNotFound) {
-			return nil, errors.Errorf("backup %q not found", bcp)
+			return nil, errors.WithCode(errors.Errorf("backup %q not found", bcp), errors.CodeBackupNotFound)
 		}
 		return nil, err
 	}
@@ -229,6 +362,125 @@ func runFinishBcp(ctx context.Context, conn connect.Client, bcp string) (fmt.Str
 		backup.ChangeBackupState(conn, bcp, defs.StatusCopyDone, "")
 }
 
+// bcpProgress is the backup state reported on each change in `--wait` mode.
+type bcpProgress struct {
+	Name     string          `json:"name"`
+	Status   defs.Status     `json:"status"`
+	Replsets []bcpRSProgress `json:"replsets"`
+	Time     int64           `json:"time"`
+}
+
+type bcpRSProgress struct {
+	Name     string             `json:"name"`
+	Status   defs.Status        `json:"status"`
+	Progress *backup.RSProgress `json:"progress,omitempty"`
+}
+
+func newBcpProgress(bcp *backup.BackupMeta) *bcpProgress {
+	rv := &bcpProgress{
+		Name:     bcp.Name,
+		Status:   bcp.Status,
+		Replsets: make([]bcpRSProgress, len(bcp.Replsets)),
+		Time:     time.Now().Unix(),
+	}
+	for i, rs := range bcp.Replsets {
+		rv.Replsets[i] = bcpRSProgress{Name: rs.Name, Status: rs.Status, Progress: rs.Progress}
+	}
+	sort.Slice(rv.Replsets, func(i, j int) bool { return rv.Replsets[i].Name < rv.Replsets[j].Name })
+
+	return rv
+}
+
+func (p *bcpProgress) String() string {
+	return fmt.Sprintf("%s %s", fmtTS(p.Time), p.state())
+}
+
+// state returns the progress string without the time.
+// It is used to find out whether something changed.
+func (p *bcpProgress) state() string {
+	s := string(p.Status)
+	for _, rs := range p.Replsets {
+		s += fmt.Sprintf("\n  %s: %s", rs.Name, rs.Status)
+		if rs.Progress == nil {
+			continue
+		}
+		if pct := rs.Progress.Percent(); pct >= 0 {
+			s += fmt.Sprintf(" %.1f%% (%s/%s)", pct,
+				storage.PrettySize(rs.Progress.Done), storage.PrettySize(rs.Progress.Total))
+		} else {
+			s += fmt.Sprintf(" (%s)", storage.PrettySize(rs.Progress.Done))
+		}
+		if rs.Progress.File != "" {
+			s += fmt.Sprintf(" [%s]", rs.Progress.File)
+		}
+	}
+
+	return s
+}
+
+// followBackup waits for the backup to finish and prints each change of
+// the backup and replsets statuses and progress. JSON formats print one
+// status object per line.
+// It returns error if the backup failed, canceled or stuck (no heartbeats).
+func followBackup(
+	ctx context.Context,
+	conn connect.Client,
+	name string,
+	outf outFormat,
+) (*defs.Status, error) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	last := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backup metadata")
+		}
+
+		p := newBcpProgress(bcp)
+		if st := p.state(); st != last {
+			last = st
+			switch outf {
+			case outJSON, outJSONpretty:
+				err = enc.Encode(p)
+				if err != nil {
+					return nil, errors.Wrap(err, "encode")
+				}
+			default:
+				fmt.Println(p)
+			}
+		}
+
+		switch bcp.Status {
+		case defs.StatusDone:
+			return &bcp.Status, nil
+		case defs.StatusCancelled:
+			if bcp.WindowExceeded() {
+				return &bcp.Status, errors.Errorf("backup '%s' was canceled: %s", name, bcp.Err)
+			}
+			return &bcp.Status, errors.Errorf("backup '%s' was canceled", name)
+		case defs.StatusError:
+			return &bcp.Status, bcp.Error()
+		}
+
+		ct, err := topo.GetClusterTime(ctx, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "get cluster time")
+		}
+		if bcp.Hb.T+defs.StaleFrameSec < ct.T {
+			return &bcp.Status, errors.Errorf("backup stuck, last beat ts: %d", bcp.Hb.T)
+		}
+	}
+}
+
 func waitBackup(
 	ctx context.Context,
 	conn connect.Client,
@@ -293,7 +545,7 @@ func waitForBcpStatus(ctx context.Context, conn connect.Client, bcpName string,
 						rs += ": " + s.Error
 					}
 				}
-				return errors.New(bmeta.Error().Error() + rs)
+				return errors.WithCode(errors.New(bmeta.Error().Error()+rs), bmeta.FailureCode())
 			}
 		case <-ctx.Done():
 			if bmeta == nil {
@@ -323,16 +575,69 @@ type bcpDesc struct {
 	LastTransitionTS   int64           `json:"last_transition_ts" yaml:"-"`
 	LastWriteTime      string          `json:"last_write_time" yaml:"last_write_time"`
 	LastTransitionTime string          `json:"last_transition_time" yaml:"last_transition_time"`
-	Namespaces         []string        `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
-	MongoVersion       string          `json:"mongodb_version" yaml:"mongodb_version"`
-	FCV                string          `json:"fcv" yaml:"fcv"`
-	PBMVersion         string          `json:"pbm_version" yaml:"pbm_version"`
-	Status             defs.Status     `json:"status" yaml:"status"`
-	Size               int64           `json:"size" yaml:"-"`
-	HSize              string          `json:"size_h" yaml:"size_h"`
-	StorageName        string          `json:"storage_name,omitempty" yaml:"storage_name,omitempty"`
-	Err                *string         `json:"error,omitempty" yaml:"error,omitempty"`
-	Replsets           []bcpReplDesc   `json:"replsets" yaml:"replsets"`
+	// ConsistentAt is the cluster time the backup restores to
+	ConsistentAt    string                      `json:"consistent_at,omitempty" yaml:"consistent_at,omitempty"`
+	ClusterTime     *backup.ClusterTimeWait     `json:"cluster_time,omitempty" yaml:"-"`
+	ClusterTimeStr  *string                     `json:"-" yaml:"cluster_time,omitempty"`
+	Namespaces      []string                    `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	SingleRS        string                      `json:"single_rs,omitempty" yaml:"single_rs,omitempty"`
+	ExcludedDBs     []string                    `json:"excluded_dbs,omitempty" yaml:"excluded_dbs,omitempty"`
+	MaxDuration     string                      `json:"max_duration,omitempty" yaml:"max_duration,omitempty"`
+	Initiator       *ctrl.Initiator             `json:"initiator,omitempty" yaml:"-"`
+	InitiatorStr    *string                     `json:"-" yaml:"initiator,omitempty"`
+	Labels          map[string]string           `json:"labels,omitempty" yaml:"labels,omitempty"`
+	MongoVersion    string                      `json:"mongodb_version" yaml:"mongodb_version"`
+	FCV             string                      `json:"fcv" yaml:"fcv"`
+	PBMVersion      string                      `json:"pbm_version" yaml:"pbm_version"`
+	MinPBMVersion   string                      `json:"min_pbm_version,omitempty" yaml:"min_pbm_version,omitempty"`
+	Status          defs.Status                 `json:"status" yaml:"status"`
+	Size            int64                       `json:"size" yaml:"-"`
+	HSize           string                      `json:"size_h" yaml:"size_h"`
+	StorageName     string                      `json:"storage_name,omitempty" yaml:"storage_name,omitempty"`
+	SourceCluster   string                      `json:"source_cluster,omitempty" yaml:"source_cluster,omitempty"`
+	EncryptionKey   string                      `json:"encryption_key,omitempty" yaml:"encryption_key,omitempty"`
+	Err             *string                     `json:"error,omitempty" yaml:"error,omitempty"`
+	ErrCode         errors.Code                 `json:"error_code,omitempty" yaml:"error_code,omitempty"`
+	Consistency     *backup.ConsistencyCheck    `json:"consistency,omitempty" yaml:"-"`
+	ConsistencyStr  *string                     `json:"-" yaml:"consistency,omitempty"`
+	Warnings        []string                    `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	Verification    *backup.RestoreVerification `json:"verification,omitempty" yaml:"-"`
+	VerificationStr *string                     `json:"-" yaml:"verification,omitempty"`
+	AutoChoice      *compress.AutoChoice        `json:"auto_compression,omitempty" yaml:"-"`
+	AutoChoiceStr   *string                     `json:"-" yaml:"auto_compression,omitempty"`
+	Hold            *backup.BackupHold          `json:"hold,omitempty" yaml:"-"`
+	HoldStr         *string                     `json:"-" yaml:"hold,omitempty"`
+	Orphaned        *backup.Orphaned            `json:"orphaned,omitempty" yaml:"-"`
+	OrphanedStr     *string                     `json:"-" yaml:"orphaned,omitempty"`
+	Chain           []bcpChainLink              `json:"chain,omitempty" yaml:"chain,omitempty"`
+	MetaFile        *bcpArtifact                `json:"metadata_file,omitempty" yaml:"metadata_file,omitempty"`
+	Replsets        []bcpReplDesc               `json:"replsets" yaml:"replsets"`
+
+	// timings renders the transfers of the replsets after the yaml
+	timings bool
+}
+
+// bcpChainLink is a backup of the incremental chain
+type bcpChainLink struct {
+	Name   string      `json:"name" yaml:"name"`
+	Status defs.Status `json:"status" yaml:"status"`
+	Base   bool        `json:"base,omitempty" yaml:"base,omitempty"`
+	Err    string      `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type bcpArtifact struct {
+	Key         string                   `json:"key" yaml:"key"`
+	Kind        string                   `json:"kind" yaml:"kind"`
+	Size        int64                    `json:"size" yaml:"size"`
+	Compression compress.CompressionType `json:"compression,omitempty" yaml:"compression,omitempty"`
+	SourcePath  string                   `json:"source_path,omitempty" yaml:"source_path,omitempty"`
+	// Checksum is the SHA-256 of the file recorded by the backup
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	Missing  bool   `json:"missing,omitempty" yaml:"missing,omitempty"`
+	// Corrupted is set if the data doesn't match the checksum
+	// (see `pbm describe-backup --verify-checksums`)
+	Corrupted bool   `json:"corrupted,omitempty" yaml:"corrupted,omitempty"`
+	Err       string `json:"error,omitempty" yaml:"error,omitempty"`
 }
 
 type bcpReplDesc struct {
@@ -347,8 +652,17 @@ type bcpReplDesc struct {
 	IsConfigSvr        *bool               `json:"configsvr,omitempty" yaml:"configsvr,omitempty"`
 	IsConfigShard      *bool               `json:"configshard,omitempty" yaml:"configshard,omitempty"`
 	SecurityOpts       *topo.MongodOptsSec `json:"security,omitempty" yaml:"security,omitempty"`
+	StorageName        string              `json:"storage_name,omitempty" yaml:"storage_name,omitempty"`
+	EncryptionKey      string              `json:"encryption_key,omitempty" yaml:"encryption_key,omitempty"`
 	Error              *string             `json:"error,omitempty" yaml:"error,omitempty"`
 	Collections        []string            `json:"collections,omitempty" yaml:"collections,omitempty"`
+	QuiesceWait        string              `json:"quiesce_wait,omitempty" yaml:"quiesce_wait,omitempty"`
+	DumpSource         *backup.DumpSource  `json:"dump_source,omitempty" yaml:"-"`
+	DumpSourceStr      string              `json:"-" yaml:"dump_source,omitempty"`
+	Artifacts          []bcpArtifact       `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+
+	// Transfers are shown as the table (see bcpDesc.String())
+	Transfers []storage.TransferStats `json:"transfers,omitempty" yaml:"-"`
 }
 
 func (b *bcpDesc) String() string {
@@ -356,8 +670,48 @@ func (b *bcpDesc) String() string {
 	if err != nil {
 		stdlog.Fatal(err)
 	}
+	if !b.timings {
+		return string(data)
+	}
+
+	var s strings.Builder
+	s.Write(data)
+	for _, rs := range b.Replsets {
+		s.WriteString("\nTransfers of " + rs.Name + ":\n")
+		if len(rs.Transfers) == 0 {
+			s.WriteString("  no transfer stats\n")
+			continue
+		}
+		writeTransfers(&s, rs.Transfers)
+	}
+
+	return s.String()
+}
 
-	return string(data)
+// writeTransfers writes the table of the transfer stats
+func writeTransfers(out io.Writer, stats []storage.TransferStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tOP\tSTART\tDURATION\tSIZE\tMB/s\tRETRIES\tP50\tP99\tMAX\tERROR")
+	for _, t := range stats {
+		name := t.Name
+		if t.Files > 1 {
+			name = fmt.Sprintf("%s (%d files)", t.Name, t.Files)
+		}
+		dur := time.Duration(t.DurationMs) * time.Millisecond
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\t%.1f\t%d\t%v\t%v\t%v\t%s\n",
+			name,
+			t.Op,
+			time.Unix(t.StartTS, 0).UTC().Format(time.RFC3339),
+			dur,
+			storage.PrettySize(t.Size),
+			t.MBps,
+			t.Retries,
+			t.Latency.Quantile(0.5),
+			t.Latency.Quantile(0.99),
+			t.Latency.Max,
+			t.Err)
+	}
+	w.Flush()
 }
 
 func byteCountIEC(b int64) string {
@@ -388,8 +742,8 @@ func describeBackup(
 	}
 
 	var stg storage.Storage
-	if b.coll || bcp.Size == 0 {
-		// to read backed up collection names
+	if b.coll || b.files || bcp.Size == 0 {
+		// to read backed up collection names, list artifacts
 		// or calculate size of files for legacy backups
 		stg, err = util.StorageFromConfig(&bcp.Store.StorageConf, node, log.LogEventFromContext(ctx))
 		if err != nil {
@@ -403,10 +757,14 @@ func describeBackup(
 	}
 
 	rv := &bcpDesc{
+		timings:            b.timings,
 		Name:               bcp.Name,
 		OPID:               bcp.OPID,
 		Type:               bcp.Type,
 		Namespaces:         bcp.Namespaces,
+		SingleRS:           bcp.SingleRS,
+		ExcludedDBs:        bcp.ExcludedDBs,
+		Labels:             bcp.Labels,
 		MongoVersion:       bcp.MongoVersion,
 		FCV:                bcp.FCV,
 		PBMVersion:         bcp.PBMVersion,
@@ -419,8 +777,52 @@ func describeBackup(
 		HSize:              byteCountIEC(bcp.Size),
 		StorageName:        bcp.Store.Name,
 	}
+	rv.MinPBMVersion, _ = bcp.RequiredPBM()
+	if e := bcp.Store.Encryption; e != nil {
+		rv.EncryptionKey = e.CurrentKeyID()
+	}
+	if bcp.IsForeign() {
+		rv.SourceCluster = bcp.SourceCluster()
+	}
 	if bcp.Err != "" {
 		rv.Err = &bcp.Err
+		rv.ErrCode = bcp.FailureCode()
+	}
+	if bcp.MaxDurationSec > 0 {
+		rv.MaxDuration = bcp.MaxDuration().String()
+	}
+	if bcp.Initiator != nil {
+		rv.Initiator = bcp.Initiator
+		rv.InitiatorStr = util.Ref(bcp.Initiator.String())
+	}
+	if lw := bcp.LastWriteTS; lw.T > 1 {
+		rv.ConsistentAt = fmt.Sprintf("%s (%d,%d)",
+			time.Unix(int64(lw.T), 0).UTC().Format(time.RFC3339), lw.T, lw.I)
+	}
+	if bcp.ClusterTime != nil {
+		rv.ClusterTime = bcp.ClusterTime
+		rv.ClusterTimeStr = util.Ref(bcp.ClusterTime.String())
+	}
+	if bcp.Consistency != nil {
+		rv.Consistency = bcp.Consistency
+		rv.ConsistencyStr = util.Ref(bcp.Consistency.String())
+	}
+	rv.Warnings = bcp.Warnings
+	if bcp.Verification != nil {
+		rv.Verification = bcp.Verification
+		rv.VerificationStr = util.Ref(bcp.Verification.String())
+	}
+	if bcp.CompressionAuto != nil {
+		rv.AutoChoice = bcp.CompressionAuto
+		rv.AutoChoiceStr = util.Ref(bcp.CompressionAuto.String())
+	}
+	if bcp.Hold != nil {
+		rv.Hold = bcp.Hold
+		rv.HoldStr = util.Ref(bcp.Hold.String())
+	}
+	if bcp.Orphaned != nil {
+		rv.Orphaned = bcp.Orphaned
+		rv.OrphanedStr = util.Ref(bcp.Orphaned.String())
 	}
 
 	if bcp.Size == 0 {
@@ -454,15 +856,43 @@ func describeBackup(
 		if r.MongodOpts != nil && r.MongodOpts.Security != nil {
 			rv.Replsets[i].SecurityOpts = r.MongodOpts.Security
 		}
+		if r.QuiesceWaitMS != 0 {
+			rv.Replsets[i].QuiesceWait = (time.Duration(r.QuiesceWaitMS) * time.Millisecond).String()
+		}
+		if r.DumpSource != nil {
+			rv.Replsets[i].DumpSource = r.DumpSource
+			rv.Replsets[i].DumpSourceStr = r.DumpSource.String()
+		}
 		if bcp.Type == defs.ExternalBackup {
 			rv.Replsets[i].Files = r.Files
 		}
+		if b.timings {
+			rv.Replsets[i].Transfers = r.Transfers
+		}
+
+		rstg := stg
+		if r.Store != nil {
+			rv.Replsets[i].StorageName = r.Store.Name
+			if e := r.Store.Encryption; e != nil {
+				rv.Replsets[i].EncryptionKey = e.CurrentKeyID()
+			}
+			if stg != nil {
+				rstg, err = util.StorageFromConfig(&r.Store.StorageConf, node, log.LogEventFromContext(ctx))
+				if err != nil {
+					return nil, errors.Wrapf(err, "get storage of replset %s", r.Name)
+				}
+			}
+		}
+
+		if b.files {
+			rv.Replsets[i].Artifacts = listRSArtifacts(rstg, bcp, &r, b.verify)
+		}
 
 		if !b.coll || bcp.Type != defs.LogicalBackup {
 			continue
 		}
 
-		nss, err := backup.ReadArchiveNamespaces(stg, r.DumpName)
+		nss, err := backup.ReadArchiveNamespaces(rstg, r.DumpName)
 		if err != nil {
 			return nil, errors.Wrap(err, "read archive metadata")
 		}
@@ -475,9 +905,475 @@ func describeBackup(
 		sort.Strings(rv.Replsets[i].Collections)
 	}
 
+	if b.files {
+		rv.MetaFile = &bcpArtifact{Key: bcp.Name + defs.MetadataFileSuffix, Kind: "metadata"}
+		statArtifacts(stg, []*bcpArtifact{rv.MetaFile})
+	}
+
+	if bcp.Type == defs.IncrementalBackup {
+		rv.Chain, err = getIncrementalChain(ctx, pbm, bcp)
+		if err != nil {
+			return nil, errors.Wrap(err, "get incremental chain")
+		}
+	}
+
 	return rv, nil
 }
 
+// getIncrementalChain returns backups the incremental backup depends on
+// down to the base one.
+func getIncrementalChain(ctx context.Context, pbm *sdk.Client, bcp *backup.BackupMeta) ([]bcpChainLink, error) {
+	var chain []bcpChainLink
+	for src := bcp.SrcBackup; src != ""; {
+		b, err := pbm.GetBackupByName(ctx, src, sdk.GetBackupByNameOptions{})
+		if err != nil {
+			if !errors.Is(err, errors.ErrNotFound) {
+				return nil, errors.Wrapf(err, "get backup %s", src)
+			}
+			chain = append(chain, bcpChainLink{Name: src, Err: "not found"})
+			break
+		}
+
+		chain = append(chain, bcpChainLink{
+			Name:   b.Name,
+			Status: b.Status,
+			Base:   b.SrcBackup == "",
+			Err:    b.Err,
+		})
+		src = b.SrcBackup
+	}
+
+	return chain, nil
+}
+
+// listRSArtifacts returns storage files of the replset backup.
+// Files that are missed on the storage are marked as such. With verify,
+// the files with the recorded checksums are read and the ones not
+// matching it are marked as corrupted.
+func listRSArtifacts(stg storage.Storage, bcp *backup.BackupMeta, rs *backup.BackupReplset, verify bool) []bcpArtifact {
+	var arts []*bcpArtifact
+
+	switch bcp.Type {
+	case defs.LogicalBackup:
+		arts = append(arts, &bcpArtifact{Key: rs.DumpName, Kind: "archive_metadata"})
+		if !bcp.IsLegacyArchive() {
+			nss, err := backup.ReadArchiveNamespaces(stg, rs.DumpName)
+			if err != nil {
+				arts[0].Err = err.Error()
+			}
+			for _, ns := range nss {
+				if ns.Size == 0 {
+					continue
+				}
+				arts = append(arts, &bcpArtifact{
+					Key:         path.Join(bcp.Name, rs.Name, archive.NSify(ns.Database, ns.Collection)+bcp.Compression.Suffix()),
+					Kind:        "collection",
+					Compression: bcp.Compression,
+				})
+			}
+		}
+
+		if rs.NSStatsFile != "" {
+			arts = append(arts, &bcpArtifact{Key: rs.NSStatsFile, Kind: "ns_stats"})
+		}
+
+		if bcp.IsLegacyBackupOplog() {
+			// imported mongodump archives have no oplog
+			if rs.OplogName != "" {
+				arts = append(arts, &bcpArtifact{Key: rs.OplogName, Kind: "oplog", Compression: bcp.Compression})
+			}
+			break
+		}
+		files, err := stg.List(rs.OplogName, "")
+		if err != nil || len(files) == 0 {
+			a := &bcpArtifact{Key: rs.OplogName, Kind: "oplog", Missing: err == nil}
+			if err != nil {
+				a.Err = err.Error()
+			}
+			arts = append(arts, a)
+			break
+		}
+		for _, f := range files {
+			arts = append(arts, &bcpArtifact{
+				Key:         path.Join(rs.OplogName, f.Name),
+				Kind:        "oplog",
+				Compression: bcp.Compression,
+			})
+		}
+	case defs.PhysicalBackup, defs.IncrementalBackup:
+		filelist := backup.Filelist(rs.Files)
+		if version.HasFilelistFile(bcp.PBMVersion) {
+			fl := &bcpArtifact{Key: path.Join(bcp.Name, rs.Name, backup.FilelistName), Kind: "filelist"}
+			arts = append(arts, fl)
+
+			var err error
+			filelist, err = backup.ReadFilelistForReplset(stg, bcp.Name, rs.Name)
+			if err != nil {
+				fl.Err = err.Error()
+			}
+		}
+
+		var dbpath string
+		if rs.MongodOpts != nil {
+			dbpath = rs.MongodOpts.Storage.DBpath
+		}
+		for _, f := range filelist {
+			if f.Len < 0 {
+				continue // not changed since the previous backup
+			}
+
+			a := &bcpArtifact{
+				Key:         path.Join(bcp.Name, rs.Name, f.Path(bcp.Compression)),
+				Kind:        "data",
+				Compression: bcp.Compression,
+				SourcePath:  f.Name,
+			}
+			if dbpath != "" {
+				a.SourcePath = path.Join(dbpath, f.Name)
+			}
+			arts = append(arts, a)
+		}
+	}
+
+	if rs.ChecksumsFile != "" {
+		sa := &bcpArtifact{Key: rs.ChecksumsFile, Kind: "checksums"}
+		sums, err := backup.ReadChecksums(stg, rs)
+		if err != nil {
+			sa.Err = err.Error()
+		}
+		for _, a := range arts {
+			a.Checksum = sums[a.Key]
+		}
+		arts = append(arts, sa)
+	}
+
+	statArtifacts(stg, arts)
+	if verify {
+		verifyArtifacts(stg, arts)
+	}
+
+	rv := make([]bcpArtifact, len(arts))
+	for i, a := range arts {
+		rv[i] = *a
+	}
+	return rv
+}
+
+// statArtifacts sets artifacts size. The ones not found are marked missed.
+func statArtifacts(stg storage.Storage, arts []*bcpArtifact) {
+	eg := util.NewErrorGroup(runtime.NumCPU() * 2)
+	for _, a := range arts {
+		if a.Missing || (a.Kind == "oplog" && a.Err != "") {
+			continue
+		}
+
+		eg.Go(func() error {
+			f, err := stg.FileStat(a.Key)
+			switch {
+			case errors.Is(err, storage.ErrNotExist):
+				a.Missing = true
+				a.Err = ""
+			case err != nil:
+				a.Err = err.Error()
+			default:
+				a.Size = f.Size
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+}
+
+// verifyArtifacts reads the artifacts with the checksums and marks the
+// ones with other data as corrupted
+func verifyArtifacts(stg storage.Storage, arts []*bcpArtifact) {
+	eg := util.NewErrorGroup(runtime.NumCPU())
+	for _, a := range arts {
+		if a.Checksum == "" || a.Missing || a.Err != "" {
+			continue
+		}
+
+		eg.Go(func() error {
+			err := storage.VerifyChecksum(stg, a.Key, a.Checksum)
+			if err != nil {
+				a.Corrupted = errors.Is(err, storage.ErrChecksumMismatch)
+				a.Err = err.Error()
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+}
+
+type bcpDiff struct {
+	A         string          `json:"a"`
+	B         string          `json:"b"`
+	Fields    []bcpDiffField  `json:"fields,omitempty"`
+	RSAdded   []string        `json:"replsets_added,omitempty"`
+	RSRemoved []string        `json:"replsets_removed,omitempty"`
+	NSAdded   []bcpDiffNS     `json:"ns_added,omitempty"`
+	NSRemoved []bcpDiffNS     `json:"ns_removed,omitempty"`
+	NSChanged []bcpDiffNS     `json:"ns_changed,omitempty"`
+	Size      bcpDiffSize     `json:"size"`
+	RSSize    []bcpDiffRSSize `json:"replsets_size,omitempty"`
+	Notes     []string        `json:"notes,omitempty"`
+}
+
+type bcpDiffField struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// bcpDiffNS is the namespace stats in the backups. For added (removed)
+// namespaces A (B) values are zero.
+type bcpDiffNS struct {
+	NS    string `json:"ns"`
+	DocsA int64  `json:"docs_a"`
+	DocsB int64  `json:"docs_b"`
+	SizeA int64  `json:"size_a"`
+	SizeB int64  `json:"size_b"`
+}
+
+type bcpDiffSize struct {
+	A     int64 `json:"a"`
+	B     int64 `json:"b"`
+	Delta int64 `json:"delta"`
+}
+
+type bcpDiffRSSize struct {
+	RS string `json:"rs"`
+	bcpDiffSize
+}
+
+func (d *bcpDiff) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "Diff %s -> %s\n", d.A, d.B)
+
+	if len(d.Fields) != 0 {
+		sb.WriteString("\nOptions:\n")
+		for _, f := range d.Fields {
+			fmt.Fprintf(&sb, "  ~ %s: %s -> %s\n", f.Field, f.A, f.B)
+		}
+	}
+
+	if len(d.RSAdded)+len(d.RSRemoved) != 0 {
+		sb.WriteString("\nTopology:\n")
+		for _, rs := range d.RSAdded {
+			fmt.Fprintf(&sb, "  + %s\n", rs)
+		}
+		for _, rs := range d.RSRemoved {
+			fmt.Fprintf(&sb, "  - %s\n", rs)
+		}
+	}
+
+	if len(d.NSAdded)+len(d.NSRemoved)+len(d.NSChanged) != 0 {
+		sb.WriteString("\nNamespaces:\n")
+		for _, ns := range d.NSAdded {
+			fmt.Fprintf(&sb, "  + %s (docs: %d, size: %s)\n", ns.NS, ns.DocsB, byteCountIEC(ns.SizeB))
+		}
+		for _, ns := range d.NSRemoved {
+			fmt.Fprintf(&sb, "  - %s (docs: %d, size: %s)\n", ns.NS, ns.DocsA, byteCountIEC(ns.SizeA))
+		}
+		for _, ns := range d.NSChanged {
+			fmt.Fprintf(&sb, "  ~ %s (docs: %d -> %d [%+d], size: %s -> %s [%s])\n",
+				ns.NS, ns.DocsA, ns.DocsB, ns.DocsB-ns.DocsA,
+				byteCountIEC(ns.SizeA), byteCountIEC(ns.SizeB), sizeDelta(ns.SizeB-ns.SizeA))
+		}
+	}
+
+	fmt.Fprintf(&sb, "\nSize: %s -> %s [%s]\n",
+		byteCountIEC(d.Size.A), byteCountIEC(d.Size.B), sizeDelta(d.Size.Delta))
+	for _, rs := range d.RSSize {
+		fmt.Fprintf(&sb, "  %s: %s -> %s [%s]\n",
+			rs.RS, byteCountIEC(rs.A), byteCountIEC(rs.B), sizeDelta(rs.Delta))
+	}
+
+	for _, n := range d.Notes {
+		fmt.Fprintf(&sb, "\nNote: %s", n)
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func sizeDelta(d int64) string {
+	if d < 0 {
+		return "-" + byteCountIEC(-d)
+	}
+	return "+" + byteCountIEC(d)
+}
+
+// diffBackup compares a metadata of two backups. Only the namespaces stats
+// are read from the storage.
+func diffBackup(ctx context.Context, pbm *sdk.Client, nameA, nameB, node string) (fmt.Stringer, error) {
+	a, err := pbm.GetBackupByName(ctx, nameA, sdk.GetBackupByNameOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backup %q", nameA)
+	}
+	b, err := pbm.GetBackupByName(ctx, nameB, sdk.GetBackupByNameOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backup %q", nameB)
+	}
+	for _, bcp := range []*backup.BackupMeta{a, b} {
+		err = backup.LoadNSStats(bcp, node, log.LogEventFromContext(ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read namespaces stats of %q", bcp.Name)
+		}
+	}
+
+	return diffBackupMeta(a, b), nil
+}
+
+func diffBackupMeta(a, b *backup.BackupMeta) *bcpDiff {
+	d := &bcpDiff{
+		A:    a.Name,
+		B:    b.Name,
+		Size: bcpDiffSize{A: a.Size, B: b.Size, Delta: b.Size - a.Size},
+	}
+
+	nssOpt := func(m *backup.BackupMeta) string {
+		if !util.IsSelective(m.Namespaces) {
+			return "*.*"
+		}
+		return strings.Join(m.Namespaces, ",")
+	}
+	stgOpt := func(m *backup.BackupMeta) string {
+		if m.Store.IsProfile {
+			return string(m.Store.Type) + " (profile: " + m.Store.Name + ")"
+		}
+		return string(m.Store.Type)
+	}
+	for _, f := range []bcpDiffField{
+		{"type", string(a.Type), string(b.Type)},
+		{"compression", string(a.Compression), string(b.Compression)},
+		{"storage", stgOpt(a), stgOpt(b)},
+		{"namespaces", nssOpt(a), nssOpt(b)},
+		{"mongodb_version", a.MongoVersion, b.MongoVersion},
+		{"fcv", a.FCV, b.FCV},
+		{"pbm_version", a.PBMVersion, b.PBMVersion},
+		{"balancer", string(a.BalancerStatus), string(b.BalancerStatus)},
+	} {
+		if f.A != f.B {
+			d.Fields = append(d.Fields, f)
+		}
+	}
+
+	rsA := make(map[string]*backup.BackupReplset, len(a.Replsets))
+	for i := range a.Replsets {
+		rsA[a.Replsets[i].Name] = &a.Replsets[i]
+	}
+	rsB := make(map[string]*backup.BackupReplset, len(b.Replsets))
+	for i := range b.Replsets {
+		rsB[b.Replsets[i].Name] = &b.Replsets[i]
+	}
+	for name := range rsB {
+		if rsA[name] == nil {
+			d.RSAdded = append(d.RSAdded, name)
+		}
+	}
+	for name := range rsA {
+		if rsB[name] == nil {
+			d.RSRemoved = append(d.RSRemoved, name)
+		}
+	}
+	sort.Strings(d.RSAdded)
+	sort.Strings(d.RSRemoved)
+
+	statsA, okA := backupNSStats(a)
+	statsB, okB := backupNSStats(b)
+	if okA && okB {
+		diffNSStats(d, statsA, statsB)
+		return d
+	}
+
+	// degrade to the artifacts size
+	for _, m := range []*backup.BackupMeta{a, b} {
+		if _, ok := backupNSStats(m); !ok {
+			d.Notes = append(d.Notes, fmt.Sprintf(
+				"namespaces stats are not recorded for %q. Only artifacts size is compared", m.Name))
+		}
+	}
+	names := make([]string, 0, len(rsA))
+	for name := range rsA {
+		if rsB[name] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sizeA, sizeB := rsFilesSize(rsA[name]), rsFilesSize(rsB[name])
+		if sizeA == 0 && sizeB == 0 {
+			continue
+		}
+		d.RSSize = append(d.RSSize, bcpDiffRSSize{
+			RS:          name,
+			bcpDiffSize: bcpDiffSize{A: sizeA, B: sizeB, Delta: sizeB - sizeA},
+		})
+	}
+
+	return d
+}
+
+// backupNSStats returns the namespaces stats summed over all replsets.
+// It returns false if stats are not recorded.
+func backupNSStats(bcp *backup.BackupMeta) (map[string]backup.NSStat, bool) {
+	if bcp.Type != defs.LogicalBackup {
+		return nil, false
+	}
+
+	rv := make(map[string]backup.NSStat)
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		if rs.NSStats == nil {
+			return nil, false
+		}
+		for _, s := range rs.NSStats {
+			v := rv[s.NS]
+			v.NS = s.NS
+			v.Docs += s.Docs
+			v.Size += s.Size
+			rv[s.NS] = v
+		}
+	}
+
+	return rv, true
+}
+
+func diffNSStats(d *bcpDiff, a, b map[string]backup.NSStat) {
+	for ns, sb := range b {
+		sa, ok := a[ns]
+		switch {
+		case !ok:
+			d.NSAdded = append(d.NSAdded, bcpDiffNS{NS: ns, DocsB: sb.Docs, SizeB: sb.Size})
+		case sa.Docs != sb.Docs || sa.Size != sb.Size:
+			d.NSChanged = append(d.NSChanged, bcpDiffNS{
+				NS:    ns,
+				DocsA: sa.Docs,
+				DocsB: sb.Docs,
+				SizeA: sa.Size,
+				SizeB: sb.Size,
+			})
+		}
+	}
+	for ns, sa := range a {
+		if _, ok := b[ns]; !ok {
+			d.NSRemoved = append(d.NSRemoved, bcpDiffNS{NS: ns, DocsA: sa.Docs, SizeA: sa.Size})
+		}
+	}
+
+	for _, l := range [][]bcpDiffNS{d.NSAdded, d.NSRemoved, d.NSChanged} {
+		sort.Slice(l, func(i, j int) bool { return l[i].NS < l[j].NS })
+	}
+}
+
+func rsFilesSize(rs *backup.BackupReplset) int64 {
+	size := int64(0)
+	for _, f := range rs.Files {
+		size += f.StgSize
+	}
+	return size
+}
+
 // bcpsMatchCluster checks if given backups match shards in the cluster. Match means that
 // each replset in backup has a respective replset on the target cluster. It's ok if cluster
 // has more shards than there are currently in backup. But in the case of sharded cluster
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
 	"github.com/percona/percona-backup-mongodb/pbm/version"
@@ -570,3 +575,195 @@ func BenchmarkBcpMatchCluster1000x1000Err(b *testing.B) {
 		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
+
+func TestListRSArtifacts(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stg.Save("b1/rs1/collection-1.wt.s2", strings.NewReader("data"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	phys := &backup.BackupMeta{
+		Name:        "b1",
+		Type:        defs.PhysicalBackup,
+		PBMVersion:  "v2.3.0",
+		Compression: compress.CompressionTypeS2,
+	}
+	rs := &backup.BackupReplset{
+		Name: "rs1",
+		Files: []backup.File{
+			{Name: "collection-1.wt"},
+			{Name: "collection-2.wt"},
+			{Name: "collection-3.wt", Len: -1},
+		},
+		MongodOpts: &topo.MongodOpts{Storage: topo.MongodOptsStorage{DBpath: "/data/db"}},
+	}
+
+	got := listRSArtifacts(stg, phys, rs, false)
+	want := []bcpArtifact{
+		{
+			Key: "b1/rs1/collection-1.wt.s2", Kind: "data", Size: 4,
+			Compression: compress.CompressionTypeS2, SourcePath: "/data/db/collection-1.wt",
+		},
+		{
+			Key: "b1/rs1/collection-2.wt.s2", Kind: "data", Missing: true,
+			Compression: compress.CompressionTypeS2, SourcePath: "/data/db/collection-2.wt",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("physical:\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	logical := &backup.BackupMeta{
+		Name:        "b2",
+		Type:        defs.LogicalBackup,
+		PBMVersion:  "v2.5.0",
+		Compression: compress.CompressionTypeS2,
+	}
+	rs = &backup.BackupReplset{
+		Name:      "rs1",
+		DumpName:  "b2/rs1/metadata.json",
+		OplogName: "b2/rs1/oplog",
+	}
+
+	got = listRSArtifacts(stg, logical, rs, false)
+	if len(got) != 2 || !got[0].Missing || got[0].Err != "" || got[1].Kind != "oplog" || !got[1].Missing {
+		t.Errorf("logical with missed files: got %+v", got)
+	}
+}
+
+func TestListRSArtifactsChecksums(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"b1/rs1/collection-1.wt.s2": "data1",
+		"b1/rs1/collection-2.wt.s2": "corrupted",
+		"b1/rs1/checksums.json": `{"b1/rs1/collection-1.wt.s2":"` + sha256hex("data1") +
+			`","b1/rs1/collection-2.wt.s2":"` + sha256hex("data2") + `"}`,
+	} {
+		if err := stg.Save(name, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bcp := &backup.BackupMeta{
+		Name:        "b1",
+		Type:        defs.PhysicalBackup,
+		PBMVersion:  "v2.3.0",
+		Compression: compress.CompressionTypeS2,
+	}
+	rs := &backup.BackupReplset{
+		Name:          "rs1",
+		Files:         []backup.File{{Name: "collection-1.wt"}, {Name: "collection-2.wt"}},
+		ChecksumsFile: "b1/rs1/checksums.json",
+	}
+
+	got := listRSArtifacts(stg, bcp, rs, false)
+	if len(got) != 3 || got[0].Checksum != sha256hex("data1") ||
+		got[1].Checksum != sha256hex("data2") || got[2].Kind != "checksums" {
+		t.Fatalf("got %+v", got)
+	}
+	for _, a := range got {
+		if a.Corrupted || a.Err != "" {
+			t.Errorf("%s: without verify: got %+v", a.Key, a)
+		}
+	}
+
+	got = listRSArtifacts(stg, bcp, rs, true)
+	if got[0].Corrupted || got[0].Err != "" {
+		t.Errorf("%s: got %+v", got[0].Key, got[0])
+	}
+	if !got[1].Corrupted || got[1].Err == "" {
+		t.Errorf("%s: expected corrupted, got %+v", got[1].Key, got[1])
+	}
+}
+
+func sha256hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestBcpProgressState(t *testing.T) {
+	bcp := &backup.BackupMeta{
+		Name:   "2024-01-02T03:04:05Z",
+		Status: defs.StatusRunning,
+		Replsets: []backup.BackupReplset{
+			{Name: "rs1", Status: defs.StatusRunning, Progress: &backup.RSProgress{Done: 2048}},
+			{
+				Name:     "rs0",
+				Status:   defs.StatusRunning,
+				Progress: &backup.RSProgress{Total: 4096, Done: 1024, File: "db.c"},
+			},
+			{Name: "cfg", Status: defs.StatusDumpDone},
+		},
+	}
+
+	want := "running" +
+		"\n  cfg: dumpDone" +
+		"\n  rs0: running 25.0% (1.00KB/4.00KB) [db.c]" +
+		"\n  rs1: running (2.00KB)"
+	if got := newBcpProgress(bcp).state(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	p := &backup.RSProgress{Total: 100, Done: 150}
+	if pct := p.Percent(); pct != 100 {
+		t.Errorf("percent is not capped: %v", pct)
+	}
+}
+
+func TestDiffBackupMeta(t *testing.T) {
+	a := &backup.BackupMeta{
+		Name:        "a",
+		Type:        defs.LogicalBackup,
+		Compression: compress.CompressionTypeS2,
+		Size:        100,
+		Replsets: []backup.BackupReplset{
+			{Name: "rs0", NSStats: []backup.NSStat{{"db.a", 10, 100}, {"db.b", 1, 10}}},
+			{Name: "rs1", NSStats: []backup.NSStat{{"db.a", 5, 50}}},
+		},
+	}
+	b := &backup.BackupMeta{
+		Name:        "b",
+		Type:        defs.LogicalBackup,
+		Compression: compress.CompressionTypeZstandard,
+		Size:        200,
+		Replsets: []backup.BackupReplset{
+			{Name: "rs0", NSStats: []backup.NSStat{{"db.a", 20, 200}, {"db.c", 2, 20}}},
+		},
+	}
+
+	d := diffBackupMeta(a, b)
+	if !reflect.DeepEqual(d.Fields, []bcpDiffField{{"compression", "s2", "zstd"}}) {
+		t.Errorf("fields: %+v", d.Fields)
+	}
+	if !reflect.DeepEqual(d.RSRemoved, []string{"rs1"}) || len(d.RSAdded) != 0 {
+		t.Errorf("topology: +%v -%v", d.RSAdded, d.RSRemoved)
+	}
+	if !reflect.DeepEqual(d.NSChanged, []bcpDiffNS{{"db.a", 15, 20, 150, 200}}) {
+		t.Errorf("changed: %+v", d.NSChanged)
+	}
+	if !reflect.DeepEqual(d.NSAdded, []bcpDiffNS{{NS: "db.c", DocsB: 2, SizeB: 20}}) {
+		t.Errorf("added: %+v", d.NSAdded)
+	}
+	if !reflect.DeepEqual(d.NSRemoved, []bcpDiffNS{{NS: "db.b", DocsA: 1, SizeA: 10}}) {
+		t.Errorf("removed: %+v", d.NSRemoved)
+	}
+	if len(d.Notes) != 0 {
+		t.Errorf("notes: %v", d.Notes)
+	}
+
+	// stats are not recorded for the old backup
+	a.Replsets[1].NSStats = nil
+	d = diffBackupMeta(a, b)
+	if len(d.NSChanged)+len(d.NSAdded)+len(d.NSRemoved) != 0 || len(d.Notes) != 1 {
+		t.Errorf("expected degraded diff, got %+v", d)
+	}
+	if d.Size.Delta != 100 {
+		t.Errorf("size delta: %d", d.Size.Delta)
+	}
+}
@@ -10,18 +10,47 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
 
 var errWaitTimeout = errors.New("Operation is in progress. Check pbm status and logs")
 
+// exitCodes are the exit codes of the failures automation mostly
+// branches on. The others exit with 1. 2 is taken by `pbm status --exit-code`.
+var exitCodes = map[errors.Code]int{
+	errors.CodeInvalidArgument:        3,
+	errors.CodeBackupNotFound:         4,
+	errors.CodeRestoreNotFound:        5,
+	errors.CodeLockHeld:               6,
+	errors.CodeStorageUnreachable:     7,
+	errors.CodeInsufficientPrivileges: 8,
+}
+
+func exitCode(err error) int {
+	if c, ok := exitCodes[errors.CodeOf(err)]; ok {
+		return c
+	}
+	return 1
+}
+
+// invalidArg marks the error of the command arguments
+func invalidArg(err error) error {
+	return errors.WithCode(err, errors.CodeInvalidArgument)
+}
+
 func sendCmd(ctx context.Context, conn connect.Client, cmd ctrl.Cmd) error {
 	cmd.TS = time.Now().UTC().Unix()
+	if cmd.Initiator == nil {
+		cmd.Initiator = ctrl.NewInitiator(ctx, conn)
+	}
 	_, err := conn.CmdStreamCollection().InsertOne(ctx, cmd)
 	return err
 }
 
-func checkForAnotherOperation(ctx context.Context, pbm *sdk.Client) error {
+// checkForAnotherOperation returns concurrentOpError if there is a running
+// operation which can't run along with op (see lock.Compatible).
+func checkForAnotherOperation(ctx context.Context, pbm *sdk.Client, op *lock.LockHeader) error {
 	locks, err := pbm.OpLocks(ctx)
 	if err != nil {
 		return errors.Wrap(err, "get operation lock")
@@ -35,8 +64,23 @@ func checkForAnotherOperation(ctx context.Context, pbm *sdk.Client) error {
 		return errors.Wrap(err, "get cluster time")
 	}
 
+	staleSec := defs.StaleFrameSec
+	if cfg, err := pbm.GetConfig(ctx); err == nil {
+		staleSec = cfg.Lock.StaleThresholdSec()
+	}
+
+	return checkConcurrentOps(op, locks, ts, staleSec)
+}
+
+// checkConcurrentOps returns concurrentOpError if any of not stale (as of ts)
+// locks is incompatible with the operation.
+func checkConcurrentOps(op *lock.LockHeader, locks []sdk.OpLock, ts sdk.Timestamp, staleSec uint32) error {
 	for _, l := range locks {
-		if l.Heartbeat.T+defs.StaleFrameSec >= ts.T {
+		ld := lock.LockData{
+			LockHeader: lock.LockHeader{Type: l.Cmd, Scope: l.Scope, Storage: l.Storage},
+			Heartbeat:  l.Heartbeat,
+		}
+		if !ld.IsStale(ts, staleSec) && !lock.Compatible(op, &ld.LockHeader) {
 			return &concurrentOpError{l}
 		}
 	}
@@ -51,9 +95,14 @@ func (e *concurrentOpError) Error() string {
 		e.Cmd, e.OpID, e.Replset, e.Node)
 }
 
+func (e *concurrentOpError) ErrorCode() errors.Code {
+	return errors.CodeLockHeld
+}
+
 func (e *concurrentOpError) MarshalJSON() ([]byte, error) {
 	s := map[string]any{
 		"error": "another operation in progress",
+		"code":  errors.CodeLockHeld,
 		"operation": map[string]any{
 			"type":    e.Cmd,
 			"opid":    e.OpID,
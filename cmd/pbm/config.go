@@ -13,7 +13,9 @@ import (
 
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
 
@@ -25,6 +27,12 @@ type configOpts struct {
 	file     string
 	set      map[string]string
 	key      string
+	dryRun   bool
+	history  bool
+	limit    int64
+	rollback int64
+
+	forceClaim bool
 }
 
 type confKV struct {
@@ -47,20 +55,97 @@ func (c confVals) String() string {
 	return s
 }
 
+type configDiff struct {
+	Changes []config.Change `json:"changes"`
+}
+
+func (c configDiff) String() string {
+	if len(c.Changes) == 0 {
+		return "No changes"
+	}
+
+	s := "Changes:\n"
+	for _, ch := range c.Changes {
+		s += "  " + ch.String() + "\n"
+	}
+
+	return s
+}
+
+type configHistory []config.HistoryEntry
+
+func (h configHistory) String() string {
+	if len(h) == 0 {
+		return "No config changes recorded"
+	}
+
+	s := ""
+	for _, e := range h {
+		if e.Version != 0 {
+			s += fmt.Sprintf("v%d ", e.Version)
+		}
+		src := e.Source
+		if e.RollbackOf != 0 {
+			src += fmt.Sprintf(" to v%d", e.RollbackOf)
+		}
+		s += fmt.Sprintf("%s %s [%s]\n", fmtTS(e.Time), e.User, src)
+		for _, ch := range e.Changes {
+			s += "  " + ch.String() + "\n"
+		}
+	}
+
+	return s
+}
+
 func runConfig(
 	ctx context.Context,
 	conn connect.Client,
 	pbm *sdk.Client,
 	c *configOpts,
 ) (fmt.Stringer, error) {
-	if len(c.set) != 0 || c.rsync || c.file != "" {
-		if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	if c.history {
+		h, err := config.GetHistory(ctx, conn, c.limit)
+		if err != nil {
+			return nil, errors.Wrap(err, "get config history")
+		}
+		return configHistory(h), nil
+	}
+
+	if c.forceClaim && !c.rsync && c.file == "" && len(c.set) == 0 && c.rollback == 0 {
+		return nil, errors.New("--force-claim-storage is applicable only with " +
+			"--force-resync, --file, --set or --rollback")
+	}
+
+	if !c.dryRun && (len(c.set) != 0 || c.rsync || c.file != "" || c.rollback != 0) {
+		if err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdResync}); err != nil {
 			return nil, err
 		}
 	}
 
 	switch {
 	case len(c.set) > 0:
+		oldCfg, err := pbm.GetConfig(ctx)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, errors.New("config is not set")
+			}
+			return nil, errors.Wrap(err, "unable to get current config")
+		}
+		newCfg := oldCfg
+		for k, v := range c.set {
+			newCfg, err = config.PreviewConfigVar(newCfg, k, v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "set %s", k)
+			}
+		}
+		changes, err := config.Diff(oldCfg, newCfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "diff")
+		}
+		if c.dryRun {
+			return configDiff{changes}, nil
+		}
+
 		var o confVals
 		rsnc := false
 		for k, v := range c.set {
@@ -75,8 +160,13 @@ func runConfig(
 				rsnc = true
 			}
 		}
+		cfg, err := pbm.GetConfig(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "get applied config")
+		}
+		recordConfigHistory(ctx, conn, &config.HistoryEntry{Source: "set", Changes: changes, Config: cfg})
 		if rsnc {
-			if _, err := pbm.SyncFromStorage(ctx); err != nil {
+			if _, err := c.startResync(ctx, pbm); err != nil {
 				return nil, errors.Wrap(err, "resync")
 			}
 		}
@@ -91,7 +181,7 @@ func runConfig(
 		}
 		return confKV{c.key, fmt.Sprint(k)}, nil
 	case c.rsync:
-		cid, err := pbm.SyncFromStorage(ctx)
+		cid, err := c.startResync(ctx, pbm)
 		if err != nil {
 			return nil, errors.Wrap(err, "resync")
 		}
@@ -137,23 +227,115 @@ func runConfig(
 			oldCfg = &config.Config{}
 		}
 
+		if err := newCfg.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid config")
+		}
+		changes, err := config.Diff(oldCfg, newCfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "diff")
+		}
+		if c.dryRun {
+			return configDiff{changes}, nil
+		}
+
 		if err := config.SetConfig(ctx, conn, newCfg); err != nil {
 			return nil, errors.Wrap(err, "unable to set config: write to db")
 		}
 
+		recordConfigHistory(ctx, conn, &config.HistoryEntry{Source: "file", Changes: changes, Config: newCfg})
+
 		// resync storage only if Storage options have changed
-		if !reflect.DeepEqual(newCfg.Storage, oldCfg.Storage) {
-			if _, err := pbm.SyncFromStorage(ctx); err != nil {
+		if !reflect.DeepEqual(newCfg.Storage, oldCfg.Storage) || c.forceClaim {
+			if _, err := c.startResync(ctx, pbm); err != nil {
 				return nil, errors.Wrap(err, "resync")
 			}
 		}
 
 		return newCfg, nil
+	case c.rollback != 0:
+		return rollbackConfig(ctx, conn, pbm, c)
 	}
 
 	return pbm.GetConfig(ctx)
 }
 
+// startResync sends the resync of the main storage. With --force-claim-storage
+// the cluster takes the storage over from the cluster which owns it.
+func (c *configOpts) startResync(ctx context.Context, pbm *sdk.Client) (sdk.CommandID, error) {
+	if c.forceClaim {
+		return pbm.ClaimStorage(ctx, "")
+	}
+	return pbm.SyncFromStorage(ctx)
+}
+
+// rollbackConfig applies the config of the version from the history again.
+func rollbackConfig(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	c *configOpts,
+) (fmt.Stringer, error) {
+	version := c.rollback
+	e, err := config.GetHistoryVersion(ctx, conn, version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get config version %d", version)
+	}
+
+	oldCfg, err := pbm.GetConfig(ctx)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.Wrap(err, "unable to get current config")
+		}
+		oldCfg = &config.Config{}
+	}
+	newCfg, err := e.RollbackConfig(oldCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid config (use --file to apply it with the secrets)")
+	}
+
+	changes, err := config.Diff(oldCfg, newCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "diff")
+	}
+	if c.dryRun {
+		return configDiff{changes}, nil
+	}
+
+	if err := config.SetConfig(ctx, conn, newCfg); err != nil {
+		return nil, errors.Wrap(err, "unable to set config: write to db")
+	}
+
+	recordConfigHistory(ctx, conn, &config.HistoryEntry{
+		Source:     "rollback",
+		RollbackOf: version,
+		Changes:    changes,
+		Config:     newCfg,
+	})
+
+	if !reflect.DeepEqual(newCfg.Storage, oldCfg.Storage) || c.forceClaim {
+		if _, err := c.startResync(ctx, pbm); err != nil {
+			return nil, errors.Wrap(err, "resync")
+		}
+	}
+
+	return newCfg, nil
+}
+
+// recordConfigHistory saves applied changes and the config into the config history.
+// The config is already applied, so failure is only reported.
+func recordConfigHistory(ctx context.Context, conn connect.Client, e *config.HistoryEntry) {
+	if len(e.Changes) == 0 {
+		return
+	}
+
+	e.Time = time.Now().Unix()
+	e.User = ctrl.NewInitiator(ctx, conn).String()
+	err := config.AddHistory(ctx, conn, e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to record config history: %v\n", err)
+	}
+}
+
 func readConfigFromFile(filename string) (*config.Config, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+func TestConfigChangeConcurrentOps(t *testing.T) {
+	// config changes (including rollback) are checked as resync
+	op := &lock.LockHeader{Type: ctrl.CmdResync}
+	now := sdk.Timestamp{T: 1000}
+
+	running := []sdk.OpLock{{
+		OpID:      "op1",
+		Cmd:       ctrl.CmdBackup,
+		Replset:   "rs0",
+		Node:      "rs0:27017",
+		Heartbeat: sdk.Timestamp{T: 995},
+	}}
+	err := checkConcurrentOps(op, running, now, defs.StaleFrameSec)
+	var opErr *concurrentOpError
+	if !errors.As(err, &opErr) || opErr.Cmd != ctrl.CmdBackup {
+		t.Errorf("expected concurrent backup error, got %v", err)
+	}
+
+	stale := []sdk.OpLock{{OpID: "op1", Cmd: ctrl.CmdBackup, Heartbeat: sdk.Timestamp{T: 900}}}
+	if err := checkConcurrentOps(op, stale, now, defs.StaleFrameSec); err != nil {
+		t.Errorf("unexpected error for stale lock: %v", err)
+	}
+
+	if err := checkConcurrentOps(op, nil, now, defs.StaleFrameSec); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// not stale by the configured threshold
+	if err := checkConcurrentOps(op, stale, now, 120); !errors.As(err, &opErr) {
+		t.Errorf("expected concurrent backup error with the configured threshold, got %v", err)
+	}
+}
+
+func TestConfigHistoryString(t *testing.T) {
+	h := configHistory{
+		{
+			Version:    4,
+			Time:       1704164645,
+			User:       "admin@host",
+			Source:     "rollback",
+			RollbackOf: 2,
+			Changes:    []config.Change{{Path: "storage.s3.credentials.secret-access-key", Old: "***", New: "***"}},
+			Config:     &config.Config{Storage: config.StorageConf{Type: "filesystem"}},
+		},
+		{Time: 1704164600, User: "admin@host", Source: "set"},
+	}
+
+	out := h.String()
+	for _, s := range []string{
+		"v4 ", "admin@host [rollback to v2]",
+		"~ storage.s3.credentials.secret-access-key: *** -> ***",
+		" admin@host [set]",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q not found in:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "v0") || strings.Contains(out, "filesystem") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,17 +17,19 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
 
 type deleteBcpOpts struct {
-	name      string
-	olderThan string
-	bcpType   string
-	dryRun    bool
-	yes       bool
+	name         string
+	olderThan    string
+	bcpType      string
+	dryRun       bool
+	yes          bool
+	overrideHold bool
 }
 
 func deleteBackup(
@@ -44,20 +47,25 @@ func deleteBackup(
 	if d.bcpType != "" && d.olderThan == "" {
 		return nil, errors.New("cannot use --type without --older-than")
 	}
+	if d.overrideHold && d.name == "" {
+		return nil, errors.New("cannot use --override-hold without backup name")
+	}
 	if !d.dryRun {
-		err := checkForAnotherOperation(ctx, pbm)
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdDeleteBackup, Storage: lock.AnyStorage})
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	var cid sdk.CommandID
-	var err error
-	if d.name != "" {
-		cid, err = deleteBackupByName(ctx, pbm, d)
-	} else {
-		cid, err = deleteManyBackup(ctx, pbm, d)
+	if d.name == "" {
+		rv, err := deleteManyBackup(ctx, pbm, d)
+		if errors.Is(err, errUserCanceled) {
+			return outMsg{err.Error()}, nil
+		}
+		return rv, err
 	}
+
+	cid, err := deleteBackupByName(ctx, pbm, d)
 	if err != nil {
 		if errors.Is(err, errUserCanceled) {
 			return outMsg{err.Error()}, nil
@@ -88,7 +96,12 @@ func deleteBackupByName(ctx context.Context, pbm *sdk.Client, d *deleteBcpOpts)
 	} else {
 		err = sdk.CanDeleteBackup(ctx, pbm, bcp)
 	}
-	if err != nil {
+	held := errors.Is(err, sdk.ErrBackupHeld)
+	if held && !d.overrideHold {
+		return sdk.NoOpID, errors.Errorf("backup cannot be deleted: %v. "+
+			"Run `pbm backup release <name>` or use --override-hold", err)
+	}
+	if err != nil && !held {
 		if errors.Is(err, sdk.ErrNotBaseIncrement) || errors.Is(err, sdk.ErrIncrementalBackup) {
 			err = errors.New("Removing a single incremental backup is not allowed; " +
 				"the entire chain must be removed instead.")
@@ -108,6 +121,17 @@ func deleteBackupByName(ctx context.Context, pbm *sdk.Client, d *deleteBcpOpts)
 	if d.dryRun {
 		return sdk.NoOpID, nil
 	}
+	if held {
+		// --yes is not enough to delete the backup on hold
+		fmt.Printf("WARNING: %v\n", err)
+		err := askTypedConfirmation("Type the backup name to delete it regardless of the hold:", d.name)
+		if err != nil {
+			return sdk.NoOpID, err
+		}
+
+		cid, err := pbm.DeleteHeldBackup(ctx, d.name)
+		return cid, errors.Wrap(err, "schedule delete")
+	}
 	if !d.yes {
 		err := askConfirmation("Are you sure you want to delete backup?")
 		if err != nil {
@@ -119,40 +143,198 @@ func deleteBackupByName(ctx context.Context, pbm *sdk.Client, d *deleteBcpOpts)
 	return cid, errors.Wrap(err, "schedule delete")
 }
 
-func deleteManyBackup(ctx context.Context, pbm *sdk.Client, d *deleteBcpOpts) (sdk.CommandID, error) {
-	var ts primitive.Timestamp
+// deleteManyBackup deletes backups older than --older-than one by one.
+// Backups required by the PITR window or incremental chains are kept.
+// A failed deletion doesn't stop the others. The result is the plan
+// (the only one with --dry-run) and the summary.
+func deleteManyBackup(ctx context.Context, pbm *sdk.Client, d *deleteBcpOpts) (fmt.Stringer, error) {
 	ts, err := parseOlderThan(d.olderThan)
 	if err != nil {
-		return sdk.NoOpID, errors.Wrap(err, "parse --older-than")
+		return nil, errors.Wrap(err, "parse --older-than")
 	}
 	if n := time.Now().UTC(); ts.T > uint32(n.Unix()) {
 		providedTime := time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339)
 		realTime := n.Format(time.RFC3339)
-		return sdk.NoOpID, errors.Errorf("--older-than %q is after now %q", providedTime, realTime)
+		return nil, errors.Errorf("--older-than %q is after now %q", providedTime, realTime)
 	}
 
 	bcpType, err := backup.ParseDeleteBackupType(d.bcpType)
 	if err != nil {
-		return sdk.NoOpID, errors.Wrap(err, "parse --type")
+		return nil, errors.Wrap(err, "parse --type")
 	}
 	backups, err := sdk.ListDeleteBackupBefore(ctx, pbm, ts, bcpType)
 	if err != nil {
-		return sdk.NoOpID, errors.Wrap(err, "fetch backup list")
+		return nil, errors.Wrap(err, "fetch backup list")
+	}
+	kept, err := sdk.ListKeptBackupsBefore(ctx, pbm, ts, bcpType)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch kept backup list")
+	}
+	if len(backups) == 0 && len(kept) == 0 {
+		return outMsg{"nothing to delete"}, nil
 	}
 
-	printDeleteInfoTo(os.Stdout, backups, nil)
-
-	if d.dryRun {
-		return sdk.NoOpID, nil
+	plan := newDeleteBackupsPlan(backups, kept)
+	if d.dryRun || len(backups) == 0 {
+		return plan, nil
 	}
+
+	rv := &deleteBackupsResult{Plan: plan}
 	if !d.yes {
+		fmt.Println(plan)
+		rv.planShown = true
 		if err := askConfirmation("Are you sure you want to delete backups?"); err != nil {
-			return sdk.NoOpID, err
+			return nil, err
 		}
 	}
 
-	cid, err := pbm.DeleteBackupBefore(ctx, ts, sdk.DeleteBackupBeforeOptions{Type: bcpType})
-	return cid, errors.Wrap(err, "schedule delete")
+	rv.Summary = &deleteSummary{Deleted: []string{}, Failed: []deleteFailure{}}
+	for i := range backups {
+		bcp := &backups[i]
+		if bcp.Type == defs.IncrementalBackup && bcp.SrcBackup != "" {
+			// deleted along with the base of the chain
+			continue
+		}
+
+		err := deleteOneBackup(ctx, pbm, bcp.Name)
+		if err != nil {
+			rv.Summary.Failed = append(rv.Summary.Failed, deleteFailure{Name: bcp.Name, Err: err.Error()})
+			continue
+		}
+
+		rv.Summary.Deleted = append(rv.Summary.Deleted, bcp.Name)
+	}
+
+	return rv, nil
+}
+
+// deleteOneBackup deletes the backup (the whole chain for incremental base)
+// and waits until its metadata is gone.
+func deleteOneBackup(ctx context.Context, pbm *sdk.Client, name string) error {
+	cid, err := pbm.DeleteBackupByName(ctx, name)
+	if err != nil {
+		return errors.Wrap(err, "schedule delete")
+	}
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	// the agent may not take the lock yet when the wait is started.
+	// so check the metadata and try to wait again a few times
+	for range 10 {
+		err = sdk.WaitForCommandWithErrorLog(ctx, pbm, cid)
+		if err != nil {
+			return err
+		}
+
+		_, err = pbm.GetBackupByName(ctx, name, sdk.GetBackupByNameOptions{})
+		if errors.Is(err, sdk.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "get backup metadata")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+
+	return errors.New("backup is not deleted. Check `pbm logs -e delete` for details")
+}
+
+// deleteBackupsPlan is the backups delete-backup --older-than deletes
+// and the ones it keeps
+type deleteBackupsPlan struct {
+	Delete []string     `json:"delete"`
+	Kept   []keptBackup `json:"kept"`
+	// Size is the total size of the deleted backups
+	Size int64 `json:"size"`
+
+	backups []backup.BackupMeta
+	kept    []sdk.KeptBackup
+}
+
+type keptBackup struct {
+	Name       string `json:"name"`
+	RequiredBy string `json:"requiredBy,omitempty"`
+}
+
+func newDeleteBackupsPlan(backups []backup.BackupMeta, kept []sdk.KeptBackup) *deleteBackupsPlan {
+	p := &deleteBackupsPlan{
+		Delete:  make([]string, 0, len(backups)),
+		Kept:    make([]keptBackup, 0, len(kept)),
+		backups: backups,
+		kept:    kept,
+	}
+	for i := range backups {
+		p.Delete = append(p.Delete, backups[i].Name)
+		p.Size += backups[i].Size
+	}
+	for i := range kept {
+		p.Kept = append(p.Kept, keptBackup{Name: kept[i].Name, RequiredBy: kept[i].RequiredBy})
+	}
+
+	return p
+}
+
+func (p *deleteBackupsPlan) String() string {
+	var sb strings.Builder
+	printDeleteInfoTo(&sb, p.backups, nil)
+
+	if len(p.kept) != 0 {
+		fmt.Fprintln(&sb, "Kept:")
+		for i := range p.kept {
+			fmt.Fprintf(&sb, " - %q [type: <%s>] kept", p.kept[i].Name, p.kept[i].Type)
+			if p.kept[i].RequiredBy != "" {
+				fmt.Fprintf(&sb, ": required by %s", p.kept[i].RequiredBy)
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(&sb, "Total: %d backup(s), %s to be removed", len(p.backups), storage.PrettySize(p.Size))
+	return sb.String()
+}
+
+// deleteBackupsResult is the plan of delete-backup --older-than
+// and the summary of the deletion
+type deleteBackupsResult struct {
+	Plan    *deleteBackupsPlan `json:"plan"`
+	Summary *deleteSummary     `json:"summary"`
+
+	// planShown is true if the plan is already shown for the confirmation
+	planShown bool
+}
+
+func (r *deleteBackupsResult) String() string {
+	if r.planShown {
+		return r.Summary.String()
+	}
+
+	return r.Plan.String() + "\n" + r.Summary.String()
+}
+
+type deleteFailure struct {
+	Name string `json:"name"`
+	Err  string `json:"error"`
+}
+
+type deleteSummary struct {
+	Deleted []string        `json:"deleted"`
+	Failed  []deleteFailure `json:"failed"`
+}
+
+func (s *deleteSummary) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Deleted: %d, failed: %d", len(s.Deleted), len(s.Failed))
+	for _, f := range s.Failed {
+		fmt.Fprintf(&sb, "\n - %q: %s", f.Name, f.Err)
+	}
+
+	return sb.String()
 }
 
 type deletePitrOpts struct {
@@ -162,6 +344,10 @@ type deletePitrOpts struct {
 	wait      bool
 	waitTime  time.Duration
 	dryRun    bool
+	rs        string
+	from      string
+	to        string
+	force     bool
 }
 
 func deletePITR(
@@ -170,14 +356,23 @@ func deletePITR(
 	pbm *sdk.Client,
 	d *deletePitrOpts,
 ) (fmt.Stringer, error) {
+	if d.from != "" || d.to != "" {
+		if d.olderThan != "" || d.all {
+			return nil, errors.New("cannot use --from/--to with --older-than or --all")
+		}
+		return deletePITRTargeted(ctx, conn, pbm, d)
+	}
 	if d.olderThan == "" && !d.all {
-		return nil, errors.New("either --older-than or --all should be set")
+		return nil, errors.New("either --older-than, --all or --from/--to should be set")
 	}
 	if d.olderThan != "" && d.all {
 		return nil, errors.New("cannot use --older-than and --all at the same command")
 	}
+	if d.rs != "" {
+		return deletePITRTargeted(ctx, conn, pbm, d)
+	}
 	if !d.dryRun {
-		err := checkForAnotherOperation(ctx, pbm)
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdDeletePITR})
 		if err != nil {
 			return nil, err
 		}
@@ -214,7 +409,7 @@ func deletePITR(
 	if d.dryRun {
 		return &outMsg{""}, nil
 	}
-	if !d.yes {
+	if !d.yes && !d.force {
 		q := "Are you sure you want to delete chunks?"
 		if d.all {
 			q = "Are you sure you want to delete ALL chunks?"
@@ -249,15 +444,290 @@ func deletePITR(
 	return rv, err
 }
 
+// deletePITRTargeted deletes chunks of the replset (--rs) and/or
+// overlapping the time range (--from/--to). Unless --force is set,
+// it refuses to make a hole that leaves later chunks unusable.
+func deletePITRTargeted(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	d *deletePitrOpts,
+) (fmt.Stringer, error) {
+	now := primitive.Timestamp{T: uint32(time.Now().UTC().Unix())}
+
+	opts := sdk.DeleteOplogOptions{RS: d.rs}
+	switch {
+	case d.all:
+		opts.OlderThan = now
+	case d.olderThan != "":
+		var err error
+		opts.OlderThan, err = parseOlderThan(d.olderThan)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse --older-than")
+		}
+		if opts.OlderThan.After(now) {
+			return nil, errors.Errorf("--older-than %q is after now %q",
+				fmtTS(int64(opts.OlderThan.T)), fmtTS(int64(now.T)))
+		}
+	default:
+		opts.Range = true
+		var err error
+		if d.from != "" {
+			opts.From, err = parseOlderThan(d.from)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse --from")
+			}
+		}
+		opts.To = now
+		if d.to != "" {
+			opts.To, err = parseOlderThan(d.to)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse --to")
+			}
+		}
+		if !opts.From.Before(opts.To) {
+			return nil, errors.New("--from should be before --to")
+		}
+	}
+
+	if !d.dryRun {
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdDeletePITR})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunks, err := sdk.ListDeleteChunks(ctx, pbm, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "list chunks")
+	}
+	if len(chunks) == 0 {
+		return outMsg{"nothing to delete"}, nil
+	}
+
+	plan, err := makePITRDeletePlan(ctx, conn, chunks)
+	if err != nil {
+		return nil, errors.Wrap(err, "make plan")
+	}
+	if d.dryRun {
+		return plan, nil
+	}
+
+	fmt.Println(plan)
+	for _, h := range plan.Holes {
+		if h.Stranded && !d.force {
+			return nil, errors.Errorf("the deletion makes a hole in %s chunks (%s) and "+
+				"later chunks won't be restorable from any base snapshot. Use --force to delete anyway",
+				h.RS, h.Range)
+		}
+	}
+
+	if !d.yes && !d.force {
+		if err := askConfirmation("Are you sure you want to delete chunks?"); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	cid, err := pbm.DeleteOplog(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "schedule pitr delete")
+	}
+
+	if !d.wait {
+		return outMsg{"Processing by agents. Please check status later"}, nil
+	}
+
+	if d.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.waitTime)
+		defer cancel()
+	}
+
+	rv, err := waitForDelete(ctx, conn, pbm, cid)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errWaitTimeout
+	}
+	return rv, err
+}
+
+type pitrDeletePlan struct {
+	Replsets []pitrDeleteRS `json:"replsets"`
+	// Holes are ranges that become not restorable in the middle of
+	// the replset timeline
+	Holes []pitrHole `json:"holes,omitempty"`
+}
+
+type pitrDeleteRS struct {
+	RS     string    `json:"rs"`
+	Chunks int       `json:"chunks"`
+	Size   int64     `json:"size"`
+	Range  timeRange `json:"range"`
+}
+
+type pitrHole struct {
+	RS    string    `json:"rs"`
+	Range timeRange `json:"range"`
+	// Stranded is true if there is no base snapshot for the chunks after the hole
+	Stranded bool `json:"stranded"`
+}
+
+func (r timeRange) String() string {
+	return fmtTS(int64(r.From)) + " - " + fmtTS(int64(r.To))
+}
+
+func (p *pitrDeletePlan) String() string {
+	sb := strings.Builder{}
+	sb.WriteString("PITR chunks to delete:\n")
+	for _, rs := range p.Replsets {
+		fmt.Fprintf(&sb, "  %s: %d chunks, %s [%s]\n", rs.RS, rs.Chunks, storage.PrettySize(rs.Size), rs.Range)
+	}
+
+	if len(p.Holes) != 0 {
+		sb.WriteString("Holes to be made in PITR ranges:\n")
+		for _, h := range p.Holes {
+			s := ""
+			if h.Stranded {
+				s = " (no base snapshot for later chunks)"
+			}
+			fmt.Fprintf(&sb, "  %s: %s%s\n", h.RS, h.Range, s)
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func makePITRDeletePlan(ctx context.Context, conn connect.Client, chunks []oplog.OplogChunk) (*pitrDeletePlan, error) {
+	del := make(map[string][]oplog.OplogChunk)
+	for _, c := range chunks {
+		del[c.RS] = append(del[c.RS], c)
+	}
+
+	bcps, err := backup.BackupsDoneList(ctx, conn, nil, 0, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups")
+	}
+
+	all := make(map[string][]oplog.OplogChunk, len(del))
+	for rs := range del {
+		all[rs], err = oplog.PITRGetChunksSlice(ctx, conn, rs, primitive.Timestamp{}, primitive.Timestamp{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "get chunks for %s", rs)
+		}
+	}
+
+	return pitrDeletePlanFor(del, all, bcps), nil
+}
+
+func pitrDeletePlanFor(
+	del map[string][]oplog.OplogChunk,
+	all map[string][]oplog.OplogChunk,
+	bcps []backup.BackupMeta,
+) *pitrDeletePlan {
+	plan := &pitrDeletePlan{}
+
+	rss := make([]string, 0, len(del))
+	for rs := range del {
+		rss = append(rss, rs)
+	}
+	sort.Strings(rss)
+
+	for _, rs := range rss {
+		r := pitrDeleteRS{RS: rs, Chunks: len(del[rs])}
+		var start, end primitive.Timestamp
+		deleted := make(map[string]bool, len(del[rs]))
+		for _, c := range del[rs] {
+			r.Size += c.Size
+			if start.IsZero() || c.StartTS.Before(start) {
+				start = c.StartTS
+			}
+			if c.EndTS.After(end) {
+				end = c.EndTS
+			}
+			deleted[c.FName] = true
+		}
+		r.Range = timeRange{From: start.T, To: end.T}
+		plan.Replsets = append(plan.Replsets, r)
+
+		if h, ok := pitrHoleFor(rs, start, end, all[rs], deleted, bcps); ok {
+			plan.Holes = append(plan.Holes, h)
+		}
+	}
+
+	return plan
+}
+
+// pitrHoleFor checks if deletion of chunks in [start, end] makes a hole
+// in the contiguous timeline of the replset, i.e. remaining chunks are
+// adjacent to the range on both sides. The chunks after the hole are
+// stranded if no base snapshot of the replset lies within them.
+func pitrHoleFor(
+	rs string,
+	start primitive.Timestamp,
+	end primitive.Timestamp,
+	chunks []oplog.OplogChunk,
+	deleted map[string]bool,
+	bcps []backup.BackupMeta,
+) (pitrHole, bool) {
+	var left bool
+	var right primitive.Timestamp // end of the contiguous range after the hole
+	for _, c := range chunks {
+		if deleted[c.FName] {
+			continue
+		}
+		if c.StartTS.Before(start) && !c.EndTS.Before(start) {
+			left = true
+		}
+		switch {
+		case right.IsZero() && !c.StartTS.After(end) && c.EndTS.After(end):
+			right = c.EndTS
+		case !right.IsZero() && !c.StartTS.After(right) && c.EndTS.After(right):
+			right = c.EndTS
+		}
+	}
+	if !left || right.IsZero() {
+		return pitrHole{}, false
+	}
+
+	h := pitrHole{RS: rs, Range: timeRange{From: start.T, To: end.T}, Stranded: true}
+	for i := range bcps {
+		b := &bcps[i]
+		if len(b.Namespaces) != 0 || b.Type == defs.ExternalBackup || b.RS(rs) == nil {
+			continue
+		}
+		if !b.LastWriteTS.Before(end) && !b.LastWriteTS.After(right) {
+			h.Stranded = false
+			break
+		}
+	}
+
+	return h, true
+}
+
 type cleanupOptions struct {
 	olderThan string
 	yes       bool
 	wait      bool
 	waitTime  time.Duration
 	dryRun    bool
+	orphaned  bool
+	confirm   bool
+	grace     time.Duration
 }
 
 func doCleanup(ctx context.Context, conn connect.Client, pbm *sdk.Client, d *cleanupOptions) (fmt.Stringer, error) {
+	if d.orphaned {
+		if d.olderThan != "" {
+			return nil, errors.New("cannot use --orphaned and --older-than at the same command")
+		}
+		return doOrphanCleanup(ctx, conn, pbm, d)
+	}
+	if d.confirm {
+		return nil, errors.New("--confirm can be used only with --orphaned")
+	}
+
 	ts, err := parseOlderThan(d.olderThan)
 	if err != nil {
 		return nil, errors.Wrap(err, "parse --older-than")
@@ -268,7 +738,7 @@ func doCleanup(ctx context.Context, conn connect.Client, pbm *sdk.Client, d *cle
 		return nil, errors.Errorf("--older-than %q is after now %q", providedTime, realTime)
 	}
 	if !d.dryRun {
-		err := checkForAnotherOperation(ctx, pbm)
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdCleanup})
 		if err != nil {
 			return nil, err
 		}
@@ -278,6 +748,9 @@ func doCleanup(ctx context.Context, conn connect.Client, pbm *sdk.Client, d *cle
 	if err != nil {
 		return nil, errors.Wrap(err, "make cleanup report")
 	}
+	for i := range info.Held {
+		fmt.Printf("Skip backup %q: on hold %s\n", info.Held[i].Name, info.Held[i].Hold)
+	}
 	if len(info.Backups) == 0 && len(info.Chunks) == 0 {
 		return outMsg{"nothing to delete"}, nil
 	}
@@ -318,6 +791,103 @@ func doCleanup(ctx context.Context, conn connect.Client, pbm *sdk.Client, d *cle
 	return rv, err
 }
 
+// doOrphanCleanup reports orphaned storage files, dangling metadata and
+// incomplete uploads. They are cleaned up by agents only with --confirm.
+func doOrphanCleanup(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	d *cleanupOptions,
+) (fmt.Stringer, error) {
+	if d.grace < 0 {
+		return nil, errors.New("--grace cannot be negative")
+	}
+	if !d.dryRun && d.confirm {
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdCleanup})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := pbm.OrphanReport(ctx, d.grace)
+	if err != nil {
+		return nil, errors.Wrap(err, "make orphan report")
+	}
+	if d.dryRun || !d.confirm || r.IsEmpty() {
+		return orphanReportOut{r}, nil
+	}
+
+	fmt.Println(orphanReportOut{r})
+
+	cid, err := pbm.RunOrphanCleanup(ctx, d.grace)
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	if !d.wait {
+		return outMsg{"Processing by agents. Please check status later"}, nil
+	}
+
+	if d.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.waitTime)
+		defer cancel()
+	}
+
+	err = sdk.WaitForCommandWithErrorLog(ctx, pbm, cid)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errWaitTimeout
+		}
+		return nil, err
+	}
+
+	return outMsg{"Orphans cleanup is done"}, nil
+}
+
+type orphanReportOut struct {
+	*sdk.OrphanReport
+}
+
+func (r orphanReportOut) String() string {
+	if r.IsEmpty() {
+		return "No orphans found"
+	}
+
+	var sb strings.Builder
+	if len(r.Files) != 0 {
+		var size int64
+		for _, f := range r.Files {
+			size += f.Size
+		}
+		fmt.Fprintf(&sb, "Orphaned files (%d, %s):\n", len(r.Files), storage.PrettySize(size))
+		for _, f := range r.Files {
+			fmt.Fprintf(&sb, " - %s [size: %s, modified: %s]\n",
+				f.Name, storage.PrettySize(f.Size), fmtTS(f.ModTime.Unix()))
+		}
+	}
+	if len(r.Backups) != 0 {
+		fmt.Fprintf(&sb, "Dangling backups (%d):\n", len(r.Backups))
+		for _, b := range r.Backups {
+			fmt.Fprintf(&sb, " - %q missing: %s\n", b.Name, strings.Join(b.Missing, ", "))
+		}
+	}
+	if len(r.Chunks) != 0 {
+		fmt.Fprintf(&sb, "Dangling PITR chunks (%d):\n", len(r.Chunks))
+		for _, c := range r.Chunks {
+			fmt.Fprintf(&sb, " - %s: %s - %s\n", c.RS, fmtTS(int64(c.StartTS.T)), fmtTS(int64(c.EndTS.T)))
+		}
+	}
+	if len(r.Uploads) != 0 {
+		fmt.Fprintf(&sb, "Incomplete uploads (%d):\n", len(r.Uploads))
+		for _, u := range r.Uploads {
+			fmt.Fprintf(&sb, " - %s [initiated: %s]\n", u.Name, fmtTS(u.Initiated.Unix()))
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
 func parseOlderThan(s string) (primitive.Timestamp, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -328,6 +898,9 @@ func parseOlderThan(s string) (primitive.Timestamp, error) {
 	if !errors.Is(err, errInvalidFormat) {
 		return ts, err
 	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return primitive.Timestamp{T: uint32(t.Unix()), I: 0}, nil
+	}
 
 	dur, err := parseDuration(s)
 	if err != nil {
@@ -353,6 +926,9 @@ func parseDuration(s string) (time.Duration, error) {
 		return 0, err
 	}
 	if c != "d" {
+		if dur, err := time.ParseDuration(s); err == nil {
+			return dur, nil
+		}
 		return 0, errInvalidDuration
 	}
 
@@ -441,6 +1017,32 @@ func askConfirmation(question string) error {
 	return errUserCanceled
 }
 
+// askTypedConfirmation requires typing the value (e.g. the backup name)
+// to confirm an operation that cannot be confirmed with y/--yes.
+func askTypedConfirmation(question, value string) error {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat stdin")
+	}
+	if (fi.Mode() & os.ModeCharDevice) == 0 {
+		return errors.New("no tty")
+	}
+
+	fmt.Printf("%s ", question)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read stdin")
+	}
+
+	if strings.TrimSpace(scanner.Text()) != value {
+		return errUserCanceled
+	}
+
+	return nil
+}
+
 func waitForDelete(
 	ctx context.Context,
 	conn connect.Client,
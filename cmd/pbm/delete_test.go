@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+func TestParseOlderThan(t *testing.T) {
+	now := time.Now().UTC().Unix()
+
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"2024-01-02T03:04:05", 1704164645},
+		{"2024-01-02", 1704153600},
+		{"2024-01-02T03:04:05Z", 1704164645},
+		{"2024-01-02T05:04:05+02:00", 1704164645},
+		{"30d", now - 30*24*3600},
+		{"12h", now - 12*3600},
+	}
+	for _, tc := range cases {
+		ts, err := parseOlderThan(tc.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if d := int64(ts.T) - tc.want; d < -1 || d > 1 {
+			t.Errorf("%s: got %d, want %d", tc.in, ts.T, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "30x", "yesterday"} {
+		if _, err := parseOlderThan(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestDeleteBackupsPlan(t *testing.T) {
+	backups := []backup.BackupMeta{
+		{Name: "b1", Type: defs.LogicalBackup, Size: 1024},
+		{Name: "b2", Type: defs.PhysicalBackup, Size: 2048},
+	}
+	kept := []sdk.KeptBackup{
+		{BackupMeta: backup.BackupMeta{Name: "b3", Type: defs.LogicalBackup}, RequiredBy: "PITR window"},
+		{BackupMeta: backup.BackupMeta{Name: "b4", Type: defs.ExternalBackup}},
+	}
+
+	plan := newDeleteBackupsPlan(backups, kept)
+	if plan.Size != 3072 || !slices.Equal(plan.Delete, []string{"b1", "b2"}) || len(plan.Kept) != 2 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	out := plan.String()
+	for _, s := range []string{
+		`"b1"`,
+		`"b2"`,
+		`"b3" [type: <logical>] kept: required by PITR window` + "\n",
+		`"b4" [type: <external>] kept` + "\n",
+		"Total: 2 backup(s), 3.00KB to be removed",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("no %q in plan:\n%s", s, out)
+		}
+	}
+
+	res := &deleteBackupsResult{Plan: plan, Summary: &deleteSummary{Deleted: []string{"b1", "b2"}}}
+	if got := res.String(); !strings.HasPrefix(got, out) || !strings.HasSuffix(got, "Deleted: 2, failed: 0") {
+		t.Errorf("unexpected result:\n%s", got)
+	}
+	res.planShown = true
+	if got := res.String(); got != "Deleted: 2, failed: 0" {
+		t.Errorf("unexpected result with the plan shown: %q", got)
+	}
+}
+
+func TestDeleteSummary(t *testing.T) {
+	s := &deleteSummary{
+		Deleted: []string{"b1"},
+		Failed:  []deleteFailure{{Name: "b2", Err: "oops"}},
+	}
+
+	want := "Deleted: 1, failed: 1\n - \"b2\": oops"
+	if got := s.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPITRDeletePlan(t *testing.T) {
+	chunk := func(rs string, start, end uint32) oplog.OplogChunk {
+		return oplog.OplogChunk{
+			RS:      rs,
+			FName:   fmt.Sprintf("%s/%d-%d", rs, start, end),
+			StartTS: primitive.Timestamp{T: start},
+			EndTS:   primitive.Timestamp{T: end},
+			Size:    10,
+		}
+	}
+	all := map[string][]oplog.OplogChunk{
+		"rs0": {chunk("rs0", 10, 20), chunk("rs0", 20, 30), chunk("rs0", 30, 40), chunk("rs0", 40, 50)},
+		"rs1": {chunk("rs1", 10, 20), chunk("rs1", 20, 30)},
+	}
+	bcp := func(lw uint32) backup.BackupMeta {
+		return backup.BackupMeta{
+			Status:      defs.StatusDone,
+			LastWriteTS: primitive.Timestamp{T: lw},
+			Replsets:    []backup.BackupReplset{{Name: "rs0"}, {Name: "rs1"}},
+		}
+	}
+
+	// the middle of rs0 and the tail of rs1
+	del := map[string][]oplog.OplogChunk{
+		"rs0": {chunk("rs0", 20, 30)},
+		"rs1": {chunk("rs1", 20, 30)},
+	}
+
+	plan := pitrDeletePlanFor(del, all, []backup.BackupMeta{bcp(10)})
+	if len(plan.Replsets) != 2 || plan.Replsets[0].Chunks != 1 || plan.Replsets[0].Size != 10 {
+		t.Fatalf("unexpected plan: %+v", plan.Replsets)
+	}
+	if len(plan.Holes) != 1 || plan.Holes[0].RS != "rs0" || !plan.Holes[0].Stranded {
+		t.Fatalf("expected stranded hole in rs0, got %+v", plan.Holes)
+	}
+	if plan.Holes[0].Range != (timeRange{From: 20, To: 30}) {
+		t.Errorf("unexpected hole range: %+v", plan.Holes[0].Range)
+	}
+
+	// a snapshot after the hole makes later chunks restorable
+	plan = pitrDeletePlanFor(del, all, []backup.BackupMeta{bcp(10), bcp(35)})
+	if len(plan.Holes) != 1 || plan.Holes[0].Stranded {
+		t.Errorf("expected not stranded hole, got %+v", plan.Holes)
+	}
+}
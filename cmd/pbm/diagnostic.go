@@ -1,18 +1,31 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
 
@@ -21,6 +34,23 @@ type diagnosticOptions struct {
 	opid    string
 	name    string
 	archive bool
+
+	output      string
+	since       string
+	maxLogLines int64
+	maxFileSize int64
+
+	agentsState bool
+}
+
+func handleDumpAgentsState(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	opid, err := ctrl.SendDumpState(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	return outMsg{fmt.Sprintf("Agents are dumping their state. "+
+		"Check `pbm logs -e %s -i %s` or the files in the agents' temp dir", ctrl.CmdDumpState, opid)}, nil
 }
 
 func handleDiagnostic(
@@ -28,6 +58,9 @@ func handleDiagnostic(
 	pbm *sdk.Client,
 	opts diagnosticOptions,
 ) (fmt.Stringer, error) {
+	if opts.path == "" {
+		return nil, errors.New("--path or --output must be provided")
+	}
 	if opts.opid == "" && opts.name == "" {
 		return nil, errors.New("--opid or --name must be provided")
 	}
@@ -257,3 +290,465 @@ func createArchive(path, prefix string) error {
 	file = nil
 	return nil
 }
+
+type diagBundleManifest struct {
+	CreatedAt string              `json:"created_at"`
+	Version   string              `json:"pbm_version"`
+	OPID      string              `json:"opid,omitempty"`
+	Since     string              `json:"since"`
+	Files     []diagBundleFile    `json:"files"`
+	Missing   []diagBundleMissing `json:"missing,omitempty"`
+}
+
+type diagBundleFile struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// diagBundleMissing is data that couldn't be collected.
+type diagBundleMissing struct {
+	Item   string `json:"item"`
+	Reason string `json:"reason"`
+}
+
+type diagBundleOut struct {
+	Output   string              `json:"output"`
+	Files    int                 `json:"files"`
+	Missing  []diagBundleMissing `json:"missing,omitempty"`
+	Redacted bool                `json:"redacted"`
+}
+
+func (o diagBundleOut) String() string {
+	s := fmt.Sprintf("Diagnostics bundle is written to %s (%d files)", o.Output, o.Files)
+	if len(o.Missing) != 0 {
+		s += "\nMissing data:"
+		for _, m := range o.Missing {
+			s += fmt.Sprintf("\n  - %s: %s", m.Item, m.Reason)
+		}
+	}
+
+	return s
+}
+
+// diagBundle writes files into the tar.gz and keeps track of them
+// in the manifest. Files bigger than maxSize are truncated.
+type diagBundle struct {
+	tw       *tar.Writer
+	maxSize  int64
+	manifest diagBundleManifest
+}
+
+func (b *diagBundle) missing(item string, err error) {
+	b.manifest.Missing = append(b.manifest.Missing, diagBundleMissing{Item: item, Reason: err.Error()})
+}
+
+func (b *diagBundle) writeFile(name string, data []byte) error {
+	f := diagBundleFile{Name: name, Size: int64(len(data))}
+	if b.maxSize > 0 && f.Size > b.maxSize {
+		data = data[:b.maxSize]
+		f.Size = b.maxSize
+		f.Truncated = true
+	}
+
+	err := b.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    f.Size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "write %s header", name)
+	}
+	_, err = b.tw.Write(data)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+
+	b.manifest.Files = append(b.manifest.Files, f)
+	return nil
+}
+
+// addJSON collects the data with fn and writes it as redacted JSON.
+// If fn fails, the item is noted in the manifest as missing.
+func (b *diagBundle) addJSON(name string, fn func() (any, error)) error {
+	v, err := fn()
+	if err != nil {
+		b.missing(name, err)
+		return nil
+	}
+
+	data, err := redactedJSON(v)
+	if err != nil {
+		b.missing(name, err)
+		return nil
+	}
+
+	return b.writeFile(name, data)
+}
+
+// addText collects the text data with fn. If fn fails, the item is
+// noted in the manifest as missing.
+func (b *diagBundle) addText(name string, fn func() (string, error)) error {
+	s, err := fn()
+	if err != nil {
+		b.missing(name, err)
+		return nil
+	}
+
+	return b.writeFile(name, []byte(s))
+}
+
+// diagSecretKeys are (lowercased) names of fields with secrets
+var diagSecretKeys = map[string]bool{
+	"access-key-id":     true,
+	"secret-access-key": true,
+	"session-token":     true,
+	"secret":            true,
+	"token":             true,
+	"key":               true,
+	"ssecustomerkey":    true,
+	"password":          true,
+	"accesskeyid":       true,
+	"secretaccesskey":   true,
+	"sessiontoken":      true,
+}
+
+// redactedJSON returns indented JSON of v with all secret values masked.
+func redactedJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal")
+	}
+
+	var doc any
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+
+	return json.MarshalIndent(redact(doc), "", "  ")
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if s, ok := val.(string); ok && s != "" && diagSecretKeys[strings.ToLower(k)] {
+				v[k] = "***"
+				continue
+			}
+			v[k] = redact(val)
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+
+	return v
+}
+
+func handleDiagnosticBundle(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	curi string,
+	opts diagnosticOptions,
+) (fmt.Stringer, error) {
+	since, err := parseOlderThan(opts.since)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --since")
+	}
+
+	file, err := os.Create(opts.output)
+	if err != nil {
+		return nil, errors.Wrap(err, "create output file")
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	b := &diagBundle{
+		tw:      tar.NewWriter(gz),
+		maxSize: opts.maxFileSize,
+		manifest: diagBundleManifest{
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			Version:   version.Current().Version,
+			OPID:      opts.opid,
+			Since:     time.Unix(int64(since.T), 0).UTC().Format(time.RFC3339),
+		},
+	}
+
+	err = collectDiagBundle(ctx, conn, pbm, curi, opts, since, b)
+	if err != nil {
+		os.Remove(opts.output)
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal manifest")
+	}
+	err = b.writeFile("manifest.json", data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = errors.Join(b.tw.Close(), gz.Close(), file.Close())
+	if err != nil {
+		os.Remove(opts.output)
+		return nil, errors.Wrap(err, "write bundle")
+	}
+
+	return diagBundleOut{
+		Output:   opts.output,
+		Files:    len(b.manifest.Files),
+		Missing:  b.manifest.Missing,
+		Redacted: true,
+	}, nil
+}
+
+func collectDiagBundle(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	curi string,
+	opts diagnosticOptions,
+	since primitive.Timestamp,
+	b *diagBundle,
+) error {
+	err := b.addJSON("report.json", func() (any, error) {
+		return sdk.Diagnostic(ctx, pbm, sdk.CommandID(opts.opid))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addText("config.yaml", func() (string, error) {
+		cfg, err := pbm.GetConfig(ctx)
+		if err != nil {
+			return "", err
+		}
+		return cfg.String(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addText("profiles.yaml", func() (string, error) {
+		profiles, err := config.ListProfiles(ctx, conn)
+		if err != nil {
+			return "", err
+		}
+		s := ""
+		for i := range profiles {
+			s += "---\n" + profiles[i].String()
+		}
+		return s, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addText("status.txt", func() (string, error) {
+		out, err := status(ctx, conn, pbm, curi, statusOptions{priority: true}, false)
+		if err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var agents []topo.AgentStat
+	err = b.addJSON("agents.json", func() (any, error) {
+		agents, err = topo.ListAgents(ctx, conn)
+		return agents, err
+	})
+	if err != nil {
+		return err
+	}
+	noteMissingAgents(ctx, conn, agents, b)
+
+	err = b.addJSON("clock.json", func() (any, error) {
+		cfg, err := pbm.GetConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return agentsClock(agents, cfg.Agent.MaxClockSkew()), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addJSON("backups.json", func() (any, error) {
+		bcps, err := pbm.GetAllBackups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rv := []sdk.BackupMetadata{}
+		for _, bcp := range bcps {
+			if bcp.LastTransitionTS < int64(since.T) && !isActiveStatus(bcp.Status) {
+				continue
+			}
+			bcp.Store = backup.Storage{}
+			rv = append(rv, bcp)
+		}
+		return rv, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addJSON("restores.json", func() (any, error) {
+		rsts, err := pbm.GetAllRestores(ctx, conn, sdk.GetAllRestoresOptions{})
+		if err != nil {
+			return nil, err
+		}
+		rv := []sdk.RestoreMetadata{}
+		for _, r := range rsts {
+			if r.LastTransitionTS >= int64(since.T) || isActiveStatus(r.Status) {
+				rv = append(rv, r)
+			}
+		}
+		return rv, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.addText("pbm.log", func() (string, error) {
+		return diagLogs(ctx, conn, bson.D{{"ts", bson.M{"$gte": int64(since.T)}}}, opts.maxLogLines)
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.opid == "" {
+		return nil
+	}
+
+	err = b.addJSON("op/command.json", func() (any, error) {
+		return pbm.CommandInfo(ctx, sdk.CommandID(opts.opid))
+	})
+	if err != nil {
+		return err
+	}
+	// the operation is either a backup or a restore (or neither)
+	if bcp, err := pbm.GetBackupByOpID(ctx, opts.opid, sdk.GetBackupByNameOptions{}); err == nil {
+		bcp.Store = backup.Storage{}
+		err = b.addJSON("op/backup.json", func() (any, error) { return bcp, nil })
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, sdk.ErrNotFound) {
+		b.missing("op/backup.json", err)
+	}
+	if rst, err := pbm.GetRestoreByOpID(ctx, opts.opid); err == nil {
+		err = b.addJSON("op/restore.json", func() (any, error) { return rst, nil })
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, sdk.ErrNotFound) {
+		b.missing("op/restore.json", err)
+	}
+
+	// all log lines of the operation regardless of --since
+	return b.addText("op/pbm.log", func() (string, error) {
+		return diagLogs(ctx, conn, bson.D{{"opid", opts.opid}}, opts.maxLogLines)
+	})
+}
+
+func isActiveStatus(s defs.Status) bool {
+	return s != defs.StatusDone && s != defs.StatusError &&
+		s != defs.StatusCancelled && s != defs.StatusPartlyDone
+}
+
+// diagClock is the offset of the agents clocks from the cluster time
+type diagClock struct {
+	MaxSkewSec int64            `json:"maxSkewSec"`
+	Agents     []diagAgentClock `json:"agents"`
+}
+
+type diagAgentClock struct {
+	RS   string `json:"rs"`
+	Node string `json:"node"`
+	// OffsetSec is nil if the agent doesn't report it
+	OffsetSec *int64 `json:"offsetSec"`
+	Skewed    bool   `json:"skewed"`
+}
+
+func agentsClock(agents []topo.AgentStat, limit time.Duration) diagClock {
+	rv := diagClock{MaxSkewSec: int64(limit.Seconds()), Agents: []diagAgentClock{}}
+	for i := range agents {
+		a := &agents[i]
+		_, skewed := a.ClockSkew(limit)
+		rv.Agents = append(rv.Agents, diagAgentClock{
+			RS:        a.RS,
+			Node:      a.Node,
+			OffsetSec: a.ClockOffsetSec,
+			Skewed:    skewed,
+		})
+	}
+	return rv
+}
+
+// noteMissingAgents notes in the manifest agents with stale heartbeats
+// and replsets without any agent as their data is not up to date.
+func noteMissingAgents(ctx context.Context, conn connect.Client, agents []topo.AgentStat, b *diagBundle) {
+	ct, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		b.missing("agents state", err)
+		return
+	}
+
+	rss := make(map[string]bool)
+	for i := range agents {
+		a := &agents[i]
+		rss[a.RS] = true
+		if a.IsStale(ct) {
+			b.missing("agent "+a.RS+"/"+a.Node,
+				errors.Errorf("no heartbeat since %s", fmtTS(int64(a.Heartbeat.T))))
+		}
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		b.missing("topology", err)
+		return
+	}
+	for _, sh := range shards {
+		if !rss[sh.RS] {
+			b.missing("agents on "+sh.RS, errors.New("no agent has reported"))
+		}
+	}
+}
+
+// diagLogs returns the last maxLines log entries matching the filter.
+// Older entries are dropped.
+func diagLogs(ctx context.Context, conn connect.Client, filter bson.D, maxLines int64) (string, error) {
+	opts := mopts.Find().SetSort(bson.D{{"ts", -1}, {"ns", -1}, {"_id", -1}})
+	if maxLines > 0 {
+		opts.SetLimit(maxLines)
+	}
+
+	cur, err := conn.LogCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return "", errors.Wrap(err, "query")
+	}
+
+	entries := []log.Entry{}
+	err = cur.All(ctx, &entries)
+	if err != nil {
+		return "", errors.Wrap(err, "decode")
+	}
+
+	sb := strings.Builder{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		sb.WriteString(entries[i].Stringify(log.AsUTC, true, true))
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+)
+
+func TestRedactedJSON(t *testing.T) {
+	v := map[string]any{
+		"storage": config.StorageConf{
+			Type: "s3",
+		},
+		"credentials": map[string]any{
+			"access-key-id":     "AKIA",
+			"secret-access-key": "secret",
+			"session-token":     "",
+		},
+		"list": []any{map[string]any{"password": "pwd", "user": "u"}},
+	}
+
+	b, err := redactedJSON(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	for _, secret := range []string{"AKIA", `"secret"`, "pwd"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("%s is not redacted: %s", secret, s)
+		}
+	}
+	if !strings.Contains(s, `"user": "u"`) {
+		t.Errorf("non-secret value is redacted: %s", s)
+	}
+}
+
+func TestDiagBundleWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b := &diagBundle{tw: tar.NewWriter(buf), maxSize: 4}
+
+	if err := b.addText("a.txt", func() (string, error) { return "12345678", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.addText("b.txt", func() (string, error) { return "", errors.New("agent is down") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.manifest.Files) != 1 || !b.manifest.Files[0].Truncated || b.manifest.Files[0].Size != 4 {
+		t.Errorf("unexpected files: %+v", b.manifest.Files)
+	}
+	if len(b.manifest.Missing) != 1 || b.manifest.Missing[0].Item != "b.txt" {
+		t.Errorf("unexpected missing: %+v", b.manifest.Missing)
+	}
+
+	tr := tar.NewReader(buf)
+	h, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(tr)
+	if h.Name != "a.txt" || string(data) != "1234" {
+		t.Errorf("unexpected entry %s: %q", h.Name, data)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+type restoreFinalizeOptions struct {
+	restore     string
+	rebalance   bool
+	skew        float64
+	collections int
+	cancel      bool
+	yes         bool
+	wait        bool
+	waitTime    time.Duration
+}
+
+type rebalanceResult struct {
+	*restore.RebalanceProgress
+}
+
+func (r rebalanceResult) String() string {
+	var s strings.Builder
+	fmt.Fprintln(&s, r.RebalanceProgress.String())
+	for _, c := range r.Collections {
+		state := "pending"
+		if c.Done {
+			state = "done"
+		}
+		fmt.Fprintf(&s, "  %s: %s, skew %.1f%%, %d chunks moved, %d split\n",
+			c.NS, state, c.Skew, c.Moved, c.Split)
+	}
+	return s.String()
+}
+
+// restoreFinalize runs the post-restore steps. For now it is the rebalance
+// of the restored chunks over the shards. The rebalance is done by the agent.
+func restoreFinalize(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	o restoreFinalizeOptions,
+) (fmt.Stringer, error) {
+	if o.cancel {
+		if _, err := pbm.CancelRebalance(ctx); err != nil {
+			return nil, errors.Wrap(err, "send cancel rebalance")
+		}
+		return outMsg{"Rebalance cancellation has started"}, nil
+	}
+	if !o.rebalance {
+		return nil, errors.New("nothing to do. Set --rebalance")
+	}
+	if o.skew <= 0 {
+		return nil, errors.New("--skew should be positive")
+	}
+	if o.collections <= 0 {
+		return nil, errors.New("--collections should be positive")
+	}
+
+	var meta *restore.RestoreMeta
+	var err error
+	if o.restore == "" {
+		meta, err = restore.GetLastRestore(ctx, conn)
+	} else {
+		meta, err = restore.GetRestoreMeta(ctx, conn, o.restore)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if meta.Status != defs.StatusDone {
+		return nil, errors.Errorf("restore %s is not done, status: %s", meta.Name, meta.Status)
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster members")
+	}
+	if len(shards) < 2 {
+		return nil, errors.New("rebalance is available for sharded clusters only")
+	}
+
+	err = checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdRebalance})
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.yes {
+		q := fmt.Sprintf("Chunks of up to %d largest sharded collections restored by %s "+
+			"will be moved between the shards. Continue?", o.collections, meta.Name)
+		if err := askConfirmation(q); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	cid, err := pbm.Rebalance(ctx, meta.Name, o.skew, o.collections)
+	if err != nil {
+		return nil, errors.Wrap(err, "schedule rebalance")
+	}
+
+	if !o.wait {
+		return outMsg{fmt.Sprintf("Rebalance has started. Check the progress with "+
+			"`pbm describe-restore %s`", meta.Name)}, nil
+	}
+
+	if o.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.waitTime)
+		defer cancel()
+	}
+
+	return waitForRebalance(ctx, conn, pbm, cid, meta.Name)
+}
+
+func waitForRebalance(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	cid sdk.CommandID,
+	name string,
+) (fmt.Stringer, error) {
+	cmd, err := pbm.CommandInfo(ctx, cid)
+	if err != nil {
+		return nil, errors.Wrap(err, "get command info")
+	}
+
+	fmt.Print("Starting rebalance")
+	startCtx, cancel := context.WithTimeout(ctx, defs.WaitActionStart)
+	defer cancel()
+	if err := waitForRebalanceStart(startCtx, conn, cid, name); err != nil {
+		msg, lerr := sdk.WaitForErrorLog(ctx, pbm, cmd)
+		if lerr != nil {
+			return nil, errors.Wrap(lerr, "read agents log")
+		}
+		if msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+
+	commandCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+
+	go func() {
+		fmt.Print("\nWaiting for rebalance to be done ")
+
+		for tick := time.NewTicker(time.Second); ; {
+			select {
+			case <-tick.C:
+				fmt.Print(".")
+			case <-commandCtx.Done():
+				return
+			}
+		}
+	}()
+
+	err = sdk.WaitForRebalance(commandCtx, pbm)
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		return outMsg{"Operation is still in progress, please check status in a while"}, nil
+	}
+
+	stopProgress()
+	fmt.Println("[done]")
+
+	meta, err := restore.GetRestoreMeta(ctx, conn, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	return rebalanceResult{meta.Rebalance}, nil
+}
+
+// waitForRebalanceStart waits for the agent to record the progress of the rebalance
+func waitForRebalanceStart(ctx context.Context, conn connect.Client, cid sdk.CommandID, name string) error {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			fmt.Print(".")
+			meta, err := restore.GetRestoreMeta(ctx, conn, name)
+			if err != nil {
+				return errors.Wrap(err, "get restore meta")
+			}
+			if meta.Rebalance != nil && meta.Rebalance.OPID == string(cid) {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.New("rebalance has not started. Check agents logs")
+		}
+	}
+}
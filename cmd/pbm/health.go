@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/sdk/cli"
+)
+
+const statusLongHelp = `Show PBM status.
+
+With --out=json the output is an object with the sections:
+  cluster     replsets with agents: "rs", "nodes" [{"host", "agent", "role",
+              "ok", "stale", "errors"}]
+  pitr        "conf", "run", "nodes", "error", "gaps" and "replsets"
+              [{"name", "node", "lastChunkEnd", "lagSec", "spanSec",
+              "chunksPerHour", "chunks", "size", "safetyMarginSec"}]
+  running     running operations [{"type", "opID", "name", "startTS",
+              "status", "scope", "storage" and "oplogProgress" per replset}].
+              Operations with compatible lock scopes run concurrently
+  locks       stale locks [{"type", "replset", "node", "opid", "heartbeat"}]
+  queue       pending backups [{"opid", "type", "profile", "submitted",
+              "expireAt", "collapsed"}] in the order they start
+  drift       the last storage reconciliation "mode", "node", "checked",
+              "added", "missing", "imported", "expired", "error"
+  oplog       "backupType", "marginPercent", "maxDurationSec" and "replsets"
+              [{"name", "node", "first", "last", "spanSec", "projectedSec",
+              "bytesPerSec", "estimateSec", "fits", "fitsMaxDuration"}]
+  backups     storage "type", "path", "region", "snapshot", "pitrChunks",
+              "probe" {"ok", "error"} and "lastBackup" {"name", "type",
+              "status", "error", "completedTS"}
+  health      "status" (ok/degraded/error), "code" and "issues"
+
+Health is evaluated for the requested sections only. With --exit-code
+the command exits with:
+  0  healthy
+  1  degraded: stale or failed agent, PITR lag over --pitr-lag-threshold,
+     PITR error or open gap, no backup within --backup-age-threshold,
+     stale lock, storage metadata drift, oplog window shorter than
+     the estimated backup duration
+  2  error: the last backup failed or the storage is unreachable`
+
+type healthStatus string
+
+const (
+	healthOK       healthStatus = "ok"
+	healthDegraded healthStatus = "degraded"
+	healthError    healthStatus = "error"
+)
+
+// ExitCode returns `pbm status --exit-code` exit code for the status.
+func (h healthStatus) ExitCode() int {
+	switch h {
+	case healthDegraded:
+		return 1
+	case healthError:
+		return 2
+	default:
+		return 0
+	}
+}
+
+type healthThresholds struct {
+	// PITRLag is the max PITR lag. If 0, two oplog spans are allowed.
+	PITRLag time.Duration
+	// BackupAge is the max age of the last successful backup. 0 - no check.
+	BackupAge time.Duration
+}
+
+type statusHealth struct {
+	Status healthStatus `json:"status"`
+	Code   int          `json:"code"`
+	Issues []string     `json:"issues"`
+}
+
+func (h *statusHealth) String() string {
+	s := string(h.Status)
+	for _, i := range h.Issues {
+		s += "\n  " + i
+	}
+	return s
+}
+
+func (h *statusHealth) add(st healthStatus, format string, args ...any) {
+	if st.ExitCode() > h.Status.ExitCode() {
+		h.Status = st
+		h.Code = st.ExitCode()
+	}
+	h.Issues = append(h.Issues, fmt.Sprintf("[%s] ", st)+fmt.Sprintf(format, args...))
+}
+
+// evalHealth derives the overall health from the collected status sections.
+// Only the requested sections are taken into account.
+func evalHealth(sections []*statusSect, t healthThresholds) *statusHealth {
+	h := &statusHealth{Status: healthOK, Issues: []string{}}
+
+	for _, sc := range sections {
+		switch o := sc.Obj.(type) {
+		case cluster:
+			clusterHealth(h, o)
+		case pitrStat:
+			pitrHealth(h, o, t)
+		case storageStat:
+			storageHealth(h, o, t)
+		case staleLocksStat:
+			for _, l := range o {
+				h.add(healthDegraded, "%s/%s: stale %s lock [opid: %s]", l.Replset, l.Node, l.Type, l.OPID)
+			}
+		case oplogWindowStat:
+			for _, rs := range o.Replsets {
+				if rs.Fits != nil && !*rs.Fits {
+					h.add(healthDegraded, "%s: oplog window %s is shorter than the estimated %s backup",
+						rs.Name, time.Duration(rs.ProjectedSec)*time.Second, o.BackupType)
+				}
+			}
+		case driftStat:
+			if o.Detected() {
+				h.add(healthDegraded, "metadata drift detected: %d backup(s) only on the storage, %d missing on the storage",
+					len(o.Added), len(o.Missing))
+			}
+		}
+	}
+
+	return h
+}
+
+func clusterHealth(h *statusHealth, c cluster) {
+	for _, rs := range c {
+		for _, n := range rs.Nodes {
+			switch {
+			case n.Role == cli.RoleArbiter || n.OK:
+			case n.Stale:
+				h.add(healthDegraded, "%s/%s: stale agent", rs.Name, n.Host)
+			case n.Ver == "":
+				h.add(healthDegraded, "%s/%s: agent not found", rs.Name, n.Host)
+			default:
+				h.add(healthDegraded, "%s/%s: agent failed: %s", rs.Name, n.Host, strings.Join(n.Errs, "; "))
+			}
+			if len(n.Missing) != 0 {
+				h.add(healthDegraded, "%s/%s: insufficient privileges: %s", rs.Name, n.Host, strings.Join(n.Missing, "; "))
+			}
+			if n.ClockSkewed {
+				h.add(healthDegraded, "%s/%s: clock is off the cluster time by %v",
+					rs.Name, n.Host, time.Duration(*n.ClockSkewSec)*time.Second)
+			}
+		}
+	}
+}
+
+func pitrHealth(h *statusHealth, p pitrStat, t healthThresholds) {
+	if r := p.Restart; r != nil {
+		if r.Status == restore.PITRRestartFailed {
+			h.add(healthDegraded, "PITR isn't enabled back after restore %s: %s", r.Restore, r.Error)
+		} else {
+			h.add(healthDegraded, "PITR disabled by restore %s is pending fresh base backup", r.Restore)
+		}
+	}
+	if !p.InConf {
+		return
+	}
+
+	for _, rs := range p.Replsets {
+		lagging := rs.IsLagging()
+		if t.PITRLag > 0 {
+			lagging = rs.LastChunkEnd != 0 && time.Duration(rs.LagSec)*time.Second > t.PITRLag
+		}
+		if lagging {
+			h.add(healthDegraded, "%s: PITR lag %s", rs.Name, time.Duration(rs.LagSec)*time.Second)
+		}
+	}
+	for _, g := range p.Gaps {
+		if g.IsOpen() {
+			h.add(healthDegraded, "%s: no oplog chunks since %s", g.RS, fmtTS(int64(g.StartTS.T)))
+		}
+	}
+	if p.Err != "" {
+		h.add(healthDegraded, "PITR error: %s", p.Err)
+	}
+}
+
+func storageHealth(h *statusHealth, s storageStat, t healthThresholds) {
+	if !s.Probe.OK {
+		h.add(healthError, "storage is unreachable: %s", s.Probe.Err)
+	}
+	for i := range s.Owners {
+		o := &s.Owners[i]
+		if o.Foreign && !o.ReadOnly {
+			h.add(healthError, "%s storage is claimed by another cluster: %s", o.storageName(), o.Owner)
+		}
+	}
+
+	b := s.LastBackup
+	if b == nil {
+		return
+	}
+	if b.Status == defs.StatusError {
+		h.add(healthError, "last backup %s failed: %s", b.Name, b.Err)
+	}
+
+	if t.BackupAge == 0 {
+		return
+	}
+	var last int64
+	for _, sn := range s.Snapshot {
+		if sn.Status == defs.StatusDone && sn.RestoreTS > last {
+			last = sn.RestoreTS
+		}
+	}
+	if age := time.Since(time.Unix(last, 0)); last == 0 || age > t.BackupAge {
+		h.add(healthDegraded, "no successful backup within %s", t.BackupAge)
+	}
+}
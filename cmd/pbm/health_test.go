@@ -0,0 +1,288 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/resync"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+	"github.com/percona/percona-backup-mongodb/sdk/cli"
+)
+
+func TestEvalHealth(t *testing.T) {
+	okCluster := cluster{{Name: "rs1", Nodes: []node{
+		{Host: "n1", Ver: "v2", OK: true},
+		{Host: "n2", Role: cli.RoleArbiter},
+	}}}
+	okPITR := pitrStat{InConf: true, Replsets: []pitrRSStat{
+		{Name: "rs1", LastChunkEnd: 100, LagSec: 120, SpanSec: 600},
+	}}
+	now := time.Now().Unix()
+	okStorage := storageStat{
+		Probe:      storageProbe{OK: true},
+		Snapshot:   []snapshotStat{{Name: "b1", Status: defs.StatusDone, RestoreTS: now - 3600}},
+		LastBackup: &lastBackupStat{Name: "b1", Status: defs.StatusDone},
+	}
+
+	sec := func(v int64) *int64 { return &v }
+
+	sections := func(objs ...any) []*statusSect {
+		rv := []*statusSect{}
+		for _, o := range objs {
+			switch o := o.(type) {
+			case cluster:
+				rv = append(rv, &statusSect{Name: "cluster", Obj: o})
+			case pitrStat:
+				rv = append(rv, &statusSect{Name: "pitr", Obj: o})
+			case storageStat:
+				rv = append(rv, &statusSect{Name: "backups", Obj: o})
+			case staleLocksStat:
+				rv = append(rv, &statusSect{Name: "locks", Obj: o})
+			case driftStat:
+				rv = append(rv, &statusSect{Name: "drift", Obj: o})
+			case oplogWindowStat:
+				rv = append(rv, &statusSect{Name: "oplog", Obj: o})
+			}
+		}
+		return rv
+	}
+
+	cases := []struct {
+		name string
+		objs []any
+		t    healthThresholds
+		want healthStatus
+		code int
+	}{
+		{"healthy", []any{okCluster, okPITR, okStorage}, healthThresholds{}, healthOK, 0},
+		{
+			"stale agent",
+			[]any{cluster{{Name: "rs1", Nodes: []node{{Host: "n1", Ver: "v2", Stale: true}}}}, okStorage},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"missed agent",
+			[]any{cluster{{Name: "rs1", Nodes: []node{{Host: "n1"}}}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"missing privileges",
+			[]any{cluster{{Name: "rs1", Nodes: []node{
+				{Host: "n1", Ver: "v2", OK: true, Missing: []string{"find on collection local.oplog.rs for pitr"}},
+			}}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"clock skew",
+			[]any{cluster{{Name: "rs1", Nodes: []node{
+				{Host: "n1", Ver: "v2", OK: true, ClockSkewSec: sec(-240), ClockSkewed: true},
+				{Host: "n2", Ver: "v2", OK: true, ClockSkewSec: sec(5)},
+			}}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"pitr pending after restore",
+			[]any{pitrStat{Restart: &pitrRestartStat{Restore: "r1", Status: restore.PITRRestartPending}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"pitr restart failed",
+			[]any{pitrStat{Restart: &pitrRestartStat{Restore: "r1", Status: restore.PITRRestartFailed}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"pitr lag over two spans",
+			[]any{pitrStat{InConf: true, Replsets: []pitrRSStat{
+				{Name: "rs1", LastChunkEnd: 100, LagSec: 1300, SpanSec: 600},
+			}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"pitr lag over threshold",
+			[]any{okPITR},
+			healthThresholds{PITRLag: time.Minute}, healthDegraded, 1,
+		},
+		{
+			"pitr lag under threshold",
+			[]any{pitrStat{InConf: true, Replsets: []pitrRSStat{
+				{Name: "rs1", LastChunkEnd: 100, LagSec: 1300, SpanSec: 600},
+			}}},
+			healthThresholds{PITRLag: time.Hour}, healthOK, 0,
+		},
+		{
+			"pitr open gap",
+			[]any{pitrStat{InConf: true, Gaps: []oplog.PITRGap{{RS: "rs1", StartTS: primitive.Timestamp{T: 1}}}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"pitr off",
+			[]any{pitrStat{Err: "some error"}},
+			healthThresholds{}, healthOK, 0,
+		},
+		{
+			"old backup",
+			[]any{okStorage},
+			healthThresholds{BackupAge: time.Minute}, healthDegraded, 1,
+		},
+		{
+			"failed backup",
+			[]any{okCluster, storageStat{
+				Probe:      storageProbe{OK: true},
+				LastBackup: &lastBackupStat{Name: "b2", Status: defs.StatusError, Err: "oops"},
+			}},
+			healthThresholds{}, healthError, 2,
+		},
+		{
+			"stale lock",
+			[]any{okCluster, staleLocksStat{{
+				LockHeader: lock.LockHeader{Type: ctrl.CmdBackup, Replset: "rs1", Node: "n1", OPID: "op1"},
+				Heartbeat:  now - 60,
+			}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"drift applied",
+			[]any{okCluster, driftStat{&resync.Drift{Imported: []string{"b1"}}}},
+			healthThresholds{}, healthOK, 0,
+		},
+		{
+			"drift detected",
+			[]any{okCluster, driftStat{&resync.Drift{Missing: []string{"b1"}}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"oplog window fits",
+			[]any{okCluster, oplogWindowStat{Replsets: []oplogWindowRS{
+				{Name: "rs1", ProjectedSec: 7200, Fits: func(v bool) *bool { return &v }(true)},
+				{Name: "rs2", ProjectedSec: 7200},
+			}}},
+			healthThresholds{}, healthOK, 0,
+		},
+		{
+			"oplog window too short",
+			[]any{okCluster, oplogWindowStat{Replsets: []oplogWindowRS{
+				{Name: "rs1", ProjectedSec: 600, Fits: func(v bool) *bool { return &v }(false)},
+			}}},
+			healthThresholds{}, healthDegraded, 1,
+		},
+		{
+			"storage of another cluster",
+			[]any{okCluster, storageStat{
+				Probe: storageProbe{OK: true},
+				Owners: []storageOwnerStat{
+					{Owner: &util.StorageOwner{ClusterID: "65f0"}},
+					{Profile: "prod", ReadOnly: true, Owner: &util.StorageOwner{ClusterID: "77aa"}, Foreign: true},
+					{Profile: "shared", Owner: &util.StorageOwner{ClusterID: "77aa"}, Foreign: true},
+				},
+			}},
+			healthThresholds{}, healthError, 2,
+		},
+		{
+			"unreachable storage and stale agent",
+			[]any{
+				cluster{{Name: "rs1", Nodes: []node{{Host: "n1", Ver: "v2", Stale: true}}}},
+				storageStat{Probe: storageProbe{Err: "access denied"}},
+			},
+			healthThresholds{}, healthError, 2,
+		},
+	}
+	for _, tc := range cases {
+		h := evalHealth(sections(tc.objs...), tc.t)
+		if h.Status != tc.want || h.Code != tc.code {
+			t.Errorf("%s: got %s (%d), want %s (%d). issues: %v",
+				tc.name, h.Status, h.Code, tc.want, tc.code, h.Issues)
+		}
+		if tc.want != healthOK && len(h.Issues) == 0 {
+			t.Errorf("%s: no issues reported", tc.name)
+		}
+	}
+}
+
+func TestGetLastBackupStat(t *testing.T) {
+	now := primitive.Timestamp{T: 1000}
+	bcps := []backup.BackupMeta{
+		{Name: "b1", Status: defs.StatusDone, StartTS: 100},
+		{Name: "b2", Status: defs.StatusError, StartTS: 200, Err: "oops"},
+		{Name: "b3", Status: defs.StatusRunning, StartTS: 300, Hb: primitive.Timestamp{T: 990}},
+	}
+
+	got := getLastBackupStat(bcps, now)
+	if got == nil || got.Name != "b2" || got.Status != defs.StatusError {
+		t.Errorf("got %+v, want failed b2", got)
+	}
+
+	bcps[2].Hb.T = 100
+	got = getLastBackupStat(bcps, now)
+	if got == nil || got.Name != "b3" || got.Status != defs.StatusError {
+		t.Errorf("got %+v, want stuck b3", got)
+	}
+
+	if got := getLastBackupStat(nil, now); got != nil {
+		t.Errorf("no backups: got %+v", got)
+	}
+}
+
+func TestStaleLocksString(t *testing.T) {
+	s := staleLocksStat{
+		{
+			LockHeader: lock.LockHeader{Type: ctrl.CmdBackup, Replset: "rs1", Node: "n1:27017", OPID: "op1"},
+			Heartbeat:  1760400000,
+		},
+		{
+			LockHeader: lock.LockHeader{Type: ctrl.CmdRestore, Replset: "rs2", Node: "n2:27017", OPID: "op2"},
+			Heartbeat:  1760400000,
+		},
+	}
+
+	got := s.String()
+	for _, want := range []string{
+		"backup [opid: op1] on rs1/n1:27017: last heartbeat at 2025-10-14T00:00:00Z\n",
+		"restore [opid: op2] on rs2/n2:27017: last heartbeat at 2025-10-14T00:00:00Z\n",
+		"pbm force-unlock --opid",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestStaleLocks(t *testing.T) {
+	now := primitive.Timestamp{T: 1000}
+	locks := []lock.LockData{
+		{LockHeader: lock.LockHeader{Type: ctrl.CmdBackup, OPID: "fresh"}, Heartbeat: primitive.Timestamp{T: 990}},
+		{LockHeader: lock.LockHeader{Type: ctrl.CmdBackup, OPID: "op1"}, Heartbeat: primitive.Timestamp{T: 950}},
+		{LockHeader: lock.LockHeader{Type: ctrl.CmdRestore, OPID: "op2"}, Heartbeat: primitive.Timestamp{T: 800}},
+	}
+
+	cases := []struct {
+		name      string
+		threshold uint32
+		want      []string
+	}{
+		{"default threshold", defs.StaleFrameSec, []string{"op1", "op2"}},
+		{"configured threshold", 120, []string{"op2"}},
+		{"threshold below the minimum", 1, []string{"op1", "op2"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []string
+			for _, l := range staleLocks(locks, now, c.threshold) {
+				got = append(got, l.OPID)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
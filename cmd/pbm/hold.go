@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// holdBackup sets (or releases if hold is false) the hold of the backup
+func holdBackup(ctx context.Context, conn connect.Client, node, name string, hold bool) (fmt.Stringer, error) {
+	bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil, errors.WithCode(errors.Errorf("backup %q not found", name), errors.CodeBackupNotFound)
+		}
+		return nil, errors.Wrap(err, "get backup metadata")
+	}
+
+	if !hold {
+		if bcp.Hold == nil {
+			return outMsg{fmt.Sprintf("Backup %q is not on hold", name)}, nil
+		}
+
+		err = backup.SetHold(ctx, conn, bcp, nil, node)
+		if err != nil {
+			return nil, errors.Wrap(err, "release hold")
+		}
+		return outMsg{fmt.Sprintf("Backup %q is released", name)}, nil
+	}
+
+	if bcp.Hold != nil {
+		return outMsg{fmt.Sprintf("Backup %q is on hold already: %s", name, bcp.Hold)}, nil
+	}
+
+	h := &backup.BackupHold{
+		By: ctrl.NewInitiator(ctx, conn).String(),
+		At: time.Now().Unix(),
+	}
+	err = backup.SetHold(ctx, conn, bcp, h, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "set hold")
+	}
+
+	return outMsg{fmt.Sprintf("Backup %q is on hold: %s", name, h)}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+type importOptions struct {
+	prefix     string
+	profile    string
+	backup     string
+	descriptor string
+	cluster    string
+	verify     bool
+}
+
+type importResult struct {
+	Backups []importedBackup `json:"backups"`
+	Err     string           `json:"error,omitempty"`
+}
+
+type importedBackup struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Format  string `json:"format"`
+	Cluster string `json:"cluster"`
+}
+
+func (r importResult) String() string {
+	var s strings.Builder
+	if len(r.Backups) == 0 {
+		s.WriteString("No backups imported")
+	} else {
+		fmt.Fprintln(&s, "Imported backups:")
+		for _, b := range r.Backups {
+			fmt.Fprintf(&s, "  %s [%s, %s] from cluster %q\n", b.Name, b.Type, b.Format, b.Cluster)
+		}
+	}
+	if r.Err != "" {
+		fmt.Fprintf(&s, "\nFailed:\n%s", r.Err)
+	}
+	return strings.TrimSuffix(s.String(), "\n")
+}
+
+// importBackups registers backups made by another cluster (or plain
+// mongodump archives) in the storage folder.
+func importBackups(ctx context.Context, conn connect.Client, node string, o importOptions) (fmt.Stringer, error) {
+	if o.profile != "" {
+		if _, err := config.GetProfile(ctx, conn, o.profile); err != nil {
+			return nil, errors.Wrapf(err, "get profile %q", o.profile)
+		}
+	}
+
+	opts := &backup.ImportOptions{
+		Profile: o.profile,
+		Prefix:  o.prefix,
+		Backup:  o.backup,
+		Cluster: o.cluster,
+		Verify:  o.verify,
+	}
+	if o.descriptor != "" {
+		buf, err := os.ReadFile(o.descriptor)
+		if err != nil {
+			return nil, errors.Wrap(err, "read descriptor file")
+		}
+		opts.Descriptor = &backup.MongodumpDescriptor{}
+		if err := yaml.UnmarshalStrict(buf, opts.Descriptor); err != nil {
+			return nil, errors.Wrap(err, "parse descriptor file")
+		}
+	}
+
+	bcps, err := backup.ImportBackups(ctx, conn, opts, node, log.LogEventFromContext(ctx))
+	if len(bcps) == 0 && err != nil {
+		return nil, err
+	}
+
+	rv := importResult{Backups: []importedBackup{}}
+	for _, b := range bcps {
+		rv.Backups = append(rv.Backups, importedBackup{
+			Name:    b.Name,
+			Type:    string(b.Type),
+			Format:  b.Provenance.Format,
+			Cluster: b.Provenance.Cluster,
+		})
+	}
+	if err != nil {
+		rv.Err = err.Error()
+	}
+
+	return rv, nil
+}
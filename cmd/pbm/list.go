@@ -5,17 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
 	"github.com/percona/percona-backup-mongodb/pbm/version"
@@ -28,6 +31,7 @@ type listOpts struct {
 	full     bool
 	size     int
 	rsMap    string
+	around   string
 }
 
 type restoreStatus struct {
@@ -39,6 +43,7 @@ type restoreStatus struct {
 	PointInTime      int64           `json:"point-in-time,omitempty"`
 	Name             string          `json:"name,omitempty"`
 	Namespaces       []string        `json:"namespaces,omitempty"`
+	SingleRS         string          `json:"singleRS,omitempty"`
 	Error            string          `json:"error,omitempty"`
 }
 
@@ -65,6 +70,9 @@ func (r restoreListOut) String() string {
 			if util.IsSelective(v.Namespaces) {
 				t += ", selective"
 			}
+			if v.SingleRS != "" {
+				t += ", partial: " + v.SingleRS
+			}
 			name = fmt.Sprintf("%s [backup: %s]", v.Name, t)
 		case restoreReplay:
 			name = fmt.Sprintf("Oplog Replay: %v - %v",
@@ -83,6 +91,8 @@ func (r restoreListOut) String() string {
 			rprint = fmt.Sprintf("%s\t%s", name, v.Status)
 		case defs.StatusError:
 			rprint = fmt.Sprintf("%s\tFailed with \"%s\"", name, v.Error)
+		case defs.StatusAborted:
+			rprint = fmt.Sprintf("%s\tAborted with \"%s\"", name, v.Error)
 		default:
 			rprint = fmt.Sprintf("%s\tIn progress [%s] (Launched at %s)",
 				name, v.Status, time.Unix(v.StartTS, 0).Format(time.RFC3339))
@@ -109,6 +119,12 @@ func runList(ctx context.Context, conn connect.Client, pbm *sdk.Client, l *listO
 		return outMsg{msg}, nil
 	}
 
+	if l.around != "" {
+		if !l.restore {
+			return nil, errors.New("--around is allowed with --restore only")
+		}
+		return pitrAround(ctx, conn, l.around)
+	}
 	if l.restore {
 		return restoreList(ctx, conn, pbm, int64(l.size))
 	}
@@ -173,6 +189,7 @@ func restoreList(ctx context.Context, conn connect.Client, pbm *sdk.Client, limi
 			PointInTime:      r.PITR,
 			Name:             r.Name,
 			Namespaces:       r.Namespaces,
+			SingleRS:         r.SingleRS,
 			Error:            r.Error,
 		}
 
@@ -191,19 +208,66 @@ func restoreList(ctx context.Context, conn connect.Client, pbm *sdk.Client, limi
 }
 
 type backupListOut struct {
-	Snapshots []snapshotStat `json:"snapshots"`
+	Snapshots []snapshotListStat `json:"snapshots"`
 	PITR      struct {
 		On       bool                   `json:"on"`
 		Ranges   []pitrRange            `json:"ranges"`
 		RsRanges map[string][]pitrRange `json:"rsRanges,omitempty"`
+
+		// Replsets are valid timelines of each replset.
+		Replsets map[string][]timeRange `json:"replsets"`
+		// Merged are cluster-wide timelines (the same as Ranges).
+		Merged []timeRange `json:"merged"`
 	} `json:"pitr"`
 }
 
+// snapshotListStat is the snapshot in `pbm list` output.
+// Values unknown for legacy backups are null rather than omitted.
+type snapshotListStat struct {
+	snapshotStat
+
+	// ConsistentAt is the cluster time the snapshot restores to
+	ConsistentAt   primitive.Timestamp       `json:"consistentAt"`
+	CompletedTS    *int64                    `json:"completedTS"`
+	Compression    *compress.CompressionType `json:"compression"`
+	TotalSize      *int64                    `json:"totalSize"`
+	StorageProfile *string                   `json:"storageProfile"`
+	Replsets       []rsListStat              `json:"replsets"`
+	PITRBase       bool                      `json:"pitrBase"`
+	// Hold is set if the snapshot is protected from the deletion
+	Hold *backup.BackupHold `json:"hold,omitempty"`
+	// Orphaned is set if the snapshot isn't on the storage since it's changed
+	Orphaned *backup.Orphaned `json:"orphaned,omitempty"`
+	// Unrestorable is set if the agents run a lower PBM version than
+	// the snapshot requires
+	Unrestorable string `json:"unrestorable,omitempty"`
+}
+
+// listTS is the time the snapshot is listed by. The aborted snapshots
+// have no restore time, they are listed by the time they were canceled.
+func (s *snapshotListStat) listTS() int64 {
+	if s.Status != defs.StatusDone && s.Status != defs.StatusOrphaned && s.CompletedTS != nil {
+		return *s.CompletedTS
+	}
+	return s.RestoreTS
+}
+
+type rsListStat struct {
+	Name string `json:"name"`
+	// Size is known only for physical backups
+	Size *int64 `json:"size"`
+}
+
+type timeRange struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
 func (bl backupListOut) String() string {
 	s := fmt.Sprintln("Backup snapshots:")
 
 	sort.Slice(bl.Snapshots, func(i, j int) bool {
-		return bl.Snapshots[i].RestoreTS < bl.Snapshots[j].RestoreTS
+		return bl.Snapshots[i].listTS() < bl.Snapshots[j].listTS()
 	})
 	for i := range bl.Snapshots {
 		b := &bl.Snapshots[i]
@@ -213,10 +277,38 @@ func (bl backupListOut) String() string {
 		} else if b.Type == defs.IncrementalBackup && b.SrcBackup == "" {
 			t += ", base"
 		}
+		if b.SingleRS != "" {
+			t += ", partial: " + b.SingleRS
+		}
+		if len(b.ExcludedDBs) != 0 {
+			t += ", partial, excluded: " + strings.Join(b.ExcludedDBs, ",")
+		}
 		if b.StoreName != "" {
 			t += ", *"
 		}
-		s += fmt.Sprintf("  %s <%s> [restore_to_time: %s]\n", b.Name, t, fmtTS(int64(b.RestoreTS)))
+		if b.Warnings != 0 {
+			t += fmt.Sprintf(", %d warnings", b.Warnings)
+		}
+		if b.Status == defs.StatusOrphaned {
+			s += fmt.Sprintf("  %s <%s> [!orphaned (storage changed)", b.Name, t)
+			if b.Orphaned != nil {
+				s += ": " + b.Orphaned.String()
+			}
+			s += fmt.Sprintf("] [restore_to_time: %s]\n", fmtTS(int64(b.RestoreTS)))
+			continue
+		}
+		if b.Status != defs.StatusDone {
+			s += fmt.Sprintf("  %s <%s> [!canceled: %s] [%s]\n", b.Name, t, b.ErrString, fmtTS(b.listTS()))
+			continue
+		}
+		s += fmt.Sprintf("  %s <%s> [restore_to_time: %s]", b.Name, t, fmtTS(int64(b.RestoreTS)))
+		if b.Hold != nil {
+			s += fmt.Sprintf(" [hold: %s]", b.Hold)
+		}
+		if b.Unrestorable != "" {
+			s += fmt.Sprintf(" [!%s]", b.Unrestorable)
+		}
+		s += "\n"
 	}
 	if bl.PITR.On {
 		s += fmt.Sprintln("\nPITR <on>:")
@@ -258,11 +350,31 @@ func backupList(
 	if err != nil {
 		return list, errors.Wrap(err, "get snapshots")
 	}
-	list.PITR.Ranges, list.PITR.RsRanges, err = getPitrList(ctx, conn, size, full, unbacked, rsMap)
+	var rsTimelines map[string][]oplog.Timeline
+	list.PITR.Ranges, rsTimelines, err = getPitrList(ctx, conn, size, unbacked, rsMap)
 	if err != nil {
 		return list, errors.Wrap(err, "get PITR ranges")
 	}
 
+	list.PITR.Replsets = make(map[string][]timeRange, len(rsTimelines))
+	for rs, tlns := range rsTimelines {
+		list.PITR.Replsets[rs] = makeTimeRanges(tlns)
+	}
+	if full {
+		list.PITR.RsRanges = make(map[string][]pitrRange, len(rsTimelines))
+		for rs, tlns := range rsTimelines {
+			for _, tln := range tlns {
+				list.PITR.RsRanges[rs] = append(list.PITR.RsRanges[rs], pitrRange{Range: tln})
+			}
+		}
+	}
+
+	merged := make([]oplog.Timeline, len(list.PITR.Ranges))
+	for i, r := range list.PITR.Ranges {
+		merged[i] = r.Range
+	}
+	list.PITR.Merged = makeTimeRanges(merged)
+
 	list.PITR.On, _, err = config.IsPITREnabled(ctx, conn)
 	if err != nil {
 		return list, errors.Wrap(err, "check if PITR is on")
@@ -276,7 +388,7 @@ func getSnapshotList(
 	conn connect.Client,
 	size int,
 	rsMap map[string]string,
-) ([]snapshotStat, error) {
+) ([]snapshotListStat, error) {
 	bcps, err := backup.BackupsList(ctx, conn, int64(size))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get backups list")
@@ -305,38 +417,108 @@ func getSnapshotList(
 	// which the `confsrv` param in `bcpMatchCluster` is all about
 	bcpsMatchCluster(bcps, ver.VersionString, fcv, shards, inf.SetName, rsMap)
 
-	var s []snapshotStat
+	agents, err := topo.ListAgentStatuses(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get agents")
+	}
+	agentVers := agentVersions(agents)
+
+	s := []snapshotListStat{}
 	for i := len(bcps) - 1; i >= 0; i-- {
-		b := bcps[i]
+		b := &bcps[i]
 
-		if b.Status != defs.StatusDone {
+		// backups aborted by the time limit are listed with the reason
+		if b.Status != defs.StatusDone && b.Status != defs.StatusOrphaned && !b.WindowExceeded() {
 			continue
 		}
 
-		s = append(s, snapshotStat{
-			Name:       b.Name,
-			Namespaces: b.Namespaces,
-			Status:     b.Status,
-			RestoreTS:  int64(b.LastWriteTS.T),
-			PBMVersion: b.PBMVersion,
-			Type:       b.Type,
-			SrcBackup:  b.SrcBackup,
-			StoreName:  b.Store.Name,
-		})
+		stat := makeSnapshotListStat(b)
+		if b.Status == defs.StatusDone {
+			if err := backup.CheckRestoreVersion(b, agentVers...); err != nil {
+				stat.Unrestorable = err.Error()
+			}
+		}
+		s = append(s, stat)
 	}
 
 	return s, nil
 }
 
+func makeSnapshotListStat(b *backup.BackupMeta) snapshotListStat {
+	rv := snapshotListStat{
+		snapshotStat: snapshotStat{
+			Name:        b.Name,
+			Namespaces:  b.Namespaces,
+			SingleRS:    b.SingleRS,
+			ExcludedDBs: b.ExcludedDBs,
+			Status:      b.Status,
+			RestoreTS:   int64(b.LastWriteTS.T),
+			PBMVersion:  b.PBMVersion,
+			Type:        b.Type,
+			SrcBackup:   b.SrcBackup,
+			StoreName:   b.Store.Name,
+		},
+		ConsistentAt: b.LastWriteTS,
+		Replsets:     make([]rsListStat, len(b.Replsets)),
+		Hold:         b.Hold,
+		Orphaned:     b.Orphaned,
+		// the same as backup.GetLastBackup() looks for
+		PITRBase: b.Status == defs.StatusDone &&
+			b.IsPITRBase() &&
+			b.Type != defs.ExternalBackup &&
+			!b.Store.IsProfile,
+	}
+
+	if b.LastTransitionTS != 0 {
+		rv.CompletedTS = &b.LastTransitionTS
+	}
+	if b.Status != defs.StatusDone {
+		rv.ErrString = b.Err
+	}
+	if b.Consistency != nil {
+		rv.Warnings = len(b.Consistency.Issues)
+	}
+	if b.Compression != "" {
+		rv.Compression = &b.Compression
+	}
+	if b.Size != 0 {
+		rv.TotalSize = &b.Size
+	}
+	if b.Store.IsProfile {
+		rv.StorageProfile = &b.Store.Name
+	}
+
+	for i := range b.Replsets {
+		rs := &b.Replsets[i]
+		rv.Replsets[i].Name = rs.Name
+		if (b.Type != defs.PhysicalBackup && b.Type != defs.IncrementalBackup) || len(rs.Files) == 0 {
+			continue
+		}
+
+		size, _ := getLegacyPhysSize([]backup.BackupReplset{*rs})
+		rv.Replsets[i].Size = &size
+	}
+
+	return rv
+}
+
+func makeTimeRanges(tlns []oplog.Timeline) []timeRange {
+	rv := make([]timeRange, len(tlns))
+	for i, t := range tlns {
+		rv[i] = timeRange{From: t.Start, To: t.End}
+	}
+
+	return rv
+}
+
 // getPitrList shows only chunks derived from `Done` and compatible version's backups
 func getPitrList(
 	ctx context.Context,
 	conn connect.Client,
 	size int,
-	full,
 	unbacked bool,
 	rsMap map[string]string,
-) ([]pitrRange, map[string][]pitrRange, error) {
+) ([]pitrRange, map[string][]oplog.Timeline, error) {
 	inf, err := topo.GetNodeInfoExt(ctx, conn.MongoClient())
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "define cluster state")
@@ -353,7 +535,7 @@ func getPitrList(
 	}
 
 	mapRevRS := util.MakeReverseRSMapFunc(rsMap)
-	rsRanges := make(map[string][]pitrRange)
+	rsTimelines := make(map[string][]oplog.Timeline)
 	var rstlines [][]oplog.Timeline
 	for _, s := range shards {
 		tlns, err := oplog.PITRGetValidTimelines(ctx, conn, mapRevRS(s.RS), now)
@@ -369,13 +551,7 @@ func getPitrList(
 			tlns = tlns[len(tlns)-size:]
 		}
 
-		if full {
-			var rsrng []pitrRange
-			for _, tln := range tlns {
-				rsrng = append(rsrng, pitrRange{Range: tln})
-			}
-			rsRanges[s.RS] = rsrng
-		}
+		rsTimelines[s.RS] = tlns
 		rstlines = append(rstlines, tlns)
 	}
 
@@ -401,7 +577,7 @@ func getPitrList(
 		}
 	}
 
-	return ranges, rsRanges, nil
+	return ranges, rsTimelines, nil
 }
 
 func getBaseSnapshotLastWrite(
@@ -470,3 +646,106 @@ func splitByBaseSnapshot(lastWrite primitive.Timestamp, tl oplog.Timeline) []pit
 
 	return ranges
 }
+
+type pitrAroundOut struct {
+	TS   string `json:"ts"`
+	Time string `json:"time"`
+	restore.PITRCheck
+
+	// Replsets are valid timelines of each replset around the time:
+	// the one that contains it or the nearest before and after.
+	Replsets map[string][]timeRange `json:"replsets"`
+}
+
+func (o pitrAroundOut) String() string {
+	s := fmt.Sprintf("Restore coverage around %s <%s>:\n", o.Time, o.TS)
+	s += "  " + strings.ReplaceAll(o.PITRCheck.String(), "\n", "\n  ") + "\n"
+
+	if len(o.Replsets) != 0 {
+		s += "\nReplsets oplog coverage:\n"
+	}
+	rss := make([]string, 0, len(o.Replsets))
+	for rs := range o.Replsets {
+		rss = append(rss, rs)
+	}
+	sort.Strings(rss)
+	for _, rs := range rss {
+		ranges := make([]string, len(o.Replsets[rs]))
+		for i, r := range o.Replsets[rs] {
+			ranges[i] = fmtTS(int64(r.From)) + " - " + fmtTS(int64(r.To))
+		}
+		if len(ranges) == 0 {
+			ranges = append(ranges, "no chunks")
+		}
+		s += fmt.Sprintf("  %s: %s\n", rs, strings.Join(ranges, ", "))
+	}
+
+	return s
+}
+
+// pitrAround shows whether the time is restorable, the nearest valid times
+// and oplog coverage of each replset near the time.
+func pitrAround(ctx context.Context, conn connect.Client, around string) (fmt.Stringer, error) {
+	ts, err := parseTS(around)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --around")
+	}
+
+	windows, err := restore.GetPITRWindows(ctx, conn, "")
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster members")
+	}
+	now, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	rv := pitrAroundOut{
+		TS:        fmt.Sprintf("%d,%d", ts.T, ts.I),
+		Time:      fmtTS(int64(ts.T)),
+		PITRCheck: restore.CheckPITRWindows(ts, windows, ""),
+		Replsets:  make(map[string][]timeRange, len(shards)),
+	}
+	for _, s := range shards {
+		tlns, err := oplog.PITRGetValidTimelines(ctx, conn, s.RS, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get PITR timelines for %s replset", s.RS)
+		}
+		rv.Replsets[s.RS] = makeTimeRanges(timelinesAround(ts.T, tlns))
+	}
+
+	return rv, nil
+}
+
+// timelinesAround returns the timeline that contains t or
+// the nearest ones before and after t.
+func timelinesAround(t uint32, tlns []oplog.Timeline) []oplog.Timeline {
+	var before, after *oplog.Timeline
+	for i := range tlns {
+		tl := &tlns[i]
+		switch {
+		case tl.Start <= t && t <= tl.End:
+			return []oplog.Timeline{*tl}
+		case tl.End < t:
+			if before == nil || tl.End > before.End {
+				before = tl
+			}
+		case after == nil || tl.Start < after.Start:
+			after = tl
+		}
+	}
+
+	rv := []oplog.Timeline{}
+	if before != nil {
+		rv = append(rv, *before)
+	}
+	if after != nil {
+		rv = append(rv, *after)
+	}
+	return rv
+}
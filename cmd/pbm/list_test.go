@@ -1,11 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 )
 
@@ -105,3 +112,174 @@ func check(t *testing.T, got, want []pitrRange) {
 		t.Errorf("got: %v, want: %v", got, want)
 	}
 }
+
+// TestBackupListJSONSchema guards `pbm list --out json` fields. Tools parse
+// them, so fields must not disappear even if values are unknown.
+func TestBackupListJSONSchema(t *testing.T) {
+	legacy := backup.BackupMeta{
+		Name:     "2020-01-01T00:00:00Z",
+		Type:     defs.LogicalBackup,
+		Status:   defs.StatusDone,
+		Replsets: []backup.BackupReplset{{Name: "rs1"}},
+	}
+	phys := backup.BackupMeta{
+		Name:             "2024-01-01T00:00:00Z",
+		Type:             defs.PhysicalBackup,
+		Status:           defs.StatusDone,
+		Compression:      "s2",
+		Size:             30,
+		LastTransitionTS: 1704067300,
+		Store:            backup.Storage{Name: "remote", IsProfile: true},
+		Replsets: []backup.BackupReplset{{
+			Name:  "rs1",
+			Files: []backup.File{{StgSize: 10}, {StgSize: 20}},
+		}},
+	}
+
+	var out backupListOut
+	out.Snapshots = []snapshotListStat{makeSnapshotListStat(&legacy), makeSnapshotListStat(&phys)}
+	out.PITR.Ranges = []pitrRange{{Range: oplog.Timeline{Start: 1, End: 5}}}
+	out.PITR.Replsets = map[string][]timeRange{"rs1": {{From: 1, To: 5}}}
+	out.PITR.Merged = []timeRange{{From: 1, To: 5}}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Snapshots []map[string]any `json:"snapshots"`
+		PITR      map[string]any   `json:"pitr"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(m map[string]any) []string {
+		rv := make([]string, 0, len(m))
+		for k := range m {
+			rv = append(rv, k)
+		}
+		sort.Strings(rv)
+		return rv
+	}
+
+	wantSnapshot := []string{
+		"completedTS", "compression", "consistentAt", "name", "pbmVersion", "pitrBase", "replsets",
+		"restoreTo", "src", "status", "storageProfile", "totalSize", "type",
+	}
+	for _, s := range got.Snapshots {
+		k := keys(s)
+		// optional legacy fields
+		k = slices.DeleteFunc(k, func(f string) bool { return f == "storage" })
+		if !reflect.DeepEqual(k, wantSnapshot) {
+			t.Errorf("snapshot %v fields:\ngot:  %v\nwant: %v", s["name"], k, wantSnapshot)
+		}
+	}
+	if !reflect.DeepEqual(keys(got.PITR), []string{"merged", "on", "ranges", "replsets"}) {
+		t.Errorf("pitr fields: got %v", keys(got.PITR))
+	}
+
+	l := got.Snapshots[0]
+	for _, f := range []string{"compression", "completedTS", "totalSize", "storageProfile"} {
+		if l[f] != nil {
+			t.Errorf("legacy %s: got %v, want null", f, l[f])
+		}
+	}
+	if rs := l["replsets"].([]any)[0].(map[string]any); rs["size"] != nil {
+		t.Errorf("legacy replset size: got %v, want null", rs["size"])
+	}
+
+	p := got.Snapshots[1]
+	if p["totalSize"] != float64(30) || p["storageProfile"] != "remote" || p["pitrBase"] != false {
+		t.Errorf("physical: got %v", p)
+	}
+	if rs := p["replsets"].([]any)[0].(map[string]any); rs["size"] != float64(30) {
+		t.Errorf("physical replset size: got %v, want 30", rs["size"])
+	}
+	if l["pitrBase"] != true {
+		t.Errorf("legacy pitrBase: got %v, want true", l["pitrBase"])
+	}
+}
+
+func TestBackupListWindowExceeded(t *testing.T) {
+	done := backup.BackupMeta{
+		Name:        "2024-01-01T00:00:00Z",
+		Type:        defs.LogicalBackup,
+		Status:      defs.StatusDone,
+		LastWriteTS: primitive.Timestamp{T: 1704067300},
+	}
+	aborted := backup.BackupMeta{
+		Name:             "2024-01-02T00:00:00Z",
+		Type:             defs.LogicalBackup,
+		Status:           defs.StatusCancelled,
+		Err:              backup.WindowError{MaxDuration: time.Hour}.Error(),
+		LastWriteTS:      primitive.Timestamp{T: 1, I: 1},
+		LastTransitionTS: 1704157200,
+	}
+
+	var out backupListOut
+	out.Snapshots = []snapshotListStat{makeSnapshotListStat(&aborted), makeSnapshotListStat(&done)}
+
+	want := "Backup snapshots:\n" +
+		"  2024-01-01T00:00:00Z <logical> [restore_to_time: 2024-01-01T00:01:40Z]\n" +
+		"  2024-01-02T00:00:00Z <logical> [!canceled: aborted: window exceeded (max duration 1h0m0s)] " +
+		"[2024-01-02T01:00:00Z]\n"
+	if got := out.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if out.Snapshots[1].PITRBase {
+		t.Error("aborted backup is a PITR base")
+	}
+}
+
+func TestBackupListOrphaned(t *testing.T) {
+	orphaned := backup.BackupMeta{
+		Name:             "2024-01-01T00:00:00Z",
+		Type:             defs.LogicalBackup,
+		Status:           defs.StatusOrphaned,
+		LastWriteTS:      primitive.Timestamp{T: 1704067300},
+		LastTransitionTS: 1704067400,
+		Orphaned: &backup.Orphaned{
+			Status:   defs.StatusDone,
+			Location: "s3://s3.amazonaws.com/old",
+			Reason:   "no metadata file on the storage s3://s3.amazonaws.com/new",
+			At:       1704157200,
+		},
+	}
+
+	var out backupListOut
+	out.Snapshots = []snapshotListStat{makeSnapshotListStat(&orphaned)}
+
+	want := "Backup snapshots:\n" +
+		"  2024-01-01T00:00:00Z <logical> [!orphaned (storage changed): last held by the main storage " +
+		"s3://s3.amazonaws.com/old: no metadata file on the storage s3://s3.amazonaws.com/new " +
+		"(since 2024-01-02T01:00:00Z)] [restore_to_time: 2024-01-01T00:01:40Z]\n"
+	if got := out.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if out.Snapshots[0].PITRBase {
+		t.Error("orphaned backup is a PITR base")
+	}
+}
+
+func TestBackupListUnrestorable(t *testing.T) {
+	bcp := backup.BackupMeta{
+		Name:          "2024-01-01T00:00:00Z",
+		Type:          defs.LogicalBackup,
+		Status:        defs.StatusDone,
+		LastWriteTS:   primitive.Timestamp{T: 1704067300},
+		MinPBMVersion: "2.8.0",
+	}
+
+	var out backupListOut
+	out.Snapshots = []snapshotListStat{makeSnapshotListStat(&bcp)}
+	out.Snapshots[0].Unrestorable = backup.CheckRestoreVersion(&bcp, "2.7.1").Error()
+
+	want := "Backup snapshots:\n" +
+		"  2024-01-01T00:00:00Z <logical> [restore_to_time: 2024-01-01T00:01:40Z] " +
+		"[!backup requires PBM >= 2.8.0, agents run 2.7.1]\n"
+	if got := out.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -6,15 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
@@ -46,6 +50,7 @@ const (
 
 type logsOpts struct {
 	tail     int64
+	rs       string
 	node     string
 	severity string
 	event    string
@@ -74,7 +79,10 @@ type pbmApp struct {
 func main() {
 	app := newPbmApp()
 	if err := app.rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		if app.rootCmd.SilenceErrors {
+			exitErr(err, app.pbmOutF)
+		}
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -102,7 +110,7 @@ func (app *pbmApp) validateEnum(fieldName, value string, valid []string) error {
 		}
 	}
 
-	return errors.New(fmt.Sprintf("invalid %s value: %q (must be one of %v)", fieldName, value, valid))
+	return invalidArg(errors.New(fmt.Sprintf("invalid %s value: %q (must be one of %v)", fieldName, value, valid)))
 }
 
 func newPbmApp() *pbmApp {
@@ -116,6 +124,9 @@ func newPbmApp() *pbmApp {
 		PersistentPostRunE: app.persistentPostRun,
 		SilenceUsage:       true,
 	}
+	app.rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return invalidArg(err)
+	})
 
 	app.rootCmd.PersistentFlags().String(
 		mongoConnFlag,
@@ -128,9 +139,17 @@ func newPbmApp() *pbmApp {
 	app.rootCmd.PersistentFlags().StringP("out", "o", string(outText), "Output format <text>/<json>")
 	_ = viper.BindPFlag("out", app.rootCmd.PersistentFlags().Lookup("out"))
 
+	app.rootCmd.PersistentFlags().Bool("log-json", false,
+		"Write own log messages (warnings, errors) to stderr as JSON lines")
+	_ = viper.BindPFlag("log-json", app.rootCmd.PersistentFlags().Lookup("log-json"))
+	_ = viper.BindEnv("log-json", "PBM_LOG_JSON")
+
+	app.rootCmd.AddCommand(app.buildAuditCmd())
 	app.rootCmd.AddCommand(app.buildBackupCmd())
 	app.rootCmd.AddCommand(app.buildBackupFinishCmd())
+	app.rootCmd.AddCommand(app.buildCancelCmd())
 	app.rootCmd.AddCommand(app.buildCancelBackupCmd())
+	app.rootCmd.AddCommand(app.buildCancelRestoreCmd())
 	app.rootCmd.AddCommand(app.buildConfigCmd())
 	app.rootCmd.AddCommand(app.buildCleanupCmd())
 	app.rootCmd.AddCommand(app.buildConfigProfileCmd())
@@ -139,12 +158,17 @@ func newPbmApp() *pbmApp {
 	app.rootCmd.AddCommand(app.buildDescBackupCmd())
 	app.rootCmd.AddCommand(app.buildDescRestoreCmd())
 	app.rootCmd.AddCommand(app.buildDiagnosticCmd())
+	app.rootCmd.AddCommand(app.buildForceUnlockCmd())
 	app.rootCmd.AddCommand(app.buildListCmd())
 	app.rootCmd.AddCommand(app.buildLogCmd())
+	app.rootCmd.AddCommand(app.buildPitrCmd())
 	app.rootCmd.AddCommand(app.buildRestoreCmd())
 	app.rootCmd.AddCommand(app.buildReplayCmd())
 	app.rootCmd.AddCommand(app.buildRestoreFinishCmd())
+	app.rootCmd.AddCommand(app.buildRestoreFinalizeCmd())
+	app.rootCmd.AddCommand(app.buildRestoreIndexesCmd())
 	app.rootCmd.AddCommand(app.buildStatusCmd())
+	app.rootCmd.AddCommand(app.buildStorageCmd())
 	app.rootCmd.AddCommand(app.buildVersionCmd())
 
 	return app
@@ -152,6 +176,8 @@ func newPbmApp() *pbmApp {
 
 func (app *pbmApp) persistentPreRun(cmd *cobra.Command, args []string) error {
 	app.pbmOutF = outFormat(viper.GetString("out"))
+	// the errors of JSON output are printed by exitErr
+	cmd.Root().SilenceErrors = app.pbmOutF == outJSON || app.pbmOutF == outJSONpretty
 
 	if cmd.Name() == "help" || cmd.Name() == "version" {
 		return nil
@@ -166,7 +192,8 @@ func (app *pbmApp) persistentPreRun(cmd *cobra.Command, args []string) error {
 		)
 	}
 
-	if viper.GetString("describe-restore.config") != "" || viper.GetString("restore-finish.config") != "" {
+	if viper.GetString("describe-restore.config") != "" || viper.GetString("restore-finish.config") != "" ||
+		viper.GetString("cancel-restore.config") != "" {
 		return nil
 	}
 
@@ -175,7 +202,8 @@ func (app *pbmApp) persistentPreRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		exitErr(errors.Wrap(err, "connect to mongodb"), app.pbmOutF)
 	}
-	app.ctx = log.SetLoggerToContext(app.ctx, log.New(app.conn, "", ""))
+	logger := log.NewWithOpts(app.conn, "", "", &log.Opts{LogJSON: viper.GetBool("log-json")})
+	app.ctx = log.SetLoggerToContext(app.ctx, logger)
 
 	ver, err := version.GetMongoVersion(app.ctx, app.conn.MongoClient())
 	if err != nil {
@@ -226,6 +254,27 @@ func (app *pbmApp) persistentPostRun(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func (app *pbmApp) buildAuditCmd() *cobra.Command {
+	opts := auditOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show operations with their initiators",
+		Long: "Show operations with their initiators, the newest first.\n\n" +
+			"The operations are read from the commands stream (the oldest commands may be gone " +
+			"as the stream is capped) and the config history. The initiator is the authenticated " +
+			"MongoDB user, the OS user and host of the client and its version.",
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return runAudit(app.ctx, app.conn, &opts)
+		}),
+	}
+
+	cmd.Flags().StringVar(&opts.since, "since", "7d",
+		"Show operations started within the duration (e.g. 7d, 12h)")
+
+	return cmd
+}
+
 func (app *pbmApp) buildBackupCmd() *cobra.Command {
 	validCompressions := []string{
 		string(compress.CompressionTypeNone),
@@ -235,6 +284,7 @@ func (app *pbmApp) buildBackupCmd() *cobra.Command {
 		string(compress.CompressionTypeS2),
 		string(compress.CompressionTypePGZIP),
 		string(compress.CompressionTypeZstandard),
+		string(compress.CompressionTypeAuto),
 	}
 
 	validBackupTypes := []string{
@@ -265,7 +315,7 @@ func (app *pbmApp) buildBackupCmd() *cobra.Command {
 
 	backupCmd.Flags().StringVar(
 		&backupOptions.compression, "compression", "",
-		"Compression type <none>/<gzip>/<snappy>/<lz4>/<s2>/<pgzip>/<zstd>",
+		"Compression type <none>/<gzip>/<snappy>/<lz4>/<s2>/<pgzip>/<zstd>/<auto>",
 	)
 	backupCmd.Flags().StringVarP(
 		&backupOptions.typ, "type", "t", string(defs.LogicalBackup),
@@ -289,17 +339,127 @@ func (app *pbmApp) buildBackupCmd() *cobra.Command {
 	backupCmd.Flags().StringVar(
 		&backupOptions.ns, "ns", "", `Namespaces to backup (e.g. "db.*", "db.collection"). If not set, backup all ("*.*")`,
 	)
+	backupCmd.Flags().StringVar(
+		&backupOptions.replset, "rs", "",
+		"Backup the single replset (shard) only. The backup is marked as partial",
+	)
+	backupCmd.Flags().StringVar(
+		&backupOptions.clusterTime, "wait-for-cluster-time", "",
+		"Capture the oplog until the cluster time passes the timestamp (e.g. 1682093090,9 or 2023-04-20T13:04:50). "+
+			"Logical backups only",
+	)
+	backupCmd.Flags().DurationVar(
+		&backupOptions.clusterTimeTimeout, "wait-for-cluster-time-timeout", 10*time.Minute,
+		"Maximum wait for --wait-for-cluster-time",
+	)
+	backupCmd.Flags().DurationVar(
+		&backupOptions.maxDuration, "max-duration", 0,
+		"Abort the backup if it runs longer (e.g. 4h). Default is backup.maxDuration of the config",
+	)
+	backupCmd.Flags().BoolVar(
+		&backupOptions.forceClaim, "force-claim-storage", false,
+		"Take the storage over if it's claimed by another cluster",
+	)
+	backupCmd.Flags().BoolVar(
+		&backupOptions.queue, "queue", false,
+		"Queue the backup if another operation is running. The queued backup isn't waited for. "+
+			"Default is backup.queue.enabled of the config",
+	)
 	backupCmd.Flags().BoolVarP(
 		&backupOptions.wait, "wait", "w", false, "Wait for the backup to finish",
 	)
 	backupCmd.Flags().DurationVar(
 		&backupOptions.waitTime, "wait-time", 0, "Maximum wait time",
 	)
+	backupCmd.Flags().DurationVar(
+		&backupOptions.waitTime, "wait-timeout", 0, "Maximum wait time. Same as --wait-time",
+	)
+	backupCmd.Flags().BoolVar(
+		&backupOptions.cancelOnInterrupt, "cancel-on-interrupt", false,
+		"Cancel the backup on Ctrl-C in --wait mode. By default, only stops waiting",
+	)
 	backupCmd.Flags().BoolVarP(
 		&backupOptions.externList, "list-files", "l", false,
 		"Shows the list of files per node to copy (only for external backups)",
 	)
 
+	diffCmd := &cobra.Command{
+		Use:   "diff <backup_name_a> <backup_name_b>",
+		Short: "Compare backups metadata: namespaces, topology and options",
+		Args:  cobra.ExactArgs(2),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return diffBackup(app.ctx, app.pbm, args[0], args[1], app.node)
+		}),
+	}
+
+	backupCmd.AddCommand(diffCmd)
+
+	importOpts := importOptions{}
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Register backups of another cluster or mongodump archives from the storage folder",
+		Args:  cobra.NoArgs,
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return importBackups(app.ctx, app.conn, app.node, importOpts)
+		}),
+	}
+
+	importCmd.Flags().StringVar(&importOpts.prefix, "prefix", "", "Storage folder with the backups")
+	_ = importCmd.MarkFlagRequired("prefix")
+	importCmd.Flags().StringVar(&importOpts.profile, "profile", "",
+		"Config profile of the storage. The main storage if not set")
+	importCmd.Flags().StringVar(&importOpts.backup, "backup", "",
+		"Import only the backup. All backups of the folder if not set")
+	importCmd.Flags().StringVar(&importOpts.descriptor, "descriptor", "",
+		"YAML/JSON file describing mongodump archives of the folder")
+	importCmd.Flags().StringVar(&importOpts.cluster, "source-cluster", "",
+		"Identity of the source cluster. Replset names of the backup if not set")
+	importCmd.Flags().BoolVar(&importOpts.verify, "verify", false,
+		"Read the data files to validate their checksums")
+
+	backupCmd.AddCommand(importCmd)
+
+	verifyOpts := verifyRestoreOptions{}
+	verifyCmd := &cobra.Command{
+		Use:   "verify-restore <backup_name>",
+		Short: "Restore the backup into a temporary mongod and check the data against the backup metadata",
+		Args:  cobra.ExactArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			verifyOpts.name = args[0]
+			return verifyRestore(app.ctx, app.conn, app.pbm, verifyOpts)
+		}),
+	}
+
+	verifyCmd.Flags().IntVar(&verifyOpts.sample, "sample-namespaces", 0,
+		"Verify only the number of random collections. All collections if not set")
+	verifyCmd.Flags().BoolVarP(&verifyOpts.wait, "wait", "w", false, "Wait for the verification done")
+	verifyCmd.Flags().DurationVar(&verifyOpts.waitTime, "wait-time", 0,
+		"Maximum wait time")
+
+	backupCmd.AddCommand(verifyCmd)
+
+	holdCmd := &cobra.Command{
+		Use:   "hold <backup_name>",
+		Short: "Protect the backup from deletion by PBM (delete-backup, cleanup and retention)",
+		Args:  cobra.ExactArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return holdBackup(app.ctx, app.conn, app.node, args[0], true)
+		}),
+	}
+
+	backupCmd.AddCommand(holdCmd)
+
+	releaseCmd := &cobra.Command{
+		Use:   "release <backup_name>",
+		Short: "Release the hold of the backup",
+		Args:  cobra.ExactArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return holdBackup(app.ctx, app.conn, app.node, args[0], false)
+		}),
+	}
+
+	backupCmd.AddCommand(releaseCmd)
+
 	return backupCmd
 }
 
@@ -318,6 +478,17 @@ func (app *pbmApp) buildBackupFinishCmd() *cobra.Command {
 	}
 }
 
+func (app *pbmApp) buildCancelCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <opid>",
+		Short: "Remove the pending backup from the queue",
+		Args:  cobra.ExactArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return cancelQueued(app.ctx, app.conn, args[0])
+		}),
+	}
+}
+
 func (app *pbmApp) buildCancelBackupCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "cancel-backup",
@@ -328,6 +499,57 @@ func (app *pbmApp) buildCancelBackupCmd() *cobra.Command {
 	}
 }
 
+func (app *pbmApp) buildCancelRestoreCmd() *cobra.Command {
+	opts := cancelRestoreOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "cancel-restore [restore_name]",
+		Short: "Abort the stuck restore and clean up after it. Default restore is the last one",
+		Long: "Abort the stuck restore and clean up after it. Default restore is the last one.\n\n" +
+			"The restore is moved to the aborted state, its locks are released. For logical restores, " +
+			"the agents drop the temporary collections and start the balancer if the restore has stopped it. " +
+			"The namespaces written by the restore (logical) or the nodes with modified data directories " +
+			"(physical) are reported. Restores with a fresh heartbeat are aborted only with --force. " +
+			"Physical restores are aborted on the storage, pass the PBM config with --config.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			if len(args) == 1 {
+				opts.restore = args[0]
+			}
+			return cancelRestore(app.ctx, app.conn, &opts, app.node)
+		}),
+	}
+
+	cmd.Flags().StringVarP(&opts.cfg, "config", "c", "", "Path to PBM config (physical restore)")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Abort the logical restore even if it is alive")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation")
+	_ = viper.BindPFlag("cancel-restore.config", cmd.Flags().Lookup("config"))
+
+	return cmd
+}
+
+func (app *pbmApp) buildForceUnlockCmd() *cobra.Command {
+	opts := forceUnlockOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "force-unlock",
+		Short: "Release locks of the operation and mark it as failed",
+		Long: "Release locks of the operation and mark it as failed.\n\n" +
+			"Stale locks (the holder has stopped the heartbeat) are reclaimed by the next operation " +
+			"automatically. Use it if that doesn't happen. Locks with a fresh heartbeat are released " +
+			"only with --force.",
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return forceUnlock(app.ctx, app.conn, &opts)
+		}),
+	}
+
+	cmd.Flags().StringVar(&opts.opid, "opid", "", "Operation ID")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Release locks even if the operation is alive")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation")
+
+	return cmd
+}
+
 func (app *pbmApp) buildCleanupCmd() *cobra.Command {
 	cleanupOpts := cleanupOptions{}
 
@@ -355,6 +577,19 @@ func (app *pbmApp) buildCleanupCmd() *cobra.Command {
 	deletePitrCmd.Flags().BoolVar(
 		&cleanupOpts.dryRun, "dry-run", false, "Report but do not delete",
 	)
+	deletePitrCmd.Flags().BoolVar(
+		&cleanupOpts.orphaned, "orphaned", false,
+		"Find storage files no metadata refers to, dangling metadata and incomplete uploads",
+	)
+	deletePitrCmd.Flags().BoolVar(
+		&cleanupOpts.confirm, "confirm", false,
+		"Delete orphaned files and uploads, mark dangling backups as failed and "+
+			"delete dangling PITR chunks metadata (with --orphaned)",
+	)
+	deletePitrCmd.Flags().DurationVar(
+		&cleanupOpts.grace, "grace", 24*time.Hour,
+		"Never touch files and uploads newer than the duration (with --orphaned)",
+	)
 
 	return deletePitrCmd
 }
@@ -379,6 +614,14 @@ func (app *pbmApp) buildConfigCmd() *cobra.Command {
 	configCmd.Flags().StringToStringVar(&cfg.set, "set", nil, "Set the option value <key.name=value>")
 	configCmd.Flags().BoolVarP(&cfg.wait, "wait", "w", false, "Wait for finish")
 	configCmd.Flags().DurationVar(&cfg.waitTime, "wait-time", 0, "Maximum wait time")
+	configCmd.Flags().BoolVar(&cfg.dryRun, "dry-run", false,
+		"Validate --set, --file or --rollback and show the changes without applying them")
+	configCmd.Flags().BoolVar(&cfg.history, "history", false, "Show the history of config changes")
+	configCmd.Flags().Int64Var(&cfg.limit, "limit", 10, "Number of history entries to show (0 for all)")
+	configCmd.Flags().Int64Var(&cfg.rollback, "rollback", 0,
+		"Apply the config of the version from --history again")
+	configCmd.Flags().BoolVar(&cfg.forceClaim, "force-claim-storage", false,
+		"Take the storage over if it's claimed by another cluster (with --force-resync, --file, --set or --rollback)")
 
 	return configCmd
 }
@@ -472,7 +715,7 @@ func (app *pbmApp) buildConfigProfileCmd() *cobra.Command {
 		Short: "Sync backup list from configuration profile",
 		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
 			if len(args) == 1 {
-				removeConfigProfileOpts.name = args[0]
+				syncConfigProfileOpts.name = args[0]
 			}
 			return handleSyncConfigProfile(app.ctx, app.pbm, syncConfigProfileOpts)
 		}),
@@ -484,6 +727,10 @@ func (app *pbmApp) buildConfigProfileCmd() *cobra.Command {
 	syncConfigProfileCmd.Flags().BoolVar(
 		&syncConfigProfileOpts.clear, "clear", false, "Clear backup list (can be used with profile name or --all)",
 	)
+	syncConfigProfileCmd.Flags().BoolVar(
+		&syncConfigProfileOpts.forceClaim, "force-claim-storage", false,
+		"Take the profile storage over if it's claimed by another cluster",
+	)
 	syncConfigProfileCmd.Flags().BoolVarP(
 		&syncConfigProfileOpts.wait, "wait", "w", false, "Wait for done by agents",
 	)
@@ -527,7 +774,8 @@ func (app *pbmApp) buildDeleteBackupCmd() *cobra.Command {
 
 	deleteBcpCmd.Flags().StringVar(
 		&deleteBcpOptions.olderThan, "older-than", "",
-		fmt.Sprintf("Delete backups older than date/time in format %s or %s", datetimeFormat, dateFormat),
+		fmt.Sprintf("Delete backups older than date/time in format %s, %s or RFC3339, "+
+			"or older than duration (e.g. 30d, 12h)", datetimeFormat, dateFormat),
 	)
 	deleteBcpCmd.Flags().StringVarP(
 		&deleteBcpOptions.bcpType, "type", "t", "",
@@ -545,6 +793,10 @@ func (app *pbmApp) buildDeleteBackupCmd() *cobra.Command {
 	deleteBcpCmd.Flags().BoolVar(
 		&deleteBcpOptions.dryRun, "dry-run", false, "Report but do not delete",
 	)
+	deleteBcpCmd.Flags().BoolVar(
+		&deleteBcpOptions.overrideHold, "override-hold", false,
+		"Delete the backup on hold. Requires typing the backup name to confirm",
+	)
 
 	return deleteBcpCmd
 }
@@ -572,7 +824,22 @@ func (app *pbmApp) buildDeletePitrCmd() *cobra.Command {
 		&deletePitrOptions.yes, "yes", "y", false, "Don't ask for confirmation",
 	)
 	deletePitrCmd.Flags().BoolVarP(
-		&deletePitrOptions.yes, "force", "f", false, "Don't ask for confirmation (deprecated)",
+		&deletePitrOptions.force, "force", "f", false,
+		"Don't ask for confirmation. With --rs or --from/--to, also allow to make a hole in PITR ranges "+
+			"that leaves later chunks without a base snapshot",
+	)
+	deletePitrCmd.Flags().StringVar(
+		&deletePitrOptions.rs, "rs", "", "Delete chunks of the replset only (e.g. of a decommissioned shard)",
+	)
+	deletePitrCmd.Flags().StringVar(
+		&deletePitrOptions.from, "from", "",
+		fmt.Sprintf("Delete chunks overlapping the range since date/time in format %s or %s. "+
+			"Default: the first chunk", datetimeFormat, dateFormat),
+	)
+	deletePitrCmd.Flags().StringVar(
+		&deletePitrOptions.to, "to", "",
+		fmt.Sprintf("Delete chunks overlapping the range up to date/time in format %s or %s. "+
+			"Default: now", datetimeFormat, dateFormat),
 	)
 	deletePitrCmd.Flags().BoolVarP(
 		&deletePitrOptions.wait, "wait", "w", false, "Wait for deletion done",
@@ -605,6 +872,18 @@ func (app *pbmApp) buildDescBackupCmd() *cobra.Command {
 	descBackupCmd.Flags().BoolVar(
 		&descBackup.coll, "with-collections", false, "Show collections in backup",
 	)
+	descBackupCmd.Flags().BoolVar(
+		&descBackup.files, "with-files", false,
+		"Show backup files on the storage (sizes, compression, source paths). Missed files are marked",
+	)
+	descBackupCmd.Flags().BoolVar(
+		&descBackup.verify, "verify-checksums", false,
+		"Read the backup files and verify their recorded checksums (with --with-files)",
+	)
+	descBackupCmd.Flags().BoolVar(
+		&descBackup.timings, "timings", false,
+		"Show upload statistics of the backup files (throughput, retries, storage latency)",
+	)
 
 	return descBackupCmd
 }
@@ -620,6 +899,9 @@ func (app *pbmApp) buildDescRestoreCmd() *cobra.Command {
 			if len(args) == 1 {
 				descRestoreOption.restore = args[0]
 			}
+			if descRestoreOption.watch {
+				return watchRestore(app.ctx, app.conn, descRestoreOption, app.node, app.pbmOutF)
+			}
 			return describeRestore(app.ctx, app.conn, descRestoreOption, app.node)
 		}),
 	}
@@ -627,6 +909,10 @@ func (app *pbmApp) buildDescRestoreCmd() *cobra.Command {
 	descRestoreCmd.Flags().StringVarP(
 		&descRestoreOption.cfg, "config", "c", "", "Show collections in backup",
 	)
+	descRestoreCmd.Flags().BoolVarP(
+		&descRestoreOption.watch, "watch", "w", false,
+		"Print the progress until the restore is finished (the namespaces of logical restores)",
+	)
 	_ = viper.BindPFlag("describe-restore.config", descRestoreCmd.Flags().Lookup("config"))
 
 	return descRestoreCmd
@@ -636,9 +922,16 @@ func (app *pbmApp) buildDiagnosticCmd() *cobra.Command {
 	diagnosticOpts := diagnosticOptions{}
 
 	diagnosticCmd := &cobra.Command{
-		Use:   "diagnostic",
-		Short: "Create diagnostic report",
+		Use:     "diagnostic",
+		Aliases: []string{"diagnostics"},
+		Short:   "Create diagnostic report",
 		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			if diagnosticOpts.agentsState {
+				return handleDumpAgentsState(app.ctx, app.conn)
+			}
+			if diagnosticOpts.output != "" {
+				return handleDiagnosticBundle(app.ctx, app.conn, app.pbm, app.mURL, diagnosticOpts)
+			}
 			return handleDiagnostic(app.ctx, app.pbm, diagnosticOpts)
 		}),
 	}
@@ -646,7 +939,28 @@ func (app *pbmApp) buildDiagnosticCmd() *cobra.Command {
 	diagnosticCmd.Flags().StringVar(
 		&diagnosticOpts.path, "path", "", "Path where files will be saved",
 	)
-	_ = diagnosticCmd.MarkFlagRequired("path")
+	diagnosticCmd.Flags().StringVar(
+		&diagnosticOpts.output, "output", "",
+		"Write the cluster diagnostics bundle (config, status, logs, metadata, agents, topology) "+
+			"to the tar.gz file. --opid adds all data of the operation. Credentials are redacted",
+	)
+	diagnosticCmd.MarkFlagsMutuallyExclusive("path", "output")
+	diagnosticCmd.Flags().BoolVar(
+		&diagnosticOpts.agentsState, "agents-state", false,
+		"Ask all agents to dump their internal state to the log and to a file in their temp dir",
+	)
+	diagnosticCmd.MarkFlagsMutuallyExclusive("agents-state", "path")
+	diagnosticCmd.MarkFlagsMutuallyExclusive("agents-state", "output")
+	diagnosticCmd.Flags().StringVar(
+		&diagnosticOpts.since, "since", "24h",
+		"Bundle logs and metadata since the date (e.g. 2024-01-02T15:04:05) or for the period (e.g. 24h, 7d)",
+	)
+	diagnosticCmd.Flags().Int64Var(
+		&diagnosticOpts.maxLogLines, "max-log-lines", 100000, "Maximum number of log lines in the bundle per log file",
+	)
+	diagnosticCmd.Flags().Int64Var(
+		&diagnosticOpts.maxFileSize, "max-file-size", 64<<20, "Bundle files bigger than this (in bytes) are truncated",
+	)
 
 	diagnosticCmd.Flags().BoolVar(
 		&diagnosticOpts.archive, "archive", false, "Create zip file",
@@ -676,6 +990,9 @@ func (app *pbmApp) buildListCmd() *cobra.Command {
 	listCmd.Flags().BoolVar(&listOptions.unbacked, "unbacked", false, "Show unbacked oplog ranges")
 	listCmd.Flags().BoolVarP(&listOptions.full, "full", "f", false, "Show extended restore info")
 	listCmd.Flags().IntVar(&listOptions.size, "size", 0, "Show last N backups")
+	listCmd.Flags().StringVar(&listOptions.around, "around", "",
+		fmt.Sprintf("With --restore, show PITR coverage and nearest restorable times around the time. "+
+			"Set in format %s or <T,I>", datetimeFormat))
 
 	listCmd.Flags().StringVar(&listOptions.rsMap, RSMappingFlag, "", RSMappingDoc)
 	_ = viper.BindPFlag(RSMappingFlag, listCmd.Flags().Lookup(RSMappingFlag))
@@ -694,7 +1011,8 @@ func (app *pbmApp) buildLogCmd() *cobra.Command {
 		Use:   "logs",
 		Short: "PBM logs",
 		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
-			if err := app.validateEnum("type", logOptions.severity, severityTypes); err != nil {
+			logOptions.severity = normalizeLogSeverity(logOptions.severity)
+			if err := app.validateEnum("severity", logOptions.severity, severityTypes); err != nil {
 				return nil, err
 			}
 
@@ -702,17 +1020,21 @@ func (app *pbmApp) buildLogCmd() *cobra.Command {
 		}),
 	}
 
-	logCmd.Flags().BoolVarP(&logOptions.follow, "follow", "f", false, "Follow output")
+	logCmd.Flags().BoolVarP(&logOptions.follow, "follow", "f", false,
+		"Follow output. With --out=json every entry is printed as a JSON object on a separate line",
+	)
 	logCmd.Flags().Int64VarP(&logOptions.tail, "tail", "t", 20,
 		"Show last N entries, 20 entries are shown by default, 0 for all logs",
 	)
+	logCmd.Flags().StringVar(&logOptions.rs, "rs", "", "Target replset")
 	logCmd.Flags().StringVarP(
 		&logOptions.node, "node", "n", "",
-		"Target node in format replset[/host:posrt]",
+		"Target node in format replset[/host:port] or host:port",
 	)
 	logCmd.Flags().StringVarP(
 		&logOptions.severity, "severity", "s", "I",
-		"Severity level D, I, W, E or F, low to high. Choosing one includes higher levels too.",
+		"Severity level D, I, W, E or F (debug, info, warning, error or fatal), low to high. "+
+			"Choosing one includes higher levels too.",
 	)
 	logCmd.Flags().StringVarP(
 		&logOptions.event, "event", "e", "",
@@ -739,13 +1061,14 @@ func (app *pbmApp) buildRestoreCmd() *cobra.Command {
 			if len(args) == 1 {
 				restoreOptions.bcp = args[0]
 			}
-			return runRestore(app.ctx, app.conn, app.pbm, &restoreOptions, app.node, app.pbmOutF)
+			return runRestore(app.ctx, app.conn, app.pbm, app.mURL, &restoreOptions, app.node, app.pbmOutF)
 		}),
 	}
 
 	restoreCmd.Flags().StringVar(
 		&restoreOptions.pitr, "time", "",
-		fmt.Sprintf("Restore to the point-in-time. Set in format %s", datetimeFormat),
+		fmt.Sprintf("Restore to the point-in-time. Set in format %s, "+
+			"or %q for the most recent time covered by oplog of all replsets", datetimeFormat, pitrLatest),
 	)
 	restoreCmd.Flags().StringVar(
 		&restoreOptions.pitrBase, "base-snapshot", "",
@@ -778,6 +1101,35 @@ func (app *pbmApp) buildRestoreCmd() *cobra.Command {
 		&restoreOptions.usersAndRoles, "with-users-and-roles", false,
 		"Includes users and roles for selected database (--ns flag)",
 	)
+	restoreCmd.Flags().BoolVar(
+		&restoreOptions.usersAndRolesOnly, "users-and-roles-only", false,
+		"Restore only users and roles of the backup without the data",
+	)
+	restoreCmd.Flags().StringVar(
+		&restoreOptions.usersAndRolesMode, "users-and-roles-mode", ctrl.UsersAndRolesMerge,
+		"How users and roles are restored with --users-and-roles-only: "+
+			"merge (create and update) or replace (also delete the ones not in the backup)",
+	)
+	restoreCmd.Flags().BoolVar(
+		&restoreOptions.drop, "drop", true,
+		"Drop the collections of the backup before restoring them. With --drop=false the documents "+
+			"are added to the existing collections, the ones with the taken _id are skipped. Logical restore only",
+	)
+	restoreCmd.Flags().BoolVar(
+		&restoreOptions.skipCollOptions, "skip-collection-options", false,
+		"Remove the validators of the restored collections instead of re-applying the validators "+
+			"of the backup after the data load. Logical restore only",
+	)
+	restoreCmd.Flags().BoolVar(
+		&restoreOptions.keepProfiling, "keep-profiling", false,
+		"Keep the profiling levels of the databases. By default, the profiler is turned off "+
+			"for the time of the restore and the levels are set back after it. Logical restore only",
+	)
+	restoreCmd.Flags().StringVar(
+		&restoreOptions.nsConflict, "ns-conflict", ctrl.NSConflictAbort,
+		"How the collections of the target with another UUID, options or type than in the backup "+
+			"are handled with --drop=false: abort, skip (don't restore them) or recreate (drop and restore them)",
+	)
 	restoreCmd.Flags().BoolVarP(
 		&restoreOptions.wait, "wait", "w", false, "Wait for the restore to finish",
 	)
@@ -791,11 +1143,33 @@ func (app *pbmApp) buildRestoreCmd() *cobra.Command {
 		&restoreOptions.conf, "config", "c", "",
 		"Mongod config for the source data. External backups only!",
 	)
+	restoreCmd.Flags().BoolVarP(
+		&restoreOptions.yes, "yes", "y", false, "Don't ask for confirmation of the resolved --time latest",
+	)
+	restoreCmd.Flags().BoolVar(
+		&restoreOptions.force, "force", false,
+		"Restore even if the target cluster has databases not in the backup or writes after the restore time",
+	)
 	restoreCmd.Flags().StringVar(
 		&restoreOptions.ts, "ts", "",
 		"MongoDB cluster time to restore to. In <T,I> format (e.g. 1682093090,9). External backups only!",
 	)
 
+	restoreCmd.Flags().StringVar(
+		&restoreOptions.replset, "rs", "",
+		"Restore the single replset (shard) only. The config server and other shards are untouched",
+	)
+	restoreCmd.Flags().StringVar(
+		&restoreOptions.dbpathMap, "dbpath-map", "",
+		"Dbpath of the nodes to restore the physical backup to instead of the mongod dbpath, "+
+			"e.g. \"rs1-0:27017=/mnt/data,rs1-1:27017=/data/db\"",
+	)
+
+	restoreCmd.Flags().StringVar(
+		&restoreOptions.sourceCluster, "source-cluster", "",
+		"The cluster the backup is made on. Required to restore backups of another cluster",
+	)
+
 	restoreCmd.Flags().StringVar(&restoreOptions.rsMap, RSMappingFlag, "", RSMappingDoc)
 	_ = viper.BindPFlag(RSMappingFlag, restoreCmd.Flags().Lookup(RSMappingFlag))
 	_ = viper.BindEnv(RSMappingFlag, RSMappingEnvVar)
@@ -827,6 +1201,68 @@ func (app *pbmApp) buildRestoreFinishCmd() *cobra.Command {
 	return restoreFinishCmd
 }
 
+func (app *pbmApp) buildRestoreFinalizeCmd() *cobra.Command {
+	finalizeOpts := restoreFinalizeOptions{}
+
+	restoreFinalizeCmd := &cobra.Command{
+		Use:   "restore-finalize [restore_name]",
+		Short: "Run post-restore steps. Default restore is the last one",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			if len(args) == 1 {
+				finalizeOpts.restore = args[0]
+			}
+			return restoreFinalize(app.ctx, app.conn, app.pbm, finalizeOpts)
+		}),
+	}
+
+	restoreFinalizeCmd.Flags().BoolVar(
+		&finalizeOpts.rebalance, "rebalance", false,
+		"Split and move chunks of the largest sharded collections over the shards",
+	)
+	restoreFinalizeCmd.Flags().Float64Var(
+		&finalizeOpts.skew, "skew", 10,
+		"Max data skew of a collection, the percent the largest shard exceeds the average",
+	)
+	restoreFinalizeCmd.Flags().IntVar(
+		&finalizeOpts.collections, "collections", 10, "Number of the largest sharded collections to rebalance",
+	)
+	restoreFinalizeCmd.Flags().BoolVar(
+		&finalizeOpts.cancel, "cancel", false, "Cancel the running rebalance",
+	)
+	restoreFinalizeCmd.Flags().BoolVarP(
+		&finalizeOpts.yes, "yes", "y", false, "Don't ask for confirmation",
+	)
+	restoreFinalizeCmd.Flags().BoolVarP(
+		&finalizeOpts.wait, "wait", "w", false, "Wait for the rebalance done",
+	)
+	restoreFinalizeCmd.Flags().DurationVar(
+		&finalizeOpts.waitTime, "wait-time", 0, "Maximum wait time",
+	)
+
+	return restoreFinalizeCmd
+}
+
+func (app *pbmApp) buildRestoreIndexesCmd() *cobra.Command {
+	opts := restoreIndexesOptions{}
+
+	restoreIndexesCmd := &cobra.Command{
+		Use:       "restore-indexes pause|resume [restore_name]",
+		Short:     "Pause or resume the index builds of the logical restore. Default restore is the last one",
+		Args:      cobra.RangeArgs(1, 2),
+		ValidArgs: []string{"pause", "resume"},
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			opts.action = args[0]
+			if len(args) == 2 {
+				opts.restore = args[1]
+			}
+			return restoreIndexes(app.ctx, app.conn, opts)
+		}),
+	}
+
+	return restoreIndexesCmd
+}
+
 func (app *pbmApp) buildReplayCmd() *cobra.Command {
 	replayOpts := replayOptions{}
 
@@ -853,6 +1289,16 @@ func (app *pbmApp) buildReplayCmd() *cobra.Command {
 	replayCmd.Flags().BoolVar(&replayOpts.wait, "wait", false, "Wait for the restore to finish")
 	replayCmd.Flags().DurationVar(&replayOpts.waitTime, "wait-time", 0, "Maximum wait time")
 
+	replayCmd.Flags().StringVar(
+		&replayOpts.source, "source", "",
+		"Replay oplog chunk files from the local directory on agents' hosts (file:///path) "+
+			"or from the path within the storage instead of the ones known to PBM",
+	)
+	replayCmd.Flags().BoolVar(
+		&replayOpts.allowGaps, "allow-gaps", false,
+		"Replay chunk files from --source even if they don't cover the whole range",
+	)
+
 	replayCmd.Flags().StringVar(&replayOpts.rsMap, RSMappingFlag, "", RSMappingDoc)
 	_ = viper.BindPFlag(RSMappingFlag, replayCmd.Flags().Lookup(RSMappingFlag))
 	_ = viper.BindEnv(RSMappingFlag, RSMappingEnvVar)
@@ -860,9 +1306,76 @@ func (app *pbmApp) buildReplayCmd() *cobra.Command {
 	return replayCmd
 }
 
+func (app *pbmApp) buildPitrCmd() *cobra.Command {
+	pitrCmd := &cobra.Command{
+		Use:   "pitr",
+		Short: "PITR oplog chunks operations",
+	}
+
+	verifyOpts := pitrVerifyOptions{}
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Make an agent check integrity of PITR oplog chunks in the storage",
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return verifyPITR(app.ctx, app.conn, app.pbm, verifyOpts)
+		}),
+	}
+
+	verifyCmd.Flags().StringVar(
+		&verifyOpts.from, "from", "",
+		fmt.Sprintf("Verify chunks from the time. Set in format %s. "+
+			"Default: from the end of the previously verified range", datetimeFormat),
+	)
+	verifyCmd.Flags().StringVar(
+		&verifyOpts.to, "to", "",
+		fmt.Sprintf("Verify chunks up to the time. Set in format %s", datetimeFormat),
+	)
+	verifyCmd.Flags().DurationVar(
+		&verifyOpts.waitTime, "wait-time", 0, "Maximum wait time",
+	)
+
+	pitrCmd.AddCommand(verifyCmd)
+
+	compactOpts := pitrCompactOptions{}
+	compactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Merge old contiguous PITR oplog chunks into bigger ones",
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return compactPITR(app.ctx, app.conn, app.pbm, compactOpts)
+		}),
+	}
+
+	compactCmd.Flags().StringVar(
+		&compactOpts.olderThan, "older-than", "",
+		fmt.Sprintf("Compact chunks ended before the time. Set in format %s or duration (e.g. 7d)", datetimeFormat),
+	)
+	compactCmd.Flags().Int64Var(
+		&compactOpts.maxSizeMB, "max-size-mb", 1024, "Max size of the merged chunk in megabytes. 0 - no limit",
+	)
+	compactCmd.Flags().DurationVar(
+		&compactOpts.maxSpan, "max-span", 6*time.Hour, "Max time range of the merged chunk. 0 - no limit",
+	)
+	compactCmd.Flags().BoolVarP(
+		&compactOpts.yes, "yes", "y", false, "Don't ask for confirmation",
+	)
+	compactCmd.Flags().BoolVarP(
+		&compactOpts.wait, "wait", "w", false, "Wait for compaction done",
+	)
+	compactCmd.Flags().DurationVar(
+		&compactOpts.waitTime, "wait-time", 0, "Maximum wait time",
+	)
+	compactCmd.Flags().BoolVar(
+		&compactOpts.dryRun, "dry-run", false, "Report but do not compact",
+	)
+
+	pitrCmd.AddCommand(compactCmd)
+
+	return pitrCmd
+}
+
 func (app *pbmApp) buildStatusCmd() *cobra.Command {
 	sectionTypes := []string{
-		"cluster", "pitr", "running", "backups",
+		"cluster", "pitr", "running", "locks", "schedule", "queue", "drift", "oplog", "control", "backups",
 	}
 
 	statusOpts := statusOptions{}
@@ -871,15 +1384,25 @@ func (app *pbmApp) buildStatusCmd() *cobra.Command {
 		Use:     "status",
 		Aliases: []string{"s"},
 		Short:   "Show PBM status",
-		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+		Long:    statusLongHelp,
+		RunE: func(cmd *cobra.Command, args []string) error {
 			for _, value := range statusOpts.sections {
 				if err := app.validateEnum("sections", value, sectionTypes); err != nil {
-					return nil, err
+					return err
 				}
 			}
 
-			return status(app.ctx, app.conn, app.pbm, app.mURL, statusOpts, app.pbmOutF == outJSONpretty)
-		}),
+			out, err := status(app.ctx, app.conn, app.pbm, app.mURL, statusOpts, app.pbmOutF == outJSONpretty)
+			if err != nil {
+				return err
+			}
+			printo(out, app.pbmOutF)
+
+			if s, ok := out.(statusOut); ok && statusOpts.exitCode && s.health.Code != 0 {
+				os.Exit(s.health.Code)
+			}
+			return nil
+		},
 	}
 
 	statusCmd.Flags().StringVar(&statusOpts.rsMap, RSMappingFlag, "", RSMappingDoc)
@@ -888,16 +1411,76 @@ func (app *pbmApp) buildStatusCmd() *cobra.Command {
 
 	statusCmd.Flags().StringArrayVarP(
 		&statusOpts.sections, "sections", "s", []string{},
-		"Sections of status to display <cluster>/<pitr>/<running>/<backups>.",
+		"Sections of status to display <cluster>/<pitr>/<running>/<locks>/<schedule>/<drift>/<backups>.",
 	)
 
 	statusCmd.Flags().BoolVarP(
 		&statusOpts.priority, "priority", "p", false, "Show backup and PITR priorities",
 	)
+	statusCmd.Flags().BoolVar(
+		&statusOpts.exitCode, "exit-code", false,
+		"Exit with 1 if the cluster is degraded or 2 on errors (see the help for details)",
+	)
+	statusCmd.Flags().DurationVar(
+		&statusOpts.health.PITRLag, "pitr-lag-threshold", 0,
+		"PITR lag to report the cluster as degraded. Default: two oplog spans",
+	)
+	statusCmd.Flags().DurationVar(
+		&statusOpts.health.BackupAge, "backup-age-threshold", 0,
+		"Report the cluster as degraded if there is no successful backup within the period. Default: no check",
+	)
 
 	return statusCmd
 }
 
+func (app *pbmApp) buildStorageCmd() *cobra.Command {
+	storageCmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Storage operations",
+	}
+
+	migrateOpts := storageMigrateOptions{}
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move backups and PITR chunks to another storage",
+		RunE: app.wrapRunE(func(cmd *cobra.Command, args []string) (fmt.Stringer, error) {
+			return migrateStorage(app.ctx, app.conn, app.pbm, app.node, migrateOpts)
+		}),
+	}
+
+	migrateCmd.Flags().StringVar(
+		&migrateOpts.from, "from", "", "Config profile of the source storage. Default: the main storage",
+	)
+	migrateCmd.Flags().StringVar(
+		&migrateOpts.to, "to", "", "Config profile of the destination storage. Default: the main storage",
+	)
+	migrateCmd.Flags().StringVar(
+		&migrateOpts.backup, "backup", "", "Move the backup only",
+	)
+	migrateCmd.Flags().BoolVar(
+		&migrateOpts.all, "all", false, "Move all done backups and PITR chunks",
+	)
+	migrateCmd.Flags().BoolVar(
+		&migrateOpts.deleteSource, "delete-source", false, "Delete the files from the source storage once moved",
+	)
+	migrateCmd.Flags().BoolVar(
+		&migrateOpts.dryRun, "dry-run", false, "Report the number and size of files to move but do not move",
+	)
+	migrateCmd.Flags().BoolVarP(
+		&migrateOpts.yes, "yes", "y", false, "Don't ask for confirmation",
+	)
+	migrateCmd.Flags().BoolVarP(
+		&migrateOpts.wait, "wait", "w", false, "Wait for the migration done",
+	)
+	migrateCmd.Flags().DurationVar(
+		&migrateOpts.waitTime, "wait-time", 0, "Maximum wait time",
+	)
+
+	storageCmd.AddCommand(migrateCmd)
+
+	return storageCmd
+}
+
 func (app *pbmApp) buildVersionCmd() *cobra.Command {
 	var (
 		versionShort  bool
@@ -961,7 +1544,11 @@ func exitErr(e error, f outFormat) {
 		var m interface{}
 		m = e
 		if _, ok := e.(json.Marshaler); !ok { //nolint:errorlint
-			m = map[string]string{"Error": e.Error()}
+			out := map[string]string{"Error": e.Error()}
+			if code := errors.CodeOf(e); code != "" {
+				out["Code"] = string(code)
+			}
+			m = out
 		}
 
 		j := json.NewEncoder(os.Stdout)
@@ -976,7 +1563,7 @@ func exitErr(e error, f outFormat) {
 		fmt.Fprintln(os.Stderr, "Error:", e)
 	}
 
-	os.Exit(1)
+	os.Exit(exitCode(e))
 }
 
 func runLogs(ctx context.Context, conn connect.Client, l *logsOpts, f outFormat) (fmt.Stringer, error) {
@@ -984,10 +1571,20 @@ func runLogs(ctx context.Context, conn connect.Client, l *logsOpts, f outFormat)
 
 	if l.node != "" {
 		n := strings.Split(l.node, "/")
-		r.RS = n[0]
-		if len(n) > 1 {
-			r.Node = n[1]
+		if len(n) == 1 && strings.Contains(n[0], ":") {
+			r.Node = n[0]
+		} else {
+			r.RS = n[0]
+			if len(n) > 1 {
+				r.Node = n[1]
+			}
+		}
+	}
+	if l.rs != "" {
+		if r.RS != "" && r.RS != l.rs {
+			return nil, errors.Errorf("--rs %q doesn't match --node %q", l.rs, l.node)
 		}
+		r.RS = l.rs
 	}
 
 	if l.event != "" {
@@ -1018,7 +1615,7 @@ func runLogs(ctx context.Context, conn connect.Client, l *logsOpts, f outFormat)
 	}
 
 	if l.follow {
-		err := followLogs(ctx, conn, r, r.Node == "", l.extr, f)
+		err := followLogs(ctx, conn, r, l.tail, r.Node == "", l.extr, f)
 		return nil, err
 	}
 
@@ -1044,9 +1641,14 @@ func runLogs(ctx context.Context, conn connect.Client, l *logsOpts, f outFormat)
 	return o, nil
 }
 
-func followLogs(ctx context.Context, conn connect.Client, r *log.LogRequest, showNode, expr bool, f outFormat) error {
-	outC, errC := log.Follow(ctx, conn, r, false)
-
+func followLogs(
+	ctx context.Context,
+	conn connect.Client,
+	r *log.LogRequest,
+	tail int64,
+	showNode, expr bool,
+	f outFormat,
+) error {
 	var enc *json.Encoder
 	switch f {
 	case outJSON:
@@ -1056,22 +1658,65 @@ func followLogs(ctx context.Context, conn connect.Client, r *log.LogRequest, sho
 		enc.SetIndent("", "  ")
 	}
 
+	printEntry := func(entry *log.Entry) {
+		if enc != nil {
+			err := enc.Encode(entry)
+			if err != nil {
+				exitErr(errors.Wrap(err, "encode output"), f)
+			}
+		} else {
+			fmt.Println(entry.Stringify(tsUTC, showNode, expr))
+		}
+	}
+
+	// print last entries first and follow from the last of them
+	printed := make(map[primitive.ObjectID]bool)
+	if tail > 0 {
+		o, err := log.LogGet(ctx, conn, r, tail)
+		if err != nil {
+			return errors.Wrap(err, "get logs")
+		}
+
+		for i := len(o.Data) - 1; i >= 0; i-- {
+			printEntry(&o.Data[i])
+		}
+		if len(o.Data) != 0 {
+			last := o.Data[0].TS
+			for i := range o.Data {
+				if o.Data[i].TS == last {
+					printed[o.Data[i].ObjID] = true
+				}
+			}
+
+			fr := *r
+			fr.TimeMin = time.Unix(last, 0)
+			r = &fr
+		}
+	}
+
+	outC, errC := log.Follow(ctx, conn, r, false)
+
+	buf := &logReorderBuf{delay: logReorderDelay}
+	tick := time.NewTicker(logReorderDelay / 4)
+	defer tick.Stop()
+
 	for {
 		select {
 		case entry, ok := <-outC:
 			if !ok {
+				buf.flush(printEntry)
 				return nil
 			}
-
-			if f == outJSON || f == outJSONpretty {
-				err := enc.Encode(entry)
-				if err != nil {
-					exitErr(errors.Wrap(err, "encode output"), f)
-				}
-			} else {
-				fmt.Println(entry.Stringify(tsUTC, showNode, expr))
+			if printed[entry.ObjID] {
+				delete(printed, entry.ObjID)
+				continue
 			}
+
+			buf.push(entry, time.Now())
+		case <-tick.C:
+			buf.pop(time.Now(), printEntry)
 		case err, ok := <-errC:
+			buf.flush(printEntry)
 			if !ok {
 				return nil
 			}
@@ -1081,22 +1726,86 @@ func followLogs(ctx context.Context, conn connect.Client, r *log.LogRequest, sho
 	}
 }
 
+// logReorderDelay is how long followed entries are held to be ordered
+// by time with entries of other nodes written a bit later.
+const logReorderDelay = time.Second
+
+// logReorderBuf holds followed log entries ordered by time.
+type logReorderBuf struct {
+	delay   time.Duration
+	entries []*log.Entry
+	arrived []time.Time
+}
+
+func (b *logReorderBuf) push(e *log.Entry, now time.Time) {
+	i := sort.Search(len(b.entries), func(i int) bool {
+		o := b.entries[i]
+		return o.TS > e.TS || (o.TS == e.TS && o.Tns > e.Tns)
+	})
+
+	b.entries = slices.Insert(b.entries, i, e)
+	b.arrived = slices.Insert(b.arrived, i, now)
+}
+
+// pop passes to fn entries from the head that are held for the delay.
+func (b *logReorderBuf) pop(now time.Time, fn func(*log.Entry)) {
+	n := 0
+	for n < len(b.entries) && now.Sub(b.arrived[n]) >= b.delay {
+		fn(b.entries[n])
+		n++
+	}
+
+	b.entries = b.entries[n:]
+	b.arrived = b.arrived[n:]
+}
+
+func (b *logReorderBuf) flush(fn func(*log.Entry)) {
+	for _, e := range b.entries {
+		fn(e)
+	}
+
+	b.entries, b.arrived = nil, nil
+}
+
+// normalizeLogSeverity converts severity name (like "warning")
+// to its letter. Other values are returned as is.
+func normalizeLogSeverity(s string) string {
+	switch strings.ToLower(s) {
+	case "debug":
+		return "D"
+	case "info":
+		return "I"
+	case "warning", "warn":
+		return "W"
+	case "error":
+		return "E"
+	case "fatal":
+		return "F"
+	}
+
+	return strings.ToUpper(s)
+}
+
 func tsUTC(ts int64) string {
 	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
 }
 
 type snapshotStat struct {
-	Name       string          `json:"name"`
-	Namespaces []string        `json:"nss,omitempty"`
-	Size       int64           `json:"size,omitempty"`
-	Status     defs.Status     `json:"status"`
-	Err        error           `json:"-"`
-	ErrString  string          `json:"error,omitempty"`
-	RestoreTS  int64           `json:"restoreTo"`
-	PBMVersion string          `json:"pbmVersion"`
-	Type       defs.BackupType `json:"type"`
-	SrcBackup  string          `json:"src"`
-	StoreName  string          `json:"storage,omitempty"`
+	Name        string          `json:"name"`
+	Namespaces  []string        `json:"nss,omitempty"`
+	SingleRS    string          `json:"singleRS,omitempty"`
+	ExcludedDBs []string        `json:"excludedDBs,omitempty"`
+	Size        int64           `json:"size,omitempty"`
+	Status      defs.Status     `json:"status"`
+	Err         error           `json:"-"`
+	ErrString   string          `json:"error,omitempty"`
+	RestoreTS   int64           `json:"restoreTo"`
+	PBMVersion  string          `json:"pbmVersion"`
+	Type        defs.BackupType `json:"type"`
+	SrcBackup   string          `json:"src"`
+	StoreName   string          `json:"storage,omitempty"`
+	// Warnings is the number of issues found by the consistency check
+	Warnings int `json:"warnings,omitempty"`
 }
 
 type pitrRange struct {
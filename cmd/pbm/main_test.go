@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestLogReorderBuf(t *testing.T) {
+	now := time.Now()
+	b := &logReorderBuf{delay: time.Second}
+
+	entry := func(ts int64, tns int, msg string) *log.Entry {
+		return &log.Entry{TS: ts, Tns: tns, Msg: msg}
+	}
+
+	b.push(entry(10, 5, "rs1: second"), now)
+	b.push(entry(11, 0, "rs1: third"), now)
+	// written later by another node
+	b.push(entry(10, 1, "rs2: first"), now.Add(300*time.Millisecond))
+	b.push(entry(12, 0, "rs2: last"), now.Add(900*time.Millisecond))
+
+	var got []string
+	collect := func(e *log.Entry) { got = append(got, e.Msg) }
+
+	b.pop(now.Add(500*time.Millisecond), collect)
+	if len(got) != 0 {
+		t.Fatalf("popped before the delay: %v", got)
+	}
+
+	b.pop(now.Add(1300*time.Millisecond), collect)
+	want := []string{"rs2: first", "rs1: second", "rs1: third"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	got = nil
+	b.flush(collect)
+	if len(got) != 1 || got[0] != "rs2: last" {
+		t.Errorf("flush: got %v", got)
+	}
+}
+
+func TestNormalizeLogSeverity(t *testing.T) {
+	cases := map[string]string{
+		"warning": "W",
+		"Error":   "E",
+		"debug":   "D",
+		"i":       "I",
+		"F":       "F",
+		"x":       "X",
+	}
+	for in, want := range cases {
+		if got := normalizeLogSeverity(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"plain", errors.New("boom"), 1},
+		{"invalid argument", errors.Wrap(invalidArg(errors.New("bad --ns")), "restore"), 3},
+		{"backup not found", errors.Wrap(errors.WithCode(errors.ErrNotFound, errors.CodeBackupNotFound), "get"), 4},
+		{"concurrent op", errors.Wrap(&concurrentOpError{}, "restore"), 6},
+		{"replset failure", (&backup.BackupMeta{
+			Err:      "rs1 failed",
+			Replsets: []backup.BackupReplset{{Name: "rs1", ErrCode: errors.CodeStorageUnreachable}},
+		}).Error(), 7},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := exitCode(c.err); got != c.code {
+				t.Errorf("want %d, got %d", c.code, got)
+			}
+		})
+	}
+}
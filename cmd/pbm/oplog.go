@@ -9,6 +9,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
@@ -19,6 +20,9 @@ type replayOptions struct {
 	wait     bool
 	waitTime time.Duration
 	rsMap    string
+
+	source    string
+	allowGaps bool
 }
 
 type oplogReplayResult struct {
@@ -63,7 +67,11 @@ func replayOplog(
 		return nil, errors.Wrap(err, "parse end time")
 	}
 
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	if o.allowGaps && o.source == "" {
+		return nil, errors.New("--allow-gaps is applicable only with --source")
+	}
+
+	if err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdReplay}); err != nil {
 		return nil, err
 	}
 
@@ -75,6 +83,9 @@ func replayOplog(
 			Start: startTS,
 			End:   endTS,
 			RSMap: rsMap,
+
+			Source:    o.source,
+			AllowGaps: o.allowGaps,
 		},
 	}
 	if err := sendCmd(ctx, conn, cmd); err != nil {
@@ -106,7 +117,7 @@ func replayOplog(
 	}
 
 	fmt.Print("Started.\nWaiting to finish")
-	err = waitRestore(ctx, conn, m, node, defs.StatusDone, 0)
+	err = waitRestore(ctx, conn, m, node, defs.StatusDone, 0, nil)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			err = errWaitTimeout
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+type pitrVerifyOptions struct {
+	from     string
+	to       string
+	waitTime time.Duration
+}
+
+type pitrVerifyResult struct {
+	*oplog.VerifyReport
+}
+
+func (r pitrVerifyResult) HasError() bool {
+	for _, rs := range r.Replsets {
+		if len(rs.Corrupt) > 0 || len(rs.Gaps) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r pitrVerifyResult) String() string {
+	var s strings.Builder
+
+	for _, rs := range r.Replsets {
+		fmt.Fprintf(&s, "%s: %d chunks checked", rs.RS, rs.Chunks)
+		if !rs.Watermark.IsZero() {
+			fmt.Fprintf(&s, " (watermark: %s)", fmtTS(int64(rs.Watermark.T)))
+		}
+		s.WriteString("\n")
+
+		for _, t := range rs.Verified {
+			fmt.Fprintf(&s, "  OK %s\n", t)
+		}
+		for _, t := range rs.Gaps {
+			fmt.Fprintf(&s, "  ! GAP %s\n", t)
+		}
+		for _, c := range rs.Corrupt {
+			fmt.Fprintf(&s, "  ! CORRUPT %s [%s - %s]: %s\n",
+				c.File, fmtTS(int64(c.Start.T)), fmtTS(int64(c.End.T)), c.Err)
+		}
+	}
+
+	if len(r.Replsets) == 0 {
+		s.WriteString("no chunks to verify\n")
+	}
+
+	return s.String()
+}
+
+// verifyPITR makes an agent check integrity of the PITR chunks and waits
+// for its report. If `from` isn't set, only chunks made after the previous
+// run are verified.
+func verifyPITR(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	o pitrVerifyOptions,
+) (fmt.Stringer, error) {
+	var from, to primitive.Timestamp
+	var err error
+	if o.from != "" {
+		from, err = parseTS(o.from)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse --from")
+		}
+	}
+	if o.to != "" {
+		to, err = parseTS(o.to)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse --to")
+		}
+	}
+	if !to.IsZero() && !from.Before(to) {
+		return nil, errors.New("--from should be before --to")
+	}
+
+	r, err := oplog.GetVerifyReport(ctx, conn)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return nil, errors.Wrap(err, "get verification report")
+	}
+	if r != nil && r.Status == defs.StatusRunning {
+		return nil, errors.Errorf("pitr chunks are being verified by %s", r.Node)
+	}
+
+	opid, err := ctrl.SendPITRVerify(ctx, conn, ctrl.PITRVerifyCmd{From: from, To: to})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	if o.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.waitTime)
+		defer cancel()
+	}
+
+	return waitForPITRVerify(ctx, conn, pbm, opid)
+}
+
+func waitForPITRVerify(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	opid ctrl.OPID,
+) (fmt.Stringer, error) {
+	fmt.Print("Waiting for the verification ")
+
+	started := false
+	start := time.Now()
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ctx.Err()
+			}
+			return outMsg{"\nOperation is still in progress, please check the agents logs in a while"}, nil
+		}
+		fmt.Print(".")
+
+		r, err := oplog.GetVerifyReport(ctx, conn)
+		if err != nil && !errors.Is(err, errors.ErrNotFound) {
+			return nil, errors.Wrap(err, "get verification report")
+		}
+
+		if r == nil || r.OPID != opid.String() {
+			if started || time.Since(start) < defs.WaitActionStart {
+				continue
+			}
+
+			fmt.Println("[error]")
+			cmd, err := pbm.CommandInfo(ctx, sdk.CommandID(opid.String()))
+			if err != nil {
+				return nil, errors.Wrap(err, "get command info")
+			}
+			msg, err := sdk.WaitForErrorLog(ctx, pbm, cmd)
+			if err != nil {
+				return nil, errors.Wrap(err, "read agents log")
+			}
+			if msg != "" {
+				return nil, errors.New(msg)
+			}
+			return nil, errors.New("verification has not started. Check agents logs")
+		}
+		started = true
+
+		switch r.Status {
+		case defs.StatusDone:
+			fmt.Println("[done]")
+			return pitrVerifyResult{r}, nil
+		case defs.StatusError:
+			fmt.Println("[error]")
+			return nil, errors.New(r.Error)
+		}
+	}
+}
+
+type pitrCompactOptions struct {
+	olderThan string
+	maxSizeMB int64
+	maxSpan   time.Duration
+	yes       bool
+	wait      bool
+	waitTime  time.Duration
+	dryRun    bool
+}
+
+// compactPITR schedules merging of contiguous PITR chunks older than
+// `--older-than` into bigger ones. The compaction is done by the agent.
+func compactPITR(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	o pitrCompactOptions,
+) (fmt.Stringer, error) {
+	if o.olderThan == "" {
+		return nil, errors.New("--older-than should be set")
+	}
+	if o.maxSizeMB < 0 || o.maxSpan < 0 {
+		return nil, errors.New("--max-size-mb and --max-span should not be negative")
+	}
+
+	until, err := parseOlderThan(o.olderThan)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --older-than")
+	}
+	now := time.Now().UTC()
+	if until.T > uint32(now.Unix()) {
+		providedTime := time.Unix(int64(until.T), 0).UTC().Format(time.RFC3339)
+		return nil, errors.Errorf("--older-than %q is after now %q", providedTime, now.Format(time.RFC3339))
+	}
+
+	if !o.dryRun {
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdPITRCompact})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := oplog.CompactOptions{
+		Before:  until,
+		MaxSize: o.maxSizeMB << 20,
+		MaxSpan: o.maxSpan,
+	}
+	rss, err := oplog.AllOplogRSNames(ctx, conn, primitive.Timestamp{}, until)
+	if err != nil {
+		return nil, errors.Wrap(err, "get replsets")
+	}
+
+	var s strings.Builder
+	total := 0
+	for _, rs := range rss {
+		chunks, err := oplog.PITRGetChunksSliceUntil(ctx, conn, rs, until)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get %s chunks", rs)
+		}
+
+		for _, grp := range oplog.CompactGroups(chunks, opts) {
+			first, last := grp[0], grp[len(grp)-1]
+			var size int64
+			for _, c := range grp {
+				size += c.Size
+			}
+			fmt.Fprintf(&s, "  %s: %d chunks [%s - %s] %s\n", rs, len(grp),
+				fmtTS(int64(first.StartTS.T)), fmtTS(int64(last.EndTS.T)), storage.PrettySize(size))
+			total += len(grp)
+		}
+	}
+	if total == 0 {
+		return outMsg{"nothing to compact"}, nil
+	}
+
+	fmt.Printf("Chunks to merge:\n%s", s.String())
+	if o.dryRun {
+		return outMsg{""}, nil
+	}
+	if !o.yes {
+		if err := askConfirmation("Are you sure you want to compact chunks?"); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	cid, err := pbm.CompactOplogRange(ctx, until, opts.MaxSize, opts.MaxSpan)
+	if err != nil {
+		return nil, errors.Wrap(err, "schedule pitr compaction")
+	}
+
+	if !o.wait {
+		return outMsg{"Processing by agents. Please check status later"}, nil
+	}
+
+	if o.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.waitTime)
+		defer cancel()
+	}
+
+	return waitForCompact(ctx, pbm, cid)
+}
+
+func waitForCompact(ctx context.Context, pbm *sdk.Client, cid sdk.CommandID) (fmt.Stringer, error) {
+	commandCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+
+	go func() {
+		fmt.Print("Waiting for compaction to be done ")
+
+		for tick := time.NewTicker(time.Second); ; {
+			select {
+			case <-tick.C:
+				fmt.Print(".")
+			case <-commandCtx.Done():
+				return
+			}
+		}
+	}()
+
+	cmd, err := pbm.CommandInfo(commandCtx, cid)
+	if err != nil {
+		return nil, errors.Wrap(err, "get command info")
+	}
+
+	err = sdk.WaitForCompactOplogRange(commandCtx, pbm)
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		msg, err := sdk.WaitForErrorLog(ctx, pbm, cmd)
+		if err != nil {
+			return nil, errors.Wrap(err, "read agents log")
+		}
+		if msg != "" {
+			return nil, errors.New(msg)
+		}
+
+		return outMsg{"Operation is still in progress, please check status in a while"}, nil
+	}
+
+	stopProgress()
+	fmt.Println("[done]")
+	return outMsg{""}, nil
+}
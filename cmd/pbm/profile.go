@@ -10,7 +10,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/sdk"
 )
 
@@ -38,6 +40,8 @@ type syncConfigProfileOptions struct {
 	clear    bool
 	wait     bool
 	waitTime time.Duration
+
+	forceClaim bool
 }
 
 type configProfileList struct {
@@ -100,7 +104,7 @@ func handleAddConfigProfile(
 	if opts.name == "" {
 		return nil, errors.New("argument `profile-name` should not be empty")
 	}
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	if err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdAddConfigProfile, Storage: opts.name}); err != nil {
 		return nil, err
 	}
 
@@ -177,7 +181,7 @@ func handleRemoveConfigProfile(
 	if opts.name == "" {
 		return nil, errors.New("argument `profile-name` should not be empty")
 	}
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	if err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdRemoveConfigProfile, Storage: opts.name}); err != nil {
 		return nil, err
 	}
 
@@ -225,8 +229,15 @@ func handleSyncConfigProfile(
 	if opts.all && opts.name != "" {
 		return nil, errors.New("ambiguous: <profile-name> and --all are provided")
 	}
+	if opts.forceClaim && (opts.all || opts.clear) {
+		return nil, errors.New("--force-claim-storage is applicable only to the sync of one profile")
+	}
 
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	op := &lock.LockHeader{Type: ctrl.CmdResync}
+	if !opts.all {
+		op.Scope, op.Storage = lock.ScopeReplset, opts.name
+	}
+	if err := checkForAnotherOperation(ctx, pbm, op); err != nil {
 		return nil, err
 	}
 
@@ -240,9 +251,12 @@ func handleSyncConfigProfile(
 			cid, err = pbm.ClearSyncFromExternalStorage(ctx, opts.name)
 		}
 	} else {
-		if opts.all {
+		switch {
+		case opts.all:
 			cid, err = pbm.SyncFromAllExternalStorages(ctx)
-		} else {
+		case opts.forceClaim:
+			cid, err = pbm.ClaimStorage(ctx, opts.name)
+		default:
 			cid, err = pbm.SyncFromExternalStorage(ctx, opts.name)
 		}
 	}
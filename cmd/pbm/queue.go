@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/queue"
+)
+
+type queuedBackupOut struct {
+	OPID     string `json:"opid"`
+	Position int    `json:"position"`
+	// Collapsed is true if the same backup is queued already
+	Collapsed bool  `json:"collapsed,omitempty"`
+	ExpireAt  int64 `json:"expireAt"`
+}
+
+func (o queuedBackupOut) String() string {
+	if o.Collapsed {
+		return fmt.Sprintf("The same backup is queued already [opid: %s], expires at %s",
+			o.OPID, fmtTS(o.ExpireAt))
+	}
+	return fmt.Sprintf("Backup is queued [opid: %s], position %d, expires at %s",
+		o.OPID, o.Position, fmtTS(o.ExpireAt))
+}
+
+// queueBackup queues the backup if another operation is running or
+// there are pending backups already, so the backup doesn't run
+// ahead of them. It returns nil if the backup can be started now.
+func queueBackup(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.Config,
+	b ctrl.BackupCmd,
+	busy bool,
+) (fmt.Stringer, error) {
+	pending, err := queue.Pending(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get queue")
+	}
+	if !busy && len(pending) == 0 {
+		return nil, nil
+	}
+
+	// the name is given when the backup is started
+	b.Name = ""
+	e, collapsed, err := queue.Enqueue(ctx, conn, b, ctrl.NewInitiator(ctx, conn),
+		cfg.Backup.Queue.TTL(), cfg.Backup.Queue.Collapse())
+	if err != nil {
+		return nil, errors.Wrap(err, "queue backup")
+	}
+
+	out := queuedBackupOut{
+		OPID:      e.ID.Hex(),
+		Position:  len(pending) + 1,
+		Collapsed: collapsed,
+		ExpireAt:  e.ExpireAt,
+	}
+	for i := range pending {
+		if pending[i].ID == e.ID {
+			out.Position = i + 1
+		}
+	}
+
+	return out, nil
+}
+
+func cancelQueued(ctx context.Context, conn connect.Client, opid string) (fmt.Stringer, error) {
+	id, err := ctrl.ParseOPID(opid)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse opid")
+	}
+
+	err = queue.Cancel(ctx, conn, id.Obj())
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil, errors.Errorf("no queued backup with opid %s", opid)
+		}
+		return nil, errors.Wrapf(err, "cancel %s", opid)
+	}
+
+	return outMsg{fmt.Sprintf("Queued backup %s is cancelled", opid)}, nil
+}
+
+type queueStat struct {
+	Pending []queuedBackup `json:"pending"`
+}
+
+type queuedBackup struct {
+	OPID      string          `json:"opid"`
+	Type      defs.BackupType `json:"type"`
+	Base      bool            `json:"base,omitempty"`
+	Profile   string          `json:"profile,omitempty"`
+	Submitted int64           `json:"submitted"`
+	ExpireAt  int64           `json:"expireAt"`
+	Collapsed int             `json:"collapsed,omitempty"`
+}
+
+func (s queueStat) String() string {
+	var b strings.Builder
+	for i, e := range s.Pending {
+		typ := string(e.Type)
+		if e.Base {
+			typ += ", base"
+		}
+		if e.Profile != "" {
+			typ += ", profile: " + e.Profile
+		}
+		fmt.Fprintf(&b, "%d. %s [%s] submitted at %s, expires at %s",
+			i+1, e.OPID, typ, fmtTS(e.Submitted), fmtTS(e.ExpireAt))
+		if e.Collapsed != 0 {
+			fmt.Fprintf(&b, " (+%d collapsed)", e.Collapsed)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("Run `pbm cancel <opid>` to remove a backup from the queue.")
+
+	return b.String()
+}
+
+func getQueueStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	pending, err := queue.Pending(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get queue")
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	rv := queueStat{Pending: make([]queuedBackup, 0, len(pending))}
+	for _, e := range pending {
+		rv.Pending = append(rv.Pending, queuedBackup{
+			OPID:      e.ID.Hex(),
+			Type:      e.Backup.Type,
+			Base:      e.Backup.Type == defs.IncrementalBackup && e.Backup.IncrBase,
+			Profile:   e.Backup.Profile,
+			Submitted: e.Submitted,
+			ExpireAt:  e.ExpireAt,
+			Collapsed: e.Collapsed,
+		})
+	}
+
+	return rv, nil
+}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mongodb/mongo-tools/common/db"
@@ -21,8 +25,11 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/restore/phys"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
@@ -50,8 +57,23 @@ type restoreOpts struct {
 	nsTo          string
 	usersAndRoles bool
 	rsMap         string
+
+	usersAndRolesOnly bool
+	usersAndRolesMode string
+
+	drop       bool
+	nsConflict string
+
+	skipCollOptions bool
+	keepProfiling   bool
+
+	replset       string
+	dbpathMap     string
+	sourceCluster string
 	conf          string
 	ts            string
+	yes           bool
+	force         bool
 
 	numParallelColls    int32
 	numInsertionWorkers int32
@@ -61,6 +83,8 @@ type restoreRet struct {
 	Name     string `json:"name,omitempty"`
 	Snapshot string `json:"snapshot,omitempty"`
 	PITR     string `json:"point-in-time,omitempty"`
+	// Latest is the target `--time latest` is resolved to
+	Latest   *latestPITR `json:"latest,omitempty"`
 	done     bool
 	physical bool
 	err      string
@@ -71,6 +95,14 @@ func (r restoreRet) HasError() bool {
 }
 
 func (r restoreRet) String() string {
+	if r.Latest != nil && !r.Latest.shown {
+		return r.Latest.String() + "\n" + r.string()
+	}
+
+	return r.string()
+}
+
+func (r restoreRet) string() string {
 	switch {
 	case r.done:
 		m := fmt.Sprintf("\nRestore finished! Check pbm describe-restore %s", r.Name)
@@ -117,54 +149,101 @@ func runRestore(
 	ctx context.Context,
 	conn connect.Client,
 	pbm *sdk.Client,
+	mURL string,
 	o *restoreOpts,
 	node string,
 	outf outFormat,
 ) (fmt.Stringer, error) {
 	numParallelColls, err := parseCLINumParallelCollsOption(o.numParallelColls)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse --num-parallel-collections option")
+		return nil, invalidArg(errors.Wrap(err, "parse --num-parallel-collections option"))
 	}
 	numInsertionWorkers, err := parseCLINumInsertionWorkersOption(o.numInsertionWorkers)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse --num-insertion-workers option")
+		return nil, invalidArg(errors.Wrap(err, "parse --num-insertion-workers option"))
 	}
 	nss, err := parseCLINSOption(o.ns)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse --ns option")
+		return nil, invalidArg(errors.Wrap(err, "parse --ns option"))
 	}
 	if err := validateNSFromNSTo(o); err != nil {
-		return nil, errors.Wrap(err, "parse --ns-from and --ns-to options")
+		return nil, invalidArg(errors.Wrap(err, "parse --ns-from and --ns-to options"))
 	}
 	if err := validateRestoreUsersAndRoles(o.usersAndRoles, nss); err != nil {
-		return nil, errors.Wrap(err, "parse --with-users-and-roles option")
+		return nil, invalidArg(errors.Wrap(err, "parse --with-users-and-roles option"))
+	}
+	if err := validateUsersAndRolesOnly(o); err != nil {
+		return nil, invalidArg(errors.Wrap(err, "parse --users-and-roles-only option"))
+	}
+	if err := validateNoDrop(o); err != nil {
+		return nil, invalidArg(errors.Wrap(err, "parse --drop option"))
+	}
+	if o.skipCollOptions && (o.extern || o.usersAndRolesOnly) {
+		return nil, invalidArg(errors.New("--skip-collection-options is for logical restores of the data only"))
+	}
+	if o.keepProfiling && (o.extern || o.usersAndRolesOnly) {
+		return nil, invalidArg(errors.New("--keep-profiling is for logical restores of the data only"))
 	}
 
 	rsMap, err := parseRSNamesMapping(o.rsMap)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse replset mapping")
+		return nil, invalidArg(errors.Wrap(err, "cannot parse replset mapping"))
+	}
+	dbpathMap, err := parseDBpathMapping(o.dbpathMap)
+	if err != nil {
+		return nil, invalidArg(errors.Wrap(err, "parse --dbpath-map option"))
 	}
 
 	if o.pitr != "" && o.bcp != "" {
-		return nil, errors.New("either a backup name or point in time should be set, non both together!")
+		return nil, invalidArg(errors.New("either a backup name or point in time should be set, non both together!"))
+	}
+	if o.replset != "" {
+		if err := validateRestoreReplsetOpts(ctx, conn, o); err != nil {
+			return nil, err
+		}
+	}
+	if o.usersAndRolesOnly {
+		// the users of sharded clusters are on the config server
+		o.replset, err = configsvrReplset(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if err := checkForAnotherOperation(ctx, pbm); err != nil {
+	if err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdRestore}); err != nil {
 		return nil, err
 	}
 
+	var latest *latestPITR
+	if o.pitr == pitrLatest {
+		latest, err = resolveLatestPITR(ctx, conn, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.pitr != "" && !o.extern {
+		ts, err := parseTS(o.pitr)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse pitr")
+		}
+		if err := restore.CheckPITRTime(ctx, conn, ts, o.pitrBase); err != nil {
+			return nil, err
+		}
+	}
+
 	clusterTime, err := topo.GetClusterTime(ctx, conn)
 	if err != nil {
 		return nil, errors.Wrap(err, "read cluster time")
 	}
 	tdiff := time.Now().Unix() - int64(clusterTime.T)
 
-	m, err := doRestore(ctx, conn, o, numParallelColls, numInsertionWorkers, nss, o.nsFrom, o.nsTo, rsMap, node, outf)
+	m, err := doRestore(ctx, conn, mURL, o, numParallelColls, numInsertionWorkers,
+		nss, o.nsFrom, o.nsTo, rsMap, dbpathMap, node, outf)
 	if err != nil {
 		return nil, err
 	}
 	if o.extern && outf == outText {
-		err = waitRestore(ctx, conn, m, node, defs.StatusCopyReady, tdiff)
+		err = waitRestore(ctx, conn, m, node, defs.StatusCopyReady, tdiff, nil)
 		if err != nil {
 			return nil, errors.Wrap(err, "waiting for the `copyReady` status")
 		}
@@ -175,6 +254,7 @@ func runRestore(
 		return restoreRet{
 			Name:     m.Name,
 			Snapshot: o.bcp,
+			Latest:   latest,
 			physical: m.Type == defs.PhysicalBackup || m.Type == defs.IncrementalBackup,
 		}, nil
 	}
@@ -185,27 +265,44 @@ func runRestore(
 		defer cancel()
 	}
 
-	typ := " logical restore.\nWaiting to finish"
-	if m.Type == defs.PhysicalBackup {
-		typ = " physical restore.\nWaiting to finish"
+	physical := m.Type == defs.PhysicalBackup || m.Type == defs.IncrementalBackup
+
+	var prg *restoreProgressPrinter
+	if physical {
+		prg = newRestoreProgressPrinter(os.Stdout, outf)
+	}
+	if outf == outText {
+		typ := " logical restore.\nWaiting to finish"
+		if physical {
+			typ = " physical restore.\nWaiting to finish\n"
+		}
+		fmt.Printf("Started%s", typ)
 	}
-	fmt.Printf("Started%s", typ)
-	err = waitRestore(ctx, conn, m, node, defs.StatusDone, tdiff)
+	var onMeta func(*restore.RestoreMeta)
+	if prg != nil {
+		onMeta = prg.print
+	}
+	err = waitRestore(ctx, conn, m, node, defs.StatusDone, tdiff, onMeta)
 	if err == nil {
 		return restoreRet{
 			Name:     m.Name,
+			Latest:   latest,
 			done:     true,
 			physical: m.Type == defs.PhysicalBackup || m.Type == defs.IncrementalBackup,
 		}, nil
 	}
 
 	if errors.Is(err, restoreFailedError{}) {
-		return restoreRet{err: err.Error()}, nil
+		msg := err.Error()
+		if prg != nil && prg.last != nil {
+			msg += failedNodesInfo(prg.last)
+		}
+		return restoreRet{Latest: latest, err: msg}, nil
 	}
 	if errors.Is(err, context.DeadlineExceeded) {
 		err = errWaitTimeout
 	}
-	return restoreRet{err: fmt.Sprintf("%s.\n Try to check logs on node %s", err.Error(), m.Leader)}, nil
+	return restoreRet{Latest: latest, err: fmt.Sprintf("%s.\n Try to check logs on node %s", err.Error(), m.Leader)}, nil
 }
 
 // We rely on heartbeats in error detection in case of all nodes failed,
@@ -220,6 +317,7 @@ func waitRestore(
 	node string,
 	status defs.Status,
 	tskew int64,
+	onMeta func(*restore.RestoreMeta),
 ) error {
 	ep, _ := config.GetEpoch(ctx, conn)
 	l := log.FromContext(ctx).
@@ -247,7 +345,9 @@ func waitRestore(
 		frameSec = 60 * 3
 	}
 	for range tk.C {
-		fmt.Print(".")
+		if onMeta == nil {
+			fmt.Print(".")
+		}
 		rmeta, err = getMeta(ctx, conn, m.Name)
 		if errors.Is(err, errors.ErrNotFound) {
 			continue
@@ -255,12 +355,18 @@ func waitRestore(
 		if err != nil {
 			return errors.Wrap(err, "get restore metadata")
 		}
+		if onMeta != nil {
+			onMeta(rmeta)
+		}
 
 		switch rmeta.Status {
 		case status, defs.StatusDone, defs.StatusPartlyDone:
 			return nil
 		case defs.StatusError:
-			return restoreFailedError{fmt.Sprintf("operation failed with: %s", rmeta.Error)}
+			return errors.WithCode(restoreFailedError{fmt.Sprintf("operation failed with: %s", rmeta.Error)},
+				rmeta.FailureCode())
+		case defs.StatusAborted:
+			return restoreFailedError{fmt.Sprintf("operation aborted: %s", rmeta.Error)}
 		}
 
 		if m.Type == defs.LogicalBackup {
@@ -281,6 +387,131 @@ func waitRestore(
 	return nil
 }
 
+type restoreProgressOut struct {
+	Name     string                 `json:"name"`
+	Status   defs.Status            `json:"status"`
+	Replsets []restoreRSProgressOut `json:"replsets"`
+}
+
+type restoreRSProgressOut struct {
+	Name   string                   `json:"name"`
+	Status defs.Status              `json:"status"`
+	NSLoad *restore.NSLoadProgress  `json:"ns_load,omitempty"`
+	Nodes  []restoreNodeProgressOut `json:"nodes"`
+}
+
+type restoreNodeProgressOut struct {
+	Name     string                    `json:"name"`
+	Status   defs.Status               `json:"status"`
+	Error    string                    `json:"error,omitempty"`
+	Progress *restore.PhysNodeProgress `json:"progress,omitempty"`
+}
+
+func newRestoreProgressOut(m *restore.RestoreMeta) *restoreProgressOut {
+	rv := &restoreProgressOut{Name: m.Name, Status: m.Status}
+	for _, rs := range m.Replsets {
+		rso := restoreRSProgressOut{Name: rs.Name, Status: rs.Status, NSLoad: rs.NSLoad}
+		for _, n := range rs.Nodes {
+			rso.Nodes = append(rso.Nodes, restoreNodeProgressOut{
+				Name:     n.Name,
+				Status:   n.Status,
+				Error:    n.Error,
+				Progress: n.Progress,
+			})
+		}
+		sort.Slice(rso.Nodes, func(i, j int) bool { return rso.Nodes[i].Name < rso.Nodes[j].Name })
+		rv.Replsets = append(rv.Replsets, rso)
+	}
+	sort.Slice(rv.Replsets, func(i, j int) bool { return rv.Replsets[i].Name < rv.Replsets[j].Name })
+
+	return rv
+}
+
+func (r *restoreProgressOut) String() string {
+	s := fmt.Sprintf("Restore %q [%s]\n", r.Name, r.Status)
+	for _, rs := range r.Replsets {
+		s += fmt.Sprintf("  %s [%s]\n", rs.Name, rs.Status)
+		if rs.NSLoad != nil {
+			s += "    " + strings.ReplaceAll(rs.NSLoad.String(), "\n", "\n    ") + "\n"
+		}
+		for _, n := range rs.Nodes {
+			s += fmt.Sprintf("    %s [%s]", n.Name, n.Status)
+			if n.Progress != nil {
+				s += " " + n.Progress.String()
+			}
+			s += "\n"
+		}
+	}
+
+	return s
+}
+
+// restoreProgressPrinter renders per-replset, per-node progress of the
+// physical restore. Text output is refreshed in place on terminals.
+// JSON formats print one object per line on each change.
+type restoreProgressPrinter struct {
+	w     io.Writer
+	outf  outFormat
+	tty   bool
+	lines int
+	prev  string
+
+	last *restore.RestoreMeta
+}
+
+func newRestoreProgressPrinter(f *os.File, outf outFormat) *restoreProgressPrinter {
+	tty := false
+	if fi, err := f.Stat(); err == nil {
+		tty = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &restoreProgressPrinter{w: f, outf: outf, tty: tty}
+}
+
+func (p *restoreProgressPrinter) print(m *restore.RestoreMeta) {
+	p.last = m
+
+	out := newRestoreProgressOut(m)
+	s := out.String()
+	if s == p.prev {
+		return
+	}
+	p.prev = s
+
+	switch p.outf {
+	case outJSON, outJSONpretty:
+		_ = json.NewEncoder(p.w).Encode(out)
+		return
+	}
+
+	if p.tty && p.lines > 0 {
+		// move the cursor up to the previous table and clear it
+		fmt.Fprintf(p.w, "\033[%dA\033[J", p.lines)
+	}
+	fmt.Fprint(p.w, s)
+	p.lines = strings.Count(s, "\n")
+}
+
+// failedNodesInfo returns errors of failed nodes and where to look for details.
+func failedNodesInfo(m *restore.RestoreMeta) string {
+	s := ""
+	for _, rs := range m.Replsets {
+		for _, n := range rs.Nodes {
+			if n.Status != defs.StatusError {
+				continue
+			}
+			s += fmt.Sprintf("\n  %s/%s: %s", rs.Name, n.Name, n.Error)
+			s += fmt.Sprintf("\n    status files: %s/%s/rs.%s/", defs.PhysRestoresDir, m.Name, rs.Name)
+			s += fmt.Sprintf("\n    logs: pbm-agent log on %s and <dbpath>/pbm.restore.log (mongod)", n.Name)
+		}
+	}
+	if s != "" {
+		s = "\n Failed nodes:" + s
+	}
+
+	return s
+}
+
 type restoreFailedError struct {
 	string
 }
@@ -298,6 +529,35 @@ func (e restoreFailedError) Is(err error) bool {
 	return ok
 }
 
+// parseDBpathMapping parses the "host:port=/path,..." list of the dbpaths
+// of the nodes.
+func parseDBpathMapping(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	rv := make(map[string]string)
+	for _, a := range strings.Split(s, ",") {
+		node, p, ok := strings.Cut(strings.TrimSpace(a), "=")
+		if !ok || node == "" || p == "" {
+			return nil, errors.Errorf("malformatted: %q", a)
+		}
+		if _, port, err := net.SplitHostPort(node); err != nil || port == "" {
+			return nil, errors.Errorf("node %q should be in the host:port format", node)
+		}
+		if !filepath.IsAbs(p) {
+			return nil, errors.Errorf("dbpath %q of %s should be an absolute path", p, node)
+		}
+		if _, ok := rv[node]; ok {
+			return nil, errors.Errorf("node %v is duplicated", node)
+		}
+
+		rv[node] = filepath.Clean(p)
+	}
+
+	return rv, nil
+}
+
 func checkBackup(
 	ctx context.Context,
 	conn connect.Client,
@@ -305,9 +565,11 @@ func checkBackup(
 	nss []string,
 	nsFrom string,
 	nsTo string,
-) (string, defs.BackupType, error) {
+	rsMap map[string]string,
+	node string,
+) (string, defs.BackupType, *restore.ShardPlan, error) {
 	if o.extern && o.bcp == "" {
-		return "", defs.ExternalBackup, nil
+		return "", defs.ExternalBackup, nil, nil
 	}
 
 	b := o.bcp
@@ -320,34 +582,432 @@ func checkBackup(
 	if b != "" {
 		bcp, err = backup.NewDBManager(conn).GetBackupByName(ctx, b)
 		if errors.Is(err, errors.ErrNotFound) {
-			return "", "", errors.Errorf("backup '%s' not found", b)
+			return "", "", nil, errors.WithCode(errors.Errorf("backup '%s' not found", b), errors.CodeBackupNotFound)
 		}
 	} else {
 		var ts primitive.Timestamp
 		ts, err = parseTS(o.pitr)
 		if err != nil {
-			return "", "", errors.Wrap(err, "parse pitr")
+			return "", "", nil, errors.Wrap(err, "parse pitr")
 		}
 
 		bcp, err = backup.GetLastBackup(ctx, conn, &primitive.Timestamp{T: ts.T + 1, I: 0})
 		if errors.Is(err, errors.ErrNotFound) {
-			return "", "", errors.New("no base snapshot found")
+			return "", "", nil, errors.New("no base snapshot found")
 		}
 	}
 	if err != nil {
-		return "", "", errors.Wrap(err, "get backup data")
+		return "", "", nil, errors.Wrap(err, "get backup data")
 	}
 	if len(nss) != 0 && bcp.Type != defs.LogicalBackup {
-		return "", "", errors.New("--ns flag is only allowed for logical restore")
+		return "", "", nil, errors.New("--ns flag is only allowed for logical restore")
 	}
 	if nsFrom != "" && nsTo != "" && bcp.Type != defs.LogicalBackup {
-		return "", "", errors.New("--ns-from and ns-to flags are only allowed for logical restore")
+		return "", "", nil, errors.New("--ns-from and ns-to flags are only allowed for logical restore")
+	}
+	if o.dbpathMap != "" && bcp.Type == defs.LogicalBackup {
+		return "", "", nil, errors.New("--dbpath-map flag is only allowed for physical restore")
+	}
+	if err := backup.CheckOrphaned(bcp); err != nil {
+		return "", "", nil, err
 	}
 	if bcp.Status != defs.StatusDone {
-		return "", "", errors.Errorf("backup '%s' didn't finish successfully", b)
+		return "", "", nil, errors.Errorf("backup '%s' didn't finish successfully", b)
+	}
+	if err := checkAgentVersions(ctx, conn, bcp); err != nil {
+		return "", "", nil, err
+	}
+	if o.usersAndRolesOnly && bcp.Type != defs.LogicalBackup {
+		return "", "", nil, errors.New("--users-and-roles-only flag is only allowed for logical restore")
+	}
+	if !o.drop && bcp.Type != defs.LogicalBackup {
+		return "", "", nil, errors.New("--drop=false is only allowed for logical restore")
+	}
+	if o.usersAndRolesOnly && util.IsSelective(bcp.Namespaces) {
+		return "", "", nil, errors.Errorf("backup '%s' is selective and has no users and roles", b)
+	}
+	if o.pitr != "" && len(bcp.ExcludedDBs) != 0 {
+		return "", "", nil, errors.Errorf("backup '%s' excludes databases %s "+
+			"and cannot be a base for point-in-time recovery", b, strings.Join(bcp.ExcludedDBs, ", "))
+	}
+	if err := restore.CheckSourceCluster(bcp, o.sourceCluster); err != nil {
+		return "", "", nil, err
+	}
+	if o.pitr != "" && bcp.IsForeign() {
+		return "", "", nil, errors.Errorf("backup '%s' is made on another cluster. "+
+			"Point-in-time restore from it is not supported", bcp.Name)
+	}
+	if err := validateRestoreReplset(bcp, o.replset, rsMap); err != nil {
+		return "", "", nil, err
+	}
+	if bcp.Type == defs.LogicalBackup {
+		viaMongos, err := checkRestoreViaMongos(ctx, conn, o, nsFrom, nsTo, rsMap)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if viaMongos {
+			// mongos places the data by the chunks of the cluster
+			return bcp.Name, bcp.Type, nil, nil
+		}
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "get cluster members")
+	}
+	if o.replset == "" && restore.ShardCountDiffers(bcp, shards) {
+		plan, err := planShardCount(ctx, conn, o, bcp, shards, nss, rsMap, node)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return bcp.Name, bcp.Type, plan, nil
+	}
+
+	noData, err := checkRSMapping(bcp, shards, rsMap, o.replset)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(noData) != 0 {
+		if bcp.Type != defs.LogicalBackup {
+			return "", "", nil, errors.Errorf("backup '%s' has no data for replsets: %s. "+
+				"Use --%s to map the backup replsets to them", bcp.Name, strings.Join(noData, ", "), RSMappingFlag)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: backup '%s' has no data for replsets: %s. Their data is not restored\n",
+			bcp.Name, strings.Join(noData, ", "))
+	}
+
+	return bcp.Name, bcp.Type, nil, nil
+}
+
+// checkRestoreViaMongos returns true if the logical restore goes through
+// mongos (`restore.viaMongos` in sharded cluster) and its options allow it.
+func checkRestoreViaMongos(
+	ctx context.Context,
+	conn connect.Client,
+	o *restoreOpts,
+	nsFrom string,
+	nsTo string,
+	rsMap map[string]string,
+) (bool, error) {
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		return false, errors.Wrap(err, "get config")
+	}
+	if !cfg.Restore.IsViaMongos() {
+		return false, nil
+	}
+
+	inf, err := topo.GetNodeInfo(ctx, conn.MongoClient())
+	if err != nil {
+		return false, errors.Wrap(err, "get cluster info")
+	}
+	if !inf.IsSharded() {
+		return false, nil
+	}
+
+	switch {
+	case o.pitr != "":
+		return false, errors.New("point-in-time restore is not supported with restore.viaMongos")
+	case o.replset != "":
+		return false, errors.New("--rs flag isn't allowed with restore.viaMongos")
+	case len(rsMap) != 0:
+		return false, errors.Errorf("--%s flag isn't allowed with restore.viaMongos", RSMappingFlag)
+	case nsFrom != "" || nsTo != "":
+		return false, errors.New("--ns-from and ns-to flags aren't allowed with restore.viaMongos")
 	}
 
-	return bcp.Name, bcp.Type, nil
+	return true, nil
+}
+
+// checkRSMapping checks that the replset mapping is total and injective for
+// the backup and the cluster: each backup replset is restored to an existing
+// replset of the cluster and no two backup replsets are restored to the same
+// one. It returns the cluster replsets no backup replset is restored to.
+// If rs is set, only the replset rs is restored.
+func checkRSMapping(
+	bcp *backup.BackupMeta,
+	shards []topo.Shard,
+	rsMap map[string]string,
+	rs string,
+) ([]string, error) {
+	cluster := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		cluster[s.RS] = true
+	}
+	inBcp := make(map[string]bool, len(bcp.Replsets))
+	for i := range bcp.Replsets {
+		inBcp[bcp.Replsets[i].Name] = true
+	}
+
+	for from, to := range rsMap {
+		if !inBcp[from] {
+			return nil, errors.Errorf("replset %q of the mapping is not in the backup", from)
+		}
+		if !cluster[to] {
+			return nil, errors.Errorf("replset %q of the mapping is not in the cluster", to)
+		}
+	}
+	if rs != "" {
+		return nil, nil
+	}
+
+	mapRS := util.MakeRSMapFunc(rsMap)
+	restored := make(map[string]string, len(bcp.Replsets))
+	var missed []string
+	for i := range bcp.Replsets {
+		from := bcp.Replsets[i].Name
+		to := mapRS(from)
+		if !cluster[to] {
+			missed = append(missed, from)
+			continue
+		}
+		if other, ok := restored[to]; ok {
+			return nil, errors.Errorf("backup replsets %q and %q are both restored to replset %q",
+				other, from, to)
+		}
+		restored[to] = from
+	}
+	if len(missed) != 0 {
+		sort.Strings(missed)
+		return nil, errors.Errorf("backup replsets %s are not in the cluster. Use --%s to map them",
+			strings.Join(missed, ", "), RSMappingFlag)
+	}
+
+	var noData []string
+	for _, s := range shards {
+		if _, ok := restored[s.RS]; !ok {
+			noData = append(noData, s.RS)
+		}
+	}
+	sort.Strings(noData)
+
+	return noData, nil
+}
+
+// planShardCount places the shards of the backup on the shards of the cluster
+// with another number of shards and warns about the estimated data size of
+// each shard. Only logical backups can be restored so. Several backup shards
+// restored to the same shard are only supported for the full snapshot restore.
+func planShardCount(
+	ctx context.Context,
+	conn connect.Client,
+	o *restoreOpts,
+	bcp *backup.BackupMeta,
+	shards []topo.Shard,
+	nss []string,
+	rsMap map[string]string,
+	node string,
+) (*restore.ShardPlan, error) {
+	diff := fmt.Sprintf("backup '%s' has %d shards, the cluster has %d",
+		bcp.Name, len(bcp.Replsets)-1, len(shards)-1)
+	if bcp.Type != defs.LogicalBackup {
+		return nil, errors.Errorf("%s. Only a logical backup can be restored "+
+			"to a cluster with another number of shards", diff)
+	}
+	hasConfigShard, err := topo.HasConfigShard(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "check for config shard")
+	}
+	if hasConfigShard {
+		return nil, errors.Errorf("%s. Restore to a cluster with config shard "+
+			"and another number of shards is not supported", diff)
+	}
+
+	err = backup.LoadNSStats(bcp, node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "read namespaces stats")
+	}
+	plan, err := restore.PlanShards(bcp, shards, rsMap)
+	if err != nil {
+		return nil, errors.Wrap(err, diff)
+	}
+	if len(plan.Merge) != 0 {
+		if o.pitr != "" {
+			return nil, errors.Errorf("%s. Point-in-time restore to a cluster "+
+				"with fewer shards is not supported", diff)
+		}
+		if util.IsSelective(nss) {
+			return nil, errors.Errorf("%s. Selective restore to a cluster "+
+				"with fewer shards is not supported", diff)
+		}
+	}
+
+	var b strings.Builder
+	for _, s := range shards {
+		if s.ID == "config" {
+			continue
+		}
+		srcs := plan.Placement[s.RS]
+		if len(srcs) == 0 {
+			fmt.Fprintf(&b, "  %s: no data\n", s.RS)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %s (about %s)\n",
+			s.RS, strings.Join(srcs, ", "), storage.PrettySize(plan.Size[s.RS]))
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s. The backup shards are restored to:\n%s"+
+		"Make sure the shards have enough disk space for the data. "+
+		"The balancer distributes the chunks over the shards after the restore.\n", diff, b.String())
+
+	return plan, nil
+}
+
+// validateRestoreReplsetOpts checks the options of the restore of the single replset.
+func validateRestoreReplsetOpts(ctx context.Context, conn connect.Client, o *restoreOpts) error {
+	if o.pitr != "" {
+		return errors.New("--rs flag isn't allowed for point-in-time restore")
+	}
+	if o.extern {
+		return errors.New("--rs flag isn't allowed for external restore")
+	}
+
+	inf, err := topo.GetNodeInfo(ctx, conn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster info")
+	}
+	if !inf.IsSharded() {
+		return errors.New("--rs flag is only allowed for sharded cluster")
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+	for _, sh := range shards {
+		if sh.RS == o.replset {
+			fmt.Fprintf(os.Stderr, "WARNING: only replset %q is restored. The config server and "+
+				"other shards are untouched, their consistency with the restored data is up to you.\n", o.replset)
+			return nil
+		}
+	}
+
+	return errors.Errorf("replset %q is not found in the cluster", o.replset)
+}
+
+// validateRestoreReplset checks that the backup can be restored to the replset
+// rs (or to the whole cluster if rs is empty). The replset is restored from
+// its own data of the backup unless it is remapped with rsMap.
+func validateRestoreReplset(bcp *backup.BackupMeta, rs string, rsMap map[string]string) error {
+	if rs == "" {
+		if bcp.SingleRS != "" {
+			return errors.Errorf("backup '%s' is a partial backup of replset %q. Use --rs to restore it",
+				bcp.Name, bcp.SingleRS)
+		}
+		return nil
+	}
+
+	src := util.MakeReverseRSMapFunc(rsMap)(rs)
+	if bcp.SingleRS != "" && bcp.SingleRS != src {
+		return errors.Errorf("backup '%s' has the data of replset %q, not %q. "+
+			"Use --%s to restore it to another replset", bcp.Name, bcp.SingleRS, src, RSMappingFlag)
+	}
+	for i := range bcp.Replsets {
+		if bcp.Replsets[i].Name == src {
+			return nil
+		}
+	}
+
+	return errors.Errorf("backup '%s' has no data of replset %q", bcp.Name, src)
+}
+
+// pitrLatest is the `--time` value to restore to the most recent time
+// covered by oplog chunks of all replsets.
+const pitrLatest = "latest"
+
+// checkAgentVersions returns an error if any agent runs a lower PBM
+// version than the backup requires (see backup.BackupMeta.RequiredPBM)
+func checkAgentVersions(ctx context.Context, conn connect.Client, bcp *backup.BackupMeta) error {
+	agents, err := topo.ListAgentStatuses(ctx, conn)
+	if err != nil {
+		return errors.Wrap(err, "get agents")
+	}
+
+	return backup.CheckRestoreVersion(bcp, agentVersions(agents)...)
+}
+
+func agentVersions(agents []topo.AgentStat) []string {
+	rv := make([]string, 0, len(agents))
+	for i := range agents {
+		if agents[i].AgentVer != "" {
+			rv = append(rv, strings.TrimPrefix(agents[i].AgentVer, "v"))
+		}
+	}
+	return rv
+}
+
+// latestPITR is the target of `--time latest`
+type latestPITR struct {
+	Time primitive.Timestamp `json:"time"`
+	Base string              `json:"baseSnapshot"`
+	// LimitedBy is the replset which oplog constrains the time
+	LimitedBy string `json:"limitedBy"`
+
+	limit restore.PITRLimit
+	// shown is true if it's already shown for the confirmation
+	shown bool
+}
+
+func (l *latestPITR) String() string {
+	return fmt.Sprintf("Latest restorable time: %s <%d,%d> based on snapshot '%s'\nLimited by %s",
+		fmtTS(int64(l.Time.T)), l.Time.T, l.Time.I, l.Base, l.limit)
+}
+
+// resolveLatestPITR replaces `--time latest` with the most recent time
+// every replset of the base snapshot has contiguous oplog up to and
+// returns it. The base snapshot is the `--base-snapshot` or the most
+// recent one.
+func resolveLatestPITR(ctx context.Context, conn connect.Client, o *restoreOpts) (*latestPITR, error) {
+	var bcp *backup.BackupMeta
+	var err error
+	if o.pitrBase != "" {
+		bcp, err = backup.NewDBManager(conn).GetBackupByName(ctx, o.pitrBase)
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil, errors.WithCode(errors.Errorf("backup '%s' not found", o.pitrBase), errors.CodeBackupNotFound)
+		}
+	} else {
+		bcp, err = backup.GetLastBackup(ctx, conn, nil)
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil, errors.New("no base snapshot found")
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+	if err := backup.CheckOrphaned(bcp); err != nil {
+		return nil, err
+	}
+	if bcp.Status != defs.StatusDone {
+		return nil, errors.Errorf("backup '%s' didn't finish successfully", bcp.Name)
+	}
+
+	chunks := make(map[string][]oplog.OplogChunk, len(bcp.Replsets))
+	for _, rs := range bcp.Replsets {
+		chunks[rs.Name], err = oplog.PITRGetChunksSlice(ctx, conn, rs.Name, bcp.LastWriteTS, primitive.Timestamp{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "get chunks for %s", rs.Name)
+		}
+	}
+
+	ts, limit, err := restore.LatestPITRTime(bcp.LastWriteTS, chunks)
+	if err != nil {
+		return nil, errors.Wrapf(err, "base snapshot '%s'", bcp.Name)
+	}
+
+	rv := &latestPITR{Time: ts, Base: bcp.Name, LimitedBy: limit.RS, limit: limit}
+	if !o.yes {
+		fmt.Println(rv)
+		rv.shown = true
+		if err := askConfirmation("Restore to this time?"); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return nil, err
+			}
+			return nil, errors.Wrap(err, "ask confirmation (use --yes to skip it)")
+		}
+	}
+
+	o.pitr = fmt.Sprintf("%d,%d", ts.T, ts.I)
+	o.pitrBase = bcp.Name
+	return rv, nil
 }
 
 // nsIsTaken returns error in case when specified namesapce is already in use (collection is created)
@@ -378,6 +1038,7 @@ func nsIsTaken(
 func doRestore(
 	ctx context.Context,
 	conn connect.Client,
+	mURL string,
 	o *restoreOpts,
 	numParallelColls *int32,
 	numInsertionWorkers *int32,
@@ -385,13 +1046,18 @@ func doRestore(
 	nsFrom string,
 	nsTo string,
 	rsMapping map[string]string,
+	dbpathMapping map[string]string,
 	node string,
 	outf outFormat,
 ) (*restore.RestoreMeta, error) {
-	bcp, bcpType, err := checkBackup(ctx, conn, o, nss, nsFrom, nsTo)
+	bcp, bcpType, plan, err := checkBackup(ctx, conn, o, nss, nsFrom, nsTo, rsMapping, node)
 	if err != nil {
 		return nil, err
 	}
+	var merge map[string][]string
+	if plan != nil {
+		rsMapping, merge = plan.RSMap, plan.Merge
+	}
 
 	// check if namespace exists when cloning collection
 	if nsFrom != "" && nsTo != "" {
@@ -400,10 +1066,40 @@ func doRestore(
 		}
 	}
 
+	// the clone, the users and roles only and the no-drop restores keep
+	// the collections of the target, so there is nothing to check
+	var targetCheck *ctrl.RestoreTargetCheck
+	if bcp != "" && nsFrom == "" && !o.usersAndRolesOnly && o.drop {
+		targetCheck, err = checkRestoreTarget(ctx, conn, mURL, o, bcp, nss, rsMapping, merge, node, outf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var nsConflicts *ctrl.RestoreNSConflicts
+	if !o.drop {
+		nsConflicts, err = checkNSConflicts(ctx, conn, o, bcp, nss, rsMapping, node, outf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the agents disable PITR for the restore. It's enabled back after
+	// the restore if it's on now. The users and roles only restore
+	// doesn't touch it.
+	var pitrOn bool
+	if !o.usersAndRolesOnly {
+		pitrOn, _, err = config.IsPITREnabled(ctx, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "check PITR")
+		}
+	}
+
 	name := time.Now().UTC().Format(time.RFC3339Nano)
 
+	initiator := ctrl.NewInitiator(ctx, conn)
 	cmd := ctrl.Cmd{
-		Cmd: ctrl.CmdRestore,
+		Cmd:       ctrl.CmdRestore,
+		Initiator: initiator,
 		Restore: &ctrl.RestoreCmd{
 			Name:                name,
 			BackupName:          bcp,
@@ -413,10 +1109,25 @@ func doRestore(
 			NamespaceFrom:       nsFrom,
 			NamespaceTo:         nsTo,
 			UsersAndRoles:       o.usersAndRoles,
+			UsersAndRolesOnly:   o.usersAndRolesOnly,
 			RSMap:               rsMapping,
+			Replset:             o.replset,
+			Merge:               merge,
 			External:            o.extern,
+			DBpathMap:           dbpathMapping,
+			SourceCluster:       o.sourceCluster,
+			TargetCheck:         targetCheck,
+			NoDrop:              !o.drop,
+			NSConflicts:         nsConflicts,
+			SkipCollOptions:     o.skipCollOptions,
+			KeepProfiling:       o.keepProfiling,
+			Initiator:           initiator.String(),
+			PITREnabled:         pitrOn,
 		},
 	}
+	if o.usersAndRolesOnly {
+		cmd.Restore.UsersAndRolesMode = o.usersAndRolesMode
+	}
 	if o.pitr != "" {
 		cmd.Restore.OplogTS, err = parseTS(o.pitr)
 		if err != nil {
@@ -573,7 +1284,7 @@ func waitForRestoreStatus(
 			switch meta.Status {
 			case defs.StatusRunning, defs.StatusDumpDone, defs.StatusDone:
 				return meta, nil
-			case defs.StatusError:
+			case defs.StatusError, defs.StatusAborted:
 				rs := ""
 				for _, s := range meta.Replsets {
 					rs += fmt.Sprintf("\n- Restore on replicaset \"%s\" in state: %v", s.Name, s.Status)
@@ -581,7 +1292,7 @@ func waitForRestoreStatus(
 						rs += ": " + s.Error
 					}
 				}
-				return nil, errors.New(meta.Error + rs)
+				return nil, errors.WithCode(errors.New(meta.Error+rs), meta.FailureCode())
 			}
 		case <-ctx.Done():
 			rs := ""
@@ -605,35 +1316,89 @@ func waitForRestoreStatus(
 type descrRestoreOpts struct {
 	restore string
 	cfg     string
+	watch   bool
 }
 
 type describeRestoreResult struct {
-	Name               string           `json:"name" yaml:"name"`
-	OPID               string           `json:"opid" yaml:"opid"`
-	Backup             string           `json:"backup" yaml:"backup"`
-	Type               defs.BackupType  `json:"type" yaml:"type"`
-	Status             defs.Status      `json:"status" yaml:"status"`
-	Error              *string          `json:"error,omitempty" yaml:"error,omitempty"`
-	Namespaces         []string         `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
-	StartTS            *int64           `json:"start_ts,omitempty" yaml:"-"`
-	StartTime          *string          `json:"start,omitempty" yaml:"start,omitempty"`
-	FinishTime         *string          `json:"finish,omitempty" yaml:"finish,omitempty"`
-	PITR               *int64           `json:"ts_to_restore,omitempty" yaml:"-"`
-	PITRTime           *string          `json:"time_to_restore,omitempty" yaml:"time_to_restore,omitempty"`
-	LastTransitionTS   int64            `json:"last_transition_ts" yaml:"-"`
-	LastTransitionTime string           `json:"last_transition_time" yaml:"last_transition_time"`
-	Replsets           []RestoreReplset `json:"replsets" yaml:"replsets"`
+	// SchemaVersion is the version of the restore record.
+	// 0 is the record of an older PBM version.
+	SchemaVersion      int                   `json:"schema_version" yaml:"-"`
+	Name               string                `json:"name" yaml:"name"`
+	OPID               string                `json:"opid" yaml:"opid"`
+	Initiator          string                `json:"initiator,omitempty" yaml:"initiator,omitempty"`
+	Backup             string                `json:"backup" yaml:"backup"`
+	Type               defs.BackupType       `json:"type" yaml:"type"`
+	Status             defs.Status           `json:"status" yaml:"status"`
+	Error              *string               `json:"error,omitempty" yaml:"error,omitempty"`
+	ErrorCode          errors.Code           `json:"error_code,omitempty" yaml:"error_code,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	SingleRS           string                `json:"single_rs,omitempty" yaml:"single_rs,omitempty"`
+	RSMap              []string              `json:"replset_remapping,omitempty" yaml:"replset_remapping,omitempty"`
+	Merge              []string              `json:"merged_replsets,omitempty" yaml:"merged_replsets,omitempty"`
+	StartTS            *int64                `json:"start_ts,omitempty" yaml:"-"`
+	StartTime          *string               `json:"start,omitempty" yaml:"start,omitempty"`
+	FinishTime         *string               `json:"finish,omitempty" yaml:"finish,omitempty"`
+	PITR               *int64                `json:"ts_to_restore,omitempty" yaml:"-"`
+	PITRTime           *string               `json:"time_to_restore,omitempty" yaml:"time_to_restore,omitempty"`
+	LastTransitionTS   int64                 `json:"last_transition_ts" yaml:"-"`
+	LastTransitionTime string                `json:"last_transition_time" yaml:"last_transition_time"`
+	Phases             []restore.PhaseTiming `json:"phases,omitempty" yaml:"phases,omitempty"`
+	Replsets           []RestoreReplset      `json:"replsets" yaml:"replsets"`
+
+	Options *restore.RestoreOptions `json:"options,omitempty" yaml:"-"`
+	Stat    *phys.RestoreStat       `json:"stat,omitempty" yaml:"-"`
+
+	Rebalance    *restore.RebalanceProgress `json:"rebalance,omitempty" yaml:"-"`
+	RebalanceStr *string                    `json:"-" yaml:"rebalance,omitempty"`
+
+	Sharding    *restore.ShardingResult `json:"sharding,omitempty" yaml:"-"`
+	ShardingStr *string                 `json:"-" yaml:"sharding,omitempty"`
+
+	TargetCheck    *ctrl.RestoreTargetCheck `json:"target_check,omitempty" yaml:"-"`
+	TargetCheckStr *string                  `json:"-" yaml:"target_check,omitempty"`
+
+	NSConflicts    *ctrl.RestoreNSConflicts `json:"ns_conflicts,omitempty" yaml:"-"`
+	NSConflictsStr *string                  `json:"-" yaml:"ns_conflicts,omitempty"`
+
+	Abort    *restore.AbortInfo `json:"abort,omitempty" yaml:"-"`
+	AbortStr *string            `json:"-" yaml:"abort,omitempty"`
 }
 
 type RestoreReplset struct {
-	Name               string        `json:"name" yaml:"name"`
-	Status             defs.Status   `json:"status" yaml:"status"`
-	PartialTxn         []db.Oplog    `json:"partial_txn,omitempty" yaml:"-"`
-	PartialTxnStr      *string       `json:"-" yaml:"partial_txn,omitempty"`
-	LastTransitionTS   int64         `json:"last_transition_ts" yaml:"-"`
-	LastTransitionTime string        `json:"last_transition_time" yaml:"last_transition_time"`
-	Nodes              []RestoreNode `json:"nodes,omitempty" yaml:"nodes,omitempty"`
-	Error              *string       `json:"error,omitempty" yaml:"error,omitempty"`
+	Name               string                       `json:"name" yaml:"name"`
+	Status             defs.Status                  `json:"status" yaml:"status"`
+	PartialTxn         []db.Oplog                   `json:"partial_txn,omitempty" yaml:"-"`
+	PartialTxnStr      *string                      `json:"-" yaml:"partial_txn,omitempty"`
+	OplogProgress      *restore.OplogProgress       `json:"oplog_progress,omitempty" yaml:"-"`
+	OplogProgressStr   *string                      `json:"-" yaml:"oplog_progress,omitempty"`
+	IndexBuilds        *restore.IndexBuildProgress  `json:"index_builds,omitempty" yaml:"-"`
+	IndexBuildsStr     *string                      `json:"-" yaml:"index_builds,omitempty"`
+	NSPriority         *restore.NSPriorityProgress  `json:"ns_priority,omitempty" yaml:"-"`
+	NSPriorityStr      *string                      `json:"-" yaml:"ns_priority,omitempty"`
+	NSLoad             *restore.NSLoadProgress      `json:"ns_load,omitempty" yaml:"-"`
+	NSLoadStr          *string                      `json:"-" yaml:"ns_load,omitempty"`
+	ParallelDBs        *restore.ParallelDBsProgress `json:"parallel_dbs,omitempty" yaml:"-"`
+	ParallelDBsStr     *string                      `json:"-" yaml:"parallel_dbs,omitempty"`
+	LastTransitionTS   int64                        `json:"last_transition_ts" yaml:"-"`
+	LastTransitionTime string                       `json:"last_transition_time" yaml:"last_transition_time"`
+	Phases             []restore.PhaseTiming        `json:"phases,omitempty" yaml:"phases,omitempty"`
+	Bytes              int64                        `json:"bytes,omitempty" yaml:"-"`
+	BytesStr           string                       `json:"-" yaml:"bytes,omitempty"`
+	CountCheck         *restore.CountCheck          `json:"count_check,omitempty" yaml:"-"`
+	CountCheckStr      *string                      `json:"-" yaml:"count_check,omitempty"`
+	Principals         *restore.PrincipalsChange    `json:"principals,omitempty" yaml:"-"`
+	PrincipalsStr      *string                      `json:"-" yaml:"principals,omitempty"`
+	Transfers          []storage.TransferStats      `json:"transfers,omitempty" yaml:"-"`
+	Failures           *restore.RestoreFailures     `json:"failures,omitempty" yaml:"-"`
+	FailuresStr        *string                      `json:"-" yaml:"failures,omitempty"`
+	CollOptions        *restore.CollOptionsResult   `json:"coll_options,omitempty" yaml:"-"`
+	CollOptionsStr     *string                      `json:"-" yaml:"coll_options,omitempty"`
+	Profiling          *restore.ProfilingPause      `json:"profiling,omitempty" yaml:"-"`
+	ProfilingStr       *string                      `json:"-" yaml:"profiling,omitempty"`
+	Throttling         *restore.Throttling          `json:"throttling,omitempty" yaml:"-"`
+	ThrottlingStr      *string                      `json:"-" yaml:"throttling,omitempty"`
+	Nodes              []RestoreNode                `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	Error              *string                      `json:"error,omitempty" yaml:"error,omitempty"`
 }
 
 type RestoreNode struct {
@@ -650,7 +1415,53 @@ func (r describeRestoreResult) String() string {
 		return fmt.Sprintln("error:", err)
 	}
 
-	return string(b)
+	var s strings.Builder
+	s.Write(b)
+	for _, rs := range r.Replsets {
+		if rs.Failures == nil || len(rs.Failures.List) == 0 {
+			continue
+		}
+		fmt.Fprintf(&s, "\nFailures (%s):\n", rs.Name)
+		writeRestoreFailures(&s, rs.Failures)
+	}
+
+	return s.String()
+}
+
+func writeRestoreFailures(out io.Writer, f *restore.RestoreFailures) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NS\tID\tCODE\tERROR")
+	for _, fl := range f.List {
+		code := ""
+		if fl.Code != 0 {
+			code = strconv.Itoa(fl.Code)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", fl.NS, fl.ID, code, fl.Error)
+	}
+	w.Flush()
+}
+
+func restoreFailuresString(f *restore.RestoreFailures) string {
+	s := fmt.Sprintf("%d failed writes", f.Total)
+	if n := int64(len(f.List)); n < f.Total {
+		s += fmt.Sprintf(" (%d listed)", n)
+	}
+	var nss []string
+	for _, ns := range f.Namespaces {
+		nss = append(nss, fmt.Sprintf("%s: %d", ns.NS, ns.Count))
+	}
+	if len(nss) != 0 {
+		s += "; " + strings.Join(nss, ", ")
+	}
+	var codes []string
+	for _, d := range f.Dropped {
+		codes = append(codes, fmt.Sprintf("E%d: %d", d.Code, d.Count))
+	}
+	if len(codes) != 0 {
+		s += "; not listed by code: " + strings.Join(codes, ", ")
+	}
+
+	return s
 }
 
 func getRestoreMetaStg(cfgPath, node string) (storage.Storage, error) {
@@ -669,6 +1480,37 @@ func getRestoreMetaStg(cfgPath, node string) (storage.Storage, error) {
 	return util.StorageFromConfig(&cfg.Storage, node, l)
 }
 
+// watchRestore prints the progress of the restore until it is finished,
+// then describes the restore.
+func watchRestore(
+	ctx context.Context,
+	conn connect.Client,
+	o descrRestoreOpts,
+	node string,
+	outf outFormat,
+) (fmt.Stringer, error) {
+	if o.cfg != "" {
+		return nil, errors.New("--watch is not supported with --config")
+	}
+
+	meta, err := restore.GetRestoreMeta(ctx, conn, o.restore)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+
+	switch meta.Status {
+	case defs.StatusDone, defs.StatusPartlyDone, defs.StatusError, defs.StatusAborted:
+	default:
+		prg := newRestoreProgressPrinter(os.Stdout, outf)
+		err = waitRestore(ctx, conn, meta, node, defs.StatusDone, 0, prg.print)
+		if err != nil && !errors.Is(err, restoreFailedError{}) {
+			return nil, err
+		}
+	}
+
+	return describeRestore(ctx, conn, o, node)
+}
+
 func describeRestore(
 	ctx context.Context,
 	conn connect.Client,
@@ -701,11 +1543,20 @@ func describeRestore(
 		return nil, errors.New("undefined restore meta")
 	}
 
+	now := time.Now().Unix()
+	res.SchemaVersion = meta.SchemaVersion
 	res.Name = meta.Name
+	res.Initiator = meta.Initiator
+	res.Options = meta.Options
+	res.Stat = meta.Stat
+	res.Phases = meta.Conditions.Phases(now)
 	res.Backup = meta.Backup
 	res.Type = meta.Type
 	res.Status = meta.Status
 	res.Namespaces = meta.Namespaces
+	res.SingleRS = meta.SingleRS
+	res.RSMap = formatRSMapping(meta.RSMap)
+	res.Merge = formatMerge(meta.Merge)
 	res.OPID = meta.OPID
 	res.LastTransitionTS = meta.LastTransitionTS
 	res.LastTransitionTime = time.Unix(res.LastTransitionTS, 0).UTC().Format(time.RFC3339)
@@ -713,13 +1564,34 @@ func describeRestore(
 	if meta.Status == defs.StatusDone {
 		res.FinishTime = util.Ref(time.Unix(meta.LastTransitionTS, 0).UTC().Format(time.RFC3339))
 	}
-	if meta.Status == defs.StatusError {
+	if meta.Status == defs.StatusError || meta.Status == defs.StatusAborted {
 		res.Error = &meta.Error
+		res.ErrorCode = meta.FailureCode()
+	}
+	if meta.Abort != nil {
+		res.Abort = meta.Abort
+		res.AbortStr = util.Ref(meta.Abort.String())
 	}
 	if meta.PITR != 0 {
 		res.PITR = &meta.PITR
 		res.PITRTime = util.Ref(time.Unix(meta.PITR, 0).UTC().Format(time.RFC3339))
 	}
+	if meta.Rebalance != nil {
+		res.Rebalance = meta.Rebalance
+		res.RebalanceStr = util.Ref(meta.Rebalance.String())
+	}
+	if meta.Sharding != nil {
+		res.Sharding = meta.Sharding
+		res.ShardingStr = util.Ref(meta.Sharding.String())
+	}
+	if meta.TargetCheck != nil {
+		res.TargetCheck = meta.TargetCheck
+		res.TargetCheckStr = util.Ref(meta.TargetCheck.String())
+	}
+	if meta.NSConflicts != nil {
+		res.NSConflicts = meta.NSConflicts
+		res.NSConflictsStr = util.Ref(meta.NSConflicts.String())
+	}
 
 	for _, rs := range meta.Replsets {
 		mrs := RestoreReplset{
@@ -728,6 +1600,56 @@ func describeRestore(
 			LastTransitionTS:   rs.LastTransitionTS,
 			PartialTxn:         rs.PartialTxn,
 			LastTransitionTime: time.Unix(rs.LastTransitionTS, 0).UTC().Format(time.RFC3339),
+			Phases:             rs.Conditions.Phases(now),
+			Bytes:              rs.BytesRestored(),
+			CountCheck:         rs.CountCheck,
+			Transfers:          rs.Transfers,
+		}
+		if mrs.Bytes != 0 {
+			mrs.BytesStr = storage.PrettySize(mrs.Bytes)
+		}
+		if rs.CountCheck != nil {
+			mrs.CountCheckStr = util.Ref(rs.CountCheck.String())
+		}
+		if rs.Failures != nil {
+			mrs.Failures = rs.Failures
+			mrs.FailuresStr = util.Ref(restoreFailuresString(rs.Failures))
+		}
+		if rs.CollOptions != nil {
+			mrs.CollOptions = rs.CollOptions
+			mrs.CollOptionsStr = util.Ref(rs.CollOptions.String())
+		}
+		if rs.Profiling != nil {
+			mrs.Profiling = rs.Profiling
+			mrs.ProfilingStr = util.Ref(rs.Profiling.String())
+		}
+		if rs.Throttling != nil {
+			mrs.Throttling = rs.Throttling
+			mrs.ThrottlingStr = util.Ref(rs.Throttling.String())
+		}
+		if rs.Principals != nil {
+			mrs.Principals = rs.Principals
+			mrs.PrincipalsStr = util.Ref(rs.Principals.String())
+		}
+		if rs.OplogProgress != nil {
+			mrs.OplogProgress = rs.OplogProgress
+			mrs.OplogProgressStr = util.Ref(rs.OplogProgress.String())
+		}
+		if rs.IndexBuilds != nil {
+			mrs.IndexBuilds = rs.IndexBuilds
+			mrs.IndexBuildsStr = util.Ref(rs.IndexBuilds.String())
+		}
+		if rs.NSPriority != nil {
+			mrs.NSPriority = rs.NSPriority
+			mrs.NSPriorityStr = util.Ref(rs.NSPriority.String())
+		}
+		if rs.NSLoad != nil {
+			mrs.NSLoad = rs.NSLoad
+			mrs.NSLoadStr = util.Ref(rs.NSLoad.String())
+		}
+		if rs.ParallelDBs != nil {
+			mrs.ParallelDBs = rs.ParallelDBs
+			mrs.ParallelDBsStr = util.Ref(rs.ParallelDBs.String())
 		}
 		if rs.Status == defs.StatusError {
 			mrs.Error = &rs.Error
@@ -770,6 +1692,38 @@ func describeRestore(
 	return res, nil
 }
 
+// formatRSMapping returns the mapping of the backup replsets to the target
+// ones in the format of the --replset-remapping flag (to=from).
+func formatRSMapping(rsMap map[string]string) []string {
+	if len(rsMap) == 0 {
+		return nil
+	}
+
+	rv := make([]string, 0, len(rsMap))
+	for from, to := range rsMap {
+		rv = append(rv, to+"="+from)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// formatMerge returns the backup replsets merged to the target ones
+// as "to=from1,from2".
+func formatMerge(merge map[string][]string) []string {
+	if len(merge) == 0 {
+		return nil
+	}
+
+	rv := make([]string, 0, len(merge))
+	for to, from := range merge {
+		rv = append(rv, to+"="+strings.Join(from, ","))
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
 func validateRestoreUsersAndRoles(usersAndRoles bool, nss []string) error {
 	if !util.IsSelective(nss) && usersAndRoles {
 		return errors.New("Including users and roles are only allowed for selected database " +
@@ -783,6 +1737,85 @@ func validateRestoreUsersAndRoles(usersAndRoles bool, nss []string) error {
 	return nil
 }
 
+// validateUsersAndRolesOnly checks the options of the restore of users
+// and roles without the data.
+func validateUsersAndRolesOnly(o *restoreOpts) error {
+	if !o.usersAndRolesOnly {
+		return nil
+	}
+
+	switch {
+	case o.bcp == "":
+		return errors.New("backup name is required")
+	case o.pitr != "" || o.extern:
+		return errors.New("not allowed for point-in-time and external restore")
+	case o.ns != "" || o.nsFrom != "" || o.nsTo != "" || o.usersAndRoles:
+		return errors.New("not allowed with --ns, --ns-from, --ns-to and --with-users-and-roles")
+	case o.replset != "" || o.dbpathMap != "":
+		return errors.New("not allowed with --rs and --dbpath-map")
+	}
+
+	switch o.usersAndRolesMode {
+	case ctrl.UsersAndRolesMerge, ctrl.UsersAndRolesReplace:
+	default:
+		return errors.Errorf("unknown --users-and-roles-mode %q, expected %q or %q",
+			o.usersAndRolesMode, ctrl.UsersAndRolesMerge, ctrl.UsersAndRolesReplace)
+	}
+
+	return nil
+}
+
+// validateNoDrop checks the options of the restore without drop
+func validateNoDrop(o *restoreOpts) error {
+	if o.drop {
+		return nil
+	}
+
+	switch {
+	case o.extern:
+		return errors.New("not allowed for external restore")
+	case o.usersAndRolesOnly:
+		return errors.New("not allowed with --users-and-roles-only")
+	case o.nsFrom != "" || o.nsTo != "":
+		return errors.New("not allowed with --ns-from and --ns-to, cloning doesn't drop collections")
+	case o.replset != "" || o.dbpathMap != "":
+		return errors.New("not allowed with --rs and --dbpath-map")
+	}
+
+	switch o.nsConflict {
+	case ctrl.NSConflictAbort, ctrl.NSConflictSkip, ctrl.NSConflictRecreate:
+	default:
+		return errors.Errorf("unknown --ns-conflict %q, expected %q, %q or %q",
+			o.nsConflict, ctrl.NSConflictAbort, ctrl.NSConflictSkip, ctrl.NSConflictRecreate)
+	}
+
+	return nil
+}
+
+// configsvrReplset returns the config server replset of the sharded
+// cluster. Empty for non-sharded cluster.
+func configsvrReplset(ctx context.Context, conn connect.Client) (string, error) {
+	inf, err := topo.GetNodeInfo(ctx, conn.MongoClient())
+	if err != nil {
+		return "", errors.Wrap(err, "get cluster info")
+	}
+	if !inf.IsSharded() {
+		return "", nil
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return "", errors.Wrap(err, "get cluster members")
+	}
+	for _, sh := range shards {
+		if sh.ID == "config" {
+			return sh.RS, nil
+		}
+	}
+
+	return "", errors.New("config server is not found in the cluster")
+}
+
 func validateNSFromNSTo(o *restoreOpts) error {
 	if o.nsFrom == "" && o.nsTo == "" {
 		return nil
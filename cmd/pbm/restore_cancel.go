@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+const cancelRestoreMsg = "aborted by `pbm cancel-restore`"
+
+type cancelRestoreOptions struct {
+	restore string
+	// cfg is the path to the PBM config. Physical restores are aborted
+	// on the storage as mongod may be down.
+	cfg   string
+	force bool
+	yes   bool
+}
+
+type cancelRestoreResult struct {
+	Name  string             `json:"name"`
+	Type  defs.BackupType    `json:"type"`
+	Abort *restore.AbortInfo `json:"abort"`
+}
+
+func (r cancelRestoreResult) String() string {
+	s := fmt.Sprintf("Restore %s is %s\n", r.Name, r.Abort)
+	if r.Type == defs.LogicalBackup {
+		s += "The agents are cleaning up. Check `pbm describe-restore " + r.Name + "` for the result\n"
+	} else {
+		s += "Restore or resync the nodes with modified data directories before starting the cluster\n"
+	}
+	return s
+}
+
+// cancelRestore aborts the restore which agents are lost (no fresh
+// heartbeats). The logical restore with alive agents is aborted only with
+// --force, the physical one never as its nodes have mongod shut down.
+func cancelRestore(ctx context.Context, conn connect.Client, o *cancelRestoreOptions, node string) (fmt.Stringer, error) {
+	if o.cfg != "" {
+		return cancelPhysRestore(o, node)
+	}
+
+	var meta *restore.RestoreMeta
+	var err error
+	if o.restore == "" {
+		meta, err = restore.GetLastRestore(ctx, conn)
+	} else {
+		meta, err = restore.GetRestoreMeta(ctx, conn, o.restore)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if meta.Type != defs.LogicalBackup {
+		return nil, errors.Errorf("restore %s is %s, use --config to abort it on the storage",
+			meta.Name, meta.Type)
+	}
+	if !meta.Status.IsRunning() && meta.Status != defs.StatusError {
+		return nil, errors.Errorf("restore %s is finished, status: %s", meta.Name, meta.Status)
+	}
+
+	alive, err := restore.IsAlive(ctx, conn, meta)
+	if err != nil {
+		return nil, err
+	}
+	if alive && !o.force {
+		return nil, errors.Errorf("restore %s is alive (it has a fresh heartbeat). "+
+			"Use --force to abort it anyway", meta.Name)
+	}
+
+	if !o.yes {
+		q := fmt.Sprintf("Abort the restore %s [status: %s]?", meta.Name, meta.Status)
+		if err := askConfirmation(q); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	info, err := restore.Abort(ctx, conn, meta.Name, cancelRestoreMsg, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "abort restore")
+	}
+
+	_, err = ctrl.SendCancelRestore(ctx, conn, ctrl.CancelRestoreCmd{Restore: meta.Name})
+	if err != nil {
+		return nil, errors.Wrap(err, "send cleanup command")
+	}
+
+	return cancelRestoreResult{Name: meta.Name, Type: meta.Type, Abort: info}, nil
+}
+
+func cancelPhysRestore(o *cancelRestoreOptions, node string) (fmt.Stringer, error) {
+	if o.restore == "" {
+		return nil, errors.New("restore name is required with --config")
+	}
+
+	stg, err := getRestoreMetaStg(o.cfg, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+	l := log.New(nil, "cli", "").NewEvent("", "", "", primitive.Timestamp{})
+	meta, err := restore.GetPhysRestoreMeta(o.restore, stg, l)
+	if err != nil && meta == nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+
+	now := time.Now().Unix()
+	alive := int64(meta.Hb.T)+int64(defs.StaleFrameSec) >= now
+	for _, rs := range meta.Replsets {
+		for _, n := range rs.Nodes {
+			if n.Status.IsRunning() && int64(n.Hb.T)+int64(defs.StaleFrameSec) >= now {
+				alive = true
+			}
+		}
+	}
+	if alive && meta.Status.IsRunning() {
+		return nil, errors.Errorf("restore %s is alive (it has a fresh heartbeat). "+
+			"Physical restores are aborted only after their agents are stopped", o.restore)
+	}
+
+	if !o.yes {
+		q := fmt.Sprintf("Abort the restore %s [status: %s]?", meta.Name, meta.Status)
+		if err := askConfirmation(q); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	info, err := restore.AbortPhys(stg, o.restore, cancelRestoreMsg, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "abort restore")
+	}
+
+	return cancelRestoreResult{Name: o.restore, Type: defs.PhysicalBackup, Abort: info}, nil
+}
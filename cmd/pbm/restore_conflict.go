@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// checkNSConflicts compares the existing collections of the target with
+// the collections of the backup before the restore without drop.
+// The conflicts are resolved by --ns-conflict: abort refuses the restore,
+// skip restores the backup without them and recreate drops them before
+// the restore with --force or the confirmation.
+func checkNSConflicts(
+	ctx context.Context,
+	conn connect.Client,
+	o *restoreOpts,
+	bcpName string,
+	nss []string,
+	rsMap map[string]string,
+	node string,
+	outf outFormat,
+) (*ctrl.RestoreNSConflicts, error) {
+	bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, bcpName)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+
+	inf, err := topo.GetNodeInfo(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster info")
+	}
+	if inf.IsSharded() {
+		return nil, errors.New("--drop=false is not supported in sharded cluster")
+	}
+
+	bcpColls, err := restore.BackupCollections(ctx, bcp, util.MakeReverseRSMapFunc(rsMap)(inf.SetName), node)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup collections")
+	}
+	if bcpColls == nil {
+		fmt.Fprintf(os.Stderr, "WARNING: collections of the backup '%s' are unknown (legacy layout), "+
+			"the conflicts aren't checked\n", bcp.Name)
+		return &ctrl.RestoreNSConflicts{Policy: o.nsConflict}, nil
+	}
+	target, err := restore.ReadTargetCollections(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get target collections")
+	}
+
+	rv := restore.NewNSConflicts(bcpColls, target, util.MakeSelectedPred(nss), o.nsConflict)
+	if len(rv.Conflicts) == 0 {
+		return rv, nil
+	}
+
+	summary := nsConflictsSummary(bcp, rv)
+	switch o.nsConflict {
+	case ctrl.NSConflictAbort:
+		return nil, errors.Errorf("%s\nUse --ns-conflict=%s or --ns-conflict=%s to restore anyway",
+			summary, ctrl.NSConflictSkip, ctrl.NSConflictRecreate)
+	case ctrl.NSConflictSkip:
+		fmt.Fprintf(os.Stderr, "WARNING: %s\nThe collections are not restored\n", summary)
+		return rv, nil
+	}
+
+	summary += "\nThe collections are dropped and restored from the backup"
+	if o.force {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", summary)
+		rv.Override = ctrl.RestoreOverrideForce
+		return rv, nil
+	}
+	if outf != outText {
+		return nil, errors.Errorf("%s\nUse --force to restore anyway", summary)
+	}
+
+	fmt.Println(summary)
+	err = askConfirmation("Drop the collections?")
+	if err != nil {
+		if errors.Is(err, errUserCanceled) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "ask confirmation (use --force to skip it)")
+	}
+
+	rv.Override = ctrl.RestoreOverrideConfirmed
+	return rv, nil
+}
+
+func nsConflictsSummary(bcp *backup.BackupMeta, c *ctrl.RestoreNSConflicts) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "The target cluster has collections conflicting with the backup '%s':", bcp.Name)
+	for _, n := range c.Conflicts {
+		fmt.Fprintf(&s, "\n  - %s: another %s", n.NS, strings.Join(n.Reasons, ", "))
+	}
+	return s.String()
+}
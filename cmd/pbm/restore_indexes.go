@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+type restoreIndexesOptions struct {
+	action  string
+	restore string
+}
+
+// restoreIndexes pauses or resumes the index builds of the running
+// logical restore. The running builds aren't interrupted by the pause,
+// the nodes hold off starting new ones.
+func restoreIndexes(ctx context.Context, conn connect.Client, o restoreIndexesOptions) (fmt.Stringer, error) {
+	var paused bool
+	switch o.action {
+	case "pause":
+		paused = true
+	case "resume":
+		paused = false
+	default:
+		return nil, errors.Errorf("unknown action %q, expected pause or resume", o.action)
+	}
+
+	var meta *restore.RestoreMeta
+	var err error
+	if o.restore == "" {
+		meta, err = restore.GetLastRestore(ctx, conn)
+	} else {
+		meta, err = restore.GetRestoreMeta(ctx, conn, o.restore)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if meta.Type != defs.LogicalBackup {
+		return nil, errors.Errorf("restore %s is %s, index builds are controlled for logical restores only",
+			meta.Name, meta.Type)
+	}
+	if !meta.Status.IsRunning() {
+		return nil, errors.Errorf("restore %s is not running, status: %s", meta.Name, meta.Status)
+	}
+
+	err = restore.SetIndexBuildsPaused(ctx, conn, meta.Name, paused)
+	if err != nil {
+		return nil, errors.Wrap(err, "set index builds state")
+	}
+
+	if paused {
+		return outMsg{fmt.Sprintf("Index builds of the restore %s are paused. "+
+			"Running builds continue, new ones wait for `pbm restore-indexes resume`", meta.Name)}, nil
+	}
+	return outMsg{fmt.Sprintf("Index builds of the restore %s are resumed", meta.Name)}, nil
+}
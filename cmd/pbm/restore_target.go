@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+	"github.com/percona/percona-backup-mongodb/sdk/cli"
+)
+
+// checkRestoreTarget compares the target cluster with the backup before
+// the restore. If the target has databases not in the backup or writes
+// after the time to restore to, the restore requires --force or
+// the confirmation.
+func checkRestoreTarget(
+	ctx context.Context,
+	conn connect.Client,
+	mURL string,
+	o *restoreOpts,
+	bcpName string,
+	nss []string,
+	rsMap map[string]string,
+	merge map[string][]string,
+	node string,
+	outf outFormat,
+) (*ctrl.RestoreTargetCheck, error) {
+	bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, bcpName)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+
+	until := bcp.LastWriteTS
+	if o.pitr != "" {
+		until, err = parseTS(o.pitr)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse pitr")
+		}
+	}
+
+	dbs := restore.SelectedDBs(nss)
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster members")
+	}
+
+	mapRevRS := util.MakeReverseRSMapFunc(rsMap)
+	rss := make(map[string]*restore.TargetReplset)
+	var bcpRSs []string
+	for _, s := range shards {
+		if o.replset != "" && s.RS != o.replset {
+			continue
+		}
+
+		rs, err := readTargetReplset(ctx, mURL, s.Host, until, dbs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replset %s", s.RS)
+		}
+		rss[s.RS] = rs
+		bcpRSs = append(bcpRSs, mapRevRS(s.RS))
+		bcpRSs = append(bcpRSs, merge[s.RS]...)
+	}
+
+	var bcpDBs []string
+	if len(dbs) == 0 {
+		bcpDBs, err = restore.BackupDatabases(ctx, bcp, bcpRSs, node)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backup databases")
+		}
+	}
+
+	rv := restore.NewTargetCheck(until, rss, bcpDBs)
+	if !rv.Failed() {
+		return rv, nil
+	}
+
+	summary := targetCheckSummary(bcp, rv)
+	if o.force {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", summary)
+		rv.Override = ctrl.RestoreOverrideForce
+		return rv, nil
+	}
+	if outf != outText {
+		return nil, errors.Errorf("%s\nUse --force to restore anyway", summary)
+	}
+
+	fmt.Println(summary)
+	err = askConfirmation("Restore anyway?")
+	if err != nil {
+		if errors.Is(err, errUserCanceled) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "ask confirmation (use --force to skip it)")
+	}
+
+	rv.Override = ctrl.RestoreOverrideConfirmed
+	return rv, nil
+}
+
+func readTargetReplset(
+	ctx context.Context,
+	mURL string,
+	host string,
+	until primitive.Timestamp,
+	dbs []string,
+) (*restore.TargetReplset, error) {
+	m, err := cli.ConnectRS(ctx, mURL, host)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = m.Disconnect(context.Background()) }()
+
+	return restore.ReadTargetReplset(ctx, m, until, dbs)
+}
+
+func targetCheckSummary(bcp *backup.BackupMeta, c *ctrl.RestoreTargetCheck) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "The target cluster has data not in the backup '%s':", bcp.Name)
+	if !c.FirstWrite.IsZero() {
+		fmt.Fprintf(&s, "\n  - writes after the restore time %s, the first at %s <%d,%d>",
+			fmtTS(int64(c.Until.T)), fmtTS(int64(c.FirstWrite.T)), c.FirstWrite.T, c.FirstWrite.I)
+	}
+	if len(c.ExtraDBs) != 0 {
+		fmt.Fprintf(&s, "\n  - databases: %s", strings.Join(c.ExtraDBs, ", "))
+	}
+	if bcp.Type == defs.LogicalBackup {
+		s.WriteString("\nThe restore overwrites the collections of the backup and the writes made after the restore time")
+	} else {
+		s.WriteString("\nThe restore replaces all data of the cluster")
+	}
+	return s.String()
+}
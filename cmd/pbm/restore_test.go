@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
 )
 
 func TestCloningValidation(t *testing.T) {
@@ -103,6 +112,51 @@ func TestCloningValidation(t *testing.T) {
 	}
 }
 
+func TestValidateUsersAndRolesOnly(t *testing.T) {
+	cases := []struct {
+		desc string
+		opts restoreOpts
+		ok   bool
+	}{
+		{"not set", restoreOpts{ns: "d.*"}, true},
+		{"merge", restoreOpts{bcp: "b", usersAndRolesOnly: true, usersAndRolesMode: "merge"}, true},
+		{"replace", restoreOpts{bcp: "b", usersAndRolesOnly: true, usersAndRolesMode: "replace"}, true},
+		{"unknown mode", restoreOpts{bcp: "b", usersAndRolesOnly: true, usersAndRolesMode: "drop"}, false},
+		{"no backup", restoreOpts{pitr: "2024-01-01T00:00:00", usersAndRolesOnly: true, usersAndRolesMode: "merge"}, false},
+		{"with ns", restoreOpts{bcp: "b", ns: "d.*", usersAndRolesOnly: true, usersAndRolesMode: "merge"}, false},
+		{"with rs", restoreOpts{bcp: "b", replset: "rs0", usersAndRolesOnly: true, usersAndRolesMode: "merge"}, false},
+	}
+	for _, c := range cases {
+		err := validateUsersAndRolesOnly(&c.opts)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result: %v", c.desc, err)
+		}
+	}
+}
+
+func TestValidateNoDrop(t *testing.T) {
+	cases := []struct {
+		desc string
+		opts restoreOpts
+		ok   bool
+	}{
+		{"drop", restoreOpts{drop: true, extern: true}, true},
+		{"abort", restoreOpts{bcp: "b", nsConflict: "abort"}, true},
+		{"skip", restoreOpts{bcp: "b", ns: "d.*", nsConflict: "skip"}, true},
+		{"recreate", restoreOpts{pitr: "2024-01-01T00:00:00", nsConflict: "recreate"}, true},
+		{"unknown policy", restoreOpts{bcp: "b", nsConflict: "merge"}, false},
+		{"external", restoreOpts{extern: true, nsConflict: "abort"}, false},
+		{"cloning", restoreOpts{bcp: "b", nsFrom: "d.c", nsTo: "d.c1", nsConflict: "abort"}, false},
+		{"users and roles only", restoreOpts{bcp: "b", usersAndRolesOnly: true, nsConflict: "abort"}, false},
+	}
+	for _, c := range cases {
+		err := validateNoDrop(&c.opts)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result: %v", c.desc, err)
+		}
+	}
+}
+
 func TestParseCLINumInsertionWorkersOption(t *testing.T) {
 	var num int32 = 1
 
@@ -144,3 +198,264 @@ func TestParseCLINumInsertionWorkersOption(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDBpathMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: nil},
+		{
+			name:  "valid",
+			value: "rs0-0:27017=/mnt/data/,rs0-1:27018=/data/db",
+			want:  map[string]string{"rs0-0:27017": "/mnt/data", "rs0-1:27018": "/data/db"},
+		},
+		{name: "no port", value: "rs0-0=/data/db", wantErr: true},
+		{name: "relative path", value: "rs0-0:27017=data/db", wantErr: true},
+		{name: "no path", value: "rs0-0:27017", wantErr: true},
+		{name: "duplicated node", value: "rs0-0:27017=/data/db,rs0-0:27017=/mnt/data", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDBpathMapping(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDBpathMapping() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDBpathMapping() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreRetLatest(t *testing.T) {
+	latest := &latestPITR{
+		Time:      primitive.Timestamp{T: 1760400000, I: 3},
+		Base:      "b1",
+		LimitedBy: "rs1",
+		limit:     restore.PITRLimit{RS: "rs1", End: primitive.Timestamp{T: 1760400000, I: 3}},
+	}
+	r := restoreRet{Name: "r1", PITR: "2025-10-14T00:00:00", Latest: latest}
+
+	want := "Latest restorable time: 2025-10-14T00:00:00Z <1760400000,3> based on snapshot 'b1'\n" +
+		"Limited by rs1: end of oplog chunks at 2025-10-14T00:00:00Z\n" +
+		"Restore to the point in time '2025-10-14T00:00:00' has started"
+	if got := r.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// already shown for the confirmation
+	latest.shown = true
+	if got := r.String(); got != "Restore to the point in time '2025-10-14T00:00:00' has started" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRestoreProgressPrinter(t *testing.T) {
+	meta := &restore.RestoreMeta{
+		Name:   "r1",
+		Status: defs.StatusError,
+		Replsets: []restore.RestoreReplset{{
+			Name:   "rs0",
+			Status: defs.StatusError,
+			Nodes: []restore.RestoreNode{
+				{
+					Name:     "n2:27017",
+					Status:   defs.StatusError,
+					Error:    "copy file: no space left",
+					Progress: &restore.PhysNodeProgress{Phase: "copying files", Files: 1, FilesTotal: 2},
+				},
+				{Name: "n1:27017", Status: defs.StatusRunning},
+			},
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	p := &restoreProgressPrinter{w: buf, outf: outText}
+	p.print(meta)
+	p.print(meta)
+
+	want := "Restore \"r1\" [error]\n" +
+		"  rs0 [error]\n" +
+		"    n1:27017 [running]\n" +
+		"    n2:27017 [error] copying files, files 1/2\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	info := failedNodesInfo(meta)
+	for _, s := range []string{"rs0/n2:27017: copy file: no space left", ".pbm.restore/r1/rs.rs0/"} {
+		if !strings.Contains(info, s) {
+			t.Errorf("no %q in:\n%s", s, info)
+		}
+	}
+	if strings.Contains(info, "n1:27017") {
+		t.Errorf("not failed node reported:\n%s", info)
+	}
+}
+
+func TestRestoreProgressPrinterNSLoad(t *testing.T) {
+	meta := &restore.RestoreMeta{
+		Name:   "r2",
+		Status: defs.StatusRunning,
+		Replsets: []restore.RestoreReplset{{
+			Name:   "rs0",
+			Status: defs.StatusRunning,
+			NSLoad: &restore.NSLoadProgress{
+				Total:      2,
+				Bytes:      512,
+				BytesTotal: 2048,
+				Namespaces: []restore.NSLoadState{
+					{NS: "shop.orders", Running: true, Bytes: 512, BytesTotal: 1024, Percent: 50, ETASec: 30},
+					{NS: "shop.items", BytesTotal: 1024},
+				},
+			},
+			Nodes: []restore.RestoreNode{{Name: "n1:27017", Status: defs.StatusRunning}},
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	p := &restoreProgressPrinter{w: buf, outf: outText}
+	p.print(meta)
+
+	want := "Restore \"r2\" [running]\n" +
+		"  rs0 [running]\n" +
+		"    0/2 namespaces, 25.0% of 2.00KB\n" +
+		"      - shop.orders: 50.0% of 1.00KB, ETA 30s\n" +
+		"      - shop.items: pending, 1.00KB\n" +
+		"    n1:27017 [running]\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestValidateRestoreReplset(t *testing.T) {
+	full := &backup.BackupMeta{
+		Name:     "full",
+		Replsets: []backup.BackupReplset{{Name: "cfg"}, {Name: "rs0"}, {Name: "rs1"}},
+	}
+	single := &backup.BackupMeta{
+		Name:     "single",
+		SingleRS: "rs0",
+		Replsets: []backup.BackupReplset{{Name: "rs0"}},
+	}
+
+	tests := []struct {
+		name    string
+		bcp     *backup.BackupMeta
+		rs      string
+		rsMap   map[string]string
+		wantErr bool
+	}{
+		{name: "full to cluster", bcp: full},
+		{name: "full to replset", bcp: full, rs: "rs1"},
+		{name: "full to unknown replset", bcp: full, rs: "rs2", wantErr: true},
+		{name: "full to remapped replset", bcp: full, rs: "rs2", rsMap: map[string]string{"rs1": "rs2"}},
+		{name: "single to cluster", bcp: single, wantErr: true},
+		{name: "single to replset", bcp: single, rs: "rs0"},
+		{name: "single to another replset", bcp: single, rs: "rs1", wantErr: true},
+		{name: "single to remapped replset", bcp: single, rs: "rs1", rsMap: map[string]string{"rs0": "rs1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRestoreReplset(tt.bcp, tt.rs, tt.rsMap)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRestoreReplset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRSMapping(t *testing.T) {
+	bcp := &backup.BackupMeta{
+		Name:     "bcp",
+		Replsets: []backup.BackupReplset{{Name: "cfg"}, {Name: "rs0"}, {Name: "rs1"}},
+	}
+	staging := []topo.Shard{{RS: "cfg"}, {RS: "staging-shard-0"}, {RS: "staging-shard-1"}}
+
+	tests := []struct {
+		name       string
+		shards     []topo.Shard
+		rsMap      map[string]string
+		rs         string
+		wantNoData []string
+		wantErr    bool
+	}{
+		{
+			name:   "same names",
+			shards: []topo.Shard{{RS: "cfg"}, {RS: "rs0"}, {RS: "rs1"}},
+		},
+		{
+			name:   "renamed",
+			shards: staging,
+			rsMap:  map[string]string{"rs0": "staging-shard-0", "rs1": "staging-shard-1"},
+		},
+		{
+			name:    "partial mapping",
+			shards:  staging,
+			rsMap:   map[string]string{"rs0": "staging-shard-0"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown backup replset",
+			shards:  staging,
+			rsMap:   map[string]string{"rs0": "staging-shard-0", "rs1": "staging-shard-1", "rs2": "cfg"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown cluster replset",
+			shards:  staging,
+			rsMap:   map[string]string{"rs0": "staging-shard-0", "rs1": "staging-shard-2"},
+			wantErr: true,
+		},
+		{
+			name:    "not injective",
+			shards:  []topo.Shard{{RS: "cfg"}, {RS: "rs0"}, {RS: "rs1"}},
+			rsMap:   map[string]string{"rs0": "rs1"},
+			wantErr: true,
+		},
+		{
+			name:       "more shards in cluster",
+			shards:     append([]topo.Shard{{RS: "rs2"}}, staging...),
+			rsMap:      map[string]string{"rs0": "staging-shard-0", "rs1": "staging-shard-1"},
+			wantNoData: []string{"rs2"},
+		},
+		{
+			name:   "single replset",
+			shards: staging,
+			rsMap:  map[string]string{"rs1": "staging-shard-1"},
+			rs:     "staging-shard-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noData, err := checkRSMapping(bcp, tt.shards, tt.rsMap, tt.rs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRSMapping() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(noData, tt.wantNoData) {
+				t.Errorf("checkRSMapping() got = %v, want %v", noData, tt.wantNoData)
+			}
+		})
+	}
+}
+
+func TestFormatRSMapping(t *testing.T) {
+	got := formatRSMapping(map[string]string{"rs1": "staging-shard-1", "rs0": "staging-shard-0"})
+	want := []string{"staging-shard-0=rs0", "staging-shard-1=rs1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formatRSMapping() got = %v, want %v", got, want)
+	}
+}
+
+func TestFormatMerge(t *testing.T) {
+	got := formatMerge(map[string][]string{"rs1": {"rs3", "rs2"}, "rs0": {"rs4"}})
+	want := []string{"rs0=rs4", "rs1=rs3,rs2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("formatMerge() got = %v, want %v", got, want)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	stdlog "log"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
@@ -17,8 +20,12 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/resync"
+	"github.com/percona/percona-backup-mongodb/pbm/schedule"
 	"github.com/percona/percona-backup-mongodb/pbm/slicer"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
@@ -32,10 +39,13 @@ type statusOptions struct {
 	rsMap    string
 	sections []string
 	priority bool
+	exitCode bool
+	health   healthThresholds
 }
 
 type statusOut struct {
 	data   []*statusSect
+	health *statusHealth
 	pretty bool
 }
 
@@ -57,6 +67,9 @@ func (o statusOut) MarshalJSON() ([]byte, error) {
 			s[sc.Name] = sc.Obj
 		}
 	}
+	if o.health != nil {
+		s["health"] = o.health
+	}
 
 	if o.pretty {
 		return json.MarshalIndent(s, "", "  ")
@@ -120,6 +133,12 @@ func status(
 					return getCurrOps(ctx, pbm)
 				},
 			},
+			{"locks", "Stale locks", nil, getStaleLocks},
+			{"schedule", "Scheduled backups", nil, getScheduleStatus},
+			{"queue", "Queued backups", nil, getQueueStatus},
+			{"drift", "Storage metadata drift", nil, getDriftStatus},
+			{"oplog", "Oplog window", nil, getOplogWindowStatus},
+			{"control", "Control collections", nil, getControlStatus},
 			{
 				"backups", "Backups", nil,
 				func(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
@@ -139,8 +158,13 @@ func status(
 	}
 
 	err = out.set(ctx, conn, sfilter)
+	if err != nil {
+		return out, err
+	}
+
+	out.health = evalHealth(out.data, opts.health)
 
-	return out, err
+	return out, nil
 }
 
 func sprinth(s string) string {
@@ -161,7 +185,14 @@ type node struct {
 	PrioPITR string     `json:"prio_pitr"`
 	PrioBcp  string     `json:"prio_backup"`
 	OK       bool       `json:"ok"`
+	Stale    bool       `json:"stale"`
 	Errs     []string   `json:"errors,omitempty"`
+	// Missing is the privileges the PBM user of the agent misses
+	Missing []string `json:"missingPrivileges,omitempty"`
+	Grants  []string `json:"grantCommands,omitempty"`
+	// ClockSkewSec is the offset of the agent clock from the cluster time
+	ClockSkewSec *int64 `json:"clockSkewSec,omitempty"`
+	ClockSkewed  bool   `json:"clockSkewed,omitempty"`
 }
 
 func (n node) String() string {
@@ -188,15 +219,22 @@ func (n node) String() string {
 	}
 	if n.OK {
 		s += " OK"
-		return s
-	}
-	if len(n.Errs) == 0 {
-		return s
+	} else if len(n.Errs) != 0 {
+		s += " FAILED status:"
+		for _, e := range n.Errs {
+			s += fmt.Sprintf("\n      > ERROR with %s", e)
+		}
 	}
 
-	s += " FAILED status:"
-	for _, e := range n.Errs {
-		s += fmt.Sprintf("\n      > ERROR with %s", e)
+	for _, m := range n.Missing {
+		s += fmt.Sprintf("\n      > MISSING privileges: %s", m)
+	}
+	for _, g := range n.Grants {
+		s += fmt.Sprintf("\n      > grant with: %s", g)
+	}
+	if n.ClockSkewed {
+		s += "\n      > " + colorWarn(fmt.Sprintf("CLOCK is off the cluster time by %v",
+			time.Duration(*n.ClockSkewSec)*time.Second))
 	}
 
 	return s
@@ -213,6 +251,14 @@ func (c cluster) String() string {
 	return s
 }
 
+func joinOps(ops []topo.PrivilegeOp) string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = string(op)
+	}
+	return strings.Join(s, ", ")
+}
+
 func clusterStatus(
 	ctx context.Context,
 	pbm *sdk.Client,
@@ -238,9 +284,26 @@ func clusterStatus(
 				node.Errs = make([]string, len(agent.Errs))
 				for j, e := range agent.Errs {
 					node.Errs[j] = e.Error()
+					if errors.As(e, &cli.LostAgentError{}) {
+						node.Stale = true
+					}
 				}
 			}
 
+			if p := agent.Privileges; p != nil {
+				for _, m := range p.Missing {
+					node.Missing = append(node.Missing, fmt.Sprintf("%s for %s", m, joinOps(m.Ops)))
+				}
+				node.Grants = p.GrantCommands(topo.PrivilegeOpBackup, topo.PrivilegeOpRestore,
+					topo.PrivilegeOpReplay, topo.PrivilegeOpPITR)
+			}
+
+			if agent.ClockOffset != nil {
+				sec := int64(agent.ClockOffset.Seconds())
+				node.ClockSkewSec = &sec
+				node.ClockSkewed = agent.ClockSkewed
+			}
+
 			if prioOpt {
 				node.PrioPITR = fmt.Sprintf("%.1f", agent.PrioPITR)
 				node.PrioBcp = fmt.Sprintf("%.1f", agent.PrioBcp)
@@ -260,14 +323,111 @@ type pitrStat struct {
 	Running      bool     `json:"run"`
 	RunningNodes []string `json:"nodes"`
 	Err          string   `json:"error,omitempty"`
+
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel *int   `json:"compressionLevel,omitempty"`
+
+	Gaps []oplog.PITRGap `json:"gaps,omitempty"`
+
+	Replsets []pitrRSStat `json:"replsets,omitempty"`
+
+	// Restart is the enabling of PITR back after the last restore
+	// if it's pending or has failed
+	Restart *pitrRestartStat `json:"restart,omitempty"`
+}
+
+type pitrRestartStat struct {
+	Restore string                    `json:"restore"`
+	Status  restore.PITRRestartStatus `json:"status"`
+	Backup  string                    `json:"backup,omitempty"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// pitrStatWindow is the period chunks rate and size are reported for
+const pitrStatWindow = 24 * time.Hour
+
+type pitrRSStat struct {
+	Name          string  `json:"name"`
+	Node          string  `json:"node,omitempty"`
+	LastChunkEnd  int64   `json:"lastChunkEnd"`
+	LagSec        int64   `json:"lagSec"`
+	SpanSec       int64   `json:"spanSec"`
+	ChunksPerHour float64 `json:"chunksPerHour"`
+	Chunks        int64   `json:"chunks"`
+	Size          int64   `json:"size"`
+	// SafetyMarginSec is how long the oldest not uploaded oplog entry
+	// would stay in the oplog. Set only while slicing is running.
+	SafetyMarginSec *int64 `json:"safetyMarginSec,omitempty"`
+}
+
+// IsLagging returns true if slicing is behind the cluster time
+// for more than two spans.
+func (s pitrRSStat) IsLagging() bool {
+	return s.LastChunkEnd != 0 && s.LagSec > 2*s.SpanSec
+}
+
+func (s pitrRSStat) String() string {
+	if s.LastChunkEnd == 0 {
+		return fmt.Sprintf("%s: no chunks", s.Name)
+	}
+
+	lag := fmt.Sprintf("lag %s", time.Duration(s.LagSec)*time.Second)
+	if s.IsLagging() {
+		lag = colorWarn(lag)
+	}
+	str := fmt.Sprintf("%s: last chunk %s (%s), %.1f chunks/h, %s in %s",
+		s.Name, fmtTS(s.LastChunkEnd), lag, s.ChunksPerHour,
+		storage.PrettySize(s.Size), strings.TrimSuffix(pitrStatWindow.String(), "0m0s"))
+	if s.Node != "" {
+		str += ", slicing on " + s.Node
+	}
+	if s.SafetyMarginSec != nil {
+		margin := fmt.Sprintf("safety margin %s", time.Duration(*s.SafetyMarginSec)*time.Second)
+		if *s.SafetyMarginSec < 3*s.SpanSec {
+			margin = colorWarn(margin)
+		}
+		str += ", " + margin
+	}
+	return str
+}
+
+// colorWarn highlights the text if stdout is a terminal.
+// Otherwise it's marked with `!`.
+func colorWarn(s string) string {
+	fi, err := os.Stdout.Stat()
+	if err != nil || (fi.Mode()&os.ModeCharDevice) == 0 {
+		return "! " + s
+	}
+
+	return "\033[33m" + s + "\033[0m"
 }
 
 func (p pitrStat) String() string {
 	status := "OFF"
-	if p.InConf || p.Running {
+	switch {
+	case p.InConf || p.Running:
 		status = "ON"
+	case p.Restart != nil && p.Restart.Status == restore.PITRRestartPending:
+		status = "PENDING fresh base backup"
 	}
 	s := fmt.Sprintf("Status [%s]", status)
+	if r := p.Restart; r != nil {
+		switch {
+		case r.Status == restore.PITRRestartFailed:
+			s += fmt.Sprintf("\n! PITR isn't enabled back after restore %s: %s", r.Restore, r.Error)
+		case r.Backup != "":
+			s += fmt.Sprintf("\nDisabled by restore %s, enabled after base backup %s", r.Restore, r.Backup)
+		default:
+			s += fmt.Sprintf("\nDisabled by restore %s, enabled after the next backup", r.Restore)
+		}
+	}
+	if p.InConf && p.Compression != "" {
+		level := "default"
+		if p.CompressionLevel != nil {
+			level = strconv.Itoa(*p.CompressionLevel)
+		}
+		s += fmt.Sprintf("\nCompression: %s (level: %s)", p.Compression, level)
+	}
 	runningNodes := ""
 	for _, n := range p.RunningNodes {
 		runningNodes += fmt.Sprintf("%s; ", n)
@@ -275,9 +435,23 @@ func (p pitrStat) String() string {
 	if len(runningNodes) != 0 {
 		s += fmt.Sprintf("\nRunning members: %s", runningNodes)
 	}
+	if len(p.Replsets) != 0 {
+		s += "\nReplsets:"
+		for _, rs := range p.Replsets {
+			s += "\n  " + rs.String()
+		}
+	}
 	if p.Err != "" {
 		s += fmt.Sprintf("\n! ERROR while running PITR backup: %s", p.Err)
 	}
+	for _, g := range p.Gaps {
+		if g.IsOpen() {
+			s += fmt.Sprintf("\n! GAP on %s: no oplog chunks since %s", g.RS, fmtTS(int64(g.StartTS.T)))
+			continue
+		}
+		s += fmt.Sprintf("\n! GAP on %s: no oplog for %s - %s",
+			g.RS, fmtTS(int64(g.StartTS.T)), fmtTS(int64(g.EndTS.T)))
+	}
 	return s
 }
 
@@ -294,6 +468,20 @@ func getPitrStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, erro
 		return p, errors.Wrap(err, "unable check PITR running status")
 	}
 
+	if p.InConf {
+		cfg, err := config.GetConfig(ctx, conn)
+		if err != nil {
+			return p, errors.Wrap(err, "get config")
+		}
+		p.Compression = string(cfg.PITR.Compression)
+		p.CompressionLevel = cfg.PITR.CompressionLevel
+
+		p.Replsets, err = getPitrRSStats(ctx, conn, cfg)
+		if err != nil {
+			return p, errors.Wrap(err, "get replsets stats")
+		}
+	}
+
 	if p.InConf && p.Running {
 		p.RunningNodes, err = oplog.GetAgentsWithACK(ctx, conn)
 		if err != nil && !errors.Is(err, errors.ErrNotFound) {
@@ -301,11 +489,172 @@ func getPitrStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, erro
 		}
 	}
 
+	p.Gaps, err = getPitrGaps(ctx, conn)
+	if err != nil {
+		return p, errors.Wrap(err, "get gaps")
+	}
+
+	if !p.InConf {
+		p.Restart, err = getPitrRestart(ctx, conn)
+		if err != nil {
+			return p, errors.Wrap(err, "get restart after restore")
+		}
+	}
+
 	p.Err, err = getPitrErr(ctx, conn)
 
 	return p, errors.Wrap(err, "check for errors")
 }
 
+// getPitrRestart returns the state of enabling PITR back after the last
+// restore. Nil if the restore hasn't disabled PITR or it's enabled back.
+func getPitrRestart(ctx context.Context, conn connect.Client) (*pitrRestartStat, error) {
+	rs, err := restore.RestoreList(ctx, conn, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get last restore")
+	}
+	if len(rs) == 0 || rs[0].PITRRestart == nil || rs[0].PITRRestart.Status == restore.PITRRestartDone {
+		return nil, nil
+	}
+
+	r := rs[0].PITRRestart
+	return &pitrRestartStat{
+		Restore: rs[0].Name,
+		Status:  r.Status,
+		Backup:  r.Backup,
+		Error:   r.Error,
+	}, nil
+}
+
+// getPitrRSStats returns the last chunk, the lag, and the chunks rate and
+// size within pitrStatWindow for each replset of the cluster.
+func getPitrRSStats(ctx context.Context, conn connect.Client, cfg *config.Config) ([]pitrRSStat, error) {
+	now, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	shards, err := topo.ClusterMembers(ctx, conn.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster members")
+	}
+
+	since := primitive.Timestamp{}
+	if w := uint32(pitrStatWindow.Seconds()); now.T > w {
+		since.T = now.T - w
+	}
+	stats, err := oplog.PITRChunksStats(ctx, conn, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks stats")
+	}
+	chunks := make(map[string]oplog.ChunksStat, len(stats))
+	for _, st := range stats {
+		chunks[st.RS] = st
+	}
+
+	locks, err := lock.GetOpLocks(ctx, conn, &lock.LockHeader{Type: ctrl.CmdPITR})
+	if err != nil {
+		return nil, errors.Wrap(err, "get locks")
+	}
+	staleSec := cfg.Lock.StaleThresholdSec()
+	nodes := make(map[string]string, len(locks))
+	for _, l := range locks {
+		if !l.IsStale(now, staleSec) {
+			nodes[l.Replset] = l.Node
+		}
+	}
+
+	var margins map[string]oplog.PITRMargin
+	meta, err := oplog.GetMeta(ctx, conn)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return nil, errors.Wrap(err, "get pitr meta")
+	}
+	if meta != nil {
+		margins = meta.Margins
+	}
+
+	rv := make([]pitrRSStat, 0, len(shards))
+	for _, sh := range shards {
+		st := pitrRSStat{
+			Name:    sh.RS,
+			Node:    nodes[sh.RS],
+			SpanSec: int64(cfg.OplogSlicerIntervalRS(sh.RS).Seconds()),
+		}
+		if m, ok := margins[sh.RS]; ok && st.Node != "" &&
+			time.Now().Unix()-m.UpdatedAt <= 2*m.SpanSec+int64(defs.StaleFrameSec) {
+			margin := m.MarginSec
+			st.SafetyMarginSec = &margin
+		}
+
+		last, err := oplog.PITRLastChunkMeta(ctx, conn, sh.RS)
+		if err != nil {
+			if !errors.Is(err, errors.ErrNotFound) {
+				return nil, errors.Wrapf(err, "get last chunk for %s", sh.RS)
+			}
+			rv = append(rv, st)
+			continue
+		}
+		st.LastChunkEnd = int64(last.EndTS.T)
+		if now.T > last.EndTS.T {
+			st.LagSec = int64(now.T - last.EndTS.T)
+		}
+
+		if c, ok := chunks[sh.RS]; ok {
+			st.Chunks = c.Count
+			st.Size = c.Size
+			from := max(since.T, c.StartTS.T)
+			if now.T > from {
+				st.ChunksPerHour = float64(c.Count) * 3600 / float64(now.T-from)
+			}
+		}
+
+		rv = append(rv, st)
+	}
+
+	return rv, nil
+}
+
+// getPitrGaps returns open gaps and holes in PITR chunks that are still
+// within the saved oplog range (so restore to those times isn't possible).
+func getPitrGaps(ctx context.Context, conn connect.Client) ([]oplog.PITRGap, error) {
+	gaps, err := oplog.PITRGaps(ctx, conn, primitive.Timestamp{})
+	if err != nil {
+		return nil, err
+	}
+
+	first := make(map[string]primitive.Timestamp)
+	rv := []oplog.PITRGap{}
+	for _, g := range gaps {
+		if g.IsOpen() {
+			rv = append(rv, g)
+			continue
+		}
+		if !g.IsHole() {
+			continue
+		}
+
+		ts, ok := first[g.RS]
+		if !ok {
+			c, err := oplog.PITRFirstChunkMeta(ctx, conn, g.RS)
+			if err != nil && !errors.Is(err, errors.ErrNotFound) {
+				return nil, errors.Wrapf(err, "get first chunk for %s", g.RS)
+			}
+			if c != nil {
+				ts = c.StartTS
+			}
+			first[g.RS] = ts
+		}
+		if ts.IsZero() || !g.EndTS.After(ts) {
+			// oplog before the gap is already deleted
+			continue
+		}
+
+		rv = append(rv, g)
+	}
+
+	return rv, nil
+}
+
 func getPitrErr(ctx context.Context, conn connect.Client) (string, error) {
 	epch, err := config.GetEpoch(ctx, conn)
 	if err != nil {
@@ -366,12 +715,34 @@ LOOP:
 	return strings.Join(errs, "; "), nil
 }
 
+// currOps are operations running at the moment. Operations with
+// compatible lock scopes can run concurrently.
+type currOps []currOp
+
+func (c currOps) String() string {
+	if len(c) == 0 {
+		return "(none)"
+	}
+
+	s := make([]string, len(c))
+	for i := range c {
+		s[i] = c[i].String()
+	}
+	return strings.Join(s, "\n")
+}
+
 type currOp struct {
 	Type    ctrl.Command `json:"type,omitempty"`
 	OPID    string       `json:"opID,omitempty"`
 	Name    string       `json:"name,omitempty"`
 	StartTS int64        `json:"startTS,omitempty"`
 	Status  string       `json:"status,omitempty"`
+	Scope   lock.Scope   `json:"scope,omitempty"`
+	Storage string       `json:"storage,omitempty"`
+
+	OplogProgress map[string]*restore.OplogProgress      `json:"oplogProgress,omitempty"`
+	IndexBuilds   map[string]*restore.IndexBuildProgress `json:"indexBuilds,omitempty"`
+	NSPriority    map[string]*restore.NSPriorityProgress `json:"nsPriority,omitempty"`
 }
 
 func (c currOp) String() string {
@@ -383,10 +754,36 @@ func (c currOp) String() string {
 	default:
 		return fmt.Sprintf("%s [op id: %s]", c.Type, c.OPID)
 	case ctrl.CmdBackup, ctrl.CmdRestore:
-		return fmt.Sprintf("%s \"%s\", started at %s. Status: %s. [op id: %s]",
+		s := fmt.Sprintf("%s \"%s\", started at %s. Status: %s. [op id: %s]",
 			c.Type, c.Name, time.Unix((c.StartTS), 0).UTC().Format("2006-01-02T15:04:05Z"),
 			c.Status, c.OPID,
 		)
+		rss := make([]string, 0, len(c.OplogProgress))
+		for rs := range c.OplogProgress {
+			rss = append(rss, rs)
+		}
+		sort.Strings(rss)
+		for _, rs := range rss {
+			s += fmt.Sprintf("\n  %s: %s", rs, c.OplogProgress[rs])
+		}
+		rss = rss[:0]
+		for rs := range c.IndexBuilds {
+			rss = append(rss, rs)
+		}
+		sort.Strings(rss)
+		for _, rs := range rss {
+			p := strings.ReplaceAll(c.IndexBuilds[rs].String(), "\n", "\n  ")
+			s += fmt.Sprintf("\n  %s indexes: %s", rs, p)
+		}
+		rss = rss[:0]
+		for rs := range c.NSPriority {
+			rss = append(rss, rs)
+		}
+		sort.Strings(rss)
+		for _, rs := range rss {
+			s += fmt.Sprintf("\n  %s: %s", rs, c.NSPriority[rs])
+		}
+		return s
 	}
 }
 
@@ -395,16 +792,35 @@ func getCurrOps(ctx context.Context, pbm *sdk.Client) (fmt.Stringer, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "get locks")
 	}
-	if len(locks) == 0 {
-		return currOp{}, nil
+
+	// each replset holds its own lock of the operation
+	rv := currOps{}
+	seen := make(map[sdk.CommandID]bool)
+	for _, l := range locks {
+		if seen[l.OpID] {
+			continue
+		}
+		seen[l.OpID] = true
+
+		op, err := getCurrOp(ctx, pbm, l)
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, op)
 	}
 
+	return rv, nil
+}
+
+func getCurrOp(ctx context.Context, pbm *sdk.Client, l sdk.OpLock) (currOp, error) {
 	r := currOp{
-		Type: locks[0].Cmd,
-		OPID: string(locks[0].OpID),
+		Type:    l.Cmd,
+		OPID:    string(l.OpID),
+		Scope:   l.Scope,
+		Storage: l.Storage,
 	}
 
-	switch locks[0].Cmd {
+	switch l.Cmd {
 	case ctrl.CmdBackup:
 		bcp, err := pbm.GetBackupByOpID(ctx, r.OPID, sdk.GetBackupByNameOptions{})
 		if err != nil {
@@ -439,6 +855,37 @@ func getCurrOps(ctx context.Context, pbm *sdk.Client) (fmt.Stringer, error) {
 		default:
 			r.Status = string(rst.Status)
 		}
+
+		for _, rs := range rst.Replsets {
+			if rs.OplogProgress == nil || rs.Status == defs.StatusDone {
+				continue
+			}
+			if r.OplogProgress == nil {
+				r.OplogProgress = make(map[string]*restore.OplogProgress)
+			}
+			r.OplogProgress[rs.Name] = rs.OplogProgress
+		}
+
+		for _, rs := range rst.Replsets {
+			p := rs.IndexBuilds
+			if p == nil || rs.Status == defs.StatusDone || p.Built+len(p.Failed) >= p.Total {
+				continue
+			}
+			if r.IndexBuilds == nil {
+				r.IndexBuilds = make(map[string]*restore.IndexBuildProgress)
+			}
+			r.IndexBuilds[rs.Name] = p
+		}
+
+		for _, rs := range rst.Replsets {
+			if rs.NSPriority == nil || rs.Status != defs.StatusRunning {
+				continue
+			}
+			if r.NSPriority == nil {
+				r.NSPriority = make(map[string]*restore.NSPriorityProgress)
+			}
+			r.NSPriority[rs.Name] = rs.NSPriority
+		}
 	}
 
 	return r, nil
@@ -450,6 +897,65 @@ type storageStat struct {
 	Region   string         `json:"region,omitempty"`
 	Snapshot []snapshotStat `json:"snapshot"`
 	PITR     *pitrRanges    `json:"pitrChunks,omitempty"`
+
+	// Probe is the result of the storage read access check
+	Probe      storageProbe    `json:"probe"`
+	LastBackup *lastBackupStat `json:"lastBackup"`
+
+	// Owners are the clusters which have claimed the main storage
+	// and the storages of the config profiles
+	Owners []storageOwnerStat `json:"owners,omitempty"`
+}
+
+type storageOwnerStat struct {
+	// Profile is the config profile name. Empty for the main storage.
+	Profile  string             `json:"profile,omitempty"`
+	ReadOnly bool               `json:"readOnly,omitempty"`
+	Owner    *util.StorageOwner `json:"owner,omitempty"`
+	// Foreign is true if the storage is claimed by another cluster
+	Foreign bool   `json:"foreign,omitempty"`
+	Err     string `json:"error,omitempty"`
+}
+
+// storageName returns the profile name or `main` for the main storage
+func (o *storageOwnerStat) storageName() string {
+	if o.Profile == "" {
+		return "main"
+	}
+	return o.Profile
+}
+
+func (o *storageOwnerStat) String() string {
+	name := o.storageName()
+	if o.Err != "" {
+		return fmt.Sprintf("%s: !!! %s", name, o.Err)
+	}
+
+	s := name + ": " + o.Owner.String()
+	if o.Owner != nil && o.Owner.ClaimedAt != 0 {
+		s += " [claimed " + fmtTS(o.Owner.ClaimedAt) + "]"
+	}
+	switch {
+	case o.ReadOnly:
+		s += " (read-only)"
+	case o.Foreign:
+		s += " !!! claimed by another cluster"
+	}
+	return s
+}
+
+type storageProbe struct {
+	OK  bool   `json:"ok"`
+	Err string `json:"error,omitempty"`
+}
+
+// lastBackupStat is the outcome of the most recent finished backup
+type lastBackupStat struct {
+	Name        string          `json:"name"`
+	Type        defs.BackupType `json:"type"`
+	Status      defs.Status     `json:"status"`
+	Err         string          `json:"error,omitempty"`
+	CompletedTS int64           `json:"completedTS"`
 }
 
 type pitrRanges struct {
@@ -459,6 +965,12 @@ type pitrRanges struct {
 
 func (s storageStat) String() string {
 	ret := fmt.Sprintf("%s %s %s\n", s.Type, s.Region, s.Path)
+	if len(s.Owners) != 0 {
+		ret += fmt.Sprintln("  Owners:")
+		for i := range s.Owners {
+			ret += fmt.Sprintf("    %s\n", s.Owners[i].String())
+		}
+	}
 	if len(s.Snapshot) == 0 && len(s.PITR.Ranges) == 0 {
 		return ret + "  (none)"
 	}
@@ -477,7 +989,11 @@ func (s storageStat) String() string {
 		case defs.StatusDone:
 			status = fmt.Sprintf("[restore_to_time: %s]", fmtTS(ss.RestoreTS))
 		case defs.StatusCancelled:
-			status = fmt.Sprintf("[!canceled: %s]", fmtTS(ss.RestoreTS))
+			if backup.IsWindowExceeded(ss.ErrString) {
+				status = fmt.Sprintf("[!canceled: %s] [%s]", ss.ErrString, fmtTS(ss.RestoreTS))
+			} else {
+				status = fmt.Sprintf("[!canceled: %s]", fmtTS(ss.RestoreTS))
+			}
 		case defs.StatusError:
 			if errors.Is(ss.Err, errIncompatible) {
 				status = fmt.Sprintf("[incompatible: %s] [%s]", ss.Err.Error(), fmtTS(ss.RestoreTS))
@@ -494,9 +1010,18 @@ func (s storageStat) String() string {
 		} else if ss.Type == defs.IncrementalBackup && ss.SrcBackup == "" {
 			t += ", base"
 		}
+		if ss.SingleRS != "" {
+			t += ", partial: " + ss.SingleRS
+		}
+		if len(ss.ExcludedDBs) != 0 {
+			t += ", partial, excluded: " + strings.Join(ss.ExcludedDBs, ",")
+		}
 		if ss.StoreName != "" {
 			t += ", *"
 		}
+		if ss.Warnings != 0 {
+			t += fmt.Sprintf(", %d warnings", ss.Warnings)
+		}
 		ret += fmt.Sprintf("    %s %s <%s> %s\n", ss.Name, storage.PrettySize(ss.Size), t, status)
 	}
 
@@ -526,6 +1051,196 @@ func (s storageStat) String() string {
 	return ret
 }
 
+type scheduleStat struct {
+	Schedules []scheduleEntry `json:"schedules"`
+	// Recent are the latest skipped or failed runs
+	Recent []schedule.Run `json:"recent,omitempty"`
+}
+
+type scheduleEntry struct {
+	Name      string          `json:"name"`
+	Cron      string          `json:"cron"`
+	Type      defs.BackupType `json:"type"`
+	Next      int64           `json:"next,omitempty"`
+	Retention string          `json:"retention,omitempty"`
+	Disabled  bool            `json:"disabled,omitempty"`
+}
+
+func (s scheduleStat) String() string {
+	var b strings.Builder
+
+	if len(s.Schedules) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, e := range s.Schedules {
+		fmt.Fprintf(&b, "%s [%s] %q: ", e.Name, e.Type, e.Cron)
+		switch {
+		case e.Disabled:
+			b.WriteString("disabled")
+		case e.Next == 0:
+			b.WriteString("no upcoming runs")
+		default:
+			b.WriteString("next " + fmtTS(e.Next))
+		}
+		if e.Retention != "" {
+			fmt.Fprintf(&b, " (retain: %s)", e.Retention)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(s.Recent) != 0 {
+		b.WriteString("Skipped/failed runs:\n")
+		for _, r := range s.Recent {
+			fmt.Fprintf(&b, "  %s %s [%s]", fmtTS(r.Slot), r.Schedule, r.Status)
+			if r.Backup != "" {
+				fmt.Fprintf(&b, " backup %q", r.Backup)
+			}
+			if r.Reason != "" {
+				b.WriteString(": " + r.Reason)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// scheduleRecentRuns is the number of skipped or failed runs shown in status
+const scheduleRecentRuns = 5
+
+type staleLock struct {
+	lock.LockHeader
+	Heartbeat int64 `json:"heartbeat"`
+}
+
+type staleLocksStat []staleLock
+
+func (s staleLocksStat) String() string {
+	var b strings.Builder
+	for _, l := range s {
+		fmt.Fprintf(&b, "%s [opid: %s] on %s/%s: last heartbeat at %s\n",
+			l.Type, l.OPID, l.Replset, l.Node, fmtTS(l.Heartbeat))
+	}
+	b.WriteString("The next operation will reclaim these locks and mark their operations as failed. " +
+		"Run `pbm force-unlock --opid <opid>` to release a lock manually.")
+
+	return b.String()
+}
+
+// getStaleLocks returns locks which holders have stopped the heartbeat.
+func getStaleLocks(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	locks, err := findStaleLocks(ctx, conn, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(locks) == 0 {
+		return nil, nil
+	}
+
+	return staleLocksStat(locks), nil
+}
+
+// findStaleLocks returns stale locks of the operation. All stale locks
+// are returned if opid is empty.
+func findStaleLocks(ctx context.Context, conn connect.Client, opid string) ([]staleLock, error) {
+	locks, err := findLocks(ctx, conn, opid)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return staleLocks(locks, ts, cfg.Lock.StaleThresholdSec()), nil
+}
+
+// staleLocks returns the locks with no heartbeat for more than threshold
+// seconds by the cluster time ts.
+func staleLocks(locks []lock.LockData, ts primitive.Timestamp, threshold uint32) []staleLock {
+	var rv []staleLock
+	for _, l := range locks {
+		if !l.IsStale(ts, threshold) {
+			continue
+		}
+
+		rv = append(rv, staleLock{
+			LockHeader: l.LockHeader,
+			Heartbeat:  int64(l.Heartbeat.T),
+		})
+	}
+
+	return rv
+}
+
+// findLocks returns locks and op locks of the operation.
+// All locks are returned if opid is empty.
+func findLocks(ctx context.Context, conn connect.Client, opid string) ([]lock.LockData, error) {
+	locks, err := lock.GetLocks(ctx, conn, &lock.LockHeader{OPID: opid})
+	if err != nil {
+		return nil, errors.Wrap(err, "get locks")
+	}
+	opLocks, err := lock.GetOpLocks(ctx, conn, &lock.LockHeader{OPID: opid})
+	if err != nil {
+		return nil, errors.Wrap(err, "get op locks")
+	}
+
+	return append(locks, opLocks...), nil
+}
+
+func getScheduleStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	if len(cfg.Schedule) == 0 {
+		return nil, nil
+	}
+
+	rv := scheduleStat{
+		Schedules: schedulesNext(cfg, time.Now()),
+	}
+	rv.Recent, err = schedule.GetRuns(ctx, conn, scheduleRecentRuns, schedule.RunSkipped, schedule.RunFailed)
+	if err != nil {
+		return nil, errors.Wrap(err, "get runs")
+	}
+
+	return rv, nil
+}
+
+// schedulesNext returns the schedules with their next runs after now.
+func schedulesNext(cfg *config.Config, now time.Time) []scheduleEntry {
+	rv := make([]scheduleEntry, 0, len(cfg.Schedule))
+	for _, name := range cfg.ScheduleNames() {
+		s := cfg.Schedule[name]
+		e := scheduleEntry{
+			Name:      name,
+			Cron:      s.Cron,
+			Type:      s.BackupType(),
+			Retention: s.Retention.String(),
+			Disabled:  s.Disabled,
+		}
+		if c, err := schedule.ParseCron(s.Cron); err == nil && !s.Disabled {
+			if next := c.Next(now); !next.IsZero() {
+				e.Next = next.Unix()
+			}
+		}
+		rv = append(rv, e)
+	}
+
+	return rv
+}
+
 func getStorageStat(
 	ctx context.Context,
 	conn connect.Client,
@@ -569,6 +1284,14 @@ func getStorageStat(
 		return s, errors.Wrap(err, "get cluster members")
 	}
 
+	now, err := topo.GetClusterTime(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	// before bcpsMatchCluster() marks incompatible backups as failed
+	s.LastBackup = getLastBackupStat(bcps, now)
+
 	// pbm.PBM is always connected either to config server or to the sole (hence main) RS
 	// which the `confsrv` param in `bcpMatchCluster` is all about
 	bcpsMatchCluster(bcps, ver.VersionString, fcv, shards, inf.SetName, rsMap)
@@ -579,21 +1302,32 @@ func getStorageStat(
 		return s, errors.Wrap(err, "get storage")
 	}
 
-	now, err := topo.GetClusterTime(ctx, conn)
+	s.Probe.OK = true
+	err = storage.HasReadAccess(ctx, stg)
+	if err != nil && !errors.Is(err, storage.ErrUninitialized) {
+		s.Probe = storageProbe{Err: err.Error()}
+	}
+
+	s.Owners, err = getStorageOwners(ctx, conn, cfg, stg, inf.Me)
 	if err != nil {
-		return nil, errors.Wrap(err, "get cluster time")
+		return s, errors.Wrap(err, "get storage owners")
 	}
 
 	for _, bcp := range bcps {
 		snpsht := snapshotStat{
-			Name:       bcp.Name,
-			Namespaces: bcp.Namespaces,
-			Status:     bcp.Status,
-			RestoreTS:  bcp.LastTransitionTS,
-			PBMVersion: bcp.PBMVersion,
-			Type:       bcp.Type,
-			SrcBackup:  bcp.SrcBackup,
-			StoreName:  bcp.Store.Name,
+			Name:        bcp.Name,
+			Namespaces:  bcp.Namespaces,
+			SingleRS:    bcp.SingleRS,
+			ExcludedDBs: bcp.ExcludedDBs,
+			Status:      bcp.Status,
+			RestoreTS:   bcp.LastTransitionTS,
+			PBMVersion:  bcp.PBMVersion,
+			Type:        bcp.Type,
+			SrcBackup:   bcp.SrcBackup,
+			StoreName:   bcp.Store.Name,
+		}
+		if bcp.Consistency != nil {
+			snpsht.Warnings = len(bcp.Consistency.Issues)
 		}
 		if err := bcp.Error(); err != nil {
 			snpsht.Err = err
@@ -638,6 +1372,89 @@ func getStorageStat(
 	return s, nil
 }
 
+// getStorageOwners reads the owner markers of the main storage
+// and the storages of the config profiles
+func getStorageOwners(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.Config,
+	stg storage.Storage,
+	node string,
+) ([]storageOwnerStat, error) {
+	self, err := util.ClusterOwner(ctx, conn, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster identity")
+	}
+
+	profiles, err := config.ListProfiles(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get config profiles")
+	}
+
+	rv := []storageOwnerStat{readStorageOwner(stg, self)}
+	for i := range profiles {
+		p := &profiles[i]
+
+		var o storageOwnerStat
+		pstg, err := util.StorageFromConfig(&p.Storage, node, log.LogEventFromContext(ctx))
+		if err != nil {
+			o.Err = err.Error()
+		} else {
+			o = readStorageOwner(pstg, self)
+		}
+		o.Profile = p.Name
+		o.ReadOnly = p.Storage.ReadOnly
+		rv = append(rv, o)
+	}
+
+	return rv, nil
+}
+
+func readStorageOwner(stg storage.Storage, self *util.StorageOwner) storageOwnerStat {
+	owner, err := util.ReadStorageOwner(stg)
+	if err != nil {
+		return storageOwnerStat{Err: err.Error()}
+	}
+
+	return storageOwnerStat{
+		Owner:   owner,
+		Foreign: owner != nil && owner.ClusterID != self.ClusterID,
+	}
+}
+
+// getLastBackupStat returns the most recent backup that is finished
+// (or stuck). Returns nil if there is no such.
+func getLastBackupStat(bcps []backup.BackupMeta, now primitive.Timestamp) *lastBackupStat {
+	var rv *lastBackupStat
+	var startTS int64
+	for i := range bcps {
+		bcp := &bcps[i]
+
+		st := &lastBackupStat{
+			Name:        bcp.Name,
+			Type:        bcp.Type,
+			Status:      bcp.Status,
+			Err:         bcp.Err,
+			CompletedTS: bcp.LastTransitionTS,
+		}
+		switch bcp.Status {
+		case defs.StatusDone, defs.StatusError, defs.StatusCancelled:
+		default:
+			if bcp.Hb.T+defs.StaleFrameSec >= now.T {
+				continue
+			}
+			st.Status = defs.StatusError
+			st.Err = fmt.Sprintf("Backup stuck at `%v` stage, last beat ts: %d", bcp.Status, bcp.Hb.T)
+		}
+
+		if rv == nil || bcp.StartTS > startTS {
+			rv, startTS = st, bcp.StartTS
+		}
+	}
+
+	return rv
+}
+
 func getPITRranges(
 	ctx context.Context,
 	conn connect.Client,
@@ -708,7 +1525,7 @@ func isValidBaseSnapshot(bcp *backup.BackupMeta) bool {
 	if bcp.Type == defs.ExternalBackup {
 		return false
 	}
-	if util.IsSelective(bcp.Namespaces) {
+	if !bcp.IsPITRBase() {
 		return false
 	}
 
@@ -772,6 +1589,236 @@ func getLegacyPhysSize(rsets []backup.BackupReplset) (int64, error) {
 	return s, nil
 }
 
+type driftStat struct {
+	*resync.Drift
+}
+
+func (s driftStat) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mode: %s, last check at %s by %s\n",
+		s.Mode, time.Unix(s.Checked, 0).UTC().Format(time.RFC3339), s.Node)
+	if s.Err != "" {
+		fmt.Fprintf(&b, "  Error: %s\n", s.Err)
+	}
+	if s.Detected() {
+		b.WriteString("  Metadata drift detected!\n")
+	}
+	for _, name := range s.Added {
+		fmt.Fprintf(&b, "  %s: only on the storage\n", name)
+	}
+	for _, name := range s.Missing {
+		fmt.Fprintf(&b, "  %s: missing on the storage\n", name)
+	}
+	for _, name := range s.Imported {
+		fmt.Fprintf(&b, "  %s: imported from the storage\n", name)
+	}
+	for _, name := range s.Expired {
+		fmt.Fprintf(&b, "  %s: removed from the metadata\n", name)
+	}
+	if s.Detected() && s.Mode != config.ResyncApply {
+		b.WriteString("Run `pbm config --force-resync` or set `resync.mode: apply` to fix the metadata.")
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// getDriftStatus returns the last storage reconciliation result
+// if the reconciliation is enabled and has found anything.
+func getDriftStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get config")
+	}
+	if cfg.Resync.ResyncMode() == config.ResyncOff {
+		return nil, nil
+	}
+
+	d, err := resync.GetDrift(ctx, conn)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get drift")
+	}
+	if !d.Detected() && d.Err == "" && len(d.Imported)+len(d.Expired) == 0 {
+		return nil, nil
+	}
+
+	return driftStat{d}, nil
+}
+
+type oplogWindowStat struct {
+	// BackupType is the type of the last backup the estimates are for
+	BackupType     defs.BackupType `json:"backupType"`
+	MarginPercent  float64         `json:"marginPercent"`
+	MaxDurationSec int64           `json:"maxDurationSec,omitempty"`
+	Replsets       []oplogWindowRS `json:"replsets"`
+}
+
+type oplogWindowRS struct {
+	Name string `json:"name"`
+	// Node is the node with the shortest window
+	Node         string  `json:"node"`
+	First        int64   `json:"first"`
+	Last         int64   `json:"last"`
+	SpanSec      int64   `json:"spanSec"`
+	ProjectedSec int64   `json:"projectedSec"`
+	BytesPerSec  float64 `json:"bytesPerSec"`
+	// EstimateSec is the estimated backup duration. Unset if there is
+	// no previous backup or the check is disabled.
+	EstimateSec     int64 `json:"estimateSec,omitempty"`
+	Fits            *bool `json:"fits,omitempty"`
+	FitsMaxDuration *bool `json:"fitsMaxDuration,omitempty"`
+}
+
+func (s oplogWindowStat) String() string {
+	var b strings.Builder
+	for _, rs := range s.Replsets {
+		fmt.Fprintf(&b, "%s: %s - %s (window %s, projected %s, %s/s) on %s\n",
+			rs.Name, fmtTS(rs.First), fmtTS(rs.Last),
+			time.Duration(rs.SpanSec)*time.Second, time.Duration(rs.ProjectedSec)*time.Second,
+			storage.PrettySize(int64(rs.BytesPerSec)), rs.Node)
+		if rs.Fits != nil {
+			fit := fmt.Sprintf("fits with %v%% margin", s.MarginPercent)
+			if !*rs.Fits {
+				fit = colorWarn("doesn't fit with " + strings.TrimPrefix(fit, "fits with "))
+			}
+			fmt.Fprintf(&b, "  %s backup estimate %s: %s\n",
+				s.BackupType, time.Duration(rs.EstimateSec)*time.Second, fit)
+		}
+		if rs.FitsMaxDuration != nil && !*rs.FitsMaxDuration {
+			fmt.Fprintf(&b, "  %s\n", colorWarn(fmt.Sprintf("shorter than backup.maxDuration %s",
+				time.Duration(s.MaxDurationSec)*time.Second)))
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// getOplogWindowStatus returns the oplog windows reported by the agents
+// compared with the estimated duration of the backup of the last type.
+func getOplogWindowStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	agents, err := topo.ListSteadyAgents(ctx, conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get agents")
+	}
+
+	rv := oplogWindowStat{BackupType: defs.LogicalBackup}
+	byRS := make(map[string]*oplogWindowRS)
+	for i := range agents {
+		w := agents[i].OplogWindow
+		if w == nil {
+			continue
+		}
+		proj := int64(w.Projected().Seconds())
+		if rs, ok := byRS[agents[i].RS]; ok && rs.ProjectedSec <= proj {
+			continue
+		}
+		byRS[agents[i].RS] = &oplogWindowRS{
+			Name:         agents[i].RS,
+			Node:         agents[i].Node,
+			First:        int64(w.First.T),
+			Last:         int64(w.Last.T),
+			SpanSec:      int64(w.Span().Seconds()),
+			ProjectedSec: proj,
+			BytesPerSec:  w.Rate,
+		}
+	}
+	if len(byRS) == 0 {
+		return nil, nil
+	}
+
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Wrap(err, "get config")
+	}
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	var wc *config.BackupOplogWindowCheck
+	if cfg.Backup != nil {
+		wc = cfg.Backup.OplogWindowCheck
+	}
+	rv.MarginPercent = wc.Margin()
+	rv.MaxDurationSec = int64(cfg.Backup.MaxRunTime().Seconds())
+
+	bcps, err := backup.BackupsDoneList(ctx, conn, nil, 1, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get last backup")
+	}
+	if len(bcps) != 0 {
+		rv.BackupType = bcps[0].Type
+	}
+
+	if wc.IsEnabled() {
+		fits, err := backup.CheckOplogWindows(ctx, conn, cfg, rv.BackupType, agents)
+		if err != nil {
+			return nil, errors.Wrap(err, "check oplog windows")
+		}
+		for _, f := range fits {
+			if rs, ok := byRS[f.RS]; ok {
+				rs.EstimateSec = int64(f.Estimate.Seconds())
+				rs.Fits = &f.Fits
+			}
+		}
+	}
+
+	for _, rs := range byRS {
+		if rv.MaxDurationSec > 0 {
+			fits := rs.ProjectedSec >= rv.MaxDurationSec
+			rs.FitsMaxDuration = &fits
+		}
+		rv.Replsets = append(rv.Replsets, *rs)
+	}
+	sort.Slice(rv.Replsets, func(i, j int) bool { return rv.Replsets[i].Name < rv.Replsets[j].Name })
+
+	return rv, nil
+}
+
+type controlStat struct {
+	Indexes       []connect.ControlIndexState `json:"indexes"`
+	RetentionDays int                         `json:"logRetentionDays,omitempty"`
+}
+
+func (s controlStat) String() string {
+	var b strings.Builder
+	for _, idx := range s.Indexes {
+		st := idx.State
+		if st != connect.IndexOK {
+			st = colorWarn(st)
+		}
+		fmt.Fprintf(&b, "%s.%s: %s\n", idx.Collection, idx.Name, st)
+	}
+	if s.RetentionDays > 0 {
+		fmt.Fprintf(&b, "Log retention: %d days\n", s.RetentionDays)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// getControlStatus returns the state of the indexes of the control
+// collections the cluster leader maintains.
+func getControlStatus(ctx context.Context, conn connect.Client) (fmt.Stringer, error) {
+	idx, err := connect.ControlIndexesState(ctx, conn.MongoClient().Database(defs.DB))
+	if err != nil {
+		return nil, errors.Wrap(err, "get indexes")
+	}
+
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	rv := controlStat{Indexes: idx}
+	if cfg != nil && cfg.Log != nil {
+		rv.RetentionDays = max(cfg.Log.RetentionDays, 0)
+	}
+	return rv, nil
+}
+
 var errMissedFile = errors.New("missed file")
 
 func getLegacyLogicalSize(bcp *backup.BackupMeta, stg storage.Storage) (int64, error) {
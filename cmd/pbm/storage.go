@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+type storageMigrateOptions struct {
+	from         string
+	to           string
+	backup       string
+	all          bool
+	deleteSource bool
+	dryRun       bool
+	yes          bool
+	wait         bool
+	waitTime     time.Duration
+}
+
+type migrationPlan struct {
+	*backup.MigrationPlan
+	ChunksCount int   `json:"chunks"`
+	Objects     int   `json:"objects"`
+	Size        int64 `json:"size"`
+}
+
+func (p migrationPlan) String() string {
+	var s strings.Builder
+	if len(p.Backups) != 0 {
+		fmt.Fprintln(&s, "Backups:")
+		for _, b := range p.Backups {
+			fmt.Fprintf(&s, "  %s: %d files, %s\n", b.Name, b.Objects, storage.PrettySize(b.Size))
+		}
+	}
+	if p.ChunksCount != 0 {
+		fmt.Fprintf(&s, "PITR chunks: %d files, %s\n", p.ChunksCount, storage.PrettySize(p.ChunksSize))
+	}
+	fmt.Fprintf(&s, "Total: %d files, %s", p.Objects, storage.PrettySize(p.Size))
+	return s.String()
+}
+
+// storageName returns the printable name of the storage profile
+func storageName(profile string) string {
+	if profile == "" {
+		return "main"
+	}
+	return profile
+}
+
+// migrateStorage moves backups and PITR chunks between the storages.
+// The migration is done by the agent.
+func migrateStorage(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	node string,
+	o storageMigrateOptions,
+) (fmt.Stringer, error) {
+	if o.from == o.to {
+		return nil, errors.New("--from and --to should be different storages")
+	}
+	if (o.backup == "") == !o.all {
+		return nil, errors.New("either --backup or --all should be set")
+	}
+	for _, name := range []string{o.from, o.to} {
+		if name == "" {
+			continue
+		}
+		if _, err := config.GetProfile(ctx, conn, name); err != nil {
+			return nil, errors.Wrapf(err, "get profile %q", name)
+		}
+	}
+
+	if !o.dryRun {
+		err := checkForAnotherOperation(ctx, pbm, &lock.LockHeader{Type: ctrl.CmdStorageMigrate})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := &backup.MigrateOptions{
+		From:         o.from,
+		To:           o.to,
+		Backup:       o.backup,
+		DeleteSource: o.deleteSource,
+	}
+	plan, err := backup.PlanMigration(ctx, conn, opts, node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "plan migration")
+	}
+	res := migrationPlan{
+		MigrationPlan: plan,
+		ChunksCount:   len(plan.Chunks),
+		Objects:       plan.Objects(),
+		Size:          plan.Size(),
+	}
+	if o.dryRun {
+		return res, nil
+	}
+	if res.Objects == 0 {
+		return outMsg{"nothing to migrate"}, nil
+	}
+
+	fmt.Println(res.String())
+	if !o.yes {
+		q := fmt.Sprintf("Artifacts will be copied from %q to %q storage", storageName(o.from), storageName(o.to))
+		if o.deleteSource {
+			q += " and deleted from the source"
+		}
+		if err := askConfirmation(q + ". Continue?"); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	cid, err := pbm.MigrateStorage(ctx, o.from, o.to, o.backup, o.deleteSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "schedule migration")
+	}
+
+	if !o.wait {
+		return outMsg{"Migration has started. Check the progress with " +
+			"`pbm logs -e " + string(ctrl.CmdStorageMigrate) + "`"}, nil
+	}
+
+	if o.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.waitTime)
+		defer cancel()
+	}
+
+	return waitForMigrate(ctx, conn, pbm, cid)
+}
+
+func waitForMigrate(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	cid sdk.CommandID,
+) (fmt.Stringer, error) {
+	cmd, err := pbm.CommandInfo(ctx, cid)
+	if err != nil {
+		return nil, errors.Wrap(err, "get command info")
+	}
+
+	fmt.Print("Starting migration")
+	startCtx, cancel := context.WithTimeout(ctx, defs.WaitActionStart)
+	defer cancel()
+	if err := waitForMigrateStart(startCtx, conn, cid); err != nil {
+		msg, lerr := sdk.WaitForErrorLog(ctx, pbm, cmd)
+		if lerr != nil {
+			return nil, errors.Wrap(lerr, "read agents log")
+		}
+		if msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+
+	commandCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+
+	go func() {
+		fmt.Print("\nWaiting for migration to be done ")
+
+		for tick := time.NewTicker(time.Second); ; {
+			select {
+			case <-tick.C:
+				fmt.Print(".")
+			case <-commandCtx.Done():
+				return
+			}
+		}
+	}()
+
+	err = sdk.WaitForStorageMigrate(commandCtx, pbm)
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+
+		return outMsg{"Operation is still in progress, please check status in a while"}, nil
+	}
+
+	stopProgress()
+	msg, err := sdk.WaitForErrorLog(ctx, pbm, cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "read agents log")
+	}
+	if msg != "" {
+		fmt.Println("[error]")
+		return nil, errors.New(msg)
+	}
+
+	fmt.Println("[done]")
+	return outMsg{""}, nil
+}
+
+// waitForMigrateStart waits for the agent to take the migration lock
+func waitForMigrateStart(ctx context.Context, conn connect.Client, cid sdk.CommandID) error {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			fmt.Print(".")
+			l, err := lock.GetLockData(ctx, conn, &lock.LockHeader{Type: ctrl.CmdStorageMigrate})
+			if err == nil && l.OPID == string(cid) {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.New("migration has not started. Check agents logs")
+		}
+	}
+}
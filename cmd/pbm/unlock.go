@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+const forceUnlockMsg = "lock is released by `pbm force-unlock`"
+
+type forceUnlockOptions struct {
+	opid  string
+	force bool
+	yes   bool
+}
+
+// forceUnlock deletes locks of the operation and marks the operation
+// as failed. Locks which holders are alive are deleted only with --force.
+func forceUnlock(ctx context.Context, conn connect.Client, o *forceUnlockOptions) (fmt.Stringer, error) {
+	if o.opid == "" {
+		return nil, errors.New("--opid is required")
+	}
+
+	locks, err := findLocks(ctx, conn, o.opid)
+	if err != nil {
+		return nil, err
+	}
+	if len(locks) == 0 {
+		return nil, errors.Errorf("no locks of the operation %s", o.opid)
+	}
+
+	stale, err := findStaleLocks(ctx, conn, o.opid)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) != len(locks) && !o.force {
+		return nil, errors.Errorf("the %s operation %s is alive (its locks have a fresh heartbeat). "+
+			"Use --force to release its locks anyway", locks[0].Type, o.opid)
+	}
+
+	if !o.yes {
+		q := fmt.Sprintf("Release %d lock(s) of the %s operation %s and mark it as failed?",
+			len(locks), locks[0].Type, o.opid)
+		if err := askConfirmation(q); err != nil {
+			if errors.Is(err, errUserCanceled) {
+				return outMsg{err.Error()}, nil
+			}
+			return nil, err
+		}
+	}
+
+	f := bson.D{{"opid", o.opid}}
+	if _, err := conn.LockCollection().DeleteMany(ctx, f); err != nil {
+		return nil, errors.Wrap(err, "delete locks")
+	}
+	if _, err := conn.LockOpCollection().DeleteMany(ctx, f); err != nil {
+		return nil, errors.Wrap(err, "delete op locks")
+	}
+
+	msg := fmt.Sprintf("Released %d lock(s) of the %s operation %s", len(locks), locks[0].Type, o.opid)
+	marked, err := markUnlockedOp(ctx, conn, locks[0].Type, o.opid)
+	if err != nil {
+		return nil, errors.Wrap(err, "mark operation as failed")
+	}
+	if marked {
+		msg += ". The operation is marked as failed"
+	}
+	if locks[0].Type == ctrl.CmdBackup {
+		msg += ". Run `pbm cleanup --orphaned` to find its leftovers on the storage"
+	}
+
+	return outMsg{msg}, nil
+}
+
+// markUnlockedOp marks not finished backup or restore as failed.
+func markUnlockedOp(ctx context.Context, conn connect.Client, typ ctrl.Command, opid string) (bool, error) {
+	switch typ {
+	case ctrl.CmdBackup:
+		bcp, err := backup.GetBackupByOPID(ctx, conn, opid)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				return false, nil
+			}
+			return false, errors.Wrap(err, "get backup meta")
+		}
+		if isFinalStatus(bcp.Status) {
+			return false, nil
+		}
+		return true, backup.ChangeBackupStateOPID(conn, opid, defs.StatusError, forceUnlockMsg)
+	case ctrl.CmdRestore:
+		r, err := restore.GetRestoreMetaByOPID(ctx, conn, opid)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				return false, nil
+			}
+			return false, errors.Wrap(err, "get restore meta")
+		}
+		if isFinalStatus(r.Status) {
+			return false, nil
+		}
+		return true, restore.ChangeRestoreStateOPID(ctx, conn, opid, defs.StatusError, forceUnlockMsg)
+	}
+
+	return false, nil
+}
+
+func isFinalStatus(s defs.Status) bool {
+	return !s.IsRunning()
+}
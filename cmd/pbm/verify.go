@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/sdk"
+)
+
+type verifyRestoreOptions struct {
+	name     string
+	sample   int
+	wait     bool
+	waitTime time.Duration
+}
+
+// verifyRestore makes an agent restore the backup into a temporary mongod
+// and check the restored collections. The result is saved to the backup
+// metadata (see `pbm describe-backup`).
+func verifyRestore(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	o verifyRestoreOptions,
+) (fmt.Stringer, error) {
+	if o.sample < 0 {
+		return nil, errors.New("--sample-namespaces cannot be negative")
+	}
+
+	bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, o.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+	if err := restore.CheckVerifiable(bcp); err != nil {
+		return nil, err
+	}
+	if v := bcp.Verification; v != nil && v.Status == defs.StatusRunning {
+		return nil, errors.Errorf("the backup is being verified by %s", v.Node)
+	}
+
+	opid, err := ctrl.SendVerifyRestore(ctx, conn, ctrl.VerifyRestoreCmd{
+		Backup:   bcp.Name,
+		SampleNS: o.sample,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	if !o.wait {
+		return outMsg{fmt.Sprintf("Verification of %q has started. Check the result with "+
+			"`pbm describe-backup %s`", bcp.Name, bcp.Name)}, nil
+	}
+
+	if o.waitTime > time.Second {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.waitTime)
+		defer cancel()
+	}
+
+	return waitForVerify(ctx, conn, pbm, bcp.Name, opid)
+}
+
+func waitForVerify(
+	ctx context.Context,
+	conn connect.Client,
+	pbm *sdk.Client,
+	name string,
+	opid ctrl.OPID,
+) (fmt.Stringer, error) {
+	fmt.Print("Waiting for the verification ")
+
+	started := false
+	start := time.Now()
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ctx.Err()
+			}
+			return outMsg{"\nOperation is still in progress, please check status in a while"}, nil
+		}
+		fmt.Print(".")
+
+		bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, name)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backup data")
+		}
+
+		v := bcp.Verification
+		if v == nil || v.OPID != opid.String() {
+			if started || time.Since(start) < defs.WaitActionStart {
+				continue
+			}
+
+			fmt.Println("[error]")
+			cmd, err := pbm.CommandInfo(ctx, sdk.CommandID(opid.String()))
+			if err != nil {
+				return nil, errors.Wrap(err, "get command info")
+			}
+			msg, err := sdk.WaitForErrorLog(ctx, pbm, cmd)
+			if err != nil {
+				return nil, errors.Wrap(err, "read agents log")
+			}
+			if msg != "" {
+				return nil, errors.New(msg)
+			}
+			return nil, errors.New("verification has not started: no agent has the mongod binary " +
+				"and restore.verify.maxDiskMb of free space. Check agents logs")
+		}
+		started = true
+
+		switch v.Status {
+		case defs.StatusDone:
+			if !v.OK() {
+				fmt.Println("[mismatch]")
+				return nil, errors.Errorf("verification of %q: %s", name, v)
+			}
+			fmt.Println("[done]")
+			return v, nil
+		case defs.StatusError:
+			fmt.Println("[error]")
+			return nil, errors.New(v.Error)
+		}
+	}
+}
@@ -15,6 +15,8 @@ const (
 	testsRemapping testTyp = "remapping"
 	testsRS        testTyp = "rs"
 
+	testsRemappingSharded testTyp = "remapping-sharded"
+
 	defaultMongoUser = "bcp"
 	defaultMongoPass = "test1234"
 
@@ -52,6 +54,8 @@ func main() {
 		runRS(mUser, mPass, bcpT)
 	case testsRemapping:
 		runRemapping(mUser, mPass)
+	case testsRemappingSharded:
+		runRemappingSharded(mUser, mPass)
 	default:
 		log.Fatalln("UNKNOWN TEST TYPE:", typ)
 	}
@@ -107,6 +111,63 @@ func runRemapping(mUser, mPass string) {
 	runRemappingTests(tests)
 }
 
+func runRemappingSharded(mUser, mPass string) {
+	uri := func(host string) string {
+		return "mongodb://" + mUser + ":" + mPass + "@" + host + ":27017/"
+	}
+	tests := &sharded.RemappingEnvironment{
+		Donor: sharded.New(sharded.ClusterConf{
+			Mongos:          uri("mongos"),
+			Configsrv:       uri("cfg01"),
+			ConfigsrvRsName: "cfg",
+			Shards: map[string]string{
+				"rs1": uri("rs101"),
+				"rs2": uri("rs201"),
+			},
+			DockerURI:    dockerURI,
+			PbmContainer: "pbmagent_rs101",
+		}),
+		Recipient: sharded.New(sharded.ClusterConf{
+			Mongos:          uri("stg-mongos"),
+			Configsrv:       uri("stgcfg01"),
+			ConfigsrvRsName: "stgcfg",
+			Shards: map[string]string{
+				"stg-rs1": uri("stg-rs101"),
+				"stg-rs2": uri("stg-rs201"),
+			},
+			DockerURI:    dockerURI,
+			PbmContainer: "pbmagent_stg-rs101",
+		}),
+		Remapping: map[string]string{
+			"stgcfg":  "cfg",
+			"stg-rs1": "rs1",
+			"stg-rs2": "rs2",
+		},
+	}
+	one := sharded.New(sharded.ClusterConf{
+		Mongos:          uri("one-mongos"),
+		Configsrv:       uri("onecfg01"),
+		ConfigsrvRsName: "onecfg",
+		Shards: map[string]string{
+			"one-rs1": uri("one-rs101"),
+		},
+		DockerURI:    dockerURI,
+		PbmContainer: "pbmagent_one-rs101",
+	})
+	// the shards are placed by pbm, only the config servers are mapped
+	toOne := &sharded.RemappingEnvironment{
+		Donor:     tests.Donor,
+		Recipient: one,
+		Remapping: map[string]string{"onecfg": "cfg"},
+	}
+	fromOne := &sharded.RemappingEnvironment{
+		Donor:     one,
+		Recipient: tests.Recipient,
+		Remapping: map[string]string{"stgcfg": "onecfg"},
+	}
+	runShardedRemappingTests(tests, toOne, fromOne)
+}
+
 func runSharded(mUser, mPass string, bcpT bcpTyp) {
 	tests := sharded.New(sharded.ClusterConf{
 		Mongos:          "mongodb://" + mUser + ":" + mPass + "@mongos:27017/",
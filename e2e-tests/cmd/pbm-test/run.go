@@ -57,6 +57,8 @@ func run(t *sharded.Cluster, typ testTyp) {
 
 		runTest("Logical PITR & Restore "+stg.name, t.PITRbasic)
 
+		runTest("PITR restart after restore "+stg.name, t.PITRRestartAfterRestore)
+
 		runTest("Oplog Replay "+stg.name, t.OplogReplay)
 
 		t.SetBallastData(1e3)
@@ -73,6 +75,8 @@ func run(t *sharded.Cluster, typ testTyp) {
 	runTest("Check the Cannot Run Delete During Backup",
 		t.CannotRunDeleteDuringBackup)
 
+	runTest("Check concurrent operations", t.ConcurrentOps)
+
 	// Skip test for sharded envs until PBM-1446 is fixed
 	if typ != testsSharded {
 		runTest("Check Backup Cancellation",
@@ -91,6 +95,12 @@ func run(t *sharded.Cluster, typ testTyp) {
 
 		runTest("Selective backup in sharded cluster", t.SelectiveBackupSharded)
 
+		runTest("Logical restore through mongos", t.RestoreViaMongos)
+
+		runTest("Logical restore of the databases in parallel", t.ParallelDatabasesRestore)
+
+		runTest("Restart mongod under the agents", t.RestartMongod)
+
 		// TODO: in the case of non-sharded cluster there is no other agent to observe
 		// TODO: failed state during the backup. For such topology test should check if
 		// TODO: a sequential run (of the backup let's say) handles a situation.
@@ -102,6 +112,15 @@ func run(t *sharded.Cluster, typ testTyp) {
 		runTest("Restart agents during the backup",
 			t.RestartAgents)
 
+		runTest("Cancel the restore with killed agents",
+			t.CancelStuckRestore)
+
+		runTest("Primary stepdown during the backup",
+			t.PrimaryStepdown)
+
+		runTest("Graceful agents shutdown",
+			t.GracefulShutdown)
+
 		runTest("Distributed Transactions backup",
 			t.DistributedTrxSnapshot)
 
@@ -47,6 +47,11 @@ func runPhysical(t *sharded.Cluster, typ testTyp) {
 		flushStore(t)
 	}
 
+	if typ == testsSharded {
+		runTest("Physical Backup with excluded databases",
+			t.PhysicalExcludeDatabases)
+	}
+
 	runTest("Physical Backup Data Bounds Check",
 		func() { t.BackupBoundsCheck(defs.PhysicalBackup, cVersion) })
 
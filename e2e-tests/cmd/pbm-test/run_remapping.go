@@ -24,4 +24,39 @@ func runRemappingTests(t *sharded.RemappingEnvironment) {
 		func() { t.BackupAndRestore(defs.LogicalBackup) })
 
 	flushStore(t.Recipient)
+
+	staging := "/etc/pbm/minio-staging.yaml"
+	t.Recipient.ApplyConfig(context.TODO(), staging)
+	flush(t.Recipient)
+
+	runTest("Logical Restore of the other cluster backup from read-only storage Minio",
+		func() { t.CrossClusterRestore("/etc/pbm/minio-readonly.yaml") })
+
+	flushStore(t.Recipient)
+	flushStore(t.Donor)
+}
+
+func runShardedRemappingTests(t, toOne, fromOne *sharded.RemappingEnvironment) {
+	storage := "/etc/pbm/minio.yaml"
+	if !confExt(storage) {
+		return
+	}
+
+	t.Donor.ApplyConfig(context.TODO(), storage)
+	flush(t.Donor)
+	t.Recipient.ApplyConfig(context.TODO(), storage)
+	flush(t.Recipient)
+	toOne.Recipient.ApplyConfig(context.TODO(), storage)
+	flush(toOne.Recipient)
+
+	t.Donor.SetBallastData(1e4)
+
+	runTest("Logical Backup & Restore of sharded cluster with remapping Minio",
+		t.ShardedBackupAndRestore)
+	runTest("Logical Backup & Restore of 2 shards to 1 shard Minio",
+		toOne.ShardCountBackupAndRestore)
+	runTest("Logical Backup & Restore of 1 shard to 2 shards Minio",
+		fromOne.ShardCountBackupAndRestore)
+
+	flushStore(t.Recipient)
 }
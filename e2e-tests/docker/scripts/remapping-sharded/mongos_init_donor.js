@@ -0,0 +1,2 @@
+sh.addShard("rs1/rs101:27017")
+sh.addShard("rs2/rs201:27017")
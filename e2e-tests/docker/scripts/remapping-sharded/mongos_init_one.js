@@ -0,0 +1 @@
+sh.addShard("one-rs1/one-rs101:27017")
@@ -0,0 +1,2 @@
+sh.addShard("stg-rs1/stg-rs101:27017")
+sh.addShard("stg-rs2/stg-rs201:27017")
@@ -35,6 +35,10 @@ func NewDocker(ctx context.Context, host string) (*Docker, error) {
 
 // StopContainers stops containers with the given labels
 func (d *Docker) StopContainers(labels []string) error {
+	return d.stopContainers(labels, container.StopOptions{})
+}
+
+func (d *Docker) stopContainers(labels []string, opts container.StopOptions) error {
 	fltr := filters.NewArgs()
 	for _, v := range labels {
 		fltr.Add("label", v)
@@ -48,7 +52,7 @@ func (d *Docker) StopContainers(labels []string) error {
 
 	for _, c := range containers {
 		log.Println("stopping container", c.ID)
-		err = d.cn.ContainerStop(d.ctx, c.ID, container.StopOptions{})
+		err = d.cn.ContainerStop(d.ctx, c.ID, opts)
 		if err != nil {
 			return errors.Wrapf(err, "stop container %s", c.ID)
 		}
@@ -62,6 +66,16 @@ func (d *Docker) StopAgents(rsName string) error {
 	return d.StopContainers([]string{"com.percona.pbm.agent.rs=" + rsName})
 }
 
+// StopAgentsGracefully stops agent containers of the given replicaset
+// giving agents the timeout to exit after SIGTERM before they are killed
+func (d *Docker) StopAgentsGracefully(rsName string, timeout time.Duration) error {
+	secs := int(timeout.Seconds())
+	return d.stopContainers(
+		[]string{"com.percona.pbm.agent.rs=" + rsName},
+		container.StopOptions{Signal: "SIGTERM", Timeout: &secs},
+	)
+}
+
 // PauseAgents pause agent containers of the given replicaset
 func (d *Docker) PauseAgents(rsName string) error {
 	fltr := filters.NewArgs()
@@ -65,6 +65,17 @@ func (c *Ctl) PITRoff() error {
 	return nil
 }
 
+// SetConfig sets the config key to the value
+func (c *Ctl) SetConfig(key, val string) error {
+	out, err := c.RunCmd("pbm", "config", "--set", key+"="+val)
+	if err != nil {
+		return errors.Wrapf(err, "config set %s=%s", key, val)
+	}
+
+	fmt.Println("done", out)
+	return nil
+}
+
 func (c *Ctl) ApplyConfig(file string) error {
 	out, err := c.RunCmd("pbm", "config", "--file", file)
 	if err != nil {
@@ -75,6 +86,17 @@ func (c *Ctl) ApplyConfig(file string) error {
 	return nil
 }
 
+// AddProfile adds the config profile and syncs its backups
+func (c *Ctl) AddProfile(name, file string) error {
+	out, err := c.RunCmd("pbm", "profile", "add", name, file, "--sync", "--wait")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("done", out)
+	return nil
+}
+
 func (c *Ctl) Resync() error {
 	out, err := c.RunCmd("pbm", "config", "--force-resync")
 	if err != nil {
@@ -247,9 +269,10 @@ func (c *Ctl) waitForRestore(rinlist string, waitFor time.Duration) error {
 	}
 }
 
-// Restore starts restore and returns the name of op
+// Restore starts restore and returns the name of op.
+// Tests restore over the data written after the backup, so it's forced.
 func (c *Ctl) Restore(bcpName string, options []string) (string, error) {
-	command := append([]string{"pbm", "restore", bcpName, "-o", "json"}, options...)
+	command := append([]string{"pbm", "restore", bcpName, "-o", "json", "--force"}, options...)
 	o, err := c.RunCmd(command...)
 	if err != nil {
 		return "", errors.Wrap(err, "run meta")
@@ -276,12 +299,12 @@ func (c *Ctl) ReplayOplog(a, b time.Time) error {
 }
 
 func (c *Ctl) PITRestore(t time.Time) error {
-	_, err := c.RunCmd("pbm", "restore", "--time", t.Format("2006-01-02T15:04:05"))
+	_, err := c.RunCmd("pbm", "restore", "--time", t.Format("2006-01-02T15:04:05"), "--force")
 	return err
 }
 
 func (c *Ctl) PITRestoreClusterTime(t, i uint32) error {
-	_, err := c.RunCmd("pbm", "restore", "--time", fmt.Sprintf("%d,%d", t, i))
+	_, err := c.RunCmd("pbm", "restore", "--time", fmt.Sprintf("%d,%d", t, i), "--force")
 	return err
 }
 
@@ -102,6 +102,15 @@ func (c *Cluster) ApplyConfig(ctx context.Context, file string) {
 	time.Sleep(time.Second * 6) // give time to refresh agent-checks
 }
 
+func (c *Cluster) AddProfile(name, file string) {
+	stdlog.Printf("add profile %q", name)
+	err := c.pbm.AddProfile(name, file)
+	if err != nil {
+		l, _ := c.pbm.ContainerLogs()
+		stdlog.Fatalf("add profile: %v\ncontainer logs: %s\n", err, l)
+	}
+}
+
 func (c *Cluster) ServerVersion() string {
 	v, err := c.mongos.ServerVersion()
 	if err != nil {
@@ -0,0 +1,147 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// CancelStuckRestore kills the agents during the logical restore and
+// checks that `pbm cancel-restore` aborts it and the agents clean up
+// after it once they are back.
+func (c *Cluster) CancelStuckRestore() {
+	ctx := context.TODO()
+
+	bcpName := c.LogicalBackup()
+	c.BackupWaitDone(ctx, bcpName)
+
+	log.Println("restoring the backup")
+	name, err := c.pbm.Restore(bcpName, nil)
+	if err != nil {
+		log.Fatalln("ERROR: restoring the backup:", err)
+	}
+	c.waitRestoreStatus(ctx, name, defs.StatusRunning, time.Minute*2)
+	// let the replsets save the namespaces they restore
+	time.Sleep(time.Second * 5)
+
+	for _, rs := range c.agentsRS() {
+		log.Println("Stopping agents on the replset", rs)
+		err := c.docker.StopAgents(rs)
+		if err != nil {
+			log.Fatalln("ERROR: stopping agents on the replset", err)
+		}
+	}
+
+	waitfor := time.Duration(defs.StaleFrameSec+10) * time.Second
+	log.Println("Sleeping for", waitfor)
+	time.Sleep(waitfor)
+
+	out, err := c.pbm.RunCmd("pbm", "cancel-restore", name, "--yes")
+	if err != nil {
+		log.Fatalln("ERROR: cancel restore:", err)
+	}
+	log.Println(out)
+
+	meta, err := restore.GetRestoreMeta(ctx, c.mongopbm.Conn(), name)
+	if err != nil {
+		log.Fatalf("ERROR: get restore meta %s: %v", name, err)
+	}
+	if meta.Status != defs.StatusAborted || meta.Abort == nil {
+		log.Fatalf("ERROR: wrong state of the restore %s. Expect: %s. Got: %s/%s",
+			name, defs.StatusAborted, meta.Status, meta.Error)
+	}
+	touched := 0
+	for _, rs := range meta.Abort.Replsets {
+		touched += len(rs.Namespaces)
+	}
+	if touched == 0 {
+		log.Fatalf("ERROR: no namespaces reported for the aborted restore %s", name)
+	}
+	c.checkNoLocks()
+
+	c.startAgents()
+	c.waitAbortCleanup(ctx, name, time.Minute*3)
+
+	bs, err := topo.GetBalancerStatus(ctx, c.mongopbm.Conn())
+	if err != nil {
+		log.Fatalln("ERROR: get balancer status:", err)
+	}
+	if !bs.IsOn() {
+		log.Fatalln("ERROR: balancer is left stopped after the aborted restore")
+	}
+	for rs, m := range c.shards {
+		colls, err := m.Conn().Database(defs.DB).ListCollectionNames(ctx,
+			bson.D{{"name", bson.M{"$in": bson.A{defs.TmpUsersCollection, defs.TmpRolesCollection}}}})
+		if err != nil {
+			log.Fatalf("ERROR: list collections on %s: %v", rs, err)
+		}
+		if len(colls) != 0 {
+			log.Fatalf("ERROR: temporary collections left on %s: %v", rs, colls)
+		}
+	}
+
+	log.Println("Trying a new restore")
+	c.LogicalRestore(ctx, bcpName)
+}
+
+func (c *Cluster) waitRestoreStatus(ctx context.Context, name string, status defs.Status, waitFor time.Duration) {
+	tmr := time.NewTimer(waitFor)
+	defer tmr.Stop()
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tmr.C:
+			log.Fatalf("ERROR: restore %s hasn't reached %s in %v", name, status, waitFor)
+		case <-tk.C:
+			meta, err := restore.GetRestoreMeta(ctx, c.mongopbm.Conn(), name)
+			if err != nil {
+				continue
+			}
+			if meta.Status == status {
+				return
+			}
+			if !meta.Status.IsRunning() {
+				log.Fatalf("ERROR: restore %s is %s before %s: %s", name, meta.Status, status, meta.Error)
+			}
+		}
+	}
+}
+
+// waitAbortCleanup waits for every replset to report the cleanup
+// after the aborted restore
+func (c *Cluster) waitAbortCleanup(ctx context.Context, name string, waitFor time.Duration) {
+	tmr := time.NewTimer(waitFor)
+	defer tmr.Stop()
+	tk := time.NewTicker(time.Second * 5)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tmr.C:
+			log.Fatalf("ERROR: restore %s isn't cleaned up in %v", name, waitFor)
+		case <-tk.C:
+			meta, err := restore.GetRestoreMeta(ctx, c.mongopbm.Conn(), name)
+			if err != nil {
+				log.Fatalf("ERROR: get restore meta %s: %v", name, err)
+			}
+			done := make(map[string]bool)
+			for _, cl := range meta.Abort.Cleanup {
+				if cl.Error == "" {
+					done[cl.RS] = true
+				}
+			}
+			if len(done) == len(c.agentsRS()) {
+				log.Printf("restore %s is cleaned up: %s", name, meta.Abort)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sharded
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+)
+
+// concurrentOpsRS is a fake replset, so the test locks don't affect agents
+const concurrentOpsRS = "e2eConcurrentOps"
+
+type concurrentOpsCase struct {
+	name    string
+	first   lock.LockHeader
+	second  lock.LockHeader
+	allowed bool
+}
+
+// ConcurrentOps checks which operations can hold locks on the replset
+// at the same time.
+func (c *Cluster) ConcurrentOps() {
+	cases := []concurrentOpsCase{
+		{
+			"backup + read-only reconciliation",
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			lock.LockHeader{Type: ctrl.CmdResync, Scope: lock.ScopeRead},
+			true,
+		},
+		{
+			"backup + profile resync",
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			lock.LockHeader{Type: ctrl.CmdResync, Scope: lock.ScopeReplset, Storage: "profile1"},
+			true,
+		},
+		{
+			"backup on a profile + pitr delete",
+			lock.LockHeader{Type: ctrl.CmdBackup, Storage: "profile1"},
+			lock.LockHeader{Type: ctrl.CmdDeletePITR},
+			true,
+		},
+		{
+			"adding a profile + backup",
+			lock.LockHeader{Type: ctrl.CmdAddConfigProfile, Storage: "profile2"},
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			true,
+		},
+		{
+			"backup + restore",
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			lock.LockHeader{Type: ctrl.CmdRestore},
+			false,
+		},
+		{
+			"backup + backup delete",
+			lock.LockHeader{Type: ctrl.CmdBackup, Storage: "profile1"},
+			lock.LockHeader{Type: ctrl.CmdDeleteBackup, Storage: lock.AnyStorage},
+			false,
+		},
+		{
+			"backup + backup on another profile",
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			lock.LockHeader{Type: ctrl.CmdBackup, Storage: "profile1"},
+			false,
+		},
+		{
+			"restore + read-only reconciliation",
+			lock.LockHeader{Type: ctrl.CmdRestore},
+			lock.LockHeader{Type: ctrl.CmdResync, Scope: lock.ScopeRead},
+			false,
+		},
+		{
+			"backup + pitr compaction",
+			lock.LockHeader{Type: ctrl.CmdBackup},
+			lock.LockHeader{Type: ctrl.CmdPITRCompact},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		log.Println("checking", tc.name)
+		c.checkConcurrentOps(tc)
+	}
+}
+
+func (c *Cluster) checkConcurrentOps(tc concurrentOpsCase) {
+	ctx := context.TODO()
+	conn := c.mongopbm.Conn()
+
+	newLock := func(h lock.LockHeader) *lock.Lock {
+		h.Replset = concurrentOpsRS
+		h.Node = "e2e"
+		h.OPID = ctrl.OPID(primitive.NewObjectID()).String()
+		h.Epoch = &primitive.Timestamp{}
+		return lock.NewLock(conn, h)
+	}
+
+	first := newLock(tc.first)
+	got, err := first.Acquire(ctx)
+	if err != nil || !got {
+		log.Fatalf("ERROR: %s: acquire %s lock: %v, %v", tc.name, tc.first.Type, got, err)
+	}
+	defer func() {
+		if err := first.Release(); err != nil {
+			log.Fatalf("ERROR: %s: release %s lock: %v", tc.name, tc.first.Type, err)
+		}
+	}()
+
+	second := newLock(tc.second)
+	got, err = second.Acquire(ctx)
+	if got {
+		defer func() {
+			if err := second.Release(); err != nil {
+				log.Fatalf("ERROR: %s: release %s lock: %v", tc.name, tc.second.Type, err)
+			}
+		}()
+	}
+
+	if tc.allowed {
+		if err != nil || !got {
+			log.Fatalf("ERROR: %s: expected to run concurrently, got: %v, %v", tc.name, got, err)
+		}
+		return
+	}
+
+	if got || !errors.Is(err, lock.ConcurrentOpError{}) {
+		log.Fatalf("ERROR: %s: expected concurrent op error, got: %v, %v", tc.name, got, err)
+	}
+}
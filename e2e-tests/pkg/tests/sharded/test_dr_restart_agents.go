@@ -10,7 +10,7 @@ import (
 )
 
 const (
-	pbmLostAgentsErr = "some of pbm-agents were lost during the backup"
+	pbmLostAgentsErr = "agent lost: "
 	pbmLostShardErr  = "convergeCluster: lost shard"
 )
 
@@ -45,9 +45,9 @@ func (c *Cluster) RestartAgents() {
 
 	if meta.Status != defs.StatusError ||
 		meta.Error() == nil ||
-		meta.Error().Error() != pbmLostAgentsErr &&
+		!strings.HasPrefix(meta.Error().Error(), pbmLostAgentsErr) &&
 			!strings.Contains(meta.Error().Error(), pbmLostShardErr) {
-		log.Fatalf("ERROR: wrong state of the backup %s. Expect: %s/%s|...%s... Got: %s/%s",
+		log.Fatalf("ERROR: wrong state of the backup %s. Expect: %s/%s...|...%s... Got: %s/%s",
 			bcpName, defs.StatusError, pbmLostAgentsErr, pbmLostShardErr, meta.Status, meta.Error())
 	}
 
@@ -0,0 +1,86 @@
+package sharded
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/e2e-tests/pkg/tests"
+)
+
+const excludeDatabasesKey = "backup.physical.excludeDatabases"
+
+// PhysicalExcludeDatabases restores the physical backup made with db1
+// excluded. db0 has to be restored as is and db1 has to be gone.
+func (c *Cluster) PhysicalExcludeDatabases() {
+	ctx := c.ctx
+	creds := tests.ExtractCredentionals(c.cfg.Mongos)
+
+	defer func() {
+		for _, db := range clusterSpec {
+			if err := c.mongos.Conn().Database(db.Name).Drop(ctx); err != nil {
+				log.Printf("drop database: %s", err.Error())
+			}
+		}
+		if err := c.pbm.SetConfig(excludeDatabasesKey, ""); err != nil {
+			log.Printf("reset config: %s", err.Error())
+		}
+	}()
+
+	mongos := c.mongos.Conn()
+	err := tests.Deploy(ctx, mongos, clusterSpec)
+	if err != nil {
+		log.Fatalf("deploy: %s", err.Error())
+	}
+
+	err = tests.GenerateData(ctx, mongos, clusterSpec)
+	if err != nil {
+		log.Fatalf("generate data (1): %s", err.Error())
+	}
+
+	err = c.pbm.SetConfig(excludeDatabasesKey, "db1")
+	if err != nil {
+		log.Fatalf("set config: %s", err.Error())
+	}
+
+	beforeState, err := tests.ClusterState(ctx, mongos, creds)
+	if err != nil {
+		log.Fatalf("get before cluster state: %s", err.Error())
+	}
+
+	bcpName := c.PhysicalBackup()
+	c.BackupWaitDone(context.TODO(), bcpName)
+
+	err = tests.GenerateData(ctx, mongos, clusterSpec)
+	if err != nil {
+		log.Fatalf("generate data (2): %s", err.Error())
+	}
+
+	c.PhysicalRestore(context.TODO(), bcpName)
+
+	// reconnected after the restore
+	mongos = c.mongos.Conn()
+	afterState, err := tests.ClusterState(ctx, mongos, creds)
+	if err != nil {
+		log.Fatalf("get after cluster state: %s", err.Error())
+	}
+
+	if !tests.Compare(beforeState, afterState, []string{"db0.*"}) {
+		log.Fatalln("Error: unexpected state of the restored databases")
+	}
+
+	dbs, err := mongos.ListDatabaseNames(ctx, bson.D{{"name", "db1"}})
+	if err != nil {
+		log.Fatalf("list databases: %s", err.Error())
+	}
+	if len(dbs) != 0 {
+		log.Fatalln("Error: excluded database db1 is restored")
+	}
+
+	log.Printf("Deleting backup %v", bcpName)
+	err = c.mongopbm.DeleteBackup(context.TODO(), bcpName)
+	if err != nil {
+		log.Fatalf("Error: delete backup %s: %v", bcpName, err)
+	}
+}
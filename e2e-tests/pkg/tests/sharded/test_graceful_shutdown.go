@@ -0,0 +1,124 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+)
+
+// agentStopTimeout should be greater than the time of the test backups
+// plus the cleanup grace, the agents wait for the backups to finish
+const agentStopTimeout = 2 * time.Minute
+
+// GracefulShutdown sends SIGTERM to all agents during oplog slicing,
+// right after the backup start and in the middle of the backup.
+// Agents have to finish their jobs and leave no locks behind.
+func (c *Cluster) GracefulShutdown() {
+	log.Println("SIGTERM during oplog slicing")
+	c.pitrOn()
+	time.Sleep(pitrCheckPeriod * 3)
+	c.stopAgentsGracefully()
+	c.checkNoLocks()
+	c.startAgents()
+	c.pitrOff()
+
+	log.Println("SIGTERM on the backup start")
+	bcpName := c.LogicalBackup()
+	c.stopAgentsGracefully()
+	c.checkNoLocks()
+	c.checkBackupStopped(bcpName)
+	c.startAgents()
+
+	log.Println("SIGTERM during the backup")
+	bcpName = c.LogicalBackup()
+	time.Sleep(10 * time.Second)
+	c.stopAgentsGracefully()
+	c.checkNoLocks()
+	c.checkBackupStopped(bcpName)
+	c.startAgents()
+
+	log.Println("Trying a new backup")
+	c.BackupAndRestore(defs.LogicalBackup)
+}
+
+func (c *Cluster) agentsRS() []string {
+	rv := make([]string, 0, len(c.shards)+1)
+	for rs := range c.shards {
+		rv = append(rv, rs)
+	}
+	if _, ok := c.shards[c.confsrv]; c.confsrv != "" && !ok {
+		rv = append(rv, c.confsrv)
+	}
+
+	return rv
+}
+
+func (c *Cluster) stopAgentsGracefully() {
+	wg := sync.WaitGroup{}
+	for _, rs := range c.agentsRS() {
+		wg.Add(1)
+		go func(rs string) {
+			defer wg.Done()
+
+			log.Println("Sending SIGTERM to agents on the replset", rs)
+			err := c.docker.StopAgentsGracefully(rs, agentStopTimeout)
+			if err != nil {
+				log.Fatalln("ERROR: stopping agents on the replset", err)
+			}
+			log.Println("Agents has stopped", rs)
+		}(rs)
+	}
+	wg.Wait()
+}
+
+func (c *Cluster) startAgents() {
+	for _, rs := range c.agentsRS() {
+		log.Println("Starting agents on the replset", rs)
+		err := c.docker.StartAgents(rs)
+		if err != nil {
+			log.Fatalln("ERROR: starting agents on the replset", err)
+		}
+	}
+
+	log.Printf("Sleeping for %v for agents to report status", time.Second*7)
+	time.Sleep(time.Second * 7)
+}
+
+func (c *Cluster) checkNoLocks() {
+	conn := c.mongopbm.Conn()
+
+	locks, err := lock.GetLocks(context.TODO(), conn, &lock.LockHeader{})
+	if err != nil {
+		log.Fatalln("ERROR: get locks:", err)
+	}
+	opLocks, err := lock.GetOpLocks(context.TODO(), conn, &lock.LockHeader{})
+	if err != nil {
+		log.Fatalln("ERROR: get op locks:", err)
+	}
+
+	if len(locks)+len(opLocks) != 0 {
+		log.Fatalf("ERROR: locks left after the agents shutdown: %v %v", locks, opLocks)
+	}
+}
+
+// checkBackupStopped checks that the backup is not left running:
+// it's either finished or canceled because of the shutdown.
+func (c *Cluster) checkBackupStopped(bcpName string) {
+	meta, err := c.mongopbm.GetBackupMeta(context.TODO(), bcpName)
+	if err != nil {
+		log.Fatalf("ERROR: get metadata for the backup %s: %v", bcpName, err)
+	}
+
+	switch meta.Status {
+	case defs.StatusDone:
+	case defs.StatusCancelled, defs.StatusError:
+		log.Printf("backup %s is %s: %s", bcpName, meta.Status, meta.Err)
+	default:
+		log.Fatalf("ERROR: wrong state of the backup %s. Expect: %s|%s|%s. Got: %s/%s",
+			bcpName, defs.StatusDone, defs.StatusCancelled, defs.StatusError, meta.Status, meta.Err)
+	}
+}
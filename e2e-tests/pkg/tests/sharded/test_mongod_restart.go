@@ -0,0 +1,119 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// RestartMongod restarts all mongod of the cluster under the running
+// agents. The agents have to recover without a restart: report the
+// status again, resume the slicing from the last saved chunk and run
+// the commands issued after the restart.
+func (c *Cluster) RestartMongod() {
+	ctx := context.TODO()
+
+	bcpName := c.LogicalBackup()
+	c.BackupWaitDone(ctx, bcpName)
+
+	c.pitrOn()
+	log.Println("turn on PITR")
+	defer c.pitrOff()
+
+	log.Println("waiting for the slicing")
+	lastEnd := make(map[string]primitive.Timestamp)
+	waitFor(5*time.Minute, "oplog chunks", func() bool {
+		for name := range c.shards {
+			chunk, err := oplog.PITRLastChunkMeta(ctx, c.mongopbm.Conn(), name)
+			if errors.Is(err, errors.ErrNotFound) {
+				return false
+			}
+			if err != nil {
+				log.Fatalf("ERROR: %s: get last chunk: %v", name, err)
+			}
+			lastEnd[name] = chunk.EndTS
+		}
+		return true
+	})
+
+	log.Println("restarting mongod")
+	err := c.docker.RestartContainers([]string{"com.percona.pbm.app=mongod"})
+	if err != nil {
+		log.Fatalln("ERROR: restart mongod:", err)
+	}
+	restartedAt := time.Now()
+
+	// the connections of the tests are as dead as the agents' ones
+	time.Sleep(10 * time.Second)
+	c.Reconnect()
+
+	log.Println("waiting for the agents status")
+	waitFor(5*time.Minute, "agents status after the restart", func() bool {
+		agents, err := topo.ListAgentStatuses(ctx, c.mongopbm.Conn())
+		if err != nil {
+			log.Printf("list agents: %v", err)
+			return false
+		}
+		if len(agents) == 0 {
+			return false
+		}
+		for i := range agents {
+			a := &agents[i]
+			if ok, _ := a.OK(); !ok || time.Unix(int64(a.Heartbeat.T), 0).Before(restartedAt) {
+				return false
+			}
+		}
+		return true
+	})
+
+	log.Println("waiting for the slicing to resume")
+	waitFor(5*time.Minute, "oplog chunks after the restart", func() bool {
+		for name := range c.shards {
+			chunk, err := oplog.PITRLastChunkMeta(ctx, c.mongopbm.Conn(), name)
+			if err != nil {
+				log.Fatalf("ERROR: %s: get last chunk: %v", name, err)
+			}
+			if time.Unix(int64(chunk.EndTS.T), 0).Before(restartedAt) {
+				return false
+			}
+		}
+		return true
+	})
+
+	// the slicing goes on from the last chunk saved before the restart
+	tlns, err := oplog.PITRTimelines(ctx, c.mongopbm.Conn())
+	if err != nil {
+		log.Fatalf("ERROR: get PITR timelines: %v", err)
+	}
+	for name, end := range lastEnd {
+		covered := false
+		for _, t := range tlns {
+			if t.Start <= end.T && t.End >= uint32(restartedAt.Unix()) {
+				covered = true
+			}
+		}
+		if !covered {
+			log.Fatalf("ERROR: %s: gap in the oplog after %v: timelines %v", name, end, tlns)
+		}
+	}
+
+	log.Println("trying a new backup")
+	bcpAfter := c.LogicalBackup()
+	c.BackupWaitDone(ctx, bcpAfter)
+
+	c.pitrOff()
+
+	for _, b := range []string{bcpName, bcpAfter} {
+		log.Printf("Deleting backup %v", b)
+		err = c.mongopbm.DeleteBackup(ctx, b)
+		if err != nil {
+			log.Fatalf("Error: delete backup %s: %v", b, err)
+		}
+	}
+}
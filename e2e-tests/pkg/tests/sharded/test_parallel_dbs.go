@@ -0,0 +1,144 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+const parallelDatabasesKey = "restore.parallelDatabases"
+
+// parallelDBsSpec is the number of 1KB documents of the databases.
+// The sizes are very different, so the small ones are done long before
+// the large one if they are loaded at once.
+var parallelDBsSpec = map[string]int{
+	"pardb_large":  100000,
+	"pardb_medium": 10000,
+	"pardb_small":  100,
+}
+
+// ParallelDatabasesRestore restores the logical backup of the databases
+// of very different sizes with `restore.parallelDatabases`. The documents
+// have to be the same and the databases of the replset have to be loaded
+// at the same time: the large one goes first, so the small one is done
+// before it only if they run in parallel.
+func (c *Cluster) ParallelDatabasesRestore() {
+	ctx := c.ctx
+	mongos := c.mongos.Conn()
+
+	defer func() {
+		for db := range parallelDBsSpec {
+			if err := mongos.Database(db).Drop(ctx); err != nil {
+				log.Printf("drop database: %s", err.Error())
+			}
+		}
+		if err := c.pbm.SetConfig(parallelDatabasesKey, "0"); err != nil {
+			log.Printf("reset config: %s", err.Error())
+		}
+	}()
+
+	pad := strings.Repeat("x", 1024)
+	for db, n := range parallelDBsSpec {
+		coll := mongos.Database(db).Collection("data")
+		for i := 0; i < n; i += 1000 {
+			docs := make([]any, 0, 1000)
+			for j := i; j < min(i+1000, n); j++ {
+				docs = append(docs, bson.D{{"_id", j}, {"pad", pad}})
+			}
+			if _, err := coll.InsertMany(ctx, docs); err != nil {
+				log.Fatalf("insert into %s: %s", db, err.Error())
+			}
+		}
+	}
+
+	bcpName := c.LogicalBackup()
+	c.BackupWaitDone(context.TODO(), bcpName)
+
+	for db := range parallelDBsSpec {
+		if err := mongos.Database(db).Drop(ctx); err != nil {
+			log.Fatalf("drop database: %s", err.Error())
+		}
+	}
+
+	err := c.pbm.SetConfig(parallelDatabasesKey, "3")
+	if err != nil {
+		log.Fatalf("set config: %s", err.Error())
+	}
+
+	log.Println("restoring the backup")
+	name, err := c.pbm.Restore(bcpName, nil)
+	if err != nil {
+		log.Fatalln("Error: restoring the backup:", err)
+	}
+	err = c.pbm.CheckRestore(bcpName, time.Minute*25)
+	if err != nil {
+		log.Fatalln("Error: check backup restore:", err)
+	}
+
+	for db, n := range parallelDBsSpec {
+		count, err := mongos.Database(db).Collection("data").CountDocuments(ctx, bson.D{})
+		if err != nil {
+			log.Fatalf("count %s: %s", db, err.Error())
+		}
+		if count != int64(n) {
+			log.Fatalf("Error: %s has %d documents, expected %d", db, count, n)
+		}
+	}
+
+	meta, err := restore.GetRestoreMeta(ctx, c.mongopbm.Conn(), name)
+	if err != nil {
+		log.Fatalf("Error: get restore meta %s: %v", name, err)
+	}
+
+	// 3 databases on 2 shards: at least one replset has 2 of them
+	checked := 0
+	for _, rs := range meta.Replsets {
+		if rs.ParallelDBs == nil {
+			continue
+		}
+
+		runs := make(map[string]restore.DBRun)
+		for _, r := range rs.ParallelDBs.Databases {
+			if len(r.Databases) == 1 {
+				if _, ok := parallelDBsSpec[r.Databases[0]]; ok {
+					runs[r.Databases[0]] = r
+				}
+			}
+		}
+		if len(runs) < 2 {
+			continue
+		}
+
+		var large, small *restore.DBRun
+		for _, db := range []string{"pardb_large", "pardb_medium", "pardb_small"} {
+			if r, ok := runs[db]; ok {
+				if large == nil {
+					large = &r
+				} else {
+					small = &r
+				}
+			}
+		}
+		log.Printf("%s: %s", rs.Name, rs.ParallelDBs)
+		if small.StartTS > large.EndTS || small.EndTS >= large.EndTS {
+			log.Fatalf("Error: %s: %s [%d-%d] is loaded after %s [%d-%d]", rs.Name,
+				small.Databases[0], small.StartTS, small.EndTS,
+				large.Databases[0], large.StartTS, large.EndTS)
+		}
+		checked++
+	}
+	if checked == 0 {
+		log.Fatalf("Error: no replset of the restore %s loaded the databases in parallel", name)
+	}
+
+	log.Printf("Deleting backup %v", bcpName)
+	err = c.mongopbm.DeleteBackup(context.TODO(), bcpName)
+	if err != nil {
+		log.Fatalf("Error: delete backup %s: %v", bcpName, err)
+	}
+}
@@ -0,0 +1,135 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+)
+
+const pitrAutoRestartKey = "pitr.autoRestartAfterRestore"
+
+// PITRRestartAfterRestore checks that PITR disabled by the restore is
+// enabled back: the leader starts a fresh base backup after the restore,
+// then the slicing resumes from it.
+func (c *Cluster) PITRRestartAfterRestore() {
+	ctx := context.TODO()
+	conn := c.mongopbm.Conn()
+
+	err := c.pbm.SetConfig(pitrAutoRestartKey, "true")
+	if err != nil {
+		log.Fatalf("ERROR: set config: %v", err)
+	}
+	defer func() {
+		if err := c.pbm.SetConfig(pitrAutoRestartKey, "false"); err != nil {
+			log.Printf("reset config: %v", err)
+		}
+	}()
+
+	c.pitrOn()
+	log.Println("turn on PITR")
+	defer c.pitrOff()
+
+	bcpName := c.LogicalBackup()
+	c.BackupWaitDone(ctx, bcpName)
+
+	c.LogicalRestore(ctx, bcpName)
+
+	rs, err := restore.RestoreList(ctx, conn, 1)
+	if err != nil || len(rs) == 0 {
+		log.Fatalf("ERROR: get last restore: %v", err)
+	}
+	rmeta := &rs[0]
+	if rmeta.PITRRestart == nil {
+		log.Fatalf("ERROR: restore %s has no PITR restart", rmeta.Name)
+	}
+
+	log.Println("waiting for the base backup")
+	var base string
+	waitFor(5*time.Minute, "base backup start", func() bool {
+		r, err := restore.GetRestoreMeta(ctx, conn, rmeta.Name)
+		if err != nil {
+			log.Fatalf("ERROR: get restore %s: %v", rmeta.Name, err)
+		}
+		if r.PITRRestart.Status == restore.PITRRestartFailed {
+			log.Fatalf("ERROR: PITR restart failed: %s", r.PITRRestart.Error)
+		}
+		base = r.PITRRestart.Backup
+		return base != ""
+	})
+	c.BackupWaitDone(ctx, base)
+
+	bcp, err := c.mongopbm.GetBackupMeta(ctx, base)
+	if err != nil {
+		log.Fatalf("ERROR: get backup %s: %v", base, err)
+	}
+	if bcp.Labels["restore"] != rmeta.Name {
+		log.Fatalf("ERROR: backup %s isn't started by restore %s: %v", base, rmeta.Name, bcp.Labels)
+	}
+
+	log.Println("waiting for PITR to be enabled")
+	waitFor(5*time.Minute, "PITR enabled", func() bool {
+		on, _, err := config.IsPITREnabled(ctx, conn)
+		if err != nil {
+			log.Fatalf("ERROR: get PITR config: %v", err)
+		}
+		return on
+	})
+
+	log.Println("waiting for the slicing to resume")
+	waitFor(5*time.Minute, "oplog chunks after the base backup", func() bool {
+		for name := range c.shards {
+			chunk, err := oplog.PITRLastChunkMeta(ctx, conn, name)
+			if errors.Is(err, errors.ErrNotFound) {
+				return false
+			}
+			if err != nil {
+				log.Fatalf("ERROR: %s: get last chunk: %v", name, err)
+			}
+			if chunk.StartTS.Compare(bcp.LastWriteTS) < 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	r, err := restore.GetRestoreMeta(ctx, conn, rmeta.Name)
+	if err != nil {
+		log.Fatalf("ERROR: get restore %s: %v", rmeta.Name, err)
+	}
+	if r.PITRRestart.Status != restore.PITRRestartDone {
+		log.Fatalf("ERROR: PITR restart status is %s, expected %s", r.PITRRestart.Status, restore.PITRRestartDone)
+	}
+
+	c.pitrOff()
+
+	for _, b := range []string{bcpName, base} {
+		log.Printf("Deleting backup %v", b)
+		err = c.mongopbm.DeleteBackup(ctx, b)
+		if err != nil {
+			log.Fatalf("Error: delete backup %s: %v", b, err)
+		}
+	}
+}
+
+// waitFor checks the condition every few seconds until it's true
+// and fails the test after the timeout
+func waitFor(timeout time.Duration, what string, cond func() bool) {
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+	tk := time.NewTicker(defs.WaitBackupStart / 2)
+	defer tk.Stop()
+
+	for !cond() {
+		select {
+		case <-tk.C:
+		case <-tmr.C:
+			log.Fatalf("ERROR: no %s in %v", what, timeout)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sharded
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+)
+
+// PrimaryStepdown checks that the logical backup survives the stepdown of
+// the shard primary while the oplog of the backup is captured
+func (c *Cluster) PrimaryStepdown() {
+	checkData := c.DataChecker()
+
+	rss := make([]string, 0, len(c.shards))
+	for name := range c.shards {
+		rss = append(rss, name)
+	}
+	if len(rss) == 0 {
+		log.Fatalln("no shards in cluster")
+	}
+	sort.Strings(rss)
+	rs := rss[0]
+
+	bcpName := c.LogicalBackup()
+
+	log.Println("waiting for the backup to run")
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	for {
+		meta, err := c.mongopbm.GetBackupMeta(ctx, bcpName)
+		if err == nil && meta.Status == defs.StatusRunning {
+			break
+		}
+		if err == nil && !meta.Status.IsRunning() {
+			log.Fatalf("ERROR: backup %s is %s before the stepdown: %v", bcpName, meta.Status, meta.Error())
+		}
+		select {
+		case <-ctx.Done():
+			log.Fatalf("ERROR: backup %s is not running: %v", bcpName, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	log.Println("stepping down the primary of", rs)
+	err := c.shards[rs].Conn().Database("admin").
+		RunCommand(context.TODO(), bson.D{{"replSetStepDown", 30}}).Err()
+	// the primary closes connections on the stepdown
+	if err != nil && !mongo.IsNetworkError(err) {
+		log.Fatalf("ERROR: step down the primary of %s: %v", rs, err)
+	}
+
+	c.BackupWaitDone(context.TODO(), bcpName)
+	c.DeleteBallast()
+
+	c.LogicalRestore(context.TODO(), bcpName)
+	checkData()
+}
@@ -6,6 +6,10 @@ import (
 	"log"
 	"strings"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/e2e-tests/pkg/tests"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 )
 
@@ -52,6 +56,50 @@ func (re *RemappingEnvironment) BackupAndRestore(typ defs.BackupType) {
 	checkData()
 }
 
+// CrossClusterRestore restores the backup of the donor to the recipient
+// with its own storage. The donor storage is added to the recipient
+// as the read-only profile (the production backups restored to staging).
+func (re *RemappingEnvironment) CrossClusterRestore(profile string) {
+	ctx := context.TODO()
+
+	checkData := re.DataChecker()
+
+	bcpName := re.Donor.LogicalBackup()
+	re.Donor.BackupWaitDone(ctx, bcpName)
+
+	re.Recipient.AddProfile("production", profile)
+	bcp, err := re.Recipient.mongopbm.GetBackupMeta(ctx, bcpName)
+	if err != nil {
+		log.Fatalln("Error: get backup of the read-only profile:", err)
+	}
+
+	opts := re.prepareRestoreOptions(defs.LogicalBackup)
+	_, err = re.Recipient.pbm.Restore(bcpName, opts)
+	if err == nil {
+		log.Fatalln("Error: restore of the other cluster backup started without --source-cluster")
+	}
+	_, err = re.Recipient.pbm.Restore(bcpName, append(opts, "--source-cluster", "rs2"))
+	if err == nil {
+		log.Fatalln("Error: restore of the other cluster backup started with the wrong --source-cluster")
+	}
+
+	re.Recipient.LogicalRestoreWithParams(ctx, bcpName,
+		append(opts, "--source-cluster", bcp.SourceCluster()))
+	checkData()
+
+	_, err = re.Recipient.pbm.RunCmd("pbm", "delete-backup", "-y", bcpName)
+	if err == nil {
+		log.Fatalln("Error: backup on the read-only storage is deleted")
+	}
+	stg, err := re.Donor.mongopbm.Storage(ctx)
+	if err != nil {
+		log.Fatalln("Error: get donor storage:", err)
+	}
+	if _, err := stg.FileStat(bcpName + defs.MetadataFileSuffix); err != nil {
+		log.Fatalln("Error: backup metadata on the read-only storage:", err)
+	}
+}
+
 func (re *RemappingEnvironment) DataChecker() func() {
 	hashes1 := make(map[string]map[string]string)
 	for name, s := range re.Donor.shards {
@@ -84,3 +132,98 @@ func (re *RemappingEnvironment) DataChecker() func() {
 		}
 	}
 }
+
+var remappingSpec = []tests.GenDBSpec{
+	{
+		Name: "remapping",
+		Collections: []tests.GenCollSpec{
+			{
+				Name: "c0",
+				ShardingKey: &tests.ShardingOptions{
+					Key: map[string]any{"r": "hashed"},
+				},
+			},
+			{
+				Name: "c1",
+			},
+		},
+	},
+}
+
+// ShardedBackupAndRestore restores the backup of the sharded cluster to the
+// cluster with different names of the shards. Beside the data of each shard,
+// it checks that the routing metadata refers to the shards of the recipient.
+func (re *RemappingEnvironment) ShardedBackupAndRestore() {
+	re.shardedBackupAndRestore(true)
+}
+
+// ShardCountBackupAndRestore restores the backup of the sharded cluster to the
+// cluster with another number of shards. The data of the shards can't be
+// compared one to one, so it checks the documents count via mongos and that
+// the routing metadata refers to the shards of the recipient.
+func (re *RemappingEnvironment) ShardCountBackupAndRestore() {
+	re.shardedBackupAndRestore(false)
+}
+
+func (re *RemappingEnvironment) shardedBackupAndRestore(checkShards bool) {
+	ctx := context.TODO()
+
+	err := tests.Deploy(ctx, re.Donor.mongos.Conn(), remappingSpec)
+	if err != nil {
+		log.Fatalln("Error: deploy:", err)
+	}
+	err = tests.GenerateData(ctx, re.Donor.mongos.Conn(), remappingSpec)
+	if err != nil {
+		log.Fatalln("Error: generate data:", err)
+	}
+
+	counts := collCounts(ctx, re.Donor.mongos.Conn())
+	checkData := func() {}
+	if checkShards {
+		checkData = re.DataChecker()
+	}
+
+	bcpName := re.Donor.LogicalBackup()
+	re.Donor.BackupWaitDone(ctx, bcpName)
+
+	log.Println("resync backup list")
+	err = re.Recipient.mongopbm.StoreResync(ctx)
+	if err != nil {
+		log.Fatalln("Error: resync backup lists:", err)
+	}
+
+	re.Recipient.LogicalRestoreWithParams(ctx, bcpName, re.prepareRestoreOptions(defs.LogicalBackup))
+	checkData()
+
+	for ns, c := range collCounts(ctx, re.Recipient.mongos.Conn()) {
+		if counts[ns] != c {
+			log.Fatalf("%s: documents count doesn't match. before %d now %d", ns, counts[ns], c)
+		}
+	}
+
+	shards, err := re.Recipient.mongos.Conn().Database("config").Collection("chunks").
+		Distinct(ctx, "shard", bson.D{})
+	if err != nil {
+		log.Fatalln("Error: get shards of chunks:", err)
+	}
+	for _, s := range shards {
+		if _, ok := re.Recipient.shards[s.(string)]; !ok {
+			log.Fatalf("chunks refer to shard %q not in the recipient cluster", s)
+		}
+	}
+}
+
+func collCounts(ctx context.Context, m *mongo.Client) map[string]int64 {
+	rv := make(map[string]int64)
+	for _, db := range remappingSpec {
+		for _, coll := range db.Collections {
+			n, err := m.Database(db.Name).Collection(coll.Name).CountDocuments(ctx, bson.D{})
+			if err != nil {
+				log.Fatalf("Error: count %s.%s: %v", db.Name, coll.Name, err)
+			}
+			rv[db.Name+"."+coll.Name] = n
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,109 @@
+package sharded
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/e2e-tests/pkg/tests"
+)
+
+const viaMongosKey = "restore.viaMongos"
+
+// RestoreViaMongos restores the logical backup through mongos into the
+// dropped databases. The documents have to be the same and the sharded
+// collections have to be sharded again by the keys of the backup.
+// The chunks and UUIDs are the new ones and aren't compared.
+func (c *Cluster) RestoreViaMongos() {
+	ctx := c.ctx
+	mongos := c.mongos.Conn()
+	creds := tests.ExtractCredentionals(c.cfg.Mongos)
+
+	defer func() {
+		for _, db := range clusterSpec {
+			if err := mongos.Database(db.Name).Drop(ctx); err != nil {
+				log.Printf("drop database: %s", err.Error())
+			}
+		}
+		if err := c.pbm.SetConfig(viaMongosKey, "false"); err != nil {
+			log.Printf("reset config: %s", err.Error())
+		}
+	}()
+
+	err := tests.Deploy(ctx, mongos, clusterSpec)
+	if err != nil {
+		log.Fatalf("deploy: %s", err.Error())
+	}
+
+	err = tests.GenerateData(ctx, mongos, clusterSpec)
+	if err != nil {
+		log.Fatalf("generate data: %s", err.Error())
+	}
+
+	beforeState, err := tests.ClusterState(ctx, mongos, creds)
+	if err != nil {
+		log.Fatalf("get before cluster state: %s", err.Error())
+	}
+
+	bcpName := c.LogicalBackup()
+	c.BackupWaitDone(context.TODO(), bcpName)
+
+	for _, db := range clusterSpec {
+		if err := mongos.Database(db.Name).Drop(ctx); err != nil {
+			log.Fatalf("drop database: %s", err.Error())
+		}
+	}
+
+	err = c.pbm.SetConfig(viaMongosKey, "true")
+	if err != nil {
+		log.Fatalf("set config: %s", err.Error())
+	}
+
+	c.LogicalRestore(context.TODO(), bcpName)
+
+	afterState, err := tests.ClusterState(ctx, mongos, creds)
+	if err != nil {
+		log.Fatalf("get after cluster state: %s", err.Error())
+	}
+
+	ok := true
+	for ns, count := range beforeState.Counts {
+		if afterState.Counts[ns] != count {
+			log.Printf("%s has %d documents, expected %d", ns, afterState.Counts[ns], count)
+			ok = false
+		}
+	}
+	if !ok {
+		log.Fatalln("Error: unexpected documents of the restored collections")
+	}
+
+	for _, db := range clusterSpec {
+		for _, coll := range db.Collections {
+			if coll.ShardingKey == nil {
+				continue
+			}
+
+			ns := db.Name + "." + coll.Name
+			var spec struct {
+				Key bson.M `bson:"key"`
+			}
+			err := mongos.Database("config").Collection("collections").
+				FindOne(ctx, bson.D{{"_id", ns}}).Decode(&spec)
+			if err != nil {
+				log.Fatalf("Error: get sharded collection %s: %s", ns, err.Error())
+			}
+			for k, v := range coll.ShardingKey.Key {
+				if spec.Key[k] != v {
+					log.Fatalf("Error: %s is sharded by %v, expected %v", ns, spec.Key, coll.ShardingKey.Key)
+				}
+			}
+		}
+	}
+
+	log.Printf("Deleting backup %v", bcpName)
+	err = c.mongopbm.DeleteBackup(context.TODO(), bcpName)
+	if err != nil {
+		log.Fatalf("Error: delete backup %s: %v", bcpName, err)
+	}
+}
@@ -2,6 +2,7 @@ package archive
 
 import (
 	"io"
+	"slices"
 	"strings"
 	"sync"
 
@@ -47,11 +48,31 @@ type (
 	DocFilterFn func(ns string, d bson.Raw) bool
 )
 
+// NSTierFn returns the tier of a namespace. Namespaces of lower tiers
+// are written to the archive first.
+type NSTierFn func(ns string) int
+
 func DefaultNSFilter(string) bool { return true }
 
 func DefaultDocFilter(string, bson.Raw) bool { return true }
 
 func Compose(w io.Writer, newReader NewReader, nsFilter NSFilterFn, concurrency int) error {
+	return ComposeTiers(w, newReader, nsFilter, concurrency, nil, nil)
+}
+
+// ComposeTiers writes the namespaces ordered by their tier. The next tier
+// starts only after all namespaces of the previous one are written, so
+// mongorestore (it reads the archive in order) restores them first.
+// onTier, if set, is called before each tier with the tier and its namespaces.
+// Nil nsTier keeps the order of the metadata.
+func ComposeTiers(
+	w io.Writer,
+	newReader NewReader,
+	nsFilter NSFilterFn,
+	concurrency int,
+	nsTier NSTierFn,
+	onTier func(tier int, nss []string),
+) error {
 	meta, err := readMetadata(newReader)
 	if err != nil {
 		return errors.Wrap(err, "metadata")
@@ -70,16 +91,44 @@ func Compose(w io.Writer, newReader NewReader, nsFilter NSFilterFn, concurrency
 		}
 	}
 
+	tiers := make(map[*Namespace]int, len(nss))
+	if nsTier != nil {
+		for _, ns := range nss {
+			tiers[ns] = nsTier(NSify(ns.Database, ns.Collection))
+		}
+		slices.SortStableFunc(nss, func(a, b *Namespace) int { return tiers[a] - tiers[b] })
+	}
+
 	meta.Namespaces = nss
 
 	if err := writePrelude(w, meta); err != nil {
 		return errors.Wrap(err, "prelude")
 	}
 
-	err = writeAllNamespaces(w, newReader,
-		int(meta.Header.ConcurrentCollections),
-		meta.Namespaces)
-	return errors.Wrap(err, "write namespaces")
+	for len(nss) != 0 {
+		tier := tiers[nss[0]]
+		n := 1
+		for n < len(nss) && tiers[nss[n]] == tier {
+			n++
+		}
+
+		if onTier != nil {
+			names := make([]string, n)
+			for i, ns := range nss[:n] {
+				names[i] = NSify(ns.Database, ns.Collection)
+			}
+			onTier(tier, names)
+		}
+		err = writeAllNamespaces(w, newReader,
+			int(meta.Header.ConcurrentCollections),
+			nss[:n])
+		if err != nil {
+			return errors.Wrap(err, "write namespaces")
+		}
+		nss = nss[n:]
+	}
+
+	return nil
 }
 
 func writePrelude(w io.Writer, m *archiveMeta) error {
@@ -12,6 +12,8 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
@@ -50,6 +52,8 @@ type NamespaceV2 struct {
 
 	CRC  int64 `bson:"crc"`
 	Size int64 `bson:"size"`
+	// Count is the number of dumped documents
+	Count int64 `bson:"count,omitempty"`
 }
 
 func (s *NamespaceV2) NS() string {
@@ -84,6 +88,10 @@ type BackupOptions struct {
 	DocFilter DocFilterFn
 
 	ParallelColls int
+
+	// ReadPref is the read preference of the collections data reads.
+	// The client default is used if nil.
+	ReadPref *readpref.ReadPref
 }
 
 type backupImpl struct {
@@ -97,6 +105,44 @@ type backupImpl struct {
 	fcv           string
 
 	concurrency int
+	readPref    *readpref.ReadPref
+	skipIndexes bool
+
+	nss []*NamespaceV2
+}
+
+// ListNamespaces returns the namespaces the backup would dump with the
+// estimated documents count of the collections. Indexes aren't listed.
+func ListNamespaces(ctx context.Context, conn *mongo.Client, nsFilter NSFilterFn) ([]*NamespaceV2, error) {
+	bcp := &backupImpl{
+		conn:        conn,
+		nsFilter:    DefaultNSFilter,
+		concurrency: max(runtime.NumCPU()/2, 1),
+		skipIndexes: true,
+	}
+	if nsFilter != nil {
+		bcp.nsFilter = nsFilter
+	}
+
+	nss, err := bcp.listAllNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range nss {
+		if !ns.IsCollection() {
+			continue
+		}
+		ns.Count, err = conn.Database(ns.DB).Collection(ns.Name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "estimate document count of %s", ns.NS())
+		}
+	}
+
+	slices.SortFunc(nss, func(a, b *NamespaceV2) int {
+		return strings.Compare(a.NS(), b.NS())
+	})
+	return nss, nil
 }
 
 func NewBackup(ctx context.Context, options BackupOptions) (*backupImpl, error) {
@@ -106,6 +152,7 @@ func NewBackup(ctx context.Context, options BackupOptions) (*backupImpl, error)
 		nsFilter:    DefaultNSFilter,
 		docFilter:   DefaultDocFilter,
 		concurrency: runtime.NumCPU() / 2,
+		readPref:    options.ReadPref,
 	}
 
 	if options.NSFilter != nil {
@@ -153,9 +200,16 @@ func (bcp *backupImpl) Run(ctx context.Context) error {
 		return errors.Wrap(err, "dump meta")
 	}
 
+	bcp.nss = nss
 	return nil
 }
 
+// Namespaces returns the dumped namespaces with their stats.
+// It is empty until the backup is done.
+func (bcp *backupImpl) Namespaces() []*NamespaceV2 {
+	return bcp.nss
+}
+
 func (bcp *backupImpl) listAllNamespaces(ctx context.Context) ([]*NamespaceV2, error) {
 	dbs, err := bcp.conn.ListDatabaseNames(ctx, bson.D{{"name", bson.M{"$ne": "local"}}})
 	if err != nil {
@@ -230,7 +284,7 @@ func (bcp *backupImpl) listDBNamespaces(ctx context.Context, db string) ([]*Name
 			ns.UUID = hex.EncodeToString(data)
 		}
 
-		if ns.Type != "view" {
+		if ns.Type != "view" && !bcp.skipIndexes {
 			ns.Indexes, err = bcp.listIndexes(ctx, db, ns.Name)
 			if err != nil {
 				return nil, errors.Wrapf(err, "list indexes for %s", ns.Name)
@@ -279,7 +333,9 @@ func (bcp *backupImpl) dumpAllCollections(ctx context.Context, nss []*NamespaceV
 }
 
 func (bcp *backupImpl) dumpCollection(ctx context.Context, ns *NamespaceV2) error {
-	count, err := bcp.conn.Database(ns.DB).Collection(ns.Name).EstimatedDocumentCount(ctx)
+	coll := bcp.conn.Database(ns.DB).Collection(ns.Name,
+		options.Collection().SetReadPreference(bcp.readPref))
+	count, err := coll.EstimatedDocumentCount(ctx)
 	if err != nil {
 		return errors.Wrap(err, "estimate document count")
 	}
@@ -293,7 +349,7 @@ func (bcp *backupImpl) dumpCollection(ctx context.Context, ns *NamespaceV2) erro
 	}
 	defer file.Close()
 
-	cur, err := bcp.conn.Database(ns.DB).Collection(ns.Name).Find(ctx, bson.D{})
+	cur, err := coll.Find(ctx, bson.D{})
 	if err != nil {
 		return errors.Wrap(err, "find")
 	}
@@ -301,6 +357,7 @@ func (bcp *backupImpl) dumpCollection(ctx context.Context, ns *NamespaceV2) erro
 
 	crc := crc64.New(crc64.MakeTable(crc64.ECMA))
 	size := int64(0)
+	docs := int64(0)
 	for cur.Next(ctx) {
 		if !bcp.docFilter(ns.NS(), cur.Current) {
 			continue
@@ -316,6 +373,7 @@ func (bcp *backupImpl) dumpCollection(ctx context.Context, ns *NamespaceV2) erro
 			return io.ErrShortWrite
 		}
 		size += int64(n)
+		docs++
 	}
 
 	err = cur.Err()
@@ -332,6 +390,7 @@ func (bcp *backupImpl) dumpCollection(ctx context.Context, ns *NamespaceV2) erro
 	}
 
 	ns.Size = size
+	ns.Count = docs
 	ns.CRC = int64(crc.Sum64())
 	return nil
 }
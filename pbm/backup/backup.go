@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/hook"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
@@ -34,6 +37,9 @@ type Backup struct {
 	timeouts            *config.BackupTimeouts
 	numParallelColls    int
 	oplogSlicerInterval time.Duration
+
+	// singleRS is the only replset to backup. Empty for the whole cluster.
+	singleRS string
 }
 
 func New(leadConn connect.Client, conn *mongo.Client, brief topo.NodeBrief, dumpConns int) *Backup {
@@ -78,6 +84,16 @@ func (b *Backup) SetConfig(cfg *config.Config) {
 	b.config = cfg
 }
 
+// compressionPool returns the pool compressing the data of the backup.
+// Nil if the parallel compression is off.
+func (b *Backup) compressionPool() *compress.Pool {
+	var pc *config.ParallelCompression
+	if b.config != nil && b.config.Backup != nil {
+		pc = b.config.Backup.ParallelCompression
+	}
+	return pc.Pool()
+}
+
 func (b *Backup) SetMongoVersion(v string) {
 	b.mongoVersion = v
 }
@@ -86,6 +102,35 @@ func (b *Backup) SetTimeouts(t *config.BackupTimeouts) {
 	b.timeouts = t
 }
 
+// SetSingleReplset limits the backup to the replset. Its node leads
+// the backup instead of the config server.
+func (b *Backup) SetSingleReplset(rs string) {
+	b.singleRS = rs
+}
+
+// IsLeader returns true if the node leads the backup.
+func (b *Backup) IsLeader(inf *topo.NodeInfo) bool {
+	if b.singleRS != "" {
+		return inf.SetName == b.singleRS
+	}
+	return inf.IsLeader()
+}
+
+// clusterMembers returns the replsets taking part in the backup.
+func (b *Backup) clusterMembers(ctx context.Context) ([]topo.Shard, error) {
+	shards, err := topo.ClusterMembers(ctx, b.leadConn.MongoClient())
+	if err != nil || b.singleRS == "" {
+		return shards, err
+	}
+
+	for _, sh := range shards {
+		if sh.RS == b.singleRS {
+			return []topo.Shard{sh}, nil
+		}
+	}
+	return nil, errors.Errorf("replset %q is not found in the cluster", b.singleRS)
+}
+
 func (b *Backup) SetSlicerInterval(d time.Duration) {
 	b.oplogSlicerInterval = d
 }
@@ -103,17 +148,29 @@ func (b *Backup) Init(
 	bcp *ctrl.BackupCmd,
 	opid ctrl.OPID,
 	balancer topo.BalancerMode,
+	l log.LogEvent,
 ) error {
+	if b.config.Storage.ReadOnly {
+		return errors.Wrapf(storage.ErrReadOnly, "storage %q", b.config.Name)
+	}
+
 	ts, err := topo.GetClusterTime(ctx, b.leadConn)
 	if err != nil {
 		return errors.Wrap(err, "read cluster time")
 	}
 
+	clusterID, err := topo.ClusterID(ctx, b.leadConn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster id")
+	}
+
 	meta := &BackupMeta{
 		Type:        b.typ,
 		OPID:        opid.String(),
 		Name:        bcp.Name,
 		Namespaces:  bcp.Namespaces,
+		SingleRS:    bcp.Replset,
+		Labels:      bcp.Labels,
 		Compression: bcp.Compression,
 		Store: Storage{
 			Name:        b.config.Name,
@@ -126,12 +183,41 @@ func (b *Backup) Init(
 		// the driver (mongo?) sets TS to the current wall clock if TS was 0, so have to init with 1
 		LastWriteTS: primitive.Timestamp{T: 1, I: 1},
 		// the driver (mongo?) sets TS to the current wall clock if TS was 0, so have to init with 1
-		FirstWriteTS:   primitive.Timestamp{T: 1, I: 1},
-		PBMVersion:     version.Current().Version,
-		MongoVersion:   b.mongoVersion,
-		Nomination:     []BackupRsNomination{},
-		BalancerStatus: balancer,
-		Hb:             ts,
+		FirstWriteTS:     primitive.Timestamp{T: 1, I: 1},
+		PBMVersion:       version.Current().Version,
+		IntegrityMarkers: true,
+		MongoVersion:     b.mongoVersion,
+		Nomination:       []BackupRsNomination{},
+		BalancerStatus:   balancer,
+		ClusterID:        clusterID,
+		Hb:               ts,
+	}
+	if t := bcp.ClusterTime; t != nil {
+		meta.ClusterTime = &ClusterTimeWait{Target: t.TS, TimeoutSec: t.TimeoutSec}
+	}
+	meta.Initiator = bcp.Initiator
+	if bcp.Compression == compress.CompressionTypeAuto {
+		choice, err := b.chooseCompression(ctx, bcp.Namespaces, bcp.Replset, l)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return errors.Wrap(err, "choose compression")
+			}
+			choice = &compress.AutoChoice{
+				Compression: defs.DefaultCompression,
+				Target:      b.config.Backup.CompressionAuto.AutoTarget(),
+				Reason:      "default, the benchmark failed: " + err.Error(),
+			}
+		}
+		l.Info("auto compression: %s is chosen: %s", choice.Compression, choice.Reason)
+		meta.Compression = choice.Compression
+		meta.CompressionAuto = choice
+	}
+	if b.typ == defs.PhysicalBackup {
+		meta.ExcludedDBs = b.config.Backup.ExcludedDatabases()
+	}
+	meta.MaxDurationSec = bcp.MaxDurationSec
+	if meta.MaxDurationSec == 0 {
+		meta.MaxDurationSec = int64(b.config.Backup.MaxRunTime().Seconds())
 	}
 
 	fcv, err := version.GetFCV(ctx, b.nodeConn)
@@ -156,6 +242,11 @@ func (b *Backup) Init(
 		if len(shards) != 0 {
 			meta.ShardRemap = shards
 		}
+
+		meta.ShardedColls, err = topo.ListShardedColls(ctx, b.leadConn.MongoClient())
+		if err != nil {
+			return errors.Wrap(err, "list sharded collections")
+		}
 	}
 
 	return saveBackupMeta(ctx, b.leadConn, meta)
@@ -197,30 +288,96 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 		}
 	}
 
-	stg, err := util.StorageFromConfig(&b.config.Storage, inf.Me, l)
+	// bstg is the backup storage. It keeps the metadata and
+	// the data of replsets without the storage override.
+	bstg, err := util.StorageFromConfig(&b.config.Storage, inf.Me, l)
 	if err != nil {
 		return errors.Wrap(err, "unable to get PBM storage configuration settings")
 	}
 
+	stg := bstg
+	rsStgName, rsStgConf, err := config.GetReplsetStorage(ctx, b.leadConn, b.config, inf.SetName)
+	if err != nil {
+		return errors.Wrap(err, "get replset storage")
+	}
+	if rsStgName != "" {
+		if rsStgConf.ReadOnly {
+			return errors.Wrapf(storage.ErrReadOnly, "replset storage %q", rsStgName)
+		}
+		rsMeta.Store = &Storage{
+			Name:        rsStgName,
+			IsProfile:   true,
+			StorageConf: *rsStgConf,
+		}
+		stg, err = util.StorageFromConfig(rsStgConf, inf.Me, l)
+		if err != nil {
+			return errors.Wrapf(err, "get replset storage %q", rsStgName)
+		}
+		l.Info("replset data goes to the storage of %q profile", rsStgName)
+	}
+	for _, c := range []*config.StorageConf{&b.config.Storage, rsStgConf} {
+		if err := c.CheckEncryptionKey(); err != nil {
+			return errors.Wrap(err, "check storage")
+		}
+	}
+
+	transfers := storage.NewStatsRecorder()
+	bstg = storage.WithStats(bstg, transfers)
+	stg = storage.WithStats(stg, transfers)
+	sums := storage.NewChecksumRecorder()
+	stg = storage.WithChecksums(stg, sums)
+	// saveTransfers is the best effort, the stats shouldn't fail the backup
+	saveTransfers := func() {
+		err := SetRSTransfers(context.Background(), b.leadConn, bcp.Name, rsMeta.Name, transfers.Stats())
+		if err != nil {
+			l.Warning("save transfer stats: %v", err)
+		}
+	}
+
 	bcpm, err := NewDBManager(b.leadConn).GetBackupByName(ctx, bcp.Name)
 	if err != nil {
 		return errors.Wrap(err, "balancer status, get backup meta")
 	}
+	// the leader has chosen the compression for `compression: auto`
+	if bcp.Compression == compress.CompressionTypeAuto {
+		bcp.Compression = bcpm.Compression
+		bcp.CompressionLevel = nil
+		if bcpm.CompressionAuto != nil {
+			bcp.CompressionLevel = bcpm.CompressionAuto.Level
+		}
+	}
 
 	// on any error the RS' and the backup' (in case this is the backup leader) meta will be marked appropriately
 	defer func() {
 		if err != nil {
 			status := defs.StatusError
+			msg := err.Error()
 			if errors.Is(err, storage.ErrCancelled) || errors.Is(err, context.Canceled) {
 				status = defs.StatusCancelled
+				// the cause tells why it was canceled (e.g. the agent shutdown)
+				if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+					msg = cause.Error()
+				}
 			}
 
-			ferr := ChangeRSState(b.leadConn, bcp.Name, rsMeta.Name, status, err.Error())
-			l.Info("mark RS as %s `%v`: %v", status, err, ferr)
+			saveTransfers()
+			code := errors.CodeOf(err)
+			if code != "" && status == defs.StatusError {
+				if ferr := SetRSErrorCode(b.leadConn, bcp.Name, rsMeta.Name, code); ferr != nil {
+					l.Warning("set replset error code %s: %v", code, ferr)
+				}
+			}
+			ferr := ChangeRSState(b.leadConn, bcp.Name, rsMeta.Name, status, msg)
+			l.Info("mark RS as %s `%v`: %v", status, msg, ferr)
 
-			if inf.IsLeader() {
-				ferr := ChangeBackupState(b.leadConn, bcp.Name, status, err.Error())
-				l.Info("mark backup as %s `%v`: %v", status, err, ferr)
+			if b.IsLeader(inf) {
+				if code != "" && status == defs.StatusError {
+					if ferr := SetErrorCode(b.leadConn, bcp.Name, code); ferr != nil {
+						l.Warning("set backup error code %s: %v", code, ferr)
+					}
+				}
+				ferr := ChangeBackupState(b.leadConn, bcp.Name, status, msg)
+				l.Info("mark backup as %s `%v`: %v", status, msg, ferr)
 			}
 		}
 
@@ -242,7 +399,7 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 		l.Debug("set balancer on")
 	}()
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		hbstop := make(chan struct{})
 		defer close(hbstop)
 
@@ -271,21 +428,44 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 		}()
 	}
 
-	err = storage.HasReadAccess(ctx, stg)
+	err = storage.HasReadAccess(ctx, bstg)
 	if err != nil {
 		if !errors.Is(err, storage.ErrUninitialized) {
 			return errors.Wrap(err, "check read access")
 		}
 
-		if inf.IsLeader() {
-			err = util.Initialize(ctx, stg)
+		if b.IsLeader(inf) {
+			err = util.Initialize(ctx, bstg)
 			if err != nil {
 				return errors.Wrap(err, "init storage")
 			}
 		}
 	}
+	if b.IsLeader(inf) {
+		owner, err := util.ClusterOwner(ctx, b.leadConn, b.config)
+		if err != nil {
+			return errors.Wrap(err, "get cluster identity")
+		}
+		err = util.CheckStorageOwner(bstg, owner, bcp.ForceClaim)
+		if err != nil {
+			return errors.Wrap(err, "check storage owner")
+		}
+	}
+	if rsMeta.Store != nil {
+		err = storage.HasReadAccess(ctx, stg)
+		if err != nil {
+			if !errors.Is(err, storage.ErrUninitialized) {
+				return errors.Wrap(err, "check replset storage read access")
+			}
 
-	if inf.IsSharded() && inf.IsLeader() {
+			err = util.Initialize(ctx, stg)
+			if err != nil {
+				return errors.Wrap(err, "init replset storage")
+			}
+		}
+	}
+
+	if inf.IsSharded() && b.IsLeader(inf) {
 		if bcpm.BalancerStatus == topo.BalancerModeOn {
 			err = topo.SetBalancerStatus(ctx, b.leadConn, topo.BalancerModeOff)
 			if err != nil {
@@ -306,20 +486,36 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 	}
 
 	defer func() {
-		if err == nil || !inf.IsLeader() {
+		if err == nil {
 			return
 		}
 
-		if err := DeleteBackupFiles(stg, bcp.Name); err != nil {
-			l.Error("Failed to delete leftover files for canceled backup %q", bcpm.Name)
+		if b.IsLeader(inf) {
+			if err := DeleteBackupFiles(bstg, bcp.Name); err != nil {
+				l.Error("Failed to delete leftover files for canceled backup %q", bcpm.Name)
+			}
+		}
+		if rsMeta.Store != nil {
+			if err := DeleteBackupFiles(stg, bcp.Name); err != nil {
+				l.Error("Failed to delete leftover replset files for canceled backup %q", bcpm.Name)
+			}
 		}
 	}()
 
+	pre := b.config.Backup.Hooks.PreHook()
+	err = b.runHook(ctx, "pre-backup", pre, bcp, opid, inf, l)
+	if err != nil {
+		if pre.Policy() != config.HookWarn {
+			return err
+		}
+		l.Warning("%v. proceeding with the backup", err)
+	}
+
 	switch b.typ {
 	case defs.LogicalBackup:
 		err = b.doLogical(ctx, bcp, opid, &rsMeta, inf, stg, l)
 	case defs.PhysicalBackup, defs.IncrementalBackup, defs.ExternalBackup:
-		err = b.doPhysical(ctx, bcp, opid, &rsMeta, inf, stg, l)
+		err = b.doPhysical(ctx, bcp, opid, &rsMeta, bcpm.ExcludedDBs, inf, stg, l)
 	default:
 		return errors.New("undefined backup type")
 	}
@@ -327,13 +523,28 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 		return err
 	}
 
+	// the files of external backups are copied by the user
+	if b.typ != defs.ExternalBackup {
+		sumsFile, err := saveChecksums(stg, bcp.Name, rsMeta.Name, sums.Sums())
+		if err != nil {
+			return errors.Wrap(err, "save checksums")
+		}
+		if sumsFile != "" {
+			err = SetRSChecksumsFile(ctx, b.leadConn, bcp.Name, rsMeta.Name, sumsFile)
+			if err != nil {
+				return errors.Wrap(err, "set checksums file")
+			}
+		}
+	}
+
+	saveTransfers()
 	err = ChangeRSState(b.leadConn, bcp.Name, rsMeta.Name, defs.StatusDone, "")
 	if err != nil {
 		return errors.Wrap(err, "set shard's StatusDone")
 	}
 
-	if inf.IsLeader() {
-		shards, err := topo.ClusterMembers(ctx, b.leadConn.MongoClient())
+	if b.IsLeader(inf) {
+		shards, err := b.clusterMembers(ctx)
 		if err != nil {
 			return errors.Wrap(err, "check cluster for backup done: get cluster members")
 		}
@@ -344,11 +555,35 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 			return err
 		}
 
+		if b.consistencyCheckOn(inf, bcp) {
+			cc, err := b.runConsistencyCheck(ctx, bcp.Name, l)
+			if err != nil {
+				l.Warning("consistency check: %v", err)
+			} else if !cc.OK() {
+				if b.config.Backup.ConsistencyCheck.IsFailOnMismatch() {
+					return errors.Errorf("consistency check: %d issues found, see `pbm describe-backup %s`",
+						len(cc.Issues), bcp.Name)
+				}
+				l.Warning("consistency check: %s", cc)
+				err = AddBackupWarning(ctx, b.leadConn, bcp.Name,
+					fmt.Sprintf("consistency check: %d issues found", len(cc.Issues)))
+				if err != nil {
+					l.Error("record consistency check warning: %v", err)
+				}
+			}
+		}
+
 		bcpm, err = NewDBManager(b.leadConn).GetBackupByName(ctx, bcp.Name)
 		if err != nil {
 			return errors.Wrap(err, "get backup metadata")
 		}
 
+		bcpm.MinPBMVersion, bcpm.RestoreFeatures = bcpm.RequiredPBM()
+		err = SetMinPBMVersion(ctx, b.leadConn, bcp.Name, bcpm.MinPBMVersion, bcpm.RestoreFeatures)
+		if err != nil {
+			return errors.Wrap(err, "set min PBM version")
+		}
+
 		// PBM-1114: update file metadata with the same values as in database
 		unix := time.Now().Unix()
 		bcpm.Status = defs.StatusDone
@@ -358,24 +593,79 @@ func (b *Backup) Run(ctx context.Context, bcp *ctrl.BackupCmd, opid ctrl.OPID, l
 			Status:    defs.StatusDone,
 		})
 
-		err = writeMeta(stg, bcpm)
+		err = writeMeta(bstg, bcpm)
 		if err != nil {
 			return errors.Wrap(err, "dump metadata")
 		}
 
-		err = CheckBackupFiles(ctx, stg, bcp.Name)
+		err = CheckBackupFiles(ctx, bstg, bcp.Name)
 		if err != nil {
 			return errors.Wrap(err, "check backup files")
 		}
 
 		err = ChangeBackupStateWithUnixTime(ctx, b.leadConn, bcp.Name, defs.StatusDone, unix, "")
-		return errors.Wrapf(err, "check cluster for backup done: update backup meta with %s",
-			defs.StatusDone)
+		if err != nil {
+			return errors.Wrapf(err, "check cluster for backup done: update backup meta with %s",
+				defs.StatusDone)
+		}
 	} else {
 		// to be sure the locks released only after the "done" status had written
 		err = b.waitForStatus(ctx, bcp.Name, defs.StatusDone, nil)
-		return errors.Wrap(err, "waiting for done")
+		if err != nil {
+			return errors.Wrap(err, "waiting for done")
+		}
 	}
+
+	// the backup is done, so the hook can't fail it
+	err = b.runHook(ctx, "post-backup", b.config.Backup.Hooks.PostHook(), bcp, opid, inf, l)
+	if err != nil {
+		l.Warning("%v", err)
+	}
+
+	return nil
+}
+
+// consistencyCheckOn returns true if the backup is checked for consistency
+// before it is done. Only full logical backups of sharded clusters are.
+func (b *Backup) consistencyCheckOn(inf *topo.NodeInfo, bcp *ctrl.BackupCmd) bool {
+	return b.typ == defs.LogicalBackup &&
+		inf.IsSharded() &&
+		b.singleRS == "" &&
+		!util.IsSelective(bcp.Namespaces) &&
+		b.config.Backup.ConsistencyCheck.IsEnabled()
+}
+
+// rsStorageConf returns the config of the storage the replset data goes to.
+func (b *Backup) rsStorageConf(rsMeta *BackupReplset) *config.StorageConf {
+	if rsMeta.Store != nil {
+		return &rsMeta.Store.StorageConf
+	}
+	return &b.config.Storage
+}
+
+// runHook runs the backup hook on the node.
+func (b *Backup) runHook(
+	ctx context.Context,
+	name string,
+	h *config.Hook,
+	bcp *ctrl.BackupCmd,
+	opid ctrl.OPID,
+	inf *topo.NodeInfo,
+	l log.LogEvent,
+) error {
+	if !h.IsEnabled() {
+		return nil
+	}
+
+	env := []string{
+		"PBM_HOOK=" + name,
+		"PBM_BACKUP_NAME=" + bcp.Name,
+		"PBM_BACKUP_TYPE=" + string(b.typ),
+		"PBM_OPID=" + opid.String(),
+		"PBM_REPLSET=" + inf.SetName,
+		"PBM_NODE=" + inf.Me,
+	}
+	return hook.Run(ctx, name, h, env, l)
 }
 
 func waitForBalancerOff(ctx context.Context, conn connect.Client, t time.Duration, l log.LogEvent) topo.BalancerMode {
@@ -424,7 +714,7 @@ func (b *Backup) toState(
 		return errors.Wrap(err, "set shard's status")
 	}
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		err = b.reconcileStatus(ctx, bcp, opid, status, wait)
 		if err != nil {
 			if errors.Is(err, errConvergeTimeOut) {
@@ -448,7 +738,7 @@ func (b *Backup) reconcileStatus(
 	status defs.Status,
 	timeout *time.Duration,
 ) error {
-	shards, err := topo.ClusterMembers(ctx, b.leadConn.MongoClient())
+	shards, err := b.clusterMembers(ctx)
 	if err != nil {
 		return errors.Wrap(err, "get cluster members")
 	}
@@ -546,6 +836,7 @@ func (b *Backup) converged(
 	if err != nil {
 		return false, errors.Wrap(err, "read cluster time")
 	}
+	staleSec := lock.StaleSec(ctx, b.leadConn)
 
 	for _, sh := range shards {
 		for _, shard := range bmeta.Replsets {
@@ -563,7 +854,7 @@ func (b *Backup) converged(
 					if err != nil {
 						return false, errors.Wrapf(err, "unable to read lock for shard %s", shard.Name)
 					}
-					if lck.Heartbeat.T+defs.StaleFrameSec < clusterTime.T {
+					if lck.IsStale(clusterTime, staleSec) {
 						return false, errors.Errorf("lost shard %s, last beat ts: %d", shard.Name, lck.Heartbeat.T)
 					}
 				}
@@ -751,8 +1042,9 @@ func setClusterLastWriteImpl(
 		if err != nil {
 			return errors.Wrap(err, "read cluster time")
 		}
+		staleSec := lock.StaleSec(ctx, conn)
 
-		locks, err := lock.GetLocks(ctx, conn, &lock.LockHeader{
+		locks, err := lock.GetOperationLocks(ctx, conn, &lock.LockHeader{
 			Type: ctrl.CmdBackup,
 			OPID: bcp.OPID,
 		})
@@ -771,7 +1063,7 @@ func setClusterLastWriteImpl(
 			if lck == nil {
 				continue
 			}
-			if lck.Heartbeat.T+defs.StaleFrameSec < clusterTime.T {
+			if lck.IsStale(clusterTime, staleSec) {
 				return errors.Errorf("lost shard %s, last beat ts: %d", replset.Name, lck.Heartbeat.T)
 			}
 		}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// sampleDocsPerColl is the size of $sample of each collection
+const sampleDocsPerColl = 1000
+
+// chooseCompression runs the benchmark of `compression: auto` on the
+// documents sampled from the replsets of the backup. The choice is made
+// once by the leader so all replsets data is compressed the same way.
+func (b *Backup) chooseCompression(
+	ctx context.Context,
+	nss []string,
+	singleRS string,
+	l log.LogEvent,
+) (*compress.AutoChoice, error) {
+	size := defs.CompressionAutoSampleMb << 20
+
+	var sample []byte
+	if !b.brief.Sharded {
+		var err error
+		sample, err = sampleDocs(ctx, b.nodeConn, nss, size)
+		if err != nil {
+			return nil, errors.Wrap(err, "sample data")
+		}
+	} else {
+		// the leader is a config server node, the data is on the shards
+		shards, err := topo.ClusterMembers(ctx, b.leadConn.MongoClient())
+		if err != nil {
+			return nil, errors.Wrap(err, "get shards")
+		}
+		var buf bytes.Buffer
+		for _, s := range shards {
+			if singleRS != "" && s.RS != singleRS {
+				continue
+			}
+			data, err := b.sampleShard(ctx, s, nss, size/len(shards))
+			if err != nil {
+				return nil, errors.Wrapf(err, "sample data of %s", s.RS)
+			}
+			buf.Write(data)
+		}
+		sample = buf.Bytes()
+	}
+
+	cfg := b.config.Backup.CompressionAuto
+	bench := &compress.AutoBench{
+		Target:     cfg.AutoTarget(),
+		Budget:     defs.CompressionAutoBudget,
+		UploadMBps: float64(cfg.UploadRate()),
+		CPUs:       max(runtime.GOMAXPROCS(0)/2, 1),
+	}
+	l.Debug("auto compression: benchmark on %d bytes of documents", len(sample))
+	return bench.Run(sample)
+}
+
+// sampleShard samples the documents of the shard. The config server
+// (which may be a config shard) is sampled on the node of the leader.
+func (b *Backup) sampleShard(ctx context.Context, s topo.Shard, nss []string, size int) ([]byte, error) {
+	if s.RS == b.brief.SetName {
+		return sampleDocs(ctx, b.nodeConn, nss, size)
+	}
+
+	_, hosts, _ := strings.Cut(s.Host, "/")
+	cn, err := connectReplset(ctx, b.brief.URI, s.RS, strings.Split(hosts, ","))
+	if err != nil {
+		return nil, errors.Wrap(err, "connect")
+	}
+	defer cn.Disconnect(context.Background()) //nolint:errcheck
+
+	return sampleDocs(ctx, cn, nss, size)
+}
+
+// sampleDocs returns up to size bytes of documents $sample'd from the
+// collections of the node (the selected ones if nss is set).
+func sampleDocs(ctx context.Context, m *mongo.Client, nss []string, size int) ([]byte, error) {
+	dbs, err := m.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list databases")
+	}
+
+	isSelected := util.MakeSelectedPred(nss)
+	var colls []string
+	for _, db := range dbs {
+		if db == defs.DB || db == "admin" || db == "local" || db == "config" {
+			continue
+		}
+		specs, err := m.Database(db).ListCollectionSpecifications(ctx, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list collections for %q", db)
+		}
+		for _, coll := range specs {
+			ns := db + "." + coll.Name
+			if coll.Type != "collection" || strings.HasPrefix(coll.Name, "system.") || !isSelected(ns) {
+				continue
+			}
+			colls = append(colls, ns)
+		}
+	}
+	if len(colls) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	quota := max(size/len(colls), 256<<10)
+	opts := options.Collection().SetReadPreference(readpref.SecondaryPreferred())
+	for _, ns := range colls {
+		if buf.Len() >= size {
+			break
+		}
+
+		db, coll, _ := strings.Cut(ns, ".")
+		cur, err := m.Database(db).Collection(coll, opts).Aggregate(ctx,
+			mongo.Pipeline{{{"$sample", bson.D{{"size", sampleDocsPerColl}}}}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "sample %q", ns)
+		}
+		start := buf.Len()
+		for buf.Len()-start < quota && buf.Len() < size && cur.Next(ctx) {
+			buf.Write(cur.Current)
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sample %q", ns)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
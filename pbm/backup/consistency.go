@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// ConsistencyCheck is the result of the check of the sharded logical backup
+// against the cluster state at the backup start
+type ConsistencyCheck struct {
+	// Time is when the check was done (unix seconds)
+	Time   int64              `bson:"time" json:"time"`
+	Issues []ConsistencyIssue `bson:"issues,omitempty" json:"issues,omitempty"`
+}
+
+// ConsistencyIssue is a discrepancy found by the check
+type ConsistencyIssue struct {
+	RS    string `bson:"rs,omitempty" json:"rs,omitempty"`
+	NS    string `bson:"ns,omitempty" json:"ns,omitempty"`
+	Shard string `bson:"shard,omitempty" json:"shard,omitempty"`
+	Msg   string `bson:"msg" json:"msg"`
+}
+
+func (i *ConsistencyIssue) String() string {
+	switch {
+	case i.NS != "":
+		return fmt.Sprintf("%s: %s: %s", i.RS, i.NS, i.Msg)
+	case i.RS != "":
+		return fmt.Sprintf("%s: %s", i.RS, i.Msg)
+	case i.Shard != "":
+		return fmt.Sprintf("shard %s: %s", i.Shard, i.Msg)
+	}
+	return i.Msg
+}
+
+// OK returns true if no discrepancies found
+func (c *ConsistencyCheck) OK() bool {
+	return len(c.Issues) == 0
+}
+
+func (c *ConsistencyCheck) String() string {
+	if c.OK() {
+		return "ok"
+	}
+
+	s := fmt.Sprintf("%d issues", len(c.Issues))
+	for i := range c.Issues {
+		s += "\n  - " + c.Issues[i].String()
+	}
+	return s
+}
+
+// nsSnapshot returns the collections the backup dumps from the node with
+// their estimated documents count
+func nsSnapshot(ctx context.Context, conn *mongo.Client) ([]NSStat, error) {
+	nss, err := archive.ListNamespaces(ctx, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []NSStat
+	for _, ns := range nss {
+		if ns.IsCollection() {
+			rv = append(rv, NSStat{NS: ns.NS(), Docs: ns.Count})
+		}
+	}
+	return rv, nil
+}
+
+// chunkOwners returns the shards owning chunks or being the primary shard
+// of databases according to the config server
+func chunkOwners(ctx context.Context, m connect.Client) ([]string, error) {
+	owners := make(map[string]bool)
+	for coll, field := range map[string]string{"chunks": "shard", "databases": "primary"} {
+		vals, err := m.ConfigDatabase().Collection(coll).Distinct(ctx, field, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "distinct %s of %s", field, coll)
+		}
+		for _, v := range vals {
+			if s, ok := v.(string); ok {
+				owners[s] = true
+			}
+		}
+	}
+
+	rv := make([]string, 0, len(owners))
+	for s := range owners {
+		rv = append(rv, s)
+	}
+	slices.Sort(rv)
+	return rv, nil
+}
+
+// checkConsistency compares the collections and documents count dumped by
+// each replset with the replset snapshot at the backup start, and the shards
+// owning chunks or databases with the shards of the backup. tolerance is
+// the percent of the documents of a collection that may be missing in
+// the dump. Replsets without the snapshot (e.g. made by older agents)
+// aren't checked.
+func checkConsistency(bcp *BackupMeta, owners []string, tolerance float64) []ConsistencyIssue {
+	var issues []ConsistencyIssue
+
+	inBackup := make(map[string]bool)
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		shard := rs.Name
+		if s, ok := bcp.ShardRemap[rs.Name]; ok {
+			shard = s
+		}
+		inBackup[shard] = true
+
+		if rs.NSSnapshot == nil {
+			continue
+		}
+		if rs.NSStats == nil {
+			issues = append(issues, ConsistencyIssue{
+				RS:  rs.Name,
+				Msg: "no stats of the dumped collections",
+			})
+			continue
+		}
+
+		dumped := make(map[string]NSStat, len(rs.NSStats))
+		for _, s := range rs.NSStats {
+			dumped[s.NS] = s
+		}
+		for _, s := range rs.NSSnapshot {
+			d, ok := dumped[s.NS]
+			if !ok {
+				issues = append(issues, ConsistencyIssue{
+					RS:  rs.Name,
+					NS:  s.NS,
+					Msg: "the collection existed at the backup start but is not in the backup",
+				})
+				continue
+			}
+			if s.Docs > 0 && float64(s.Docs-d.Docs) > float64(s.Docs)*tolerance/100 {
+				issues = append(issues, ConsistencyIssue{
+					RS: rs.Name,
+					NS: s.NS,
+					Msg: fmt.Sprintf("dumped %d documents of %d at the backup start (tolerance %v%%)",
+						d.Docs, s.Docs, tolerance),
+				})
+			}
+		}
+	}
+
+	for _, s := range owners {
+		if !inBackup[s] {
+			issues = append(issues, ConsistencyIssue{
+				Shard: s,
+				Msg:   "owns chunks or databases but is not in the backup",
+			})
+		}
+	}
+
+	return issues
+}
+
+// runConsistencyCheck checks the backup once all replsets are done and
+// saves the result in the backup metadata
+func (b *Backup) runConsistencyCheck(
+	ctx context.Context,
+	bcpName string,
+	l log.LogEvent,
+) (*ConsistencyCheck, error) {
+	bcp, err := NewDBManager(b.leadConn).GetBackupByName(ctx, bcpName)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup metadata")
+	}
+	err = LoadNSStats(bcp, b.brief.Me, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "read namespaces stats")
+	}
+
+	owners, err := chunkOwners(ctx, b.leadConn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunk owners")
+	}
+
+	rv := &ConsistencyCheck{
+		Time:   time.Now().Unix(),
+		Issues: checkConsistency(bcp, owners, b.config.Backup.ConsistencyCheck.Tolerance()),
+	}
+	err = SetConsistencyCheck(ctx, b.leadConn, bcpName, rv)
+	return rv, errors.Wrap(err, "save result")
+}
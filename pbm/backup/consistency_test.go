@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	bcp := &BackupMeta{
+		ShardRemap: map[string]string{"cfg": "config"},
+		Replsets: []BackupReplset{
+			{
+				Name:       "cfg",
+				NSSnapshot: []NSStat{{NS: "config.chunks", Docs: 10}},
+				NSStats:    []NSStat{{NS: "config.chunks", Docs: 10}},
+			},
+			{
+				Name: "rs0",
+				NSSnapshot: []NSStat{
+					{NS: "db.a", Docs: 100},
+					{NS: "db.b", Docs: 100},
+					{NS: "db.c", Docs: 100},
+					{NS: "db.empty"},
+				},
+				NSStats: []NSStat{
+					{NS: "db.a", Docs: 95},
+					{NS: "db.b", Docs: 50},
+					{NS: "db.new", Docs: 1},
+					{NS: "db.empty"},
+				},
+			},
+			{
+				// made by an older agent
+				Name:    "rs1",
+				NSStats: []NSStat{{NS: "db.a", Docs: 1}},
+			},
+			{
+				Name:       "rs2",
+				NSSnapshot: []NSStat{{NS: "db.a", Docs: 1}},
+			},
+		},
+	}
+
+	got := checkConsistency(bcp, []string{"config", "rs0", "rs1", "rs3"}, 10)
+	want := []ConsistencyIssue{
+		{RS: "rs0", NS: "db.b", Msg: "dumped 50 documents of 100 at the backup start (tolerance 10%)"},
+		{RS: "rs0", NS: "db.c", Msg: "the collection existed at the backup start but is not in the backup"},
+		{RS: "rs2", Msg: "no stats of the dumped collections"},
+		{Shard: "rs3", Msg: "owns chunks or databases but is not in the backup"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	bcp.Replsets = bcp.Replsets[:1]
+	if got := checkConsistency(bcp, []string{"config"}, 10); len(got) != 0 {
+		t.Errorf("unexpected issues: %+v", got)
+	}
+}
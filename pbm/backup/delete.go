@@ -2,6 +2,8 @@ package backup
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,6 +17,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
 )
 
@@ -46,26 +49,29 @@ var (
 	ErrNonIncrementalBackup = errors.New("backup is not incremental")
 	ErrNotBaseIncrement     = errors.New("backup is not base increment")
 	ErrBaseForPITR          = errors.New("cannot delete the last PITR base snapshot while PITR is enabled")
+	ErrReadOnlyStorage      = errors.New("backup is on the read-only storage")
 )
 
 type CleanupInfo struct {
 	Backups []BackupMeta       `json:"backups"`
 	Chunks  []oplog.OplogChunk `json:"chunks"`
+	// Held are the backups on hold which would be deleted otherwise
+	Held []BackupMeta `json:"held,omitempty"`
 }
 
 // DeleteBackup deletes backup with the given name from the current storage
-// and pbm database
-func DeleteBackup(ctx context.Context, conn connect.Client, name, node string) error {
+// and pbm database. The backup on hold is deleted only with overrideHold.
+func DeleteBackup(ctx context.Context, conn connect.Client, name, node string, overrideHold bool) error {
 	bcp, err := NewDBManager(conn).GetBackupByName(ctx, name)
 	if err != nil {
 		return errors.Wrap(err, "get backup meta")
 	}
 
 	if bcp.Type == defs.IncrementalBackup {
-		return deleteIncremetalChainImpl(ctx, conn, bcp, node)
+		return deleteIncremetalChainImpl(ctx, conn, bcp, node, overrideHold)
 	}
 
-	return deleteBackupImpl(ctx, conn, bcp, node)
+	return deleteBackupImpl(ctx, conn, bcp, node, overrideHold)
 }
 
 func deleteBackupImpl(
@@ -73,9 +79,10 @@ func deleteBackupImpl(
 	conn connect.Client,
 	bcp *BackupMeta,
 	node string,
+	overrideHold bool,
 ) error {
 	err := CanDeleteBackup(ctx, conn, bcp)
-	if err != nil {
+	if err != nil && !(overrideHold && errors.Is(err, ErrBackupHeld)) {
 		return err
 	}
 
@@ -84,7 +91,7 @@ func deleteBackupImpl(
 		return errors.Wrap(err, "get storage")
 	}
 
-	err = DeleteBackupFiles(stg, bcp.Name)
+	err = DeleteAllBackupFiles(ctx, stg, bcp, node)
 	if err != nil {
 		return errors.Wrap(err, "delete files from storage")
 	}
@@ -97,14 +104,37 @@ func deleteBackupImpl(
 	return nil
 }
 
-func deleteIncremetalChainImpl(ctx context.Context, conn connect.Client, bcp *BackupMeta, node string) error {
+// DeleteAllBackupFiles deletes the backup files from the backup storage stg
+// and from the storages of the replsets with the storage override.
+func DeleteAllBackupFiles(ctx context.Context, stg storage.Storage, bcp *BackupMeta, node string) error {
+	for _, s := range bcp.Storages()[1:] {
+		rstg, err := util.StorageFromConfig(&s.StorageConf, node, log.LogEventFromContext(ctx))
+		if err != nil {
+			return errors.Wrapf(err, "get storage %q", s.Name)
+		}
+		err = DeleteBackupFiles(rstg, bcp.Name)
+		if err != nil {
+			return errors.Wrapf(err, "storage %q", s.Name)
+		}
+	}
+
+	return DeleteBackupFiles(stg, bcp.Name)
+}
+
+func deleteIncremetalChainImpl(
+	ctx context.Context,
+	conn connect.Client,
+	bcp *BackupMeta,
+	node string,
+	overrideHold bool,
+) error {
 	increments, err := FetchAllIncrements(ctx, conn, bcp)
 	if err != nil {
 		return err
 	}
 
 	err = CanDeleteIncrementalChain(ctx, conn, bcp, increments)
-	if err != nil {
+	if err != nil && !(overrideHold && errors.Is(err, ErrBackupHeld)) {
 		return err
 	}
 
@@ -121,7 +151,7 @@ func deleteIncremetalChainImpl(ctx context.Context, conn connect.Client, bcp *Ba
 	for i := len(all) - 1; i >= 0; i-- {
 		bcp := all[i]
 
-		err = DeleteBackupFiles(stg, bcp.Name)
+		err = DeleteAllBackupFiles(ctx, stg, bcp, node)
 		if err != nil {
 			return errors.Wrap(err, "delete files from storage")
 		}
@@ -136,10 +166,24 @@ func deleteIncremetalChainImpl(ctx context.Context, conn connect.Client, bcp *Ba
 	return nil
 }
 
+// CanDeleteBackup returns the reason the backup can't be deleted.
+// ErrBackupHeld is returned only if the hold is the only reason.
 func CanDeleteBackup(ctx context.Context, conn connect.Client, bcp *BackupMeta) error {
+	err := canDeleteBackup(ctx, conn, bcp)
+	if err != nil {
+		return err
+	}
+
+	return checkHold(bcp)
+}
+
+func canDeleteBackup(ctx context.Context, conn connect.Client, bcp *BackupMeta) error {
 	if bcp.Status.IsRunning() {
 		return ErrBackupInProgress
 	}
+	if bcp.IsReadOnly() {
+		return ErrReadOnlyStorage
+	}
 	if !isValidBaseSnapshot(bcp) {
 		return nil
 	}
@@ -158,15 +202,39 @@ func CanDeleteBackup(ctx context.Context, conn connect.Client, bcp *BackupMeta)
 	return nil
 }
 
+// CanDeleteIncrementalChain returns the reason the chain can't be deleted.
+// ErrBackupHeld is returned only if the hold of any backup of the chain
+// is the only reason.
 func CanDeleteIncrementalChain(
 	ctx context.Context,
 	conn connect.Client,
 	base *BackupMeta,
 	increments [][]*BackupMeta,
+) error {
+	err := canDeleteIncrementalChain(ctx, conn, base, increments)
+	if err != nil {
+		return err
+	}
+
+	chain := []*BackupMeta{base}
+	for _, incs := range increments {
+		chain = append(chain, incs...)
+	}
+	return checkHold(chain...)
+}
+
+func canDeleteIncrementalChain(
+	ctx context.Context,
+	conn connect.Client,
+	base *BackupMeta,
+	increments [][]*BackupMeta,
 ) error {
 	if base.Status.IsRunning() {
 		return ErrBackupInProgress
 	}
+	if base.IsReadOnly() {
+		return ErrReadOnlyStorage
+	}
 	if base.Type != defs.IncrementalBackup {
 		return ErrNonIncrementalBackup
 	}
@@ -323,10 +391,13 @@ func DeleteBackupBefore(
 	bcpType defs.BackupType,
 	node string,
 ) error {
-	backups, err := ListDeleteBackupBefore(ctx, conn, primitive.Timestamp{T: uint32(t.Unix())}, bcpType)
+	backups, held, err := listDeleteBackupBefore(ctx, conn, primitive.Timestamp{T: uint32(t.Unix())}, bcpType)
 	if err != nil {
 		return err
 	}
+	for i := range held {
+		log.LogEventFromContext(ctx).Info("skip backup %q: on hold %s", held[i].Name, held[i].Hold)
+	}
 	if len(backups) == 0 {
 		return nil
 	}
@@ -339,7 +410,7 @@ func DeleteBackupBefore(
 	for i := range backups {
 		bcp := &backups[i]
 
-		err := DeleteBackupFiles(stg, bcp.Name)
+		err := DeleteAllBackupFiles(ctx, stg, bcp, node)
 		if err != nil {
 			return errors.Wrapf(err, "delete files from storage for %q", bcp.Name)
 		}
@@ -359,38 +430,193 @@ func ListDeleteBackupBefore(
 	ts primitive.Timestamp,
 	bcpType defs.BackupType,
 ) ([]BackupMeta, error) {
+	rv, _, err := listDeleteBackupBefore(ctx, conn, ts, bcpType)
+	return rv, err
+}
+
+// listDeleteBackupBefore returns the backups to delete and the ones
+// on hold which are kept.
+func listDeleteBackupBefore(
+	ctx context.Context,
+	conn connect.Client,
+	ts primitive.Timestamp,
+	bcpType defs.BackupType,
+) ([]BackupMeta, []BackupMeta, error) {
 	info, err := MakeCleanupInfo(ctx, conn, ts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bcpType == "" {
+		return info.Backups, info.Held, nil
+	}
+
+	pred := deleteTypeFilter(bcpType)
+	rv := []BackupMeta{}
+	for i := range info.Backups {
+		if pred(&info.Backups[i]) {
+			rv = append(rv, info.Backups[i])
+		}
+	}
+	held := []BackupMeta{}
+	for i := range info.Held {
+		if pred(&info.Held[i]) {
+			held = append(held, info.Held[i])
+		}
+	}
+
+	return rv, held, nil
+}
+
+// KeptBackup is a backup older than the deletion time
+// that is not deleted because other data depends on it.
+type KeptBackup struct {
+	BackupMeta
+
+	// RequiredBy describes what depends on the backup.
+	// Empty if it's not known.
+	RequiredBy string `json:"requiredBy,omitempty"`
+}
+
+// ListKeptBackupsBefore returns backups of the type (any if empty) created
+// before ts which ListDeleteBackupBefore excludes from deletion because
+// the PITR window or an incremental chain depends on them, or they are
+// on hold.
+func ListKeptBackupsBefore(
+	ctx context.Context,
+	conn connect.Client,
+	ts primitive.Timestamp,
+	bcpType defs.BackupType,
+) ([]KeptBackup, error) {
+	all, err := listBackupsBefore(ctx, conn, ts)
+	if err != nil {
+		return nil, errors.Wrap(err, "list backups before")
+	}
+	deleted, err := ListDeleteBackupBefore(ctx, conn, ts, bcpType)
 	if err != nil {
 		return nil, err
 	}
-	if len(info.Backups) == 0 {
-		return nil, nil
+
+	pred := func(*BackupMeta) bool { return true }
+	if bcpType != "" {
+		pred = deleteTypeFilter(bcpType)
 	}
-	if bcpType == "" {
-		return info.Backups, nil
+
+	rv := []KeptBackup{}
+	for i := range all {
+		bcp := &all[i]
+		if !pred(bcp) || slices.ContainsFunc(deleted, func(b BackupMeta) bool { return b.Name == bcp.Name }) {
+			continue
+		}
+
+		var reason string
+		if bcp.Hold != nil {
+			reason = "the hold " + bcp.Hold.String()
+		} else if bcp.Type == defs.IncrementalBackup {
+			next, err := nextIncrementName(ctx, conn, bcp.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "find increment based on %q", bcp.Name)
+			}
+			if next != "" {
+				reason = fmt.Sprintf("incremental backup %q", next)
+			}
+		}
+		if reason == "" && isValidBaseSnapshot(bcp) {
+			// only the base snapshot of the PITR timeline after ts is kept
+			reason = "PITR window"
+		}
+
+		rv = append(rv, KeptBackup{BackupMeta: *bcp, RequiredBy: reason})
+	}
+
+	return rv, nil
+}
+
+func nextIncrementName(ctx context.Context, conn connect.Client, bcpName string) (string, error) {
+	f := bson.D{
+		{"src_backup", bcpName},
+		{"status", bson.M{"$nin": bson.A{defs.StatusCancelled, defs.StatusError}}},
+	}
+	o := options.FindOne().SetProjection(bson.D{{"name", 1}})
+	rv := struct {
+		Name string `bson:"name"`
+	}{}
+	err := conn.BcpCollection().FindOne(ctx, f, o).Decode(&rv)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "query")
 	}
 
-	pred := func(m *BackupMeta) bool { return m.Type == bcpType }
+	return rv.Name, nil
+}
+
+func deleteTypeFilter(bcpType defs.BackupType) func(*BackupMeta) bool {
 	switch bcpType {
 	case defs.LogicalBackup:
-		pred = func(m *BackupMeta) bool {
+		return func(m *BackupMeta) bool {
 			return m.Type == defs.LogicalBackup && !util.IsSelective(m.Namespaces)
 		}
 	case SelectiveBackup:
-		pred = func(m *BackupMeta) bool { return util.IsSelective(m.Namespaces) }
+		return func(m *BackupMeta) bool { return util.IsSelective(m.Namespaces) }
 	}
 
-	rv := []BackupMeta{}
-	for i := range info.Backups {
-		if pred(&info.Backups[i]) {
-			rv = append(rv, info.Backups[i])
+	return func(m *BackupMeta) bool { return m.Type == bcpType }
+}
+
+// ListPITRChunksToDelete returns chunks older than olderThan to delete by
+// the `delete-pitr`, keeping chunks needed by backups (see MakeCleanupInfo).
+// If rs is not empty, only chunks of the replset are returned.
+func ListPITRChunksToDelete(
+	ctx context.Context,
+	conn connect.Client,
+	rs string,
+	olderThan primitive.Timestamp,
+) ([]oplog.OplogChunk, error) {
+	r, err := MakeCleanupInfo(ctx, conn, olderThan)
+	if err != nil {
+		return nil, err
+	}
+	if rs == "" {
+		return r.Chunks, nil
+	}
+
+	chunks := []oplog.OplogChunk{}
+	for _, c := range r.Chunks {
+		if c.RS == rs {
+			chunks = append(chunks, c)
 		}
 	}
+	return chunks, nil
+}
 
-	return rv, nil
+// ListPITRChunksInRange returns chunks overlapping [from, to] to delete by
+// the `delete-pitr --from/--to`. If rs is not empty, only chunks of
+// the replset are returned.
+func ListPITRChunksInRange(
+	ctx context.Context,
+	conn connect.Client,
+	rs string,
+	from primitive.Timestamp,
+	to primitive.Timestamp,
+) ([]oplog.OplogChunk, error) {
+	chunks, err := oplog.PITRGetChunksSlice(ctx, conn, rs, from, to)
+	return chunks, errors.Wrap(err, "get chunks")
 }
 
+// MakeCleanupInfo returns the backups and chunks to delete before ts.
+// The backups on hold (and the ones they depend on) are kept.
 func MakeCleanupInfo(ctx context.Context, conn connect.Client, ts primitive.Timestamp) (CleanupInfo, error) {
+	info, err := makeCleanupInfo(ctx, conn, ts)
+	if err != nil {
+		return info, err
+	}
+
+	info.Backups, info.Held = excludeHeld(info.Backups)
+	return info, nil
+}
+
+func makeCleanupInfo(ctx context.Context, conn connect.Client, ts primitive.Timestamp) (CleanupInfo, error) {
 	backups, err := listBackupsBefore(ctx, conn, primitive.Timestamp{T: ts.T + 1})
 	if err != nil {
 		return CleanupInfo{}, errors.Wrap(err, "list backups before")
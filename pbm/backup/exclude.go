@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// collStorageStats is the part of `$collStats` storageStats with
+// the WiredTiger tables of the collection and its indexes
+type collStorageStats struct {
+	StorageStats struct {
+		WiredTiger struct {
+			URI string `bson:"uri"`
+		} `bson:"wiredTiger"`
+		IndexDetails map[string]struct {
+			URI string `bson:"uri"`
+		} `bson:"indexDetails"`
+	} `bson:"storageStats"`
+}
+
+// excludedFiles returns the data files (relative to the dbpath) of
+// the collections and indexes of the databases. The files are looked up
+// by the WiredTiger tables (idents) of the current catalog.
+func excludedFiles(ctx context.Context, m *mongo.Client, dbs []string) (map[string]struct{}, error) {
+	rv := make(map[string]struct{})
+	for _, db := range dbs {
+		colls, err := m.Database(db).ListCollectionNames(ctx, bson.D{{"type", "collection"}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list collections of %q", db)
+		}
+
+		for _, coll := range colls {
+			cur, err := m.Database(db).Collection(coll).Aggregate(ctx,
+				mongo.Pipeline{{{"$collStats", bson.D{{"storageStats", bson.D{}}}}}})
+			if err != nil {
+				return nil, errors.Wrapf(err, "collStats %s.%s", db, coll)
+			}
+			var stats []collStorageStats
+			if err := cur.All(ctx, &stats); err != nil {
+				return nil, errors.Wrapf(err, "decode collStats %s.%s", db, coll)
+			}
+
+			for _, s := range stats {
+				if f, ok := identFile(s.StorageStats.WiredTiger.URI); ok {
+					rv[f] = struct{}{}
+				}
+				for _, idx := range s.StorageStats.IndexDetails {
+					if f, ok := identFile(idx.URI); ok {
+						rv[f] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	return rv, nil
+}
+
+// identFile returns the data file of the WiredTiger table uri
+// (e.g. `statistics:table:collection-7-1234` is `collection-7-1234.wt`).
+func identFile(uri string) (string, bool) {
+	_, ident, ok := strings.Cut(uri, "table:")
+	if !ok || ident == "" {
+		return "", false
+	}
+	return ident + ".wt", true
+}
+
+// excludeFiles removes the excluded files from the backup cursor files.
+// It returns the rest, the number and the size of removed files.
+func excludeFiles(files []File, dbpath string, excl map[string]struct{}) ([]File, int, int64) {
+	rv := make([]File, 0, len(files))
+	var n int
+	var size int64
+	for _, f := range files {
+		name := path.Clean("./" + strings.TrimPrefix(f.Name, dbpath))
+		if _, ok := excl[name]; ok {
+			n++
+			size += f.Size
+			continue
+		}
+		rv = append(rv, f)
+	}
+
+	return rv, n, size
+}
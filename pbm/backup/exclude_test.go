@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdentFile(t *testing.T) {
+	cases := map[string]string{
+		"statistics:table:collection-7-1234":         "collection-7-1234.wt",
+		"statistics:table:analytics/index-9-1234":    "analytics/index-9-1234.wt",
+		"statistics:table:analytics/collection/3-12": "analytics/collection/3-12.wt",
+		"":                  "",
+		"statistics:table:": "",
+	}
+	for uri, want := range cases {
+		got, ok := identFile(uri)
+		if got != want || ok != (want != "") {
+			t.Errorf("%q: got %q, %v; want %q", uri, got, ok, want)
+		}
+	}
+}
+
+func TestExcludeFiles(t *testing.T) {
+	files := []File{
+		{Name: "/data/db/WiredTiger.wt", Size: 10},
+		{Name: "/data/db/collection-7-1234.wt", Size: 100},
+		{Name: "/data/db/index-8-1234.wt", Size: 20},
+		{Name: "/data/db/collection-9-1234.wt", Size: 50},
+		{Name: "/data/db/analytics/collection-3-1234.wt", Size: 1000},
+	}
+	excl := map[string]struct{}{
+		"collection-7-1234.wt":           {},
+		"index-8-1234.wt":                {},
+		"analytics/collection-3-1234.wt": {},
+	}
+
+	got, n, size := excludeFiles(files, "/data/db", excl)
+	want := []File{files[0], files[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files: got %v, want %v", got, want)
+	}
+	if n != 3 || size != 1120 {
+		t.Errorf("excluded: got %d files, %d bytes; want 3, 1120", n, size)
+	}
+}
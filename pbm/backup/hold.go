@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// ErrBackupHeld is returned on the deletion of the backup on hold
+// (see `pbm backup hold`) unless the hold is overridden.
+var ErrBackupHeld = errors.New("backup is on hold")
+
+// BackupHold protects the backup from the deletion by PBM: `pbm
+// delete-backup`, `pbm cleanup` and retention skip it. The hold is saved
+// in the metadata file on the storage as well, so resync keeps it.
+type BackupHold struct {
+	// By is who has set the hold
+	By string `bson:"by" json:"by"`
+	// At is unix seconds
+	At int64 `bson:"at" json:"at"`
+}
+
+func (h *BackupHold) String() string {
+	return fmt.Sprintf("set by %s at %s", h.By, time.Unix(h.At, 0).UTC().Format(time.RFC3339))
+}
+
+// SetHold sets the hold of the backup (releases it if h is nil) in the
+// metadata file on the storage and then in the database.
+func SetHold(ctx context.Context, conn connect.Client, bcp *BackupMeta, h *BackupHold, node string) error {
+	if bcp.Status != defs.StatusDone {
+		return errors.Errorf("backup is %s", bcp.Status)
+	}
+	if bcp.IsReadOnly() {
+		return ErrReadOnlyStorage
+	}
+
+	stg, err := util.StorageFromConfig(&bcp.Store.StorageConf, node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	meta, err := ReadMetadata(stg, bcp.Name+defs.MetadataFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "read metadata from storage")
+	}
+	meta.Hold = h
+	err = writeMeta(stg, meta)
+	if err != nil {
+		return errors.Wrap(err, "write metadata to storage")
+	}
+
+	upd := bson.D{{"$set", bson.M{"hold": h}}}
+	if h == nil {
+		upd = bson.D{{"$unset", bson.M{"hold": 1}}}
+	}
+	_, err = conn.BcpCollection().UpdateOne(ctx, bson.D{{"name", bcp.Name}}, upd)
+	return errors.Wrap(err, "update metadata in db")
+}
+
+// checkHold returns ErrBackupHeld if any of the backups is on hold
+func checkHold(bcps ...*BackupMeta) error {
+	for _, b := range bcps {
+		if b.Hold != nil {
+			return errors.Wrapf(ErrBackupHeld, "%q %s", b.Name, b.Hold)
+		}
+	}
+	return nil
+}
+
+// excludeHeld returns the backups without the ones on hold and the
+// held ones. The increments are restored from the base of the chain, so
+// the backups the held increments are based on are kept as well.
+func excludeHeld(bcps []BackupMeta) ([]BackupMeta, []BackupMeta) {
+	keep := make(map[string]bool)
+	for i := range bcps {
+		if bcps[i].Hold != nil {
+			keep[bcps[i].Name] = true
+		}
+	}
+	if len(keep) == 0 {
+		return bcps, nil
+	}
+
+	// the increments follow their bases in bcps
+	for i := len(bcps) - 1; i >= 0; i-- {
+		if b := &bcps[i]; keep[b.Name] && b.SrcBackup != "" {
+			keep[b.SrcBackup] = true
+		}
+	}
+
+	rv := make([]BackupMeta, 0, len(bcps))
+	var held []BackupMeta
+	for i := range bcps {
+		switch {
+		case bcps[i].Hold != nil:
+			held = append(held, bcps[i])
+		case !keep[bcps[i].Name]:
+			rv = append(rv, bcps[i])
+		}
+	}
+
+	return rv, held
+}
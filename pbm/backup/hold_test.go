@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestExcludeHeld(t *testing.T) {
+	hold := &BackupHold{By: "admin@admin", At: 1760000000}
+	bcps := []BackupMeta{
+		{Name: "logical", Type: defs.LogicalBackup},
+		{Name: "physical", Type: defs.PhysicalBackup, Hold: hold},
+		{Name: "base", Type: defs.IncrementalBackup},
+		{Name: "inc1", Type: defs.IncrementalBackup, SrcBackup: "base"},
+		{Name: "inc2", Type: defs.IncrementalBackup, SrcBackup: "inc1", Hold: hold},
+		{Name: "inc3", Type: defs.IncrementalBackup, SrcBackup: "inc2"},
+	}
+
+	rv, held := excludeHeld(bcps)
+	if got, want := names(rv), []string{"logical", "inc3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deleted: want %v, got %v", want, got)
+	}
+	if got, want := names(held), []string{"physical", "inc2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("held: want %v, got %v", want, got)
+	}
+
+	rv, held = excludeHeld(bcps[:1])
+	if len(rv) != 1 || len(held) != 0 {
+		t.Errorf("no holds: got %v, held %v", names(rv), names(held))
+	}
+}
+
+func TestCheckHold(t *testing.T) {
+	free := &BackupMeta{Name: "free"}
+	held := &BackupMeta{Name: "held", Hold: &BackupHold{By: "admin@admin", At: 1760000000}}
+
+	if err := checkHold(free); err != nil {
+		t.Errorf("free: unexpected %v", err)
+	}
+	if err := checkHold(free, held); !errors.Is(err, ErrBackupHeld) {
+		t.Errorf("held: want ErrBackupHeld, got %v", err)
+	}
+}
+
+func names(bcps []BackupMeta) []string {
+	rv := []string{}
+	for i := range bcps {
+		rv = append(rv, bcps[i].Name)
+	}
+	return rv
+}
@@ -0,0 +1,503 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mtArchive "github.com/mongodb/mongo-tools/common/archive"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+// ImportOptions define backups to register from the storage folder
+type ImportOptions struct {
+	// Profile is the config profile of the storage. Empty is the main storage.
+	Profile string
+	// Prefix is the folder of the storage with the backups
+	Prefix string
+	// Backup is the only backup to import. All backups of the folder
+	// are imported if empty.
+	Backup string
+	// Cluster is the identity of the source cluster.
+	// The replset names of the backup are used if empty.
+	Cluster string
+	// Descriptor describes mongodump archives of the folder.
+	// PBM backups metadata is read from the folder if nil.
+	Descriptor *MongodumpDescriptor
+	// Verify makes import read the data files to validate their checksums
+	Verify bool
+}
+
+// MongodumpDescriptor describes mongodump archives (`mongodump --archive`)
+// made without PBM. Archive paths are relative to the import folder.
+type MongodumpDescriptor struct {
+	// Name is the name of the backup. The Time in RFC3339 format if empty.
+	Name         string                   `json:"name,omitempty" yaml:"name,omitempty"`
+	MongoVersion string                   `json:"mongodb_version" yaml:"mongodb_version"`
+	FCV          string                   `json:"fcv,omitempty" yaml:"fcv,omitempty"`
+	Compression  compress.CompressionType `json:"compression,omitempty" yaml:"compression,omitempty"`
+	// Time is when the dump was made. The data is restored as of this time.
+	Time     time.Time          `json:"time" yaml:"time"`
+	Replsets []MongodumpReplset `json:"replsets" yaml:"replsets"`
+}
+
+type MongodumpReplset struct {
+	Name    string `json:"name" yaml:"name"`
+	Archive string `json:"archive" yaml:"archive"`
+	// SHA256 is the checksum of the archive file. Not checked if empty.
+	SHA256    string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	ConfigSvr bool   `json:"configsvr,omitempty" yaml:"configsvr,omitempty"`
+}
+
+// BackupMeta returns the metadata of the mongodump backup
+func (d *MongodumpDescriptor) BackupMeta() (*BackupMeta, error) {
+	if d.Time.IsZero() {
+		return nil, errors.New("time is not set")
+	}
+	if d.MongoVersion == "" {
+		return nil, errors.New("mongodb_version is not set")
+	}
+	// empty compression is detected from the archives (see checkArchives)
+	if d.Compression != "" && !compress.IsValidCompressionType(string(d.Compression)) {
+		return nil, errors.Errorf("invalid compression %q", d.Compression)
+	}
+	if len(d.Replsets) == 0 {
+		return nil, errors.New("no replsets")
+	}
+
+	name := d.Name
+	if name == "" {
+		name = d.Time.UTC().Format(time.RFC3339)
+	}
+	ts := primitive.Timestamp{T: uint32(d.Time.Unix())}
+	bcp := &BackupMeta{
+		Type:             defs.LogicalBackup,
+		Name:             name,
+		Compression:      d.Compression,
+		MongoVersion:     d.MongoVersion,
+		FCV:              d.FCV,
+		StartTS:          d.Time.Unix(),
+		LastTransitionTS: d.Time.Unix(),
+		FirstWriteTS:     ts,
+		LastWriteTS:      ts,
+		Status:           defs.StatusDone,
+		// the PBM registering the archives. The replsets data is read as
+		// single archives by the provenance (see BackupMeta.IsMongodump).
+		PBMVersion: version.Current().Version,
+		Provenance: &Provenance{
+			Source: ProvenanceImport,
+			Format: ImportFormatMongodump,
+		},
+	}
+
+	for _, rs := range d.Replsets {
+		if rs.Name == "" || rs.Archive == "" {
+			return nil, errors.New("replset name and archive should be set")
+		}
+		if bcp.RS(rs.Name) != nil {
+			return nil, errors.Errorf("duplicated replset %q", rs.Name)
+		}
+
+		bcp.Replsets = append(bcp.Replsets, BackupReplset{
+			Name:         rs.Name,
+			DumpName:     rs.Archive,
+			Status:       defs.StatusDone,
+			FirstWriteTS: ts,
+			LastWriteTS:  ts,
+			IsConfigSvr:  &rs.ConfigSvr,
+		})
+	}
+
+	return bcp, nil
+}
+
+// ImportBackups registers backups of the storage folder in the backups
+// collection. The data files of each backup are checked before.
+// Backups that fail the checks are not registered, their errors are
+// returned joined along with the imported backups.
+func ImportBackups(
+	ctx context.Context,
+	conn connect.Client,
+	o *ImportOptions,
+	node string,
+	l log.LogEvent,
+) ([]*BackupMeta, error) {
+	if strings.Trim(o.Prefix, "/") == "" {
+		return nil, errors.New("prefix is not set. Use resync for the storage root")
+	}
+
+	cfg, err := profileStorageConf(ctx, conn, o.Profile)
+	if err != nil {
+		return nil, err
+	}
+	store := Storage{
+		Name:        o.Profile,
+		IsProfile:   o.Profile != "",
+		StorageConf: *cfg.WithPrefix(o.Prefix),
+	}
+	stg, err := util.StorageFromConfig(&store.StorageConf, node, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+
+	var bcps []*BackupMeta
+	format := ImportFormatPBM
+	if o.Descriptor != nil {
+		format = ImportFormatMongodump
+		bcp, err := o.Descriptor.BackupMeta()
+		if err != nil {
+			return nil, errors.Wrap(err, "descriptor")
+		}
+		if o.Backup != "" && o.Backup != bcp.Name {
+			return nil, errors.Errorf("descriptor is for backup %q", bcp.Name)
+		}
+		err = checkArchives(stg, bcp, o.Descriptor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backup %q", bcp.Name)
+		}
+		bcps = append(bcps, bcp)
+	} else {
+		bcps, err = readImportMeta(stg, o.Backup)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(bcps) == 0 {
+		return nil, errors.Errorf("no backups in %q", o.Prefix)
+	}
+
+	var imported []*BackupMeta
+	var errs []error
+	for _, bcp := range bcps {
+		err := importBackup(ctx, conn, stg, bcp, o, l, &Provenance{
+			Source:  ProvenanceImport,
+			Node:    node,
+			Time:    time.Now().Unix(),
+			Format:  format,
+			Prefix:  o.Prefix,
+			Cluster: o.Cluster,
+		}, store)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "backup %q", bcp.Name))
+			continue
+		}
+
+		l.Info("backup %q imported from %q", bcp.Name, o.Prefix)
+		imported = append(imported, bcp)
+	}
+
+	return imported, errors.Join(errs...)
+}
+
+// readImportMeta reads metadata of backups in the root of stg
+func readImportMeta(stg storage.Storage, name string) ([]*BackupMeta, error) {
+	if name != "" {
+		bcp, err := ReadMetadata(stg, name+defs.MetadataFileSuffix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read metadata of %q", name)
+		}
+		return []*BackupMeta{bcp}, nil
+	}
+
+	files, err := stg.List("", defs.MetadataFileSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "list metadata files")
+	}
+
+	var rv []*BackupMeta
+	for _, f := range files {
+		if strings.Contains(f.Name, "/") {
+			continue
+		}
+
+		bcp, err := ReadMetadata(stg, f.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %q", f.Name)
+		}
+		rv = append(rv, bcp)
+	}
+
+	return rv, nil
+}
+
+// checkArchives checks the mongodump archives exist and match checksums
+// of the descriptor. It sets the backup size and, if the descriptor has
+// none, the compression detected by the magic bytes of the archives.
+func checkArchives(stg storage.Storage, bcp *BackupMeta, d *MongodumpDescriptor) error {
+	detect := bcp.Compression == ""
+	for _, rs := range d.Replsets {
+		f, err := stg.FileStat(rs.Archive)
+		if err != nil {
+			return errors.Wrapf(err, "archive %q", rs.Archive)
+		}
+		bcp.Size += f.Size
+
+		if detect {
+			c, err := detectCompression(stg, rs.Archive)
+			if err != nil {
+				return errors.Wrapf(err, "archive %q", rs.Archive)
+			}
+			if bcp.Compression != "" && bcp.Compression != c {
+				return errors.Errorf("archive %q: compression %s, other archives: %s",
+					rs.Archive, c, bcp.Compression)
+			}
+			bcp.Compression = c
+		}
+
+		if rs.SHA256 == "" {
+			continue
+		}
+		sum, err := checksum(stg, rs.Archive)
+		if err != nil {
+			return errors.Wrapf(err, "checksum of %q", rs.Archive)
+		}
+		if !strings.EqualFold(sum, rs.SHA256) {
+			return errors.Errorf("archive %q: checksum mismatch: got %s, expected %s",
+				rs.Archive, sum, rs.SHA256)
+		}
+	}
+
+	return nil
+}
+
+func detectCompression(stg storage.Storage, name string) (compress.CompressionType, error) {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return "", errors.Wrap(err, "open")
+	}
+	defer r.Close()
+
+	c, _, err := compress.Detect(r)
+	return c, err
+}
+
+func importBackup(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	bcp *BackupMeta,
+	o *ImportOptions,
+	l log.LogEvent,
+	p *Provenance,
+	store Storage,
+) error {
+	if bcp.Status != defs.StatusDone {
+		return errors.Errorf("backup is not done: %s", bcp.Status)
+	}
+	for _, rs := range bcp.Replsets {
+		if rs.Store != nil {
+			return errors.Errorf("replset %q data is on %q storage", rs.Name, rs.Store.Name)
+		}
+	}
+
+	_, err := NewDBManager(conn).GetBackupByName(ctx, bcp.Name)
+	if err == nil {
+		return errors.New("backup with the name already exists")
+	}
+	if !errors.Is(err, errors.ErrNotFound) {
+		return errors.Wrap(err, "get backup")
+	}
+
+	if p.Cluster == "" {
+		p.Cluster = bcp.SourceCluster()
+	}
+	// the layout of the data files depends on the provenance
+	bcp.Provenance = p
+	// the storage conf of the source cluster is replaced by the own one
+	bcp.Store = store
+
+	// the files are copied to the storage by the user,
+	// so they have no integrity markers
+	if err := checkBackupDataFiles(ctx, stg, bcp, false); err != nil {
+		return errors.Wrap(err, "check data files")
+	}
+	if o.Verify {
+		unverified, err := VerifyBackupData(ctx, stg, bcp)
+		if err != nil {
+			return errors.Wrap(err, "verify data")
+		}
+		if len(unverified) != 0 {
+			l.Warning("backup %q: no checksums to verify %d files: %s",
+				bcp.Name, len(unverified), strings.Join(unverified, ", "))
+		}
+	}
+
+	return saveBackupMeta(ctx, conn, bcp)
+}
+
+// VerifyBackupData reads the data files of the backup to validate them.
+// The files with the checksums recorded by the backup are checked against
+// them. The rest of the compressed files are read through the decompression,
+// which validates the checksums of the compression streams, and the
+// uncompressed data files of logical backups are parsed as BSON.
+// It returns the files that have nothing to be validated by: uncompressed
+// files of physical backups without the recorded checksums. They are
+// only read.
+func VerifyBackupData(ctx context.Context, stg storage.Storage, bcp *BackupMeta) ([]string, error) {
+	sums := make(map[string]string)
+	for i := range bcp.Replsets {
+		rs, err := ReadChecksums(stg, &bcp.Replsets[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "read checksums of %q", bcp.Replsets[i].Name)
+		}
+		for name, sum := range rs {
+			sums[name] = sum
+		}
+	}
+
+	files := make(map[string]compress.CompressionType)
+	if bcp.Type == defs.LogicalBackup && bcp.IsLegacyArchive() {
+		for _, rs := range bcp.Replsets {
+			files[rs.DumpName] = bcp.Compression
+			if rs.OplogName != "" {
+				files[rs.OplogName] = bcp.Compression
+			}
+		}
+	} else {
+		list, err := stg.List(bcp.Name, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "list files")
+		}
+		for _, f := range list {
+			c := compress.FileCompression(strings.TrimPrefix(path.Ext(f.Name), "."))
+			files[path.Join(bcp.Name, f.Name)] = c
+		}
+	}
+
+	var mu sync.Mutex
+	unverified := []string{}
+	eg := util.NewErrorGroup(runtime.NumCPU())
+	for name, c := range files {
+		eg.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if sum, ok := sums[name]; ok {
+				return storage.VerifyChecksum(stg, name, sum)
+			}
+
+			verified, err := verifyFile(stg, name, c, bcp.Type == defs.LogicalBackup)
+			if err != nil {
+				return errors.Wrap(err, name)
+			}
+			if !verified {
+				mu.Lock()
+				unverified = append(unverified, name)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	if err := errors.Join(eg.Wait()...); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(unverified)
+	return unverified, nil
+}
+
+// verifyFile reads the file and validates its data: the compression
+// stream and, for logical backups, the BSON documents (JSON for the
+// metadata files). It returns false if the data has nothing to be
+// validated by (uncompressed physical backup files).
+func verifyFile(stg storage.Storage, name string, c compress.CompressionType, logical bool) (bool, error) {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return false, errors.Wrap(err, "open")
+	}
+	defer r.Close()
+
+	dr, err := compress.Decompress(r, c, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "decompress")
+	}
+	defer dr.Close()
+
+	base := strings.TrimSuffix(name, c.Suffix())
+	switch {
+	case logical && path.Ext(base) == ".json":
+		data, err := io.ReadAll(dr)
+		if err != nil {
+			return false, errors.Wrap(err, "read")
+		}
+		if !json.Valid(data) {
+			return false, errors.New("invalid json")
+		}
+		return true, nil
+	case logical:
+		return true, verifyBSONStream(dr)
+	}
+
+	_, err = io.Copy(io.Discard, dr)
+	if err != nil {
+		return false, errors.Wrap(err, "read")
+	}
+	return c != compress.CompressionTypeNone, nil
+}
+
+// maxBSONDocSize is the limit of the BSON document in the backup files.
+// The oplog entries and mongodump archive headers may exceed the 16MB
+// of the user documents.
+const maxBSONDocSize = 64 << 20
+
+// verifyBSONStream validates the BSON documents of the collection or oplog
+// file of the logical backup. The mongodump archives have the magic number
+// ahead and the terminators between their sections.
+func verifyBSONStream(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(4); err == nil && binary.LittleEndian.Uint32(b) == mtArchive.MagicNumber {
+		_, _ = br.Discard(4)
+	}
+
+	var size [4]byte
+	for n := 0; ; n++ {
+		_, err := io.ReadFull(br, size[:])
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "document %d: read size", n)
+		}
+
+		l := binary.LittleEndian.Uint32(size[:])
+		if l == archiveTerminator {
+			continue
+		}
+		if l < 5 || l > maxBSONDocSize {
+			return errors.Errorf("document %d: invalid size %d", n, l)
+		}
+
+		doc := make([]byte, l)
+		copy(doc, size[:])
+		_, err = io.ReadFull(br, doc[4:])
+		if err != nil {
+			return errors.Wrapf(err, "document %d: read", n)
+		}
+		if err := bson.Raw(doc).Validate(); err != nil {
+			return errors.Wrapf(err, "document %d", n)
+		}
+	}
+}
+
+// archiveTerminator ends the sections of the mongodump archive
+const archiveTerminator = 0xFFFFFFFF
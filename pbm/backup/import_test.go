@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+func TestMongodumpDescriptor(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	d := &MongodumpDescriptor{
+		MongoVersion: "7.0.5",
+		Compression:  compress.CompressionTypeGZIP,
+		Time:         ts,
+		Replsets: []MongodumpReplset{
+			{Name: "cfg", Archive: "cfg.archive.gz", ConfigSvr: true},
+			{Name: "rs0", Archive: "rs0.archive.gz"},
+		},
+	}
+	bcp, err := d.BackupMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcp.Name != "2024-05-01T10:00:00Z" || bcp.Type != defs.LogicalBackup || bcp.Status != defs.StatusDone {
+		t.Errorf("got %s %s %s", bcp.Name, bcp.Type, bcp.Status)
+	}
+	if !bcp.IsMongodump() || !bcp.IsLegacyArchive() || !bcp.IsLegacyBackupOplog() {
+		t.Errorf("provenance %+v should be restored as the legacy archive", bcp.Provenance)
+	}
+	if bcp.PBMVersion != version.Current().Version {
+		t.Errorf("pbm version: got %s", bcp.PBMVersion)
+	}
+	if bcp.LastWriteTS.T != uint32(ts.Unix()) {
+		t.Errorf("last write: got %v", bcp.LastWriteTS)
+	}
+	if rs := bcp.RS("cfg"); rs == nil || !*rs.IsConfigSvr || rs.DumpName != "cfg.archive.gz" || rs.OplogName != "" {
+		t.Errorf("cfg: got %+v", rs)
+	}
+	if rs := bcp.RS("rs0"); rs == nil || *rs.IsConfigSvr {
+		t.Errorf("rs0: got %+v", rs)
+	}
+
+	invalid := map[string]func(d *MongodumpDescriptor){
+		"no time":           func(d *MongodumpDescriptor) { d.Time = time.Time{} },
+		"no version":        func(d *MongodumpDescriptor) { d.MongoVersion = "" },
+		"no replsets":       func(d *MongodumpDescriptor) { d.Replsets = nil },
+		"no archive":        func(d *MongodumpDescriptor) { d.Replsets[1].Archive = "" },
+		"duplicated rs":     func(d *MongodumpDescriptor) { d.Replsets[1].Name = "cfg" },
+		"wrong compression": func(d *MongodumpDescriptor) { d.Compression = "rar" },
+	}
+	for name, f := range invalid {
+		t.Run(name, func(t *testing.T) {
+			d := *d
+			d.Replsets = append([]MongodumpReplset{}, d.Replsets...)
+			f(&d)
+			if _, err := d.BackupMeta(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestVerifyBackupData(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := bson.Marshal(bson.D{{"_id", 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := compress.Compress(&buf, compress.CompressionTypeGZIP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat(doc, 1<<10))
+	w.Close()
+	data := buf.Bytes()
+
+	save := func(name string, b []byte) {
+		t.Helper()
+		if err := stg.Save(name, bytes.NewReader(b), int64(len(b))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("bcp/rs0/db.c.gz", data)
+	save("bcp/rs0/metadata.json", []byte("{}"))
+
+	save("bcp/rs0/db.e", append(append([]byte{}, doc...), doc...))
+
+	bcp := &BackupMeta{Name: "bcp", Type: defs.LogicalBackup, PBMVersion: "2.5.0"}
+	unverified, err := VerifyBackupData(context.Background(), stg, bcp)
+	if err != nil {
+		t.Fatalf("valid data: %v", err)
+	}
+	if len(unverified) != 0 {
+		t.Errorf("unverified: %v", unverified)
+	}
+
+	broken := append([]byte{}, data...)
+	broken[len(broken)-5] ^= 0xff // the crc32 of gzip trailer
+	save("bcp/rs0/db.d.gz", broken)
+	if _, err := VerifyBackupData(context.Background(), stg, bcp); err == nil {
+		t.Error("expected error for broken file")
+	}
+	save("bcp/rs0/db.d.gz", data)
+
+	save("bcp/rs0/db.f", doc[:len(doc)-2])
+	if _, err := VerifyBackupData(context.Background(), stg, bcp); err == nil {
+		t.Error("expected error for truncated uncompressed file")
+	}
+	save("bcp/rs0/db.f", doc)
+
+	sum := sha256.Sum256([]byte("wt"))
+	sums, _ := json.Marshal(map[string]string{"bcp/rs0/collection-1.wt": hex.EncodeToString(sum[:])})
+	save("bcp/rs0/collection-1.wt", []byte("wt"))
+	save("bcp/rs0/collection-2.wt", []byte("wt"))
+	save("bcp/rs0/checksums.json", sums)
+	phys := &BackupMeta{Name: "bcp", Type: defs.PhysicalBackup, PBMVersion: "2.9.0",
+		Replsets: []BackupReplset{{Name: "rs0", ChecksumsFile: "bcp/rs0/checksums.json"}}}
+	unverified, err = VerifyBackupData(context.Background(), stg, phys)
+	if err != nil {
+		t.Fatalf("physical: %v", err)
+	}
+	if !slices.Contains(unverified, "bcp/rs0/collection-2.wt") || slices.Contains(unverified, "bcp/rs0/collection-1.wt") {
+		t.Errorf("physical unverified: %v", unverified)
+	}
+
+	save("bcp/rs0/collection-1.wt", []byte("xx"))
+	if _, err := VerifyBackupData(context.Background(), stg, phys); err == nil {
+		t.Error("expected checksum mismatch")
+	}
+}
+
+func TestCheckArchivesDetectCompression(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rs := range []string{"rs0", "rs1"} {
+		var buf bytes.Buffer
+		w, err := compress.Compress(&buf, compress.CompressionTypeLZ4, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte(rs), 1<<10))
+		w.Close()
+		if err := stg.Save(rs+".archive", &buf, int64(buf.Len())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &MongodumpDescriptor{
+		MongoVersion: "7.0.5",
+		Time:         time.Now(),
+		Replsets:     []MongodumpReplset{{Name: "rs0", Archive: "rs0.archive"}, {Name: "rs1", Archive: "rs1.archive"}},
+	}
+	bcp, err := d.BackupMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkArchives(stg, bcp, d); err != nil {
+		t.Fatal(err)
+	}
+	if bcp.Compression != compress.CompressionTypeLZ4 {
+		t.Errorf("detected %q, expected %q", bcp.Compression, compress.CompressionTypeLZ4)
+	}
+}
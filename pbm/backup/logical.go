@@ -11,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
@@ -45,12 +46,43 @@ func (b *Backup) doLogical(
 	if err != nil {
 		return errors.Wrap(err, "get namespaces size")
 	}
+	progress := &progressTracker{}
+	for _, sz := range nssSize {
+		progress.total.Add(sz)
+	}
 	if bcp.Compression == compress.CompressionTypeNone {
 		for n := range nssSize {
 			nssSize[n] *= 4
 		}
 	}
 
+	var readPref *readpref.ReadPref
+	rsMeta.DumpSource = &DumpSource{
+		Node:   inf.Me,
+		State:  "SECONDARY",
+		Hidden: inf.Hidden,
+		Tags:   inf.Tags,
+	}
+	if inf.IsPrimary {
+		rsMeta.DumpSource.State = "PRIMARY"
+	}
+	if rp := b.config.BackupReadPrefRS(rsMeta.Name); rp != nil {
+		readPref, err = rp.ReadPref()
+		if err != nil {
+			return errors.Wrap(err, "read preference")
+		}
+		rsMeta.DumpSource.ReadPref = readPref.String()
+		l.Info("dump from %s %s selected by read preference %s", rsMeta.DumpSource.State, inf.Me, readPref)
+	}
+
+	if b.consistencyCheckOn(inf, bcp) {
+		rsMeta.NSSnapshot, err = nsSnapshot(ctx, b.nodeConn)
+		if err != nil {
+			l.Warning("consistency check: list namespaces: %v", err)
+			rsMeta.NSSnapshot = nil
+		}
+	}
+
 	rsMeta.Status = defs.StatusRunning
 	rsMeta.OplogName = path.Join(bcp.Name, rsMeta.Name, "oplog")
 	rsMeta.DumpName = path.Join(bcp.Name, rsMeta.Name, archive.MetaFile)
@@ -59,7 +91,7 @@ func (b *Backup) doLogical(
 		return errors.Wrap(err, "add shard's metadata")
 	}
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		err := b.reconcileStatus(ctx,
 			bcp.Name, opid.String(), defs.StatusRunning, util.Ref(b.timeouts.StartingStatus()))
 		if err != nil {
@@ -83,11 +115,16 @@ func (b *Backup) doLogical(
 		}
 	}
 
+	reconnect, closeReconnect := b.oplogReconnect(inf.Hosts)
+	defer closeReconnect()
+
 	stopOplogSlicer := startOplogSlicer(ctx,
 		b.nodeConn,
-		b.leadConn.MongoOptions().WriteConcern,
+		b.leadConn.WriteConcern(),
 		b.SlicerInterval(),
 		rsMeta.FirstWriteTS,
+		b.timeouts.OplogRetryWindow(),
+		reconnect,
 		func(ctx context.Context, w io.WriterTo, from, till primitive.Timestamp) (int64, error) {
 			filename := rsMeta.OplogName + "/" + FormatChunkName(from, till, bcp.Compression)
 			return storage.Upload(ctx, w, stg, bcp.Compression, bcp.CompressionLevel, filename, -1)
@@ -149,31 +186,63 @@ func (b *Backup) doLogical(
 		}
 	}
 
+	nsStats := []NSStat{}
+	var collOpts []CollOptions
+	pool := b.compressionPool()
+	defer pool.Release()
+	stopProgress := progress.start(ctx, b.leadConn, bcp.Name, rsMeta.Name, l)
 	snapshotSize, err := snapshot.UploadDump(ctx,
 		func(newFile archive.NewWriter) error {
 			bcp, err := archive.NewBackup(ctx, archive.BackupOptions{
-				Client:        b.nodeConn,
-				NewFile:       newFile,
+				Client: b.nodeConn,
+				NewFile: func(ns string) (io.WriteCloser, error) {
+					w, err := newFile(ns)
+					if err != nil {
+						return nil, err
+					}
+					progress.setFile(ns)
+					return progress.writer(w), nil
+				},
 				NSFilter:      nsFilter,
 				DocFilter:     docFilter,
 				ParallelColls: numParallelColls,
+				ReadPref:      readPref,
 			})
 			if err != nil {
 				return errors.Wrap(err, "new backup")
 			}
 
-			return bcp.Run(ctx)
+			err = bcp.Run(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, ns := range bcp.Namespaces() {
+				if ns.IsCollection() {
+					nsStats = append(nsStats, NSStat{NS: ns.NS(), Docs: ns.Count, Size: ns.Size})
+				}
+				if ns.IsCollection() && len(ns.Options) != 0 {
+					opts, err := bson.MarshalExtJSON(ns.Options, true, false)
+					if err != nil {
+						return errors.Wrapf(err, "marshal %s options", ns.NS())
+					}
+					collOpts = append(collOpts, CollOptions{NS: ns.NS(), Options: string(opts)})
+				}
+			}
+			return nil
 		},
 		func(ns, ext string, r io.Reader) error {
-			stg, err := util.StorageFromConfig(&b.config.Storage, b.brief.Me, l)
+			stg, err := util.StorageFromConfig(b.rsStorageConf(rsMeta), b.brief.Me, l)
 			if err != nil {
 				return errors.Wrap(err, "get storage")
 			}
 			filepath := path.Join(bcp.Name, rsMeta.Name, ns+ext)
 			return stg.Save(filepath, r, nssSize[ns])
 		},
+		pool,
 		bcp.Compression,
 		bcp.CompressionLevel)
+	stopProgress()
 	if err != nil {
 		return errors.Wrap(err, "dump")
 	}
@@ -183,6 +252,18 @@ func (b *Backup) doLogical(
 		return errors.Wrap(err, "generate archive meta v1")
 	}
 
+	statsFile, err := saveNSStats(stg, bcp.Name, rsMeta.Name, nsStats)
+	if err == nil {
+		err = SetRSNSStatsFile(ctx, b.leadConn, bcp.Name, rsMeta.Name, statsFile)
+	}
+	if err != nil {
+		l.Warning("set namespaces stats: %v", err)
+	}
+	err = SetRSCollOptions(ctx, b.leadConn, bcp.Name, rsMeta.Name, collOpts)
+	if err != nil {
+		l.Warning("set collection options: %v", err)
+	}
+
 	l.Info("dump finished, waiting for the oplog")
 
 	err = ChangeRSState(b.leadConn, bcp.Name, rsMeta.Name, defs.StatusDumpDone, "")
@@ -190,7 +271,7 @@ func (b *Backup) doLogical(
 		return errors.Wrap(err, "set shard's StatusDumpDone")
 	}
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		err := b.reconcileStatus(ctx, bcp.Name, opid.String(), defs.StatusDumpDone, nil)
 		if err != nil {
 			return errors.Wrap(err, "check cluster for dump done")
@@ -202,6 +283,13 @@ func (b *Backup) doLogical(
 		}
 	}
 
+	if bcp.ClusterTime != nil {
+		err = b.waitForClusterTime(ctx, bcp.ClusterTime, l)
+		if err != nil {
+			return errors.Wrap(err, "wait for cluster time")
+		}
+	}
+
 	lastSavedTS, oplogSize, err := stopOplogSlicer()
 	if err != nil {
 		return errors.Wrap(err, "oplog")
@@ -212,11 +300,18 @@ func (b *Backup) doLogical(
 		return errors.Wrap(err, "set shard's last write ts")
 	}
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		err = b.setClusterLastWrite(ctx, bcp.Name)
 		if err != nil {
 			return errors.Wrap(err, "set cluster last write ts")
 		}
+
+		if bcp.ClusterTime != nil {
+			err = b.setClusterTimeReached(ctx, bcp.Name, bcp.ClusterTime.TS, l)
+			if err != nil {
+				return errors.Wrap(err, "set cluster time reached")
+			}
+		}
 	}
 
 	err = IncBackupSize(ctx, b.leadConn, bcp.Name, snapshotSize+oplogSize)
@@ -227,6 +322,61 @@ func (b *Backup) doLogical(
 	return nil
 }
 
+// waitForClusterTime holds the oplog capture until the last write of the node
+// passes the target cluster time or the timeout expires. The last chunk of
+// the oplog is taken up to the node last write once the wait is over.
+func (b *Backup) waitForClusterTime(ctx context.Context, t *ctrl.ClusterTimeTarget, l log.LogEvent) error {
+	majority, err := topo.IsWriteMajorityRequested(ctx, b.nodeConn, b.leadConn.WriteConcern())
+	if err != nil {
+		l.Warning("inspect requested majority: %v", err)
+	}
+
+	l.Info("waiting for the cluster time %d,%d (timeout %ds)", t.TS.T, t.TS.I, t.TimeoutSec)
+	deadline := time.Now().Add(time.Duration(t.TimeoutSec) * time.Second)
+	for {
+		lw, err := topo.GetLastWrite(ctx, b.nodeConn, majority)
+		if err != nil {
+			l.Warning("get last write: %v", err)
+		} else if !lw.Before(t.TS) {
+			l.Info("cluster time %d,%d is reached", t.TS.T, t.TS.I)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			l.Warning("cluster time %d,%d isn't reached within %ds, last write %d,%d",
+				t.TS.T, t.TS.I, t.TimeoutSec, lw.T, lw.I)
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setClusterTimeReached records whether the backup covers the target
+// cluster time. The cluster last write has to be set before.
+func (b *Backup) setClusterTimeReached(
+	ctx context.Context,
+	bcpName string,
+	target primitive.Timestamp,
+	l log.LogEvent,
+) error {
+	bcp, err := NewDBManager(b.leadConn).GetBackupByName(ctx, bcpName)
+	if err != nil {
+		return errors.Wrap(err, "get backup metadata")
+	}
+
+	reached := !bcp.LastWriteTS.Before(target)
+	if !reached {
+		l.Warning("the backup is consistent at %d,%d before the requested cluster time %d,%d",
+			bcp.LastWriteTS.T, bcp.LastWriteTS.I, target.T, target.I)
+	}
+	return SetClusterTimeReached(ctx, b.leadConn, bcpName, reached)
+}
+
 func dropTMPcoll(ctx context.Context, uri string) error {
 	m, err := connect.MongoConnect(ctx, uri)
 	if err != nil {
@@ -0,0 +1,510 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// migrateParallel is the number of files copied at once
+const migrateParallel = 4
+
+// MigrateOptions define artifacts to move between the storages.
+// From and To are config profile names. Empty name is the main storage.
+type MigrateOptions struct {
+	From string
+	To   string
+	// Backup is the only backup to migrate. If empty, all done backups
+	// and PITR chunks on the From storage are migrated.
+	Backup       string
+	DeleteSource bool
+}
+
+// MigrationPlan is the list of artifacts to move between the storages
+type MigrationPlan struct {
+	Backups []BackupMigration `json:"backups"`
+	// Chunks are PITR chunks on the source storage
+	Chunks     []oplog.OplogChunk `json:"-"`
+	ChunksSize int64              `json:"chunks_size"`
+}
+
+// BackupMigration is the backup files on the source storage
+type BackupMigration struct {
+	Name string `json:"name"`
+	// Objects is the number of files including the metadata file
+	Objects int   `json:"objects"`
+	Size    int64 `json:"size"`
+
+	files []storage.FileInfo
+	// flat are the files of the replsets out of the backup dir
+	// (the layout of the backups before v2.0)
+	flat    []string
+	srcConf *config.StorageConf
+}
+
+// Objects returns the total number of files to copy
+func (p *MigrationPlan) Objects() int {
+	n := len(p.Chunks)
+	for i := range p.Backups {
+		n += p.Backups[i].Objects
+	}
+	return n
+}
+
+// Size returns the total size of files to copy
+func (p *MigrationPlan) Size() int64 {
+	n := p.ChunksSize
+	for i := range p.Backups {
+		n += p.Backups[i].Size
+	}
+	return n
+}
+
+// isStorage returns true if st is the storage of the profile
+func isStorage(st *Storage, profile string) bool {
+	return st != nil && st.Name == profile && st.IsProfile == (profile != "")
+}
+
+// fromStorage returns the first storage of the backup which is
+// the storage of the profile. Nil if the backup isn't on it.
+func (b *BackupMeta) fromStorage(profile string) *Storage {
+	for _, st := range b.Storages() {
+		if isStorage(st, profile) {
+			return st
+		}
+	}
+	return nil
+}
+
+// moveStorage replaces references to the from storage with the to
+// storage. Replsets on the backup storage have no storage reference.
+// It returns false if the backup isn't on the from storage.
+func (b *BackupMeta) moveStorage(from string, to *Storage) bool {
+	moved := false
+	if isStorage(&b.Store, from) {
+		b.Store = *to
+		moved = true
+	}
+	for i := range b.Replsets {
+		rs := &b.Replsets[i]
+		if !isStorage(rs.Store, from) {
+			continue
+		}
+
+		moved = true
+		if isStorage(&b.Store, to.Name) {
+			rs.Store = nil
+			continue
+		}
+		st := *to
+		rs.Store = &st
+	}
+	return moved
+}
+
+// PlanMigration lists artifacts on the From storage to move.
+// Only done backups are migrated.
+func PlanMigration(
+	ctx context.Context,
+	conn connect.Client,
+	o *MigrateOptions,
+	node string,
+	l log.LogEvent,
+) (*MigrationPlan, error) {
+	if o.From == o.To {
+		return nil, errors.New("source and destination storages are the same")
+	}
+	dst, err := profileStorageConf(ctx, conn, o.To)
+	if err != nil {
+		return nil, err
+	}
+	if dst.ReadOnly {
+		return nil, errors.Errorf("destination storage %q is read-only", o.To)
+	}
+	if o.DeleteSource {
+		src, err := profileStorageConf(ctx, conn, o.From)
+		if err != nil {
+			return nil, err
+		}
+		if src.ReadOnly {
+			return nil, errors.Errorf("source storage %q is read-only, files cannot be deleted", o.From)
+		}
+	}
+
+	var bcps []BackupMeta
+	if o.Backup != "" {
+		bcp, err := NewDBManager(conn).GetBackupByName(ctx, o.Backup)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get backup %q", o.Backup)
+		}
+		if bcp.Status != defs.StatusDone {
+			return nil, errors.Errorf("backup %q is not done, status: %s", o.Backup, bcp.Status)
+		}
+		if bcp.fromStorage(o.From) == nil {
+			return nil, errors.Errorf("backup %q is not on the source storage", o.Backup)
+		}
+		bcps = append(bcps, *bcp)
+	} else {
+		all, err := NewDBManager(conn).GetAllBackups(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backups")
+		}
+		for i := range all {
+			if all[i].Status == defs.StatusDone && all[i].fromStorage(o.From) != nil {
+				bcps = append(bcps, all[i])
+			}
+		}
+	}
+
+	plan := &MigrationPlan{Backups: []BackupMigration{}, Chunks: []oplog.OplogChunk{}}
+	for i := range bcps {
+		bm, err := planBackupMigration(&bcps[i], o.From, node, l)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backup %q", bcps[i].Name)
+		}
+		plan.Backups = append(plan.Backups, *bm)
+	}
+
+	if o.Backup != "" {
+		return plan, nil
+	}
+
+	chunks, err := oplog.PITRGetChunksSlice(ctx, conn, "", primitive.Timestamp{}, primitive.Timestamp{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks")
+	}
+	for i := range chunks {
+		if chunks[i].Storage == o.From {
+			plan.Chunks = append(plan.Chunks, chunks[i])
+			plan.ChunksSize += chunks[i].Size
+		}
+	}
+
+	return plan, nil
+}
+
+func planBackupMigration(bcp *BackupMeta, from, node string, l log.LogEvent) (*BackupMigration, error) {
+	st := bcp.fromStorage(from)
+	stg, err := util.StorageFromConfig(&st.StorageConf, node, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get source storage")
+	}
+
+	files, err := stg.List(bcp.Name, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "list files")
+	}
+
+	bm := &BackupMigration{Name: bcp.Name, srcConf: &st.StorageConf}
+	for _, f := range files {
+		f.Name = path.Join(bcp.Name, f.Name)
+		bm.files = append(bm.files, f)
+		bm.Objects++
+		bm.Size += f.Size
+	}
+
+	for _, name := range flatFiles(bcp, from) {
+		f, err := stg.FileStat(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat %s", name)
+		}
+		f.Name = name
+		bm.files = append(bm.files, f)
+		bm.flat = append(bm.flat, name)
+		bm.Objects++
+		bm.Size += f.Size
+	}
+
+	// the metadata file is rewritten on the backup storage
+	bm.Objects++
+	if isStorage(&bcp.Store, from) {
+		if f, err := stg.FileStat(bcp.Name + defs.MetadataFileSuffix); err == nil {
+			bm.Size += f.Size
+		}
+	}
+
+	return bm, nil
+}
+
+// flatFiles returns the files of the replsets on the from storage which
+// are out of the backup dir: the dump and oplog of the legacy backups
+// are in the root of the storage.
+func flatFiles(bcp *BackupMeta, from string) []string {
+	var rv []string
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		st := rs.Store
+		if st == nil {
+			st = &bcp.Store
+		}
+		if !isStorage(st, from) {
+			continue
+		}
+
+		for _, name := range []string{rs.DumpName, rs.OplogName} {
+			if name != "" && !strings.HasPrefix(name, bcp.Name+"/") {
+				rv = append(rv, name)
+			}
+		}
+	}
+	return rv
+}
+
+// Migrate copies artifacts of the plan to the To storage.
+//
+// The backup metadata is switched to the new storage only after all files
+// of the backup are verified on it. Until then restores use the source
+// storage. Files already copied (e.g. by an interrupted migration)
+// are verified and not copied again.
+func Migrate(
+	ctx context.Context,
+	conn connect.Client,
+	plan *MigrationPlan,
+	o *MigrateOptions,
+	node string,
+	l log.LogEvent,
+) error {
+	dstConf, err := profileStorageConf(ctx, conn, o.To)
+	if err != nil {
+		return err
+	}
+	dst, err := util.StorageFromConfig(dstConf, node, l)
+	if err != nil {
+		return errors.Wrap(err, "get destination storage")
+	}
+	to := &Storage{Name: o.To, IsProfile: o.To != "", StorageConf: *dstConf}
+
+	for i := range plan.Backups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		bm := &plan.Backups[i]
+		l.Info("backup %s: copy %d files (%d bytes)", bm.Name, bm.Objects, bm.Size)
+		if err := migrateBackup(ctx, conn, bm, dst, to, o, node, l); err != nil {
+			return errors.Wrapf(err, "backup %q", bm.Name)
+		}
+		l.Info("backup %s: moved to %q storage", bm.Name, o.To)
+	}
+
+	if len(plan.Chunks) == 0 {
+		return nil
+	}
+
+	srcConf, err := profileStorageConf(ctx, conn, o.From)
+	if err != nil {
+		return err
+	}
+	src, err := util.StorageFromConfig(srcConf, node, l)
+	if err != nil {
+		return errors.Wrap(err, "get chunks storage")
+	}
+	cp := newFileCopier(src, dst, srcConf, dstConf)
+
+	l.Info("copy %d PITR chunks (%d bytes)", len(plan.Chunks), plan.ChunksSize)
+	for i := range plan.Chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		c := &plan.Chunks[i]
+		if err := cp.copy(c.FName, c.Size); err != nil {
+			return errors.Wrapf(err, "chunk %s", c.FName)
+		}
+		if err := oplog.PITRSetChunkStorage(ctx, conn, c, o.To); err != nil {
+			return errors.Wrapf(err, "update chunk %s", c.FName)
+		}
+		if o.DeleteSource {
+			if err := src.Delete(c.FName); err != nil && !errors.Is(err, storage.ErrNotExist) {
+				l.Warning("delete source chunk %s: %v", c.FName, err)
+			}
+		}
+	}
+	l.Info("PITR chunks moved to %q storage", o.To)
+
+	return nil
+}
+
+func profileStorageConf(ctx context.Context, conn connect.Client, profile string) (*config.StorageConf, error) {
+	if profile == "" {
+		cfg, err := config.GetConfig(ctx, conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		return &cfg.Storage, nil
+	}
+
+	p, err := config.GetProfile(ctx, conn, profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get profile %q", profile)
+	}
+	return &p.Storage, nil
+}
+
+func migrateBackup(
+	ctx context.Context,
+	conn connect.Client,
+	bm *BackupMigration,
+	dst storage.Storage,
+	to *Storage,
+	o *MigrateOptions,
+	node string,
+	l log.LogEvent,
+) error {
+	src, err := util.StorageFromConfig(bm.srcConf, node, l)
+	if err != nil {
+		return errors.Wrap(err, "get source storage")
+	}
+	cp := newFileCopier(src, dst, bm.srcConf, &to.StorageConf)
+
+	eg := util.NewErrorGroup(migrateParallel)
+	for _, f := range bm.files {
+		eg.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return errors.Wrap(cp.copy(f.Name, f.Size), f.Name)
+		})
+	}
+	if errs := eg.Wait(); len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	// re-read to not overwrite changes made since the plan
+	bcp, err := NewDBManager(conn).GetBackupByName(ctx, bm.Name)
+	if err != nil {
+		return errors.Wrap(err, "get backup metadata")
+	}
+	if !bcp.moveStorage(o.From, to) {
+		return errors.New("backup is not on the source storage anymore")
+	}
+
+	mstg, err := util.StorageFromConfig(&bcp.Store.StorageConf, node, l)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+	if err := writeMeta(mstg, bcp); err != nil {
+		return errors.Wrap(err, "write metadata file")
+	}
+	if _, err := ReadMetadata(mstg, bcp.Name+defs.MetadataFileSuffix); err != nil {
+		return errors.Wrap(err, "check metadata file")
+	}
+
+	if err := SetBackupStorage(ctx, conn, bcp); err != nil {
+		return errors.Wrap(err, "update backup metadata")
+	}
+
+	if o.DeleteSource {
+		if err := DeleteBackupFiles(src, bm.Name); err != nil {
+			l.Warning("backup %s: delete source files: %v", bm.Name, err)
+		}
+		for _, name := range bm.flat {
+			if err := src.Delete(name); err != nil {
+				l.Warning("backup %s: delete source file %s: %v", bm.Name, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fileCopier copies files between the storages and verifies them
+type fileCopier struct {
+	src storage.Storage
+	dst storage.Storage
+	// serverSide is true if files can be copied byte by byte
+	// without decryption
+	serverSide bool
+}
+
+func newFileCopier(src, dst storage.Storage, srcConf, dstConf *config.StorageConf) *fileCopier {
+	return &fileCopier{
+		src:        src,
+		dst:        dst,
+		serverSide: reflect.DeepEqual(srcConf.Encryption, dstConf.Encryption),
+	}
+}
+
+// copy copies the file unless the same file is already on the destination.
+// The checksum of the copy is verified against the source file.
+func (c *fileCopier) copy(name string, size int64) error {
+	if _, err := c.dst.FileStat(name); err == nil {
+		sum, err := checksum(c.src, name)
+		if err != nil {
+			return errors.Wrap(err, "source checksum")
+		}
+		dsum, err := checksum(c.dst, name)
+		if err != nil {
+			return errors.Wrap(err, "destination checksum")
+		}
+		if sum == dsum {
+			return nil
+		}
+	} else if !errors.Is(err, storage.ErrNotExist) && !errors.Is(err, storage.ErrEmpty) {
+		return errors.Wrap(err, "destination file stat")
+	}
+
+	sum, err := c.transfer(name, size)
+	if err != nil {
+		return err
+	}
+
+	dsum, err := checksum(c.dst, name)
+	if err != nil {
+		return errors.Wrap(err, "destination checksum")
+	}
+	if sum != dsum {
+		return errors.Errorf("checksum mismatch: source %s, destination %s", sum, dsum)
+	}
+
+	return nil
+}
+
+// transfer copies the file server-side if possible. Otherwise, the file is
+// streamed through. It returns the checksum of the source file.
+func (c *fileCopier) transfer(name string, size int64) (string, error) {
+	sc, ok := storage.Unwrap(c.dst).(storage.ServerSideCopier)
+	if ok && c.serverSide && !storage.IsReadOnly(c.dst) {
+		err := sc.CopyFrom(storage.Unwrap(c.src), name, name)
+		if err == nil {
+			sum, err := checksum(c.src, name)
+			return sum, errors.Wrap(err, "source checksum")
+		}
+		if !errors.Is(err, storage.ErrCopyUnsupported) {
+			return "", errors.Wrap(err, "server-side copy")
+		}
+	}
+
+	r, err := c.src.SourceReader(name)
+	if err != nil {
+		return "", errors.Wrap(err, "open source")
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if err := c.dst.Save(name, io.TeeReader(r, h), size); err != nil {
+		return "", errors.Wrap(err, "save")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksum returns sha256 of the file content
+func checksum(stg storage.Storage, name string) (string, error) {
+	return storage.Checksum(stg, name)
+}
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestMoveStorage(t *testing.T) {
+	eu := &Storage{Name: "eu", IsProfile: true}
+	aws := &Storage{Name: "aws", IsProfile: true}
+
+	t.Run("backup storage", func(t *testing.T) {
+		bcp := &BackupMeta{
+			Store:    Storage{},
+			Replsets: []BackupReplset{{Name: "rs0"}, {Name: "rs1", Store: eu}},
+		}
+		if !bcp.moveStorage("", aws) {
+			t.Fatal("expected to move")
+		}
+		if !isStorage(&bcp.Store, "aws") {
+			t.Errorf("backup storage: got %+v", bcp.Store)
+		}
+		if bcp.Replsets[0].Store != nil || bcp.Replsets[1].Store != eu {
+			t.Errorf("replsets: got %+v, %+v", bcp.Replsets[0].Store, bcp.Replsets[1].Store)
+		}
+	})
+
+	t.Run("replset storage", func(t *testing.T) {
+		bcp := &BackupMeta{
+			Store:    Storage{},
+			Replsets: []BackupReplset{{Name: "rs0"}, {Name: "rs1", Store: eu}},
+		}
+		if !bcp.moveStorage("eu", aws) {
+			t.Fatal("expected to move")
+		}
+		if !isStorage(&bcp.Store, "") {
+			t.Errorf("backup storage: got %+v", bcp.Store)
+		}
+		if !isStorage(bcp.Replsets[1].Store, "aws") {
+			t.Errorf("rs1: got %+v", bcp.Replsets[1].Store)
+		}
+	})
+
+	t.Run("replset to the backup storage", func(t *testing.T) {
+		bcp := &BackupMeta{
+			Store:    Storage{},
+			Replsets: []BackupReplset{{Name: "rs0"}, {Name: "rs1", Store: eu}},
+		}
+		if !bcp.moveStorage("eu", &Storage{}) {
+			t.Fatal("expected to move")
+		}
+		if bcp.Replsets[1].Store != nil {
+			t.Errorf("rs1: expected the backup storage, got %+v", bcp.Replsets[1].Store)
+		}
+	})
+
+	t.Run("not on the storage", func(t *testing.T) {
+		bcp := &BackupMeta{Store: *eu, Replsets: []BackupReplset{{Name: "rs0"}}}
+		if bcp.moveStorage("", aws) {
+			t.Error("unexpected move")
+		}
+		if bcp.fromStorage("") != nil {
+			t.Error("unexpected source storage")
+		}
+	})
+}
+
+func TestFlatFiles(t *testing.T) {
+	eu := &Storage{Name: "eu", IsProfile: true}
+	bcp := &BackupMeta{
+		Name: "2020-01-01T00:00:00Z",
+		Replsets: []BackupReplset{
+			{
+				Name:      "rs0",
+				DumpName:  "2020-01-01T00:00:00Z_rs0.dump.s2",
+				OplogName: "2020-01-01T00:00:00Z_rs0.oplog.s2",
+			},
+			{
+				Name:      "rs1",
+				DumpName:  "2020-01-01T00:00:00Z/rs1/metadata.json",
+				OplogName: "2020-01-01T00:00:00Z/rs1/oplog",
+			},
+			{Name: "rs2", Store: eu, DumpName: "2020-01-01T00:00:00Z_rs2.dump.s2"},
+		},
+	}
+
+	got := flatFiles(bcp, "")
+	want := []string{"2020-01-01T00:00:00Z_rs0.dump.s2", "2020-01-01T00:00:00Z_rs0.oplog.s2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("main storage: want %v, got %v", want, got)
+	}
+	got = flatFiles(bcp, "eu")
+	want = []string{"2020-01-01T00:00:00Z_rs2.dump.s2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("eu: want %v, got %v", want, got)
+	}
+}
+
+func TestFileCopier(t *testing.T) {
+	src, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("pbm"), 1<<10)
+	if err := src.Save("bcp/rs0/data", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	cp := newFileCopier(src, dst, &config.StorageConf{}, &config.StorageConf{})
+	if err := cp.copy("bcp/rs0/data", int64(len(data))); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if got := readFile(t, dst, "bcp/rs0/data"); !bytes.Equal(got, data) {
+		t.Fatalf("copy: got %d bytes", len(got))
+	}
+
+	// resumed migration overwrites a broken copy
+	if err := dst.Save("bcp/rs0/data", bytes.NewReader(data[:10]), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.copy("bcp/rs0/data", int64(len(data))); err != nil {
+		t.Fatalf("copy over broken: %v", err)
+	}
+	if got := readFile(t, dst, "bcp/rs0/data"); !bytes.Equal(got, data) {
+		t.Fatalf("copy over broken: got %d bytes", len(got))
+	}
+
+	if err := cp.copy("bcp/rs0/missed", 0); err == nil {
+		t.Error("expected error for missed source file")
+	}
+}
+
+func readFile(t *testing.T, stg *fs.FS, name string) []byte {
+	t.Helper()
+
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeleteReadOnlyBackupFiles(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stg.Save("bcp/rs0/data", bytes.NewReader([]byte("pbm")), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	err = DeleteBackupFiles(storage.ReadOnly(stg), "bcp")
+	if !errors.Is(err, storage.ErrReadOnly) {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if got := readFile(t, stg, "bcp/rs0/data"); string(got) != "pbm" {
+		t.Errorf("file is changed: %q", got)
+	}
+}
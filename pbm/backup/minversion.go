@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+// ErrPBMTooOld is returned if the backup can't be restored by the PBM
+// version the agents run (see BackupMeta.RequiredPBM).
+var ErrPBMTooOld = errors.New("PBM is too old for the backup")
+
+// PBMTooOldError tells which PBM version the backup requires and which
+// lower versions the agents run.
+type PBMTooOldError struct {
+	Required string
+	Features []version.RestoreFeature
+	Running  []string
+}
+
+func (e PBMTooOldError) Error() string {
+	msg := "backup requires PBM >= " + e.Required
+	if len(e.Features) != 0 {
+		fs := make([]string, len(e.Features))
+		for i, f := range e.Features {
+			fs[i] = string(f)
+		}
+		msg += fmt.Sprintf(" (%s)", strings.Join(fs, ", "))
+	}
+	return msg + ", agents run " + strings.Join(e.Running, ", ")
+}
+
+func (PBMTooOldError) Is(err error) bool {
+	return err == ErrPBMTooOld //nolint:errorlint
+}
+
+// restoreFeatures returns the features of the backup format which older
+// PBM can't restore
+func (b *BackupMeta) restoreFeatures() []version.RestoreFeature {
+	encrypted := b.Store.Encryption != nil
+	rsStorage := false
+	checksums := false
+	for i := range b.Replsets {
+		if st := b.Replsets[i].Store; st != nil {
+			rsStorage = true
+			encrypted = encrypted || st.Encryption != nil
+		}
+		checksums = checksums || b.Replsets[i].ChecksumsFile != ""
+	}
+
+	var rv []version.RestoreFeature
+	if encrypted {
+		rv = append(rv, version.FeatureEncryption)
+	}
+	if rsStorage {
+		rv = append(rv, version.FeatureReplsetStorage)
+	}
+	if len(b.ExcludedDBs) != 0 {
+		rv = append(rv, version.FeatureExcludedDBs)
+	}
+	if b.HasIntegrityMarkers() {
+		rv = append(rv, version.FeatureIntegrityMarkers)
+	}
+	if checksums {
+		rv = append(rv, version.FeatureArtifactChecksums)
+	}
+	return rv
+}
+
+// RequiredPBM returns the lowest PBM version restoring the backup and the
+// features of the backup which require it. The version is computed from
+// the metadata for the backups made before it was recorded.
+func (b *BackupMeta) RequiredPBM() (string, []version.RestoreFeature) {
+	if b.MinPBMVersion != "" {
+		return b.MinPBMVersion, b.RestoreFeatures
+	}
+
+	fs := b.restoreFeatures()
+	return version.MinRestoreVersion(b.Type, b.PBMVersion, fs), fs
+}
+
+// CheckRestoreVersion returns PBMTooOldError if any of the PBM versions
+// is lower than the backup requires.
+func CheckRestoreVersion(bcp *BackupMeta, versions ...string) error {
+	minv, fs := bcp.RequiredPBM()
+
+	var old []string
+	for _, v := range versions {
+		if !version.SatisfiesMin(v, minv) && !slices.Contains(old, v) {
+			old = append(old, v)
+		}
+	}
+	if len(old) == 0 {
+		return nil
+	}
+
+	return PBMTooOldError{Required: minv, Features: fs, Running: old}
+}
+
+// SetMinPBMVersion records the lowest PBM version restoring the backup.
+func SetMinPBMVersion(
+	ctx context.Context,
+	conn connect.Client,
+	bcpName string,
+	minv string,
+	features []version.RestoreFeature,
+) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"min_pbm_version": minv, "restore_features": features}}})
+
+	return errors.Wrap(err, "update")
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+func TestRequiredPBM(t *testing.T) {
+	encrypted := Storage{StorageConf: config.StorageConf{Encryption: &encrypt.Config{}}}
+
+	testCases := []struct {
+		name     string
+		bcp      BackupMeta
+		want     string
+		features []version.RestoreFeature
+	}{
+		{"plain", BackupMeta{Type: defs.PhysicalBackup, PBMVersion: "2.8.0"}, "", nil},
+		{"legacy", BackupMeta{Type: defs.LogicalBackup, PBMVersion: "2.3.0"}, "1.5.0", nil},
+		{
+			"encrypted",
+			BackupMeta{Type: defs.LogicalBackup, PBMVersion: "2.8.0", Store: encrypted},
+			"2.9.0",
+			[]version.RestoreFeature{version.FeatureEncryption},
+		},
+		{
+			"rs storage",
+			BackupMeta{
+				Type:        defs.PhysicalBackup,
+				PBMVersion:  "2.9.0",
+				Replsets:    []BackupReplset{{Name: "rs0"}, {Name: "rs1", Store: &encrypted}},
+				ExcludedDBs: []string{"logs"},
+			},
+			"2.9.0",
+			[]version.RestoreFeature{
+				version.FeatureEncryption,
+				version.FeatureReplsetStorage,
+				version.FeatureExcludedDBs,
+			},
+		},
+		{
+			"integrity",
+			BackupMeta{
+				Type:             defs.LogicalBackup,
+				PBMVersion:       "2.9.0",
+				IntegrityMarkers: true,
+				Replsets:         []BackupReplset{{Name: "rs0", ChecksumsFile: "bcp/rs0/checksums.json"}},
+			},
+			"2.9.0",
+			[]version.RestoreFeature{version.FeatureIntegrityMarkers, version.FeatureArtifactChecksums},
+		},
+		{
+			"stamped",
+			BackupMeta{Type: defs.LogicalBackup, PBMVersion: "2.8.0", MinPBMVersion: "2.9.0"},
+			"2.9.0",
+			nil,
+		},
+	}
+	for _, tc := range testCases {
+		got, features := tc.bcp.RequiredPBM()
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if len(features) != len(tc.features) {
+			t.Errorf("%s: got features %v, want %v", tc.name, features, tc.features)
+			continue
+		}
+		for i := range features {
+			if features[i] != tc.features[i] {
+				t.Errorf("%s: got features %v, want %v", tc.name, features, tc.features)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckRestoreVersion(t *testing.T) {
+	bcp := &BackupMeta{
+		Type:            defs.LogicalBackup,
+		MinPBMVersion:   "2.8.0",
+		RestoreFeatures: []version.RestoreFeature{version.FeatureEncryption},
+	}
+
+	if err := CheckRestoreVersion(bcp, "2.8.0", "2.9.1"); err != nil {
+		t.Errorf("new agents: %v", err)
+	}
+
+	err := CheckRestoreVersion(bcp, "2.7.0", "2.8.0", "2.6.1", "2.7.0")
+	if !errors.Is(err, ErrPBMTooOld) {
+		t.Fatalf("old agents: got %v", err)
+	}
+	want := "backup requires PBM >= 2.8.0 (encryption), agents run 2.7.0, 2.6.1"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
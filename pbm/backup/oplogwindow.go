@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// estimateBackups is the number of the recent backups the duration
+// of the next backup is estimated by
+const estimateBackups = 5
+
+// OplogWindowFit is the oplog window of the replset compared to
+// the estimated duration of the backup.
+type OplogWindowFit struct {
+	RS       string        `json:"rs"`
+	Window   time.Duration `json:"window"`
+	Estimate time.Duration `json:"estimate"`
+	Fits     bool          `json:"fits"`
+}
+
+// CheckOplogWindows compares the oplog windows reported by the agents
+// with the estimated duration of the backup of the type and the margin of
+// `backup.oplogWindowCheck`. If there are no previous backups of the type,
+// `backup.maxDuration` is the estimate.
+func CheckOplogWindows(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.Config,
+	typ defs.BackupType,
+	agents []topo.AgentStat,
+) ([]OplogWindowFit, error) {
+	est, err := EstimateDurations(ctx, conn, typ)
+	if err != nil {
+		return nil, errors.Wrap(err, "estimate backup duration")
+	}
+	if len(est) == 0 {
+		if d := cfg.Backup.MaxRunTime(); d > 0 {
+			est[""] = d
+		}
+	}
+
+	var wc *config.BackupOplogWindowCheck
+	if cfg.Backup != nil {
+		wc = cfg.Backup.OplogWindowCheck
+	}
+
+	return FitOplogWindows(OplogWindows(agents), est, wc.Margin()), nil
+}
+
+// EstimateDurations returns the expected backup duration of each replset:
+// the longest one of the recent done backups of the type.
+func EstimateDurations(
+	ctx context.Context,
+	conn connect.Client,
+	typ defs.BackupType,
+) (map[string]time.Duration, error) {
+	cur, err := conn.BcpCollection().Find(ctx,
+		bson.D{{"status", defs.StatusDone}, {"type", typ}},
+		options.Find().SetLimit(estimateBackups).SetSort(bson.D{{"start_ts", -1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var bcps []BackupMeta
+	if err := cur.All(ctx, &bcps); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	return estimateDurations(bcps), nil
+}
+
+func estimateDurations(bcps []BackupMeta) map[string]time.Duration {
+	rv := make(map[string]time.Duration)
+	for i := range bcps {
+		for _, rs := range bcps[i].Replsets {
+			if rs.LastTransitionTS <= rs.StartTS {
+				continue
+			}
+			d := time.Duration(rs.LastTransitionTS-rs.StartTS) * time.Second
+			rv[rs.Name] = max(rv[rs.Name], d)
+		}
+	}
+
+	return rv
+}
+
+// OplogWindows returns the shortest projected oplog window of the nodes
+// of each replset. Replsets with no windows reported are omitted.
+func OplogWindows(agents []topo.AgentStat) map[string]time.Duration {
+	rv := make(map[string]time.Duration)
+	for i := range agents {
+		w := agents[i].OplogWindow
+		if w == nil || agents[i].Arbiter {
+			continue
+		}
+
+		d := w.Projected()
+		if cur, ok := rv[agents[i].RS]; !ok || d < cur {
+			rv[agents[i].RS] = d
+		}
+	}
+
+	return rv
+}
+
+// FitOplogWindows compares the windows with the estimated durations and
+// the margin (in percent of the estimate). The replsets without
+// the estimate (e.g. added after the last backup) are compared with
+// the longest estimate. Nothing is returned if there are no estimates.
+func FitOplogWindows(
+	windows map[string]time.Duration,
+	estimates map[string]time.Duration,
+	margin float64,
+) []OplogWindowFit {
+	var longest time.Duration
+	for _, d := range estimates {
+		longest = max(longest, d)
+	}
+	if longest == 0 {
+		return nil
+	}
+
+	rv := make([]OplogWindowFit, 0, len(windows))
+	for rs, w := range windows {
+		est, ok := estimates[rs]
+		if !ok {
+			est = longest
+		}
+		rv = append(rv, OplogWindowFit{
+			RS:       rs,
+			Window:   w,
+			Estimate: est,
+			Fits:     float64(w) >= float64(est)*(1+margin/100),
+		})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].RS < rv[j].RS })
+
+	return rv
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestEstimateDurations(t *testing.T) {
+	bcps := []BackupMeta{
+		{Replsets: []BackupReplset{
+			{Name: "rs0", StartTS: 1000, LastTransitionTS: 1600},
+			{Name: "rs1", StartTS: 1000, LastTransitionTS: 1300},
+		}},
+		{Replsets: []BackupReplset{
+			{Name: "rs0", StartTS: 5000, LastTransitionTS: 5900},
+			{Name: "rs1", StartTS: 5000, LastTransitionTS: 0},
+		}},
+	}
+
+	got := estimateDurations(bcps)
+	want := map[string]time.Duration{"rs0": 900 * time.Second, "rs1": 300 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOplogWindows(t *testing.T) {
+	agents := []topo.AgentStat{
+		{RS: "rs0", OplogWindow: &topo.OplogWindow{
+			First: primitive.Timestamp{T: 1000}, Last: primitive.Timestamp{T: 4600},
+		}},
+		// not full yet: 1 GB of 4 GB in an hour
+		{RS: "rs0", OplogWindow: &topo.OplogWindow{
+			First: primitive.Timestamp{T: 1000}, Last: primitive.Timestamp{T: 4600},
+			Size: 1 << 30, MaxSize: 4 << 30, Rate: float64(1<<30) / 3600,
+		}},
+		{RS: "rs1"},
+		{RS: "rs1", Arbiter: true, OplogWindow: &topo.OplogWindow{}},
+	}
+
+	got := OplogWindows(agents)
+	want := map[string]time.Duration{"rs0": time.Hour}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if d := agents[1].OplogWindow.Projected(); d != 4*time.Hour {
+		t.Errorf("projected: got %v, want 4h", d)
+	}
+}
+
+func TestFitOplogWindows(t *testing.T) {
+	windows := map[string]time.Duration{
+		"rs0": 3 * time.Hour,
+		"rs1": 2 * time.Hour,
+		"rs2": time.Hour,
+	}
+	est := map[string]time.Duration{
+		"rs0": 2 * time.Hour,
+		"rs1": time.Hour,
+	}
+
+	got := FitOplogWindows(windows, est, 50)
+	want := []OplogWindowFit{
+		{RS: "rs0", Window: 3 * time.Hour, Estimate: 2 * time.Hour, Fits: true},
+		{RS: "rs1", Window: 2 * time.Hour, Estimate: time.Hour, Fits: true},
+		{RS: "rs2", Window: time.Hour, Estimate: 2 * time.Hour, Fits: false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := FitOplogWindows(windows, est, 60); got[0].Fits {
+		t.Errorf("rs0 fits with 60%% margin: %v", got[0])
+	}
+	if got := FitOplogWindows(windows, nil, 50); got != nil {
+		t.Errorf("no estimates: got %v", got)
+	}
+}
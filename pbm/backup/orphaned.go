@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrBackupOrphaned is returned on the restore of the orphaned backup
+// (see defs.StatusOrphaned).
+var ErrBackupOrphaned = errors.New("backup is orphaned (storage changed)")
+
+// Orphaned is why the backup is orphaned: its metadata file isn't on the
+// storage it belongs to since the storage config is changed. Unlike the
+// backups deleted from the storage, the metadata is kept, and the backup
+// is done again once the file is back on the configured storage.
+type Orphaned struct {
+	// Status is the status of the backup before it's orphaned
+	Status defs.Status `bson:"status" json:"status"`
+	// Storage is the profile name of the storage which held the backup
+	// last. Empty for the main storage.
+	Storage string `bson:"storage,omitempty" json:"storage,omitempty"`
+	// Location is the path of that storage (see config.StorageConf.Path)
+	Location string `bson:"location" json:"location"`
+	// Reason is what has been found on the storage configured now
+	Reason string `bson:"reason" json:"reason"`
+	// At is unix seconds
+	At int64 `bson:"at" json:"at"`
+}
+
+func (o *Orphaned) String() string {
+	storage := o.Storage
+	if storage == "" {
+		storage = "main"
+	}
+	return fmt.Sprintf("last held by the %s storage %s: %s (since %s)",
+		storage, o.Location, o.Reason, time.Unix(o.At, 0).UTC().Format(time.RFC3339))
+}
+
+// CheckOrphaned returns ErrBackupOrphaned with the storage which held
+// the backup last if the backup is orphaned.
+func CheckOrphaned(bcp *BackupMeta) error {
+	if bcp.Status != defs.StatusOrphaned {
+		return nil
+	}
+	if bcp.Orphaned == nil {
+		return errors.Wrapf(ErrBackupOrphaned, "%q", bcp.Name)
+	}
+	return errors.Wrapf(ErrBackupOrphaned, "%q %s", bcp.Name, bcp.Orphaned)
+}
+
+// SetOrphaned marks the done backup as orphaned.
+func SetOrphaned(ctx context.Context, conn connect.Client, bcpName string, o *Orphaned) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"status", o.Status}},
+		bson.D{{"$set", bson.M{"status": defs.StatusOrphaned, "orphaned": o}}})
+	return errors.Wrap(err, "update")
+}
+
+// RecoverOrphaned returns the orphaned backup to its previous status
+// (o is nil if unknown). The storage is set to the one the backup is
+// found on.
+func RecoverOrphaned(ctx context.Context, conn connect.Client, bcpName string, o *Orphaned, store *Storage) error {
+	status := defs.StatusDone
+	if o != nil {
+		status = o.Status
+	}
+
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"status", defs.StatusOrphaned}},
+		bson.D{
+			{"$set", bson.M{"status": status, "store": store}},
+			{"$unset", bson.M{"orphaned": 1}},
+		})
+	return errors.Wrap(err, "update")
+}
@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// OrphanReport is the result of the cross-check of storage files
+// with backups and PITR chunks metadata.
+type OrphanReport struct {
+	// Files are storage files no backup or chunk metadata refers to.
+	Files []OrphanFile `json:"files"`
+	// Backups are done backups which files are missed on the storage.
+	Backups []DanglingBackup `json:"backups"`
+	// Chunks are PITR chunks which files are missed on the storage.
+	Chunks []oplog.OplogChunk `json:"chunks"`
+	// Uploads are incomplete (multipart) uploads.
+	Uploads []storage.IncompleteUpload `json:"uploads"`
+}
+
+// IsEmpty returns true if nothing is found.
+func (r *OrphanReport) IsEmpty() bool {
+	return len(r.Files) == 0 && len(r.Backups) == 0 && len(r.Chunks) == 0 && len(r.Uploads) == 0
+}
+
+type OrphanFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type DanglingBackup struct {
+	Name    string   `json:"name"`
+	Missing []string `json:"missing"`
+}
+
+// FindOrphans scans the storage and cross-references its files with
+// backups and PITR chunks metadata.
+//
+// Files and uploads modified after `before` are never reported as
+// an in-flight backup may not have committed its metadata yet.
+// Backups stored on external profiles and imported backups are not checked.
+func FindOrphans(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	before time.Time,
+) (*OrphanReport, error) {
+	files, err := stg.List("", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "list files")
+	}
+
+	var uploads []storage.IncompleteUpload
+	if ul, ok := stg.(storage.UploadsLister); ok {
+		uploads, err = ul.ListUploads("")
+		if err != nil {
+			return nil, errors.Wrap(err, "list uploads")
+		}
+	}
+
+	bcps, err := NewDBManager(conn).GetAllBackups(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups")
+	}
+
+	chunks, err := oplog.PITRGetChunksSlice(ctx, conn, "", primitive.Timestamp{}, primitive.Timestamp{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks")
+	}
+
+	return findOrphans(files, uploads, bcps, chunks, before), nil
+}
+
+func findOrphans(
+	files []storage.FileInfo,
+	uploads []storage.IncompleteUpload,
+	bcps []BackupMeta,
+	chunks []oplog.OplogChunk,
+	before time.Time,
+) *OrphanReport {
+	rv := &OrphanReport{
+		Files:   []OrphanFile{},
+		Backups: []DanglingBackup{},
+		Chunks:  []oplog.OplogChunk{},
+		Uploads: []storage.IncompleteUpload{},
+	}
+
+	known := make(map[string]bool)
+	for i := range bcps {
+		if !bcps[i].Store.IsProfile {
+			known[bcps[i].Name] = true
+		}
+	}
+	chunkFiles := make(map[string]bool, len(chunks))
+	for i := range chunks {
+		chunkFiles[chunks[i].FName] = true
+	}
+
+	stored := make(map[string]bool, len(files))
+	withData := make(map[string]bool)
+	for _, f := range files {
+		stored[f.Name] = true
+
+		name := orphanBackupName(f.Name)
+		if name == "" {
+			continue
+		}
+		if strings.HasSuffix(f.Name, defs.MetadataFileSuffix) && !strings.Contains(f.Name, "/") {
+			// backup that is not synced yet still refers to its files
+			known[name] = true
+		} else {
+			withData[name] = true
+		}
+	}
+
+	for _, f := range files {
+		if f.ModTime.IsZero() || f.ModTime.After(before) {
+			continue
+		}
+
+		orphan := false
+		if strings.HasPrefix(f.Name, defs.PITRfsPrefix+"/") {
+			orphan = !chunkFiles[f.Name]
+		} else if name := orphanBackupName(f.Name); name != "" {
+			orphan = !known[name]
+		}
+		if orphan {
+			rv.Files = append(rv.Files, OrphanFile{Name: f.Name, Size: f.Size, ModTime: f.ModTime})
+		}
+	}
+
+	for i := range bcps {
+		bcp := &bcps[i]
+		// imported backups are in the own folder of the storage
+		if bcp.Store.IsProfile || bcp.IsImported() || bcp.Status != defs.StatusDone ||
+			time.Unix(bcp.LastTransitionTS, 0).After(before) {
+			continue
+		}
+
+		var missing []string
+		if !stored[bcp.Name+defs.MetadataFileSuffix] {
+			missing = append(missing, bcp.Name+defs.MetadataFileSuffix)
+		}
+		// data of replsets with the storage override is on other storages
+		elsewhere := len(bcp.Replsets) != 0 &&
+			!slices.ContainsFunc(bcp.Replsets, func(rs BackupReplset) bool { return rs.Store == nil })
+		if bcp.Type != defs.ExternalBackup && !elsewhere && !withData[bcp.Name] {
+			missing = append(missing, "backup data")
+		}
+		if len(missing) != 0 {
+			rv.Backups = append(rv.Backups, DanglingBackup{Name: bcp.Name, Missing: missing})
+		}
+	}
+
+	for i := range chunks {
+		c := &chunks[i]
+		if c.Storage != "" {
+			// the chunk is on the replset storage
+			continue
+		}
+		if !stored[c.FName] && !time.Unix(int64(c.EndTS.T), 0).After(before) {
+			rv.Chunks = append(rv.Chunks, *c)
+		}
+	}
+
+	for _, u := range uploads {
+		if !u.Initiated.IsZero() && !u.Initiated.After(before) {
+			rv.Uploads = append(rv.Uploads, u)
+		}
+	}
+
+	return rv
+}
+
+// orphanBackupName returns the backup name the file belongs to.
+// The file is either the backup metadata file, a file in the backup
+// folder or a file of the legacy layout (`<name>_<rs>...`).
+// Empty string is returned if the file doesn't look like a backup file.
+func orphanBackupName(fname string) string {
+	name, _, _ := strings.Cut(fname, "/")
+	name = strings.TrimSuffix(name, defs.MetadataFileSuffix)
+	name, _, _ = strings.Cut(name, "_")
+
+	if _, err := time.Parse(time.RFC3339, name); err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// CleanupOrphans deletes orphaned files and incomplete uploads, marks
+// dangling backups as failed and deletes metadata of dangling chunks.
+// It proceeds on errors and returns all of them.
+func CleanupOrphans(ctx context.Context, conn connect.Client, stg storage.Storage, r *OrphanReport) error {
+	var errs []error
+
+	for _, f := range r.Files {
+		err := stg.Delete(f.Name)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			errs = append(errs, errors.Wrapf(err, "delete %s", f.Name))
+		}
+	}
+
+	if ul, ok := stg.(storage.UploadsLister); ok {
+		for _, u := range r.Uploads {
+			err := ul.AbortUpload(u)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "abort upload %s", u.Name))
+			}
+		}
+	}
+
+	for _, b := range r.Backups {
+		msg := "missing on storage: " + strings.Join(b.Missing, ", ")
+		err := ChangeBackupState(conn, b.Name, defs.StatusError, msg)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "mark backup %s", b.Name))
+		}
+	}
+
+	for _, c := range r.Chunks {
+		_, err := conn.PITRChunksCollection().DeleteOne(ctx, bson.D{
+			{"rs", c.RS},
+			{"start_ts", c.StartTS},
+			{"end_ts", c.EndTS},
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "delete chunk meta %s", c.FName))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+func TestOrphanBackupName(t *testing.T) {
+	cases := map[string]string{
+		"2024-01-02T03:04:05Z.pbm.json":               "2024-01-02T03:04:05Z",
+		"2024-01-02T03:04:05Z/rs0/metadata.json":      "2024-01-02T03:04:05Z",
+		"2024-01-02T03:04:05Z_rs0.dump.s2":            "2024-01-02T03:04:05Z",
+		".pbm.init":                                   "",
+		".pbm.restore/2024-01-02T03:04:05Z.json":      "",
+		"pbmPitr/rs0/20240102/20240102.oplog.s2":      "",
+		"some/user/file":                              "",
+		"2024-01-02T03:04:05Z-not-a-backup/something": "",
+	}
+	for in, want := range cases {
+		if got := orphanBackupName(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	before := now.Add(-24 * time.Hour)
+
+	file := func(name string, mod time.Time) storage.FileInfo {
+		return storage.FileInfo{Name: name, Size: 10, ModTime: mod}
+	}
+	files := []storage.FileInfo{
+		file(".pbm.init", old),
+		// known backup
+		file("2024-01-01T00:00:00Z.pbm.json", old),
+		file("2024-01-01T00:00:00Z/rs0/metadata.json", old),
+		// not synced backup
+		file("2024-01-02T00:00:00Z.pbm.json", old),
+		file("2024-01-02T00:00:00Z/rs0/metadata.json", old),
+		// aborted backup
+		file("2024-01-03T00:00:00Z/rs0/metadata.json", old),
+		file("2024-01-03T00:00:00Z/rs0/db.c.s2", old),
+		// in-flight backup without metadata yet
+		file("2024-01-04T00:00:00Z/rs0/db.c.s2", now),
+		// chunks
+		file("pbmPitr/rs0/20240101/c1.oplog.s2", old),
+		file("pbmPitr/rs0/20240101/c2.oplog.s2", old),
+		// unknown file
+		file("notes.txt", old),
+	}
+	bcps := []BackupMeta{
+		{Name: "2024-01-01T00:00:00Z", Status: defs.StatusDone, Type: defs.LogicalBackup},
+		// files are removed manually
+		{Name: "2023-12-01T00:00:00Z", Status: defs.StatusDone, Type: defs.LogicalBackup},
+		// on external profile
+		{Name: "2023-12-02T00:00:00Z", Status: defs.StatusDone, Store: Storage{IsProfile: true}},
+		// imported, files are in the import folder
+		{
+			Name:       "2023-12-03T00:00:00Z",
+			Status:     defs.StatusDone,
+			Type:       defs.LogicalBackup,
+			Provenance: &Provenance{Source: ProvenanceImport, Prefix: "foreign"},
+		},
+	}
+	chunks := []oplog.OplogChunk{
+		{RS: "rs0", FName: "pbmPitr/rs0/20240101/c1.oplog.s2", EndTS: primitive.Timestamp{T: uint32(old.Unix())}},
+		{RS: "rs0", FName: "pbmPitr/rs0/20240101/c0.oplog.s2", EndTS: primitive.Timestamp{T: uint32(old.Unix())}},
+	}
+	uploads := []storage.IncompleteUpload{
+		{Name: "2024-01-03T00:00:00Z/rs0/big.s2", ID: "1", Initiated: old},
+		{Name: "2024-01-04T00:00:00Z/rs0/big.s2", ID: "2", Initiated: now},
+	}
+
+	r := findOrphans(files, uploads, bcps, chunks, before)
+
+	gotFiles := []string{}
+	for _, f := range r.Files {
+		gotFiles = append(gotFiles, f.Name)
+	}
+	wantFiles := []string{
+		"2024-01-03T00:00:00Z/rs0/metadata.json",
+		"2024-01-03T00:00:00Z/rs0/db.c.s2",
+		"pbmPitr/rs0/20240101/c2.oplog.s2",
+	}
+	if len(gotFiles) != len(wantFiles) {
+		t.Fatalf("files: got %v, want %v", gotFiles, wantFiles)
+	}
+	for i := range wantFiles {
+		if gotFiles[i] != wantFiles[i] {
+			t.Errorf("files: got %v, want %v", gotFiles, wantFiles)
+			break
+		}
+	}
+
+	if len(r.Backups) != 1 || r.Backups[0].Name != "2023-12-01T00:00:00Z" || len(r.Backups[0].Missing) != 2 {
+		t.Errorf("backups: got %+v", r.Backups)
+	}
+	if len(r.Chunks) != 1 || r.Chunks[0].FName != "pbmPitr/rs0/20240101/c0.oplog.s2" {
+		t.Errorf("chunks: got %+v", r.Chunks)
+	}
+	if len(r.Uploads) != 1 || r.Uploads[0].ID != "1" {
+		t.Errorf("uploads: got %+v", r.Uploads)
+	}
+}
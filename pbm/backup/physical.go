@@ -204,6 +204,7 @@ func (b *Backup) doPhysical(
 	bcp *ctrl.BackupCmd,
 	opid ctrl.OPID,
 	rsMeta *BackupReplset,
+	excludeDBs []string,
 	inf *topo.NodeInfo,
 	stg storage.Storage,
 	l log.LogEvent,
@@ -225,14 +226,14 @@ func (b *Backup) doPhysical(
 			}
 
 			// ? should be done during Init()?
-			if inf.IsLeader() {
+			if b.IsLeader(inf) {
 				err := SetSrcBackup(ctx, b.leadConn, bcp.Name, src.Name)
 				if err != nil {
 					return errors.Wrap(err, "set source backup in meta")
 				}
 			}
 
-			if !b.config.Storage.Equal(&src.Store.StorageConf) {
+			if !b.rsStorageConf(rsMeta).Equal(&src.RSStorage(rsMeta.Name).StorageConf) {
 				return errors.New("cannot use the configured storage: " +
 					"source backup is stored on a different storage")
 			}
@@ -268,6 +269,14 @@ func (b *Backup) doPhysical(
 			}
 		}
 	}
+	if b.config != nil && b.config.Backup.Quiesce.IsEnabled() {
+		d, err := b.quiesce(ctx, l)
+		if err != nil {
+			return errors.Wrap(err, "quiesce node")
+		}
+		rsMeta.QuiesceWaitMS = d.Milliseconds()
+	}
+
 	cursor := NewBackupCursor(b.nodeConn, l, currOpts)
 	defer cursor.Close()
 
@@ -283,6 +292,17 @@ func (b *Backup) doPhysical(
 
 	l.Debug("backup cursor id: %s", bcur.Meta.ID)
 
+	if len(excludeDBs) != 0 {
+		excl, err := excludedFiles(ctx, b.nodeConn, excludeDBs)
+		if err != nil {
+			return errors.Wrap(err, "get files of excluded databases")
+		}
+		var n int
+		bcur.Data, n, rsMeta.ExcludedSize = excludeFiles(bcur.Data, bcur.Meta.DBpath, excl)
+		l.Info("excluded databases %v: skip %d files (%s)",
+			excludeDBs, n, storage.PrettySize(rsMeta.ExcludedSize))
+	}
+
 	lwts, err := topo.GetLastWrite(ctx, b.nodeConn, true)
 	if err != nil {
 		return errors.Wrap(err, "get shard's last write ts")
@@ -311,7 +331,7 @@ func (b *Backup) doPhysical(
 		return errors.Wrap(err, "add shard's metadata")
 	}
 
-	if inf.IsLeader() {
+	if b.IsLeader(inf) {
 		err := b.reconcileStatus(ctx,
 			bcp.Name, opid.String(), defs.StatusRunning, util.Ref(b.timeouts.StartingStatus()))
 		if err != nil {
@@ -367,6 +387,97 @@ func (b *Backup) doPhysical(
 	return b.uploadPhysical(ctx, bcp, rsMeta, data, jrnls, bcur.Meta.DBpath, stg, l)
 }
 
+// quiesce prepares the node for opening the backup cursor. It waits for
+// in-progress index builds to finish (if configured) and forces a checkpoint
+// so the backup cursor won't capture the node under checkpoint pressure.
+// It returns how long the quiescing took.
+//
+// On timeout, it returns an error only if `failOnTimeout` is set.
+// Otherwise, the backup proceeds right away.
+func (b *Backup) quiesce(ctx context.Context, l log.LogEvent) (time.Duration, error) {
+	opts := b.config.Backup.Quiesce
+
+	qctx, cancel := context.WithTimeout(ctx, opts.TimeoutDuration())
+	defer cancel()
+
+	start := time.Now()
+	err := quiesceNode(qctx, b.nodeConn, opts.WaitIndexBuilds, l)
+	dur := time.Since(start)
+	if err == nil {
+		l.Debug("node quiesced in %v", dur)
+		return dur, nil
+	}
+	if ctx.Err() != nil {
+		return dur, ctx.Err()
+	}
+
+	if qctx.Err() != nil {
+		if opts.FailOnTimeout {
+			return dur, errors.Errorf("timeout after %v", dur)
+		}
+		l.Warning("quiesce timed out after %v. proceeding with the backup", dur)
+		return dur, nil
+	}
+
+	l.Warning("quiesce: %v. proceeding with the backup", err)
+	return dur, nil
+}
+
+func quiesceNode(ctx context.Context, m *mongo.Client, waitIdx bool, l log.LogEvent) error {
+	if waitIdx {
+		err := waitIndexBuilds(ctx, m, l)
+		if err != nil {
+			return errors.Wrap(err, "wait for index builds")
+		}
+	}
+
+	// fsync without lock forces WiredTiger to take a checkpoint
+	err := m.Database("admin").RunCommand(ctx, bson.D{{"fsync", 1}}).Err()
+	return errors.Wrap(err, "fsync")
+}
+
+// indexBuildsPollInterval is how often waitIndexBuilds checks the builds
+var indexBuildsPollInterval = time.Second
+
+// waitIndexBuilds waits for the in-progress index builds to finish.
+//
+// The builds aren't paused: MongoDB has no command to pause a running
+// build, only to abort it (dropIndexes), which would lose the index the
+// user asked for. The builds started during the wait are waited for too.
+func waitIndexBuilds(ctx context.Context, m *mongo.Client, l log.LogEvent) error {
+	filter := bson.D{
+		{"currentOp", 1},
+		{"$or", bson.A{
+			bson.D{{"command.createIndexes", bson.D{{"$exists", true}}}},
+			bson.D{{"desc", primitive.Regex{Pattern: "^IndexBuildsCoordinator"}}},
+		}},
+	}
+
+	tk := time.NewTicker(indexBuildsPollInterval)
+	defer tk.Stop()
+
+	for {
+		res := struct {
+			InProg []bson.Raw `bson:"inprog"`
+		}{}
+		err := m.Database("admin").RunCommand(ctx, filter).Decode(&res)
+		if err != nil {
+			return errors.Wrap(err, "get current ops")
+		}
+		if len(res.InProg) == 0 {
+			return nil
+		}
+
+		l.Debug("waiting for %d index build(s) to finish", len(res.InProg))
+
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func (b *Backup) handleExternal(
 	ctx context.Context,
 	bcp *ctrl.BackupCmd,
@@ -495,9 +606,21 @@ func (b *Backup) uploadPhysical(
 	stg storage.Storage,
 	l log.LogEvent,
 ) error {
+	progress := &progressTracker{}
+	for _, f := range data {
+		progress.total.Add(uploadedLen(f))
+	}
+	for _, f := range jrnls {
+		progress.total.Add(uploadedLen(f))
+	}
+	stopProgress := progress.start(ctx, b.leadConn, bcp.Name, rsMeta.Name, l)
+	defer stopProgress()
+
+	pool := b.compressionPool()
+	defer pool.Release()
 	l.Info("uploading data")
 	dataFiles, err := uploadFiles(ctx, data, bcp.Name+"/"+rsMeta.Name, dbpath,
-		b.typ == defs.IncrementalBackup, stg, bcp.Compression, bcp.CompressionLevel, l)
+		b.typ == defs.IncrementalBackup, stg, pool, bcp.Compression, bcp.CompressionLevel, progress, l)
 	if err != nil {
 		return errors.Wrap(err, "upload data files")
 	}
@@ -505,7 +628,7 @@ func (b *Backup) uploadPhysical(
 
 	l.Info("uploading journals")
 	ju, err := uploadFiles(ctx, jrnls, bcp.Name+"/"+rsMeta.Name, dbpath,
-		false, stg, bcp.Compression, bcp.CompressionLevel, l)
+		false, stg, pool, bcp.Compression, bcp.CompressionLevel, progress, l)
 	if err != nil {
 		return errors.Wrap(err, "upload journal files")
 	}
@@ -595,8 +718,10 @@ func uploadFiles(
 	trimPrefix string,
 	incr bool,
 	stg storage.Storage,
+	pool *compress.Pool,
 	comprT compress.CompressionType,
 	comprL *int,
+	progress *progressTracker,
 	l log.LogEvent,
 ) ([]File, error) {
 	if len(files) == 0 {
@@ -639,10 +764,12 @@ func uploadFiles(
 			continue
 		}
 
-		fw, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+		progress.setFile(trim(wfile.Name))
+		fw, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, pool, comprT, comprL, l)
 		if err != nil {
 			return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 		}
+		progress.add(uploadedLen(wfile))
 		fw.Name = trim(wfile.Name)
 
 		data = append(data, *fw)
@@ -654,10 +781,12 @@ func uploadFiles(
 		return data, nil
 	}
 
-	f, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+	progress.setFile(trim(wfile.Name))
+	f, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, pool, comprT, comprL, l)
 	if err != nil {
 		return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 	}
+	progress.add(uploadedLen(wfile))
 	f.Name = trim(wfile.Name)
 
 	data = append(data, *f)
@@ -665,11 +794,20 @@ func uploadFiles(
 	return data, nil
 }
 
+// uploadedLen returns amount of the source data uploaded for the file.
+func uploadedLen(f File) int64 {
+	if f.Len > 0 {
+		return f.Len
+	}
+	return f.Size
+}
+
 func writeFile(
 	ctx context.Context,
 	src File,
 	dst string,
 	stg storage.Storage,
+	pool *compress.Pool,
 	compression compress.CompressionType,
 	compressLevel *int,
 	l log.LogEvent,
@@ -691,7 +829,7 @@ func writeFile(
 		dst += fmt.Sprintf(".%d-%d", src.Off, src.Len)
 	}
 
-	_, err = storage.Upload(ctx, &src, stg, compression, compressLevel, dst, sz)
+	_, err = storage.UploadPool(ctx, &src, stg, pool, compression, compressLevel, dst, sz)
 	if err != nil {
 		return nil, errors.Wrap(err, "upload file")
 	}
@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// progressInterval is how often the replset progress is written to the backup metadata
+const progressInterval = 2 * time.Second
+
+// RSProgress is the data transfer progress of the replset backup.
+type RSProgress struct {
+	// Total is expected (estimated) amount of bytes. It is zero if unknown.
+	Total int64 `bson:"total" json:"total"`
+	// Done is amount of bytes dumped (logical) or uploaded (physical).
+	Done int64 `bson:"done" json:"done"`
+	// File is the collection or the file is being uploaded.
+	File string `bson:"file,omitempty" json:"file,omitempty"`
+	// UpdatedTS is unix time of the last update.
+	UpdatedTS int64 `bson:"updated_ts" json:"updated_ts"`
+}
+
+// Percent returns the done percentage. For an unknown total it is -1.
+// Done may exceed the estimated total, so the result is capped with 100.
+func (p *RSProgress) Percent() float64 {
+	if p == nil || p.Total <= 0 {
+		return -1
+	}
+
+	return min(float64(p.Done)/float64(p.Total)*100, 100)
+}
+
+func SetRSProgress(ctx context.Context, conn connect.Client, bcpName, rsName string, p *RSProgress) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.progress": p}}})
+
+	return err
+}
+
+// SetRSChecksumsFile records the checksums file of the replset.
+func SetRSChecksumsFile(ctx context.Context, conn connect.Client, bcpName, rsName, file string) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.checksums_file": file}}})
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRSTransfers(
+	ctx context.Context,
+	conn connect.Client,
+	bcpName, rsName string,
+	stats []storage.TransferStats,
+) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.transfers": stats}}})
+
+	return err
+}
+
+// progressTracker accumulates the replset progress and periodically
+// writes it to the backup metadata. A nil tracker is no-op.
+type progressTracker struct {
+	total atomic.Int64
+	done  atomic.Int64
+
+	mu   sync.Mutex
+	file string
+}
+
+func (t *progressTracker) add(n int64) {
+	if t != nil {
+		t.done.Add(n)
+	}
+}
+
+func (t *progressTracker) setFile(name string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.file = name
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) get() *RSProgress {
+	t.mu.Lock()
+	file := t.file
+	t.mu.Unlock()
+
+	return &RSProgress{
+		Total:     t.total.Load(),
+		Done:      t.done.Load(),
+		File:      file,
+		UpdatedTS: time.Now().Unix(),
+	}
+}
+
+// writer counts bytes written to w as done.
+func (t *progressTracker) writer(w io.WriteCloser) io.WriteCloser {
+	if t == nil {
+		return w
+	}
+
+	return &progressWriter{w: w, t: t}
+}
+
+// start runs writing of the progress to the backup metadata until
+// the returned func is called. The final state is written on stop.
+func (t *progressTracker) start(
+	ctx context.Context,
+	conn connect.Client,
+	bcpName string,
+	rsName string,
+	l log.LogEvent,
+) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		tk := time.NewTicker(progressInterval)
+		defer tk.Stop()
+
+		var last RSProgress
+		for {
+			select {
+			case <-tk.C:
+				p := t.get()
+				if p.Done == last.Done && p.Total == last.Total && p.File == last.File {
+					continue
+				}
+				err := SetRSProgress(ctx, conn, bcpName, rsName, p)
+				if err != nil && ctx.Err() == nil {
+					l.Warning("set progress: %v", err)
+				}
+				last = *p
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+
+		p := t.get()
+		p.File = ""
+		if err := SetRSProgress(context.Background(), conn, bcpName, rsName, p); err != nil {
+			l.Warning("set progress: %v", err)
+		}
+	}
+}
+
+type progressWriter struct {
+	w io.WriteCloser
+	t *progressTracker
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.t.add(int64(n))
+	return n, err
+}
+
+func (w *progressWriter) Close() error {
+	return w.w.Close()
+}
@@ -52,7 +52,7 @@ func (m *dbMangerImpl) query(ctx context.Context, clause bson.D) (*BackupMeta, e
 	res := m.conn.BcpCollection().FindOne(ctx, clause)
 	if err := res.Err(); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.ErrNotFound
+			return nil, errors.WithCode(errors.ErrNotFound, errors.CodeBackupNotFound)
 		}
 		return nil, errors.Wrap(err, "get")
 	}
@@ -70,7 +70,7 @@ func getBackupMeta(ctx context.Context, conn connect.Client, clause bson.D) (*Ba
 	res := conn.BcpCollection().FindOne(ctx, clause)
 	if err := res.Err(); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.ErrNotFound
+			return nil, errors.WithCode(errors.ErrNotFound, errors.CodeBackupNotFound)
 		}
 		return nil, errors.Wrap(err, "get")
 	}
@@ -219,6 +219,28 @@ func ChangeRSState(conn connect.Client, bcpName, rsName string, s defs.Status, m
 	return err
 }
 
+// SetErrorCode sets the code of the backup failure
+func SetErrorCode(conn connect.Client, bcpName string, code errors.Code) error {
+	_, err := conn.BcpCollection().UpdateOne(
+		context.Background(),
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"error_code": code}}},
+	)
+
+	return err
+}
+
+// SetRSErrorCode sets the code of the replset backup failure
+func SetRSErrorCode(conn connect.Client, bcpName, rsName string, code errors.Code) error {
+	_, err := conn.BcpCollection().UpdateOne(
+		context.Background(),
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.error_code": code}}},
+	)
+
+	return err
+}
+
 func IncBackupSize(ctx context.Context, conn connect.Client, bcpName string, size int64) error {
 	_, err := conn.BcpCollection().UpdateOne(ctx,
 		bson.D{{"name", bcpName}},
@@ -239,26 +261,105 @@ func SetRSLastWrite(conn connect.Client, bcpName, rsName string, ts primitive.Ti
 	return err
 }
 
+// SetRSNSStatsFile records the namespaces stats file of the replset.
+func SetRSNSStatsFile(ctx context.Context, conn connect.Client, bcpName, rsName, file string) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.ns_stats_file": file}}})
+
+	return err
+}
+
+func SetRSCollOptions(ctx context.Context, conn connect.Client, bcpName, rsName string, opts []CollOptions) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.coll_options": opts}}})
+
+	return err
+}
+
+func SetClusterTimeReached(ctx context.Context, conn connect.Client, bcpName string, reached bool) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"cluster_time.reached": reached}}})
+
+	return err
+}
+
+func SetConsistencyCheck(ctx context.Context, conn connect.Client, bcpName string, c *ConsistencyCheck) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"consistency": c}}})
+
+	return err
+}
+
+// AddBackupWarning records the warning of the backup.
+func AddBackupWarning(ctx context.Context, conn connect.Client, bcpName string, msg string) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$push", bson.M{"warnings": msg}}})
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreVerification(ctx context.Context, conn connect.Client, bcpName string, v *RestoreVerification) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"verification": v}}})
+
+	return err
+}
+
+// SetBackupStorage sets storages of the backup and its replsets at once
+func SetBackupStorage(ctx context.Context, conn connect.Client, bcp *BackupMeta) error {
+	_, err := conn.BcpCollection().UpdateOne(ctx,
+		bson.D{{"name", bcp.Name}},
+		bson.D{{"$set", bson.M{"store": bcp.Store, "replsets": bcp.Replsets}}})
+
+	return err
+}
+
 func LastIncrementalBackup(ctx context.Context, conn connect.Client) (*BackupMeta, error) {
-	return getRecentBackup(ctx, conn, nil, nil, -1, bson.D{{"type", string(defs.IncrementalBackup)}})
+	return getRecentBackup(ctx, conn, nil, nil, -1, bson.D{
+		{"type", string(defs.IncrementalBackup)},
+		{"single_rs", nil},
+	})
 }
 
-// GetLastBackup returns last successfully finished backup (non-selective and non-external)
+// notImported filters out backups of other clusters registered by
+// `pbm backup import`. They are not a base for PITR of this cluster.
+var notImported = bson.E{"provenance.source", bson.M{"$ne": ProvenanceImport}}
+
+// GetLastBackup returns last successfully finished backup (non-selective, non-external and
+// of the whole cluster)
 // or nil if there is no such backup yet. If ts isn't nil it will
 // search for the most recent backup that finished before specified timestamp
 func GetLastBackup(ctx context.Context, conn connect.Client, before *primitive.Timestamp) (*BackupMeta, error) {
 	return getRecentBackup(ctx, conn, nil, before, -1, bson.D{
 		{"nss", nil},
+		{"single_rs", nil},
+		{"excluded_dbs", nil},
 		{"type", bson.M{"$ne": defs.ExternalBackup}},
 		{"store.profile", nil},
+		notImported,
 	})
 }
 
+// LastBackupOfType returns the most recent successfully finished backup
+// of the given type or errors.ErrNotFound if there is no such backup yet.
+func LastBackupOfType(ctx context.Context, conn connect.Client, t defs.BackupType) (*BackupMeta, error) {
+	return getRecentBackup(ctx, conn, nil, nil, -1, bson.D{{"type", string(t)}})
+}
+
 func GetFirstBackup(ctx context.Context, conn connect.Client, after *primitive.Timestamp) (*BackupMeta, error) {
 	return getRecentBackup(ctx, conn, after, nil, 1, bson.D{
 		{"nss", nil},
+		{"single_rs", nil},
+		{"excluded_dbs", nil},
 		{"type", bson.M{"$ne": defs.ExternalBackup}},
 		{"store.profile", nil},
+		notImported,
 	})
 }
 
@@ -286,7 +387,7 @@ func getRecentBackup(
 	)
 	if err := res.Err(); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.ErrNotFound
+			return nil, errors.WithCode(errors.ErrNotFound, errors.CodeBackupNotFound)
 		}
 		return nil, errors.Wrap(err, "get")
 	}
@@ -321,8 +422,11 @@ func findBaseSnapshotLWImpl(
 ) (primitive.Timestamp, error) {
 	f := bson.D{
 		{"nss", nil},
+		{"single_rs", nil},
+		{"excluded_dbs", nil},
 		{"type", bson.M{"$ne": defs.ExternalBackup}},
 		{"store.profile", nil},
+		notImported,
 		{"last_write_ts", lwCond},
 		{"status", defs.StatusDone},
 	}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestWaitIndexBuilds(t *testing.T) {
+	defer func(d time.Duration) { indexBuildsPollInterval = d }(indexBuildsPollInterval)
+	indexBuildsPollInterval = 10 * time.Millisecond
+
+	inProg := func(n int) bson.D {
+		ops := bson.A{}
+		for range n {
+			ops = append(ops, bson.D{{"desc", "IndexBuildsCoordinatorMongod-0"}})
+		}
+		return mtest.CreateSuccessResponse(bson.E{"inprog", ops})
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("no builds", func(mt *mtest.T) {
+		mt.AddMockResponses(inProg(0))
+		if err := waitIndexBuilds(context.Background(), mt.Client, log.DiscardEvent); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	mt.Run("builds finish", func(mt *mtest.T) {
+		mt.AddMockResponses(inProg(2), inProg(1), inProg(0))
+		if err := waitIndexBuilds(context.Background(), mt.Client, log.DiscardEvent); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if n := len(mt.GetAllStartedEvents()); n != 3 {
+			t.Errorf("expected 3 currentOp calls, got %d", n)
+		}
+	})
+
+	mt.Run("timeout", func(mt *mtest.T) {
+		for range 100 {
+			mt.AddMockResponses(inProg(1))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := waitIndexBuilds(ctx, mt.Client, log.DiscardEvent)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	})
+
+	mt.Run("currentOp error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    13,
+			Name:    "Unauthorized",
+			Message: "not authorized",
+		}))
+		if err := waitIndexBuilds(context.Background(), mt.Client, log.DiscardEvent); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestQuiesceNode(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name    string
+		waitIdx bool
+		want    []string
+	}{
+		{"checkpoint only", false, []string{"fsync"}},
+		{"wait index builds", true, []string{"currentOp", "fsync"}},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			if tt.waitIdx {
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{"inprog", bson.A{}}))
+			}
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+			if err := quiesceNode(context.Background(), mt.Client, tt.waitIdx, log.DiscardEvent); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, e := range mt.GetAllStartedEvents() {
+				got = append(got, e.CommandName)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got commands %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
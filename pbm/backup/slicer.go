@@ -2,15 +2,17 @@ package backup
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
@@ -20,17 +22,21 @@ type uploadChunkFunc func(ctx context.Context, w io.WriterTo, from, till primiti
 
 type stopSlicerFunc func() (primitive.Timestamp, int64, error)
 
+type reconnectFunc func(ctx context.Context) (*mongo.Client, error)
+
 func startOplogSlicer(
 	ctx context.Context,
 	m *mongo.Client,
 	writeConcern *writeconcern.WriteConcern,
 	interval time.Duration,
 	startOpTime primitive.Timestamp,
+	retryWindow time.Duration,
+	reconnect reconnectFunc,
 	upload uploadChunkFunc,
 ) stopSlicerFunc {
 	l := log.LogEventFromContext(ctx)
 	var uploaded int64
-	var err error
+	var err, lastErr error
 
 	stopSignalC := make(chan struct{})
 	stoppedC := make(chan struct{})
@@ -42,7 +48,9 @@ func startOplogSlicer(
 		defer t.Stop()
 
 		oplog := oplog.NewOplogBackup(m)
+		oplog.SetRetry(retryWindow, reconnect, l.Warning)
 		keepRunning := true
+		var lastFailedAt time.Time
 		for keepRunning {
 			select {
 			case <-t.C:
@@ -66,6 +74,24 @@ func startOplogSlicer(
 				}
 
 				l.Error("failed to get last write: %v", err)
+				if !keepRunning {
+					// retry the last slice (stopSignalC is closed)
+					// while the node is failing over
+					if lastFailedAt.IsZero() {
+						lastFailedAt = time.Now()
+					}
+					if time.Since(lastFailedAt) < retryWindow {
+						keepRunning = true
+						select {
+						case <-time.After(time.Second):
+						case <-ctx.Done():
+							lastErr = errors.Wrap(ctx.Err(), "get last write for the last oplog slice")
+							return
+						}
+					} else {
+						lastErr = errors.Wrap(err, "get last write for the last oplog slice")
+					}
+				}
 				continue
 			}
 			if !currOpTime.After(startOpTime) {
@@ -82,6 +108,10 @@ func startOplogSlicer(
 				}
 
 				l.Error("failed to upload oplog: %v", err)
+				if !keepRunning {
+					// the backup is inconsistent without the last slice
+					lastErr = errors.Wrap(err, "upload the last oplog slice")
+				}
 				continue
 			}
 
@@ -108,6 +138,58 @@ func startOplogSlicer(
 			<-stoppedC
 		}
 
+		if err == nil {
+			err = lastErr
+		}
 		return startOpTime, uploaded, err
 	}
 }
+
+// oplogReconnect returns the client to resume the oplog tailing with after
+// the node failover. It is the node itself once it is a primary or
+// a secondary again, or the connection to the replset of hosts otherwise
+// (the node is down or in rollback). The returned func closes
+// the connection made.
+func (b *Backup) oplogReconnect(hosts []string) (reconnectFunc, func()) {
+	var rsConn *mongo.Client
+
+	reconnect := func(ctx context.Context) (*mongo.Client, error) {
+		inf, err := topo.GetNodeInfo(ctx, b.nodeConn)
+		if err == nil && (inf.IsPrimary || inf.Secondary) {
+			return b.nodeConn, nil
+		}
+
+		if rsConn == nil {
+			rsConn, err = connectReplset(ctx, b.brief.URI, b.brief.SetName, hosts)
+			if err != nil {
+				return nil, errors.Wrap(err, "connect to replset")
+			}
+		}
+		return rsConn, nil
+	}
+
+	return reconnect, func() {
+		if rsConn != nil {
+			_ = rsConn.Disconnect(context.Background())
+		}
+	}
+}
+
+// connectReplset connects to the replset rs of hosts with the credentials
+// and options of the node uri.
+func connectReplset(ctx context.Context, uri, rs string, hosts []string) (*mongo.Client, error) {
+	rsURI, err := connect.HostsURI(uri, hosts, "directConnection", "connect", "replicaSet")
+	if err != nil {
+		return nil, errors.Wrap(err, "parse uri")
+	}
+	curi, err := url.Parse(rsURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse uri")
+	}
+
+	query := curi.Query()
+	query.Set("replicaSet", rs)
+	curi.RawQuery = query.Encode()
+
+	return connect.MongoConnect(ctx, curi.String(), connect.AppName("pbm-agent"), connect.Direct(false))
+}
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"path"
@@ -10,6 +11,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	sfs "github.com/percona/percona-backup-mongodb/pbm/storage/fs"
 	"github.com/percona/percona-backup-mongodb/pbm/util"
@@ -41,12 +43,20 @@ func ReadMetadata(stg storage.Storage, filename string) (*BackupMeta, error) {
 	return meta, nil
 }
 
+// CheckBackupDataFiles checks that the data files of the backup exist
+// and aren't empty. The files of the backups made by newer versions must
+// have the integrity markers (on the filesystem storage), the file
+// without the marker is possibly truncated.
 func CheckBackupDataFiles(ctx context.Context, stg storage.Storage, bcp *BackupMeta) error {
+	return checkBackupDataFiles(ctx, stg, bcp, bcp.HasIntegrityMarkers())
+}
+
+func checkBackupDataFiles(ctx context.Context, stg storage.Storage, bcp *BackupMeta, markers bool) error {
 	switch bcp.Type {
 	case defs.LogicalBackup:
-		return checkLogicalBackupDataFiles(ctx, stg, bcp)
+		return checkLogicalBackupDataFiles(ctx, stg, bcp, markers)
 	case defs.PhysicalBackup, defs.IncrementalBackup:
-		return checkPhysicalBackupDataFiles(ctx, stg, bcp)
+		return checkPhysicalBackupDataFiles(ctx, stg, bcp, markers)
 	case defs.ExternalBackup:
 		return nil // no files available
 	}
@@ -54,17 +64,40 @@ func CheckBackupDataFiles(ctx context.Context, stg storage.Storage, bcp *BackupM
 	return errors.Errorf("unknown backup type %s", bcp.Type)
 }
 
-func checkLogicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *BackupMeta) error {
-	legacy := version.IsLegacyArchive(bcp.PBMVersion)
+// replsetStorage returns the storage of the replset data.
+// It's stg unless the replset had the storage override.
+func replsetStorage(ctx context.Context, stg storage.Storage, rs *BackupReplset) (storage.Storage, error) {
+	if rs.Store == nil {
+		return stg, nil
+	}
+
+	rstg, err := util.StorageFromConfig(&rs.Store.StorageConf, "", log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get storage %q of replset %s", rs.Store.Name, rs.Name)
+	}
+	return rstg, nil
+}
+
+func checkLogicalBackupDataFiles(ctx context.Context, bstg storage.Storage, bcp *BackupMeta, markers bool) error {
+	legacy := bcp.IsLegacyArchive()
 
 	eg := util.NewErrorGroup(runtime.NumCPU() * 2)
 	for _, rs := range bcp.Replsets {
 		eg.Go(func() error {
-			eg.Go(func() error { return checkFile(stg, rs.DumpName) })
+			stg, err := replsetStorage(ctx, bstg, &rs)
+			if err != nil {
+				return err
+			}
+
+			eg.Go(func() error { return checkFile(stg, rs.DumpName, markers) })
 
 			eg.Go(func() error {
-				if version.IsLegacyBackupOplog(bcp.PBMVersion) {
-					return checkFile(stg, rs.OplogName)
+				if bcp.IsLegacyBackupOplog() {
+					if rs.OplogName == "" {
+						// imported mongodump archive
+						return nil
+					}
+					return checkFile(stg, rs.OplogName, markers)
 				}
 
 				files, err := stg.List(rs.OplogName, "")
@@ -75,8 +108,14 @@ func checkLogicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *Ba
 					return errors.Wrap(err, "no oplog files")
 				}
 				for i := range files {
+					name := path.Join(rs.OplogName, files[i].Name)
 					if files[i].Size == 0 {
-						return errors.Errorf("%q is empty", path.Join(rs.OplogName, files[i].Name))
+						return errors.Errorf("%q is empty", name)
+					}
+					if markers {
+						if err := storage.VerifyIntegrity(stg, name); err != nil {
+							return errors.Wrapf(err, "file %q", name)
+						}
 					}
 				}
 
@@ -100,7 +139,7 @@ func checkLogicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *Ba
 				ns := archive.NSify(ns.Database, ns.Collection)
 				f := path.Join(bcp.Name, rs.Name, ns+bcp.Compression.Suffix())
 
-				eg.Go(func() error { return checkFile(stg, f) })
+				eg.Go(func() error { return checkFile(stg, f, markers) })
 			}
 
 			return nil
@@ -111,10 +150,15 @@ func checkLogicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *Ba
 	return errors.Join(errs...)
 }
 
-func checkPhysicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *BackupMeta) error {
+func checkPhysicalBackupDataFiles(ctx context.Context, bstg storage.Storage, bcp *BackupMeta, markers bool) error {
 	eg := util.NewErrorGroup(runtime.NumCPU() * 2)
 	for _, rs := range bcp.Replsets {
 		eg.Go(func() error {
+			stg, err := replsetStorage(ctx, bstg, &rs)
+			if err != nil {
+				return err
+			}
+
 			var filelist Filelist
 			if version.HasFilelistFile(bcp.PBMVersion) {
 				var err error
@@ -143,6 +187,12 @@ func checkPhysicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *B
 					if stat.Size == 0 {
 						return errors.Errorf("empty file %s", filepath)
 					}
+					if markers {
+						err = storage.VerifyIntegrity(stg, filepath)
+						if err != nil {
+							return errors.Wrapf(err, "file %s", filepath)
+						}
+					}
 
 					return nil
 				})
@@ -156,6 +206,101 @@ func checkPhysicalBackupDataFiles(_ context.Context, stg storage.Storage, bcp *B
 	return errors.Join(errs...)
 }
 
+// saveChecksums saves the checksums of the replset files to the storage
+// and returns the file name. Empty if there are no checksums.
+func saveChecksums(stg storage.Storage, bcpName, rsName string, sums map[string]string) (string, error) {
+	if len(sums) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(sums)
+	if err != nil {
+		return "", errors.Wrap(err, "encode")
+	}
+
+	name := path.Join(bcpName, rsName, ChecksumsName)
+	err = stg.Save(name, bytes.NewReader(data), int64(len(data)))
+	return name, errors.Wrapf(err, "save %q", name)
+}
+
+// ReadChecksums returns the checksums of the replset files by the storage
+// file name (see BackupReplset.ChecksumsFile). Nil if there are none.
+func ReadChecksums(stg storage.Storage, rs *BackupReplset) (map[string]string, error) {
+	if rs.ChecksumsFile == "" {
+		return nil, nil
+	}
+
+	r, err := stg.SourceReader(rs.ChecksumsFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %q", rs.ChecksumsFile)
+	}
+	defer r.Close()
+
+	sums := make(map[string]string)
+	err = json.NewDecoder(r).Decode(&sums)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %q", rs.ChecksumsFile)
+	}
+	return sums, nil
+}
+
+// saveNSStats saves the namespaces stats of the replset to the storage
+// and returns the file name. The file is saved for no namespaces as well,
+// so such replset isn't taken for one made by older versions.
+func saveNSStats(stg storage.Storage, bcpName, rsName string, stats []NSStat) (string, error) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return "", errors.Wrap(err, "encode")
+	}
+
+	name := path.Join(bcpName, rsName, NSStatsName)
+	err = stg.Save(name, bytes.NewReader(data), int64(len(data)))
+	return name, errors.Wrapf(err, "save %q", name)
+}
+
+// ReadNSStats returns the namespaces stats of the replset by the storage
+// file name (see BackupReplset.NSStatsFile). Nil if there are none.
+func ReadNSStats(stg storage.Storage, rs *BackupReplset) ([]NSStat, error) {
+	if rs.NSStatsFile == "" {
+		return nil, nil
+	}
+
+	r, err := stg.SourceReader(rs.NSStatsFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %q", rs.NSStatsFile)
+	}
+	defer r.Close()
+
+	stats := []NSStat{}
+	err = json.NewDecoder(r).Decode(&stats)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %q", rs.NSStatsFile)
+	}
+	return stats, nil
+}
+
+// LoadNSStats reads the namespaces stats of the backup replsets from
+// their storages into BackupReplset.NSStats.
+func LoadNSStats(bcp *BackupMeta, node string, l log.LogEvent) error {
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		if rs.NSStatsFile == "" || rs.NSStats != nil {
+			continue
+		}
+
+		stg, err := util.StorageFromConfig(&bcp.RSStorage(rs.Name).StorageConf, node, l)
+		if err != nil {
+			return errors.Wrapf(err, "get storage of %s", rs.Name)
+		}
+		rs.NSStats, err = ReadNSStats(stg, rs)
+		if err != nil {
+			return errors.Wrap(err, rs.Name)
+		}
+	}
+
+	return nil
+}
+
 func ReadFilelistForReplset(stg storage.Storage, bcpName, rsName string) (Filelist, error) {
 	pfFilepath := path.Join(bcpName, rsName, FilelistName)
 	rdr, err := stg.SourceReader(pfFilepath)
@@ -187,7 +332,9 @@ func ReadArchiveNamespaces(stg storage.Storage, metafile string) ([]*archive.Nam
 	return meta.Namespaces, nil
 }
 
-func checkFile(stg storage.Storage, filename string) error {
+// checkFile checks that the file exists and isn't empty. With markers,
+// the file must also have the integrity marker (see storage.VerifyIntegrity).
+func checkFile(stg storage.Storage, filename string, markers bool) error {
 	f, err := stg.FileStat(filename)
 	if err != nil {
 		return errors.Wrapf(err, "file %q", filename)
@@ -195,13 +342,23 @@ func checkFile(stg storage.Storage, filename string) error {
 	if f.Size == 0 {
 		return errors.Errorf("%q is empty", filename)
 	}
+	if markers {
+		err = storage.VerifyIntegrity(stg, filename)
+		if err != nil {
+			return errors.Wrapf(err, "file %q", filename)
+		}
+	}
 
 	return nil
 }
 
 // DeleteBackupFiles removes backup's artifacts from storage
 func DeleteBackupFiles(stg storage.Storage, backupName string) error {
-	if fs, ok := stg.(*sfs.FS); ok {
+	if storage.IsReadOnly(stg) {
+		// deleteBackupFromFS works on the unwrapped storage
+		return errors.Wrapf(storage.ErrReadOnly, "delete %s", backupName)
+	}
+	if fs, ok := storage.Unwrap(stg).(*sfs.FS); ok {
 		return deleteBackupFromFS(fs, backupName)
 	}
 
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCheckBackupDataFilesMarkers(t *testing.T) {
+	root := t.TempDir()
+	stg, err := fs.New(&fs.Config{Path: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(name string, b []byte) {
+		t.Helper()
+		if err := stg.Save(name, bytes.NewReader(b), int64(len(b))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("bcp/rs0/metadata.json", []byte(`{"namespaces":[{"db":"db","collection":"c","size":3072}]}`))
+	save("bcp/rs0/db.c", bytes.Repeat([]byte("pbm"), 1<<10))
+	save("bcp/rs0/oplog/20240501100000-1.20240501100100-1", []byte("oplog"))
+
+	files, err := stg.List("bcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("markers should be hidden, got %v", files)
+	}
+
+	bcp := &BackupMeta{
+		Name:             "bcp",
+		Type:             defs.LogicalBackup,
+		PBMVersion:       "2.8.0",
+		IntegrityMarkers: true,
+		Compression:      compress.CompressionTypeNone,
+		Replsets: []BackupReplset{{
+			Name:      "rs0",
+			DumpName:  "bcp/rs0/metadata.json",
+			OplogName: "bcp/rs0/oplog",
+		}},
+	}
+	ctx := context.Background()
+	if err := CheckBackupDataFiles(ctx, stg, bcp); err != nil {
+		t.Fatalf("valid backup: %v", err)
+	}
+
+	data := filepath.Join(root, "bcp/rs0/db.c")
+	if err := os.Truncate(data, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBackupDataFiles(ctx, stg, bcp); !errors.Is(err, storage.ErrTruncated) {
+		t.Errorf("truncated file: expected ErrTruncated, got %v", err)
+	}
+
+	save("bcp/rs0/db.c", bytes.Repeat([]byte("pbm"), 1<<10))
+	if err := os.Remove(data + ".pbm.ok"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBackupDataFiles(ctx, stg, bcp); !errors.Is(err, storage.ErrTruncated) {
+		t.Errorf("no marker: expected ErrTruncated, got %v", err)
+	}
+
+	bcp.IntegrityMarkers = false
+	if err := CheckBackupDataFiles(ctx, stg, bcp); err != nil {
+		t.Errorf("older backup without marker: %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFSOverwriteMarker(t *testing.T) {
+	root := t.TempDir()
+	stg, err := fs.New(&fs.Config{Path: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "bcp/rs0/db.c"
+	short := []byte("pbm")
+	if err := stg.Save(name, bytes.NewReader(short), int64(len(short))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed overwrite keeps the file with its marker
+	data := bytes.Repeat([]byte("pbm"), 1<<10)
+	r := io.MultiReader(bytes.NewReader(data), failingReader{})
+	if err := stg.Save(name, r, int64(len(data))); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	if err := stg.VerifyIntegrity(name); err != nil {
+		t.Errorf("failed overwrite: %v", err)
+	}
+
+	if err := stg.Save(name, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	inf, err := stg.FileStat(name)
+	if err != nil {
+		t.Fatalf("overwritten file: %v", err)
+	}
+	if inf.Size != int64(len(data)) {
+		t.Errorf("size %d, expected %d", inf.Size, len(data))
+	}
+	if err := stg.VerifyIntegrity(name); err != nil {
+		t.Errorf("overwritten file: %v", err)
+	}
+}
+
+func TestFSIntegrityMarker(t *testing.T) {
+	root := t.TempDir()
+	stg, err := fs.New(&fs.Config{Path: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "bcp/rs0/db.c"
+	data := bytes.Repeat([]byte("pbm"), 1<<10)
+	if err := stg.Save(name, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	// same size, another content
+	broken := append([]byte{}, data...)
+	broken[10] ^= 0xff
+	if err := os.WriteFile(filepath.Join(root, name), broken, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(r)
+	r.Close()
+	if !errors.Is(err, storage.ErrTruncated) {
+		t.Errorf("checksum mismatch: expected ErrTruncated, got %v", err)
+	}
+
+	if err := stg.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, name+".pbm.ok")); !os.IsNotExist(err) {
+		t.Errorf("marker is left after delete: %v", err)
+	}
+}
+
+func TestNSStatsFile(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		stats []NSStat
+	}{
+		{"no collections", []NSStat{}},
+		{"collections", []NSStat{{NS: "db.a", Docs: 10, Size: 100}, {NS: "db.b", Docs: 1, Size: 10}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := saveNSStats(stg, "bcp", tt.name, tt.stats)
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			if file != "bcp/"+tt.name+"/"+NSStatsName {
+				t.Errorf("unexpected file %q", file)
+			}
+
+			got, err := ReadNSStats(stg, &BackupReplset{NSStatsFile: file})
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if got == nil {
+				t.Fatal("expected stats, got nil")
+			}
+			if len(got) != len(tt.stats) {
+				t.Fatalf("expected %v, got %v", tt.stats, got)
+			}
+			for i := range got {
+				if got[i] != tt.stats[i] {
+					t.Errorf("expected %v, got %v", tt.stats[i], got[i])
+				}
+			}
+		})
+	}
+
+	got, err := ReadNSStats(stg, &BackupReplset{})
+	if err != nil || got != nil {
+		t.Errorf("no file: expected nil, got %v, %v", got, err)
+	}
+}
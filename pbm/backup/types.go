@@ -5,6 +5,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,9 +15,12 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
 )
 
 // BackupMeta is a backup's metadata
@@ -39,7 +45,16 @@ type BackupMeta struct {
 	// If all shard names are the same as their replset names, the map is nil.
 	ShardRemap map[string]string `bson:"shardRemap,omitempty" json:"shardRemap,omitempty"`
 
+	// SingleRS is the name of the replset if this is the backup of the single
+	// replset of the cluster (partial backup). Empty for the whole cluster.
+	SingleRS string `bson:"single_rs,omitempty" json:"single_rs,omitempty"`
+
+	// CompressionAuto is the benchmark result of `compression: auto`
+	// which chose the Compression.
+	CompressionAuto *compress.AutoChoice `bson:"compressionAuto,omitempty" json:"compressionAuto,omitempty"`
+
 	Namespaces       []string                 `bson:"nss,omitempty" json:"nss,omitempty"`
+	Labels           map[string]string        `bson:"labels,omitempty" json:"labels,omitempty"`
 	Replsets         []BackupReplset          `bson:"replsets" json:"replsets"`
 	Compression      compress.CompressionType `bson:"compression" json:"compression"`
 	Store            Storage                  `bson:"store" json:"store"`
@@ -55,9 +70,173 @@ type BackupMeta struct {
 	Conditions       []Condition              `bson:"conditions" json:"conditions"`
 	Nomination       []BackupRsNomination     `bson:"n" json:"n"`
 	Err              string                   `bson:"error,omitempty" json:"error,omitempty"`
-	PBMVersion       string                   `bson:"pbm_version" json:"pbm_version"`
-	BalancerStatus   topo.BalancerMode        `bson:"balancer" json:"balancer"`
-	runtimeError     error
+	// ErrCode is the kind of the failure if it's known
+	ErrCode    errors.Code `bson:"error_code,omitempty" json:"error_code,omitempty"`
+	PBMVersion string      `bson:"pbm_version" json:"pbm_version"`
+	// IntegrityMarkers is true if the files of the backup are saved with
+	// their integrity markers on the filesystem storage
+	IntegrityMarkers bool              `bson:"integrity_markers,omitempty" json:"integrity_markers,omitempty"`
+	BalancerStatus   topo.BalancerMode `bson:"balancer" json:"balancer"`
+	// ClusterID is the id of the cluster the backup is made on
+	ClusterID string `bson:"cluster_id,omitempty" json:"cluster_id,omitempty"`
+
+	// Provenance is set if the metadata wasn't created by a backup run
+	// of this cluster but imported from the storage.
+	Provenance *Provenance `bson:"provenance,omitempty" json:"provenance,omitempty"`
+
+	// ClusterTime is the requested cluster time the backup has to cover
+	// (see `pbm backup --wait-for-cluster-time`). Nil if not requested.
+	ClusterTime *ClusterTimeWait `bson:"cluster_time,omitempty" json:"cluster_time,omitempty"`
+
+	// Initiator is who has started the backup.
+	// Nil for the backups started by older clients.
+	Initiator *ctrl.Initiator `bson:"initiator,omitempty" json:"initiator,omitempty"`
+
+	// MaxDurationSec is how long the backup may run before it is aborted
+	// (see `pbm backup --max-duration`). Zero means no limit.
+	MaxDurationSec int64 `bson:"max_duration_sec,omitempty" json:"max_duration_sec,omitempty"`
+
+	// Consistency is the result of the consistency check of the sharded
+	// logical backup. Nil if the check wasn't run.
+	Consistency *ConsistencyCheck `bson:"consistency,omitempty" json:"consistency,omitempty"`
+
+	// Warnings are the problems found with the done backup, e.g. the
+	// discrepancies of the consistency check.
+	Warnings []string `bson:"warnings,omitempty" json:"warnings,omitempty"`
+
+	// ExcludedDBs are the databases which data files the physical backup
+	// hasn't copied (see `backup.physical.excludeDatabases`). The backup
+	// is partial and isn't a base for point-in-time recovery.
+	ExcludedDBs []string `bson:"excluded_dbs,omitempty" json:"excluded_dbs,omitempty"`
+
+	// ShardedColls are the sharded collections of the cluster at the
+	// backup start. The restores check the sharding of the cluster
+	// against them. Empty for non-sharded clusters and older backups.
+	ShardedColls []topo.ShardedColl `bson:"sharded_colls,omitempty" json:"sharded_colls,omitempty"`
+
+	// Verification is the result of the last restore of the backup into
+	// a temporary mongod. Nil if the backup hasn't been verified.
+	Verification *RestoreVerification `bson:"verification,omitempty" json:"verification,omitempty"`
+
+	// Hold protects the backup from the deletion (see `pbm backup hold`).
+	// Nil if the backup isn't on hold.
+	Hold *BackupHold `bson:"hold,omitempty" json:"hold,omitempty"`
+
+	// Orphaned is set if the backup is orphaned since the storage is
+	// changed (see defs.StatusOrphaned).
+	Orphaned *Orphaned `bson:"orphaned,omitempty" json:"orphaned,omitempty"`
+
+	// MinPBMVersion is the lowest PBM version restoring the backup. Set
+	// once the backup is done, empty for older backups (see RequiredPBM).
+	MinPBMVersion string `bson:"min_pbm_version,omitempty" json:"min_pbm_version,omitempty"`
+	// RestoreFeatures are the format features of the backup which raised
+	// the MinPBMVersion.
+	RestoreFeatures []version.RestoreFeature `bson:"restore_features,omitempty" json:"restore_features,omitempty"`
+
+	runtimeError error
+}
+
+const (
+	// ProvenanceResync is the source of backups imported by the periodic
+	// reconciliation with the storage.
+	ProvenanceResync = "resync"
+	// ProvenanceImport is the source of backups registered by
+	// `pbm backup import`. Their files are out of the storage root,
+	// so the storage sync keeps their metadata.
+	ProvenanceImport = "import"
+)
+
+// Formats of imported backups
+const (
+	ImportFormatPBM       = "pbm"
+	ImportFormatMongodump = "mongodump"
+)
+
+// Provenance tells where the backup metadata comes from.
+type Provenance struct {
+	Source string `bson:"source" json:"source"`
+	Node   string `bson:"node,omitempty" json:"node,omitempty"`
+	// Time is when the metadata was imported (unix seconds).
+	Time int64 `bson:"time" json:"time"`
+
+	// Format is the format of the imported backup data
+	Format string `bson:"format,omitempty" json:"format,omitempty"`
+	// Prefix is the storage folder of the imported backup
+	Prefix string `bson:"prefix,omitempty" json:"prefix,omitempty"`
+	// Cluster is the identity of the cluster the imported backup was made on
+	Cluster string `bson:"cluster,omitempty" json:"cluster,omitempty"`
+}
+
+// IsImported returns true if the backup is registered by `pbm backup import`
+func (b *BackupMeta) IsImported() bool {
+	return b.Provenance != nil && b.Provenance.Source == ProvenanceImport
+}
+
+// IsMongodump returns true if the backup is imported from mongodump archives
+func (b *BackupMeta) IsMongodump() bool {
+	return b.IsImported() && b.Provenance.Format == ImportFormatMongodump
+}
+
+// IsLegacyArchive returns true if the replset data of the logical backup is
+// a single mongodump archive: the backup is made by PBM before v2.0 or
+// imported from mongodump.
+func (b *BackupMeta) IsLegacyArchive() bool {
+	return b.IsMongodump() || version.IsLegacyArchive(b.PBMVersion)
+}
+
+// IsLegacyBackupOplog returns true if the oplog of the logical backup
+// replset is a single file (PBM before v2.4) or there's none (mongodump).
+func (b *BackupMeta) IsLegacyBackupOplog() bool {
+	return b.IsMongodump() || version.IsLegacyBackupOplog(b.PBMVersion)
+}
+
+// HasIntegrityMarkers returns true if the data files of the backup must
+// have the integrity markers on the filesystem storage. Imported backups
+// are copied to the storage by the user and have none.
+func (b *BackupMeta) HasIntegrityMarkers() bool {
+	return !b.IsImported() && b.IntegrityMarkers
+}
+
+// IsPITRBase returns true if the backup has all the data of the cluster, so
+// the oplog can be replayed on top of it. Selective, single-replset and
+// physical backups with excluded databases are partial.
+func (b *BackupMeta) IsPITRBase() bool {
+	return len(b.Namespaces) == 0 && b.SingleRS == "" && len(b.ExcludedDBs) == 0
+}
+
+// IsReadOnly returns true if any storage of the backup is read-only
+func (b *BackupMeta) IsReadOnly() bool {
+	for _, s := range b.Storages() {
+		if s.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// IsForeign returns true if the backup is made on another cluster:
+// imported or read from the read-only storage of the other cluster.
+func (b *BackupMeta) IsForeign() bool {
+	return b.IsImported() || b.IsReadOnly()
+}
+
+// SourceCluster returns the identity of the cluster the backup is made on.
+// It's the cluster set on import, the cluster id or the sorted replset
+// names of the backup (for backups made before PBM kept the cluster id).
+func (b *BackupMeta) SourceCluster() string {
+	if b.Provenance != nil && b.Provenance.Cluster != "" {
+		return b.Provenance.Cluster
+	}
+	if b.ClusterID != "" {
+		return b.ClusterID
+	}
+
+	rss := make([]string, 0, len(b.Replsets))
+	for _, rs := range b.Replsets {
+		rss = append(rss, rs.Name)
+	}
+	slices.Sort(rss)
+	return strings.Join(rss, ",")
 }
 
 func (b *BackupMeta) Error() error {
@@ -65,12 +244,26 @@ func (b *BackupMeta) Error() error {
 	case b.runtimeError != nil:
 		return b.runtimeError
 	case b.Err != "":
-		return errors.New(b.Err)
+		return errors.WithCode(errors.New(b.Err), b.FailureCode())
 	default:
 		return nil
 	}
 }
 
+// FailureCode returns the code of the backup failure or the first one
+// of the failed replsets. "" if unknown.
+func (b *BackupMeta) FailureCode() errors.Code {
+	if b.ErrCode != "" {
+		return b.ErrCode
+	}
+	for i := range b.Replsets {
+		if b.Replsets[i].ErrCode != "" {
+			return b.Replsets[i].ErrCode
+		}
+	}
+	return ""
+}
+
 func (b *BackupMeta) SetRuntimeError(err error) {
 	b.runtimeError = err
 	b.Status = defs.StatusError
@@ -87,6 +280,33 @@ func (b *BackupMeta) RS(name string) *BackupReplset {
 	return nil
 }
 
+// RSStorage returns the storage the replset data was stored on.
+// It's the backup storage unless the replset had the storage override.
+func (b *BackupMeta) RSStorage(name string) *Storage {
+	for i := range b.Replsets {
+		if rs := &b.Replsets[i]; rs.Name == name && rs.Store != nil {
+			return rs.Store
+		}
+	}
+	return &b.Store
+}
+
+// Storages returns all storages the backup data is on.
+// The backup storage (with the metadata) goes first.
+func (b *BackupMeta) Storages() []*Storage {
+	rv := []*Storage{&b.Store}
+	seen := map[string]bool{b.Store.Name: true}
+	for i := range b.Replsets {
+		st := b.Replsets[i].Store
+		if st == nil || seen[st.Name] {
+			continue
+		}
+		seen[st.Name] = true
+		rv = append(rv, st)
+	}
+	return rv
+}
+
 // Storage keeps storage configuration used during backup.
 //
 // If external configuration is used, IsProfile is `true` and Name is set.
@@ -124,9 +344,14 @@ type BackupReplset struct {
 	LastWriteTS      primitive.Timestamp `bson:"last_write_ts" json:"last_write_ts"`
 	Node             string              `bson:"node" json:"node"` // node that performed backup
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
+	ErrCode          errors.Code         `bson:"error_code,omitempty" json:"error_code,omitempty"`
 	Conditions       []Condition         `bson:"conditions" json:"conditions"`
 	MongodOpts       *topo.MongodOpts    `bson:"mongod_opts,omitempty" json:"mongod_opts,omitempty"`
 
+	// Store is the storage of the replset data if it's not the backup storage
+	// (see `replsets.<rs>.storage` option). Nil for the backup storage.
+	Store *Storage `bson:"store,omitempty" json:"store,omitempty"`
+
 	// required for external backup (PBM-1252)
 	PBMVersion   string `bson:"pbm_version,omitempty" json:"pbm_version,omitempty"`
 	MongoVersion string `bson:"mongo_version,omitempty" json:"mongo_version,omitempty"`
@@ -134,6 +359,116 @@ type BackupReplset struct {
 	// CustomThisID is customized thisBackupName value for $backupCursor (in WT: "this_id").
 	// If it is not set (empty), the default value was used.
 	CustomThisID string `bson:"this_id,omitempty" json:"this_id,omitempty"`
+
+	// QuiesceWaitMS is time (in milliseconds) spent on the node quiescing
+	// before opening the backup cursor. See `backup.quiesce` option.
+	QuiesceWaitMS int64 `bson:"quiesce_wait_ms,omitempty" json:"quiesce_wait_ms,omitempty"`
+
+	// ExcludedSize is the size of the data files skipped by the physical
+	// backup with excluded databases (see BackupMeta.ExcludedDBs).
+	ExcludedSize int64 `bson:"excluded_size,omitempty" json:"excluded_size,omitempty"`
+
+	// Progress of the data transfer. Updated while the backup is running.
+	Progress *RSProgress `bson:"progress,omitempty" json:"progress,omitempty"`
+
+	// Transfers are the upload statistics of the replset files.
+	// Saved when the replset is done or failed.
+	Transfers []storage.TransferStats `bson:"transfers,omitempty" json:"transfers,omitempty"`
+
+	// ChecksumsFile is the storage file with the SHA-256 checksums of the
+	// replset files (see ChecksumsName). Empty for older backups.
+	ChecksumsFile string `bson:"checksums_file,omitempty" json:"checksums_file,omitempty"`
+
+	// NSStatsFile is the storage file with the stats of dumped namespaces
+	// (see NSStatsName). Logical backups only, empty for older backups.
+	NSStatsFile string `bson:"ns_stats_file,omitempty" json:"ns_stats_file,omitempty"`
+
+	// NSStats are stats of dumped namespaces read from NSStatsFile
+	// (see LoadNSStats). A replset with no collections has empty stats,
+	// nil means there are no stats.
+	NSStats []NSStat `bson:"-" json:"-"`
+
+	// NSSnapshot are the collections of the replset with their estimated
+	// documents count at the backup start. Set for the consistency check of
+	// sharded logical backups only.
+	NSSnapshot []NSStat `bson:"ns_snapshot,omitempty" json:"ns_snapshot,omitempty"`
+
+	// CollOptions are the listCollections options of the dumped collections
+	// (logical backups only). The restore re-asserts them after the data load.
+	// It is empty for backups made by older versions.
+	CollOptions []CollOptions `bson:"coll_options,omitempty" json:"coll_options,omitempty"`
+
+	// DumpSource is the node the logical backup data is dumped from.
+	// It is empty for backups made by older versions.
+	DumpSource *DumpSource `bson:"dump_source,omitempty" json:"dump_source,omitempty"`
+}
+
+// ClusterTimeWait is the extension of the backup oplog capture up to
+// the requested cluster time
+type ClusterTimeWait struct {
+	Target     primitive.Timestamp `bson:"target" json:"target"`
+	TimeoutSec int64               `bson:"timeout_sec" json:"timeout_sec"`
+	// Reached is set if the backup covers the target. It is false if
+	// the timeout expired before (or the backup isn't done yet).
+	Reached bool `bson:"reached" json:"reached"`
+}
+
+func (w *ClusterTimeWait) String() string {
+	s := fmt.Sprintf("%d,%d (%s)", w.Target.T, w.Target.I,
+		time.Unix(int64(w.Target.T), 0).UTC().Format(time.RFC3339))
+	if w.Reached {
+		return s + " reached"
+	}
+	return s + fmt.Sprintf(" not reached within %ds", w.TimeoutSec)
+}
+
+// DumpSource describes the node serving the logical backup dump
+type DumpSource struct {
+	Node   string            `bson:"node" json:"node"`
+	State  string            `bson:"state" json:"state"`
+	Hidden bool              `bson:"hidden,omitempty" json:"hidden,omitempty"`
+	Tags   map[string]string `bson:"tags,omitempty" json:"tags,omitempty"`
+	// ReadPref is the configured read preference the node is selected by.
+	// Empty if not configured.
+	ReadPref string `bson:"read_pref,omitempty" json:"read_pref,omitempty"`
+}
+
+// String returns e.g. `rs03:27017 (SECONDARY, hidden, dc=backup) by secondary(tagSet=dc=backup)`
+func (s *DumpSource) String() string {
+	attrs := []string{s.State}
+	if s.Hidden {
+		attrs = append(attrs, "hidden")
+	}
+	tags := make([]string, 0, len(s.Tags))
+	for k, v := range s.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	slices.Sort(tags)
+	attrs = append(attrs, tags...)
+
+	rv := s.Node + " (" + strings.Join(attrs, ", ") + ")"
+	if s.ReadPref != "" {
+		rv += " by " + s.ReadPref
+	}
+	return rv
+}
+
+// NSStat is the namespace stats recorded during the logical backup.
+type NSStat struct {
+	NS   string `bson:"ns" json:"ns"`
+	Docs int64  `bson:"docs" json:"docs"`
+	// Size is the uncompressed size of dumped documents in bytes
+	Size int64 `bson:"size" json:"size"`
+}
+
+// CollOptions are the options of the collection as listCollections
+// returns them.
+type CollOptions struct {
+	NS string `bson:"ns" json:"ns"`
+	// Options is the canonical extended JSON of the options, the same
+	// way the archive metadata keeps them. So they survive the JSON
+	// metadata on the storage as is.
+	Options string `bson:"options" json:"options"`
 }
 
 type Condition struct {
@@ -181,6 +516,17 @@ func (f *File) WriteTo(w io.Writer) (int64, error) {
 	return io.Copy(w, io.NewSectionReader(fd, f.Off, f.Len))
 }
 
+// ChecksumsName is the name of the file with the checksums of the replset
+// files of the backup. The file is a JSON object of the SHA-256 checksums
+// by the storage file name.
+const ChecksumsName = "checksums.json"
+
+// NSStatsName is the name of the file with the stats of dumped namespaces
+// of the replset. The file is a JSON array of NSStat. The stats are kept
+// out of the backup metadata, as thousands of collections may not fit
+// a document.
+const NSStatsName = "ns_stats.json"
+
 // FilelistName is filename that is used to store list of files for physical backup
 const FilelistName = "filelist.pbm"
 
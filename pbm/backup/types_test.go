@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestRSStorage(t *testing.T) {
+	eu := &Storage{Name: "eu", IsProfile: true}
+	bcp := &BackupMeta{
+		Store: Storage{},
+		Replsets: []BackupReplset{
+			{Name: "rs0"},
+			{Name: "rs1", Store: eu},
+			{Name: "rs2", Store: eu},
+		},
+	}
+
+	if got := bcp.RSStorage("rs0"); got != &bcp.Store {
+		t.Errorf("rs0: expected the backup storage, got %+v", got)
+	}
+	if got := bcp.RSStorage("rs1"); got != eu {
+		t.Errorf("rs1: expected the replset storage, got %+v", got)
+	}
+	if got := bcp.RSStorage("rs3"); got != &bcp.Store {
+		t.Errorf("unknown replset: expected the backup storage, got %+v", got)
+	}
+
+	stgs := bcp.Storages()
+	if len(stgs) != 2 || stgs[0] != &bcp.Store || stgs[1] != eu {
+		t.Errorf("storages: got %+v", stgs)
+	}
+}
+
+func TestBackupIsForeign(t *testing.T) {
+	ro := &Storage{Name: "prod", IsProfile: true}
+	ro.ReadOnly = true
+
+	bcp := &BackupMeta{
+		Store:    Storage{},
+		Replsets: []BackupReplset{{Name: "rs1"}, {Name: "cfg"}},
+	}
+	if bcp.IsForeign() {
+		t.Error("own backup is foreign")
+	}
+	if got := bcp.SourceCluster(); got != "cfg,rs1" {
+		t.Errorf("source cluster: got %q", got)
+	}
+
+	bcp.ClusterID = "65f1c0e2b4a1d2e3f4a5b6c7"
+	if got := bcp.SourceCluster(); got != bcp.ClusterID {
+		t.Errorf("source cluster: got %q", got)
+	}
+
+	bcp.Replsets[1].Store = ro
+	if !bcp.IsReadOnly() || !bcp.IsForeign() {
+		t.Error("backup with the replset on read-only storage is not foreign")
+	}
+
+	bcp.Replsets[1].Store = nil
+	bcp.Provenance = &Provenance{Source: ProvenanceImport, Cluster: "prod"}
+	if !bcp.IsForeign() || bcp.IsReadOnly() {
+		t.Error("imported backup is not foreign")
+	}
+	if got := bcp.SourceCluster(); got != "prod" {
+		t.Errorf("source cluster: got %q", got)
+	}
+}
+
+func TestBackupFailureCode(t *testing.T) {
+	bcp := &BackupMeta{
+		Err:      "convergeCluster: rs1 failed",
+		Replsets: []BackupReplset{{Name: "cfg"}, {Name: "rs1", ErrCode: errors.CodeStorageUnreachable}},
+	}
+	if code := errors.CodeOf(bcp.Error()); code != errors.CodeStorageUnreachable {
+		t.Errorf("replset code: got %q", code)
+	}
+
+	bcp.ErrCode = errors.CodeLockHeld
+	if code := errors.CodeOf(errors.Wrap(bcp.Error(), "backup")); code != errors.CodeLockHeld {
+		t.Errorf("backup code: got %q", code)
+	}
+	if bcp.Error().Error() != bcp.Err {
+		t.Errorf("message: got %q", bcp.Error())
+	}
+}
+
+func TestClusterTimeWaitString(t *testing.T) {
+	w := ClusterTimeWait{Target: primitive.Timestamp{T: 1700000000, I: 3}, TimeoutSec: 600}
+	if got, want := w.String(), "1700000000,3 (2023-11-14T22:13:20Z) not reached within 600s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	w.Reached = true
+	if got, want := w.String(), "1700000000,3 (2023-11-14T22:13:20Z) reached"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
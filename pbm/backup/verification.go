@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+)
+
+// RestoreVerification is the result of the restore of the backup into
+// a temporary mongod (see `pbm backup verify-restore`).
+type RestoreVerification struct {
+	OPID string `bson:"opid" json:"opid"`
+	// Node is the agent that has run the verification
+	Node   string      `bson:"node" json:"node"`
+	Status defs.Status `bson:"status" json:"status"`
+	Error  string      `bson:"error,omitempty" json:"error,omitempty"`
+	// StartTS and FinishTS are unix seconds
+	StartTS  int64 `bson:"start_ts" json:"start_ts"`
+	FinishTS int64 `bson:"finish_ts,omitempty" json:"finish_ts,omitempty"`
+	// Sample is the requested number of random collections.
+	// Zero means all collections of the backup.
+	Sample int `bson:"sample,omitempty" json:"sample,omitempty"`
+	// Collections is the number of verified collections
+	Collections int `bson:"collections" json:"collections"`
+	// Issues are the mismatches of the restored collections
+	// with the backup metadata
+	Issues []ConsistencyIssue `bson:"issues,omitempty" json:"issues,omitempty"`
+}
+
+// OK returns true if the verification is done without mismatches
+func (v *RestoreVerification) OK() bool {
+	return v.Status == defs.StatusDone && len(v.Issues) == 0
+}
+
+func (v *RestoreVerification) String() string {
+	at := time.Unix(v.StartTS, 0).UTC().Format(time.RFC3339)
+	switch v.Status {
+	case defs.StatusDone:
+	case defs.StatusError:
+		return fmt.Sprintf("failed at %s on %s: %s", at, v.Node, v.Error)
+	default:
+		return fmt.Sprintf("%s since %s on %s", v.Status, at, v.Node)
+	}
+
+	s := fmt.Sprintf("%d collections at %s on %s: ", v.Collections, at, v.Node)
+	if len(v.Issues) == 0 {
+		return s + "ok"
+	}
+	s += fmt.Sprintf("%d issues", len(v.Issues))
+	for i := range v.Issues {
+		s += "\n  - " + v.Issues[i].String()
+	}
+	return s
+}
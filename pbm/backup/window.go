@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrWindowExceeded is the cause of the backup canceled after
+// it has run longer than its max duration
+var ErrWindowExceeded = errors.New("aborted: window exceeded")
+
+// WindowError is the cancel cause of the backup running out of its
+// max duration. The message is saved as the backup error.
+type WindowError struct {
+	MaxDuration time.Duration
+}
+
+func (e WindowError) Error() string {
+	return ErrWindowExceeded.Error() + " (max duration " + e.MaxDuration.String() + ")"
+}
+
+func (WindowError) Is(err error) bool {
+	return err == ErrWindowExceeded //nolint:errorlint
+}
+
+// MaxDuration returns the time limit of the backup. Zero means no limit.
+func (b *BackupMeta) MaxDuration() time.Duration {
+	return time.Duration(b.MaxDurationSec) * time.Second
+}
+
+// Deadline returns the time the backup is aborted at. The second value
+// is false if the backup has no time limit.
+func (b *BackupMeta) Deadline() (time.Time, bool) {
+	if b.MaxDurationSec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(b.StartTS, 0).Add(b.MaxDuration()), true
+}
+
+// WindowExceeded returns true if the backup was canceled
+// for running out of its max duration
+func (b *BackupMeta) WindowExceeded() bool {
+	return b.Status == defs.StatusCancelled && IsWindowExceeded(b.Err)
+}
+
+// IsWindowExceeded returns true if the backup error message is
+// the one of WindowError
+func IsWindowExceeded(msg string) bool {
+	return strings.HasPrefix(msg, ErrWindowExceeded.Error())
+}
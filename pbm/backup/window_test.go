@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestWindowExceeded(t *testing.T) {
+	cause := WindowError{MaxDuration: 4 * time.Hour}
+	if !errors.Is(cause, ErrWindowExceeded) {
+		t.Errorf("%v is not ErrWindowExceeded", cause)
+	}
+
+	// the cancel cause is saved as the backup error
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+	meta := &BackupMeta{Status: defs.StatusCancelled, Err: context.Cause(ctx).Error()}
+	if meta.Err != "aborted: window exceeded (max duration 4h0m0s)" {
+		t.Errorf("unexpected error message %q", meta.Err)
+	}
+	if !meta.WindowExceeded() {
+		t.Errorf("backup %+v isn't window exceeded", meta)
+	}
+
+	for _, m := range []*BackupMeta{
+		{Status: defs.StatusCancelled, Err: "context canceled"},
+		{Status: defs.StatusError, Err: meta.Err},
+	} {
+		if m.WindowExceeded() {
+			t.Errorf("backup %+v is window exceeded", m)
+		}
+	}
+}
+
+func TestBackupDeadline(t *testing.T) {
+	meta := &BackupMeta{StartTS: 1704067200}
+	if _, ok := meta.Deadline(); ok {
+		t.Error("expected no deadline")
+	}
+
+	meta.MaxDurationSec = 3600
+	d, ok := meta.Deadline()
+	if !ok || d.Unix() != 1704070800 {
+		t.Errorf("unexpected deadline %v (%v)", d, ok)
+	}
+}
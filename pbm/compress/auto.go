@@ -0,0 +1,266 @@
+package compress
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// CompressionTypeAuto makes the backup choose the compression by the
+// benchmark of the candidates on the sampled data (see AutoBench).
+// The chosen compression is saved to the backup metadata, files are
+// never compressed with "auto".
+const CompressionTypeAuto CompressionType = "auto"
+
+// AutoTarget is what the auto compression optimizes.
+type AutoTarget string
+
+const (
+	// AutoTargetTime is the shortest backup: the compression and the upload
+	// of the compressed data run together, so the slowest of them defines
+	// the time.
+	AutoTargetTime AutoTarget = "time"
+	// AutoTargetSize is the smallest backup of the compressions which are
+	// not slower than the upload of the uncompressed data.
+	AutoTargetSize AutoTarget = "size"
+)
+
+func IsValidAutoTarget(s string) bool {
+	switch AutoTarget(s) {
+	case "", AutoTargetTime, AutoTargetSize:
+		return true
+	}
+	return false
+}
+
+// AutoCandidate is a compression benchmarked by AutoBench.
+type AutoCandidate struct {
+	Compression CompressionType
+	Level       *int
+}
+
+// AutoCandidates are the compressions benchmarked by default.
+// CompressionTypeNone is always considered and isn't benchmarked.
+var AutoCandidates = []AutoCandidate{
+	{Compression: CompressionTypeS2},
+	{Compression: CompressionTypeSNAPPY},
+	{Compression: CompressionTypeLZ4},
+	{Compression: CompressionTypeZstandard},
+	{Compression: CompressionTypePGZIP},
+}
+
+// AutoResult is the benchmark result of a compression.
+//
+//nolint:lll
+type AutoResult struct {
+	Compression CompressionType `bson:"compression" json:"compression" yaml:"compression"`
+	Level       *int            `bson:"level,omitempty" json:"level,omitempty" yaml:"level,omitempty"`
+	// Ratio is the compressed size to the raw size.
+	Ratio float64 `bson:"ratio" json:"ratio" yaml:"ratio"`
+	// MBps is the raw MB compressed per CPU second. Zero for no compression.
+	MBps float64 `bson:"mbps" json:"mbps" yaml:"mbps"`
+}
+
+func (r AutoResult) String() string {
+	if r.Compression == CompressionTypeNone {
+		return string(r.Compression)
+	}
+	name := string(r.Compression)
+	if r.Level != nil {
+		name = fmt.Sprintf("%s/%d", r.Compression, *r.Level)
+	}
+	return fmt.Sprintf("%s (%.0f%% of the size, %.1f MB/s per CPU)", name, r.Ratio*100, r.MBps)
+}
+
+// AutoChoice is the compression chosen by AutoBench and why.
+//
+//nolint:lll
+type AutoChoice struct {
+	Compression CompressionType `bson:"compression" json:"compression" yaml:"compression"`
+	Level       *int            `bson:"level,omitempty" json:"level,omitempty" yaml:"level,omitempty"`
+	Target      AutoTarget      `bson:"target" json:"target" yaml:"target"`
+	Reason      string          `bson:"reason" json:"reason" yaml:"reason"`
+	SampleSize  int64           `bson:"sampleSize" json:"sampleSize" yaml:"sampleSize"`
+	Results     []AutoResult    `bson:"results,omitempty" json:"results,omitempty" yaml:"results,omitempty"`
+}
+
+func (c *AutoChoice) String() string {
+	return fmt.Sprintf("%s (target %s): %s", c.Compression, c.Target, c.Reason)
+}
+
+// measureFunc compresses the sample once. It returns the compressed size
+// and the CPU time spent.
+type measureFunc func(c AutoCandidate, sample []byte) (int64, time.Duration, error)
+
+// maxBenchRounds limits the compressions of the sample by a candidate
+const maxBenchRounds = 100
+
+// AutoBench chooses the compression for the target by the benchmark of
+// the candidates on the sample. Each candidate compresses the sample
+// repeatedly for its share of the budget of CPU time.
+type AutoBench struct {
+	Target AutoTarget
+	// Candidates are AutoCandidates if empty.
+	Candidates []AutoCandidate
+	// Budget is the CPU time of the whole benchmark.
+	Budget time.Duration
+	// UploadMBps is the expected upload rate of the storage.
+	UploadMBps float64
+	// CPUs is the number of CPUs the parallel compressions (pgzip, s2 and
+	// zstd) use at once. The others compress on a single CPU.
+	CPUs int
+
+	// measure is measureCPU unless a test sets it
+	measure measureFunc
+}
+
+// Run benchmarks the candidates and chooses the compression.
+func (b *AutoBench) Run(sample []byte) (*AutoChoice, error) {
+	if len(sample) == 0 {
+		return nil, errors.New("no data to benchmark")
+	}
+	if b.UploadMBps <= 0 {
+		return nil, errors.New("upload rate should be positive")
+	}
+
+	cands := b.Candidates
+	if len(cands) == 0 {
+		cands = AutoCandidates
+	}
+	measure := b.measure
+	if measure == nil {
+		measure = measureCPU
+	}
+	share := b.Budget / time.Duration(len(cands))
+
+	rv := &AutoChoice{
+		Target:     b.Target,
+		SampleSize: int64(len(sample)),
+		Results:    make([]AutoResult, 0, len(cands)),
+	}
+	if rv.Target == "" {
+		rv.Target = AutoTargetTime
+	}
+	for _, c := range cands {
+		var in, out int64
+		var spent time.Duration
+		for i := 0; i == 0 || spent < share && i < maxBenchRounds; i++ {
+			n, d, err := measure(c, sample)
+			if err != nil {
+				return nil, errors.Wrapf(err, "benchmark %s", c.Compression)
+			}
+			in += int64(len(sample))
+			out += n
+			spent += d
+		}
+
+		spent = max(spent, time.Microsecond)
+		rv.Results = append(rv.Results, AutoResult{
+			Compression: c.Compression,
+			Level:       c.Level,
+			Ratio:       float64(out) / float64(in),
+			MBps:        float64(in) / (1 << 20) / spent.Seconds(),
+		})
+	}
+
+	best, reason := chooseAuto(rv.Results, rv.Target, b.UploadMBps, max(b.CPUs, 1))
+	rv.Compression, rv.Level, rv.Reason = best.Compression, best.Level, reason
+	return rv, nil
+}
+
+// chooseAuto returns the result of the compression for the target.
+// The compression runs along with the upload, so a raw MB takes about
+// max(compression time, upload time of the compressed data).
+func chooseAuto(rs []AutoResult, target AutoTarget, uploadMBps float64, cpus int) (AutoResult, string) {
+	none := AutoResult{Compression: CompressionTypeNone, Ratio: 1}
+	rawTime := 1 / uploadMBps
+	secPerMB := func(r AutoResult) float64 {
+		if r.Compression == CompressionTypeNone {
+			return rawTime
+		}
+		speed := r.MBps
+		switch r.Compression {
+		case CompressionTypePGZIP, CompressionTypeS2, CompressionTypeZstandard:
+			speed *= float64(cpus)
+		}
+		return max(1/speed, r.Ratio/uploadMBps)
+	}
+
+	best := none
+	switch target {
+	case AutoTargetSize:
+		for _, r := range rs {
+			if secPerMB(r) > rawTime {
+				continue
+			}
+			if r.Ratio < best.Ratio || r.Ratio == best.Ratio && r.MBps > best.MBps {
+				best = r
+			}
+		}
+		if best.Compression == CompressionTypeNone {
+			return best, fmt.Sprintf("no compression is faster than the upload at %.0f MB/s", uploadMBps)
+		}
+		return best, fmt.Sprintf("%s is the smallest of the compressions not slower than the upload at %.0f MB/s",
+			best, uploadMBps)
+	default:
+		for _, r := range rs {
+			t, bt := secPerMB(r), secPerMB(best)
+			if t < bt || t == bt && r.Ratio < best.Ratio {
+				best = r
+			}
+		}
+		if best.Compression == CompressionTypeNone {
+			return best, fmt.Sprintf("no compression makes the backup faster than the upload "+
+				"of the raw data at %.0f MB/s", uploadMBps)
+		}
+		return best, fmt.Sprintf("%s makes the fastest backup: %.1f s/GB vs %.1f s/GB without compression "+
+			"at the upload %.0f MB/s (%d CPUs)", best, secPerMB(best)*1024, rawTime*1024, uploadMBps, cpus)
+	}
+}
+
+// measureCPU compresses the sample and returns the CPU time of
+// the process (the compressions may run on several goroutines).
+// The CPU time isn't of the compression alone: the other work of the
+// agent meanwhile (e.g. the oplog slicing) is counted too, which makes
+// the compressions look slower than they are on a busy agent.
+func measureCPU(c AutoCandidate, sample []byte) (int64, time.Duration, error) {
+	ts, err := cpuTime()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w := &countWriter{}
+	cw, err := Compress(w, c.Compression, c.Level)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "create writer")
+	}
+	if _, err := cw.Write(sample); err != nil {
+		return 0, 0, errors.Wrap(err, "compress")
+	}
+	if err := cw.Close(); err != nil {
+		return 0, 0, errors.Wrap(err, "compress")
+	}
+
+	te, err := cpuTime()
+	if err != nil {
+		return 0, 0, err
+	}
+	return w.n, te - ts, nil
+}
+
+func cpuTime() (time.Duration, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, errors.Wrap(err, "get CPU usage")
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
+
+type countWriter struct{ n int64 }
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
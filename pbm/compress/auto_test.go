@@ -0,0 +1,125 @@
+package compress
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeBench returns the measure of the compressions with the given ratio
+// and speed (MB per CPU second) and counts the calls.
+func fakeBench(perf map[CompressionType][2]float64, calls map[CompressionType]int) measureFunc {
+	return func(c AutoCandidate, sample []byte) (int64, time.Duration, error) {
+		calls[c.Compression]++
+		p := perf[c.Compression]
+		mb := float64(len(sample)) / (1 << 20)
+		d := time.Duration(mb / p[1] * float64(time.Second))
+		return int64(float64(len(sample)) * p[0]), d, nil
+	}
+}
+
+func TestAutoBench(t *testing.T) {
+	// {ratio, MB/s per CPU}
+	perf := map[CompressionType][2]float64{
+		CompressionTypeS2:        {0.5, 80},
+		CompressionTypeSNAPPY:    {0.6, 120},
+		CompressionTypeLZ4:       {0.55, 150},
+		CompressionTypeZstandard: {0.3, 40},
+		CompressionTypePGZIP:     {0.28, 10},
+	}
+	sample := make([]byte, 1<<20)
+
+	cases := []struct {
+		name   string
+		target AutoTarget
+		upload float64
+		cpus   int
+		want   CompressionType
+	}{
+		// the compression of a single slow CPU is slower than the upload
+		{"slow cpu", AutoTargetTime, 200, 1, CompressionTypeNone},
+		{"slow cpu size", AutoTargetSize, 200, 1, CompressionTypeNone},
+		{"slow cpu slower upload", AutoTargetTime, 100, 1, CompressionTypeLZ4},
+		{"slow upload", AutoTargetTime, 20, 4, CompressionTypeZstandard},
+		{"slow upload default target", "", 20, 4, CompressionTypeZstandard},
+		{"slow upload size", AutoTargetSize, 20, 4, CompressionTypePGZIP},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := make(map[CompressionType]int)
+			b := &AutoBench{
+				Target:     c.target,
+				Budget:     time.Second,
+				UploadMBps: c.upload,
+				CPUs:       c.cpus,
+				measure:    fakeBench(perf, calls),
+			}
+			got, err := b.Run(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Compression != c.want {
+				t.Errorf("got %s, want %s: %s", got.Compression, c.want, got.Reason)
+			}
+			if got.Reason == "" {
+				t.Error("no reason")
+			}
+			if len(got.Results) != len(AutoCandidates) {
+				t.Fatalf("got %d results, want %d", len(got.Results), len(AutoCandidates))
+			}
+			for _, r := range got.Results {
+				p := perf[r.Compression]
+				if math.Abs(r.Ratio-p[0]) > 1e-5 || math.Abs(r.MBps-p[1])/p[1] > 1e-5 {
+					t.Errorf("%s: got ratio %v speed %v, want %v", r.Compression, r.Ratio, r.MBps, p)
+				}
+			}
+
+			// 200ms of the budget per candidate
+			if calls[CompressionTypeS2] != 16 || calls[CompressionTypePGZIP] != 2 {
+				t.Errorf("got rounds %v", calls)
+			}
+		})
+	}
+}
+
+func TestAutoBenchLimits(t *testing.T) {
+	calls := make(map[CompressionType]int)
+	b := &AutoBench{
+		Candidates: []AutoCandidate{{Compression: CompressionTypeS2}},
+		Budget:     time.Second,
+		UploadMBps: 100,
+		measure: func(c AutoCandidate, sample []byte) (int64, time.Duration, error) {
+			calls[c.Compression]++
+			return int64(len(sample) / 2), 0, nil
+		},
+	}
+	if _, err := b.Run(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if calls[CompressionTypeS2] != maxBenchRounds {
+		t.Errorf("got %d rounds, want %d", calls[CompressionTypeS2], maxBenchRounds)
+	}
+
+	if _, err := b.Run(nil); err == nil {
+		t.Error("empty sample: no error")
+	}
+}
+
+func TestAutoBenchCPU(t *testing.T) {
+	b := &AutoBench{Budget: 50 * time.Millisecond, UploadMBps: 100, CPUs: 2}
+	got, err := b.Run(testData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != len(AutoCandidates) {
+		t.Fatalf("got %d results", len(got.Results))
+	}
+	for _, r := range got.Results {
+		if r.Ratio <= 0 || r.Ratio >= 1 || r.MBps <= 0 {
+			t.Errorf("%s: ratio %v, speed %v", r.Compression, r.Ratio, r.MBps)
+		}
+	}
+	if got.Compression == "" || got.Target != AutoTargetTime {
+		t.Errorf("got %+v", got)
+	}
+}
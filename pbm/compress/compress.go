@@ -1,9 +1,14 @@
 package compress
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"io"
 	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/golang/snappy"
@@ -13,6 +18,7 @@ import (
 	"github.com/pierrec/lz4"
 
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
 )
 
 type CompressionType string
@@ -81,7 +87,15 @@ func FileCompression(ext string) CompressionType {
 }
 
 // Compress makes a compressed writer from the given one
-func Compress(w io.Writer, compression CompressionType, level *int) (io.WriteCloser, error) {
+func Compress(w io.Writer, compression CompressionType, level *int) (_ io.WriteCloser, err error) {
+	// the memory of the parallel writers is held till the writer is closed
+	var mem *memory.Grant
+	defer func() {
+		if err != nil {
+			mem.Release()
+		}
+	}()
+
 	switch compression {
 	case CompressionTypeGZIP:
 		if level == nil {
@@ -100,15 +114,15 @@ func Compress(w io.Writer, compression CompressionType, level *int) (io.WriteClo
 		if err != nil {
 			return nil, err
 		}
-		cc := runtime.NumCPU() / 2
-		if cc == 0 {
-			cc = 1
+		mem, err = reserveBlocks(runtime.NumCPU() / 2)
+		if err != nil {
+			return nil, err
 		}
-		err = pgw.SetConcurrency(1<<20, cc)
+		err = pgw.SetConcurrency(compressBlockSize, mem.Units())
 		if err != nil {
 			return nil, err
 		}
-		return pgw, nil
+		return memWriteCloser{pgw, mem}, nil
 	case CompressionTypeLZ4:
 		lz4w := lz4.NewWriter(w)
 		if level != nil {
@@ -118,28 +132,23 @@ func Compress(w io.Writer, compression CompressionType, level *int) (io.WriteClo
 	case CompressionTypeSNAPPY:
 		return snappy.NewBufferedWriter(w), nil
 	case CompressionTypeS2:
-		cc := runtime.NumCPU() / 3
-		if cc == 0 {
-			cc = 1
+		mem, err = reserveBlocks(runtime.NumCPU() / 3)
+		if err != nil {
+			return nil, err
 		}
-		writerOptions := []s2.WriterOption{s2.WriterConcurrency(cc)}
-		if level != nil {
-			switch *level {
-			case 1:
-				writerOptions = append(writerOptions, s2.WriterUncompressed())
-			case 3:
-				writerOptions = append(writerOptions, s2.WriterBetterCompression())
-			case 4:
-				writerOptions = append(writerOptions, s2.WriterBestCompression())
-			}
-		}
-		return s2.NewWriter(w, writerOptions...), nil
+		return memWriteCloser{s2.NewWriter(w, s2WriterOptions(level, mem.Units())...), mem}, nil
 	case CompressionTypeZstandard:
-		encLevel := zstd.SpeedDefault
-		if level != nil {
-			encLevel = zstd.EncoderLevelFromZstd(*level)
+		mem, err = memory.Default.Reserve(zstdEncoderMem, 1, runtime.GOMAXPROCS(0))
+		if err != nil {
+			return nil, err
+		}
+		zw, err := zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstdLevel(level)),
+			zstd.WithEncoderConcurrency(mem.Units()))
+		if err != nil {
+			return nil, err
 		}
-		return zstd.NewWriter(w, zstd.WithEncoderLevel(encLevel))
+		return memWriteCloser{zw, mem}, nil
 	case CompressionTypeNone:
 		fallthrough
 	default:
@@ -147,14 +156,109 @@ func Compress(w io.Writer, compression CompressionType, level *int) (io.WriteClo
 	}
 }
 
-// Decompress wraps given reader by the decompressing io.ReadCloser
-func Decompress(r io.Reader, c CompressionType) (io.ReadCloser, error) {
+func s2WriterOptions(level *int, concurrency int) []s2.WriterOption {
+	opts := []s2.WriterOption{s2.WriterConcurrency(concurrency)}
+	if level != nil {
+		switch *level {
+		case 1:
+			opts = append(opts, s2.WriterUncompressed())
+		case 3:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 4:
+			opts = append(opts, s2.WriterBestCompression())
+		}
+	}
+	return opts
+}
+
+func zstdLevel(level *int) zstd.EncoderLevel {
+	if level == nil {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(*level)
+}
+
+// magic bytes of the compressed streams
+var (
+	magicGZIP      = []byte{0x1f, 0x8b, 0x08}
+	magicZstd      = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicLZ4       = []byte{0x04, 0x22, 0x4d, 0x18}
+	magicLZ4Legacy = []byte{0x02, 0x21, 0x4c, 0x18}
+	magicSnappy    = []byte("\xff\x06\x00\x00sNaPpY")
+	magicS2        = []byte("\xff\x06\x00\x00S2sTwO")
+)
+
+// Detect returns the compression of the stream by its magic bytes and
+// the reader of the whole stream. Uncompressed data (BSON) has none of them,
+// it is reported as CompressionTypeNone.
+func Detect(r io.Reader) (CompressionType, io.Reader, error) {
+	br := bufio.NewReaderSize(r, 64)
+	head, err := br.Peek(len(magicSnappy))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", nil, errors.Wrap(err, "read magic bytes")
+	}
+
+	c := CompressionTypeNone
+	switch {
+	case bytes.HasPrefix(head, magicZstd):
+		c = CompressionTypeZstandard
+	case bytes.HasPrefix(head, magicLZ4), bytes.HasPrefix(head, magicLZ4Legacy):
+		c = CompressionTypeLZ4
+	case bytes.HasPrefix(head, magicSnappy):
+		c = CompressionTypeSNAPPY
+	case bytes.HasPrefix(head, magicS2):
+		c = CompressionTypeS2
+	case bytes.HasPrefix(head, magicGZIP):
+		c = CompressionTypeGZIP
+	}
+
+	return c, br, nil
+}
+
+var strictDetection atomic.Bool
+
+// SetDetection sets how Decompress handles the compression of the metadata.
+// In the strict mode the files are read only by the compression given, no
+// compression is read as is and unknown compression is an error. Otherwise,
+// the magic bytes of the stream are checked, and the detected compression
+// is used if the given one is missing, unknown or contradicts them.
+func SetDetection(strict bool) {
+	strictDetection.Store(strict)
+}
+
+// Decompress wraps given reader by the decompressing io.ReadCloser.
+// Empty c means the compression is unknown (e.g. not in the metadata).
+// Unless the strict mode is set (see SetDetection), the compression is
+// checked against the magic bytes of the stream, except for
+// CompressionTypeNone, which is read as is. logf reports the detected
+// compression if it differs from c, it may be nil.
+func Decompress(r io.Reader, c CompressionType, logf func(msg string, args ...any)) (io.ReadCloser, error) {
+	if strictDetection.Load() {
+		if c == "" {
+			c = CompressionTypeNone
+		}
+		if !IsValidCompressionType(string(c)) {
+			return nil, errors.Errorf("unknown compression %q", c)
+		}
+	} else {
+		var err error
+		c, r, err = checkCompression(r, c, logf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	switch c {
 	case CompressionTypeGZIP, CompressionTypePGZIP:
 		rr, err := gzip.NewReader(r)
 		return rr, errors.Wrap(err, "gzip reader")
 	case CompressionTypeLZ4:
-		return io.NopCloser(lz4.NewReader(r)), nil
+		// lz4 frames of older tools (`lz4 -l`) have the legacy format
+		br := bufio.NewReader(r)
+		if head, _ := br.Peek(len(magicLZ4Legacy)); bytes.Equal(head, magicLZ4Legacy) {
+			return io.NopCloser(lz4.NewReaderLegacy(br)), nil
+		}
+		return io.NopCloser(lz4.NewReader(br)), nil
 	case CompressionTypeSNAPPY:
 		return io.NopCloser(snappy.NewReader(r)), nil
 	case CompressionTypeS2:
@@ -162,13 +266,118 @@ func Decompress(r io.Reader, c CompressionType) (io.ReadCloser, error) {
 	case CompressionTypeZstandard:
 		rr, err := zstd.NewReader(r)
 		return io.NopCloser(rr), errors.Wrap(err, "zstandard reader")
-	case CompressionTypeNone:
-		fallthrough
 	default:
 		return io.NopCloser(r), nil
 	}
 }
 
+// checkCompression returns the compression of the stream by its magic
+// bytes if c is empty, unknown or a compression they contradict.
+// CompressionTypeNone is taken as is: uncompressed data may start with
+// any bytes.
+func checkCompression(
+	r io.Reader,
+	c CompressionType,
+	logf func(msg string, args ...any),
+) (CompressionType, io.Reader, error) {
+	if c == CompressionTypeNone {
+		return c, r, nil
+	}
+
+	br := bufio.NewReaderSize(r, 64)
+	head, err := br.Peek(len(magicSnappy))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", nil, errors.Wrap(err, "detect compression: read magic bytes")
+	}
+	if m := truncatedMagic(head); m != "" {
+		return "", nil, errors.Errorf("detect compression: truncated %s header: "+
+			"the stream is %d bytes", m, len(head))
+	}
+
+	got, _, _ := Detect(bytes.NewReader(head))
+	switch {
+	case sameCompression(c, got):
+		return c, br, nil
+	case got == CompressionTypeNone && len(head) == 0:
+		// no data
+		return c, br, nil
+	}
+
+	if logf != nil {
+		switch {
+		case c == "":
+			logf("no compression in the metadata, detected %s", got)
+		case !IsValidCompressionType(string(c)):
+			logf("unknown compression %q in the metadata, detected %s", c, got)
+		default:
+			logf("compression %s of the metadata doesn't match the data, detected %s", c, got)
+		}
+	}
+	return got, br, nil
+}
+
+func sameCompression(a, b CompressionType) bool {
+	if a == CompressionTypePGZIP {
+		a = CompressionTypeGZIP
+	}
+	if b == CompressionTypePGZIP {
+		b = CompressionTypeGZIP
+	}
+	return a == b
+}
+
+// truncatedMagic returns the compressions ("snappy or s2") the whole stream
+// is the beginning of the magic bytes of, if it's shorter than them
+func truncatedMagic(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+
+	var rv []string
+	for _, m := range []struct {
+		c     CompressionType
+		magic []byte
+	}{
+		{CompressionTypeGZIP, magicGZIP},
+		{CompressionTypeZstandard, magicZstd},
+		{CompressionTypeLZ4, magicLZ4},
+		{CompressionTypeLZ4, magicLZ4Legacy},
+		{CompressionTypeSNAPPY, magicSnappy},
+		{CompressionTypeS2, magicS2},
+	} {
+		if len(head) < len(m.magic) && bytes.HasPrefix(m.magic, head) &&
+			!slices.Contains(rv, string(m.c)) {
+			rv = append(rv, string(m.c))
+		}
+	}
+	return strings.Join(rv, " or ")
+}
+
+// compressBlockSize is the block of the parallel pgzip and s2 writers.
+// A worker holds the block and its compressed data.
+const compressBlockSize = 1 << 20
+
+// zstdEncoderMem is the estimated memory of an encoder of the zstd writer
+// (the window and the blocks of the default level).
+const zstdEncoderMem = 16 << 20
+
+// reserveBlocks reserves the memory of up to cc workers of the parallel
+// writer. At least one worker is reserved.
+func reserveBlocks(cc int) (*memory.Grant, error) {
+	return memory.Default.Reserve(2*compressBlockSize, 1, max(cc, 1))
+}
+
+// memWriteCloser returns the memory of the writer to the budget on Close
+type memWriteCloser struct {
+	io.WriteCloser
+	mem *memory.Grant
+}
+
+func (w memWriteCloser) Close() error {
+	defer w.mem.Release()
+	return w.WriteCloser.Close()
+}
+
 type nopWriteCloser struct{ io.Writer }
 
 func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,301 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pierrec/lz4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testLevels = map[CompressionType][]*int{
+	CompressionTypeNone:      {nil},
+	CompressionTypeGZIP:      {nil, intRef(1), intRef(9)},
+	CompressionTypePGZIP:     {nil, intRef(1), intRef(9)},
+	CompressionTypeSNAPPY:    {nil},
+	CompressionTypeLZ4:       {nil, intRef(0), intRef(9), intRef(16)},
+	CompressionTypeS2:        {nil, intRef(1), intRef(3), intRef(4)},
+	CompressionTypeZstandard: {nil, intRef(1), intRef(10), intRef(22)},
+}
+
+func intRef(i int) *int { return &i }
+
+func testData(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		b, err := bson.Marshal(bson.D{{"_id", i}, {"name", fmt.Sprintf("doc-%d", i)}, {"v", i % 7}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func compressData(t *testing.T, c CompressionType, level *int, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := Compress(&buf, c, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompressData(t *testing.T, c CompressionType, data []byte) []byte {
+	t.Helper()
+
+	return decompressDataLog(t, c, data, nil)
+}
+
+func decompressDataLog(t *testing.T, c CompressionType, data []byte, logf func(string, ...any)) []byte {
+	t.Helper()
+
+	r, err := Decompress(bytes.NewReader(data), c, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rv, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := testData(t)
+
+	for c, levels := range testLevels {
+		if !IsValidCompressionType(string(c)) {
+			t.Errorf("%s is not valid", c)
+		}
+		for _, l := range levels {
+			name := string(c) + "/default"
+			if l != nil {
+				name = fmt.Sprintf("%s/%d", c, *l)
+			}
+			t.Run(name, func(t *testing.T) {
+				cdata := compressData(t, c, l, data)
+				if got := decompressData(t, c, cdata); !bytes.Equal(got, data) {
+					t.Errorf("decompressed data doesn't match: %d bytes, expected %d", len(got), len(data))
+				}
+
+				// no compression in the metadata
+				if got := decompressData(t, "", cdata); !bytes.Equal(got, data) {
+					t.Errorf("detected: decompressed data doesn't match: %d bytes, expected %d",
+						len(got), len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	data := testData(t)
+
+	for c := range testLevels {
+		t.Run(string(c), func(t *testing.T) {
+			cdata := compressData(t, c, nil, data)
+			want := c
+			if c == CompressionTypePGZIP {
+				want = CompressionTypeGZIP
+			}
+
+			got, r, err := Detect(bytes.NewReader(cdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("detected %s, expected %s", got, want)
+			}
+			if rest, _ := io.ReadAll(r); !bytes.Equal(rest, cdata) {
+				t.Error("the reader doesn't return the whole stream")
+			}
+		})
+	}
+
+	t.Run("short", func(t *testing.T) {
+		got, _, err := Detect(bytes.NewReader([]byte{0x1f}))
+		if err != nil || got != CompressionTypeNone {
+			t.Errorf("got %s, %v", got, err)
+		}
+	})
+}
+
+func TestLZ4Legacy(t *testing.T) {
+	data := testData(t)
+
+	var buf bytes.Buffer
+	w := lz4.NewWriterLegacy(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []CompressionType{CompressionTypeLZ4, ""} {
+		if got := decompressData(t, c, buf.Bytes()); !bytes.Equal(got, data) {
+			t.Errorf("%q: decompressed data doesn't match: %d bytes, expected %d", c, len(got), len(data))
+		}
+	}
+}
+
+func TestDecompressFallback(t *testing.T) {
+	data := testData(t)
+
+	var logged []string
+	logf := func(msg string, args ...any) {
+		logged = append(logged, fmt.Sprintf(msg, args...))
+	}
+
+	var legacy bytes.Buffer
+	w := lz4.NewWriterLegacy(&legacy)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := map[string]struct {
+		c     CompressionType
+		cdata []byte
+	}{
+		"gzip":       {CompressionTypeGZIP, compressData(t, CompressionTypeGZIP, nil, data)},
+		"pgzip":      {CompressionTypePGZIP, compressData(t, CompressionTypePGZIP, nil, data)},
+		"zstd":       {CompressionTypeZstandard, compressData(t, CompressionTypeZstandard, nil, data)},
+		"s2":         {CompressionTypeS2, compressData(t, CompressionTypeS2, nil, data)},
+		"snappy":     {CompressionTypeSNAPPY, compressData(t, CompressionTypeSNAPPY, nil, data)},
+		"lz4":        {CompressionTypeLZ4, compressData(t, CompressionTypeLZ4, nil, data)},
+		"lz4-legacy": {CompressionTypeLZ4, legacy.Bytes()},
+	}
+	for name, s := range streams {
+		// no, unknown and other compressions in the metadata
+		for _, meta := range []CompressionType{"", "lzma", otherCompression(s.c)} {
+			t.Run(name+"/"+string(meta), func(t *testing.T) {
+				logged = nil
+				if got := decompressDataLog(t, meta, s.cdata, logf); !bytes.Equal(got, data) {
+					t.Errorf("decompressed data doesn't match: %d bytes, expected %d", len(got), len(data))
+				}
+				if len(logged) != 1 {
+					t.Errorf("expected the detection to be logged, got %q", logged)
+				}
+			})
+		}
+
+		t.Run(name+"/match", func(t *testing.T) {
+			logged = nil
+			if got := decompressDataLog(t, s.c, s.cdata, logf); !bytes.Equal(got, data) {
+				t.Errorf("decompressed data doesn't match: %d bytes, expected %d", len(got), len(data))
+			}
+			if len(logged) != 0 {
+				t.Errorf("unexpected detection: %q", logged)
+			}
+		})
+	}
+
+	t.Run("uncompressed", func(t *testing.T) {
+		logged = nil
+		if got := decompressDataLog(t, CompressionTypeS2, data, logf); !bytes.Equal(got, data) {
+			t.Errorf("decompressed data doesn't match: %d bytes, expected %d", len(got), len(data))
+		}
+		if len(logged) != 1 {
+			t.Errorf("expected the detection to be logged, got %q", logged)
+		}
+		logged = nil
+		decompressDataLog(t, CompressionTypeNone, data, logf)
+		if len(logged) != 0 {
+			t.Errorf("unexpected detection: %q", logged)
+		}
+	})
+}
+
+func TestDecompressNone(t *testing.T) {
+	for name, data := range map[string][]byte{
+		// the BSON document of 559903 bytes starts with the gzip magic
+		"gzip magic": append([]byte{0x1f, 0x8b, 0x08, 0x00}, testData(t)...),
+		"zstd magic": append(append([]byte{}, magicZstd...), testData(t)...),
+		"one byte":   {0xff},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := decompressData(t, CompressionTypeNone, data); !bytes.Equal(got, data) {
+				t.Errorf("the data is changed: %d bytes, expected %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func otherCompression(c CompressionType) CompressionType {
+	if c == CompressionTypeZstandard {
+		return CompressionTypeGZIP
+	}
+	return CompressionTypeZstandard
+}
+
+func TestDecompressStrict(t *testing.T) {
+	SetDetection(true)
+	t.Cleanup(func() { SetDetection(false) })
+
+	data := testData(t)
+	cdata := compressData(t, CompressionTypeZstandard, nil, data)
+
+	_, err := Decompress(bytes.NewReader(cdata), "lzma", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown compression") {
+		t.Errorf("expected unknown compression error, got %v", err)
+	}
+
+	// no compression is read as is
+	for _, c := range []CompressionType{"", CompressionTypeNone} {
+		r, err := Decompress(bytes.NewReader(cdata), c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(r); !bytes.Equal(got, cdata) {
+			t.Errorf("%q: the data is decompressed in the strict mode", c)
+		}
+	}
+	if got := decompressData(t, CompressionTypeZstandard, cdata); !bytes.Equal(got, data) {
+		t.Errorf("decompressed data doesn't match: %d bytes, expected %d", len(got), len(data))
+	}
+}
+
+func TestDecompressTruncatedHeader(t *testing.T) {
+	for name, head := range map[string][]byte{
+		"gzip":       magicGZIP[:2],
+		"zstd":       magicZstd[:3],
+		"lz4":        magicLZ4[:1],
+		"lz4-legacy": magicLZ4Legacy[:3],
+		"snappy":     magicSnappy[:7],
+		"s2":         magicS2[:6],
+		"framing":    magicS2[:4],
+	} {
+		for _, c := range []CompressionType{"", CompressionTypeGZIP, CompressionTypeS2} {
+			t.Run(name+"/"+string(c), func(t *testing.T) {
+				_, err := Decompress(bytes.NewReader(head), c, nil)
+				if err == nil || !strings.Contains(err.Error(), "truncated") {
+					t.Errorf("expected truncated header error, got %v", err)
+				}
+			})
+		}
+	}
+
+	_, err := Decompress(bytes.NewReader(magicS2[:4]), "", nil)
+	if err == nil || !strings.Contains(err.Error(), "truncated snappy or s2 header") {
+		t.Errorf("framing: got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+)
+
+// DefaultFrameSize is the size of the data compressed by a worker at once
+const DefaultFrameSize = 4 << 20
+
+// Pool compresses the streams of its writers in frames on a limited number
+// of workers. A stream is split into frames of the fixed size (only the last
+// one is smaller), they are compressed concurrently and written in order.
+// Both zstd and s2 streams are sequences of independent frames, so the
+// output is a regular stream for Decompress. Other compressions are not
+// split.
+//
+// The workers are shared by all writers of the pool. A writer waits for
+// a free worker before the next frame (backpressure), so the pool holds
+// up to workers frames (and their compressed data) plus the frame being
+// filled by each writer.
+type Pool struct {
+	workers   chan struct{}
+	frameSize int
+	// mem is the memory of the workers frames
+	mem *memory.Grant
+
+	mu       sync.Mutex
+	encoders map[zstd.EncoderLevel]*zstd.Encoder
+}
+
+// NewPool creates the pool of the workers. frameSize <= 0 is DefaultFrameSize.
+// The workers are reserved from the memory budget, the pool has fewer
+// of them if the budget is short. Release returns them. Nil if there is
+// no memory for a worker, the streams are compressed without the pool then.
+func NewPool(workers, frameSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if frameSize <= 0 {
+		frameSize = DefaultFrameSize
+	}
+
+	mem, _ := memory.Default.Reserve(2*int64(frameSize), 0, workers)
+	if mem.Units() == 0 {
+		mem.Release()
+		return nil
+	}
+	return &Pool{
+		workers:   make(chan struct{}, mem.Units()),
+		frameSize: frameSize,
+		mem:       mem,
+		encoders:  make(map[zstd.EncoderLevel]*zstd.Encoder),
+	}
+}
+
+// Release returns the memory of the workers to the budget.
+// The pool shouldn't be used after it.
+func (p *Pool) Release() {
+	if p != nil {
+		p.mem.Release()
+	}
+}
+
+// IsParallel returns true if the pool splits the streams of compression c
+func IsParallel(c CompressionType) bool {
+	return c == CompressionTypeZstandard || c == CompressionTypeS2
+}
+
+// Compress makes a compressed writer from the given one. Nil pool is
+// the same as the package Compress.
+func (p *Pool) Compress(w io.Writer, compression CompressionType, level *int) (io.WriteCloser, error) {
+	if p == nil || !IsParallel(compression) {
+		return Compress(w, compression, level)
+	}
+
+	var encode frameEncoder
+	switch compression {
+	case CompressionTypeZstandard:
+		enc, err := p.zstdEncoder(zstdLevel(level))
+		if err != nil {
+			return nil, errors.Wrap(err, "zstd encoder")
+		}
+		encode = func(dst, src []byte) ([]byte, error) {
+			return enc.EncodeAll(src, dst), nil
+		}
+	case CompressionTypeS2:
+		opts := s2WriterOptions(level, 1)
+		encode = func(dst, src []byte) ([]byte, error) {
+			buf := bytes.NewBuffer(dst)
+			sw := s2.NewWriter(buf, opts...)
+			if _, err := sw.Write(src); err != nil {
+				return nil, err
+			}
+			err := sw.Close()
+			return buf.Bytes(), err
+		}
+	}
+
+	pw := &parallelWriter{
+		w:      w,
+		pool:   p,
+		encode: encode,
+		queue:  make(chan *frame, cap(p.workers)),
+		done:   make(chan struct{}),
+		free:   make(chan []byte, 2),
+	}
+	go pw.run()
+
+	return pw, nil
+}
+
+// zstdEncoder returns the encoder of the level shared by the writers.
+// EncodeAll is safe for concurrent use.
+func (p *Pool) zstdEncoder(level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if enc, ok := p.encoders[level]; ok {
+		return enc, nil
+	}
+
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(cap(p.workers)))
+	if err != nil {
+		return nil, err
+	}
+	p.encoders[level] = enc
+	return enc, nil
+}
+
+// frameEncoder appends the compressed src to dst
+type frameEncoder func(dst, src []byte) ([]byte, error)
+
+type frame struct {
+	in    []byte
+	out   []byte
+	err   error
+	ready chan struct{}
+}
+
+type parallelWriter struct {
+	w      io.Writer
+	pool   *Pool
+	encode frameEncoder
+
+	// buf grows up to the frame size. Small streams don't take
+	// the whole frame.
+	buf    []byte
+	frames int
+	closed bool
+
+	// queue is the frames in the stream order
+	queue chan *frame
+	done  chan struct{}
+	free  chan []byte
+
+	mu  sync.Mutex
+	err error
+}
+
+func (pw *parallelWriter) Write(p []byte) (int, error) {
+	if pw.closed {
+		return 0, errors.New("write to closed writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		if err := pw.getErr(); err != nil {
+			return n, err
+		}
+
+		k := min(len(p), pw.pool.frameSize-len(pw.buf))
+		pw.buf = append(pw.buf, p[:k]...)
+		p = p[k:]
+		n += k
+
+		if len(pw.buf) == pw.pool.frameSize {
+			pw.flush()
+		}
+	}
+
+	return n, nil
+}
+
+// flush sends the buffered data to compression. It blocks until
+// a worker is free.
+func (pw *parallelWriter) flush() {
+	f := &frame{in: pw.buf, ready: make(chan struct{})}
+	pw.buf = pw.getBuf()
+	pw.frames++
+
+	pw.pool.workers <- struct{}{}
+	pw.queue <- f
+	go func() {
+		f.out, f.err = pw.encode(pw.getBuf(), f.in)
+		close(f.ready)
+	}()
+}
+
+// run writes the compressed frames in order. The worker of a frame is
+// released after its data is written, that bounds the memory of the pool.
+func (pw *parallelWriter) run() {
+	defer close(pw.done)
+
+	for f := range pw.queue {
+		<-f.ready
+
+		switch {
+		case f.err != nil:
+			pw.setErr(errors.Wrap(f.err, "compress frame"))
+		case pw.getErr() == nil:
+			if _, err := pw.w.Write(f.out); err != nil {
+				pw.setErr(err)
+			}
+		}
+
+		pw.putBuf(f.in)
+		pw.putBuf(f.out)
+		<-pw.pool.workers
+	}
+}
+
+// Close compresses the rest of the data and waits for all frames
+// to be written. An empty stream is a single empty frame as
+// the regular writer makes it.
+func (pw *parallelWriter) Close() error {
+	if pw.closed {
+		return pw.getErr()
+	}
+	pw.closed = true
+
+	if len(pw.buf) > 0 || pw.frames == 0 {
+		pw.flush()
+	}
+	close(pw.queue)
+	<-pw.done
+
+	return pw.getErr()
+}
+
+func (pw *parallelWriter) getBuf() []byte {
+	select {
+	case b := <-pw.free:
+		return b[:0]
+	default:
+		return make([]byte, 0, pw.pool.frameSize)
+	}
+}
+
+func (pw *parallelWriter) putBuf(b []byte) {
+	select {
+	case pw.free <- b:
+	default:
+	}
+}
+
+func (pw *parallelWriter) getErr() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	return pw.err
+}
+
+func (pw *parallelWriter) setErr(err error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if pw.err == nil {
+		pw.err = err
+	}
+}
@@ -0,0 +1,209 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+)
+
+func TestPoolRoundTrip(t *testing.T) {
+	data := testData(t)
+	pool := NewPool(4, 16<<10)
+
+	for c, levels := range testLevels {
+		for _, l := range levels {
+			name := string(c) + "/default"
+			if l != nil {
+				name = fmt.Sprintf("%s/%d", c, *l)
+			}
+			t.Run(name, func(t *testing.T) {
+				for _, size := range []int{0, 100, 16 << 10, len(data)} {
+					var buf bytes.Buffer
+					w, err := pool.Compress(&buf, c, l)
+					if err != nil {
+						t.Fatal(err)
+					}
+					// odd writes to cross the frames boundaries
+					for b := data[:size]; len(b) > 0; {
+						n := min(len(b), 7919)
+						if _, err := w.Write(b[:n]); err != nil {
+							t.Fatal(err)
+						}
+						b = b[n:]
+					}
+					if err := w.Close(); err != nil {
+						t.Fatal(err)
+					}
+
+					if got := decompressData(t, c, buf.Bytes()); !bytes.Equal(got, data[:size]) {
+						t.Errorf("%d bytes: decompressed %d bytes", size, len(got))
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPoolConcurrentWriters(t *testing.T) {
+	data := testData(t)
+	pool := NewPool(2, 8<<10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var buf bytes.Buffer
+			w, err := pool.Compress(&buf, CompressionTypeZstandard, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			w.Write(data)
+			if err := w.Close(); err != nil {
+				errs <- err
+				return
+			}
+			r, err := Decompress(&buf, CompressionTypeZstandard, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err == nil && !bytes.Equal(got, data) {
+				err = fmt.Errorf("decompressed %d bytes, expected %d", len(got), len(data))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+type failWriter struct{ n int }
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestPoolWriteError(t *testing.T) {
+	data := testData(t)
+	pool := NewPool(2, 4<<10)
+
+	w, err := pool.Compress(&failWriter{n: 1}, CompressionTypeS2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = w.Write(data); err != nil {
+			break
+		}
+	}
+	if err := w.Close(); err == nil {
+		t.Error("expected error")
+	}
+
+	// the workers are released
+	if n := len(pool.workers); n != 0 {
+		t.Errorf("%d workers are busy", n)
+	}
+}
+
+func TestPoolMemoryBudget(t *testing.T) {
+	defer func(b *memory.Budget) { memory.Default = b }(memory.Default)
+	memory.Default = &memory.Budget{}
+	memory.Default.SetLimit(4 * 16 << 10)
+
+	// a worker holds the frame and its compressed data
+	pool := NewPool(8, 16<<10)
+	if n := cap(pool.workers); n != 2 {
+		t.Errorf("got %d workers, want 2", n)
+	}
+	if u := memory.Default.Usage(); u.UsedBytes != 4*16<<10 {
+		t.Errorf("usage: got %+v", u)
+	}
+
+	// the worker of the writer exceeds the limit
+	if _, err := Compress(io.Discard, CompressionTypePGZIP, nil); !errors.Is(err, memory.ErrNoMemory) {
+		t.Errorf("expected ErrNoMemory, got %v", err)
+	}
+
+	pool.Release()
+	if u := memory.Default.Usage(); u.UsedBytes != 0 || u.PeakBytes != 4*16<<10 {
+		t.Errorf("usage after release: got %+v", u)
+	}
+
+	// no memory for a worker of the pool
+	memory.Default.SetLimit(16 << 10)
+	if pool := NewPool(8, 16<<10); pool != nil {
+		t.Errorf("got the pool of %d workers", cap(pool.workers))
+	}
+}
+
+func benchData() []byte {
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"percona", "backup", "mongodb", "oplog", "chunk", "replset", "shard"}
+
+	var buf bytes.Buffer
+	for buf.Len() < 64<<20 {
+		fmt.Fprintf(&buf, `{"_id":%d,"w":"%s","v":%d}`, rnd.Int63(), words[rnd.Intn(len(words))], rnd.Intn(1000))
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkPool shows the scaling of the compression with the workers
+// (`go test -bench Pool -benchtime 3x ./pbm/compress`)
+func BenchmarkPool(b *testing.B) {
+	data := benchData()
+
+	for _, c := range []struct {
+		ct    CompressionType
+		level *int
+	}{
+		{CompressionTypeZstandard, intRef(3)},
+		{CompressionTypeZstandard, intRef(19)},
+		{CompressionTypeS2, nil},
+	} {
+		name := string(c.ct)
+		if c.level != nil {
+			name = fmt.Sprintf("%s-%d", c.ct, *c.level)
+		}
+		b.Run(name+"/serial", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				w, _ := Compress(io.Discard, c.ct, c.level)
+				w.Write(data)
+				w.Close()
+			}
+		})
+		for _, workers := range []int{1, 2, 4, 8, 16} {
+			b.Run(fmt.Sprintf("%s/workers-%d", name, workers), func(b *testing.B) {
+				pool := NewPool(workers, DefaultFrameSize)
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					w, _ := pool.Compress(io.Discard, c.ct, c.level)
+					w.Write(data)
+					w.Close()
+				}
+			})
+		}
+	}
+}
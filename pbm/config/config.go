@@ -6,22 +6,30 @@ import (
 	"io"
 	"maps"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/azure"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
@@ -63,10 +71,23 @@ func keys(t reflect.Type) confMap {
 
 // validateConfigKey checks if a config key valid
 func validateConfigKey(k string) bool {
-	_, ok := _confmap[k]
+	_, ok := configKeyKind(k)
 	return ok
 }
 
+// configKeyKind returns the type of the config key value.
+func configKeyKind(k string) (reflect.Kind, bool) {
+	if f, ok := scheduleKeyField(k); ok {
+		return _scheduleConfmap[f], true
+	}
+	if isOplogSpanMinRSKey(k) {
+		return reflect.Float64, true
+	}
+
+	kind, ok := _confmap[k]
+	return kind, ok
+}
+
 // Config is a pbm config
 type Config struct {
 	Name      string `bson:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
@@ -76,16 +97,37 @@ type Config struct {
 	PITR    *PITRConf    `bson:"pitr,omitempty" json:"pitr,omitempty" yaml:"pitr,omitempty"`
 	Backup  *BackupConf  `bson:"backup,omitempty" json:"backup,omitempty" yaml:"backup,omitempty"`
 	Restore *RestoreConf `bson:"restore,omitempty" json:"restore,omitempty" yaml:"restore,omitempty"`
+	Lock    *LockConf    `bson:"lock,omitempty" json:"lock,omitempty" yaml:"lock,omitempty"`
+	Resync  *ResyncConf  `bson:"resync,omitempty" json:"resync,omitempty" yaml:"resync,omitempty"`
+	Cluster *ClusterConf `bson:"cluster,omitempty" json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Agent   *AgentConf   `bson:"agent,omitempty" json:"agent,omitempty" yaml:"agent,omitempty"`
+	Log     *LogConf     `bson:"log,omitempty" json:"log,omitempty" yaml:"log,omitempty"`
+
+	Notifications *notify.Config `bson:"notifications,omitempty" json:"notifications,omitempty" yaml:"notifications,omitempty"`
+
+	Schedule map[string]*ScheduleConf `bson:"schedule,omitempty" json:"schedule,omitempty" yaml:"schedule,omitempty"`
+
+	// Replsets are the per-replset overrides of the config.
+	Replsets map[string]*ReplsetConf `bson:"replsets,omitempty" json:"replsets,omitempty" yaml:"replsets,omitempty"`
 
 	Epoch primitive.Timestamp `bson:"epoch" json:"-" yaml:"-"`
 }
 
+// Parse decodes the YAML config. Fields unknown to PBM are rejected
+// with their full path (e.g. `storage.s3.regon`).
 func Parse(r io.Reader) (*Config, error) {
-	cfg := &Config{}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
 
-	dec := yaml.NewDecoder(r)
-	dec.SetStrict(true)
-	err := dec.Decode(cfg)
+	err = checkUnknownFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	err = yaml.UnmarshalStrict(data, cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "decode")
 	}
@@ -109,44 +151,24 @@ func (c *Config) Clone() *Config {
 		Storage:   *c.Storage.Clone(),
 		PITR:      c.PITR.Clone(),
 		Restore:   c.Restore.Clone(),
+		Lock:      c.Lock.Clone(),
+		Resync:    c.Resync.Clone(),
+		Cluster:   c.Cluster.Clone(),
+		Agent:     c.Agent.Clone(),
+		Log:       c.Log.Clone(),
 		Backup:    c.Backup.Clone(),
+		Schedule:  cloneSchedules(c.Schedule),
+		Replsets:  cloneReplsets(c.Replsets),
 		Epoch:     c.Epoch,
+
+		Notifications: c.Notifications.Clone(),
 	}
 
 	return rv
 }
 
 func (c *Config) String() string {
-	c = c.Clone()
-
-	if c.Storage.S3 != nil {
-		if c.Storage.S3.Credentials.AccessKeyID != "" {
-			c.Storage.S3.Credentials.AccessKeyID = "***"
-		}
-		if c.Storage.S3.Credentials.SecretAccessKey != "" {
-			c.Storage.S3.Credentials.SecretAccessKey = "***"
-		}
-		if c.Storage.S3.Credentials.SessionToken != "" {
-			c.Storage.S3.Credentials.SessionToken = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Secret != "" {
-			c.Storage.S3.Credentials.Vault.Secret = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Token != "" {
-			c.Storage.S3.Credentials.Vault.Token = "***"
-		}
-		if c.Storage.S3.ServerSideEncryption != nil &&
-			c.Storage.S3.ServerSideEncryption.SseCustomerKey != "" {
-			c.Storage.S3.ServerSideEncryption.SseCustomerKey = "***"
-		}
-	}
-	if c.Storage.Azure != nil {
-		if c.Storage.Azure.Credentials.Key != "" {
-			c.Storage.Azure.Credentials.Key = "***"
-		}
-	}
-
-	b, err := yaml.Marshal(c)
+	b, err := yaml.Marshal(c.maskSecrets())
 	if err != nil {
 		return fmt.Sprintln("error:", err)
 	}
@@ -164,6 +186,18 @@ func (c *Config) OplogSlicerInterval() time.Duration {
 	return time.Duration(c.PITR.OplogSpanMin * float64(time.Minute))
 }
 
+// OplogSlicerIntervalRS returns interval for oplog slicer routine of the given replset.
+// If there is no override for the replset, it returns general oplog slicer interval.
+func (c *Config) OplogSlicerIntervalRS(rs string) time.Duration {
+	if c.PITR != nil {
+		if m := c.PITR.OplogSpanMinRS[rs]; m > 0 {
+			return time.Duration(m * float64(time.Minute))
+		}
+	}
+
+	return c.OplogSlicerInterval()
+}
+
 // BackupSlicerInterval returns interval for backup slicer routine.
 // If it is not confugured, the function returns general oplog slicer interval.
 func (c *Config) BackupSlicerInterval() time.Duration {
@@ -184,10 +218,92 @@ type Priority map[string]float64
 type PITRConf struct {
 	Enabled          bool                     `bson:"enabled" json:"enabled" yaml:"enabled"`
 	OplogSpanMin     float64                  `bson:"oplogSpanMin,omitempty" json:"oplogSpanMin,omitempty" yaml:"oplogSpanMin,omitempty"`
+	OplogSpanMinRS   map[string]float64       `bson:"oplogSpanMinRS,omitempty" json:"oplogSpanMinRS,omitempty" yaml:"oplogSpanMinRS,omitempty"`
 	OplogOnly        bool                     `bson:"oplogOnly,omitempty" json:"oplogOnly,omitempty" yaml:"oplogOnly,omitempty"`
 	Priority         Priority                 `bson:"priority,omitempty" json:"priority,omitempty" yaml:"priority,omitempty"`
 	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+
+	// ExcludeNamespaces is a list of namespaces (wildcards allowed, e.g. `cache.*`)
+	// which ops are skipped during the oplog replay.
+	ExcludeNamespaces []string `bson:"excludeNamespaces,omitempty" json:"excludeNamespaces,omitempty" yaml:"excludeNamespaces,omitempty"`
+	// FilterAtCapture makes the slicer drop ops of ExcludeNamespaces
+	// before saving chunks. Otherwise, the full oplog is captured.
+	FilterAtCapture bool `bson:"filterAtCapture,omitempty" json:"filterAtCapture,omitempty" yaml:"filterAtCapture,omitempty"`
+	// AdaptiveSpan lets the slicer make chunks more often than oplogSpanMin
+	// when the oplog is close to roll over not yet uploaded entries.
+	AdaptiveSpan bool `bson:"adaptiveSpan,omitempty" json:"adaptiveSpan,omitempty" yaml:"adaptiveSpan,omitempty"`
+	// AutoRestartAfterRestore makes the cluster leader start the base
+	// backup after the restore which has disabled PITR. PITR is enabled
+	// back once the backup is done.
+	AutoRestartAfterRestore bool `bson:"autoRestartAfterRestore,omitempty" json:"autoRestartAfterRestore,omitempty" yaml:"autoRestartAfterRestore,omitempty"`
+	// HostUploads limits the chunk uploads of the agents on the same host
+	HostUploads *PITRHostUploads `bson:"hostUploads,omitempty" json:"hostUploads,omitempty" yaml:"hostUploads,omitempty"`
+}
+
+// PITRHostUploads limits the number of the PITR chunks uploaded at once
+// by the agents of the host (e.g. the members of several replsets on one
+// machine) and randomizes the chunk boundaries, so the slicers don't
+// saturate the network together. The agents share slot files in LockDir.
+//
+//nolint:lll
+type PITRHostUploads struct {
+	Disabled bool `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Limit is the number of the chunks the agents of the host upload
+	// at once. Default is 2.
+	Limit int `bson:"limit,omitempty" json:"limit,omitempty" yaml:"limit,omitempty"`
+	// LockDir is the directory of the slot files. It has to be the same
+	// and writable for all agents of the host. Default is pbm-pitr-uploads
+	// in the temporary directory.
+	LockDir string `bson:"lockDir,omitempty" json:"lockDir,omitempty" yaml:"lockDir,omitempty"`
+	// JitterPercent is how much (in percent of the span) each chunk
+	// boundary is randomly moved by. 0 keeps the span. Default is 10.
+	JitterPercent *float64 `bson:"jitterPercent,omitempty" json:"jitterPercent,omitempty" yaml:"jitterPercent,omitempty"`
+}
+
+// IsEnabled returns true unless the limit is disabled.
+func (c *PITRHostUploads) IsEnabled() bool {
+	return c == nil || !c.Disabled
+}
+
+// MaxUploads returns the limit of the uploads.
+// If not set, returns default value (DefaultPITRHostUploads).
+func (c *PITRHostUploads) MaxUploads() int {
+	if c == nil || c.Limit <= 0 {
+		return defs.DefaultPITRHostUploads
+	}
+	return c.Limit
+}
+
+// Dir returns the directory of the slot files.
+func (c *PITRHostUploads) Dir() string {
+	if c == nil || c.LockDir == "" {
+		return filepath.Join(os.TempDir(), "pbm-pitr-uploads")
+	}
+	return c.LockDir
+}
+
+// Jitter returns the jitter in percent of the span. 0 if disabled.
+// If not set, returns default value (DefaultPITRSpanJitter).
+func (c *PITRHostUploads) Jitter() float64 {
+	switch {
+	case c == nil:
+		return defs.DefaultPITRSpanJitter
+	case c.Disabled:
+		return 0
+	case c.JitterPercent == nil:
+		return defs.DefaultPITRSpanJitter
+	}
+	return *c.JitterPercent
+}
+
+// CaptureExcludeNS returns namespaces that should be dropped by the slicer.
+func (cfg *PITRConf) CaptureExcludeNS() []string {
+	if cfg == nil || !cfg.FilterAtCapture {
+		return nil
+	}
+
+	return cfg.ExcludeNamespaces
 }
 
 func (cfg *PITRConf) Clone() *PITRConf {
@@ -197,10 +313,20 @@ func (cfg *PITRConf) Clone() *PITRConf {
 
 	rv := *cfg
 	rv.Priority = maps.Clone(cfg.Priority)
+	rv.OplogSpanMinRS = maps.Clone(cfg.OplogSpanMinRS)
+	rv.ExcludeNamespaces = slices.Clone(cfg.ExcludeNamespaces)
 	if cfg.CompressionLevel != nil {
 		a := *cfg.CompressionLevel
 		rv.CompressionLevel = &a
 	}
+	if cfg.HostUploads != nil {
+		h := *cfg.HostUploads
+		if h.JitterPercent != nil {
+			j := *h.JitterPercent
+			h.JitterPercent = &j
+		}
+		rv.HostUploads = &h
+	}
 
 	return &rv
 }
@@ -211,6 +337,17 @@ type StorageConf struct {
 	S3         *s3.Config    `bson:"s3,omitempty" json:"s3,omitempty" yaml:"s3,omitempty"`
 	Azure      *azure.Config `bson:"azure,omitempty" json:"azure,omitempty" yaml:"azure,omitempty"`
 	Filesystem *fs.Config    `bson:"filesystem,omitempty" json:"filesystem,omitempty" yaml:"filesystem,omitempty"`
+
+	// Encryption enables the client-side encryption of the files
+	// on the storage. See package encrypt.
+	Encryption *encrypt.Config `bson:"encryption,omitempty" json:"encryption,omitempty" yaml:"encryption,omitempty"`
+
+	// ReadOnly makes PBM refuse writes and deletes on the storage.
+	// It is the storage of another cluster, e.g. production backups
+	// restored to staging. Only config profiles can be read-only.
+	ReadOnly bool `bson:"readonly,omitempty" json:"readonly,omitempty" yaml:"readonly,omitempty"`
+
+	Timeouts *StorageTimeouts `bson:"timeouts,omitempty" json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
 }
 
 func (s *StorageConf) Clone() *StorageConf {
@@ -219,7 +356,10 @@ func (s *StorageConf) Clone() *StorageConf {
 	}
 
 	rv := &StorageConf{
-		Type: s.Type,
+		Type:       s.Type,
+		Encryption: s.Encryption.Clone(),
+		ReadOnly:   s.ReadOnly,
+		Timeouts:   s.Timeouts.Clone(),
 	}
 
 	switch s.Type {
@@ -254,6 +394,64 @@ func (s *StorageConf) Equal(other *StorageConf) bool {
 	return false
 }
 
+// StorageTimeouts are the limits of the storage operations
+// (see storage.Timeouts)
+type StorageTimeouts struct {
+	// Metadata is the timeout (in seconds) of the operations
+	// without data transfer (stat, list, delete).
+	Metadata *uint32 `bson:"metadataSec,omitempty" json:"metadataSec,omitempty" yaml:"metadataSec,omitempty"`
+	// Idle is how long (in seconds) the data transfer can make no progress.
+	Idle *uint32 `bson:"idleSec,omitempty" json:"idleSec,omitempty" yaml:"idleSec,omitempty"`
+}
+
+func (t *StorageTimeouts) Clone() *StorageTimeouts {
+	if t == nil {
+		return nil
+	}
+
+	rv := &StorageTimeouts{}
+	if t.Metadata != nil {
+		v := *t.Metadata
+		rv.Metadata = &v
+	}
+	if t.Idle != nil {
+		v := *t.Idle
+		rv.Idle = &v
+	}
+	return rv
+}
+
+// Timeouts returns the limits of the storage operations.
+// Not set or zero values are the defaults
+// (storage.DefaultMetadataTimeout and storage.DefaultIdleTimeout).
+func (t *StorageTimeouts) Timeouts() storage.Timeouts {
+	rv := storage.Timeouts{
+		Metadata: storage.DefaultMetadataTimeout,
+		Idle:     storage.DefaultIdleTimeout,
+	}
+	if t == nil {
+		return rv
+	}
+
+	if t.Metadata != nil && *t.Metadata != 0 {
+		rv.Metadata = time.Duration(*t.Metadata) * time.Second
+	}
+	if t.Idle != nil && *t.Idle != 0 {
+		rv.Idle = time.Duration(*t.Idle) * time.Second
+	}
+	return rv
+}
+
+// CheckEncryptionKey returns an error if the storage is encrypted and
+// the key for new files is not available to the current process.
+func (s *StorageConf) CheckEncryptionKey() error {
+	if s.Encryption == nil {
+		return nil
+	}
+
+	return errors.Wrap(s.Encryption.CheckKey(s.Encryption.CurrentKeyID()), "storage.encryption")
+}
+
 func (s *StorageConf) Cast() error {
 	switch s.Type {
 	case storage.Filesystem:
@@ -314,6 +512,23 @@ func (s *StorageConf) Path() string {
 	return path
 }
 
+// WithPrefix returns a copy of the config pointing to the prefix
+// (folder) of the storage.
+func (s *StorageConf) WithPrefix(prefix string) *StorageConf {
+	rv := s.Clone()
+	switch rv.Type {
+	case storage.S3:
+		rv.S3.Prefix = path.Join(rv.S3.Prefix, prefix)
+	case storage.Azure:
+		rv.Azure.Prefix = path.Join(rv.Azure.Prefix, prefix)
+	case storage.Filesystem:
+		rv.Filesystem.Path = path.Join(rv.Filesystem.Path, prefix)
+	case storage.Blackhole: // no config
+	}
+
+	return rv
+}
+
 // RestoreConf is config options for the restore
 //
 //nolint:lll
@@ -324,6 +539,10 @@ type RestoreConf struct {
 	BatchSize              int `bson:"batchSize" json:"batchSize,omitempty" yaml:"batchSize,omitempty"`
 	NumInsertionWorkers    int `bson:"numInsertionWorkers" json:"numInsertionWorkers,omitempty" yaml:"numInsertionWorkers,omitempty"`
 	NumParallelCollections int `bson:"numParallelCollections" json:"numParallelCollections,omitempty" yaml:"numParallelCollections,omitempty"`
+	// ParallelDatabases is the number of databases of the replset the
+	// logical restore loads at once, each by its own mongorestore with
+	// NumParallelCollections and NumInsertionWorkers. Default is 1.
+	ParallelDatabases int `bson:"parallelDatabases,omitempty" json:"parallelDatabases,omitempty" yaml:"parallelDatabases,omitempty"`
 
 	// NumDownloadWorkers sets the num of goroutine would be requesting chunks
 	// during the download. By default, it's set to GOMAXPROCS.
@@ -332,11 +551,66 @@ type RestoreConf struct {
 	// to download files from the storage.
 	MaxDownloadBufferMb int `bson:"maxDownloadBufferMb" json:"maxDownloadBufferMb,omitempty" yaml:"maxDownloadBufferMb,omitempty"`
 	DownloadChunkMb     int `bson:"downloadChunkMb" json:"downloadChunkMb,omitempty" yaml:"downloadChunkMb,omitempty"`
+	// PrefetchMb is how much of the files the logical restore and the
+	// oplog replay read ahead from the storage while the data already read
+	// is decompressed and applied. It's shared by the files read at once.
+	// Default is defs.DefaultRestorePrefetchMb.
+	PrefetchMb int `bson:"prefetchMb,omitempty" json:"prefetchMb,omitempty" yaml:"prefetchMb,omitempty"`
+	// NumParallelFiles is the number of files copied concurrently by each node
+	// during physical restore. MaxDownloadBufferMb is split between them.
+	// By default, files are copied one by one.
+	NumParallelFiles int `bson:"numParallelFiles,omitempty" json:"numParallelFiles,omitempty" yaml:"numParallelFiles,omitempty"`
+	// MaxDownloadRateMb limits the combined download rate (MB/s) of the node
+	// during physical restore. 0 is no limit.
+	MaxDownloadRateMb int `bson:"maxDownloadRateMb,omitempty" json:"maxDownloadRateMb,omitempty" yaml:"maxDownloadRateMb,omitempty"`
 
 	// MongodLocation sets the location of mongod used for internal runs during
 	// physical restore. Will try $PATH/mongod if not set.
 	MongodLocation    string            `bson:"mongodLocation" json:"mongodLocation,omitempty" yaml:"mongodLocation,omitempty"`
 	MongodLocationMap map[string]string `bson:"mongodLocationMap" json:"mongodLocationMap,omitempty" yaml:"mongodLocationMap,omitempty"`
+
+	// KeepLast is the number of the latest restores to keep the records of.
+	// Older finished restores are deleted after a restore. 0 keeps all.
+	KeepLast int `bson:"keepLast,omitempty" json:"keepLast,omitempty" yaml:"keepLast,omitempty"`
+
+	// IndexBuild controls the index builds after the data of the logical
+	// restore is loaded.
+	IndexBuild *IndexBuildConf `bson:"indexBuild,omitempty" json:"indexBuild,omitempty" yaml:"indexBuild,omitempty"`
+
+	// NamespacePriority is the ordered list of namespace patterns
+	// ("db.coll", "db.*" or "*.coll") restored first by the logical restore.
+	// Each pattern is a tier restored after the previous one. The rest of
+	// the namespaces are restored afterwards in the backup order.
+	NamespacePriority []string `bson:"namespacePriority,omitempty" json:"namespacePriority,omitempty" yaml:"namespacePriority,omitempty"`
+
+	// Verify is the options of the restore verification into a temporary
+	// mongod (see `pbm backup verify-restore`).
+	Verify *RestoreVerifyConf `bson:"verify,omitempty" json:"verify,omitempty" yaml:"verify,omitempty"`
+
+	// ViaMongos makes the logical restore of a sharded cluster write
+	// the data of all backup replsets through a mongos instead of
+	// restoring each replset to its shard.
+	ViaMongos bool `bson:"viaMongos,omitempty" json:"viaMongos,omitempty" yaml:"viaMongos,omitempty"`
+
+	// ShardKeyMismatch is what the restore of a sharded cluster does once
+	// a sharded collection of the backup is sharded by another key or
+	// isn't sharded in the cluster. Default is ShardKeyMismatchFail.
+	ShardKeyMismatch ShardKeyMismatch `bson:"shardKeyMismatch,omitempty" json:"shardKeyMismatch,omitempty" yaml:"shardKeyMismatch,omitempty"`
+	// RestoreZones makes the restore through mongos recreate the zones
+	// of the sharded collections of the backup.
+	RestoreZones bool `bson:"restoreZones,omitempty" json:"restoreZones,omitempty" yaml:"restoreZones,omitempty"`
+
+	// MaxFailureDetails is how many failed writes of the logical restore
+	// are saved with the namespace, _id and error in the restore metadata.
+	// The rest are counted by the error code. Default is
+	// defs.DefaultRestoreMaxFailureDetails, negative saves none.
+	MaxFailureDetails int `bson:"maxFailureDetails,omitempty" json:"maxFailureDetails,omitempty" yaml:"maxFailureDetails,omitempty"`
+
+	// StrictCompression makes restores read the files only with the
+	// compression of the metadata. By default, files with no or unknown
+	// compression in the metadata, or with the magic bytes of another
+	// compression, are decompressed by the detected one.
+	StrictCompression bool `bson:"strictCompression,omitempty" json:"strictCompression,omitempty" yaml:"strictCompression,omitempty"`
 }
 
 func (cfg *RestoreConf) Clone() *RestoreConf {
@@ -345,6 +619,17 @@ func (cfg *RestoreConf) Clone() *RestoreConf {
 	}
 
 	rv := *cfg
+	if cfg.IndexBuild != nil {
+		b := *cfg.IndexBuild
+		rv.IndexBuild = &b
+	}
+	if cfg.NamespacePriority != nil {
+		rv.NamespacePriority = slices.Clone(cfg.NamespacePriority)
+	}
+	if cfg.Verify != nil {
+		v := *cfg.Verify
+		rv.Verify = &v
+	}
 	if len(cfg.MongodLocationMap) != 0 {
 		rv.MongodLocationMap = make(map[string]string, len(cfg.MongodLocationMap))
 		for k, v := range cfg.MongodLocationMap {
@@ -355,6 +640,445 @@ func (cfg *RestoreConf) Clone() *RestoreConf {
 	return &rv
 }
 
+// PrefetchSize returns the read-ahead size of the restore files in bytes.
+func (cfg *RestoreConf) PrefetchSize() int {
+	if cfg == nil || cfg.PrefetchMb <= 0 {
+		return defs.DefaultRestorePrefetchMb << 20
+	}
+	return cfg.PrefetchMb << 20
+}
+
+// ParallelDBs returns the number of databases the logical restore
+// loads at once.
+func (cfg *RestoreConf) ParallelDBs() int {
+	if cfg == nil || cfg.ParallelDatabases < 1 {
+		return 1
+	}
+	return cfg.ParallelDatabases
+}
+
+// FailureDetails returns how many failed writes of the logical restore
+// are saved with the details.
+func (cfg *RestoreConf) FailureDetails() int {
+	if cfg == nil || cfg.MaxFailureDetails == 0 {
+		return defs.DefaultRestoreMaxFailureDetails
+	}
+	return max(cfg.MaxFailureDetails, 0)
+}
+
+// IsViaMongos returns true if the logical restore of a sharded cluster
+// is routed through a mongos.
+func (cfg *RestoreConf) IsViaMongos() bool {
+	return cfg != nil && cfg.ViaMongos
+}
+
+// IsStrictCompression returns true if the compression of the files
+// is never detected (see RestoreConf.StrictCompression).
+func (cfg *RestoreConf) IsStrictCompression() bool {
+	return cfg != nil && cfg.StrictCompression
+}
+
+// ShardKeyMismatchPolicy returns what the restore does on the sharding
+// mismatch. If not set, returns ShardKeyMismatchFail.
+func (cfg *RestoreConf) ShardKeyMismatchPolicy() ShardKeyMismatch {
+	if cfg == nil || cfg.ShardKeyMismatch == "" {
+		return ShardKeyMismatchFail
+	}
+	return cfg.ShardKeyMismatch
+}
+
+// IsRestoreZones returns true if the restore through mongos recreates
+// the zones of the backup.
+func (cfg *RestoreConf) IsRestoreZones() bool {
+	return cfg != nil && cfg.RestoreZones
+}
+
+// ShardKeyMismatch is what the restore does once a sharded collection of
+// the backup is sharded by another key or isn't sharded in the cluster
+type ShardKeyMismatch string
+
+const (
+	// ShardKeyMismatchFail fails the restore
+	ShardKeyMismatchFail ShardKeyMismatch = "fail"
+	// ShardKeyMismatchWarn keeps the sharding of the cluster,
+	// the mismatch is logged and saved to the restore metadata
+	ShardKeyMismatchWarn ShardKeyMismatch = "warn"
+)
+
+// IndexBuildOrder is the order the collections indexes are built in
+type IndexBuildOrder string
+
+const (
+	// IndexBuildByName builds the indexes of the collections sorted by
+	// namespace (default)
+	IndexBuildByName IndexBuildOrder = ""
+	// IndexBuildLargestFirst starts with the largest collections
+	IndexBuildLargestFirst IndexBuildOrder = "largestFirst"
+	// IndexBuildLargestLast starts with the smallest collections
+	IndexBuildLargestLast IndexBuildOrder = "largestLast"
+)
+
+// IndexBuildConf is the options of the index builds of the logical restore
+//
+//nolint:lll
+type IndexBuildConf struct {
+	// MaxConcurrent is the max number of createIndexes commands running
+	// on the node at once. Default is 1.
+	MaxConcurrent int `bson:"maxConcurrent,omitempty" json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty"`
+	// BatchSize is the max number of indexes of a collection built by one
+	// createIndexes command. 0 builds all indexes of the collection at once.
+	BatchSize int `bson:"batchSize,omitempty" json:"batchSize,omitempty" yaml:"batchSize,omitempty"`
+	// CommitQuorum overrides the commitQuorum of the builds: a number of
+	// members, "majority", "votingMembers" or a replset tag name.
+	// The server default is used if empty.
+	CommitQuorum string `bson:"commitQuorum,omitempty" json:"commitQuorum,omitempty" yaml:"commitQuorum,omitempty"`
+	// Order of the collections builds by the collection size.
+	Order IndexBuildOrder `bson:"order,omitempty" json:"order,omitempty" yaml:"order,omitempty"`
+	// Retries is how many times a failed build is retried.
+	Retries int `bson:"retries,omitempty" json:"retries,omitempty" yaml:"retries,omitempty"`
+}
+
+// Concurrency returns the max number of concurrent builds.
+// If not set or zero, returns 1.
+func (c *IndexBuildConf) Concurrency() int {
+	if c == nil || c.MaxConcurrent <= 0 {
+		return 1
+	}
+	return c.MaxConcurrent
+}
+
+// Quorum returns the commitQuorum value of the createIndexes command.
+// It is nil if not set.
+func (c *IndexBuildConf) Quorum() any {
+	if c == nil || c.CommitQuorum == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(c.CommitQuorum); err == nil {
+		return int32(n)
+	}
+	return c.CommitQuorum
+}
+
+// RestoreVerifyConf is the options of the restore verification. An agent
+// runs it only if it has the mongod binary and MaxDiskMb free in the Dir.
+//
+//nolint:lll
+type RestoreVerifyConf struct {
+	// MongodLocation is the mongod binary of the temporary instance.
+	// Falls back to restore.mongodLocationMap, restore.mongodLocation
+	// and $PATH/mongod.
+	MongodLocation string `bson:"mongodLocation,omitempty" json:"mongodLocation,omitempty" yaml:"mongodLocation,omitempty"`
+	// Dir is where the dbpath of the temporary instance is created.
+	// The temporary directory of the OS by default.
+	Dir string `bson:"dir,omitempty" json:"dir,omitempty" yaml:"dir,omitempty"`
+	// MaxDiskMb is the max size of the dbpath. The verification is
+	// stopped once the restored data outgrows it.
+	MaxDiskMb int `bson:"maxDiskMb,omitempty" json:"maxDiskMb,omitempty" yaml:"maxDiskMb,omitempty"`
+	// MaxDuration is how long the verification may run (e.g. "2h").
+	MaxDuration string `bson:"maxDuration,omitempty" json:"maxDuration,omitempty" yaml:"maxDuration,omitempty"`
+}
+
+// VerifyMongod returns the mongod binary of the temporary instance on the node
+func (cfg *RestoreConf) VerifyMongod(node string) string {
+	if cfg == nil {
+		return "mongod"
+	}
+	if cfg.Verify != nil && cfg.Verify.MongodLocation != "" {
+		return cfg.Verify.MongodLocation
+	}
+	if m, ok := cfg.MongodLocationMap[node]; ok {
+		return m
+	}
+	if cfg.MongodLocation != "" {
+		return cfg.MongodLocation
+	}
+	return "mongod"
+}
+
+// ScratchDir returns the directory of the temporary dbpath.
+// If not set, returns the temporary directory of the OS.
+func (c *RestoreVerifyConf) ScratchDir() string {
+	if c == nil || c.Dir == "" {
+		return os.TempDir()
+	}
+	return c.Dir
+}
+
+// MaxDisk returns the max size of the temporary dbpath in bytes.
+// If not set, returns defs.DefaultVerifyMaxDiskMb.
+func (c *RestoreVerifyConf) MaxDisk() int64 {
+	if c == nil || c.MaxDiskMb <= 0 {
+		return defs.DefaultVerifyMaxDiskMb << 20
+	}
+	return int64(c.MaxDiskMb) << 20
+}
+
+// Timeout returns how long the verification may run.
+// If not set, returns defs.DefaultVerifyMaxDuration.
+func (c *RestoreVerifyConf) Timeout() time.Duration {
+	if c == nil {
+		return defs.DefaultVerifyMaxDuration
+	}
+	if d := parseMaxDuration(c.MaxDuration); d > 0 {
+		return d
+	}
+	return defs.DefaultVerifyMaxDuration
+}
+
+// LockConf is config options for the operation locks
+type LockConf struct {
+	// StaleThreshold is how long (in seconds) a lock heartbeat may be
+	// missed before the lock is considered abandoned (e.g. the agent was
+	// killed) and can be reclaimed by another operation.
+	// It can't be less than defs.StaleFrameSec, which is the default.
+	StaleThreshold uint32 `bson:"staleThresholdSec,omitempty" json:"staleThresholdSec,omitempty" yaml:"staleThresholdSec,omitempty"`
+}
+
+func (cfg *LockConf) Clone() *LockConf {
+	if cfg == nil {
+		return nil
+	}
+
+	rv := *cfg
+	return &rv
+}
+
+// StaleThresholdSec returns the lock staleness threshold in seconds.
+// If not set, returns defs.StaleFrameSec.
+func (cfg *LockConf) StaleThresholdSec() uint32 {
+	if cfg == nil || cfg.StaleThreshold < defs.StaleFrameSec {
+		return defs.StaleFrameSec
+	}
+
+	return cfg.StaleThreshold
+}
+
+// ResyncMode is what the lead agent does when the storage contents
+// don't match the backups metadata in the database.
+type ResyncMode string
+
+const (
+	// ResyncOff disables the periodic reconciliation.
+	ResyncOff ResyncMode = "off"
+	// ResyncWarn only reports the metadata drift in pbm status.
+	ResyncWarn ResyncMode = "warn"
+	// ResyncApply imports backups added to the storage and
+	// expires metadata of backups removed from the storage.
+	ResyncApply ResyncMode = "apply"
+)
+
+const defaultResyncIntervalMin = 60
+
+// ResyncConf is config options for the periodic reconciliation
+// of the backups metadata with the main storage.
+type ResyncConf struct {
+	Mode        ResyncMode `bson:"mode,omitempty" json:"mode,omitempty" yaml:"mode,omitempty"`
+	IntervalMin float64    `bson:"intervalMin,omitempty" json:"intervalMin,omitempty" yaml:"intervalMin,omitempty"`
+}
+
+func (cfg *ResyncConf) Clone() *ResyncConf {
+	if cfg == nil {
+		return nil
+	}
+
+	rv := *cfg
+	return &rv
+}
+
+// ClusterConf is the identity of the cluster on the storages it uses
+type ClusterConf struct {
+	// Name is the human-readable name of the cluster saved along
+	// with the cluster id into the storage owner marker.
+	Name string `bson:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+
+	// WriteConcern is the write concern of the writes of PBM to its
+	// control collections and of the restores.
+	WriteConcern *WriteConcernConf `bson:"writeConcern,omitempty" json:"writeConcern,omitempty" yaml:"writeConcern,omitempty"`
+}
+
+func (cfg *ClusterConf) Clone() *ClusterConf {
+	if cfg == nil {
+		return nil
+	}
+
+	rv := *cfg
+	if cfg.WriteConcern != nil {
+		wc := *cfg.WriteConcern
+		if cfg.WriteConcern.J != nil {
+			j := *cfg.WriteConcern.J
+			wc.J = &j
+		}
+		rv.WriteConcern = &wc
+	}
+	return &rv
+}
+
+// WriteConcernConf is the write concern of PBM writes. The options not
+// set are left to the server: the default write concern of the cluster
+// (majority unless changed by setDefaultRWConcern), no time limit.
+type WriteConcernConf struct {
+	// W is "majority", the number of members or a tag set name.
+	// The default write concern of the cluster applies if not set.
+	W string `bson:"w,omitempty" json:"w,omitempty" yaml:"w,omitempty"`
+	// J requests the acknowledgment of the write to the on-disk journal
+	J *bool `bson:"j,omitempty" json:"j,omitempty" yaml:"j,omitempty"`
+	// WTimeout is the time limit of the write concern in milliseconds.
+	// 0 is no limit.
+	WTimeout int `bson:"wtimeout,omitempty" json:"wtimeout,omitempty" yaml:"wtimeout,omitempty"`
+}
+
+// WriteConcern returns the write concern of the options. W is left unset
+// if the options don't set it.
+func (cfg *WriteConcernConf) WriteConcern() *writeconcern.WriteConcern {
+	wc := &writeconcern.WriteConcern{}
+	if cfg.W != "" {
+		if n, err := strconv.Atoi(cfg.W); err == nil {
+			wc.W = n
+		} else {
+			wc.W = cfg.W
+		}
+	}
+	wc.Journal = cfg.J
+	wc.WTimeout = time.Duration(cfg.WTimeout) * time.Millisecond
+
+	return wc
+}
+
+func (cfg *WriteConcernConf) validate() []error {
+	var errs []error
+	if n, err := strconv.Atoi(cfg.W); err == nil && n < 1 {
+		errs = append(errs, errors.New("cluster.writeConcern.w: should be \"majority\", "+
+			"a tag set name or a number of members greater than 0"))
+	}
+	if cfg.WTimeout < 0 {
+		errs = append(errs, errors.New("cluster.writeConcern.wtimeout: cannot be negative"))
+	}
+
+	return errs
+}
+
+// AgentConf is config options of the agents process
+type AgentConf struct {
+	// MaxMemoryMB is the memory budget of the buffers of an agent
+	// (compression, uploads, restore read-ahead and batches).
+	// Unlimited if not set.
+	MaxMemoryMB int `bson:"maxMemoryMB,omitempty" json:"maxMemoryMB,omitempty" yaml:"maxMemoryMB,omitempty"`
+
+	// ClockSkew is the check of the agents clocks against the cluster time
+	ClockSkew *ClockSkewConf `bson:"clockSkew,omitempty" json:"clockSkew,omitempty" yaml:"clockSkew,omitempty"`
+}
+
+// ClockSkewConf is the check of the offset of the agents wall clocks
+// from the cluster time.
+type ClockSkewConf struct {
+	// MaxSec is the offset the clock of an agent is considered skewed
+	// beyond. Default is defs.DefaultMaxClockSkewSec.
+	MaxSec int `bson:"maxSec,omitempty" json:"maxSec,omitempty" yaml:"maxSec,omitempty"`
+	// FailOnSkew makes skewed nodes refuse to lead backups and restores,
+	// and keeps them from being nominated for backups and PITR slicing.
+	// Otherwise, the skew is only warned about.
+	FailOnSkew bool `bson:"failOnSkew,omitempty" json:"failOnSkew,omitempty" yaml:"failOnSkew,omitempty"`
+}
+
+func (cfg *AgentConf) Clone() *AgentConf {
+	if cfg == nil {
+		return nil
+	}
+
+	rv := *cfg
+	if cfg.ClockSkew != nil {
+		c := *cfg.ClockSkew
+		rv.ClockSkew = &c
+	}
+	return &rv
+}
+
+// MaxClockSkew returns the offset from the cluster time the clock of
+// an agent is considered skewed beyond.
+func (cfg *AgentConf) MaxClockSkew() time.Duration {
+	if cfg == nil || cfg.ClockSkew == nil || cfg.ClockSkew.MaxSec <= 0 {
+		return defs.DefaultMaxClockSkewSec * time.Second
+	}
+	return time.Duration(cfg.ClockSkew.MaxSec) * time.Second
+}
+
+// FailOnClockSkew returns true if skewed nodes don't take part in
+// the time-sensitive operations (see ClockSkewConf.FailOnSkew).
+func (cfg *AgentConf) FailOnClockSkew() bool {
+	return cfg != nil && cfg.ClockSkew != nil && cfg.ClockSkew.FailOnSkew
+}
+
+// MaxMemory returns the memory budget of the agent in bytes.
+// 0 if unlimited.
+func (cfg *AgentConf) MaxMemory() int64 {
+	if cfg == nil || cfg.MaxMemoryMB <= 0 {
+		return 0
+	}
+	return int64(cfg.MaxMemoryMB) << 20
+}
+
+// LogConf is config options of the PBM log in the database
+type LogConf struct {
+	// RetentionDays is how long the log entries are kept. They expire by
+	// the TTL index the lead agent maintains (or are deleted by it if the
+	// log collection is capped). If not set, the entries are kept until
+	// the capped log collection overwrites them.
+	RetentionDays int `bson:"retentionDays,omitempty" json:"retentionDays,omitempty" yaml:"retentionDays,omitempty"`
+}
+
+func (cfg *LogConf) Clone() *LogConf {
+	if cfg == nil {
+		return nil
+	}
+
+	rv := *cfg
+	return &rv
+}
+
+// Retention returns how long the log entries are kept.
+// 0 if they aren't deleted by age.
+func (cfg *LogConf) Retention() time.Duration {
+	if cfg == nil || cfg.RetentionDays <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.RetentionDays) * 24 * time.Hour
+}
+
+// WriteConcern returns the configured write concern of PBM writes.
+// Nil if it isn't set, the one of the connection applies then.
+func (c *Config) WriteConcern() *writeconcern.WriteConcern {
+	if c == nil || c.Cluster == nil || c.Cluster.WriteConcern == nil {
+		return nil
+	}
+	return c.Cluster.WriteConcern.WriteConcern()
+}
+
+// ClusterName returns the configured name of the cluster. Empty if not set.
+func (c *Config) ClusterName() string {
+	if c == nil || c.Cluster == nil {
+		return ""
+	}
+	return c.Cluster.Name
+}
+
+// ResyncMode returns the reconciliation mode. If not set, returns ResyncOff.
+func (cfg *ResyncConf) ResyncMode() ResyncMode {
+	if cfg == nil || cfg.Mode == "" {
+		return ResyncOff
+	}
+
+	return cfg.Mode
+}
+
+// Interval returns the reconciliation interval. Default is 1 hour.
+func (cfg *ResyncConf) Interval() time.Duration {
+	if cfg == nil || cfg.IntervalMin <= 0 {
+		return defaultResyncIntervalMin * time.Minute
+	}
+
+	return time.Duration(cfg.IntervalMin * float64(time.Minute))
+}
+
 //nolint:lll
 type BackupConf struct {
 	OplogSpanMin     float64                  `bson:"oplogSpanMin" json:"oplogSpanMin" yaml:"oplogSpanMin"`
@@ -364,6 +1088,28 @@ type BackupConf struct {
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
 
 	NumParallelCollections int `bson:"numParallelCollections" json:"numParallelCollections,omitempty" yaml:"numParallelCollections,omitempty"`
+
+	Quiesce *BackupQuiesce `bson:"quiesce,omitempty" json:"quiesce,omitempty" yaml:"quiesce,omitempty"`
+	Hooks   *BackupHooks   `bson:"hooks,omitempty" json:"hooks,omitempty" yaml:"hooks,omitempty"`
+
+	ReadPreference *ReadPreference `bson:"readPreference,omitempty" json:"readPreference,omitempty" yaml:"readPreference,omitempty"`
+
+	ConsistencyCheck *BackupConsistencyCheck `bson:"consistencyCheck,omitempty" json:"consistencyCheck,omitempty" yaml:"consistencyCheck,omitempty"`
+
+	ParallelCompression *ParallelCompression `bson:"parallelCompression,omitempty" json:"parallelCompression,omitempty" yaml:"parallelCompression,omitempty"`
+
+	// MaxDuration (e.g. `4h`) is how long a backup may run before it is
+	// aborted. Empty means no limit. `pbm backup --max-duration` and
+	// schedules override it.
+	MaxDuration string `bson:"maxDuration,omitempty" json:"maxDuration,omitempty" yaml:"maxDuration,omitempty"`
+
+	Physical *BackupPhysical `bson:"physical,omitempty" json:"physical,omitempty" yaml:"physical,omitempty"`
+
+	OplogWindowCheck *BackupOplogWindowCheck `bson:"oplogWindowCheck,omitempty" json:"oplogWindowCheck,omitempty" yaml:"oplogWindowCheck,omitempty"`
+
+	CompressionAuto *BackupCompressionAuto `bson:"compressionAuto,omitempty" json:"compressionAuto,omitempty" yaml:"compressionAuto,omitempty"`
+
+	Queue *BackupQueue `bson:"queue,omitempty" json:"queue,omitempty" yaml:"queue,omitempty"`
 }
 
 func (cfg *BackupConf) Clone() *BackupConf {
@@ -375,23 +1121,390 @@ func (cfg *BackupConf) Clone() *BackupConf {
 
 	rv.Priority = maps.Clone(cfg.Priority)
 	if cfg.Timeouts != nil {
-		if cfg.Timeouts.Starting != nil {
-			rv.Timeouts = &BackupTimeouts{
-				Starting: cfg.Timeouts.Starting,
-			}
-		}
+		t := *cfg.Timeouts
+		rv.Timeouts = &t
 	}
 	if cfg.CompressionLevel != nil {
 		a := *cfg.CompressionLevel
 		rv.CompressionLevel = &a
 	}
+	if cfg.Quiesce != nil {
+		q := *cfg.Quiesce
+		rv.Quiesce = &q
+	}
+	rv.Hooks = cfg.Hooks.Clone()
+	rv.ReadPreference = cfg.ReadPreference.Clone()
+	if cfg.ConsistencyCheck != nil {
+		c := *cfg.ConsistencyCheck
+		if c.CountTolerance != nil {
+			t := *c.CountTolerance
+			c.CountTolerance = &t
+		}
+		rv.ConsistencyCheck = &c
+	}
+	if cfg.ParallelCompression != nil {
+		p := *cfg.ParallelCompression
+		rv.ParallelCompression = &p
+	}
+	if cfg.Physical != nil {
+		rv.Physical = &BackupPhysical{
+			ExcludeDatabases: slices.Clone(cfg.Physical.ExcludeDatabases),
+		}
+	}
+	if cfg.OplogWindowCheck != nil {
+		c := *cfg.OplogWindowCheck
+		if c.MarginPercent != nil {
+			m := *c.MarginPercent
+			c.MarginPercent = &m
+		}
+		rv.OplogWindowCheck = &c
+	}
+	if cfg.CompressionAuto != nil {
+		c := *cfg.CompressionAuto
+		rv.CompressionAuto = &c
+	}
+	if cfg.Queue != nil {
+		q := *cfg.Queue
+		rv.Queue = &q
+	}
+
+	return &rv
+}
+
+// ExcludedDatabases returns the databases physical backups skip.
+func (cfg *BackupConf) ExcludedDatabases() []string {
+	if cfg == nil || cfg.Physical == nil {
+		return nil
+	}
+	return cfg.Physical.ExcludeDatabases
+}
+
+// MaxRunTime returns the max duration of backups. Zero means no limit.
+func (cfg *BackupConf) MaxRunTime() time.Duration {
+	if cfg == nil {
+		return 0
+	}
+	return parseMaxDuration(cfg.MaxDuration)
+}
+
+// parseMaxDuration returns zero for empty or invalid durations
+// (the config validation rejects the invalid ones).
+func parseMaxDuration(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// BackupCompressionAuto is the config of `compression: auto`. The backup
+// benchmarks the compressions on a sample of the data at the start and
+// uses the best one for the target.
+//
+//nolint:lll
+type BackupCompressionAuto struct {
+	// Target is `time` (default) for the fastest backup or `size` for
+	// the smallest one not slower than the upload of the raw data.
+	Target compress.AutoTarget `bson:"target,omitempty" json:"target,omitempty" yaml:"target,omitempty"`
+	// UploadRateMb is the expected upload rate (MB/s) to the storage.
+	// Default is defs.DefaultCompressionAutoUploadRateMb.
+	UploadRateMb int `bson:"uploadRateMb,omitempty" json:"uploadRateMb,omitempty" yaml:"uploadRateMb,omitempty"`
+}
+
+// AutoTarget returns the target of the auto compression.
+func (c *BackupCompressionAuto) AutoTarget() compress.AutoTarget {
+	if c == nil || c.Target == "" {
+		return compress.AutoTargetTime
+	}
+	return c.Target
+}
+
+// UploadRate returns the expected upload rate in MB/s.
+func (c *BackupCompressionAuto) UploadRate() int {
+	if c == nil || c.UploadRateMb <= 0 {
+		return defs.DefaultCompressionAutoUploadRateMb
+	}
+	return c.UploadRateMb
+}
+
+// BackupQueue is the config of the queue of backups deferred while
+// another operation is running (see `pbm backup --queue`). The lead
+// agent starts the queued backups in the submission order.
+//
+//nolint:lll
+type BackupQueue struct {
+	// Enabled queues `pbm backup` by default, as with `--queue`.
+	Enabled bool `bson:"enabled,omitempty" json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// TTLMin is how long (in minutes) a backup waits in the queue before
+	// it expires. Default is defs.DefaultQueueTTLMin.
+	TTLMin int `bson:"ttlMin,omitempty" json:"ttlMin,omitempty" yaml:"ttlMin,omitempty"`
+	// KeepDuplicates queues the backup even if the same backup is the
+	// last one in the queue. Otherwise, they are collapsed into one.
+	KeepDuplicates bool `bson:"keepDuplicates,omitempty" json:"keepDuplicates,omitempty" yaml:"keepDuplicates,omitempty"`
+}
+
+// IsEnabled returns true if `pbm backup` is queued by default.
+func (c *BackupQueue) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// TTL returns how long a backup waits in the queue.
+func (c *BackupQueue) TTL() time.Duration {
+	if c == nil || c.TTLMin <= 0 {
+		return defs.DefaultQueueTTLMin * time.Minute
+	}
+	return time.Duration(c.TTLMin) * time.Minute
+}
+
+// Collapse returns true if the same backups in a row are collapsed.
+func (c *BackupQueue) Collapse() bool {
+	return c == nil || !c.KeepDuplicates
+}
+
+// BackupPhysical is the config of physical backups.
+//
+//nolint:lll
+type BackupPhysical struct {
+	// ExcludeDatabases are the databases which data files aren't copied.
+	// The backup is partial: the databases are missing after the restore
+	// and the backup isn't a base for point-in-time recovery.
+	ExcludeDatabases []string `bson:"excludeDatabases,omitempty" json:"excludeDatabases,omitempty" yaml:"excludeDatabases,omitempty"`
+}
+
+// ParallelCompression splits the zstd and s2 compressed backup data into
+// frames compressed by a pool of workers (see compress.Pool).
+//
+//nolint:lll
+type ParallelCompression struct {
+	Disabled bool `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Workers is the number of frames compressed at once by the node.
+	// Default is half of the CPUs.
+	Workers int `bson:"workers,omitempty" json:"workers,omitempty" yaml:"workers,omitempty"`
+	// FrameSizeMb is the size of the data compressed by a worker at once.
+	// Default is 4.
+	FrameSizeMb int `bson:"frameSizeMb,omitempty" json:"frameSizeMb,omitempty" yaml:"frameSizeMb,omitempty"`
+}
+
+// Pool returns the compression pool. Nil if the parallel compression is
+// disabled or there are less than 2 workers.
+func (c *ParallelCompression) Pool() *compress.Pool {
+	if c != nil && c.Disabled {
+		return nil
+	}
+
+	workers := runtime.GOMAXPROCS(0) / 2
+	frameSize := 0
+	if c != nil {
+		if c.Workers > 0 {
+			workers = c.Workers
+		}
+		frameSize = c.FrameSizeMb << 20
+	}
+	if workers < 2 {
+		return nil
+	}
+
+	return compress.NewPool(workers, frameSize)
+}
+
+// BackupConsistencyCheck is the check of sharded logical backups made by
+// the backup leader before the backup is marked as done. The namespaces and
+// documents count dumped by each shard are compared with the shard state at
+// the backup start, and the shards owning chunks with the backup shards.
+//
+//nolint:lll
+type BackupConsistencyCheck struct {
+	// Disabled skips the check (e.g. for clusters with lots of collections).
+	Disabled bool `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// FailOnMismatch marks the backup as failed on discrepancies.
+	// Otherwise, the backup is done and the discrepancies are recorded
+	// as its warnings.
+	FailOnMismatch bool `bson:"failOnMismatch,omitempty" json:"failOnMismatch,omitempty" yaml:"failOnMismatch,omitempty"`
+	// CountTolerance is the percent of the documents of a collection that
+	// may be missing in the dump (e.g. deleted during the backup).
+	// Default is 10.
+	CountTolerance *float64 `bson:"countTolerance,omitempty" json:"countTolerance,omitempty" yaml:"countTolerance,omitempty"`
+}
+
+// IsEnabled returns true unless the check is disabled.
+func (c *BackupConsistencyCheck) IsEnabled() bool {
+	return c == nil || !c.Disabled
+}
+
+// IsFailOnMismatch returns true if the backup fails on discrepancies.
+func (c *BackupConsistencyCheck) IsFailOnMismatch() bool {
+	return c != nil && c.FailOnMismatch
+}
+
+// Tolerance returns the count tolerance in percent.
+// If not set, returns default value (DefaultConsistencyCountTolerance).
+func (c *BackupConsistencyCheck) Tolerance() float64 {
+	if c == nil || c.CountTolerance == nil {
+		return defs.DefaultConsistencyCountTolerance
+	}
+	return *c.CountTolerance
+}
+
+// BackupOplogWindowCheck is the check of the oplog windows made by
+// the backup leader before the backup starts. The backup duration
+// estimated by the previous backups of the same type should fit into
+// the oplog window of each replset with the margin. Otherwise, the oplog
+// may roll over during the backup and the backup will fail.
+//
+//nolint:lll
+type BackupOplogWindowCheck struct {
+	// Disabled skips the check.
+	Disabled bool `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// FailOnShort refuses to start the backup if a window is too short.
+	// Otherwise, the backup starts with a warning.
+	FailOnShort bool `bson:"failOnShort,omitempty" json:"failOnShort,omitempty" yaml:"failOnShort,omitempty"`
+	// MarginPercent is how much (in percent of the estimated duration)
+	// the window should exceed the backup duration. Default is 50.
+	MarginPercent *float64 `bson:"marginPercent,omitempty" json:"marginPercent,omitempty" yaml:"marginPercent,omitempty"`
+}
+
+// IsEnabled returns true unless the check is disabled.
+func (c *BackupOplogWindowCheck) IsEnabled() bool {
+	return c == nil || !c.Disabled
+}
+
+// Margin returns the margin in percent.
+// If not set, returns default value (DefaultOplogWindowMargin).
+func (c *BackupOplogWindowCheck) Margin() float64 {
+	if c == nil || c.MarginPercent == nil {
+		return defs.DefaultOplogWindowMargin
+	}
+	return *c.MarginPercent
+}
+
+// BackupQuiesce describes the node preparation before opening of the backup
+// cursor for physical (and incremental, external) backups.
+// It is a no-op for logical backups.
+//
+//nolint:lll
+type BackupQuiesce struct {
+	Enabled bool `bson:"enabled" json:"enabled" yaml:"enabled"`
+	// WaitIndexBuilds makes the node wait for in-progress index builds
+	// to finish before the checkpoint is triggered. The builds can't be
+	// paused, MongoDB is only able to abort them.
+	WaitIndexBuilds bool `bson:"waitIndexBuilds,omitempty" json:"waitIndexBuilds,omitempty" yaml:"waitIndexBuilds,omitempty"`
+	// Timeout (in seconds) to quiesce the node. Default is 20 seconds.
+	// It should be less than `backup.timeouts.startingStatus`.
+	Timeout uint32 `bson:"timeout,omitempty" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// FailOnTimeout fails the backup if the node wasn't quiesced in time.
+	// Otherwise, the backup proceeds as is.
+	FailOnTimeout bool `bson:"failOnTimeout,omitempty" json:"failOnTimeout,omitempty" yaml:"failOnTimeout,omitempty"`
+}
+
+// IsEnabled returns true if the quiescing is configured.
+func (q *BackupQuiesce) IsEnabled() bool {
+	return q != nil && q.Enabled
+}
+
+// TimeoutDuration returns timeout duration for the node quiescing.
+// If not set or zero, returns default value (DefaultQuiesceTimeout).
+func (q *BackupQuiesce) TimeoutDuration() time.Duration {
+	if q == nil || q.Timeout == 0 {
+		return defs.DefaultQuiesceTimeout
+	}
+
+	return time.Duration(q.Timeout) * time.Second
+}
+
+// BackupHooks are commands run on the node making the snapshot backup
+// of its replset. Pre runs before the data copy starts, Post runs after
+// the backup is done, its failure is only logged. They don't run for
+// PITR oplog chunks.
+type BackupHooks struct {
+	Pre  *Hook `bson:"pre,omitempty" json:"pre,omitempty" yaml:"pre,omitempty"`
+	Post *Hook `bson:"post,omitempty" json:"post,omitempty" yaml:"post,omitempty"`
+}
+
+func (h *BackupHooks) Clone() *BackupHooks {
+	if h == nil {
+		return nil
+	}
+
+	return &BackupHooks{
+		Pre:  h.Pre.Clone(),
+		Post: h.Post.Clone(),
+	}
+}
+
+// PreHook returns the pre-backup hook. It's nil if not set.
+func (h *BackupHooks) PreHook() *Hook {
+	if h == nil {
+		return nil
+	}
+	return h.Pre
+}
+
+// PostHook returns the post-backup hook. It's nil if not set.
+func (h *BackupHooks) PostHook() *Hook {
+	if h == nil {
+		return nil
+	}
+	return h.Post
+}
+
+// HookFailurePolicy defines what to do if the hook command fails.
+type HookFailurePolicy string
+
+const (
+	// HookAbort fails the operation (default)
+	HookAbort HookFailurePolicy = "abort"
+	// HookWarn logs the failure and proceeds with the operation
+	HookWarn HookFailurePolicy = "warn"
+)
+
+// Hook is a shell command run by the agent (with `/bin/sh -c`).
+//
+//nolint:lll
+type Hook struct {
+	Cmd string `bson:"cmd" json:"cmd" yaml:"cmd"`
+	// Timeout (in seconds) of the command. Default is 20 seconds.
+	Timeout   uint32            `bson:"timeout,omitempty" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	OnFailure HookFailurePolicy `bson:"onFailure,omitempty" json:"onFailure,omitempty" yaml:"onFailure,omitempty"`
+}
 
+func (h *Hook) Clone() *Hook {
+	if h == nil {
+		return nil
+	}
+
+	rv := *h
 	return &rv
 }
 
+// IsEnabled returns true if the hook has a command.
+func (h *Hook) IsEnabled() bool {
+	return h != nil && h.Cmd != ""
+}
+
+// TimeoutDuration returns the command timeout.
+// If not set or zero, returns default value (DefaultHookTimeout).
+func (h *Hook) TimeoutDuration() time.Duration {
+	if h == nil || h.Timeout == 0 {
+		return defs.DefaultHookTimeout
+	}
+
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// Policy returns the failure policy. HookAbort is the default.
+func (h *Hook) Policy() HookFailurePolicy {
+	if h.OnFailure == "" {
+		return HookAbort
+	}
+	return h.OnFailure
+}
+
 type BackupTimeouts struct {
 	// Starting is timeout (in seconds) to wait for a backup to start.
 	Starting *uint32 `bson:"startingStatus,omitempty" json:"startingStatus,omitempty" yaml:"startingStatus,omitempty"`
+	// OplogRetry is how long (in seconds) to try to resume the oplog
+	// tailing of the backup after the node failover.
+	OplogRetry *uint32 `bson:"oplogRetrySec,omitempty" json:"oplogRetrySec,omitempty" yaml:"oplogRetrySec,omitempty"`
 }
 
 // StartingStatus returns timeout duration for .
@@ -404,6 +1517,16 @@ func (t *BackupTimeouts) StartingStatus() time.Duration {
 	return time.Duration(*t.Starting) * time.Second
 }
 
+// OplogRetryWindow returns how long to try to resume the oplog tailing.
+// If not set or zero, returns default value (DefaultOplogRetryWindow).
+func (t *BackupTimeouts) OplogRetryWindow() time.Duration {
+	if t == nil || t.OplogRetry == nil || *t.OplogRetry == 0 {
+		return defs.DefaultOplogRetryWindow
+	}
+
+	return time.Duration(*t.OplogRetry) * time.Second
+}
+
 func GetConfig(ctx context.Context, m connect.Client) (*Config, error) {
 	res := m.ConfigCollection().FindOne(ctx, bson.D{{"profile", nil}})
 	if err := res.Err(); err != nil {
@@ -415,6 +1538,13 @@ func GetConfig(ctx context.Context, m connect.Client) (*Config, error) {
 		return nil, errors.Wrap(err, "decode")
 	}
 
+	applyDefaults(cfg)
+
+	return cfg, nil
+}
+
+// applyDefaults sets missed sections and the default compression.
+func applyDefaults(cfg *Config) {
 	if cfg.PITR == nil {
 		cfg.PITR = &PITRConf{}
 	}
@@ -430,12 +1560,15 @@ func GetConfig(ctx context.Context, m connect.Client) (*Config, error) {
 	}
 	if cfg.PITR.Compression == "" {
 		cfg.PITR.Compression = cfg.Backup.Compression
+		// PITR chunks are compressed as they are made
+		if cfg.PITR.Compression == compress.CompressionTypeAuto {
+			cfg.PITR.Compression = defs.DefaultCompression
+		}
 	}
-	if cfg.PITR.CompressionLevel == nil {
+	// the level is specific to the compression type
+	if cfg.PITR.CompressionLevel == nil && cfg.PITR.Compression == cfg.Backup.Compression {
 		cfg.PITR.CompressionLevel = cfg.Backup.CompressionLevel
 	}
-
-	return cfg, nil
 }
 
 // SetConfig stores config doc within the database.
@@ -452,11 +1585,17 @@ func SetConfig(ctx context.Context, m connect.Client, cfg *Config) error {
 		s3.SDKLogLevel(cfg.Storage.S3.DebugLogLevels, os.Stderr)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
 	if cfg.PITR != nil {
-		if c := string(cfg.PITR.Compression); c != "" && !compress.IsValidCompressionType(c) {
-			return errors.Errorf("unsupported compression type: %q", c)
+		if _, err := ns.NewMatcher(cfg.PITR.ExcludeNamespaces); err != nil {
+			return errors.Wrap(err, "pitr.excludeNamespaces")
 		}
 	}
+	if err := CheckReplsetStorages(ctx, m, cfg); err != nil {
+		return err
+	}
 
 	ct, err := topo.GetClusterTime(ctx, m)
 	if err != nil {
@@ -476,22 +1615,71 @@ func SetConfig(ctx context.Context, m connect.Client, cfg *Config) error {
 	return errors.Wrap(err, "mongo defs.ConfigCollection UpdateOne")
 }
 
+func validateOplogSpanMin(m float64) error {
+	if time.Duration(m*float64(time.Minute)) < defs.MinPITRInterval {
+		return errors.Errorf("oplog span %v min is less than allowed %v", m, defs.MinPITRInterval)
+	}
+
+	return nil
+}
+
 func SetConfigVar(ctx context.Context, m connect.Client, key, val string) error {
-	if !validateConfigKey(key) {
-		return errors.New("invalid config key")
+	v, err := parseConfigVar(key, val)
+	if err != nil {
+		return err
 	}
 
-	// just check if config was set
-	_, err := GetConfig(ctx, m)
+	// check if config was set and the new value makes it valid
+	cfg, err := GetConfig(ctx, m)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return errors.New("config is not set")
 		}
 		return err
 	}
+	if _, err := applyConfigVar(cfg, key, v); err != nil {
+		return err
+	}
+
+	// TODO: how to be with special case options like pitr.enabled
+	switch key {
+	case "pitr.enabled":
+		return errors.Wrap(confSetPITR(ctx, m, v.(bool)), "write to db")
+	case "storage.s3.debugLogLevels":
+		s3.SDKLogLevel(v.(string), os.Stderr)
+	}
+
+	upd := bson.M{"$set": bson.M{key: v}}
+	if isOplogSpanMinRSKey(key) && v == 0.0 {
+		upd = bson.M{"$unset": bson.M{key: 1}}
+	}
+	_, err = m.ConfigCollection().UpdateOne(ctx, bson.D{{"profile", nil}}, upd)
+	return errors.Wrap(err, "write to db")
+}
+
+// PreviewConfigVar returns a copy of the cfg with the key set to val.
+// The value is validated the same way SetConfigVar does
+// but nothing is written to the database.
+func PreviewConfigVar(cfg *Config, key, val string) (*Config, error) {
+	v, err := parseConfigVar(key, val)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyConfigVar(cfg, key, v)
+}
+
+// parseConfigVar casts the string value to the type of the config key.
+func parseConfigVar(key, val string) (interface{}, error) {
+	if !validateConfigKey(key) {
+		return nil, errors.New("invalid config key")
+	}
+
+	kind, _ := configKeyKind(key)
 
 	var v interface{}
-	switch _confmap[key] {
+	var err error
+	switch kind {
 	case reflect.String:
 		v = val
 	case reflect.Uint, reflect.Uint32:
@@ -510,29 +1698,100 @@ func SetConfigVar(ctx context.Context, m connect.Client, key, val string) error
 		v, err = strconv.ParseBool(val)
 	}
 	if err != nil {
-		return errors.Wrapf(err, "casting value of %s", key)
+		return nil, errors.Wrapf(err, "casting value of %s", key)
+	}
+
+	if f, ok := scheduleKeyField(key); ok && f == "labels" {
+		v, err = parseLabels(val)
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
 	}
 
-	// TODO: how to be with special case options like pitr.enabled
 	switch key {
-	case "pitr.enabled":
-		return errors.Wrap(confSetPITR(ctx, m, v.(bool)), "write to db")
 	case "pitr.compression":
 		if c := v.(string); c != "" && !compress.IsValidCompressionType(c) {
-			return errors.Errorf("unsupported compression type: %q", c)
+			return nil, errors.Errorf("unsupported compression type: %q", c)
 		}
+	case "pitr.excludeNamespaces":
+		nss := splitList(val)
+		if _, err := ns.NewMatcher(nss); err != nil {
+			return nil, errors.Wrap(err, "pitr.excludeNamespaces")
+		}
+		v = nss
+	case "backup.physical.excludeDatabases":
+		v = splitList(val)
 	case "storage.filesystem.path":
 		if v.(string) == "" {
-			return errors.New("storage.filesystem.path can't be empty")
+			return nil, errors.New("storage.filesystem.path can't be empty")
 		}
-	case "storage.s3.debugLogLevels":
-		s3.SDKLogLevel(v.(string), os.Stderr)
 	}
 
-	_, err = m.ConfigCollection().UpdateOne(ctx,
-		bson.D{{"profile", nil}},
-		bson.M{"$set": bson.M{key: v}})
-	return errors.Wrap(err, "write to db")
+	return v, nil
+}
+
+// splitList returns the non-empty items of the comma-separated list
+func splitList(val string) []string {
+	rv := []string{}
+	for _, s := range strings.Split(val, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			rv = append(rv, s)
+		}
+	}
+	return rv
+}
+
+// applyConfigVar returns a validated copy of the cfg with the key set to v.
+func applyConfigVar(cfg *Config, key string, v interface{}) (*Config, error) {
+	raw, err := bson.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal config")
+	}
+	doc := bson.M{}
+	err = bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal config")
+	}
+
+	path := strings.Split(key, ".")
+	sub := doc
+	for _, p := range path[:len(path)-1] {
+		next, ok := sub[p].(bson.M)
+		if !ok {
+			next = bson.M{}
+			sub[p] = next
+		}
+		sub = next
+	}
+	// zero removes the oplog span override of the replset
+	if isOplogSpanMinRSKey(key) && v == 0.0 {
+		delete(sub, path[len(path)-1])
+	} else {
+		sub[path[len(path)-1]] = v
+	}
+
+	raw, err = bson.Marshal(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal config")
+	}
+	rv := &Config{}
+	err = bson.Unmarshal(raw, rv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "set %s", key)
+	}
+
+	err = rv.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// isOplogSpanMinRSKey checks if the key is `pitr.oplogSpanMinRS.<replset>`
+func isOplogSpanMinRSKey(key string) bool {
+	rs, ok := strings.CutPrefix(key, "pitr.oplogSpanMinRS.")
+	return ok && rs != "" && !strings.Contains(rs, ".")
 }
 
 func confSetPITR(ctx context.Context, m connect.Client, value bool) error {
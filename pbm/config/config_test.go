@@ -0,0 +1,20 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+)
+
+func TestBackupConsistencyCheckDefaults(t *testing.T) {
+	var c *BackupConsistencyCheck
+	if !c.IsEnabled() || c.IsFailOnMismatch() || c.Tolerance() != defs.DefaultConsistencyCountTolerance {
+		t.Errorf("nil check: enabled %v, fail on mismatch %v, tolerance %v",
+			c.IsEnabled(), c.IsFailOnMismatch(), c.Tolerance())
+	}
+
+	c = &BackupConsistencyCheck{FailOnMismatch: true}
+	if !c.IsFailOnMismatch() {
+		t.Error("fail on mismatch is not set")
+	}
+}
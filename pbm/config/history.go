@@ -0,0 +1,276 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// Change is a change of a single config field.
+// Secret values are masked.
+type Change struct {
+	Path string `bson:"path" json:"path"`
+	Old  string `bson:"old,omitempty" json:"old,omitempty"`
+	New  string `bson:"new,omitempty" json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("+ %s: %s", c.Path, c.New)
+	case c.New == "":
+		return fmt.Sprintf("- %s: %s", c.Path, c.Old)
+	}
+
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// Diff returns field-by-field changes between old and new configs
+// sorted by the field path. Defaults are applied to both sides, so
+// the omitted value and the default one are the same.
+// Secret values are masked, though the change of the secret is reported.
+func Diff(oldCfg, newCfg *Config) ([]Change, error) {
+	if oldCfg == nil {
+		oldCfg = &Config{}
+	}
+	if newCfg == nil {
+		newCfg = &Config{}
+	}
+	oldCfg, newCfg = oldCfg.Clone(), newCfg.Clone()
+	applyDefaults(oldCfg)
+	applyDefaults(newCfg)
+
+	oldRaw, err := flattenConfig(oldCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "old config")
+	}
+	newRaw, err := flattenConfig(newCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "new config")
+	}
+	oldMasked, err := flattenYAML(oldCfg.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "old config")
+	}
+	newMasked, err := flattenYAML(newCfg.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "new config")
+	}
+
+	paths := make(map[string]struct{}, len(oldRaw)+len(newRaw))
+	for p := range oldRaw {
+		paths[p] = struct{}{}
+	}
+	for p := range newRaw {
+		paths[p] = struct{}{}
+	}
+
+	rv := []Change{}
+	for p := range paths {
+		if oldRaw[p] == newRaw[p] {
+			continue
+		}
+		rv = append(rv, Change{Path: p, Old: oldMasked[p], New: newMasked[p]})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Path < rv[j].Path })
+
+	return rv, nil
+}
+
+func flattenConfig(c *Config) (map[string]string, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal")
+	}
+
+	return flattenYAML(string(b))
+}
+
+// flattenYAML returns leaf values of the YAML doc by their paths.
+func flattenYAML(s string) (map[string]string, error) {
+	var doc map[any]any
+	err := yaml.Unmarshal([]byte(s), &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+
+	rv := make(map[string]string)
+	flattenTo(rv, "", doc)
+	return rv, nil
+}
+
+func flattenTo(dst map[string]string, path string, v any) {
+	switch v := v.(type) {
+	case map[any]any:
+		for k, val := range v {
+			p := toString(k)
+			if path != "" {
+				p = path + "." + p
+			}
+			flattenTo(dst, p, val)
+		}
+	case []any:
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = fmt.Sprint(v[i])
+		}
+		dst[path] = strings.Join(s, ",")
+	case nil:
+	default:
+		dst[path] = fmt.Sprint(v)
+	}
+}
+
+// HistoryEntry is a record about applied config change.
+type HistoryEntry struct {
+	// Version is the sequence number of the applied config.
+	// Zero for records made before the config versioning.
+	Version int64 `bson:"version,omitempty" json:"version,omitempty"`
+	// Time is unix time of the change
+	Time int64 `bson:"time" json:"time"`
+	// User is who made the change. Older records have `user@host`,
+	// the newer ones the initiator of the change (see ctrl.Initiator).
+	User string `bson:"user" json:"user"`
+	// Source of the change (e.g. `file`, `set` or `rollback`, and
+	// `restore` or `pitr-restart` for the changes made by agents)
+	Source string `bson:"source" json:"source"`
+	// RollbackOf is the version the config was rolled back to.
+	RollbackOf int64    `bson:"rollbackOf,omitempty" json:"rollbackOf,omitempty"`
+	Changes    []Change `bson:"changes" json:"changes"`
+	// Config is the applied config with the secret values masked
+	// (see RollbackConfig). It is never printed.
+	Config *Config `bson:"config,omitempty" json:"-"`
+}
+
+// HistoryLimit is how many last versions the config history keeps
+const HistoryLimit = 1000
+
+// historyInsertAttempts is how many times AddHistory tries to take
+// the next version if it's taken by a concurrent change
+const historyInsertAttempts = 5
+
+// AddHistory records the config change and sets the entry version
+// to the next after the last recorded one. The versions are unique by
+// the index, so the concurrent change takes the version after.
+// The config is stored with the secret values masked.
+// The versions older than the last HistoryLimit are removed.
+func AddHistory(ctx context.Context, m connect.Client, e *HistoryEntry) error {
+	doc := *e
+	doc.Config = e.Config.maskSecrets()
+
+	for range historyInsertAttempts {
+		last, err := lastHistoryVersion(ctx, m)
+		if err != nil {
+			return errors.Wrap(err, "get last version")
+		}
+
+		doc.Version = last + 1
+		_, err = m.ConfigHistoryCollection().InsertOne(ctx, doc)
+		if err == nil {
+			e.Version = doc.Version
+			return errors.Wrap(trimHistory(ctx, m, doc.Version), "trim")
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return errors.Wrap(err, "insert")
+		}
+	}
+
+	return errors.Errorf("no free version after %d attempts", historyInsertAttempts)
+}
+
+// trimHistory removes the versions older than the last HistoryLimit
+// up to the version last
+func trimHistory(ctx context.Context, m connect.Client, last int64) error {
+	if last <= HistoryLimit {
+		return nil
+	}
+
+	_, err := m.ConfigHistoryCollection().DeleteMany(ctx,
+		bson.D{{"version", bson.D{{"$lte", last - HistoryLimit}}}})
+	return err
+}
+
+func lastHistoryVersion(ctx context.Context, m connect.Client) (int64, error) {
+	var e HistoryEntry
+	err := m.ConfigHistoryCollection().FindOne(ctx,
+		bson.D{},
+		options.FindOne().
+			SetSort(bson.D{{"version", -1}}).
+			SetProjection(bson.D{{"version", 1}}),
+	).Decode(&e)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return e.Version, nil
+}
+
+// GetHistory returns last `limit` config changes, the newest first.
+// Zero limit means no limit.
+func GetHistory(ctx context.Context, m connect.Client, limit int64) ([]HistoryEntry, error) {
+	opts := options.Find().SetSort(bson.D{{"time", -1}, {"_id", -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cur, err := m.ConfigHistoryCollection().Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	rv := []HistoryEntry{}
+	err = cur.All(ctx, &rv)
+	return rv, errors.Wrap(err, "decode")
+}
+
+// ErrUnknownVersion means there is no such config version in the history.
+var ErrUnknownVersion = errors.New("unknown config version")
+
+// GetHistoryVersion returns the history entry of the config version.
+func GetHistoryVersion(ctx context.Context, m connect.Client, v int64) (*HistoryEntry, error) {
+	e := &HistoryEntry{}
+	err := m.ConfigHistoryCollection().FindOne(ctx, bson.D{{"version", v}}).Decode(e)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUnknownVersion
+		}
+		return nil, errors.Wrap(err, "query")
+	}
+
+	return e, nil
+}
+
+// RollbackConfig returns the config of the entry to apply again.
+// The masked secret values are taken from the current config cur,
+// it's an error if it doesn't have them.
+// The config goes through the validation as any new one, and
+// its epoch is reset so SetConfig bumps it and agents reload the config.
+func (e *HistoryEntry) RollbackConfig(cur *Config) (*Config, error) {
+	if e.Config == nil {
+		return nil, errors.Errorf("version %d has no stored config", e.Version)
+	}
+
+	cfg := e.Config.Clone()
+	cfg.Epoch = primitive.Timestamp{}
+	if err := cfg.unmaskSecrets(cur); err != nil {
+		return nil, errors.Wrapf(err, "version %d", e.Version)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "version %d", e.Version)
+	}
+
+	return cfg, nil
+}
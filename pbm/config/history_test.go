@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
+)
+
+func TestRollbackConfig(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`
+storage:
+  type: s3
+  s3:
+    bucket: b
+    region: us-east-1
+    credentials:
+      access-key-id: key1
+      secret-access-key: secret1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Epoch = primitive.Timestamp{T: 100, I: 1}
+
+	e := &HistoryEntry{Version: 3, Config: cfg.maskSecrets()}
+	if got := e.Config.Storage.S3.Credentials.SecretAccessKey; got != redacted {
+		t.Errorf("secret is not masked: %q", got)
+	}
+	if cfg.Storage.S3.Credentials.SecretAccessKey != "secret1" {
+		t.Error("masking modified the config")
+	}
+
+	rb, err := e.RollbackConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !rb.Epoch.IsZero() {
+		t.Errorf("epoch is not reset: %v", rb.Epoch)
+	}
+	if rb.Storage.S3.Credentials.SecretAccessKey != "secret1" {
+		t.Errorf("secret is not restored: %q", rb.Storage.S3.Credentials.SecretAccessKey)
+	}
+	if e.Config.Epoch.T != 100 {
+		t.Error("stored config is modified")
+	}
+
+	if _, err := e.RollbackConfig(&Config{}); err == nil ||
+		!strings.Contains(err.Error(), "storage.s3.credentials.") {
+		t.Errorf("expected unrecoverable secret error, got %v", err)
+	}
+
+	if _, err := (&HistoryEntry{Version: 1}).RollbackConfig(nil); err == nil ||
+		!strings.Contains(err.Error(), "no stored config") {
+		t.Errorf("expected no stored config error, got %v", err)
+	}
+
+	lvl := 100
+	bad := &HistoryEntry{Version: 2, Config: &Config{Backup: &BackupConf{Compression: "zstd", CompressionLevel: &lvl}}}
+	if _, err := bad.RollbackConfig(nil); err == nil || !strings.Contains(err.Error(), "backup.compressionLevel") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
+
+func TestUnmaskWebhookSecrets(t *testing.T) {
+	stored := &Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+		{URL: "https://a", Secret: "sa"},
+		{URL: "https://b", Secret: "sb"},
+	}}}
+	masked := stored.maskSecrets()
+
+	cur := &Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+		{URL: "https://b", Secret: "sb2"},
+		{URL: "https://a", Secret: "sa2"},
+	}}}
+	if err := masked.unmaskSecrets(cur); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w := masked.Notifications.Webhooks; w[0].Secret != "sa2" || w[1].Secret != "sb2" {
+		t.Errorf("secrets are not matched by the URL: %+v", w)
+	}
+
+	masked = stored.maskSecrets()
+	cur.Notifications.Webhooks = cur.Notifications.Webhooks[:1]
+	if err := masked.unmaskSecrets(cur); err == nil || !strings.Contains(err.Error(), "https://a") {
+		t.Errorf("expected unrecoverable secret error, got %v", err)
+	}
+}
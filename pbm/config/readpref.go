@@ -0,0 +1,104 @@
+package config
+
+import (
+	"maps"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// minMaxStalenessSec is the smallest maxStalenessSeconds MongoDB accepts
+const minMaxStalenessSec = 90
+
+// ReadPreference selects the node of the replset the logical backup dumps
+// the data from. Unlike the driver read preference, hidden members are
+// eligible for it. It narrows the nodes chosen by `backup.priority`.
+//
+//nolint:lll
+type ReadPreference struct {
+	// Mode is one of primary, primaryPreferred, secondary,
+	// secondaryPreferred or nearest.
+	Mode string `bson:"mode" json:"mode" yaml:"mode"`
+	// TagSets are tried in order, the first one matching any node is used.
+	// They aren't applied to the primary unless the mode is nearest.
+	TagSets []map[string]string `bson:"tagSets,omitempty" json:"tagSets,omitempty" yaml:"tagSets,omitempty"`
+	// MaxStalenessSeconds excludes the secondaries lagging behind
+	// the primary more than that. Zero means no limit.
+	MaxStalenessSeconds int `bson:"maxStalenessSeconds,omitempty" json:"maxStalenessSeconds,omitempty" yaml:"maxStalenessSeconds,omitempty"`
+}
+
+func (p *ReadPreference) Clone() *ReadPreference {
+	if p == nil {
+		return nil
+	}
+
+	rv := *p
+	if p.TagSets != nil {
+		rv.TagSets = make([]map[string]string, len(p.TagSets))
+		for i, s := range p.TagSets {
+			rv.TagSets[i] = maps.Clone(s)
+		}
+	}
+	return &rv
+}
+
+// ReadPref returns the driver read preference
+func (p *ReadPreference) ReadPref() (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(p.Mode)
+	if err != nil {
+		return nil, errors.Wrap(err, "mode")
+	}
+
+	var opts []readpref.Option
+	if len(p.TagSets) != 0 {
+		opts = append(opts, readpref.WithTagSets(tag.NewTagSetsFromMaps(p.TagSets)...))
+	}
+	if p.MaxStalenessSeconds != 0 {
+		opts = append(opts, readpref.WithMaxStaleness(time.Duration(p.MaxStalenessSeconds)*time.Second))
+	}
+
+	rv, err := readpref.New(mode, opts...)
+	return rv, errors.Wrap(err, "primary mode cannot be combined with tagSets or maxStalenessSeconds")
+}
+
+// String returns the human-readable read preference.
+// E.g. `secondary(maxStaleness=2m0s tagSet=dc=backup)`.
+func (p *ReadPreference) String() string {
+	rp, err := p.ReadPref()
+	if err != nil {
+		return p.Mode
+	}
+	return rp.String()
+}
+
+func (p *ReadPreference) validate(section string) []error {
+	var errs []error
+	if _, err := p.ReadPref(); err != nil {
+		errs = append(errs, errors.Wrap(err, section))
+	}
+	if p.MaxStalenessSeconds < 0 {
+		errs = append(errs, errors.Errorf("%s.maxStalenessSeconds: cannot be negative", section))
+	} else if p.MaxStalenessSeconds > 0 && p.MaxStalenessSeconds < minMaxStalenessSec {
+		errs = append(errs, errors.Errorf("%s.maxStalenessSeconds: should be at least %d",
+			section, minMaxStalenessSec))
+	}
+
+	return errs
+}
+
+// BackupReadPrefRS returns the read preference of the logical backup
+// dump for the replset. The `replsets.<rs>.backup.readPreference`
+// override takes precedence over `backup.readPreference`.
+// It's nil if neither is set.
+func (c *Config) BackupReadPrefRS(rs string) *ReadPreference {
+	if r := c.Replsets[rs]; r != nil && r.Backup != nil && r.Backup.ReadPreference != nil {
+		return r.Backup.ReadPreference
+	}
+	if c.Backup != nil {
+		return c.Backup.ReadPreference
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"context"
+	"sort"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ReplsetConf overrides the config for the replset. E.g. a shard in another
+// region may have to keep its data in a regional bucket.
+type ReplsetConf struct {
+	// Storage is the name of the config profile which storage keeps the replset
+	// backup data and oplog chunks instead of the main storage. The backup
+	// metadata and data of other replsets stay on the main storage.
+	// It applies to backups to the main storage only (not to `--profile` ones).
+	Storage string `bson:"storage,omitempty" json:"storage,omitempty" yaml:"storage,omitempty"`
+
+	PITR   *ReplsetPITRConf   `bson:"pitr,omitempty" json:"pitr,omitempty" yaml:"pitr,omitempty"`
+	Backup *ReplsetBackupConf `bson:"backup,omitempty" json:"backup,omitempty" yaml:"backup,omitempty"`
+}
+
+// ReplsetBackupConf overrides the backup options for the replset.
+type ReplsetBackupConf struct {
+	ReadPreference *ReadPreference `bson:"readPreference,omitempty" json:"readPreference,omitempty" yaml:"readPreference,omitempty"`
+}
+
+// ReplsetPITRConf overrides the PITR options for the replset.
+// The chunk span is overridden by PITRConf.OplogSpanMinRS.
+type ReplsetPITRConf struct {
+	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
+	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+}
+
+func (c *ReplsetConf) Clone() *ReplsetConf {
+	if c == nil {
+		return nil
+	}
+
+	rv := *c
+	if c.PITR != nil {
+		p := *c.PITR
+		if c.PITR.CompressionLevel != nil {
+			a := *c.PITR.CompressionLevel
+			p.CompressionLevel = &a
+		}
+		rv.PITR = &p
+	}
+	if c.Backup != nil {
+		rv.Backup = &ReplsetBackupConf{ReadPreference: c.Backup.ReadPreference.Clone()}
+	}
+
+	return &rv
+}
+
+func cloneReplsets(m map[string]*ReplsetConf) map[string]*ReplsetConf {
+	if m == nil {
+		return nil
+	}
+
+	rv := make(map[string]*ReplsetConf, len(m))
+	for rs, c := range m {
+		rv[rs] = c.Clone()
+	}
+	return rv
+}
+
+// ReplsetNames returns sorted names of the replsets with overrides.
+func (c *Config) ReplsetNames() []string {
+	rv := make([]string, 0, len(c.Replsets))
+	for rs := range c.Replsets {
+		rv = append(rv, rs)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+// ReplsetStorage returns the config profile name of the replset storage.
+// It's empty if the replset uses the main storage.
+func (c *Config) ReplsetStorage(rs string) string {
+	if c.IsProfile {
+		return ""
+	}
+	if r := c.Replsets[rs]; r != nil {
+		return r.Storage
+	}
+	return ""
+}
+
+// PITRConfRS returns the PITR config with the compression overrides of the replset.
+// The oplog span override is returned by OplogSlicerIntervalRS.
+func (c *Config) PITRConfRS(rs string) *PITRConf {
+	r := c.Replsets[rs]
+	if r == nil || r.PITR == nil {
+		return c.PITR
+	}
+
+	rv := c.PITR.Clone()
+	if rv == nil {
+		rv = &PITRConf{}
+	}
+	if r.PITR.Compression != "" {
+		rv.Compression = r.PITR.Compression
+		rv.CompressionLevel = r.PITR.CompressionLevel
+	} else if r.PITR.CompressionLevel != nil {
+		rv.CompressionLevel = r.PITR.CompressionLevel
+	}
+	return rv
+}
+
+// GetReplsetStorage returns the config profile name and the storage config
+// the replset data is stored on. The name is empty for the main storage.
+func GetReplsetStorage(ctx context.Context, m connect.Client, cfg *Config, rs string) (string, *StorageConf, error) {
+	name := cfg.ReplsetStorage(rs)
+	if name == "" {
+		return "", &cfg.Storage, nil
+	}
+
+	profile, err := GetProfile(ctx, m, name)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "replsets.%s.storage: get profile %q", rs, name)
+	}
+	if err := profile.Storage.Cast(); err != nil {
+		return "", nil, errors.Wrapf(err, "replsets.%s.storage: profile %q: storage cast", rs, name)
+	}
+
+	return name, &profile.Storage, nil
+}
+
+// CheckReplsetStorages checks that the storage overrides refer to existing
+// profiles and the storages can keep parts of the same backup: all replset
+// data has to be restorable along with the main storage data, so the storages
+// must be of the same type (e.g. an S3 bucket per region).
+func CheckReplsetStorages(ctx context.Context, m connect.Client, cfg *Config) error {
+	var errs []error
+	for _, rs := range cfg.ReplsetNames() {
+		name := cfg.ReplsetStorage(rs)
+		if name == "" {
+			continue
+		}
+
+		_, stg, err := GetReplsetStorage(ctx, m, cfg, rs)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if stg.Type != cfg.Storage.Type {
+			errs = append(errs, errors.Errorf("replsets.%s.storage: profile %q has %s storage "+
+				"while the main storage is %s. storages of one backup must be of the same type",
+				rs, name, stg.Type, cfg.Storage.Type))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateReplsets(c *Config) []error {
+	var errs []error
+	for _, rs := range c.ReplsetNames() {
+		r := c.Replsets[rs]
+		if r == nil {
+			continue
+		}
+		if r.Backup != nil && r.Backup.ReadPreference != nil {
+			errs = append(errs, r.Backup.ReadPreference.validate("replsets."+rs+".backup.readPreference")...)
+		}
+		if r.PITR == nil {
+			continue
+		}
+
+		p := r.PITR
+		if p.Compression != "" || p.CompressionLevel != nil {
+			errs = append(errs, validateCompression("replsets."+rs+".pitr", p.Compression, p.CompressionLevel))
+		}
+	}
+
+	return errs
+}
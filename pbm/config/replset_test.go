@@ -0,0 +1,81 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+func TestReplsetOverrides(t *testing.T) {
+	lvl := 3
+	cfg := &Config{
+		PITR: &PITRConf{
+			OplogSpanMin:   10,
+			OplogSpanMinRS: map[string]float64{"rs1": 2, "rs2": 4},
+			Compression:    compress.CompressionTypeS2,
+		},
+		Replsets: map[string]*ReplsetConf{
+			"rs1": {
+				Storage: "eu",
+				PITR:    &ReplsetPITRConf{Compression: compress.CompressionTypeZstandard, CompressionLevel: &lvl},
+			},
+		},
+	}
+
+	if got := cfg.ReplsetStorage("rs1"); got != "eu" {
+		t.Errorf("rs1 storage: got %q", got)
+	}
+	if got := cfg.ReplsetStorage("rs2"); got != "" {
+		t.Errorf("rs2 storage: got %q", got)
+	}
+
+	if got := cfg.OplogSlicerIntervalRS("rs1"); got != 2*time.Minute {
+		t.Errorf("rs1 interval: got %v", got)
+	}
+	if got := cfg.OplogSlicerIntervalRS("rs2"); got != 4*time.Minute {
+		t.Errorf("rs2 interval: got %v", got)
+	}
+
+	p := cfg.PITRConfRS("rs1")
+	if p.Compression != compress.CompressionTypeZstandard || p.CompressionLevel == nil || *p.CompressionLevel != 3 {
+		t.Errorf("rs1 compression: got %s %v", p.Compression, p.CompressionLevel)
+	}
+	if cfg.PITR.Compression != compress.CompressionTypeS2 {
+		t.Errorf("pitr config is changed: %s", cfg.PITR.Compression)
+	}
+	if p := cfg.PITRConfRS("rs2"); p != cfg.PITR {
+		t.Errorf("rs2: expected the general pitr config")
+	}
+
+	c := cfg.Clone()
+	c.Replsets["rs1"].Storage = "us"
+	*c.Replsets["rs1"].PITR.CompressionLevel = 5
+	if cfg.Replsets["rs1"].Storage != "eu" || lvl != 3 {
+		t.Error("clone shares the replsets config")
+	}
+
+	// the dump read preference override
+	cfg.Backup = &BackupConf{ReadPreference: &ReadPreference{Mode: "secondaryPreferred"}}
+	cfg.Replsets["rs1"].Backup = &ReplsetBackupConf{ReadPreference: &ReadPreference{
+		Mode:    "secondary",
+		TagSets: []map[string]string{{"dc": "backup"}},
+	}}
+	if got := cfg.BackupReadPrefRS("rs1"); got.Mode != "secondary" {
+		t.Errorf("rs1 read preference: got %v", got)
+	}
+	if got := cfg.BackupReadPrefRS("rs2"); got.Mode != "secondaryPreferred" {
+		t.Errorf("rs2 read preference: got %v", got)
+	}
+	c = cfg.Clone()
+	c.Replsets["rs1"].Backup.ReadPreference.TagSets[0]["dc"] = "east"
+	if cfg.Replsets["rs1"].Backup.ReadPreference.TagSets[0]["dc"] != "backup" {
+		t.Error("clone shares the read preference tag sets")
+	}
+
+	// storage overrides don't apply to backups to profiles
+	cfg.IsProfile = true
+	if got := cfg.ReplsetStorage("rs1"); got != "" {
+		t.Errorf("profile rs1 storage: got %q", got)
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"maps"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/schedule"
+)
+
+// CatchUpPolicy defines what to do with a scheduled slot
+// while another operation is running.
+type CatchUpPolicy string
+
+const (
+	// CatchUpSkip skips the slot (default)
+	CatchUpSkip CatchUpPolicy = "skip"
+	// CatchUpRetry retries the slot until CatchUpWindow is passed
+	CatchUpRetry CatchUpPolicy = "retry"
+)
+
+// defaultCatchUpWindow is used for the retry policy
+// if the window isn't set explicitly.
+const defaultCatchUpWindow = time.Hour
+
+// ScheduleConf is a scheduled backup run by the cluster leader agent.
+//
+//nolint:lll
+type ScheduleConf struct {
+	// Cron is a 5-field cron expression in UTC (e.g. `0 2 * * *`).
+	Cron             string                   `bson:"cron" json:"cron" yaml:"cron"`
+	Type             defs.BackupType          `bson:"type,omitempty" json:"type,omitempty" yaml:"type,omitempty"`
+	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
+	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+	// Labels are added to the backups made by the schedule.
+	Labels map[string]string `bson:"labels,omitempty" json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Retention holds hints on how many backups of the schedule are supposed
+	// to be kept. PBM doesn't delete backups by itself.
+	Retention *ScheduleRetention `bson:"retention,omitempty" json:"retention,omitempty" yaml:"retention,omitempty"`
+	// CatchUp defines what to do if the slot is busy by another operation.
+	CatchUp CatchUpPolicy `bson:"catchUp,omitempty" json:"catchUp,omitempty" yaml:"catchUp,omitempty"`
+	// CatchUpWindow is how long (e.g. `30m`) the retry policy
+	// keeps trying to start the slot.
+	CatchUpWindow string `bson:"catchUpWindow,omitempty" json:"catchUpWindow,omitempty" yaml:"catchUpWindow,omitempty"`
+	// MaxDuration (e.g. `4h`) aborts the backups of the schedule running
+	// longer. Empty is `backup.maxDuration`.
+	MaxDuration string `bson:"maxDuration,omitempty" json:"maxDuration,omitempty" yaml:"maxDuration,omitempty"`
+	Disabled    bool   `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+type ScheduleRetention struct {
+	KeepLast int `bson:"keepLast,omitempty" json:"keepLast,omitempty" yaml:"keepLast,omitempty"`
+	KeepDays int `bson:"keepDays,omitempty" json:"keepDays,omitempty" yaml:"keepDays,omitempty"`
+}
+
+func (s *ScheduleRetention) String() string {
+	if s == nil {
+		return ""
+	}
+
+	var p []string
+	if s.KeepLast > 0 {
+		p = append(p, "last "+strconv.Itoa(s.KeepLast))
+	}
+	if s.KeepDays > 0 {
+		p = append(p, strconv.Itoa(s.KeepDays)+"d")
+	}
+	return strings.Join(p, ", ")
+}
+
+func (s *ScheduleConf) Clone() *ScheduleConf {
+	if s == nil {
+		return nil
+	}
+
+	rv := *s
+	rv.Labels = maps.Clone(s.Labels)
+	if s.CompressionLevel != nil {
+		a := *s.CompressionLevel
+		rv.CompressionLevel = &a
+	}
+	if s.Retention != nil {
+		r := *s.Retention
+		rv.Retention = &r
+	}
+
+	return &rv
+}
+
+// BackupType returns the type of the scheduled backups. Logical is the default.
+func (s *ScheduleConf) BackupType() defs.BackupType {
+	if s.Type == "" {
+		return defs.LogicalBackup
+	}
+	return s.Type
+}
+
+// Policy returns catch-up policy. CatchUpSkip is the default.
+func (s *ScheduleConf) Policy() CatchUpPolicy {
+	if s.CatchUp == "" {
+		return CatchUpSkip
+	}
+	return s.CatchUp
+}
+
+// Window returns how long a busy slot is retried. It's zero for the skip policy.
+func (s *ScheduleConf) Window() time.Duration {
+	if s.Policy() != CatchUpRetry {
+		return 0
+	}
+
+	d, err := time.ParseDuration(s.CatchUpWindow)
+	if err != nil || d <= 0 {
+		return defaultCatchUpWindow
+	}
+	return d
+}
+
+// MaxRunTime returns the max duration of the scheduled backups.
+// Zero means the backup config value is used.
+func (s *ScheduleConf) MaxRunTime() time.Duration {
+	return parseMaxDuration(s.MaxDuration)
+}
+
+// ScheduleNames returns names of the schedules in alphabetical order.
+func (c *Config) ScheduleNames() []string {
+	rv := make([]string, 0, len(c.Schedule))
+	for n := range c.Schedule {
+		rv = append(rv, n)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
+
+func cloneSchedules(s map[string]*ScheduleConf) map[string]*ScheduleConf {
+	if s == nil {
+		return nil
+	}
+
+	rv := make(map[string]*ScheduleConf, len(s))
+	for n, c := range s {
+		rv[n] = c.Clone()
+	}
+	return rv
+}
+
+func validateSchedule(name string, s *ScheduleConf) []error {
+	section := "schedule." + name
+	if s == nil {
+		return []error{errors.Errorf("%s: empty", section)}
+	}
+	if strings.Contains(name, ".") || strings.Contains(name, "/") {
+		return []error{errors.Errorf("%s: name cannot contain '.' or '/'", section)}
+	}
+
+	var errs []error
+	if s.Cron == "" {
+		errs = append(errs, errors.Errorf("%s.cron: required", section))
+	} else if _, err := schedule.ParseCron(s.Cron); err != nil {
+		errs = append(errs, errors.Wrapf(err, "%s.cron", section))
+	}
+
+	switch s.Type {
+	case "", defs.LogicalBackup, defs.PhysicalBackup, defs.IncrementalBackup:
+	default:
+		errs = append(errs, errors.Errorf("%s.type: unsupported backup type %q", section, s.Type))
+	}
+
+	errs = append(errs, validateCompression(section, s.Compression, s.CompressionLevel))
+
+	switch s.CatchUp {
+	case "", CatchUpSkip, CatchUpRetry:
+	default:
+		errs = append(errs, errors.Errorf("%s.catchUp: unknown policy %q, expected %q or %q",
+			section, s.CatchUp, CatchUpSkip, CatchUpRetry))
+	}
+	if s.CatchUpWindow != "" {
+		if d, err := time.ParseDuration(s.CatchUpWindow); err != nil || d <= 0 {
+			errs = append(errs, errors.Errorf("%s.catchUpWindow: invalid duration %q", section, s.CatchUpWindow))
+		}
+	}
+	if err := validateMaxDuration(s.MaxDuration); err != nil {
+		errs = append(errs, errors.Wrapf(err, "%s.maxDuration", section))
+	}
+
+	if s.Retention != nil && (s.Retention.KeepLast < 0 || s.Retention.KeepDays < 0) {
+		errs = append(errs, errors.Errorf("%s.retention: cannot be negative", section))
+	}
+
+	return errs
+}
+
+// _scheduleConfmap is a list of valid keys of a schedule
+var _scheduleConfmap confMap = keys(reflect.TypeOf(ScheduleConf{}))
+
+// scheduleKeyField returns the field name of `schedule.<name>.<field>` key.
+func scheduleKeyField(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "schedule.")
+	if !ok {
+		return "", false
+	}
+	name, field, ok := strings.Cut(rest, ".")
+	if !ok || name == "" {
+		return "", false
+	}
+	_, ok = _scheduleConfmap[field]
+	return field, ok
+}
+
+// parseLabels parses `key=value,key2=value2` list.
+func parseLabels(s string) (map[string]string, error) {
+	rv := make(map[string]string)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, errors.Errorf("invalid label %q, expected key=value", p)
+		}
+		rv[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return rv, nil
+}
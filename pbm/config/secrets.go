@@ -0,0 +1,144 @@
+package config
+
+import (
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/secret"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+type secretField struct {
+	path string
+	v    *string
+}
+
+// secretFields returns the credential-bearing fields of the storage config.
+// Their values may be references (see package secret).
+func (s *StorageConf) secretFields() []secretField {
+	var rv []secretField
+	switch s.Type {
+	case storage.S3:
+		if s.S3 == nil {
+			break
+		}
+		c := &s.S3.Credentials
+		rv = append(rv,
+			secretField{"storage.s3.credentials.access-key-id", &c.AccessKeyID},
+			secretField{"storage.s3.credentials.secret-access-key", &c.SecretAccessKey},
+			secretField{"storage.s3.credentials.session-token", &c.SessionToken},
+			secretField{"storage.s3.credentials.vault.secret", &c.Vault.Secret},
+			secretField{"storage.s3.credentials.vault.token", &c.Vault.Token})
+		if sse := s.S3.ServerSideEncryption; sse != nil {
+			rv = append(rv, secretField{"storage.s3.serverSideEncryption.sseCustomerKey", &sse.SseCustomerKey})
+		}
+	case storage.Azure:
+		if s.Azure != nil {
+			rv = append(rv, secretField{"storage.azure.credentials.key", &s.Azure.Credentials.Key})
+		}
+	}
+
+	return rv
+}
+
+// Resolve returns a copy of the storage config with the secret references
+// replaced by their values. The config itself isn't changed, so
+// the references (not the values) are stored and displayed.
+func (s *StorageConf) Resolve() (*StorageConf, error) {
+	rv := s.Clone()
+	for _, f := range rv.secretFields() {
+		v, err := secret.Resolve(*f.v)
+		if err != nil {
+			return nil, errors.Wrap(err, f.path)
+		}
+		*f.v = v
+	}
+
+	return rv, nil
+}
+
+func (s *StorageConf) checkSecrets() []error {
+	var errs []error
+	for _, f := range s.secretFields() {
+		if err := secret.Check(*f.v); err != nil {
+			errs = append(errs, errors.Wrap(err, f.path))
+		}
+	}
+
+	return errs
+}
+
+// redact hides the secret value. References are kept as they don't
+// disclose the secret.
+// redacted is the mask of the secret values
+const redacted = "***"
+
+func redact(v string) string {
+	if v == "" || secret.IsRef(v) {
+		return v
+	}
+	return redacted
+}
+
+// maskSecrets returns a copy of the config with the secret values masked.
+// The secret references are kept.
+func (c *Config) maskSecrets() *Config {
+	c = c.Clone()
+	if c == nil {
+		return nil
+	}
+
+	for _, f := range c.Storage.secretFields() {
+		*f.v = redact(*f.v)
+	}
+	if c.Notifications != nil {
+		for i := range c.Notifications.Webhooks {
+			c.Notifications.Webhooks[i].Secret = redact(c.Notifications.Webhooks[i].Secret)
+		}
+	}
+
+	return c
+}
+
+// unmaskSecrets sets the masked secret values to the ones of the same
+// fields (webhooks by the URL) of the config from.
+func (c *Config) unmaskSecrets(from *Config) error {
+	known := make(map[string]string)
+	if from != nil {
+		for _, f := range from.Storage.secretFields() {
+			known[f.path] = *f.v
+		}
+		if from.Notifications != nil {
+			for _, w := range from.Notifications.Webhooks {
+				known["notifications.webhooks."+w.URL+".secret"] = w.Secret
+			}
+		}
+	}
+
+	unmask := func(path string, v *string) error {
+		if *v != redacted {
+			return nil
+		}
+		s := known[path]
+		if s == "" || s == redacted {
+			return errors.Errorf("%s: the secret isn't kept in the history "+
+				"and the current config doesn't have it", path)
+		}
+		*v = s
+		return nil
+	}
+
+	for _, f := range c.Storage.secretFields() {
+		if err := unmask(f.path, f.v); err != nil {
+			return err
+		}
+	}
+	if c.Notifications != nil {
+		for i := range c.Notifications.Webhooks {
+			w := &c.Notifications.Webhooks[i]
+			if err := unmask("notifications.webhooks."+w.URL+".secret", &w.Secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
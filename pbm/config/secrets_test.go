@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestStorageResolve(t *testing.T) {
+	t.Setenv("PBM_TEST_KEY_ID", "key-id")
+	keyFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(keyFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Storage: StorageConf{Type: storage.S3, S3: &s3.Config{
+		Bucket: "bcp",
+		Credentials: s3.Credentials{
+			AccessKeyID:     "${PBM_TEST_KEY_ID}",
+			SecretAccessKey: "file://" + keyFile,
+			SessionToken:    "plain-token",
+		},
+	}}}
+
+	stg, err := cfg.Storage.Resolve()
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	c := stg.S3.Credentials
+	if c.AccessKeyID != "key-id" || c.SecretAccessKey != "s3cr3t" || c.SessionToken != "plain-token" {
+		t.Errorf("unexpected resolved credentials: %+v", c)
+	}
+	if cfg.Storage.S3.Credentials.AccessKeyID != "${PBM_TEST_KEY_ID}" {
+		t.Errorf("config is changed: %+v", cfg.Storage.S3.Credentials)
+	}
+
+	out := cfg.String()
+	for _, s := range []string{"${PBM_TEST_KEY_ID}", "file://" + keyFile, "session-token: '***'"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in the config output:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "plain-token") {
+		t.Errorf("secret in the config output:\n%s", out)
+	}
+
+	cfg.Storage.S3.Credentials.AccessKeyID = "${PBM_TEST_UNSET_VAR}"
+	_, err = cfg.Storage.Resolve()
+	if err == nil || !strings.Contains(err.Error(), "storage.s3.credentials.access-key-id") ||
+		!strings.Contains(err.Error(), "PBM_TEST_UNSET_VAR") {
+		t.Errorf("expected error with the path and the variable, got %v", err)
+	}
+}
@@ -0,0 +1,512 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrUnknownField is returned if the config has a field that is not
+// known by PBM (e.g. has a typo).
+var ErrUnknownField = errors.New("unknown field")
+
+// checkUnknownFields returns error with YAML paths (like `storage.s3.regon`)
+// of all fields in data unknown for the config.
+func checkUnknownFields(data []byte) error {
+	var doc any
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return errors.Wrap(err, "decode")
+	}
+
+	unknown := unknownFields(doc, reflect.TypeOf(Config{}), "")
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return errors.Wrap(ErrUnknownField, strings.Join(unknown, ", "))
+}
+
+func unknownFields(v any, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var rv []string
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[any]any)
+		if !ok {
+			return nil
+		}
+
+		fields := yamlFields(t)
+		for k, val := range m {
+			name, _ := k.(string)
+			p := name
+			if path != "" {
+				p = path + "." + name
+			}
+
+			ft, ok := fields[name]
+			if !ok {
+				rv = append(rv, p)
+				continue
+			}
+			rv = append(rv, unknownFields(val, ft, p)...)
+		}
+	case reflect.Map:
+		m, ok := v.(map[any]any)
+		if !ok {
+			return nil
+		}
+		for k, val := range m {
+			rv = append(rv, unknownFields(val, t.Elem(), path+"."+toString(k))...)
+		}
+	case reflect.Slice:
+		l, ok := v.([]any)
+		if !ok {
+			return nil
+		}
+		for _, val := range l {
+			rv = append(rv, unknownFields(val, t.Elem(), path)...)
+		}
+	}
+
+	return rv
+}
+
+// yamlFields returns the struct fields by their YAML names.
+// Fields of inlined structs are included.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	rv := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if slices.Contains(tag[1:], "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			for n, t := range yamlFields(ft) {
+				rv[n] = t
+			}
+			continue
+		}
+
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		rv[name] = f.Type
+	}
+
+	return rv
+}
+
+func toString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := yaml.Marshal(v)
+	return strings.TrimSpace(string(b))
+}
+
+// Validate makes semantic checks of the config values
+// that cannot be expressed by types.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.PITR != nil {
+		errs = append(errs, validateCompression("pitr", c.PITR.Compression, c.PITR.CompressionLevel))
+		if c.PITR.OplogSpanMin < 0 {
+			errs = append(errs, errors.New("pitr.oplogSpanMin: cannot be negative"))
+		} else if c.PITR.OplogSpanMin > 0 {
+			if err := validateOplogSpanMin(c.PITR.OplogSpanMin); err != nil {
+				errs = append(errs, errors.Wrap(err, "pitr.oplogSpanMin"))
+			}
+		}
+		for rs, m := range c.PITR.OplogSpanMinRS {
+			if m == 0 {
+				continue
+			}
+			if err := validateOplogSpanMin(m); err != nil {
+				errs = append(errs, errors.Wrapf(err, "pitr.oplogSpanMinRS.%s", rs))
+			}
+		}
+		for p, v := range c.PITR.Priority {
+			if v < 0 {
+				errs = append(errs, errors.Errorf("pitr.priority.%s: cannot be negative", p))
+			}
+		}
+		if h := c.PITR.HostUploads; h != nil {
+			if h.Limit < 0 {
+				errs = append(errs, errors.New("pitr.hostUploads.limit: cannot be negative"))
+			}
+			if j := h.JitterPercent; j != nil && (*j < 0 || *j > maxPITRSpanJitter) {
+				errs = append(errs, errors.Errorf("pitr.hostUploads.jitterPercent: should be in [0, %v]",
+					maxPITRSpanJitter))
+			}
+		}
+	}
+
+	if c.Backup != nil {
+		errs = append(errs, validateCompression("backup", c.Backup.Compression, c.Backup.CompressionLevel))
+		if c.Backup.OplogSpanMin < 0 {
+			errs = append(errs, errors.New("backup.oplogSpanMin: cannot be negative"))
+		} else if c.Backup.OplogSpanMin > 0 {
+			if err := validateOplogSpanMin(c.Backup.OplogSpanMin); err != nil {
+				errs = append(errs, errors.Wrap(err, "backup.oplogSpanMin"))
+			}
+		}
+		if c.Backup.NumParallelCollections < 0 {
+			errs = append(errs, errors.New("backup.numParallelCollections: cannot be negative"))
+		}
+		if err := validateMaxDuration(c.Backup.MaxDuration); err != nil {
+			errs = append(errs, errors.Wrap(err, "backup.maxDuration"))
+		}
+		if ca := c.Backup.CompressionAuto; ca != nil {
+			if !compress.IsValidAutoTarget(string(ca.Target)) {
+				errs = append(errs, errors.Errorf("backup.compressionAuto.target: should be %q or %q",
+					compress.AutoTargetTime, compress.AutoTargetSize))
+			}
+			if ca.UploadRateMb < 0 {
+				errs = append(errs, errors.New("backup.compressionAuto.uploadRateMb: cannot be negative"))
+			}
+		}
+		if q := c.Backup.Queue; q != nil && q.TTLMin < 0 {
+			errs = append(errs, errors.New("backup.queue.ttlMin: cannot be negative"))
+		}
+		if pc := c.Backup.ParallelCompression; pc != nil {
+			if pc.Workers < 0 {
+				errs = append(errs, errors.New("backup.parallelCompression.workers: cannot be negative"))
+			}
+			if pc.FrameSizeMb < 0 || pc.FrameSizeMb > 512 {
+				errs = append(errs, errors.New("backup.parallelCompression.frameSizeMb: should be in [0, 512]"))
+			}
+		}
+		for p, v := range c.Backup.Priority {
+			if v < 0 {
+				errs = append(errs, errors.Errorf("backup.priority.%s: cannot be negative", p))
+			}
+		}
+		if q := c.Backup.Quiesce; q.IsEnabled() && q.TimeoutDuration() >= c.Backup.Timeouts.StartingStatus() {
+			errs = append(errs, errors.Errorf(
+				"backup.quiesce.timeout: %v should be less than backup.timeouts.startingStatus %v",
+				q.TimeoutDuration(), c.Backup.Timeouts.StartingStatus()))
+		}
+		if c.Backup.ReadPreference != nil {
+			errs = append(errs, c.Backup.ReadPreference.validate("backup.readPreference")...)
+		}
+		if cc := c.Backup.ConsistencyCheck; cc != nil && cc.CountTolerance != nil {
+			if t := *cc.CountTolerance; t < 0 || t > 100 {
+				errs = append(errs, errors.Errorf(
+					"backup.consistencyCheck.countTolerance: %v should be between 0 and 100", t))
+			}
+		}
+		if oc := c.Backup.OplogWindowCheck; oc != nil && oc.MarginPercent != nil {
+			if m := *oc.MarginPercent; m < 0 {
+				errs = append(errs, errors.Errorf(
+					"backup.oplogWindowCheck.marginPercent: %v cannot be negative", m))
+			}
+		}
+		errs = append(errs, validateExcludeDatabases(c.Backup.ExcludedDatabases())...)
+		if h := c.Backup.Hooks; h != nil {
+			errs = append(errs, validateHook("backup.hooks.pre", h.Pre)...)
+			errs = append(errs, validateHook("backup.hooks.post", h.Post)...)
+			if h.Post != nil && h.Post.OnFailure == HookAbort {
+				errs = append(errs, errors.Errorf("backup.hooks.post.onFailure: "+
+					"the hook runs after the backup is done and can't abort it, use %q", HookWarn))
+			}
+			// nodes have to start copying the data within the starting timeout
+			if h.Pre.IsEnabled() {
+				d := h.Pre.TimeoutDuration()
+				if q := c.Backup.Quiesce; q.IsEnabled() {
+					d += q.TimeoutDuration()
+				}
+				if d >= c.Backup.Timeouts.StartingStatus() {
+					errs = append(errs, errors.Errorf(
+						"backup.hooks.pre.timeout: %v (with quiesce timeout) should be less than "+
+							"backup.timeouts.startingStatus %v", d, c.Backup.Timeouts.StartingStatus()))
+				}
+			}
+		}
+	}
+
+	if c.Restore != nil {
+		for name, v := range map[string]int{
+			"batchSize":              c.Restore.BatchSize,
+			"numInsertionWorkers":    c.Restore.NumInsertionWorkers,
+			"numParallelCollections": c.Restore.NumParallelCollections,
+			"parallelDatabases":      c.Restore.ParallelDatabases,
+			"numDownloadWorkers":     c.Restore.NumDownloadWorkers,
+			"maxDownloadBufferMb":    c.Restore.MaxDownloadBufferMb,
+			"downloadChunkMb":        c.Restore.DownloadChunkMb,
+			"prefetchMb":             c.Restore.PrefetchMb,
+			"numParallelFiles":       c.Restore.NumParallelFiles,
+			"maxDownloadRateMb":      c.Restore.MaxDownloadRateMb,
+			"keepLast":               c.Restore.KeepLast,
+		} {
+			if v < 0 {
+				errs = append(errs, errors.Errorf("restore.%s: cannot be negative", name))
+			}
+		}
+		errs = append(errs, validateIndexBuild(c.Restore.IndexBuild)...)
+		errs = append(errs, validateNSPriority(c.Restore.NamespacePriority)...)
+		errs = append(errs, validateRestoreVerify(c.Restore.Verify)...)
+		switch c.Restore.ShardKeyMismatch {
+		case "", ShardKeyMismatchFail, ShardKeyMismatchWarn:
+		default:
+			errs = append(errs, errors.Errorf(
+				"restore.shardKeyMismatch: unknown policy %q, expected one of: %s, %s",
+				c.Restore.ShardKeyMismatch, ShardKeyMismatchFail, ShardKeyMismatchWarn))
+		}
+	}
+
+	if c.Lock != nil && c.Lock.StaleThreshold != 0 && c.Lock.StaleThreshold < defs.StaleFrameSec {
+		errs = append(errs, errors.Errorf("lock.staleThresholdSec: should be at least %d", defs.StaleFrameSec))
+	}
+
+	if c.Cluster != nil && c.Cluster.WriteConcern != nil {
+		errs = append(errs, c.Cluster.WriteConcern.validate()...)
+	}
+
+	if c.Agent != nil && c.Agent.MaxMemoryMB < 0 {
+		errs = append(errs, errors.New("agent.maxMemoryMB: should be positive"))
+	}
+	if c.Agent != nil && c.Agent.ClockSkew != nil && c.Agent.ClockSkew.MaxSec < 0 {
+		errs = append(errs, errors.New("agent.clockSkew.maxSec: should be positive"))
+	}
+
+	if c.Log != nil && c.Log.RetentionDays < 0 {
+		errs = append(errs, errors.New("log.retentionDays: should be positive"))
+	}
+
+	if c.Resync != nil {
+		switch c.Resync.Mode {
+		case "", ResyncOff, ResyncWarn, ResyncApply:
+		default:
+			errs = append(errs, errors.Errorf("resync.mode: unknown mode %q, expected one of: %s, %s, %s",
+				c.Resync.Mode, ResyncOff, ResyncWarn, ResyncApply))
+		}
+		if c.Resync.IntervalMin < 0 {
+			errs = append(errs, errors.New("resync.intervalMin: should be positive"))
+		}
+	}
+
+	if c.Storage.ReadOnly && !c.IsProfile {
+		errs = append(errs, errors.New("storage.readonly: the main storage cannot be read-only, "+
+			"add the storage as a config profile"))
+	}
+	errs = append(errs, c.Storage.checkSecrets()...)
+	if e := c.Storage.Encryption; e != nil {
+		for _, err := range e.Validate() {
+			errs = append(errs, errors.Wrap(err, "storage.encryption"))
+		}
+	}
+	errs = append(errs, validateReplsets(c)...)
+	errs = append(errs, c.Notifications.Validate()...)
+
+	for _, name := range c.ScheduleNames() {
+		errs = append(errs, validateSchedule(name, c.Schedule[name])...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateExcludeDatabases rejects the system databases: the cluster can't
+// start without them.
+func validateExcludeDatabases(dbs []string) []error {
+	var errs []error
+	for _, db := range dbs {
+		switch {
+		case db == "":
+			errs = append(errs, errors.New("backup.physical.excludeDatabases: empty database name"))
+		case db == "admin", db == "local", db == "config":
+			errs = append(errs, errors.Errorf(
+				"backup.physical.excludeDatabases: system database %q cannot be excluded", db))
+		case strings.ContainsAny(db, "./\\ \"$*"):
+			errs = append(errs, errors.Errorf(
+				"backup.physical.excludeDatabases: invalid database name %q", db))
+		}
+	}
+
+	return errs
+}
+
+func validateIndexBuild(b *IndexBuildConf) []error {
+	if b == nil {
+		return nil
+	}
+
+	var errs []error
+	for name, v := range map[string]int{
+		"maxConcurrent": b.MaxConcurrent,
+		"batchSize":     b.BatchSize,
+		"retries":       b.Retries,
+	} {
+		if v < 0 {
+			errs = append(errs, errors.Errorf("restore.indexBuild.%s: cannot be negative", name))
+		}
+	}
+	switch b.Order {
+	case IndexBuildByName, IndexBuildLargestFirst, IndexBuildLargestLast:
+	default:
+		errs = append(errs, errors.Errorf("restore.indexBuild.order: unknown %q, should be %s or %s",
+			b.Order, IndexBuildLargestFirst, IndexBuildLargestLast))
+	}
+	if n, ok := b.Quorum().(int32); ok && n < 0 {
+		errs = append(errs, errors.New("restore.indexBuild.commitQuorum: cannot be negative"))
+	}
+
+	return errs
+}
+
+func validateRestoreVerify(v *RestoreVerifyConf) []error {
+	if v == nil {
+		return nil
+	}
+
+	var errs []error
+	if v.MaxDiskMb < 0 {
+		errs = append(errs, errors.New("restore.verify.maxDiskMb: cannot be negative"))
+	}
+	if v.Dir != "" && !filepath.IsAbs(v.Dir) {
+		errs = append(errs, errors.Errorf("restore.verify.dir: %q should be an absolute path", v.Dir))
+	}
+	if err := validateMaxDuration(v.MaxDuration); err != nil {
+		errs = append(errs, errors.Wrap(err, "restore.verify.maxDuration"))
+	}
+
+	return errs
+}
+
+func validateNSPriority(patterns []string) []error {
+	var errs []error
+	seen := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		if !isNSPattern(p) {
+			errs = append(errs, errors.Errorf("restore.namespacePriority: invalid pattern %q, "+
+				"expected db.coll, db.* or *.coll", p))
+			continue
+		}
+		if seen[p] {
+			errs = append(errs, errors.Errorf("restore.namespacePriority: duplicate pattern %q", p))
+		}
+		seen[p] = true
+	}
+
+	return errs
+}
+
+// isNSPattern checks if p is "db.coll", "db.*" or "*.coll"
+func isNSPattern(p string) bool {
+	db, coll, ok := strings.Cut(p, ".")
+	if !ok || db == "" || coll == "" || db == "*" && coll == "*" {
+		return false
+	}
+	wildcard := func(s string) bool { return s != "*" && strings.Contains(s, "*") }
+	return !wildcard(db) && !wildcard(coll)
+}
+
+func validateHook(section string, h *Hook) []error {
+	if h == nil {
+		return nil
+	}
+
+	var errs []error
+	if strings.TrimSpace(h.Cmd) == "" {
+		errs = append(errs, errors.Errorf("%s.cmd: required", section))
+	}
+	switch h.OnFailure {
+	case "", HookAbort, HookWarn:
+	default:
+		errs = append(errs, errors.Errorf("%s.onFailure: unknown policy %q, expected %q or %q",
+			section, h.OnFailure, HookAbort, HookWarn))
+	}
+
+	return errs
+}
+
+// validateCompression checks the compression type and that the level
+// is within the range supported by the compression.
+func validateCompression(section string, c compress.CompressionType, level *int) error {
+	if c == compress.CompressionTypeAuto {
+		if strings.HasSuffix(section, "pitr") {
+			return errors.Errorf("%s.compression: %q is applicable only to backups", section, c)
+		}
+		if level != nil {
+			return errors.Errorf("%s.compressionLevel: not applicable to %q compression", section, c)
+		}
+		return nil
+	}
+	if c != "" && !compress.IsValidCompressionType(string(c)) {
+		return errors.Errorf("%s.compression: unsupported compression type: %q", section, c)
+	}
+	if level == nil {
+		return nil
+	}
+
+	var minLvl, maxLvl int
+	switch c {
+	case compress.CompressionTypeGZIP, compress.CompressionTypePGZIP:
+		minLvl, maxLvl = -2, 9
+	case compress.CompressionTypeZstandard:
+		minLvl, maxLvl = 1, 22
+	case compress.CompressionTypeS2, "":
+		// s2 is the default compression
+		minLvl, maxLvl = 1, 4
+	case compress.CompressionTypeLZ4:
+		minLvl, maxLvl = 0, 16
+	default:
+		return nil
+	}
+	if *level < minLvl || *level > maxLvl {
+		return errors.Errorf("%s.compressionLevel: %d is out of range [%d, %d] for %q",
+			section, *level, minLvl, maxLvl, c)
+	}
+
+	return nil
+}
+
+// maxPITRSpanJitter is the max jitter (in percent of the span) of the
+// PITR chunk boundaries. The bigger ones would make the chunks too
+// irregular to keep up with the span.
+const maxPITRSpanJitter = 50.0
+
+// MinMaxDuration is the shortest backup time limit. Shorter ones
+// would abort backups before the nodes are even nominated.
+const MinMaxDuration = time.Minute
+
+// validateMaxDuration checks the backup time limit. Empty is no limit.
+func validateMaxDuration(s string) error {
+	if s == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Errorf("invalid duration %q", s)
+	}
+	if d < MinMaxDuration {
+		return errors.Errorf("%v should be at least %v", d, MinMaxDuration)
+	}
+	return nil
+}
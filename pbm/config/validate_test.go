@@ -0,0 +1,412 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/azure"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestParseUnknownFields(t *testing.T) {
+	_, err := Parse(strings.NewReader(`
+storage:
+  type: s3
+  s3:
+    bucket: b
+    regon: us-east-1
+pitr:
+  enabled: true
+  oplogSpanMinRS:
+    rs0: 5
+  extra: 1
+`))
+	if !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("expected ErrUnknownField, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "storage.s3.regon") || !strings.Contains(msg, "pitr.extra") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = Parse(strings.NewReader(`
+storage:
+  type: filesystem
+  filesystem:
+    path: /tmp
+backup:
+  compression: zstd
+  compressionLevel: 3
+`))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	lvl := func(l int) *int { return &l }
+	jitter := 60.0
+
+	cases := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"ok", Config{Backup: &BackupConf{Compression: "gzip", CompressionLevel: lvl(9)}}, ""},
+		{"level", Config{Backup: &BackupConf{Compression: "zstd", CompressionLevel: lvl(23)}},
+			"backup.compressionLevel"},
+		{"type", Config{PITR: &PITRConf{Compression: "zip"}}, "pitr.compression"},
+		{"parallel compression", Config{Backup: &BackupConf{ParallelCompression: &ParallelCompression{Workers: 8, FrameSizeMb: 8}}},
+			""},
+		{"parallel compression workers", Config{Backup: &BackupConf{ParallelCompression: &ParallelCompression{Workers: -1}}},
+			"backup.parallelCompression.workers"},
+		{"max duration", Config{Backup: &BackupConf{MaxDuration: "4h"}}, ""},
+		{"queue", Config{Backup: &BackupConf{Queue: &BackupQueue{Enabled: true, TTLMin: 30}}}, ""},
+		{"queue ttl", Config{Backup: &BackupConf{Queue: &BackupQueue{TTLMin: -1}}}, "backup.queue.ttlMin"},
+		{"max duration invalid", Config{Backup: &BackupConf{MaxDuration: "4"}}, "backup.maxDuration"},
+		{"max duration short", Config{Backup: &BackupConf{MaxDuration: "30s"}}, "backup.maxDuration"},
+		{"auto", Config{Backup: &BackupConf{Compression: "auto",
+			CompressionAuto: &BackupCompressionAuto{Target: "size", UploadRateMb: 50}}}, ""},
+		{"auto level", Config{Backup: &BackupConf{Compression: "auto", CompressionLevel: lvl(3)}},
+			"backup.compressionLevel"},
+		{"auto pitr", Config{PITR: &PITRConf{Compression: "auto"}}, "pitr.compression"},
+		{"auto target", Config{Backup: &BackupConf{CompressionAuto: &BackupCompressionAuto{Target: "speed"}}},
+			"backup.compressionAuto.target"},
+		{"lz4", Config{Backup: &BackupConf{Compression: "lz4", CompressionLevel: lvl(9)}}, ""},
+		{"lz4 level", Config{Backup: &BackupConf{Compression: "lz4", CompressionLevel: lvl(17)}},
+			"backup.compressionLevel"},
+		{"snappy", Config{PITR: &PITRConf{Compression: "snappy"}}, ""},
+		{"span", Config{PITR: &PITRConf{OplogSpanMin: 0.01}}, "pitr.oplogSpanMin"},
+		{"host uploads", Config{PITR: &PITRConf{HostUploads: &PITRHostUploads{Limit: -1}}}, "pitr.hostUploads.limit"},
+		{"jitter", Config{PITR: &PITRConf{HostUploads: &PITRHostUploads{JitterPercent: &jitter}}},
+			"pitr.hostUploads.jitterPercent"},
+		{"negative", Config{Restore: &RestoreConf{BatchSize: -1}}, "restore.batchSize"},
+		{"keep last", Config{Restore: &RestoreConf{KeepLast: -1}}, "restore.keepLast"},
+		{"parallel databases", Config{Restore: &RestoreConf{ParallelDatabases: -2}}, "restore.parallelDatabases"},
+		{"shard key mismatch", Config{Restore: &RestoreConf{ShardKeyMismatch: "ignore"}}, "restore.shardKeyMismatch"},
+		{"prefetch", Config{Restore: &RestoreConf{PrefetchMb: -8}}, "restore.prefetchMb"},
+		{"index build", Config{Restore: &RestoreConf{IndexBuild: &IndexBuildConf{
+			MaxConcurrent: 2, BatchSize: 4, CommitQuorum: "majority", Order: IndexBuildLargestLast, Retries: 2,
+		}}}, ""},
+		{"index build order", Config{Restore: &RestoreConf{IndexBuild: &IndexBuildConf{Order: "smallest"}}},
+			"restore.indexBuild.order"},
+		{"index build quorum", Config{Restore: &RestoreConf{IndexBuild: &IndexBuildConf{CommitQuorum: "-1"}}},
+			"restore.indexBuild.commitQuorum"},
+		{"index build retries", Config{Restore: &RestoreConf{IndexBuild: &IndexBuildConf{Retries: -1}}},
+			"restore.indexBuild.retries"},
+		{"ns priority", Config{Restore: &RestoreConf{NamespacePriority: []string{"app.sessions", "*.users", "app.*"}}},
+			""},
+		{"ns priority pattern", Config{Restore: &RestoreConf{NamespacePriority: []string{"app"}}},
+			"restore.namespacePriority"},
+		{"ns priority all", Config{Restore: &RestoreConf{NamespacePriority: []string{"*.*"}}},
+			"restore.namespacePriority"},
+		{"verify", Config{Restore: &RestoreConf{Verify: &RestoreVerifyConf{
+			Dir: "/data/scratch", MaxDiskMb: 2048, MaxDuration: "2h",
+		}}}, ""},
+		{"verify dir", Config{Restore: &RestoreConf{Verify: &RestoreVerifyConf{Dir: "scratch"}}},
+			"restore.verify.dir"},
+		{"verify disk", Config{Restore: &RestoreConf{Verify: &RestoreVerifyConf{MaxDiskMb: -1}}},
+			"restore.verify.maxDiskMb"},
+		{"verify duration", Config{Restore: &RestoreConf{Verify: &RestoreVerifyConf{MaxDuration: "1d"}}},
+			"restore.verify.maxDuration"},
+		{"quiesce", Config{Backup: &BackupConf{Quiesce: &BackupQuiesce{Enabled: true, Timeout: 600}}},
+			"backup.quiesce.timeout"},
+		{"consistency tolerance", Config{Backup: &BackupConf{ConsistencyCheck: &BackupConsistencyCheck{
+			CountTolerance: func(v float64) *float64 { return &v }(120),
+		}}}, "backup.consistencyCheck.countTolerance"},
+		{"oplog window margin", Config{Backup: &BackupConf{OplogWindowCheck: &BackupOplogWindowCheck{
+			MarginPercent: func(v float64) *float64 { return &v }(-10),
+		}}}, "backup.oplogWindowCheck.marginPercent"},
+		{"hooks", Config{Backup: &BackupConf{Hooks: &BackupHooks{
+			Pre:  &Hook{Cmd: "flush-cache", OnFailure: HookWarn},
+			Post: &Hook{Cmd: "verify", Timeout: 600},
+		}}}, ""},
+		{"hook cmd", Config{Backup: &BackupConf{Hooks: &BackupHooks{Post: &Hook{}}}}, "backup.hooks.post.cmd"},
+		{"post hook abort", Config{Backup: &BackupConf{Hooks: &BackupHooks{Post: &Hook{Cmd: "a", OnFailure: HookAbort}}}},
+			"backup.hooks.post.onFailure"},
+		{"hook policy", Config{Backup: &BackupConf{Hooks: &BackupHooks{Pre: &Hook{Cmd: "a", OnFailure: "skip"}}}},
+			"backup.hooks.pre.onFailure"},
+		{"hook timeout", Config{Backup: &BackupConf{
+			Quiesce: &BackupQuiesce{Enabled: true},
+			Hooks:   &BackupHooks{Pre: &Hook{Cmd: "a", Timeout: 15}},
+		}}, "backup.hooks.pre.timeout"},
+		{"read preference", Config{Backup: &BackupConf{ReadPreference: &ReadPreference{
+			Mode: "secondary", TagSets: []map[string]string{{"dc": "backup"}}, MaxStalenessSeconds: 120,
+		}}}, ""},
+		{"read preference mode", Config{Backup: &BackupConf{ReadPreference: &ReadPreference{Mode: "any"}}},
+			"backup.readPreference: mode"},
+		{"read preference primary tags", Config{Backup: &BackupConf{ReadPreference: &ReadPreference{
+			Mode: "primary", TagSets: []map[string]string{{"dc": "backup"}},
+		}}}, "backup.readPreference: primary mode"},
+		{"read preference staleness", Config{Backup: &BackupConf{ReadPreference: &ReadPreference{
+			Mode: "nearest", MaxStalenessSeconds: 30,
+		}}}, "backup.readPreference.maxStalenessSeconds"},
+		{"exclude databases", Config{Backup: &BackupConf{Physical: &BackupPhysical{
+			ExcludeDatabases: []string{"analytics", "cache"},
+		}}}, ""},
+		{"exclude system database", Config{Backup: &BackupConf{Physical: &BackupPhysical{
+			ExcludeDatabases: []string{"analytics", "config"},
+		}}}, `system database "config"`},
+		{"exclude invalid database", Config{Backup: &BackupConf{Physical: &BackupPhysical{
+			ExcludeDatabases: []string{"analytics.events"},
+		}}}, "backup.physical.excludeDatabases"},
+		{"lock", Config{Lock: &LockConf{StaleThreshold: 120}}, ""},
+		{"lock threshold", Config{Lock: &LockConf{StaleThreshold: 10}}, "lock.staleThresholdSec"},
+		{"resync", Config{Resync: &ResyncConf{Mode: ResyncWarn, IntervalMin: 30}}, ""},
+		{"readonly", Config{Storage: StorageConf{ReadOnly: true}}, "storage.readonly"},
+		{"readonly profile", Config{IsProfile: true, Name: "prod", Storage: StorageConf{ReadOnly: true}}, ""},
+		{"resync mode", Config{Resync: &ResyncConf{Mode: "auto"}}, "resync.mode"},
+		{"resync interval", Config{Resync: &ResyncConf{IntervalMin: -1}}, "resync.intervalMin"},
+		{"agent memory", Config{Agent: &AgentConf{MaxMemoryMB: 512}}, ""},
+		{"agent negative memory", Config{Agent: &AgentConf{MaxMemoryMB: -1}}, "agent.maxMemoryMB"},
+		{"clock skew", Config{Agent: &AgentConf{ClockSkew: &ClockSkewConf{MaxSec: 60, FailOnSkew: true}}}, ""},
+		{"negative clock skew", Config{Agent: &AgentConf{ClockSkew: &ClockSkewConf{MaxSec: -1}}},
+			"agent.clockSkew.maxSec"},
+		{"webhook", Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+			{URL: "https://example.com/hook", Events: []notify.Event{notify.BackupFailed}},
+			{URL: "https://hooks.example.com/${HOOK_TOKEN}", Secret: "${HOOK_SECRET}"},
+		}}}, ""},
+		{"webhook url", Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+			{URL: "ftp://example.com"},
+		}}}, "notifications.webhooks[0].url"},
+		{"secret refs", Config{Storage: StorageConf{Type: storage.S3, S3: &s3.Config{Credentials: s3.Credentials{
+			AccessKeyID:     "${AWS_ACCESS_KEY_ID}",
+			SecretAccessKey: "file:///run/secrets/aws-secret",
+		}}}}, ""},
+		{"secret env ref", Config{Storage: StorageConf{Type: storage.S3, S3: &s3.Config{Credentials: s3.Credentials{
+			SecretAccessKey: "${AWS_SECRET",
+		}}}}, "storage.s3.credentials.secret-access-key"},
+		{"secret file ref", Config{Storage: StorageConf{Type: storage.Azure, Azure: &azure.Config{
+			Credentials: azure.Credentials{Key: "file://azure-key"},
+		}}}, "storage.azure.credentials.key"},
+		{"encryption", Config{Storage: StorageConf{Type: storage.Filesystem, Encryption: &encrypt.Config{
+			KeyID: "k1", Keys: map[string]string{"k1": "${PBM_KEY_K1}"},
+		}}}, ""},
+		{"encryption literal key", Config{Storage: StorageConf{Type: storage.Filesystem, Encryption: &encrypt.Config{
+			KeyID: "k1", Keys: map[string]string{"k1": "c2VjcmV0"},
+		}}}, "storage.encryption: keys.k1"},
+		{"encryption kmip", Config{Storage: StorageConf{Type: storage.Filesystem, Encryption: &encrypt.Config{
+			KMIP: &encrypt.KMIPConfig{Endpoint: "kms", ClientCert: "/etc/pbm/kmip.pem", ClientKey: "kmip.key", KeyUID: "1"},
+		}}}, "storage.encryption: kmip.clientKey"},
+		{"webhook secret ref", Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+			{URL: "http://example.com", Secret: "${1SECRET}"},
+		}}}, "notifications.webhooks[0].secret"},
+		{"webhook event", Config{Notifications: &notify.Config{Webhooks: []notify.Webhook{
+			{URL: "http://example.com", Events: []notify.Event{"backup.done"}},
+		}}}, "notifications.webhooks[0].events"},
+		{"replset", Config{Replsets: map[string]*ReplsetConf{
+			"rs1": {Storage: "eu", PITR: &ReplsetPITRConf{Compression: "zstd"}},
+		}}, ""},
+		{"replset compression", Config{Replsets: map[string]*ReplsetConf{
+			"rs1": {PITR: &ReplsetPITRConf{Compression: "zstd", CompressionLevel: lvl(30)}},
+		}}, "replsets.rs1.pitr.compressionLevel"},
+		{"write concern", Config{Cluster: &ClusterConf{WriteConcern: &WriteConcernConf{W: "2", WTimeout: 30000}}},
+			""},
+		{"write concern w", Config{Cluster: &ClusterConf{WriteConcern: &WriteConcernConf{W: "0"}}},
+			"cluster.writeConcern.w"},
+		{"write concern wtimeout", Config{Cluster: &ClusterConf{WriteConcern: &WriteConcernConf{WTimeout: -1}}},
+			"cluster.writeConcern.wtimeout"},
+		{"replset read preference", Config{Replsets: map[string]*ReplsetConf{
+			"rs1": {Backup: &ReplsetBackupConf{ReadPreference: &ReadPreference{Mode: "secondary"}}},
+		}}, ""},
+		{"replset read preference mode", Config{Replsets: map[string]*ReplsetConf{
+			"rs1": {Backup: &ReplsetBackupConf{ReadPreference: &ReadPreference{}}},
+		}}, "replsets.rs1.backup.readPreference: mode"},
+	}
+	for _, tc := range cases {
+		err := tc.cfg.Validate()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q error, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestWriteConcern(t *testing.T) {
+	if wc := (&Config{}).WriteConcern(); wc != nil {
+		t.Errorf("not set: expected nil, got %+v", wc)
+	}
+
+	j := true
+	cases := []struct {
+		conf WriteConcernConf
+		w    any
+	}{
+		{WriteConcernConf{WTimeout: 5000}, nil},
+		{WriteConcernConf{J: &j}, nil},
+		{WriteConcernConf{W: "majority"}, "majority"},
+		{WriteConcernConf{W: "3", J: &j}, 3},
+		{WriteConcernConf{W: "dc1"}, "dc1"},
+	}
+	for _, tc := range cases {
+		cfg := &Config{Cluster: &ClusterConf{WriteConcern: &tc.conf}}
+		wc := cfg.WriteConcern()
+		if wc.W != tc.w {
+			t.Errorf("%+v: expected w %v, got %v", tc.conf, tc.w, wc.W)
+		}
+		if wc.Journal != tc.conf.J {
+			t.Errorf("%+v: expected j %v, got %v", tc.conf, tc.conf.J, wc.Journal)
+		}
+		if wc.WTimeout != time.Duration(tc.conf.WTimeout)*time.Millisecond {
+			t.Errorf("%+v: expected wtimeout %dms, got %v", tc.conf, tc.conf.WTimeout, wc.WTimeout)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	oldCfg, err := Parse(strings.NewReader(`
+storage:
+  type: s3
+  s3:
+    bucket: b
+    region: us-east-1
+    credentials:
+      access-key-id: key1
+      secret-access-key: secret1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCfg, err := PreviewConfigVar(oldCfg, "storage.s3.credentials.secret-access-key", "secret2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCfg, err = PreviewConfigVar(newCfg, "pitr.enabled", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(oldCfg, newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "pitr.enabled", Old: "false", New: "true"},
+		{Path: "storage.s3.credentials.secret-access-key", Old: "***", New: "***"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("got %v, want %v", changes, want)
+			break
+		}
+	}
+
+	if _, err := PreviewConfigVar(oldCfg, "backup.compressionLevel", "100"); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`
+storage:
+  type: filesystem
+  filesystem:
+    path: /tmp
+schedule:
+  nightly:
+    cron: "0 2 * * *"
+    type: physical
+    labels:
+      env: prod
+    retention:
+      keepLast: 7
+    catchUp: retry
+    catchUpWindow: 30m
+    maxDuration: 4h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := cfg.Schedule["nightly"]
+	if s.Window() != 30*time.Minute || s.BackupType() != defs.PhysicalBackup || s.MaxRunTime() != 4*time.Hour {
+		t.Errorf("unexpected schedule: %+v", s)
+	}
+
+	cfg, err = PreviewConfigVar(cfg, "schedule.hourly.cron", "@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = PreviewConfigVar(cfg, "schedule.hourly.labels", "a=1, b=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := cfg.Schedule["hourly"]
+	if h == nil || h.Cron != "@hourly" || h.Labels["b"] != "2" || h.Window() != 0 {
+		t.Errorf("unexpected schedule: %+v", h)
+	}
+
+	if _, err := PreviewConfigVar(cfg, "schedule.hourly.cron", "61 * * * *"); err == nil {
+		t.Error("expected error for invalid cron")
+	}
+	if _, err := PreviewConfigVar(cfg, "schedule.other.type", "logical"); err == nil ||
+		!strings.Contains(err.Error(), "schedule.other.cron") {
+		t.Errorf("expected missed cron error, got %v", err)
+	}
+	if _, err := PreviewConfigVar(cfg, "schedule.hourly.catchUp", "later"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	if _, err := PreviewConfigVar(cfg, "schedule.hourly.maxDuration", "10"); err == nil ||
+		!strings.Contains(err.Error(), "schedule.hourly.maxDuration") {
+		t.Errorf("expected max duration error, got %v", err)
+	}
+	if _, err := PreviewConfigVar(cfg, "schedule.hourly.unknown", "1"); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestPreviewOplogSpanMinRS(t *testing.T) {
+	cfg := &Config{PITR: &PITRConf{OplogSpanMin: 10}}
+
+	cfg, err := PreviewConfigVar(cfg, "pitr.oplogSpanMinRS.rs1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = PreviewConfigVar(cfg, "pitr.oplogSpanMinRS.rs2", "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.OplogSlicerIntervalRS("rs1"); got != 2*time.Minute {
+		t.Errorf("rs1 interval: got %v", got)
+	}
+	if got := cfg.OplogSlicerIntervalRS("rs2"); got != 30*time.Minute {
+		t.Errorf("rs2 interval: got %v", got)
+	}
+
+	// zero removes the override
+	cfg, err = PreviewConfigVar(cfg, "pitr.oplogSpanMinRS.rs1", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.PITR.OplogSpanMinRS["rs1"]; ok || cfg.OplogSlicerIntervalRS("rs1") != 10*time.Minute {
+		t.Errorf("rs1 override isn't removed: %v", cfg.PITR.OplogSpanMinRS)
+	}
+
+	for _, c := range []struct{ key, val string }{
+		{"pitr.oplogSpanMinRS.rs1", "0.01"},
+		{"pitr.oplogSpanMinRS.rs1", "-1"},
+		{"pitr.oplogSpanMinRS.rs1", "often"},
+		{"pitr.oplogSpanMinRS.", "5"},
+		{"pitr.oplogSpanMinRS.rs1.x", "5"},
+	} {
+		if _, err := PreviewConfigVar(cfg, c.key, c.val); err == nil {
+			t.Errorf("%s=%s: expected error", c.key, c.val)
+		}
+	}
+}
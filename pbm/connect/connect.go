@@ -2,8 +2,8 @@ package connect
 
 import (
 	"context"
-	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -110,9 +110,7 @@ func MongoConnectWithOpts(ctx context.Context,
 	uri string,
 	mongoOptions ...MongoOption,
 ) (*mongo.Client, *options.ClientOptions, error) {
-	if !strings.HasPrefix(uri, "mongodb://") {
-		uri = "mongodb://" + uri
-	}
+	uri = NormalizeURI(uri)
 
 	// default options
 	mopts := options.Client().
@@ -179,21 +177,41 @@ func MongoConnect(
 }
 
 type clientImpl struct {
-	client  *mongo.Client
+	client  atomic.Pointer[mongo.Client]
 	options *options.ClientOptions
+	// uri and appName are the ones of Connect to rebuild the
+	// connection with (see Reconnect)
+	uri     string
+	appName string
+	// wc is the write concern of the PBM collections (see SetWriteConcern).
+	// Nil is the one of the connection.
+	wc atomic.Pointer[writeconcern.WriteConcern]
+}
+
+func newClient(client *mongo.Client, opts *options.ClientOptions) *clientImpl {
+	c := &clientImpl{options: opts}
+	c.client.Store(client)
+	return c
 }
 
 func UnsafeClient(m *mongo.Client) *clientImpl {
-	return &clientImpl{
-		client:  m,
-		options: options.Client(),
-	}
+	return newClient(m, options.Client())
 }
 
 // Connect resolves MongoDB connection to Primary member and wraps it within Client object.
 // In case of replica set it returns connection to Primary member,
 // while in case of sharded cluster it returns connection to Config RS Primary member.
 func Connect(ctx context.Context, uri, appName string) (*clientImpl, error) {
+	c, err := connect(ctx, uri, appName)
+	if err != nil {
+		return nil, err
+	}
+	c.uri = uri
+	c.appName = appName
+	return c, nil
+}
+
+func connect(ctx context.Context, uri, appName string) (*clientImpl, error) {
 	client, opts, err := MongoConnectWithOpts(ctx, uri, AppName(appName))
 	if err != nil {
 		return nil, errors.Wrap(err, "create mongo connection")
@@ -205,10 +223,7 @@ func Connect(ctx context.Context, uri, appName string) (*clientImpl, error) {
 		return nil, errors.Wrap(err, "get NodeInfo")
 	}
 	if inf.isMongos() {
-		return &clientImpl{
-			client:  client,
-			options: opts,
-		}, nil
+		return newClient(client, opts), nil
 	}
 
 	inf.Opts, err = getMongodOpts(ctx, client, nil)
@@ -218,10 +233,7 @@ func Connect(ctx context.Context, uri, appName string) (*clientImpl, error) {
 	}
 
 	if inf.isClusterLeader() {
-		return &clientImpl{
-			client:  client,
-			options: opts,
-		}, nil
+		return newClient(client, opts), nil
 	}
 
 	csvr, err := getConfigsvrURI(ctx, client)
@@ -240,32 +252,45 @@ func Connect(ctx context.Context, uri, appName string) (*clientImpl, error) {
 		return nil, errors.Wrapf(err, "define config server connection URI from %s", csvr)
 	}
 
-	curi, err := url.Parse(uri)
-	if err != nil {
-		return nil, errors.Wrap(err, "parse mongo-uri")
-	}
-
 	// Preserving the `replicaSet` parameter will cause an error
 	// while connecting to the ConfigServer (mismatched replicaset names)
-	curi.Host = chost[1]
-	client, err = MongoConnect(ctx, curi.String(), AppName(appName), NoRS())
+	curi, err := HostsURI(uri, []string{chost[1]}, "replicaSet")
+	if err != nil {
+		return nil, err
+	}
+	client, err = MongoConnect(ctx, curi, AppName(appName), NoRS())
 	if err != nil {
 		return nil, errors.Wrap(err, "create mongo connection to configsvr")
 	}
 
-	return &clientImpl{
-		client:  client,
-		options: opts,
-	}, nil
+	return newClient(client, opts), nil
+}
+
+// MongosConnect connects to the mongos routers on hosts with
+// the credentials and options of the uri.
+func MongosConnect(ctx context.Context, uri string, hosts []string, mongoOptions ...MongoOption) (*mongo.Client, error) {
+	muri, err := MongosURI(uri, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	mongoOptions = append(mongoOptions, NoRS(), Direct(false))
+	return MongoConnect(ctx, muri, mongoOptions...)
+}
+
+// MongosURI returns the uri with the hosts of the mongos routers.
+// The replicaSet and directConnection options are removed.
+func MongosURI(uri string, hosts []string) (string, error) {
+	return HostsURI(uri, hosts, "replicaSet", "directConnection")
 }
 
 func (l *clientImpl) HasValidConnection(ctx context.Context) error {
-	err := l.client.Ping(ctx, readpref.Primary())
+	err := l.client.Load().Ping(ctx, readpref.Primary())
 	if err != nil {
 		return err
 	}
 
-	info, err := getNodeInfo(ctx, l.client)
+	info, err := getNodeInfo(ctx, l.client.Load())
 	if err != nil {
 		return errors.Wrap(err, "get node info ext")
 	}
@@ -278,68 +303,155 @@ func (l *clientImpl) HasValidConnection(ctx context.Context) error {
 }
 
 func (l *clientImpl) Disconnect(ctx context.Context) error {
-	return l.client.Disconnect(ctx)
+	return l.client.Load().Disconnect(ctx)
 }
 
 func (l *clientImpl) MongoClient() *mongo.Client {
-	return l.client
+	return l.client.Load()
+}
+
+// Reconnect replaces the connection with a new one made the way Connect
+// does. The old one is disconnected after ReplacedClientGrace (see
+// DisconnectAfter), so operations in flight on it aren't broken.
+// The write concern set by SetWriteConcern is kept.
+func (l *clientImpl) Reconnect(ctx context.Context) error {
+	if l.uri == "" {
+		return errors.New("no connection string to reconnect with")
+	}
+
+	c, err := connect(ctx, l.uri, l.appName)
+	if err != nil {
+		return err
+	}
+
+	old := l.client.Swap(c.client.Load())
+	DisconnectAfter(old, ReplacedClientGrace)
+	return nil
+}
+
+const (
+	// ReplacedClientGrace is how long the replaced client stays connected
+	// for the operations which have got it before the replacement
+	ReplacedClientGrace = 30 * time.Minute
+	// replacedClientDrain is how long the disconnect of the replaced client
+	// waits for the connections in use to be returned to the pool
+	replacedClientDrain = time.Minute
+)
+
+// DisconnectAfter disconnects the client in the background after the grace
+// period. The connections in use by then are waited for replacedClientDrain.
+// Operations started on the client after the disconnect fail.
+func DisconnectAfter(c *mongo.Client, grace time.Duration) {
+	if c == nil {
+		return
+	}
+
+	time.AfterFunc(grace, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), replacedClientDrain)
+		defer cancel()
+
+		_ = c.Disconnect(ctx)
+	})
 }
 
 func (l *clientImpl) MongoOptions() *options.ClientOptions {
 	return l.options
 }
 
+// SetWriteConcern sets the write concern of the writes to the PBM
+// collections (`cluster.writeConcern`). Nil sets back the one of
+// the connection.
+func (l *clientImpl) SetWriteConcern(wc *writeconcern.WriteConcern) {
+	l.wc.Store(wc)
+}
+
+// WriteConcern returns the write concern of the writes to the PBM collections.
+func (l *clientImpl) WriteConcern() *writeconcern.WriteConcern {
+	if wc := l.wc.Load(); wc != nil {
+		return wc
+	}
+	return l.options.WriteConcern
+}
+
+func (l *clientImpl) pbmDatabase() *mongo.Database {
+	return l.client.Load().Database(defs.DB, options.Database().SetWriteConcern(l.wc.Load()))
+}
+
 func (l *clientImpl) ConfigDatabase() *mongo.Database {
-	return l.client.Database("config")
+	return l.client.Load().Database("config")
 }
 
 func (l *clientImpl) AdminCommand(ctx context.Context, cmd bson.D, opts ...*options.RunCmdOptions) *mongo.SingleResult {
 	cmd = l.applyOptonsFromConnString(cmd)
-	return l.client.Database(defs.DB).RunCommand(ctx, cmd, opts...)
+	return l.client.Load().Database(defs.DB).RunCommand(ctx, cmd, opts...)
 }
 
 func (l *clientImpl) LogCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.LogCollection)
+	return l.pbmDatabase().Collection(defs.LogCollection)
 }
 
 func (l *clientImpl) ConfigCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.ConfigCollection)
+	return l.pbmDatabase().Collection(defs.ConfigCollection)
+}
+
+func (l *clientImpl) ConfigHistoryCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.ConfigHistoryCollection)
 }
 
 func (l *clientImpl) LockCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.LockCollection)
+	return l.pbmDatabase().Collection(defs.LockCollection)
 }
 
 func (l *clientImpl) LockOpCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.LockOpCollection)
+	return l.pbmDatabase().Collection(defs.LockOpCollection)
 }
 
 func (l *clientImpl) BcpCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.BcpCollection)
+	return l.pbmDatabase().Collection(defs.BcpCollection)
 }
 
 func (l *clientImpl) RestoresCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.RestoresCollection)
+	return l.pbmDatabase().Collection(defs.RestoresCollection)
 }
 
 func (l *clientImpl) CmdStreamCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.CmdStreamCollection)
+	return l.pbmDatabase().Collection(defs.CmdStreamCollection)
 }
 
 func (l *clientImpl) PITRChunksCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.PITRChunksCollection)
+	return l.pbmDatabase().Collection(defs.PITRChunksCollection)
 }
 
 func (l *clientImpl) PITRCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.PITRCollection)
+	return l.pbmDatabase().Collection(defs.PITRCollection)
+}
+
+func (l *clientImpl) PITRGapsCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.PITRGapsCollection)
+}
+
+func (l *clientImpl) PITRVerifyCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.PITRVerifyCollection)
+}
+
+func (l *clientImpl) ScheduleRunsCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.ScheduleRunsCollection)
+}
+
+func (l *clientImpl) StorageDriftCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.StorageDriftCollection)
+}
+
+func (l *clientImpl) QueueCollection() *mongo.Collection {
+	return l.pbmDatabase().Collection(defs.QueueCollection)
 }
 
 func (l *clientImpl) PBMOpLogCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.PBMOpLogCollection)
+	return l.pbmDatabase().Collection(defs.PBMOpLogCollection)
 }
 
 func (l *clientImpl) AgentsStatusCollection() *mongo.Collection {
-	return l.client.Database(defs.DB).Collection(defs.AgentsStatusCollection)
+	return l.pbmDatabase().Collection(defs.AgentsStatusCollection)
 }
 
 func (l *clientImpl) applyOptonsFromConnString(cmd bson.D) bson.D {
@@ -350,8 +462,8 @@ func (l *clientImpl) applyOptonsFromConnString(cmd bson.D) bson.D {
 	cmdName := cmd[0].Key
 	switch cmdName {
 	case "create":
-		if l.options.WriteConcern != nil {
-			cmd = append(cmd, bson.E{"writeConcern", l.options.WriteConcern})
+		if wc := l.WriteConcern(); wc != nil {
+			cmd = append(cmd, bson.E{"writeConcern", wc})
 		}
 	default:
 		// do nothing for all other commands:
@@ -371,12 +483,17 @@ type Client interface {
 
 	MongoClient() *mongo.Client
 	MongoOptions() *options.ClientOptions
+	Reconnect(ctx context.Context) error
+
+	SetWriteConcern(wc *writeconcern.WriteConcern)
+	WriteConcern() *writeconcern.WriteConcern
 
 	ConfigDatabase() *mongo.Database
 	AdminCommand(ctx context.Context, cmd bson.D, opts ...*options.RunCmdOptions) *mongo.SingleResult
 
 	LogCollection() *mongo.Collection
 	ConfigCollection() *mongo.Collection
+	ConfigHistoryCollection() *mongo.Collection
 	LockCollection() *mongo.Collection
 	LockOpCollection() *mongo.Collection
 	BcpCollection() *mongo.Collection
@@ -384,6 +501,11 @@ type Client interface {
 	CmdStreamCollection() *mongo.Collection
 	PITRChunksCollection() *mongo.Collection
 	PITRCollection() *mongo.Collection
+	PITRGapsCollection() *mongo.Collection
+	PITRVerifyCollection() *mongo.Collection
+	ScheduleRunsCollection() *mongo.Collection
+	StorageDriftCollection() *mongo.Collection
+	QueueCollection() *mongo.Collection
 	PBMOpLogCollection() *mongo.Collection
 	AgentsStatusCollection() *mongo.Collection
 }
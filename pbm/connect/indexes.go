@@ -0,0 +1,248 @@
+package connect
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ControlIndex is an index of the PBM control collections maintained by
+// the lead agent (see EnsureControlIndexes).
+type ControlIndex struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+}
+
+// ControlIndexes are the indexes of the queries of the control collections
+// that would scan the collections otherwise.
+var ControlIndexes = []ControlIndex{
+	{defs.LogCollection, "pbm_opid_ts", bson.D{{"opid", 1}, {"ts", 1}}},
+	{defs.LogCollection, "pbm_ts", bson.D{{"ts", 1}}},
+	{defs.BcpCollection, "pbm_status_start", bson.D{{"status", 1}, {"start_ts", 1}}},
+	{defs.RestoresCollection, "pbm_status_start", bson.D{{"status", 1}, {"start_ts", 1}}},
+}
+
+// namespaceNotFoundCode is the error code of listIndexes of the
+// collection that doesn't exist yet
+const namespaceNotFoundCode = 26
+
+// Index states of ControlIndexState
+const (
+	IndexOK       = "ok"
+	IndexMissing  = "missing"
+	IndexOutdated = "outdated"
+)
+
+// ControlIndexState is the state of the control index in the database
+type ControlIndexState struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+}
+
+// EnsureControlIndexes creates the missing control indexes in the PBM
+// database db and recreates the ones with other keys. The indexes in
+// place are left as is, so it can be called any time. It returns the
+// indexes changed.
+func EnsureControlIndexes(ctx context.Context, db *mongo.Database) ([]string, error) {
+	state, err := ControlIndexesState(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for i, s := range state {
+		if s.State == IndexOK {
+			continue
+		}
+
+		idx := ControlIndexes[i]
+		coll := db.Collection(idx.Collection)
+		if s.State == IndexOutdated {
+			_, err := coll.Indexes().DropOne(ctx, idx.Name)
+			if err != nil {
+				return changed, errors.Wrapf(err, "drop outdated index %s.%s", idx.Collection, idx.Name)
+			}
+		}
+		_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    idx.Keys,
+			Options: options.Index().SetName(idx.Name),
+		})
+		if err != nil {
+			return changed, errors.Wrapf(err, "create index %s.%s", idx.Collection, idx.Name)
+		}
+		changed = append(changed, idx.Collection+"."+idx.Name)
+	}
+
+	return changed, nil
+}
+
+// ControlIndexesState returns the state of each of ControlIndexes
+// in the PBM database db.
+func ControlIndexesState(ctx context.Context, db *mongo.Database) ([]ControlIndexState, error) {
+	existing := make(map[string]map[string]bson.D)
+	rv := make([]ControlIndexState, len(ControlIndexes))
+	for i, idx := range ControlIndexes {
+		keys, ok := existing[idx.Collection]
+		if !ok {
+			var err error
+			keys, err = indexKeys(ctx, db.Collection(idx.Collection))
+			if err != nil {
+				return nil, errors.Wrapf(err, "list indexes of %s", idx.Collection)
+			}
+			existing[idx.Collection] = keys
+		}
+
+		rv[i] = ControlIndexState{Collection: idx.Collection, Name: idx.Name, State: IndexMissing}
+		if k, ok := keys[idx.Name]; ok {
+			rv[i].State = IndexOutdated
+			if sameIndexKeys(k, idx.Keys) {
+				rv[i].State = IndexOK
+			}
+		}
+	}
+
+	return rv, nil
+}
+
+// indexKeys returns the keys of the collection indexes by the index name
+func indexKeys(ctx context.Context, coll *mongo.Collection) (map[string]bson.D, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		var ce mongo.CommandError
+		if errors.As(err, &ce) && ce.Code == namespaceNotFoundCode {
+			return map[string]bson.D{}, nil
+		}
+		return nil, err
+	}
+
+	var specs []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	if err := cur.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+
+	rv := make(map[string]bson.D, len(specs))
+	for _, s := range specs {
+		rv[s.Name] = s.Key
+	}
+	return rv, nil
+}
+
+// sameIndexKeys compares the index keys. The server may return the
+// direction as int32, int64 or double.
+func sameIndexKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+		av, aok := keyDirection(a[i].Value)
+		bv, bok := keyDirection(b[i].Value)
+		if aok != bok || (aok && av != bv) || (!aok && !reflect.DeepEqual(a[i].Value, b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func keyDirection(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// LogTTLIndex is the name of the TTL index of the log entries time
+// (see EnsureLogTTL)
+const LogTTLIndex = "pbm_ttl"
+
+// EnsureLogTTL makes the log entries of the PBM database db expire after
+// the retention by the TTL index on the entry time (`t`). The index is
+// created, its expiration is changed with collMod if the retention is,
+// and dropped with the zero retention.
+// It returns false if the log collection doesn't support TTL indexes
+// (it is capped), the entries have to be deleted otherwise then.
+func EnsureLogTTL(ctx context.Context, db *mongo.Database, retention time.Duration) (bool, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{{"name", defs.LogCollection}})
+	if err != nil {
+		return false, errors.Wrap(err, "get log collection options")
+	}
+	if len(specs) != 0 {
+		if capped, ok := specs[0].Options.Lookup("capped").BooleanOK(); ok && capped {
+			return false, nil
+		}
+	}
+
+	coll := db.Collection(defs.LogCollection)
+	expire, exists, err := ttlIndexExpire(ctx, coll, LogTTLIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "get ttl index")
+	}
+
+	sec := int32(retention.Seconds())
+	switch {
+	case retention == 0:
+		if exists {
+			_, err = coll.Indexes().DropOne(ctx, LogTTLIndex)
+			return true, errors.Wrap(err, "drop ttl index")
+		}
+	case !exists:
+		_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{"t", 1}},
+			Options: options.Index().SetName(LogTTLIndex).SetExpireAfterSeconds(sec),
+		})
+		return true, errors.Wrap(err, "create ttl index")
+	case expire != int64(sec):
+		err = db.RunCommand(ctx, bson.D{
+			{"collMod", defs.LogCollection},
+			{"index", bson.D{{"name", LogTTLIndex}, {"expireAfterSeconds", sec}}},
+		}).Err()
+		return true, errors.Wrap(err, "update ttl index")
+	}
+
+	return true, nil
+}
+
+// ttlIndexExpire returns the expireAfterSeconds of the collection index
+func ttlIndexExpire(ctx context.Context, coll *mongo.Collection, name string) (int64, bool, error) {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		var ce mongo.CommandError
+		if errors.As(err, &ce) && ce.Code == namespaceNotFoundCode {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	for _, s := range specs {
+		if s.Name != name {
+			continue
+		}
+		if s.ExpireAfterSeconds == nil {
+			return 0, true, nil
+		}
+		return int64(*s.ExpireAfterSeconds), true, nil
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,214 @@
+package connect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+)
+
+func TestSameIndexKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b bson.D
+		want bool
+	}{
+		{"same", bson.D{{"opid", 1}, {"ts", 1}}, bson.D{{"opid", int32(1)}, {"ts", 1.0}}, true},
+		{"order", bson.D{{"ts", 1}, {"opid", 1}}, bson.D{{"opid", 1}, {"ts", 1}}, false},
+		{"direction", bson.D{{"ts", -1}}, bson.D{{"ts", 1}}, false},
+		{"prefix", bson.D{{"opid", 1}}, bson.D{{"opid", 1}, {"ts", 1}}, false},
+		{"type", bson.D{{"ts", "hashed"}}, bson.D{{"ts", "hashed"}}, true},
+		{"type and direction", bson.D{{"ts", "hashed"}}, bson.D{{"ts", 1}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sameIndexKeys(tc.a, tc.b); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func newTestDB(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	ctx := context.Background()
+	// testcontainers panics if there is no docker
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("create mongo test container: %v", r)
+		}
+	}()
+	c, err := mongodb.Run(ctx, "perconalab/percona-server-mongodb:7.0")
+	if err != nil {
+		t.Skipf("create mongo test container: %v", err)
+	}
+	t.Cleanup(func() { _ = testcontainers.TerminateContainer(c) })
+
+	uri, err := c.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("connection string: %v", err)
+	}
+	cn, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = cn.Disconnect(context.Background()) })
+
+	return cn.Database(defs.DB)
+}
+
+func checkControlIndexes(t *testing.T, db *mongo.Database) {
+	t.Helper()
+
+	state, err := ControlIndexesState(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range state {
+		if s.State != IndexOK {
+			t.Errorf("%s.%s: %s", s.Collection, s.Name, s.State)
+		}
+	}
+}
+
+func TestEnsureControlIndexes(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	t.Run("fresh", func(t *testing.T) {
+		state, err := ControlIndexesState(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range state {
+			if s.State != IndexMissing {
+				t.Errorf("%s.%s: want %s, got %s", s.Collection, s.Name, IndexMissing, s.State)
+			}
+		}
+
+		changed, err := EnsureControlIndexes(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changed) != len(ControlIndexes) {
+			t.Errorf("changed: want %d, got %v", len(ControlIndexes), changed)
+		}
+		checkControlIndexes(t, db)
+
+		changed, err = EnsureControlIndexes(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changed) != 0 {
+			t.Errorf("changed on the second run: %v", changed)
+		}
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		if err := db.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// the data of an older version: capped log with the entries,
+		// the index of the same name with other keys
+		err := db.CreateCollection(ctx, defs.LogCollection,
+			options.CreateCollection().SetCapped(true).SetSizeInBytes(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Collection(defs.LogCollection).InsertMany(ctx, []any{
+			bson.D{{"ts", int64(1)}, {"opid", "a"}},
+			bson.D{{"ts", int64(2)}, {"opid", "b"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Collection(defs.BcpCollection).InsertOne(ctx, bson.D{{"name", "b"}, {"status", "done"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Collection(defs.LogCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{"ts", -1}, {"opid", 1}},
+			Options: options.Index().SetName("pbm_ts"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed, err := EnsureControlIndexes(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changed) != len(ControlIndexes) {
+			t.Errorf("changed: want %d, got %v", len(ControlIndexes), changed)
+		}
+		checkControlIndexes(t, db)
+	})
+}
+
+func logTTL(t *testing.T, db *mongo.Database) (int64, bool) {
+	t.Helper()
+
+	expire, ok, err := ttlIndexExpire(context.Background(), db.Collection(defs.LogCollection), LogTTLIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expire, ok
+}
+
+func TestEnsureLogTTL(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	t.Run("not capped", func(t *testing.T) {
+		for _, c := range []struct {
+			name      string
+			retention time.Duration
+			want      int64
+			exists    bool
+		}{
+			{"create", 14 * 24 * time.Hour, 14 * 24 * 3600, true},
+			{"same", 14 * 24 * time.Hour, 14 * 24 * 3600, true},
+			{"change", 24 * time.Hour, 24 * 3600, true},
+			{"drop", 0, 0, false},
+			{"no retention", 0, 0, false},
+		} {
+			ttl, err := EnsureLogTTL(ctx, db, c.retention)
+			if err != nil || !ttl {
+				t.Fatalf("%s: ttl %v, err %v", c.name, ttl, err)
+			}
+			expire, ok := logTTL(t, db)
+			if ok != c.exists || expire != c.want {
+				t.Errorf("%s: want %v (%v), got %v (%v)", c.name, c.want, c.exists, expire, ok)
+			}
+		}
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		if err := db.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+		err := db.CreateCollection(ctx, defs.LogCollection,
+			options.CreateCollection().SetCapped(true).SetSizeInBytes(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ttl, err := EnsureLogTTL(ctx, db, 24*time.Hour)
+		if err != nil || ttl {
+			t.Fatalf("ttl %v, err %v", ttl, err)
+		}
+		if _, ok := logTTL(t, db); ok {
+			t.Error("ttl index is created on the capped collection")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package connect
+
+import (
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+const (
+	schemeMongoDB    = "mongodb://"
+	schemeMongoDBSRV = "mongodb+srv://"
+)
+
+// NormalizeURI adds the `mongodb://` scheme to the uri without one.
+// The `mongodb+srv://` uri is kept as is.
+func NormalizeURI(uri string) string {
+	if strings.HasPrefix(uri, schemeMongoDB) || strings.HasPrefix(uri, schemeMongoDBSRV) {
+		return uri
+	}
+
+	return schemeMongoDB + uri
+}
+
+// ParseURI parses and validates the connection string. The hosts and
+// options of the `mongodb+srv://` uri are resolved from DNS.
+func ParseURI(uri string) (*connstring.ConnString, error) {
+	cs, err := connstring.ParseAndValidate(NormalizeURI(uri))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse mongo-uri")
+	}
+
+	return cs, nil
+}
+
+// HostsURI returns the uri with the hosts instead of the uri ones, keeping
+// the credentials and options except dropOpts. `mongodb+srv://` becomes
+// `mongodb://` with the options the SRV uri implies (TLS and the TXT
+// record options).
+func HostsURI(uri string, hosts []string, dropOpts ...string) (string, error) {
+	uri = NormalizeURI(uri)
+	curi, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.Wrap(err, "parse mongo-uri")
+	}
+
+	q := curi.Query()
+	if strings.HasPrefix(uri, schemeMongoDBSRV) {
+		cs, err := ParseURI(uri)
+		if err != nil {
+			return "", err
+		}
+
+		curi.Scheme = "mongodb"
+		if cs.SSL && !queryHas(q, "tls", "ssl") {
+			q.Set("tls", "true")
+		}
+		if cs.AuthSourceSet && !queryHas(q, "authSource") {
+			q.Set("authSource", cs.AuthSource)
+		}
+		if cs.ReplicaSet != "" && !queryHas(q, "replicaSet") {
+			q.Set("replicaSet", cs.ReplicaSet)
+		}
+		// valid for SRV only
+		queryDel(q, "srvServiceName", "srvMaxHosts")
+	}
+
+	queryDel(q, dropOpts...)
+
+	curi.Host = strings.Join(hosts, ",")
+	curi.RawQuery = q.Encode()
+	return curi.String(), nil
+}
+
+// queryHas returns true if the query has any of the keys. The keys of
+// the connection string options are case-insensitive.
+func queryHas(q url.Values, keys ...string) bool {
+	for k := range q {
+		for _, key := range keys {
+			if strings.EqualFold(k, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// queryDel removes the keys from the query, ignoring case.
+func queryDel(q url.Values, keys ...string) {
+	for k := range q {
+		for _, key := range keys {
+			if strings.EqualFold(k, key) {
+				q.Del(k)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
@@ -29,6 +30,14 @@ const (
 	CmdDeleteBackup        Command = "delete"
 	CmdDeletePITR          Command = "deletePitr"
 	CmdCleanup             Command = "cleanup"
+	CmdPITRCompact         Command = "pitrCompact"
+	CmdDumpState           Command = "dumpState"
+	CmdRebalance           Command = "rebalance"
+	CmdCancelRebalance     Command = "cancelRebalance"
+	CmdStorageMigrate      Command = "storageMigrate"
+	CmdCancelRestore       Command = "cancelRestore"
+	CmdVerifyRestore       Command = "verifyRestore"
+	CmdPITRVerify          Command = "pitrVerify"
 )
 
 func (c Command) String() string {
@@ -55,6 +64,22 @@ func (c Command) String() string {
 		return "Delete PITR chunks"
 	case CmdCleanup:
 		return "Cleanup backups and PITR chunks"
+	case CmdPITRCompact:
+		return "Compact PITR chunks"
+	case CmdDumpState:
+		return "Dump agent state"
+	case CmdRebalance:
+		return "Rebalance restored chunks"
+	case CmdCancelRebalance:
+		return "Rebalance cancellation"
+	case CmdStorageMigrate:
+		return "Migrate backups between storages"
+	case CmdCancelRestore:
+		return "Restore cancellation"
+	case CmdVerifyRestore:
+		return "Restore verification"
+	case CmdPITRVerify:
+		return "PITR chunks verification"
 	default:
 		return "Undefined"
 	}
@@ -81,17 +106,26 @@ func (o OPID) Obj() primitive.ObjectID {
 }
 
 type Cmd struct {
-	Cmd        Command          `bson:"cmd"`
-	Resync     *ResyncCmd       `bson:"resync,omitempty"`
-	Profile    *ProfileCmd      `bson:"profile,omitempty"`
-	Backup     *BackupCmd       `bson:"backup,omitempty"`
-	Restore    *RestoreCmd      `bson:"restore,omitempty"`
-	Replay     *ReplayCmd       `bson:"replay,omitempty"`
-	Delete     *DeleteBackupCmd `bson:"delete,omitempty"`
-	DeletePITR *DeletePITRCmd   `bson:"deletePitr,omitempty"`
-	Cleanup    *CleanupCmd      `bson:"cleanup,omitempty"`
-	TS         int64            `bson:"ts"`
-	OPID       OPID             `bson:"-"`
+	Cmd           Command           `bson:"cmd"`
+	Resync        *ResyncCmd        `bson:"resync,omitempty"`
+	Profile       *ProfileCmd       `bson:"profile,omitempty"`
+	Backup        *BackupCmd        `bson:"backup,omitempty"`
+	Restore       *RestoreCmd       `bson:"restore,omitempty"`
+	Replay        *ReplayCmd        `bson:"replay,omitempty"`
+	Delete        *DeleteBackupCmd  `bson:"delete,omitempty"`
+	DeletePITR    *DeletePITRCmd    `bson:"deletePitr,omitempty"`
+	Cleanup       *CleanupCmd       `bson:"cleanup,omitempty"`
+	PITRCompact   *PITRCompactCmd   `bson:"pitrCompact,omitempty"`
+	Rebalance     *RebalanceCmd     `bson:"rebalance,omitempty"`
+	Migrate       *MigrateCmd       `bson:"migrate,omitempty"`
+	CancelRestore *CancelRestoreCmd `bson:"cancelRestore,omitempty"`
+	VerifyRestore *VerifyRestoreCmd `bson:"verifyRestore,omitempty"`
+	PITRVerify    *PITRVerifyCmd    `bson:"pitrVerify,omitempty"`
+	TS            int64             `bson:"ts"`
+	// Initiator is who has sent the command.
+	// Nil for the commands sent by older clients.
+	Initiator *Initiator `bson:"initiator,omitempty"`
+	OPID      OPID       `bson:"-"`
 }
 
 func (c Cmd) String() string {
@@ -110,10 +144,29 @@ func (c Cmd) String() string {
 	}
 	buf.WriteString(" <ts: ")
 	buf.WriteString(strconv.FormatInt(c.TS, 10))
+	if c.Initiator != nil {
+		buf.WriteString(", by: ")
+		buf.WriteString(c.Initiator.String())
+	}
 	buf.WriteString(">")
 	return buf.String()
 }
 
+// propagateInitiator passes the initiator to the commands
+// which save it into their metadata
+func (c *Cmd) propagateInitiator() {
+	if c.Initiator == nil {
+		return
+	}
+
+	switch {
+	case c.Backup != nil:
+		c.Backup.Initiator = c.Initiator
+	case c.Restore != nil:
+		c.Restore.Initiator = c.Initiator.String()
+	}
+}
+
 type ProfileCmd struct {
 	Name      string             `bson:"name"`
 	IsProfile bool               `bson:"profile"`
@@ -124,6 +177,8 @@ type ResyncCmd struct {
 	Name  string `bson:"name,omitempty"`
 	All   bool   `bson:"all,omitempty"`
 	Clear bool   `bson:"clear,omitempty"`
+	// ForceClaim takes the storage over from another cluster
+	ForceClaim bool `bson:"forceClaim,omitempty"`
 }
 
 type BackupCmd struct {
@@ -136,6 +191,27 @@ type BackupCmd struct {
 	NumParallelColls *int32                   `bson:"numParallelColls,omitempty"`
 	Filelist         bool                     `bson:"filelist,omitempty"`
 	Profile          string                   `bson:"profile,omitempty"`
+	Labels           map[string]string        `bson:"labels,omitempty"`
+	// Replset is the only replset to backup
+	Replset string `bson:"rs,omitempty"`
+	// Initiator is who has sent the command. It isn't stored in
+	// the command, ListenCmd sets it from the Cmd.
+	Initiator *Initiator `bson:"-"`
+	// ClusterTime extends the oplog capture of the logical backup until
+	// the cluster time passes the target (see `--wait-for-cluster-time`)
+	ClusterTime *ClusterTimeTarget `bson:"clusterTime,omitempty"`
+	// MaxDurationSec aborts the backup running longer.
+	// Zero is `backup.maxDuration` of the config.
+	MaxDurationSec int64 `bson:"maxDurationSec,omitempty"`
+	// ForceClaim takes the storage over from another cluster
+	ForceClaim bool `bson:"forceClaim,omitempty"`
+}
+
+// ClusterTimeTarget is the cluster time the backup has to cover
+type ClusterTimeTarget struct {
+	TS primitive.Timestamp `bson:"ts"`
+	// TimeoutSec caps the wait for the cluster time
+	TimeoutSec int64 `bson:"timeoutSec"`
 }
 
 func (b BackupCmd) String() string {
@@ -145,7 +221,20 @@ func (b BackupCmd) String() string {
 	} else {
 		level = strconv.Itoa(*b.CompressionLevel)
 	}
-	return fmt.Sprintf("name: %s, compression: %s (level: %s)", b.Name, b.Compression, level)
+	s := fmt.Sprintf("name: %s, compression: %s (level: %s)", b.Name, b.Compression, level)
+	if b.Replset != "" {
+		s += ", replset: " + b.Replset
+	}
+	if t := b.ClusterTime; t != nil {
+		s += fmt.Sprintf(", wait for cluster time: %d,%d (timeout %ds)", t.TS.T, t.TS.I, t.TimeoutSec)
+	}
+	if b.MaxDurationSec > 0 {
+		s += fmt.Sprintf(", max duration: %ds", b.MaxDurationSec)
+	}
+	if b.ForceClaim {
+		s += ", force claim storage"
+	}
+	return s
 }
 
 type RestoreCmd struct {
@@ -156,6 +245,44 @@ type RestoreCmd struct {
 	NamespaceTo   string            `bson:"nsTo,omitempty"`
 	UsersAndRoles bool              `bson:"usersAndRoles,omitempty"`
 	RSMap         map[string]string `bson:"rsMap,omitempty"`
+	// UsersAndRolesOnly restores the users and roles of the backup
+	// without the data. Replset is the config server in sharded clusters.
+	UsersAndRolesOnly bool `bson:"usersAndRolesOnly,omitempty"`
+	// UsersAndRolesMode is how the users and roles are applied.
+	// UsersAndRolesMerge is the default.
+	UsersAndRolesMode string `bson:"usersAndRolesMode,omitempty"`
+	// NoDrop restores the collections of the backup without dropping
+	// the existing ones. Documents with the taken _id are skipped.
+	NoDrop bool `bson:"noDrop,omitempty"`
+	// NSConflicts are the collections of the target that conflict with
+	// the backup ones and their decisions. Only with NoDrop.
+	NSConflicts *RestoreNSConflicts `bson:"nsConflicts,omitempty"`
+	// SkipCollOptions removes the validators of the restored collections
+	// instead of re-asserting the backup ones after the data load
+	// (logical restore only)
+	SkipCollOptions bool `bson:"skipCollOptions,omitempty"`
+	// KeepProfiling keeps the profiling levels of the databases as is.
+	// By default, the logical restore turns the profiler off for its time.
+	KeepProfiling bool `bson:"keepProfiling,omitempty"`
+	// Replset is the only replset to restore
+	Replset string `bson:"rs,omitempty"`
+	// Merge is the backup replsets restored to the target replset in
+	// addition to the one of RSMap (logical restore to a cluster with
+	// fewer shards)
+	Merge map[string][]string `bson:"merge,omitempty"`
+	// SourceCluster is the cluster the backup is made on. Required
+	// to restore backups of another cluster.
+	SourceCluster string `bson:"sourceCluster,omitempty"`
+	// TargetCheck is the pre-flight check of the target cluster
+	// made by the client. Nil if not checked.
+	TargetCheck *RestoreTargetCheck `bson:"targetCheck,omitempty"`
+	// Initiator is the user@host the restore is started by. ListenCmd
+	// replaces it with the initiator of the Cmd if the client has sent it.
+	Initiator string `bson:"initiator,omitempty"`
+	// PITREnabled is true if PITR was enabled when the client started
+	// the restore. The restore disables it and the cluster leader enables
+	// it back after a fresh base backup.
+	PITREnabled bool `bson:"pitrEnabled,omitempty"`
 
 	NumParallelColls    *int32 `bson:"numParallelColls,omitempty"`
 	NumInsertionWorkers *int32 `bson:"numInsertionWorkers,omitempty"`
@@ -165,6 +292,10 @@ type RestoreCmd struct {
 	External bool                `bson:"external"`
 	ExtConf  topo.ExternOpts     `bson:"extConf"`
 	ExtTS    primitive.Timestamp `bson:"extTS"`
+
+	// DBpathMap is the dbpath of the nodes (host:port) to restore
+	// the physical backup to instead of the dbpath of their mongod
+	DBpathMap map[string]string `bson:"dbpathMap,omitempty"`
 }
 
 func (r RestoreCmd) String() string {
@@ -182,32 +313,260 @@ func (r RestoreCmd) String() string {
 		bcp += fmt.Sprintf(" point-in-time: <%d,%d>", r.OplogTS.T, r.OplogTS.I)
 	}
 
+	if r.Replset != "" {
+		bcp += " replset: " + r.Replset
+	}
+	if r.UsersAndRolesOnly {
+		bcp += " users and roles only: " + r.UsersAndRolesMode
+	}
+	if r.NoDrop {
+		bcp += " no drop"
+	}
+	if r.SkipCollOptions {
+		bcp += " skip collection options"
+	}
+	if r.KeepProfiling {
+		bcp += " keep profiling"
+	}
+
 	return fmt.Sprintf("name: %s, %s", r.Name, bcp)
 }
 
+// Modes of the users and roles only restore
+const (
+	// UsersAndRolesMerge creates and updates the users and roles of
+	// the backup. Others are kept.
+	UsersAndRolesMerge = "merge"
+	// UsersAndRolesReplace is UsersAndRolesMerge that also deletes
+	// the users and roles not in the backup.
+	UsersAndRolesReplace = "replace"
+)
+
+// Overrides of the failed restore target check
+const (
+	RestoreOverrideForce     = "force"
+	RestoreOverrideConfirmed = "confirmed"
+)
+
+// RestoreTargetCheck is the result of the check of the restore target
+// against the backup. The check fails if the target has data the restore
+// would destroy: databases not in the backup and writes after the time
+// the backup restores to.
+type RestoreTargetCheck struct {
+	// Until is the time the restore restores to
+	Until primitive.Timestamp `bson:"until" json:"until"`
+	// FirstWrite is the first write of user data on the target after Until.
+	// Zero if there is none.
+	FirstWrite primitive.Timestamp `bson:"firstWrite,omitempty" json:"first_write,omitempty"`
+	// ExtraDBs are databases of the target not in the backup
+	ExtraDBs []string `bson:"extraDBs,omitempty" json:"extra_dbs,omitempty"`
+	// DBsChecked is false if the databases of the backup are unknown
+	// (physical and legacy backups, selective restores)
+	DBsChecked bool `bson:"dbsChecked" json:"dbs_checked"`
+	// Override is how the failed check was overridden (force or confirmed)
+	Override string `bson:"override,omitempty" json:"override,omitempty"`
+}
+
+// Failed returns true if the target has data the restore destroys
+func (c *RestoreTargetCheck) Failed() bool {
+	return !c.FirstWrite.IsZero() || len(c.ExtraDBs) != 0
+}
+
+func (c *RestoreTargetCheck) String() string {
+	if !c.Failed() {
+		return "passed"
+	}
+
+	s := "failed"
+	if !c.FirstWrite.IsZero() {
+		s += fmt.Sprintf(", write after the restore time <%d,%d>", c.FirstWrite.T, c.FirstWrite.I)
+	}
+	if len(c.ExtraDBs) != 0 {
+		s += ", databases not in the backup: " + strings.Join(c.ExtraDBs, ", ")
+	}
+	if c.Override != "" {
+		s += ", overridden by " + c.Override
+	}
+	return s
+}
+
+// Policies of the collections conflicts of the restore without drop
+const (
+	// NSConflictAbort refuses the restore if there are conflicts
+	NSConflictAbort = "abort"
+	// NSConflictSkip restores the backup without the conflicting
+	// namespaces. The target collections are kept.
+	NSConflictSkip = "skip"
+	// NSConflictRecreate drops the conflicting collections of the target
+	// and restores them from the backup.
+	NSConflictRecreate = "recreate"
+)
+
+// RestoreNSConflicts is the check of the existing collections of the
+// target against the collections of the backup before the restore without
+// drop. A collection conflicts if it has another UUID, options or type.
+type RestoreNSConflicts struct {
+	// Policy is how the conflicts are resolved
+	Policy    string       `bson:"policy" json:"policy"`
+	Conflicts []NSConflict `bson:"conflicts,omitempty" json:"conflicts,omitempty"`
+	// Override is how dropping of the collections is confirmed
+	// (force or confirmed). Only with NSConflictRecreate.
+	Override string `bson:"override,omitempty" json:"override,omitempty"`
+}
+
+// NSConflict is a collection of the target that conflicts with the backup
+type NSConflict struct {
+	NS string `bson:"ns" json:"ns"`
+	// Reasons are what differs: uuid, options or type
+	Reasons []string `bson:"reasons" json:"reasons"`
+	// Decision is what the restore does with the namespace: skip or recreate
+	Decision string `bson:"decision" json:"decision"`
+}
+
+// Decided returns the namespaces of the conflicts with the decision
+func (c *RestoreNSConflicts) Decided(decision string) []string {
+	if c == nil {
+		return nil
+	}
+
+	var rv []string
+	for _, n := range c.Conflicts {
+		if n.Decision == decision {
+			rv = append(rv, n.NS)
+		}
+	}
+	return rv
+}
+
+func (c *RestoreNSConflicts) String() string {
+	if len(c.Conflicts) == 0 {
+		return "none"
+	}
+
+	s := make([]string, len(c.Conflicts))
+	for i, n := range c.Conflicts {
+		s[i] = fmt.Sprintf("%s (%s): %s", n.NS, strings.Join(n.Reasons, ", "), n.Decision)
+	}
+	rv := strings.Join(s, "; ")
+	if c.Override != "" {
+		rv += ", confirmed by " + c.Override
+	}
+	return rv
+}
+
 type ReplayCmd struct {
 	Name  string              `bson:"name"`
 	Start primitive.Timestamp `bson:"start,omitempty"`
 	End   primitive.Timestamp `bson:"end,omitempty"`
 	RSMap map[string]string   `bson:"rsMap,omitempty"`
+
+	// Source is a local directory (`file://` or absolute path) on agents'
+	// hosts or a path within the storage with oplog chunk files.
+	// If empty, chunks from PBM metadata are used.
+	Source    string `bson:"source,omitempty"`
+	AllowGaps bool   `bson:"allowGaps,omitempty"`
 }
 
 func (c ReplayCmd) String() string {
-	return fmt.Sprintf("name: %s, time: %d - %d", c.Name, c.Start, c.End)
+	s := fmt.Sprintf("name: %s, time: %d - %d", c.Name, c.Start, c.End)
+	if c.Source != "" {
+		s += ", source: " + c.Source
+	}
+	return s
 }
 
 type DeleteBackupCmd struct {
 	Backup    string          `bson:"backup"`
 	OlderThan int64           `bson:"olderthan"`
 	Type      defs.BackupType `bson:"type"`
+	// OverrideHold deletes the Backup even if it's on hold
+	OverrideHold bool `bson:"overrideHold,omitempty"`
 }
 
+// DeletePITRCmd deletes chunks older than OlderThan. If OlderThan is not
+// set, chunks overlapping [From, To] are deleted instead. RS limits
+// deletion to the replset chunks.
 type DeletePITRCmd struct {
-	OlderThan int64 `bson:"olderthan"`
+	OlderThan int64               `bson:"olderthan"`
+	RS        string              `bson:"rs,omitempty"`
+	Mode      DeletePITRMode      `bson:"mode,omitempty"`
+	From      primitive.Timestamp `bson:"from,omitempty"`
+	To        primitive.Timestamp `bson:"to,omitempty"`
 }
 
+// DeletePITRMode is how the chunks to delete are selected
+type DeletePITRMode string
+
+const (
+	// DeletePITROlderThan deletes the chunks older than OlderThan.
+	// It is the mode of the commands without one.
+	DeletePITROlderThan DeletePITRMode = "olderThan"
+	// DeletePITRRange deletes the chunks within From - To
+	DeletePITRRange DeletePITRMode = "range"
+)
+
+// IsRange returns true if the chunks within From - To are deleted
+func (d *DeletePITRCmd) IsRange() bool {
+	return d.Mode == DeletePITRRange
+}
+
+// CleanupCmd deletes backups and chunks older than OlderThan.
+// If Orphaned is set, orphaned storage files and dangling metadata
+// are cleaned up instead. Files newer than GraceSec are never touched.
 type CleanupCmd struct {
 	OlderThan primitive.Timestamp `bson:"olderThan"`
+	Orphaned  bool                `bson:"orphaned,omitempty"`
+	GraceSec  int64               `bson:"graceSec,omitempty"`
+}
+
+// PITRCompactCmd merges contiguous chunks ended before OlderThan.
+// MaxSize (bytes) and MaxSpanSec limit the merged chunk. 0 means no limit.
+type PITRCompactCmd struct {
+	OlderThan  primitive.Timestamp `bson:"olderThan"`
+	MaxSize    int64               `bson:"maxSize,omitempty"`
+	MaxSpanSec int64               `bson:"maxSpanSec,omitempty"`
+}
+
+// RebalanceCmd distributes chunks of the largest sharded collections
+// of the Restore over the shards until the data skew of each collection
+// is within Skew percent. Collections is the number of collections.
+type RebalanceCmd struct {
+	Restore     string  `bson:"restore"`
+	Skew        float64 `bson:"skew"`
+	Collections int     `bson:"collections"`
+}
+
+// CancelRestoreCmd makes the agents clean up after the aborted Restore:
+// drop the temporary collections of the restore on replsets primaries and
+// restart the balancer if the restore has stopped it.
+type CancelRestoreCmd struct {
+	Restore string `bson:"restore"`
+}
+
+// VerifyRestoreCmd makes an agent restore the backup into a temporary
+// mongod and check the restored data against the backup metadata.
+// Only SampleNS random collections are restored if set.
+type VerifyRestoreCmd struct {
+	Backup   string `bson:"backup"`
+	SampleNS int    `bson:"sampleNS,omitempty"`
+}
+
+// PITRVerifyCmd makes an agent check integrity of the PITR chunks
+// in the range. The range ends at the last chunk if To isn't set, and
+// starts at the last verified chunk of the replset if From isn't set.
+type PITRVerifyCmd struct {
+	From primitive.Timestamp `bson:"from,omitempty"`
+	To   primitive.Timestamp `bson:"to,omitempty"`
+}
+
+// MigrateCmd moves backups and PITR chunks from the From storage
+// to the To one. Both are config profile names, empty for the main storage.
+// Only the Backup is moved if set.
+type MigrateCmd struct {
+	From         string `bson:"from"`
+	To           string `bson:"to"`
+	Backup       string `bson:"backup,omitempty"`
+	DeleteSource bool   `bson:"deleteSource,omitempty"`
 }
 
 func (d DeleteBackupCmd) String() string {
@@ -0,0 +1,78 @@
+package ctrl
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/version"
+)
+
+// Initiator is the client which has sent the command.
+// It's captured by the client at the submit time.
+//
+//nolint:lll
+type Initiator struct {
+	// User is the authenticated MongoDB user (as `user@db`)
+	User string `bson:"user,omitempty" json:"user,omitempty" yaml:"user,omitempty"`
+	// OSUser is the OS user of the client process
+	OSUser string `bson:"osUser,omitempty" json:"osUser,omitempty" yaml:"osUser,omitempty"`
+	// Host is the hostname of the client
+	Host string `bson:"host,omitempty" json:"host,omitempty" yaml:"host,omitempty"`
+	// App is the client program (e.g. `pbm` or `pbm-agent` for scheduled backups)
+	App     string `bson:"app,omitempty" json:"app,omitempty" yaml:"app,omitempty"`
+	Version string `bson:"version,omitempty" json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+func (i *Initiator) String() string {
+	if i == nil {
+		return "unknown"
+	}
+
+	who := i.User
+	if who == "" {
+		who = "<no auth>"
+	}
+
+	var from []string
+	if i.OSUser != "" || i.Host != "" {
+		from = append(from, i.OSUser+"@"+i.Host)
+	}
+	if i.App != "" {
+		from = append(from, strings.TrimSpace(i.App+" "+i.Version))
+	}
+	if len(from) == 0 {
+		return who
+	}
+	return who + " (" + strings.Join(from, ", ") + ")"
+}
+
+// NewInitiator returns the identity of this process as the client of m.
+// Parts which can't be read are left empty: the identity shouldn't
+// fail the command.
+func NewInitiator(ctx context.Context, m connect.Client) *Initiator {
+	rv := &Initiator{
+		App:     filepath.Base(os.Args[0]),
+		Version: version.Current().Version,
+	}
+
+	if auth, err := topo.CurrentUser(ctx, m.MongoClient()); err == nil {
+		users := make([]string, len(auth.Users))
+		for i, u := range auth.Users {
+			users[i] = u.User + "@" + u.DB
+		}
+		rv.User = strings.Join(users, ",")
+	}
+	if u, err := user.Current(); err == nil {
+		rv.OSUser = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		rv.Host = h
+	}
+
+	return rv
+}
@@ -31,6 +31,18 @@ func (c CursorClosedError) Unwrap() error {
 	return c.Err
 }
 
+const (
+	// cmdReadTimeout is the time limit of reading the new commands. The
+	// read of a dead connection fails after it instead of blocking.
+	cmdReadTimeout = 30 * time.Second
+	// cmdRetryMax is the max pause between the failed reads
+	cmdRetryMax = 30 * time.Second
+)
+
+// ListenCmd polls the cmd stream for the new commands. The read errors
+// are sent to the errors chan and the read is retried with the growing
+// pause from the same point, so the commands issued while the connection
+// is broken aren't lost. The dead cursor is reported by CursorClosedError.
 func ListenCmd(ctx context.Context, m connect.Client, cl <-chan struct{}) (<-chan Cmd, <-chan error) {
 	cmd := make(chan Cmd)
 	errc := make(chan error)
@@ -42,6 +54,7 @@ func ListenCmd(ctx context.Context, m connect.Client, cl <-chan struct{}) (<-cha
 		ts := time.Now().UTC().Unix()
 		var lastTS int64
 		var lastCmd Command
+		pause := time.Second
 		for {
 			select {
 			case <-ctx.Done():
@@ -51,49 +64,78 @@ func ListenCmd(ctx context.Context, m connect.Client, cl <-chan struct{}) (<-cha
 				return
 			default:
 			}
-			cur, err := m.CmdStreamCollection().Find(
-				ctx,
-				bson.M{"ts": bson.M{"$gte": ts}},
-			)
+
+			cmds, skipped, err := readCmds(ctx, m, ts)
+			for _, err := range skipped {
+				errc <- err
+			}
 			if err != nil {
-				errc <- errors.Wrap(err, "watch the cmd stream")
+				errc <- err
+				select {
+				case <-ctx.Done():
+				case <-cl:
+				case <-time.After(pause):
+				}
+				pause = min(pause*2, cmdRetryMax)
 				continue
 			}
+			pause = time.Second
 
-			for cur.Next(ctx) {
-				c := Cmd{}
-				err := cur.Decode(&c)
-				if err != nil {
-					errc <- errors.Wrap(err, "message decode")
-					continue
-				}
-
+			for _, c := range cmds {
 				if c.Cmd == lastCmd && c.TS == lastTS {
 					continue
 				}
 
-				opid, ok := cur.Current.Lookup("_id").ObjectIDOK()
-				if !ok {
-					errc <- errors.New("unable to get operation ID")
-					continue
-				}
-
-				c.OPID = OPID(opid)
-
 				lastCmd = c.Cmd
 				lastTS = c.TS
 				cmd <- c
 				ts = time.Now().UTC().Unix()
 			}
-			if err := cur.Err(); err != nil {
-				errc <- CursorClosedError{err}
-				cur.Close(ctx)
-				return
-			}
-			cur.Close(ctx)
 			time.Sleep(time.Second * 1)
 		}
 	}()
 
 	return cmd, errc
 }
+
+// readCmds reads the commands issued since ts. The undecodable ones are
+// skipped and their errors are returned as well.
+func readCmds(ctx context.Context, m connect.Client, ts int64) ([]Cmd, []error, error) {
+	ctx, cancel := context.WithTimeout(ctx, cmdReadTimeout)
+	defer cancel()
+
+	cur, err := m.CmdStreamCollection().Find(
+		ctx,
+		bson.M{"ts": bson.M{"$gte": ts}},
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "watch the cmd stream")
+	}
+	defer cur.Close(context.Background())
+
+	var cmds []Cmd
+	var skipped []error
+	for cur.Next(ctx) {
+		c := Cmd{}
+		err := cur.Decode(&c)
+		if err != nil {
+			skipped = append(skipped, errors.Wrap(err, "message decode"))
+			continue
+		}
+
+		opid, ok := cur.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			skipped = append(skipped, errors.New("unable to get operation ID"))
+			continue
+		}
+
+		c.OPID = OPID(opid)
+		c.propagateInitiator()
+		cmds = append(cmds, c)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, skipped, CursorClosedError{err}
+	}
+
+	return cmds, skipped, nil
+}
@@ -12,6 +12,25 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 )
 
+func SendBackup(ctx context.Context, m connect.Client, b BackupCmd) (OPID, error) {
+	cmd := Cmd{
+		Cmd:    CmdBackup,
+		Backup: &b,
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
+// SendBackupAs sends the backup on behalf of the initiator
+// (e.g. of the queued backup). Nil is the sender itself.
+func SendBackupAs(ctx context.Context, m connect.Client, b BackupCmd, init *Initiator) (OPID, error) {
+	cmd := Cmd{
+		Cmd:       CmdBackup,
+		Backup:    &b,
+		Initiator: init,
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
 func SendDeleteBackupByName(ctx context.Context, m connect.Client, name string) (OPID, error) {
 	cmd := Cmd{
 		Cmd: CmdDeleteBackup,
@@ -22,6 +41,14 @@ func SendDeleteBackupByName(ctx context.Context, m connect.Client, name string)
 	return sendCommand(ctx, m, cmd)
 }
 
+func SendDeleteBackup(ctx context.Context, m connect.Client, d DeleteBackupCmd) (OPID, error) {
+	cmd := Cmd{
+		Cmd:    CmdDeleteBackup,
+		Delete: &d,
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
 func SendDeleteBackupBefore(
 	ctx context.Context,
 	m connect.Client,
@@ -52,6 +79,14 @@ func SendDeleteOplogRangeBefore(
 	return sendCommand(ctx, m, cmd)
 }
 
+func SendDeletePITR(ctx context.Context, m connect.Client, d DeletePITRCmd) (OPID, error) {
+	cmd := Cmd{
+		Cmd:        CmdDeletePITR,
+		DeletePITR: &d,
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
 func SendCleanup(
 	ctx context.Context,
 	m connect.Client,
@@ -66,6 +101,78 @@ func SendCleanup(
 	return sendCommand(ctx, m, cmd)
 }
 
+func SendCleanupOrphans(
+	ctx context.Context,
+	m connect.Client,
+	grace time.Duration,
+) (OPID, error) {
+	cmd := Cmd{
+		Cmd: CmdCleanup,
+		Cleanup: &CleanupCmd{
+			Orphaned: true,
+			GraceSec: int64(grace.Seconds()),
+		},
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
+func SendPITRCompact(
+	ctx context.Context,
+	m connect.Client,
+	before primitive.Timestamp,
+	maxSize int64,
+	maxSpan time.Duration,
+) (OPID, error) {
+	cmd := Cmd{
+		Cmd: CmdPITRCompact,
+		PITRCompact: &PITRCompactCmd{
+			OlderThan:  before,
+			MaxSize:    maxSize,
+			MaxSpanSec: int64(maxSpan.Seconds()),
+		},
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
+func SendRebalance(ctx context.Context, m connect.Client, cmd RebalanceCmd) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{
+		Cmd:       CmdRebalance,
+		Rebalance: &cmd,
+	})
+}
+
+func SendStorageMigrate(ctx context.Context, m connect.Client, cmd MigrateCmd) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{
+		Cmd:     CmdStorageMigrate,
+		Migrate: &cmd,
+	})
+}
+
+func SendVerifyRestore(ctx context.Context, m connect.Client, cmd VerifyRestoreCmd) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{
+		Cmd:           CmdVerifyRestore,
+		VerifyRestore: &cmd,
+	})
+}
+
+func SendPITRVerify(ctx context.Context, m connect.Client, cmd PITRVerifyCmd) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{
+		Cmd:        CmdPITRVerify,
+		PITRVerify: &cmd,
+	})
+}
+
+func SendCancelRestore(ctx context.Context, m connect.Client, cmd CancelRestoreCmd) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{
+		Cmd:           CmdCancelRestore,
+		CancelRestore: &cmd,
+	})
+}
+
+func SendCancelRebalance(ctx context.Context, m connect.Client) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{Cmd: CmdCancelRebalance})
+}
+
 func SendAddConfigProfile(
 	ctx context.Context,
 	m connect.Client,
@@ -97,6 +204,16 @@ func SendResync(ctx context.Context, m connect.Client) (OPID, error) {
 	return sendCommand(ctx, m, Cmd{Cmd: CmdResync})
 }
 
+// SendClaimStorage resyncs the storage of the profile (the main one if
+// the name is empty) taking it over from the cluster which owns it.
+func SendClaimStorage(ctx context.Context, m connect.Client, name string) (OPID, error) {
+	cmd := Cmd{
+		Cmd:    CmdResync,
+		Resync: &ResyncCmd{Name: name, ForceClaim: true},
+	}
+	return sendCommand(ctx, m, cmd)
+}
+
 func SendSyncMetaFrom(ctx context.Context, m connect.Client, name string) (OPID, error) {
 	opts := &ResyncCmd{}
 	if name != "" {
@@ -131,8 +248,17 @@ func SendCancelBackup(ctx context.Context, m connect.Client) (OPID, error) {
 	return sendCommand(ctx, m, Cmd{Cmd: CmdCancelBackup})
 }
 
+// SendDumpState asks all agents to dump their internal state
+// to the log and to a file in the temp dir.
+func SendDumpState(ctx context.Context, m connect.Client) (OPID, error) {
+	return sendCommand(ctx, m, Cmd{Cmd: CmdDumpState})
+}
+
 func sendCommand(ctx context.Context, m connect.Client, cmd Cmd) (OPID, error) {
 	cmd.TS = time.Now().UTC().Unix()
+	if cmd.Initiator == nil {
+		cmd.Initiator = NewInitiator(ctx, m)
+	}
 	res, err := m.CmdStreamCollection().InsertOne(ctx, cmd)
 	if err != nil {
 		return NilOPID, err
@@ -13,6 +13,8 @@ const (
 	LogCollection = "pbmLog"
 	// ConfigCollection is the name of the mongo collection that contains PBM configs
 	ConfigCollection = "pbmConfig"
+	// ConfigHistoryCollection contains history of the config changes
+	ConfigHistoryCollection = "pbmConfigHistory"
 	// LockCollection is the name of the mongo collection that is used
 	// by agents to coordinate mutually exclusive operations (e.g. backup/restore)
 	LockCollection = "pbmLock"
@@ -30,6 +32,16 @@ const (
 	PITRChunksCollection = "pbmPITRChunks"
 	// pbmPITR is a collection for PITR operational data
 	PITRCollection = "pbmPITR"
+	// PITRGapsCollection contains history of detected gaps in PITR oplog slicing
+	PITRGapsCollection = "pbmPITRGaps"
+	// PITRVerifyCollection contains watermarks of verified PITR chunks
+	PITRVerifyCollection = "pbmPITRVerify"
+	// ScheduleRunsCollection contains history of scheduled backup runs
+	ScheduleRunsCollection = "pbmScheduleRuns"
+	// StorageDriftCollection contains the last result of the storage metadata reconciliation
+	StorageDriftCollection = "pbmStorageDrift"
+	// QueueCollection contains the commands deferred till the running operation is done
+	QueueCollection = "pbmQueue"
 	// PBMOpLogCollection contains log of acquired locks (hence run ops)
 	PBMOpLogCollection = "pbmOpLog"
 	// AgentsStatusCollection is an agents registry with its status/health checks
@@ -52,8 +64,61 @@ const (
 var (
 	WaitActionStart = time.Second * 15
 	WaitBackupStart = WaitActionStart + PITRcheckRange*12/10 // 33 seconds
+
+	// DefaultQuiesceTimeout is a time limit for the node quiescing before
+	// the physical backup. Should fit into WaitBackupStart.
+	DefaultQuiesceTimeout = time.Second * 20
+
+	// DefaultHookTimeout is a time limit for the hook command.
+	// Should fit into WaitBackupStart for pre-backup hooks.
+	DefaultHookTimeout = time.Second * 20
+
+	// DefaultOplogRetryWindow is how long the backup tries to resume
+	// the oplog tailing after the node failover (stepdown, restart).
+	DefaultOplogRetryWindow = time.Minute
 )
 
+// DefaultConsistencyCountTolerance is the percent of the documents of
+// a collection that may be missing in the dump of a sharded backup
+// before it is reported by the consistency check.
+const DefaultConsistencyCountTolerance = 10.0
+
+// DefaultRestorePrefetchMb is how much of the files read at once the
+// restore reads ahead from the storage.
+const DefaultRestorePrefetchMb = 8
+
+// DefaultRestoreMaxFailureDetails is how many failed writes of the logical
+// restore are saved with the details in the restore metadata.
+const DefaultRestoreMaxFailureDetails = 100
+
+// DefaultMaxClockSkewSec is the offset (in seconds) of the agent clock from
+// the cluster time it's considered skewed beyond. The cluster time of an
+// idle cluster lags behind up to the no-op writer interval (10s).
+const DefaultMaxClockSkewSec = 30
+
+// DefaultQueueTTLMin is how long (in minutes) a queued command waits
+// for the running operation before it expires.
+const DefaultQueueTTLMin = 60
+
+// DefaultVerifyMaxDiskMb is the max size of the dbpath of the temporary
+// mongod the restore verification runs.
+const DefaultVerifyMaxDiskMb = 10 << 10
+
+// DefaultVerifyMaxDuration is how long the restore verification may run.
+const DefaultVerifyMaxDuration = time.Hour
+
+// DefaultOplogWindowMargin is the percent of the estimated backup duration
+// the oplog window has to exceed it by.
+const DefaultOplogWindowMargin = 50.0
+
+// DefaultPITRHostUploads is the number of PITR chunks the agents of
+// a host upload at once (see `pitr.hostUploads`).
+const DefaultPITRHostUploads = 2
+
+// DefaultPITRSpanJitter is the percent of the span the PITR chunk
+// boundaries are randomly moved by.
+const DefaultPITRSpanJitter = 10.0
+
 type NodeHealth int
 
 const (
@@ -106,6 +171,12 @@ const (
 	StatusDone       Status = "done"
 	StatusCancelled  Status = "canceled"
 	StatusError      Status = "error"
+	// StatusAborted is the final status of a restore stopped by
+	// `pbm cancel-restore` or after its agents were lost
+	StatusAborted Status = "aborted"
+	// StatusOrphaned is the status of a done backup which metadata file
+	// isn't on the storage it belongs to after the storage is changed
+	StatusOrphaned Status = "orphaned"
 
 	// status to communicate last op timestamp if it's not set
 	// during external restore
@@ -117,7 +188,9 @@ func (s Status) IsRunning() bool {
 	case
 		StatusDone,
 		StatusCancelled,
-		StatusError:
+		StatusError,
+		StatusAborted,
+		StatusOrphaned:
 		return false
 	}
 
@@ -146,13 +219,28 @@ const (
 
 	StorInitFile    = ".pbm.init"
 	PhysRestoresDir = ".pbm.restore"
+	// StorOwnerFile is the marker of the cluster which uses the storage
+	StorOwnerFile = ".pbm.cluster"
 )
 
 const (
 	// DefaultPITRInterval oplog slicing time span
 	DefaultPITRInterval = time.Minute * 10
+	// MinPITRInterval is the lowest allowed per-replset oplog slicing time span
+	MinPITRInterval = time.Second * 30
 	// PITRfsPrefix is a prefix (folder) for PITR chunks on the storage
 	PITRfsPrefix = "pbmPitr"
 )
 
 const DefaultCompression = compress.CompressionTypeS2
+
+// DefaultCompressionAutoUploadRateMb is the upload rate (MB/s) the auto
+// compression expects from the storage.
+const DefaultCompressionAutoUploadRateMb = 100
+
+// CompressionAutoBudget is the CPU time of the auto compression benchmark.
+const CompressionAutoBudget = 2 * time.Second
+
+// CompressionAutoSampleMb is the size of the data the auto compression
+// benchmark runs on.
+const CompressionAutoSampleMb = 8
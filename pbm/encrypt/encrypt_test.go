@@ -0,0 +1,260 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encryptData(t *testing.T, data []byte, id string, key []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write by odd pieces to cross the chunks boundaries
+	for p := data; len(p) > 0; {
+		n := min(len(p), 7777)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decryptData(data []byte, key []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), func(string) ([]byte, error) { return key, nil })
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := newKey(t)
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+
+		enc := encryptData(t, data, "k1", key)
+		if !IsEncrypted(enc) {
+			t.Errorf("%d: no header", size)
+		}
+		if want := EncryptedSize(int64(size), "k1"); int64(len(enc)) != want {
+			t.Errorf("%d: encrypted size %d, expected %d", size, len(enc), want)
+		}
+		if size > 16 && bytes.Contains(enc, data[:16]) {
+			t.Errorf("%d: plain text in the encrypted data", size)
+		}
+
+		got, err := decryptData(enc, key)
+		if err != nil {
+			t.Errorf("%d: decrypt: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d: decrypted data differs", size)
+		}
+	}
+}
+
+func TestTamperDetection(t *testing.T) {
+	key := newKey(t)
+	data := make([]byte, 2*ChunkSize+100)
+	_, _ = rand.Read(data)
+	enc := encryptData(t, data, "k1", key)
+
+	hdr := int(headerSize("k1"))
+	sealed := ChunkSize + tagSize
+	modify := func(fn func(b []byte) []byte) []byte {
+		return fn(bytes.Clone(enc))
+	}
+
+	cases := map[string][]byte{
+		"key id":             modify(func(b []byte) []byte { b[len(magic)+2] = 'x'; return b }),
+		"wrapped key":        modify(func(b []byte) []byte { b[hdr-1] ^= 1; return b }),
+		"chunk data":         modify(func(b []byte) []byte { b[hdr+sealed+10] ^= 1; return b }),
+		"last chunk":         modify(func(b []byte) []byte { b[len(b)-1] ^= 1; return b }),
+		"truncated":          modify(func(b []byte) []byte { return b[:hdr+2*sealed] }),
+		"truncated in chunk": modify(func(b []byte) []byte { return b[:hdr+sealed+100] }),
+		"appended":           modify(func(b []byte) []byte { return append(b, make([]byte, tagSize)...) }),
+		"no chunks":          modify(func(b []byte) []byte { return b[:hdr] }),
+		"swapped chunks": modify(func(b []byte) []byte {
+			c1 := bytes.Clone(b[hdr : hdr+sealed])
+			copy(b[hdr:], b[hdr+sealed:hdr+2*sealed])
+			copy(b[hdr+sealed:], c1)
+			return b
+		}),
+	}
+	for name, b := range cases {
+		_, err := decryptData(b, key)
+		if !errors.Is(err, ErrAuth) {
+			t.Errorf("%s: expected ErrAuth, got %v", name, err)
+		}
+	}
+
+	if _, err := decryptData(enc, newKey(t)); !errors.Is(err, ErrAuth) {
+		t.Errorf("wrong key: expected ErrAuth, got %v", err)
+	}
+
+	// the same data and key give different output
+	if bytes.Equal(enc, encryptData(t, data, "k1", key)) {
+		t.Error("data key is reused")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	t.Setenv("PBM_TEST_KEY1", base64.StdEncoding.EncodeToString(k1))
+	keyFile := filepath.Join(t.TempDir(), "key2")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(k2)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	raw, err := fs.New(&fs.Config{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := bytes.Repeat([]byte("plain text of the collection document "), 5000)
+	if err := raw.Save("old/data", bytes.NewReader(plain), int64(len(plain))); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{KeyID: "k1", Keys: map[string]string{"k1": "${PBM_TEST_KEY1}"}}
+	stg := Storage(raw, NewKeyring(cfg))
+
+	save := func(stg storage.Storage, name string) {
+		t.Helper()
+		_, err := storage.Upload(context.Background(), &source{plain}, stg, compress.CompressionTypeGZIP, nil, name, -1)
+		if err != nil {
+			t.Fatalf("upload %s: %v", name, err)
+		}
+	}
+	read := func(stg storage.Storage, name string, cmp compress.CompressionType) ([]byte, error) {
+		rc, err := stg.SourceReader(name)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		r, err := compress.Decompress(rc, cmp, nil)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	save(stg, "b1/rs0/data.gz")
+	onDisk, err := os.ReadFile(filepath.Join(dir, "b1/rs0/data.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(onDisk) {
+		t.Fatal("file is not encrypted")
+	}
+	if got, err := read(stg, "b1/rs0/data.gz", compress.CompressionTypeGZIP); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("read encrypted: %v", err)
+	}
+	if got, err := read(stg, "old/data", compress.CompressionTypeNone); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("read plain: %v", err)
+	}
+
+	meta := "b1" + defs.MetadataFileSuffix
+	if err := stg.Save(meta, strings.NewReader(`{"name":"b1"}`), -1); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, meta)); string(b) != `{"name":"b1"}` {
+		t.Errorf("metadata is not plain text: %q", b)
+	}
+
+	// rotation: new files use k2, old ones are still readable
+	cfg = &Config{KeyID: "k2", Keys: map[string]string{"k1": "${PBM_TEST_KEY1}", "k2": "file://" + keyFile}}
+	stg = Storage(raw, NewKeyring(cfg))
+	save(stg, "b2/rs0/data.gz")
+	for _, name := range []string{"b1/rs0/data.gz", "b2/rs0/data.gz"} {
+		if got, err := read(stg, name, compress.CompressionTypeGZIP); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("read %s after rotation: %v", name, err)
+		}
+	}
+
+	// k1 is dropped
+	cfg = &Config{KeyID: "k2", Keys: map[string]string{"k2": "file://" + keyFile}}
+	stg = Storage(raw, NewKeyring(cfg))
+	if _, err := read(stg, "b1/rs0/data.gz", compress.CompressionTypeGZIP); !errors.Is(err, ErrNoKey) ||
+		!strings.Contains(err.Error(), `"k1"`) {
+		t.Errorf("expected ErrNoKey for k1, got %v", err)
+	}
+
+	// k2 is unavailable for new files
+	cfg = &Config{KeyID: "k2", Keys: map[string]string{"k2": "${PBM_TEST_NO_KEY}"}}
+	if err := cfg.CheckKey("k2"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+	if err := Storage(raw, NewKeyring(cfg)).Save("b3/rs0/data", bytes.NewReader(plain), -1); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey on save, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"ok", Config{KeyID: "k1", Keys: map[string]string{"k1": "${KEY1}", "k0": "file:///etc/pbm/k0"}}, ""},
+		{"no key id", Config{Keys: map[string]string{"k1": "${KEY1}"}}, "keyID: required"},
+		{"unknown key id", Config{KeyID: "k2", Keys: map[string]string{"k1": "${KEY1}"}}, "keyID"},
+		{"literal key", Config{KeyID: "k1", Keys: map[string]string{"k1": "c2VjcmV0"}}, "keys.k1"},
+		{"bad ref", Config{KeyID: "k1", Keys: map[string]string{"k1": "file://key"}}, "keys.k1"},
+		{"bad id", Config{KeyID: "k 1", Keys: map[string]string{"k 1": "${KEY1}"}}, "invalid key id"},
+	}
+	for _, tc := range cases {
+		err := errors.Join(tc.cfg.Validate()...)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected %q error, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+type source struct{ data []byte }
+
+func (s *source) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.data)
+	return int64(n), err
+}
@@ -0,0 +1,216 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"regexp"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/secret"
+)
+
+// Config is the client-side encryption config of the storage.
+type Config struct {
+	// KeyID is the ID of the master key new files are encrypted with.
+	// If empty, the KMIP key is used.
+	KeyID string `bson:"keyID,omitempty" json:"keyID,omitempty" yaml:"keyID,omitempty"`
+	// Keys are the master keys by ID. A key is a reference (see package
+	// secret) to the base64 encoded 32 bytes key. Keys of the files written
+	// before the key rotation have to be kept to read the files.
+	Keys map[string]string `bson:"keys,omitempty" json:"keys,omitempty" yaml:"keys,omitempty"`
+	// KMIP is the server the keys not found in Keys are fetched from.
+	KMIP *KMIPConfig `bson:"kmip,omitempty" json:"kmip,omitempty" yaml:"kmip,omitempty"`
+}
+
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+
+	rv := *c
+	rv.Keys = maps.Clone(c.Keys)
+	rv.KMIP = c.KMIP.Clone()
+	return &rv
+}
+
+// CurrentKeyID returns the ID of the key new files are encrypted with.
+func (c *Config) CurrentKeyID() string {
+	if c.KeyID == "" && c.KMIP != nil {
+		return c.KMIP.KeyUID
+	}
+	return c.KeyID
+}
+
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Validate checks the config. It doesn't check that the keys are available.
+// Errors are prefixed with the field path within the config.
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.KeyID == "" {
+		if c.KMIP == nil {
+			errs = append(errs, errors.New("keyID: required"))
+		}
+	} else if _, ok := c.Keys[c.KeyID]; !ok {
+		errs = append(errs, errors.Errorf("keyID: key %q is not in keys", c.KeyID))
+	}
+
+	for id, ref := range c.Keys {
+		if !keyIDRe.MatchString(id) {
+			errs = append(errs, errors.Errorf("keys.%s: invalid key id, expected up to 64 of [A-Za-z0-9._-]", id))
+			continue
+		}
+		if !secret.IsRef(ref) {
+			errs = append(errs, errors.Errorf("keys.%s: expected a reference to the key "+
+				"(file:///path or ${ENV_VAR}), the key itself must not be stored in the config", id))
+			continue
+		}
+		if err := secret.Check(ref); err != nil {
+			errs = append(errs, errors.Wrapf(err, "keys.%s", id))
+		}
+	}
+
+	if c.KMIP != nil {
+		errs = append(errs, c.KMIP.Validate()...)
+	}
+
+	return errs
+}
+
+// ErrNoKey means the master key is not available.
+var ErrNoKey = errors.New("encryption key is not available")
+
+// KeyError is the error of getting the master key. It is ErrNoKey.
+type KeyError struct {
+	ID  string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("encryption key %q is not available: %v", e.ID, e.Err)
+}
+
+func (e *KeyError) Is(target error) bool {
+	return target == ErrNoKey //nolint:errorlint
+}
+
+// Keyring provides the master keys of the config. The keys are resolved
+// on the first use with the environment of the current process. Keys
+// which are not in the config keys are fetched from the KMIP server.
+// The keys are kept in memory only.
+type Keyring struct {
+	cfg  *Config
+	kmip *kmipClient
+	// fetch makes the concurrent reads of the same key wait for
+	// a single resolve, mu isn't held meanwhile
+	fetch singleflight.Group
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func NewKeyring(cfg *Config) *Keyring {
+	k := &Keyring{cfg: cfg.Clone(), keys: make(map[string][]byte)}
+	if k.cfg.KMIP != nil {
+		k.kmip = newKMIPClient(k.cfg.KMIP)
+	}
+	return k
+}
+
+// KeyID returns the ID of the key for new files.
+func (k *Keyring) KeyID() string {
+	return k.cfg.CurrentKeyID()
+}
+
+// Key returns the master key by its ID. The KMIP server (with retries)
+// is requested without holding the cached keys, so the other keys are
+// returned meanwhile.
+func (k *Keyring) Key(id string) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[id]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	v, err, _ := k.fetch.Do(id, func() (any, error) {
+		return k.resolve(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+func (k *Keyring) resolve(id string) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[id]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var err error
+	if ref, ok := k.cfg.Keys[id]; ok {
+		key, err = resolveKey(ref)
+	} else if k.kmip != nil {
+		key, err = k.kmip.getKey(context.Background(), id)
+	} else {
+		err = errors.New("not in storage.encryption.keys")
+	}
+	if err == nil && len(key) != keySize {
+		err = errors.Errorf("expected %d bytes key, got %d", keySize, len(key))
+	}
+	if err != nil {
+		return nil, &KeyError{ID: id, Err: err}
+	}
+
+	k.mu.Lock()
+	k.keys[id] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+func resolveKey(ref string) ([]byte, error) {
+	v, err := secret.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "expected base64 encoded key")
+	}
+
+	return key, nil
+}
+
+// Check returns an error if the KMIP server is not reachable or doesn't
+// provide the KMIP key. The cached keys aren't used.
+func (k *Keyring) Check(ctx context.Context) error {
+	if k.kmip == nil {
+		return nil
+	}
+
+	uid := k.cfg.KMIP.KeyUID
+	key, err := k.kmip.getKey(ctx, uid)
+	if err == nil && len(key) != keySize {
+		err = errors.Errorf("expected %d bytes key, got %d", keySize, len(key))
+	}
+	if err != nil {
+		return &KeyError{ID: uid, Err: err}
+	}
+
+	return nil
+}
+
+// CheckKey returns an error if the key of the config is not available
+// to the current process.
+func (c *Config) CheckKey(id string) error {
+	_, err := NewKeyring(c).Key(id)
+	return err
+}
@@ -0,0 +1,477 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// KMIPConfig is the KMIP server the master keys are fetched from.
+// The keys are identified by their KMIP unique identifier.
+type KMIPConfig struct {
+	// Endpoint is host:port of the server. The default port is 5696.
+	Endpoint string `bson:"endpoint" json:"endpoint" yaml:"endpoint"`
+	// ServerName is the name to verify the server certificate against.
+	// The endpoint host is used if empty.
+	ServerName string `bson:"serverName,omitempty" json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	// ClientCert and ClientKey are the paths to the PEM encoded client
+	// certificate and its private key.
+	ClientCert string `bson:"clientCert" json:"clientCert" yaml:"clientCert"`
+	ClientKey  string `bson:"clientKey" json:"clientKey" yaml:"clientKey"`
+	// CA is the path to the PEM encoded CA certificates of the server.
+	// The system pool is used if empty.
+	CA string `bson:"ca,omitempty" json:"ca,omitempty" yaml:"ca,omitempty"`
+	// KeyUID is the unique identifier of the key new files are encrypted
+	// with unless keyID is set.
+	KeyUID string `bson:"keyUID" json:"keyUID" yaml:"keyUID"`
+}
+
+const kmipDefaultPort = "5696"
+
+func (c *KMIPConfig) Clone() *KMIPConfig {
+	if c == nil {
+		return nil
+	}
+
+	rv := *c
+	return &rv
+}
+
+// Validate checks the config. It doesn't check that the files exist.
+func (c *KMIPConfig) Validate() []error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, errors.New("kmip.endpoint: required"))
+	}
+	if c.KeyUID == "" {
+		errs = append(errs, errors.New("kmip.keyUID: required"))
+	} else if len(c.KeyUID) > maxKeyIDLen {
+		errs = append(errs, errors.Errorf("kmip.keyUID: longer than %d bytes", maxKeyIDLen))
+	}
+
+	for _, f := range []struct {
+		name, path string
+		required   bool
+	}{
+		{"clientCert", c.ClientCert, true},
+		{"clientKey", c.ClientKey, true},
+		{"ca", c.CA, false},
+	} {
+		switch {
+		case f.path == "" && f.required:
+			errs = append(errs, errors.Errorf("kmip.%s: required", f.name))
+		case f.path != "" && !filepath.IsAbs(f.path):
+			errs = append(errs, errors.Errorf("kmip.%s: expected an absolute path", f.name))
+		}
+	}
+
+	return errs
+}
+
+func (c *KMIPConfig) addr() string {
+	if _, _, err := net.SplitHostPort(c.Endpoint); err == nil {
+		return c.Endpoint
+	}
+	return net.JoinHostPort(c.Endpoint, kmipDefaultPort)
+}
+
+func (c *KMIPConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "load client certificate")
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   c.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(c.addr())
+	}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA")
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in %s", c.CA)
+		}
+	}
+
+	return conf, nil
+}
+
+// kmipClient gets the keys from the KMIP server. Only the Get operation
+// of the raw symmetric keys is implemented.
+type kmipClient struct {
+	cfg *KMIPConfig
+
+	// attempts is the number of connection attempts. The wait between them
+	// starts from backoff and doubles.
+	attempts int
+	backoff  time.Duration
+	// timeout of each attempt
+	timeout time.Duration
+}
+
+func newKMIPClient(cfg *KMIPConfig) *kmipClient {
+	return &kmipClient{
+		cfg:      cfg,
+		attempts: 4,
+		backoff:  time.Second,
+		timeout:  10 * time.Second,
+	}
+}
+
+// kmipError is the error result of the operation. It isn't retried.
+type kmipError struct {
+	Status  uint32
+	Reason  uint32
+	Message string
+}
+
+func (e *kmipError) Error() string {
+	return fmt.Sprintf("kmip: result status %d, reason %#x: %s", e.Status, e.Reason, e.Message)
+}
+
+// getKey gets the key by its unique identifier. Connection failures are retried.
+func (c *kmipClient) getKey(ctx context.Context, uid string) ([]byte, error) {
+	conf, err := c.cfg.tlsConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "kmip")
+	}
+
+	wait := c.backoff
+	for i := 1; ; i++ {
+		var key []byte
+		key, err = c.get(ctx, conf, uid)
+		if err == nil {
+			return key, nil
+		}
+
+		var kerr *kmipError
+		if errors.As(err, &kerr) || i >= c.attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(err, "kmip")
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+
+	return nil, errors.Wrapf(err, "kmip %s", c.cfg.Endpoint)
+}
+
+func (c *kmipClient) get(ctx context.Context, conf *tls.Config, uid string) ([]byte, error) {
+	d := tls.Dialer{NetDialer: &net.Dialer{Timeout: c.timeout}, Config: conf}
+	conn, err := d.DialContext(ctx, "tcp", c.cfg.addr())
+	if err != nil {
+		return nil, errors.Wrap(err, "connect")
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, errors.Wrap(err, "set deadline")
+	}
+
+	b, err := kmipGetRequest(uid).MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "encode request")
+	}
+	_, err = conn.Write(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "send request")
+	}
+
+	resp, err := readTTLV(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response")
+	}
+
+	return parseGetResponse(resp)
+}
+
+// KMIP tags, types and enumerations used by the client.
+const (
+	tagBatchCount           uint32 = 0x42000D
+	tagBatchItem            uint32 = 0x42000F
+	tagKeyBlock             uint32 = 0x420040
+	tagKeyFormatType        uint32 = 0x420042
+	tagKeyMaterial          uint32 = 0x420043
+	tagKeyValue             uint32 = 0x420045
+	tagObjectType           uint32 = 0x420057
+	tagOperation            uint32 = 0x42005C
+	tagProtocolVersion      uint32 = 0x420069
+	tagProtocolVersionMajor uint32 = 0x42006A
+	tagProtocolVersionMinor uint32 = 0x42006B
+	tagRequestHeader        uint32 = 0x420077
+	tagRequestMessage       uint32 = 0x420078
+	tagRequestPayload       uint32 = 0x420079
+	tagResponseHeader       uint32 = 0x42007A
+	tagResponseMessage      uint32 = 0x42007B
+	tagResponsePayload      uint32 = 0x42007C
+	tagResultMessage        uint32 = 0x42007D
+	tagResultReason         uint32 = 0x42007E
+	tagResultStatus         uint32 = 0x42007F
+	tagSymmetricKey         uint32 = 0x42008F
+	tagUniqueIdentifier     uint32 = 0x420094
+
+	ttlvStructure   byte = 0x01
+	ttlvInteger     byte = 0x02
+	ttlvLongInteger byte = 0x03
+	ttlvEnumeration byte = 0x05
+	ttlvBoolean     byte = 0x06
+	ttlvTextString  byte = 0x07
+	ttlvByteString  byte = 0x08
+	ttlvDateTime    byte = 0x09
+
+	kmipOpGet           uint32 = 0x0A
+	kmipStatusSuccess   uint32 = 0x00
+	kmipObjSymmetricKey uint32 = 0x02
+	kmipKeyFormatRaw    uint32 = 0x01
+)
+
+// maxTTLVSize limits the size of the message read from the server.
+const maxTTLVSize = 1 << 20
+
+// ttlv is an item of the KMIP Tag-Type-Length-Value encoding.
+// Value is []ttlv for structures, int32 for integers, uint32 for
+// enumerations, int64 for long integers and date-time, bool, string
+// or []byte.
+type ttlv struct {
+	Tag   uint32
+	Type  byte
+	Value any
+}
+
+func ttlvStruct(tag uint32, items ...ttlv) ttlv {
+	return ttlv{tag, ttlvStructure, items}
+}
+
+func kmipGetRequest(uid string) ttlv {
+	return ttlvStruct(tagRequestMessage,
+		ttlvStruct(tagRequestHeader,
+			ttlvStruct(tagProtocolVersion,
+				ttlv{tagProtocolVersionMajor, ttlvInteger, int32(1)},
+				ttlv{tagProtocolVersionMinor, ttlvInteger, int32(4)}),
+			ttlv{tagBatchCount, ttlvInteger, int32(1)}),
+		ttlvStruct(tagBatchItem,
+			ttlv{tagOperation, ttlvEnumeration, kmipOpGet},
+			ttlvStruct(tagRequestPayload,
+				ttlv{tagUniqueIdentifier, ttlvTextString, uid},
+				ttlv{tagKeyFormatType, ttlvEnumeration, kmipKeyFormatRaw})))
+}
+
+func parseGetResponse(resp ttlv) ([]byte, error) {
+	if resp.Tag != tagResponseMessage {
+		return nil, &kmipError{Message: fmt.Sprintf("unexpected message %#x", resp.Tag)}
+	}
+	item := resp.child(tagBatchItem)
+	if item == nil {
+		return nil, &kmipError{Message: "no batch item in response"}
+	}
+
+	status, _ := item.child(tagResultStatus).enum()
+	if status != kmipStatusSuccess {
+		rv := &kmipError{Status: status}
+		rv.Reason, _ = item.child(tagResultReason).enum()
+		rv.Message, _ = item.child(tagResultMessage).text()
+		return nil, rv
+	}
+
+	payload := item.child(tagResponsePayload)
+	if typ, _ := payload.child(tagObjectType).enum(); typ != kmipObjSymmetricKey {
+		return nil, &kmipError{Message: fmt.Sprintf("object type %d is not a symmetric key", typ)}
+	}
+	block := payload.child(tagSymmetricKey).child(tagKeyBlock)
+	if f, _ := block.child(tagKeyFormatType).enum(); f != kmipKeyFormatRaw {
+		return nil, &kmipError{Message: fmt.Sprintf("key format %d is not raw", f)}
+	}
+	key, ok := block.child(tagKeyValue).child(tagKeyMaterial).Value.([]byte)
+	if !ok {
+		return nil, &kmipError{Message: "no key material in response"}
+	}
+
+	return key, nil
+}
+
+// child returns the first item of the structure with the tag or nil.
+func (t *ttlv) child(tag uint32) *ttlv {
+	if t == nil {
+		return nil
+	}
+	items, _ := t.Value.([]ttlv)
+	for i := range items {
+		if items[i].Tag == tag {
+			return &items[i]
+		}
+	}
+
+	return nil
+}
+
+func (t *ttlv) enum() (uint32, bool) {
+	if t == nil {
+		return 0, false
+	}
+	v, ok := t.Value.(uint32)
+	return v, ok
+}
+
+func (t *ttlv) text() (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	v, ok := t.Value.(string)
+	return v, ok
+}
+
+func (t ttlv) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.encode(&buf)
+	return buf.Bytes(), err
+}
+
+func (t ttlv) encode(buf *bytes.Buffer) error {
+	var val []byte
+	switch v := t.Value.(type) {
+	case []ttlv:
+		var b bytes.Buffer
+		for _, c := range v {
+			if err := c.encode(&b); err != nil {
+				return err
+			}
+		}
+		val = b.Bytes()
+	case int32:
+		val = binary.BigEndian.AppendUint32(nil, uint32(v))
+	case uint32:
+		val = binary.BigEndian.AppendUint32(nil, v)
+	case int64:
+		val = binary.BigEndian.AppendUint64(nil, uint64(v))
+	case bool:
+		var n uint64
+		if v {
+			n = 1
+		}
+		val = binary.BigEndian.AppendUint64(nil, n)
+	case string:
+		val = []byte(v)
+	case []byte:
+		val = v
+	default:
+		return errors.Errorf("ttlv %#x: unsupported value %T", t.Tag, t.Value)
+	}
+
+	buf.Write([]byte{byte(t.Tag >> 16), byte(t.Tag >> 8), byte(t.Tag), t.Type})
+	buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(val))))
+	buf.Write(val)
+	buf.Write(make([]byte, padded(len(val))-len(val)))
+	return nil
+}
+
+func padded(n int) int {
+	return (n + 7) / 8 * 8
+}
+
+// readTTLV reads one item (the message) from r.
+func readTTLV(r io.Reader) (ttlv, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return ttlv{}, err
+	}
+	l := binary.BigEndian.Uint32(hdr[4:])
+	if l > maxTTLVSize {
+		return ttlv{}, errors.Errorf("message of %d bytes is too big", l)
+	}
+
+	b := make([]byte, 8+padded(int(l)))
+	copy(b, hdr)
+	if _, err := io.ReadFull(r, b[8:]); err != nil {
+		return ttlv{}, err
+	}
+
+	t, _, err := decodeTTLV(b)
+	return t, err
+}
+
+// decodeTTLV decodes the first item of b and returns its encoded size.
+func decodeTTLV(b []byte) (ttlv, int, error) {
+	if len(b) < 8 {
+		return ttlv{}, 0, errors.New("ttlv: short header")
+	}
+	t := ttlv{
+		Tag:  uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]),
+		Type: b[3],
+	}
+	l := int(binary.BigEndian.Uint32(b[4:8]))
+	n := 8 + padded(l)
+	if l > maxTTLVSize || len(b) < n {
+		return ttlv{}, 0, errors.Errorf("ttlv %#x: short value", t.Tag)
+	}
+	val := b[8 : 8+l]
+
+	fixed := func(size int) error {
+		if l != size {
+			return errors.Errorf("ttlv %#x: invalid length %d of type %#x", t.Tag, l, t.Type)
+		}
+		return nil
+	}
+
+	switch t.Type {
+	case ttlvStructure:
+		items := []ttlv{}
+		for len(val) > 0 {
+			c, cn, err := decodeTTLV(val)
+			if err != nil {
+				return ttlv{}, 0, err
+			}
+			items = append(items, c)
+			val = val[cn:]
+		}
+		t.Value = items
+	case ttlvInteger, ttlvEnumeration:
+		if err := fixed(4); err != nil {
+			return ttlv{}, 0, err
+		}
+		v := binary.BigEndian.Uint32(val)
+		if t.Type == ttlvInteger {
+			t.Value = int32(v)
+		} else {
+			t.Value = v
+		}
+	case ttlvLongInteger, ttlvDateTime:
+		if err := fixed(8); err != nil {
+			return ttlv{}, 0, err
+		}
+		t.Value = int64(binary.BigEndian.Uint64(val))
+	case ttlvBoolean:
+		if err := fixed(8); err != nil {
+			return ttlv{}, 0, err
+		}
+		t.Value = binary.BigEndian.Uint64(val) != 0
+	case ttlvTextString:
+		t.Value = string(val)
+	default:
+		// byte strings and the types the client doesn't use (big integers, intervals)
+		t.Value = bytes.Clone(val)
+	}
+
+	return t, n, nil
+}
@@ -0,0 +1,440 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+const (
+	kmipStatusOpFailed uint32 = 0x01
+	kmipReasonNotFound uint32 = 0x01
+)
+
+// fakeKMIP is the KMIP server which serves the Get operation of the keys.
+type fakeKMIP struct {
+	ln   net.Listener
+	cfg  *KMIPConfig
+	keys map[string][]byte
+
+	// drop is the number of the next connections closed without a response
+	drop     atomic.Int32
+	requests atomic.Int32
+
+	wg sync.WaitGroup
+}
+
+func newFakeKMIP(t *testing.T, keys map[string][]byte) *fakeKMIP {
+	t.Helper()
+
+	dir := t.TempDir()
+	ca, caKey := newCert(t, nil, nil, "ca")
+	srv, srvKey := newCert(t, ca, caKey, "server")
+	cli, cliKey := newCert(t, ca, caKey, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{srv.Raw}, PrivateKey: srvKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeKMIP{
+		ln:   ln,
+		keys: keys,
+		cfg: &KMIPConfig{
+			Endpoint:   ln.Addr().String(),
+			ServerName: "server",
+			ClientCert: writePEM(t, dir, "client.pem", "CERTIFICATE", cli.Raw),
+			ClientKey:  writePEM(t, dir, "client.key", "EC PRIVATE KEY", marshalKey(t, cliKey)),
+			CA:         writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw),
+		},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.close)
+
+	return s
+}
+
+func (s *fakeKMIP) close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *fakeKMIP) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+func (s *fakeKMIP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if s.drop.Add(-1) >= 0 {
+		return
+	}
+	req, err := readTTLV(conn)
+	if err != nil {
+		return
+	}
+	s.requests.Add(1)
+
+	item := req.child(tagBatchItem)
+	op, _ := item.child(tagOperation).enum()
+	uid, _ := item.child(tagRequestPayload).child(tagUniqueIdentifier).text()
+
+	res := []ttlv{{tagOperation, ttlvEnumeration, op}}
+	key, ok := s.keys[uid]
+	switch {
+	case op != kmipOpGet:
+		res = append(res, kmipFailure(0x04, "operation not supported")...)
+	case !ok:
+		res = append(res, kmipFailure(kmipReasonNotFound, "item not found")...)
+	default:
+		res = append(res,
+			ttlv{tagResultStatus, ttlvEnumeration, kmipStatusSuccess},
+			ttlvStruct(tagResponsePayload,
+				ttlv{tagObjectType, ttlvEnumeration, kmipObjSymmetricKey},
+				ttlv{tagUniqueIdentifier, ttlvTextString, uid},
+				ttlvStruct(tagSymmetricKey,
+					ttlvStruct(tagKeyBlock,
+						ttlv{tagKeyFormatType, ttlvEnumeration, kmipKeyFormatRaw},
+						ttlvStruct(tagKeyValue,
+							ttlv{tagKeyMaterial, ttlvByteString, key})))))
+	}
+
+	b, _ := ttlvStruct(tagResponseMessage,
+		ttlvStruct(tagResponseHeader,
+			ttlv{tagBatchCount, ttlvInteger, int32(1)}),
+		ttlvStruct(tagBatchItem, res...)).MarshalBinary()
+	_, _ = conn.Write(b)
+}
+
+func kmipFailure(reason uint32, msg string) []ttlv {
+	return []ttlv{
+		{tagResultStatus, ttlvEnumeration, kmipStatusOpFailed},
+		{tagResultReason, ttlvEnumeration, reason},
+		{tagResultMessage, ttlvTextString, msg},
+	}
+}
+
+func newCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readAll(t *testing.T, stg storage.Storage, name string) []byte {
+	t.Helper()
+
+	rc, err := stg.SourceReader(name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return b
+}
+
+func writePEM(t *testing.T, dir, name, typ string, b []byte) string {
+	t.Helper()
+
+	p := filepath.Join(dir, name)
+	err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestTTLV(t *testing.T) {
+	// examples of the KMIP 1.4 specification, 9.1.2
+	for _, tc := range []struct {
+		item ttlv
+		enc  string
+	}{
+		{ttlv{0x420020, ttlvInteger, int32(8)}, "42002002000000040000000800000000"},
+		{ttlv{0x420020, ttlvLongInteger, int64(123456789000000000)}, "420020030000000801B69B4BA5749200"},
+		{ttlv{0x420020, ttlvEnumeration, uint32(255)}, "4200200500000004000000FF00000000"},
+		{ttlv{0x420020, ttlvBoolean, true}, "42002006000000080000000000000001"},
+		{ttlv{0x420020, ttlvTextString, "Hello World"}, "420020070000000B48656C6C6F20576F726C640000000000"},
+		{ttlv{0x420020, ttlvByteString, []byte{1, 2, 3}}, "42002008000000030102030000000000"},
+		{ttlvStruct(0x420020,
+			ttlv{0x420004, ttlvEnumeration, uint32(254)},
+			ttlv{0x420005, ttlvInteger, int32(255)}),
+			"42002001000000204200040500000004000000FE000000004200050200000004000000FF00000000"},
+	} {
+		b, err := tc.item.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.ToUpper(hex.EncodeToString(b)); got != tc.enc {
+			t.Errorf("encode %v: got %s, want %s", tc.item, got, tc.enc)
+		}
+
+		got, err := readTTLV(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("decode %s: %v", tc.enc, err)
+		}
+		b2, _ := got.MarshalBinary()
+		if !bytes.Equal(b, b2) {
+			t.Errorf("decode %s: got %v", tc.enc, got)
+		}
+	}
+
+	b, _ := ttlv{0x420020, ttlvTextString, "Hello World"}.MarshalBinary()
+	if _, err := readTTLV(bytes.NewReader(b[:20])); err == nil {
+		t.Error("expected error for truncated message")
+	}
+	b, _ = hex.DecodeString("42002002000000050000000800000000")
+	if _, _, err := decodeTTLV(b); err == nil {
+		t.Error("expected error for invalid integer length")
+	}
+}
+
+func TestKMIP(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	srv := newFakeKMIP(t, map[string][]byte{"uid-1": k1, "uid-2": k2, "short": k1[:16]})
+	cfg := &Config{KMIP: srv.cfg.Clone()}
+	cfg.KMIP.KeyUID = "uid-1"
+
+	newRing := func(cfg *Config) *Keyring {
+		k := NewKeyring(cfg)
+		k.kmip.backoff = time.Millisecond
+		return k
+	}
+
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("pbm"), 50000)
+
+	keys := newRing(cfg)
+	if keys.KeyID() != "uid-1" {
+		t.Errorf("unexpected key id %q", keys.KeyID())
+	}
+	if err := Storage(stg, keys).Save("a", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+	// the key is cached
+	if err := Storage(stg, keys).Save("a2", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.requests.Load(); n != 1 {
+		t.Errorf("expected the cached key, got %d requests", n)
+	}
+
+	// rotation: new files with uid-2, the old ones are read with uid-1
+	cfg2 := cfg.Clone()
+	cfg2.KMIP.KeyUID = "uid-2"
+	keys2 := newRing(cfg2)
+	if err := Storage(stg, keys2).Save("b", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if got := readAll(t, Storage(stg, keys2), name); !bytes.Equal(got, data) {
+			t.Errorf("%s: data mismatch", name)
+		}
+	}
+
+	// local keys take precedence and may coexist with KMIP keys
+	t.Setenv("PBM_TEST_KMIP_LOCAL", base64.StdEncoding.EncodeToString(k2))
+	cfg3 := cfg.Clone()
+	cfg3.KeyID = "local"
+	cfg3.Keys = map[string]string{"local": "${PBM_TEST_KMIP_LOCAL}"}
+	if err := Storage(stg, newRing(cfg3)).Save("c", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, Storage(stg, newRing(cfg3)), "a"); !bytes.Equal(got, data) {
+		t.Error("data mismatch")
+	}
+
+	// errors of the server aren't retried
+	srv.requests.Store(0)
+	_, err = newRing(cfg).Key("uid-404")
+	if !errors.Is(err, ErrNoKey) || !strings.Contains(err.Error(), "item not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if n := srv.requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+	if _, err := newRing(cfg).Key("short"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+
+	// dropped connections are retried
+	srv.drop.Store(2)
+	if _, err := newRing(cfg).Key("uid-1"); err != nil {
+		t.Errorf("unexpected error after retries: %v", err)
+	}
+	srv.drop.Store(10)
+	_, err = newRing(cfg).Key("uid-1")
+	if !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected error after retries, got %v", err)
+	}
+	srv.drop.Store(0)
+
+	// the cached keys are returned while another key is retried
+	slow := newRing(cfg)
+	if _, err := slow.Key("uid-1"); err != nil {
+		t.Fatal(err)
+	}
+	slow.kmip.backoff = 200 * time.Millisecond
+	srv.drop.Store(1)
+	retried := make(chan error, 1)
+	go func() {
+		_, err := slow.Key("uid-2")
+		retried <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := slow.Key("uid-1"); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("cached key: %v in %v", err, time.Since(start))
+	}
+	if err := <-retried; err != nil {
+		t.Errorf("unexpected error after retries: %v", err)
+	}
+
+	// the check bypasses the cache
+	if err := Check(context.Background(), Storage(stg, keys)); err != nil {
+		t.Errorf("unexpected check error: %v", err)
+	}
+	if err := Check(context.Background(), stg); err != nil {
+		t.Errorf("unexpected check error of plain storage: %v", err)
+	}
+	srv.close()
+	if err := Check(context.Background(), Storage(stg, keys)); err == nil {
+		t.Error("expected check error of stopped server")
+	}
+	if got := readAll(t, Storage(stg, keys), "a"); !bytes.Equal(got, data) {
+		t.Error("cached key: data mismatch")
+	}
+
+	// client certificate is required
+	cfg4 := cfg.Clone()
+	cfg4.KMIP.ClientCert = filepath.Join(t.TempDir(), "none.pem")
+	if _, err := newRing(cfg4).Key("uid-1"); err == nil || !strings.Contains(err.Error(), "client certificate") {
+		t.Errorf("expected certificate error, got %v", err)
+	}
+}
+
+func TestKMIPConfigValidate(t *testing.T) {
+	kmip := func(f func(c *KMIPConfig)) *Config {
+		c := &KMIPConfig{
+			Endpoint:   "kms.example.com",
+			ClientCert: "/etc/pbm/kmip.pem",
+			ClientKey:  "/etc/pbm/kmip.key",
+			KeyUID:     "1",
+		}
+		f(c)
+		return &Config{KMIP: c}
+	}
+
+	for _, tc := range []struct {
+		name string
+		cfg  *Config
+		err  string
+	}{
+		{"ok", kmip(func(*KMIPConfig) {}), ""},
+		{"ca", kmip(func(c *KMIPConfig) { c.CA = "/etc/pbm/ca.pem" }), ""},
+		{"relative ca", kmip(func(c *KMIPConfig) { c.CA = "ca.pem" }), "kmip.ca"},
+		{"endpoint", kmip(func(c *KMIPConfig) { c.Endpoint = "" }), "kmip.endpoint"},
+		{"key uid", kmip(func(c *KMIPConfig) { c.KeyUID = "" }), "kmip.keyUID"},
+		{"long uid", kmip(func(c *KMIPConfig) { c.KeyUID = strings.Repeat("u", 256) }), "kmip.keyUID"},
+		{"client key", kmip(func(c *KMIPConfig) { c.ClientKey = "" }), "kmip.clientKey"},
+	} {
+		errs := tc.cfg.Validate()
+		if tc.err == "" {
+			if len(errs) != 0 {
+				t.Errorf("%s: unexpected errors: %v", tc.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.err) {
+			t.Errorf("%s: expected %q error, got %v", tc.name, tc.err, errs)
+		}
+	}
+
+	if (&KMIPConfig{Endpoint: "kms"}).addr() != "kms:5696" {
+		t.Error("expected the default port")
+	}
+}
@@ -0,0 +1,197 @@
+package encrypt
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// Storage wraps the storage to encrypt saved files and decrypt the read ones.
+// The data is encrypted after the compression, as it comes to Save.
+//
+// PBM service files (the init file, backup and restore metadata) are kept
+// in plain text, so they can be inspected and resynced without the keys.
+// Files without the encryption header (e.g. written before the encryption
+// was enabled) are read as is.
+func Storage(stg storage.Storage, keys *Keyring) storage.Storage {
+	s := &encryptedStorage{stg: stg, keys: keys}
+	if ul, ok := stg.(storage.UploadsLister); ok {
+		return &encryptedUploadsStorage{encryptedStorage: s, ul: ul}
+	}
+	return s
+}
+
+// WrapSourceReader returns the reader function which decrypts the files
+// read by fn (e.g. the parallel S3 download of the unwrapped storage) if
+// the stg is encrypted. Otherwise, it returns fn.
+func WrapSourceReader(
+	stg storage.Storage,
+	fn func(name string) (io.ReadCloser, error),
+) func(name string) (io.ReadCloser, error) {
+	s := find(stg)
+	if s == nil {
+		return fn
+	}
+	return s.wrap(fn)
+}
+
+// Check returns an error if the KMIP server of the encrypted stg is not
+// reachable. It does nothing for not encrypted storages.
+func Check(ctx context.Context, stg storage.Storage) error {
+	s := find(stg)
+	if s == nil {
+		return nil
+	}
+	return s.keys.Check(ctx)
+}
+
+// find returns the encryption wrapper of stg or nil.
+func find(stg storage.Storage) *encryptedStorage {
+	switch s := storage.Find(stg, isEncrypted).(type) {
+	case *encryptedStorage:
+		return s
+	case *encryptedUploadsStorage:
+		return s.encryptedStorage
+	}
+	return nil
+}
+
+func isEncrypted(stg storage.Storage) bool {
+	switch stg.(type) {
+	case *encryptedStorage, *encryptedUploadsStorage:
+		return true
+	}
+	return false
+}
+
+func isPlain(name string) bool {
+	return name == defs.StorInitFile ||
+		name == defs.StorOwnerFile ||
+		strings.HasSuffix(name, defs.MetadataFileSuffix) ||
+		strings.HasPrefix(name, defs.PhysRestoresDir+"/")
+}
+
+type encryptedStorage struct {
+	stg  storage.Storage
+	keys *Keyring
+}
+
+func (s *encryptedStorage) Unwrap() storage.Storage {
+	return s.stg
+}
+
+func (s *encryptedStorage) Type() storage.Type {
+	return s.stg.Type()
+}
+
+func (s *encryptedStorage) Save(name string, data io.Reader, size int64) error {
+	if isPlain(name) {
+		return s.stg.Save(name, data, size)
+	}
+
+	id := s.keys.KeyID()
+	key, err := s.keys.Key(id)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	encDone := make(chan error, 1)
+	go func() {
+		w, err := NewWriter(pw, id, key)
+		if err == nil {
+			_, err = io.Copy(w, data)
+			if err == nil {
+				err = w.Close()
+			}
+		}
+		pw.CloseWithError(err)
+		encDone <- err
+	}()
+
+	err = s.stg.Save(name, pr, EncryptedSize(size, id))
+	if err != nil {
+		// the encryption stops on the next write
+		pr.CloseWithError(err)
+		return err
+	}
+
+	return errors.Wrap(<-encDone, "encrypt")
+}
+
+func (s *encryptedStorage) SourceReader(name string) (io.ReadCloser, error) {
+	if isPlain(name) {
+		return s.stg.SourceReader(name)
+	}
+
+	return s.wrap(s.stg.SourceReader)(name)
+}
+
+func (s *encryptedStorage) wrap(fn func(string) (io.ReadCloser, error)) func(string) (io.ReadCloser, error) {
+	return func(name string) (io.ReadCloser, error) {
+		rc, err := fn(name)
+		if err != nil {
+			return nil, err
+		}
+
+		br := bufio.NewReader(rc)
+		pre, err := br.Peek(len(magic))
+		if err != nil && !errors.Is(err, io.EOF) {
+			rc.Close()
+			return nil, errors.Wrapf(err, "read %s", name)
+		}
+		if !IsEncrypted(pre) {
+			return &readCloser{Reader: br, c: rc}, nil
+		}
+
+		r, err := NewReader(br, s.keys.Key)
+		if err != nil {
+			rc.Close()
+			return nil, errors.Wrapf(err, "decrypt %s", name)
+		}
+		return &readCloser{Reader: r, c: rc}, nil
+	}
+}
+
+func (s *encryptedStorage) FileStat(name string) (storage.FileInfo, error) {
+	return s.stg.FileStat(name)
+}
+
+func (s *encryptedStorage) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	return s.stg.List(prefix, suffix)
+}
+
+func (s *encryptedStorage) Delete(name string) error {
+	return s.stg.Delete(name)
+}
+
+func (s *encryptedStorage) Copy(src, dst string) error {
+	return s.stg.Copy(src, dst)
+}
+
+type encryptedUploadsStorage struct {
+	*encryptedStorage
+	ul storage.UploadsLister
+}
+
+func (s *encryptedUploadsStorage) ListUploads(prefix string) ([]storage.IncompleteUpload, error) {
+	return s.ul.ListUploads(prefix)
+}
+
+func (s *encryptedUploadsStorage) AbortUpload(u storage.IncompleteUpload) error {
+	return s.ul.AbortUpload(u)
+}
+
+type readCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (r *readCloser) Close() error {
+	return r.c.Close()
+}
@@ -0,0 +1,306 @@
+// Package encrypt implements the client-side encryption of storage files.
+//
+// A file is encrypted with its own random data key by AES-256-GCM in
+// chunks. The data key is wrapped (encrypted) with the master key and
+// stored in the file header along with the master key ID:
+//
+//	magic "PBMENC" | version | len(keyID) | keyID | wrapped data key | chunks...
+//
+// Each chunk is sealed with the header as additional data and the nonce
+// made of the chunk number and the last chunk flag. So modified, reordered,
+// removed or appended chunks, truncation and a modified header are detected.
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+const (
+	magic   = "PBMENC"
+	version = 1
+
+	keySize   = 32 // AES-256
+	nonceSize = 12
+	tagSize   = 16
+
+	wrappedKeySize = nonceSize + keySize + tagSize
+
+	maxKeyIDLen = 255
+
+	// ChunkSize is the size of the plain text chunk.
+	ChunkSize = 64 << 10
+)
+
+// ErrAuth means the encrypted data or its header doesn't pass
+// the authentication (it is corrupted or tampered with) or
+// the key is wrong.
+var ErrAuth = errors.New("message authentication failed: the file is corrupted or tampered with")
+
+// KeyFunc returns the master key by its ID.
+type KeyFunc func(id string) ([]byte, error)
+
+// IsEncrypted returns true if the data starts with the header
+// of the encrypted file.
+func IsEncrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(magic))
+}
+
+// EncryptedSize returns the size of the encrypted file for the plain
+// text size. A negative (unknown) size is returned as is.
+func EncryptedSize(size int64, keyID string) int64 {
+	if size < 0 {
+		return size
+	}
+
+	// the last chunk may be full but is always written
+	chunks := max((size+ChunkSize-1)/ChunkSize, 1)
+	return headerSize(keyID) + size + chunks*tagSize
+}
+
+func headerSize(keyID string) int64 {
+	return int64(len(magic) + 2 + len(keyID) + wrappedKeySize)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, errors.Errorf("invalid key size %d, expected %d", len(key), keySize)
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(b)
+}
+
+func chunkNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, n)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+type writer struct {
+	w    io.Writer
+	aead cipher.AEAD
+	ad   []byte
+	buf  []byte
+	out  []byte
+	n    uint64
+	err  error
+}
+
+// NewWriter returns the writer which encrypts the data with a new data key
+// wrapped by the master key and writes it to w. The data is written
+// in full only on Close.
+func NewWriter(w io.Writer, keyID string, masterKey []byte) (io.WriteCloser, error) {
+	if len(keyID) == 0 || len(keyID) > maxKeyIDLen {
+		return nil, errors.Errorf("invalid key id %q", keyID)
+	}
+
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "master key")
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "generate data key")
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "data key")
+	}
+
+	hdr := make([]byte, 0, headerSize(keyID))
+	hdr = append(hdr, magic...)
+	hdr = append(hdr, version, byte(len(keyID)))
+	hdr = append(hdr, keyID...)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+	hdr = append(hdr, nonce...)
+	hdr = master.Seal(hdr, nonce, dataKey, hdr[:len(hdr)-nonceSize])
+
+	if _, err := w.Write(hdr); err != nil {
+		return nil, errors.Wrap(err, "write header")
+	}
+
+	return &writer{
+		w:    w,
+		aead: aead,
+		ad:   hdr,
+		buf:  make([]byte, 0, ChunkSize),
+		out:  make([]byte, 0, ChunkSize+tagSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// the full chunk is sealed only when more data comes:
+		// the last chunk has to be sealed as the last one
+		if len(w.buf) == ChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *writer) seal(last bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.n, last), w.buf, w.ad)
+	w.n++
+	w.buf = w.buf[:0]
+
+	_, err := w.w.Write(w.out)
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	err := w.seal(true)
+	if err == nil {
+		w.err = errors.New("write to closed writer")
+	}
+	return err
+}
+
+type reader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	ad   []byte
+	buf  []byte
+	out  []byte
+	n    uint64
+	done bool
+	err  error
+}
+
+// NewReader returns the reader of the plain text of the encrypted data.
+// The master key is requested by the key ID from the header.
+// The data read is authenticated chunk by chunk, so an error
+// (e.g. ErrAuth) may be returned after some data was read.
+func NewReader(r io.Reader, keys KeyFunc) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, ChunkSize+tagSize)
+
+	pre := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(br, pre); err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	if !IsEncrypted(pre) {
+		return nil, errors.New("not encrypted")
+	}
+	if pre[len(magic)] != version {
+		return nil, errors.Errorf("unsupported encryption format version %d", pre[len(magic)])
+	}
+
+	hdr := make([]byte, len(pre)+int(pre[len(magic)+1])+wrappedKeySize)
+	copy(hdr, pre)
+	if _, err := io.ReadFull(br, hdr[len(pre):]); err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	keyID := string(hdr[len(pre) : len(hdr)-wrappedKeySize])
+
+	masterKey, err := keys(keyID)
+	if err != nil {
+		return nil, err
+	}
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "master key %q", keyID)
+	}
+
+	wrapped := hdr[len(hdr)-wrappedKeySize:]
+	dataKey, err := master.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], hdr[:len(hdr)-wrappedKeySize])
+	if err != nil {
+		return nil, errors.Wrapf(ErrAuth, "unwrap data key with master key %q", keyID)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "data key")
+	}
+
+	return &reader{
+		r:    br,
+		aead: aead,
+		ad:   hdr,
+		buf:  make([]byte, ChunkSize+tagSize),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		// the last chunk (at least the tag) is always written
+		return errors.Wrap(ErrAuth, "unexpected end of data")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.r.Peek(1); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			last = true
+		}
+	}
+
+	r.out, err = r.aead.Open(r.buf[:0], chunkNonce(r.n, last), r.buf[:n], r.ad)
+	if err != nil {
+		return errors.Wrapf(ErrAuth, "chunk %d", r.n)
+	}
+	r.n++
+	r.done = last
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package errors
+
+// Code is the stable kind of the failure for the automation around PBM
+// to branch on. Codes are additive: the message of the coded error is
+// the message of the error it wraps.
+type Code string
+
+const (
+	// CodeStorageUnreachable - the remote storage can't be accessed
+	CodeStorageUnreachable Code = "StorageUnreachable"
+	// CodeLockHeld - another operation holds the lock
+	CodeLockHeld Code = "LockHeld"
+	// CodeInsufficientPrivileges - the user lacks the required roles
+	CodeInsufficientPrivileges Code = "InsufficientPrivileges"
+	// CodeBackupNotFound - no backup with the given name
+	CodeBackupNotFound Code = "BackupNotFound"
+	// CodeRestoreNotFound - no restore with the given name
+	CodeRestoreNotFound Code = "RestoreNotFound"
+	// CodeInvalidArgument - the command or its options are wrong
+	CodeInvalidArgument Code = "InvalidArgument"
+)
+
+// coder is implemented by the errors of a known kind
+type coder interface {
+	ErrorCode() Code
+}
+
+type codedError struct {
+	err  error
+	code Code
+}
+
+func (e *codedError) Error() string   { return e.err.Error() }
+func (e *codedError) Unwrap() error   { return e.err }
+func (e *codedError) Cause() error    { return e.err }
+func (e *codedError) ErrorCode() Code { return e.code }
+
+// WithCode attaches the code to the error. The error is returned as is
+// if the code is "" and nil if err is nil.
+func WithCode(err error, code Code) error {
+	if err == nil || code == "" {
+		return err
+	}
+	return &codedError{err: err, code: code}
+}
+
+// CodeOf returns the code of the error: the outermost one attached by
+// WithCode (or reported by the error itself) in the chain.
+// It returns "" if the error has no code.
+func CodeOf(err error) Code {
+	var c coder
+	if As(err, &c) {
+		return c.ErrorCode()
+	}
+	return ""
+}
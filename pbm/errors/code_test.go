@@ -0,0 +1,67 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+)
+
+type lockErr struct{}
+
+func (lockErr) Error() string   { return "another operation is running" }
+func (lockErr) ErrorCode() Code { return CodeLockHeld }
+
+func TestCodeOf(t *testing.T) {
+	storageErr := WithCode(Wrap(New("dial tcp: connection refused"), "file stat"), CodeStorageUnreachable)
+
+	cases := []struct {
+		name string
+		err  error
+		code Code
+		msg  string
+	}{
+		{"none", Wrap(New("boom"), "backup"), "", "backup: boom"},
+		{"nil", nil, "", ""},
+		{"wrapped", Wrapf(Wrap(storageErr, "check read access"), "backup %s", "b1"), CodeStorageUnreachable,
+			"backup b1: check read access: file stat: dial tcp: connection refused"},
+		{"joined", Join(New("other"), Wrap(storageErr, "rs1")), CodeStorageUnreachable,
+			"other\nrs1: file stat: dial tcp: connection refused"},
+		{"std wrapped", fmt.Errorf("init: %w", storageErr), CodeStorageUnreachable,
+			"init: file stat: dial tcp: connection refused"},
+		{"typed", Wrap(lockErr{}, "acquire lock"), CodeLockHeld, "acquire lock: another operation is running"},
+		{"outermost wins", WithCode(Wrap(lockErr{}, "start"), CodeInvalidArgument), CodeInvalidArgument,
+			"start: another operation is running"},
+		{"empty code", WithCode(Wrap(lockErr{}, "start"), ""), CodeLockHeld, "start: another operation is running"},
+		{"not found", Wrap(WithCode(ErrNotFound, CodeBackupNotFound), "get backup"), CodeBackupNotFound,
+			"get backup: not found"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := CodeOf(c.err); got != c.code {
+				t.Errorf("code: want %q, got %q", c.code, got)
+			}
+			if c.err != nil && c.err.Error() != c.msg {
+				t.Errorf("message: want %q, got %q", c.msg, c.err.Error())
+			}
+		})
+	}
+}
+
+func TestWithCode(t *testing.T) {
+	if WithCode(nil, CodeLockHeld) != nil {
+		t.Error("nil error has to stay nil")
+	}
+
+	err := Wrap(WithCode(ErrNotFound, CodeBackupNotFound), "get backup")
+	if !Is(err, ErrNotFound) {
+		t.Error("the coded error has to match the sentinel")
+	}
+	if Cause(err) != ErrNotFound { //nolint:errorlint
+		t.Errorf("cause: want %v, got %v", ErrNotFound, Cause(err))
+	}
+
+	var l lockErr
+	if !As(Wrap(WithCode(lockErr{}, CodeInvalidArgument), "x"), &l) {
+		t.Error("the coded error has to unwrap to the typed one")
+	}
+}
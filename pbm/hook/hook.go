@@ -0,0 +1,80 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// OutputLimit is the max size of the command output written to the log.
+const OutputLimit = 4 << 10
+
+// waitDelay is how long to wait for the output of the command processes
+// after the command itself was killed on timeout.
+const waitDelay = time.Second
+
+// Run runs the hook command with env (`KEY=value`) added to the agent
+// environment. The command output (stdout and stderr) goes to the log.
+// The error is returned regardless of the failure policy.
+func Run(ctx context.Context, name string, h *config.Hook, env []string, l log.LogEvent) error {
+	timeout := h.TimeoutDuration()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out := &limitedBuffer{limit: OutputLimit}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Cmd)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = waitDelay
+
+	l.Info("run %s hook", name)
+	start := time.Now()
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = errors.Errorf("timed out after %v", timeout)
+	}
+	if out.Len() != 0 {
+		l.Info("%s hook output:\n%s", name, out)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%s hook", name)
+	}
+
+	l.Info("%s hook finished in %v", name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := min(len(p), max(b.limit-b.buf.Len(), 0))
+	b.buf.Write(p[:n])
+	b.dropped += len(p) - n
+
+	return len(p), nil
+}
+
+func (b *limitedBuffer) Len() int {
+	return b.buf.Len()
+}
+
+func (b *limitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+
+	return fmt.Sprintf("%s\n... truncated %d bytes", b.buf.String(), b.dropped)
+}
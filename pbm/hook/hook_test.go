@@ -0,0 +1,78 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+)
+
+type recordEvent struct {
+	msgs []string
+}
+
+func (e *recordEvent) add(msg string, args ...any) {
+	e.msgs = append(e.msgs, fmt.Sprintf(msg, args...))
+}
+
+func (e *recordEvent) Debug(msg string, args ...any)   { e.add(msg, args...) }
+func (e *recordEvent) Info(msg string, args ...any)    { e.add(msg, args...) }
+func (e *recordEvent) Warning(msg string, args ...any) { e.add(msg, args...) }
+func (e *recordEvent) Error(msg string, args ...any)   { e.add(msg, args...) }
+func (e *recordEvent) Fatal(msg string, args ...any)   { e.add(msg, args...) }
+
+func (e *recordEvent) output() string {
+	return strings.Join(e.msgs, "\n")
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("env and output", func(t *testing.T) {
+		l := &recordEvent{}
+		h := &config.Hook{Cmd: `echo "backup $PBM_BACKUP_NAME"; echo oops >&2`}
+		err := Run(ctx, "pre-backup", h, []string{"PBM_BACKUP_NAME=b1"}, l)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out := l.output(); !strings.Contains(out, "backup b1\noops") {
+			t.Errorf("expected the command output, got %q", out)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		l := &recordEvent{}
+		err := Run(ctx, "post-backup", &config.Hook{Cmd: "echo failed; exit 3"}, nil, l)
+		if err == nil || !strings.Contains(err.Error(), "post-backup hook: exit status 3") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if out := l.output(); !strings.Contains(out, "failed") {
+			t.Errorf("expected the command output, got %q", out)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		err := Run(ctx, "pre-backup", &config.Hook{Cmd: "sleep 10", Timeout: 1}, nil, &recordEvent{})
+		if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("truncated output", func(t *testing.T) {
+		l := &recordEvent{}
+		cmd := fmt.Sprintf("head -c %d /dev/zero | tr '\\0' x", OutputLimit+100)
+		err := Run(ctx, "pre-backup", &config.Hook{Cmd: cmd}, nil, l)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		out := l.output()
+		if !strings.Contains(out, "... truncated 100 bytes") {
+			t.Errorf("expected truncated output, got %d bytes", len(out))
+		}
+		if strings.Count(out, "x") != OutputLimit {
+			t.Errorf("expected %d bytes of output, got %d", OutputLimit, strings.Count(out, "x"))
+		}
+	})
+}
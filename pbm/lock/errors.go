@@ -1,6 +1,12 @@
 package lock
 
-import "fmt"
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
 
 // ConcurrentOpError means lock was already acquired by another node
 type ConcurrentOpError struct {
@@ -11,6 +17,10 @@ func (e ConcurrentOpError) Error() string {
 	return fmt.Sprintf("another operation is running: %s '%s'", e.Lock.Type, e.Lock.OPID)
 }
 
+func (ConcurrentOpError) ErrorCode() errors.Code {
+	return errors.CodeLockHeld
+}
+
 func (ConcurrentOpError) Is(err error) bool {
 	if err == nil {
 		return false
@@ -23,6 +33,8 @@ func (ConcurrentOpError) Is(err error) bool {
 // StaleLockError - the lock was already got but the operation seems to be staled (no hb from the node)
 type StaleLockError struct {
 	Lock LockHeader
+	// Heartbeat is the last heartbeat of the deleted stale lock
+	Heartbeat primitive.Timestamp
 }
 
 func (e StaleLockError) Error() string {
@@ -48,6 +60,10 @@ func (e DuplicatedOpError) Error() string {
 	return fmt.Sprintf("duplicate operation: %s [%s]", e.Lock.OPID, e.Lock.Type)
 }
 
+func (DuplicatedOpError) ErrorCode() errors.Code {
+	return errors.CodeLockHeld
+}
+
 func (DuplicatedOpError) Is(err error) bool {
 	if err == nil {
 		return false
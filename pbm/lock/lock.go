@@ -8,6 +8,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
@@ -25,6 +26,11 @@ type LockHeader struct {
 	// should be a pointer so mongo find with empty epoch would work
 	// otherwise it always set it at least to "epoch":{"$timestamp":{"t":0,"i":0}}
 	Epoch *primitive.Timestamp `bson:"epoch,omitempty" json:"epoch,omitempty"`
+	// Scope is empty for the default scope of the command (see CmdScope).
+	Scope Scope `bson:"scope,omitempty" json:"scope,omitempty"`
+	// Storage is the config profile name the operation works with.
+	// Empty is for the main storage and AnyStorage is for any storage.
+	Storage string `bson:"storage,omitempty" json:"storage,omitempty"`
 }
 
 type LockData struct {
@@ -32,6 +38,23 @@ type LockData struct {
 	Heartbeat  primitive.Timestamp `bson:"hb"` // separated in order the lock can be searchable by the header
 }
 
+// IsStale returns true if the lock has no heartbeat for more than staleSec
+// by the cluster time ts. The threshold can't be less than defs.StaleFrameSec.
+func (l *LockData) IsStale(ts primitive.Timestamp, staleSec uint32) bool {
+	return l.Heartbeat.T+max(staleSec, defs.StaleFrameSec) < ts.T
+}
+
+// StaleSec returns the configured lock stale threshold (lock.staleThresholdSec)
+// in seconds. It's defs.StaleFrameSec if the config can't be read.
+func StaleSec(ctx context.Context, m connect.Client) uint32 {
+	cfg, err := config.GetConfig(ctx, m)
+	if err != nil {
+		return defs.StaleFrameSec
+	}
+
+	return cfg.Lock.StaleThresholdSec()
+}
+
 // Lock is a lock for the PBM operation (e.g. backup, restore)
 type Lock struct {
 	LockData
@@ -66,6 +89,12 @@ func newLock(m connect.Client, coll *mongo.Collection, h LockHeader) *Lock {
 	}
 }
 
+// SetStaleSec sets how old (in seconds) the heartbeat of a concurrent lock
+// should be to consider the lock stale. It can't be less than defs.StaleFrameSec.
+func (l *Lock) SetStaleSec(sec uint32) {
+	l.staleSec = max(sec, defs.StaleFrameSec)
+}
+
 func (l *Lock) Connect() connect.Client {
 	return l.m
 }
@@ -76,6 +105,14 @@ func (l *Lock) Connect() connect.Client {
 // In case of concurrent lock exists is stale it will be deleted and
 // ErrWasStaleLock gonna be returned. A client shell mark respective operation
 // as stale and retry if it needs to
+//
+// The locks of the lock collection exclude each other on the replset by the
+// unique index, as well do the locks of the same type of the op-lock
+// collection. If the replset is held by a compatible lock (see Compatible),
+// the lock is taken in the op-lock collection instead. The locks of both
+// collections are checked for Compatible after the insert. If two
+// incompatible locks are being acquired at the same time, both of them may fail
+// with ConcurrentOpError, but never both succeed.
 func (l *Lock) Acquire(ctx context.Context) (bool, error) {
 	got, err := l.acquireImpl(ctx)
 	if err != nil {
@@ -83,20 +120,29 @@ func (l *Lock) Acquire(ctx context.Context) (bool, error) {
 	}
 
 	if got {
-		// log the operation. duplicate means error
-		err := l.log(ctx)
-		if err != nil {
-			rerr := l.Release()
-			if rerr != nil {
-				err = errors.Errorf("%v. Also failed to release the lock: %v", err, rerr)
+		err := l.checkPeers(ctx)
+		if err == nil {
+			l.hb(ctx)
+			// log the operation. duplicate means error
+			err = l.log(ctx)
+			if err == nil {
+				return true, nil
 			}
-			return false, err
 		}
-		return true, nil
+
+		rerr := l.Release()
+		if rerr != nil {
+			err = errors.Errorf("%v. Also failed to release the lock: %v", err, rerr)
+		}
+		return false, err
 	}
 
 	// there is some concurrent lock
-	peer, err := getLockData(ctx, &LockHeader{Replset: l.Replset}, l.coll)
+	ph := &LockHeader{Replset: l.Replset}
+	if l.coll.Name() == defs.LockOpCollection {
+		ph.Type = l.Type
+	}
+	peer, err := getLockData(ctx, ph, l.coll)
 	if err != nil {
 		return false, errors.Wrap(err, "check for the peer")
 	}
@@ -107,19 +153,61 @@ func (l *Lock) Acquire(ctx context.Context) (bool, error) {
 	}
 
 	// peer is alive
-	if peer.Heartbeat.T+l.staleSec >= ts.T {
+	if !peer.IsStale(ts, l.staleSec) {
 		if l.OPID != peer.OPID {
 			return false, ConcurrentOpError{Lock: peer.LockHeader}
 		}
 		return false, nil
 	}
 
-	_, err = l.coll.DeleteOne(ctx, peer.LockHeader)
+	return false, deleteStale(ctx, l.coll, &peer)
+}
+
+// checkPeers returns an error if there is an incompatible lock on the replset
+// in the lock or the op-lock collection. PITR slicing checks the operations
+// it can run along with by itself, so its locks aren't checked.
+func (l *Lock) checkPeers(ctx context.Context) error {
+	if l.Type == ctrl.CmdPITR {
+		return nil
+	}
+
+	for _, coll := range []*mongo.Collection{l.m.LockCollection(), l.m.LockOpCollection()} {
+		peers, err := getLocks(ctx, &LockHeader{Replset: l.Replset}, coll)
+		if err != nil {
+			return errors.Wrapf(err, "check for peers in %s", coll.Name())
+		}
+
+		for i := range peers {
+			peer := &peers[i]
+			if peer.Type == ctrl.CmdPITR {
+				continue
+			}
+			// the lock itself
+			if coll.Name() == l.coll.Name() && peer.Type == l.Type && peer.OPID == l.OPID {
+				continue
+			}
+			if Compatible(&l.LockHeader, &peer.LockHeader) {
+				continue
+			}
+
+			if !peer.IsStale(l.Heartbeat, l.staleSec) {
+				return ConcurrentOpError{Lock: peer.LockHeader}
+			}
+
+			return deleteStale(ctx, coll, peer)
+		}
+	}
+
+	return nil
+}
+
+func deleteStale(ctx context.Context, coll *mongo.Collection, peer *LockData) error {
+	_, err := coll.DeleteOne(ctx, peer.LockHeader)
 	if err != nil {
-		return false, errors.Wrap(err, "delete stale lock")
+		return errors.Wrap(err, "delete stale lock")
 	}
 
-	return false, StaleLockError{Lock: peer.LockHeader}
+	return StaleLockError{Lock: peer.LockHeader, Heartbeat: peer.Heartbeat}
 }
 
 func (l *Lock) log(ctx context.Context) error {
@@ -158,6 +246,32 @@ func (l *Lock) acquireImpl(ctx context.Context) (bool, error) {
 		return false, errors.Wrap(err, "read cluster time")
 	}
 
+	_, err = l.coll.InsertOne(ctx, l.LockData)
+	if err == nil {
+		return true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, errors.Wrap(err, "acquire lock")
+	}
+	if l.coll.Name() != defs.LockCollection || l.OpScope() == ScopeCluster {
+		return false, nil
+	}
+
+	// the replset is held by another lock. If it's compatible,
+	// the lock is taken along with it in the op-lock collection.
+	holder, err := getLockData(ctx, &LockHeader{Replset: l.Replset}, l.coll)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// released meanwhile
+			return l.acquireImpl(ctx)
+		}
+		return false, errors.Wrap(err, "get the replset lock")
+	}
+	if !Compatible(&l.LockHeader, &holder.LockHeader) {
+		return false, nil
+	}
+
+	l.coll = l.m.LockOpCollection()
 	_, err = l.coll.InsertOne(ctx, l.LockData)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
@@ -166,7 +280,6 @@ func (l *Lock) acquireImpl(ctx context.Context) (bool, error) {
 		return false, errors.Wrap(err, "acquire lock")
 	}
 
-	l.hb(ctx)
 	return true, nil
 }
 
@@ -233,6 +346,28 @@ func GetOpLocks(ctx context.Context, m connect.Client, lh *LockHeader) ([]LockDa
 	return getLocks(ctx, lh, m.LockOpCollection())
 }
 
+// GetOperationLocks returns the locks of the running operations: the ones
+// of the lock collection and the ones taken along with them in the op-lock
+// collection (see Lock.Acquire). PITR slicing locks are not included.
+func GetOperationLocks(ctx context.Context, m connect.Client, lh *LockHeader) ([]LockData, error) {
+	locks, err := getLocks(ctx, lh, m.LockCollection())
+	if err != nil {
+		return nil, err
+	}
+
+	opLocks, err := getLocks(ctx, lh, m.LockOpCollection())
+	if err != nil {
+		return nil, err
+	}
+	for i := range opLocks {
+		if opLocks[i].Type != ctrl.CmdPITR {
+			locks = append(locks, opLocks[i])
+		}
+	}
+
+	return locks, nil
+}
+
 func getLocks(ctx context.Context, lh *LockHeader, cl *mongo.Collection) ([]LockData, error) {
 	cur, err := cl.Find(ctx, lh)
 	if err != nil {
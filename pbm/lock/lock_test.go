@@ -0,0 +1,51 @@
+package lock
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+)
+
+func TestLockDataIsStale(t *testing.T) {
+	now := primitive.Timestamp{T: 1000}
+
+	cases := []struct {
+		name     string
+		hb       uint32
+		staleSec uint32
+		want     bool
+	}{
+		{"fresh", 990, defs.StaleFrameSec, false},
+		{"on the threshold", 970, defs.StaleFrameSec, false},
+		{"stale", 969, defs.StaleFrameSec, true},
+		{"not stale by the configured threshold", 900, 120, false},
+		{"stale by the configured threshold", 879, 120, true},
+		{"threshold below the minimum", 960, 5, true},
+		{"threshold below the minimum keeps fresh lock", 975, 5, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := LockData{Heartbeat: primitive.Timestamp{T: c.hb}}
+			if got := l.IsStale(now, c.staleSec); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSetStaleSec(t *testing.T) {
+	l := &Lock{}
+
+	l.SetStaleSec(120)
+	if l.staleSec != 120 {
+		t.Errorf("got %d, want 120", l.staleSec)
+	}
+
+	l.SetStaleSec(1)
+	if l.staleSec != defs.StaleFrameSec {
+		t.Errorf("got %d, want %d", l.staleSec, defs.StaleFrameSec)
+	}
+}
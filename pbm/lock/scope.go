@@ -0,0 +1,76 @@
+package lock
+
+import (
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+)
+
+// Scope defines which operations can run along with the lock holder.
+type Scope string
+
+const (
+	// ScopeCluster operations can't run along with any other operation
+	// (e.g. restore). Locks of such operations are taken on every replset.
+	ScopeCluster Scope = "cluster"
+	// ScopeReplset operations conflict with operations on the same storage
+	// of the replset (e.g. backup and delete on the main storage).
+	ScopeReplset Scope = "replset"
+	// ScopeRead operations only read data and metadata. They conflict
+	// only with ScopeCluster operations.
+	ScopeRead Scope = "read"
+)
+
+// AnyStorage is the lock storage of operations which may affect
+// any storage (e.g. deleting a backup, which can be on a profile storage).
+const AnyStorage = "*"
+
+// CmdScope returns the default scope of the command.
+// Unknown commands are cluster-exclusive.
+func CmdScope(cmd ctrl.Command) Scope {
+	switch cmd {
+	case ctrl.CmdBackup,
+		ctrl.CmdDeleteBackup,
+		ctrl.CmdDeletePITR,
+		ctrl.CmdPITRCompact,
+		ctrl.CmdPITR,
+		ctrl.CmdAddConfigProfile,
+		ctrl.CmdRemoveConfigProfile:
+		return ScopeReplset
+	case ctrl.CmdVerifyRestore, ctrl.CmdPITRVerify:
+		return ScopeRead
+	default:
+		return ScopeCluster
+	}
+}
+
+// OpScope returns the lock scope. Locks created without scope
+// (e.g. by older agents) have the default scope of their command.
+func (h *LockHeader) OpScope() Scope {
+	if h.Scope != "" {
+		return h.Scope
+	}
+
+	return CmdScope(h.Type)
+}
+
+// Compatible returns true if operations of a and b can run
+// on the same replset at the same time.
+//
+// Operations of the same type are never compatible.
+func Compatible(a, b *LockHeader) bool {
+	if a.Type == b.Type {
+		return false
+	}
+
+	sa, sb := a.OpScope(), b.OpScope()
+	switch {
+	case sa == ScopeCluster || sb == ScopeCluster:
+		return false
+	case sa == ScopeRead || sb == ScopeRead:
+		return true
+	}
+
+	if a.Storage == AnyStorage || b.Storage == AnyStorage {
+		return false
+	}
+	return a.Storage != b.Storage
+}
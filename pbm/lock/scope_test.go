@@ -0,0 +1,92 @@
+package lock
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestCompatible(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b LockHeader
+		want bool
+	}{
+		{
+			"backup and restore",
+			LockHeader{Type: ctrl.CmdBackup},
+			LockHeader{Type: ctrl.CmdRestore},
+			false,
+		},
+		{
+			"same type on different storages",
+			LockHeader{Type: ctrl.CmdBackup},
+			LockHeader{Type: ctrl.CmdBackup, Storage: "p1"},
+			false,
+		},
+		{
+			"same storage",
+			LockHeader{Type: ctrl.CmdBackup},
+			LockHeader{Type: ctrl.CmdDeletePITR},
+			false,
+		},
+		{
+			"any storage",
+			LockHeader{Type: ctrl.CmdBackup, Storage: "p1"},
+			LockHeader{Type: ctrl.CmdDeleteBackup, Storage: AnyStorage},
+			false,
+		},
+		{
+			"different storages",
+			LockHeader{Type: ctrl.CmdBackup},
+			LockHeader{Type: ctrl.CmdAddConfigProfile, Storage: "p1"},
+			true,
+		},
+		{
+			"read and replset",
+			LockHeader{Type: ctrl.CmdResync, Scope: ScopeRead},
+			LockHeader{Type: ctrl.CmdBackup},
+			true,
+		},
+		{
+			"read and cluster",
+			LockHeader{Type: ctrl.CmdResync, Scope: ScopeRead},
+			LockHeader{Type: ctrl.CmdRestore},
+			false,
+		},
+		{
+			"legacy lock without scope",
+			LockHeader{Type: ctrl.CmdResync},
+			LockHeader{Type: ctrl.CmdBackup, Storage: "p1"},
+			false,
+		},
+		{
+			"slicing and profile resync",
+			LockHeader{Type: ctrl.CmdPITR},
+			LockHeader{Type: ctrl.CmdResync, Scope: ScopeReplset, Storage: "p1"},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		if got := Compatible(&tc.a, &tc.b); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+		if got := Compatible(&tc.b, &tc.a); got != tc.want {
+			t.Errorf("%s (reversed): got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLockErrorCode(t *testing.T) {
+	h := LockHeader{Type: ctrl.CmdBackup, OPID: "65f1c0e2b4a1d2e3f4a5b6c7"}
+	for _, err := range []error{ConcurrentOpError{h}, DuplicatedOpError{h}} {
+		if code := errors.CodeOf(errors.Wrap(err, "acquire lock")); code != errors.CodeLockHeld {
+			t.Errorf("%v: want %s, got %q", err, errors.CodeLockHeld, code)
+		}
+	}
+	if code := errors.CodeOf(StaleLockError{Lock: h}); code != "" {
+		t.Errorf("stale lock: got %q", code)
+	}
+}
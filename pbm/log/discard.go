@@ -59,6 +59,8 @@ func (discardLoggerImpl) Opts() *Opts { return nil }
 func (discardLoggerImpl) SetLogLevelAndJSON(cfg *Opts) {
 }
 
+func (discardLoggerImpl) Reopen() error { return nil }
+
 type discardEventImpl struct{}
 
 func (discardEventImpl) Debug(msg string, args ...any)   {}
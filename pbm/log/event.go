@@ -11,24 +11,38 @@ type eventImpl struct {
 	obj  string
 	ep   primitive.Timestamp
 	opid string
+	comp string
+}
+
+// WithComponent returns the event which output level can be overridden
+// by the component log level (e.g. storage). Other events are returned as is.
+func WithComponent(ev LogEvent, comp string) LogEvent {
+	e, ok := ev.(*eventImpl)
+	if !ok {
+		return ev
+	}
+
+	c := *e
+	c.comp = comp
+	return &c
 }
 
 func (e *eventImpl) Debug(msg string, args ...interface{}) {
-	e.l.Debug(e.typ, e.obj, e.opid, e.ep, msg, args...)
+	e.l.output(Debug, e.comp, e.typ, e.obj, e.opid, e.ep, msg, args...)
 }
 
 func (e *eventImpl) Info(msg string, args ...interface{}) {
-	e.l.Info(e.typ, e.obj, e.opid, e.ep, msg, args...)
+	e.l.output(Info, e.comp, e.typ, e.obj, e.opid, e.ep, msg, args...)
 }
 
 func (e *eventImpl) Warning(msg string, args ...interface{}) {
-	e.l.Warning(e.typ, e.obj, e.opid, e.ep, msg, args...)
+	e.l.output(Warning, e.comp, e.typ, e.obj, e.opid, e.ep, msg, args...)
 }
 
 func (e *eventImpl) Error(msg string, args ...interface{}) {
-	e.l.Error(e.typ, e.obj, e.opid, e.ep, msg, args...)
+	e.l.output(Error, e.comp, e.typ, e.obj, e.opid, e.ep, msg, args...)
 }
 
 func (e *eventImpl) Fatal(msg string, args ...interface{}) {
-	e.l.Fatal(e.typ, e.obj, e.opid, e.ep, msg, args...)
+	e.l.output(Fatal, e.comp, e.typ, e.obj, e.opid, e.ep, msg, args...)
 }
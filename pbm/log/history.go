@@ -29,6 +29,16 @@ type Entry struct {
 	TZone   int                `bson:"tz" json:"-"`
 	LogKeys `bson:",inline" json:",inline"`
 	Msg     string `bson:"msg" json:"msg"`
+	// Err is the error of the message (if any) as it is in the message.
+	// It's in the JSON lines of FormatJSONFull only.
+	Err string `bson:"error,omitempty" json:"-"`
+	// Time is the entry time as a date for the TTL index of the log
+	// collection (see connect.EnsureLogTTL). Older entries don't have it.
+	Time time.Time `bson:"t,omitempty" json:"-"`
+
+	// comp is the logger component (e.g. storage). It isn't stored
+	// and only defines the output level.
+	comp string
 }
 
 func (e *Entry) Stringify(f tsFormatFn, showNode, extr bool) string {
@@ -213,6 +223,10 @@ func CommandLastError(ctx context.Context, cc connect.Client, cid string) (strin
 	return l.Msg, nil
 }
 
+// Follow tails the log collection and sends entries matching the request.
+//
+// The cursor is recreated if it is timed out, killed or the connection
+// is lost. Entries that have already been sent are not sent again.
 func Follow(
 	ctx context.Context,
 	conn connect.Client,
@@ -226,34 +240,96 @@ func Follow(
 		defer close(errC)
 		defer close(outC)
 
-		opt := options.Find().SetCursorType(options.TailableAwait)
+		// entries of the last seen second. to skip them after reconnect
+		var lastTS int64
+		seen := make(map[primitive.ObjectID]bool)
 
-		cur, err := conn.LogCollection().Find(ctx, filter, opt)
-		if err != nil {
-			errC <- errors.Wrap(err, "query")
-			return
-		}
-		defer cur.Close(context.Background())
+		for {
+			f := filter
+			if lastTS != 0 {
+				f = bson.D{{"$and", bson.A{filter, bson.D{{"ts", bson.M{"$gte": lastTS}}}}}}
+			}
 
-		for cur.Next(ctx) {
-			e := &Entry{}
-			if err := cur.Decode(e); err != nil {
-				errC <- errors.Wrap(err, "decode")
+			err := followCursor(ctx, conn, f, func(e *Entry) {
+				if e.TS == lastTS && seen[e.ObjID] {
+					return
+				}
+				if e.TS > lastTS {
+					lastTS = e.TS
+					clear(seen)
+				}
+				seen[e.ObjID] = true
+
+				select {
+				case outC <- e:
+				case <-ctx.Done():
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !isRecoverableCursorErr(err) {
+				errC <- err
 				return
 			}
 
-			e.ObjID, _ = cur.Current.Lookup("_id").ObjectIDOK()
-			outC <- e
-		}
-
-		if err := cur.Err(); err != nil {
-			errC <- err
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
 		}
 	}()
 
 	return outC, errC
 }
 
+// followCursor reads the tailable cursor until it is dead.
+func followCursor(ctx context.Context, conn connect.Client, filter bson.D, fn func(*Entry)) error {
+	opt := options.Find().SetCursorType(options.TailableAwait)
+
+	cur, err := conn.LogCollection().Find(ctx, filter, opt)
+	if err != nil {
+		return errors.Wrap(err, "query")
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(ctx) {
+		e := &Entry{}
+		if err := cur.Decode(e); err != nil {
+			return errors.Wrap(err, "decode")
+		}
+
+		e.ObjID, _ = cur.Current.Lookup("_id").ObjectIDOK()
+		fn(e)
+	}
+
+	return cur.Err()
+}
+
+// isRecoverableCursorErr returns true if the follow can continue
+// with a new cursor.
+func isRecoverableCursorErr(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	const (
+		cursorNotFound  = 43
+		queryPlanKilled = 175
+		cursorKilled    = 237
+	)
+	var cerr mongo.CommandError
+	if errors.As(err, &cerr) {
+		switch cerr.Code {
+		case cursorNotFound, queryPlanKilled, cursorKilled:
+			return true
+		}
+	}
+
+	return false
+}
+
 func LogGet(ctx context.Context, m connect.Client, r *LogRequest, limit int64) (*Entries, error) {
 	return fetch(ctx, m, r, limit, false)
 }
@@ -3,6 +3,7 @@ package log
 import (
 	"context"
 	"io"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -37,6 +38,8 @@ type Logger interface {
 	Output(ctx context.Context, e *Entry) error
 	Opts() *Opts
 	SetLogLevelAndJSON(cfg *Opts)
+	// Reopen reopens the log file (e.g. after it was rotated by logrotate).
+	Reopen() error
 }
 
 type LogEvent interface {
@@ -90,18 +93,29 @@ func (s Severity) String() string {
 }
 
 func strToSeverity(s string) Severity {
-	switch s {
-	case F:
-		return Fatal
-	case E:
-		return Error
-	case W:
-		return Warning
-	case I:
-		return Info
-	case D:
+	sv, ok := ParseSeverity(s)
+	if !ok {
 		return Debug
+	}
+
+	return sv
+}
+
+// ParseSeverity parses the severity either by its letter (D, I, W, E, F)
+// or full name (debug, info, warning, error, fatal). It's case-insensitive.
+func ParseSeverity(s string) (Severity, bool) {
+	switch strings.ToLower(s) {
+	case "f", "fatal":
+		return Fatal, true
+	case "e", "error":
+		return Error, true
+	case "w", "warn", "warning":
+		return Warning, true
+	case "i", "info":
+		return Info, true
+	case "d", "debug":
+		return Debug, true
 	default:
-		return Debug
+		return Debug, false
 	}
 }
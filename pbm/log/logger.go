@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -38,8 +37,10 @@ type loggerImpl struct {
 
 	pauseMgo int32
 
-	logLevel Severity
-	logJSON  bool
+	logLevel   Severity
+	logJSON    bool
+	jsonFull   bool
+	components map[string]Severity
 }
 
 // New creates default logger which outputs to stderr.
@@ -56,15 +57,17 @@ func New(conn connect.Client, rs, node string) Logger {
 // NewWithOpts creates logger based on provided options.
 func NewWithOpts(conn connect.Client, rs, node string, opts *Opts) Logger {
 	l := &loggerImpl{
-		conn:     conn,
-		rs:       rs,
-		node:     node,
-		logLevel: strToSeverity(opts.LogLevel),
-		logJSON:  opts.LogJSON,
+		conn:       conn,
+		rs:         rs,
+		node:       node,
+		logLevel:   strToSeverity(opts.LogLevel),
+		logJSON:    opts.LogJSON,
+		jsonFull:   opts.LogJSONFull,
+		components: parseComponents(opts.Components),
 	}
 
 	l.mu.Lock()
-	l.createLogger(opts.LogPath)
+	l.createLogger(opts)
 	l.mu.Unlock()
 
 	return l
@@ -75,11 +78,50 @@ type Opts struct {
 	LogPath  string
 	LogJSON  bool
 	LogLevel string
+	// LogJSONFull makes the JSON lines have all the fields of the log
+	// collection documents (see FormatJSONFull). Requires LogJSON.
+	LogJSONFull bool
+
+	// MaxSizeMB is the size of the log file to be rotated at.
+	// 0 disables rotation.
+	MaxSizeMB int
+	// MaxFiles is the number of rotated files to keep.
+	MaxFiles int
+	// Components are the log levels of the components (e.g. storage)
+	// or events (e.g. backup, restore), overriding LogLevel.
+	Components map[string]string
+}
+
+// FormatJSONFull is the log format of the JSON lines with the same fields
+// as the documents in the log collection. The JSON lines of LogJSON alone
+// have no ns, tz and error, as in older versions.
+const FormatJSONFull = "json-full"
+
+// jsonFullEntry is the JSON line of FormatJSONFull
+type jsonFullEntry struct {
+	TS    int64 `json:"ts"`
+	Tns   int   `json:"ns"`
+	TZone int   `json:"tz"`
+	LogKeys
+	Msg string `json:"msg"`
+	Err string `json:"error,omitempty"`
+}
+
+func newJSONFullEntry(e *Entry) *jsonFullEntry {
+	return &jsonFullEntry{
+		TS:      e.TS,
+		Tns:     e.Tns,
+		TZone:   e.TZone,
+		LogKeys: e.LogKeys,
+		Msg:     e.Msg,
+		Err:     e.Err,
+	}
 }
 
 type logger struct {
 	out     io.Writer
 	logPath string
+	file    *rotatingFile
 }
 
 func newStdLogger() *logger {
@@ -89,35 +131,21 @@ func newStdLogger() *logger {
 	}
 }
 
-func newFileLogger(logPath string) (*logger, error) {
+func newFileLogger(logPath string, maxSizeMB, maxFiles int) (*logger, error) {
 	fullpath, err := filepath.Abs(logPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "abs")
 	}
 
-	fileInfo, err := os.Stat(fullpath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, errors.Wrap(err, "stat")
-		}
-
-		dirpath := filepath.Dir(fullpath)
-		err = os.MkdirAll(dirpath, fs.ModeDir|0o777)
-		if err != nil {
-			return nil, errors.Wrap(err, "mkdir -p")
-		}
-	} else if fileInfo.IsDir() {
-		return nil, errors.New("path is dir")
-	}
-
-	out, err := os.OpenFile(fullpath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	f, err := openRotatingFile(fullpath, int64(maxSizeMB)*1024*1024, maxFiles)
 	if err != nil {
-		return nil, errors.Wrap(err, "open file")
+		return nil, err
 	}
 
 	return &logger{
-		out:     out,
+		out:     f,
 		logPath: logPath,
+		file:    f,
 	}, nil
 }
 
@@ -133,15 +161,16 @@ func (l *logger) close() {
 
 // createLogger creates file/stderr type of logger based on logPath.
 // In case of an error during file logger creation, it falls back to stderr logger.
-func (l *loggerImpl) createLogger(logPath string) {
+func (l *loggerImpl) createLogger(opts *Opts) {
 	// close old one first
 	l.logger.close()
 
 	// and create new one
+	logPath := opts.LogPath
 	if strings.TrimSpace(logPath) == "" || logPath == logPathStdErr {
 		l.logger = newStdLogger()
 	} else {
-		fl, err := newFileLogger(logPath)
+		fl, err := newFileLogger(logPath, opts.MaxSizeMB, opts.MaxFiles)
 		if err != nil {
 			l.logger = newStdLogger()
 			log.Printf("[ERROR] error while creating file logger: %v", err)
@@ -191,15 +220,43 @@ func (l *loggerImpl) ResumeMgo() {
 	atomic.StoreInt32(&l.pauseMgo, 0)
 }
 
+// Write writes the raw output (e.g. of mongo-tools). With FormatJSONFull
+// each line is written as an info entry.
 func (l *loggerImpl) Write(p []byte) (int, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	return l.logger.out.Write(p)
+	if !l.logJSON || !l.jsonFull {
+		return l.logger.out.Write(p)
+	}
+
+	//nolint:gosmopolitan
+	_, tz := time.Now().Local().Zone()
+	t := time.Now().UTC()
+	enc := json.NewEncoder(l.logger.out)
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		err := enc.Encode(&jsonFullEntry{
+			TS:      t.Unix(),
+			Tns:     t.Nanosecond(),
+			TZone:   tz,
+			LogKeys: LogKeys{RS: l.rs, Node: l.node, Severity: Info},
+			Msg:     line,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
 }
 
 func (l *loggerImpl) output(
 	s Severity,
+	comp,
 	event,
 	obj,
 	opid string,
@@ -217,6 +274,7 @@ func (l *loggerImpl) output(
 	e := &Entry{
 		TS:    t.Unix(),
 		Tns:   t.Nanosecond(),
+		Time:  t,
 		TZone: tz,
 		LogKeys: LogKeys{
 			RS:       l.rs,
@@ -227,7 +285,9 @@ func (l *loggerImpl) output(
 			OPID:     opid,
 			Epoch:    epoch,
 		},
-		Msg: msg,
+		Msg:  msg,
+		Err:  lastError(args),
+		comp: comp,
 	}
 
 	err := l.Output(context.TODO(), e)
@@ -236,28 +296,39 @@ func (l *loggerImpl) output(
 	}
 }
 
+// lastError returns the text of the last non-nil error in the args.
+func lastError(args []any) string {
+	for i := len(args) - 1; i >= 0; i-- {
+		if err, ok := args[i].(error); ok && err != nil {
+			return err.Error()
+		}
+	}
+
+	return ""
+}
+
 func (l *loggerImpl) Printf(msg string, args ...interface{}) {
-	l.output(Info, "", "", "", primitive.Timestamp{}, msg, args...)
+	l.output(Info, "", "", "", "", primitive.Timestamp{}, msg, args...)
 }
 
 func (l *loggerImpl) Debug(event, obj, opid string, epoch primitive.Timestamp, msg string, args ...interface{}) {
-	l.output(Debug, event, obj, opid, epoch, msg, args...)
+	l.output(Debug, "", event, obj, opid, epoch, msg, args...)
 }
 
 func (l *loggerImpl) Info(event, obj, opid string, epoch primitive.Timestamp, msg string, args ...interface{}) {
-	l.output(Info, event, obj, opid, epoch, msg, args...)
+	l.output(Info, "", event, obj, opid, epoch, msg, args...)
 }
 
 func (l *loggerImpl) Warning(event, obj, opid string, epoch primitive.Timestamp, msg string, args ...interface{}) {
-	l.output(Warning, event, obj, opid, epoch, msg, args...)
+	l.output(Warning, "", event, obj, opid, epoch, msg, args...)
 }
 
 func (l *loggerImpl) Error(event, obj, opid string, epoch primitive.Timestamp, msg string, args ...interface{}) {
-	l.output(Error, event, obj, opid, epoch, msg, args...)
+	l.output(Error, "", event, obj, opid, epoch, msg, args...)
 }
 
 func (l *loggerImpl) Fatal(event, obj, opid string, epoch primitive.Timestamp, msg string, args ...interface{}) {
-	l.output(Fatal, event, obj, opid, epoch, msg, args...)
+	l.output(Fatal, "", event, obj, opid, epoch, msg, args...)
 }
 
 func (l *loggerImpl) Output(ctx context.Context, e *Entry) error {
@@ -286,13 +357,23 @@ func (l *loggerImpl) Output(ctx context.Context, e *Entry) error {
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	if l.logger != nil && l.logLevel >= e.Severity {
+	if l.logger != nil && l.level(e) >= e.Severity {
 		var err error
 		if l.logJSON {
-			err = json.NewEncoder(l.logger.out).Encode(e)
+			var v any = e
+			if l.jsonFull {
+				v = newJSONFullEntry(e)
+			}
+			err = json.NewEncoder(l.logger.out).Encode(v)
 			err = errors.Wrap(err, "io json")
 		} else {
-			_, err = l.logger.out.Write(append([]byte(e.String()), '\n'))
+			s := e.String()
+			if l.logger.file != nil {
+				// there is no context of the file lines, so keep
+				// the structured fields as in the log collection
+				s = e.Stringify(AsLocal, true, e.OPID != "")
+			}
+			_, err = l.logger.out.Write(append([]byte(s), '\n'))
 			err = errors.Wrap(err, "io text")
 		}
 
@@ -306,12 +387,69 @@ func (l *loggerImpl) Output(ctx context.Context, e *Entry) error {
 	return rerr
 }
 
+// level returns the output level of the entry. The level of the component
+// takes precedence over the level of the event.
+func (l *loggerImpl) level(e *Entry) Severity {
+	if s, ok := l.components[e.comp]; ok && e.comp != "" {
+		return s
+	}
+	if s, ok := l.components[e.Event]; ok && e.Event != "" {
+		return s
+	}
+
+	return l.logLevel
+}
+
+func parseComponents(comps map[string]string) map[string]Severity {
+	if len(comps) == 0 {
+		return nil
+	}
+
+	rv := make(map[string]Severity, len(comps))
+	for c, s := range comps {
+		rv[strings.ToLower(c)] = strToSeverity(s)
+	}
+
+	return rv
+}
+
 func (l *loggerImpl) Opts() *Opts {
-	return &Opts{
-		LogPath:  l.logger.logPath,
-		LogJSON:  l.logJSON,
-		LogLevel: l.logLevel.String(),
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var comps map[string]string
+	if len(l.components) != 0 {
+		comps = make(map[string]string, len(l.components))
+		for c, s := range l.components {
+			comps[c] = s.String()
+		}
+	}
+
+	opts := &Opts{
+		LogPath:     l.logger.logPath,
+		LogJSON:     l.logJSON,
+		LogJSONFull: l.jsonFull,
+		LogLevel:    l.logLevel.String(),
+		Components:  comps,
+	}
+	if l.logger.file != nil {
+		opts.MaxSizeMB = int(l.logger.file.maxSize / 1024 / 1024)
+		opts.MaxFiles = l.logger.file.maxFiles
+	}
+
+	return opts
+}
+
+// Reopen reopens the log file. It does nothing for stderr.
+func (l *loggerImpl) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.logger == nil || l.logger.file == nil {
+		return nil
 	}
+
+	return l.logger.file.reopen()
 }
 
 func (l *loggerImpl) SetLogLevelAndJSON(cfg *Opts) {
@@ -325,7 +463,9 @@ func (l *loggerImpl) SetLogLevelAndJSON(cfg *Opts) {
 	if cfg.LogLevel != "" && l.logLevel.String() != cfg.LogLevel {
 		l.logLevel = strToSeverity(cfg.LogLevel)
 	}
+	l.components = parseComponents(cfg.Components)
 	if l.logJSON != cfg.LogJSON {
 		l.logJSON = cfg.LogJSON
 	}
+	l.jsonFull = cfg.LogJSONFull
 }
@@ -1,12 +1,19 @@
 package log
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
 )
 
 func TestLoggerConstructor(t *testing.T) {
@@ -44,3 +51,186 @@ func TestLoggerConstructor(t *testing.T) {
 		})
 	})
 }
+
+func TestComponentLevels(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "pbm.log")
+	l := NewWithOpts(nil, "rs", "node", &Opts{
+		LogPath:  f,
+		LogLevel: I,
+		Components: map[string]string{
+			"Storage": "debug",
+			"backup":  "error",
+		},
+	})
+
+	l.NewDefaultEvent().Debug("default debug")
+	WithComponent(l.NewDefaultEvent(), "storage").Debug("storage debug")
+	l.NewEvent("backup", "b1", "opid1", primitive.Timestamp{}).Warning("backup warning")
+	l.NewEvent("backup", "b1", "opid1", primitive.Timestamp{}).Error("backup error")
+	WithComponent(l.NewEvent("backup", "b1", "opid1", primitive.Timestamp{}), "storage").Debug("backup storage")
+	l.NewEvent("restore", "r1", "opid2", primitive.Timestamp{}).Info("restore info")
+
+	b, _ := os.ReadFile(f)
+	out := string(b)
+	for _, msg := range []string{"storage debug", "backup error", "backup storage", "restore info"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("expected %q in %q", msg, out)
+		}
+	}
+	for _, msg := range []string{"default debug", "backup warning"} {
+		if strings.Contains(out, msg) {
+			t.Errorf("unexpected %q in %q", msg, out)
+		}
+	}
+	if want := "[rs/node] [restore/r1/opid2] restore info"; !strings.Contains(out, want) {
+		t.Errorf("expected structured fields %q in %q", want, out)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	cases := map[string]Severity{
+		"D":       Debug,
+		"debug":   Debug,
+		"INFO":    Info,
+		"w":       Warning,
+		"warning": Warning,
+		"error":   Error,
+		"F":       Fatal,
+	}
+	for s, want := range cases {
+		got, ok := ParseSeverity(s)
+		if !ok || got != want {
+			t.Errorf("%s: got %v, %v, want %v", s, got, ok, want)
+		}
+	}
+	if _, ok := ParseSeverity("trace"); ok {
+		t.Errorf("trace: expected to be invalid")
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestLogFormat(t *testing.T) {
+	loc := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = loc }()
+
+	entries := []*Entry{
+		{
+			TS: 1704164645, Tns: 123000000,
+			LogKeys: LogKeys{Severity: Info, RS: "rs0", Node: "rs0:27017", Event: "backup", ObjName: "2024-01-02T03:04:05Z", OPID: "6593"},
+			Msg:     "backup started",
+		},
+		{
+			TS: 1704164646, Tns: 5,
+			LogKeys: LogKeys{Severity: Error, RS: "rs0", Node: "rs0:27017", Event: "backup", ObjName: "2024-01-02T03:04:05Z", OPID: "6593"},
+			Msg:     "backup: upload: connection reset",
+			Err:     "upload: connection reset",
+		},
+		{
+			TS:      1704164647,
+			LogKeys: LogKeys{Severity: Warning, RS: "rs0", Node: "rs0:27017", Epoch: primitive.Timestamp{T: 1704160000, I: 1}},
+			Msg:     "listening for the commands",
+		},
+	}
+
+	for _, format := range []struct {
+		name string
+		json bool
+		full bool
+	}{{"text", false, false}, {"json", true, false}, {FormatJSONFull, true, true}} {
+		f := filepath.Join(t.TempDir(), "pbm.log")
+		l := NewWithOpts(nil, "rs0", "rs0:27017", &Opts{LogPath: f, LogJSON: format.json, LogJSONFull: format.full})
+		for _, e := range entries {
+			if err := l.Output(context.Background(), e); err != nil {
+				t.Fatalf("%s: output: %v", format.name, err)
+			}
+		}
+
+		got, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", "format."+format.name)
+		if *update {
+			if err := os.WriteFile(golden, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", format.name, got, want)
+		}
+	}
+}
+
+func TestLogJSONFields(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "pbm.log")
+	l := NewWithOpts(nil, "rs0", "rs0:27017", &Opts{LogPath: f, LogJSON: true, LogJSONFull: true})
+
+	l.NewEvent("restore", "r1", "opid1", primitive.Timestamp{}).Error("restore: %v", errors.New("no space left"))
+	_, _ = l.Write([]byte("2024-01-02T03:04:05.000+0000\trestoring db.c\n\n"))
+
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", b)
+	}
+
+	var e map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]any{
+		"s": float64(Error), "e": "restore", "eobj": "r1", "opid": "opid1",
+		"rs": "rs0", "node": "rs0:27017", "msg": "restore: no space left", "error": "no space left",
+	} {
+		if e[k] != want {
+			t.Errorf("%s: got %v, want %v", k, e[k], want)
+		}
+	}
+
+	e = nil
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["msg"] != "2024-01-02T03:04:05.000+0000\trestoring db.c" || e["s"] != float64(Info) {
+		t.Errorf("unexpected raw output entry: %v", e)
+	}
+}
+
+func TestLogJSONLegacy(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "pbm.log")
+	l := NewWithOpts(nil, "rs0", "rs0:27017", &Opts{LogPath: f, LogJSON: true})
+
+	l.NewEvent("restore", "r1", "opid1", primitive.Timestamp{}).Error("restore: %v", errors.New("no space left"))
+	_, _ = l.Write([]byte("restoring db.c\n"))
+
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", b)
+	}
+
+	var e map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"ns", "tz", "error"} {
+		if _, ok := e[k]; ok {
+			t.Errorf("unexpected field %q in %s", k, lines[0])
+		}
+	}
+	if lines[1] != "restoring db.c" {
+		t.Errorf("raw output is changed: %q", lines[1])
+	}
+}
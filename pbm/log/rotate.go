@@ -0,0 +1,145 @@
+package log
+
+import (
+	"fmt"
+	"io/fs"
+	stdlog "log"
+	"os"
+	"path/filepath"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+const defaultMaxFiles = 5
+
+// rotatingFile is a log file which is rotated once it grows over maxSize.
+// Rotated files are <path>.1 (the most recent) up to <path>.<maxFiles>,
+// older ones are deleted. maxSize 0 disables rotation, so the file
+// can be rotated by an external tool (e.g. logrotate) and reopened.
+//
+// It isn't safe for concurrent use. The logger serializes writes.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	r := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	fileInfo, err := os.Stat(r.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrap(err, "stat")
+		}
+
+		err = os.MkdirAll(filepath.Dir(r.path), fs.ModeDir|0o777)
+		if err != nil {
+			return errors.Wrap(err, "mkdir -p")
+		}
+	} else if fileInfo.IsDir() {
+		return errors.New("path is dir")
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "stat file")
+	}
+
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, errors.Wrap(err, "rotate")
+		}
+	}
+	if r.f == nil {
+		return 0, errors.New("file is closed")
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// reopen closes and opens the file by its path again. It's expected to be called
+// after the file has been moved by an external tool.
+func (r *rotatingFile) reopen() error {
+	r.Close()
+	return r.open()
+}
+
+// rotate shifts the rotated files and opens a new file. If shifting fails,
+// logging continues to the current file.
+func (r *rotatingFile) rotate() error {
+	r.Close()
+
+	err := r.shift()
+	if err != nil {
+		stdlog.Printf("[ERROR] rotate log file %s: %v", r.path, err)
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) shift() error {
+	err := os.Remove(r.name(r.maxFiles))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove the oldest file")
+	}
+	for i := r.maxFiles - 1; i > 0; i-- {
+		err = os.Rename(r.name(i), r.name(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "rename %s", r.name(i))
+		}
+	}
+	err = os.Rename(r.path, r.name(1))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "rename %s", r.path)
+	}
+
+	return nil
+}
+
+func (r *rotatingFile) name(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+func (r *rotatingFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
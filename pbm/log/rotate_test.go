@@ -0,0 +1,71 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "pbm.log")
+
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+
+	files := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to be removed, got: %v", path, err)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pbm.log")
+
+	f, err := openRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	_, _ = f.Write([]byte("before\n"))
+	// logrotate
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := f.reopen(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	_, _ = f.Write([]byte("after\n"))
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "after\n" {
+		t.Errorf("got %q, want %q", got, "after\n")
+	}
+	got, _ = os.ReadFile(path + ".old")
+	if !strings.Contains(string(got), "before") || strings.Contains(string(got), "after") {
+		t.Errorf("rotated file: got %q", got)
+	}
+}
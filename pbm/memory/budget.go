@@ -0,0 +1,182 @@
+// Package memory accounts the large buffers of the agent (compression,
+// storage uploads, restore read-ahead and insertion batches) against
+// the memory budget `agent.maxMemoryMB`.
+//
+// The consumers reserve their buffers in units (a compression block,
+// an upload part) and run with as many units as the budget gives them.
+// When the budget is short, the consumer degrades down to its minimum
+// concurrency. The limit is never exceeded: the reservation waits for
+// the minimum to be released by the others, and fails if it isn't
+// released in time (see ErrNoMemory).
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrNoMemory is returned by Reserve if the minimum of the reservation
+// doesn't fit into the budget.
+var ErrNoMemory = errors.New("memory budget is used up")
+
+// reserveWait is how long Reserve waits for the minimum to be released
+const reserveWait = 30 * time.Second
+
+// Default is the budget of the agent process.
+var Default = &Budget{}
+
+// Budget is the accounting semaphore of the memory. The zero value
+// is unlimited.
+type Budget struct {
+	mx    sync.Mutex
+	limit int64
+	used  int64
+	peak  int64
+	// degraded is the number of reservations granted fewer units
+	// than requested
+	degraded int64
+	// freed is closed on release to wake up the waiting reservations
+	freed chan struct{}
+	// wait is reserveWait if not set
+	wait time.Duration
+}
+
+// Usage is the state of the budget.
+type Usage struct {
+	// LimitBytes is 0 if the budget is unlimited.
+	LimitBytes int64 `json:"limitBytes"`
+	UsedBytes  int64 `json:"usedBytes"`
+	PeakBytes  int64 `json:"peakBytes"`
+	Degraded   int64 `json:"degraded"`
+}
+
+// SetLimit sets the budget size in bytes. Not positive n makes it unlimited.
+// The reservations made before aren't changed, the waiting ones are retried.
+func (b *Budget) SetLimit(n int64) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.limit = max(n, 0)
+	b.wake()
+}
+
+// Usage returns the current state of the budget.
+func (b *Budget) Usage() Usage {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return Usage{
+		LimitBytes: b.limit,
+		UsedBytes:  b.used,
+		PeakBytes:  b.peak,
+		Degraded:   b.degraded,
+	}
+}
+
+// Reserve grants the most units of the unit bytes each, from minUnits to
+// maxUnits, that fit into the free budget. If the minUnits don't fit,
+// it waits for the other grants to be released and returns ErrNoMemory
+// if they aren't released in time or the minUnits exceed the whole limit.
+// The caller has to Release the grant when the buffers are freed.
+func (b *Budget) Reserve(unit int64, minUnits, maxUnits int) (*Grant, error) {
+	maxUnits = max(maxUnits, minUnits)
+	g := &Grant{b: b, units: maxUnits}
+	if unit <= 0 || maxUnits == 0 {
+		return g, nil
+	}
+
+	var timeout <-chan time.Time
+	for {
+		b.mx.Lock()
+		if b.limit > 0 && int64(minUnits)*unit > b.limit {
+			b.mx.Unlock()
+			return nil, errors.Wrapf(ErrNoMemory, "%d x %d bytes exceed the limit of %d bytes",
+				minUnits, unit, b.limit)
+		}
+
+		fit := int64(maxUnits)
+		if b.limit > 0 {
+			// the lowered limit may be below the used
+			fit = max(min((b.limit-b.used)/unit, fit), 0)
+		}
+		if fit >= int64(minUnits) {
+			g.units = int(fit)
+			if g.units < maxUnits {
+				b.degraded++
+			}
+			g.bytes = int64(g.units) * unit
+			b.used += g.bytes
+			b.peak = max(b.peak, b.used)
+			b.mx.Unlock()
+			return g, nil
+		}
+
+		if b.freed == nil {
+			b.freed = make(chan struct{})
+		}
+		freed := b.freed
+		wait := b.wait
+		b.mx.Unlock()
+
+		if timeout == nil {
+			if wait <= 0 {
+				wait = reserveWait
+			}
+			t := time.NewTimer(wait)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-freed:
+		case <-timeout:
+			return nil, errors.Wrapf(ErrNoMemory, "no %d x %d bytes released in %v", minUnits, unit, wait)
+		}
+	}
+}
+
+func (b *Budget) release(n int64) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.used -= n
+	b.wake()
+}
+
+// wake wakes up the waiting reservations. b.mx has to be held.
+func (b *Budget) wake() {
+	if b.freed != nil {
+		close(b.freed)
+		b.freed = nil
+	}
+}
+
+// Grant is the memory reserved from the budget.
+type Grant struct {
+	b     *Budget
+	units int
+	bytes int64
+	once  sync.Once
+}
+
+// Units returns the number of the granted units.
+func (g *Grant) Units() int {
+	if g == nil {
+		return 0
+	}
+	return g.units
+}
+
+// Release returns the granted memory to the budget.
+// It is safe to call Release more than once.
+func (g *Grant) Release() {
+	if g == nil {
+		return
+	}
+	g.once.Do(func() {
+		if g.bytes != 0 {
+			g.b.release(g.bytes)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package memory
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestReserve(t *testing.T) {
+	b := &Budget{}
+
+	g, err := b.Reserve(1<<20, 1, 8)
+	if err != nil || g.Units() != 8 {
+		t.Errorf("unlimited: got %d units, %v", g.Units(), err)
+	}
+	g.Release()
+
+	b.SetLimit(10 << 20)
+	b.wait = 10 * time.Millisecond
+	g1, _ := b.Reserve(1<<20, 1, 8)
+	g2, _ := b.Reserve(1<<20, 1, 8)
+	if g1.Units() != 8 || g2.Units() != 2 {
+		t.Errorf("got %d, %d units", g1.Units(), g2.Units())
+	}
+	// the minimum isn't granted over the limit
+	if _, err := b.Reserve(1<<20, 1, 8); !errors.Is(err, ErrNoMemory) {
+		t.Errorf("over the limit: expected ErrNoMemory, got %v", err)
+	}
+	if _, err := b.Reserve(1<<20, 11, 11); !errors.Is(err, ErrNoMemory) {
+		t.Errorf("minimum over the whole limit: expected ErrNoMemory, got %v", err)
+	}
+	if u := b.Usage(); u.UsedBytes != 10<<20 || u.Degraded != 1 {
+		t.Errorf("usage: got %+v", u)
+	}
+
+	if g, err := b.Reserve(1<<20, 0, 4); err != nil || g.Units() != 0 {
+		t.Errorf("optional units over the limit: got %d, %v", g.Units(), err)
+	}
+
+	// the reservation waits for the release
+	b.wait = time.Minute
+	time.AfterFunc(10*time.Millisecond, g2.Release)
+	g3, err := b.Reserve(1<<20, 1, 8)
+	if err != nil || g3.Units() != 2 {
+		t.Errorf("after release: got %d units, %v", g3.Units(), err)
+	}
+
+	g1.Release()
+	g1.Release()
+	g2.Release()
+	g3.Release()
+	if u := b.Usage(); u.UsedBytes != 0 || u.PeakBytes != 10<<20 || u.LimitBytes != 10<<20 {
+		t.Errorf("usage after release: got %+v", u)
+	}
+
+	var nilGrant *Grant
+	nilGrant.Release()
+	if nilGrant.Units() != 0 {
+		t.Error("nil grant has units")
+	}
+}
+
+// TestBudgetWorkload runs the consumers which would take 6x of the limit
+// at their full concurrency. With the budget they degrade and the buffers
+// held at once stay within the limit.
+func TestBudgetWorkload(t *testing.T) {
+	const (
+		unit      = 1 << 20
+		consumers = 16
+		maxUnits  = 8
+		limit     = 20 << 20
+	)
+
+	run := func(b *Budget) (int64, int64) {
+		var held, peak atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < consumers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					g, err := b.Reserve(unit, 1, maxUnits)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					// the buffers of the granted units
+					n := held.Add(int64(g.Units()) * unit)
+					for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+					}
+					time.Sleep(time.Millisecond)
+					held.Add(-int64(g.Units()) * unit)
+					g.Release()
+				}
+			}()
+		}
+		wg.Wait()
+		return peak.Load(), b.Usage().UsedBytes
+	}
+
+	unlimited, _ := run(&Budget{})
+	if unlimited <= limit {
+		t.Fatalf("the workload doesn't exceed the limit: peak %d", unlimited)
+	}
+
+	b := &Budget{}
+	b.SetLimit(limit)
+	peak, used := run(b)
+	if peak > limit {
+		t.Errorf("peak %d exceeds the limit %d", peak, limit)
+	}
+	if bp := b.Usage().PeakBytes; bp < peak {
+		t.Errorf("budget peak %d is less than the held %d", bp, peak)
+	}
+	if used != 0 {
+		t.Errorf("%d bytes aren't released", used)
+	}
+	if b.Usage().Degraded == 0 {
+		t.Error("no reservation is degraded")
+	}
+}
+
+// TestBudgetWorkloadFits checks the limit is never exceeded when
+// the minimums of the consumers fit it.
+func TestBudgetWorkloadFits(t *testing.T) {
+	b := &Budget{}
+	b.SetLimit(32 << 20)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				// the minimum is optional, the consumer runs without the
+				// extra buffers (e.g. the read-ahead) if there is no memory
+				g, _ := b.Reserve(1<<20, 0, 16)
+				time.Sleep(100 * time.Microsecond)
+				g.Release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if u := b.Usage(); u.PeakBytes > 32<<20 || u.UsedBytes != 0 {
+		t.Errorf("usage: got %+v", u)
+	}
+}
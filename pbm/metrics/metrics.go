@@ -0,0 +1,269 @@
+// Package metrics provides metrics of PBM agents in the Prometheus
+// text exposition format.
+//
+// Metric names and labels are a public interface (dashboards and alerts
+// rely on them) and must not change. Per-agent metrics are declared here.
+// Cluster-wide metrics are computed by the cluster leader agent on scrape.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+)
+
+type metricType string
+
+const (
+	counterType metricType = "counter"
+	gaugeType   metricType = "gauge"
+)
+
+// Vec is a metric with a fixed set of labels.
+type Vec struct {
+	name   string
+	help   string
+	typ    metricType
+	labels []string
+
+	mx      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	values []string
+	v      float64
+}
+
+// Add adds val to the series with given label values.
+// Label values are expected in the order of the Vec labels.
+func (v *Vec) Add(val float64, lvs ...string) {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	v.get(lvs).v += val
+}
+
+// Inc increments the series with given label values.
+func (v *Vec) Inc(lvs ...string) {
+	v.Add(1, lvs...)
+}
+
+// Set sets the value of the series with given label values.
+func (v *Vec) Set(val float64, lvs ...string) {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	v.get(lvs).v = val
+}
+
+// Value returns the value of the series with given label values.
+func (v *Vec) Value(lvs ...string) float64 {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	s, ok := v.samples[strings.Join(lvs, "\xff")]
+	if !ok {
+		return 0
+	}
+	return s.v
+}
+
+// Series is a snapshot of the metric series.
+type Series struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Series returns the current series sorted by label values.
+func (v *Vec) Series() []Series {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rv := make([]Series, 0, len(keys))
+	for _, k := range keys {
+		s := v.samples[k]
+		ls := make(map[string]string, len(v.labels))
+		for i, l := range v.labels {
+			ls[l] = s.values[i]
+		}
+		rv = append(rv, Series{Labels: ls, Value: s.v})
+	}
+
+	return rv
+}
+
+func (v *Vec) get(lvs []string) *sample {
+	if len(lvs) != len(v.labels) {
+		panic("metrics: " + v.name + ": expected " + strconv.Itoa(len(v.labels)) +
+			" label values, got " + strconv.Itoa(len(lvs)))
+	}
+
+	k := strings.Join(lvs, "\xff")
+	s, ok := v.samples[k]
+	if !ok {
+		s = &sample{values: append([]string(nil), lvs...)}
+		v.samples[k] = s
+	}
+	return s
+}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mx   sync.Mutex
+	vecs []*Vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a counter. Its name should have the _total suffix.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Vec {
+	return r.add(name, help, counterType, labels)
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Vec {
+	return r.add(name, help, gaugeType, labels)
+}
+
+func (r *Registry) add(name, help string, typ metricType, labels []string) *Vec {
+	v := &Vec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, e := range r.vecs {
+		if e.name == name {
+			panic("metrics: duplicated metric " + name)
+		}
+	}
+	r.vecs = append(r.vecs, v)
+
+	return v
+}
+
+// Write writes all metrics in the Prometheus text format. Metrics are
+// sorted by name and series by label values. Metrics without series are
+// omitted.
+func (r *Registry) Write(w io.Writer) error {
+	r.mx.Lock()
+	vecs := append([]*Vec(nil), r.vecs...)
+	r.mx.Unlock()
+
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, v := range vecs {
+		v.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+func (v *Vec) write(w *bufio.Writer) {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	if len(v.samples) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w.WriteString("# HELP " + v.name + " " + escapeHelp(v.help) + "\n")
+	w.WriteString("# TYPE " + v.name + " " + string(v.typ) + "\n")
+	for _, k := range keys {
+		s := v.samples[k]
+
+		w.WriteString(v.name)
+		if len(v.labels) != 0 {
+			w.WriteByte('{')
+			for i, l := range v.labels {
+				if i != 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(l + `="` + escapeLabel(s.values[i]) + `"`)
+			}
+			w.WriteByte('}')
+		}
+		w.WriteString(" " + strconv.FormatFloat(s.v, 'g', -1, 64) + "\n")
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Default is the registry of the per-agent metrics.
+var Default = NewRegistry()
+
+var (
+	// StorageBytes is the number of bytes transferred to (op="upload")
+	// and from (op="download") the storage by the agent.
+	StorageBytes = Default.NewCounter("pbm_storage_bytes_total",
+		"Bytes transferred to/from the storage", "storage", "op")
+
+	// StorageErrors is the number of failed storage operations by the agent.
+	// op is one of save, read, stat, list, delete, copy.
+	// class is one of not_exist, empty, timeout, canceled, other.
+	StorageErrors = Default.NewCounter("pbm_storage_errors_total",
+		"Failed storage operations", "storage", "op", "class")
+
+	// LockAcquireFailures is the number of times the agent couldn't acquire
+	// a lock for the operation because of another operation (reason="concurrent"),
+	// the same operation has been already run (reason="duplicated")
+	// or an error (reason="error"). Losing the race to another agent of
+	// the same replset for the same operation isn't counted.
+	LockAcquireFailures = Default.NewCounter("pbm_lock_acquire_failures_total",
+		"Failed lock acquisitions", "operation", "reason")
+
+	// MemoryBytes is the memory of the agent buffers accounted by the budget
+	// `agent.maxMemoryMB`. state is one of used, peak, limit (0 if unlimited).
+	MemoryBytes = Default.NewGauge("pbm_agent_memory_bytes",
+		"Memory of the agent buffers", "state")
+
+	// MemoryDegraded is the number of times the buffers got less concurrency
+	// than requested because of the memory budget.
+	MemoryDegraded = Default.NewCounter("pbm_agent_memory_degraded_total",
+		"Buffer reservations degraded by the memory budget")
+)
+
+// SetMemory sets the memory metrics to the usage of the budget.
+func SetMemory(u memory.Usage) {
+	MemoryBytes.Set(float64(u.UsedBytes), "used")
+	MemoryBytes.Set(float64(u.PeakBytes), "peak")
+	MemoryBytes.Set(float64(u.LimitBytes), "limit")
+	MemoryDegraded.Set(float64(u.Degraded))
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_ops_total", "Test ops\nsecond line", "op", "class")
+	g := r.NewGauge("test_lag_seconds", "Test lag", "rs")
+	r.NewGauge("test_unused", "No series", "rs")
+	n := r.NewGauge("test_up", "No labels")
+
+	c.Inc("save", "other")
+	c.Add(2, "read", "not_exist")
+	c.Inc("save", "other")
+	g.Set(1.5, `rs"0\`)
+	n.Set(1)
+
+	buf := &bytes.Buffer{}
+	if err := r.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_lag_seconds Test lag
+# TYPE test_lag_seconds gauge
+test_lag_seconds{rs="rs\"0\\"} 1.5
+# HELP test_ops_total Test ops\nsecond line
+# TYPE test_ops_total counter
+test_ops_total{op="read",class="not_exist"} 2
+test_ops_total{op="save",class="other"} 2
+# HELP test_up No labels
+# TYPE test_up gauge
+test_up 1
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRegistryLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on wrong label values count")
+		}
+	}()
+	c.Inc("x")
+}
+
+func TestVecSeries(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test", "storage", "op")
+	c.Add(10, "s3", "upload")
+	c.Add(5, "fs", "download")
+
+	got := c.Series()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 series, got %+v", got)
+	}
+	if got[0].Labels["storage"] != "fs" || got[0].Labels["op"] != "download" || got[0].Value != 5 {
+		t.Errorf("unexpected first series: %+v", got[0])
+	}
+	if got[1].Labels["storage"] != "s3" || got[1].Value != 10 {
+		t.Errorf("unexpected second series: %+v", got[1])
+	}
+}
+
+func TestMeteredStorage(t *testing.T) {
+	fsStg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stg := Storage(fsStg)
+	if _, ok := storage.Unwrap(stg).(*fs.FS); !ok {
+		t.Errorf("unwrap: got %T", storage.Unwrap(stg))
+	}
+	if _, ok := stg.(storage.UploadsLister); !ok {
+		t.Error("uploads lister is lost")
+	}
+	if Storage(stg) != stg {
+		t.Error("storage is wrapped twice")
+	}
+
+	typ := string(storage.Filesystem)
+	upload := StorageBytes.Value(typ, "upload")
+	download := StorageBytes.Value(typ, "download")
+	notExist := StorageErrors.Value(typ, "stat", "not_exist")
+
+	data := strings.Repeat("x", 1000)
+	if err := stg.Save("file", strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := stg.SourceReader("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if _, err := stg.FileStat("missing"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if d := StorageBytes.Value(typ, "upload") - upload; d != 1000 {
+		t.Errorf("uploaded bytes: %v", d)
+	}
+	if d := StorageBytes.Value(typ, "download") - download; d != 1000 {
+		t.Errorf("downloaded bytes: %v", d)
+	}
+	if d := StorageErrors.Value(typ, "stat", "not_exist") - notExist; d != 1 {
+		t.Errorf("stat errors: %v", d)
+	}
+}
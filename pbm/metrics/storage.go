@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// Storage wraps the storage to count transferred bytes and errors.
+// Use storage.Unwrap to get the original storage.
+func Storage(stg storage.Storage) storage.Storage {
+	if stg == nil {
+		return nil
+	}
+	switch stg.(type) {
+	case *meteredStorage, *meteredUploadsStorage:
+		return stg
+	}
+
+	s := &meteredStorage{stg: stg}
+	if ul, ok := stg.(storage.UploadsLister); ok {
+		return &meteredUploadsStorage{meteredStorage: s, ul: ul}
+	}
+	return s
+}
+
+type meteredStorage struct {
+	stg storage.Storage
+}
+
+func (s *meteredStorage) Unwrap() storage.Storage {
+	return s.stg
+}
+
+func (s *meteredStorage) Type() storage.Type {
+	return s.stg.Type()
+}
+
+func (s *meteredStorage) Save(name string, data io.Reader, size int64) error {
+	r := &countingReader{r: data, stg: s.stg.Type(), op: "upload"}
+	err := s.stg.Save(name, r, size)
+	return s.check("save", err)
+}
+
+func (s *meteredStorage) SourceReader(name string) (io.ReadCloser, error) {
+	rc, err := s.stg.SourceReader(name)
+	if err != nil {
+		return nil, s.check("read", err)
+	}
+
+	return &countingReadCloser{
+		countingReader: countingReader{r: rc, stg: s.stg.Type(), op: "download"},
+		c:              rc,
+	}, nil
+}
+
+func (s *meteredStorage) FileStat(name string) (storage.FileInfo, error) {
+	fi, err := s.stg.FileStat(name)
+	return fi, s.check("stat", err)
+}
+
+func (s *meteredStorage) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	files, err := s.stg.List(prefix, suffix)
+	return files, s.check("list", err)
+}
+
+func (s *meteredStorage) Delete(name string) error {
+	return s.check("delete", s.stg.Delete(name))
+}
+
+func (s *meteredStorage) Copy(src, dst string) error {
+	return s.check("copy", s.stg.Copy(src, dst))
+}
+
+func (s *meteredStorage) check(op string, err error) error {
+	if err != nil {
+		StorageErrors.Inc(string(s.stg.Type()), op, errorClass(err))
+	}
+	return err
+}
+
+type meteredUploadsStorage struct {
+	*meteredStorage
+	ul storage.UploadsLister
+}
+
+func (s *meteredUploadsStorage) ListUploads(prefix string) ([]storage.IncompleteUpload, error) {
+	u, err := s.ul.ListUploads(prefix)
+	return u, s.check("list", err)
+}
+
+func (s *meteredUploadsStorage) AbortUpload(u storage.IncompleteUpload) error {
+	return s.check("delete", s.ul.AbortUpload(u))
+}
+
+type countingReader struct {
+	r   io.Reader
+	stg storage.Type
+	op  string
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		StorageBytes.Add(float64(n), string(r.stg), r.op)
+	}
+	if err != nil && !errors.Is(err, io.EOF) && r.op == "download" {
+		StorageErrors.Inc(string(r.stg), "read", errorClass(err))
+	}
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	c io.Closer
+}
+
+func (r *countingReadCloser) Close() error {
+	return r.c.Close()
+}
+
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+		return "not_exist"
+	case errors.Is(err, storage.ErrEmpty):
+		return "empty"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, storage.ErrTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
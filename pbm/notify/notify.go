@@ -0,0 +1,269 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/secret"
+)
+
+// Event is a lifecycle event of an operation.
+type Event string
+
+const (
+	BackupStarted     Event = "backup.started"
+	BackupFinished    Event = "backup.finished"
+	BackupFailed      Event = "backup.failed"
+	RestoreFinished   Event = "restore.finished"
+	RestoreFailed     Event = "restore.failed"
+	PITRGapOpened     Event = "pitr.gap.opened"
+	PITRGapClosed     Event = "pitr.gap.closed"
+	RetentionFinished Event = "retention.finished"
+)
+
+// Events is the list of all events which can be sent.
+var Events = []Event{
+	BackupStarted,
+	BackupFinished,
+	BackupFailed,
+	RestoreFinished,
+	RestoreFailed,
+	PITRGapOpened,
+	PITRGapClosed,
+	RetentionFinished,
+}
+
+const (
+	// SignatureHeader keeps `sha256=<hex>` HMAC of the request body.
+	// It's set only if the webhook has a secret.
+	SignatureHeader = "X-PBM-Signature"
+	// EventHeader keeps the event name.
+	EventHeader = "X-PBM-Event"
+
+	defaultTimeout = 10 * time.Second
+	maxAttempts    = 3
+)
+
+// retryDelay is the pause before the next delivery attempt.
+// It grows with every attempt.
+var retryDelay = 5 * time.Second
+
+// Config is the notifications config.
+type Config struct {
+	Webhooks []Webhook `bson:"webhooks,omitempty" json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+}
+
+// Webhook is an HTTP endpoint the events are POSTed to.
+//
+// URL and Secret may refer to environment variables or files of pbm-agent
+// (e.g. `${PBM_WEBHOOK_SECRET}` or `file:///etc/pbm/webhook-secret`, see package secret).
+// They are resolved before sending.
+type Webhook struct {
+	URL string `bson:"url" json:"url" yaml:"url"`
+	// Events to send. All events are sent if empty.
+	Events []Event `bson:"events,omitempty" json:"events,omitempty" yaml:"events,omitempty"`
+	// Secret is a key of the request signature (see SignatureHeader).
+	Secret     string `bson:"secret,omitempty" json:"secret,omitempty" yaml:"secret,omitempty"`
+	TimeoutSec int    `bson:"timeoutSec,omitempty" json:"timeoutSec,omitempty" yaml:"timeoutSec,omitempty"`
+}
+
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+
+	rv := &Config{}
+	if c.Webhooks != nil {
+		rv.Webhooks = make([]Webhook, len(c.Webhooks))
+		for i, w := range c.Webhooks {
+			w.Events = slices.Clone(w.Events)
+			rv.Webhooks[i] = w
+		}
+	}
+
+	return rv
+}
+
+// Validate returns errors of the webhooks options.
+func (c *Config) Validate() []error {
+	if c == nil {
+		return nil
+	}
+
+	var errs []error
+	for i, w := range c.Webhooks {
+		switch {
+		case w.URL == "":
+			errs = append(errs, errors.Errorf("notifications.webhooks[%d].url: required", i))
+		case secret.IsRef(w.URL):
+			// references are resolved by the agent
+			if err := secret.Check(w.URL); err != nil {
+				errs = append(errs, errors.Wrapf(err, "notifications.webhooks[%d].url", i))
+			}
+		default:
+			u, err := url.Parse(w.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, errors.Errorf("notifications.webhooks[%d].url: expected http(s) URL", i))
+			}
+		}
+		for _, e := range w.Events {
+			if !slices.Contains(Events, e) {
+				errs = append(errs, errors.Errorf("notifications.webhooks[%d].events: unknown event %q", i, e))
+			}
+		}
+		if err := secret.Check(w.Secret); err != nil {
+			errs = append(errs, errors.Wrapf(err, "notifications.webhooks[%d].secret", i))
+		}
+		if w.TimeoutSec < 0 {
+			errs = append(errs, errors.Errorf("notifications.webhooks[%d].timeoutSec: cannot be negative", i))
+		}
+	}
+
+	return errs
+}
+
+// Match returns true if the event should be sent to the webhook.
+func (w *Webhook) Match(e Event) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, e)
+}
+
+func (w *Webhook) timeout() time.Duration {
+	if w.TimeoutSec <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(w.TimeoutSec) * time.Second
+}
+
+// Payload is the JSON body of the request.
+type Payload struct {
+	Event Event `json:"event"`
+	// Time is when the event happened (unix seconds).
+	Time    int64  `json:"time"`
+	Replset string `json:"replset,omitempty"`
+	Node    string `json:"node,omitempty"`
+
+	// Name is the operation (backup, restore) name.
+	Name   string `json:"name,omitempty"`
+	Type   string `json:"type,omitempty"`
+	OPID   string `json:"opid,omitempty"`
+	Backup string `json:"backup,omitempty"`
+
+	// Start and End of the operation or PITR gap (unix seconds).
+	Start       int64 `json:"start,omitempty"`
+	End         int64 `json:"end,omitempty"`
+	DurationSec int64 `json:"durationSec,omitempty"`
+
+	// Size is the size of the backup on the storage.
+	Size int64 `json:"size,omitempty"`
+	// SizeUncompressed is the size of dumped documents (logical backups only).
+	SizeUncompressed int64 `json:"sizeUncompressed,omitempty"`
+
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Sign returns `sha256=<hex>` HMAC of the body.
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Send POSTs the payload to every webhook subscribed to its event.
+// Failed deliveries are retried. If all attempts fail, the payload is
+// logged as an error, so it isn't lost.
+func Send(ctx context.Context, hooks []Webhook, p *Payload, l log.LogEvent) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		l.Error("encode %s payload: %v", p.Event, err)
+		return
+	}
+
+	for i := range hooks {
+		w := &hooks[i]
+		if !w.Match(p.Event) {
+			continue
+		}
+
+		err := deliver(ctx, w, p.Event, body)
+		if err != nil {
+			l.Error("webhook #%d: undelivered %s after %d attempts: %v. payload: %s",
+				i, p.Event, maxAttempts, err, body)
+		}
+	}
+}
+
+func deliver(ctx context.Context, w *Webhook, e Event, body []byte) error {
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(retryDelay * time.Duration(i)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var retry bool
+		retry, err = post(ctx, w, e, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// post sends the request once. It returns true if the request
+// may succeed on retry.
+func post(ctx context.Context, w *Webhook, e Event, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout())
+	defer cancel()
+
+	u, err := secret.Resolve(w.URL)
+	if err != nil {
+		return false, errors.Wrap(err, "url")
+	}
+	key, err := secret.Resolve(w.Secret)
+	if err != nil {
+		return false, errors.Wrap(err, "secret")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(e))
+	if key != "" {
+		req.Header.Set(SignatureHeader, Sign(key, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// don't leak expanded URL (it may have a token)
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return true, errors.Wrap(err, "send")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, errors.Errorf("unexpected status %s", resp.Status)
+}
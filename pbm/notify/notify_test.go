@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+type hookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fails    int // number of requests to fail with 503
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newHookServer(t *testing.T, fails int) *hookServer {
+	t.Helper()
+
+	s := &hookServer{fails: fails}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if s.fails > 0 {
+			s.fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestSend(t *testing.T) {
+	retryDelay = time.Millisecond
+
+	t.Setenv("PBM_TEST_HOOK_SECRET", "s3cr3t")
+	srv := newHookServer(t, 1)
+	other := newHookServer(t, 0)
+
+	p := &Payload{
+		Event:       BackupFailed,
+		Time:        1700000000,
+		Name:        "2024-01-01T00:00:00Z",
+		OPID:        "opid1",
+		DurationSec: 42,
+		Size:        1024,
+		Error:       "storage is unreachable",
+	}
+	Send(context.Background(), []Webhook{
+		{URL: srv.URL, Secret: "${PBM_TEST_HOOK_SECRET}"},
+		{URL: other.URL, Events: []Event{BackupFinished}},
+	}, p, log.DiscardEvent)
+
+	if len(srv.requests) != 2 {
+		t.Fatalf("expected a retry, got %d requests", len(srv.requests))
+	}
+	if len(other.requests) != 0 {
+		t.Errorf("unsubscribed webhook got %d requests", len(other.requests))
+	}
+
+	r, body := srv.requests[1], srv.bodies[1]
+	if got := r.Header.Get(EventHeader); got != string(BackupFailed) {
+		t.Errorf("event header: got %q", got)
+	}
+	if got, want := r.Header.Get(SignatureHeader), Sign("s3cr3t", body); got != want {
+		t.Errorf("signature: got %q, want %q", got, want)
+	}
+
+	var got Payload
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if got != *p {
+		t.Errorf("payload: got %+v, want %+v", got, *p)
+	}
+}
+
+func TestSendUndelivered(t *testing.T) {
+	retryDelay = time.Millisecond
+
+	srv := newHookServer(t, maxAttempts)
+	l := &recordEvent{}
+
+	Send(context.Background(), []Webhook{{URL: srv.URL}}, &Payload{Event: RestoreFailed, OPID: "opid2"}, l)
+
+	if len(srv.requests) != maxAttempts {
+		t.Errorf("expected %d attempts, got %d", maxAttempts, len(srv.requests))
+	}
+	if len(l.errors) != 1 || !strings.Contains(l.errors[0], `"opid":"opid2"`) {
+		t.Errorf("expected dead-letter entry with the payload, got %q", l.errors)
+	}
+}
+
+func TestSendNoRetryOnClientError(t *testing.T) {
+	retryDelay = time.Millisecond
+
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	l := &recordEvent{}
+	Send(context.Background(), []Webhook{{URL: srv.URL}}, &Payload{Event: BackupStarted}, l)
+
+	if n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+	if len(l.errors) != 1 {
+		t.Errorf("expected dead-letter entry, got %q", l.errors)
+	}
+}
+
+type recordEvent struct {
+	errors []string
+}
+
+func (e *recordEvent) Debug(msg string, args ...any)   {}
+func (e *recordEvent) Info(msg string, args ...any)    {}
+func (e *recordEvent) Warning(msg string, args ...any) {}
+func (e *recordEvent) Fatal(msg string, args ...any)   {}
+
+func (e *recordEvent) Error(msg string, args ...any) {
+	e.errors = append(e.errors, fmt.Sprintf(msg, args...))
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -36,8 +37,16 @@ type OplogBackup struct {
 	stopC chan struct{}
 	start primitive.Timestamp
 	end   primitive.Timestamp
+
+	exclude *ns.Matcher
+
+	retryWindow time.Duration
+	reconnect   func(context.Context) (*mongo.Client, error)
+	retryLog    func(msg string, args ...any)
 }
 
+const oplogRetryInterval = time.Second
+
 // NewOplogBackup creates a new Oplog instance
 func NewOplogBackup(m *mongo.Client) *OplogBackup {
 	return &OplogBackup{cl: m}
@@ -49,6 +58,37 @@ func (ot *OplogBackup) SetTailingSpan(start, end primitive.Timestamp) {
 	ot.end = end
 }
 
+// SetExcludeNS sets namespaces (wildcards allowed) which ops should be
+// dropped from the oplog slice. Empty list means no filtering.
+func (ot *OplogBackup) SetExcludeNS(nss []string) error {
+	if len(nss) == 0 {
+		ot.exclude = nil
+		return nil
+	}
+
+	m, err := ns.NewMatcher(nss)
+	if err != nil {
+		return errors.Wrap(err, "create namespaces matcher")
+	}
+
+	ot.exclude = m
+	return nil
+}
+
+// SetRetry makes WriteTo resume the tailing after the node failover
+// (stepdown, restart, network errors) for up to window since the last
+// read record. reconnect returns the client to resume with, the current
+// one is kept if it is nil. l logs the retries, may be nil.
+func (ot *OplogBackup) SetRetry(
+	window time.Duration,
+	reconnect func(context.Context) (*mongo.Client, error),
+	l func(msg string, args ...any),
+) {
+	ot.retryWindow = window
+	ot.reconnect = reconnect
+	ot.retryLog = l
+}
+
 type InsuffRangeError struct {
 	primitive.Timestamp
 }
@@ -60,11 +100,29 @@ func (e InsuffRangeError) Error() string {
 		e.Timestamp)
 }
 
+// ResumeGapError is returned if the oplog tailing can't be resumed
+// after the failover because the records since the last read one are
+// missing on the node.
+type ResumeGapError struct {
+	// From is the last record read before the failover
+	From primitive.Timestamp
+	// To is the first record available after the failover
+	To primitive.Timestamp
+}
+
+func (e ResumeGapError) Error() string {
+	return fmt.Sprintf("oplog records between %v and %v are missing on the node "+
+		"the tailing is resumed on (rolled off the oplog)", e.From, e.To)
+}
+
 // WriteTo writes an oplog slice between start and end timestamps into the given io.Writer
 //
 // To be sure we have read ALL records up to the specified cluster time.
 // Specifically, to be sure that no operations from the past gonna came after we finished the slicing,
 // we have to tail until some record with ts > endTS. And it might be a noop.
+//
+// If the retry is set (see SetRetry), the tailing is resumed from the last
+// read record after the node failover.
 func (ot *OplogBackup) WriteTo(w io.Writer) (int64, error) {
 	if ot.start.T == 0 || ot.end.T == 0 {
 		return 0, errors.Errorf("oplog TailingSpan should be set, have start: %v, end: %v", ot.start, ot.end)
@@ -89,10 +147,57 @@ func (ot *OplogBackup) WriteTo(w io.Writer) (int64, error) {
 		ot.mu.Unlock()
 	}()
 
+	var written int64
+	var last primitive.Timestamp
+	var failedAt time.Time
+	for {
+		prev := last
+		n, err := ot.tail(ctx, w, &last)
+		written += n
+		if err == nil || ot.retryWindow == 0 || ctx.Err() != nil || !isFailoverError(err) {
+			return written, err
+		}
+
+		if failedAt.IsZero() || last.After(prev) {
+			failedAt = time.Now()
+		}
+		if time.Since(failedAt) > ot.retryWindow {
+			return written, errors.Wrapf(err, "oplog tailing is not resumed in %v", ot.retryWindow)
+		}
+		if ot.retryLog != nil {
+			ot.retryLog("oplog tailing interrupted after %v: %v. Resuming", last, err)
+		}
+
+		select {
+		case <-time.After(oplogRetryInterval):
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+
+		if ot.reconnect != nil {
+			cl, err := ot.reconnect(ctx)
+			if err != nil {
+				if ot.retryLog != nil {
+					ot.retryLog("reconnect: %v", err)
+				}
+				continue
+			}
+			ot.cl = cl
+		}
+	}
+}
+
+// tail writes the oplog records since ot.start, or after last if it is
+// set, up to ot.end. last is set to each record read.
+func (ot *OplogBackup) tail(ctx context.Context, w io.Writer, last *primitive.Timestamp) (int64, error) {
+	resumed := !last.IsZero()
+	filter := bson.M{"ts": bson.M{"$gte": ot.start}}
+	if resumed {
+		filter = bson.M{"ts": bson.M{"$gt": *last}}
+	}
+
 	cur, err := ot.cl.Database("local").Collection("oplog.rs").Find(ctx,
-		bson.M{
-			"ts": bson.M{"$gte": ot.start},
-		},
+		filter,
 		options.Find().SetCursorType(options.Tailable),
 	)
 	if err != nil {
@@ -122,12 +227,19 @@ func (ot *OplogBackup) WriteTo(w io.Writer) (int64, error) {
 		// the first record retrieval due to ongoing write traffic (i.e. oplog append).
 		// There's a chance of false-negative though.
 		if !rcheck {
-			ok, err := ot.IsSufficient(ot.start)
-			if err != nil {
-				return 0, errors.Wrap(err, "check oplog sufficiency")
-			}
-			if !ok {
-				return 0, InsuffRangeError{ot.start}
+			if resumed {
+				err = ot.checkResumed(ctx, *last, opts)
+				if err != nil {
+					return 0, err
+				}
+			} else {
+				ok, err := ot.IsSufficient(ot.start)
+				if err != nil {
+					return 0, errors.Wrap(err, "check oplog sufficiency")
+				}
+				if !ok {
+					return 0, InsuffRangeError{ot.start}
+				}
 			}
 			rcheck = true
 		}
@@ -138,19 +250,91 @@ func (ot *OplogBackup) WriteTo(w io.Writer) (int64, error) {
 
 		// skip noop operations
 		if cur.Current.Lookup("op").String() == string(defs.OperationNoop) {
+			*last = opts
 			continue
 		}
 
-		n, err := w.Write(cur.Current)
+		rec := []byte(cur.Current)
+		if ot.exclude != nil {
+			rec, err = filterExcludedOps(ot.exclude, cur.Current)
+			if err != nil {
+				return written, errors.Wrapf(err, "filter record %v", opts)
+			}
+			if rec == nil {
+				*last = opts
+				continue
+			}
+		}
+
+		n, err := w.Write(rec)
 		if err != nil {
 			return written, errors.Wrap(err, "write to pipe")
 		}
 		written += int64(n)
+		*last = opts
 	}
 
 	return written, cur.Err()
 }
 
+// checkResumed checks that the oplog the tailing is resumed on continues
+// the one read before the failover. It has to contain the last read record.
+func (ot *OplogBackup) checkResumed(ctx context.Context, last, first primitive.Timestamp) error {
+	c, err := ot.cl.Database("local").Collection("oplog.rs").
+		CountDocuments(ctx, bson.M{"ts": last}, options.Count().SetLimit(1))
+	if err != nil {
+		return errors.Wrap(err, "check the last read record")
+	}
+	if c != 0 {
+		return nil
+	}
+
+	ok, err := ot.IsSufficient(last)
+	if err != nil {
+		return errors.Wrap(err, "check oplog sufficiency")
+	}
+	if !ok {
+		return ResumeGapError{From: last, To: first}
+	}
+
+	return errors.Errorf("the oplog has no record %v read before the failover. "+
+		"It is rolled back or the node has another oplog history", last)
+}
+
+// isFailoverError returns true if err is caused by the node stepdown,
+// restart or network issues so the tailing can be resumed.
+func isFailoverError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, code := range failoverErrorCodes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+var failoverErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	43,    // CursorNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
 func (ot *OplogBackup) Cancel() {
 	ot.mu.Lock()
 	defer ot.mu.Unlock()
@@ -178,3 +362,101 @@ func (ot *OplogBackup) IsSufficient(from primitive.Timestamp) (bool, error) {
 
 	return c != 0, nil
 }
+
+// filterExcludedOps returns nil if the whole op targets excluded namespaces.
+// For `applyOps` (including transactions), only excluded internal ops are
+// removed, so the entry stays in place with its txn metadata even if no
+// internal ops are left.
+// The record is returned as is if nothing was filtered out.
+func filterExcludedOps(m *ns.Matcher, raw bson.Raw) (bson.Raw, error) {
+	var op Record
+	err := bson.Unmarshal(raw, &op)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode op")
+	}
+
+	if isOpExcludedBy(m, &op) {
+		return nil, nil
+	}
+	if op.Operation != "c" || !isTxnOps(&op) {
+		return raw, nil
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode op")
+	}
+
+	changed, err := filterApplyOpsDoc(m, doc)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return raw, nil
+	}
+
+	b, err := bson.Marshal(doc)
+	return b, errors.Wrap(err, "encode op")
+}
+
+// filterApplyOpsDoc removes excluded ops from the `applyOps` of the given
+// oplog entry (in place). It returns true if anything was removed.
+func filterApplyOpsDoc(m *ns.Matcher, doc bson.D) (bool, error) {
+	changed := false
+	for i := range doc {
+		if doc[i].Key != "o" {
+			continue
+		}
+
+		obj, ok := doc[i].Value.(bson.D)
+		if !ok {
+			return false, nil
+		}
+		for j := range obj {
+			if obj[j].Key != "applyOps" {
+				continue
+			}
+
+			ops, ok := obj[j].Value.(bson.A)
+			if !ok {
+				return false, errors.Errorf("unknown format for applyOps: %T", obj[j].Value)
+			}
+
+			kept := make(bson.A, 0, len(ops))
+			for _, o := range ops {
+				d, ok := o.(bson.D)
+				if !ok {
+					return false, errors.Errorf("unknown format for applyOps op: %T", o)
+				}
+
+				b, err := bson.Marshal(d)
+				if err != nil {
+					return false, errors.Wrap(err, "encode applyOps op")
+				}
+				var rec Record
+				err = bson.Unmarshal(b, &rec)
+				if err != nil {
+					return false, errors.Wrap(err, "decode applyOps op")
+				}
+
+				if isOpExcludedBy(m, &rec) {
+					changed = true
+					continue
+				}
+				if rec.Operation == "c" && isTxnOps(&rec) {
+					c, err := filterApplyOpsDoc(m, d)
+					if err != nil {
+						return false, err
+					}
+					changed = changed || c
+				}
+
+				kept = append(kept, d)
+			}
+			obj[j].Value = kept
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,136 @@
+package oplog
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestFilterExcludedOps(t *testing.T) {
+	m, err := ns.NewMatcher([]string{"cache.*"})
+	if err != nil {
+		t.Fatalf("create matcher: %v", err)
+	}
+
+	testCases := []struct {
+		desc    string
+		op      bson.D
+		dropped bool
+		ns      []string // expected namespaces of applyOps
+	}{
+		{
+			desc:    "excluded insert",
+			op:      createExcludeTestOp("i", "cache.c1", bson.D{{"_id", 1}}),
+			dropped: true,
+		},
+		{
+			desc: "included insert",
+			op:   createExcludeTestOp("i", "mydb.c1", bson.D{{"_id", 1}}),
+		},
+		{
+			desc:    "excluded drop",
+			op:      createExcludeTestOp("c", "cache.$cmd", bson.D{{"drop", "c1"}}),
+			dropped: true,
+		},
+		{
+			desc: "applyOps with mixed namespaces",
+			op: createExcludeTestOp("c", "admin.$cmd", bson.D{{"applyOps", bson.A{
+				createExcludeTestOp("i", "cache.c2", bson.D{{"_id", 2}}),
+				createExcludeTestOp("i", "mydb.c2", bson.D{{"_id", 2}}),
+			}}}),
+			ns: []string{"mydb.c2"},
+		},
+		{
+			desc: "transaction with excluded namespaces only",
+			op: createExcludeTestTxn(bson.A{
+				createExcludeTestOp("i", "cache.c3", bson.D{{"_id", 3}}),
+			}),
+			ns: []string{},
+		},
+		{
+			desc: "transaction with mixed namespaces",
+			op: createExcludeTestTxn(bson.A{
+				createExcludeTestOp("i", "mydb.c3", bson.D{{"_id", 3}}),
+				createExcludeTestOp("u", "cache.c3", bson.D{{"$set", bson.D{{"a", 1}}}}),
+				createExcludeTestOp("d", "mydb.c4", bson.D{{"_id", 4}}),
+			}),
+			ns: []string{"mydb.c3", "mydb.c4"},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			raw, err := bson.Marshal(tC.op)
+			if err != nil {
+				t.Fatalf("marshal op: %v", err)
+			}
+
+			got, err := filterExcludedOps(m, raw)
+			if err != nil {
+				t.Fatalf("filter: %v", err)
+			}
+			if tC.dropped {
+				if got != nil {
+					t.Errorf("expected op to be dropped, got %v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected op to be kept")
+			}
+
+			var rec Record
+			if err := bson.Unmarshal(got, &rec); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if tC.ns == nil {
+				return
+			}
+			if _, ok := bson.Raw(raw).Lookup("lsid").DocumentOK(); ok && (rec.LSID == nil || rec.TxnNumber == nil) {
+				t.Error("txn metadata is lost")
+			}
+
+			ops, _ := rec.Object[0].Value.(bson.A)
+			if len(ops) != len(tC.ns) {
+				t.Fatalf("wrong number of applyOps, want=%d, got=%d", len(tC.ns), len(ops))
+			}
+			for i, o := range ops {
+				var n string
+				for _, e := range o.(bson.D) {
+					if e.Key == "ns" {
+						n, _ = e.Value.(string)
+					}
+				}
+				if n != tC.ns[i] {
+					t.Errorf("wrong #%d. namespace: want=%s, got=%s", i, tC.ns[i], n)
+				}
+			}
+		})
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	testCases := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{"not writable primary", mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}, true},
+		{"interrupted at shutdown", mongo.CommandError{Code: 11600}, true},
+		{"wrapped", errors.Wrap(mongo.CommandError{Code: 189}, "next"), true},
+		{"duplicate key", mongo.CommandError{Code: 11000}, false},
+		{"plain", errors.New("decode"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := isFailoverError(tc.err); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -28,6 +28,17 @@ type OplogChunk struct {
 	StartTS     primitive.Timestamp      `bson:"start_ts"`
 	EndTS       primitive.Timestamp      `bson:"end_ts"`
 	Size        int64                    `bson:"size"`
+
+	// SpanMin is the oplog slicing span (in minutes) the chunk was made with.
+	SpanMin float64 `bson:"span_min,omitempty"`
+
+	// ExcludedNS is the list of namespaces filtered out by the slicer
+	// (`pitr.filterAtCapture`). Empty if the chunk has the full oplog.
+	ExcludedNS []string `bson:"excluded_ns,omitempty"`
+
+	// Storage is the config profile name of the storage with the chunk
+	// (see `replsets.<rs>.storage` option). Empty for the main storage.
+	Storage string `bson:"storage,omitempty"`
 }
 
 // PITRLastChunkMeta returns the most recent PITR chunk for the given Replset
@@ -143,6 +154,37 @@ func pitrGetChunksSlice(ctx context.Context, m connect.Client, q bson.D) ([]Oplo
 	return chnks, cur.Err()
 }
 
+// ChunksStat is a summary of the replset chunks.
+// StartTS is the start of the oldest chunk among counted ones.
+type ChunksStat struct {
+	RS      string              `bson:"_id"`
+	Count   int64               `bson:"count"`
+	Size    int64               `bson:"size"`
+	StartTS primitive.Timestamp `bson:"start_ts"`
+}
+
+// PITRChunksStats returns per replset summary of chunks that end after
+// the given time.
+func PITRChunksStats(ctx context.Context, m connect.Client, since primitive.Timestamp) ([]ChunksStat, error) {
+	cur, err := m.PITRChunksCollection().Aggregate(ctx, mongo.Pipeline{
+		{{"$match", bson.D{{"end_ts", bson.D{{"$gt", since}}}}}},
+		{{"$group", bson.D{
+			{"_id", "$rs"},
+			{"count", bson.D{{"$sum", 1}}},
+			{"size", bson.D{{"$sum", "$size"}}},
+			{"start_ts", bson.D{{"$min", "$start_ts"}}},
+		}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	rv := []ChunksStat{}
+	err = cur.All(ctx, &rv)
+	return rv, errors.Wrap(err, "decode")
+}
+
 // PITRGetChunkStarts returns a pitr slice chunk that belongs to the
 // given replica set and start from the given timestamp
 func PITRGetChunkStarts(
@@ -174,6 +216,15 @@ func PITRAddChunk(ctx context.Context, m connect.Client, c OplogChunk) error {
 	return err
 }
 
+// PITRSetChunkStorage sets the storage profile of the chunk
+func PITRSetChunkStorage(ctx context.Context, m connect.Client, c *OplogChunk, storage string) error {
+	_, err := m.PITRChunksCollection().UpdateOne(ctx,
+		bson.D{{"rs", c.RS}, {"start_ts", c.StartTS}, {"fname", c.FName}},
+		bson.D{{"$set", bson.M{"storage": storage}}})
+
+	return err
+}
+
 // PITRGetValidTimelines returns time ranges valid for PITR restore
 // for the given replicaset. We don't check for any "restore intrusions"
 // or other integrity issues since it's guaranteed be the slicer that
@@ -0,0 +1,336 @@
+package oplog
+
+import (
+	"context"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// CompactOptions defines which chunks are merged and into what.
+type CompactOptions struct {
+	// Before is the time only chunks ended before are compacted.
+	Before primitive.Timestamp
+	// MaxSize is the max size of the merged chunk. No limit if 0.
+	MaxSize int64
+	// MaxSpan is the max time range of the merged chunk. No limit if 0.
+	MaxSpan time.Duration
+
+	Compression compress.CompressionType
+	Level       *int
+
+	// Storage is the config profile name of the storage the chunks are
+	// compacted on (empty for the main storage). Chunks on other storages
+	// are skipped.
+	Storage string
+}
+
+// CompactStat is the summary of the replset chunks compaction.
+type CompactStat struct {
+	RS      string
+	Merged  int
+	Created int
+	Removed int
+}
+
+// CompactGroups splits the replset chunks (sorted by start_ts) into groups
+// of contiguous chunks to merge. Chunks with different excluded namespaces
+// are never merged. Groups of a single chunk are omitted.
+func CompactGroups(chunks []OplogChunk, o CompactOptions) [][]OplogChunk {
+	var rv [][]OplogChunk
+	var grp []OplogChunk
+	var size int64
+
+	flush := func() {
+		if len(grp) > 1 {
+			rv = append(rv, grp)
+		}
+		grp, size = nil, 0
+	}
+
+	for _, c := range chunks {
+		if !c.EndTS.Before(o.Before) {
+			break
+		}
+
+		if len(grp) != 0 {
+			first, last := grp[0], grp[len(grp)-1]
+			if c.StartTS.After(last.EndTS) ||
+				!slices.Equal(c.ExcludedNS, last.ExcludedNS) ||
+				(o.MaxSize > 0 && size+c.Size > o.MaxSize) ||
+				(o.MaxSpan > 0 && time.Duration(c.EndTS.T-first.StartTS.T)*time.Second > o.MaxSpan) {
+				flush()
+			}
+		}
+
+		grp = append(grp, c)
+		size += c.Size
+	}
+	flush()
+
+	return rv
+}
+
+// CompactChunks merges contiguous replset chunks older than `o.Before`
+// into bigger ones.
+//
+// The merged chunk is uploaded and verified first. Then its metadata is
+// added and only after that the metadata and files of the merged chunks
+// are removed. So the time range stays restorable at any moment and
+// a restore may meet both the merged chunk and the originals (overlapping
+// ops are skipped during the replay).
+// If compaction was interrupted, chunks covered by another chunk are
+// removed on the next run.
+func CompactChunks(
+	ctx context.Context,
+	m connect.Client,
+	stg storage.Storage,
+	rs string,
+	o CompactOptions,
+) (*CompactStat, error) {
+	l := log.LogEventFromContext(ctx)
+	stat := &CompactStat{RS: rs}
+
+	chunks, err := PITRGetChunksSliceUntil(ctx, m, rs, o.Before)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks")
+	}
+	chunks = slices.DeleteFunc(chunks, func(c OplogChunk) bool { return c.Storage != o.Storage })
+
+	chunks, covered := splitCoveredChunks(chunks)
+	if len(covered) != 0 {
+		l.Info("%s: remove %d chunks left by the interrupted compaction", rs, len(covered))
+		err = removeChunks(ctx, m, stg, covered)
+		if err != nil {
+			return nil, errors.Wrap(err, "remove covered chunks")
+		}
+		stat.Removed += len(covered)
+	}
+
+	for _, grp := range CompactGroups(chunks, o) {
+		first, last := grp[0], grp[len(grp)-1]
+		l.Info("%s: merge %d chunks [%s - %s]",
+			rs, len(grp), fmtCompactTS(first.StartTS), fmtCompactTS(last.EndTS))
+
+		c, err := mergeChunks(ctx, stg, grp, o.Compression, o.Level)
+		if err != nil {
+			return nil, errors.Wrapf(err, "merge chunks %v.%v", first.StartTS.T, last.EndTS.T)
+		}
+
+		err = VerifyChunk(stg, c)
+		if err != nil {
+			if derr := stg.Delete(c.FName); derr != nil {
+				l.Error("remove %s: %v", c.FName, derr)
+			}
+			return nil, errors.Wrapf(err, "verify merged chunk %s", c.FName)
+		}
+
+		err = PITRAddChunk(ctx, m, *c)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, errors.Wrapf(err, "save merged chunk meta %s", c.FName)
+		}
+
+		err = removeChunks(ctx, m, stg, grp)
+		if err != nil {
+			return nil, errors.Wrap(err, "remove merged chunks")
+		}
+
+		stat.Merged += len(grp)
+		stat.Created++
+		l.Debug("%s: created %s", rs, c.FName)
+	}
+
+	return stat, nil
+}
+
+// splitCoveredChunks separates chunks which time range lies within the range
+// of another chunk. The chunks with the same range don't cover each other.
+// Both returned slices are sorted by start_ts.
+func splitCoveredChunks(chunks []OplogChunk) ([]OplogChunk, []OplogChunk) {
+	// the chunks starting at the same time go from the longest one,
+	// so the covering chunk is always met before the covered ones
+	chunks = slices.Clone(chunks)
+	slices.SortStableFunc(chunks, func(a, b OplogChunk) int {
+		if c := a.StartTS.Compare(b.StartTS); c != 0 {
+			return c
+		}
+		return b.EndTS.Compare(a.EndTS)
+	})
+
+	var rv, covered []OplogChunk
+	// maxEnd is the latest end among the met chunks and
+	// maxStart is the earliest start of the chunks ending at it
+	var maxStart, maxEnd primitive.Timestamp
+	for i, c := range chunks {
+		if i != 0 && (maxEnd.After(c.EndTS) || maxEnd.Equal(c.EndTS) && maxStart.Before(c.StartTS)) {
+			covered = append(covered, c)
+			continue
+		}
+
+		rv = append(rv, c)
+		if i == 0 || c.EndTS.After(maxEnd) {
+			maxStart, maxEnd = c.StartTS, c.EndTS
+		}
+	}
+
+	return rv, covered
+}
+
+// removeChunks deletes chunks metadata and then its files. So if it
+// stops halfway there are only orphan files left and no meta of missed files.
+func removeChunks(ctx context.Context, m connect.Client, stg storage.Storage, chunks []OplogChunk) error {
+	for _, c := range chunks {
+		_, err := m.PITRChunksCollection().DeleteOne(ctx, bson.D{
+			{"rs", c.RS},
+			{"start_ts", c.StartTS},
+			{"end_ts", c.EndTS},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "delete chunk meta %s", c.FName)
+		}
+	}
+
+	for _, c := range chunks {
+		err := stg.Delete(c.FName)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return errors.Wrapf(err, "delete chunk file %s", c.FName)
+		}
+	}
+
+	return nil
+}
+
+func mergeChunks(
+	ctx context.Context,
+	stg storage.Storage,
+	chunks []OplogChunk,
+	cmp compress.CompressionType,
+	level *int,
+) (*OplogChunk, error) {
+	first, last := chunks[0], chunks[len(chunks)-1]
+	c := &OplogChunk{
+		RS:          first.RS,
+		FName:       FormatChunkFilepath(first.RS, first.StartTS, last.EndTS, cmp),
+		Compression: cmp,
+		StartTS:     first.StartTS,
+		EndTS:       last.EndTS,
+		ExcludedNS:  first.ExcludedNS,
+		Storage:     first.Storage,
+	}
+
+	size, err := storage.Upload(ctx, &chunksSource{stg: stg, chunks: chunks}, stg, cmp, level, c.FName, -1)
+	if err != nil {
+		if derr := stg.Delete(c.FName); derr != nil && !errors.Is(derr, storage.ErrNotExist) {
+			log.LogEventFromContext(ctx).Error("remove %s: %v", c.FName, derr)
+		}
+		return nil, errors.Wrap(err, "upload")
+	}
+	c.Size = size
+
+	return c, nil
+}
+
+// chunksSource writes ops of the given chunks one after another.
+// Ops that aren't after the previously written one are skipped.
+type chunksSource struct {
+	stg    storage.Storage
+	chunks []OplogChunk
+}
+
+func (s *chunksSource) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	var last primitive.Timestamp
+	for i := range s.chunks {
+		c := &s.chunks[i]
+		err := readChunkOps(s.stg, c, func(raw []byte) error {
+			ts, err := opTS(raw)
+			if err != nil {
+				return err
+			}
+			if !ts.After(last) {
+				return nil
+			}
+
+			wn, err := w.Write(raw)
+			n += int64(wn)
+			if err != nil {
+				return errors.Wrap(err, "write")
+			}
+			last = ts
+			return nil
+		})
+		if err != nil {
+			return n, errors.Wrapf(err, "read %s", c.FName)
+		}
+	}
+
+	return n, nil
+}
+
+// readChunkOps calls fn for each op of the chunk file.
+// Old chunks with `.snappy` suffix are in fact S2, so such chunks are
+// read with S2 if snappy fails on the very first op.
+func readChunkOps(stg storage.Storage, c *OplogChunk, fn func(raw []byte) error) error {
+	read := 0
+	err := readChunkFile(stg, c.FName, c.Compression, func(raw []byte) error {
+		read++
+		return fn(raw)
+	})
+	if err != nil && read == 0 &&
+		c.Compression == compress.CompressionTypeSNAPPY && errors.Is(err, snappy.ErrCorrupt) {
+		err = readChunkFile(stg, c.FName, compress.CompressionTypeS2, fn)
+	}
+
+	return err
+}
+
+func readChunkFile(stg storage.Storage, fname string, cmp compress.CompressionType, fn func([]byte) error) error {
+	rdr, err := stg.SourceReader(fname)
+	if err != nil {
+		return errors.Wrap(err, "get object")
+	}
+	defer rdr.Close()
+
+	orr, err := compress.Decompress(rdr, cmp, nil)
+	if err != nil {
+		return errors.Wrap(err, "decompress")
+	}
+	defer orr.Close()
+
+	src := db.NewBufferlessBSONSource(orr)
+	for {
+		raw := src.LoadNext()
+		if raw == nil {
+			break
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(src.Err(), "read ops")
+}
+
+func opTS(raw []byte) (primitive.Timestamp, error) {
+	op := struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}{}
+	err := bson.Unmarshal(raw, &op)
+	return op.TS, errors.Wrap(err, "decode op")
+}
+
+func fmtCompactTS(ts primitive.Timestamp) string {
+	return time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,258 @@
+package oplog
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCompactGroups(t *testing.T) {
+	chunk := func(start, end uint32, size int64, excl ...string) OplogChunk {
+		return OplogChunk{
+			FName:      FormatChunkFilepath("rs1", primitive.Timestamp{T: start}, primitive.Timestamp{T: end}, ""),
+			StartTS:    primitive.Timestamp{T: start},
+			EndTS:      primitive.Timestamp{T: end},
+			Size:       size,
+			ExcludedNS: excl,
+		}
+	}
+
+	chunks := []OplogChunk{
+		chunk(0, 600, 10),
+		chunk(600, 1200, 10),
+		chunk(1200, 1800, 10),
+		// gap
+		chunk(2000, 2600, 10),
+		chunk(2600, 3200, 10, "db.c"),
+		chunk(3200, 3800, 10, "db.c"),
+		// single chunk after exclusion change
+		chunk(3800, 4400, 10),
+		chunk(4400, 5000, 10),
+	}
+
+	cases := []struct {
+		name string
+		o    CompactOptions
+		want [][2]uint32
+	}{
+		{
+			"no limits",
+			CompactOptions{Before: primitive.Timestamp{T: 10000}},
+			[][2]uint32{{0, 1800}, {2600, 3800}, {3800, 5000}},
+		},
+		{
+			"before",
+			CompactOptions{Before: primitive.Timestamp{T: 3500}},
+			[][2]uint32{{0, 1800}},
+		},
+		{
+			"max size",
+			CompactOptions{Before: primitive.Timestamp{T: 10000}, MaxSize: 20},
+			[][2]uint32{{0, 1200}, {2600, 3800}, {3800, 5000}},
+		},
+		{
+			"max span",
+			CompactOptions{Before: primitive.Timestamp{T: 10000}, MaxSpan: 1500 * time.Second},
+			[][2]uint32{{0, 1200}, {2600, 3800}, {3800, 5000}},
+		},
+	}
+	for _, tc := range cases {
+		got := [][2]uint32{}
+		for _, g := range CompactGroups(chunks, tc.o) {
+			got = append(got, [2]uint32{g[0].StartTS.T, g[len(g)-1].EndTS.T})
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSplitCoveredChunks(t *testing.T) {
+	chunk := func(name string, start, end uint32) OplogChunk {
+		return OplogChunk{
+			FName:   name,
+			StartTS: primitive.Timestamp{T: start},
+			EndTS:   primitive.Timestamp{T: end},
+		}
+	}
+	names := func(chunks []OplogChunk) []string {
+		var rv []string
+		for _, c := range chunks {
+			rv = append(rv, c.FName)
+		}
+		return rv
+	}
+
+	cases := []struct {
+		name    string
+		chunks  []OplogChunk
+		rest    []string
+		covered []string
+	}{
+		{
+			name: "interrupted compaction",
+			chunks: []OplogChunk{
+				chunk("merged", 0, 1800),
+				chunk("c1", 0, 600),
+				chunk("c2", 600, 1200),
+				chunk("c3", 1200, 1800),
+				chunk("c4", 1800, 2400),
+			},
+			rest:    []string{"merged", "c4"},
+			covered: []string{"c1", "c2", "c3"},
+		},
+		{
+			name: "covering chunk goes after the covered ones",
+			chunks: []OplogChunk{
+				chunk("c1", 0, 600),
+				chunk("c2", 600, 1200),
+				chunk("merged", 0, 1200),
+			},
+			rest:    []string{"merged"},
+			covered: []string{"c1", "c2"},
+		},
+		{
+			name: "covered by a chunk ending at the same time",
+			chunks: []OplogChunk{
+				chunk("merged", 0, 1200),
+				chunk("c1", 600, 1200),
+			},
+			rest:    []string{"merged"},
+			covered: []string{"c1"},
+		},
+		{
+			name: "same range",
+			chunks: []OplogChunk{
+				chunk("c1", 0, 600),
+				chunk("c1.s2", 0, 600),
+			},
+			rest: []string{"c1", "c1.s2"},
+		},
+		{
+			name: "overlapping",
+			chunks: []OplogChunk{
+				chunk("c1", 0, 700),
+				chunk("c2", 600, 1200),
+				chunk("c3", 650, 1100),
+			},
+			rest:    []string{"c1", "c2"},
+			covered: []string{"c3"},
+		},
+		{
+			name: "no chunks",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rest, covered := splitCoveredChunks(c.chunks)
+			if got := names(rest); !slices.Equal(got, c.rest) {
+				t.Errorf("rest: got %v, want %v", got, c.rest)
+			}
+			if got := names(covered); !slices.Equal(got, c.covered) {
+				t.Errorf("covered: got %v, want %v", got, c.covered)
+			}
+		})
+	}
+}
+
+func TestMergeChunks(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(start, end uint32, cmp compress.CompressionType, tss ...uint32) OplogChunk {
+		t.Helper()
+
+		c := OplogChunk{
+			RS:          "rs1",
+			Compression: cmp,
+			StartTS:     primitive.Timestamp{T: start, I: 1},
+			EndTS:       primitive.Timestamp{T: end, I: 1},
+		}
+		c.FName = FormatChunkFilepath(c.RS, c.StartTS, c.EndTS, cmp)
+		if cmp == compress.CompressionTypeSNAPPY {
+			// old chunks with `.snappy` suffix are S2 in fact
+			cmp = compress.CompressionTypeS2
+		}
+
+		buf := &bytes.Buffer{}
+		w, err := compress.Compress(buf, cmp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ts := range tss {
+			raw, err := bson.Marshal(bson.D{
+				{"ts", primitive.Timestamp{T: ts, I: 1}},
+				{"op", "i"},
+				{"ns", "db.c"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(raw); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := stg.Save(c.FName, buf, int64(buf.Len())); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	chunks := []OplogChunk{
+		save(10, 12, compress.CompressionTypeSNAPPY, 10, 11, 12),
+		// overlaps with the previous one
+		save(11, 14, compress.CompressionTypeGZIP, 11, 12, 13, 14),
+		save(14, 16, compress.CompressionTypeS2, 15, 16),
+	}
+
+	c, err := mergeChunks(context.Background(), stg, chunks, compress.CompressionTypeS2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.StartTS.T != 10 || c.EndTS.T != 16 || c.Size == 0 {
+		t.Errorf("merged chunk: got %+v", c)
+	}
+	if err := VerifyChunk(stg, c); err != nil {
+		t.Errorf("verify merged chunk: %v", err)
+	}
+
+	var tss []uint32
+	err = readChunkOps(stg, c, func(raw []byte) error {
+		ts, err := opTS(raw)
+		tss = append(tss, ts.T)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{10, 11, 12, 13, 14, 15, 16}
+	if len(tss) != len(want) {
+		t.Fatalf("merged ops: got %v, want %v", tss, want)
+	}
+	for i := range want {
+		if tss[i] != want[i] {
+			t.Fatalf("merged ops: got %v, want %v", tss, want)
+		}
+	}
+}
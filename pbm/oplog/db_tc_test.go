@@ -9,8 +9,12 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mongodb"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
 )
 
 var mClient *mongo.Client
@@ -96,3 +100,65 @@ func TestApplyOps(t *testing.T) {
 		t.Fatalf("wrong number of docs in new collection, got=%d, want=1", cnt)
 	}
 }
+
+func TestPITRGapLifecycle(t *testing.T) {
+	ctx := context.Background()
+	m := connect.UnsafeClient(mClient)
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+
+	if _, err := GetOpenPITRGap(ctx, m, "rs1"); !errors.Is(err, errors.ErrNotFound) {
+		t.Fatalf("expected no open gap, got %v", err)
+	}
+
+	opened, err := OpenPITRGap(ctx, m, "rs1", "n1:27017", ts(100))
+	if err != nil || !opened {
+		t.Fatalf("open: opened=%v err=%v", opened, err)
+	}
+	opened, err = OpenPITRGap(ctx, m, "rs1", "n2:27017", ts(200))
+	if err != nil || opened {
+		t.Fatalf("second open: opened=%v err=%v", opened, err)
+	}
+
+	gap, err := GetOpenPITRGap(ctx, m, "rs1")
+	if err != nil {
+		t.Fatalf("get open gap: %v", err)
+	}
+	if gap.StartTS != ts(100) || gap.Node != "n1:27017" || !gap.IsOpen() {
+		t.Fatalf("unexpected open gap: %+v", gap)
+	}
+
+	_, err = m.PITRChunksCollection().InsertMany(ctx, []any{
+		OplogChunk{RS: "rs1", StartTS: ts(50), EndTS: ts(100)},
+		OplogChunk{RS: "rs1", StartTS: ts(160), EndTS: ts(220)},
+		OplogChunk{RS: "rs1", StartTS: ts(220), EndTS: ts(280)},
+		OplogChunk{RS: "rs2", StartTS: ts(120), EndTS: ts(180)},
+	})
+	if err != nil {
+		t.Fatalf("insert chunks: %v", err)
+	}
+
+	end, err := GapEndTS(ctx, m, "rs1", gap.StartTS)
+	if err != nil || end != ts(160) {
+		t.Fatalf("gap end: got %v, %v; want %v", end, err, ts(160))
+	}
+	if err := ClosePITRGap(ctx, m, "rs1", end); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := GetOpenPITRGap(ctx, m, "rs1"); !errors.Is(err, errors.ErrNotFound) {
+		t.Fatalf("expected the gap closed, got %v", err)
+	}
+
+	if _, err := OpenPITRGap(ctx, m, "rs2", "", ts(180)); err != nil {
+		t.Fatalf("open rs2: %v", err)
+	}
+	gaps, err := PITRGaps(ctx, m, ts(150))
+	if err != nil {
+		t.Fatalf("gaps: %v", err)
+	}
+	if len(gaps) != 2 || gaps[0].RS != "rs1" || !gaps[0].IsHole() || gaps[1].RS != "rs2" || !gaps[1].IsOpen() {
+		t.Fatalf("unexpected gaps: %+v", gaps)
+	}
+	if gaps, err := PITRGaps(ctx, m, ts(160)); err != nil || len(gaps) != 1 {
+		t.Fatalf("gaps after the hole end: %+v, %v", gaps, err)
+	}
+}
@@ -0,0 +1,151 @@
+package oplog
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// PITRGap describes a period when oplog slicing on a replset lagged behind
+// the cluster time for longer than allowed.
+//
+// Node is the member that was slicing when the gap had been detected (if any).
+// StartTS is the end of the last chunk saved before the gap had been detected.
+// EndTS is set once slicing resumes and equals to the start of the first chunk
+// made after StartTS. If EndTS is greater than StartTS, chunks timeline has
+// a hole and no point-in-time restore is possible within [StartTS, EndTS].
+type PITRGap struct {
+	RS       string              `bson:"rs" json:"rs"`
+	Node     string              `bson:"node,omitempty" json:"node,omitempty"`
+	StartTS  primitive.Timestamp `bson:"start_ts" json:"start_ts"`
+	EndTS    primitive.Timestamp `bson:"end_ts" json:"end_ts"`
+	OpenedAt int64               `bson:"opened_at" json:"opened_at"`
+	ClosedAt int64               `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+}
+
+// IsOpen returns true if slicing hasn't resumed yet.
+func (g *PITRGap) IsOpen() bool {
+	return g.ClosedAt == 0
+}
+
+// IsHole returns true if the gap left a hole in the chunks timeline.
+func (g *PITRGap) IsHole() bool {
+	return !g.IsOpen() && g.EndTS.After(g.StartTS)
+}
+
+// OpenPITRGap records a new open gap for the replset if there is none yet.
+// It returns true if a new gap has been created.
+func OpenPITRGap(
+	ctx context.Context,
+	m connect.Client,
+	rs string,
+	node string,
+	start primitive.Timestamp,
+) (bool, error) {
+	res, err := m.PITRGapsCollection().UpdateOne(
+		ctx,
+		bson.D{{"rs", rs}, {"closed_at", bson.D{{"$exists", false}}}},
+		bson.D{{"$setOnInsert", bson.D{
+			{"rs", rs},
+			{"node", node},
+			{"start_ts", start},
+			{"end_ts", primitive.Timestamp{}},
+			{"opened_at", time.Now().Unix()},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "upsert")
+	}
+
+	return res.UpsertedCount > 0, nil
+}
+
+// ClosePITRGap closes the open gap of the replset with the given end time.
+func ClosePITRGap(ctx context.Context, m connect.Client, rs string, end primitive.Timestamp) error {
+	_, err := m.PITRGapsCollection().UpdateOne(
+		ctx,
+		bson.D{{"rs", rs}, {"closed_at", bson.D{{"$exists", false}}}},
+		bson.D{{"$set", bson.D{
+			{"end_ts", end},
+			{"closed_at", time.Now().Unix()},
+		}}},
+	)
+	return errors.Wrap(err, "update")
+}
+
+// GetOpenPITRGap returns the open gap of the replset.
+// If there is no open gap, ErrNotFound is returned.
+func GetOpenPITRGap(ctx context.Context, m connect.Client, rs string) (*PITRGap, error) {
+	res := m.PITRGapsCollection().FindOne(
+		ctx,
+		bson.D{{"rs", rs}, {"closed_at", bson.D{{"$exists", false}}}},
+	)
+	if err := res.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	g := &PITRGap{}
+	err := res.Decode(g)
+	return g, errors.Wrap(err, "decode")
+}
+
+// PITRGaps returns open gaps and gaps that ended after the given time,
+// sorted by the start time.
+func PITRGaps(ctx context.Context, m connect.Client, after primitive.Timestamp) ([]PITRGap, error) {
+	cur, err := m.PITRGapsCollection().Find(
+		ctx,
+		bson.D{{"$or", bson.A{
+			bson.D{{"closed_at", bson.D{{"$exists", false}}}},
+			bson.D{{"end_ts", bson.D{{"$gt", after}}}},
+		}}},
+		options.Find().SetSort(bson.D{{"start_ts", 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	gaps := []PITRGap{}
+	err = cur.All(ctx, &gaps)
+	return gaps, errors.Wrap(err, "decode")
+}
+
+// firstChunkAfter returns the oldest chunk of the replset started at or after ts.
+func firstChunkAfter(ctx context.Context, m connect.Client, rs string, ts primitive.Timestamp) (*OplogChunk, error) {
+	res := m.PITRChunksCollection().FindOne(
+		ctx,
+		bson.D{{"rs", rs}, {"start_ts", bson.D{{"$gte", ts}}}},
+		options.FindOne().SetSort(bson.D{{"start_ts", 1}}),
+	)
+	if err := res.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	chnk := &OplogChunk{}
+	err := res.Decode(chnk)
+	return chnk, errors.Wrap(err, "decode")
+}
+
+// GapEndTS returns the time the slicing of the replset has resumed from
+// after the gap started at ts.
+func GapEndTS(ctx context.Context, m connect.Client, rs string, ts primitive.Timestamp) (primitive.Timestamp, error) {
+	c, err := firstChunkAfter(ctx, m, rs, ts)
+	if err != nil {
+		return primitive.Timestamp{}, err
+	}
+
+	return c.StartTS, nil
+}
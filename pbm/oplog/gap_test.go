@@ -0,0 +1,39 @@
+package oplog
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPITRGapState(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+
+	tests := []struct {
+		name     string
+		gap      PITRGap
+		wantOpen bool
+		wantHole bool
+	}{
+		{"open", PITRGap{StartTS: ts(10), OpenedAt: 100}, true, false},
+		{"closed with hole", PITRGap{StartTS: ts(10), EndTS: ts(20), ClosedAt: 200}, false, true},
+		{"caught up", PITRGap{StartTS: ts(10), EndTS: ts(10), ClosedAt: 200}, false, false},
+		{"resumed before start", PITRGap{StartTS: ts(10), EndTS: ts(5), ClosedAt: 200}, false, false},
+		{"same second later increment", PITRGap{
+			StartTS:  primitive.Timestamp{T: 10, I: 1},
+			EndTS:    primitive.Timestamp{T: 10, I: 2},
+			ClosedAt: 200,
+		}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.gap.IsOpen(); got != tt.wantOpen {
+				t.Errorf("IsOpen() = %v, want %v", got, tt.wantOpen)
+			}
+			if got := tt.gap.IsHole(); got != tt.wantHole {
+				t.Errorf("IsHole() = %v, want %v", got, tt.wantHole)
+			}
+		})
+	}
+}
@@ -17,11 +17,34 @@ import (
 
 // PITRMeta contains all operational data about PITR execution process.
 type PITRMeta struct {
-	StartTS    int64               `bson:"start_ts" json:"start_ts"`
-	Hb         primitive.Timestamp `bson:"hb" json:"hb"`
-	Status     Status              `bson:"status" json:"status"`
-	Nomination []PITRNomination    `bson:"n" json:"n"`
-	Replsets   []PITRReplset       `bson:"replsets" json:"replsets"`
+	StartTS    int64                 `bson:"start_ts" json:"start_ts"`
+	Hb         primitive.Timestamp   `bson:"hb" json:"hb"`
+	Status     Status                `bson:"status" json:"status"`
+	Nomination []PITRNomination      `bson:"n" json:"n"`
+	Replsets   []PITRReplset         `bson:"replsets" json:"replsets"`
+	Margins    map[string]PITRMargin `bson:"margins,omitempty" json:"margins,omitempty"`
+	Ranges     map[string]PITRRanges `bson:"ranges,omitempty" json:"ranges,omitempty"`
+}
+
+// PITRRanges are the valid restore ranges of the replset chunks as they
+// were after the chunks deletion.
+type PITRRanges struct {
+	Timelines []Timeline `bson:"timelines" json:"timelines"`
+	// OPID is the delete command the ranges were recomputed after
+	OPID      string `bson:"opid" json:"opid"`
+	UpdatedAt int64  `bson:"updated_at" json:"updated_at"`
+}
+
+// PITRMargin is the safety margin of the replset slicing: how much time
+// is left before the oldest not uploaded oplog entry rolls off the oplog.
+// A negative margin means some oplog has been lost already.
+type PITRMargin struct {
+	Node       string              `bson:"node" json:"node"`
+	MarginSec  int64               `bson:"margin_sec" json:"margin_sec"`
+	LastTS     primitive.Timestamp `bson:"last_ts" json:"last_ts"`
+	OplogFirst primitive.Timestamp `bson:"oplog_first" json:"oplog_first"`
+	SpanSec    int64               `bson:"span_sec" json:"span_sec"`
+	UpdatedAt  int64               `bson:"updated_at" json:"updated_at"`
 }
 
 // PITRNomination is used to choose (nominate and elect) member(s)
@@ -275,6 +298,30 @@ func GetAgentsWithACK(ctx context.Context, conn connect.Client) ([]string, error
 	return agents, nil
 }
 
+// SetPITRMargin records the slicing safety margin of the replset.
+func SetPITRMargin(ctx context.Context, conn connect.Client, rs string, m PITRMargin) error {
+	m.UpdatedAt = time.Now().Unix()
+	_, err := conn.PITRCollection().UpdateOne(
+		ctx,
+		bson.D{},
+		bson.D{{"$set", bson.M{"margins." + rs: m}}},
+		options.Update().SetUpsert(true),
+	)
+	return errors.Wrap(err, "update pitr doc for RS margin")
+}
+
+// SetPITRRanges records the valid restore ranges of the replset.
+func SetPITRRanges(ctx context.Context, conn connect.Client, rs string, r PITRRanges) error {
+	r.UpdatedAt = time.Now().Unix()
+	_, err := conn.PITRCollection().UpdateOne(
+		ctx,
+		bson.D{},
+		bson.D{{"$set", bson.M{"ranges." + rs: r}}},
+		options.Update().SetUpsert(true),
+	)
+	return errors.Wrap(err, "update pitr doc for RS ranges")
+}
+
 func SetHbForPITR(ctx context.Context, conn connect.Client) error {
 	ts, err := topo.GetClusterTime(ctx, conn)
 	if err != nil {
@@ -3,6 +3,7 @@ package oplog
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -54,9 +55,74 @@ func findTransactionStartTime(ctx context.Context, m *mongo.Client) (primitive.T
 	return primitive.Timestamp{T: t, I: i}, nil
 }
 
+// GetOplogWindow returns time span between the first and the last oplog records on the node.
+func GetOplogWindow(ctx context.Context, m *mongo.Client) (time.Duration, error) {
+	first, err := findOplogTS(ctx, m, 1)
+	if err != nil {
+		return 0, errors.Wrap(err, "get first oplog ts")
+	}
+	last, err := findOplogTS(ctx, m, -1)
+	if err != nil {
+		return 0, errors.Wrap(err, "get last oplog ts")
+	}
+
+	return time.Duration(int64(last.T)-int64(first.T)) * time.Second, nil
+}
+
+// GetNodeWindow returns the oplog window of the node with the oplog size.
+func GetNodeWindow(ctx context.Context, m *mongo.Client) (*topo.OplogWindow, error) {
+	first, err := findOplogTS(ctx, m, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get first oplog ts")
+	}
+	last, err := findOplogTS(ctx, m, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get last oplog ts")
+	}
+
+	cur, err := m.Database("local").Collection("oplog.rs").Aggregate(ctx,
+		mongo.Pipeline{{{"$collStats", bson.D{{"storageStats", bson.D{}}}}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "get oplog stats")
+	}
+	var stats []struct {
+		StorageStats struct {
+			Size    int64 `bson:"size"`
+			MaxSize int64 `bson:"maxSize"`
+		} `bson:"storageStats"`
+	}
+	if err := cur.All(ctx, &stats); err != nil {
+		return nil, errors.Wrap(err, "decode oplog stats")
+	}
+
+	w := &topo.OplogWindow{
+		First:     first,
+		Last:      last,
+		UpdatedAt: time.Now().Unix(),
+	}
+	if len(stats) != 0 {
+		w.Size = stats[0].StorageStats.Size
+		w.MaxSize = stats[0].StorageStats.MaxSize
+	}
+	if span := w.Span().Seconds(); span > 0 {
+		w.Rate = float64(w.Size) / span
+	}
+
+	return w, nil
+}
+
+// GetOplogFirstTS returns the timestamp of the oldest oplog record on the node.
+func GetOplogFirstTS(ctx context.Context, m *mongo.Client) (primitive.Timestamp, error) {
+	return findOplogTS(ctx, m, 1)
+}
+
 func findLastOplogTS(ctx context.Context, m *mongo.Client) (primitive.Timestamp, error) {
+	return findOplogTS(ctx, m, -1)
+}
+
+func findOplogTS(ctx context.Context, m *mongo.Client, sort int) (primitive.Timestamp, error) {
 	coll := m.Database("local").Collection("oplog.rs")
-	o := options.FindOne().SetSort(bson.M{"$natural": -1})
+	o := options.FindOne().SetSort(bson.M{"$natural": sort})
 	doc, err := coll.FindOne(ctx, bson.D{}, o).Raw()
 	if err != nil {
 		return primitive.Timestamp{}, errors.Wrap(err, "query oplog")
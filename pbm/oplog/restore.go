@@ -92,6 +92,14 @@ var cloningNSSupportedCommands = map[string]struct{}{
 	"commitIndexBuild": {},
 }
 
+// txnControlCommands commit or abort a (prepared) transaction. Ops of
+// the transaction are checked one by one on apply, so these commands
+// shouldn't be filtered out by namespace.
+var txnControlCommands = map[string]struct{}{
+	"commitTransaction": {},
+	"abortTransaction":  {},
+}
+
 var dontPreserveUUID = []string{
 	"admin.system.users",
 	"admin.system.roles",
@@ -150,6 +158,11 @@ type OplogRestore struct {
 	// if we've moved further in general. No need in
 	// `I` precision.
 	lastOpT uint32
+	// skipTS is the Timestamp up to which the ops are skipped as
+	// already applied from the overlapped chunk (see SetSkipApplied).
+	skipTS primitive.Timestamp
+	// observedOps is the number of ops read within the time frame.
+	observedOps uint64
 
 	preserveUUID bool
 	cnamespase   string
@@ -209,6 +222,23 @@ func NewOplogRestore(
 	}, nil
 }
 
+// SetExcludeNS adds namespaces (wildcards allowed) which ops should be
+// skipped during the replay, on top of the always excluded ones.
+func (o *OplogRestore) SetExcludeNS(nss []string) error {
+	if len(nss) == 0 {
+		return nil
+	}
+
+	exclude := slices.Concat(snapshot.ExcludeFromRestore, excludeFromOplog, nss)
+	matcher, err := ns.NewMatcher(exclude)
+	if err != nil {
+		return errors.Wrap(err, "create matcher for the collections exclude")
+	}
+
+	o.excludeNS = matcher
+	return nil
+}
+
 // SetOpFilter allows to restrict skip ops by specific conditions
 func (o *OplogRestore) SetOpFilter(f OpFilter) {
 	if f == nil {
@@ -227,6 +257,13 @@ func (o *OplogRestore) SetTimeframe(start, end primitive.Timestamp) {
 	o.endTS = end
 }
 
+// SetSkipApplied makes Apply skip the ops up to ts (inclusive) which were
+// applied from the previous chunk overlapping the next one.
+// Zero ts disables it.
+func (o *OplogRestore) SetSkipApplied(ts primitive.Timestamp) {
+	o.skipTS = ts
+}
+
 // Apply applys an oplog from a given source
 func (o *OplogRestore) Apply(src io.ReadCloser) (primitive.Timestamp, error) {
 	bsonSource := db.NewDecodedBSONSource(db.NewBufferlessBSONSource(src))
@@ -250,6 +287,11 @@ func (o *OplogRestore) Apply(src io.ReadCloser) (primitive.Timestamp, error) {
 			continue
 		}
 
+		// skip if operation has been already applied from the overlapped chunk
+		if !o.skipTS.IsZero() && !oe.Timestamp.After(o.skipTS) {
+			continue
+		}
+
 		// finish if operation happened after the desired time frame (oe.Timestamp > to)
 		if o.endTS.T > 0 && oe.Timestamp.Compare(o.endTS) == 1 {
 			return lts, nil
@@ -263,6 +305,7 @@ func (o *OplogRestore) Apply(src io.ReadCloser) (primitive.Timestamp, error) {
 		lts = oe.Timestamp
 		// keeping track of last applied (observed) clusterTime
 		atomic.StoreUint32(&o.lastOpT, oe.Timestamp.T)
+		atomic.AddUint64(&o.observedOps, 1)
 	}
 
 	return lts, bsonSource.Err()
@@ -361,6 +404,12 @@ func (o *OplogRestore) isOpSelected(oe *Record) bool {
 	if cmd == "applyOps" {
 		return true // internal ops of applyOps are checked one by one later
 	}
+	if _, ok := txnControlCommands[cmd]; ok {
+		return true
+	}
+	if cmd == "renameCollection" {
+		return true // both namespaces are checked by handleRenameNS
+	}
 	if _, ok := selectedNSSupportedCommands[cmd]; ok {
 		s, _ := oe.Object[0].Value.(string)
 		return colls[s]
@@ -369,6 +418,57 @@ func (o *OplogRestore) isOpSelected(oe *Record) bool {
 	return false
 }
 
+// isNSRestored returns true if ops on the given namespace pass the namespace
+// filters (excluded, allowed and selected namespaces).
+func (o *OplogRestore) isNSRestored(ns string) bool {
+	rec := &Record{Operation: "i", Namespace: ns}
+	return !o.isOpExcluded(rec) && isOpAllowed(rec) && o.isOpSelected(rec)
+}
+
+// handleRenameNS checks both namespaces of renameCollection against
+// the namespace filters. Rename that moves a collection into or out of
+// the restored set can't be applied as is since only one of the collections
+// exists. So it is converted to:
+//   - drop of the source collection if it's moved out of the set;
+//   - drop of the target collection if it's moved into the set. The data of
+//     the source collection isn't restored and the target (if existed before)
+//     is replaced by the rename.
+//
+// It returns false if the op should be skipped.
+func (o *OplogRestore) handleRenameNS(op db.Oplog) (db.Oplog, bool) {
+	from, _ := op.Object[0].Value.(string)
+	to, _ := bsonutil.FindValueByKey("to", &op.Object)
+	toNS, _ := to.(string)
+
+	fromIn, toIn := o.isNSRestored(from), o.isNSRestored(toNS)
+	switch {
+	case fromIn && toIn:
+		return op, true
+	case fromIn:
+		return renameToDrop(op, from), true
+	case toIn:
+		return renameToDrop(op, toNS), true
+	}
+
+	return op, false
+}
+
+// renameToDrop returns the `drop` command for the ns made from the
+// renameCollection op.
+func renameToDrop(op db.Oplog, ns string) db.Oplog {
+	d, c, _ := strings.Cut(ns, ".")
+	op.Namespace = d + ".$cmd"
+	op.Object = bson.D{{"drop", c}}
+	op.Query = nil
+	// ui points to the source collection
+	op.UI = nil
+	return op
+}
+
+func isRenameCmd(op *db.Oplog) bool {
+	return op.Operation == "c" && len(op.Object) > 0 && op.Object[0].Key == "renameCollection"
+}
+
 // isOpForCloning returns whether op needs to be processed or not in case of cloning NS.
 // In case of non cloning use case, it's always true.
 func (o *OplogRestore) isOpForCloning(oe *db.Oplog) bool {
@@ -395,6 +495,9 @@ func (o *OplogRestore) isOpForCloning(oe *db.Oplog) bool {
 	if cmd == "applyOps" {
 		return true // internal ops of applyOps are checked one by one later
 	}
+	if _, ok := txnControlCommands[cmd]; ok {
+		return true
+	}
 
 	if db != o.cloneNS.fromDB {
 		// it's command not relevant for db to clone from
@@ -413,12 +516,22 @@ func (o *OplogRestore) isOpForCloning(oe *db.Oplog) bool {
 }
 
 func (o *OplogRestore) isOpExcluded(oe *Record) bool {
-	if o.excludeNS == nil {
+	return isOpExcludedBy(o.excludeNS, oe)
+}
+
+// isOpExcludedBy returns true if the op targets a namespace that matches
+// the given matcher. `applyOps` isn't excluded as a whole, its internal ops
+// should be checked one by one.
+func isOpExcludedBy(m *ns.Matcher, oe *Record) bool {
+	if m == nil {
 		return false
 	}
 	db, coll, _ := strings.Cut(oe.Namespace, ".")
 	if coll != "$cmd" {
-		return o.excludeNS.Has(oe.Namespace)
+		return m.Has(oe.Namespace)
+	}
+	if len(oe.Object) == 0 {
+		return false
 	}
 
 	cmd := oe.Object[0].Key
@@ -427,7 +540,11 @@ func (o *OplogRestore) isOpExcluded(oe *Record) bool {
 	}
 	if _, ok := selectedNSSupportedCommands[cmd]; ok {
 		coll, _ = oe.Object[0].Value.(string)
-		return o.excludeNS.Has(db + "." + coll)
+		return m.Has(db + "." + coll)
+	}
+	if cmd == "dropDatabase" {
+		// only if the whole database is excluded (e.g. `db.*`)
+		return m.Has(db + ".*")
 	}
 	// handle renameCollection and convertToCapped commands.
 	// NOTE: convertToCapped is done by creating a temporary capped collection,
@@ -435,11 +552,11 @@ func (o *OplogRestore) isOpExcluded(oe *Record) bool {
 	//       and renaming it to the source collection.
 	if cmd == "renameCollection" {
 		from, _ := oe.Object[0].Value.(string)
-		if o.excludeNS.Has(from) {
+		if m.Has(from) {
 			return true
 		}
 		to, _ := oe.Object[1].Value.(string)
-		if o.excludeNS.Has(to) {
+		if m.Has(to) {
 			return true
 		}
 	}
@@ -451,6 +568,12 @@ func (o *OplogRestore) LastOpTS() uint32 {
 	return atomic.LoadUint32(&o.lastOpT)
 }
 
+// ObservedOps returns the number of ops applied (or skipped by filters)
+// so far. It is safe to call concurrently with Apply.
+func (o *OplogRestore) ObservedOps() uint64 {
+	return atomic.LoadUint64(&o.observedOps)
+}
+
 func (o *OplogRestore) handleOp(oe db.Oplog) error {
 	// skip if operation happened after the desired time frame (oe.Timestamp > o.lastTS)
 	if o.endTS.T > 0 && oe.Timestamp.Compare(o.endTS) == 1 {
@@ -462,6 +585,14 @@ func (o *OplogRestore) handleOp(oe db.Oplog) error {
 		return nil
 	}
 
+	if isRenameCmd(&oe) && !o.cloneNS.IsSpecified() {
+		var ok bool
+		oe, ok = o.handleRenameNS(oe)
+		if !ok {
+			return nil
+		}
+	}
+
 	if o.isOpExcluded(&oe) || !isOpAllowed(&oe) ||
 		!o.isOpSelected(&oe) || !o.isOpForCloning(&oe) {
 		return nil
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/mongodb/mongo-tools/common/db"
@@ -638,3 +639,242 @@ func replaceNsWithinOpEntry(t *testing.T, jsonEntry, ns string) *db.Oplog {
 	}
 	return &oe
 }
+
+func TestApplyExcludeNS(t *testing.T) {
+	ops := []bson.D{
+		createExcludeTestOp("i", "cache.c1", bson.D{{"_id", 1}}),
+		createExcludeTestOp("i", "mydb.c1", bson.D{{"_id", 1}}),
+		createExcludeTestOp("c", "admin.$cmd", bson.D{{"applyOps", bson.A{
+			createExcludeTestOp("i", "cache.c2", bson.D{{"_id", 2}}),
+			createExcludeTestOp("i", "mydb.c2", bson.D{{"_id", 2}}),
+		}}}),
+		createExcludeTestTxn(bson.A{
+			createExcludeTestOp("i", "mydb.c3", bson.D{{"_id", 3}}),
+			createExcludeTestOp("d", "cache.c3", bson.D{{"_id", 3}}),
+		}),
+		createExcludeTestOp("c", "cache.$cmd", bson.D{{"drop", "c1"}}),
+		createExcludeTestOp("c", "cache.$cmd", bson.D{{"dropDatabase", 1}}),
+	}
+
+	b := &bytes.Buffer{}
+	for _, op := range ops {
+		raw, err := bson.Marshal(op)
+		if err != nil {
+			t.Fatalf("marshal op: %v", err)
+		}
+		b.Write(raw)
+	}
+
+	db := newMDBTestClient()
+	oRestore := newOplogRestoreTest(db)
+	oRestore.txnData = make(map[string]Txn)
+	oRestore.txnCommit = newCQueue(saveLastDistTxns)
+	if err := oRestore.SetExcludeNS([]string{"cache.*"}); err != nil {
+		t.Fatalf("set exclude ns: %v", err)
+	}
+
+	_, err := oRestore.Apply(io.NopCloser(b))
+	if err != nil {
+		t.Fatalf("error while applying oplog: %v", err)
+	}
+
+	want := []string{"mydb.c1", "mydb.c2", "mydb.c3"}
+	if len(db.applyOpsInv) != len(want) {
+		t.Fatalf("wrong number of applyOps invocation, want=%d, got=%d: %v",
+			len(want), len(db.applyOpsInv), db.applyOpsInv)
+	}
+	for i, ns := range want {
+		if got := db.applyOpsInv[i]["ns"]; got != ns {
+			t.Errorf("wrong #%d. namespace: want=%s, got=%s", i, ns, got)
+		}
+	}
+}
+
+func createExcludeTestOp(op, ns string, o bson.D) bson.D {
+	return bson.D{
+		{"ts", primitive.Timestamp{T: 1732706433, I: 1}},
+		{"t", int64(1)},
+		{"v", 2},
+		{"op", op},
+		{"ns", ns},
+		{"o", o},
+	}
+}
+
+func createExcludeTestTxn(ops bson.A) bson.D {
+	txn := createExcludeTestOp("c", "admin.$cmd", bson.D{{"applyOps", ops}})
+	return append(txn,
+		bson.E{"lsid", bson.D{{"id", primitive.Binary{Subtype: 0x04, Data: []byte("0123456789abcdef")}}}},
+		bson.E{"txnNumber", int64(1)})
+}
+
+func TestApplyNSFilter(t *testing.T) {
+	prepared := createExcludeTestTxn(bson.A{
+		createExcludeTestOp("i", "mydb.c1", bson.D{{"_id", 4}}),
+		createExcludeTestOp("i", "other.c1", bson.D{{"_id", 4}}),
+	})
+	prepared[len(prepared)-3].Value = append(prepared[len(prepared)-3].Value.(bson.D), bson.E{"prepare", true})
+	commit := createExcludeTestTxn(nil)
+	commit[len(commit)-3].Value = bson.D{
+		{"commitTransaction", 1},
+		{"commitTimestamp", primitive.Timestamp{T: 1732706433, I: 1}},
+	}
+
+	testCases := []struct {
+		desc    string
+		include []string
+		exclude []string
+		ops     []bson.D
+		want    []map[string]string
+	}{
+		{
+			desc:    "rename out of the included set",
+			include: []string{"mydb.c1"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"renameCollection", "mydb.c1"}, {"to", "mydb.c2"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+			},
+		},
+		{
+			desc:    "rename into the included set",
+			include: []string{"mydb.c1"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"renameCollection", "mydb.c2"}, {"to", "mydb.c1"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+			},
+		},
+		{
+			desc:    "rename within the included set",
+			include: []string{"mydb.c1", "mydb.c2"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"renameCollection", "mydb.c1"}, {"to", "mydb.c2"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "renameCollection", "coll": "mydb.c1"},
+			},
+		},
+		{
+			desc:    "rename out of the set",
+			include: []string{"mydb.*"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "other.$cmd", bson.D{{"renameCollection", "other.c1"}, {"to", "other.c2"}}),
+			},
+		},
+		{
+			desc:    "rename into the excluded set",
+			exclude: []string{"mydb.tmp"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"renameCollection", "mydb.c1"}, {"to", "mydb.tmp"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+			},
+		},
+		{
+			desc:    "rename out of the excluded set",
+			exclude: []string{"mydb.tmp"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"renameCollection", "mydb.tmp"}, {"to", "mydb.c1"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+			},
+		},
+		{
+			desc:    "applyOps batch",
+			include: []string{"mydb.*"},
+			exclude: []string{"mydb.skip"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "admin.$cmd", bson.D{{"applyOps", bson.A{
+					createExcludeTestOp("i", "mydb.c1", bson.D{{"_id", 1}}),
+					createExcludeTestOp("i", "other.c1", bson.D{{"_id", 1}}),
+					createExcludeTestOp("i", "mydb.skip", bson.D{{"_id", 1}}),
+				}}}),
+			},
+			want: []map[string]string{
+				{"op": "i", "ns": "mydb.c1"},
+			},
+		},
+		{
+			desc:    "transaction with mixed namespaces",
+			include: []string{"mydb.c1"},
+			ops: []bson.D{
+				createExcludeTestTxn(bson.A{
+					createExcludeTestOp("i", "mydb.c1", bson.D{{"_id", 3}}),
+					createExcludeTestOp("u", "other.c1", bson.D{{"$set", bson.D{{"a", 1}}}}),
+					createExcludeTestOp("d", "mydb.c2", bson.D{{"_id", 3}}),
+				}),
+			},
+			want: []map[string]string{
+				{"op": "i", "ns": "mydb.c1"},
+			},
+		},
+		{
+			desc:    "prepared transaction with mixed namespaces",
+			include: []string{"mydb.c1"},
+			ops:     []bson.D{prepared, commit},
+			want: []map[string]string{
+				{"op": "i", "ns": "mydb.c1"},
+			},
+		},
+		{
+			desc:    "create and drop",
+			include: []string{"mydb.c1"},
+			exclude: []string{"mydb.c2"},
+			ops: []bson.D{
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"create", "c1"}}),
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"create", "c2"}}),
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"create", "c3"}}),
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"drop", "c1"}}),
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"drop", "c2"}}),
+				createExcludeTestOp("c", "mydb.$cmd", bson.D{{"drop", "c3"}}),
+			},
+			want: []map[string]string{
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "create", "coll": "c1"},
+				{"op": "c", "ns": "mydb.$cmd", "cmd": "drop", "coll": "c1"},
+			},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			for _, op := range tC.ops {
+				raw, err := bson.Marshal(op)
+				if err != nil {
+					t.Fatalf("marshal op: %v", err)
+				}
+				b.Write(raw)
+			}
+
+			db := newMDBTestClient()
+			oRestore := newOplogRestoreTest(db)
+			oRestore.txnData = make(map[string]Txn)
+			oRestore.txnCommit = newCQueue(saveLastDistTxns)
+			oRestore.SetIncludeNS(tC.include)
+			if err := oRestore.SetExcludeNS(tC.exclude); err != nil {
+				t.Fatalf("set exclude ns: %v", err)
+			}
+
+			_, err := oRestore.Apply(io.NopCloser(b))
+			if err != nil {
+				t.Fatalf("error while applying oplog: %v", err)
+			}
+
+			if len(db.applyOpsInv) != len(tC.want) {
+				t.Fatalf("wrong number of applyOps invocation, want=%d, got=%d: %v",
+					len(tC.want), len(db.applyOpsInv), db.applyOpsInv)
+			}
+			for i, want := range tC.want {
+				if !reflect.DeepEqual(db.applyOpsInv[i], want) {
+					t.Errorf("wrong #%d. op: want=%v, got=%v", i, want, db.applyOpsInv[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package oplog
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// ChunksFromFiles makes chunks metadata from the list of files found under
+// the prefix (e.g. a copy of the `pbmPitr` dir). Files are expected to be laid
+// out as `[...]/<rs>/<date>/<chunk file>`. FName of the returned chunks is
+// the path to the file within the storage.
+// Files that aren't oplog chunks are returned as skipped.
+// There is no metadata of the files, so the compression of the chunks
+// without a compression suffix is left empty for the readers to detect it
+// by the magic bytes (see compress.Decompress).
+func ChunksFromFiles(prefix string, files []storage.FileInfo) ([]OplogChunk, []string) {
+	var chunks []OplogChunk
+	var skipped []string
+
+	for _, f := range files {
+		name := strings.Trim(f.Name, "/")
+		ppath := strings.Split(name, "/")
+		if len(ppath) > 3 {
+			ppath = ppath[len(ppath)-3:]
+		}
+
+		c := MakeChunkMetaFromFilepath(path.Join(ppath...))
+		if c == nil || !isKnownChunkSuffix(name, c.Compression) {
+			skipped = append(skipped, f.Name)
+			continue
+		}
+
+		if c.Compression == compress.CompressionTypeNone {
+			c.Compression = ""
+		}
+		c.FName = path.Join(prefix, f.Name)
+		c.Size = f.Size
+		chunks = append(chunks, *c)
+	}
+
+	return chunks, skipped
+}
+
+// isKnownChunkSuffix checks if the file extension matches the parsed compression.
+// An unknown extension is parsed as no compression.
+func isKnownChunkSuffix(name string, c compress.CompressionType) bool {
+	if c != compress.CompressionTypeNone {
+		return true
+	}
+
+	return strings.HasSuffix(name, ".oplog")
+}
+
+// DedupChunks sorts chunks by time and removes ones that are fully covered
+// by others (e.g. the same chunk copied twice). Partially overlapping chunks
+// are kept, already applied ops are skipped during the replay.
+// All chunks are expected to belong to the same replset.
+func DedupChunks(chunks []OplogChunk) []OplogChunk {
+	sorted := make([]OplogChunk, len(chunks))
+	copy(sorted, chunks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if c := sorted[i].StartTS.Compare(sorted[j].StartTS); c != 0 {
+			return c == -1
+		}
+		return sorted[i].EndTS.After(sorted[j].EndTS)
+	})
+
+	rv := []OplogChunk{}
+	var last primitive.Timestamp
+	for _, c := range sorted {
+		if !last.IsZero() && !c.EndTS.After(last) {
+			continue
+		}
+
+		rv = append(rv, c)
+		last = c.EndTS
+	}
+
+	return rv
+}
+
+// ChunksHoles returns time ranges within [from, to] that aren't covered by
+// the given chunks. Chunks should be sorted and deduplicated (see DedupChunks).
+func ChunksHoles(chunks []OplogChunk, from, to primitive.Timestamp) []Timeline {
+	holes := []Timeline{}
+
+	last := from
+	for _, c := range chunks {
+		if !c.EndTS.After(last) {
+			continue
+		}
+		if c.StartTS.After(to) {
+			break
+		}
+		if c.StartTS.After(last) {
+			holes = append(holes, Timeline{Start: last.T, End: c.StartTS.T})
+		}
+		last = c.EndTS
+		if !last.Before(to) {
+			return holes
+		}
+	}
+
+	return append(holes, Timeline{Start: last.T, End: to.T})
+}
+
+// SelectChunks returns chunks that have ops within [from, to].
+func SelectChunks(chunks []OplogChunk, from, to primitive.Timestamp) []OplogChunk {
+	rv := []OplogChunk{}
+	for _, c := range chunks {
+		if c.EndTS.Before(from) || c.StartTS.After(to) {
+			continue
+		}
+		rv = append(rv, c)
+	}
+
+	return rv
+}
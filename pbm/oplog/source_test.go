@@ -0,0 +1,119 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+func TestChunksFromFiles(t *testing.T) {
+	files := []storage.FileInfo{
+		{Name: "rs1/20240105/20240105100000-1.20240105101000-2.oplog.s2", Size: 10},
+		{Name: "pbmPitr/rs2/20240105/20240105100000-1.20240105101000-2.oplog", Size: 20},
+		{Name: "rs1/20240105/20240105100000-1.20240105101000-2.oplog.bin"},
+		{Name: "rs1/20240105/notes.txt"},
+	}
+
+	chunks, skipped := ChunksFromFiles("copy", files)
+	want := []OplogChunk{
+		{
+			RS:          "rs1",
+			FName:       "copy/rs1/20240105/20240105100000-1.20240105101000-2.oplog.s2",
+			Compression: compress.CompressionTypeS2,
+			StartTS:     primitive.Timestamp{T: 1704448800, I: 1},
+			EndTS:       primitive.Timestamp{T: 1704449400, I: 2},
+			Size:        10,
+		},
+		{
+			RS:          "rs2",
+			FName:       "copy/pbmPitr/rs2/20240105/20240105100000-1.20240105101000-2.oplog",
+			Compression: "", // detected by the readers
+			StartTS:     primitive.Timestamp{T: 1704448800, I: 1},
+			EndTS:       primitive.Timestamp{T: 1704449400, I: 2},
+			Size:        20,
+		},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("wrong chunks:\nwant=%+v\ngot= %+v", want, chunks)
+	}
+	if len(skipped) != 2 {
+		t.Errorf("expected 2 skipped files, got %v", skipped)
+	}
+}
+
+func TestDedupChunks(t *testing.T) {
+	chunks := []OplogChunk{
+		testChunk(20, 30),
+		testChunk(10, 20),
+		testChunk(10, 20), // the same chunk copied twice
+		testChunk(12, 18), // fully covered
+		testChunk(25, 40), // partially overlapped
+	}
+
+	got := DedupChunks(chunks)
+	want := []OplogChunk{testChunk(10, 20), testChunk(20, 30), testChunk(25, 40)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want=%v, got=%v", want, got)
+	}
+}
+
+func TestChunksHoles(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+
+	testCases := []struct {
+		desc     string
+		chunks   []OplogChunk
+		from, to primitive.Timestamp
+		want     []Timeline
+	}{
+		{
+			desc:   "contiguous",
+			chunks: []OplogChunk{testChunk(10, 20), testChunk(20, 30), testChunk(25, 40)},
+			from:   ts(15),
+			to:     ts(35),
+			want:   []Timeline{},
+		},
+		{
+			desc:   "hole in the middle",
+			chunks: []OplogChunk{testChunk(10, 20), testChunk(25, 40)},
+			from:   ts(15),
+			to:     ts(35),
+			want:   []Timeline{{Start: 20, End: 25}},
+		},
+		{
+			desc:   "holes on the edges",
+			chunks: []OplogChunk{testChunk(10, 20)},
+			from:   ts(5),
+			to:     ts(25),
+			want:   []Timeline{{Start: 5, End: 10}, {Start: 20, End: 25}},
+		},
+		{
+			desc:   "chunk after the range",
+			chunks: []OplogChunk{testChunk(10, 20), testChunk(30, 40)},
+			from:   ts(10),
+			to:     ts(25),
+			want:   []Timeline{{Start: 20, End: 25}},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got := ChunksHoles(tC.chunks, tC.from, tC.to)
+			if !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("want=%v, got=%v", tC.want, got)
+			}
+		})
+	}
+}
+
+func testChunk(start, end uint32) OplogChunk {
+	return OplogChunk{
+		RS:      "rs1",
+		StartTS: primitive.Timestamp{T: start},
+		EndTS:   primitive.Timestamp{T: end},
+	}
+}
@@ -0,0 +1,284 @@
+package oplog
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// VerifyWatermark is the end of the contiguous range of the replset chunks
+// that has been verified already.
+type VerifyWatermark struct {
+	RS         string              `bson:"rs" json:"rs"`
+	VerifiedTS primitive.Timestamp `bson:"verified_ts" json:"verified_ts"`
+	VerifiedAt int64               `bson:"verified_at" json:"verified_at"`
+}
+
+// GetVerifyWatermark returns the verification watermark of the replset.
+// If chunks of the replset have never been verified, ErrNotFound is returned.
+func GetVerifyWatermark(ctx context.Context, m connect.Client, rs string) (*VerifyWatermark, error) {
+	res := m.PITRVerifyCollection().FindOne(ctx, bson.D{{"rs", rs}})
+	if err := res.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	w := &VerifyWatermark{}
+	err := res.Decode(w)
+	return w, errors.Wrap(err, "decode")
+}
+
+// SetVerifyWatermark sets the verification watermark of the replset.
+func SetVerifyWatermark(ctx context.Context, m connect.Client, rs string, ts primitive.Timestamp) error {
+	_, err := m.PITRVerifyCollection().UpdateOne(
+		ctx,
+		bson.D{{"rs", rs}},
+		bson.D{{"$set", bson.D{
+			{"verified_ts", ts},
+			{"verified_at", time.Now().Unix()},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	return errors.Wrap(err, "upsert")
+}
+
+// verifyReportID is the _id of the last verification report. The report
+// is kept along with the watermarks, which have no _id set.
+const verifyReportID = "report"
+
+// CorruptChunk is the chunk that has failed the verification
+type CorruptChunk struct {
+	File  string              `bson:"file" json:"file"`
+	Start primitive.Timestamp `bson:"start" json:"start"`
+	End   primitive.Timestamp `bson:"end" json:"end"`
+	Err   string              `bson:"error" json:"error"`
+}
+
+// RSVerifyResult is the verification result of the replset chunks
+type RSVerifyResult struct {
+	RS        string              `bson:"rs" json:"rs"`
+	Chunks    int                 `bson:"chunks" json:"chunks"`
+	Verified  []Timeline          `bson:"verified" json:"verified"`
+	Corrupt   []CorruptChunk      `bson:"corrupt,omitempty" json:"corrupt,omitempty"`
+	Gaps      []Timeline          `bson:"gaps,omitempty" json:"gaps,omitempty"`
+	Watermark primitive.Timestamp `bson:"watermark" json:"watermark"`
+}
+
+// VerifyReport is the result of the chunks verification run by an agent.
+// Only the last report is kept.
+type VerifyReport struct {
+	OPID     string           `bson:"opid" json:"opid"`
+	Node     string           `bson:"node" json:"node"`
+	Status   defs.Status      `bson:"status" json:"status"`
+	Error    string           `bson:"error,omitempty" json:"error,omitempty"`
+	StartTS  int64            `bson:"start_ts" json:"start_ts"`
+	FinishTS int64            `bson:"finish_ts,omitempty" json:"finish_ts,omitempty"`
+	Replsets []RSVerifyResult `bson:"replsets" json:"replsets"`
+}
+
+// GetVerifyReport returns the last verification report.
+// If chunks have never been verified, ErrNotFound is returned.
+func GetVerifyReport(ctx context.Context, m connect.Client) (*VerifyReport, error) {
+	res := m.PITRVerifyCollection().FindOne(ctx, bson.D{{"_id", verifyReportID}})
+	if err := res.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	r := &VerifyReport{}
+	err := res.Decode(r)
+	return r, errors.Wrap(err, "decode")
+}
+
+// SetVerifyReport replaces the last verification report.
+func SetVerifyReport(ctx context.Context, m connect.Client, r *VerifyReport) error {
+	_, err := m.PITRVerifyCollection().ReplaceOne(
+		ctx,
+		bson.D{{"_id", verifyReportID}},
+		r,
+		options.Replace().SetUpsert(true),
+	)
+	return errors.Wrap(err, "upsert")
+}
+
+// VerifyReplset checks integrity of the replset chunks in the range and
+// contiguity across their boundaries. If `from` isn't set, only chunks made
+// after the previous run are verified. The watermark is advanced up to
+// the first corrupt chunk. chunkStorage returns the storage of the chunk.
+func VerifyReplset(
+	ctx context.Context,
+	m connect.Client,
+	chunkStorage func(*OplogChunk) (storage.Storage, error),
+	rs string,
+	from primitive.Timestamp,
+	to primitive.Timestamp,
+) (*RSVerifyResult, error) {
+	res := &RSVerifyResult{RS: rs, Verified: []Timeline{}}
+
+	w, err := GetVerifyWatermark(ctx, m, rs)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return nil, errors.Wrap(err, "get watermark")
+	}
+	if w != nil {
+		res.Watermark = w.VerifiedTS
+	}
+
+	fromWatermark := from.IsZero() && !res.Watermark.IsZero()
+	if fromWatermark {
+		from = res.Watermark
+	}
+
+	chunks, err := PITRGetChunksSlice(ctx, m, rs, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks")
+	}
+
+	// the watermark moves only if the verified range is adjacent to it
+	moveWatermark := to.IsZero() && (res.Watermark.IsZero() || !from.After(res.Watermark))
+	prev := primitive.Timestamp{}
+	lastOK := false
+	if fromWatermark {
+		prev = res.Watermark
+	}
+	for i := range chunks {
+		c := &chunks[i]
+		if fromWatermark && !c.EndTS.After(res.Watermark) {
+			continue
+		}
+
+		if !prev.IsZero() && c.StartTS.After(prev) {
+			res.Gaps = append(res.Gaps, Timeline{Start: prev.T, End: c.StartTS.T})
+		}
+		if c.EndTS.After(prev) {
+			prev = c.EndTS
+		}
+		res.Chunks++
+
+		stg, err := chunkStorage(c)
+		if err != nil {
+			return nil, err
+		}
+		err = VerifyChunk(stg, c)
+		if err != nil {
+			res.Corrupt = append(res.Corrupt, CorruptChunk{
+				File:  c.FName,
+				Start: c.StartTS,
+				End:   c.EndTS,
+				Err:   err.Error(),
+			})
+			moveWatermark = false
+			lastOK = false
+			continue
+		}
+
+		n := len(res.Verified)
+		if lastOK && res.Verified[n-1].End >= c.StartTS.T {
+			res.Verified[n-1].End = c.EndTS.T
+		} else {
+			res.Verified = append(res.Verified, Timeline{Start: c.StartTS.T, End: c.EndTS.T})
+		}
+		lastOK = true
+
+		if moveWatermark && c.EndTS.After(res.Watermark) {
+			res.Watermark = c.EndTS
+		}
+	}
+
+	if moveWatermark && !res.Watermark.IsZero() && (w == nil || res.Watermark.After(w.VerifiedTS)) {
+		err = SetVerifyWatermark(ctx, m, rs, res.Watermark)
+		if err != nil {
+			return nil, errors.Wrap(err, "set watermark")
+		}
+	}
+
+	return res, nil
+}
+
+// VerifyChunk reads the whole chunk file and checks that it can be
+// decompressed, consists of valid BSON documents ordered by time and
+// all its ops lie within the chunk time range. The first and the last ops
+// may not match the range exactly since noops (and excluded namespaces)
+// aren't written to chunks.
+// Old chunks with `.snappy` suffix are in fact S2, so such chunks are
+// read with S2 if snappy fails.
+func VerifyChunk(stg storage.Storage, c *OplogChunk) error {
+	err := verifyChunkFile(stg, c, c.Compression)
+	if err != nil && c.Compression == compress.CompressionTypeSNAPPY && errors.Is(err, snappy.ErrCorrupt) {
+		err = verifyChunkFile(stg, c, compress.CompressionTypeS2)
+	}
+
+	return err
+}
+
+func verifyChunkFile(stg storage.Storage, c *OplogChunk, cmp compress.CompressionType) error {
+	rdr, err := stg.SourceReader(c.FName)
+	if err != nil {
+		return errors.Wrap(err, "get object")
+	}
+	defer rdr.Close()
+
+	orr, err := compress.Decompress(rdr, cmp, nil)
+	if err != nil {
+		return errors.Wrap(err, "decompress")
+	}
+	defer orr.Close()
+
+	var first, last primitive.Timestamp
+	src := db.NewBufferlessBSONSource(orr)
+	for n := 0; ; n++ {
+		raw := src.LoadNext()
+		if raw == nil {
+			break
+		}
+
+		if err := bson.Raw(raw).Validate(); err != nil {
+			return errors.Wrapf(err, "op #%d: invalid bson", n)
+		}
+
+		op := struct {
+			TS primitive.Timestamp `bson:"ts"`
+		}{}
+		if err := bson.Unmarshal(raw, &op); err != nil {
+			return errors.Wrapf(err, "op #%d: decode", n)
+		}
+		if op.TS.Before(last) {
+			return errors.Errorf("op #%d: ts %v is before the previous op %v", n, op.TS, last)
+		}
+
+		if n == 0 {
+			first = op.TS
+		}
+		last = op.TS
+	}
+	if err := src.Err(); err != nil {
+		return errors.Wrap(err, "read ops")
+	}
+
+	if first.IsZero() {
+		return nil
+	}
+	if first.Before(c.StartTS) {
+		return errors.Errorf("the first op %v is before the chunk start %v", first, c.StartTS)
+	}
+	if last.After(c.EndTS) {
+		return errors.Errorf("the last op %v is after the chunk end %v", last, c.EndTS)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package oplog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestVerifyChunk(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(name string, cmp compress.CompressionType, data []byte) {
+		t.Helper()
+
+		buf := &bytes.Buffer{}
+		w, err := compress.Compress(buf, cmp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := stg.Save(name, buf, int64(buf.Len())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ops := func(tss ...uint32) []byte {
+		t.Helper()
+
+		var data []byte
+		for _, ts := range tss {
+			raw, err := bson.Marshal(bson.D{
+				{"ts", primitive.Timestamp{T: ts, I: 1}},
+				{"op", "i"},
+				{"ns", "db.c"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, raw...)
+		}
+		return data
+	}
+
+	chunk := func(name string, cmp compress.CompressionType, start, end uint32) *OplogChunk {
+		return &OplogChunk{
+			RS:          "rs1",
+			FName:       name,
+			Compression: cmp,
+			StartTS:     primitive.Timestamp{T: start, I: 1},
+			EndTS:       primitive.Timestamp{T: end, I: 1},
+		}
+	}
+
+	save("ok.s2", compress.CompressionTypeS2, ops(10, 11, 12))
+	save("noop.gz", compress.CompressionTypeGZIP, ops(11))
+	save("s2.snappy", compress.CompressionTypeS2, ops(10, 12))
+	save("bounds.s2", compress.CompressionTypeS2, ops(10, 13))
+	save("order.s2", compress.CompressionTypeS2, ops(10, 12, 11))
+	save("truncated.s2", compress.CompressionTypeS2, ops(10, 11, 12)[:50])
+	if err := stg.Save("broken.s2", strings.NewReader("not an s2 stream"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		c   *OplogChunk
+		err string
+	}{
+		{chunk("ok.s2", compress.CompressionTypeS2, 10, 12), ""},
+		{chunk("noop.gz", compress.CompressionTypeGZIP, 10, 12), ""},
+		{chunk("s2.snappy", compress.CompressionTypeSNAPPY, 10, 12), ""},
+		{chunk("bounds.s2", compress.CompressionTypeS2, 10, 12), "after the chunk end"},
+		{chunk("order.s2", compress.CompressionTypeS2, 10, 12), "before the previous op"},
+		{chunk("truncated.s2", compress.CompressionTypeS2, 10, 12), "read ops"},
+		{chunk("broken.s2", compress.CompressionTypeS2, 10, 12), "read ops"},
+		{chunk("missed.s2", compress.CompressionTypeS2, 10, 12), "get object"},
+	}
+	for _, tc := range cases {
+		err := VerifyChunk(stg, tc.c)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.c.FName, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got: %v", tc.c.FName, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package prio
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// MatchReadPref returns the agents of a replset eligible for the read
+// preference. Agents failing the health check and arbiters are skipped.
+// In contrast to the driver server selection, hidden members are eligible
+// and the staleness is the replication lag reported by the agent.
+func MatchReadPref(agents []topo.AgentStat, rp *config.ReadPreference) ([]topo.AgentStat, error) {
+	mode, err := readpref.ModeFromString(rp.Mode)
+	if err != nil {
+		return nil, errors.Wrap(err, "mode")
+	}
+
+	var primary, secondaries []topo.AgentStat
+	for _, a := range agents {
+		if ok, _ := a.OK(); !ok || a.Arbiter {
+			continue
+		}
+
+		switch a.State {
+		case defs.NodeStatePrimary:
+			primary = append(primary, a)
+		case defs.NodeStateSecondary:
+			if rp.MaxStalenessSeconds > 0 && a.ReplicationLag > rp.MaxStalenessSeconds {
+				continue
+			}
+			secondaries = append(secondaries, a)
+		}
+	}
+
+	switch mode {
+	case readpref.PrimaryMode:
+		return primary, nil
+	case readpref.PrimaryPreferredMode:
+		if len(primary) != 0 {
+			return primary, nil
+		}
+		return matchTagSets(secondaries, rp.TagSets), nil
+	case readpref.SecondaryMode:
+		return matchTagSets(secondaries, rp.TagSets), nil
+	case readpref.SecondaryPreferredMode:
+		if rv := matchTagSets(secondaries, rp.TagSets); len(rv) != 0 {
+			return rv, nil
+		}
+		return primary, nil
+	case readpref.NearestMode:
+		return matchTagSets(append(primary, secondaries...), rp.TagSets), nil
+	}
+
+	return nil, errors.Errorf("unsupported mode %s", mode)
+}
+
+// matchTagSets returns the agents matching the first tag set that
+// matches any of them. All agents match if there are no tag sets.
+func matchTagSets(agents []topo.AgentStat, sets []map[string]string) []topo.AgentStat {
+	if len(sets) == 0 {
+		return agents
+	}
+
+	for _, set := range sets {
+		var rv []topo.AgentStat
+		for _, a := range agents {
+			if hasTags(a.Tags, set) {
+				rv = append(rv, a)
+			}
+		}
+		if len(rv) != 0 {
+			return rv
+		}
+	}
+
+	return nil
+}
+
+func hasTags(tags, set map[string]string) bool {
+	for k, v := range set {
+		if t, ok := tags[k]; !ok || t != v {
+			return false
+		}
+	}
+	return true
+}
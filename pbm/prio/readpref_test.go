@@ -0,0 +1,109 @@
+package prio
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestMatchReadPref(t *testing.T) {
+	withTags := func(a topo.AgentStat, tags map[string]string) topo.AgentStat {
+		a.Tags = tags
+		return a
+	}
+	withLag := func(a topo.AgentStat, lag int) topo.AgentStat {
+		a.ReplicationLag = lag
+		return a
+	}
+
+	backupDC := map[string]string{"dc": "backup"}
+	agents := []topo.AgentStat{
+		newP("rs0", "rs01:27017"),
+		withLag(newS("rs0", "rs02:27017"), 200),
+		withTags(newH("rs0", "rs03:27017"), backupDC),
+		newA("rs0", "rs04:27017"),
+	}
+	secondaryOnly := []topo.AgentStat{agents[1], agents[2]}
+
+	testCases := []struct {
+		desc   string
+		rp     config.ReadPreference
+		agents []topo.AgentStat
+		want   []string
+	}{
+		{
+			desc: "primary",
+			rp:   config.ReadPreference{Mode: "primary"},
+			want: []string{"rs01:27017"},
+		},
+		{
+			desc: "secondary includes hidden",
+			rp:   config.ReadPreference{Mode: "secondary"},
+			want: []string{"rs02:27017", "rs03:27017"},
+		},
+		{
+			desc: "tag sets select hidden member",
+			rp: config.ReadPreference{
+				Mode:    "secondary",
+				TagSets: []map[string]string{{"dc": "east"}, backupDC},
+			},
+			want: []string{"rs03:27017"},
+		},
+		{
+			desc: "max staleness",
+			rp:   config.ReadPreference{Mode: "secondary", MaxStalenessSeconds: 120},
+			want: []string{"rs03:27017"},
+		},
+		{
+			desc: "secondary preferred falls back to primary",
+			rp: config.ReadPreference{
+				Mode:    "secondaryPreferred",
+				TagSets: []map[string]string{{"dc": "east"}},
+			},
+			want: []string{"rs01:27017"},
+		},
+		{
+			desc:   "primary preferred without primary",
+			rp:     config.ReadPreference{Mode: "primaryPreferred", TagSets: []map[string]string{backupDC}},
+			agents: secondaryOnly,
+			want:   []string{"rs03:27017"},
+		},
+		{
+			desc: "nearest",
+			rp:   config.ReadPreference{Mode: "nearest", MaxStalenessSeconds: 120},
+			want: []string{"rs01:27017", "rs03:27017"},
+		},
+		{
+			desc:   "no match",
+			rp:     config.ReadPreference{Mode: "primary"},
+			agents: secondaryOnly,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			in := tc.agents
+			if in == nil {
+				in = agents
+			}
+			got, err := MatchReadPref(in, &tc.rp)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var nodes []string
+			for _, a := range got {
+				nodes = append(nodes, a.Node)
+			}
+			if !reflect.DeepEqual(nodes, tc.want) {
+				t.Errorf("got %v, want %v", nodes, tc.want)
+			}
+		})
+	}
+
+	if _, err := MatchReadPref(agents, &config.ReadPreference{Mode: "any"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
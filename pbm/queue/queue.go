@@ -0,0 +1,356 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+type Status string
+
+const (
+	// StatusPending means the backup waits for the running operation
+	StatusPending Status = "pending"
+	// StatusStarted means the backup command has been sent
+	StatusStarted Status = "started"
+	// StatusCancelled means the entry was removed by `pbm cancel`
+	StatusCancelled Status = "cancelled"
+	// StatusExpired means the backup wasn't started within its TTL
+	StatusExpired Status = "expired"
+	// StatusFailed means the backup command couldn't be sent
+	StatusFailed Status = "failed"
+)
+
+// ErrConflict is returned by Enqueue if the last queued backup is
+// the same backup with other options.
+var ErrConflict = errors.New("conflicting backup is queued")
+
+// Entry is a backup deferred till the running operation is done.
+// The lead agent starts the pending entries one by one in the
+// submission order (see Pending).
+type Entry struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Backup    ctrl.BackupCmd     `bson:"backup"`
+	Initiator *ctrl.Initiator    `bson:"initiator,omitempty"`
+	Status    Status             `bson:"status"`
+	Reason    string             `bson:"reason,omitempty"`
+	Submitted int64              `bson:"submitted"`
+	ExpireAt  int64              `bson:"expireAt"`
+	Started   int64              `bson:"started,omitempty"`
+	// Name is the name of the backup given when it's started
+	Name string `bson:"name,omitempty"`
+	// OPID is the opid of the sent backup command
+	OPID string `bson:"opid,omitempty"`
+	// Collapsed is the number of the same backups collapsed into the entry
+	Collapsed int `bson:"collapsed,omitempty"`
+	// Tail marks the last pending entry (see Enqueue)
+	Tail bool `bson:"tail,omitempty"`
+}
+
+// enqueueAttempts is how many times Enqueue rereads the tail of the
+// queue changed by a concurrent Enqueue
+const enqueueAttempts = 5
+
+// errTailChanged means the tail entry has been changed since it was read
+var errTailChanged = errors.New("the tail of the queue has changed")
+
+// Enqueue adds the backup to the queue. If collapse is set and the last
+// pending entry is the same full backup, the backup is collapsed into it
+// and the entry is returned with true. The same backup with other
+// options is rejected with ErrConflict then.
+//
+// The last pending entry is marked as the tail. The mark is moved with
+// conditional updates and is unique among the pending entries (see the
+// index in the agent setup), so concurrent Enqueue calls either collapse
+// into the same tail or append one after another.
+func Enqueue(
+	ctx context.Context,
+	m connect.Client,
+	b ctrl.BackupCmd,
+	init *ctrl.Initiator,
+	ttl time.Duration,
+	collapse bool,
+) (*Entry, bool, error) {
+	for range enqueueAttempts {
+		e, collapsed, err := enqueue(ctx, m, b, init, ttl, collapse)
+		if !errors.Is(err, errTailChanged) {
+			return e, collapsed, err
+		}
+	}
+
+	return nil, false, errors.Wrap(errTailChanged, "too many concurrent submissions, try again")
+}
+
+func enqueue(
+	ctx context.Context,
+	m connect.Client,
+	b ctrl.BackupCmd,
+	init *ctrl.Initiator,
+	ttl time.Duration,
+	collapse bool,
+) (*Entry, bool, error) {
+	now := time.Now()
+
+	last, err := lastPending(ctx, m)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "get last pending")
+	}
+	if last != nil {
+		if collapse {
+			same, err := collapsible(&last.Backup, &b)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "opid %s", last.ID.Hex())
+			}
+			if same {
+				ok, err := collapseInto(ctx, m, last, now.Add(ttl))
+				if err != nil {
+					return nil, false, err
+				}
+				if !ok {
+					return nil, false, errTailChanged
+				}
+				return last, true, nil
+			}
+		}
+
+		res, err := m.QueueCollection().UpdateOne(ctx,
+			bson.D{{"_id", last.ID}, {"status", StatusPending}, {"tail", true}},
+			bson.D{{"$unset", bson.D{{"tail", ""}}}})
+		if err != nil {
+			return nil, false, errors.Wrap(err, "untail")
+		}
+		if res.MatchedCount == 0 {
+			return nil, false, errTailChanged
+		}
+	}
+
+	e := &Entry{
+		ID:        primitive.NewObjectID(),
+		Backup:    b,
+		Initiator: init,
+		Status:    StatusPending,
+		Submitted: now.Unix(),
+		ExpireAt:  now.Add(ttl).Unix(),
+		Tail:      true,
+	}
+	_, err = m.QueueCollection().InsertOne(ctx, e)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, false, errTailChanged
+		}
+		return nil, false, errors.Wrap(err, "insert")
+	}
+
+	return e, false, nil
+}
+
+// collapseInto counts the backup in the pending tail entry and extends
+// its TTL. It returns false if the entry isn't the pending tail anymore.
+func collapseInto(ctx context.Context, m connect.Client, e *Entry, expireAt time.Time) (bool, error) {
+	res, err := m.QueueCollection().UpdateOne(ctx,
+		bson.D{{"_id", e.ID}, {"status", StatusPending}, {"tail", true}},
+		bson.D{
+			{"$inc", bson.D{{"collapsed", 1}}},
+			{"$max", bson.D{{"expireAt", expireAt.Unix()}}},
+		})
+	if err != nil {
+		return false, errors.Wrap(err, "update")
+	}
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+
+	e.Collapsed++
+	e.ExpireAt = max(e.ExpireAt, expireAt.Unix())
+	return true, nil
+}
+
+// collapsible returns true if b is the same full backup as the queued
+// one, so it can be collapsed into it. The same backup with other options
+// is an ErrConflict. Incremental backups are never collapsed.
+func collapsible(queued, b *ctrl.BackupCmd) (bool, error) {
+	if !isFull(queued) || !isFull(b) || !sameTarget(queued, b) {
+		return false, nil
+	}
+	if !sameOptions(queued, b) {
+		return false, errors.Wrapf(ErrConflict, "%s backup with other options", b.Type)
+	}
+	return true, nil
+}
+
+func isFull(b *ctrl.BackupCmd) bool {
+	return b.Type != defs.IncrementalBackup || b.IncrBase
+}
+
+func sameTarget(a, b *ctrl.BackupCmd) bool {
+	return a.Type == b.Type &&
+		a.IncrBase == b.IncrBase &&
+		a.Profile == b.Profile &&
+		a.Replset == b.Replset &&
+		sameNamespaces(a.Namespaces, b.Namespaces)
+}
+
+func sameNamespaces(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func sameOptions(a, b *ctrl.BackupCmd) bool {
+	eq := func(x, y *int) bool { return x == y || (x != nil && y != nil && *x == *y) }
+	eq32 := func(x, y *int32) bool { return x == y || (x != nil && y != nil && *x == *y) }
+	ct := func(x, y *ctrl.ClusterTimeTarget) bool { return x == y || (x != nil && y != nil && *x == *y) }
+
+	return a.Compression == b.Compression &&
+		eq(a.CompressionLevel, b.CompressionLevel) &&
+		eq32(a.NumParallelColls, b.NumParallelColls) &&
+		a.Filelist == b.Filelist &&
+		maps.Equal(a.Labels, b.Labels) &&
+		ct(a.ClusterTime, b.ClusterTime) &&
+		a.MaxDurationSec == b.MaxDurationSec &&
+		a.ForceClaim == b.ForceClaim
+}
+
+func lastPending(ctx context.Context, m connect.Client) (*Entry, error) {
+	e := &Entry{}
+	err := m.QueueCollection().FindOne(ctx,
+		bson.D{{"status", StatusPending}, {"tail", true}}).
+		Decode(e)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Pending returns the pending entries in the submission order.
+func Pending(ctx context.Context, m connect.Client) ([]Entry, error) {
+	cur, err := m.QueueCollection().Find(ctx,
+		bson.D{{"status", StatusPending}},
+		options.Find().SetSort(bson.D{{"submitted", 1}, {"_id", 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	rv := []Entry{}
+	err = cur.All(ctx, &rv)
+	return rv, errors.Wrap(err, "decode")
+}
+
+// LastStarted returns the most recently started entry. Nil if none.
+func LastStarted(ctx context.Context, m connect.Client) (*Entry, error) {
+	e := &Entry{}
+	err := m.QueueCollection().FindOne(ctx,
+		bson.D{{"status", StatusStarted}},
+		options.FindOne().SetSort(bson.D{{"started", -1}})).
+		Decode(e)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "query")
+	}
+
+	return e, nil
+}
+
+// Start moves the pending entry to the started status with the backup
+// name. It returns false if the entry isn't pending anymore (e.g. it has
+// been cancelled or started by another agent).
+func Start(ctx context.Context, m connect.Client, id primitive.ObjectID, name string) (bool, error) {
+	res, err := m.QueueCollection().UpdateOne(ctx,
+		bson.D{{"_id", id}, {"status", StatusPending}},
+		bson.D{{"$set", bson.D{
+			{"status", StatusStarted},
+			{"name", name},
+			{"started", time.Now().Unix()},
+		}}})
+	if err != nil {
+		return false, errors.Wrap(err, "update")
+	}
+
+	return res.MatchedCount != 0, nil
+}
+
+// SetOPID sets the opid of the sent backup command.
+func SetOPID(ctx context.Context, m connect.Client, id primitive.ObjectID, opid string) error {
+	_, err := m.QueueCollection().UpdateOne(ctx,
+		bson.D{{"_id", id}},
+		bson.D{{"$set", bson.D{{"opid", opid}}}})
+	return errors.Wrap(err, "update")
+}
+
+// SetStatus moves the entry from the status to s. It returns false
+// if the entry isn't in the status.
+func SetStatus(
+	ctx context.Context,
+	m connect.Client,
+	id primitive.ObjectID,
+	from, s Status,
+	reason string,
+) (bool, error) {
+	res, err := m.QueueCollection().UpdateOne(ctx,
+		bson.D{{"_id", id}, {"status", from}},
+		bson.D{{"$set", bson.D{{"status", s}, {"reason", reason}}}})
+	if err != nil {
+		return false, errors.Wrap(err, "update")
+	}
+
+	return res.MatchedCount != 0, nil
+}
+
+// Cancel removes the pending entry from the queue. It returns
+// errors.ErrNotFound if there is no such entry.
+func Cancel(ctx context.Context, m connect.Client, id primitive.ObjectID) error {
+	ok, err := SetStatus(ctx, m, id, StatusPending, StatusCancelled, "cancelled by user")
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	e := &Entry{}
+	err = m.QueueCollection().FindOne(ctx, bson.D{{"_id", id}}).Decode(e)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errors.ErrNotFound
+		}
+		return errors.Wrap(err, "query")
+	}
+
+	msg := fmt.Sprintf("the entry is %s", e.Status)
+	if e.Status == StatusStarted {
+		msg += fmt.Sprintf(" as backup %q, use `pbm cancel-backup`", e.Name)
+	}
+	return errors.New(msg)
+}
+
+// DeleteFinished deletes the entries which aren't pending and were
+// started (or submitted, if never started) before the time.
+func DeleteFinished(ctx context.Context, m connect.Client, before time.Time) error {
+	_, err := m.QueueCollection().DeleteMany(ctx, bson.D{
+		{"status", bson.D{{"$ne", StatusPending}}},
+		{"$or", bson.A{
+			bson.D{{"started", bson.D{{"$lt", before.Unix()}}}},
+			bson.D{{"started", bson.D{{"$exists", false}}}, {"submitted", bson.D{{"$lt", before.Unix()}}}},
+		}},
+	})
+	return errors.Wrap(err, "delete")
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestCollapsible(t *testing.T) {
+	lvl := func(v int) *int { return &v }
+	logical := ctrl.BackupCmd{
+		Type:             defs.LogicalBackup,
+		Namespaces:       []string{"db.a", "db.b"},
+		Compression:      "zstd",
+		CompressionLevel: lvl(3),
+		Labels:           map[string]string{"team": "ops"},
+	}
+
+	cases := []struct {
+		name     string
+		queued   ctrl.BackupCmd
+		b        func(b *ctrl.BackupCmd)
+		same     bool
+		conflict bool
+	}{
+		{"same", logical, func(b *ctrl.BackupCmd) {
+			b.Name = "2026-10-14T10:00:00Z"
+			b.Namespaces = []string{"db.b", "db.a"}
+			b.CompressionLevel = lvl(3)
+		}, true, false},
+		{"other type", logical, func(b *ctrl.BackupCmd) { b.Type = defs.PhysicalBackup }, false, false},
+		{"other profile", logical, func(b *ctrl.BackupCmd) { b.Profile = "s3" }, false, false},
+		{"other namespaces", logical, func(b *ctrl.BackupCmd) { b.Namespaces = []string{"db.a"} }, false, false},
+		{"other level", logical, func(b *ctrl.BackupCmd) { b.CompressionLevel = nil }, false, true},
+		{"other labels", logical, func(b *ctrl.BackupCmd) { b.Labels = nil }, false, true},
+		{"incremental", ctrl.BackupCmd{Type: defs.IncrementalBackup},
+			func(b *ctrl.BackupCmd) {}, false, false},
+		{"incremental base", ctrl.BackupCmd{Type: defs.IncrementalBackup, IncrBase: true},
+			func(b *ctrl.BackupCmd) {}, true, false},
+		{"incremental after base", ctrl.BackupCmd{Type: defs.IncrementalBackup, IncrBase: true},
+			func(b *ctrl.BackupCmd) { b.IncrBase = false }, false, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := c.queued
+			b.Namespaces = append([]string(nil), c.queued.Namespaces...)
+			c.b(&b)
+
+			same, err := collapsible(&c.queued, &b)
+			if same != c.same {
+				t.Errorf("collapsible: want %v, got %v", c.same, same)
+			}
+			if got := errors.Is(err, ErrConflict); got != c.conflict {
+				t.Errorf("conflict: want %v, got %v", c.conflict, err)
+			}
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	logical := ctrl.BackupCmd{Type: defs.LogicalBackup, Compression: "zstd"}
+	other := logical
+	other.Compression = "gzip"
+
+	tail := func(b ctrl.BackupCmd) bson.D {
+		raw, err := bson.Marshal(&Entry{
+			ID:       primitive.NewObjectID(),
+			Backup:   b,
+			Status:   StatusPending,
+			ExpireAt: time.Now().Add(time.Hour).Unix(),
+			Tail:     true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := bson.D{}
+		if err := bson.Unmarshal(raw, &d); err != nil {
+			t.Fatal(err)
+		}
+		return mtest.CreateCursorResponse(0, "admin.pbmQueue", mtest.FirstBatch, d)
+	}
+	none := mtest.CreateCursorResponse(0, "admin.pbmQueue", mtest.FirstBatch)
+	updated := func(n int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{"n", n}, bson.E{"nModified", n})
+	}
+	inserted := mtest.CreateSuccessResponse(bson.E{"n", 1})
+	dupKey := mtest.CreateWriteErrorsResponse(mtest.WriteError{
+		Code:    11000,
+		Message: "E11000 duplicate key error",
+	})
+
+	var busyResp []bson.D
+	var busyCmds []string
+	for range enqueueAttempts {
+		busyResp = append(busyResp, none, dupKey)
+		busyCmds = append(busyCmds, "find", "insert")
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		collapse  bool
+		responses []bson.D
+		cmds      []string
+		collapsed bool
+		err       error
+	}{
+		{"empty queue", true, []bson.D{none, inserted},
+			[]string{"find", "insert"}, false, nil},
+		{"collapse", true, []bson.D{tail(logical), updated(1)},
+			[]string{"find", "update"}, true, nil},
+		{"no collapse", false, []bson.D{tail(logical), updated(1), inserted},
+			[]string{"find", "update", "insert"}, false, nil},
+		{"other backup", true, []bson.D{tail(ctrl.BackupCmd{Type: defs.PhysicalBackup}), updated(1), inserted},
+			[]string{"find", "update", "insert"}, false, nil},
+		{"conflict", true, []bson.D{tail(other)},
+			[]string{"find"}, false, ErrConflict},
+		{"tail started", true, []bson.D{tail(logical), updated(0), none, inserted},
+			[]string{"find", "update", "find", "insert"}, false, nil},
+		{"tail moved", false, []bson.D{tail(logical), updated(0), tail(logical), updated(1), inserted},
+			[]string{"find", "update", "find", "update", "insert"}, false, nil},
+		{"concurrent insert", true, []bson.D{none, dupKey, tail(logical), updated(1)},
+			[]string{"find", "insert", "find", "update"}, true, nil},
+		{"too many attempts", true, busyResp, busyCmds, false, errTailChanged},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+
+			e, collapsed, err := Enqueue(context.Background(), connect.UnsafeClient(mt.Client),
+				logical, nil, time.Hour, tt.collapse)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if collapsed != tt.collapsed {
+					t.Errorf("collapsed: want %v, got %v", tt.collapsed, collapsed)
+				}
+				if collapsed && e.Collapsed != 1 {
+					t.Errorf("expected 1 collapsed, got %d", e.Collapsed)
+				}
+				if !collapsed && !e.Tail {
+					t.Error("the new entry isn't the tail")
+				}
+			}
+
+			cmds := []string{}
+			for _, ev := range mt.GetAllStartedEvents() {
+				cmds = append(cmds, ev.CommandName)
+			}
+			if !slices.Equal(cmds, tt.cmds) {
+				t.Errorf("commands: want %v, got %v", tt.cmds, cmds)
+			}
+		})
+	}
+}
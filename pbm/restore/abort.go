@@ -0,0 +1,327 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/lock"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// AbortInfo is the outcome of the abort of the restore. Replsets is
+// the blast radius: the state each replset was left in.
+type AbortInfo struct {
+	Time   int64  `bson:"time" json:"time"`
+	Reason string `bson:"reason" json:"reason"`
+	// Auto is true if the restore is aborted after its agents were lost
+	Auto          bool             `bson:"auto,omitempty" json:"auto,omitempty"`
+	LocksReleased int              `bson:"locks_released" json:"locks_released"`
+	Replsets      []AbortedReplset `bson:"replsets" json:"replsets"`
+	// Cleanup is done by the agents after the abort (logical restore)
+	Cleanup []AbortCleanup `bson:"cleanup,omitempty" json:"cleanup,omitempty"`
+}
+
+type AbortedReplset struct {
+	Name   string      `bson:"name" json:"name"`
+	Status defs.Status `bson:"status" json:"status"`
+	// Namespaces are the namespaces the logical restore has written to
+	Namespaces []string `bson:"nss,omitempty" json:"nss,omitempty"`
+	// ModifiedNodes are the nodes which data directories the physical
+	// restore has modified. They have to be restored or resynced.
+	ModifiedNodes []string `bson:"modified_nodes,omitempty" json:"modified_nodes,omitempty"`
+}
+
+type AbortCleanup struct {
+	RS   string `bson:"rs" json:"rs"`
+	Node string `bson:"node" json:"node"`
+	Time int64  `bson:"time" json:"time"`
+	// Dropped is the temporary collections of the restore dropped on the node
+	Dropped           []string `bson:"dropped,omitempty" json:"dropped,omitempty"`
+	BalancerRestarted bool     `bson:"balancer_restarted,omitempty" json:"balancer_restarted,omitempty"`
+	// ProfilingRestored are the databases of the node with the profiling
+	// level turned off by the restore set back
+	ProfilingRestored []string `bson:"profiling_restored,omitempty" json:"profiling_restored,omitempty"`
+	Error             string   `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+func (a *AbortInfo) String() string {
+	s := fmt.Sprintf("aborted at %s: %s",
+		time.Unix(a.Time, 0).UTC().Format(time.RFC3339), a.Reason)
+	if a.Auto {
+		s += " (auto)"
+	}
+	if a.LocksReleased > 0 {
+		s += fmt.Sprintf("\nlocks released: %d", a.LocksReleased)
+	}
+	for _, rs := range a.Replsets {
+		s += fmt.Sprintf("\n%s [%s]", rs.Name, rs.Status)
+		switch {
+		case len(rs.ModifiedNodes) > 0:
+			s += " modified data directories: " + strings.Join(rs.ModifiedNodes, ", ")
+		case len(rs.Namespaces) > 0:
+			s += fmt.Sprintf(" %d namespaces: %s", len(rs.Namespaces), strings.Join(rs.Namespaces, ", "))
+		default:
+			s += " no data touched"
+		}
+	}
+	for _, c := range a.Cleanup {
+		s += fmt.Sprintf("\ncleanup %s/%s:", c.RS, c.Node)
+		if len(c.Dropped) > 0 {
+			s += " dropped " + strings.Join(c.Dropped, ", ") + ";"
+		}
+		if c.BalancerRestarted {
+			s += " balancer started;"
+		}
+		if len(c.ProfilingRestored) > 0 {
+			s += " profiling restored on " + strings.Join(c.ProfilingRestored, ", ") + ";"
+		}
+		if c.Error != "" {
+			s += " failed: " + c.Error
+		}
+		s = strings.TrimSuffix(s, ";")
+	}
+	return s
+}
+
+// ErrNotAbortable means the restore is finished already
+var ErrNotAbortable = errors.New("restore is finished")
+
+func checkAbortable(meta *RestoreMeta) error {
+	switch meta.Status {
+	case defs.StatusDone, defs.StatusPartlyDone, defs.StatusCancelled, defs.StatusAborted:
+		return errors.Wrapf(ErrNotAbortable, "%s status: %s", meta.Name, meta.Status)
+	}
+	return nil
+}
+
+// abortedReplsets returns the state the replsets of the restore are left in
+func abortedReplsets(meta *RestoreMeta) []AbortedReplset {
+	rv := make([]AbortedReplset, 0, len(meta.Replsets))
+	for i := range meta.Replsets {
+		rs := &meta.Replsets[i]
+		ar := AbortedReplset{Name: rs.Name, Status: rs.Status}
+		if meta.Type == defs.LogicalBackup {
+			ar.Namespaces = rs.Namespaces
+		} else {
+			for j := range rs.Nodes {
+				if physDataModified(&rs.Nodes[j]) {
+					ar.ModifiedNodes = append(ar.ModifiedNodes, rs.Nodes[j].Name)
+				}
+			}
+			slices.Sort(ar.ModifiedNodes)
+		}
+		rv = append(rv, ar)
+	}
+	slices.SortFunc(rv, func(a, b AbortedReplset) int { return strings.Compare(a.Name, b.Name) })
+	return rv
+}
+
+// physDataModified returns true if the physical restore has touched
+// the node data directory. The node flushes it right after moving
+// to the running state (see PhysRestore.Snapshot).
+func physDataModified(n *RestoreNode) bool {
+	for _, c := range n.Conditions {
+		if c.Status == defs.StatusRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAlive returns true if the running restore or any of its locks has
+// a fresh heartbeat. The restores which aren't alive are lost, they are
+// aborted by `pbm cancel-restore` or the cluster leader.
+func IsAlive(ctx context.Context, m connect.Client, meta *RestoreMeta) (bool, error) {
+	ts, err := topo.GetClusterTime(ctx, m)
+	if err != nil {
+		return false, errors.Wrap(err, "read cluster time")
+	}
+	staleSec := lock.StaleSec(ctx, m)
+	if meta.Status.IsRunning() && meta.Hb.T+staleSec >= ts.T {
+		return true, nil
+	}
+
+	for _, get := range []func(context.Context, connect.Client, *lock.LockHeader) ([]lock.LockData, error){
+		lock.GetLocks,
+		lock.GetOpLocks,
+	} {
+		locks, err := get(ctx, m, &lock.LockHeader{OPID: meta.OPID})
+		if err != nil {
+			return false, errors.Wrap(err, "get locks")
+		}
+		for _, l := range locks {
+			if !l.IsStale(ts, staleSec) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Abort moves the logical restore to the aborted state and releases its
+// locks. Agents have to clean up after that (see ctrl.CmdCancelRestore).
+func Abort(ctx context.Context, m connect.Client, name, reason string, auto bool) (*AbortInfo, error) {
+	meta, err := GetRestoreMeta(ctx, m, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if err := checkAbortable(meta); err != nil {
+		return nil, err
+	}
+	if meta.Type != defs.LogicalBackup {
+		return nil, errors.Errorf("%s is %s restore, it is aborted on the storage", name, meta.Type)
+	}
+
+	info := &AbortInfo{
+		Time:     time.Now().Unix(),
+		Reason:   reason,
+		Auto:     auto,
+		Replsets: abortedReplsets(meta),
+	}
+
+	f := bson.D{{"opid", meta.OPID}}
+	res, err := m.LockCollection().DeleteMany(ctx, f)
+	if err != nil {
+		return nil, errors.Wrap(err, "delete locks")
+	}
+	info.LocksReleased = int(res.DeletedCount)
+	res, err = m.LockOpCollection().DeleteMany(ctx, f)
+	if err != nil {
+		return nil, errors.Wrap(err, "delete op locks")
+	}
+	info.LocksReleased += int(res.DeletedCount)
+
+	if err := setRestoreAbort(ctx, m, name, info); err != nil {
+		return nil, errors.Wrap(err, "save abort info")
+	}
+	err = ChangeRestoreState(ctx, m, name, defs.StatusAborted, reason)
+	if err != nil {
+		return nil, errors.Wrap(err, "set restore state")
+	}
+
+	return info, nil
+}
+
+// AbortPhys moves the physical restore to the aborted state. The restore
+// has no locks and temporary collections as mongod is shut down during
+// the restore, its state is on the storage only.
+func AbortPhys(stg storage.Storage, name, reason string, l log.LogEvent) (*AbortInfo, error) {
+	meta, err := GetPhysRestoreMeta(name, stg, l)
+	if err != nil && meta == nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if err := checkAbortable(meta); err != nil {
+		return nil, err
+	}
+
+	info := &AbortInfo{
+		Time:     time.Now().Unix(),
+		Reason:   reason,
+		Replsets: abortedReplsets(meta),
+	}
+
+	err = util.RetryableWrite(stg,
+		path.Join(defs.PhysRestoresDir, name, "cluster."+string(defs.StatusAborted)),
+		[]byte(fmt.Sprintf("%d:%s", info.Time, reason)))
+	if err != nil {
+		return nil, errors.Wrap(err, "write cluster state")
+	}
+
+	meta.Status = defs.StatusAborted
+	meta.Error = reason
+	meta.LastTransitionTS = info.Time
+	meta.Abort = info
+	buf, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return nil, errors.Wrap(err, "encode restore meta")
+	}
+	err = util.RetryableWrite(stg, path.Join(defs.PhysRestoresDir, name)+".json", buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "write restore meta")
+	}
+
+	return info, nil
+}
+
+// CleanupAborted drops the temporary collections the aborted restore may
+// have left on the node, sets back the profiling levels of the node turned
+// off by the restore and, on the cluster leader, starts the balancer
+// stopped by the restore. The result is added to the abort info.
+func CleanupAborted(
+	ctx context.Context,
+	m connect.Client,
+	node *mongo.Client,
+	nodeInfo *topo.NodeInfo,
+	name string,
+) (*AbortCleanup, error) {
+	meta, err := GetRestoreMeta(ctx, m, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if meta.Status != defs.StatusAborted {
+		return nil, errors.Errorf("restore is not aborted, status: %s", meta.Status)
+	}
+
+	rv := &AbortCleanup{
+		RS:   nodeInfo.SetName,
+		Node: nodeInfo.Me,
+		Time: time.Now().Unix(),
+	}
+
+	var errs []error
+	colls, err := node.Database(defs.DB).ListCollectionNames(ctx,
+		bson.D{{"name", bson.M{"$in": bson.A{defs.TmpUsersCollection, defs.TmpRolesCollection}}}})
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "list temporary collections"))
+	} else if len(colls) > 0 {
+		if err := util.DropTMPcoll(ctx, node); err != nil {
+			errs = append(errs, err)
+		} else {
+			for _, c := range colls {
+				rv.Dropped = append(rv.Dropped, defs.DB+"."+c)
+			}
+			slices.Sort(rv.Dropped)
+		}
+	}
+
+	dbs, err := resumeAbortedProfiling(ctx, m, node, meta, nodeInfo.SetName, nodeInfo.Me)
+	rv.ProfilingRestored = dbs
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "restore profiling"))
+	}
+
+	if meta.BalancerStopped && nodeInfo.IsClusterLeader() {
+		err := topo.SetBalancerStatus(ctx, m, topo.BalancerModeOn)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "start balancer"))
+		} else {
+			rv.BalancerRestarted = true
+			if err := SetBalancerStopped(ctx, m, name, false); err != nil {
+				errs = append(errs, errors.Wrap(err, "save balancer state"))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		rv.Error = err.Error()
+	}
+	if err := addAbortCleanup(ctx, m, name, rv); err != nil {
+		return rv, errors.Wrap(err, "save cleanup")
+	}
+	return rv, nil
+}
@@ -0,0 +1,78 @@
+package restore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestAbortedReplsetsLogical(t *testing.T) {
+	meta := &RestoreMeta{
+		Type: defs.LogicalBackup,
+		Replsets: []RestoreReplset{
+			{Name: "rs1", Status: defs.StatusDumpDone, Namespaces: []string{"db.a", "db.b"}},
+			{Name: "cfg", Status: defs.StatusRunning},
+		},
+	}
+
+	want := []AbortedReplset{
+		{Name: "cfg", Status: defs.StatusRunning},
+		{Name: "rs1", Status: defs.StatusDumpDone, Namespaces: []string{"db.a", "db.b"}},
+	}
+	if got := abortedReplsets(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestAbortPhys(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := defs.PhysRestoresDir + "/r1/"
+	for name, data := range map[string]string{
+		"cluster.hb":                    "100",
+		"cluster.starting":              "100",
+		"cluster.running":               "110",
+		"rs.rs0/rs.running":             "110",
+		"rs.rs0/node.n1:27017.hb":       "100",
+		"rs.rs0/node.n1:27017.starting": "100",
+		"rs.rs0/node.n1:27017.running":  "110",
+		"rs.rs0/node.n2:27017.hb":       "100",
+		"rs.rs0/node.n2:27017.starting": "100",
+	} {
+		if err := stg.Save(dir+name, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := AbortPhys(stg, "r1", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AbortedReplset{{Name: "rs0", Status: defs.StatusRunning, ModifiedNodes: []string{"n1:27017"}}}
+	if !reflect.DeepEqual(info.Replsets, want) {
+		t.Errorf("got %+v, want %+v", info.Replsets, want)
+	}
+
+	meta, err := GetPhysRestoreMeta("r1", stg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Status != defs.StatusAborted || meta.Error != "test" {
+		t.Errorf("unexpected status %q: %q", meta.Status, meta.Error)
+	}
+	if meta.Abort == nil || !reflect.DeepEqual(meta.Abort.Replsets, want) {
+		t.Errorf("unexpected abort info: %+v", meta.Abort)
+	}
+
+	_, err = AbortPhys(stg, "r1", "test", nil)
+	if !errors.Is(err, ErrNotAbortable) {
+		t.Errorf("expected ErrNotAbortable, got %v", err)
+	}
+}
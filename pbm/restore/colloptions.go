@@ -0,0 +1,387 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+func (c *CollOptionsResult) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "set on %d collections", len(c.Applied))
+	if len(c.Failed) != 0 {
+		fmt.Fprintf(&s, ", not applied on %d:", len(c.Failed))
+		for _, f := range c.Failed {
+			fmt.Fprintf(&s, " %s (%s);", f.NS, f.Error)
+		}
+	}
+	return strings.TrimSuffix(s.String(), ";")
+}
+
+// collOptionsPlan is what brings the options of the restored collection
+// to the backup ones.
+type collOptionsPlan struct {
+	// cappedSize is the size to convert the collection to capped with.
+	// 0 if it's already capped (or shouldn't be).
+	cappedSize int64
+	// collMod are the fields of the collMod command (without the
+	// collection name). Empty if there is nothing to modify.
+	collMod bson.D
+	// applied are the names of the options the plan changes
+	applied []string
+	// errs are the options that can't be changed
+	errs []string
+}
+
+// planCollOptions compares the options of the backup with the current
+// options of the collection. The validator and validation level and
+// action are set as in the backup. If skipValidators is true, the
+// validator is removed instead. Capped collections get the size and max
+// of the backup. The collation and capped collections that aren't capped
+// in the backup can't be changed without recreating the collection.
+func planCollOptions(want, curr bson.D, skipValidators bool) collOptionsPlan {
+	p := collOptionsPlan{}
+
+	if skipValidators {
+		if v, ok := lookupOption(curr, "validator"); ok && !isEmptyDoc(v) {
+			p.collMod = append(p.collMod, bson.E{"validator", bson.D{}})
+			p.applied = append(p.applied, "validator (removed)")
+		}
+	} else {
+		for _, k := range []string{"validator", "validationLevel", "validationAction"} {
+			w, ok := lookupOption(want, k)
+			if !ok {
+				continue
+			}
+			if c, _ := lookupOption(curr, k); !sameOption(w, c) {
+				p.collMod = append(p.collMod, bson.E{k, w})
+				p.applied = append(p.applied, k)
+			}
+		}
+	}
+
+	wantCapped, currCapped := isCapped(want), isCapped(curr)
+	switch {
+	case wantCapped && !currCapped:
+		size, _ := lookupOption(want, "size")
+		p.cappedSize = optionInt(size)
+		if p.cappedSize <= 0 {
+			p.errs = append(p.errs, "capped: no size in the backup")
+			p.cappedSize = 0
+			break
+		}
+		p.applied = append(p.applied, "capped")
+		// convertToCapped has no max
+		if m, _ := lookupOption(want, "max"); optionInt(m) > 0 {
+			p.collMod = append(p.collMod, bson.E{"cappedMax", optionInt(m)})
+			p.applied = append(p.applied, "max")
+		}
+	case wantCapped && currCapped:
+		w, _ := lookupOption(want, "size")
+		c, _ := lookupOption(curr, "size")
+		if optionInt(w) > 0 && optionInt(w) != optionInt(c) {
+			p.collMod = append(p.collMod, bson.E{"cappedSize", optionInt(w)})
+			p.applied = append(p.applied, "size")
+		}
+		w, _ = lookupOption(want, "max")
+		c, _ = lookupOption(curr, "max")
+		if optionInt(w) != optionInt(c) {
+			p.collMod = append(p.collMod, bson.E{"cappedMax", optionInt(w)})
+			p.applied = append(p.applied, "max")
+		}
+	case !wantCapped && currCapped:
+		p.errs = append(p.errs, "capped: the collection is capped but not in the backup")
+	}
+
+	w, _ := lookupOption(want, "collation")
+	c, _ := lookupOption(curr, "collation")
+	if !sameOption(w, c) {
+		p.errs = append(p.errs, "collation: differs from the backup and can't be changed")
+	}
+
+	return p
+}
+
+func lookupOption(opts bson.D, key string) (any, bool) {
+	for _, e := range opts {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func isCapped(opts bson.D) bool {
+	v, _ := lookupOption(opts, "capped")
+	capped, _ := v.(bool)
+	return capped
+}
+
+func isEmptyDoc(v any) bool {
+	switch d := v.(type) {
+	case nil:
+		return true
+	case bson.D:
+		return len(d) == 0
+	case bson.M:
+		return len(d) == 0
+	}
+	return false
+}
+
+// optionInt returns the number of the option. The server may return
+// int32, int64 or double for the same option.
+func optionInt(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// sameOption returns true if the options are equal. Absent and empty
+// documents are the same.
+func sameOption(a, b any) bool {
+	if isEmptyDoc(a) && isEmptyDoc(b) {
+		return true
+	}
+	ab, err := bson.Marshal(bson.D{{"v", a}})
+	if err != nil {
+		return false
+	}
+	bb, err := bson.Marshal(bson.D{{"v", b}})
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
+
+// backupCollOptions returns the options of the collections of the backup
+// replsets rss by the namespace they are restored to. The backup metadata
+// has precedence over the archive metadata (see loadIndexesFrom), which is
+// the only source for backups made by older versions.
+func (r *Restore) backupCollOptions(
+	bcp *backup.BackupMeta,
+	rss []string,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) (map[string]bson.D, error) {
+	selected := util.MakeSelectedPred(nss)
+	if cloneNS.IsSpecified() {
+		selected = func(ns string) bool { return ns == cloneNS.FromNS }
+	}
+	rename := func(ns string) string {
+		if cloneNS.IsSpecified() {
+			return cloneNS.ToNS
+		}
+		return ns
+	}
+
+	rv := make(map[string]bson.D)
+	for ns, opts := range r.archiveOptions {
+		if selected(ns) {
+			rv[rename(ns)] = opts
+		}
+	}
+
+	for _, name := range rss {
+		rs := bcp.RS(name)
+		if rs == nil {
+			continue
+		}
+
+		for _, o := range rs.CollOptions {
+			if !selected(o.NS) {
+				continue
+			}
+
+			opts := bson.D{}
+			err := bson.UnmarshalExtJSON([]byte(o.Options), true, &opts)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %s options", o.NS)
+			}
+			rv[rename(o.NS)] = opts
+		}
+	}
+
+	skip := r.nsConflicts.Decided(ctrl.NSConflictSkip)
+	for ns := range rv {
+		db, coll, _ := strings.Cut(ns, ".")
+		if isSystemDB(db) || strings.HasPrefix(coll, "system.") || slices.Contains(skip, ns) {
+			delete(rv, ns)
+		}
+	}
+
+	return rv, nil
+}
+
+// restoreCollOptions re-asserts the options of the backup on the
+// collections restored to the replset: validators (or removes them with
+// `--skip-collection-options`), capped sizes, and checks the collation.
+// The collections with the options that couldn't be applied are logged
+// and reported in the restore metadata. Nothing fails the restore.
+// On sharded clusters it's done by restoreShardedCollOptions instead.
+func (r *Restore) restoreCollOptions(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) {
+	if r.nodeInfo.IsSharded() {
+		return
+	}
+
+	rss := []string{util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)}
+	rss = append(rss, r.merge[r.brief.SetName]...)
+	r.applyBackupCollOptions(ctx, r.nodeConn, bcp, rss, nss, cloneNS)
+}
+
+// restoreShardedCollOptions re-asserts the options of the backup on the
+// collections of the sharded cluster through mongos (see restoreCollOptions),
+// so they are set on all shards of the sharded collections and in the
+// config. It's done by the leader once the router config is restored.
+func (r *Restore) restoreShardedCollOptions(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) {
+	if !r.nodeInfo.IsSharded() || !r.isLeader() {
+		return
+	}
+
+	err := r.connectMongos(ctx)
+	if err != nil {
+		r.log.Warning("restore collection options: %v", err)
+		return
+	}
+	defer func() {
+		if err := r.mongos.Disconnect(context.Background()); err != nil {
+			r.log.Warning("disconnect mongos: %v", err)
+		}
+		r.mongos, r.mongosURI = nil, ""
+	}()
+
+	// the mongos may have cached the routing of the cluster before the restore
+	err = r.mongos.Database("admin").RunCommand(ctx, bson.D{{"flushRouterConfig", 1}}).Err()
+	if err != nil {
+		r.log.Warning("restore collection options: flushRouterConfig: %v", err)
+		return
+	}
+
+	rss := make([]string, 0, len(bcp.Replsets))
+	for _, rs := range bcp.Replsets {
+		rss = append(rss, rs.Name)
+	}
+	r.applyBackupCollOptions(ctx, r.mongos, bcp, rss, nss, cloneNS)
+}
+
+// applyBackupCollOptions sets the options of the collections of the backup
+// replsets rss with the connection m and saves the result.
+func (r *Restore) applyBackupCollOptions(
+	ctx context.Context,
+	m *mongo.Client,
+	bcp *backup.BackupMeta,
+	rss []string,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) {
+	want, err := r.backupCollOptions(bcp, rss, nss, cloneNS)
+	if err != nil {
+		r.log.Warning("restore collection options: %v", err)
+		return
+	}
+	if len(want) == 0 {
+		return
+	}
+
+	skip := r.options.SkipCollOptions
+	res := &CollOptionsResult{}
+	names := make([]string, 0, len(want))
+	for ns := range want {
+		names = append(names, ns)
+	}
+	slices.Sort(names)
+
+	for _, ns := range names {
+		applied, err := applyCollOptions(ctx, m, ns, want[ns], skip)
+		if len(applied) != 0 {
+			r.log.Info("collection options of %s are set: %s", ns, strings.Join(applied, ", "))
+			res.Applied = append(res.Applied, CollOptionsChange{NS: ns, Options: applied})
+		}
+		if err != nil {
+			r.log.Warning("collection options of %s: %v", ns, err)
+			res.Failed = append(res.Failed, CollOptionsChange{NS: ns, Error: err.Error()})
+		}
+	}
+	if len(res.Applied) == 0 && len(res.Failed) == 0 {
+		return
+	}
+
+	err = SetRestoreRSCollOptions(ctx, r.leadConn, r.name, r.nodeInfo.SetName, res)
+	if err != nil {
+		r.log.Warning("save collection options result: %v", err)
+	}
+}
+
+// applyCollOptions brings the options of the collection ns to want.
+// It returns the changed options. Views, time series and absent
+// collections are skipped.
+func applyCollOptions(ctx context.Context, m *mongo.Client, ns string, want bson.D, skip bool) ([]string, error) {
+	db, coll, _ := strings.Cut(ns, ".")
+	cur, err := m.Database(db).ListCollections(ctx,
+		bson.D{{"name", coll}, {"type", "collection"}})
+	if err != nil {
+		return nil, errors.Wrap(err, "list collections")
+	}
+	var specs []struct {
+		Options bson.D `bson:"options"`
+	}
+	if err := cur.All(ctx, &specs); err != nil {
+		return nil, errors.Wrap(err, "decode collection spec")
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	p := planCollOptions(want, specs[0].Options, skip)
+	var applied []string
+	if p.cappedSize != 0 {
+		err := m.Database(db).RunCommand(ctx,
+			bson.D{{"convertToCapped", coll}, {"size", p.cappedSize}}).Err()
+		if err != nil {
+			return nil, errors.Wrap(err, "convertToCapped")
+		}
+		applied = append(applied, "capped")
+	}
+	if len(p.collMod) != 0 {
+		cmd := append(bson.D{{"collMod", coll}}, p.collMod...)
+		err := m.Database(db).RunCommand(ctx, cmd).Err()
+		if err != nil {
+			return applied, errors.Wrap(err, "collMod")
+		}
+	}
+	applied = p.applied
+
+	if len(p.errs) != 0 {
+		return applied, errors.New(strings.Join(p.errs, "; "))
+	}
+	return applied, nil
+}
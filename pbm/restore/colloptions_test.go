@@ -0,0 +1,158 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestPlanCollOptions(t *testing.T) {
+	validator := bson.E{"validator", bson.D{{"$jsonSchema", bson.D{{"required", bson.A{"name"}}}}}}
+	level := bson.E{"validationLevel", "moderate"}
+	action := bson.E{"validationAction", "warn"}
+	collation := bson.E{"collation", bson.D{{"locale", "fr"}, {"strength", int32(1)}}}
+	capped := bson.D{{"capped", true}, {"size", int64(1048576)}, {"max", int32(100)}}
+
+	cases := []struct {
+		name       string
+		want, curr bson.D
+		skip       bool
+		cappedSize int64
+		collMod    bson.D
+		applied    []string
+		errs       int
+	}{
+		{
+			name: "same",
+			want: append(bson.D{validator, level, action, collation}, capped...),
+			curr: append(bson.D{validator, level, action, collation}, capped...),
+		},
+		{
+			name:    "validator",
+			want:    bson.D{validator, level, action},
+			curr:    bson.D{},
+			collMod: bson.D{validator, level, action},
+			applied: []string{"validator", "validationLevel", "validationAction"},
+		},
+		{
+			name:    "skip validator",
+			want:    bson.D{validator, level},
+			curr:    bson.D{validator, level},
+			skip:    true,
+			collMod: bson.D{{"validator", bson.D{}}},
+			applied: []string{"validator (removed)"},
+		},
+		{
+			name:       "capped with validator",
+			want:       append(bson.D{validator}, capped...),
+			curr:       bson.D{},
+			cappedSize: 1048576,
+			collMod:    bson.D{validator, {"cappedMax", int64(100)}},
+			applied:    []string{"validator", "capped", "max"},
+		},
+		{
+			name:    "capped size",
+			want:    capped,
+			curr:    bson.D{{"capped", true}, {"size", 4096.0}, {"max", int64(100)}},
+			collMod: bson.D{{"cappedSize", int64(1048576)}},
+			applied: []string{"size"},
+		},
+		{
+			name: "capped no size",
+			want: bson.D{{"capped", true}},
+			curr: bson.D{},
+			errs: 1,
+		},
+		{
+			name: "not capped in backup",
+			want: bson.D{collation},
+			curr: append(bson.D{collation}, capped...),
+			errs: 1,
+		},
+		{
+			name:       "capped with collation mismatch",
+			want:       append(bson.D{collation}, capped[:2]...),
+			curr:       bson.D{},
+			cappedSize: 1048576,
+			applied:    []string{"capped"},
+			errs:       1,
+		},
+		{
+			name:    "capped and validator with collation",
+			want:    append(bson.D{validator, action, collation}, capped...),
+			curr:    append(bson.D{collation, action}, capped[:2]...),
+			collMod: bson.D{validator, {"cappedMax", int64(100)}},
+			applied: []string{"validator", "max"},
+		},
+		{
+			name: "collation not in backup",
+			want: bson.D{},
+			curr: bson.D{collation},
+			errs: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := planCollOptions(tc.want, tc.curr, tc.skip)
+			if p.cappedSize != tc.cappedSize {
+				t.Errorf("capped size: want %d, got %d", tc.cappedSize, p.cappedSize)
+			}
+			if !reflect.DeepEqual(p.collMod, tc.collMod) {
+				t.Errorf("collMod: want %v, got %v", tc.collMod, p.collMod)
+			}
+			if !reflect.DeepEqual(p.applied, tc.applied) {
+				t.Errorf("applied: want %v, got %v", tc.applied, p.applied)
+			}
+			if len(p.errs) != tc.errs {
+				t.Errorf("errors: want %d, got %v", tc.errs, p.errs)
+			}
+		})
+	}
+}
+
+func TestBackupCollOptions(t *testing.T) {
+	bcp := &backup.BackupMeta{
+		Replsets: []backup.BackupReplset{
+			{Name: "rs0", CollOptions: []backup.CollOptions{
+				{NS: "db.a", Options: `{"capped":true,"size":{"$numberLong":"4096"}}`},
+				{NS: "db.system.js", Options: `{"validator":{"a":{"$exists":true}}}`},
+				{NS: "admin.c", Options: `{"capped":true,"size":{"$numberInt":"4096"}}`},
+			}},
+		},
+	}
+
+	r := &Restore{
+		brief: topo.NodeBrief{SetName: "rs0"},
+		archiveOptions: map[string]bson.D{
+			"db.a": {{"capped", true}, {"size", int32(1)}},
+			"db.b": {{"validationAction", "warn"}},
+		},
+	}
+
+	got, err := r.backupCollOptions(bcp, []string{"rs0"}, nil, snapshot.CloneNS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bson.D{
+		"db.a": {{"capped", true}, {"size", int64(4096)}},
+		"db.b": {{"validationAction", "warn"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	got, err = r.backupCollOptions(bcp, []string{"rs0"}, []string{"db.a"}, snapshot.CloneNS{FromNS: "db.a", ToNS: "db.a2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]bson.D{"db.a2": {{"capped", true}, {"size", int64(4096)}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clone: want %v, got %v", want, got)
+	}
+}
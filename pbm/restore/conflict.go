@@ -0,0 +1,184 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sort"
+
+	"github.com/mongodb/mongo-tools/mongorestore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// CollectionSpec is what identifies a collection (or a view) beyond its name
+type CollectionSpec struct {
+	Type string
+	// UUID is hex encoded. Empty for views.
+	UUID    string
+	Options bson.D
+}
+
+// ReadTargetCollections returns the collections of the user databases
+// of the replset m by namespace.
+func ReadTargetCollections(ctx context.Context, m *mongo.Client) (map[string]CollectionSpec, error) {
+	dbs, err := m.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list databases")
+	}
+
+	rv := make(map[string]CollectionSpec)
+	for _, db := range dbs {
+		if isSystemDB(db) {
+			continue
+		}
+
+		cur, err := m.Database(db).ListCollections(ctx, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list collections of %s", db)
+		}
+		for cur.Next(ctx) {
+			c := struct {
+				Name    string `bson:"name"`
+				Type    string `bson:"type"`
+				Options bson.D `bson:"options"`
+			}{}
+			if err := cur.Decode(&c); err != nil {
+				cur.Close(ctx)
+				return nil, errors.Wrapf(err, "decode collection of %s", db)
+			}
+
+			spec := CollectionSpec{Type: c.Type, Options: c.Options}
+			if _, data, ok := cur.Current.Lookup("info", "uuid").BinaryOK(); ok {
+				spec.UUID = hex.EncodeToString(data)
+			}
+			rv[archive.NSify(db, c.Name)] = spec
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list collections of %s", db)
+		}
+	}
+
+	return rv, nil
+}
+
+// BackupCollections returns the collections of the user databases of
+// the backup replset rs by namespace. They are read from the metadata of
+// the archive. Returns nil for backups of the legacy layout.
+func BackupCollections(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	rs string,
+	node string,
+) (map[string]CollectionSpec, error) {
+	if bcp.IsLegacyArchive() {
+		return nil, nil
+	}
+	meta := bcp.RS(rs)
+	if meta == nil {
+		return nil, errors.Errorf("no replset %q in the backup", rs)
+	}
+
+	stg, err := util.StorageFromConfig(&bcp.RSStorage(rs).StorageConf, node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+	nss, err := backup.ReadArchiveNamespaces(stg, meta.DumpName)
+	if err != nil {
+		return nil, errors.Wrap(err, "read namespaces")
+	}
+
+	rv := make(map[string]CollectionSpec, len(nss))
+	for _, ns := range nss {
+		if isSystemDB(ns.Database) {
+			continue
+		}
+
+		var md mongorestore.Metadata
+		err := bson.UnmarshalExtJSON([]byte(ns.Metadata), true, &md)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %s.%s metadata", ns.Database, ns.Collection)
+		}
+		rv[archive.NSify(ns.Database, ns.Collection)] = CollectionSpec{
+			Type:    ns.Type,
+			UUID:    md.UUID,
+			Options: md.Options,
+		}
+	}
+
+	return rv, nil
+}
+
+// NewNSConflicts compares the collections of the backup selected for
+// the restore with the existing collections of the target. The policy
+// decides what the restore does with each conflict.
+func NewNSConflicts(
+	bcp map[string]CollectionSpec,
+	target map[string]CollectionSpec,
+	selected func(ns string) bool,
+	policy string,
+) *ctrl.RestoreNSConflicts {
+	rv := &ctrl.RestoreNSConflicts{Policy: policy}
+	for ns, b := range bcp {
+		t, ok := target[ns]
+		if !ok || !selected(ns) {
+			continue
+		}
+
+		reasons := nsConflictReasons(b, t)
+		if len(reasons) == 0 {
+			continue
+		}
+
+		c := ctrl.NSConflict{NS: ns, Reasons: reasons}
+		if policy != ctrl.NSConflictAbort {
+			c.Decision = policy
+		}
+		rv.Conflicts = append(rv.Conflicts, c)
+	}
+	sort.Slice(rv.Conflicts, func(i, j int) bool { return rv.Conflicts[i].NS < rv.Conflicts[j].NS })
+
+	return rv
+}
+
+func nsConflictReasons(b, t CollectionSpec) []string {
+	var rv []string
+	if b.Type != t.Type {
+		// the options of a view aren't comparable with the collection ones
+		return []string{"type"}
+	}
+	if b.UUID != "" && t.UUID != "" && b.UUID != t.UUID {
+		rv = append(rv, "uuid")
+	}
+
+	if !sameOptions(b.Options, t.Options) {
+		rv = append(rv, "options")
+	}
+
+	return rv
+}
+
+func sameOptions(a, b bson.D) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	ba, err := bson.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := bson.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ba, bb)
+}
@@ -0,0 +1,57 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+)
+
+func TestNewNSConflicts(t *testing.T) {
+	bcp := map[string]CollectionSpec{
+		"db0.same":   {Type: "collection", UUID: "aa"},
+		"db0.uuid":   {Type: "collection", UUID: "bb"},
+		"db0.capped": {Type: "collection", UUID: "cc", Options: bson.D{{"capped", true}, {"size", int64(4096)}}},
+		"db0.view":   {Type: "view", Options: bson.D{{"viewOn", "same"}}},
+		"db0.new":    {Type: "collection", UUID: "dd"},
+		"db1.uuid":   {Type: "collection", UUID: "ee"},
+	}
+	target := map[string]CollectionSpec{
+		"db0.same":   {Type: "collection", UUID: "aa"},
+		"db0.uuid":   {Type: "collection", UUID: "01"},
+		"db0.capped": {Type: "collection", UUID: "02"},
+		"db0.view":   {Type: "collection", UUID: "03"},
+		"db0.other":  {Type: "collection", UUID: "04"},
+		"db1.uuid":   {Type: "collection", UUID: "05"},
+	}
+	all := func(string) bool { return true }
+
+	got := NewNSConflicts(bcp, target, all, ctrl.NSConflictSkip)
+	want := &ctrl.RestoreNSConflicts{
+		Policy: ctrl.NSConflictSkip,
+		Conflicts: []ctrl.NSConflict{
+			{NS: "db0.capped", Reasons: []string{"uuid", "options"}, Decision: ctrl.NSConflictSkip},
+			{NS: "db0.uuid", Reasons: []string{"uuid"}, Decision: ctrl.NSConflictSkip},
+			{NS: "db0.view", Reasons: []string{"type"}, Decision: ctrl.NSConflictSkip},
+			{NS: "db1.uuid", Reasons: []string{"uuid"}, Decision: ctrl.NSConflictSkip},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if d := got.Decided(ctrl.NSConflictSkip); len(d) != 4 || d[0] != "db0.capped" {
+		t.Errorf("decided: got %v", d)
+	}
+
+	db1 := func(ns string) bool { return ns == "db1.uuid" }
+	got = NewNSConflicts(bcp, target, db1, ctrl.NSConflictAbort)
+	want = &ctrl.RestoreNSConflicts{
+		Policy:    ctrl.NSConflictAbort,
+		Conflicts: []ctrl.NSConflict{{NS: "db1.uuid", Reasons: []string{"uuid"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selected: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package restore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const (
+	// copyAttempts is the number of attempts to copy a file
+	copyAttempts = 3
+	copyBackoff  = 5 * time.Second
+)
+
+type sourceReaderFn func(name string) (io.ReadCloser, error)
+
+// copyPart is the data of the backup to write to the destination file.
+// It is the whole file or its range of an incremental backup.
+type copyPart struct {
+	src  string
+	cmpr compress.CompressionType
+	file backup.File
+}
+
+// copyJob is the destination file and its data. The parts are written
+// one by one, the jobs are copied concurrently.
+type copyJob struct {
+	dst   string
+	fname string
+	parts []copyPart
+}
+
+// fileCopier copies the backup files to the dbpath during physical restore.
+// Each worker uses its own source reader (e.g. the S3 download with its
+// buffers). A failed part is retried without copying the completed ones again.
+type fileCopier struct {
+	readers  []sourceReaderFn
+	limit    *rateLimiter
+	progress *physProgress
+	log      log.LogEvent
+
+	attempts int
+	backoff  time.Duration
+
+	start time.Time
+}
+
+func newFileCopier(readers []sourceReaderFn, rateMb int, progress *physProgress, l log.LogEvent) *fileCopier {
+	return &fileCopier{
+		readers:  readers,
+		limit:    newRateLimiter(rateMb),
+		progress: progress,
+		log:      l,
+		attempts: copyAttempts,
+		backoff:  copyBackoff,
+		start:    time.Now(),
+	}
+}
+
+// copy copies the jobs and returns the first failure. On failure, the jobs
+// which are not started yet are skipped.
+func (c *fileCopier) copy(ctx context.Context, jobs []copyJob) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobc := make(chan copyJob)
+	errc := make(chan error, len(c.readers))
+	wg := sync.WaitGroup{}
+	for _, read := range c.readers {
+		wg.Add(1)
+		go func(read sourceReaderFn) {
+			defer wg.Done()
+
+			buf := make([]byte, 32*1024)
+			for job := range jobc {
+				err := c.copyJob(ctx, read, job, buf)
+				if err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+			}
+		}(read)
+	}
+
+loop:
+	for _, job := range jobs {
+		select {
+		case jobc <- job:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobc)
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+func (c *fileCopier) copyJob(ctx context.Context, read sourceReaderFn, job copyJob, buf []byte) error {
+	err := os.MkdirAll(filepath.Dir(job.dst), os.ModeDir|0o700)
+	if err != nil {
+		return errors.Wrapf(err, "create path %s", filepath.Dir(job.dst))
+	}
+
+	for _, p := range job.parts {
+		c.log.Info("copy <%s> to <%s>", p.src, job.dst)
+		c.progress.update(func(pr *PhysNodeProgress) { pr.File = job.fname })
+
+		err := c.copyPart(ctx, read, job.dst, p, buf)
+		if err != nil {
+			return err
+		}
+		c.progress.update(func(pr *PhysNodeProgress) { pr.Files++ })
+	}
+
+	return nil
+}
+
+func (c *fileCopier) copyPart(ctx context.Context, read sourceReaderFn, dst string, p copyPart, buf []byte) error {
+	wait := c.backoff
+	for i := 1; ; i++ {
+		n, err := c.copyOnce(read, dst, p, buf)
+		if err == nil {
+			return nil
+		}
+
+		// the part is copied again
+		c.progress.update(func(pr *PhysNodeProgress) { pr.Bytes -= n })
+		if i >= c.attempts || ctx.Err() != nil {
+			return err
+		}
+
+		c.log.Warning("%v. Retry in %v (attempt %d/%d)", err, wait, i+1, c.attempts)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// copyOnce copies the part and returns the number of written bytes.
+func (c *fileCopier) copyOnce(read sourceReaderFn, dst string, p copyPart, buf []byte) (int64, error) {
+	sr, err := read(p.src)
+	if err != nil {
+		return 0, errors.Wrapf(err, "create source reader for <%s>", p.src)
+	}
+	defer sr.Close()
+
+	data, err := compress.Decompress(c.limit.reader(sr), p.cmpr, c.log.Info)
+	if err != nil {
+		return 0, errors.Wrapf(err, "decompress object %s", p.src)
+	}
+	defer data.Close()
+
+	fw, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, p.file.Fmode)
+	if err != nil {
+		return 0, errors.Wrapf(err, "create/open destination file <%s>", dst)
+	}
+	defer fw.Close()
+
+	if p.file.Off != 0 {
+		_, err := fw.Seek(p.file.Off, io.SeekStart)
+		if err != nil {
+			return 0, errors.Wrapf(err, "set file offset <%s>|%d", dst, p.file.Off)
+		}
+	}
+
+	n, err := io.CopyBuffer(&progressWriter{w: fw, c: c}, data, buf)
+	if err != nil {
+		return n, errors.Wrapf(err, "copy file <%s>", dst)
+	}
+
+	if p.file.Size != 0 {
+		err = fw.Truncate(p.file.Size)
+		if err != nil {
+			return n, errors.Wrapf(err, "truncate file <%s>|%d", dst, p.file.Size)
+		}
+	}
+
+	return n, fw.Close()
+}
+
+// progressWriter counts the written bytes in the progress.
+type progressWriter struct {
+	w io.Writer
+	c *fileCopier
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.c.progress.update(func(pr *PhysNodeProgress) {
+		pr.Bytes += int64(n)
+		if d := time.Since(w.c.start).Seconds(); d > 0 {
+			pr.BytesPerSec = float64(pr.Bytes) / d
+		}
+	})
+
+	return n, err
+}
+
+// rateLimiter limits the combined rate of the readers.
+type rateLimiter struct {
+	mx   sync.Mutex
+	rate float64 // bytes per second
+	next time.Time
+}
+
+// newRateLimiter returns the limiter of rateMb MB/s or nil if rateMb is 0.
+func newRateLimiter(rateMb int) *rateLimiter {
+	if rateMb <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rateMb) * (1 << 20)}
+}
+
+// wait blocks until n more bytes fit the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mx.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	t := l.next
+	l.next = t.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mx.Unlock()
+
+	time.Sleep(time.Until(t))
+}
+
+// reader returns r limited by l. It returns r if l is nil.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+// maxLimitedRead is the max size of a read, so the readers get the
+// rate in turns.
+const maxLimitedRead = 256 << 10
+
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxLimitedRead {
+		p = p[:maxLimitedRead]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.wait(n)
+	}
+	return n, err
+}
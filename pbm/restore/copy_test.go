@@ -0,0 +1,236 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+// flakyReader fails the first reads of the files.
+type flakyReader struct {
+	stg storage.Storage
+
+	mx    sync.Mutex
+	fails map[string]int
+	reads map[string]int
+}
+
+func (r *flakyReader) read(name string) (io.ReadCloser, error) {
+	r.mx.Lock()
+	r.reads[name]++
+	fail := r.fails[name] > 0
+	r.fails[name]--
+	r.mx.Unlock()
+
+	if fail {
+		return nil, errors.New("connection reset")
+	}
+	return r.stg.SourceReader(name)
+}
+
+// saveFiles saves n compressed files of size bytes to stg and returns
+// the copy jobs of them to dbpath.
+func saveFiles(t testing.TB, stg storage.Storage, dbpath string, n, size int) ([]copyJob, map[string][]byte) {
+	t.Helper()
+
+	var jobs []copyJob
+	data := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("collection-%d.wt", i)
+		doc := fmt.Sprintf("document %d ", i)
+		b := bytes.Repeat([]byte(doc), size/len(doc)+1)[:size]
+
+		var buf bytes.Buffer
+		w, err := compress.Compress(&buf, compress.CompressionTypeS2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(b)
+		w.Close()
+		src := "bcp/rs0/" + name + compress.CompressionTypeS2.Suffix()
+		if err := stg.Save(src, &buf, int64(buf.Len())); err != nil {
+			t.Fatal(err)
+		}
+
+		data[name] = b
+		jobs = append(jobs, copyJob{
+			dst:   filepath.Join(dbpath, name),
+			fname: name,
+			parts: []copyPart{{
+				src:  src,
+				cmpr: compress.CompressionTypeS2,
+				file: backup.File{Name: name, Size: int64(size), Fmode: 0o600},
+			}},
+		})
+	}
+
+	return jobs, data
+}
+
+func TestFileCopier(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbpath := t.TempDir()
+	jobs, data := saveFiles(t, stg, dbpath, 10, 100<<10)
+
+	// a range of the file of an incremental backup
+	patch := bytes.Repeat([]byte{'x'}, 1000)
+	if err := stg.Save("inc/rs0/collection-1.wt.0-1000", bytes.NewReader(patch), int64(len(patch))); err != nil {
+		t.Fatal(err)
+	}
+	jobs[1].parts = append(jobs[1].parts, copyPart{
+		src:  "inc/rs0/collection-1.wt.0-1000",
+		cmpr: compress.CompressionTypeNone,
+		file: backup.File{Name: "collection-1.wt", Off: 0, Len: 1000, Size: 100 << 10, Fmode: 0o600},
+	})
+	data["collection-1.wt"] = append(patch, data["collection-1.wt"][1000:]...)
+
+	fr := &flakyReader{
+		stg:   stg,
+		fails: map[string]int{jobs[3].parts[0].src: 2},
+		reads: map[string]int{},
+	}
+	readers := []sourceReaderFn{fr.read, fr.read, fr.read, fr.read}
+	prg := &physProgress{}
+	cp := newFileCopier(readers, 0, prg, log.DiscardEvent)
+	cp.backoff = time.Millisecond
+
+	if err := cp.copy(context.Background(), jobs); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range data {
+		got, err := os.ReadFile(filepath.Join(dbpath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: data mismatch", name)
+		}
+	}
+	for src, n := range fr.reads {
+		want := 1
+		if src == jobs[3].parts[0].src {
+			want = 3
+		}
+		if n != want {
+			t.Errorf("%s: read %d times, want %d", src, n, want)
+		}
+	}
+	p, _ := prg.get()
+	if p.Files != 11 || p.Bytes != 10*100<<10+1000 || p.BytesPerSec <= 0 {
+		t.Errorf("unexpected progress: %+v", p)
+	}
+
+	// a failed file fails the copy after all attempts
+	fr.fails = map[string]int{jobs[5].parts[0].src: 10}
+	fr.reads = map[string]int{}
+	err = cp.copy(context.Background(), jobs)
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected read error, got %v", err)
+	}
+	if n := fr.reads[jobs[5].parts[0].src]; n != copyAttempts {
+		t.Errorf("read %d times, want %d", n, copyAttempts)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter")
+	}
+
+	l := newRateLimiter(8)
+	src := bytes.Repeat([]byte{1}, 1<<20)
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(io.Discard, l.reader(bytes.NewReader(src)))
+		}()
+	}
+	wg.Wait()
+
+	// 2MB at 8MB/s, the first read isn't delayed
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("copied in %v", d)
+	}
+}
+
+// BenchmarkCopyFiles copies 32 files of 8MB. The storage is the filesystem
+// or MinIO (or any S3) if PBM_BENCH_S3_ENDPOINT is set, e.g.:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	PBM_BENCH_S3_ENDPOINT=http://localhost:9000 PBM_BENCH_S3_BUCKET=bench \
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin \
+//	go test -run - -bench CopyFiles ./pbm/restore
+func BenchmarkCopyFiles(b *testing.B) {
+	const files, size = 32, 8 << 20
+
+	stg, newReader := benchStorage(b)
+	dbpath := b.TempDir()
+	jobs, _ := saveFiles(b, stg, dbpath, files, size)
+
+	for _, parallel := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("files-%d", parallel), func(b *testing.B) {
+			b.SetBytes(files * size)
+			for i := 0; i < b.N; i++ {
+				readers := make([]sourceReaderFn, parallel)
+				for j := range readers {
+					readers[j] = newReader()
+				}
+				cp := newFileCopier(readers, 0, &physProgress{}, log.DiscardEvent)
+				if err := cp.copy(context.Background(), jobs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchStorage(b *testing.B) (storage.Storage, func() sourceReaderFn) {
+	b.Helper()
+
+	endpoint := os.Getenv("PBM_BENCH_S3_ENDPOINT")
+	if endpoint == "" {
+		stg, err := fs.New(&fs.Config{Path: b.TempDir()})
+		if err != nil {
+			b.Fatal(err)
+		}
+		return stg, func() sourceReaderFn { return stg.SourceReader }
+	}
+
+	pathStyle := true
+	stg, err := s3.New(&s3.Config{
+		Region:         "us-east-1",
+		EndpointURL:    endpoint,
+		ForcePathStyle: &pathStyle,
+		Bucket:         os.Getenv("PBM_BENCH_S3_BUCKET"),
+		Prefix:         fmt.Sprintf("pbm-bench-%d", time.Now().UnixNano()),
+		Credentials: s3.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+	}, "bench", log.DiscardEvent)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return stg, func() sourceReaderFn { return stg.NewDownload(0, 0, 0).SourceReader }
+}
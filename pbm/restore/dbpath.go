@@ -0,0 +1,218 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// dstPath returns the name of the file relative to the dbpath and its
+// destination path.
+func dstPath(dbpath string, set files, f backup.File) (string, string) {
+	fname := f.Name
+	switch {
+	case set.dbpath != "":
+		// cut dbpath from destination if there is any (see PBM-1058)
+		fname = strings.TrimPrefix(fname, set.dbpath)
+	case filepath.IsAbs(fname) && set.srcDBpath != "":
+		// the absolute names within the dbpath of the backed up node
+		// are placed to the dbpath of the restore
+		if rel, err := filepath.Rel(set.srcDBpath, fname); err == nil && !strings.HasPrefix(rel, "..") {
+			fname = rel
+		}
+	}
+
+	return fname, filepath.Join(dbpath, fname)
+}
+
+// isMountedDir returns true if the entry of the dir is a directory on
+// another filesystem (e.g. the journal on a separate mount). Such entries
+// are kept and only their content is replaced. The links aren't followed.
+func isMountedDir(dir string, fi os.FileInfo) bool {
+	if !fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+
+	d, err1 := device(dir)
+	e, err2 := device(filepath.Join(dir, fi.Name()))
+	return err1 == nil && err2 == nil && d != e
+}
+
+func device(p string) (uint64, error) {
+	var st syscall.Stat_t
+	err := syscall.Stat(p, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil //nolint:unconvert
+}
+
+// removeAll removes the content of the dir. Mount points are kept, their
+// content is removed. Links are removed, not what they point to.
+func removeAll(dir string, l log.LogEvent) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "open dir")
+	}
+	defer d.Close()
+
+	entries, err := d.Readdir(-1)
+	if err != nil {
+		return errors.Wrap(err, "read file names")
+	}
+	for _, e := range entries {
+		n := e.Name()
+		if n == internalMongodLog {
+			continue
+		}
+		if isMountedDir(dir, e) {
+			l.Debug("keep %s, remove its content", filepath.Join(dir, n))
+			err = removeAll(filepath.Join(dir, n), l)
+			if err != nil {
+				return errors.Wrapf(err, "remove content of '%s'", n)
+			}
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(dir, n))
+		if err != nil {
+			return errors.Wrapf(err, "remove '%s'", n)
+		}
+		l.Debug("remove %s", filepath.Join(dir, n))
+	}
+	return nil
+}
+
+// dataUsage adds the size of the files in the dir the same way removeAll
+// removes them to the usage by the device.
+func dataUsage(dir string, usage map[uint64]int64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "read dir %s", dir)
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return errors.Wrapf(err, "stat %s", e.Name())
+		}
+
+		p := filepath.Join(dir, e.Name())
+		if fi.IsDir() {
+			err = dataUsage(p, usage)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		dev, err := device(p)
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", p)
+		}
+		usage[dev] += fi.Size()
+	}
+
+	return nil
+}
+
+// existingDevice returns the device of the path or of its nearest
+// existing parent and that path.
+func existingDevice(p string) (uint64, string, error) {
+	for {
+		dev, err := device(p)
+		if err == nil {
+			return dev, p, nil
+		}
+		parent := filepath.Dir(p)
+		if !errors.Is(err, os.ErrNotExist) || parent == p {
+			return 0, "", errors.Wrapf(err, "stat %s", p)
+		}
+		p = parent
+	}
+}
+
+// checkDiskSpace checks that the filesystems of the destination files
+// have space for the files of the sizes. The data in the dbpath is removed
+// before the copy, so its space is counted as available.
+func checkDiskSpace(dbpath string, sizes map[string]int64) error {
+	type fsSpace struct {
+		path string
+		need int64
+	}
+
+	byDev := make(map[uint64]*fsSpace)
+	for dst, size := range sizes {
+		dev, p, err := existingDevice(filepath.Dir(dst))
+		if err != nil {
+			return err
+		}
+		s, ok := byDev[dev]
+		if !ok {
+			s = &fsSpace{path: p}
+			byDev[dev] = s
+		}
+		s.need += size
+	}
+
+	usage := make(map[uint64]int64)
+	err := dataUsage(dbpath, usage)
+	if err != nil {
+		return errors.Wrap(err, "get dbpath usage")
+	}
+
+	var errs []string
+	for dev, s := range byDev {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(s.path, &st)
+		if err != nil {
+			return errors.Wrapf(err, "statfs %s", s.path)
+		}
+		free := int64(st.Bavail) * int64(st.Bsize) //nolint:unconvert
+		if avail := free + usage[dev]; s.need > avail {
+			errs = append(errs, errors.Errorf("%s: need %s, available %s (%s free and %s of the current data)",
+				s.path, storage.PrettySize(s.need), storage.PrettySize(avail),
+				storage.PrettySize(free), storage.PrettySize(usage[dev])).Error())
+		}
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return errors.Errorf("not enough disk space: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// fileSizes returns the final sizes of the files of the sets by their
+// destination paths.
+func fileSizes(dbpath string, sets []files) map[string]int64 {
+	sizes := make(map[string]int64)
+	// from the base to the target backup, so the target size wins
+	for i := len(sets) - 1; i >= 0; i-- {
+		if sets[i].BcpName == bcpDir {
+			continue
+		}
+		for _, f := range sets[i].Data {
+			_, dst := dstPath(dbpath, sets[i], f)
+			size := f.Size
+			if size == 0 {
+				size = f.Len
+			}
+			sizes[dst] = size
+		}
+	}
+
+	return sizes
+}
@@ -0,0 +1,121 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestRemoveAllKeepsTargetsOfLinks(t *testing.T) {
+	dbpath := t.TempDir()
+	journal := t.TempDir()
+
+	for _, f := range []string{
+		filepath.Join(dbpath, "collection-0.wt"),
+		filepath.Join(dbpath, internalMongodLog),
+		filepath.Join(journal, "WiredTigerLog.0000000001"),
+	} {
+		if err := os.WriteFile(f, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dbpath, "db"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(journal, filepath.Join(dbpath, "journal")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeAll(dbpath, log.DiscardEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != internalMongodLog {
+		t.Errorf("unexpected dbpath content: %v", names)
+	}
+	// the link is removed, not followed
+	if entries, err := os.ReadDir(journal); err != nil || len(entries) != 1 {
+		t.Errorf("content of the link target is changed: %v %v", entries, err)
+	}
+}
+
+func TestFileSizes(t *testing.T) {
+	sets := []files{
+		{
+			BcpName: "inc",
+			Data: []backup.File{
+				{Name: "collection-0.wt", Off: 0, Len: 10, Size: 200},
+				{Name: "WiredTiger.wt", Len: 30},
+			},
+		},
+		{
+			BcpName: "base",
+			dbpath:  "/data/db/",
+			Data: []backup.File{
+				{Name: "/data/db/collection-0.wt", Size: 100},
+				{Name: "/data/db/collection-1.wt", Size: 50},
+			},
+		},
+		{
+			BcpName:   "old",
+			srcDBpath: "/data/db",
+			Data: []backup.File{
+				{Name: "/data/db/index-1.wt", Size: 10},
+			},
+		},
+		{
+			BcpName: bcpDir,
+			Data:    []backup.File{{Name: "db/"}},
+		},
+	}
+
+	got := fileSizes("/mnt/data", sets)
+	want := map[string]int64{
+		"/mnt/data/collection-0.wt": 200,
+		"/mnt/data/collection-1.wt": 50,
+		"/mnt/data/WiredTiger.wt":   30,
+		"/mnt/data/index-1.wt":      10,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %d, want %d", k, got[k], v)
+		}
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dbpath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dbpath, "collection-0.wt"), make([]byte, 1<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the current data is removed before the copy
+	err := checkDiskSpace(dbpath, map[string]int64{
+		filepath.Join(dbpath, "db", "collection-0.wt"): 1 << 20,
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = checkDiskSpace(dbpath, map[string]int64{
+		filepath.Join(dbpath, "collection-0.wt"): 1 << 62,
+	})
+	if err == nil || !strings.Contains(err.Error(), "not enough disk space") {
+		t.Errorf("expected not enough space error, got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// RestoreOptions are the options the restore is started with
+type RestoreOptions struct {
+	Namespaces          []string             `bson:"nss,omitempty" json:"nss,omitempty"`
+	NamespaceFrom       string               `bson:"ns_from,omitempty" json:"ns_from,omitempty"`
+	NamespaceTo         string               `bson:"ns_to,omitempty" json:"ns_to,omitempty"`
+	UsersAndRoles       bool                 `bson:"users_and_roles,omitempty" json:"users_and_roles,omitempty"`
+	UsersAndRolesOnly   bool                 `bson:"users_and_roles_only,omitempty" json:"users_and_roles_only,omitempty"`
+	UsersAndRolesMode   string               `bson:"users_and_roles_mode,omitempty" json:"users_and_roles_mode,omitempty"`
+	NoDrop              bool                 `bson:"no_drop,omitempty" json:"no_drop,omitempty"`
+	SkipCollOptions     bool                 `bson:"skip_coll_options,omitempty" json:"skip_coll_options,omitempty"`
+	KeepProfiling       bool                 `bson:"keep_profiling,omitempty" json:"keep_profiling,omitempty"`
+	RSMap               map[string]string    `bson:"rs_map,omitempty" json:"rs_map,omitempty"`
+	Replset             string               `bson:"replset,omitempty" json:"replset,omitempty"`
+	PITR                *primitive.Timestamp `bson:"pitr,omitempty" json:"pitr,omitempty"`
+	SourceCluster       string               `bson:"source_cluster,omitempty" json:"source_cluster,omitempty"`
+	NumParallelColls    *int32               `bson:"num_parallel_colls,omitempty" json:"num_parallel_colls,omitempty"`
+	NumInsertionWorkers *int32               `bson:"num_insertion_workers,omitempty" json:"num_insertion_workers,omitempty"`
+	External            bool                 `bson:"external,omitempty" json:"external,omitempty"`
+	ExternalTS          *primitive.Timestamp `bson:"external_ts,omitempty" json:"external_ts,omitempty"`
+	DBpathMap           map[string]string    `bson:"dbpath_map,omitempty" json:"dbpath_map,omitempty"`
+}
+
+func NewRestoreOptions(cmd *ctrl.RestoreCmd) *RestoreOptions {
+	rv := &RestoreOptions{
+		Namespaces:          cmd.Namespaces,
+		NamespaceFrom:       cmd.NamespaceFrom,
+		NamespaceTo:         cmd.NamespaceTo,
+		UsersAndRoles:       cmd.UsersAndRoles,
+		UsersAndRolesOnly:   cmd.UsersAndRolesOnly,
+		UsersAndRolesMode:   cmd.UsersAndRolesMode,
+		NoDrop:              cmd.NoDrop,
+		SkipCollOptions:     cmd.SkipCollOptions,
+		KeepProfiling:       cmd.KeepProfiling,
+		RSMap:               cmd.RSMap,
+		Replset:             cmd.Replset,
+		SourceCluster:       cmd.SourceCluster,
+		NumParallelColls:    cmd.NumParallelColls,
+		NumInsertionWorkers: cmd.NumInsertionWorkers,
+		External:            cmd.External,
+		DBpathMap:           cmd.DBpathMap,
+	}
+	if !cmd.OplogTS.IsZero() {
+		rv.PITR = &cmd.OplogTS
+	}
+	if !cmd.ExtTS.IsZero() {
+		rv.ExternalTS = &cmd.ExtTS
+	}
+
+	return rv
+}
+
+// PhaseTiming is the time spent in the status
+type PhaseTiming struct {
+	Status      defs.Status `json:"status" yaml:"status"`
+	StartTS     int64       `json:"start_ts" yaml:"-"`
+	DurationSec int64       `json:"duration_sec" yaml:"duration_sec"`
+}
+
+// Phases returns the time spent in each status of the conditions until
+// the next one. The last status of the running restore lasts until now.
+// The final statuses (done, error, canceled) are not phases.
+func (b Conditions) Phases(now int64) []PhaseTiming {
+	rv := []PhaseTiming{}
+	for i, c := range b {
+		if !c.Status.IsRunning() {
+			continue
+		}
+
+		end := now
+		if i+1 < len(b) {
+			end = b[i+1].Timestamp
+		}
+		rv = append(rv, PhaseTiming{
+			Status:      c.Status,
+			StartTS:     c.Timestamp,
+			DurationSec: max(end-c.Timestamp, 0),
+		})
+	}
+
+	return rv
+}
+
+// BytesRestored returns the size of the backup data read by the replset.
+// The physical restore reports it per node, the largest one is taken.
+func (rs *RestoreReplset) BytesRestored() int64 {
+	rv := rs.Bytes
+	for _, n := range rs.Nodes {
+		if n.Progress != nil && n.Progress.Bytes > rv {
+			rv = n.Progress.Bytes
+		}
+	}
+
+	return rv
+}
+
+// CountCheck is the check of the documents count of the restored
+// collections against the backup. It is made after the data restore
+// of the replset and before the oplog is applied.
+type CountCheck struct {
+	Collections int             `bson:"collections" json:"collections"`
+	Mismatches  []CountMismatch `bson:"mismatches,omitempty" json:"mismatches,omitempty"`
+}
+
+type CountMismatch struct {
+	NS       string `bson:"ns" json:"ns"`
+	Expected int64  `bson:"expected" json:"expected"`
+	Actual   int64  `bson:"actual" json:"actual"`
+}
+
+func (c *CountCheck) Passed() bool {
+	return len(c.Mismatches) == 0
+}
+
+func (c *CountCheck) String() string {
+	if c.Passed() {
+		return fmt.Sprintf("ok (%d collections)", c.Collections)
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "%d of %d collections differ from the backup:", len(c.Mismatches), c.Collections)
+	for _, m := range c.Mismatches {
+		fmt.Fprintf(&s, " %s (expected %d, got %d);", m.NS, m.Expected, m.Actual)
+	}
+	return strings.TrimSuffix(s.String(), ";")
+}
+
+// expectedCounts returns the documents count of the collections of
+// the backup replsets rss selected by nss. Collections cloned by cloneNS
+// are counted under the new name. Returns nil if any of the replsets has
+// no namespaces stats (backups of older versions).
+func expectedCounts(
+	bcp *backup.BackupMeta,
+	rss []string,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) map[string]int64 {
+	selected := util.MakeSelectedPred(nss)
+	if cloneNS.IsSpecified() {
+		selected = func(ns string) bool { return ns == cloneNS.FromNS }
+	}
+
+	rv := make(map[string]int64)
+	for _, name := range rss {
+		rs := bcp.RS(name)
+		if rs == nil {
+			continue
+		}
+		if rs.NSStats == nil {
+			return nil
+		}
+
+		for _, s := range rs.NSStats {
+			db, coll, _ := strings.Cut(s.NS, ".")
+			if isSystemDB(db) || strings.HasPrefix(coll, "system.") || !selected(s.NS) {
+				continue
+			}
+
+			ns := s.NS
+			if cloneNS.IsSpecified() {
+				ns = cloneNS.ToNS
+			}
+			rv[ns] += s.Docs
+		}
+	}
+
+	return rv
+}
+
+// checkCounts compares the documents count of the restored collections
+// of the replset with the backup. Returns nil if the backup has no stats.
+func (r *Restore) checkCounts(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) (*CountCheck, error) {
+	if bcp.IsLegacyArchive() {
+		return nil, nil
+	}
+
+	rss := []string{util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)}
+	rss = append(rss, r.merge[r.brief.SetName]...)
+	expected := expectedCounts(bcp, rss, nss, cloneNS)
+	if expected == nil {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(expected))
+	for ns := range expected {
+		names = append(names, ns)
+	}
+	slices.Sort(names)
+
+	rv := &CountCheck{}
+	for _, ns := range names {
+		db, coll, _ := strings.Cut(ns, ".")
+		n, err := r.nodeConn.Database(db).Collection(coll).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "count %s", ns)
+		}
+
+		rv.Collections++
+		if n != expected[ns] {
+			rv.Mismatches = append(rv.Mismatches, CountMismatch{NS: ns, Expected: expected[ns], Actual: n})
+		}
+	}
+
+	return rv, nil
+}
+
+// readCounter adds the bytes read to n
+type readCounter struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// DeleteOldRestores deletes the records of the finished restores except
+// the keep latest ones. The files of physical restores are deleted from
+// stg unless it is nil or read-only. keep 0 keeps all.
+func DeleteOldRestores(ctx context.Context, m connect.Client, stg storage.Storage, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	cur, err := m.RestoresCollection().Find(ctx, bson.D{},
+		options.Find().
+			SetSort(bson.D{{"start_ts", -1}}).
+			SetSkip(int64(keep)).
+			SetProjection(bson.D{{"name", 1}, {"type", 1}, {"status", 1}}))
+	if err != nil {
+		return errors.Wrap(err, "query")
+	}
+	old := []RestoreMeta{}
+	err = cur.All(ctx, &old)
+	if err != nil {
+		return errors.Wrap(err, "decode")
+	}
+
+	names := []string{}
+	for _, r := range old {
+		if r.Status.IsRunning() && r.Status != defs.StatusPartlyDone {
+			continue
+		}
+
+		if r.Type != defs.LogicalBackup && stg != nil && !storage.IsReadOnly(stg) {
+			err := deletePhysRestoreFiles(stg, r.Name)
+			if err != nil {
+				return errors.Wrapf(err, "delete files of %s", r.Name)
+			}
+		}
+		names = append(names, r.Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	_, err = m.RestoresCollection().DeleteMany(ctx, bson.D{{"name", bson.M{"$in": names}}})
+	return errors.Wrap(err, "delete")
+}
+
+func deletePhysRestoreFiles(stg storage.Storage, name string) error {
+	dir := path.Join(defs.PhysRestoresDir, name)
+	files, err := stg.List(dir, "")
+	if err != nil {
+		return errors.Wrap(err, "list")
+	}
+	for _, f := range files {
+		err := stg.Delete(path.Join(dir, f.Name))
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return errors.Wrapf(err, "delete %s", f.Name)
+		}
+	}
+
+	err = stg.Delete(dir + ".json")
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "delete meta")
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+)
+
+func TestConditionsPhases(t *testing.T) {
+	conds := Conditions{
+		{Timestamp: 100, Status: defs.StatusStarting},
+		{Timestamp: 105, Status: defs.StatusRunning},
+		{Timestamp: 160, Status: defs.StatusDumpDone},
+	}
+
+	got := conds.Phases(200)
+	want := []PhaseTiming{
+		{Status: defs.StatusStarting, StartTS: 100, DurationSec: 5},
+		{Status: defs.StatusRunning, StartTS: 105, DurationSec: 55},
+		{Status: defs.StatusDumpDone, StartTS: 160, DurationSec: 40},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("running: got %+v, want %+v", got, want)
+	}
+
+	conds = append(conds, &Condition{Timestamp: 170, Status: defs.StatusDone})
+	got = conds.Phases(200)
+	want[2].DurationSec = 10
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("done: got %+v, want %+v", got, want)
+	}
+
+	if got := (Conditions{}).Phases(200); len(got) != 0 {
+		t.Errorf("empty: got %+v", got)
+	}
+}
+
+func TestExpectedCounts(t *testing.T) {
+	bcp := &backup.BackupMeta{
+		Replsets: []backup.BackupReplset{
+			{Name: "rs0", NSStats: []backup.NSStat{
+				{NS: "db.a", Docs: 10},
+				{NS: "db.b", Docs: 5},
+				{NS: "db.system.js", Docs: 1},
+				{NS: "admin.system.users", Docs: 2},
+			}},
+			{Name: "rs1", NSStats: []backup.NSStat{
+				{NS: "db.a", Docs: 7},
+				{NS: "other.c", Docs: 3},
+			}},
+			{Name: "rs2"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		rss     []string
+		nss     []string
+		cloneNS snapshot.CloneNS
+		want    map[string]int64
+	}{
+		{"all", []string{"rs0"}, nil, snapshot.CloneNS{}, map[string]int64{"db.a": 10, "db.b": 5}},
+		{"merge", []string{"rs0", "rs1"}, nil, snapshot.CloneNS{},
+			map[string]int64{"db.a": 17, "db.b": 5, "other.c": 3}},
+		{"selective", []string{"rs0", "rs1"}, []string{"db.a", "other.*"}, snapshot.CloneNS{},
+			map[string]int64{"db.a": 17, "other.c": 3}},
+		{"clone", []string{"rs0"}, []string{"db.b"}, snapshot.CloneNS{FromNS: "db.b", ToNS: "db.b2"},
+			map[string]int64{"db.b2": 5}},
+		{"no stats", []string{"rs0", "rs2"}, nil, snapshot.CloneNS{}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := expectedCounts(bcp, c.rss, c.nss, c.cloneNS)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBytesRestored(t *testing.T) {
+	rs := &RestoreReplset{Bytes: 10}
+	if got := rs.BytesRestored(); got != 10 {
+		t.Errorf("logical: got %d", got)
+	}
+
+	rs = &RestoreReplset{Nodes: []RestoreNode{
+		{Progress: &PhysNodeProgress{Bytes: 300}},
+		{},
+		{Progress: &PhysNodeProgress{Bytes: 500}},
+	}}
+	if got := rs.BytesRestored(); got != 500 {
+		t.Errorf("physical: got %d", got)
+	}
+}
@@ -0,0 +1,545 @@
+package restore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/idx"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+const (
+	// indexBuildHbFrame is how often the index builds progress is saved
+	// and the pause of the builds is checked
+	indexBuildHbFrame = 5 * time.Second
+	// indexBuildRetryDelay is the pause before the retry of a failed build
+	indexBuildRetryDelay = 5 * time.Second
+)
+
+// IndexBuildProgress is the state of the index builds on the replset
+type IndexBuildProgress struct {
+	// Total is the number of indexes to build
+	Total int `bson:"total" json:"total"`
+	// Built is the number of indexes built
+	Built   int                 `bson:"built" json:"built"`
+	Running []IndexBuildOp      `bson:"running,omitempty" json:"running,omitempty"`
+	Failed  []IndexBuildFailure `bson:"failed,omitempty" json:"failed,omitempty"`
+	// Paused is set if new builds wait for `pbm restore-indexes resume`
+	Paused    bool  `bson:"paused,omitempty" json:"paused,omitempty"`
+	UpdatedAt int64 `bson:"updated_at" json:"updated_at"`
+}
+
+// IndexBuildOp is the running createIndexes command
+type IndexBuildOp struct {
+	NS      string   `bson:"ns" json:"ns"`
+	Indexes []string `bson:"indexes" json:"indexes"`
+	Attempt int      `bson:"attempt" json:"attempt"`
+	// Phase is the build step reported by currentOp
+	// (e.g. `Index Build: scanning collection`)
+	Phase string `bson:"phase,omitempty" json:"phase,omitempty"`
+	Done  int64  `bson:"done,omitempty" json:"done,omitempty"`
+	Of    int64  `bson:"of,omitempty" json:"of,omitempty"`
+}
+
+func (o *IndexBuildOp) String() string {
+	s := fmt.Sprintf("%s: %s", o.NS, strings.Join(o.Indexes, ", "))
+	if o.Of > 0 {
+		s += fmt.Sprintf(" %.0f%%", float64(o.Done)/float64(o.Of)*100)
+	}
+	if o.Phase != "" {
+		s += " (" + o.Phase + ")"
+	}
+	if o.Attempt > 1 {
+		s += fmt.Sprintf(" [attempt %d]", o.Attempt)
+	}
+	return s
+}
+
+// IndexBuildFailure is the index failed to build after all attempts
+type IndexBuildFailure struct {
+	NS       string `bson:"ns" json:"ns"`
+	Index    string `bson:"index" json:"index"`
+	Attempts int    `bson:"attempts" json:"attempts"`
+	Error    string `bson:"error" json:"error"`
+}
+
+func (p *IndexBuildProgress) String() string {
+	s := fmt.Sprintf("%d/%d indexes built", p.Built, p.Total)
+	if len(p.Running) != 0 {
+		s += fmt.Sprintf(", %d builds running", len(p.Running))
+	}
+	if len(p.Failed) != 0 {
+		s += fmt.Sprintf(", %d failed", len(p.Failed))
+	}
+	if p.Paused {
+		s += " [paused]"
+	}
+	for _, o := range p.Running {
+		s += "\n  - " + o.String()
+	}
+	for _, f := range p.Failed {
+		s += fmt.Sprintf("\n  - FAILED %s: %s after %d attempts: %s", f.NS, f.Index, f.Attempts, f.Error)
+	}
+	return s
+}
+
+// indexBuild is the indexes of a collection built by one createIndexes command
+type indexBuild struct {
+	db      string
+	coll    string
+	indexes []*idx.IndexDocument
+	size    int64
+}
+
+func (b *indexBuild) ns() string {
+	return b.db + "." + b.coll
+}
+
+func (b *indexBuild) names() []string {
+	rv := make([]string, len(b.indexes))
+	for i, index := range b.indexes {
+		rv[i], _ = index.Options["name"].(string)
+	}
+	return rv
+}
+
+func (b *indexBuild) key() string {
+	return b.ns() + "/" + strings.Join(b.names(), ",")
+}
+
+// splitIndexBuilds splits the indexes of the collection into builds
+// of up to batchSize indexes. 0 is all indexes in one build.
+func splitIndexBuilds(db, coll string, indexes []*idx.IndexDocument, batchSize int) []*indexBuild {
+	if batchSize <= 0 {
+		batchSize = len(indexes)
+	}
+
+	var rv []*indexBuild
+	for i := 0; i < len(indexes); i += batchSize {
+		batch := indexes[i:min(i+batchSize, len(indexes))]
+		rv = append(rv, &indexBuild{db: db, coll: coll, indexes: batch})
+	}
+	return rv
+}
+
+// sortIndexBuilds orders the builds by the namespace or the collection size
+func sortIndexBuilds(builds []*indexBuild, order config.IndexBuildOrder) {
+	slices.SortStableFunc(builds, func(a, b *indexBuild) int {
+		c := 0
+		switch order {
+		case config.IndexBuildLargestFirst:
+			c = cmp.Compare(b.size, a.size)
+		case config.IndexBuildLargestLast:
+			c = cmp.Compare(a.size, b.size)
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(a.ns(), b.ns())
+	})
+}
+
+// indexBuildTracker keeps the progress of the builds of the replset
+type indexBuildTracker struct {
+	mx      sync.Mutex
+	p       IndexBuildProgress
+	running map[string]*IndexBuildOp
+	paused  bool
+}
+
+func newIndexBuildTracker(builds []*indexBuild) *indexBuildTracker {
+	t := &indexBuildTracker{running: make(map[string]*IndexBuildOp)}
+	for _, b := range builds {
+		t.p.Total += len(b.indexes)
+	}
+	return t
+}
+
+func (t *indexBuildTracker) started(b *indexBuild, attempt int) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.running[b.key()] = &IndexBuildOp{NS: b.ns(), Indexes: b.names(), Attempt: attempt}
+}
+
+func (t *indexBuildTracker) stopped(b *indexBuild) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	delete(t.running, b.key())
+}
+
+// finished records the outcome of the build after all attempts
+func (t *indexBuildTracker) finished(b *indexBuild, err error, attempts int) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if err == nil {
+		t.p.Built += len(b.indexes)
+		return
+	}
+	for _, name := range b.names() {
+		t.p.Failed = append(t.p.Failed, IndexBuildFailure{
+			NS:       b.ns(),
+			Index:    name,
+			Attempts: attempts,
+			Error:    err.Error(),
+		})
+	}
+}
+
+func (t *indexBuildTracker) isPaused() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.paused
+}
+
+func (t *indexBuildTracker) setPaused(v bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.paused = v
+}
+
+// setOps sets the state of the running builds reported by currentOp
+func (t *indexBuildTracker) setOps(ops []currentIndexOp) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for _, o := range t.running {
+		for _, c := range ops {
+			if c.NS != o.NS || !slices.Contains(c.names(), o.Indexes[0]) {
+				continue
+			}
+			// the build thread reports the progress,
+			// the command thread only waits for it
+			if c.Msg != "" || c.Progress != nil {
+				o.Phase, o.Done, o.Of = c.phase(), c.Progress.done(), c.Progress.total()
+			}
+		}
+	}
+}
+
+func (t *indexBuildTracker) get() *IndexBuildProgress {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	rv := t.p
+	rv.Paused = t.paused
+	rv.Failed = slices.Clone(t.p.Failed)
+	rv.Running = make([]IndexBuildOp, 0, len(t.running))
+	for _, o := range t.running {
+		rv.Running = append(rv.Running, *o)
+	}
+	slices.SortFunc(rv.Running, func(a, b IndexBuildOp) int {
+		return strings.Compare(a.NS+a.Indexes[0], b.NS+b.Indexes[0])
+	})
+	rv.UpdatedAt = time.Now().Unix()
+
+	return &rv
+}
+
+type currentIndexOp struct {
+	NS      string `bson:"ns"`
+	Msg     string `bson:"msg"`
+	Command struct {
+		Indexes []struct {
+			Name string `bson:"name"`
+		} `bson:"indexes"`
+	} `bson:"command"`
+	Progress *indexOpProgress `bson:"progress"`
+}
+
+func (o *currentIndexOp) names() []string {
+	rv := make([]string, len(o.Command.Indexes))
+	for i, index := range o.Command.Indexes {
+		rv[i] = index.Name
+	}
+	return rv
+}
+
+// phase cuts the counters off the currentOp message, e.g. `Index Build:
+// scanning collection Index Build: scanning collection: 1000/5000 20%`
+func (o *currentIndexOp) phase() string {
+	msg := o.Msg
+	if i := strings.LastIndex(msg, ": "); i > 0 && i+2 < len(msg) && msg[i+2] >= '0' && msg[i+2] <= '9' {
+		msg = msg[:i]
+	}
+	// the message repeats the phase before the counters
+	if h := len(msg) / 2; len(msg)%2 == 1 && msg[:h] == msg[h+1:] {
+		msg = msg[:h]
+	}
+	return msg
+}
+
+type indexOpProgress struct {
+	Done  int64 `bson:"done"`
+	Total int64 `bson:"total"`
+}
+
+func (p *indexOpProgress) done() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.Done
+}
+
+func (p *indexOpProgress) total() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.Total
+}
+
+// buildIndexes runs the builds with up to conf.MaxConcurrent of them at once.
+// The indexes failed to build are reported but don't fail the restore.
+func (r *Restore) buildIndexes(ctx context.Context, builds []*indexBuild, conf *config.IndexBuildConf) error {
+	t := newIndexBuildTracker(builds)
+	r.pollIndexBuilds(ctx, t)
+
+	stop := make(chan struct{})
+	var twg sync.WaitGroup
+	twg.Add(1)
+	go func() {
+		defer twg.Done()
+
+		tk := time.NewTicker(indexBuildHbFrame)
+		defer tk.Stop()
+		for {
+			select {
+			case <-tk.C:
+				r.pollIndexBuilds(ctx, t)
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sem := make(chan struct{}, conf.Concurrency())
+	var wg sync.WaitGroup
+	err := func() error {
+		for _, b := range builds {
+			if err := r.waitIndexBuildsResumed(ctx, t); err != nil {
+				return err
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				r.buildIndex(ctx, t, b, conf)
+			}()
+		}
+		return nil
+	}()
+	wg.Wait()
+	close(stop)
+	twg.Wait()
+
+	r.saveIndexBuilds(ctx, t)
+	if err != nil {
+		return errors.Wrap(err, "build indexes")
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "build indexes")
+	}
+
+	p := t.get()
+	if len(p.Failed) != 0 {
+		r.log.Warning("%d of %d indexes failed to build", len(p.Failed), p.Total)
+	}
+	return nil
+}
+
+// buildIndex runs the build. If a build of several indexes fails,
+// they are built one by one to report the failed indexes individually.
+func (r *Restore) buildIndex(ctx context.Context, t *indexBuildTracker, b *indexBuild, conf *config.IndexBuildConf) {
+	attempts, err := r.runIndexBuild(ctx, t, b, conf)
+	if err == nil || len(b.indexes) == 1 || ctx.Err() != nil {
+		if err != nil {
+			r.log.Warning("failed to build indexes for %s: %s: %v",
+				b.ns(), strings.Join(b.names(), ", "), err)
+		}
+		t.finished(b, err, attempts)
+		return
+	}
+
+	r.log.Warning("failed to build indexes for %s: %v. Building them one by one", b.ns(), err)
+	for _, one := range splitIndexBuilds(b.db, b.coll, b.indexes, 1) {
+		attempts, err := r.runIndexBuild(ctx, t, one, conf)
+		if err != nil {
+			r.log.Warning("failed to build index %s.%s: %v", one.ns(), one.names()[0], err)
+		}
+		t.finished(one, err, attempts)
+	}
+}
+
+// runIndexBuild runs the createIndexes command of the build retrying it up to
+// conf.Retries times. Returns the number of attempts made.
+func (r *Restore) runIndexBuild(
+	ctx context.Context,
+	t *indexBuildTracker,
+	b *indexBuild,
+	conf *config.IndexBuildConf,
+) (int, error) {
+	cmd := bson.D{
+		{"createIndexes", b.coll},
+		{"indexes", b.indexes},
+		{"ignoreUnknownIndexOptions", true},
+	}
+	if q := conf.Quorum(); q != nil {
+		cmd = append(cmd, bson.E{"commitQuorum", q})
+	}
+	retries := 0
+	if conf != nil {
+		retries = conf.Retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt == 1 {
+			r.log.Info("restoring indexes for %s: %s", b.ns(), strings.Join(b.names(), ", "))
+		} else {
+			r.log.Info("restoring indexes for %s: %s (attempt %d of %d)",
+				b.ns(), strings.Join(b.names(), ", "), attempt, retries+1)
+		}
+
+		t.started(b, attempt)
+		err := r.dataConn().Database(b.db).RunCommand(ctx, cmd).Err()
+		t.stopped(b)
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		r.log.Warning("createIndexes for %s: %v. Retrying in %v", b.ns(), err, indexBuildRetryDelay)
+		select {
+		case <-time.After(indexBuildRetryDelay):
+		case <-ctx.Done():
+			return attempt, err
+		}
+	}
+}
+
+// waitIndexBuildsResumed blocks while the index builds are paused
+func (r *Restore) waitIndexBuildsResumed(ctx context.Context, t *indexBuildTracker) error {
+	if !t.isPaused() {
+		return nil
+	}
+
+	r.log.Info("index builds are paused")
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for t.isPaused() {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r.log.Info("index builds are resumed")
+
+	return nil
+}
+
+// pollIndexBuilds syncs the pause of the builds with the restore meta,
+// fetches the running builds state and saves the progress
+func (r *Restore) pollIndexBuilds(ctx context.Context, t *indexBuildTracker) {
+	meta, err := GetRestoreMeta(ctx, r.leadConn, r.name)
+	if err != nil {
+		r.log.Warning("get restore meta: %v", err)
+	} else {
+		t.setPaused(meta.IndexBuildsPaused)
+	}
+
+	ops, err := r.currentIndexOps(ctx)
+	if err != nil {
+		r.log.Warning("get index builds state: %v", err)
+	} else {
+		t.setOps(ops)
+	}
+
+	r.saveIndexBuilds(ctx, t)
+}
+
+func (r *Restore) saveIndexBuilds(ctx context.Context, t *indexBuildTracker) {
+	err := SetIndexBuildProgress(ctx, r.leadConn, r.name, r.nodeInfo.SetName, t.get())
+	if err != nil {
+		r.log.Warning("save index builds progress: %v", err)
+	}
+}
+
+func (r *Restore) currentIndexOps(ctx context.Context) ([]currentIndexOp, error) {
+	res := struct {
+		InProg []currentIndexOp `bson:"inprog"`
+	}{}
+	err := r.dataConn().Database("admin").RunCommand(ctx, bson.D{
+		{"currentOp", 1},
+		{"command.createIndexes", bson.D{{"$exists", true}}},
+	}).Decode(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "currentOp")
+	}
+
+	return res.InProg, nil
+}
+
+// setIndexBuildSizes sets the size of the builds collections
+func (r *Restore) setIndexBuildSizes(ctx context.Context, builds []*indexBuild) {
+	sizes := make(map[string]int64)
+	for _, b := range builds {
+		size, ok := sizes[b.ns()]
+		if !ok {
+			var err error
+			size, err = r.collSize(ctx, b.db, b.coll)
+			if err != nil {
+				r.log.Warning("get %s size: %v", b.ns(), err)
+			}
+			sizes[b.ns()] = size
+		}
+		b.size = size
+	}
+}
+
+func (r *Restore) collSize(ctx context.Context, db, coll string) (int64, error) {
+	cur, err := r.dataConn().Database(db).Collection(coll).Aggregate(ctx, mongo.Pipeline{
+		{{"$collStats", bson.D{{"storageStats", bson.D{}}}}},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "collStats")
+	}
+
+	var stats []struct {
+		StorageStats struct {
+			Size int64 `bson:"size"`
+		} `bson:"storageStats"`
+	}
+	if err := cur.All(ctx, &stats); err != nil {
+		return 0, errors.Wrap(err, "decode")
+	}
+
+	var size int64
+	// sharded collections have the stats of each shard
+	for _, s := range stats {
+		size += s.StorageStats.Size
+	}
+	return size, nil
+}
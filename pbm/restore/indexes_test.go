@@ -0,0 +1,134 @@
+package restore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/idx"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func testIndexes(names ...string) []*idx.IndexDocument {
+	rv := make([]*idx.IndexDocument, len(names))
+	for i, n := range names {
+		rv[i] = &idx.IndexDocument{Options: map[string]any{"name": n}}
+	}
+	return rv
+}
+
+func TestSplitIndexBuilds(t *testing.T) {
+	indexes := testIndexes("a", "b", "c")
+
+	testCases := []struct {
+		batch int
+		want  [][]string
+	}{
+		{batch: 0, want: [][]string{{"a", "b", "c"}}},
+		{batch: 1, want: [][]string{{"a"}, {"b"}, {"c"}}},
+		{batch: 2, want: [][]string{{"a", "b"}, {"c"}}},
+		{batch: 5, want: [][]string{{"a", "b", "c"}}},
+	}
+
+	for _, tc := range testCases {
+		var got [][]string
+		for _, b := range splitIndexBuilds("db", "coll", indexes, tc.batch) {
+			got = append(got, b.names())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("batch %d: got %v, want %v", tc.batch, got, tc.want)
+		}
+	}
+}
+
+func TestSortIndexBuilds(t *testing.T) {
+	builds := func() []*indexBuild {
+		return []*indexBuild{
+			{db: "db", coll: "b", size: 10},
+			{db: "db", coll: "c", size: 30},
+			{db: "db", coll: "a", size: 20},
+			{db: "db", coll: "d", size: 20},
+		}
+	}
+
+	testCases := []struct {
+		order config.IndexBuildOrder
+		want  []string
+	}{
+		{order: config.IndexBuildByName, want: []string{"a", "b", "c", "d"}},
+		{order: config.IndexBuildLargestFirst, want: []string{"c", "a", "d", "b"}},
+		{order: config.IndexBuildLargestLast, want: []string{"b", "a", "d", "c"}},
+	}
+
+	for _, tc := range testCases {
+		bs := builds()
+		sortIndexBuilds(bs, tc.order)
+
+		var got []string
+		for _, b := range bs {
+			got = append(got, b.coll)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("order %q: got %v, want %v", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestIndexBuildTracker(t *testing.T) {
+	ok := splitIndexBuilds("db", "c1", testIndexes("a", "b"), 0)[0]
+	bad := splitIndexBuilds("db", "c2", testIndexes("x"), 0)[0]
+	running := splitIndexBuilds("db", "c3", testIndexes("y"), 0)[0]
+
+	tr := newIndexBuildTracker([]*indexBuild{ok, bad, running})
+	tr.started(ok, 1)
+	tr.stopped(ok)
+	tr.finished(ok, nil, 1)
+	tr.started(bad, 3)
+	tr.stopped(bad)
+	tr.finished(bad, errors.New("boom"), 3)
+	tr.started(running, 1)
+
+	op := currentIndexOp{
+		NS:       "db.c3",
+		Msg:      "Index Build: scanning collection Index Build: scanning collection: 25/100 25%",
+		Progress: &indexOpProgress{Done: 25, Total: 100},
+	}
+	op.Command.Indexes = append(op.Command.Indexes, struct {
+		Name string `bson:"name"`
+	}{Name: "y"})
+	tr.setOps([]currentIndexOp{op})
+	tr.setPaused(true)
+
+	p := tr.get()
+	if p.Total != 4 || p.Built != 2 || !p.Paused {
+		t.Errorf("got total %d, built %d, paused %v", p.Total, p.Built, p.Paused)
+	}
+	wantFailed := []IndexBuildFailure{{NS: "db.c2", Index: "x", Attempts: 3, Error: "boom"}}
+	if !reflect.DeepEqual(p.Failed, wantFailed) {
+		t.Errorf("failed: got %+v, want %+v", p.Failed, wantFailed)
+	}
+	wantRunning := []IndexBuildOp{{
+		NS:      "db.c3",
+		Indexes: []string{"y"},
+		Attempt: 1,
+		Phase:   "Index Build: scanning collection",
+		Done:    25,
+		Of:      100,
+	}}
+	if !reflect.DeepEqual(p.Running, wantRunning) {
+		t.Errorf("running: got %+v, want %+v", p.Running, wantRunning)
+	}
+
+	s := p.String()
+	for _, want := range []string{
+		"2/4 indexes built, 1 builds running, 1 failed [paused]",
+		"db.c3: y 25% (Index Build: scanning collection)",
+		"FAILED db.c2: x after 3 attempts: boom",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("%q is not in %q", want, s)
+		}
+	}
+}
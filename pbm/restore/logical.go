@@ -8,6 +8,8 @@ import (
 	"path"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
@@ -17,6 +19,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
@@ -28,6 +31,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/lock"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/restore/phys"
 	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
@@ -63,11 +67,66 @@ type Restore struct {
 	// sMap is mapping between old and new shard names. used for router config update.
 	// empty if all shard names are the same
 	sMap map[string]string
+	// singleRS is the only replset to restore. Its primary leads the restore
+	// instead of the config server. Empty for the whole cluster.
+	singleRS string
+	// merge is the backup replsets restored to the target replset in
+	// addition to the one mapped by rsMap. Only if the cluster has
+	// fewer shards than the backup.
+	merge map[string][]string
+	// sourceCluster is the cluster of the backup set by --source-cluster
+	sourceCluster string
+	// targetCheck is the check of the cluster made by the client
+	targetCheck *ctrl.RestoreTargetCheck
+	// noDrop restores to the existing collections without dropping them
+	noDrop bool
+	// nsConflicts are the conflicting collections of the no drop restore
+	// found by the client. The skipped ones aren't restored.
+	nsConflicts *ctrl.RestoreNSConflicts
+	initiator   string
+	options     *RestoreOptions
+	// pitrRestart is set if PITR was enabled before the restore
+	pitrRestart *PITRRestart
+	// bytes is the size of the backup files read from the storage
+	bytes atomic.Int64
+	// profiling is the profiling turned off on the node for the time of
+	// the restore. Nil if nothing is turned off or it's restored already.
+	profiling *ProfilingPause
+	// stopWatch stops the throttling watch of the node
+	stopWatch chan struct{}
+	// balancerStopped is true if the leader has stopped the balancer
+	// for the time of the restore
+	balancerStopped bool
+	// transfers records the downloads of the storage files
+	transfers *storage.StatsRecorder
+	// failures are the failed writes of mongorestore of all snapshots
+	// of the restore
+	failures *snapshot.Failures
+	// failuresSaved is the total of the failures saved last
+	failuresSaved int64
+	// failuresMx guards failuresSaved of the parallel mongorestores
+	failuresMx sync.Mutex
+	// reservedBatches is the number of the collection batches of each
+	// mongorestore already reserved from the memory budget by the parallel
+	// restore of the databases
+	reservedBatches int
+	// parallelDBs is the number of the databases restored at once
+	parallelDBs int
+	// mongos is the connection to the routers of the restore through
+	// mongos (see `restore.viaMongos`). The data is written to mongosURI.
+	mongos    *mongo.Client
+	mongosURI string
+	// sharding is the sharded collections of the backup restored to
+	// the sharded cluster (see verifySharding)
+	sharding *ShardingResult
 
 	log  log.LogEvent
 	opid string
 
 	indexCatalog *idx.IndexCatalog
+	// archiveOptions are the collection options of the archive metadata
+	// by the backup namespace. See backupCollOptions.
+	archiveOptions map[string]bson.D
 }
 
 type oplogRange struct {
@@ -104,6 +163,28 @@ func New(
 		numParallelColls:          numParallelColls,
 		numInsertionWorkersPerCol: numInsertionWorkersPerCol,
 		indexCatalog:              idx.NewIndexCatalog(),
+		transfers:                 storage.NewStatsRecorder(),
+	}
+}
+
+// isLeader returns true if the node leads the restore.
+func (r *Restore) isLeader() bool {
+	if r.singleRS != "" {
+		return r.nodeInfo.SetName == r.singleRS
+	}
+	return r.nodeInfo.IsLeader()
+}
+
+// withStats records the downloads of stg for the restore metadata
+func (r *Restore) withStats(stg storage.Storage) storage.Storage {
+	return storage.WithStats(stg, r.transfers)
+}
+
+// saveTransfers is the best effort, the stats shouldn't fail the restore
+func (r *Restore) saveTransfers(ctx context.Context) {
+	err := SetRestoreRSTransfers(ctx, r.leadConn, r.name, r.nodeInfo.SetName, r.transfers.Stats())
+	if err != nil {
+		r.log.Warning("save transfer stats: %v", err)
 	}
 }
 
@@ -113,6 +194,10 @@ func (r *Restore) Close() {
 	if r.stopHB != nil {
 		close(r.stopHB)
 	}
+	if r.stopWatch != nil {
+		close(r.stopWatch)
+		r.stopWatch = nil
+	}
 }
 
 func (r *Restore) exit(ctx context.Context, err error) {
@@ -124,9 +209,103 @@ func (r *Restore) exit(ctx context.Context, err error) {
 		}
 	}
 
+	if r.profiling != nil {
+		r.resumeProfiling(ctx)
+	}
+	if r.balancerStopped {
+		r.startBalancer(ctx)
+	}
+
 	r.Close()
 }
 
+// stopBalancer stops the balancer of the sharded cluster for the time of
+// the restore, so it doesn't move chunks of the collections being restored.
+// It's done by the leader only if the restore writes to sharded collections.
+// The restore meta keeps the state until the balancer is started back
+// on the restore exit or `pbm cancel-restore`.
+func (r *Restore) stopBalancer(ctx context.Context, bcp *backup.BackupMeta, nss []string) error {
+	if !r.brief.Sharded || !r.isLeader() {
+		return nil
+	}
+
+	sharded, err := r.restoresShardedColls(ctx, bcp, nss)
+	if err != nil {
+		return errors.Wrap(err, "check sharded collections")
+	}
+	if !sharded {
+		return nil
+	}
+
+	bs, err := topo.GetBalancerStatus(ctx, r.leadConn)
+	if err != nil {
+		return errors.Wrap(err, "get balancer status")
+	}
+	if !bs.IsOn() {
+		return nil
+	}
+
+	err = SetBalancerStopped(ctx, r.leadConn, r.name, true)
+	if err != nil {
+		return errors.Wrap(err, "save balancer state")
+	}
+	err = topo.SetBalancerStatus(ctx, r.leadConn, topo.BalancerModeOff)
+	if err != nil {
+		return errors.Wrap(err, "stop balancer")
+	}
+
+	r.balancerStopped = true
+	r.log.Info("balancer is stopped for the time of the restore")
+	return nil
+}
+
+// shardedCollsVersion is the first PBM version recording the sharded
+// collections of the backup (see backup.BackupMeta.ShardedColls)
+const shardedCollsVersion = "2.9.0"
+
+// restoresShardedColls returns true if any of the namespaces nss is sharded
+// in the backup or on the target. The backups made before the sharded
+// collections were recorded are taken as having them.
+func (r *Restore) restoresShardedColls(ctx context.Context, bcp *backup.BackupMeta, nss []string) (bool, error) {
+	if len(bcp.ShardedColls) == 0 && !version.SatisfiesMin(bcp.PBMVersion, shardedCollsVersion) {
+		return true, nil
+	}
+
+	selected := util.MakeSelectedPred(nss)
+	for _, c := range bcp.ShardedColls {
+		if selected(c.NS) {
+			return true, nil
+		}
+	}
+
+	curr, err := topo.ListShardedColls(ctx, r.leadConn.MongoClient())
+	if err != nil {
+		return false, errors.Wrap(err, "list sharded collections")
+	}
+	for _, c := range curr {
+		if selected(c.NS) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *Restore) startBalancer(ctx context.Context) {
+	err := topo.SetBalancerStatus(ctx, r.leadConn, topo.BalancerModeOn)
+	if err != nil {
+		r.log.Error("start balancer: %v", err)
+		return
+	}
+
+	r.balancerStopped = false
+	err = SetBalancerStopped(ctx, r.leadConn, r.name, false)
+	if err != nil {
+		r.log.Warning("save balancer state: %v", err)
+	}
+	r.log.Info("balancer is started")
+}
+
 // resolveNamespace resolves final namespace(s) based on the backup namespace,
 // restore namespace, cloning options and option whether we should restore users&roles
 func resolveNamespace(nssBackup, nssRestore []string, cloneNS snapshot.CloneNS, usingUsersAndRoles bool) []string {
@@ -185,15 +364,39 @@ func (r *Restore) Snapshot(
 
 	defer func() { r.exit(log.Copy(context.Background(), ctx), err) }()
 
+	r.singleRS = cmd.Replset
+	r.merge = cmd.Merge
+	r.sourceCluster = cmd.SourceCluster
+	r.targetCheck = cmd.TargetCheck
+	r.noDrop = cmd.NoDrop
+	r.nsConflicts = cmd.NSConflicts
+	r.initiator = cmd.Initiator
+	r.options = NewRestoreOptions(cmd)
+	r.pitrRestart = NewPITRRestart(cmd)
 	err = r.init(ctx, cmd.Name, opid, l)
 	if err != nil {
 		return err
 	}
 
-	r.bcpStg, err = util.StorageFromConfig(&bcp.Store.StorageConf, r.brief.Me, r.log)
+	if r.brief.Sharded && r.noDrop {
+		return errors.New("restore without drop is not supported in sharded cluster")
+	}
+
+	if err := CheckSourceCluster(bcp, cmd.SourceCluster); err != nil {
+		return err
+	}
+
+	if err := checkBackupKey(r.bcpStorageConf(bcp)); err != nil {
+		return err
+	}
+	r.bcpStg, err = util.StorageFromConfig(r.bcpStorageConf(bcp), r.brief.Me, r.log)
 	if err != nil {
 		return errors.Wrap(err, "get backup storage")
 	}
+	r.bcpStg = r.withStats(r.bcpStg)
+	if err := backup.LoadNSStats(bcp, r.brief.Me, r.log); err != nil {
+		r.log.Warning("read namespaces stats: %v", err)
+	}
 
 	cloneNS := snapshot.CloneNS{FromNS: cmd.NamespaceFrom, ToNS: cmd.NamespaceTo}
 	if r.brief.Sharded && cloneNS.IsSpecified() {
@@ -221,6 +424,20 @@ func (r *Restore) Snapshot(
 		return err
 	}
 
+	err = r.checkTarget(ctx, bcp, bcp.LastWriteTS, cmd.Namespaces, cloneNS)
+	if err != nil {
+		return err
+	}
+
+	err = r.stopBalancer(ctx, bcp, nss)
+	if err != nil {
+		r.log.Warning("the balancer may move chunks during the restore: %v", err)
+	}
+
+	if r.viaMongos() {
+		return r.snapshotViaMongos(ctx, bcp, nss, usersAndRolesOpt)
+	}
+
 	err = r.setShards(ctx, bcp)
 	if err != nil {
 		return err
@@ -240,28 +457,50 @@ func (r *Restore) Snapshot(
 		return err
 	}
 
+	oplogRanges := []oplogRange{
+		{chunks: chunks, storage: r.bcpStg},
+	}
+	merged, err := r.mergedObjects(ctx, bcp)
+	if err != nil {
+		return err
+	}
+	oplogRanges = append(oplogRanges, merged...)
+
 	err = r.toState(ctx, defs.StatusRunning, &defs.WaitActionStart)
 	if err != nil {
 		return err
 	}
+	r.prepareNode(ctx)
+
+	err = r.dropConflicting(ctx)
+	if err != nil {
+		return err
+	}
 
 	err = r.RunSnapshot(ctx, dump, bcp, nss, cloneNS, usersAndRolesOpt)
 	if err != nil {
 		return err
 	}
 
+	for _, rs := range r.merge[r.brief.SetName] {
+		err = r.mergeSnapshot(ctx, bcp, rs)
+		if err != nil {
+			return errors.Wrapf(err, "merge data of replset %q", rs)
+		}
+	}
+	r.saveDataStat(ctx, bcp, nss, cloneNS)
+	r.restoreCollOptions(ctx, bcp, nss, cloneNS)
+
 	err = r.toState(ctx, defs.StatusDumpDone, nil)
 	if err != nil {
 		return err
 	}
 
-	oplogRanges := []oplogRange{
-		{chunks: chunks, storage: r.bcpStg},
-	}
 	oplogOption := &applyOplogOption{
-		end:     &bcp.LastWriteTS,
-		nss:     nss,
-		cloudNS: cloneNS,
+		end:       &bcp.LastWriteTS,
+		nss:       nss,
+		cloudNS:   cloneNS,
+		excludeNS: r.nsConflicts.Decided(ctrl.NSConflictSkip),
 	}
 	if r.nodeInfo.IsConfigSrv() && util.IsSelective(nss) {
 		oplogOption.nss = []string{"config.databases"}
@@ -285,10 +524,38 @@ func (r *Restore) Snapshot(
 	if err = r.updateRouterConfig(ctx); err != nil {
 		return errors.Wrap(err, "update router config")
 	}
+	r.restoreShardedCollOptions(ctx, bcp, nss, cloneNS)
+
+	if r.brief.Sharded && r.nodeInfo.IsConfigSrv() && !bcp.IsLegacyArchive() {
+		err = r.checkRestoredSharding(ctx, bcp, nss)
+		if err != nil {
+			return err
+		}
+	}
 
 	return r.Done(ctx)
 }
 
+// checkRestoredSharding verifies the sharding metadata the config server
+// has restored from its dump: the sharded collections of the backup have
+// to be sharded by the same keys.
+func (r *Restore) checkRestoredSharding(ctx context.Context, bcp *backup.BackupMeta, nss []string) error {
+	if !util.IsSelective(nss) {
+		nss = bcp.Namespaces
+	}
+	if !util.IsSelective(nss) {
+		nss = []string{"*.*"}
+	}
+
+	colls, _, err := r.backupSharding(bcp)
+	if err != nil {
+		return errors.Wrap(err, "read backup sharding")
+	}
+	r.sharding = newShardingResult(colls, r.skipConflicting(util.MakeSelectedPred(nss)))
+
+	return errors.Wrap(r.verifySharding(ctx, r.nodeConn, true), "verify sharding")
+}
+
 // newConfigsvrOpFilter filters out not needed ops during selective backup on configsvr
 func newConfigsvrOpFilter(nss []string) oplog.OpFilter {
 	selected := util.MakeSelectedPred(nss)
@@ -328,24 +595,54 @@ func (r *Restore) PITR(
 
 	defer func() { r.exit(log.Copy(context.Background(), ctx), err) }()
 
+	r.targetCheck = cmd.TargetCheck
+	r.noDrop = cmd.NoDrop
+	r.nsConflicts = cmd.NSConflicts
+	r.initiator = cmd.Initiator
+	r.options = NewRestoreOptions(cmd)
+	r.pitrRestart = NewPITRRestart(cmd)
 	err = r.init(ctx, cmd.Name, opid, l)
 	if err != nil {
 		return err
 	}
 
+	if r.brief.Sharded && r.noDrop {
+		return errors.New("restore without drop is not supported in sharded cluster")
+	}
+
+	if bcp.IsForeign() {
+		return errors.New("point-in-time restore from a backup of another cluster is not supported")
+	}
+
+	if r.viaMongos() {
+		return errors.New("point-in-time restore is not supported with restore.viaMongos")
+	}
+
+	if len(cmd.Merge) != 0 {
+		return errors.New("point-in-time restore to a cluster with another number of shards is not supported")
+	}
+
 	if bcp.LastWriteTS.Compare(cmd.OplogTS) >= 0 {
 		return errors.New("snapshot's last write is later than the target time. " +
 			"Try to set an earlier snapshot. Or leave the snapshot empty so PBM will choose one.")
 	}
 
-	r.bcpStg, err = util.StorageFromConfig(&bcp.Store.StorageConf, r.brief.Me, r.log)
+	if err := checkBackupKey(r.bcpStorageConf(bcp)); err != nil {
+		return err
+	}
+	r.bcpStg, err = util.StorageFromConfig(r.bcpStorageConf(bcp), r.brief.Me, r.log)
 	if err != nil {
 		return errors.Wrap(err, "get backup storage")
 	}
+	r.bcpStg = r.withStats(r.bcpStg)
+	if err := backup.LoadNSStats(bcp, r.brief.Me, r.log); err != nil {
+		r.log.Warning("read namespaces stats: %v", err)
+	}
 	r.oplogStg, err = util.GetStorage(ctx, r.leadConn, r.nodeInfo.Me, log.LogEventFromContext(ctx))
 	if err != nil {
 		return errors.Wrap(err, "get oplog storage")
 	}
+	r.oplogStg = r.withStats(r.oplogStg)
 
 	cloneNS := snapshot.CloneNS{FromNS: cmd.NamespaceFrom, ToNS: cmd.NamespaceTo}
 	if r.brief.Sharded && cloneNS.IsSpecified() {
@@ -363,7 +660,7 @@ func (r *Restore) PITR(
 		cloneNS,
 		cmd.UsersAndRoles)
 
-	if r.nodeInfo.IsLeader() {
+	if r.isLeader() {
 		err = SetOplogTimestamps(ctx, r.leadConn, r.name, 0, int64(cmd.OplogTS.T))
 		if err != nil {
 			return errors.Wrap(err, "set PITR timestamp")
@@ -380,6 +677,16 @@ func (r *Restore) PITR(
 		return err
 	}
 
+	err = r.checkTarget(ctx, bcp, cmd.OplogTS, cmd.Namespaces, cloneNS)
+	if err != nil {
+		return err
+	}
+
+	err = r.stopBalancer(ctx, bcp, nss)
+	if err != nil {
+		r.log.Warning("the balancer may move chunks during the restore: %v", err)
+	}
+
 	err = r.setShards(ctx, bcp)
 	if err != nil {
 		return err
@@ -417,11 +724,19 @@ func (r *Restore) PITR(
 	if err != nil {
 		return err
 	}
+	r.prepareNode(ctx)
+
+	err = r.dropConflicting(ctx)
+	if err != nil {
+		return err
+	}
 
 	err = r.RunSnapshot(ctx, dump, bcp, nss, cloneNS, usersAndRolesOpt)
 	if err != nil {
 		return err
 	}
+	r.saveDataStat(ctx, bcp, nss, cloneNS)
+	r.restoreCollOptions(ctx, bcp, nss, cloneNS)
 
 	err = r.toState(ctx, defs.StatusDumpDone, nil)
 	if err != nil {
@@ -430,13 +745,15 @@ func (r *Restore) PITR(
 
 	oplogRanges := []oplogRange{
 		{chunks: bcpChunks, storage: r.bcpStg},
-		{chunks: chunks, storage: r.oplogStg},
 	}
+	oplogRanges = append(oplogRanges, chunks...)
 	oplogOption := applyOplogOption{
-		end:     &cmd.OplogTS,
-		nss:     nss,
-		cloudNS: cloneNS,
+		end:       &cmd.OplogTS,
+		nss:       nss,
+		cloudNS:   cloneNS,
+		excludeNS: r.cfg.PITR.ExcludeNamespaces,
 	}
+	oplogOption.excludeNS = append(oplogOption.excludeNS, r.nsConflicts.Decided(ctrl.NSConflictSkip)...)
 	if r.nodeInfo.IsConfigSrv() && util.IsSelective(nss) {
 		oplogOption.nss = []string{"config.databases"}
 		oplogOption.nss = append(oplogOption.nss, nss...)
@@ -459,6 +776,7 @@ func (r *Restore) PITR(
 	if err = r.updateRouterConfig(ctx); err != nil {
 		return errors.Wrap(err, "update router config")
 	}
+	r.restoreShardedCollOptions(ctx, bcp, nss, cloneNS)
 
 	return r.Done(ctx)
 }
@@ -480,16 +798,26 @@ func (r *Restore) ReplayOplog(ctx context.Context, cmd *ctrl.ReplayCmd, opid ctr
 		return errors.Wrap(err, "get cluster members")
 	}
 
-	if r.nodeInfo.IsLeader() {
+	if r.isLeader() {
 		err := SetOplogTimestamps(ctx, r.leadConn, r.name, int64(cmd.Start.T), int64(cmd.End.T))
 		if err != nil {
 			return errors.Wrap(err, "set oplog timestamps")
 		}
 	}
 
-	oplogShards, err := oplog.AllOplogRSNames(ctx, r.leadConn, cmd.Start, cmd.End)
-	if err != nil {
-		return err
+	var src *replaySource
+	var oplogShards []string
+	if cmd.Source != "" {
+		src, err = r.openReplaySource(ctx, cmd.Source)
+		if err != nil {
+			return errors.Wrap(err, "open source")
+		}
+		oplogShards = src.RSNames(cmd.Start, cmd.End)
+	} else {
+		oplogShards, err = oplog.AllOplogRSNames(ctx, r.leadConn, cmd.Start, cmd.End)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = r.checkTopologyForOplog(r.shards, oplogShards)
@@ -501,14 +829,25 @@ func (r *Restore) ReplayOplog(ctx context.Context, cmd *ctrl.ReplayCmd, opid ctr
 		return r.Done(ctx) // skip. no oplog for current rs
 	}
 
-	r.oplogStg, err = util.GetStorage(ctx, r.leadConn, r.nodeInfo.Me, log.LogEventFromContext(ctx))
-	if err != nil {
-		return errors.Wrapf(err, "get oplog storage")
-	}
+	var oplogRanges []oplogRange
+	if src != nil {
+		r.oplogStg = r.withStats(src.stg)
+		opChunks, err := src.Chunks(ctx, cmd.Start, cmd.End, r.nodeInfo.SetName, r.rsMap, cmd.AllowGaps)
+		if err != nil {
+			return errors.Wrap(err, "source chunks")
+		}
+		oplogRanges = []oplogRange{{chunks: opChunks, storage: r.oplogStg}}
+	} else {
+		r.oplogStg, err = util.GetStorage(ctx, r.leadConn, r.nodeInfo.Me, log.LogEventFromContext(ctx))
+		if err != nil {
+			return errors.Wrapf(err, "get oplog storage")
+		}
+		r.oplogStg = r.withStats(r.oplogStg)
 
-	opChunks, err := r.chunks(ctx, cmd.Start, cmd.End)
-	if err != nil {
-		return err
+		oplogRanges, err = r.chunks(ctx, cmd.Start, cmd.End)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = r.toState(ctx, defs.StatusRunning, &defs.WaitActionStart)
@@ -516,13 +855,11 @@ func (r *Restore) ReplayOplog(ctx context.Context, cmd *ctrl.ReplayCmd, opid ctr
 		return err
 	}
 
-	oplogRanges := []oplogRange{
-		{chunks: opChunks, storage: r.oplogStg},
-	}
 	oplogOption := applyOplogOption{
-		start:  &cmd.Start,
-		end:    &cmd.End,
-		unsafe: true,
+		start:     &cmd.Start,
+		end:       &cmd.End,
+		unsafe:    true,
+		excludeNS: r.cfg.PITR.ExcludeNamespaces,
 	}
 	err = r.applyOplog(ctx, oplogRanges, &oplogOption)
 	if err != nil {
@@ -546,20 +883,30 @@ func (r *Restore) init(ctx context.Context, name string, opid ctrl.OPID, l log.L
 
 	r.name = name
 	r.opid = opid.String()
-	if r.nodeInfo.IsLeader() {
+	if r.isLeader() {
 		ts, err := topo.GetClusterTime(ctx, r.leadConn)
 		if err != nil {
 			return errors.Wrap(err, "init restore meta, read cluster time")
 		}
 
 		meta := &RestoreMeta{
-			Type:     defs.LogicalBackup,
-			OPID:     r.opid,
-			Name:     r.name,
-			StartTS:  time.Now().Unix(),
-			Status:   defs.StatusStarting,
-			Replsets: []RestoreReplset{},
-			Hb:       ts,
+			SchemaVersion: RestoreMetaVersion,
+			Type:          defs.LogicalBackup,
+			OPID:          r.opid,
+			Name:          r.name,
+			StartTS:       time.Now().Unix(),
+			Status:        defs.StatusStarting,
+			SingleRS:      r.singleRS,
+			RSMap:         r.rsMap,
+			Merge:         r.merge,
+			Replsets:      []RestoreReplset{},
+			Hb:            ts,
+			SourceCluster: r.sourceCluster,
+			TargetCheck:   r.targetCheck,
+			NSConflicts:   r.nsConflicts,
+			Initiator:     r.initiator,
+			Options:       r.options,
+			PITRRestart:   r.pitrRestart,
 		}
 		err = SetRestoreMeta(ctx, r.leadConn, meta)
 		if err != nil {
@@ -635,8 +982,8 @@ func (r *Restore) checkTopologyForOplog(currShards []topo.Shard, oplogShards []s
 // chunks defines chunks of oplog slice in given range, ensures its integrity (timeline
 // is contiguous - there are no gaps), checks for respective files on storage and returns
 // chunks list if all checks passed
-func (r *Restore) chunks(ctx context.Context, from, to primitive.Timestamp) ([]oplog.OplogChunk, error) {
-	return chunks(ctx, r.leadConn, r.oplogStg, from, to, r.nodeInfo.SetName, r.rsMap)
+func (r *Restore) chunks(ctx context.Context, from, to primitive.Timestamp) ([]oplogRange, error) {
+	return chunks(ctx, r.leadConn, r.oplogStg, from, to, r.nodeInfo.SetName, r.rsMap, r.nodeInfo.Me)
 }
 
 // LookupBackupMeta fetches backup metadata.
@@ -686,14 +1033,29 @@ func (r *Restore) setShards(ctx context.Context, bcp *backup.BackupMeta) error {
 	}
 
 	mapRS, mapRevRS := util.MakeRSMapFunc(r.rsMap), util.MakeReverseRSMapFunc(r.rsMap)
+	mergedTo := make(map[string]string)
+	for to, from := range r.merge {
+		for _, rs := range from {
+			mergedTo[rs] = to
+		}
+	}
 
 	var nors []string
 	for _, sh := range bcp.Replsets {
-		name := mapRS(sh.Name)
+		name, merged := mergedTo[sh.Name]
+		if !merged {
+			name = mapRS(sh.Name)
+		}
+		if r.singleRS != "" && name != r.singleRS {
+			continue
+		}
 		rs, ok := fl[name]
 		if !ok {
 			nors = append(nors, name)
 			continue
+		} else if merged {
+			// the shard is added for the replset mapped to it
+			continue
 		} else if mapRevRS(name) != sh.Name {
 			nors = append(nors, name)
 			continue
@@ -702,7 +1064,7 @@ func (r *Restore) setShards(ctx context.Context, bcp *backup.BackupMeta) error {
 		r.shards = append(r.shards, rs)
 	}
 
-	if r.nodeInfo.IsLeader() && len(nors) > 0 {
+	if r.isLeader() && len(nors) > 0 {
 		return errors.Errorf("extra/unknown replica set found in the backup: %s", strings.Join(nors, ", "))
 	}
 
@@ -727,17 +1089,104 @@ func (r *Restore) snapshotObjects(bcp *backup.BackupMeta) (string, []oplog.Oplog
 		}
 	}
 	if !ok {
-		if r.nodeInfo.IsLeader() {
+		if r.isLeader() {
 			return "", nil, ErrNoDataForConfigsvr
 		}
 		return "", nil, ErrNoDataForShard
 	}
 
-	if _, err := r.bcpStg.FileStat(rsMeta.DumpName); err != nil {
+	return r.replsetObjects(r.bcpStg, bcp, rsMeta)
+}
+
+// mergedObjects checks the backup data of the replsets merged to the node's
+// replset and returns the ranges of their oplog.
+func (r *Restore) mergedObjects(ctx context.Context, bcp *backup.BackupMeta) ([]oplogRange, error) {
+	merge := r.merge[r.brief.SetName]
+	if len(merge) == 0 {
+		return nil, nil
+	}
+	if bcp.IsLegacyArchive() {
+		return nil, errors.New("restore to a cluster with fewer shards is not supported from legacy backup")
+	}
+
+	var size int64
+	var ranges []oplogRange
+	for _, name := range merge {
+		rsMeta := bcp.RS(name)
+		if rsMeta == nil {
+			return nil, errors.Errorf("no replset %q in the backup", name)
+		}
+
+		err := r.checkForCompatibility(ctx, name, bcp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replset %q", name)
+		}
+
+		stgConf := &bcp.RSStorage(name).StorageConf
+		if err := checkBackupKey(stgConf); err != nil {
+			return nil, err
+		}
+		stg, err := util.StorageFromConfig(stgConf, r.brief.Me, r.log)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get backup storage of replset %q", name)
+		}
+		stg = r.withStats(stg)
+		_, chunks, err := r.replsetObjects(stg, bcp, rsMeta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replset %q", name)
+		}
+		ranges = append(ranges, oplogRange{chunks: chunks, storage: stg})
+		size += ReplsetDataSize(bcp, rsMeta)
+	}
+
+	if rsMeta := bcp.RS(util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)); rsMeta != nil {
+		size += ReplsetDataSize(bcp, rsMeta)
+	}
+	r.log.Info("merge data of replsets %s", strings.Join(merge, ", "))
+	r.warnDiskSpace(ctx, size)
+
+	return ranges, nil
+}
+
+// warnDiskSpace warns if the restored data of the size may not fit
+// the filesystem of the node.
+func (r *Restore) warnDiskSpace(ctx context.Context, size int64) {
+	var stats struct {
+		FsTotalSize int64 `bson:"fsTotalSize"`
+		FsUsedSize  int64 `bson:"fsUsedSize"`
+	}
+	err := r.nodeConn.Database("admin").RunCommand(ctx, bson.D{{"dbStats", 1}}).Decode(&stats)
+	if err != nil {
+		r.log.Warning("check disk space: get dbStats: %v", err)
+		return
+	}
+	if free := stats.FsTotalSize - stats.FsUsedSize; stats.FsTotalSize != 0 && size > free {
+		r.log.Warning("estimated size of the restored data %s exceeds free disk space %s",
+			storage.PrettySize(size), storage.PrettySize(free))
+	}
+}
+
+// replsetObjects checks the backup data of rsMeta on stg and returns
+// the dump name and the oplog chunks of the replset.
+func (r *Restore) replsetObjects(
+	stg storage.Storage,
+	bcp *backup.BackupMeta,
+	rsMeta *backup.BackupReplset,
+) (string, []oplog.OplogChunk, error) {
+	if _, err := stg.FileStat(rsMeta.DumpName); err != nil {
 		return "", nil, errors.Wrapf(err, "failed to ensure snapshot file %s", rsMeta.DumpName)
 	}
-	if version.IsLegacyBackupOplog(bcp.PBMVersion) {
-		if _, err := r.bcpStg.FileStat(rsMeta.OplogName); err != nil {
+	if bcp.HasIntegrityMarkers() {
+		if err := storage.VerifyIntegrity(stg, rsMeta.DumpName); err != nil {
+			return "", nil, errors.Wrapf(err, "snapshot file %s", rsMeta.DumpName)
+		}
+	}
+	if bcp.IsLegacyBackupOplog() {
+		if rsMeta.OplogName == "" {
+			// imported mongodump archive, nothing to replay
+			return rsMeta.DumpName, nil, nil
+		}
+		if _, err := stg.FileStat(rsMeta.OplogName); err != nil {
 			return "", nil, errors.Errorf("failed to ensure oplog file %s: %v", rsMeta.OplogName, err)
 		}
 
@@ -751,7 +1200,7 @@ func (r *Restore) snapshotObjects(bcp *backup.BackupMeta) (string, []oplog.Oplog
 		return rsMeta.DumpName, chunks, nil
 	}
 
-	files, err := r.bcpStg.List(rsMeta.OplogName, "")
+	files, err := stg.List(rsMeta.OplogName, "")
 	if err != nil {
 		return "", nil, errors.Wrap(err, "failed to list oplog files")
 	}
@@ -774,7 +1223,27 @@ func (r *Restore) snapshotObjects(bcp *backup.BackupMeta) (string, []oplog.Oplog
 	return rsMeta.DumpName, chunks, nil
 }
 
+// checkTarget runs CheckTarget on the node. The check is about the data
+// the restore drops, so it's skipped for the clone and no-drop restores
+// which keep the collections of the target.
+func (r *Restore) checkTarget(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	until primitive.Timestamp,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) error {
+	if cloneNS.IsSpecified() || r.noDrop {
+		return nil
+	}
+
+	return CheckTarget(ctx, r.nodeConn, bcp, until, nss, r.brief.Me, r.targetCheck)
+}
+
 func (r *Restore) checkSnapshot(ctx context.Context, bcp *backup.BackupMeta, nss []string) error {
+	if err := backup.CheckOrphaned(bcp); err != nil {
+		return err
+	}
 	if bcp.Status != defs.StatusDone {
 		return errors.Errorf("backup wasn't successful: status: %s, error: %s",
 			bcp.Status, bcp.Error())
@@ -784,6 +1253,9 @@ func (r *Restore) checkSnapshot(ctx context.Context, bcp *backup.BackupMeta, nss
 		return errors.Errorf("backup PBM v%s is incompatible with the running PBM v%s",
 			bcp.PBMVersion, version.Current().Version)
 	}
+	if err := backup.CheckRestoreVersion(bcp, version.Current().Version); err != nil {
+		return err
+	}
 
 	if bcp.FCV != "" {
 		fcv, err := version.GetFCV(ctx, r.nodeConn)
@@ -825,7 +1297,14 @@ func (r *Restore) checkSnapshot(ctx context.Context, bcp *backup.BackupMeta, nss
 
 func (r *Restore) toState(ctx context.Context, status defs.Status, wait *time.Duration) error {
 	r.log.Info("moving to state %s", status)
-	return toState(ctx, r.leadConn, status, r.name, r.nodeInfo, r.reconcileStatus, wait)
+	err := toState(ctx, r.leadConn, status, r.name, r.nodeInfo.SetName, r.isLeader(), r.reconcileStatus, wait)
+	return topo.WriteConcernError(ctx, r.leadConn.MongoClient(), "move to "+string(status), err)
+}
+
+// adminDB returns the admin database of the node with the write
+// concern of the restore (`cluster.writeConcern`).
+func (r *Restore) adminDB() *mongo.Database {
+	return r.nodeConn.Database("admin", options.Database().SetWriteConcern(r.cfg.WriteConcern()))
 }
 
 func (r *Restore) RunSnapshot(
@@ -836,7 +1315,7 @@ func (r *Restore) RunSnapshot(
 	cloneNS snapshot.CloneNS,
 	usersAndRolesOpt restoreUsersAndRolesOption,
 ) error {
-	if version.IsLegacyArchive(bcp.PBMVersion) {
+	if bcp.IsLegacyArchive() {
 		if util.IsSelective(bcp.Namespaces) || util.IsSelective(nss) {
 			return errors.New("selective restore is not supported from legacy backup")
 		}
@@ -853,18 +1332,43 @@ func (r *Restore) RunSnapshot(
 
 	mapRS := util.MakeReverseRSMapFunc(r.rsMap)
 
+	r.saveNamespaces(ctx, bcp, nss, cloneNS, usersAndRolesOpt)
+
 	r.log.Debug("restoring up to %d collections in parallel", r.numParallelColls)
 
-	rdr, err := snapshot.DownloadDump(
-		func(ns string) (io.ReadCloser, error) {
-			stg, err := util.StorageFromConfig(&bcp.Store.StorageConf, r.brief.Me, r.log)
-			if err != nil {
-				return nil, errors.Wrap(err, "get storage")
+	nsTier, onTier := r.nsTiers(ctx)
+	load := r.newNSLoadTracker(cloneNS, nsTier)
+	// while importing backup made by RS with another name
+	// that current RS we can't use our r.node.RS() to point files
+	// we have to use mapping passed by --replset-mapping option
+	download := r.dumpDownload(r.bcpStorageConf(bcp), path.Join(bcp.Name, mapRS(r.brief.SetName)))
+	selected := r.skipConflicting(util.MakeSelectedPred(nss))
+
+	// the whole dump is restored by one mongorestore with the namespace
+	// priority, cloning and the selective restore of the config server
+	var dbs *memory.Grant
+	if nsTier == nil && !cloneNS.IsSpecified() && !(r.nodeInfo.IsConfigSrv() && util.IsSelective(nss)) {
+		dbs = r.reserveParallelDBs()
+	}
+	if dbs != nil {
+		defer dbs.Release()
+
+		err := r.restoreDBsParallel(ctx, download, bcp.Compression, selected, load, cloneNS, dbs)
+		if err != nil {
+			return err
+		}
+
+		if usersAndRolesOpt {
+			if err := r.restoreUsersAndRoles(ctx, nss); err != nil {
+				return errors.Wrap(err, "restoring users and roles")
 			}
-			// while importing backup made by RS with another name
-			// that current RS we can't use our r.node.RS() to point files
-			// we have to use mapping passed by --replset-mapping option
-			rdr, err := stg.SourceReader(path.Join(bcp.Name, mapRS(r.brief.SetName), ns))
+		}
+		return nil
+	}
+
+	rdr, err := snapshot.DownloadDumpTiers(
+		func(ns string) (io.ReadCloser, error) {
+			rdr, err := download(ns)
 			if err != nil {
 				return nil, err
 			}
@@ -879,6 +1383,10 @@ func (r *Restore) RunSnapshot(
 				if err != nil {
 					return nil, errors.Wrap(err, "load indexes")
 				}
+				err = load.loadSizes(bytes.NewReader(data))
+				if err != nil {
+					return nil, errors.Wrap(err, "load sizes")
+				}
 
 				rdr = io.NopCloser(bytes.NewReader(data))
 			}
@@ -886,15 +1394,18 @@ func (r *Restore) RunSnapshot(
 			return rdr, nil
 		},
 		bcp.Compression,
-		util.MakeSelectedPred(nss),
-		r.numParallelColls)
+		r.log.Info,
+		load.match(selected),
+		r.numParallelColls,
+		nsTier,
+		onTier)
 	if err != nil {
 		return err
 	}
 	defer rdr.Close()
 
 	if r.nodeInfo.IsConfigSrv() && util.IsSelective(nss) {
-		err = r.snapshot(rdr, cloneNS, true)
+		err = r.snapshot(ctx, rdr, cloneNS, true, false, load)
 		if err != nil {
 			return errors.Wrap(err, "mongorestore")
 		}
@@ -904,7 +1415,7 @@ func (r *Restore) RunSnapshot(
 			return err
 		}
 	} else {
-		err = r.snapshot(rdr, cloneNS, false)
+		err = r.snapshot(ctx, rdr, cloneNS, false, false, load)
 		if err != nil {
 			return errors.Wrap(err, "mongorestore")
 		}
@@ -919,6 +1430,223 @@ func (r *Restore) RunSnapshot(
 	return nil
 }
 
+// mergeSnapshot restores the dump of the backup replset rs to the node's
+// replset after its own dump. The collections restored from both dumps
+// (sharded ones) get the documents of rs added, the rest of rs
+// collections are restored as usual. The cluster databases
+// (admin, config and local) are taken from the own dump only.
+func (r *Restore) mergeSnapshot(ctx context.Context, bcp *backup.BackupMeta, rs string) error {
+	download := r.dumpDownload(&bcp.RSStorage(rs).StorageConf, path.Join(bcp.Name, rs))
+	own := util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)
+	restored, err := r.dumpNamespaces(r.dumpDownload(r.bcpStorageConf(bcp), path.Join(bcp.Name, own)))
+	if err != nil {
+		return errors.Wrap(err, "read own dump metadata")
+	}
+	nss, err := r.dumpNamespaces(download)
+	if err != nil {
+		return errors.Wrap(err, "read dump metadata")
+	}
+
+	var alone, shared []string
+	for _, ns := range nss {
+		db, _, _ := strings.Cut(ns, ".")
+		if db == "admin" || db == "config" || db == "local" {
+			continue
+		}
+		if slices.Contains(restored, ns) {
+			shared = append(shared, ns)
+		} else {
+			alone = append(alone, ns)
+		}
+	}
+
+	return r.restoreDumpParts(ctx, bcp, rs, download, alone, shared)
+}
+
+// restoreDumpParts restores the alone namespaces of the dump of the backup
+// replset rs as usual and merges the documents of the shared ones into
+// the existing collections.
+func (r *Restore) restoreDumpParts(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	rs string,
+	download snapshot.DownloadFunc,
+	alone, shared []string,
+) error {
+	nsTier, onTier := r.nsTiers(ctx)
+	for _, part := range []struct {
+		nss   []string
+		merge bool
+	}{{alone, false}, {shared, true}} {
+		if len(part.nss) == 0 {
+			continue
+		}
+		r.log.Debug("restore %d namespaces of %s (merge: %v)", len(part.nss), rs, part.merge)
+
+		selected := make(map[string]bool, len(part.nss))
+		for _, ns := range part.nss {
+			selected[ns] = true
+		}
+		load := r.newNSLoadTracker(snapshot.CloneNS{}, nsTier)
+		rdr, err := snapshot.DownloadDumpTiers(
+			func(ns string) (io.ReadCloser, error) {
+				rdr, err := download(ns)
+				if err != nil || ns != archive.MetaFile {
+					return rdr, err
+				}
+
+				data, err := io.ReadAll(rdr)
+				rdr.Close()
+				if err != nil {
+					return nil, err
+				}
+				err = r.loadIndexesFrom(bytes.NewReader(data), snapshot.CloneNS{})
+				if err != nil {
+					return nil, errors.Wrap(err, "load indexes")
+				}
+				err = load.loadSizes(bytes.NewReader(data))
+				if err != nil {
+					return nil, errors.Wrap(err, "load sizes")
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+			bcp.Compression,
+			r.log.Info,
+			load.match(func(ns string) bool { return selected[ns] }),
+			r.numParallelColls,
+			nsTier,
+			onTier)
+		if err != nil {
+			return err
+		}
+
+		err = r.snapshot(ctx, rdr, snapshot.CloneNS{}, false, part.merge, load)
+		rdr.Close()
+		if err != nil {
+			return errors.Wrap(err, "mongorestore")
+		}
+	}
+
+	return nil
+}
+
+// dumpDownload returns the download of the dump files in the dir of stg.
+func (r *Restore) dumpDownload(stgConf *config.StorageConf, dir string) snapshot.DownloadFunc {
+	return func(ns string) (io.ReadCloser, error) {
+		stg, err := util.StorageFromConfig(stgConf, r.brief.Me, r.log)
+		if err != nil {
+			return nil, errors.Wrap(err, "get storage")
+		}
+		stg = r.withStats(stg)
+		rdr, err := stg.SourceReader(path.Join(dir, ns))
+		if err != nil {
+			return nil, err
+		}
+		return &readCounter{ReadCloser: storage.Prefetch(rdr, r.prefetchSize()), n: &r.bytes}, nil
+	}
+}
+
+// prefetchSize returns the read-ahead of a dump file. The read-ahead of
+// the restore is shared by the collections restored at once.
+func (r *Restore) prefetchSize() int {
+	files := max(r.numParallelColls, 1) * max(r.parallelDBs, 1)
+	return r.cfg.Restore.PrefetchSize() / files
+}
+
+// saveDataStat saves the bytes read and the documents count check of
+// the replset data restore. Failures are only logged.
+func (r *Restore) saveDataStat(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+) {
+	check, err := r.checkCounts(ctx, bcp, nss, cloneNS)
+	if err != nil {
+		r.log.Warning("check documents count: %v", err)
+	} else if check != nil && !check.Passed() {
+		r.log.Warning("documents count of %d collection(s) differs from the backup: %v",
+			len(check.Mismatches), check.Mismatches)
+	}
+
+	err = RestoreSetRSData(ctx, r.leadConn, r.name, r.nodeInfo.SetName, r.bytes.Load(), check)
+	if err != nil {
+		r.log.Warning("save data stats: %v", err)
+	}
+}
+
+// saveNamespaces saves the namespaces the replset restore is going to
+// write to, so the aborted restore can report them. Failures are only logged.
+func (r *Restore) saveNamespaces(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	cloneNS snapshot.CloneNS,
+	usersAndRoles restoreUsersAndRolesOption,
+) {
+	own := util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)
+	dumped, err := r.dumpNamespaces(r.dumpDownload(r.bcpStorageConf(bcp), path.Join(bcp.Name, own)))
+	if err != nil {
+		r.log.Warning("save restored namespaces: read dump metadata: %v", err)
+		return
+	}
+	for _, rs := range r.merge[r.brief.SetName] {
+		merged, err := r.dumpNamespaces(r.dumpDownload(&bcp.RSStorage(rs).StorageConf, path.Join(bcp.Name, rs)))
+		if err != nil {
+			r.log.Warning("save restored namespaces: read dump metadata of %s: %v", rs, err)
+			return
+		}
+		for _, ns := range merged {
+			db, _, _ := strings.Cut(ns, ".")
+			if db != "admin" && db != "config" && db != "local" {
+				dumped = append(dumped, ns)
+			}
+		}
+	}
+
+	selected := util.MakeSelectedPred(nss)
+	touched := []string{}
+	for _, ns := range dumped {
+		if !selected(ns) {
+			continue
+		}
+		if cloneNS.IsSpecified() && ns == cloneNS.FromNS {
+			ns = cloneNS.ToNS
+		}
+		touched = append(touched, ns)
+	}
+	if usersAndRoles {
+		touched = append(touched, "admin.system.users", "admin.system.roles")
+	}
+	slices.Sort(touched)
+	touched = slices.Compact(touched)
+
+	err = SetRestoreRSNamespaces(ctx, r.leadConn, r.name, r.nodeInfo.SetName, touched)
+	if err != nil {
+		r.log.Warning("save restored namespaces: %v", err)
+	}
+}
+
+// dumpNamespaces returns the namespaces of the dump metadata.
+func (r *Restore) dumpNamespaces(download snapshot.DownloadFunc) ([]string, error) {
+	rdr, err := download(archive.MetaFile)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	meta, err := archive.ReadMetadata(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	nss := make([]string, 0, len(meta.Namespaces))
+	for _, ns := range meta.Namespaces {
+		nss = append(nss, archive.NSify(ns.Database, ns.Collection))
+	}
+	return nss, nil
+}
+
 func (r *Restore) restoreLegacyArchive(
 	ctx context.Context,
 	dump string,
@@ -930,14 +1658,14 @@ func (r *Restore) restoreLegacyArchive(
 	}
 	defer sr.Close()
 
-	rdr, err := compress.Decompress(sr, bcp.Compression)
+	rdr, err := compress.Decompress(sr, bcp.Compression, r.log.Info)
 	if err != nil {
 		return errors.Wrapf(err, "decompress object %s", dump)
 	}
 	defer rdr.Close()
 
 	// Restore snapshot (mongorestore)
-	err = r.snapshot(rdr, snapshot.CloneNS{}, false)
+	err = r.snapshot(ctx, rdr, snapshot.CloneNS{}, false, false, nil)
 	if err != nil {
 		return errors.Wrap(err, "mongorestore")
 	}
@@ -1008,6 +1736,7 @@ func (r *Restore) restoreUsersAndRoles(ctx context.Context, nss []string) error
 
 	err = r.swapUsers(ctx, cusr, nss)
 	if err != nil {
+		err = topo.WriteConcernError(ctx, r.nodeConn, "restore users and roles", err)
 		return errors.Wrap(err, "swap users 'n' roles")
 	}
 
@@ -1036,6 +1765,15 @@ func (r *Restore) loadIndexesFrom(rdr io.Reader, cloneNS snapshot.CloneNS) error
 				ns.Database, ns.Collection)
 		}
 
+		if len(md.Options) != 0 {
+			if r.archiveOptions == nil {
+				r.archiveOptions = make(map[string]bson.D)
+			}
+			if _, ok := r.archiveOptions[ns.Database+"."+ns.Collection]; !ok {
+				r.archiveOptions[ns.Database+"."+ns.Collection] = md.Options
+			}
+		}
+
 		if cloneNS.IsSpecified() && ns.Database == fromDB && ns.Collection == fromColl {
 			r.indexCatalog.AddIndexes(toDB, toColl, md.Indexes)
 		} else {
@@ -1070,7 +1808,17 @@ func (r *Restore) loadIndexesFrom(rdr io.Reader, cloneNS snapshot.CloneNS) error
 func (r *Restore) restoreIndexes(ctx context.Context, nss []string) error {
 	r.log.Debug("building indexes up")
 
-	isSelected := util.MakeSelectedPred(nss)
+	var conf *config.IndexBuildConf
+	if r.cfg.Restore != nil {
+		conf = r.cfg.Restore.IndexBuild
+	}
+	batchSize, order := 0, config.IndexBuildByName
+	if conf != nil {
+		batchSize, order = conf.BatchSize, conf.Order
+	}
+
+	var builds []*indexBuild
+	isSelected := r.skipConflicting(util.MakeSelectedPred(nss))
 	for _, ns := range r.indexCatalog.Namespaces() {
 		if ns := archive.NSify(ns.DB, ns.Collection); !isSelected(ns) {
 			r.log.Debug("skip restore indexes for %q", ns)
@@ -1091,29 +1839,24 @@ func (r *Restore) restoreIndexes(ctx context.Context, nss []string) error {
 			continue
 		}
 
-		var indexNames []string
 		for _, index := range indexes {
 			index.Options["ns"] = ns.DB + "." + ns.Collection
-			indexNames = append(indexNames, index.Options["name"].(string))
 			// remove the index version, forcing an update
 			delete(index.Options, "v")
 		}
 
-		rawCommand := bson.D{
-			{"createIndexes", ns.Collection},
-			{"indexes", indexes},
-			{"ignoreUnknownIndexOptions", true},
-		}
+		builds = append(builds, splitIndexBuilds(ns.DB, ns.Collection, indexes, batchSize)...)
+	}
+	if len(builds) == 0 {
+		return nil
+	}
 
-		r.log.Info("restoring indexes for %s.%s: %s",
-			ns.DB, ns.Collection, strings.Join(indexNames, ", "))
-		err := r.nodeConn.Database(ns.DB).RunCommand(ctx, rawCommand).Err()
-		if err != nil {
-			return errors.Wrapf(err, "createIndexes for %s.%s", ns.DB, ns.Collection)
-		}
+	if order != config.IndexBuildByName {
+		r.setIndexBuildSizes(ctx, builds)
 	}
+	sortIndexBuilds(builds, order)
 
-	return nil
+	return r.buildIndexes(ctx, builds, conf)
 }
 
 func (r *Restore) updateRouterConfig(ctx context.Context) error {
@@ -1261,6 +2004,7 @@ func (r *Restore) getcommittedTxn(ctx context.Context) (map[string]primitive.Tim
 		if err != nil {
 			return nil, errors.Wrap(err, "read cluster time")
 		}
+		staleSec := lock.StaleSec(ctx, r.leadConn)
 
 		// not going directly thru bmeta.Replsets to be sure we've heard back
 		// from all participated in the restore shards.
@@ -1281,7 +2025,7 @@ func (r *Restore) getcommittedTxn(ctx context.Context) (map[string]primitive.Tim
 				if err != nil {
 					return nil, errors.Wrapf(err, "unable to read lock for shard %s", shard.Name)
 				}
-				if lck.Heartbeat.T+defs.StaleFrameSec < clusterTime.T {
+				if lck.IsStale(clusterTime, staleSec) {
 					return nil, errors.Errorf("lost shard %s, last beat ts: %d", shard.Name, lck.Heartbeat.T)
 				}
 			}
@@ -1305,11 +2049,17 @@ func (r *Restore) getcommittedTxn(ctx context.Context) (map[string]primitive.Tim
 	return txn, nil
 }
 
+// bcpStorageConf returns the config of the storage with the replset data of the backup.
+func (r *Restore) bcpStorageConf(bcp *backup.BackupMeta) *config.StorageConf {
+	return &bcp.RSStorage(util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)).StorageConf
+}
+
 func (r *Restore) applyOplog(ctx context.Context, ranges []oplogRange, options *applyOplogOption) error {
 	mgoV, err := version.GetMongoVersion(ctx, r.nodeConn)
 	if err != nil || len(mgoV.Version) < 1 {
 		return errors.Wrap(err, "define mongo version")
 	}
+	options.prefetch = r.cfg.Restore.PrefetchSize()
 	stat := phys.RestoreShardStat{}
 	partial, err := applyOplog(ctx,
 		r.nodeConn,
@@ -1319,6 +2069,7 @@ func (r *Restore) applyOplog(ctx context.Context, ranges []oplogRange, options *
 		r.indexCatalog,
 		r.setcommittedTxn,
 		r.getcommittedTxn,
+		r.setOplogProgress,
 		&stat.Txn,
 		&mgoV)
 	if err != nil {
@@ -1345,30 +2096,135 @@ func (r *Restore) applyOplog(ctx context.Context, ranges []oplogRange, options *
 	return nil
 }
 
-func (r *Restore) snapshot(input io.Reader, cloneNS snapshot.CloneNS, excludeRouterCollections bool) error {
+func (r *Restore) setOplogProgress(ctx context.Context, p *OplogProgress) error {
+	return SetOplogProgress(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+}
+
+// skipConflicting returns the selected predicate without the conflicting
+// namespaces skipped by the no drop restore.
+func (r *Restore) skipConflicting(selected archive.NSFilterFn) archive.NSFilterFn {
+	skip := r.nsConflicts.Decided(ctrl.NSConflictSkip)
+	if len(skip) == 0 {
+		return selected
+	}
+
+	r.log.Info("skip conflicting namespaces: %s", strings.Join(skip, ", "))
+	return func(ns string) bool {
+		return selected(ns) && !slices.Contains(skip, ns)
+	}
+}
+
+// dropConflicting drops the conflicting collections of the no drop
+// restore to be recreated from the backup.
+func (r *Restore) dropConflicting(ctx context.Context) error {
+	for _, ns := range r.nsConflicts.Decided(ctrl.NSConflictRecreate) {
+		db, coll, _ := strings.Cut(ns, ".")
+		r.log.Info("drop conflicting collection %s", ns)
+		err := r.nodeConn.Database(db).Collection(coll).Drop(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "drop conflicting collection %s", ns)
+		}
+	}
+
+	return nil
+}
+
+// snapshot restores the input. If merge is true, the documents
+// are added to the existing collections. The progress of the namespaces
+// is saved if load is set. The input is closed once ctx is done (e.g.
+// the restore is aborted) as mongorestore reads it till the end.
+func (r *Restore) snapshot(
+	ctx context.Context,
+	input io.ReadCloser,
+	cloneNS snapshot.CloneNS,
+	excludeRouterCollections bool,
+	merge bool,
+	load *nsLoadTracker,
+) error {
+	uri := r.brief.URI
+	if r.mongosURI != "" {
+		uri = r.mongosURI
+	}
+	if r.failures == nil {
+		r.failures = snapshot.NewFailures(r.cfg.Restore.FailureDetails())
+	}
+	var nsp *snapshot.NSProgress
+	if load != nil {
+		nsp = snapshot.NewNSProgress()
+	}
 	rf, err := snapshot.NewRestore(
-		r.brief.URI,
+		uri,
 		r.cfg, cloneNS,
 		r.numParallelColls,
 		r.numInsertionWorkersPerCol,
-		excludeRouterCollections)
+		r.reservedBatches,
+		excludeRouterCollections,
+		merge,
+		r.noDrop,
+		r.mongosURI != "",
+		r.failures,
+		nsp)
 	if err != nil {
 		return err
 	}
 
+	stopClose := context.AfterFunc(ctx, func() { _ = input.Close() })
+	defer stopClose()
+
+	if load != nil {
+		stop := make(chan struct{})
+		go r.trackNSLoad(ctx, load, nsp, stop)
+		defer func() {
+			close(stop)
+			r.saveNSLoad(ctx, load, nsp)
+		}()
+	}
+
 	_, err = rf.ReadFrom(input)
+	r.saveFailures(ctx)
 	return err
 }
 
+// saveFailures saves the failed writes to the restore metadata and logs
+// the ones without details by the error code. It is the best effort,
+// like saveTransfers.
+func (r *Restore) saveFailures(ctx context.Context) {
+	r.failuresMx.Lock()
+	defer r.failuresMx.Unlock()
+
+	total := r.failures.Total()
+	if total == r.failuresSaved {
+		return
+	}
+	r.failuresSaved = total
+
+	f := &RestoreFailures{
+		Total:      total,
+		List:       r.failures.List(),
+		Dropped:    r.failures.Dropped(),
+		Namespaces: r.failures.Namespaces(),
+	}
+	for _, d := range f.Dropped {
+		r.log.Warning("failed writes without details: %d with error code %d", d.Count, d.Code)
+	}
+
+	err := SetRestoreRSFailures(ctx, r.leadConn, r.name, r.nodeInfo.SetName, f)
+	if err != nil {
+		r.log.Warning("save restore failures: %v", err)
+	}
+}
+
 // Done waits for the replicas to finish the job
 // and marks restore as done
 func (r *Restore) Done(ctx context.Context) error {
+	r.saveTransfers(ctx)
 	err := ChangeRestoreRSState(ctx, r.leadConn, r.name, r.nodeInfo.SetName, defs.StatusDone, "")
 	if err != nil {
+		err = topo.WriteConcernError(ctx, r.leadConn.MongoClient(), "set replset restore status done", err)
 		return errors.Wrap(err, "set shard's StatusDone")
 	}
 
-	if r.nodeInfo.IsLeader() {
+	if r.isLeader() {
 		err = r.reconcileStatus(ctx, defs.StatusDone, nil)
 		if err != nil {
 			return errors.Wrap(err, "check cluster for the restore done")
@@ -1414,7 +2270,7 @@ func (r *Restore) swapUsers(ctx context.Context, exclude *topo.AuthInfo, nss []s
 		dbs = append(dbs, db)
 	}
 
-	rolesC := r.nodeConn.Database("admin").Collection("system.roles")
+	rolesC := r.adminDB().Collection("system.roles")
 
 	eroles := []string{}
 	for _, r := range exclude.UserRoles {
@@ -1469,7 +2325,7 @@ func (r *Restore) swapUsers(ctx context.Context, exclude *topo.AuthInfo, nss []s
 	}
 	defer cur.Close(ctx)
 
-	usersC := r.nodeConn.Database("admin").Collection("system.users")
+	usersC := r.adminDB().Collection("system.users")
 	_, err = usersC.DeleteMany(ctx, filterUsers)
 	if err != nil {
 		return errors.Wrap(err, "delete current users")
@@ -1504,12 +2360,35 @@ func (r *Restore) waitForStatus(ctx context.Context, status defs.Status) error {
 	return waitForStatus(ctx, r.leadConn, r.name, status)
 }
 
-// MarkFailed sets the restore and rs state as failed with the given message
+// MarkFailed sets the restore and rs state as failed with the given message.
+// The restore state of the aborted restore is kept.
 func (r *Restore) MarkFailed(ctx context.Context, e error) error {
-	err := ChangeRestoreState(ctx, r.leadConn, r.name, defs.StatusError, e.Error())
-	if err != nil {
-		return errors.Wrap(err, "set restore state")
+	meta, err := GetRestoreMeta(ctx, r.leadConn, r.name)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return errors.Wrap(err, "get restore meta")
+	}
+	code := errors.CodeOf(e)
+	if meta == nil || meta.Status != defs.StatusAborted {
+		if code != "" {
+			if err := SetRestoreErrorCode(ctx, r.leadConn, r.name, code); err != nil {
+				r.log.Warning("set restore error code %s: %v", code, err)
+			}
+		}
+		err = ChangeRestoreState(ctx, r.leadConn, r.name, defs.StatusError, e.Error())
+		if err != nil {
+			err = topo.WriteConcernError(ctx, r.leadConn.MongoClient(), "set restore status error", err)
+			return errors.Wrap(err, "set restore state")
+		}
+	}
+	r.saveTransfers(ctx)
+	if code != "" {
+		if err := SetRestoreRSErrorCode(ctx, r.leadConn, r.name, r.nodeInfo.SetName, code); err != nil {
+			r.log.Warning("set replset restore error code %s: %v", code, err)
+		}
 	}
 	err = ChangeRestoreRSState(ctx, r.leadConn, r.name, r.nodeInfo.SetName, defs.StatusError, e.Error())
+	if err != nil {
+		err = topo.WriteConcernError(ctx, r.leadConn.MongoClient(), "set replset restore status error", err)
+	}
 	return errors.Wrap(err, "set replset state")
 }
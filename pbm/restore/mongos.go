@@ -0,0 +1,397 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// mongosPingFrame is how recent the ping of the mongos has to be
+// to restore through it
+const mongosPingFrame = time.Minute
+
+// viaMongos returns true if the logical restore goes through mongos
+func (r *Restore) viaMongos() bool {
+	return r.brief.Sharded && r.cfg.Restore.IsViaMongos()
+}
+
+// dataConn is the connection the restored data is written to
+func (r *Restore) dataConn() *mongo.Client {
+	if r.mongos != nil {
+		return r.mongos
+	}
+	return r.nodeConn
+}
+
+// snapshotViaMongos restores the backup of the sharded cluster through
+// mongos (`restore.viaMongos`). The leader restores the dumps of all
+// backup replsets, mongos places the documents by the current chunks
+// of the cluster. The other replsets only follow the restore states.
+// The oplog of the backup isn't replayed.
+func (r *Restore) snapshotViaMongos(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	nss []string,
+	usersAndRolesOpt restoreUsersAndRolesOption,
+) error {
+	if r.singleRS != "" || len(r.merge) != 0 || len(r.rsMap) != 0 {
+		return errors.New("single replset restore and replset mapping are not supported with restore.viaMongos")
+	}
+	if bcp.IsLegacyArchive() {
+		return errors.New("restore.viaMongos is not supported for legacy backup")
+	}
+
+	var err error
+	r.shards, err = topo.ClusterMembers(ctx, r.leadConn.MongoClient())
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+
+	if !r.isLeader() {
+		r.log.Info("the data is restored through mongos by the config server")
+		err = r.toState(ctx, defs.StatusRunning, &defs.WaitActionStart)
+		if err != nil {
+			return err
+		}
+		err = r.toState(ctx, defs.StatusDumpDone, nil)
+		if err != nil {
+			return err
+		}
+		return r.Done(ctx)
+	}
+
+	if !util.IsSelective(nss) {
+		nss = bcp.Namespaces
+	}
+	if !util.IsSelective(nss) {
+		nss = []string{"*.*"}
+	}
+	selected := util.MakeSelectedPred(nss)
+
+	err = r.connectMongos(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.mongos.Disconnect(context.Background()); err != nil {
+			r.log.Warning("disconnect mongos: %v", err)
+		}
+		r.mongos, r.mongosURI = nil, ""
+	}()
+
+	sharded, err := r.prepareShardedColls(ctx, bcp, selected)
+	if err != nil {
+		return errors.Wrap(err, "prepare sharded collections")
+	}
+
+	err = r.toState(ctx, defs.StatusRunning, &defs.WaitActionStart)
+	if err != nil {
+		return err
+	}
+
+	restored := make(map[string]bool)
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		download := r.dumpDownload(&bcp.RSStorage(rs.Name).StorageConf, path.Join(bcp.Name, rs.Name))
+		dumped, err := r.dumpNamespaces(download)
+		if err != nil {
+			return errors.Wrapf(err, "read dump metadata of %s", rs.Name)
+		}
+
+		isConfigSvr := rs.IsConfigSvr != nil && *rs.IsConfigSvr
+		alone, shared := splitMongosNamespaces(dumped, isConfigSvr, selected, sharded, restored)
+		err = r.restoreDumpParts(ctx, bcp, rs.Name, download, alone, shared)
+		if err != nil {
+			return errors.Wrapf(err, "restore data of replset %q", rs.Name)
+		}
+	}
+
+	if usersAndRolesOpt {
+		if err := r.restoreUsersAndRoles(ctx, nss); err != nil {
+			return errors.Wrap(err, "restoring users and roles")
+		}
+	}
+
+	err = r.toState(ctx, defs.StatusDumpDone, nil)
+	if err != nil {
+		return err
+	}
+
+	err = r.restoreIndexes(ctx, nss)
+	if err != nil {
+		return errors.Wrap(err, "restore indexes")
+	}
+
+	err = r.verifySharding(ctx, r.mongos, r.cfg.Restore.IsRestoreZones())
+	if err != nil {
+		return errors.Wrap(err, "verify sharding")
+	}
+
+	return r.Done(ctx)
+}
+
+// splitMongosNamespaces returns the namespaces of the replset dump restored
+// through mongos. The alone ones are restored anew, the documents of the
+// shared ones (sharded or restored from another replset dump) are added to
+// the existing collections. The admin database is restored from the config
+// server dump only, the config and local databases are never restored.
+// restored is updated with the returned namespaces.
+func splitMongosNamespaces(
+	dumped []string,
+	isConfigSvr bool,
+	selected archive.NSFilterFn,
+	sharded, restored map[string]bool,
+) ([]string, []string) {
+	var alone, shared []string
+	for _, ns := range dumped {
+		db, _, _ := strings.Cut(ns, ".")
+		if db == "config" || db == "local" || (db == "admin" && !isConfigSvr) || !selected(ns) {
+			continue
+		}
+
+		if sharded[ns] || restored[ns] {
+			shared = append(shared, ns)
+		} else {
+			alone = append(alone, ns)
+		}
+		restored[ns] = true
+	}
+
+	return alone, shared
+}
+
+// connectMongos connects to the active mongos routers with the credentials
+// of the node uri.
+func (r *Restore) connectMongos(ctx context.Context) error {
+	hosts, err := topo.ActiveMongos(ctx, r.leadConn, mongosPingFrame)
+	if err != nil {
+		return errors.Wrap(err, "get mongos")
+	}
+	if len(hosts) == 0 {
+		return errors.New("no active mongos found")
+	}
+
+	r.mongosURI, err = connect.MongosURI(r.brief.URI, hosts)
+	if err != nil {
+		return errors.Wrap(err, "mongos uri")
+	}
+	r.mongos, err = connect.MongosConnect(ctx, r.brief.URI, hosts, connect.AppName("pbm-agent"))
+	if err != nil {
+		r.mongosURI = ""
+		return errors.Wrap(err, "connect to mongos")
+	}
+
+	r.log.Info("restore through mongos %s", strings.Join(hosts, ", "))
+	return nil
+}
+
+// shardedCollSpec is the config.collections document of the sharded collection
+type shardedCollSpec struct {
+	NS           string `bson:"_id"`
+	Key          bson.D `bson:"key"`
+	Unique       bool   `bson:"unique"`
+	Dropped      bool   `bson:"dropped"`
+	Unsplittable bool   `bson:"unsplittable"`
+}
+
+// prepareShardedColls makes the selected collections sharded in the backup
+// sharded in the cluster before the data is restored. A collection that is
+// sharded by the same key is kept (with its chunks) and emptied, a missed
+// collection is sharded by the key of the backup. A collection sharded by
+// another key fails the restore before any data is changed, or is kept
+// and emptied as well with `restore.shardKeyMismatch: warn`. The zones of
+// the backup are recreated with `restore.restoreZones`.
+// It returns the namespaces of the sharded collections.
+func (r *Restore) prepareShardedColls(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	selected archive.NSFilterFn,
+) (map[string]bool, error) {
+	specs, zones, err := r.backupSharding(bcp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read backup sharded collections")
+	}
+	r.sharding = newShardingResult(specs, selected)
+
+	var colls []*ShardedCollResult
+	for i := range r.sharding.Collections {
+		c := &r.sharding.Collections[i]
+		if strings.Contains(c.NS, ".system.buckets.") {
+			r.log.Warning("sharded timeseries %s is restored unsharded", c.NS)
+			c.Action = ShardingSkipped
+			continue
+		}
+		colls = append(colls, c)
+	}
+
+	var mismatch []string
+	for _, c := range colls {
+		var curr shardedCollSpec
+		err := r.mongos.Database("config").Collection("collections").
+			FindOne(ctx, bson.D{{"_id", c.NS}, {"dropped", bson.D{{"$ne", true}}}}).Decode(&curr)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "get %s", c.NS)
+		}
+		if curr.Unsplittable {
+			continue
+		}
+		c.Action = ShardingKept
+		if !sameShardKey(curr.Key, c.key) {
+			c.Action = ShardingMismatch
+			c.CurrentKey = formatShardKey(curr.Key)
+			mismatch = append(mismatch, fmt.Sprintf("%s is sharded by %s, the backup has %s",
+				c.NS, c.CurrentKey, c.Key))
+		}
+	}
+	err = shardingMismatch(r.cfg.Restore.ShardKeyMismatchPolicy(), mismatch, r.log.Warning)
+	if err != nil {
+		r.saveSharding(ctx)
+		return nil, err
+	}
+
+	rv := make(map[string]bool, len(colls))
+	for _, c := range colls {
+		rv[c.NS] = true
+		db, coll, _ := strings.Cut(c.NS, ".")
+		if c.Action == ShardingKept || c.Action == ShardingMismatch {
+			r.log.Info("sharded collection %s exists, its documents are replaced", c.NS)
+			_, err := r.mongos.Database(db).Collection(coll).DeleteMany(ctx, bson.D{})
+			if err != nil {
+				return nil, errors.Wrapf(err, "delete documents of %s", c.NS)
+			}
+			continue
+		}
+
+		r.log.Info("shard collection %s by %s", c.NS, c.Key)
+		// the collection may exist unsharded
+		err := r.mongos.Database(db).Collection(coll).Drop(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "drop %s", c.NS)
+		}
+		// noop since 6.0
+		err = r.mongos.Database("admin").RunCommand(ctx, bson.D{{"enableSharding", db}}).Err()
+		if err != nil {
+			return nil, errors.Wrapf(err, "enable sharding for %s", db)
+		}
+		cmd := bson.D{{"shardCollection", c.NS}, {"key", c.key}}
+		if c.Unique {
+			cmd = append(cmd, bson.E{"unique", true})
+		}
+		err = r.mongos.Database("admin").RunCommand(ctx, cmd).Err()
+		if err != nil {
+			return nil, errors.Wrapf(err, "shard collection %s", c.NS)
+		}
+		c.Action = ShardingCreated
+	}
+
+	if r.cfg.Restore.IsRestoreZones() {
+		r.restoreZones(ctx, bcp, zones)
+	}
+	r.saveSharding(ctx)
+
+	return rv, nil
+}
+
+// backupShardedColls reads config.collections of the config server dump.
+func (r *Restore) backupShardedColls(bcp *backup.BackupMeta) ([]shardedCollSpec, error) {
+	var rv []shardedCollSpec
+	err := r.readConfigDump(bcp, collectionsNS, func(b bson.Raw) error {
+		var c shardedCollSpec
+		if err := bson.Unmarshal(b, &c); err != nil {
+			return err
+		}
+		rv = append(rv, c)
+		return nil
+	})
+
+	return rv, err
+}
+
+// readConfigDump decodes the documents of the config.<coll> dump
+// of the config server replset of the backup
+func (r *Restore) readConfigDump(bcp *backup.BackupMeta, coll string, fn func(bson.Raw) error) error {
+	var cfgRS *backup.BackupReplset
+	for i := range bcp.Replsets {
+		if rs := &bcp.Replsets[i]; rs.IsConfigSvr != nil && *rs.IsConfigSvr {
+			cfgRS = rs
+			break
+		}
+	}
+	if cfgRS == nil {
+		return errors.New("no configsvr replset metadata found")
+	}
+
+	download := r.dumpDownload(&bcp.RSStorage(cfgRS.Name).StorageConf, path.Join(bcp.Name, cfgRS.Name))
+	rdr, err := download("config." + coll + bcp.Compression.Suffix())
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	drdr, err := compress.Decompress(rdr, bcp.Compression, r.log.Info)
+	if err != nil {
+		return err
+	}
+	defer drdr.Close()
+
+	buf := make([]byte, archive.MaxBSONSize)
+	for {
+		buf, err = archive.ReadBSONBuffer(drdr, buf[:cap(buf)])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := fn(buf); err != nil {
+			return errors.Wrap(err, "unmarshal")
+		}
+	}
+}
+
+// sameShardKey returns true if the keys have the same fields in the same
+// order and of the same kind (the numeric directions of any type).
+func sameShardKey(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+		x, xok := a[i].Value.(string)
+		y, yok := b[i].Value.(string)
+		if xok != yok || x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// formatShardKey returns the key as {field: value, ...}
+func formatShardKey(key bson.D) string {
+	fields := make([]string, len(key))
+	for i, e := range key {
+		fields[i] = fmt.Sprintf("%s: %v", e.Key, e.Value)
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
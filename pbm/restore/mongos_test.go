@@ -0,0 +1,71 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+func TestSameShardKey(t *testing.T) {
+	testCases := []struct {
+		a, b bson.D
+		want bool
+	}{
+		{bson.D{{"a", 1}}, bson.D{{"a", int64(1)}}, true},
+		{bson.D{{"a", 1}, {"b", 1.0}}, bson.D{{"a", int32(1)}, {"b", 1}}, true},
+		{bson.D{{"a", "hashed"}}, bson.D{{"a", "hashed"}}, true},
+		{bson.D{{"a", "hashed"}}, bson.D{{"a", 1}}, false},
+		{bson.D{{"a", 1}}, bson.D{{"b", 1}}, false},
+		{bson.D{{"a", 1}, {"b", 1}}, bson.D{{"b", 1}, {"a", 1}}, false},
+		{bson.D{{"a", 1}}, bson.D{{"a", 1}, {"b", 1}}, false},
+	}
+
+	for _, tc := range testCases {
+		if got := sameShardKey(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s vs %s: got %v, want %v", formatShardKey(tc.a), formatShardKey(tc.b), got, tc.want)
+		}
+	}
+}
+
+func TestSplitMongosNamespaces(t *testing.T) {
+	selected := util.MakeSelectedPred(nil)
+	sharded := map[string]bool{"app.events": true}
+	restored := make(map[string]bool)
+
+	cfgDump := []string{"admin.system.users", "config.chunks", "config.collections", "app.settings"}
+	alone, shared := splitMongosNamespaces(cfgDump, true, selected, sharded, restored)
+	if want := []string{"admin.system.users", "app.settings"}; !reflect.DeepEqual(alone, want) {
+		t.Errorf("config server alone: got %v, want %v", alone, want)
+	}
+	if len(shared) != 0 {
+		t.Errorf("config server shared: got %v", shared)
+	}
+
+	rs0Dump := []string{"admin.system.version", "local.oplog.rs", "app.events", "app.users"}
+	alone, shared = splitMongosNamespaces(rs0Dump, false, selected, sharded, restored)
+	if want := []string{"app.users"}; !reflect.DeepEqual(alone, want) {
+		t.Errorf("rs0 alone: got %v, want %v", alone, want)
+	}
+	if want := []string{"app.events"}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("rs0 shared: got %v, want %v", shared, want)
+	}
+
+	// the collections restored from rs0 are merged
+	rs1Dump := []string{"app.events", "app.users", "app.orders"}
+	alone, shared = splitMongosNamespaces(rs1Dump, false, selected, sharded, restored)
+	if want := []string{"app.orders"}; !reflect.DeepEqual(alone, want) {
+		t.Errorf("rs1 alone: got %v, want %v", alone, want)
+	}
+	if want := []string{"app.events", "app.users"}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("rs1 shared: got %v, want %v", shared, want)
+	}
+
+	alone, shared = splitMongosNamespaces(rs1Dump, false, util.MakeSelectedPred([]string{"app.orders"}),
+		sharded, make(map[string]bool))
+	if want := []string{"app.orders"}; !reflect.DeepEqual(alone, want) || len(shared) != 0 {
+		t.Errorf("selective: got %v, %v", alone, shared)
+	}
+}
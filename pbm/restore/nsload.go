@@ -0,0 +1,354 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+const (
+	// nsLoadHbFrame is how often the namespaces progress is saved
+	nsLoadHbFrame = 5 * time.Second
+	// nsLoadTopN is the max number of namespaces listed in the progress
+	nsLoadTopN = 10
+	// nsLoadMinShare is the share of the data of the restore below which
+	// a namespace is counted in the other bucket instead of listed
+	nsLoadMinShare = 0.01
+)
+
+// NSLoadProgress is the progress of the data load of the namespaces of
+// the logical restore on the replset. The sizes are the BSON sizes of
+// the archive metadata. Only the largest namespaces in flight and
+// pending are listed, the rest are summed up in Other.
+type NSLoadProgress struct {
+	// Done is the number of the namespaces loaded
+	Done       int   `bson:"done" json:"done"`
+	Total      int   `bson:"total" json:"total"`
+	Bytes      int64 `bson:"bytes" json:"bytes"`
+	BytesTotal int64 `bson:"bytes_total" json:"bytes_total"`
+	// ETASec is the estimated time left of the data load.
+	// 0 if it's unknown yet.
+	ETASec     int64         `bson:"eta_sec,omitempty" json:"eta_sec,omitempty"`
+	Namespaces []NSLoadState `bson:"nss,omitempty" json:"nss,omitempty"`
+	Other      *NSLoadOther  `bson:"other,omitempty" json:"other,omitempty"`
+	UpdatedAt  int64         `bson:"updated_at" json:"updated_at"`
+}
+
+// NSLoadState is the progress of the data load of the namespace
+type NSLoadState struct {
+	NS string `bson:"ns" json:"ns"`
+	// Running is false for the namespaces mongorestore hasn't started yet
+	Running    bool    `bson:"running" json:"running"`
+	Bytes      int64   `bson:"bytes" json:"bytes"`
+	BytesTotal int64   `bson:"bytes_total" json:"bytes_total"`
+	Percent    float64 `bson:"percent" json:"percent"`
+	// ETASec is the estimated time till the namespace is loaded.
+	// 0 if it's unknown yet.
+	ETASec int64 `bson:"eta_sec,omitempty" json:"eta_sec,omitempty"`
+}
+
+// NSLoadOther are the namespaces in flight and pending not listed
+// in the progress
+type NSLoadOther struct {
+	Namespaces int   `bson:"nss" json:"nss"`
+	Running    int   `bson:"running" json:"running"`
+	Bytes      int64 `bson:"bytes" json:"bytes"`
+	BytesTotal int64 `bson:"bytes_total" json:"bytes_total"`
+}
+
+func (p *NSLoadProgress) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d/%d namespaces, %.1f%% of %s",
+		p.Done, p.Total, loadPercent(p.Bytes, p.BytesTotal), storage.PrettySize(p.BytesTotal))
+	if p.ETASec > 0 {
+		fmt.Fprintf(&b, ", ETA %s", fmtETA(p.ETASec))
+	}
+	for _, s := range p.Namespaces {
+		b.WriteString("\n  - " + s.String())
+	}
+	if o := p.Other; o != nil {
+		fmt.Fprintf(&b, "\n  - other %d namespaces (%d running): %.1f%% of %s",
+			o.Namespaces, o.Running, loadPercent(o.Bytes, o.BytesTotal), storage.PrettySize(o.BytesTotal))
+	}
+
+	return b.String()
+}
+
+func (s *NSLoadState) String() string {
+	var rv string
+	if s.Running {
+		rv = fmt.Sprintf("%s: %.1f%% of %s", s.NS, s.Percent, storage.PrettySize(s.BytesTotal))
+	} else {
+		rv = fmt.Sprintf("%s: pending, %s", s.NS, storage.PrettySize(s.BytesTotal))
+	}
+	if s.ETASec > 0 {
+		rv += ", ETA " + fmtETA(s.ETASec)
+	}
+	return rv
+}
+
+func loadPercent(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return min(float64(done)/float64(total)*100, 100)
+}
+
+func fmtETA(sec int64) string {
+	return (time.Duration(sec) * time.Second).String()
+}
+
+type nsSample struct {
+	t     time.Time
+	bytes int64
+}
+
+// nsLoadTracker computes the progress of the namespaces of the archive
+// restored by mongorestore. The namespaces are the ones the archive is
+// composed of (see match) by the namespace they are restored to.
+type nsLoadTracker struct {
+	mx sync.Mutex
+
+	start   time.Time
+	cloneNS snapshot.CloneNS
+	tier    archive.NSTierFn
+	// restored returns false for the namespaces mongorestore skips
+	restored func(ns string) bool
+
+	// sizes are the archive sizes by the backup namespace
+	sizes map[string]int64
+	// order is the namespaces in the archive order once sorted
+	order  []string
+	sorted bool
+	total  map[string]int64
+	tiers  map[string]int
+	// first is the first observation of the namespaces in flight
+	first map[string]nsSample
+	last  map[string]snapshot.NSBytes
+}
+
+func newNSLoadTracker(
+	now time.Time,
+	cloneNS snapshot.CloneNS,
+	nsTier archive.NSTierFn,
+	restored func(ns string) bool,
+) *nsLoadTracker {
+	return &nsLoadTracker{
+		start:    now,
+		cloneNS:  cloneNS,
+		tier:     nsTier,
+		restored: restored,
+		sizes:    make(map[string]int64),
+		total:    make(map[string]int64),
+		tiers:    make(map[string]int),
+		first:    make(map[string]nsSample),
+		last:     make(map[string]snapshot.NSBytes),
+	}
+}
+
+// newNSLoadTracker returns the tracker of the data load of the restore
+func (r *Restore) newNSLoadTracker(cloneNS snapshot.CloneNS, nsTier archive.NSTierFn) *nsLoadTracker {
+	viaMongos := r.mongosURI != ""
+	return newNSLoadTracker(time.Now(), cloneNS, nsTier, func(ns string) bool {
+		if slices.Contains(snapshot.ExcludeFromRestore, ns) ||
+			ns == "admin.system.users" || ns == "admin.system.roles" {
+			return false
+		}
+		if viaMongos {
+			db, _, _ := strings.Cut(ns, ".")
+			return db != "config" && db != "local"
+		}
+		return true
+	})
+}
+
+// loadSizes reads the sizes of the namespaces of the archive metadata
+func (t *nsLoadTracker) loadSizes(rdr io.Reader) error {
+	meta, err := archive.ReadMetadata(rdr)
+	if err != nil {
+		return err
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for _, ns := range meta.Namespaces {
+		t.sizes[archive.NSify(ns.Database, ns.Collection)] = ns.Size
+	}
+	return nil
+}
+
+// match returns the selected predicate of the archive that records
+// the namespaces the archive is composed of
+func (t *nsLoadTracker) match(selected archive.NSFilterFn) archive.NSFilterFn {
+	return func(ns string) bool {
+		if !selected(ns) {
+			return false
+		}
+		if t.restored(ns) {
+			t.add(ns)
+		}
+		return true
+	}
+}
+
+func (t *nsLoadTracker) add(ns string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	to := ns
+	if t.cloneNS.IsSpecified() && ns == t.cloneNS.FromNS {
+		to = t.cloneNS.ToNS
+	}
+	if _, ok := t.total[to]; ok {
+		return
+	}
+
+	t.total[to] = t.sizes[ns]
+	t.order = append(t.order, to)
+	if t.tier != nil {
+		t.tiers[to] = t.tier(ns)
+		t.sorted = false
+	}
+}
+
+// observe records the data of the namespaces inserted by mongorestore
+func (t *nsLoadTracker) observe(now time.Time, nss map[string]snapshot.NSBytes) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for ns, p := range nss {
+		if _, ok := t.total[ns]; !ok {
+			continue
+		}
+		if _, ok := t.first[ns]; !ok {
+			t.first[ns] = nsSample{t: now, bytes: p.Bytes}
+		}
+		t.last[ns] = p
+	}
+}
+
+// get returns the progress at now. The ETA of the namespace in flight
+// is its remaining data at its own rate since it was observed
+// first. Pending namespaces start as the earlier ones complete, so their
+// ETA is the remaining data up to and including them at the rate of
+// the whole restore.
+func (t *nsLoadTracker) get(now time.Time) *NSLoadProgress {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.sorted {
+		// the archive is composed tier by tier (see archive.ComposeTiers)
+		slices.SortStableFunc(t.order, func(a, b string) int { return t.tiers[a] - t.tiers[b] })
+		t.sorted = true
+	}
+
+	rv := &NSLoadProgress{Total: len(t.order), UpdatedAt: now.Unix()}
+
+	var running, pending []NSLoadState
+	for _, ns := range t.order {
+		total := t.total[ns]
+		rv.BytesTotal += total
+
+		p, seen := t.last[ns]
+		if p.Done {
+			rv.Done++
+			rv.Bytes += total
+			continue
+		}
+
+		s := NSLoadState{NS: ns, Running: seen, Bytes: min(p.Bytes, total), BytesTotal: total}
+		rv.Bytes += s.Bytes
+		if seen {
+			running = append(running, s)
+		} else {
+			pending = append(pending, s)
+		}
+	}
+
+	var rate float64
+	if d := now.Sub(t.start).Seconds(); d > 0 {
+		rate = float64(rv.Bytes) / d
+	}
+	if rate > 0 {
+		rv.ETASec = int64(float64(rv.BytesTotal-rv.Bytes) / rate)
+	}
+
+	var left int64
+	for i := range running {
+		s := &running[i]
+		s.Percent = loadPercent(s.Bytes, s.BytesTotal)
+		left += s.BytesTotal - s.Bytes
+
+		f := t.first[s.NS]
+		if d := now.Sub(f.t).Seconds(); d > 0 && s.Bytes > f.bytes {
+			s.ETASec = int64(float64(s.BytesTotal-s.Bytes) / (float64(s.Bytes-f.bytes) / d))
+		}
+	}
+	for i := range pending {
+		left += pending[i].BytesTotal
+		if rate > 0 {
+			pending[i].ETASec = int64(float64(left) / rate)
+		}
+	}
+
+	small := int64(float64(rv.BytesTotal) * nsLoadMinShare)
+	for _, s := range append(running, pending...) {
+		if len(rv.Namespaces) < nsLoadTopN && s.BytesTotal > 0 && s.BytesTotal >= small {
+			rv.Namespaces = append(rv.Namespaces, s)
+			continue
+		}
+
+		if rv.Other == nil {
+			rv.Other = &NSLoadOther{}
+		}
+		rv.Other.Namespaces++
+		if s.Running {
+			rv.Other.Running++
+		}
+		rv.Other.Bytes += s.Bytes
+		rv.Other.BytesTotal += s.BytesTotal
+	}
+
+	return rv
+}
+
+// trackNSLoad saves the progress of the namespaces until stop is closed
+func (r *Restore) trackNSLoad(
+	ctx context.Context,
+	t *nsLoadTracker,
+	p *snapshot.NSProgress,
+	stop <-chan struct{},
+) {
+	tk := time.NewTicker(nsLoadHbFrame)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			r.saveNSLoad(ctx, t, p)
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Restore) saveNSLoad(ctx context.Context, t *nsLoadTracker, p *snapshot.NSProgress) {
+	now := time.Now()
+	t.observe(now, p.Get())
+
+	err := SetNSLoadProgress(ctx, r.leadConn, r.name, r.nodeInfo.SetName, t.get(now))
+	if err != nil {
+		r.log.Warning("save namespaces progress: %v", err)
+	}
+}
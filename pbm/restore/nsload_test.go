@@ -0,0 +1,179 @@
+package restore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+)
+
+func newTestNSLoadTracker(t *testing.T, start time.Time, sizes map[string]int64, order ...string) *nsLoadTracker {
+	t.Helper()
+
+	tr := newNSLoadTracker(start, snapshot.CloneNS{}, nil, func(string) bool { return true })
+	for ns, size := range sizes {
+		tr.sizes[ns] = size
+	}
+	match := tr.match(func(string) bool { return true })
+	for _, ns := range order {
+		match(ns)
+	}
+	return tr
+}
+
+func TestNSLoadProgress(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	at := func(sec int) time.Time { return t0.Add(time.Duration(sec) * time.Second) }
+
+	tr := newTestNSLoadTracker(t, t0,
+		map[string]int64{"db.a": 10000, "db.b": 5000, "db.c": 50, "db.d": 2000},
+		"db.a", "db.b", "db.c", "db.d")
+
+	p := tr.get(at(1))
+	if p.Total != 4 || p.BytesTotal != 17050 || p.ETASec != 0 || len(p.Namespaces) != 3 {
+		t.Fatalf("before the start: %+v", p)
+	}
+	for _, s := range p.Namespaces {
+		if s.Running || s.ETASec != 0 {
+			t.Errorf("before the start: %+v", s)
+		}
+	}
+
+	// one collection at a time, 400 bytes/s since 5s
+	tr.observe(at(5), map[string]snapshot.NSBytes{"db.a": {Bytes: 0}})
+	tr.observe(at(15), map[string]snapshot.NSBytes{
+		"db.a":          {Bytes: 4000},
+		"admin.tempusr": {Bytes: 100},
+	})
+	p = tr.get(at(15))
+	want := []NSLoadState{
+		{NS: "db.a", Running: true, Bytes: 4000, BytesTotal: 10000, Percent: 40, ETASec: 15},
+		// (6000 + 5000) / (4000 / 15)
+		{NS: "db.b", BytesTotal: 5000, ETASec: 41},
+		{NS: "db.d", BytesTotal: 2000, ETASec: 48},
+	}
+	if fmt.Sprint(p.Namespaces) != fmt.Sprint(want) {
+		t.Errorf("in flight:\nwant %+v\ngot  %+v", want, p.Namespaces)
+	}
+	if p.Bytes != 4000 || p.ETASec != 48 {
+		t.Errorf("total: %+v", p)
+	}
+	if o := p.Other; o == nil || o.Namespaces != 1 || o.Running != 0 || o.BytesTotal != 50 {
+		t.Errorf("other: %+v", p.Other)
+	}
+
+	tr.observe(at(30), map[string]snapshot.NSBytes{
+		"db.a": {Bytes: 10000, Done: true},
+		"db.b": {Bytes: 1000},
+		"db.c": {Bytes: 80, Done: true},
+	})
+	p = tr.get(at(30))
+	if p.Done != 2 || p.Bytes != 11050 {
+		t.Errorf("done: %+v", p)
+	}
+	if len(p.Namespaces) != 2 || p.Namespaces[0].NS != "db.b" || !p.Namespaces[0].Running ||
+		p.Namespaces[0].Percent != 20 || p.Namespaces[0].ETASec != 0 {
+		t.Errorf("in flight after the first: %+v", p.Namespaces)
+	}
+	if p.Other != nil {
+		t.Errorf("other: %+v", p.Other)
+	}
+
+	tr.observe(at(40), map[string]snapshot.NSBytes{"db.b": {Bytes: 3000}})
+	p = tr.get(at(40))
+	// 2000 bytes left at 200 bytes/s since 30s
+	if p.Namespaces[0].ETASec != 10 {
+		t.Errorf("second in flight: %+v", p.Namespaces[0])
+	}
+}
+
+func TestNSLoadProgressCoalesce(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+
+	sizes := make(map[string]int64)
+	var order []string
+	for i := range 15 {
+		ns := fmt.Sprintf("db.c%02d", i)
+		sizes[ns] = 1000
+		order = append(order, ns)
+	}
+	tr := newTestNSLoadTracker(t, t0, sizes, order...)
+
+	nss := make(map[string]snapshot.NSBytes)
+	for _, ns := range order[:12] {
+		nss[ns] = snapshot.NSBytes{Bytes: 500}
+	}
+	tr.observe(t0.Add(10*time.Second), nss)
+	p := tr.get(t0.Add(10 * time.Second))
+
+	if len(p.Namespaces) != nsLoadTopN {
+		t.Fatalf("listed: want %d, got %d", nsLoadTopN, len(p.Namespaces))
+	}
+	if p.Namespaces[0].NS != "db.c00" || p.Namespaces[nsLoadTopN-1].NS != "db.c09" {
+		t.Errorf("order: %+v", p.Namespaces)
+	}
+	want := NSLoadOther{Namespaces: 5, Running: 2, Bytes: 1000, BytesTotal: 5000}
+	if p.Other == nil || *p.Other != want {
+		t.Errorf("other: want %+v, got %+v", want, p.Other)
+	}
+}
+
+func TestNSLoadTrackerMatch(t *testing.T) {
+	r := &Restore{}
+	tr := r.newNSLoadTracker(snapshot.CloneNS{FromNS: "db.a", ToNS: "db.a2"},
+		func(ns string) int {
+			if ns == "db.b" {
+				return 0
+			}
+			return 1
+		})
+	err := tr.loadSizes(strings.NewReader(`{"namespaces": [` +
+		`{"db": "db", "collection": "a", "size": 100},` +
+		`{"db": "db", "collection": "b", "size": 200},` +
+		`{"db": "admin", "collection": "system.users", "size": 10},` +
+		`{"db": "admin", "collection": "pbmLog", "size": 10},` +
+		`{"db": "db", "collection": "c", "size": 300}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match := tr.match(func(ns string) bool { return ns != "db.c" })
+	for _, ns := range []string{"db.a", "db.b", "admin.system.users", "admin.pbmLog", "db.c"} {
+		if got, want := match(ns), ns != "db.c"; got != want {
+			t.Errorf("match %s: want %v, got %v", ns, want, got)
+		}
+	}
+
+	p := tr.get(time.Now())
+	if p.Total != 2 || p.BytesTotal != 300 {
+		t.Errorf("total: %+v", p)
+	}
+	if len(p.Namespaces) != 2 || p.Namespaces[0].NS != "db.b" || p.Namespaces[1].NS != "db.a2" {
+		t.Errorf("namespaces: %+v", p.Namespaces)
+	}
+}
+
+func TestNSLoadProgressString(t *testing.T) {
+	p := &NSLoadProgress{
+		Done:       3,
+		Total:      10,
+		Bytes:      3 << 30,
+		BytesTotal: 12 << 30,
+		ETASec:     3720,
+		Namespaces: []NSLoadState{
+			{NS: "shop.orders", Running: true, Bytes: 1 << 30, BytesTotal: 8 << 30, Percent: 12.5, ETASec: 2100},
+			{NS: "shop.items", BytesTotal: 2 << 30, ETASec: 3000},
+		},
+		Other: &NSLoadOther{Namespaces: 5, Running: 1, Bytes: 1 << 29, BytesTotal: 1 << 30},
+	}
+
+	want := "3/10 namespaces, 25.0% of 12.00GB, ETA 1h2m0s\n" +
+		"  - shop.orders: 12.5% of 8.00GB, ETA 35m0s\n" +
+		"  - shop.items: pending, 2.00GB, ETA 50m0s\n" +
+		"  - other 5 namespaces (1 running): 50.0% of 1.00GB"
+	if got := p.String(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,60 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// NSPriorityProgress is the namespace priority tier the logical restore
+// is in on the replset (see config.RestoreConf.NamespacePriority)
+type NSPriorityProgress struct {
+	// Tier is 1-based. The last tier is the namespaces that match
+	// no pattern.
+	Tier  int `bson:"tier" json:"tier"`
+	Tiers int `bson:"tiers" json:"tiers"`
+	// Pattern is empty for the last tier
+	Pattern    string `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	Namespaces int    `bson:"nss" json:"nss"`
+	UpdatedAt  int64  `bson:"updated_at" json:"updated_at"`
+}
+
+func (p *NSPriorityProgress) String() string {
+	pattern := p.Pattern
+	if pattern == "" {
+		pattern = "the rest"
+	}
+	return fmt.Sprintf("priority tier %d/%d (%s): %d namespaces", p.Tier, p.Tiers, pattern, p.Namespaces)
+}
+
+// nsTiers returns the namespace tiers of the restore and the callback
+// saving the tier in flight. Both are nil if no priority is configured.
+func (r *Restore) nsTiers(ctx context.Context) (archive.NSTierFn, func(int, []string)) {
+	if r.cfg == nil || r.cfg.Restore == nil || len(r.cfg.Restore.NamespacePriority) == 0 {
+		return nil, nil
+	}
+
+	patterns := r.cfg.Restore.NamespacePriority
+	onTier := func(tier int, nss []string) {
+		p := &NSPriorityProgress{
+			Tier:       tier + 1,
+			Tiers:      len(patterns) + 1,
+			Namespaces: len(nss),
+			UpdatedAt:  time.Now().Unix(),
+		}
+		if tier < len(patterns) {
+			p.Pattern = patterns[tier]
+		}
+		r.log.Info("restoring %s", p)
+
+		err := SetNSPriorityProgress(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+		if err != nil {
+			r.log.Warning("save namespace priority progress: %v", err)
+		}
+	}
+
+	return util.MakeNSTierFunc(patterns), onTier
+}
@@ -0,0 +1,344 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// ParallelDBsProgress is the data load of the databases of the replset
+// run by parallel mongorestore instances (see `restore.parallelDatabases`).
+type ParallelDBsProgress struct {
+	// Parallel is the number of the databases loaded at once
+	Parallel  int     `bson:"parallel" json:"parallel"`
+	Databases []DBRun `bson:"dbs" json:"dbs"`
+	UpdatedAt int64   `bson:"updated_at" json:"updated_at"`
+}
+
+// DBRun is the data load of the databases by one mongorestore instance.
+// The cluster databases (admin and config) are loaded together.
+type DBRun struct {
+	Databases []string    `bson:"dbs" json:"dbs"`
+	Status    defs.Status `bson:"status" json:"status"`
+	// Size is the size of the databases in the archive
+	Size    int64  `bson:"size" json:"size"`
+	StartTS int64  `bson:"start_ts,omitempty" json:"start_ts,omitempty"`
+	EndTS   int64  `bson:"end_ts,omitempty" json:"end_ts,omitempty"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+func (p *ParallelDBsProgress) String() string {
+	var b strings.Builder
+	done := 0
+	for i := range p.Databases {
+		if p.Databases[i].Status == defs.StatusDone {
+			done++
+		}
+	}
+	fmt.Fprintf(&b, "%d/%d done, up to %d at once", done, len(p.Databases), p.Parallel)
+	for _, r := range p.Databases {
+		fmt.Fprintf(&b, "\n  - %s [%s] %s", strings.Join(r.Databases, ", "), r.Status, storage.PrettySize(r.Size))
+		if r.StartTS != 0 {
+			end := "..."
+			if r.EndTS != 0 {
+				end = time.Unix(r.EndTS, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(&b, " %s - %s", time.Unix(r.StartTS, 0).UTC().Format(time.RFC3339), end)
+		}
+		if r.Error != "" {
+			b.WriteString(": " + r.Error)
+		}
+	}
+	return b.String()
+}
+
+// isClusterDB returns true for the databases loaded before the others
+func isClusterDB(db string) bool {
+	return db == "admin" || db == "config"
+}
+
+// planDBRuns groups the namespaces of the archive by database. The cluster
+// databases are returned as one run to be loaded before the others.
+// The rest are sorted by size, the largest first, so the large databases
+// don't keep the restore waiting at the end.
+func planDBRuns(nss []string, sizes map[string]int64) (*DBRun, []DBRun) {
+	var cluster *DBRun
+	byDB := make(map[string]*DBRun)
+	var runs []*DBRun
+
+	for _, ns := range nss {
+		db, _, _ := strings.Cut(ns, ".")
+		if isClusterDB(db) {
+			if cluster == nil {
+				cluster = &DBRun{Status: defs.StatusStarting}
+			}
+			if !slices.Contains(cluster.Databases, db) {
+				cluster.Databases = append(cluster.Databases, db)
+			}
+			cluster.Size += sizes[ns]
+			continue
+		}
+
+		r, ok := byDB[db]
+		if !ok {
+			r = &DBRun{Databases: []string{db}, Status: defs.StatusStarting}
+			byDB[db] = r
+			runs = append(runs, r)
+		}
+		r.Size += sizes[ns]
+	}
+	if cluster != nil {
+		slices.Sort(cluster.Databases)
+	}
+
+	rv := make([]DBRun, 0, len(runs))
+	for _, r := range runs {
+		rv = append(rv, *r)
+	}
+	slices.SortStableFunc(rv, func(a, b DBRun) int {
+		switch {
+		case a.Size > b.Size:
+			return -1
+		case a.Size < b.Size:
+			return 1
+		}
+		return strings.Compare(a.Databases[0], b.Databases[0])
+	})
+
+	return cluster, rv
+}
+
+// runParallel runs the jobs with up to n at once. Once a job fails,
+// the pending ones aren't started. The errors of the jobs are joined.
+func runParallel(n, jobs int, run func(i int) error, skip func(i int)) error {
+	n = max(min(n, jobs), 1)
+
+	var mx sync.Mutex
+	var errs []error
+	failed := false
+
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := 0; i < jobs; i++ {
+			mx.Lock()
+			stop := failed
+			mx.Unlock()
+			if stop {
+				skip(i)
+				continue
+			}
+			next <- i
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				err := run(i)
+				if err != nil {
+					mx.Lock()
+					failed = true
+					errs = append(errs, err)
+					mx.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// reserveParallelDBs reserves a collection batch for each database the
+// restore loads at once. It's the configured number unless the memory budget
+// of the agent can't fit them. Nil if the databases aren't loaded in parallel.
+// The caller has to Release the grant once the databases are restored.
+func (r *Restore) reserveParallelDBs() *memory.Grant {
+	n := r.cfg.Restore.ParallelDBs()
+	if n < 2 {
+		return nil
+	}
+
+	// the databases are restored one by one without the memory
+	g, _ := memory.Default.Reserve(snapshot.BatchMemory(r.cfg, r.numInsertionWorkersPerCol), 0, n)
+	if g.Units() < 2 {
+		g.Release()
+		r.log.Warning("memory budget is short: databases are restored one by one instead of %d in parallel", n)
+		return nil
+	}
+	if g.Units() < n {
+		r.log.Warning("memory budget is short: %d databases in parallel instead of %d", g.Units(), n)
+	}
+	return g
+}
+
+// restoreDBsParallel loads the dump of the replset database by database
+// with a mongorestore instance for each unit of the grant at once. The
+// grant holds the first collection batch of the instances. The cluster
+// databases are loaded first. The progress is saved to the restore metadata.
+func (r *Restore) restoreDBsParallel(
+	ctx context.Context,
+	download snapshot.DownloadFunc,
+	compression compress.CompressionType,
+	selected archive.NSFilterFn,
+	load *nsLoadTracker,
+	cloneNS snapshot.CloneNS,
+	dbs *memory.Grant,
+) error {
+	n := dbs.Units()
+	r.reservedBatches, r.parallelDBs = 1, n
+	defer func() { r.reservedBatches, r.parallelDBs = 0, 0 }()
+
+	// the metadata is read once: the indexes and sizes are loaded from
+	// it and each stream is composed of the cached copy
+	rdr, err := download(archive.MetaFile)
+	if err != nil {
+		return errors.Wrap(err, "download metadata")
+	}
+	metaData, err := io.ReadAll(rdr)
+	rdr.Close()
+	if err != nil {
+		return errors.Wrap(err, "read metadata")
+	}
+	if err := r.loadIndexesFrom(bytes.NewReader(metaData), cloneNS); err != nil {
+		return errors.Wrap(err, "load indexes")
+	}
+	if err := load.loadSizes(bytes.NewReader(metaData)); err != nil {
+		return errors.Wrap(err, "load sizes")
+	}
+	meta, err := archive.ReadMetadata(bytes.NewReader(metaData))
+	if err != nil {
+		return errors.Wrap(err, "parse metadata")
+	}
+
+	nss := []string{}
+	sizes := make(map[string]int64, len(meta.Namespaces))
+	for _, ns := range meta.Namespaces {
+		name := archive.NSify(ns.Database, ns.Collection)
+		sizes[name] = ns.Size
+		if selected(name) {
+			nss = append(nss, name)
+		}
+	}
+
+	cluster, runs := planDBRuns(nss, sizes)
+	p := &ParallelDBsProgress{Parallel: n}
+	if cluster != nil {
+		p.Databases = append(p.Databases, *cluster)
+	}
+	p.Databases = append(p.Databases, runs...)
+
+	if r.failures == nil {
+		r.failures = snapshot.NewFailures(r.cfg.Restore.FailureDetails())
+	}
+
+	var mx sync.Mutex
+	save := func(i int, s defs.Status, err error) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		d := &p.Databases[i]
+		d.Status = s
+		switch s {
+		case defs.StatusRunning:
+			d.StartTS = time.Now().Unix()
+		case defs.StatusDone, defs.StatusError:
+			d.EndTS = time.Now().Unix()
+		}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		p.UpdatedAt = time.Now().Unix()
+
+		err = SetRestoreRSParallelDBs(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+		if err != nil {
+			r.log.Warning("save databases progress: %v", err)
+		}
+	}
+
+	run := func(i int) error {
+		dbs := p.Databases[i].Databases
+		r.log.Info("restoring database(s) %s", strings.Join(dbs, ", "))
+		save(i, defs.StatusRunning, nil)
+
+		err := r.restoreDBs(ctx, download, metaData, compression, selected, load, dbs)
+		if err != nil {
+			err = errors.Wrapf(err, "restore %s", strings.Join(dbs, ", "))
+			save(i, defs.StatusError, err)
+			return err
+		}
+
+		r.log.Info("database(s) %s restored", strings.Join(dbs, ", "))
+		save(i, defs.StatusDone, nil)
+		return nil
+	}
+	skip := func(i int) { save(i, defs.StatusCancelled, nil) }
+
+	r.log.Info("restoring %d databases, up to %d in parallel", len(runs), n)
+
+	first := 0
+	if cluster != nil {
+		// admin and config are loaded alone and before the others
+		first = 1
+		if err := run(0); err != nil {
+			for i := 1; i < len(p.Databases); i++ {
+				skip(i)
+			}
+			return err
+		}
+	}
+
+	return runParallel(n, len(runs), func(i int) error { return run(first + i) }, func(i int) { skip(first + i) })
+}
+
+// restoreDBs loads the collections of the databases of the dump by one
+// mongorestore.
+func (r *Restore) restoreDBs(
+	ctx context.Context,
+	download snapshot.DownloadFunc,
+	metaData []byte,
+	compression compress.CompressionType,
+	selected archive.NSFilterFn,
+	load *nsLoadTracker,
+	dbs []string,
+) error {
+	rdr, err := snapshot.DownloadDumpTiers(
+		func(ns string) (io.ReadCloser, error) {
+			if ns == archive.MetaFile {
+				return io.NopCloser(bytes.NewReader(metaData)), nil
+			}
+			return download(ns)
+		},
+		compression,
+		r.log.Info,
+		load.match(func(ns string) bool {
+			db, _, _ := strings.Cut(ns, ".")
+			return slices.Contains(dbs, db) && selected(ns)
+		}),
+		r.numParallelColls,
+		nil,
+		nil)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	return errors.Wrap(r.snapshot(ctx, rdr, snapshot.CloneNS{}, false, false, load), "mongorestore")
+}
@@ -0,0 +1,146 @@
+package restore
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+)
+
+func TestPlanDBRuns(t *testing.T) {
+	nss := []string{"admin.system.users", "db0.a", "big.a", "config.settings", "big.b", "small.a", "db1.a"}
+	sizes := map[string]int64{
+		"admin.system.users": 1,
+		"config.settings":    2,
+		"big.a":              100,
+		"big.b":              50,
+		"db0.a":              10,
+		"db1.a":              10,
+		"small.a":            1,
+	}
+
+	cluster, runs := planDBRuns(nss, sizes)
+	if cluster == nil {
+		t.Fatal("no cluster run")
+	}
+	if !reflect.DeepEqual(cluster.Databases, []string{"admin", "config"}) || cluster.Size != 3 {
+		t.Errorf("cluster run: %v %d", cluster.Databases, cluster.Size)
+	}
+
+	var got []string
+	for _, r := range runs {
+		if len(r.Databases) != 1 {
+			t.Errorf("run of %v", r.Databases)
+		}
+		got = append(got, r.Databases[0])
+	}
+	if want := []string{"big", "db0", "db1", "small"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if runs[0].Size != 150 {
+		t.Errorf("size of big: %d", runs[0].Size)
+	}
+
+	cluster, runs = planDBRuns([]string{"db0.a"}, nil)
+	if cluster != nil || len(runs) != 1 {
+		t.Errorf("no cluster dbs: %v %v", cluster, runs)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	t.Run("concurrency", func(t *testing.T) {
+		var curr, top atomic.Int32
+		err := runParallel(3, 7, func(int) error {
+			n := curr.Add(1)
+			for {
+				m := top.Load()
+				if n <= m || top.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			curr.Add(-1)
+			return nil
+		}, func(i int) { t.Errorf("job %d is skipped", i) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if top.Load() != 3 {
+			t.Errorf("want 3 at once, got %d", top.Load())
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var mx sync.Mutex
+		var ran, skipped []int
+		failure := errors.New("failure")
+		err := runParallel(1, 4, func(i int) error {
+			mx.Lock()
+			ran = append(ran, i)
+			mx.Unlock()
+			if i == 1 {
+				return failure
+			}
+			return nil
+		}, func(i int) {
+			mx.Lock()
+			skipped = append(skipped, i)
+			mx.Unlock()
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("want failure, got %v", err)
+		}
+		if len(ran)+len(skipped) != 4 || len(skipped) == 0 {
+			t.Errorf("ran %v, skipped %v", ran, skipped)
+		}
+	})
+}
+
+func TestReserveParallelDBs(t *testing.T) {
+	defer func(b *memory.Budget) { memory.Default = b }(memory.Default)
+
+	cfg := &config.Config{Restore: &config.RestoreConf{ParallelDatabases: 4}}
+	r := &Restore{cfg: cfg, numInsertionWorkersPerCol: 1, log: log.DiscardEvent}
+	batch := snapshot.BatchMemory(cfg, 1)
+
+	testCases := []struct {
+		name  string
+		limit int64
+		units int
+	}{
+		{"unlimited", 0, 4},
+		{"short", 3 * batch, 3},
+		{"one", batch, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			memory.Default = &memory.Budget{}
+			memory.Default.SetLimit(tc.limit)
+
+			g := r.reserveParallelDBs()
+			if g.Units() != tc.units {
+				t.Fatalf("got %d units, want %d", g.Units(), tc.units)
+			}
+			// the grant is held until the databases are restored
+			if u := memory.Default.Usage(); u.UsedBytes != int64(tc.units)*batch {
+				t.Errorf("used %d, want %d", u.UsedBytes, int64(tc.units)*batch)
+			}
+			g.Release()
+			if u := memory.Default.Usage(); u.UsedBytes != 0 {
+				t.Errorf("memory isn't released: %+v", u)
+			}
+		})
+	}
+
+	r.cfg = &config.Config{Restore: &config.RestoreConf{}}
+	if g := r.reserveParallelDBs(); g != nil {
+		t.Errorf("not configured: got %d units", g.Units())
+	}
+}
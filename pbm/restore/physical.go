@@ -13,6 +13,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,6 +34,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/restore/phys"
@@ -62,12 +64,17 @@ type files struct {
 
 	// dbpath to cut from destination if there is any (see PBM-1058)
 	dbpath string
+	// srcDBpath is the dbpath of the backed up node
+	srcDBpath string
 }
 
 type PhysRestore struct {
 	leadConn connect.Client
 	node     *mongo.Client
 	dbpath   string
+	// singleRS is the only replset to restore. Its primary leads the restore
+	// instead of the config server. Empty for the whole cluster.
+	singleRS string
 	// an ephemeral port to restart mongod on during the restore
 	tmpPort int
 	tmpConf *os.File
@@ -86,7 +93,8 @@ type PhysRestore struct {
 	files     []files
 	restoreTS primitive.Timestamp
 
-	confOpts *config.RestoreConf
+	confOpts      *config.RestoreConf
+	pitrExcludeNS []string
 
 	mongod string // location of mongod used for internal restarts
 
@@ -94,6 +102,8 @@ type PhysRestore struct {
 	// state with the resto of the cluster
 	syncPathNode     string
 	syncPathNodeStat string
+	// syncPathSharding is the sharding check of the config server
+	syncPathSharding string
 	syncPathRS       string
 	syncPathCluster  string
 	syncPathPeers    map[string]struct{}
@@ -101,12 +111,21 @@ type PhysRestore struct {
 	syncPathShards map[string]struct{}
 	// Non-ConfigServer shards
 	syncPathDataShards map[string]struct{}
+	// node's restore progress
+	syncPathNodeProgress string
 
 	stopHB chan struct{}
 
+	progress physProgress
+
 	log log.LogEvent
 
 	rsMap map[string]string
+
+	// pbmConfig is the PBM config of the cluster (collection -> docs)
+	// kept over the restore of a backup made on another cluster.
+	// Otherwise, the cluster gets the other cluster's storage.
+	pbmConfig map[string][]bson.Raw
 }
 
 func NewPhysical(
@@ -422,7 +441,7 @@ func (r *PhysRestore) toState(status defs.Status) (_ defs.Status, err error) {
 					r.log.Error("toState: write replset error state `%v`: %v", err, serr)
 				}
 			}
-			if r.nodeInfo.IsClusterLeader() && status != defs.StatusDone {
+			if r.isClusterLeader() && status != defs.StatusDone {
 				serr := util.RetryableWrite(r.stg,
 					r.syncPathCluster+"."+string(defs.StatusError), errStatus(err))
 				if serr != nil {
@@ -452,7 +471,7 @@ func (r *PhysRestore) toState(status defs.Status) (_ defs.Status, err error) {
 		}
 	}
 
-	if r.nodeInfo.IsClusterLeader() || status == defs.StatusDone {
+	if r.isClusterLeader() || status == defs.StatusDone {
 		r.log.Info("waiting for shards %v", r.syncPathShards)
 		cstat, err := r.waitFiles(status, copyMap(r.syncPathShards), true)
 		if err != nil {
@@ -738,16 +757,31 @@ func (r *PhysRestore) Snapshot(
 ) (err error) {
 	l.Debug("port: %d", r.tmpPort)
 
+	r.singleRS = cmd.Replset
+
+	if p := cmd.DBpathMap[r.nodeInfo.Me]; p != "" && p != r.dbpath {
+		l.Info("dbpath %s is mapped to %s", r.dbpath, p)
+		r.dbpath = p
+	}
+
 	meta := &RestoreMeta{
-		Type:     defs.PhysicalBackup,
-		OPID:     opid.String(),
-		Name:     cmd.Name,
-		Backup:   cmd.BackupName,
-		StartTS:  time.Now().Unix(),
-		Status:   defs.StatusInit,
-		Replsets: []RestoreReplset{{Name: r.nodeInfo.Me}},
-	}
-	if r.nodeInfo.IsClusterLeader() {
+		SchemaVersion: RestoreMetaVersion,
+		Type:          defs.PhysicalBackup,
+		OPID:          opid.String(),
+		Name:          cmd.Name,
+		Backup:        cmd.BackupName,
+		StartTS:       time.Now().Unix(),
+		Status:        defs.StatusInit,
+		SingleRS:      cmd.Replset,
+		RSMap:         r.rsMap,
+		Replsets:      []RestoreReplset{{Name: r.nodeInfo.Me}},
+		SourceCluster: cmd.SourceCluster,
+		TargetCheck:   cmd.TargetCheck,
+		Initiator:     cmd.Initiator,
+		Options:       NewRestoreOptions(cmd),
+		PITRRestart:   NewPITRRestart(cmd),
+	}
+	if r.isClusterLeader() {
 		meta.Leader = r.nodeInfo.Me + "/" + r.rsConf.ID
 	}
 
@@ -777,6 +811,33 @@ func (r *PhysRestore) Snapshot(
 			return err
 		}
 		r.restoreTS = r.bcp.LastWriteTS
+
+		err = CheckSourceCluster(r.bcp, cmd.SourceCluster)
+		if err != nil {
+			return err
+		}
+		if r.nodeInfo.IsPrimary {
+			until := r.bcp.LastWriteTS
+			if !pitr.IsZero() {
+				until = pitr
+			}
+			err = CheckTarget(ctx, r.node, r.bcp, until, cmd.Namespaces, r.nodeInfo.Me, cmd.TargetCheck)
+			if err != nil {
+				return err
+			}
+		}
+		if r.bcp.IsForeign() && !pitr.IsZero() {
+			return errors.New("point-in-time restore from a backup of another cluster is not supported")
+		}
+		if len(r.bcp.ExcludedDBs) != 0 && !pitr.IsZero() {
+			return errors.New("point-in-time restore from a backup with excluded databases is not supported")
+		}
+		if r.bcp.IsForeign() && r.nodeInfo.IsLeader() {
+			r.pbmConfig, err = readPBMConfig(ctx, r.node)
+			if err != nil {
+				return errors.Wrap(err, "read pbm config")
+			}
+		}
 	}
 	if cmd.ExtTS.T > 0 {
 		r.restoreTS = cmd.ExtTS
@@ -789,12 +850,12 @@ func (r *PhysRestore) Snapshot(
 
 	var oplogRanges []oplogRange
 	if !pitr.IsZero() {
-		chunks, err := chunks(ctx, r.leadConn, r.stg, r.restoreTS, pitr, r.rsConf.ID, r.rsMap)
+		ranges, err := chunks(ctx, r.leadConn, r.stg, r.restoreTS, pitr, r.rsConf.ID, r.rsMap, r.nodeInfo.Me)
 		if err != nil {
 			return err
 		}
 
-		oplogRanges = append(oplogRanges, oplogRange{chunks: chunks, storage: r.stg})
+		oplogRanges = append(oplogRanges, ranges...)
 	}
 
 	if meta.Type == defs.IncrementalBackup {
@@ -804,6 +865,13 @@ func (r *PhysRestore) Snapshot(
 		}
 	}
 
+	if !cmd.External {
+		err = r.checkDBpath()
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = r.toState(defs.StatusStarting)
 	if err != nil {
 		return errors.Wrap(err, "move to running state")
@@ -910,13 +978,15 @@ func (r *PhysRestore) Snapshot(
 			l.Info("open replset metadata file <%s>: %v. Continue without.", rsMetaF, err)
 		}
 
+		r.setPhase("cleaning up datadir")
 		err = r.cleanupDatadir(needFiles)
 		if err != nil {
 			return errors.Wrap(err, "cleanup datadir")
 		}
 	} else {
 		l.Info("copying backup data")
-		stats.D, err = r.copyFiles()
+		r.setPhase("copying files")
+		stats.D, err = r.copyFiles(ctx)
 		if err != nil {
 			return errors.Wrap(err, "copy files")
 		}
@@ -939,12 +1009,14 @@ func (r *PhysRestore) Snapshot(
 	}
 
 	l.Info("preparing data")
+	r.setPhase("preparing data")
 	err = r.prepareData()
 	if err != nil {
 		return errors.Wrap(err, "prepare data")
 	}
 
 	l.Info("recovering oplog as standalone")
+	r.setPhase("recovering oplog")
 	err = r.recoverStandalone()
 	if err != nil {
 		return errors.Wrap(err, "recover oplog as standalone")
@@ -952,6 +1024,7 @@ func (r *PhysRestore) Snapshot(
 
 	if !pitr.IsZero() && r.nodeInfo.IsPrimary {
 		l.Info("replaying pitr oplog")
+		r.setPhase("replaying oplog")
 		err = r.replayOplog(r.bcp.LastWriteTS, pitr, oplogRanges, &stats)
 		if err != nil {
 			return errors.Wrap(err, "replay pitr oplog")
@@ -959,12 +1032,14 @@ func (r *PhysRestore) Snapshot(
 	}
 
 	l.Info("clean-up and reset replicaset config")
+	r.setPhase("resetting replset")
 	err = r.resetRS()
 	if err != nil {
 		return errors.Wrap(err, "clean-up, rs_reset")
 	}
 
 	l.Info("restore on node succeed")
+	r.setPhase("done")
 	// The node at this stage was restored successfully, so we shouldn't
 	// clean up dbPath nor write error status for the node whatever happens
 	// next.
@@ -1102,81 +1177,97 @@ func (r *PhysRestore) dumpMeta(meta *RestoreMeta, s defs.Status, msg string) err
 	return nil
 }
 
-func (r *PhysRestore) copyFiles() (*s3.DownloadStat, error) {
+func (r *PhysRestore) copyFiles(ctx context.Context) (*s3.DownloadStat, error) {
 	var stat *s3.DownloadStat
-	readFn := r.bcpStg.SourceReader
-	if t, ok := r.bcpStg.(*s3.S3); ok {
-		d := t.NewDownload(r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
-		readFn = d.SourceReader
+	readers := make([]sourceReaderFn, max(r.confOpts.NumParallelFiles, 1))
+	if t, ok := storage.Unwrap(r.bcpStg).(*s3.S3); ok {
+		// each file in flight has its own download buffers
+		bufMb := r.confOpts.MaxDownloadBufferMb
+		if bufMb > 0 {
+			bufMb = max(bufMb/len(readers), 1)
+		}
+		var downloads []*s3.Download
+		for i := range readers {
+			d := t.NewDownload(r.confOpts.NumDownloadWorkers, bufMb, r.confOpts.DownloadChunkMb)
+			downloads = append(downloads, d)
+			readers[i] = encrypt.WrapSourceReader(r.bcpStg, d.SourceReader)
+		}
 
 		defer func() {
-			s := d.Stat()
+			s := downloads[0].Stat()
+			for _, d := range downloads[1:] {
+				ds := d.Stat()
+				s.Arenas = append(s.Arenas, ds.Arenas...)
+				s.BufSize += ds.BufSize
+			}
 			stat = &s
 
 			r.log.Debug("download stat: %s", s)
 		}()
+	} else {
+		for i := range readers {
+			readers[i] = r.bcpStg.SourceReader
+		}
+	}
+
+	var filesTotal int
+	var bytesTotal int64
+	for _, set := range r.files {
+		if set.BcpName == bcpDir {
+			continue
+		}
+		for _, f := range set.Data {
+			filesTotal++
+			if f.Len > 0 {
+				bytesTotal += f.Len
+			} else {
+				bytesTotal += f.Size
+			}
+		}
 	}
+	r.progress.update(func(p *PhysNodeProgress) {
+		p.FilesTotal = filesTotal
+		p.BytesTotal = bytesTotal
+	})
+
+	cp := newFileCopier(readers, r.confOpts.MaxDownloadRateMb, &r.progress, r.log)
+	r.log.Debug("copy files: %d in parallel, rate limit %d MB/s", len(readers), r.confOpts.MaxDownloadRateMb)
 
 	setName := util.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
-	cpbuf := make([]byte, 32*1024)
+	// the sets go from the base backup to the target one, so each set
+	// is copied after the previous one is done
 	for i := len(r.files) - 1; i >= 0; i-- {
 		set := r.files[i]
+
+		var jobs []copyJob
+		byDst := make(map[string]int)
 		for _, f := range set.Data {
 			src := filepath.Join(set.BcpName, setName, f.Path(set.Cmpr))
-			// cut dbpath from destination if there is any (see PBM-1058)
-			fname := f.Name
-			if set.dbpath != "" {
-				fname = strings.TrimPrefix(fname, set.dbpath)
-			}
-			dst := filepath.Join(r.dbpath, fname)
+			fname, dst := dstPath(r.dbpath, set, f)
 
-			err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o700)
-			if err != nil {
-				return stat, errors.Wrapf(err, "create path %s", filepath.Dir(dst))
-			}
 			// if this is a directory, only ensure it is created.
 			if set.BcpName == bcpDir {
-				r.log.Info("create dir <%s>", filepath.Dir(f.Name))
-				continue
-			}
-
-			r.log.Info("copy <%s> to <%s>", src, dst)
-			sr, err := readFn(src)
-			if err != nil {
-				return stat, errors.Wrapf(err, "create source reader for <%s>", src)
-			}
-			defer sr.Close()
-
-			data, err := compress.Decompress(sr, set.Cmpr)
-			if err != nil {
-				return stat, errors.Wrapf(err, "decompress object %s", src)
-			}
-			defer data.Close()
-
-			fw, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, f.Fmode)
-			if err != nil {
-				return stat, errors.Wrapf(err, "create/open destination file <%s>", dst)
-			}
-			defer fw.Close()
-
-			if f.Off != 0 {
-				_, err := fw.Seek(f.Off, io.SeekStart)
+				err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o700)
 				if err != nil {
-					return stat, errors.Wrapf(err, "set file offset <%s>|%d", dst, f.Off)
+					return stat, errors.Wrapf(err, "create path %s", filepath.Dir(dst))
 				}
+				r.log.Info("create dir <%s>", filepath.Dir(f.Name))
+				continue
 			}
 
-			_, err = io.CopyBuffer(fw, data, cpbuf)
-			if err != nil {
-				return stat, errors.Wrapf(err, "copy file <%s>", dst)
+			// ranges of the same file are written by the same job
+			j, ok := byDst[dst]
+			if !ok {
+				j = len(jobs)
+				byDst[dst] = j
+				jobs = append(jobs, copyJob{dst: dst, fname: fname})
 			}
+			jobs[j].parts = append(jobs[j].parts, copyPart{src: src, cmpr: set.Cmpr, file: f})
+		}
 
-			if f.Size != 0 {
-				err = fw.Truncate(f.Size)
-				if err != nil {
-					return stat, errors.Wrapf(err, "truncate file <%s>|%d", dst, f.Size)
-				}
-			}
+		err := cp.copy(ctx, jobs)
+		if err != nil {
+			return stat, err
 		}
 	}
 	return stat, nil
@@ -1233,6 +1324,12 @@ func (r *PhysRestore) prepareData() error {
 
 	ctx := context.Background()
 
+	// before the oplog recovery, so it doesn't write the missing files
+	err = r.dropExcludedDBs(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "drop excluded databases")
+	}
+
 	_, err = c.Database("local").Collection("replset.minvalid").DeleteMany(ctx, bson.D{})
 	if err != nil {
 		return errors.Wrap(err, "drop replset.minvalid")
@@ -1386,9 +1483,11 @@ func (r *PhysRestore) replayOplog(
 	}
 
 	oplogOption := applyOplogOption{
-		start:  &from,
-		end:    &to,
-		unsafe: true,
+		start:     &from,
+		end:       &to,
+		unsafe:    true,
+		excludeNS: r.pitrExcludeNS,
+		prefetch:  r.confOpts.PrefetchSize(),
 	}
 	partial, err := applyOplog(ctx,
 		nodeConn,
@@ -1398,6 +1497,7 @@ func (r *PhysRestore) replayOplog(
 		nil,
 		r.setcommittedTxn,
 		r.getcommittedTxn,
+		r.setOplogProgress,
 		&stat.Txn,
 		&mgoV)
 	if err != nil {
@@ -1504,6 +1604,17 @@ func (r *PhysRestore) resetRS() error {
 		}
 	}
 
+	// the oplog recovery may have recreated them
+	err = r.dropExcludedDBs(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "drop excluded databases")
+	}
+
+	err = r.verifySharding(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "verify sharding")
+	}
+
 	colls, err := c.Database("config").ListCollectionNames(ctx, bson.D{{"name", bson.M{"$regex": `^cache\.`}}})
 	if err != nil {
 		return errors.Wrap(err, "list cache collections")
@@ -1552,6 +1663,13 @@ func (r *PhysRestore) resetRS() error {
 	// restore and chunks made after the backup. So it would successfully start slicing
 	// and overwrites chunks after the backup.
 	if r.nodeInfo.IsLeader() {
+		if r.pbmConfig != nil {
+			err = writePBMConfig(ctx, c, r.pbmConfig)
+			if err != nil {
+				return errors.Wrap(err, "restore pbm config of the cluster")
+			}
+		}
+
 		_, err = c.Database(defs.DB).Collection(defs.ConfigCollection).UpdateOne(ctx, bson.D{},
 			bson.D{{"$set", bson.M{"pitr.enabled": false}}},
 		)
@@ -1565,11 +1683,125 @@ func (r *PhysRestore) resetRS() error {
 	return r.shutdown(c)
 }
 
+// dropExcludedDBs drops the databases excluded from the backup. Their data
+// files aren't restored, only the catalog entries are left. The config
+// server removes the sharding metadata of the databases as well.
+func (r *PhysRestore) dropExcludedDBs(ctx context.Context, c *mongo.Client) error {
+	if r.bcp == nil || len(r.bcp.ExcludedDBs) == 0 {
+		return nil
+	}
+
+	for _, db := range r.bcp.ExcludedDBs {
+		r.log.Debug("dropping excluded database %q", db)
+		err := c.Database(db).Drop(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "drop %q", db)
+		}
+	}
+
+	if !r.nodeInfo.IsConfigSrv() {
+		return nil
+	}
+	return dropShardingMeta(ctx, c, r.bcp.ExcludedDBs)
+}
+
+// dropShardingMeta removes the databases and their collections
+// from the sharding metadata of the config server
+func dropShardingMeta(ctx context.Context, c *mongo.Client, dbs []string) error {
+	re := make([]string, len(dbs))
+	for i, db := range dbs {
+		re[i] = regexp.QuoteMeta(db)
+	}
+	nsRE := bson.M{"$regex": `^(` + strings.Join(re, "|") + `)\.`}
+
+	cfg := c.Database("config")
+	cur, err := cfg.Collection("collections").Find(ctx, bson.D{{"_id", nsRE}},
+		options.Find().SetProjection(bson.D{{"uuid", 1}}))
+	if err != nil {
+		return errors.Wrap(err, "find config.collections")
+	}
+	var colls []struct {
+		UUID *primitive.Binary `bson:"uuid"`
+	}
+	if err := cur.All(ctx, &colls); err != nil {
+		return errors.Wrap(err, "decode config.collections")
+	}
+	uuids := bson.A{}
+	for _, coll := range colls {
+		if coll.UUID != nil {
+			uuids = append(uuids, *coll.UUID)
+		}
+	}
+
+	// chunks refer to the collection by uuid since 5.0 and by ns before
+	_, err = cfg.Collection("chunks").DeleteMany(ctx, bson.D{{"$or", bson.A{
+		bson.D{{"ns", nsRE}},
+		bson.D{{"uuid", bson.M{"$in": uuids}}},
+	}}})
+	if err != nil {
+		return errors.Wrap(err, "delete from config.chunks")
+	}
+	_, err = cfg.Collection("tags").DeleteMany(ctx, bson.D{{"ns", nsRE}})
+	if err != nil {
+		return errors.Wrap(err, "delete from config.tags")
+	}
+	_, err = cfg.Collection("collections").DeleteMany(ctx, bson.D{{"_id", nsRE}})
+	if err != nil {
+		return errors.Wrap(err, "delete from config.collections")
+	}
+	_, err = cfg.Collection("databases").DeleteMany(ctx, bson.D{{"_id", bson.M{"$in": dbs}}})
+	if err != nil {
+		return errors.Wrap(err, "delete from config.databases")
+	}
+
+	return nil
+}
+
+var pbmConfigCollections = []string{
+	defs.ConfigCollection,
+	defs.ConfigHistoryCollection,
+}
+
+func readPBMConfig(ctx context.Context, m *mongo.Client) (map[string][]bson.Raw, error) {
+	rv := make(map[string][]bson.Raw)
+	for _, coll := range pbmConfigCollections {
+		cur, err := m.Database(defs.DB).Collection(coll).Find(ctx, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "find %s", coll)
+		}
+		var docs []bson.Raw
+		if err := cur.All(ctx, &docs); err != nil {
+			return nil, errors.Wrapf(err, "decode %s", coll)
+		}
+		rv[coll] = docs
+	}
+
+	return rv, nil
+}
+
+// writePBMConfig replaces the restored PBM config with conf
+func writePBMConfig(ctx context.Context, m *mongo.Client, conf map[string][]bson.Raw) error {
+	for coll, docs := range conf {
+		ms := []mongo.WriteModel{&mongo.DeleteManyModel{Filter: bson.D{}}}
+		for _, doc := range docs {
+			ms = append(ms, &mongo.InsertOneModel{Document: doc})
+		}
+
+		_, err := m.Database(defs.DB).Collection(coll).BulkWrite(ctx, ms)
+		if err != nil {
+			return errors.Wrapf(err, "update %s", coll)
+		}
+	}
+
+	return nil
+}
+
 func (r *PhysRestore) cleanUpPBMCollections(ctx context.Context, c *mongo.Client) {
 	pbmCollections := []string{
 		defs.LockCollection,
 		defs.LogCollection,
 		// defs.ConfigCollection,
+		// defs.ConfigHistoryCollection,
 		defs.LockCollection,
 		defs.LockOpCollection,
 		defs.BcpCollection,
@@ -1577,6 +1809,11 @@ func (r *PhysRestore) cleanUpPBMCollections(ctx context.Context, c *mongo.Client
 		defs.CmdStreamCollection,
 		defs.PITRChunksCollection,
 		defs.PITRCollection,
+		defs.PITRGapsCollection,
+		defs.PITRVerifyCollection,
+		defs.ScheduleRunsCollection,
+		defs.StorageDriftCollection,
+		defs.QueueCollection,
 		defs.PBMOpLogCollection,
 		defs.AgentsStatusCollection,
 	}
@@ -1649,7 +1886,7 @@ func (r *PhysRestore) agreeCommonRestoreTS() (primitive.Timestamp, error) {
 		return ts, errors.Wrap(err, "write RS timestamp")
 	}
 
-	if r.nodeInfo.IsClusterLeader() {
+	if r.isClusterLeader() {
 		_, err := r.waitFiles(defs.StatusExtTS, copyMap(r.syncPathShards), true)
 		if err != nil {
 			return ts, errors.Wrap(err, "wait for shards timestamp")
@@ -1841,6 +2078,7 @@ func (r *PhysRestore) init(ctx context.Context, name string, opid ctrl.OPID, l l
 	}
 
 	r.confOpts = cfg.Restore
+	r.pitrExcludeNS = cfg.PITR.ExcludeNamespaces
 
 	r.mongod = "mongod" // run from $PATH by default
 	if r.confOpts.MongodLocation != "" {
@@ -1859,6 +2097,9 @@ func (r *PhysRestore) init(ctx context.Context, name string, opid ctrl.OPID, l l
 
 	r.syncPathNode = fmt.Sprintf("%s/%s/rs.%s/node.%s", defs.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
 	r.syncPathNodeStat = fmt.Sprintf("%s/%s/rs.%s/stat.%s", defs.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathSharding = fmt.Sprintf("%s/%s/rs.%s/sharding.%s", defs.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathNodeProgress = fmt.Sprintf("%s/%s/rs.%s/progress.%s",
+		defs.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
 	r.syncPathRS = fmt.Sprintf("%s/%s/rs.%s/rs", defs.PhysRestoresDir, r.name, r.rsConf.ID)
 	r.syncPathCluster = fmt.Sprintf("%s/%s/cluster", defs.PhysRestoresDir, r.name)
 	r.syncPathPeers = make(map[string]struct{})
@@ -1876,6 +2117,9 @@ func (r *PhysRestore) init(ctx context.Context, name string, opid ctrl.OPID, l l
 	r.syncPathShards = make(map[string]struct{})
 	r.syncPathDataShards = make(map[string]struct{})
 	for _, s := range dsh {
+		if r.singleRS != "" && s.RS != r.singleRS {
+			continue
+		}
 		r.syncPathShards[fmt.Sprintf("%s/%s/rs.%s/rs", defs.PhysRestoresDir, r.name, s.RS)] = struct{}{}
 		if s.ID != "config" {
 			r.syncPathDataShards[fmt.Sprintf("%s/%s/rs.%s/rs", defs.PhysRestoresDir, r.name, s.RS)] = struct{}{}
@@ -1890,8 +2134,10 @@ func (r *PhysRestore) init(ctx context.Context, name string, opid ctrl.OPID, l l
 	r.stopHB = make(chan struct{})
 	go func() {
 		tk := time.NewTicker(time.Second * hbFrameSec)
+		ptk := time.NewTicker(physProgressFrame)
 		defer func() {
 			tk.Stop()
+			ptk.Stop()
 			l.Debug("hearbeats stopped")
 		}()
 
@@ -1902,6 +2148,11 @@ func (r *PhysRestore) init(ctx context.Context, name string, opid ctrl.OPID, l l
 				if err != nil {
 					l.Warning("send heartbeat: %v", err)
 				}
+			case <-ptk.C:
+				err := r.writeProgress()
+				if err != nil {
+					l.Warning("write progress: %v", err)
+				}
 			case <-r.stopHB:
 				return
 			}
@@ -1934,6 +2185,43 @@ func (r *PhysRestore) hb() error {
 	return nil
 }
 
+// setPhase sets the current restore step on the node and reports it.
+func (r *PhysRestore) setPhase(phase string) {
+	r.progress.update(func(p *PhysNodeProgress) {
+		p.Phase = phase
+		p.File = ""
+	})
+
+	err := r.writeProgress()
+	if err != nil {
+		r.log.Warning("write progress: %v", err)
+	}
+}
+
+// writeProgress writes the node progress to the storage if it has changed.
+func (r *PhysRestore) writeProgress() error {
+	p, changed := r.progress.get()
+	if !changed {
+		return nil
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	return util.RetryableWrite(r.stg, r.syncPathNodeProgress, b)
+}
+
+// setOplogProgress reports the PITR oplog replay as a part of the node progress.
+func (r *PhysRestore) setOplogProgress(_ context.Context, op *OplogProgress) error {
+	r.progress.update(func(p *PhysNodeProgress) {
+		p.Oplog = op
+	})
+
+	return r.writeProgress()
+}
+
 func (r *PhysRestore) checkHB(file string) error {
 	ts := time.Now().Unix()
 
@@ -1972,6 +2260,25 @@ func (r *PhysRestore) checkHB(file string) error {
 	return nil
 }
 
+// checkDBpath checks that the dbpath exists and its filesystems have space
+// for the files of the backup.
+func (r *PhysRestore) checkDBpath() error {
+	fi, err := os.Stat(r.dbpath)
+	if err != nil {
+		return errors.Wrap(err, "check dbpath")
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("dbpath %s is not a directory", r.dbpath)
+	}
+
+	err = checkDiskSpace(r.dbpath, fileSizes(r.dbpath, r.files))
+	if err != nil {
+		return errors.Wrap(err, "check disk space")
+	}
+
+	return nil
+}
+
 func (r *PhysRestore) setTmpConf(xopts *topo.MongodOpts) error {
 	opts := &topo.MongodOpts{}
 	opts.Storage = *topo.NewMongodOptsStorage()
@@ -2068,6 +2375,9 @@ func (r *PhysRestore) setBcpFiles(ctx context.Context) error {
 			Cmpr:    bcp.Compression,
 			Data:    []backup.File{},
 		}
+		if rs.MongodOpts != nil {
+			data.srcDBpath = rs.MongodOpts.Storage.DBpath
+		}
 		// PBM-1058
 		var is1058 bool
 		for _, f := range append(rs.Files, rs.Journal...) {
@@ -2194,7 +2504,11 @@ func (r *PhysRestore) prepareBackup(ctx context.Context, backupName string) erro
 		return errors.Wrap(err, "get backup metadata")
 	}
 
-	r.bcpStg, err = util.StorageFromConfig(&r.bcp.Store.StorageConf, r.nodeInfo.Me, log.LogEventFromContext(ctx))
+	rsStg := r.bcp.RSStorage(util.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName))
+	if err := checkBackupKey(&rsStg.StorageConf); err != nil {
+		return err
+	}
+	r.bcpStg, err = util.StorageFromConfig(&rsStg.StorageConf, r.nodeInfo.Me, log.LogEventFromContext(ctx))
 	if err != nil {
 		return errors.Wrap(err, "get backup storage")
 	}
@@ -2208,6 +2522,9 @@ func (r *PhysRestore) prepareBackup(ctx context.Context, backupName string) erro
 		return errors.Wrap(err, "set backup name")
 	}
 
+	if err := backup.CheckOrphaned(r.bcp); err != nil {
+		return err
+	}
 	if r.bcp.Status != defs.StatusDone {
 		return errors.Errorf("backup wasn't successful: status: %s, error: %s", r.bcp.Status, r.bcp.Error())
 	}
@@ -2216,6 +2533,9 @@ func (r *PhysRestore) prepareBackup(ctx context.Context, backupName string) erro
 		return errors.Errorf("backup version (v%s) is not compatible with PBM v%s",
 			r.bcp.PBMVersion, version.Current().Version)
 	}
+	if err := backup.CheckRestoreVersion(r.bcp, version.Current().Version); err != nil {
+		return err
+	}
 
 	mgoV, err := version.GetMongoVersion(ctx, r.node)
 	if err != nil || len(mgoV.Version) < 1 {
@@ -2251,6 +2571,9 @@ func (r *PhysRestore) prepareBackup(ctx context.Context, backupName string) erro
 
 	var nors []string
 	for _, sh := range r.bcp.Replsets {
+		if r.singleRS != "" && sh.Name != mapRevRS(r.singleRS) {
+			continue
+		}
 		if _, ok := fl[sh.Name]; !ok {
 			nors = append(nors, sh.Name)
 		}
@@ -2278,6 +2601,14 @@ func (r *PhysRestore) prepareBackup(ctx context.Context, backupName string) erro
 	return nil
 }
 
+// isClusterLeader returns true if the node leads the restore.
+func (r *PhysRestore) isClusterLeader() bool {
+	if r.singleRS != "" {
+		return r.nodeInfo.SetName == r.singleRS && r.nodeInfo.IsPrimary && r.nodeInfo.Me == r.nodeInfo.Primary
+	}
+	return r.nodeInfo.IsClusterLeader()
+}
+
 // ensure mongod for internal restarts is available and matches
 // the backup's version
 //
@@ -2339,7 +2670,7 @@ func (r *PhysRestore) MarkFailed(meta *RestoreMeta, e error, markCluster bool) {
 			r.log.Error("MarkFailed: write replset error state `%v`: %v", e, serr)
 		}
 	}
-	if r.nodeInfo.IsClusterLeader() && markCluster {
+	if r.isClusterLeader() && markCluster {
 		serr := util.RetryableWrite(r.stg,
 			r.syncPathCluster+"."+string(defs.StatusError), errStatus(e))
 		if serr != nil {
@@ -2348,30 +2679,6 @@ func (r *PhysRestore) MarkFailed(meta *RestoreMeta, e error, markCluster bool) {
 	}
 }
 
-func removeAll(dir string, l log.LogEvent) error {
-	d, err := os.Open(dir)
-	if err != nil {
-		return errors.Wrap(err, "open dir")
-	}
-	defer d.Close()
-
-	names, err := d.Readdirnames(-1)
-	if err != nil {
-		return errors.Wrap(err, "read file names")
-	}
-	for _, n := range names {
-		if n == internalMongodLog {
-			continue
-		}
-		err = os.RemoveAll(filepath.Join(dir, n))
-		if err != nil {
-			return errors.Wrapf(err, "remove '%s'", n)
-		}
-		l.Debug("remove %s", filepath.Join(dir, n))
-	}
-	return nil
-}
-
 func majmin(v string) string {
 	if len(v) == 0 {
 		return v
@@ -0,0 +1,289 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+// PITRLimit tells what limits the latest restorable time of the replset:
+// either a hole in chunks or the end of its chunks (Gap is nil).
+type PITRLimit struct {
+	RS  string
+	End primitive.Timestamp
+	Gap *oplog.Timeline
+}
+
+func (l PITRLimit) String() string {
+	if l.Gap != nil {
+		return fmt.Sprintf("%s: oplog gap %s - %s", l.RS, fmtUnix(l.Gap.Start), fmtUnix(l.Gap.End))
+	}
+	return fmt.Sprintf("%s: end of oplog chunks at %s", l.RS, fmtUnix(l.End.T))
+}
+
+// LatestPITRTime returns the most recent time up to which all replsets
+// have contiguous oplog chunks since the snapshot's last write and the
+// replset that limits it.
+func LatestPITRTime(
+	from primitive.Timestamp,
+	chunks map[string][]oplog.OplogChunk,
+) (primitive.Timestamp, PITRLimit, error) {
+	var latest primitive.Timestamp
+	var limit PITRLimit
+
+	rss := make([]string, 0, len(chunks))
+	for rs := range chunks {
+		rss = append(rss, rs)
+	}
+	sort.Strings(rss)
+
+	for _, rs := range rss {
+		l := PITRLimit{RS: rs, End: from}
+		for _, c := range chunks[rs] {
+			if !c.EndTS.After(l.End) {
+				continue
+			}
+			if c.StartTS.After(l.End) {
+				l.Gap = &oplog.Timeline{Start: l.End.T, End: c.StartTS.T}
+				break
+			}
+			l.End = c.EndTS
+		}
+		if !l.End.After(from) {
+			return latest, l, errors.Errorf("no oplog chunks for %s after the snapshot (%s)", rs, l)
+		}
+
+		if latest.IsZero() || l.End.Before(latest) {
+			latest, limit = l.End, l
+		}
+	}
+	if latest.IsZero() {
+		return latest, limit, errors.New("no replsets to restore")
+	}
+
+	return latest, limit, nil
+}
+
+// PITRWindow is the time range restorable from the base snapshot:
+// (Start, End], where Start is the snapshot's last write. End is zero
+// if there is no contiguous oplog after the snapshot.
+type PITRWindow struct {
+	Base  string
+	Start primitive.Timestamp
+	End   primitive.Timestamp
+	// Limit is the replset which coverage constrains End
+	Limit PITRLimit
+}
+
+func (w PITRWindow) covers(ts primitive.Timestamp) bool {
+	return !w.End.IsZero() && ts.After(w.Start) && !ts.After(w.End)
+}
+
+// PITRWindows returns restore windows of the base snapshots sorted by
+// the snapshot's last write. Each replset of the snapshot must have
+// contiguous chunks (per replset, not merged cluster-wide ones).
+func PITRWindows(bcps []backup.BackupMeta, chunks map[string][]oplog.OplogChunk) []PITRWindow {
+	rv := make([]PITRWindow, 0, len(bcps))
+	for i := range bcps {
+		bcp := &bcps[i]
+
+		rsChunks := make(map[string][]oplog.OplogChunk, len(bcp.Replsets))
+		for _, rs := range bcp.Replsets {
+			rsChunks[rs.Name] = chunks[rs.Name]
+		}
+
+		w := PITRWindow{Base: bcp.Name, Start: bcp.LastWriteTS}
+		end, limit, err := LatestPITRTime(bcp.LastWriteTS, rsChunks)
+		if err == nil {
+			w.End = end
+		}
+		w.Limit = limit
+		rv = append(rv, w)
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Start.Before(rv[j].Start) })
+	return rv
+}
+
+// PITRSuggestion is a valid restore time near the requested one.
+type PITRSuggestion struct {
+	Time  primitive.Timestamp `json:"-"`
+	TS    string              `json:"ts"`
+	Date  string              `json:"time"`
+	Base  string              `json:"base_snapshot"`
+	Limit string              `json:"limited_by"`
+}
+
+func newPITRSuggestion(ts primitive.Timestamp, w PITRWindow) *PITRSuggestion {
+	return &PITRSuggestion{
+		Time:  ts,
+		TS:    fmt.Sprintf("%d,%d", ts.T, ts.I),
+		Date:  fmtUnix(ts.T),
+		Base:  w.Base,
+		Limit: w.Limit.String(),
+	}
+}
+
+func (s *PITRSuggestion) String() string {
+	return fmt.Sprintf("%s <%s> (base snapshot: '%s', limited by %s)", s.Date, s.TS, s.Base, s.Limit)
+}
+
+// PITRCheck is the result of the check of target restore time.
+type PITRCheck struct {
+	// Covered is set if the time is restorable. Base is the snapshot
+	// the restore would use.
+	Covered *PITRSuggestion `json:"covered,omitempty"`
+	// Reason why the time isn't restorable
+	Reason string          `json:"reason,omitempty"`
+	Before *PITRSuggestion `json:"nearest_before,omitempty"`
+	After  *PITRSuggestion `json:"nearest_after,omitempty"`
+}
+
+// CheckPITRWindows checks if ts is restorable from the windows. When the
+// base is not set, the most recent snapshot before ts is used as the
+// restore does. Otherwise, the nearest valid times are suggested.
+func CheckPITRWindows(ts primitive.Timestamp, windows []PITRWindow, base string) PITRCheck {
+	var rv PITRCheck
+
+	var chosen *PITRWindow
+	for i := range windows {
+		w := &windows[i]
+		if ts.After(w.Start) && (base == "" || w.Base == base) {
+			chosen = w
+		}
+	}
+
+	switch {
+	case chosen == nil && base != "":
+		rv.Reason = fmt.Sprintf("base snapshot '%s' is later than the target time", base)
+	case chosen == nil:
+		rv.Reason = "no base snapshot before the target time"
+	case chosen.covers(ts):
+		rv.Covered = newPITRSuggestion(ts, *chosen)
+		return rv
+	case chosen.End.IsZero():
+		rv.Reason = fmt.Sprintf("no contiguous oplog after base snapshot '%s' (%s)", chosen.Base, chosen.Limit)
+	default:
+		rv.Reason = fmt.Sprintf("oplog after base snapshot '%s' ends before the target time (%s)",
+			chosen.Base, chosen.Limit)
+	}
+
+	for _, w := range windows {
+		if w.End.IsZero() || (base != "" && w.Base != base) {
+			continue
+		}
+
+		if w.covers(ts) {
+			// restorable with an older snapshot only
+			rv.Reason += fmt.Sprintf(". The time is restorable with --base-snapshot=%s", w.Base)
+			continue
+		}
+		if !w.End.After(ts) && (rv.Before == nil || w.End.After(rv.Before.Time)) {
+			rv.Before = newPITRSuggestion(w.End, w)
+		}
+		first := primitive.Timestamp{T: w.Start.T, I: w.Start.I + 1}
+		if first.After(ts) && (rv.After == nil || first.Before(rv.After.Time)) {
+			rv.After = newPITRSuggestion(first, w)
+		}
+	}
+
+	return rv
+}
+
+func (c PITRCheck) String() string {
+	if c.Covered != nil {
+		return "Restorable: " + c.Covered.String()
+	}
+
+	s := "Not restorable: " + c.Reason
+	if c.Before != nil {
+		s += "\nNearest valid time before: " + c.Before.String()
+	}
+	if c.After != nil {
+		s += "\nNearest valid time after: " + c.After.String()
+	}
+	return s
+}
+
+// GetPITRWindows returns restore windows of all snapshots suitable as a PITR
+// base (or the one given).
+func GetPITRWindows(ctx context.Context, conn connect.Client, base string) ([]PITRWindow, error) {
+	var bcps []backup.BackupMeta
+	if base != "" {
+		bcp, err := backup.NewDBManager(conn).GetBackupByName(ctx, base)
+		if err != nil {
+			if errors.Is(err, errors.ErrNotFound) {
+				return nil, errors.WithCode(errors.Errorf("backup '%s' not found", base), errors.CodeBackupNotFound)
+			}
+			return nil, errors.Wrap(err, "get backup data")
+		}
+		bcps = append(bcps, *bcp)
+	} else {
+		all, err := backup.BackupsDoneList(ctx, conn, nil, 0, 1)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backups")
+		}
+		for i := range all {
+			// the same as backup.GetLastBackup() looks for
+			b := &all[i]
+			if len(b.Namespaces) == 0 && b.Type != defs.ExternalBackup && !b.Store.IsProfile {
+				bcps = append(bcps, *b)
+			}
+		}
+	}
+
+	var from primitive.Timestamp
+	rss := make(map[string]struct{})
+	for i := range bcps {
+		if from.IsZero() || bcps[i].LastWriteTS.Before(from) {
+			from = bcps[i].LastWriteTS
+		}
+		for _, rs := range bcps[i].Replsets {
+			rss[rs.Name] = struct{}{}
+		}
+	}
+
+	chunks := make(map[string][]oplog.OplogChunk, len(rss))
+	for rs := range rss {
+		var err error
+		chunks[rs], err = oplog.PITRGetChunksSlice(ctx, conn, rs, from, primitive.Timestamp{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "get chunks for %s", rs)
+		}
+	}
+
+	return PITRWindows(bcps, chunks), nil
+}
+
+// CheckPITRTime returns error with the nearest valid restore times if
+// the point-in-time ts isn't restorable from the base snapshot (the most
+// recent one before ts if empty). It's checked by the client before
+// sending the restore and by the agents before starting it.
+func CheckPITRTime(ctx context.Context, conn connect.Client, ts primitive.Timestamp, base string) error {
+	windows, err := GetPITRWindows(ctx, conn, base)
+	if err != nil {
+		return err
+	}
+
+	c := CheckPITRWindows(ts, windows, base)
+	if c.Covered != nil {
+		return nil
+	}
+
+	return errors.Errorf("point-in-time %s <%d,%d> is not restorable: %s", fmtUnix(ts.T), ts.T, ts.I,
+		strings.TrimPrefix(c.String(), "Not restorable: "))
+}
+
+func fmtUnix(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
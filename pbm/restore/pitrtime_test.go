@@ -0,0 +1,145 @@
+package restore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+func TestLatestPITRTime(t *testing.T) {
+	chunk := func(start, end uint32) oplog.OplogChunk {
+		return oplog.OplogChunk{
+			StartTS: primitive.Timestamp{T: start, I: 1},
+			EndTS:   primitive.Timestamp{T: end, I: 1},
+		}
+	}
+	from := primitive.Timestamp{T: 15, I: 1}
+
+	tests := []struct {
+		name    string
+		chunks  map[string][]oplog.OplogChunk
+		want    uint32
+		limitRS string
+		gap     *oplog.Timeline
+		wantErr bool
+	}{
+		{
+			name: "shortest replset",
+			chunks: map[string][]oplog.OplogChunk{
+				"rs1": {chunk(10, 20), chunk(20, 30), chunk(30, 40)},
+				"rs2": {chunk(10, 20), chunk(20, 35)},
+			},
+			want:    35,
+			limitRS: "rs2",
+		},
+		{
+			name: "gap",
+			chunks: map[string][]oplog.OplogChunk{
+				"rs1": {chunk(10, 20), chunk(20, 30), chunk(30, 40)},
+				"rs2": {chunk(10, 20), chunk(20, 25), chunk(27, 40)},
+			},
+			want:    25,
+			limitRS: "rs2",
+			gap:     &oplog.Timeline{Start: 25, End: 27},
+		},
+		{
+			name: "overlapped chunks",
+			chunks: map[string][]oplog.OplogChunk{
+				"rs1": {chunk(10, 20), chunk(12, 18), chunk(18, 30)},
+			},
+			want:    30,
+			limitRS: "rs1",
+		},
+		{
+			name: "no coverage after snapshot",
+			chunks: map[string][]oplog.OplogChunk{
+				"rs1": {chunk(10, 20)},
+				"rs2": {chunk(17, 20)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "no chunks",
+			chunks: map[string][]oplog.OplogChunk{
+				"rs1": {chunk(10, 20)},
+				"rs2": {},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limit, err := LatestPITRTime(from, tt.chunks)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LatestPITRTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.T != tt.want {
+				t.Errorf("LatestPITRTime() got = %v, want %v", got, tt.want)
+			}
+			if limit.RS != tt.limitRS || !reflect.DeepEqual(limit.Gap, tt.gap) {
+				t.Errorf("LatestPITRTime() limit = %+v, want rs %s gap %v", limit, tt.limitRS, tt.gap)
+			}
+		})
+	}
+}
+
+func TestCheckPITRWindows(t *testing.T) {
+	chunk := func(start, end uint32) oplog.OplogChunk {
+		return oplog.OplogChunk{
+			StartTS: primitive.Timestamp{T: start, I: 1},
+			EndTS:   primitive.Timestamp{T: end, I: 1},
+		}
+	}
+	bcp := func(name string, lw uint32) backup.BackupMeta {
+		return backup.BackupMeta{
+			Name:        name,
+			LastWriteTS: primitive.Timestamp{T: lw, I: 1},
+			Replsets:    []backup.BackupReplset{{Name: "rs1"}, {Name: "rs2"}},
+		}
+	}
+	// rs2 has a gap 30-40: it's restorable (10, 30] from b1 and (45, 60] from b2
+	windows := PITRWindows(
+		[]backup.BackupMeta{bcp("b2", 45), bcp("b1", 10)},
+		map[string][]oplog.OplogChunk{
+			"rs1": {chunk(5, 60)},
+			"rs2": {chunk(5, 30), chunk(40, 60)},
+		})
+	if len(windows) != 2 || windows[0].Base != "b1" || windows[0].End.T != 30 || windows[0].Limit.RS != "rs2" {
+		t.Fatalf("unexpected windows: %+v", windows)
+	}
+
+	c := CheckPITRWindows(primitive.Timestamp{T: 20}, windows, "")
+	if c.Covered == nil || c.Covered.Base != "b1" {
+		t.Errorf("expected covered by b1, got %+v", c)
+	}
+
+	c = CheckPITRWindows(primitive.Timestamp{T: 35}, windows, "")
+	if c.Covered != nil || c.Before == nil || c.After == nil {
+		t.Fatalf("expected suggestions, got %+v", c)
+	}
+	if c.Before.Time.T != 30 || c.Before.Base != "b1" || !strings.Contains(c.Before.Limit, "rs2") {
+		t.Errorf("unexpected before: %+v", c.Before)
+	}
+	if c.After.Time != (primitive.Timestamp{T: 45, I: 2}) || c.After.Base != "b2" {
+		t.Errorf("unexpected after: %+v", c.After)
+	}
+
+	// the most recent snapshot is used by default but the older one covers
+	c = CheckPITRWindows(primitive.Timestamp{T: 47}, windows, "b1")
+	if c.Covered != nil || !strings.Contains(c.Reason, "'b1'") {
+		t.Errorf("unexpected result for b1 base: %+v", c)
+	}
+
+	c = CheckPITRWindows(primitive.Timestamp{T: 5}, windows, "")
+	if c.Covered != nil || c.Before != nil || c.After == nil || c.After.Base != "b1" {
+		t.Errorf("unexpected result before snapshots: %+v", c)
+	}
+}
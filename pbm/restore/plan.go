@@ -0,0 +1,189 @@
+package restore
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// configShardID is the shard name of the config server in the shard map
+const configShardID = "config"
+
+// ShardPlan is the placement of the backup shards on the shards of the
+// cluster with another number of shards. Only logical restore supports it.
+type ShardPlan struct {
+	// RSMap maps the backup replsets to the target ones one to one.
+	// It has the format of the restore command RSMap.
+	RSMap map[string]string
+	// Merge lists the backup replsets restored to the target replset
+	// in addition to the one mapped by RSMap.
+	Merge map[string][]string
+	// Placement lists all backup replsets restored to the target shard.
+	Placement map[string][]string
+	// Size is the estimated size of the data restored to the target shard.
+	Size map[string]int64
+}
+
+// ShardCountDiffers returns true if the backup of the sharded cluster has
+// another number of shards than the cluster.
+func ShardCountDiffers(bcp *backup.BackupMeta, shards []topo.Shard) bool {
+	if len(shards) < 2 || len(bcp.Replsets) < 2 {
+		return false
+	}
+
+	return len(bcp.Replsets) != len(shards)
+}
+
+// ReplsetDataSize returns the estimated size of the replset data in the
+// backup. It is the size of the dumped documents if the backup has
+// the namespaces stats, or the share of the backup size otherwise.
+func ReplsetDataSize(bcp *backup.BackupMeta, rs *backup.BackupReplset) int64 {
+	if rs.NSStats == nil {
+		return bcp.Size / int64(len(bcp.Replsets))
+	}
+
+	var size int64
+	for _, s := range rs.NSStats {
+		size += s.Size
+	}
+	return size
+}
+
+// PlanShards places the shards of the backup on the shards of the cluster.
+// The config server and the shards mapped by rsMap or having the same name
+// in the cluster keep their place. The rest go to the shards without data
+// first and then to the shards with the least data, the largest first.
+// If the cluster has more shards, some of them get no data, the balancer
+// moves the chunks to them afterward.
+func PlanShards(
+	bcp *backup.BackupMeta,
+	shards []topo.Shard,
+	rsMap map[string]string,
+) (*ShardPlan, error) {
+	var clusterCfg string
+	var targets []string
+	cluster := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		cluster[s.RS] = true
+		if s.ID == configShardID {
+			clusterCfg = s.RS
+			continue
+		}
+		targets = append(targets, s.RS)
+	}
+	sort.Strings(targets)
+
+	var bcpCfg string
+	var sources []*backup.BackupReplset
+	inBcp := make(map[string]bool, len(bcp.Replsets))
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		if rs.IsConfigShard != nil && *rs.IsConfigShard {
+			return nil, errors.New("backup with config shard can't be restored " +
+				"to a cluster with another number of shards")
+		}
+		inBcp[rs.Name] = true
+		if rs.IsConfigSvr != nil && *rs.IsConfigSvr {
+			bcpCfg = rs.Name
+			continue
+		}
+		sources = append(sources, rs)
+	}
+	if bcpCfg == "" || clusterCfg == "" {
+		return nil, errors.New("both the backup and the cluster have to be sharded")
+	}
+
+	for from, to := range rsMap {
+		if !inBcp[from] {
+			return nil, errors.Errorf("replset %q of the mapping is not in the backup", from)
+		}
+		if !cluster[to] {
+			return nil, errors.Errorf("replset %q of the mapping is not in the cluster", to)
+		}
+	}
+
+	mapRS := util.MakeRSMapFunc(rsMap)
+	if to := mapRS(bcpCfg); to != clusterCfg {
+		return nil, errors.Errorf("config server replset %q of the backup has to be restored "+
+			"to the config server replset %q, not to %q", bcpCfg, clusterCfg, to)
+	}
+
+	plan := &ShardPlan{
+		RSMap:     make(map[string]string),
+		Merge:     make(map[string][]string),
+		Placement: make(map[string][]string),
+		Size:      make(map[string]int64),
+	}
+	if bcpCfg != clusterCfg {
+		plan.RSMap[bcpCfg] = clusterCfg
+	}
+
+	placed := plan.Placement
+	place := func(rs *backup.BackupReplset, to string) {
+		placed[to] = append(placed[to], rs.Name)
+		plan.Size[to] += ReplsetDataSize(bcp, rs)
+	}
+
+	var rest []*backup.BackupReplset
+	for _, rs := range sources {
+		to := mapRS(rs.Name)
+		if to == clusterCfg {
+			return nil, errors.Errorf("shard %q of the backup can't be restored to the config server", rs.Name)
+		}
+		if !cluster[to] {
+			rest = append(rest, rs)
+			continue
+		}
+		place(rs, to)
+	}
+
+	sort.SliceStable(rest, func(i, j int) bool {
+		si, sj := ReplsetDataSize(bcp, rest[i]), ReplsetDataSize(bcp, rest[j])
+		if si != sj {
+			return si > sj
+		}
+		return rest[i].Name < rest[j].Name
+	})
+	for _, rs := range rest {
+		to := targets[0]
+		for _, t := range targets {
+			if len(placed[t]) == 0 {
+				to = t
+				break
+			}
+			if plan.Size[t] < plan.Size[to] {
+				to = t
+			}
+		}
+		place(rs, to)
+	}
+
+	for _, t := range targets {
+		srcs := placed[t]
+		if len(srcs) == 0 {
+			// the node of the replset would take the data of the backup
+			// replset with the same name
+			if inBcp[t] {
+				return nil, errors.Errorf("replset %q of the cluster gets no data while the backup "+
+					"replset %q is restored to another one. Use --replset-remapping to place it", t, t)
+			}
+			continue
+		}
+		// the replset with the same name is restored as usual
+		if i := slices.Index(srcs, t); i > 0 {
+			srcs[0], srcs[i] = srcs[i], srcs[0]
+		}
+		if srcs[0] != t {
+			plan.RSMap[srcs[0]] = t
+		}
+		if len(srcs) > 1 {
+			plan.Merge[t] = srcs[1:]
+		}
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,136 @@
+package restore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+func TestPlanShards(t *testing.T) {
+	yes := true
+	rs := func(name string, size int64) backup.BackupReplset {
+		return backup.BackupReplset{Name: name, NSStats: []backup.NSStat{{NS: "db.c", Size: size}}}
+	}
+	bcp := func(shards ...backup.BackupReplset) *backup.BackupMeta {
+		cfg := backup.BackupReplset{Name: "cfg", IsConfigSvr: &yes}
+		return &backup.BackupMeta{Name: "bcp", Replsets: append([]backup.BackupReplset{cfg}, shards...)}
+	}
+	cluster := func(cfg string, shards ...string) []topo.Shard {
+		rv := []topo.Shard{{ID: "config", RS: cfg}}
+		for _, s := range shards {
+			rv = append(rv, topo.Shard{ID: s, RS: s})
+		}
+		return rv
+	}
+
+	cases := []struct {
+		name    string
+		bcp     *backup.BackupMeta
+		shards  []topo.Shard
+		rsMap   map[string]string
+		want    *ShardPlan
+		wantErr string
+	}{
+		{
+			name:   "2 to 1",
+			bcp:    bcp(rs("rs1", 10), rs("rs2", 20)),
+			shards: cluster("cfg", "rs1"),
+			want: &ShardPlan{
+				RSMap:     map[string]string{},
+				Merge:     map[string][]string{"rs1": {"rs2"}},
+				Placement: map[string][]string{"rs1": {"rs1", "rs2"}},
+				Size:      map[string]int64{"rs1": 30},
+			},
+		},
+		{
+			name:   "3 to 2 renamed",
+			bcp:    bcp(rs("rs1", 10), rs("rs2", 30), rs("rs3", 20)),
+			shards: cluster("stgcfg", "a", "b"),
+			rsMap:  map[string]string{"cfg": "stgcfg"},
+			want: &ShardPlan{
+				RSMap:     map[string]string{"cfg": "stgcfg", "rs2": "a", "rs3": "b"},
+				Merge:     map[string][]string{"b": {"rs1"}},
+				Placement: map[string][]string{"a": {"rs2"}, "b": {"rs3", "rs1"}},
+				Size:      map[string]int64{"a": 30, "b": 30},
+			},
+		},
+		{
+			name:   "1 to 2",
+			bcp:    bcp(rs("rs1", 10)),
+			shards: cluster("stgcfg", "stg1", "stg2"),
+			rsMap:  map[string]string{"cfg": "stgcfg", "rs1": "stg2"},
+			want: &ShardPlan{
+				RSMap:     map[string]string{"cfg": "stgcfg", "rs1": "stg2"},
+				Merge:     map[string][]string{},
+				Placement: map[string][]string{"stg2": {"rs1"}},
+				Size:      map[string]int64{"stg2": 10},
+			},
+		},
+		{
+			name:   "mapped to the same shard",
+			bcp:    bcp(rs("rs1", 10), rs("rs2", 20), rs("rs3", 5)),
+			shards: cluster("cfg", "rs1", "rs3"),
+			rsMap:  map[string]string{"rs2": "rs3"},
+			want: &ShardPlan{
+				RSMap:     map[string]string{},
+				Merge:     map[string][]string{"rs3": {"rs2"}},
+				Placement: map[string][]string{"rs1": {"rs1"}, "rs3": {"rs3", "rs2"}},
+				Size:      map[string]int64{"rs1": 10, "rs3": 25},
+			},
+		},
+		{
+			name:    "config server to a shard",
+			bcp:     bcp(rs("rs1", 10), rs("rs2", 20)),
+			shards:  cluster("stgcfg", "rs1"),
+			wantErr: "config server replset",
+		},
+		{
+			name:    "unknown target",
+			bcp:     bcp(rs("rs1", 10), rs("rs2", 20)),
+			shards:  cluster("cfg", "rs1"),
+			rsMap:   map[string]string{"rs2": "rs3"},
+			wantErr: "not in the cluster",
+		},
+		{
+			name:    "same name shard without data",
+			bcp:     bcp(rs("rs1", 10)),
+			shards:  cluster("cfg", "rs0", "rs1"),
+			rsMap:   map[string]string{"rs1": "rs0"},
+			wantErr: "gets no data",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := PlanShards(c.bcp, c.shards, c.rsMap)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestShardCountDiffers(t *testing.T) {
+	b := &backup.BackupMeta{Replsets: []backup.BackupReplset{{Name: "cfg"}, {Name: "rs1"}, {Name: "rs2"}}}
+	if ShardCountDiffers(b, []topo.Shard{{ID: "config"}, {ID: "rs1"}, {ID: "rs2"}}) {
+		t.Error("same count")
+	}
+	if !ShardCountDiffers(b, []topo.Shard{{ID: "config"}, {ID: "rs1"}}) {
+		t.Error("fewer shards")
+	}
+	if ShardCountDiffers(b, []topo.Shard{{ID: "rs0"}}) {
+		t.Error("not sharded cluster")
+	}
+}
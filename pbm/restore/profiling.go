@@ -0,0 +1,350 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// throttleCheckPeriod is how often the flow control of the node is
+// checked during the restore
+const throttleCheckPeriod = time.Minute
+
+// ProfilingPause is the state of the database profiler of the node
+// writing the restore: the databases with the profiler turned off for the
+// time of the restore and their levels before. Saved before the levels
+// are changed, so `pbm cancel-restore` can set them back.
+type ProfilingPause struct {
+	Node      string      `bson:"node" json:"node"`
+	Databases []DBProfile `bson:"dbs" json:"dbs"`
+	// Restored is true once the levels are set back
+	Restored bool  `bson:"restored" json:"restored"`
+	Time     int64 `bson:"time" json:"time"`
+	// Errors are the databases the level couldn't be read or changed on
+	Errors []string `bson:"errors,omitempty" json:"errors,omitempty"`
+}
+
+// DBProfile is the profiling level of the database
+type DBProfile struct {
+	DB    string `bson:"db" json:"db"`
+	Level int    `bson:"level" json:"level"`
+}
+
+func (p *ProfilingPause) String() string {
+	dbs := make([]string, 0, len(p.Databases))
+	for _, d := range p.Databases {
+		dbs = append(dbs, fmt.Sprintf("%s (level %d)", d.DB, d.Level))
+	}
+
+	s := "turned off on " + p.Node + ": " + strings.Join(dbs, ", ")
+	if p.Restored {
+		s += "; restored"
+	}
+	if len(p.Errors) != 0 {
+		s += "; errors: " + strings.Join(p.Errors, "; ")
+	}
+	return s
+}
+
+// Throttling is the flow control of the node observed throttling the
+// writes of the restore.
+type Throttling struct {
+	// TargetRateLimit is the tickets per second the writes are limited to
+	TargetRateLimit int64 `bson:"target_rate_limit" json:"target_rate_limit"`
+	// TargetLagSec is the `flowControlTargetLagSeconds` parameter
+	TargetLagSec int64 `bson:"target_lag_sec,omitempty" json:"target_lag_sec,omitempty"`
+	// AcquiringMicros is the time the writes waited for tickets
+	// since the previous check
+	AcquiringMicros int64 `bson:"acquiring_micros" json:"acquiring_micros"`
+	// Observed is how many checks have found the writes throttled
+	Observed int   `bson:"observed" json:"observed"`
+	FirstAt  int64 `bson:"first_at" json:"first_at"`
+	LastAt   int64 `bson:"last_at" json:"last_at"`
+}
+
+func (t *Throttling) String() string {
+	s := fmt.Sprintf("flow control limited the writes to %d tickets/s, waited %s for tickets",
+		t.TargetRateLimit, time.Duration(t.AcquiringMicros)*time.Microsecond)
+	if t.TargetLagSec != 0 {
+		s += fmt.Sprintf(" (flowControlTargetLagSeconds: %d)", t.TargetLagSec)
+	}
+	if t.Observed > 1 {
+		s += fmt.Sprintf(", observed %d times", t.Observed)
+	}
+	return s
+}
+
+// flowControlStatus is the `flowControl` section of serverStatus
+type flowControlStatus struct {
+	Enabled             bool  `bson:"enabled"`
+	TargetRateLimit     int64 `bson:"targetRateLimit"`
+	TimeAcquiringMicros int64 `bson:"timeAcquiringMicros"`
+	IsLagged            bool  `bson:"isLagged"`
+	IsLaggedCount       int64 `bson:"isLaggedCount"`
+}
+
+// throttled returns true if the flow control has throttled the writes
+// since the prev status. The time the writes waited for tickets is
+// returned as well.
+func throttled(prev, curr *flowControlStatus) (bool, int64) {
+	if !curr.Enabled {
+		return false, 0
+	}
+
+	waited := curr.TimeAcquiringMicros
+	lagged := curr.IsLagged
+	if prev != nil {
+		waited -= prev.TimeAcquiringMicros
+		lagged = lagged || curr.IsLaggedCount > prev.IsLaggedCount
+	}
+	if waited < 0 {
+		// the node is restarted
+		waited = curr.TimeAcquiringMicros
+	}
+
+	return lagged && waited > 0, waited
+}
+
+// pauseProfiling turns off the profiler of the databases of the node
+// for the time of the restore. The levels are saved to the restore meta
+// first and set back by resumeProfiling on the restore exit.
+// It's the best effort, nothing fails the restore.
+func (r *Restore) pauseProfiling(ctx context.Context) {
+	if r.options.KeepProfiling {
+		return
+	}
+
+	dbs, err := r.nodeConn.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		r.log.Warning("profiling: list databases: %v", err)
+		return
+	}
+
+	p := &ProfilingPause{Node: r.nodeInfo.Me, Time: time.Now().Unix()}
+	for _, db := range dbs {
+		if db == "admin" || db == "local" || db == "config" {
+			continue
+		}
+
+		lvl, err := profilingLevel(ctx, r.nodeConn, db)
+		if err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("%s: %v", db, err))
+			continue
+		}
+		if lvl != 0 {
+			p.Databases = append(p.Databases, DBProfile{DB: db, Level: lvl})
+		}
+	}
+	if len(p.Databases) == 0 && len(p.Errors) == 0 {
+		return
+	}
+
+	err = SetRestoreRSProfiling(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+	if err != nil {
+		// the levels couldn't be set back if the agent is gone
+		r.log.Warning("profiling is left as is: save profiling levels: %v", err)
+		return
+	}
+
+	paused := p.Databases[:0]
+	for _, d := range p.Databases {
+		err := setProfilingLevel(ctx, r.nodeConn, d.DB, 0)
+		if err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("%s: %v", d.DB, err))
+			continue
+		}
+		r.log.Info("profiling of %s is turned off for the restore (level was %d)", d.DB, d.Level)
+		paused = append(paused, d)
+	}
+	p.Databases = paused
+	for _, e := range p.Errors {
+		r.log.Warning("profiling: %s", e)
+	}
+
+	err = SetRestoreRSProfiling(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+	if err != nil {
+		r.log.Warning("save profiling levels: %v", err)
+	}
+	r.profiling = p
+}
+
+// prepareNode turns off the profiling and starts the throttling
+// watch of the node for the data load. Both are stopped on the exit.
+func (r *Restore) prepareNode(ctx context.Context) {
+	r.pauseProfiling(ctx)
+
+	r.stopWatch = make(chan struct{})
+	go r.watchThrottling(ctx, r.stopWatch)
+}
+
+// resumeProfiling sets back the profiling levels turned off by pauseProfiling
+func (r *Restore) resumeProfiling(ctx context.Context) {
+	p := r.profiling
+	restored, errs := resumeProfiling(ctx, r.nodeConn, p.Databases)
+	for _, d := range restored {
+		r.log.Info("profiling level %d of %s is restored", d.Level, d.DB)
+	}
+	p.Errors = errs
+	for _, e := range p.Errors {
+		r.log.Warning("profiling: %s", e)
+	}
+	p.Restored = true
+	r.profiling = nil
+
+	err := SetRestoreRSProfiling(ctx, r.leadConn, r.name, r.nodeInfo.SetName, p)
+	if err != nil {
+		r.log.Warning("save profiling levels: %v", err)
+	}
+}
+
+func resumeProfiling(ctx context.Context, node *mongo.Client, dbs []DBProfile) ([]DBProfile, []string) {
+	var restored []DBProfile
+	var errs []string
+	for _, d := range dbs {
+		err := setProfilingLevel(ctx, node, d.DB, d.Level)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("restore level %d of %s: %v", d.Level, d.DB, err))
+			continue
+		}
+		restored = append(restored, d)
+	}
+	return restored, errs
+}
+
+func profilingLevel(ctx context.Context, node *mongo.Client, db string) (int, error) {
+	res := struct {
+		Was int `bson:"was"`
+	}{}
+	err := node.Database(db).RunCommand(ctx, bson.D{{"profile", -1}}).Decode(&res)
+	return res.Was, errors.Wrap(err, "get profiling level")
+}
+
+func setProfilingLevel(ctx context.Context, node *mongo.Client, db string, lvl int) error {
+	err := node.Database(db).RunCommand(ctx, bson.D{{"profile", lvl}}).Err()
+	return errors.Wrapf(err, "set profiling level %d", lvl)
+}
+
+// watchThrottling checks the flow control of the node until the stop
+// is closed. Once the writes are throttled, the observed values are
+// logged and saved to the restore meta.
+func (r *Restore) watchThrottling(ctx context.Context, stop <-chan struct{}) {
+	var prev *flowControlStatus
+	var t *Throttling
+
+	tk := time.NewTicker(throttleCheckPeriod)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+		}
+
+		curr, err := getFlowControl(ctx, r.nodeConn)
+		if err != nil {
+			r.log.Debug("check flow control: %v", err)
+			continue
+		}
+
+		ok, waited := throttled(prev, curr)
+		prev = curr
+		if !ok {
+			continue
+		}
+
+		now := time.Now().Unix()
+		if t == nil {
+			t = &Throttling{FirstAt: now, TargetLagSec: flowControlTargetLag(ctx, r.nodeConn)}
+		}
+		t.TargetRateLimit = curr.TargetRateLimit
+		t.AcquiringMicros = waited
+		t.Observed++
+		t.LastAt = now
+		if t.Observed == 1 {
+			r.log.Warning("the restore is throttled: %s", t)
+		} else {
+			r.log.Debug("the restore is throttled: %s", t)
+		}
+
+		err = SetRestoreRSThrottling(ctx, r.leadConn, r.name, r.nodeInfo.SetName, t)
+		if err != nil {
+			r.log.Warning("save throttling: %v", err)
+		}
+	}
+}
+
+func getFlowControl(ctx context.Context, node *mongo.Client) (*flowControlStatus, error) {
+	res := struct {
+		FlowControl *flowControlStatus `bson:"flowControl"`
+	}{}
+	err := node.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}}).Decode(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "serverStatus")
+	}
+	if res.FlowControl == nil {
+		return nil, errors.New("no flowControl section")
+	}
+	return res.FlowControl, nil
+}
+
+// flowControlTargetLag returns the `flowControlTargetLagSeconds` parameter.
+// 0 if it can't be read.
+func flowControlTargetLag(ctx context.Context, node *mongo.Client) int64 {
+	res := struct {
+		Lag int64 `bson:"flowControlTargetLagSeconds"`
+	}{}
+	err := node.Database("admin").RunCommand(ctx,
+		bson.D{{"getParameter", 1}, {"flowControlTargetLagSeconds", 1}}).Decode(&res)
+	if err != nil {
+		return 0
+	}
+	return res.Lag
+}
+
+// resumeAbortedProfiling sets back the profiling levels turned off on the
+// node by the aborted restore. It returns the databases of restored levels.
+func resumeAbortedProfiling(
+	ctx context.Context,
+	m connect.Client,
+	node *mongo.Client,
+	meta *RestoreMeta,
+	rs, me string,
+) ([]string, error) {
+	var p *ProfilingPause
+	for i := range meta.Replsets {
+		if meta.Replsets[i].Name == rs {
+			p = meta.Replsets[i].Profiling
+		}
+	}
+	if p == nil || p.Restored || p.Node != me {
+		return nil, nil
+	}
+
+	restored, errs := resumeProfiling(ctx, node, p.Databases)
+	dbs := make([]string, 0, len(restored))
+	for _, d := range restored {
+		dbs = append(dbs, d.DB)
+	}
+	slices.Sort(dbs)
+
+	p.Restored = true
+	p.Errors = errs
+	if err := SetRestoreRSProfiling(ctx, m, meta.Name, rs, p); err != nil {
+		errs = append(errs, "save profiling levels: "+err.Error())
+	}
+	if len(errs) != 0 {
+		return dbs, errors.New(strings.Join(errs, "; "))
+	}
+	return dbs, nil
+}
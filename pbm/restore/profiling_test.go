@@ -0,0 +1,52 @@
+package restore
+
+import (
+	"testing"
+)
+
+func TestThrottled(t *testing.T) {
+	cases := []struct {
+		name   string
+		prev   *flowControlStatus
+		curr   flowControlStatus
+		ok     bool
+		waited int64
+	}{
+		{"disabled", nil, flowControlStatus{IsLagged: true, TimeAcquiringMicros: 100}, false, 0},
+		{"first lagged", nil,
+			flowControlStatus{Enabled: true, IsLagged: true, TimeAcquiringMicros: 100}, true, 100},
+		{"not lagged",
+			&flowControlStatus{Enabled: true, TimeAcquiringMicros: 100, IsLaggedCount: 2},
+			flowControlStatus{Enabled: true, TimeAcquiringMicros: 300, IsLaggedCount: 2}, false, 200},
+		{"lagged since prev",
+			&flowControlStatus{Enabled: true, TimeAcquiringMicros: 100, IsLaggedCount: 2},
+			flowControlStatus{Enabled: true, TimeAcquiringMicros: 300, IsLaggedCount: 3}, true, 200},
+		{"lagged no waits",
+			&flowControlStatus{Enabled: true, IsLagged: true, TimeAcquiringMicros: 100},
+			flowControlStatus{Enabled: true, IsLagged: true, TimeAcquiringMicros: 100}, false, 0},
+		{"restarted",
+			&flowControlStatus{Enabled: true, TimeAcquiringMicros: 1000, IsLaggedCount: 5},
+			flowControlStatus{Enabled: true, IsLagged: true, TimeAcquiringMicros: 50}, true, 50},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, waited := throttled(c.prev, &c.curr)
+			if ok != c.ok || waited != c.waited {
+				t.Errorf("want %v, %d; got %v, %d", c.ok, c.waited, ok, waited)
+			}
+		})
+	}
+}
+
+func TestProfilingPauseString(t *testing.T) {
+	p := &ProfilingPause{
+		Node:      "rs1:27017",
+		Databases: []DBProfile{{DB: "app", Level: 2}, {DB: "reports", Level: 1}},
+		Restored:  true,
+	}
+	want := "turned off on rs1:27017: app (level 2), reports (level 1); restored"
+	if got := p.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,208 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+type setOplogProgressFn func(ctx context.Context, p *OplogProgress) error
+
+const (
+	oplogProgressHbFrame = 5 * time.Second
+	// oplogRateWindow is the period ops/sec is calculated for
+	oplogRateWindow = time.Minute
+)
+
+type opsSample struct {
+	t   time.Time
+	ops uint64
+}
+
+// oplogProgress tracks the oplog replay. The replay loop reports chunk
+// boundaries and run() samples applied ops and saves the progress.
+type oplogProgress struct {
+	mx sync.Mutex
+
+	or      *oplog.OplogRestore
+	start   time.Time
+	samples []opsSample
+
+	chunk      string
+	chunkNum   int
+	chunks     int
+	target     primitive.Timestamp
+	bytesDone  int64
+	bytesTotal int64
+}
+
+func newOplogProgress(or *oplog.OplogRestore, ranges []oplogRange, target primitive.Timestamp) *oplogProgress {
+	p := &oplogProgress{
+		or:     or,
+		start:  time.Now(),
+		target: target,
+	}
+	var last primitive.Timestamp
+	for _, r := range ranges {
+		for _, c := range r.chunks {
+			p.chunks++
+			p.bytesTotal += c.Size
+			last = c.EndTS
+		}
+	}
+	if p.target.IsZero() {
+		p.target = last
+	}
+	p.samples = []opsSample{{t: p.start}}
+
+	return p
+}
+
+func (p *oplogProgress) chunkStarted(c *oplog.OplogChunk) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.chunk = c.FName
+	p.chunkNum++
+}
+
+func (p *oplogProgress) chunkDone(c *oplog.OplogChunk) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.bytesDone += c.Size
+}
+
+func (p *oplogProgress) sample() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	now := time.Now()
+	p.samples = append(p.samples, opsSample{t: now, ops: p.or.ObservedOps()})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].t) >= oplogRateWindow {
+		p.samples = p.samples[1:]
+	}
+}
+
+func (p *oplogProgress) get() *OplogProgress {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	rv := &OplogProgress{
+		Chunk:     p.chunk,
+		ChunkNum:  p.chunkNum,
+		Chunks:    p.chunks,
+		LastTS:    primitive.Timestamp{T: p.or.LastOpTS()},
+		TargetTS:  p.target,
+		BytesLeft: p.bytesTotal - p.bytesDone,
+		UpdatedAt: time.Now().Unix(),
+	}
+
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	if d := last.t.Sub(first.t).Seconds(); d > 0 {
+		rv.OpsPerSec = float64(last.ops-first.ops) / d
+	}
+
+	if elapsed := time.Since(p.start).Seconds(); p.bytesDone > 0 && elapsed > 0 {
+		rv.ETASec = int64(float64(rv.BytesLeft) / (float64(p.bytesDone) / elapsed))
+	}
+
+	return rv
+}
+
+// run samples and saves the progress until stop is closed.
+func (p *oplogProgress) run(ctx context.Context, stop <-chan struct{}, save setOplogProgressFn) {
+	tk := time.NewTicker(oplogProgressHbFrame)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			p.sample()
+			p.save(ctx, save)
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *oplogProgress) save(ctx context.Context, save setOplogProgressFn) {
+	if save == nil {
+		return
+	}
+
+	err := save(ctx, p.get())
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("save oplog replay progress: %v", err)
+	}
+}
+
+func (p *OplogProgress) String() string {
+	s := fmt.Sprintf("chunk %d/%d, last op %s, target %s, %.0f ops/s",
+		p.ChunkNum, p.Chunks, fmtProgressTS(p.LastTS), fmtProgressTS(p.TargetTS), p.OpsPerSec)
+	if p.ETASec > 0 {
+		s += fmt.Sprintf(", ETA %s", time.Duration(p.ETASec)*time.Second)
+	}
+
+	return s
+}
+
+func fmtProgressTS(ts primitive.Timestamp) string {
+	return time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339)
+}
+
+// physProgressFrame is how often the node progress of the physical
+// restore is written to the storage
+const physProgressFrame = 10 * time.Second
+
+// physProgress tracks the physical restore on the node.
+type physProgress struct {
+	mx      sync.Mutex
+	p       PhysNodeProgress
+	changed bool
+}
+
+func (p *physProgress) update(fn func(p *PhysNodeProgress)) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	fn(&p.p)
+	p.p.UpdatedAt = time.Now().Unix()
+	p.changed = true
+}
+
+// get returns the progress and whether it has changed since the last call.
+func (p *physProgress) get() (PhysNodeProgress, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	changed := p.changed
+	p.changed = false
+	return p.p, changed
+}
+
+func (p *PhysNodeProgress) String() string {
+	s := p.Phase
+	if p.FilesTotal > 0 {
+		s += fmt.Sprintf(", files %d/%d", p.Files, p.FilesTotal)
+	}
+	if p.BytesTotal > 0 {
+		s += fmt.Sprintf(" (%.1f%%)", min(float64(p.Bytes)/float64(p.BytesTotal)*100, 100))
+	}
+	if p.BytesPerSec > 0 {
+		s += fmt.Sprintf(", %.1f MB/s", p.BytesPerSec/(1<<20))
+	}
+	if p.Oplog != nil {
+		s += ", oplog " + p.Oplog.String()
+	}
+
+	return s
+}
@@ -0,0 +1,107 @@
+package restore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestOplogProgress(t *testing.T) {
+	ranges := []oplogRange{
+		{chunks: []oplog.OplogChunk{
+			{FName: "c1", Size: 100, EndTS: primitive.Timestamp{T: 10}},
+		}},
+		{chunks: []oplog.OplogChunk{
+			{FName: "c2", Size: 200, EndTS: primitive.Timestamp{T: 20}},
+			{FName: "c3", Size: 100, EndTS: primitive.Timestamp{T: 30}},
+		}},
+	}
+
+	p := newOplogProgress(&oplog.OplogRestore{}, ranges, primitive.Timestamp{})
+	if p.target.T != 30 {
+		t.Errorf("target: got %v, want the end of the last chunk", p.target)
+	}
+
+	p.chunkStarted(&ranges[0].chunks[0])
+	got := p.get()
+	if got.ChunkNum != 1 || got.Chunks != 3 || got.Chunk != "c1" {
+		t.Errorf("chunk: got %d/%d %q", got.ChunkNum, got.Chunks, got.Chunk)
+	}
+	if got.BytesLeft != 400 || got.ETASec != 0 {
+		t.Errorf("before first chunk done: bytes left %d, eta %d", got.BytesLeft, got.ETASec)
+	}
+
+	p.start = time.Now().Add(-10 * time.Second)
+	p.chunkDone(&ranges[0].chunks[0])
+	got = p.get()
+	if got.BytesLeft != 300 {
+		t.Errorf("bytes left: got %d, want 300", got.BytesLeft)
+	}
+	if got.ETASec < 29 || got.ETASec > 31 {
+		t.Errorf("eta: got %d, want ~30", got.ETASec)
+	}
+
+	p = newOplogProgress(&oplog.OplogRestore{}, ranges, primitive.Timestamp{T: 25})
+	if p.target.T != 25 {
+		t.Errorf("target: got %v, want the restore target", p.target)
+	}
+}
+
+func TestParsePhysRestoreProgress(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := defs.PhysRestoresDir + "/r1/rs.rs0/"
+	for name, data := range map[string]string{
+		"node.rs0.example.com:27017.running": "100",
+		"progress.rs0.example.com:27017": `{"phase":"copying files","files":2,"files_total":4,` +
+			`"bytes":50,"bytes_total":200,"updated_at":110}`,
+		"rs.running": "100",
+	} {
+		if err := stg.Save(dir+name, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta, err := ParsePhysRestoreStatus("r1", stg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Replsets) != 1 || len(meta.Replsets[0].Nodes) != 1 {
+		t.Fatalf("unexpected replsets: %+v", meta.Replsets)
+	}
+
+	n := meta.Replsets[0].Nodes[0]
+	if n.Name != "rs0.example.com:27017" || n.Status != defs.StatusRunning {
+		t.Errorf("unexpected node: %+v", n)
+	}
+	if n.Progress == nil {
+		t.Fatal("no progress")
+	}
+	if want := "copying files, files 2/4 (25.0%)"; n.Progress.String() != want {
+		t.Errorf("got %q, want %q", n.Progress.String(), want)
+	}
+}
+
+func TestPhysProgressChanged(t *testing.T) {
+	p := &physProgress{}
+	if _, changed := p.get(); changed {
+		t.Error("changed before any update")
+	}
+
+	p.update(func(p *PhysNodeProgress) { p.Files++ })
+	if got, changed := p.get(); !changed || got.Files != 1 {
+		t.Errorf("got %+v, changed %v", got, changed)
+	}
+	if _, changed := p.get(); changed {
+		t.Error("changed flag is not reset")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/restore/phys"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/topo"
 )
 
@@ -29,7 +30,7 @@ func getRestoreMeta(ctx context.Context, m connect.Client, clause bson.D) (*Rest
 	res := m.RestoresCollection().FindOne(ctx, clause)
 	if err := res.Err(); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.ErrNotFound
+			return nil, errors.WithCode(errors.ErrNotFound, errors.CodeRestoreNotFound)
 		}
 		return nil, errors.Wrap(err, "get")
 	}
@@ -62,6 +63,28 @@ func changeRestoreState(ctx context.Context, m connect.Client, clause bson.D, s
 	return err
 }
 
+// SetRestoreErrorCode sets the code of the restore failure
+func SetRestoreErrorCode(ctx context.Context, m connect.Client, name string, code errors.Code) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"error_code": code}}},
+	)
+
+	return err
+}
+
+// SetRestoreRSErrorCode sets the code of the replset restore failure
+func SetRestoreRSErrorCode(ctx context.Context, m connect.Client, name, rsName string, code errors.Code) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.error_code": code}}},
+	)
+
+	return err
+}
+
 func ChangeRestoreRSState(
 	ctx context.Context,
 	m connect.Client,
@@ -115,6 +138,24 @@ func RestoreSetRSStat(
 	return err
 }
 
+// RestoreSetRSData sets the bytes read from the storage and the count
+// check of the data restore on the replset.
+func RestoreSetRSData(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	bytes int64,
+	check *CountCheck,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.bytes": bytes, "replsets.$.count_check": check}}},
+	)
+
+	return err
+}
+
 func RestoreSetStat(ctx context.Context, m connect.Client, name string, stat phys.RestoreStat) error {
 	_, err := m.RestoresCollection().UpdateOne(
 		ctx,
@@ -145,6 +186,212 @@ func SetCurrentOp(ctx context.Context, m connect.Client, name, rsName string, ts
 	return err
 }
 
+// SetOplogProgress sets the oplog replay progress of the replset.
+func SetOplogProgress(ctx context.Context, m connect.Client, name, rsName string, p *OplogProgress) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.oplog_progress": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+// SetIndexBuildProgress sets the index builds progress of the replset.
+func SetIndexBuildProgress(ctx context.Context, m connect.Client, name, rsName string, p *IndexBuildProgress) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.index_builds": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+// SetNSLoadProgress sets the progress of the namespaces data load of the replset.
+func SetNSLoadProgress(ctx context.Context, m connect.Client, name, rsName string, p *NSLoadProgress) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.ns_load": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+// SetNSPriorityProgress sets the namespace priority tier in flight on the replset.
+func SetNSPriorityProgress(ctx context.Context, m connect.Client, name, rsName string, p *NSPriorityProgress) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.ns_priority": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+// SetIndexBuildsPaused pauses or resumes the start of new index builds
+// of the restore.
+func SetIndexBuildsPaused(ctx context.Context, m connect.Client, name string, paused bool) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"index_builds_paused": paused}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSNamespaces(ctx context.Context, m connect.Client, name, rsName string, nss []string) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.nss": nss}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSTransfers(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	stats []storage.TransferStats,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.transfers": stats}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSCollOptions(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	res *CollOptionsResult,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.coll_options": res}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSParallelDBs(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	p *ParallelDBsProgress,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.parallel_dbs": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSProfiling(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	p *ProfilingPause,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.profiling": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSThrottling(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	t *Throttling,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.throttling": t}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreRSFailures(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	failures *RestoreFailures,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.failures": failures}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetBalancerStopped(ctx context.Context, m connect.Client, name string, stopped bool) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"balancer_stopped": stopped}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func setRestoreAbort(ctx context.Context, m connect.Client, name string, a *AbortInfo) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"abort": a}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func addAbortCleanup(ctx context.Context, m connect.Client, name string, c *AbortCleanup) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$push", bson.M{"abort.cleanup": c}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRebalanceProgress(ctx context.Context, m connect.Client, name string, p *RebalanceProgress) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"rebalance": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+// SetPITRRestart updates the state of enabling PITR back after the restore.
+func SetPITRRestart(ctx context.Context, m connect.Client, name string, p *PITRRestart) error {
+	_, err := m.RestoresCollection().UpdateOne(ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"pitr_restart": p}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
 func SetRestoreMeta(ctx context.Context, m connect.Client, meta *RestoreMeta) error {
 	meta.LastTransitionTS = meta.StartTS
 	meta.Conditions = append(meta.Conditions, &Condition{
@@ -260,3 +507,30 @@ func RestoreList(ctx context.Context, m connect.Client, limit int64) ([]RestoreM
 	err = cur.All(ctx, &restores)
 	return restores, err
 }
+
+// SetRestoreRSPrincipals saves the users and roles changed on the replset
+// by the users and roles only restore.
+func SetRestoreRSPrincipals(
+	ctx context.Context,
+	m connect.Client,
+	name, rsName string,
+	change *PrincipalsChange,
+) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.principals": change}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
+
+func SetRestoreSharding(ctx context.Context, m connect.Client, name string, s *ShardingResult) error {
+	_, err := m.RestoresCollection().UpdateOne(
+		ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"sharding": s}}},
+	)
+
+	return errors.Wrap(err, "update")
+}
@@ -0,0 +1,445 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// rebalanceMaxSteps limits the number of chunk moves and splits per collection
+const rebalanceMaxSteps = 1000
+
+type shardChunk struct {
+	Min   bson.Raw `bson:"min"`
+	Max   bson.Raw `bson:"max"`
+	Shard string   `bson:"shard"`
+	Jumbo bool     `bson:"jumbo"`
+	// Size is the estimated size of the chunk data
+	Size int64 `bson:"-"`
+}
+
+func (c *shardChunk) key() string {
+	return string(c.Min) + string(c.Max)
+}
+
+type rebalanceStep struct {
+	// Split is true if the chunk has to be split. Otherwise it has to be
+	// moved from the From shard to the To one.
+	Split bool
+	Chunk *shardChunk
+	From  string
+	To    string
+}
+
+// dataSkew returns how much the largest shard exceeds the average in percent
+func dataSkew(chunks []*shardChunk, shards []string) float64 {
+	if len(shards) == 0 {
+		return 0
+	}
+
+	size := shardSizes(chunks)
+	var total, top int64
+	for _, s := range shards {
+		total += size[s]
+		top = max(top, size[s])
+	}
+	if total == 0 {
+		return 0
+	}
+
+	avg := float64(total) / float64(len(shards))
+	return (float64(top) - avg) / avg * 100
+}
+
+func shardSizes(chunks []*shardChunk) map[string]int64 {
+	rv := make(map[string]int64)
+	for _, c := range chunks {
+		rv[c.Shard] += c.Size
+	}
+	return rv
+}
+
+// nextRebalanceStep returns the step which reduces the data skew of the
+// collection, or false if the skew is within maxSkew or can't be reduced.
+// It moves the largest chunk of the largest shard which doesn't
+// overturn the balance to the smallest shard. If there is no such chunk,
+// the largest chunk of the largest shard is split.
+func nextRebalanceStep(chunks []*shardChunk, shards []string, maxSkew float64) (rebalanceStep, bool) {
+	if len(shards) < 2 || dataSkew(chunks, shards) <= maxSkew {
+		return rebalanceStep{}, false
+	}
+
+	sorted := slices.Clone(shards)
+	slices.Sort(sorted)
+	size := shardSizes(chunks)
+	top, bottom := sorted[0], sorted[0]
+	for _, s := range sorted {
+		if size[s] > size[top] {
+			top = s
+		}
+		if size[s] < size[bottom] {
+			bottom = s
+		}
+	}
+
+	var cand []*shardChunk
+	for _, c := range chunks {
+		if c.Shard == top && !c.Jumbo && c.Size > 0 {
+			cand = append(cand, c)
+		}
+	}
+	if len(cand) == 0 {
+		return rebalanceStep{}, false
+	}
+	sort.SliceStable(cand, func(i, j int) bool { return cand[i].Size > cand[j].Size })
+
+	gap := (size[top] - size[bottom]) / 2
+	for _, c := range cand {
+		if c.Size <= gap {
+			return rebalanceStep{Chunk: c, From: top, To: bottom}, true
+		}
+	}
+
+	return rebalanceStep{Split: true, Chunk: cand[0], From: top}, true
+}
+
+func (p *RebalanceProgress) String() string {
+	done, balanced := 0, 0
+	for _, c := range p.Collections {
+		if !c.Done {
+			continue
+		}
+		done++
+		if c.Skew <= p.MaxSkew {
+			balanced++
+		}
+	}
+
+	s := fmt.Sprintf("%s, %d/%d collections processed, %d within %.1f%% skew",
+		p.Status, done, len(p.Collections), balanced, p.MaxSkew)
+	if p.Error != "" {
+		s += ": " + p.Error
+	}
+	return s
+}
+
+type shardedColl struct {
+	NS   string           `bson:"_id"`
+	UUID primitive.Binary `bson:"uuid"`
+	Key  bson.Raw         `bson:"key"`
+	// size is the collection size in the backup
+	size int64
+}
+
+// Rebalance distributes chunks of the largest sharded collections of the
+// restore over the shards until the data skew of each of them is within
+// opts.Skew percent. mongos is the connection to a router.
+//
+// The distribution is read anew on each run, so the canceled or failed
+// rebalance can be started again to continue. The progress is saved in
+// the restore meta after each step. node is the name of the node reading
+// the namespaces stats of the backup.
+func Rebalance(
+	ctx context.Context,
+	m connect.Client,
+	mongos *mongo.Client,
+	opts *ctrl.RebalanceCmd,
+	opid string,
+	node string,
+	l log.LogEvent,
+) error {
+	meta, err := GetRestoreMeta(ctx, m, opts.Restore)
+	if err != nil {
+		return errors.Wrap(err, "get restore meta")
+	}
+	if meta.Status != defs.StatusDone {
+		return errors.Errorf("restore %s is not done, status: %s", meta.Name, meta.Status)
+	}
+
+	bcp, err := backup.NewDBManager(m).GetBackupByName(ctx, meta.Backup)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
+		return errors.Wrap(err, "get backup meta")
+	}
+	if bcp != nil {
+		if err := backup.LoadNSStats(bcp, node, l); err != nil {
+			l.Warning("read namespaces stats: %v", err)
+		}
+	}
+
+	shards, err := rebalanceShards(ctx, m)
+	if err != nil {
+		return errors.Wrap(err, "get shards")
+	}
+	colls, err := rebalanceCollections(ctx, m, bcp, opts.Collections)
+	if err != nil {
+		return errors.Wrap(err, "get sharded collections")
+	}
+
+	p := &RebalanceProgress{
+		Status:  defs.StatusRunning,
+		OPID:    opid,
+		MaxSkew: opts.Skew,
+		StartTS: time.Now().Unix(),
+	}
+	for _, c := range colls {
+		p.Collections = append(p.Collections, RebalanceNS{NS: c.NS})
+	}
+	save := func() error {
+		p.UpdatedAt = time.Now().Unix()
+		// the progress has to be saved even if the rebalance is canceled
+		return SetRebalanceProgress(context.WithoutCancel(ctx), m, meta.Name, p)
+	}
+	if err := save(); err != nil {
+		return errors.Wrap(err, "save progress")
+	}
+
+	err = rebalanceColls(ctx, m, mongos, colls, shards, p, save, l)
+	switch {
+	case err == nil:
+		p.Status = defs.StatusDone
+	case errors.Is(err, context.Canceled):
+		p.Status = defs.StatusCancelled
+	default:
+		p.Status = defs.StatusError
+		p.Error = err.Error()
+	}
+	if err := save(); err != nil {
+		l.Error("save progress: %v", err)
+	}
+
+	return err
+}
+
+func rebalanceColls(
+	ctx context.Context,
+	m connect.Client,
+	mongos *mongo.Client,
+	colls []shardedColl,
+	shards []string,
+	p *RebalanceProgress,
+	save func() error,
+	l log.LogEvent,
+) error {
+	sizes := make(map[string]int64)
+	for i := range colls {
+		coll := &colls[i]
+		state := &p.Collections[i]
+		// chunks those can't be split (e.g. single shard key value)
+		unsplittable := make(map[string]bool)
+
+		for step := 0; ; step++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			chunks, err := collChunks(ctx, m, mongos, coll, sizes)
+			if err != nil {
+				return errors.Wrapf(err, "get %s chunks", coll.NS)
+			}
+			for _, c := range chunks {
+				c.Jumbo = c.Jumbo || unsplittable[c.key()]
+			}
+
+			state.Skew = dataSkew(chunks, shards)
+			st, ok := nextRebalanceStep(chunks, shards, p.MaxSkew)
+			if !ok || step == rebalanceMaxSteps {
+				state.Done = true
+				if err := save(); err != nil {
+					return errors.Wrap(err, "save progress")
+				}
+				if state.Skew > p.MaxSkew {
+					l.Warning("%s: data skew %.1f%% can't be reduced further", coll.NS, state.Skew)
+				} else {
+					l.Info("%s: data skew %.1f%%", coll.NS, state.Skew)
+				}
+				break
+			}
+
+			bounds := bson.A{st.Chunk.Min, st.Chunk.Max}
+			if st.Split {
+				err = mongos.Database("admin").RunCommand(ctx, bson.D{
+					{"split", coll.NS},
+					{"bounds", bounds},
+				}).Err()
+				if err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					l.Debug("%s: split chunk on %s: %v", coll.NS, st.From, err)
+					unsplittable[st.Chunk.key()] = true
+					continue
+				}
+				state.Split++
+			} else {
+				l.Debug("%s: moving chunk of %d bytes from %s to %s", coll.NS, st.Chunk.Size, st.From, st.To)
+				err = mongos.Database("admin").RunCommand(ctx, bson.D{
+					{"moveChunk", coll.NS},
+					{"bounds", bounds},
+					{"to", st.To},
+				}).Err()
+				if err != nil {
+					return errors.Wrapf(err, "move %s chunk from %s to %s", coll.NS, st.From, st.To)
+				}
+				state.Moved++
+			}
+
+			if err := save(); err != nil {
+				return errors.Wrap(err, "save progress")
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebalanceShards returns the shards which can take chunks
+func rebalanceShards(ctx context.Context, m connect.Client) ([]string, error) {
+	cur, err := m.ConfigDatabase().Collection("shards").Find(ctx, bson.D{{"draining", bson.D{{"$ne", true}}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var shards []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &shards); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	rv := make([]string, 0, len(shards))
+	for _, s := range shards {
+		rv = append(rv, s.ID)
+	}
+	return rv, nil
+}
+
+// rebalanceCollections returns up to n largest sharded collections.
+// The size is taken from the namespaces stats of the backup. If the backup
+// has no stats (or is missing), the number of chunks is used instead.
+func rebalanceCollections(
+	ctx context.Context,
+	m connect.Client,
+	bcp *backup.BackupMeta,
+	n int,
+) ([]shardedColl, error) {
+	cur, err := m.ConfigDatabase().Collection("collections").Find(ctx,
+		bson.D{
+			{"dropped", bson.D{{"$ne", true}}},
+			// tracked unsharded collections (8.0+)
+			{"unsplittable", bson.D{{"$ne", true}}},
+		},
+		options.Find().SetProjection(bson.D{{"_id", 1}, {"uuid", 1}, {"key", 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var colls []shardedColl
+	if err := cur.All(ctx, &colls); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	stats := make(map[string]int64)
+	if bcp != nil {
+		for _, rs := range bcp.Replsets {
+			for _, s := range rs.NSStats {
+				stats[s.NS] += s.Size
+			}
+		}
+	}
+
+	rv := make([]shardedColl, 0, len(colls))
+	for _, c := range colls {
+		if strings.HasPrefix(c.NS, "config.") {
+			continue
+		}
+
+		if len(stats) != 0 {
+			c.size = stats[c.NS]
+		} else {
+			c.size, err = m.ConfigDatabase().Collection("chunks").
+				CountDocuments(ctx, bson.D{{"uuid", c.UUID}})
+			if err != nil {
+				return nil, errors.Wrapf(err, "count %s chunks", c.NS)
+			}
+		}
+		if c.size == 0 {
+			continue
+		}
+
+		rv = append(rv, c)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].size != rv[j].size {
+			return rv[i].size > rv[j].size
+		}
+		return rv[i].NS < rv[j].NS
+	})
+	if n > 0 && len(rv) > n {
+		rv = rv[:n]
+	}
+
+	return rv, nil
+}
+
+// collChunks returns chunks of the collection with the estimated sizes.
+// The sizes of known chunks are taken from the sizes cache.
+func collChunks(
+	ctx context.Context,
+	m connect.Client,
+	mongos *mongo.Client,
+	coll *shardedColl,
+	sizes map[string]int64,
+) ([]*shardChunk, error) {
+	cur, err := m.ConfigDatabase().Collection("chunks").Find(ctx,
+		bson.D{{"uuid", coll.UUID}},
+		options.Find().SetProjection(bson.D{{"min", 1}, {"max", 1}, {"shard", 1}, {"jumbo", 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var chunks []*shardChunk
+	if err := cur.All(ctx, &chunks); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	for _, c := range chunks {
+		size, ok := sizes[c.key()]
+		if !ok {
+			var res struct {
+				Size int64 `bson:"size"`
+			}
+			err := mongos.Database("admin").RunCommand(ctx, bson.D{
+				{"dataSize", coll.NS},
+				{"keyPattern", coll.Key},
+				{"min", c.Min},
+				{"max", c.Max},
+				{"estimate", true},
+			}).Decode(&res)
+			if err != nil {
+				return nil, errors.Wrap(err, "get chunk size")
+			}
+
+			size = res.Size
+			sizes[c.key()] = size
+		}
+		c.Size = size
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,96 @@
+package restore
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDataSkew(t *testing.T) {
+	chunks := []*shardChunk{
+		{Shard: "rs1", Size: 30},
+		{Shard: "rs1", Size: 30},
+		{Shard: "rs2", Size: 30},
+	}
+
+	if got := dataSkew(chunks, []string{"rs1", "rs2", "rs3"}); math.Abs(got-100) > 0.001 {
+		t.Errorf("3 shards: got %f, want 100", got)
+	}
+	if got := dataSkew(chunks[:1], []string{"rs1"}); got != 0 {
+		t.Errorf("single shard: got %f, want 0", got)
+	}
+	if got := dataSkew(nil, []string{"rs1", "rs2"}); got != 0 {
+		t.Errorf("no data: got %f, want 0", got)
+	}
+}
+
+func TestNextRebalanceStep(t *testing.T) {
+	chunk := func(shard string, size int64) *shardChunk {
+		min, _ := bson.Marshal(bson.D{{"_id", size}})
+		return &shardChunk{Min: min, Shard: shard, Size: size}
+	}
+	shards := []string{"rs1", "rs2", "rs3"}
+
+	t.Run("balanced", func(t *testing.T) {
+		chunks := []*shardChunk{chunk("rs1", 10), chunk("rs2", 10), chunk("rs3", 11)}
+		if st, ok := nextRebalanceStep(chunks, shards, 10); ok {
+			t.Errorf("unexpected step %+v", st)
+		}
+	})
+
+	t.Run("move largest fitting chunk to the smallest shard", func(t *testing.T) {
+		big := chunk("rs1", 80)
+		fit := chunk("rs1", 40)
+		chunks := []*shardChunk{chunk("rs1", 10), big, fit, chunk("rs2", 30)}
+		st, ok := nextRebalanceStep(chunks, shards, 10)
+		if !ok {
+			t.Fatal("expected step")
+		}
+		if st.Split || st.Chunk != fit || st.From != "rs1" || st.To != "rs3" {
+			t.Errorf("got %+v", st)
+		}
+	})
+
+	t.Run("split too large chunk", func(t *testing.T) {
+		big := chunk("rs1", 100)
+		chunks := []*shardChunk{big}
+		st, ok := nextRebalanceStep(chunks, shards, 10)
+		if !ok {
+			t.Fatal("expected step")
+		}
+		if !st.Split || st.Chunk != big || st.From != "rs1" {
+			t.Errorf("got %+v", st)
+		}
+	})
+
+	t.Run("jumbo chunks only", func(t *testing.T) {
+		big := chunk("rs1", 100)
+		big.Jumbo = true
+		if st, ok := nextRebalanceStep([]*shardChunk{big}, shards, 10); ok {
+			t.Errorf("unexpected step %+v", st)
+		}
+	})
+
+	t.Run("converges", func(t *testing.T) {
+		chunks := []*shardChunk{chunk("rs1", 1000)}
+		for i := 0; i != 100; i++ {
+			st, ok := nextRebalanceStep(chunks, shards, 10)
+			if !ok {
+				break
+			}
+			if !st.Split {
+				st.Chunk.Shard = st.To
+				continue
+			}
+			half := *st.Chunk
+			half.Size /= 2
+			st.Chunk.Size -= half.Size
+			half.Min, _ = bson.Marshal(bson.D{{"_id", i}})
+			chunks = append(chunks, &half)
+		}
+		if skew := dataSkew(chunks, shards); skew > 10 {
+			t.Errorf("skew %f after rebalance", skew)
+		}
+	})
+}
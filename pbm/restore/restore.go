@@ -3,6 +3,7 @@ package restore
 import (
 	"context"
 	"encoding/json"
+	"slices"
 	"time"
 
 	"github.com/golang/snappy"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
@@ -40,21 +42,53 @@ func GetMetaFromStore(stg storage.Storage, bcpName string) (*backup.BackupMeta,
 	return b, errors.Wrap(err, "decode")
 }
 
+// checkBackupKey fails the restore early if the backup data is encrypted
+// and the key is not available to the agent.
+func checkBackupKey(stg *config.StorageConf) error {
+	if stg.Encryption == nil {
+		return nil
+	}
+
+	err := stg.Encryption.CheckKey(stg.Encryption.CurrentKeyID())
+	return errors.Wrap(err, "backup is encrypted")
+}
+
+// CheckSourceCluster checks the --source-cluster value of the restore.
+// It's required for backups made on another cluster and has to be the
+// cluster the backup is made on, so the data of a wrong cluster isn't
+// restored by mistake.
+func CheckSourceCluster(bcp *backup.BackupMeta, cluster string) error {
+	src := bcp.SourceCluster()
+	if cluster == "" {
+		if bcp.IsForeign() {
+			return errors.Errorf("backup %q is made on cluster %q. Set --source-cluster=%s to restore it",
+				bcp.Name, src, src)
+		}
+		return nil
+	}
+	if cluster != src {
+		return errors.Errorf("backup %q is made on cluster %q, not %q", bcp.Name, src, cluster)
+	}
+
+	return nil
+}
+
 func toState(
 	ctx context.Context,
 	conn connect.Client,
 	status defs.Status,
 	bcp string,
-	inf *topo.NodeInfo,
+	rs string,
+	leader bool,
 	reconcileFn reconcileStatus,
 	wait *time.Duration,
 ) error {
-	err := ChangeRestoreRSState(ctx, conn, bcp, inf.SetName, status, "")
+	err := ChangeRestoreRSState(ctx, conn, bcp, rs, status, "")
 	if err != nil {
 		return errors.Wrap(err, "set shard's status")
 	}
 
-	if inf.IsLeader() {
+	if leader {
 		err = reconcileFn(ctx, status, wait)
 		if err != nil {
 			if errors.Is(err, errConvergeTimeOut) {
@@ -151,11 +185,15 @@ func converged(
 	if err != nil {
 		return false, errors.Wrap(err, "get backup metadata")
 	}
+	if bmeta.Status == defs.StatusAborted {
+		return false, errors.Errorf("restore aborted: %s", bmeta.Error)
+	}
 
 	clusterTime, err := topo.GetClusterTime(ctx, conn)
 	if err != nil {
 		return false, errors.Wrap(err, "read cluster time")
 	}
+	staleSec := lock.StaleSec(ctx, conn)
 
 	for _, sh := range shards {
 		for _, shard := range bmeta.Replsets {
@@ -173,7 +211,7 @@ func converged(
 					if err != nil {
 						return false, errors.Wrapf(err, "unable to read lock for shard %s", shard.Name)
 					}
-					if lck.Heartbeat.T+defs.StaleFrameSec < clusterTime.T {
+					if lck.IsStale(clusterTime, staleSec) {
 						return false, errors.Errorf("lost shard %s, last beat ts: %d", shard.Name, lck.Heartbeat.T)
 					}
 				}
@@ -231,6 +269,8 @@ func waitForStatus(ctx context.Context, conn connect.Client, name string, status
 				return nil
 			case defs.StatusError:
 				return errors.Errorf("cluster failed: %s", meta.Error)
+			case defs.StatusAborted:
+				return errors.Errorf("restore aborted: %s", meta.Error)
 			}
 		case <-ctx.Done():
 			return nil
@@ -249,7 +289,8 @@ func chunks(
 	to primitive.Timestamp,
 	rsName string,
 	rsMap map[string]string,
-) ([]oplog.OplogChunk, error) {
+	node string,
+) ([]oplogRange, error) {
 	mapRevRS := util.MakeReverseRSMapFunc(rsMap)
 	chunks, err := oplog.PITRGetChunksSlice(ctx, conn, mapRevRS(rsName), from, to)
 	if err != nil {
@@ -266,24 +307,86 @@ func chunks(
 			chunks[len(chunks)-1].EndTS)
 	}
 
+	ranges, err := splitByStorage(ctx, conn, stg, chunks, node)
+	if err != nil {
+		return nil, err
+	}
+
 	last := from
-	for _, c := range chunks {
-		if last.Compare(c.StartTS) == -1 {
-			return nil, errors.Errorf(
-				"integrity vilolated, expect chunk with start_ts %v, but got %v",
-				last, c.StartTS)
+	for _, r := range ranges {
+		for _, c := range r.chunks {
+			if last.Compare(c.StartTS) == -1 {
+				return nil, errors.Errorf(
+					"integrity vilolated, expect chunk with start_ts %v, but got %v",
+					last, c.StartTS)
+			}
+			last = c.EndTS
+
+			_, err := r.storage.FileStat(c.FName)
+			if err != nil {
+				return nil, errors.Errorf(
+					"failed to ensure chunk %v.%v on the storage, file: %s, error: %v",
+					c.StartTS, c.EndTS, c.FName, err)
+			}
 		}
-		last = c.EndTS
+	}
 
-		_, err := stg.FileStat(c.FName)
-		if err != nil {
-			return nil, errors.Errorf(
-				"failed to ensure chunk %v.%v on the storage, file: %s, error: %v",
-				c.StartTS, c.EndTS, c.FName, err)
+	warnFilteredChunks(log.LogEventFromContext(ctx), chunks)
+
+	return ranges, nil
+}
+
+// splitByStorage splits the chunks into ranges of consecutive chunks on the
+// same storage. stg is the main storage. Storages of the chunks made with
+// the `replsets.<rs>.storage` option are taken from the config profiles.
+func splitByStorage(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	chunks []oplog.OplogChunk,
+	node string,
+) ([]oplogRange, error) {
+	stgs := map[string]storage.Storage{"": stg}
+
+	var rv []oplogRange
+	for i, c := range chunks {
+		if i > 0 && c.Storage == chunks[i-1].Storage {
+			rv[len(rv)-1].chunks = append(rv[len(rv)-1].chunks, c)
+			continue
+		}
+
+		cstg, ok := stgs[c.Storage]
+		if !ok {
+			var err error
+			cstg, err = util.GetProfileStorage(ctx, conn, c.Storage, node, log.LogEventFromContext(ctx))
+			if err != nil {
+				return nil, errors.Wrapf(err, "get storage of chunk %s", c.FName)
+			}
+			stgs[c.Storage] = cstg
 		}
+		rv = append(rv, oplogRange{chunks: []oplog.OplogChunk{c}, storage: cstg})
 	}
 
-	return chunks, nil
+	return rv, nil
+}
+
+// warnFilteredChunks warns if some chunks were captured with `pitr.filterAtCapture`.
+// Such chunks have no ops for the excluded namespaces, hence those won't be replayed.
+func warnFilteredChunks(l log.LogEvent, chunks []oplog.OplogChunk) {
+	var nss []string
+	for _, c := range chunks {
+		for _, ns := range c.ExcludedNS {
+			if !slices.Contains(nss, ns) {
+				nss = append(nss, ns)
+			}
+		}
+	}
+	if len(nss) == 0 {
+		return
+	}
+
+	l.Warning("some oplog chunks were captured without ops for namespaces %v. "+
+		"changes to these namespaces won't be replayed", nss)
 }
 
 type applyOplogOption struct {
@@ -293,6 +396,11 @@ type applyOplogOption struct {
 	cloudNS snapshot.CloneNS
 	unsafe  bool
 	filter  oplog.OpFilter
+	// excludeNS is a list of namespaces (`pitr.excludeNamespaces`)
+	// which ops are skipped
+	excludeNS []string
+	// prefetch is the read-ahead size of the chunks (see storage.Prefetch)
+	prefetch int
 }
 
 type (
@@ -332,6 +440,7 @@ func applyOplog(
 	ic *idx.IndexCatalog,
 	setTxn setcommittedTxnFn,
 	getTxn getcommittedTxnFn,
+	setProgress setOplogProgressFn,
 	stat *phys.DistTxnStat,
 	mgoV *version.MongoVersion,
 ) (partial []oplog.Txn, err error) {
@@ -356,6 +465,10 @@ func applyOplog(
 	}
 
 	oplogRestore.SetOpFilter(options.filter)
+	err = oplogRestore.SetExcludeNS(options.excludeNS)
+	if err != nil {
+		return nil, errors.Wrap(err, "set excluded namespaces")
+	}
 
 	var startTS, endTS primitive.Timestamp
 	if options.start != nil {
@@ -374,11 +487,30 @@ func applyOplog(
 		return nil, errors.Wrap(err, "set cloning ns")
 	}
 
+	prg := newOplogProgress(oplogRestore, ranges, endTS)
+	stopPrg := make(chan struct{})
+	go prg.run(ctx, stopPrg, setProgress)
+	defer func() {
+		close(stopPrg)
+		prg.sample()
+		prg.save(ctx, setProgress)
+	}()
+
 	var lts primitive.Timestamp
 	for _, oplogRange := range ranges {
 		stg := oplogRange.storage
+		// the ranges (e.g. of the merged replsets) are of their own time,
+		// only the chunks of the same range overlap
+		var applied primitive.Timestamp
 		for _, chnk := range oplogRange.chunks {
 			log.Debug("+ applying %v", chnk)
+			prg.chunkStarted(&chnk)
+
+			var skip primitive.Timestamp
+			if !applied.IsZero() && !chnk.StartTS.After(applied) {
+				skip = applied
+			}
+			oplogRestore.SetSkipApplied(skip)
 
 			// If the compression is Snappy and it failed we try S2.
 			// Up until v1.7.0 the compression of pitr chunks was always S2.
@@ -390,13 +522,21 @@ func applyOplog(
 			// PBM versions) won’t be compatible - during the restore, PBM will treat such
 			// files as Snappy (judging by its suffix) but in fact, they are s2 files
 			// and restore will fail with snappy: corrupt input. So we try S2 in such a case.
-			lts, err = replayChunk(chnk.FName, oplogRestore, stg, chnk.Compression)
+			lts, err = replayChunk(chnk.FName, oplogRestore, stg, chnk.Compression, options.prefetch, log.Info)
 			if err != nil && errors.Is(err, snappy.ErrCorrupt) {
-				lts, err = replayChunk(chnk.FName, oplogRestore, stg, compress.CompressionTypeS2)
+				lts, err = replayChunk(chnk.FName, oplogRestore, stg, compress.CompressionTypeS2, options.prefetch, log.Info)
 			}
 			if err != nil {
-				return nil, errors.Wrapf(err, "replay chunk %v.%v", chnk.StartTS.T, chnk.EndTS.T)
+				return nil, errors.Wrapf(err, "replay chunk %v.%v (last applied op: %v)",
+					chnk.StartTS.T, chnk.EndTS.T, oplogRestore.LastOpTS())
 			}
+			if lts.After(applied) {
+				applied = lts
+			}
+
+			prg.chunkDone(&chnk)
+			prg.sample()
+			log.Info("applied %s: %s", chnk.FName, prg.get())
 		}
 	}
 
@@ -437,15 +577,18 @@ func replayChunk(
 	oplog *oplog.OplogRestore,
 	stg storage.Storage,
 	c compress.CompressionType,
+	prefetch int,
+	logf func(msg string, args ...any),
 ) (primitive.Timestamp, error) {
 	or, err := stg.SourceReader(file)
 	if err != nil {
 		lts := primitive.Timestamp{}
 		return lts, errors.Wrapf(err, "get object %s form the storage", file)
 	}
+	or = storage.Prefetch(or, prefetch)
 	defer or.Close()
 
-	oplogReader, err := compress.Decompress(or, c)
+	oplogReader, err := compress.Decompress(or, c, logf)
 	if err != nil {
 		lts := primitive.Timestamp{}
 		return lts, errors.Wrapf(err, "decompress object %s", file)
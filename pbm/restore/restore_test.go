@@ -0,0 +1,58 @@
+package restore
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+)
+
+func TestCheckSourceCluster(t *testing.T) {
+	own := &backup.BackupMeta{
+		Name:      "own",
+		ClusterID: "65f1c0e2b4a1d2e3f4a5b6c7",
+		Replsets:  []backup.BackupReplset{{Name: "rs0"}},
+	}
+	foreign := &backup.BackupMeta{
+		Name:      "prod",
+		ClusterID: "65f1c0e2b4a1d2e3f4a5b6c8",
+		Replsets:  []backup.BackupReplset{{Name: "rs1"}, {Name: "cfg"}},
+		Store: backup.Storage{
+			Name:        "prod",
+			IsProfile:   true,
+			StorageConf: config.StorageConf{ReadOnly: true},
+		},
+	}
+	imported := &backup.BackupMeta{
+		Name:       "dump",
+		Replsets:   []backup.BackupReplset{{Name: "rs1"}, {Name: "cfg"}},
+		Provenance: &backup.Provenance{Source: backup.ProvenanceImport},
+	}
+
+	cases := []struct {
+		name    string
+		bcp     *backup.BackupMeta
+		cluster string
+		ok      bool
+	}{
+		{"own backup", own, "", true},
+		{"own backup with its cluster", own, own.ClusterID, true},
+		{"own backup with another cluster", own, foreign.ClusterID, false},
+		{"foreign backup", foreign, "", false},
+		{"foreign backup with its cluster", foreign, foreign.ClusterID, true},
+		{"foreign backup with another cluster", foreign, own.ClusterID, false},
+		{"imported backup", imported, "", false},
+		{"imported backup with replsets", imported, "cfg,rs1", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := CheckSourceCluster(c.bcp, c.cluster)
+			if c.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -110,6 +110,16 @@ func (r *Restore) getShardMapping(bcp *backup.BackupMeta) map[string]string {
 			rv[sourceS] = s.ID
 		}
 	}
+	// the shards merged to the cluster shards
+	for _, s := range r.shards {
+		for _, rs := range r.merge[s.RS] {
+			sourceS := source[rs]
+			if sourceS == "" {
+				sourceS = rs
+			}
+			rv[sourceS] = s.ID
+		}
+	}
 
 	return rv
 }
@@ -127,7 +137,7 @@ func (r *Restore) configsvrRestoreDatabases(
 	if err != nil {
 		return err
 	}
-	rdr, err = compress.Decompress(rdr, bcp.Compression)
+	rdr, err = compress.Decompress(rdr, bcp.Compression, r.log.Info)
 	if err != nil {
 		return err
 	}
@@ -210,7 +220,7 @@ func (r *Restore) configsvrRestoreCollections(
 	if err != nil {
 		return nil, err
 	}
-	rdr, err = compress.Decompress(rdr, bcp.Compression)
+	rdr, err = compress.Decompress(rdr, bcp.Compression, r.log.Info)
 	if err != nil {
 		return nil, err
 	}
@@ -274,7 +284,7 @@ func (r *Restore) configsvrRestoreChunks(
 	if err != nil {
 		return err
 	}
-	rdr, err = compress.Decompress(rdr, bcp.Compression)
+	rdr, err = compress.Decompress(rdr, bcp.Compression, r.log.Info)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,416 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// ShardingAction is what the restore through mongos has done to the
+// sharded collection of the backup before the data load
+type ShardingAction string
+
+const (
+	// ShardingCreated - the collection is sharded by the backup key
+	ShardingCreated ShardingAction = "sharded"
+	// ShardingKept - the collection is sharded by the backup key already
+	ShardingKept ShardingAction = "kept"
+	// ShardingMismatch - the collection is sharded by another key and
+	// kept as is (`restore.shardKeyMismatch: warn`)
+	ShardingMismatch ShardingAction = "mismatch"
+	// ShardingSkipped - the collection is restored unsharded
+	// (sharded timeseries)
+	ShardingSkipped ShardingAction = "skipped"
+)
+
+// ShardingCheck is the sharding of the collection in the cluster after
+// the restore compared to the backup one
+type ShardingCheck string
+
+const (
+	ShardingOK        ShardingCheck = "ok"
+	ShardingOtherKey  ShardingCheck = "otherKey"
+	ShardingUnsharded ShardingCheck = "unsharded"
+)
+
+// ShardingResult is what the restore has done and found in the cluster
+// for each sharded collection of the backup
+type ShardingResult struct {
+	Collections []ShardedCollResult `bson:"collections" json:"collections"`
+	UpdatedAt   int64               `bson:"updated_at" json:"updated_at"`
+}
+
+// ShardedCollResult is the sharding of the collection of the backup
+// in the cluster
+type ShardedCollResult struct {
+	NS string `bson:"ns" json:"ns"`
+	// Key is the shard key of the backup
+	Key    string `bson:"key" json:"key"`
+	Unique bool   `bson:"unique,omitempty" json:"unique,omitempty"`
+	// Action is done before the data load by the restore through mongos
+	Action ShardingAction `bson:"action,omitempty" json:"action,omitempty"`
+	// Check is empty until the restore has checked the cluster
+	Check ShardingCheck `bson:"check,omitempty" json:"check,omitempty"`
+	// CurrentKey is the shard key of the cluster if it's another one
+	CurrentKey string `bson:"current_key,omitempty" json:"current_key,omitempty"`
+	// Zones is the number of the zone ranges in the backup
+	Zones int `bson:"zones,omitempty" json:"zones,omitempty"`
+	// ZonesRestored is the number of the zone ranges in the cluster
+	ZonesRestored int    `bson:"zones_restored,omitempty" json:"zones_restored,omitempty"`
+	Error         string `bson:"error,omitempty" json:"error,omitempty"`
+
+	key bson.D
+}
+
+func (s *ShardingResult) String() string {
+	var b strings.Builder
+	ok := 0
+	for i := range s.Collections {
+		if s.Collections[i].Check == ShardingOK {
+			ok++
+		}
+	}
+	fmt.Fprintf(&b, "%d/%d collections are sharded as in the backup", ok, len(s.Collections))
+	for _, c := range s.Collections {
+		fmt.Fprintf(&b, "\n  - %s %s", c.NS, c.Key)
+		if c.Unique {
+			b.WriteString(" unique")
+		}
+		if c.Action != "" {
+			b.WriteString(" [" + string(c.Action) + "]")
+		}
+		switch c.Check {
+		case ShardingOtherKey:
+			b.WriteString(": sharded by " + c.CurrentKey)
+		case ShardingUnsharded:
+			b.WriteString(": not sharded")
+		case ShardingOK:
+			b.WriteString(": ok")
+		}
+		if c.Zones != 0 || c.ZonesRestored != 0 {
+			fmt.Fprintf(&b, ", zones %d/%d", c.ZonesRestored, c.Zones)
+		}
+		if c.Error != "" {
+			b.WriteString("; " + c.Error)
+		}
+	}
+	return b.String()
+}
+
+func newShardedCollResult(c topo.ShardedColl) ShardedCollResult {
+	return ShardedCollResult{
+		NS:     c.NS,
+		Key:    formatShardKey(c.Key),
+		Unique: c.Unique,
+		Zones:  c.Zones,
+		key:    c.Key,
+	}
+}
+
+// newShardingResult returns the result of the backup collections
+// accepted by selected
+func newShardingResult(colls []topo.ShardedColl, selected archive.NSFilterFn) *ShardingResult {
+	rv := &ShardingResult{Collections: []ShardedCollResult{}}
+	for _, c := range colls {
+		if selected(c.NS) {
+			rv.Collections = append(rv.Collections, newShardedCollResult(c))
+		}
+	}
+	return rv
+}
+
+// checkSharding compares the sharding of the cluster (curr) with the
+// backup collections of the result. The skipped collections aren't
+// checked. It returns the mismatches.
+func checkSharding(res *ShardingResult, curr []topo.ShardedColl) []string {
+	byNS := make(map[string]*topo.ShardedColl, len(curr))
+	for i := range curr {
+		byNS[curr[i].NS] = &curr[i]
+	}
+
+	var mismatch []string
+	for i := range res.Collections {
+		c := &res.Collections[i]
+		if c.Action == ShardingSkipped {
+			continue
+		}
+
+		cc, ok := byNS[c.NS]
+		if !ok {
+			c.Check = ShardingUnsharded
+			c.ZonesRestored = 0
+			mismatch = append(mismatch, fmt.Sprintf("%s isn't sharded, the backup has %s", c.NS, c.Key))
+			continue
+		}
+
+		c.ZonesRestored = cc.Zones
+		if !sameShardKey(cc.Key, c.key) {
+			c.Check = ShardingOtherKey
+			c.CurrentKey = formatShardKey(cc.Key)
+			mismatch = append(mismatch, fmt.Sprintf("%s is sharded by %s, the backup has %s",
+				c.NS, c.CurrentKey, c.Key))
+			continue
+		}
+		c.Check = ShardingOK
+	}
+
+	return mismatch
+}
+
+// shardingMismatch returns the error of the mismatches if the policy
+// is to fail. Otherwise, the mismatches are logged.
+func shardingMismatch(policy config.ShardKeyMismatch, mismatch []string, warn func(string, ...any)) error {
+	if len(mismatch) == 0 {
+		return nil
+	}
+	if policy != config.ShardKeyMismatchWarn {
+		return errors.Errorf("sharding mismatch: %s", strings.Join(mismatch, "; "))
+	}
+
+	for _, m := range mismatch {
+		warn("sharding mismatch: %s", m)
+	}
+	return nil
+}
+
+// verifySharding checks the sharding of the cluster by the config
+// database of conn after the data load. The result is saved to the
+// restore metadata. The mismatch fails the restore unless
+// `restore.shardKeyMismatch` is "warn". The zones are expected to be
+// restored if withZones.
+func (r *Restore) verifySharding(ctx context.Context, conn *mongo.Client, withZones bool) error {
+	curr, err := topo.ListShardedColls(ctx, conn)
+	if err != nil {
+		return errors.Wrap(err, "list sharded collections")
+	}
+
+	mismatch := checkSharding(r.sharding, curr)
+	r.saveSharding(ctx)
+
+	for _, c := range r.sharding.Collections {
+		if withZones && c.Check == ShardingOK && c.Zones != c.ZonesRestored {
+			r.log.Warning("%s has %d zone ranges, the backup has %d", c.NS, c.ZonesRestored, c.Zones)
+		}
+	}
+	if len(mismatch) == 0 {
+		r.log.Info("sharding of %d collections is verified", len(r.sharding.Collections))
+	}
+
+	return shardingMismatch(r.cfg.Restore.ShardKeyMismatchPolicy(), mismatch, r.log.Warning)
+}
+
+func (r *Restore) saveSharding(ctx context.Context) {
+	r.sharding.UpdatedAt = time.Now().Unix()
+	err := SetRestoreSharding(ctx, r.leadConn, r.name, r.sharding)
+	if err != nil {
+		r.log.Warning("save sharding: %v", err)
+	}
+}
+
+// backupSharding returns the sharded collections of the config server
+// dump with the number of their zone ranges
+func (r *Restore) backupSharding(bcp *backup.BackupMeta) ([]topo.ShardedColl, []zoneRange, error) {
+	specs, err := r.backupShardedColls(bcp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read config.collections")
+	}
+	zones, err := r.backupZones(bcp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read config.tags")
+	}
+
+	n := make(map[string]int)
+	for _, z := range zones {
+		n[z.NS]++
+	}
+
+	var rv []topo.ShardedColl
+	for _, c := range specs {
+		if c.Dropped || c.Unsplittable || strings.HasPrefix(c.NS, "config.") {
+			continue
+		}
+		rv = append(rv, topo.ShardedColl{NS: c.NS, Key: c.Key, Unique: c.Unique, Zones: n[c.NS]})
+	}
+
+	return rv, zones, nil
+}
+
+// zoneRange is the config.tags document
+type zoneRange struct {
+	NS  string `bson:"ns"`
+	Min bson.D `bson:"min"`
+	Max bson.D `bson:"max"`
+	Tag string `bson:"tag"`
+}
+
+// backupZones reads config.tags of the config server dump.
+// Nil if the dump has no zones.
+func (r *Restore) backupZones(bcp *backup.BackupMeta) ([]zoneRange, error) {
+	var rv []zoneRange
+	err := r.readConfigDump(bcp, "tags", func(b bson.Raw) error {
+		var z zoneRange
+		if err := bson.Unmarshal(b, &z); err != nil {
+			return err
+		}
+		rv = append(rv, z)
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	return rv, err
+}
+
+// backupShardZones reads the zones of the shards from config.shards
+// of the config server dump
+func (r *Restore) backupShardZones(bcp *backup.BackupMeta) (map[string][]string, error) {
+	rv := make(map[string][]string)
+	err := r.readConfigDump(bcp, "shards", func(b bson.Raw) error {
+		var s struct {
+			ID   string   `bson:"_id"`
+			Tags []string `bson:"tags"`
+		}
+		if err := bson.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		if len(s.Tags) != 0 {
+			rv[s.ID] = s.Tags
+		}
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotExist) {
+		return rv, nil
+	}
+	return rv, err
+}
+
+// restoreZones recreates the zone ranges of the backup for the collections
+// sharded or kept before the data load. The zones are added to the shards
+// of the same names as in the backup. It's the best effort: the errors are
+// saved to the results of the collections.
+func (r *Restore) restoreZones(ctx context.Context, bcp *backup.BackupMeta, zones []zoneRange) {
+	if len(zones) == 0 {
+		return
+	}
+
+	shardZones, err := r.backupShardZones(bcp)
+	if err != nil {
+		r.log.Warning("zones aren't restored: read config.shards: %v", err)
+		return
+	}
+
+	admin := r.mongos.Database("admin")
+	assigned := make(map[string]bool)
+	for _, s := range r.shards {
+		for _, tag := range shardZones[s.ID] {
+			err := admin.RunCommand(ctx, bson.D{{"addShardToZone", s.ID}, {"zone", tag}}).Err()
+			if err != nil {
+				r.log.Warning("add shard %s to zone %s: %v", s.ID, tag, err)
+				continue
+			}
+			assigned[tag] = true
+		}
+	}
+
+	colls := make(map[string]*ShardedCollResult, len(r.sharding.Collections))
+	for i := range r.sharding.Collections {
+		c := &r.sharding.Collections[i]
+		if c.Action == ShardingCreated || c.Action == ShardingKept {
+			colls[c.NS] = c
+		}
+	}
+
+	var errs map[string][]string
+	for _, z := range zones {
+		c, ok := colls[z.NS]
+		if !ok {
+			continue
+		}
+
+		var err error
+		if !assigned[z.Tag] {
+			err = errors.Errorf("zone %s has no shard in the cluster", z.Tag)
+		} else {
+			err = admin.RunCommand(ctx, bson.D{
+				{"updateZoneKeyRange", z.NS},
+				{"min", z.Min},
+				{"max", z.Max},
+				{"zone", z.Tag},
+			}).Err()
+		}
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string][]string)
+			}
+			errs[c.NS] = append(errs[c.NS], fmt.Sprintf("zone %s %s - %s: %v",
+				z.Tag, formatShardKey(z.Min), formatShardKey(z.Max), err))
+			continue
+		}
+		c.ZonesRestored++
+	}
+
+	for ns, e := range errs {
+		c := colls[ns]
+		c.Error = strings.Join(e, "; ")
+		r.log.Warning("zones of %s: %s", ns, c.Error)
+	}
+}
+
+// verifySharding checks the sharding metadata of the restored data files
+// of the config server against the one recorded at the backup start.
+// Nothing is changed, the result is saved to the sharding sync file.
+// The mismatch fails the restore unless `restore.shardKeyMismatch` is "warn".
+func (r *PhysRestore) verifySharding(ctx context.Context, c *mongo.Client) error {
+	if !r.nodeInfo.IsConfigSrv() || !r.nodeInfo.IsLeader() || r.bcp == nil {
+		return nil
+	}
+	if len(r.bcp.ShardedColls) == 0 {
+		r.log.Debug("no sharded collections recorded in the backup, skip the sharding check")
+		return nil
+	}
+
+	res := newShardingResult(r.bcp.ShardedColls, func(ns string) bool {
+		db, _, _ := strings.Cut(ns, ".")
+		return !slices.Contains(r.bcp.ExcludedDBs, db)
+	})
+	curr, err := topo.ListShardedColls(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "list sharded collections")
+	}
+	mismatch := checkSharding(res, curr)
+	res.UpdatedAt = time.Now().Unix()
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	err = util.RetryableWrite(r.stg, r.syncPathSharding, b)
+	if err != nil {
+		r.log.Warning("write sharding check: %v", err)
+	}
+
+	for _, c := range res.Collections {
+		if c.Check == ShardingOK && c.Zones != c.ZonesRestored {
+			r.log.Warning("%s has %d zone ranges, the backup has %d", c.NS, c.ZonesRestored, c.Zones)
+		}
+	}
+	if len(mismatch) == 0 {
+		r.log.Info("sharding of %d collections is verified", len(res.Collections))
+	}
+
+	return shardingMismatch(r.confOpts.ShardKeyMismatchPolicy(), mismatch, r.log.Warning)
+}
@@ -0,0 +1,97 @@
+package restore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+func TestCheckSharding(t *testing.T) {
+	bcp := []topo.ShardedColl{
+		{NS: "app.users", Key: bson.D{{"_id", "hashed"}}, Zones: 2},
+		{NS: "app.orders", Key: bson.D{{"customer", 1}, {"_id", 1}}, Unique: true},
+		{NS: "app.events", Key: bson.D{{"ts", 1}}},
+		{NS: "app.metrics", Key: bson.D{{"meta", 1}}},
+		{NS: "other.logs", Key: bson.D{{"_id", 1}}},
+	}
+	curr := []topo.ShardedColl{
+		{NS: "app.users", Key: bson.D{{"_id", "hashed"}}, Zones: 1},
+		{NS: "app.orders", Key: bson.D{{"customer", int64(1)}, {"_id", 1.0}}, Unique: true},
+		{NS: "app.events", Key: bson.D{{"_id", "hashed"}}},
+		{NS: "other.logs", Key: bson.D{{"_id", 1}}},
+	}
+
+	res := newShardingResult(bcp, util.MakeSelectedPred([]string{"app.*"}))
+	res.Collections[3].Action = ShardingSkipped
+
+	mismatch := checkSharding(res, curr)
+	want := []string{
+		"app.events is sharded by {_id: hashed}, the backup has {ts: 1}",
+	}
+	if !reflect.DeepEqual(mismatch, want) {
+		t.Fatalf("mismatch: got %q, want %q", mismatch, want)
+	}
+
+	checks := make(map[string]ShardingCheck)
+	for _, c := range res.Collections {
+		checks[c.NS] = c.Check
+	}
+	wantChecks := map[string]ShardingCheck{
+		"app.users":   ShardingOK,
+		"app.orders":  ShardingOK,
+		"app.events":  ShardingOtherKey,
+		"app.metrics": "",
+	}
+	if !reflect.DeepEqual(checks, wantChecks) {
+		t.Errorf("checks: got %v, want %v", checks, wantChecks)
+	}
+	if c := res.Collections[0]; c.Zones != 2 || c.ZonesRestored != 1 {
+		t.Errorf("zones: got %d/%d, want 1/2", c.ZonesRestored, c.Zones)
+	}
+	if c := res.Collections[2]; c.CurrentKey != "{_id: hashed}" {
+		t.Errorf("current key: got %s", c.CurrentKey)
+	}
+
+	res = newShardingResult(bcp[:1], util.MakeSelectedPred(nil))
+	mismatch = checkSharding(res, nil)
+	if len(mismatch) != 1 || res.Collections[0].Check != ShardingUnsharded {
+		t.Errorf("unsharded: got %q, %s", mismatch, res.Collections[0].Check)
+	}
+}
+
+func TestShardingMismatch(t *testing.T) {
+	mismatch := []string{"app.a isn't sharded", "app.b is sharded by { b: 1 }"}
+
+	var warned []string
+	warn := func(msg string, args ...any) {
+		warned = append(warned, msg)
+	}
+
+	for _, p := range []config.ShardKeyMismatch{"", config.ShardKeyMismatchFail} {
+		err := shardingMismatch(p, mismatch, warn)
+		if err == nil || !strings.Contains(err.Error(), "app.b is sharded by") {
+			t.Errorf("%q: got %v", p, err)
+		}
+	}
+	if len(warned) != 0 {
+		t.Errorf("fail policy warned: %q", warned)
+	}
+
+	err := shardingMismatch(config.ShardKeyMismatchWarn, mismatch, warn)
+	if err != nil {
+		t.Errorf("warn: got %v", err)
+	}
+	if len(warned) != len(mismatch) {
+		t.Errorf("warn: got %d warnings, want %d", len(warned), len(mismatch))
+	}
+
+	if err := shardingMismatch(config.ShardKeyMismatchFail, nil, warn); err != nil {
+		t.Errorf("no mismatch: got %v", err)
+	}
+}
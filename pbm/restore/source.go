@@ -0,0 +1,186 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// replaySource is a set of oplog chunk files given by `oplog-replay --source`
+type replaySource struct {
+	stg    storage.Storage
+	chunks []oplog.OplogChunk
+}
+
+// openReplaySource lists chunk files of the source. The source is either
+// a local directory (`file://` or absolute path) or a path within the main storage.
+func (r *Restore) openReplaySource(ctx context.Context, source string) (*replaySource, error) {
+	l := log.LogEventFromContext(ctx)
+
+	var stg storage.Storage
+	prefix := ""
+	if dir, ok := strings.CutPrefix(source, "file://"); ok || filepath.IsAbs(source) {
+		if !ok {
+			dir = source
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return nil, errors.Wrapf(err, "check local dir %q", dir)
+		}
+		fsStg, err := fs.New(&fs.Config{Path: dir})
+		if err != nil {
+			return nil, errors.Wrapf(err, "open local dir %q", dir)
+		}
+		stg = fsStg
+	} else {
+		var err error
+		stg, err = util.GetStorage(ctx, r.leadConn, r.nodeInfo.Me, l)
+		if err != nil {
+			return nil, errors.Wrap(err, "get storage")
+		}
+		prefix = strings.Trim(source, "/")
+	}
+
+	files, err := stg.List(prefix, "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "list files in %q", source)
+	}
+
+	chunks, skipped := oplog.ChunksFromFiles(prefix, files)
+	if len(skipped) > 0 {
+		l.Warning("skip %d files in %q that aren't oplog chunks: %v", len(skipped), source, skipped)
+	}
+	if len(chunks) == 0 {
+		return nil, errors.Errorf("no oplog chunks found in %q", source)
+	}
+
+	return &replaySource{stg: stg, chunks: chunks}, nil
+}
+
+// RSNames returns replsets that have chunks within [from, to].
+func (s *replaySource) RSNames(from, to primitive.Timestamp) []string {
+	rss := []string{}
+	for _, c := range oplog.SelectChunks(s.chunks, from, to) {
+		if !slices.Contains(rss, c.RS) {
+			rss = append(rss, c.RS)
+		}
+	}
+
+	return rss
+}
+
+// Chunks returns deduplicated chunks of the replset that cover [from, to].
+// Holes in the range are an error unless allowGaps is set.
+func (s *replaySource) Chunks(
+	ctx context.Context,
+	from,
+	to primitive.Timestamp,
+	rsName string,
+	rsMap map[string]string,
+	allowGaps bool,
+) ([]oplog.OplogChunk, error) {
+	rs := util.MakeReverseRSMapFunc(rsMap)(rsName)
+
+	rsChunks := []oplog.OplogChunk{}
+	for _, c := range s.chunks {
+		if c.RS == rs {
+			rsChunks = append(rsChunks, c)
+		}
+	}
+
+	chunks := oplog.SelectChunks(oplog.DedupChunks(rsChunks), from, to)
+	if len(chunks) == 0 {
+		return nil, errors.New("no chunks found")
+	}
+
+	holes := oplog.ChunksHoles(chunks, from, to)
+	if len(holes) > 0 {
+		if !allowGaps {
+			return nil, errors.Errorf("oplog chunks don't cover the range, holes: %v. "+
+				"use --allow-gaps to replay anyway", holes)
+		}
+		log.LogEventFromContext(ctx).Warning("replaying oplog with holes: %v", holes)
+	}
+
+	for i := range chunks {
+		err := checkChunkFile(s.stg, &chunks[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "check chunk file %s", chunks[i].FName)
+		}
+	}
+
+	return chunks, nil
+}
+
+// checkChunkFile ensures the file can be decompressed and its first op
+// is within the chunk time range.
+// Old chunks with `.snappy` suffix are in fact S2, so the compression
+// is fixed in such case.
+func checkChunkFile(stg storage.Storage, c *oplog.OplogChunk) error {
+	ts, err := firstOpTS(stg, c.FName, c.Compression)
+	if err != nil && errors.Is(err, snappy.ErrCorrupt) {
+		ts, err = firstOpTS(stg, c.FName, compress.CompressionTypeS2)
+		if err == nil {
+			c.Compression = compress.CompressionTypeS2
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if ts.Before(c.StartTS) || ts.After(c.EndTS) {
+		return errors.Errorf("the first op %v is out of the chunk range [%v, %v]", ts, c.StartTS, c.EndTS)
+	}
+
+	return nil
+}
+
+func firstOpTS(stg storage.Storage, file string, c compress.CompressionType) (primitive.Timestamp, error) {
+	var ts primitive.Timestamp
+
+	rdr, err := stg.SourceReader(file)
+	if err != nil {
+		return ts, errors.Wrap(err, "get object")
+	}
+	defer rdr.Close()
+
+	// the detected compression is logged by the replay
+	orr, err := compress.Decompress(rdr, c, nil)
+	if err != nil {
+		return ts, errors.Wrap(err, "decompress")
+	}
+	defer orr.Close()
+
+	src := db.NewBufferlessBSONSource(orr)
+	raw := src.LoadNext()
+	if raw == nil {
+		if err := src.Err(); err != nil {
+			return ts, errors.Wrap(err, "read op")
+		}
+		return ts, errors.New("no ops")
+	}
+
+	op := struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}{}
+	err = bson.Unmarshal(raw, &op)
+	if err != nil {
+		return ts, errors.Wrap(err, "decode op")
+	}
+
+	return op.TS, nil
+}
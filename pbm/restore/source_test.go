@@ -0,0 +1,55 @@
+package restore
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCheckChunkFile(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op, err := bson.Marshal(bson.M{"ts": primitive.Timestamp{T: 105}, "op": "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stg.Save("rs0/20240101/chunk.oplog", bytes.NewReader(op), int64(len(op))); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		start, end uint32
+		ok         bool
+	}{
+		{"start", 105, 110, true},
+		// the chunk starts at the end of the previous one
+		{"within", 100, 110, true},
+		{"end", 100, 105, true},
+		{"before", 106, 110, false},
+		{"after", 90, 104, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &oplog.OplogChunk{
+				FName:       "rs0/20240101/chunk.oplog",
+				Compression: compress.CompressionTypeNone,
+				StartTS:     primitive.Timestamp{T: tc.start},
+				EndTS:       primitive.Timestamp{T: tc.end},
+			}
+			err := checkChunkFile(stg, c)
+			if (err == nil) != tc.ok {
+				t.Errorf("got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
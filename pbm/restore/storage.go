@@ -57,6 +57,9 @@ func GetPhysRestoreMeta(restoreName string, stg storage.Storage, l log.LogEvent)
 	rmeta.Conditions = condsm.Conditions
 	rmeta.Type = defs.PhysicalBackup
 	rmeta.Stat = condsm.Stat
+	if condsm.Sharding != nil {
+		rmeta.Sharding = condsm.Sharding
+	}
 
 	return rmeta, err
 }
@@ -162,6 +165,24 @@ func ParsePhysRestoreStatus(restoreName string, stg storage.Storage, l log.LogEv
 					rs.rs.LastTransitionTS = l.Timestamp
 					rs.rs.Error = l.Error
 				}
+			case "progress":
+				src, err := stg.SourceReader(filepath.Join(defs.PhysRestoresDir, restoreName, f.Name))
+				if err != nil {
+					l.Error("get progress file %s: %v", f.Name, err)
+					break
+				}
+				prg := &PhysNodeProgress{}
+				err = json.NewDecoder(src).Decode(prg)
+				src.Close()
+				if err != nil {
+					l.Error("unmarshal progress file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node := rs.nodes[nName]
+				node.Name = nName
+				node.Progress = prg
+				rs.nodes[nName] = node
 			case "stat":
 				src, err := stg.SourceReader(filepath.Join(defs.PhysRestoresDir, restoreName, f.Name))
 				if err != nil {
@@ -189,6 +210,20 @@ func ParsePhysRestoreStatus(restoreName string, stg storage.Storage, l log.LogEv
 					lstat.Download = *st.D
 				}
 				meta.Stat.RS[rsName][nName] = lstat
+			case "sharding":
+				src, err := stg.SourceReader(filepath.Join(defs.PhysRestoresDir, restoreName, f.Name))
+				if err != nil {
+					l.Error("get sharding file %s: %v", f.Name, err)
+					break
+				}
+				sh := &ShardingResult{}
+				err = json.NewDecoder(src).Decode(sh)
+				src.Close()
+				if err != nil {
+					l.Error("unmarshal sharding file %s: %v", f.Name, err)
+					break
+				}
+				meta.Sharding = sh
 			}
 			rss[rsName] = rs
 
@@ -229,7 +264,9 @@ func ParsePhysRestoreStatus(restoreName string, stg storage.Storage, l log.LogEv
 			if rs.rs.Error == "" {
 				rs.rs.Error = nodeErr
 			}
-			meta.Status = defs.StatusError
+			if meta.Status != defs.StatusAborted {
+				meta.Status = defs.StatusError
+			}
 			if meta.Error == "" {
 				meta.Error = nodeErr
 			}
@@ -253,7 +290,8 @@ func parsePhysRestoreCond(stg storage.Storage, fname, restoreName string) (*Cond
 		return nil, errors.Wrapf(err, "read file %s", fname)
 	}
 
-	if cond.Status == defs.StatusError || cond.Status == defs.StatusExtTS {
+	switch cond.Status {
+	case defs.StatusError, defs.StatusExtTS, defs.StatusAborted:
 		estr := strings.SplitN(string(b), ":", 2)
 		if len(estr) != 2 {
 			return nil, errors.Errorf("malformatted data in %s: %s", fname, b)
@@ -262,7 +300,7 @@ func parsePhysRestoreCond(stg storage.Storage, fname, restoreName string) (*Cond
 		if err != nil {
 			return nil, errors.Wrapf(err, "read ts from %s", fname)
 		}
-		if cond.Status == defs.StatusError {
+		if cond.Status != defs.StatusExtTS {
 			cond.Error = estr[1]
 		}
 		return &cond, nil
@@ -0,0 +1,225 @@
+package restore
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// TargetReplset is the state of the replset of the restore target
+type TargetReplset struct {
+	// FirstWrite is the first write of user data after the restore time
+	FirstWrite primitive.Timestamp
+	// DBs are the user databases of the replset
+	DBs []string
+}
+
+func isSystemDB(db string) bool {
+	return db == defs.DB || db == "config" || db == "local"
+}
+
+// ReadTargetReplset reads the state of the replset m. Only writes after
+// until to databases of dbs (any user database if empty) are looked for.
+// The oplog is scanned forward from until, so only the entries after it
+// are read, up to the first user write.
+func ReadTargetReplset(
+	ctx context.Context,
+	m *mongo.Client,
+	until primitive.Timestamp,
+	dbs []string,
+) (*TargetReplset, error) {
+	names, err := m.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list databases")
+	}
+
+	rv := &TargetReplset{}
+	for _, db := range names {
+		if !isSystemDB(db) {
+			rv.DBs = append(rv.DBs, db)
+		}
+	}
+
+	op := struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}{}
+	err = m.Database("local").Collection("oplog.rs").
+		FindOne(ctx, userWritesFilter(until, dbs),
+			options.FindOne().
+				SetHint(bson.D{{"$natural", 1}}).
+				SetProjection(bson.D{{"ts", 1}})).
+		Decode(&op)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Wrap(err, "find first write")
+	}
+	rv.FirstWrite = op.TS
+
+	return rv, nil
+}
+
+// userWritesFilter matches oplog entries of user data writes after ts.
+// Transactions are applyOps commands on the admin database.
+func userWritesFilter(ts primitive.Timestamp, dbs []string) bson.D {
+	ns := bson.M{"$not": primitive.Regex{Pattern: `^(admin|config|local)\.`}}
+	if len(dbs) != 0 {
+		quoted := make([]string, len(dbs))
+		for i, db := range dbs {
+			quoted[i] = regexp.QuoteMeta(db)
+		}
+		ns = bson.M{"$regex": `^(` + strings.Join(quoted, "|") + `)\.`}
+	}
+
+	return bson.D{
+		{"ts", bson.M{"$gt": ts}},
+		{"$or", bson.A{
+			bson.D{{"op", bson.M{"$in": bson.A{"i", "u", "d", "c"}}}, {"ns", ns}},
+			bson.D{{"op", "c"}, {"ns", "admin.$cmd"}, {"o.applyOps", bson.M{"$exists": true}}},
+		}},
+	}
+}
+
+// SelectedDBs returns the databases of the namespaces of the selective
+// restore. A selective restore touches them only, other databases of
+// the target are kept. Nil if the restore isn't selective.
+func SelectedDBs(nss []string) []string {
+	if !util.IsSelective(nss) {
+		return nil
+	}
+
+	var rv []string
+	for _, ns := range nss {
+		db, _, _ := strings.Cut(ns, ".")
+		if !slices.Contains(rv, db) {
+			rv = append(rv, db)
+		}
+	}
+	return rv
+}
+
+// CheckTarget checks the replset m before the restore as the client does
+// (see NewTargetCheck). It is run by the agent on the replset primary to
+// catch the writes made since the check of the client and the restores
+// sent by clients which don't check the target. The check overridden by
+// the client is skipped. The databases are compared with all databases
+// of the backup, as the replsets may be mapped or merged.
+func CheckTarget(
+	ctx context.Context,
+	m *mongo.Client,
+	bcp *backup.BackupMeta,
+	until primitive.Timestamp,
+	nss []string,
+	node string,
+	client *ctrl.RestoreTargetCheck,
+) error {
+	if client != nil && client.Override != "" {
+		return nil
+	}
+
+	dbs := SelectedDBs(nss)
+	rs, err := ReadTargetReplset(ctx, m, until, dbs)
+	if err != nil {
+		return errors.Wrap(err, "read target")
+	}
+
+	var bcpDBs []string
+	if len(dbs) == 0 {
+		rss := make([]string, len(bcp.Replsets))
+		for i := range bcp.Replsets {
+			rss[i] = bcp.Replsets[i].Name
+		}
+		bcpDBs, err = BackupDatabases(ctx, bcp, rss, node)
+		if err != nil {
+			return errors.Wrap(err, "get backup databases")
+		}
+	}
+
+	c := NewTargetCheck(until, map[string]*TargetReplset{"": rs}, bcpDBs)
+	if c.Failed() {
+		return errors.Errorf("restore target check %s. Use --force to restore anyway", c)
+	}
+
+	return nil
+}
+
+// NewTargetCheck compares the replsets of the restore target with the
+// backup. bcpDBs are the databases of the backup, nil if unknown.
+func NewTargetCheck(
+	until primitive.Timestamp,
+	rss map[string]*TargetReplset,
+	bcpDBs []string,
+) *ctrl.RestoreTargetCheck {
+	rv := &ctrl.RestoreTargetCheck{Until: until, DBsChecked: bcpDBs != nil}
+
+	extra := make(map[string]bool)
+	for _, rs := range rss {
+		if !rs.FirstWrite.IsZero() && (rv.FirstWrite.IsZero() || rv.FirstWrite.After(rs.FirstWrite)) {
+			rv.FirstWrite = rs.FirstWrite
+		}
+		if !rv.DBsChecked {
+			continue
+		}
+		for _, db := range rs.DBs {
+			if !slices.Contains(bcpDBs, db) {
+				extra[db] = true
+			}
+		}
+	}
+
+	for db := range extra {
+		rv.ExtraDBs = append(rv.ExtraDBs, db)
+	}
+	slices.Sort(rv.ExtraDBs)
+
+	return rv
+}
+
+// BackupDatabases returns the user databases of the backup replsets rss.
+// Returns nil if the databases are unknown without reading the data
+// (physical backups and logical backups of the legacy layout).
+func BackupDatabases(
+	ctx context.Context,
+	bcp *backup.BackupMeta,
+	rss []string,
+	node string,
+) ([]string, error) {
+	if bcp.Type != defs.LogicalBackup || bcp.IsLegacyArchive() {
+		return nil, nil
+	}
+
+	rv := []string{}
+	for _, name := range rss {
+		rs := bcp.RS(name)
+		if rs == nil {
+			continue
+		}
+
+		stg, err := util.StorageFromConfig(&bcp.RSStorage(name).StorageConf, node, log.LogEventFromContext(ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "get storage of %s", name)
+		}
+		nss, err := backup.ReadArchiveNamespaces(stg, rs.DumpName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read namespaces of %s", name)
+		}
+		for _, ns := range nss {
+			if !isSystemDB(ns.Database) && !slices.Contains(rv, ns.Database) {
+				rv = append(rv, ns.Database)
+			}
+		}
+	}
+
+	return rv, nil
+}
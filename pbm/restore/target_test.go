@@ -0,0 +1,71 @@
+package restore
+
+import (
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewTargetCheck(t *testing.T) {
+	until := primitive.Timestamp{T: 100}
+
+	t.Run("empty target", func(t *testing.T) {
+		c := NewTargetCheck(until, map[string]*TargetReplset{"rs0": {}, "rs1": {}}, []string{"db0"})
+		if c.Failed() {
+			t.Errorf("unexpected fail: %+v", c)
+		}
+	})
+
+	t.Run("same databases", func(t *testing.T) {
+		c := NewTargetCheck(until, map[string]*TargetReplset{
+			"rs0": {DBs: []string{"db0"}},
+			"rs1": {DBs: []string{"db1"}},
+		}, []string{"db0", "db1"})
+		if c.Failed() {
+			t.Errorf("unexpected fail: %+v", c)
+		}
+	})
+
+	t.Run("extra databases and writes", func(t *testing.T) {
+		c := NewTargetCheck(until, map[string]*TargetReplset{
+			"rs0": {DBs: []string{"db0", "new"}, FirstWrite: primitive.Timestamp{T: 120, I: 2}},
+			"rs1": {DBs: []string{"db1", "another", "new"}, FirstWrite: primitive.Timestamp{T: 110}},
+		}, []string{"db0", "db1"})
+		if !c.Failed() {
+			t.Fatal("expected fail")
+		}
+		if len(c.ExtraDBs) != 2 || c.ExtraDBs[0] != "another" || c.ExtraDBs[1] != "new" {
+			t.Errorf("extra dbs: got %v", c.ExtraDBs)
+		}
+		if c.FirstWrite != (primitive.Timestamp{T: 110}) {
+			t.Errorf("first write: got %v", c.FirstWrite)
+		}
+	})
+
+	t.Run("unknown backup databases", func(t *testing.T) {
+		c := NewTargetCheck(until, map[string]*TargetReplset{"rs0": {DBs: []string{"db0"}}}, nil)
+		if c.Failed() || c.DBsChecked {
+			t.Errorf("unexpected fail: %+v", c)
+		}
+	})
+}
+
+func TestSelectedDBs(t *testing.T) {
+	tests := []struct {
+		nss  []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"*.*"}, nil},
+		{[]string{"db0.*"}, []string{"db0"}},
+		{[]string{"db0.c0", "db1.*", "db0.c1"}, []string{"db0", "db1"}},
+	}
+
+	for _, tt := range tests {
+		got := SelectedDBs(tt.nss)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v: want %v, got %v", tt.nss, tt.want, got)
+		}
+	}
+}
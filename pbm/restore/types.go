@@ -2,32 +2,126 @@ package restore
 
 import (
 	"sort"
+	"time"
 
 	"github.com/mongodb/mongo-tools/common/db"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/restore/phys"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
+// RestoreMetaVersion is the schema version of RestoreMeta. Restores made
+// by older versions have 0 and no Initiator, Options, replsets Bytes
+// and CountCheck.
+const RestoreMetaVersion = 1
+
 type RestoreMeta struct {
-	Status           defs.Status         `bson:"status" json:"status"`
-	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
-	Name             string              `bson:"name" json:"name"`
-	OPID             string              `bson:"opid" json:"opid"`
-	Backup           string              `bson:"backup" json:"backup"`
-	BcpChain         []string            `bson:"bcp_chain" json:"bcp_chain"` // for incremental
-	Namespaces       []string            `bson:"nss,omitempty" json:"nss,omitempty"`
-	StartPITR        int64               `bson:"start_pitr" json:"start_pitr"`
-	PITR             int64               `bson:"pitr" json:"pitr"`
-	Replsets         []RestoreReplset    `bson:"replsets" json:"replsets"`
-	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
-	StartTS          int64               `bson:"start_ts" json:"start_ts"`
-	LastTransitionTS int64               `bson:"last_transition_ts" json:"last_transition_ts"`
-	Conditions       Conditions          `bson:"conditions" json:"conditions"`
-	Type             defs.BackupType     `bson:"type" json:"type"`
-	Leader           string              `bson:"l,omitempty" json:"l,omitempty"`
-	Stat             *phys.RestoreStat   `bson:"stat,omitempty" json:"stat,omitempty"`
+	SchemaVersion int         `bson:"schema_version,omitempty" json:"schema_version,omitempty"`
+	Status        defs.Status `bson:"status" json:"status"`
+	Error         string      `bson:"error,omitempty" json:"error,omitempty"`
+	// ErrorCode is the kind of the failure if it's known
+	ErrorCode        errors.Code              `bson:"error_code,omitempty" json:"error_code,omitempty"`
+	Name             string                   `bson:"name" json:"name"`
+	OPID             string                   `bson:"opid" json:"opid"`
+	Backup           string                   `bson:"backup" json:"backup"`
+	BcpChain         []string                 `bson:"bcp_chain" json:"bcp_chain"` // for incremental
+	Namespaces       []string                 `bson:"nss,omitempty" json:"nss,omitempty"`
+	SingleRS         string                   `bson:"single_rs,omitempty" json:"single_rs,omitempty"`
+	RSMap            map[string]string        `bson:"rs_map,omitempty" json:"rs_map,omitempty"` // backup replset -> target replset
+	Merge            map[string][]string      `bson:"merge,omitempty" json:"merge,omitempty"`   // target replset -> merged backup replsets
+	SourceCluster    string                   `bson:"source_cluster,omitempty" json:"source_cluster,omitempty"`
+	TargetCheck      *ctrl.RestoreTargetCheck `bson:"target_check,omitempty" json:"target_check,omitempty"`
+	NSConflicts      *ctrl.RestoreNSConflicts `bson:"ns_conflicts,omitempty" json:"ns_conflicts,omitempty"`
+	Initiator        string                   `bson:"initiator,omitempty" json:"initiator,omitempty"`
+	Options          *RestoreOptions          `bson:"options,omitempty" json:"options,omitempty"`
+	StartPITR        int64                    `bson:"start_pitr" json:"start_pitr"`
+	PITR             int64                    `bson:"pitr" json:"pitr"`
+	Replsets         []RestoreReplset         `bson:"replsets" json:"replsets"`
+	Hb               primitive.Timestamp      `bson:"hb" json:"hb"`
+	StartTS          int64                    `bson:"start_ts" json:"start_ts"`
+	LastTransitionTS int64                    `bson:"last_transition_ts" json:"last_transition_ts"`
+	Conditions       Conditions               `bson:"conditions" json:"conditions"`
+	Type             defs.BackupType          `bson:"type" json:"type"`
+	Leader           string                   `bson:"l,omitempty" json:"l,omitempty"`
+	Stat             *phys.RestoreStat        `bson:"stat,omitempty" json:"stat,omitempty"`
+	Rebalance        *RebalanceProgress       `bson:"rebalance,omitempty" json:"rebalance,omitempty"`
+	// IndexBuildsPaused holds off the start of new index builds of
+	// the logical restore (see `pbm restore-indexes pause`).
+	IndexBuildsPaused bool `bson:"index_builds_paused,omitempty" json:"index_builds_paused,omitempty"`
+	// BalancerStopped is true while the balancer stopped by the restore
+	// isn't started back
+	BalancerStopped bool `bson:"balancer_stopped,omitempty" json:"balancer_stopped,omitempty"`
+	// Abort is set once the restore is aborted (see `pbm cancel-restore`)
+	Abort *AbortInfo `bson:"abort,omitempty" json:"abort,omitempty"`
+	// PITRRestart is set if PITR was enabled before the restore
+	PITRRestart *PITRRestart `bson:"pitr_restart,omitempty" json:"pitr_restart,omitempty"`
+	// Sharding is the sharding of the sharded collections of the backup
+	// in the cluster. Nil for non-sharded clusters and point-in-time restores.
+	Sharding *ShardingResult `bson:"sharding,omitempty" json:"sharding,omitempty"`
+}
+
+type PITRRestartStatus string
+
+const (
+	// PITRRestartPending waits for a fresh base backup
+	PITRRestartPending PITRRestartStatus = "pending"
+	// PITRRestartDone is PITR enabled back
+	PITRRestartDone PITRRestartStatus = "done"
+	// PITRRestartFailed is PITR left disabled (the restore or
+	// the base backup has failed)
+	PITRRestartFailed PITRRestartStatus = "failed"
+)
+
+// PITRRestart is the state of enabling PITR back after the restore.
+// The cluster leader enables it once there is a backup made after the
+// restore, and starts the backup itself with `pitr.autoRestartAfterRestore`.
+type PITRRestart struct {
+	Status PITRRestartStatus `bson:"status" json:"status"`
+	Error  string            `bson:"error,omitempty" json:"error,omitempty"`
+	// Backup is the base backup, the one started by the leader while
+	// it is pending
+	Backup    string `bson:"backup,omitempty" json:"backup,omitempty"`
+	UpdatedAt int64  `bson:"updated_at" json:"updated_at"`
+}
+
+// NewPITRRestart returns the pending restart if PITR was enabled
+// before the restore cmd.
+func NewPITRRestart(cmd *ctrl.RestoreCmd) *PITRRestart {
+	if !cmd.PITREnabled || cmd.UsersAndRolesOnly {
+		return nil
+	}
+
+	return &PITRRestart{
+		Status:    PITRRestartPending,
+		UpdatedAt: time.Now().Unix(),
+	}
+}
+
+// RebalanceProgress is the state of the chunks distribution over the shards
+// after the restore (see `pbm restore-finalize --rebalance`).
+type RebalanceProgress struct {
+	Status defs.Status `bson:"status" json:"status"`
+	Error  string      `bson:"error,omitempty" json:"error,omitempty"`
+	OPID   string      `bson:"opid" json:"opid"`
+	// MaxSkew is the allowed data skew in percent
+	MaxSkew     float64       `bson:"max_skew" json:"max_skew"`
+	Collections []RebalanceNS `bson:"collections" json:"collections"`
+	StartTS     int64         `bson:"start_ts" json:"start_ts"`
+	UpdatedAt   int64         `bson:"updated_at" json:"updated_at"`
+}
+
+type RebalanceNS struct {
+	NS    string `bson:"ns" json:"ns"`
+	Moved int    `bson:"moved" json:"moved"`
+	Split int    `bson:"split" json:"split"`
+	// Skew is the last measured data skew in percent
+	Skew float64 `bson:"skew" json:"skew"`
+	Done bool    `bson:"done" json:"done"`
 }
 
 type RestoreReplset struct {
@@ -42,9 +136,88 @@ type RestoreReplset struct {
 	LastWriteTS      primitive.Timestamp   `bson:"last_write_ts" json:"last_write_ts"`
 	Nodes            []RestoreNode         `bson:"nodes,omitempty" json:"nodes,omitempty"`
 	Error            string                `bson:"error,omitempty" json:"error,omitempty"`
+	ErrorCode        errors.Code           `bson:"error_code,omitempty" json:"error_code,omitempty"`
 	Conditions       Conditions            `bson:"conditions" json:"conditions"`
 	Hb               primitive.Timestamp   `bson:"hb" json:"hb"`
 	Stat             phys.RestoreShardStat `bson:"stat" json:"stat"`
+	OplogProgress    *OplogProgress        `bson:"oplog_progress,omitempty" json:"oplog_progress,omitempty"`
+	// Bytes is the size of the backup files read from the storage
+	// (logical restore). See BytesRestored.
+	Bytes      int64       `bson:"bytes,omitempty" json:"bytes,omitempty"`
+	CountCheck *CountCheck `bson:"count_check,omitempty" json:"count_check,omitempty"`
+	// IndexBuilds is the progress of the index builds of the logical restore
+	IndexBuilds *IndexBuildProgress `bson:"index_builds,omitempty" json:"index_builds,omitempty"`
+	// NSPriority is the namespace priority tier of the logical restore
+	NSPriority *NSPriorityProgress `bson:"ns_priority,omitempty" json:"ns_priority,omitempty"`
+	// NSLoad is the progress of the namespaces data load of the
+	// logical restore
+	NSLoad *NSLoadProgress `bson:"ns_load,omitempty" json:"ns_load,omitempty"`
+	// ParallelDBs is the data load of the databases by the parallel
+	// mongorestore instances of the logical restore
+	ParallelDBs *ParallelDBsProgress `bson:"parallel_dbs,omitempty" json:"parallel_dbs,omitempty"`
+	// Namespaces are the namespaces the logical restore writes to on
+	// the replset. Saved before the data is restored.
+	Namespaces []string `bson:"nss,omitempty" json:"nss,omitempty"`
+	// Principals are the users and roles changed by the users and
+	// roles only restore
+	Principals *PrincipalsChange `bson:"principals,omitempty" json:"principals,omitempty"`
+	// Transfers are the download statistics of the backup and oplog
+	// files of the logical restore
+	Transfers []storage.TransferStats `bson:"transfers,omitempty" json:"transfers,omitempty"`
+	// Failures are the failed writes of the logical restore
+	Failures *RestoreFailures `bson:"failures,omitempty" json:"failures,omitempty"`
+	// CollOptions is the result of re-asserting the backup collection
+	// options after the data load of the logical restore
+	CollOptions *CollOptionsResult `bson:"coll_options,omitempty" json:"coll_options,omitempty"`
+	// Profiling is the profiling turned off for the time of the
+	// logical restore
+	Profiling *ProfilingPause `bson:"profiling,omitempty" json:"profiling,omitempty"`
+	// Throttling is the flow control observed throttling the writes
+	// of the logical restore
+	Throttling *Throttling `bson:"throttling,omitempty" json:"throttling,omitempty"`
+}
+
+// CollOptionsResult are the collections of the replset with the options
+// changed to the backup ones and the ones that couldn't be changed.
+type CollOptionsResult struct {
+	Applied []CollOptionsChange `bson:"applied,omitempty" json:"applied,omitempty"`
+	Failed  []CollOptionsChange `bson:"failed,omitempty" json:"failed,omitempty"`
+}
+
+// CollOptionsChange is the change of the collection options. Options are
+// the names of the options (e.g. `validator`, `capped`).
+type CollOptionsChange struct {
+	NS      string   `bson:"ns" json:"ns"`
+	Options []string `bson:"options,omitempty" json:"options,omitempty"`
+	Error   string   `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// RestoreFailures are the failed writes of mongorestore on the replset.
+// Up to `restore.maxFailureDetails` of them are listed, the rest are
+// counted by the error code in Dropped.
+type RestoreFailures struct {
+	Total   int64                `bson:"total" json:"total"`
+	List    []snapshot.Failure   `bson:"list,omitempty" json:"list,omitempty"`
+	Dropped []snapshot.CodeCount `bson:"dropped,omitempty" json:"dropped,omitempty"`
+	// Namespaces are the failures by namespace as mongorestore
+	// reports them
+	Namespaces []snapshot.NSCount `bson:"nss,omitempty" json:"nss,omitempty"`
+}
+
+// OplogProgress is the state of the oplog replay on the replset.
+// It is updated on heartbeats and at each chunk boundary. LastTS stays
+// in place on failure, so a retry can start from there.
+type OplogProgress struct {
+	Chunk     string              `bson:"chunk" json:"chunk"`
+	ChunkNum  int                 `bson:"chunk_num" json:"chunk_num"`
+	Chunks    int                 `bson:"chunks" json:"chunks"`
+	LastTS    primitive.Timestamp `bson:"last_ts" json:"last_ts"`
+	TargetTS  primitive.Timestamp `bson:"target_ts" json:"target_ts"`
+	OpsPerSec float64             `bson:"ops_per_sec" json:"ops_per_sec"`
+	BytesLeft int64               `bson:"bytes_left" json:"bytes_left"`
+	// ETASec is the estimated time left. 0 if it's unknown yet.
+	ETASec    int64 `bson:"eta_sec" json:"eta_sec"`
+	UpdatedAt int64 `bson:"updated_at" json:"updated_at"`
 }
 
 type Condition struct {
@@ -53,6 +226,20 @@ type Condition struct {
 	Error     string      `bson:"error,omitempty" json:"error,omitempty"`
 }
 
+// FailureCode returns the code of the restore failure or the first one
+// of the failed replsets. "" if unknown.
+func (r *RestoreMeta) FailureCode() errors.Code {
+	if r.ErrorCode != "" {
+		return r.ErrorCode
+	}
+	for i := range r.Replsets {
+		if r.Replsets[i].ErrorCode != "" {
+			return r.Replsets[i].ErrorCode
+		}
+	}
+	return ""
+}
+
 type Conditions []*Condition
 
 func (b Conditions) Len() int           { return len(b) }
@@ -74,4 +261,23 @@ type RestoreNode struct {
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
 	Conditions       Conditions          `bson:"conditions" json:"conditions"`
 	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
+	Progress         *PhysNodeProgress   `bson:"progress,omitempty" json:"progress,omitempty"`
+}
+
+// PhysNodeProgress is the progress of the physical restore on the node.
+// Agents write it to the storage along with the heartbeats.
+type PhysNodeProgress struct {
+	// Phase is the current step of the restore on the node
+	Phase      string `json:"phase"`
+	Files      int    `json:"files"`
+	FilesTotal int    `json:"files_total"`
+	Bytes      int64  `json:"bytes"`
+	BytesTotal int64  `json:"bytes_total"`
+	// BytesPerSec is the average copy rate
+	BytesPerSec float64 `json:"bytes_per_sec,omitempty"`
+	// File is the last file started to copy
+	File string `json:"file,omitempty"`
+	// Oplog is the state of the PITR oplog replay (if any)
+	Oplog     *OplogProgress `json:"oplog,omitempty"`
+	UpdatedAt int64          `json:"updated_at"`
 }
@@ -0,0 +1,307 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/ctrl"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// PrincipalsChange is the users and roles changed by the users and roles
+// only restore. The principals are `<db>.<name>`.
+type PrincipalsChange struct {
+	Mode  string         `bson:"mode" json:"mode"`
+	Users PrincipalsDiff `bson:"users" json:"users"`
+	Roles PrincipalsDiff `bson:"roles" json:"roles"`
+	// Skipped are the PBM user and its roles. They are never changed.
+	Skipped []string `bson:"skipped,omitempty" json:"skipped,omitempty"`
+}
+
+type PrincipalsDiff struct {
+	Created []string `bson:"created,omitempty" json:"created,omitempty"`
+	Updated []string `bson:"updated,omitempty" json:"updated,omitempty"`
+	Deleted []string `bson:"deleted,omitempty" json:"deleted,omitempty"`
+}
+
+func (d PrincipalsDiff) String() string {
+	var s []string
+	if len(d.Created) != 0 {
+		s = append(s, "created "+strings.Join(d.Created, ", "))
+	}
+	if len(d.Updated) != 0 {
+		s = append(s, "updated "+strings.Join(d.Updated, ", "))
+	}
+	if len(d.Deleted) != 0 {
+		s = append(s, "deleted "+strings.Join(d.Deleted, ", "))
+	}
+	if len(s) == 0 {
+		return "unchanged"
+	}
+	return strings.Join(s, "; ")
+}
+
+func (c *PrincipalsChange) String() string {
+	s := c.Mode + ": users " + c.Users.String() + "; roles " + c.Roles.String()
+	if len(c.Skipped) != 0 {
+		s += "; skipped " + strings.Join(c.Skipped, ", ")
+	}
+	return s
+}
+
+// UsersAndRoles restores the users and roles of the backup without
+// the data. They are restored to the tmp collections and applied to the
+// users and roles of the replset (the config server of sharded clusters).
+// The PBM user and its roles are never changed.
+//
+//nolint:nonamedreturns
+func (r *Restore) UsersAndRoles(
+	ctx context.Context,
+	cmd *ctrl.RestoreCmd,
+	opid ctrl.OPID,
+	bcp *backup.BackupMeta,
+) (err error) {
+	l := log.LogEventFromContext(ctx)
+
+	defer func() { r.exit(log.Copy(context.Background(), ctx), err) }()
+
+	r.singleRS = cmd.Replset
+	r.sourceCluster = cmd.SourceCluster
+	r.initiator = cmd.Initiator
+	r.options = NewRestoreOptions(cmd)
+	err = r.init(ctx, cmd.Name, opid, l)
+	if err != nil {
+		return err
+	}
+
+	if util.IsSelective(bcp.Namespaces) {
+		return errors.New("selective backup has no users and roles")
+	}
+	if bcp.IsLegacyArchive() {
+		return errors.New("users and roles restore is not supported from legacy backup")
+	}
+	if err := CheckSourceCluster(bcp, cmd.SourceCluster); err != nil {
+		return err
+	}
+	if err := checkBackupKey(r.bcpStorageConf(bcp)); err != nil {
+		return err
+	}
+	r.bcpStg, err = util.StorageFromConfig(r.bcpStorageConf(bcp), r.brief.Me, r.log)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
+	nss := []string{
+		defs.DB + "." + defs.TmpUsersCollection,
+		defs.DB + "." + defs.TmpRolesCollection,
+	}
+	err = setRestoreBackup(ctx, r.leadConn, r.name, cmd.BackupName, nss)
+	if err != nil {
+		return errors.Wrap(err, "set backup name")
+	}
+	err = r.checkSnapshot(ctx, bcp, nss)
+	if err != nil {
+		return err
+	}
+	err = r.setShards(ctx, bcp)
+	if err != nil {
+		return err
+	}
+	if _, _, err = r.snapshotObjects(bcp); err != nil {
+		return err
+	}
+
+	err = r.toState(ctx, defs.StatusRunning, &defs.WaitActionStart)
+	if err != nil {
+		return err
+	}
+
+	own := util.MakeReverseRSMapFunc(r.rsMap)(r.brief.SetName)
+	rdr, err := snapshot.DownloadDump(
+		r.dumpDownload(r.bcpStorageConf(bcp), path.Join(bcp.Name, own)),
+		bcp.Compression,
+		r.log.Info,
+		util.MakeSelectedPred(nss),
+		r.numParallelColls)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	err = r.snapshot(ctx, rdr, snapshot.CloneNS{}, false, false, nil)
+	if err != nil {
+		return errors.Wrap(err, "mongorestore")
+	}
+
+	change, err := r.applyPrincipals(ctx, cmd.UsersAndRolesMode)
+	if err != nil {
+		err = topo.WriteConcernError(ctx, r.nodeConn, "apply users and roles", err)
+		return errors.Wrap(err, "apply users and roles")
+	}
+	r.log.Info("users and roles restored: %s", change)
+
+	if err := util.DropTMPcoll(ctx, r.nodeConn); err != nil {
+		r.log.Warning("drop tmp collections: %v", err)
+	}
+
+	err = SetRestoreRSPrincipals(ctx, r.leadConn, r.name, r.nodeInfo.SetName, change)
+	if err != nil {
+		return errors.Wrap(err, "save changed users and roles")
+	}
+
+	return r.Done(ctx)
+}
+
+// applyPrincipals applies the users and roles of the tmp collections.
+// The roles go first as the users refer to them.
+func (r *Restore) applyPrincipals(ctx context.Context, mode string) (*PrincipalsChange, error) {
+	if mode == "" {
+		mode = ctrl.UsersAndRolesMerge
+	}
+	replace := mode == ctrl.UsersAndRolesReplace
+
+	cusr, err := topo.CurrentUser(ctx, r.nodeConn)
+	if err != nil {
+		return nil, errors.Wrap(err, "get current user")
+	}
+	protected := make(map[string]bool)
+	for _, u := range cusr.Users {
+		protected[u.DB+"."+u.User] = true
+	}
+	for _, rl := range cusr.UserRoles {
+		protected[rl.DB+"."+rl.Role] = true
+	}
+	keep := func(id string) bool { return protected[id] }
+
+	rv := &PrincipalsChange{Mode: mode}
+	admin := r.adminDB()
+
+	var skipped []string
+	rv.Roles, skipped, err = applyPrincipalsColl(ctx,
+		admin.Collection(defs.TmpRolesCollection), admin.Collection("system.roles"), replace, keep)
+	if err != nil {
+		return nil, errors.Wrap(err, "roles")
+	}
+	rv.Skipped = append(rv.Skipped, skipped...)
+
+	rv.Users, skipped, err = applyPrincipalsColl(ctx,
+		admin.Collection(defs.TmpUsersCollection), admin.Collection("system.users"), replace, keep)
+	if err != nil {
+		return nil, errors.Wrap(err, "users")
+	}
+	rv.Skipped = append(rv.Skipped, skipped...)
+
+	return rv, nil
+}
+
+func applyPrincipalsColl(
+	ctx context.Context,
+	from *mongo.Collection,
+	to *mongo.Collection,
+	replace bool,
+	keep func(id string) bool,
+) (PrincipalsDiff, []string, error) {
+	bcp, err := readPrincipals(ctx, from)
+	if err != nil {
+		return PrincipalsDiff{}, nil, errors.Wrap(err, "read backup")
+	}
+	curr, err := readPrincipals(ctx, to)
+	if err != nil {
+		return PrincipalsDiff{}, nil, errors.Wrap(err, "read current")
+	}
+
+	p := planPrincipals(bcp, curr, replace, keep)
+	for _, id := range p.diff.Created {
+		if _, err := to.InsertOne(ctx, bcp[id]); err != nil {
+			return p.diff, p.skipped, errors.Wrapf(err, "create %s", id)
+		}
+	}
+	for _, id := range p.diff.Updated {
+		if _, err := to.ReplaceOne(ctx, bson.D{{"_id", id}}, bcp[id]); err != nil {
+			return p.diff, p.skipped, errors.Wrapf(err, "update %s", id)
+		}
+	}
+	for _, id := range p.diff.Deleted {
+		if _, err := to.DeleteOne(ctx, bson.D{{"_id", id}}); err != nil {
+			return p.diff, p.skipped, errors.Wrapf(err, "delete %s", id)
+		}
+	}
+
+	return p.diff, p.skipped, nil
+}
+
+// readPrincipals returns the documents of the users or roles collection
+// by their `_id` (`<db>.<name>`)
+func readPrincipals(ctx context.Context, c *mongo.Collection) (map[string]bson.Raw, error) {
+	cur, err := c.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+	defer cur.Close(ctx)
+
+	rv := make(map[string]bson.Raw)
+	for cur.Next(ctx) {
+		id, ok := cur.Current.Lookup("_id").StringValueOK()
+		if !ok {
+			continue
+		}
+		rv[id] = slices.Clone(cur.Current)
+	}
+
+	return rv, errors.Wrap(cur.Err(), "cursor")
+}
+
+type principalsPlan struct {
+	diff    PrincipalsDiff
+	skipped []string
+}
+
+// planPrincipals returns the principals to create and update from
+// the backup, and to delete (with replace) as they aren't in the backup.
+// The protected (kept) principals are skipped if they'd be changed.
+func planPrincipals(bcp, curr map[string]bson.Raw, replace bool, keep func(id string) bool) principalsPlan {
+	var p principalsPlan
+	for id, doc := range bcp {
+		c, ok := curr[id]
+		switch {
+		case ok && bytes.Equal(c, doc):
+			continue
+		case keep(id):
+			p.skipped = append(p.skipped, id)
+		case ok:
+			p.diff.Updated = append(p.diff.Updated, id)
+		default:
+			p.diff.Created = append(p.diff.Created, id)
+		}
+	}
+	if replace {
+		for id := range curr {
+			if _, ok := bcp[id]; ok {
+				continue
+			}
+			if keep(id) {
+				p.skipped = append(p.skipped, id)
+				continue
+			}
+			p.diff.Deleted = append(p.diff.Deleted, id)
+		}
+	}
+
+	slices.Sort(p.diff.Created)
+	slices.Sort(p.diff.Updated)
+	slices.Sort(p.diff.Deleted)
+	slices.Sort(p.skipped)
+	return p
+}
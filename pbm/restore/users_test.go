@@ -0,0 +1,57 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPlanPrincipals(t *testing.T) {
+	doc := func(id, pwd string) bson.Raw {
+		b, err := bson.Marshal(bson.D{{"_id", id}, {"pwd", pwd}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	bcp := map[string]bson.Raw{
+		"admin.alice": doc("admin.alice", "a"),
+		"app.bob":     doc("app.bob", "b"),
+		"admin.pbm":   doc("admin.pbm", "old"),
+		"app.carol":   doc("app.carol", "c"),
+	}
+	curr := map[string]bson.Raw{
+		"app.bob":   doc("app.bob", "changed"),
+		"admin.pbm": doc("admin.pbm", "new"),
+		"app.carol": doc("app.carol", "c"),
+		"app.dave":  doc("app.dave", "d"),
+	}
+	keep := func(id string) bool { return id == "admin.pbm" }
+
+	got := planPrincipals(bcp, curr, false, keep)
+	want := principalsPlan{
+		diff: PrincipalsDiff{
+			Created: []string{"admin.alice"},
+			Updated: []string{"app.bob"},
+		},
+		skipped: []string{"admin.pbm"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merge: got %+v, want %+v", got, want)
+	}
+
+	got = planPrincipals(bcp, curr, true, keep)
+	want.diff.Deleted = []string{"app.dave"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replace: got %+v, want %+v", got, want)
+	}
+
+	// the PBM user isn't deleted even if it isn't in the backup
+	delete(bcp, "admin.pbm")
+	got = planPrincipals(bcp, curr, true, keep)
+	if !reflect.DeepEqual(got.skipped, []string{"admin.pbm"}) || !reflect.DeepEqual(got.diff.Deleted, []string{"app.dave"}) {
+		t.Errorf("replace without PBM user: got %+v", got)
+	}
+}
@@ -0,0 +1,557 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+const (
+	// verifyMongodLog is the log of the temporary mongod in its dbpath
+	verifyMongodLog = "mongod.log"
+
+	verifyStartTimeout = 2 * time.Minute
+	verifyStopTimeout  = time.Minute
+	verifyDiskCheck    = 5 * time.Second
+)
+
+// VerifyOptions are the options of the restore verification on the node
+type VerifyOptions struct {
+	// Mongod is the binary of the temporary mongod
+	Mongod string
+	// Dir is where the temporary dbpath is created
+	Dir string
+	// MaxDisk is the max size of the temporary dbpath in bytes
+	MaxDisk     int64
+	MaxDuration time.Duration
+	// Sample is the number of random collections to verify. 0 is all.
+	Sample int
+	Node   string
+}
+
+func NewVerifyOptions(cfg *config.RestoreConf, node string, sample int) *VerifyOptions {
+	var v *config.RestoreVerifyConf
+	if cfg != nil {
+		v = cfg.Verify
+	}
+
+	return &VerifyOptions{
+		Mongod:      cfg.VerifyMongod(node),
+		Dir:         v.ScratchDir(),
+		MaxDisk:     v.MaxDisk(),
+		MaxDuration: v.Timeout(),
+		Sample:      sample,
+		Node:        node,
+	}
+}
+
+// CheckVerifiable returns an error if the backup can't be verified.
+// Only the logical backups can be restored into the temporary mongod.
+func CheckVerifiable(bcp *backup.BackupMeta) error {
+	if bcp.Status != defs.StatusDone {
+		return errors.Errorf("backup %q is %s, only done backups can be verified", bcp.Name, bcp.Status)
+	}
+	if bcp.Type != defs.LogicalBackup {
+		return errors.Errorf("backup %q is %s, only logical backups can be verified", bcp.Name, bcp.Type)
+	}
+	if bcp.IsLegacyArchive() {
+		return errors.Errorf("backup %q has the legacy layout and can't be verified", bcp.Name)
+	}
+
+	return nil
+}
+
+// CheckNode returns an error if the node can't run the verification:
+// the mongod binary isn't found or the Dir has less than MaxDisk free.
+func (o *VerifyOptions) CheckNode() error {
+	bin, err := exec.LookPath(o.Mongod)
+	if err != nil {
+		return errors.Wrapf(err, "find mongod %q", o.Mongod)
+	}
+	o.Mongod = bin
+
+	var st syscall.Statfs_t
+	err = syscall.Statfs(o.Dir, &st)
+	if err != nil {
+		return errors.Wrapf(err, "statfs %s", o.Dir)
+	}
+	free := int64(st.Bavail) * int64(st.Bsize) //nolint:unconvert
+	if free < o.MaxDisk {
+		return errors.Errorf("not enough disk space in %s: %s free, restore.verify.maxDiskMb is %s",
+			o.Dir, storage.PrettySize(free), storage.PrettySize(o.MaxDisk))
+	}
+
+	return nil
+}
+
+// verifyNS is the collection of the backup replset to verify
+type verifyNS struct {
+	rs string
+	ns string
+	// size is the size of the dumped documents
+	size int64
+	crc  int64
+	// docs is the number of the dumped documents, -1 if unknown
+	docs int64
+}
+
+// planVerify returns the collections to verify sorted by replset and
+// namespace. Only the user collections are verified (views and time series
+// have no data of their own). If sample is set, only sample collections
+// picked by shuffle are returned.
+func planVerify(
+	nss map[string][]*archive.Namespace,
+	stats map[string][]backup.NSStat,
+	sample int,
+	shuffle func(n int, swap func(i, j int)),
+) []verifyNS {
+	var rv []verifyNS
+	for rs, list := range nss {
+		docs := make(map[string]int64, len(stats[rs]))
+		for _, s := range stats[rs] {
+			docs[s.NS] = s.Docs
+		}
+
+		for _, ns := range list {
+			if isSystemDB(ns.Database) || strings.HasPrefix(ns.Collection, "system.") {
+				continue
+			}
+			if ns.Type != "" && ns.Type != "collection" {
+				continue
+			}
+
+			c := verifyNS{
+				rs:   rs,
+				ns:   archive.NSify(ns.Database, ns.Collection),
+				size: ns.Size,
+				crc:  ns.CRC,
+				docs: -1,
+			}
+			if n, ok := docs[c.ns]; ok {
+				c.docs = n
+			}
+			rv = append(rv, c)
+		}
+	}
+
+	sortVerifyNS(rv)
+	if sample > 0 && sample < len(rv) {
+		shuffle(len(rv), func(i, j int) { rv[i], rv[j] = rv[j], rv[i] })
+		rv = rv[:sample]
+		sortVerifyNS(rv)
+	}
+
+	return rv
+}
+
+func sortVerifyNS(nss []verifyNS) {
+	sort.Slice(nss, func(i, j int) bool {
+		if nss[i].rs != nss[j].rs {
+			return nss[i].rs < nss[j].rs
+		}
+		return nss[i].ns < nss[j].ns
+	})
+}
+
+// verifySize returns the size of the largest replset of the plan.
+// Replsets are restored one by one, so it is the data the dbpath holds.
+func verifySize(plan []verifyNS) int64 {
+	sizes := make(map[string]int64)
+	var rv int64
+	for _, c := range plan {
+		sizes[c.rs] += c.size
+		rv = max(rv, sizes[c.rs])
+	}
+
+	return rv
+}
+
+// verifyIssues compares the restored collection with the backup metadata
+func verifyIssues(c verifyNS, docs, crc int64) []backup.ConsistencyIssue {
+	var rv []backup.ConsistencyIssue
+	if c.docs >= 0 && docs != c.docs {
+		rv = append(rv, backup.ConsistencyIssue{
+			RS:  c.rs,
+			NS:  c.ns,
+			Msg: fmt.Sprintf("restored %d documents, %d in the backup", docs, c.docs),
+		})
+	}
+	if crc != c.crc {
+		rv = append(rv, backup.ConsistencyIssue{
+			RS:  c.rs,
+			NS:  c.ns,
+			Msg: fmt.Sprintf("checksum of the restored documents %x, %x in the backup", uint64(crc), uint64(c.crc)),
+		})
+	}
+
+	return rv
+}
+
+// Verify restores the collections of the backup into a temporary mongod
+// and compares them with the backup metadata. The replsets are restored
+// one after another into the same mongod which data is dropped in between.
+// It returns the number of verified collections and the mismatches found.
+// The mongod is stopped and its dbpath removed in any case.
+func Verify(
+	ctx context.Context,
+	cfg *config.Config,
+	bcp *backup.BackupMeta,
+	o *VerifyOptions,
+	l log.LogEvent,
+) (int, []backup.ConsistencyIssue, error) {
+	stgs := make(map[string]storage.Storage, len(bcp.Replsets))
+	nss := make(map[string][]*archive.Namespace, len(bcp.Replsets))
+	stats := make(map[string][]backup.NSStat, len(bcp.Replsets))
+	for _, rs := range bcp.Replsets {
+		stg, err := util.StorageFromConfig(&bcp.RSStorage(rs.Name).StorageConf, o.Node, l)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "get storage")
+		}
+		list, err := backup.ReadArchiveNamespaces(stg, rs.DumpName)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "read namespaces of %s", rs.Name)
+		}
+
+		stgs[rs.Name] = stg
+		nss[rs.Name] = list
+		stats[rs.Name] = rs.NSStats
+	}
+
+	plan := planVerify(nss, stats, o.Sample, rand.Shuffle)
+	if len(plan) == 0 {
+		l.Info("no collections to verify")
+		return 0, nil, nil
+	}
+	if need := verifySize(plan); need > o.MaxDisk {
+		return 0, nil, errors.Errorf("the restored data needs about %s, restore.verify.maxDiskMb is %s",
+			storage.PrettySize(need), storage.PrettySize(o.MaxDisk))
+	}
+
+	dbpath, err := os.MkdirTemp(o.Dir, "pbm-verify-")
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "create dbpath")
+	}
+	defer func() {
+		if err := os.RemoveAll(dbpath); err != nil {
+			l.Warning("remove dbpath %s: %v", dbpath, err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	mgo, err := startVerifyMongod(ctx, o.Mongod, dbpath)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "start mongod")
+	}
+	defer mgo.stop(l)
+	l.Info("started mongod on port %d, dbpath %s", mgo.port, dbpath)
+
+	// mongorestore doesn't stop on the context, so the mongod is killed
+	go mgo.watch(ctx, cancel, o.MaxDisk)
+
+	var issues []backup.ConsistencyIssue
+	for i := 0; i < len(plan); {
+		rs := plan[i].rs
+		j := i
+		for j < len(plan) && plan[j].rs == rs {
+			j++
+		}
+		colls := plan[i:j]
+		i = j
+
+		l.Info("restoring %d collections of %s", len(colls), rs)
+		err := mgo.restore(ctx, cfg, bcp, rs, stgs[rs], colls)
+		if err == nil {
+			var found []backup.ConsistencyIssue
+			found, err = mgo.check(ctx, colls)
+			issues = append(issues, found...)
+		}
+		if err == nil {
+			err = mgo.dropData(ctx, colls)
+		}
+		if err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				err = cause
+			}
+			return 0, nil, errors.Wrap(err, rs)
+		}
+	}
+
+	return len(plan), issues, nil
+}
+
+// verifyMongod is the temporary mongod of the verification
+type verifyMongod struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	port   int
+	dbpath string
+	conn   *mongo.Client
+}
+
+func startVerifyMongod(ctx context.Context, bin, dbpath string) (*verifyMongod, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "find a free port")
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	m := &verifyMongod{
+		cmd: exec.Command(bin,
+			"--dbpath", dbpath,
+			"--port", strconv.Itoa(port),
+			"--bind_ip", "localhost",
+			"--logpath", path.Join(dbpath, verifyMongodLog),
+			"--setParameter", "diagnosticDataCollectionEnabled=false"),
+		exited: make(chan struct{}),
+		port:   port,
+		dbpath: dbpath,
+	}
+	errBuf := &bytes.Buffer{}
+	m.cmd.Stderr = errBuf
+	err = m.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = m.cmd.Wait()
+		close(m.exited)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, verifyStartTimeout)
+	defer cancel()
+	for {
+		m.conn, err = connect.MongoConnect(ctx, fmt.Sprintf("mongodb://localhost:%d", port),
+			connect.AppName("pbm-verify-restore"),
+			connect.Direct(true),
+			connect.WriteConcern(writeconcern.W1()),
+			connect.ServerSelectionTimeout(time.Second*5),
+		)
+		if err == nil {
+			return m, nil
+		}
+
+		select {
+		case <-m.exited:
+			return nil, errors.Errorf("mongod has exited: %s (see %s)",
+				strings.TrimSpace(errBuf.String()), path.Join(dbpath, verifyMongodLog))
+		case <-ctx.Done():
+			m.kill()
+			return nil, errors.Wrap(err, "connect")
+		default:
+		}
+	}
+}
+
+func (m *verifyMongod) kill() {
+	_ = m.cmd.Process.Kill()
+	<-m.exited
+}
+
+// stop shuts the mongod down. It is killed if not down in time.
+func (m *verifyMongod) stop(l log.LogEvent) {
+	select {
+	case <-m.exited:
+		return
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), verifyStopTimeout)
+	defer cancel()
+	err := m.conn.Database("admin").RunCommand(ctx, bson.D{{"shutdown", 1}, {"force", true}}).Err()
+	if err != nil && !strings.Contains(err.Error(), "socket was unexpectedly closed") {
+		l.Debug("shutdown mongod: %v", err)
+	}
+	_ = m.conn.Disconnect(ctx)
+
+	select {
+	case <-m.exited:
+	case <-ctx.Done():
+		l.Warning("mongod is not down in %v, killing it", verifyStopTimeout)
+		m.kill()
+	}
+}
+
+// watch kills the mongod once the dbpath outgrows the maxDisk or
+// the context is done.
+func (m *verifyMongod) watch(ctx context.Context, cancel context.CancelCauseFunc, maxDisk int64) {
+	tk := time.NewTicker(verifyDiskCheck)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.kill()
+			return
+		case <-m.exited:
+			return
+		case <-tk.C:
+		}
+
+		size, err := dirSize(m.dbpath)
+		if err != nil || size <= maxDisk {
+			continue
+		}
+		cancel(errors.Errorf("the dbpath has outgrown restore.verify.maxDiskMb: %s of %s",
+			storage.PrettySize(size), storage.PrettySize(maxDisk)))
+	}
+}
+
+func dirSize(dir string) (int64, error) {
+	var rv int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// files are created and removed by mongod meanwhile
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rv += fi.Size()
+		return nil
+	})
+
+	return rv, err
+}
+
+// restore restores the collections of the backup replset rs
+func (m *verifyMongod) restore(
+	ctx context.Context,
+	cfg *config.Config,
+	bcp *backup.BackupMeta,
+	rs string,
+	stg storage.Storage,
+	colls []verifyNS,
+) error {
+	selected := make(map[string]bool, len(colls))
+	for _, c := range colls {
+		selected[c.ns] = true
+	}
+
+	rdr, err := snapshot.DownloadDump(
+		func(ns string) (io.ReadCloser, error) {
+			return stg.SourceReader(path.Join(bcp.Name, rs, ns))
+		},
+		bcp.Compression,
+		log.LogEventFromContext(ctx).Info,
+		func(ns string) bool { return selected[ns] },
+		1)
+	if err != nil {
+		return errors.Wrap(err, "download")
+	}
+	defer rdr.Close()
+
+	// a single insertion worker keeps the documents in the dump order,
+	// so the checksums of the natural order are comparable
+	rf, err := snapshot.NewRestore(fmt.Sprintf("mongodb://localhost:%d", m.port),
+		cfg, snapshot.CloneNS{}, 1, 1, 0, false, false, false, false, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "create mongorestore")
+	}
+	_, err = rf.ReadFrom(rdr)
+	if err != nil {
+		return errors.Wrap(err, "mongorestore")
+	}
+
+	return ctx.Err()
+}
+
+// check compares the restored collections with the backup metadata
+func (m *verifyMongod) check(ctx context.Context, colls []verifyNS) ([]backup.ConsistencyIssue, error) {
+	var rv []backup.ConsistencyIssue
+	for _, c := range colls {
+		db, name, _ := strings.Cut(c.ns, ".")
+		coll := m.conn.Database(db).Collection(name)
+
+		docs, err := coll.CountDocuments(ctx, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "count %s", c.ns)
+		}
+		crc, err := checksum(ctx, coll)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checksum %s", c.ns)
+		}
+
+		rv = append(rv, verifyIssues(c, docs, crc)...)
+	}
+
+	return rv, nil
+}
+
+// checksum is the checksum of the documents in the natural order as
+// the logical backup computes it
+func checksum(ctx context.Context, coll *mongo.Collection) (int64, error) {
+	cur, err := coll.Find(ctx, bson.D{}, options.Find().SetHint(bson.D{{"$natural", 1}}))
+	if err != nil {
+		return 0, errors.Wrap(err, "find")
+	}
+	defer cur.Close(ctx)
+
+	crc := crc64.New(crc64.MakeTable(crc64.ECMA))
+	for cur.Next(ctx) {
+		crc.Write(cur.Current)
+	}
+
+	return int64(crc.Sum64()), errors.Wrap(cur.Err(), "cursor")
+}
+
+// dropData drops the databases of the collections to free the space
+// for the next replset
+func (m *verifyMongod) dropData(ctx context.Context, colls []verifyNS) error {
+	dropped := make(map[string]bool)
+	for _, c := range colls {
+		db, _, _ := strings.Cut(c.ns, ".")
+		if dropped[db] {
+			continue
+		}
+		err := m.conn.Database(db).Drop(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "drop %s", db)
+		}
+		dropped[db] = true
+	}
+
+	return nil
+}
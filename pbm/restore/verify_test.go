@@ -0,0 +1,81 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	mtArchive "github.com/mongodb/mongo-tools/common/archive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+)
+
+func TestPlanVerify(t *testing.T) {
+	ns := func(db, coll, typ string, size, crc int64) *archive.Namespace {
+		return &archive.Namespace{
+			CollectionMetadata: &mtArchive.CollectionMetadata{Database: db, Collection: coll, Type: typ},
+			Size:               size,
+			CRC:                crc,
+		}
+	}
+
+	nss := map[string][]*archive.Namespace{
+		"rs1": {
+			ns("app", "users", "collection", 100, 1),
+			ns("app", "active", "view", 0, 0),
+			ns("app", "system.js", "collection", 10, 2),
+			ns("admin", "system.users", "collection", 10, 3),
+			ns("config", "chunks", "collection", 10, 4),
+		},
+		"rs0": {
+			ns("app", "orders", "collection", 300, 5),
+			ns("app", "users", "collection", 50, 6),
+			ns("metrics", "cpu", "timeseries", 20, 7),
+		},
+	}
+	stats := map[string][]backup.NSStat{
+		"rs0": {{NS: "app.orders", Docs: 30}, {NS: "app.users", Docs: 5}},
+	}
+
+	got := planVerify(nss, stats, 0, nil)
+	want := []verifyNS{
+		{rs: "rs0", ns: "app.orders", size: 300, crc: 5, docs: 30},
+		{rs: "rs0", ns: "app.users", size: 50, crc: 6, docs: 5},
+		{rs: "rs1", ns: "app.users", size: 100, crc: 1, docs: -1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("all: got %+v, want %+v", got, want)
+	}
+	if s := verifySize(got); s != 350 {
+		t.Errorf("size: got %d, want 350", s)
+	}
+
+	reverse := func(n int, swap func(i, j int)) {
+		for i := 0; i < n/2; i++ {
+			swap(i, n-1-i)
+		}
+	}
+	got = planVerify(nss, stats, 2, reverse)
+	want = []verifyNS{want[1], want[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sample: got %+v, want %+v", got, want)
+	}
+}
+
+func TestVerifyIssues(t *testing.T) {
+	c := verifyNS{rs: "rs0", ns: "app.users", crc: 42, docs: 5}
+
+	if got := verifyIssues(c, 5, 42); len(got) != 0 {
+		t.Errorf("match: got %v", got)
+	}
+	if got := verifyIssues(c, 4, 41); len(got) != 2 {
+		t.Errorf("mismatch: got %v", got)
+	}
+
+	// the count is unknown without the stats of the backup
+	c.docs = -1
+	got := verifyIssues(c, 4, 42)
+	if len(got) != 0 {
+		t.Errorf("unknown count: got %v", got)
+	}
+}
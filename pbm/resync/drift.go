@@ -0,0 +1,254 @@
+package resync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// DriftGrace is how old changes have to be to be considered as a drift.
+// It keeps the reconciler off files being copied to the storage
+// and backups that are finishing right now.
+const DriftGrace = 10 * time.Minute
+
+const driftID = "main"
+
+// Drift is the result of the last reconciliation of the backups metadata
+// with the main storage.
+//
+// Added and Missing are backups which are still out of sync after the
+// reconciliation: found only on the storage and only in the database respectively.
+// Imported and Expired are backups whose metadata has been fixed by it.
+type Drift struct {
+	ID       string            `bson:"_id" json:"-"`
+	Mode     config.ResyncMode `bson:"mode" json:"mode"`
+	Node     string            `bson:"node" json:"node"`
+	Checked  int64             `bson:"checked" json:"checked"`
+	Added    []string          `bson:"added,omitempty" json:"added,omitempty"`
+	Missing  []string          `bson:"missing,omitempty" json:"missing,omitempty"`
+	Imported []string          `bson:"imported,omitempty" json:"imported,omitempty"`
+	Expired  []string          `bson:"expired,omitempty" json:"expired,omitempty"`
+	Err      string            `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// Detected returns true if the storage is out of sync with the metadata.
+func (d *Drift) Detected() bool {
+	return len(d.Added) != 0 || len(d.Missing) != 0
+}
+
+// GetDrift returns the last reconciliation result.
+// It returns mongo.ErrNoDocuments if there was none.
+func GetDrift(ctx context.Context, conn connect.Client) (*Drift, error) {
+	d := &Drift{}
+	err := conn.StorageDriftCollection().FindOne(ctx, bson.D{{"_id", driftID}}).Decode(d)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "query")
+	}
+
+	return d, nil
+}
+
+// SaveDrift replaces the last reconciliation result.
+func SaveDrift(ctx context.Context, conn connect.Client, d *Drift) error {
+	d.ID = driftID
+	_, err := conn.StorageDriftCollection().ReplaceOne(ctx,
+		bson.D{{"_id", driftID}},
+		d,
+		options.Replace().SetUpsert(true))
+	return errors.Wrap(err, "replace")
+}
+
+type backupRef struct {
+	Name             string      `bson:"name"`
+	Status           defs.Status `bson:"status"`
+	LastTransitionTS int64       `bson:"last_transition_ts"`
+}
+
+// DetectDrift compares the metadata files on the main storage with the
+// backups in the database. Only the storage list is read, metadata files
+// are not downloaded.
+func DetectDrift(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	now time.Time,
+) ([]string, []string, error) {
+	files, err := stg.List("", defs.MetadataFileSuffix)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "list storage")
+	}
+
+	cur, err := conn.BcpCollection().Find(ctx,
+		bson.D{{"store.profile", nil}, notImported},
+		options.Find().SetProjection(bson.D{
+			{"name", 1},
+			{"status", 1},
+			{"last_transition_ts", 1},
+		}))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "query backups")
+	}
+
+	var bcps []backupRef
+	err = cur.All(ctx, &bcps)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode backups")
+	}
+
+	added, missing := compareMeta(files, bcps, now)
+	return added, missing, nil
+}
+
+// compareMeta returns backups which are only on the storage (added) and
+// done backups which are only in the database (missing).
+// Changes younger than DriftGrace are ignored.
+func compareMeta(files []storage.FileInfo, bcps []backupRef, now time.Time) ([]string, []string) {
+	onStorage := make(map[string]bool, len(files))
+	var added []string
+	for _, f := range files {
+		if strings.Contains(f.Name, "/") {
+			continue
+		}
+
+		name := strings.TrimSuffix(f.Name, defs.MetadataFileSuffix)
+		onStorage[name] = true
+		if !f.ModTime.IsZero() && now.Sub(f.ModTime) < DriftGrace {
+			continue
+		}
+		added = append(added, name)
+	}
+
+	inDB := make(map[string]bool, len(bcps))
+	var missing []string
+	for _, b := range bcps {
+		inDB[b.Name] = true
+		if onStorage[b.Name] || b.Status != defs.StatusDone {
+			continue
+		}
+		if now.Sub(time.Unix(b.LastTransitionTS, 0)) < DriftGrace {
+			continue
+		}
+		missing = append(missing, b.Name)
+	}
+
+	rv := added[:0]
+	for _, name := range added {
+		if !inDB[name] {
+			rv = append(rv, name)
+		}
+	}
+
+	sort.Strings(rv)
+	sort.Strings(missing)
+	return rv, missing
+}
+
+// ApplyDrift imports backups added to the storage and deletes the database
+// metadata of backups removed from the storage. It never deletes storage data.
+// Imported backups are marked with backup.ProvenanceResync.
+//
+// The drift is updated with the applied changes. Backups which couldn't be
+// fixed are left in Added and Missing.
+func ApplyDrift(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	cfg *config.StorageConf,
+	d *Drift,
+) {
+	l := log.LogEventFromContext(ctx)
+
+	var added []string
+	for _, name := range d.Added {
+		err := importBackup(ctx, conn, stg, cfg, name, d.Node)
+		if err != nil {
+			l.Error("import backup %s: %v", name, err)
+			added = append(added, name)
+			continue
+		}
+		l.Info("imported backup %s from the storage", name)
+		d.Imported = append(d.Imported, name)
+	}
+	d.Added = added
+
+	var missing []string
+	for _, name := range d.Missing {
+		err := expireBackup(ctx, conn, stg, name)
+		if err != nil {
+			l.Error("expire backup %s: %v", name, err)
+			missing = append(missing, name)
+			continue
+		}
+		l.Info("removed metadata of backup %s deleted from the storage", name)
+		d.Expired = append(d.Expired, name)
+	}
+	d.Missing = missing
+}
+
+func importBackup(
+	ctx context.Context,
+	conn connect.Client,
+	stg storage.Storage,
+	cfg *config.StorageConf,
+	name string,
+	node string,
+) error {
+	meta, err := backup.ReadMetadata(stg, name+defs.MetadataFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "read metadata")
+	}
+
+	err = backup.CheckBackupDataFiles(ctx, stg, meta)
+	if err != nil {
+		log.LogEventFromContext(ctx).Warning("backup %s: %v", meta.Name, err)
+		meta.Status = defs.StatusError
+		meta.Err = err.Error()
+	}
+
+	// overwriting config allows PBM to download files from the current deployment
+	meta.Store = backup.Storage{StorageConf: *cfg}
+	meta.Provenance = &backup.Provenance{
+		Source: backup.ProvenanceResync,
+		Node:   node,
+		Time:   time.Now().Unix(),
+	}
+
+	// don't overwrite a backup which has appeared in the meantime
+	_, err = conn.BcpCollection().InsertOne(ctx, meta)
+	return errors.Wrap(err, "insert")
+}
+
+func expireBackup(ctx context.Context, conn connect.Client, stg storage.Storage, name string) error {
+	// the list might be inconsistent, double-check the file is gone
+	_, err := stg.FileStat(name + defs.MetadataFileSuffix)
+	if err == nil {
+		return errors.New("metadata file exists on the storage")
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "check metadata file")
+	}
+
+	_, err = conn.BcpCollection().DeleteOne(ctx, bson.D{
+		{"name", name},
+		{"store.profile", nil},
+		{"status", defs.StatusDone},
+		notImported,
+	})
+	return errors.Wrap(err, "delete metadata")
+}
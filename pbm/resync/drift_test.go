@@ -0,0 +1,43 @@
+package resync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+func TestCompareMeta(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+
+	files := []storage.FileInfo{
+		{Name: "b1" + defs.MetadataFileSuffix, ModTime: old},
+		{Name: "b2" + defs.MetadataFileSuffix, ModTime: old},
+		{Name: "b3" + defs.MetadataFileSuffix},                   // unknown mod time
+		{Name: "b4" + defs.MetadataFileSuffix, ModTime: now},     // being copied
+		{Name: "dir/b5" + defs.MetadataFileSuffix, ModTime: old}, // not a backup
+	}
+	bcps := []backupRef{
+		{Name: "b1", Status: defs.StatusDone, LastTransitionTS: old.Unix()},
+		{Name: "b6", Status: defs.StatusDone, LastTransitionTS: old.Unix()},
+		{Name: "b7", Status: defs.StatusDone, LastTransitionTS: now.Unix()},    // just finished
+		{Name: "b8", Status: defs.StatusError, LastTransitionTS: old.Unix()},   // no metadata file
+		{Name: "b9", Status: defs.StatusRunning, LastTransitionTS: old.Unix()}, // in progress
+	}
+
+	added, missing := compareMeta(files, bcps, now)
+	if want := []string{"b2", "b3"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added: got %v, want %v", added, want)
+	}
+	if want := []string{"b6"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing: got %v, want %v", missing, want)
+	}
+
+	added, missing = compareMeta(nil, nil, now)
+	if len(added) != 0 || len(missing) != 0 {
+		t.Errorf("empty: got %v, %v", added, missing)
+	}
+}
@@ -0,0 +1,56 @@
+package resync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestResyncKeepsHold(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hold is set by another cluster sharing the storage
+	hold := &backup.BackupHold{By: "admin@admin (root@other-cluster, pbm 2.8.0)", At: 1760000000}
+	for _, m := range []*backup.BackupMeta{
+		{Name: "held", Type: defs.LogicalBackup, Status: defs.StatusDone, Hold: hold},
+		{Name: "free", Type: defs.LogicalBackup, Status: defs.StatusDone},
+	} {
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = stg.Save(m.Name+defs.MetadataFileSuffix, bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bcps, err := getAllBackupMetaFromStorage(context.Background(), stg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bcps) != 2 {
+		t.Fatalf("want 2 backups, got %d", len(bcps))
+	}
+
+	for _, b := range bcps {
+		switch b.Name {
+		case "held":
+			if b.Hold == nil || *b.Hold != *hold {
+				t.Errorf("held: want hold %v, got %v", hold, b.Hold)
+			}
+		case "free":
+			if b.Hold != nil {
+				t.Errorf("free: unexpected hold %v", b.Hold)
+			}
+		}
+	}
+}
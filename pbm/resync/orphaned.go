@@ -0,0 +1,292 @@
+package resync
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+// the done and orphaned backups which files are in the storage root
+var orphanCandidates = bson.D{
+	{"status", bson.M{"$in": bson.A{defs.StatusDone, defs.StatusOrphaned}}},
+	notImported,
+}
+
+type storeRef struct {
+	Name     string           `bson:"name"`
+	Status   defs.Status      `bson:"status"`
+	Store    backup.Storage   `bson:"store"`
+	Orphaned *backup.Orphaned `bson:"orphaned,omitempty"`
+}
+
+// currentStorage is the storage a backup belongs to as it's configured now
+type currentStorage struct {
+	// conf is nil if the profile is removed
+	conf *config.StorageConf
+	stg  storage.Storage
+}
+
+// CheckOrphaned verifies that the metadata files of the done and orphaned
+// backups are on the storages configured now: the main or the profile one.
+// The backups recorded with another storage config which metadata file is
+// missed there are marked as orphaned, the orphaned backups found again are
+// returned to their status. Only the metadata files are checked (by stat).
+// Imported backups aren't checked.
+//
+// It returns the names of the newly orphaned and recovered backups.
+func CheckOrphaned(ctx context.Context, conn connect.Client, node string, now time.Time) ([]string, []string, error) {
+	l := log.LogEventFromContext(ctx)
+
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get config")
+	}
+
+	cur, err := conn.BcpCollection().Find(ctx, orphanCandidates,
+		options.Find().SetProjection(bson.D{
+			{"name", 1},
+			{"status", 1},
+			{"store", 1},
+			{"orphaned", 1},
+		}))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "query backups")
+	}
+
+	var bcps []storeRef
+	err = cur.All(ctx, &bcps)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "decode backups")
+	}
+
+	stgs := make(map[string]*currentStorage)
+	var orphaned, recovered []string
+	for i := range bcps {
+		b := &bcps[i]
+		profile := storeProfile(&b.Store)
+
+		cs, ok := stgs[profile]
+		if !ok {
+			cs, err = getCurrentStorage(ctx, conn, cfg, profile, node)
+			if err != nil {
+				l.Warning("skip backups of the %s storage: %v", storageName(profile), err)
+			}
+			stgs[profile] = cs
+		}
+		if cs == nil {
+			continue
+		}
+
+		reason, err := orphanReason(&b.Store, cs, b.Name)
+		if err != nil {
+			l.Warning("backup %s: check metadata file: %v", b.Name, err)
+			continue
+		}
+
+		switch {
+		case reason != "" && b.Status != defs.StatusOrphaned:
+			o := &backup.Orphaned{
+				Status:   b.Status,
+				Storage:  profile,
+				Location: b.Store.Path(),
+				Reason:   reason,
+				At:       now.Unix(),
+			}
+			err = backup.SetOrphaned(ctx, conn, b.Name, o)
+			if err != nil {
+				l.Error("mark backup %s as orphaned: %v", b.Name, err)
+				continue
+			}
+			l.Warning("backup %s is orphaned: %s", b.Name, o)
+			orphaned = append(orphaned, b.Name)
+		case reason == "" && b.Status == defs.StatusOrphaned:
+			store := b.Store
+			store.StorageConf = *cs.conf
+			err = backup.RecoverOrphaned(ctx, conn, b.Name, b.Orphaned, &store)
+			if err != nil {
+				l.Error("recover orphaned backup %s: %v", b.Name, err)
+				continue
+			}
+			l.Info("orphaned backup %s is found on the storage %s", b.Name, cs.conf.Path())
+			recovered = append(recovered, b.Name)
+		}
+	}
+
+	return orphaned, recovered, nil
+}
+
+// storeProfile returns the profile name of the storage. Empty for the main one.
+func storeProfile(s *backup.Storage) string {
+	if !s.IsProfile {
+		return ""
+	}
+	return s.Name
+}
+
+func storageName(profile string) string {
+	if profile == "" {
+		return "main"
+	}
+	return profile
+}
+
+func getCurrentStorage(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.Config,
+	profile string,
+	node string,
+) (*currentStorage, error) {
+	conf := &cfg.Storage
+	if profile != "" {
+		p, err := config.GetProfile(ctx, conn, profile)
+		if err != nil {
+			if errors.Is(err, config.ErrMissedConfigProfile) {
+				return &currentStorage{}, nil
+			}
+			return nil, errors.Wrap(err, "get profile")
+		}
+		conf = &p.Storage
+	}
+
+	stg, err := util.StorageFromConfig(conf, node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+
+	return &currentStorage{conf: conf, stg: stg}, nil
+}
+
+// orphanReason returns why the backup recorded with the storage store
+// is orphaned from the storage configured now. Empty if it isn't.
+// The metadata file is looked for only if the storage is changed.
+func orphanReason(store *backup.Storage, cs *currentStorage, name string) (string, error) {
+	if cs.conf == nil {
+		return "the storage profile is removed", nil
+	}
+	if store.StorageConf.Equal(cs.conf) {
+		return "", nil
+	}
+
+	_, err := cs.stg.FileStat(name + defs.MetadataFileSuffix)
+	if err == nil {
+		return "", nil
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		return missedMetaReason(cs.conf), nil
+	}
+	return "", err
+}
+
+func missedMetaReason(conf *config.StorageConf) string {
+	return "no metadata file on the storage " + conf.Path()
+}
+
+// storageChangedBackups returns the done and orphaned backups of the
+// profile storage recorded with another storage config than cfg.
+func storageChangedBackups(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.StorageConf,
+	profile string,
+) ([]*backup.BackupMeta, error) {
+	filter := bson.D{{"store.profile", nil}}
+	if profile != "" {
+		filter = bson.D{{"store.profile", true}, {"store.name", profile}}
+	}
+	filter = append(filter, orphanCandidates...)
+
+	cur, err := conn.BcpCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var bcps []*backup.BackupMeta
+	err = cur.All(ctx, &bcps)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	rv := bcps[:0]
+	for _, b := range bcps {
+		if !b.Store.StorageConf.Equal(cfg) {
+			rv = append(rv, b)
+		}
+	}
+	return rv, nil
+}
+
+// keepOrphaned inserts back the backups of the changed storage (see
+// storageChangedBackups) which aren't on the synced storage cfg
+// as orphaned.
+func keepOrphaned(
+	ctx context.Context,
+	conn connect.Client,
+	prev []*backup.BackupMeta,
+	synced []*backup.BackupMeta,
+	cfg *config.StorageConf,
+	now time.Time,
+) {
+	l := log.LogEventFromContext(ctx)
+
+	onStorage := make(map[string]bool, len(synced))
+	for _, b := range synced {
+		onStorage[b.Name] = true
+	}
+
+	for _, b := range orphanedOf(prev, onStorage, cfg, now) {
+		_, err := conn.BcpCollection().InsertOne(ctx, b)
+		if err != nil {
+			if !mongo.IsDuplicateKeyError(err) {
+				l.Error("keep orphaned backup %s: %v", b.Name, err)
+			}
+			continue
+		}
+		l.Warning("backup %s is orphaned: %s", b.Name, b.Orphaned)
+	}
+}
+
+// orphanedOf marks the backups of prev which aren't on the storage cfg as
+// orphaned and returns them.
+func orphanedOf(
+	prev []*backup.BackupMeta,
+	onStorage map[string]bool,
+	cfg *config.StorageConf,
+	now time.Time,
+) []*backup.BackupMeta {
+	var rv []*backup.BackupMeta
+	for _, b := range prev {
+		if onStorage[b.Name] {
+			continue
+		}
+
+		if b.Orphaned == nil {
+			status := b.Status
+			if status == defs.StatusOrphaned {
+				status = defs.StatusDone
+			}
+			b.Orphaned = &backup.Orphaned{
+				Status:   status,
+				Storage:  storeProfile(&b.Store),
+				Location: b.Store.Path(),
+				Reason:   missedMetaReason(cfg),
+				At:       now.Unix(),
+			}
+			b.Status = defs.StatusOrphaned
+		}
+		rv = append(rv, b)
+	}
+	return rv
+}
@@ -0,0 +1,85 @@
+package resync
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func fsStorageConf(path string) config.StorageConf {
+	return config.StorageConf{Type: storage.Filesystem, Filesystem: &fs.Config{Path: path}}
+}
+
+func TestOrphanReason(t *testing.T) {
+	newPath := t.TempDir()
+	stg, err := fs.New(&fs.Config{Path: newPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stg.Save("copied"+defs.MetadataFileSuffix, bytes.NewReader([]byte("{}")), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	curr := fsStorageConf(newPath)
+	cs := &currentStorage{conf: &curr, stg: stg}
+	old := &backup.Storage{StorageConf: fsStorageConf("/old")}
+
+	testCases := []struct {
+		name  string
+		store *backup.Storage
+		cs    *currentStorage
+		want  string
+	}{
+		{"same", &backup.Storage{StorageConf: fsStorageConf(newPath)}, cs, ""},
+		{"copied", old, cs, ""},
+		{"gone", old, cs, "no metadata file on the storage " + newPath},
+		{"removed", &backup.Storage{Name: "p", IsProfile: true}, &currentStorage{}, "the storage profile is removed"},
+	}
+	for _, tc := range testCases {
+		got, err := orphanReason(tc.store, tc.cs, tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOrphanedOf(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	curr := fsStorageConf("/new")
+	since := &backup.Orphaned{Status: defs.StatusDone, Location: "/older", Reason: "no metadata file", At: 1}
+
+	prev := []*backup.BackupMeta{
+		{Name: "synced", Status: defs.StatusDone, Store: backup.Storage{StorageConf: fsStorageConf("/old")}},
+		{Name: "done", Status: defs.StatusDone, Store: backup.Storage{StorageConf: fsStorageConf("/old")}},
+		{Name: "again", Status: defs.StatusOrphaned, Orphaned: since},
+	}
+
+	rv := orphanedOf(prev, map[string]bool{"synced": true}, &curr, now)
+	if len(rv) != 2 || rv[0].Name != "done" || rv[1].Name != "again" {
+		t.Fatalf("got %v", rv)
+	}
+
+	want := backup.Orphaned{
+		Status:   defs.StatusDone,
+		Location: "/old",
+		Reason:   "no metadata file on the storage /new",
+		At:       now.Unix(),
+	}
+	if rv[0].Status != defs.StatusOrphaned || *rv[0].Orphaned != want {
+		t.Errorf("done: got %s %+v, want %+v", rv[0].Status, rv[0].Orphaned, want)
+	}
+	if rv[1].Orphaned != since {
+		t.Errorf("again: the orphaned reason is changed: %+v", rv[1].Orphaned)
+	}
+}
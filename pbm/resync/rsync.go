@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -24,9 +25,16 @@ import (
 
 // Resync sync oplog, backup, and restore meta from provided storage.
 //
-// It checks for read and write permissions, drops all meta from the database
-// and populate it again by reading meta from the storage.
-func Resync(ctx context.Context, conn connect.Client, cfg *config.StorageConf, node string) error {
+// It checks for read and write permissions and the storage owner (see
+// CheckStorageOwner), drops all meta from the database and populate it
+// again by reading meta from the storage.
+func Resync(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.StorageConf,
+	node string,
+	forceClaim bool,
+) error {
 	l := log.LogEventFromContext(ctx)
 
 	stg, err := util.StorageFromConfig(cfg, node, l)
@@ -52,12 +60,17 @@ func Resync(ctx context.Context, conn connect.Client, cfg *config.StorageConf, n
 		}
 	}
 
+	err = CheckStorageOwner(ctx, conn, stg, forceClaim)
+	if err != nil {
+		return err
+	}
+
 	err = SyncBackupList(ctx, conn, cfg, "", node)
 	if err != nil {
 		l.Error("failed sync backup metadata: %v", err)
 	}
 
-	err = resyncOplogRange(ctx, conn, stg)
+	err = resyncOplogRange(ctx, conn, stg, replsetStorages(ctx, conn, cfg, node))
 	if err != nil {
 		l.Error("failed sync oplog range: %v", err)
 	}
@@ -70,6 +83,33 @@ func Resync(ctx context.Context, conn connect.Client, cfg *config.StorageConf, n
 	return nil
 }
 
+// CheckStorageOwner verifies that the storage belongs to the cluster
+// and claims it if it has no owner yet. With force, the storage of
+// another cluster is claimed over.
+func CheckStorageOwner(ctx context.Context, conn connect.Client, stg storage.Storage, force bool) error {
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	owner, err := util.ClusterOwner(ctx, conn, cfg)
+	if err != nil {
+		return errors.Wrap(err, "get cluster identity")
+	}
+
+	err = util.CheckStorageOwner(stg, owner, force)
+	if err != nil {
+		return errors.Wrap(err, "check storage owner")
+	}
+	if force {
+		log.LogEventFromContext(ctx).Info("storage is claimed by %s", owner)
+	}
+
+	return nil
+}
+
+// notImported filters out backups registered by `pbm backup import`
+var notImported = bson.E{"provenance.source", bson.M{"$ne": backup.ProvenanceImport}}
+
 func ClearBackupList(ctx context.Context, conn connect.Client, profile string) error {
 	var filter bson.D
 	if profile == "" {
@@ -83,6 +123,8 @@ func ClearBackupList(ctx context.Context, conn connect.Client, profile string) e
 			{"store.name", profile},
 		}
 	}
+	// imported backups are out of the storage root and not synced
+	filter = append(filter, notImported)
 
 	_, err := conn.BcpCollection().DeleteMany(ctx, filter)
 	if err != nil {
@@ -106,6 +148,12 @@ func SyncBackupList(
 		return errors.Wrap(err, "storage from config")
 	}
 
+	// the backups of the previous storage config are kept as orphaned
+	changed, err := storageChangedBackups(ctx, conn, cfg, profile)
+	if err != nil {
+		return errors.Wrap(err, "get backups of the changed storage")
+	}
+
 	err = ClearBackupList(ctx, conn, profile)
 	if err != nil {
 		return errors.Wrapf(err, "clear backup list")
@@ -119,6 +167,7 @@ func SyncBackupList(
 	l.Debug("got backups list: %v", len(backupList))
 
 	if len(backupList) == 0 {
+		keepOrphaned(ctx, conn, changed, nil, cfg, time.Now())
 		return nil
 	}
 
@@ -133,7 +182,13 @@ func SyncBackupList(
 		backupList[i].Store = backupStore
 	}
 
-	return insertBackupList(ctx, conn, backupList)
+	err = insertBackupList(ctx, conn, backupList)
+	if err != nil {
+		return err
+	}
+
+	keepOrphaned(ctx, conn, changed, backupList, cfg, time.Now())
+	return nil
 }
 
 func insertBackupList(
@@ -164,9 +219,13 @@ func insertBackupList(
 					}
 				} else {
 					_, err = conn.BcpCollection().ReplaceOne(ctx,
-						bson.D{{"name", bcp.Name}},
+						bson.D{{"name", bcp.Name}, notImported},
 						bcp,
 						options.Replace().SetUpsert(true))
+					if mongo.IsDuplicateKeyError(err) {
+						l.Warning("skip backup %q: the name is taken by an imported backup", bcp.Name)
+						continue
+					}
 				}
 				if err != nil {
 					errC <- errors.Wrapf(err, "backup %q", bcp.Name)
@@ -196,43 +255,96 @@ func insertBackupList(
 	return nil
 }
 
+// replsetStorages returns storages of the `replsets.<rs>.storage` overrides
+// by the config profile name if cfg is the main storage.
+func replsetStorages(
+	ctx context.Context,
+	conn connect.Client,
+	cfg *config.StorageConf,
+	node string,
+) map[string]replsetStorage {
+	l := log.LogEventFromContext(ctx)
+
+	mcfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			l.Warning("get config: %v. skip replset storages", err)
+		}
+		return nil
+	}
+	if !mcfg.Storage.Equal(cfg) {
+		return nil
+	}
+
+	rv := make(map[string]replsetStorage)
+	for rs := range mcfg.Replsets {
+		name, stgConf, err := config.GetReplsetStorage(ctx, conn, mcfg, rs)
+		if err != nil {
+			l.Warning("skip storage of %s: %v", rs, err)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		stg, err := util.StorageFromConfig(stgConf, node, l)
+		if err != nil {
+			l.Warning("skip storage %q of %s: %v", name, rs, err)
+			continue
+		}
+		rv[rs] = replsetStorage{name: name, stg: stg}
+	}
+
+	return rv
+}
+
+type replsetStorage struct {
+	name string
+	stg  storage.Storage
+}
+
+// resyncOplogRange syncs the chunks meta from the main storage stg and
+// the chunks of replsets from their storages (see replsetStorages).
 func resyncOplogRange(
 	ctx context.Context,
 	conn connect.Client,
 	stg storage.Storage,
+	rsStgs map[string]replsetStorage,
 ) error {
-	l := log.LogEventFromContext(ctx)
-
 	_, err := conn.PITRChunksCollection().DeleteMany(ctx, bson.M{})
 	if err != nil {
 		return errors.Wrapf(err, "clean up %s", defs.PITRChunksCollection)
 	}
 
-	chunkFiles, err := stg.List(defs.PITRfsPrefix, "")
+	chunks, err := storageChunks(ctx, stg, "", "")
 	if err != nil {
-		return errors.Wrap(err, "get list of pitr chunks")
+		return err
 	}
-
-	var chunks []any
-	for _, file := range chunkFiles {
-		info, err := stg.FileStat(defs.PITRfsPrefix + "/" + file.Name)
+	for rs, s := range rsStgs {
+		c, err := storageChunks(ctx, s.stg, s.name, rs)
 		if err != nil {
-			l.Warning("skip pitr chunk %s/%s because of %v", defs.PITRfsPrefix, file.Name, err)
+			log.LogEventFromContext(ctx).Warning("skip pitr chunks of %s on %q: %v", rs, s.name, err)
 			continue
 		}
-
-		chunk := oplog.MakeChunkMetaFromFilepath(file.Name)
-		if chunk != nil {
-			chunk.Size = info.Size
-			chunks = append(chunks, chunk)
-		}
+		chunks = append(chunks, c...)
 	}
 
 	if len(chunks) == 0 {
 		return nil
 	}
 
-	_, err = conn.PITRChunksCollection().InsertMany(ctx, chunks)
+	docs := make([]any, 0, len(chunks))
+	seen := make(map[string]bool, len(chunks))
+	for _, c := range chunks {
+		// the chunk may be on both storages if it was copied
+		if seen[c.FName] {
+			continue
+		}
+		seen[c.FName] = true
+		docs = append(docs, c)
+	}
+
+	_, err = conn.PITRChunksCollection().InsertMany(ctx, docs)
 	if err != nil {
 		return errors.Wrap(err, "insert retrieved pitr meta")
 	}
@@ -240,6 +352,38 @@ func resyncOplogRange(
 	return nil
 }
 
+// storageChunks returns meta of the chunks on the storage. If rs is set,
+// only chunks of the replset are returned. name is the config profile
+// name of the storage (empty for the main storage).
+func storageChunks(ctx context.Context, stg storage.Storage, name, rs string) ([]*oplog.OplogChunk, error) {
+	l := log.LogEventFromContext(ctx)
+
+	chunkFiles, err := stg.List(defs.PITRfsPrefix, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "get list of pitr chunks")
+	}
+
+	var chunks []*oplog.OplogChunk
+	for _, file := range chunkFiles {
+		chunk := oplog.MakeChunkMetaFromFilepath(file.Name)
+		if chunk == nil || (rs != "" && chunk.RS != rs) {
+			continue
+		}
+
+		info, err := stg.FileStat(defs.PITRfsPrefix + "/" + file.Name)
+		if err != nil {
+			l.Warning("skip pitr chunk %s/%s because of %v", defs.PITRfsPrefix, file.Name, err)
+			continue
+		}
+
+		chunk.Size = info.Size
+		chunk.Storage = name
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
 func resyncPhysicalRestores(
 	ctx context.Context,
 	conn connect.Client,
@@ -277,6 +421,17 @@ func resyncPhysicalRestores(
 		return errors.Wrap(err, "insert restore meta into db")
 	}
 
+	cfg, err := config.GetConfig(ctx, conn)
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	if cfg.Restore != nil {
+		err = restore.DeleteOldRestores(ctx, conn, stg, cfg.Restore.KeepLast)
+		if err != nil {
+			return errors.Wrap(err, "delete old restores")
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,221 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// Cron is a parsed standard 5-field cron expression
+// (minute, hour, day of month, month, day of week).
+// All times are evaluated in UTC.
+type Cron struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// domAny and dowAny are set for `*` fields. If both day fields are
+	// restricted, a day matches either of them (as in cron(8)).
+	domAny bool
+	dowAny bool
+}
+
+// maxNextSearch limits how far Next looks for a matching time.
+// It is enough for any valid expression (e.g. `0 0 29 2 *`).
+const maxNextSearch = 5 * 366 * 24 * time.Hour
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [...]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{
+		name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"},
+	},
+	{
+		name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"},
+	},
+}
+
+// ParseCron parses cron expression like `30 2 * * 1-5`, `*/15 * * * *`
+// or one of the @daily, @hourly, etc. shortcuts.
+// Month and day of week fields accept three-letter names.
+func ParseCron(s string) (*Cron, error) {
+	expr := strings.TrimSpace(s)
+	if v, ok := cronShortcuts[strings.ToLower(expr)]; ok {
+		expr = v
+	}
+
+	fs := strings.Fields(expr)
+	if len(fs) != len(cronFields) {
+		return nil, errors.Errorf("cron %q: expected %d fields, got %d", s, len(cronFields), len(fs))
+	}
+
+	var bits [len(cronFields)]uint64
+	for i, f := range fs {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cron %q: %s", s, cronFields[i].name)
+		}
+		bits[i] = b
+	}
+
+	c := &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: fs[2] == "*",
+		dowAny: fs[4] == "*",
+	}
+	// both 0 and 7 are Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var rv uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			l, h, _ := strings.Cut(rng, "-")
+			var err error
+			lo, err = cronValue(l, f)
+			if err != nil {
+				return 0, err
+			}
+			hi, err = cronValue(h, f)
+			if err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, errors.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := cronValue(rng, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			rv |= 1 << v
+		}
+	}
+
+	return rv, nil
+}
+
+func cronValue(s string, f cronField) (int, error) {
+	for i, n := range f.names {
+		if strings.EqualFold(s, n) {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.Errorf("invalid value %q, expected %d-%d", s, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first matching time after t (with minute precision).
+// It returns zero time if there is no matching time
+// within the next five years (e.g. `0 0 31 2 *`).
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxNextSearch)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// Last returns the latest matching time within (from, to].
+// It returns zero time if there is none.
+func (c *Cron) Last(from, to time.Time) time.Time {
+	var rv time.Time
+	for t := c.Next(from); !t.IsZero() && !t.After(to); t = c.Next(t) {
+		rv = t
+	}
+
+	return rv
+}
+
+func (c *Cron) dayMatch(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
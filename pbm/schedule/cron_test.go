@@ -0,0 +1,75 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		cron string
+		from string
+		want string
+	}{
+		{"0 2 * * *", "2026-10-14T01:00:00Z", "2026-10-14T02:00:00Z"},
+		{"0 2 * * *", "2026-10-14T02:00:00Z", "2026-10-15T02:00:00Z"},
+		{"*/15 * * * *", "2026-10-14T10:07:30Z", "2026-10-14T10:15:00Z"},
+		{"30 1 * * mon-fri", "2026-10-16T02:00:00Z", "2026-10-19T01:30:00Z"},
+		{"0 0 1,15 * *", "2026-10-02T00:00:00Z", "2026-10-15T00:00:00Z"},
+		{"0 0 29 2 *", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 0 * * 7", "2026-10-14T00:00:00Z", "2026-10-18T00:00:00Z"},
+		// either day field matches if both are restricted
+		{"0 0 13 * 5", "2026-10-14T00:00:00Z", "2026-10-16T00:00:00Z"},
+		{"@monthly", "2026-12-14T00:00:00Z", "2027-01-01T00:00:00Z"},
+	}
+	for _, c := range cases {
+		cr, err := ParseCron(c.cron)
+		if err != nil {
+			t.Fatalf("%q: %v", c.cron, err)
+		}
+		if got := cr.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q from %s: got %s, want %s", c.cron, c.from, got.Format(time.RFC3339), c.want)
+		}
+	}
+
+	cr, _ := ParseCron("0 0 31 2 *")
+	if got := cr.Next(at("2026-01-01T00:00:00Z")); !got.IsZero() {
+		t.Errorf("expected no next time, got %v", got)
+	}
+
+	cr, _ = ParseCron("*/10 * * * *")
+	got := cr.Last(at("2026-10-14T10:00:00Z"), at("2026-10-14T10:35:00Z"))
+	if !got.Equal(at("2026-10-14T10:30:00Z")) {
+		t.Errorf("last: got %v", got)
+	}
+	if got := cr.Last(at("2026-10-14T10:31:00Z"), at("2026-10-14T10:35:00Z")); !got.IsZero() {
+		t.Errorf("last: expected none, got %v", got)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * funday",
+	} {
+		if _, err := ParseCron(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
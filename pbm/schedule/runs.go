@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+type RunStatus string
+
+const (
+	// RunStarted means the backup command has been sent
+	RunStarted RunStatus = "started"
+	// RunDone means the backup has been finished successfully
+	RunDone RunStatus = "done"
+	// RunSkipped means the slot was skipped (e.g. another operation was running)
+	RunSkipped RunStatus = "skipped"
+	// RunFailed means the backup couldn't be started or has failed
+	RunFailed RunStatus = "failed"
+)
+
+// Run is a record of a scheduled slot processing.
+//
+// ID is unique per schedule and slot, so the slot can be claimed only once.
+// Hence two agents can't fire the same slot even if both consider
+// themselves as the cluster leader at the moment.
+type Run struct {
+	ID       string              `bson:"_id" json:"-"`
+	Schedule string              `bson:"schedule" json:"schedule"`
+	Slot     int64               `bson:"slot" json:"slot"`
+	Status   RunStatus           `bson:"status" json:"status"`
+	Reason   string              `bson:"reason,omitempty" json:"reason,omitempty"`
+	Backup   string              `bson:"backup,omitempty" json:"backup,omitempty"`
+	OPID     string              `bson:"opid,omitempty" json:"opid,omitempty"`
+	Node     string              `bson:"node" json:"node"`
+	Epoch    primitive.Timestamp `bson:"epoch" json:"-"`
+	Time     int64               `bson:"time" json:"time"`
+}
+
+// RunID returns ID of the schedule's run for the slot.
+func RunID(schedule string, slot time.Time) string {
+	return fmt.Sprintf("%s/%d", schedule, slot.Unix())
+}
+
+// ClaimRun inserts the run. It returns false if the slot
+// has already been claimed.
+func ClaimRun(ctx context.Context, m connect.Client, r *Run) (bool, error) {
+	r.ID = RunID(r.Schedule, time.Unix(r.Slot, 0))
+	r.Time = time.Now().Unix()
+
+	_, err := m.ScheduleRunsCollection().InsertOne(ctx, r)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "insert")
+	}
+
+	return true, nil
+}
+
+// RunExists returns true if the slot of the schedule has been claimed.
+func RunExists(ctx context.Context, m connect.Client, schedule string, slot time.Time) (bool, error) {
+	err := m.ScheduleRunsCollection().
+		FindOne(ctx, bson.D{{"_id", RunID(schedule, slot)}}).
+		Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "query")
+	}
+
+	return true, nil
+}
+
+// SetRunStatus updates status of the run.
+func SetRunStatus(ctx context.Context, m connect.Client, id string, s RunStatus, reason string) error {
+	_, err := m.ScheduleRunsCollection().UpdateOne(ctx,
+		bson.D{{"_id", id}},
+		bson.D{{"$set", bson.D{
+			{"status", s},
+			{"reason", reason},
+			{"time", time.Now().Unix()},
+		}}})
+	return errors.Wrap(err, "update")
+}
+
+// SetRunBackup sets the backup started by the run.
+func SetRunBackup(ctx context.Context, m connect.Client, id, name, opid string) error {
+	_, err := m.ScheduleRunsCollection().UpdateOne(ctx,
+		bson.D{{"_id", id}},
+		bson.D{{"$set", bson.D{{"backup", name}, {"opid", opid}}}})
+	return errors.Wrap(err, "update")
+}
+
+// GetRuns returns the most recent runs, up to limit (0 means no limit).
+// If status is given, only runs with the status are returned.
+func GetRuns(ctx context.Context, m connect.Client, limit int64, status ...RunStatus) ([]Run, error) {
+	f := bson.D{}
+	if len(status) != 0 {
+		f = bson.D{{"status", bson.D{{"$in", status}}}}
+	}
+	cur, err := m.ScheduleRunsCollection().Find(ctx, f,
+		options.Find().SetSort(bson.D{{"slot", -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	rv := []Run{}
+	err = cur.All(ctx, &rv)
+	return rv, errors.Wrap(err, "decode")
+}
@@ -0,0 +1,114 @@
+// Package secret resolves references to secrets in config values, so the
+// config stored in the database doesn't have to keep the secrets themselves.
+//
+// A value may be
+//   - `file:///path/to/file` - the content of the file (without the trailing newline),
+//   - a string with `${NAME}` references - replaced with the environment variable values.
+//
+// References are resolved by the process that uses the value (e.g. by every agent),
+// with its own environment and filesystem.
+package secret
+
+import (
+	"os"
+	"strings"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+const filePrefix = "file://"
+
+// IsRef returns true if the value refers to a file or an environment variable.
+func IsRef(v string) bool {
+	return strings.HasPrefix(v, filePrefix) || strings.Contains(v, "${")
+}
+
+// Check returns an error if a reference in the value is malformed.
+// It doesn't check that the variables or the file exist.
+func Check(v string) error {
+	if path, ok := strings.CutPrefix(v, filePrefix); ok {
+		if !strings.HasPrefix(path, "/") {
+			return errors.Errorf("file reference %q: expected absolute path (file:///path)", v)
+		}
+		return nil
+	}
+
+	_, err := expand(v, func(string) (string, bool) { return "", true })
+	return err
+}
+
+// Resolve returns the value with all references replaced.
+// A value without references is returned as is.
+func Resolve(v string) (string, error) {
+	if path, ok := strings.CutPrefix(v, filePrefix); ok {
+		return readFile(path)
+	}
+	if !strings.Contains(v, "${") {
+		return v, nil
+	}
+
+	return expand(v, os.LookupEnv)
+}
+
+func readFile(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", errors.Errorf("file reference %q: expected absolute path (file:///path)", filePrefix+path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "read secret file %s", path)
+	}
+
+	s := strings.TrimRight(string(data), "\r\n")
+	if s == "" {
+		return "", errors.Errorf("secret file %s is empty", path)
+	}
+
+	return s, nil
+}
+
+// expand replaces `${NAME}` references with values returned by lookup.
+func expand(v string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(v, "${")
+		if i == -1 {
+			b.WriteString(v)
+			return b.String(), nil
+		}
+		b.WriteString(v[:i])
+		v = v[i+2:]
+
+		j := strings.IndexByte(v, '}')
+		if j == -1 {
+			return "", errors.New("unterminated ${ reference")
+		}
+		name := v[:j]
+		v = v[j+1:]
+
+		if !isEnvName(name) {
+			return "", errors.Errorf("invalid environment variable name %q", name)
+		}
+		val, ok := lookup(name)
+		if !ok {
+			return "", errors.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(val)
+	}
+}
+
+func isEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
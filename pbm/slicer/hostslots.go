@@ -0,0 +1,105 @@
+package slicer
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// slotPollInterval is how often the busy slots are tried again
+const slotPollInterval = time.Second
+
+// hostSlots limits the chunk uploads of the agents on the host (see
+// `pitr.hostUploads`). Each of the limit slots is a file in the shared
+// directory, the upload holds the flock of one of them. The kernel drops
+// the lock once the agent is gone, so a killed agent never leaks the slot.
+type hostSlots struct {
+	dir   string
+	limit int
+	poll  time.Duration
+}
+
+// newHostSlots returns nil if the limit is disabled
+func newHostSlots(cfg *config.PITRHostUploads) *hostSlots {
+	if !cfg.IsEnabled() {
+		return nil
+	}
+
+	return &hostSlots{
+		dir:   cfg.Dir(),
+		limit: cfg.MaxUploads(),
+		poll:  slotPollInterval,
+	}
+}
+
+// acquire waits for a free slot until ctx is done. The returned func
+// releases the slot.
+func (h *hostSlots) acquire(ctx context.Context) (func(), error) {
+	err := os.MkdirAll(h.dir, 0o777)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create %s", h.dir)
+	}
+
+	for {
+		for i := range h.limit {
+			release, err := tryLockSlot(filepath.Join(h.dir, fmt.Sprintf("slot.%d", i)))
+			if err != nil {
+				return nil, err
+			}
+			if release != nil {
+				return release, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(h.poll):
+		}
+	}
+}
+
+// tryLockSlot returns nil if the slot is held by another upload
+func tryLockSlot(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0o666)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", name)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "lock %s", name)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// jitterSpan moves the span randomly by up to percent of it in either
+// direction, so the slicers started at once don't cut the chunks at the
+// same time. r is a random number in [0, 1).
+func jitterSpan(span time.Duration, percent, r float64) time.Duration {
+	if percent <= 0 {
+		return span
+	}
+
+	d := time.Duration(float64(span) * percent / 100 * (2*r - 1))
+	return max(span+d, min(span, minAdaptiveSpan))
+}
+
+func (s *Slicer) nextTick(span time.Duration) time.Duration {
+	return jitterSpan(span, s.jitter, rand.Float64())
+}
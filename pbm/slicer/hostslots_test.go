@@ -0,0 +1,154 @@
+package slicer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	helperDirEnv   = "PBM_TEST_SLOTS_DIR"
+	helperLimitEnv = "PBM_TEST_SLOTS_LIMIT"
+)
+
+// holdSlot takes the slot, checks no more than limit uploads are holding
+// one and releases it after a pause
+func holdSlot(ctx context.Context, h *hostSlots, holders string, id string) error {
+	release, err := h.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	mark := filepath.Join(holders, id)
+	err = os.WriteFile(mark, nil, 0o666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mark)
+
+	ents, err := os.ReadDir(holders)
+	if err != nil {
+		return err
+	}
+	if len(ents) > h.limit {
+		return fmt.Errorf("%d uploads at once, the limit is %d", len(ents), h.limit)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+// TestHelperSlots is an agent of the host in TestHostSlotsProcesses
+func TestHelperSlots(t *testing.T) {
+	dir := os.Getenv(helperDirEnv)
+	if dir == "" {
+		t.Skip("run by TestHostSlotsProcesses")
+	}
+	limit, _ := strconv.Atoi(os.Getenv(helperLimitEnv))
+
+	h := &hostSlots{dir: filepath.Join(dir, "slots"), limit: limit, poll: time.Millisecond}
+	for i := range 5 {
+		err := holdSlot(context.Background(), h, filepath.Join(dir, "holders"), fmt.Sprintf("%d.%d", os.Getpid(), i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHostSlotsProcesses(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "holders"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperSlots$")
+			cmd.Env = append(os.Environ(), helperDirEnv+"="+dir, helperLimitEnv+"=2")
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				errs[i] = fmt.Errorf("%w: %s", err, out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("agent %d: %v", i, err)
+		}
+	}
+}
+
+func TestHostSlots(t *testing.T) {
+	dir := t.TempDir()
+	holders := filepath.Join(dir, "holders")
+	if err := os.Mkdir(holders, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	h := &hostSlots{dir: filepath.Join(dir, "slots"), limit: 1, poll: time.Millisecond}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = holdSlot(context.Background(), h, holders, strconv.Itoa(i))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+
+	// the waiting upload is canceled with the slicer
+	release, err := h.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.acquire(ctx); err == nil {
+		t.Error("got the busy slot")
+	}
+}
+
+func TestJitterSpan(t *testing.T) {
+	span := 10 * time.Minute
+	testCases := []struct {
+		percent, r float64
+		want       time.Duration
+	}{
+		{0, 0.9, span},
+		{10, 0.5, span},
+		{10, 0, 9 * time.Minute},
+		{10, 0.75, 10*time.Minute + 30*time.Second},
+		{50, 0, 5 * time.Minute},
+	}
+	for _, tc := range testCases {
+		if got := jitterSpan(span, tc.percent, tc.r); got != tc.want {
+			t.Errorf("%v%% %v: got %v, want %v", tc.percent, tc.r, got, tc.want)
+		}
+	}
+
+	// never shorter than the shortest span
+	if got := jitterSpan(40*time.Second, 50, 0); got != minAdaptiveSpan {
+		t.Errorf("short span: got %v, want %v", got, minAdaptiveSpan)
+	}
+}
@@ -3,6 +3,7 @@ package slicer
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -34,9 +35,19 @@ type Slicer struct {
 	span       int64
 	lastTS     primitive.Timestamp
 	storage    storage.Storage
+	stgName    string
 	oplog      *oplog.OplogBackup
 	l          log.LogEvent
 	cfg        *config.Config
+	excludeNS  []string
+	// slots limit the uploads of the agents on the host. Nil if disabled.
+	slots *hostSlots
+	// jitter is the percent of the span the chunk boundaries are moved by
+	jitter float64
+
+	cmpMx       sync.Mutex
+	compression compress.CompressionType
+	level       *int
 }
 
 // NewSlicer creates an incremental backup object
@@ -48,7 +59,7 @@ func NewSlicer(
 	cfg *config.Config,
 	logger log.Logger,
 ) *Slicer {
-	return &Slicer{
+	s := &Slicer{
 		leadClient: cn,
 		node:       node,
 		rs:         rs,
@@ -57,7 +68,34 @@ func NewSlicer(
 		oplog:      oplog.NewOplogBackup(node),
 		cfg:        cfg,
 		l:          logger.NewEvent(string(ctrl.CmdPITR), "", "", cfg.Epoch),
+
+		compression: cfg.PITRConfRS(rs).Compression,
+		level:       cfg.PITRConfRS(rs).CompressionLevel,
+	}
+
+	var hu *config.PITRHostUploads
+	if cfg.PITR != nil {
+		hu = cfg.PITR.HostUploads
+	}
+	s.slots = newHostSlots(hu)
+	s.jitter = hu.Jitter()
+
+	if nss := cfg.PITR.CaptureExcludeNS(); len(nss) != 0 {
+		if err := s.oplog.SetExcludeNS(nss); err != nil {
+			s.l.Error("set pitr.excludeNamespaces: %v. capturing the full oplog", err)
+		} else {
+			s.excludeNS = nss
+		}
 	}
+
+	return s
+}
+
+// SetStorageName sets the config profile name of the storage the chunks
+// are uploaded to (see `replsets.<rs>.storage` option).
+// It's empty for the main storage.
+func (s *Slicer) SetStorageName(name string) {
+	s.stgName = name
 }
 
 // SetSpan sets span duration. Streaming will recognize the change and adjust on the next iteration.
@@ -69,6 +107,23 @@ func (s *Slicer) GetSpan() time.Duration {
 	return time.Duration(atomic.LoadInt64(&s.span))
 }
 
+// SetCompression sets compression for the chunks. Streaming will use it
+// starting from the next chunk.
+func (s *Slicer) SetCompression(c compress.CompressionType, level *int) {
+	s.cmpMx.Lock()
+	defer s.cmpMx.Unlock()
+
+	s.compression = c
+	s.level = level
+}
+
+func (s *Slicer) GetCompression() (compress.CompressionType, *int) {
+	s.cmpMx.Lock()
+	defer s.cmpMx.Unlock()
+
+	return s.compression, s.level
+}
+
 func (s *Slicer) Catchup(ctx context.Context) error {
 	s.l.Debug("start_catchup")
 
@@ -132,11 +187,12 @@ func (s *Slicer) Catchup(ctx context.Context) error {
 			return errors.Wrap(err, "get config")
 		}
 
+		pitrConf := cfg.PITRConfRS(s.rs)
 		err = s.upload(ctx,
 			lastChunk.EndTS,
 			rs.FirstWriteTS,
-			cfg.PITR.Compression,
-			cfg.PITR.CompressionLevel)
+			pitrConf.Compression,
+			pitrConf.CompressionLevel)
 		if err != nil {
 			var rangeErr oplog.InsuffRangeError
 			if !errors.As(err, &rangeErr) {
@@ -243,6 +299,7 @@ func (s *Slicer) copyReplsetOplog(ctx context.Context, rs *backup.BackupReplset)
 			StartTS:     fw,
 			EndTS:       lw,
 			Size:        stat.Size,
+			Storage:     s.stgName,
 		}
 		err = oplog.PITRAddChunk(ctx, s.leadClient, meta)
 		if err != nil && !mongo.IsDuplicateKeyError(err) {
@@ -282,8 +339,6 @@ func (s *Slicer) Stream(
 	startingNode *topo.NodeInfo,
 	stopC <-chan struct{},
 	backupSig <-chan ctrl.OPID,
-	compression compress.CompressionType,
-	level *int,
 	timeouts *config.BackupTimeouts,
 	monitorPrio bool,
 ) error {
@@ -293,7 +348,7 @@ func (s *Slicer) Stream(
 	s.l.Info("streaming started from %v / %v", time.Unix(int64(s.lastTS.T), 0).UTC(), s.lastTS.T)
 
 	cspan := s.GetSpan()
-	tk := time.NewTicker(cspan)
+	tk := time.NewTicker(s.nextTick(cspan))
 	defer tk.Stop()
 
 	// early check for the log sufficiency to display error
@@ -303,6 +358,7 @@ func (s *Slicer) Stream(
 		return errors.Wrap(err, "check oplog sufficiency")
 	}
 	if !ok {
+		s.recordLoss(ctx, startingNode.Me)
 		return oplog.InsuffRangeError{s.lastTS}
 	}
 	s.l.Debug(LogStartMsg)
@@ -401,7 +457,7 @@ func (s *Slicer) Stream(
 				prio.CalcPriorityForNode(ninf))
 		}
 		if sliceTo.IsZero() {
-			majority, err := topo.IsWriteMajorityRequested(ctx, s.node, s.leadClient.MongoOptions().WriteConcern)
+			majority, err := topo.IsWriteMajorityRequested(ctx, s.node, s.leadClient.WriteConcern())
 			if err != nil {
 				return errors.Wrap(err, "define requested majority")
 			}
@@ -411,6 +467,12 @@ func (s *Slicer) Stream(
 			}
 		}
 
+		margin, err := s.checkMargin(ctx, startingNode.Me)
+		if err != nil {
+			return err
+		}
+
+		compression, level := s.GetCompression()
 		err = s.upload(ctx, s.lastTS, sliceTo, compression, level)
 		if err != nil {
 			return err
@@ -430,13 +492,85 @@ func (s *Slicer) Stream(
 
 		s.lastTS = sliceTo
 
-		if ispan := s.GetSpan(); cspan != ispan {
-			tk.Reset(ispan)
+		if ispan := s.nextSpan(margin); cspan != ispan {
+			if ispan < s.GetSpan() {
+				s.l.Warning("oplog safety margin is %v, shrinking span to %v", margin, ispan)
+			}
 			cspan = ispan
+			tk.Reset(s.nextTick(cspan))
+		} else if s.jitter > 0 {
+			tk.Reset(s.nextTick(cspan))
 		}
 	}
 }
 
+const (
+	// marginWarnSpans is the safety margin (in spans) below which
+	// the slicer starts warning and (if enabled) shrinking the span.
+	marginWarnSpans = 3
+	// minAdaptiveSpan is the shortest span `pitr.adaptiveSpan` can set.
+	minAdaptiveSpan = 30 * time.Second
+)
+
+// checkMargin checks how much time is left before the oldest not uploaded
+// oplog entry rolls off the node's oplog and records it for the status.
+// If the entry is gone already, the gap is recorded and InsuffRangeError
+// is returned.
+func (s *Slicer) checkMargin(ctx context.Context, node string) (time.Duration, error) {
+	first, err := oplog.GetOplogFirstTS(ctx, s.node)
+	if err != nil {
+		return 0, errors.Wrap(err, "get oplog first ts")
+	}
+
+	span := s.GetSpan()
+	margin := time.Duration(int64(s.lastTS.T)-int64(first.T)) * time.Second
+	err = oplog.SetPITRMargin(ctx, s.leadClient, s.rs, oplog.PITRMargin{
+		Node:       node,
+		MarginSec:  int64(margin.Seconds()),
+		LastTS:     s.lastTS,
+		OplogFirst: first,
+		SpanSec:    int64(span.Seconds()),
+	})
+	if err != nil {
+		s.l.Warning("save safety margin: %v", err)
+	}
+
+	switch {
+	case first.After(s.lastTS):
+		s.recordLoss(ctx, node)
+		return margin, oplog.InsuffRangeError{s.lastTS}
+	case margin < span:
+		s.l.Error("oplog safety margin is %v (span %v). "+
+			"not uploaded oplog is about to roll off, the storage is too slow or the oplog is too small",
+			margin, span)
+	case margin < marginWarnSpans*span:
+		s.l.Warning("oplog safety margin is %v (span %v). uploads are falling behind the oplog", margin, span)
+	}
+
+	return margin, nil
+}
+
+// recordLoss records the gap since the last uploaded chunk when the oplog
+// after it has rolled off already.
+func (s *Slicer) recordLoss(ctx context.Context, node string) {
+	s.l.Error("oplog since %v has rolled off before it was uploaded. "+
+		"PITR is not possible until the next backup", formatts(s.lastTS))
+	if _, err := oplog.OpenPITRGap(ctx, s.leadClient, s.rs, node, s.lastTS); err != nil {
+		s.l.Error("record gap: %v", err)
+	}
+}
+
+// nextSpan returns the span for the next chunk. With `pitr.adaptiveSpan`
+// it is shrunk to push chunks sooner when the safety margin is low.
+func (s *Slicer) nextSpan(margin time.Duration) time.Duration {
+	span := s.GetSpan()
+	if s.cfg.PITR == nil || !s.cfg.PITR.AdaptiveSpan || margin >= marginWarnSpans*span {
+		return span
+	}
+
+	return min(span, max(margin/marginWarnSpans, minAdaptiveSpan))
+}
+
 func (s *Slicer) upload(
 	ctx context.Context,
 	from primitive.Timestamp,
@@ -444,6 +578,22 @@ func (s *Slicer) upload(
 	compression compress.CompressionType,
 	level *int,
 ) error {
+	if s.slots != nil {
+		start := time.Now()
+		release, err := s.slots.acquire(ctx)
+		switch {
+		case err == nil:
+			defer release()
+			if d := time.Since(start); d >= time.Second {
+				s.l.Debug("waited %v for the host upload slot", d.Round(time.Second))
+			}
+		case ctx.Err() != nil:
+			return errors.Wrap(err, "wait for the host upload slot")
+		default:
+			s.l.Warning("host upload slot: %v. uploading without the limit", err)
+		}
+	}
+
 	s.oplog.SetTailingSpan(from, to)
 	fname := oplog.FormatChunkFilepath(s.rs, from, to, compression)
 	// if use parent ctx, upload will be canceled on the "done" signal
@@ -468,6 +618,9 @@ func (s *Slicer) upload(
 		StartTS:     from,
 		EndTS:       to,
 		Size:        size,
+		SpanMin:     s.GetSpan().Minutes(),
+		ExcludedNS:  s.excludeNS,
+		Storage:     s.stgName,
 	}
 	err = oplog.PITRAddChunk(ctx, s.leadClient, meta)
 	if err != nil {
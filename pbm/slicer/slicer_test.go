@@ -0,0 +1,197 @@
+package slicer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+func TestNextSpan(t *testing.T) {
+	span := 10 * time.Minute
+
+	tests := []struct {
+		name     string
+		adaptive bool
+		margin   time.Duration
+		want     time.Duration
+	}{
+		{"not adaptive", false, time.Minute, span},
+		{"enough margin", true, marginWarnSpans * span, span},
+		{"low margin", true, 2 * span, 2 * span / marginWarnSpans},
+		{"tiny margin", true, time.Minute, minAdaptiveSpan},
+		{"no margin", true, 0, minAdaptiveSpan},
+		{"negative margin", true, -time.Minute, minAdaptiveSpan},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Slicer{cfg: &config.Config{PITR: &config.PITRConf{AdaptiveSpan: tt.adaptive}}}
+			s.SetSpan(span)
+			if got := s.nextSpan(tt.margin); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no pitr config", func(t *testing.T) {
+		s := &Slicer{cfg: &config.Config{}}
+		s.SetSpan(span)
+		if got := s.nextSpan(0); got != span {
+			t.Errorf("got %v, want %v", got, span)
+		}
+	})
+
+	t.Run("short span", func(t *testing.T) {
+		s := &Slicer{cfg: &config.Config{PITR: &config.PITRConf{AdaptiveSpan: true}}}
+		s.SetSpan(10 * time.Second)
+		if got := s.nextSpan(0); got != 10*time.Second {
+			t.Errorf("span is grown to %v", got)
+		}
+	})
+}
+
+func TestCheckMargin(t *testing.T) {
+	span := 10 * time.Minute
+	lastTS := primitive.Timestamp{T: 100000, I: 3}
+
+	oplogFirst := func(t uint32) bson.D {
+		return mtest.CreateCursorResponse(0, "local.oplog.rs", mtest.FirstBatch,
+			bson.D{{"ts", primitive.Timestamp{T: t, I: 1}}})
+	}
+	updated := mtest.CreateSuccessResponse(bson.E{"n", 1}, bson.E{"nModified", 1})
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	newSlicer := func(mt *mtest.T) *Slicer {
+		s := &Slicer{
+			leadClient: connect.UnsafeClient(mt.Client),
+			node:       mt.Client,
+			rs:         "rs1",
+			lastTS:     lastTS,
+			l:          log.DiscardEvent,
+			cfg:        &config.Config{},
+		}
+		s.SetSpan(span)
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		first      uint32
+		wantMargin time.Duration
+	}{
+		{"enough margin", lastTS.T - 3600, time.Hour},
+		{"warn margin", lastTS.T - 1200, 20 * time.Minute},
+		{"about to roll off", lastTS.T - 60, time.Minute},
+		{"at the last ts", lastTS.T, 0},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(oplogFirst(tt.first), updated)
+
+			margin, err := newSlicer(mt).checkMargin(context.Background(), "n1:27017")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if margin != tt.wantMargin {
+				t.Errorf("margin %v, want %v", margin, tt.wantMargin)
+			}
+
+			ev := mt.GetAllStartedEvents()
+			if len(ev) != 2 || ev[1].CommandName != "update" {
+				t.Fatalf("expected find and margin update, got %d commands", len(ev))
+			}
+			sec, ok := ev[1].Command.Lookup("updates", "0", "u", "$set", "margins.rs1", "margin_sec").AsInt64OK()
+			if !ok || sec != int64(tt.wantMargin.Seconds()) {
+				t.Errorf("recorded margin %v (%v), want %v", sec, ok, tt.wantMargin.Seconds())
+			}
+		})
+	}
+
+	mt.Run("rolled off", func(mt *mtest.T) {
+		mt.AddMockResponses(oplogFirst(lastTS.T+10), updated, updated)
+
+		_, err := newSlicer(mt).checkMargin(context.Background(), "n1:27017")
+		var rangeErr oplog.InsuffRangeError
+		if !errors.As(err, &rangeErr) || rangeErr.Timestamp != lastTS {
+			t.Fatalf("expected InsuffRangeError for %v, got %v", lastTS, err)
+		}
+
+		ev := mt.GetAllStartedEvents()
+		if len(ev) != 3 {
+			t.Fatalf("expected the gap recorded, got %d commands", len(ev))
+		}
+		gap := ev[2].Command.Lookup("updates", "0", "u", "$setOnInsert")
+		start, i := gap.Document().Lookup("start_ts").Timestamp()
+		if node := gap.Document().Lookup("node").StringValue(); node != "n1:27017" {
+			t.Errorf("gap node %q", node)
+		}
+		if (primitive.Timestamp{T: start, I: i}) != lastTS {
+			t.Errorf("gap start %v, want %v", primitive.Timestamp{T: start, I: i}, lastTS)
+		}
+	})
+
+	mt.Run("margin is not saved", func(mt *mtest.T) {
+		mt.AddMockResponses(oplogFirst(lastTS.T-3600), mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    11600,
+			Name:    "InterruptedAtShutdown",
+			Message: "interrupted",
+		}))
+
+		margin, err := newSlicer(mt).checkMargin(context.Background(), "n1:27017")
+		if err != nil || margin != time.Hour {
+			t.Errorf("got %v, %v; want %v", margin, err, time.Hour)
+		}
+	})
+
+	mt.Run("oplog query error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    13,
+			Name:    "Unauthorized",
+			Message: "not authorized",
+		}))
+
+		if _, err := newSlicer(mt).checkMargin(context.Background(), "n1:27017"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestRecordLoss(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("gap error is only logged", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    11600,
+			Name:    "InterruptedAtShutdown",
+			Message: "interrupted",
+		}))
+
+		s := &Slicer{
+			leadClient: connect.UnsafeClient(mt.Client),
+			rs:         "rs1",
+			lastTS:     primitive.Timestamp{T: 100},
+			l:          log.DiscardEvent,
+		}
+		s.recordLoss(context.Background(), "n1:27017")
+
+		ev := mt.GetAllStartedEvents()
+		if len(ev) != 1 || ev[0].CommandName != "update" {
+			t.Fatalf("expected the gap upsert, got %d commands", len(ev))
+		}
+		if rs := ev[0].Command.Lookup("updates", "0", "q", "rs").StringValue(); rs != "rs1" {
+			t.Errorf("gap replset %q", rs)
+		}
+	})
+}
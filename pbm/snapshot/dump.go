@@ -16,6 +16,7 @@ func UploadDump(
 	ctx context.Context,
 	dump func(archive.NewWriter) error,
 	upload UploadFunc,
+	pool *compress.Pool,
 	compression compress.CompressionType,
 	compressionLevel *int,
 ) (int64, error) {
@@ -44,7 +45,7 @@ func UploadDump(
 			atomic.AddInt64(&uploadSize, rc.n)
 		}()
 
-		w, err := compress.Compress(pw, compression, compressionLevel)
+		w, err := pool.Compress(pw, compression, compressionLevel)
 		dwc := io.WriteCloser(&delegatedWriteCloser{w, funcCloser(func() error {
 			err0 := w.Close()
 			err1 := pw.Close()
@@ -60,11 +61,28 @@ func UploadDump(
 
 type DownloadFunc func(filename string) (io.ReadCloser, error)
 
+// DownloadDump composes the archive of the namespaces files. logf reports
+// the compression detected by the files data (see compress.Decompress).
 func DownloadDump(
 	download DownloadFunc,
 	compression compress.CompressionType,
+	logf func(msg string, args ...any),
 	match archive.NSFilterFn,
 	numParallelColls int,
+) (io.ReadCloser, error) {
+	return DownloadDumpTiers(download, compression, logf, match, numParallelColls, nil, nil)
+}
+
+// DownloadDumpTiers is DownloadDump with the namespaces streamed tier
+// by tier (see archive.ComposeTiers).
+func DownloadDumpTiers(
+	download DownloadFunc,
+	compression compress.CompressionType,
+	logf func(msg string, args ...any),
+	match archive.NSFilterFn,
+	numParallelColls int,
+	nsTier archive.NSTierFn,
+	onTier func(tier int, nss []string),
 ) (io.ReadCloser, error) {
 	pr, pw := io.Pipe()
 
@@ -83,11 +101,11 @@ func DownloadDump(
 				return r, nil
 			}
 
-			r, err = compress.Decompress(r, compression)
+			r, err = compress.Decompress(r, compression, logf)
 			return r, errors.Wrapf(err, "create decompressor: %q", ns)
 		}
 
-		err := archive.Compose(pw, newReader, match, numParallelColls)
+		err := archive.ComposeTiers(pw, newReader, match, numParallelColls, nsTier, onTier)
 		pw.CloseWithError(errors.Wrap(err, "compose"))
 	}()
 
@@ -0,0 +1,237 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Failure is a failed write of mongorestore
+type Failure struct {
+	// NS is empty if the error doesn't tell the namespace
+	NS string `bson:"ns,omitempty" json:"ns,omitempty"`
+	// ID is the _id of the document (duplicate key errors only)
+	ID string `bson:"id,omitempty" json:"id,omitempty"`
+	// Code is the server error code. 0 if unknown.
+	Code  int    `bson:"code,omitempty" json:"code,omitempty"`
+	Error string `bson:"error" json:"error"`
+}
+
+// CodeCount is the number of failures with the error code
+type CodeCount struct {
+	Code  int   `bson:"code" json:"code"`
+	Count int64 `bson:"count" json:"count"`
+}
+
+// NSCount is the number of failures of the namespace
+type NSCount struct {
+	NS    string `bson:"ns" json:"ns"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+var (
+	// the message of mongo-tools (see db.FilterError)
+	continueErrRe = regexp.MustCompile(`continuing through error: (.*)$`)
+	// the log of the namespace result (see mongorestore.Result)
+	finishedRe = regexp.MustCompile(`finished restoring (\S+) \((\d+) documents?, (\d+) failures?\)`)
+	// the error of the namespace with stopOnError
+	nsErrRe    = regexp.MustCompile(`^(\S+): error restoring from [^:]*: (.*)$`)
+	codeRe     = regexp.MustCompile(`\bE(\d{4,5}) `)
+	collRe     = regexp.MustCompile(`collection: (\S+)`)
+	dupIDKeyRe = regexp.MustCompile(`dup key: \{ _id: (.*) \}`)
+)
+
+// Failures collects the failed writes of mongorestore. mongorestore
+// logs them (continuing through errors) or returns the first one
+// (stop on error), so they are taken from its log and the result error.
+// Up to max failures are kept with the details, the rest are counted
+// by the error code.
+type Failures struct {
+	mu      sync.Mutex
+	max     int
+	list    []Failure
+	dropped map[int]int64
+	nss     map[string]int64
+	total   int64
+	// partial is the last line of the log written in parts
+	partial []byte
+}
+
+// NewFailures returns failures keeping up to max details.
+func NewFailures(max int) *Failures {
+	return &Failures{
+		max:     max,
+		dropped: make(map[int]int64),
+		nss:     make(map[string]int64),
+	}
+}
+
+// Write parses the lines of mongo-tools log.
+func (f *Failures) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	buf := append(f.partial, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i == -1 {
+			break
+		}
+		f.parseLine(string(buf[:i]))
+		buf = buf[i+1:]
+	}
+	f.partial = append([]byte(nil), buf...)
+
+	return len(p), nil
+}
+
+func (f *Failures) parseLine(line string) {
+	if m := continueErrRe.FindStringSubmatch(line); m != nil {
+		f.add(newFailure("", m[1]))
+		return
+	}
+	if m := finishedRe.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.ParseInt(m[3], 10, 64)
+		if n > 0 {
+			f.nss[m[1]] += n
+		}
+	}
+}
+
+// AddErr records the error mongorestore has stopped on.
+func (f *Failures) AddErr(err error) {
+	if f == nil || err == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg := err.Error()
+	ns := ""
+	if m := nsErrRe.FindStringSubmatch(msg); m != nil {
+		ns, msg = m[1], m[2]
+	}
+	f.add(newFailure(ns, msg))
+}
+
+func (f *Failures) add(fl Failure) {
+	f.total++
+	if len(f.list) < f.max {
+		f.list = append(f.list, fl)
+		return
+	}
+	f.dropped[fl.Code]++
+}
+
+func newFailure(ns, msg string) Failure {
+	fl := Failure{NS: ns, Error: strings.TrimSpace(msg)}
+	if m := codeRe.FindStringSubmatch(msg); m != nil {
+		fl.Code, _ = strconv.Atoi(m[1])
+	}
+	if m := collRe.FindStringSubmatch(msg); m != nil && fl.NS == "" {
+		fl.NS = m[1]
+	}
+	if m := dupIDKeyRe.FindStringSubmatch(msg); m != nil {
+		fl.ID = m[1]
+	}
+
+	return fl
+}
+
+// Total is the number of the failures
+func (f *Failures) Total() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.total
+}
+
+// List returns the recorded failures
+func (f *Failures) List() []Failure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Failure(nil), f.list...)
+}
+
+// Dropped returns the number of the failures without recorded details
+// by the error code
+func (f *Failures) Dropped() []CodeCount {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rv := make([]CodeCount, 0, len(f.dropped))
+	for c, n := range f.dropped {
+		rv = append(rv, CodeCount{Code: c, Count: n})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Count > rv[j].Count })
+	return rv
+}
+
+// Namespaces returns the number of the failures of the namespaces
+// reported by mongorestore
+func (f *Failures) Namespaces() []NSCount {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rv := make([]NSCount, 0, len(f.nss))
+	for ns, n := range f.nss {
+		rv = append(rv, NSCount{NS: ns, Count: n})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].NS < rv[j].NS })
+	return rv
+}
+
+// toolLog forwards mongo-tools log to the agent log and the failures
+// of the running restores. The parallel restores of the databases share
+// the failures, so the subscriptions are counted.
+type toolLog struct {
+	mu   sync.RWMutex
+	out  io.Writer
+	subs map[*Failures]int
+}
+
+var tlog = &toolLog{out: io.Discard, subs: make(map[*Failures]int)}
+
+// ToolLogWriter returns the writer for mongo-tools log (see
+// mongo-tools/common/log.SetWriter). It writes to w and collects
+// the failures of the restores.
+func ToolLogWriter(w io.Writer) io.Writer {
+	tlog.mu.Lock()
+	tlog.out = w
+	tlog.mu.Unlock()
+
+	return tlog
+}
+
+func (t *toolLog) Write(p []byte) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for f := range t.subs {
+		_, _ = f.Write(p)
+	}
+	return t.out.Write(p)
+}
+
+func (t *toolLog) subscribe(f *Failures) {
+	t.mu.Lock()
+	t.subs[f]++
+	t.mu.Unlock()
+}
+
+func (t *toolLog) unsubscribe(f *Failures) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.subs[f] <= 1 {
+		delete(t.subs, f)
+		return
+	}
+	t.subs[f]--
+}
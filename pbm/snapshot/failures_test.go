@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFailures(t *testing.T) {
+	f := NewFailures(2)
+
+	log := "2024-10-01T10:00:00.000+0000\tcontinuing through error: " +
+		"E11000 duplicate key error collection: db.c index: _id_ dup key: { _id: 1 }\n" +
+		"2024-10-01T10:00:00.000+0000\tcontinuing through error: " +
+		"E11000 duplicate key error collection: db.c index: _id_ dup key: { _id: ObjectId('66fbc1d7b2e4a1f0c8a9e123') }\n" +
+		"2024-10-01T10:00:00.000+0000\tcontinuing through error: Document failed validation\n" +
+		"2024-10-01T10:00:01.000+0000\tfinished restoring db.c (10 documents, 3 failures)\n" +
+		"2024-10-01T10:00:01.000+0000\tfinished restoring db.d (5 documents, 0 failures)\n"
+
+	// the lines may come in parts
+	for _, p := range []string{log[:50], log[50:200], log[200:]} {
+		_, _ = f.Write([]byte(p))
+	}
+
+	if f.Total() != 3 {
+		t.Errorf("total: want 3, got %d", f.Total())
+	}
+
+	wantList := []Failure{
+		{
+			NS:    "db.c",
+			ID:    "1",
+			Code:  11000,
+			Error: "E11000 duplicate key error collection: db.c index: _id_ dup key: { _id: 1 }",
+		},
+		{
+			NS:   "db.c",
+			ID:   "ObjectId('66fbc1d7b2e4a1f0c8a9e123')",
+			Code: 11000,
+			Error: "E11000 duplicate key error collection: db.c index: _id_ " +
+				"dup key: { _id: ObjectId('66fbc1d7b2e4a1f0c8a9e123') }",
+		},
+	}
+	if got := f.List(); !reflect.DeepEqual(got, wantList) {
+		t.Errorf("list: want %+v, got %+v", wantList, got)
+	}
+
+	wantDropped := []CodeCount{{Code: 0, Count: 1}}
+	if got := f.Dropped(); !reflect.DeepEqual(got, wantDropped) {
+		t.Errorf("dropped: want %+v, got %+v", wantDropped, got)
+	}
+
+	wantNSs := []NSCount{{NS: "db.c", Count: 3}}
+	if got := f.Namespaces(); !reflect.DeepEqual(got, wantNSs) {
+		t.Errorf("namespaces: want %+v, got %+v", wantNSs, got)
+	}
+}
+
+func TestFailuresAddErr(t *testing.T) {
+	f := NewFailures(10)
+	f.AddErr(errors.New("db.c: error restoring from archive on stdin: " +
+		"bulk write exception: write errors: [E11000 duplicate key error collection: db.c " +
+		"index: _id_ dup key: { _id: \"a\" }]"))
+
+	want := []Failure{{
+		NS:   "db.c",
+		Code: 11000,
+		Error: "bulk write exception: write errors: [E11000 duplicate key error collection: db.c " +
+			"index: _id_ dup key: { _id: \"a\" }]",
+		ID: "\"a\"",
+	}}
+	if got := f.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	var nf *Failures
+	nf.AddErr(errors.New("no panic"))
+}
+
+func TestToolLogWriter(t *testing.T) {
+	var out recorder
+	w := ToolLogWriter(&out)
+	defer ToolLogWriter(io.Discard)
+
+	f := NewFailures(10)
+	tlog.subscribe(f)
+	_, _ = w.Write([]byte("continuing through error: E11000 duplicate key\n"))
+	tlog.unsubscribe(f)
+	_, _ = w.Write([]byte("continuing through error: E11000 duplicate key\n"))
+
+	if f.Total() != 1 {
+		t.Errorf("failures: want 1, got %d", f.Total())
+	}
+	if out.n != 2 {
+		t.Errorf("log writes: want 2, got %d", out.n)
+	}
+}
+
+func TestToolLogSharedFailures(t *testing.T) {
+	w := ToolLogWriter(io.Discard)
+
+	// two restores of the same failures, the first one finishes early
+	f := NewFailures(10)
+	tlog.subscribe(f)
+	tlog.subscribe(f)
+	_, _ = w.Write([]byte("continuing through error: E11000 duplicate key\n"))
+	tlog.unsubscribe(f)
+	_, _ = w.Write([]byte("continuing through error: E11000 duplicate key\n"))
+	tlog.unsubscribe(f)
+	_, _ = w.Write([]byte("continuing through error: E11000 duplicate key\n"))
+
+	if f.Total() != 2 {
+		t.Errorf("failures: want 2, got %d", f.Total())
+	}
+	if len(tlog.subs) != 0 {
+		t.Errorf("subscriptions left: %v", tlog.subs)
+	}
+}
+
+type recorder struct{ n int }
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.n++
+	return len(p), nil
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/progress"
+)
+
+// NSBytes is the data of the namespace inserted by mongorestore
+type NSBytes struct {
+	// Bytes is the size of the inserted BSON documents
+	Bytes int64
+	// Done is set once mongorestore has finished the namespace
+	Done bool
+}
+
+// NSProgress tracks the namespaces being restored. mongorestore reports
+// the documents it inserts by the target namespace (see NewRestore).
+type NSProgress struct {
+	mx      sync.Mutex
+	running map[string]progress.Progressor
+	done    map[string]int64
+}
+
+func NewNSProgress() *NSProgress {
+	return &NSProgress{
+		running: make(map[string]progress.Progressor),
+		done:    make(map[string]int64),
+	}
+}
+
+func (p *NSProgress) Attach(name string, pr progress.Progressor) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.running[name] = pr
+}
+
+func (p *NSProgress) Detach(name string) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if pr, ok := p.running[name]; ok {
+		p.done[name], _ = pr.Progress()
+		delete(p.running, name)
+	}
+}
+
+// Get returns the namespaces mongorestore has started so far
+func (p *NSProgress) Get() map[string]NSBytes {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	rv := make(map[string]NSBytes, len(p.running)+len(p.done))
+	for ns, n := range p.done {
+		rv[ns] = NSBytes{Bytes: n, Done: true}
+	}
+	for ns, pr := range p.running {
+		n, _ := pr.Progress()
+		rv[ns] = NSBytes{Bytes: n}
+	}
+	return rv
+}
+
+// progressManager reports the progress to NSProgress and the progress
+// bars of mongorestore
+type progressManager struct {
+	ns   *NSProgress
+	next progress.Manager
+}
+
+func (m *progressManager) Attach(name string, pr progress.Progressor) {
+	m.ns.Attach(name, pr)
+	if m.next != nil {
+		m.next.Attach(name, pr)
+	}
+}
+
+func (m *progressManager) Detach(name string) {
+	m.ns.Detach(name)
+	if m.next != nil {
+		m.next.Detach(name)
+	}
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/progress"
+)
+
+func TestNSProgress(t *testing.T) {
+	p := NewNSProgress()
+	m := &progressManager{ns: p}
+
+	a, b := progress.NewCounter(100), progress.NewCounter(0)
+	m.Attach("db.a", a)
+	m.Attach("db.b", b)
+	a.Set(40)
+	b.Set(10)
+
+	want := map[string]NSBytes{"db.a": {Bytes: 40}, "db.b": {Bytes: 10}}
+	if got := p.Get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("running: want %v, got %v", want, got)
+	}
+
+	a.Set(100)
+	m.Detach("db.a")
+	m.Detach("db.c")
+	a.Set(200)
+
+	want = map[string]NSBytes{"db.a": {Bytes: 100, Done: true}, "db.b": {Bytes: 10}}
+	if got := p.Get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("detached: want %v, got %v", want, got)
+	}
+}
@@ -3,33 +3,51 @@ package snapshot
 import (
 	"io"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/mongodb/mongo-tools/common/db"
 	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/mongorestore"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 
 	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
 )
 
 const (
 	preserveUUID = true
 
 	batchSizeDefault = 500
+
+	// batchDocSize is the estimated average size of the documents
+	// in the insertion batches
+	batchDocSize = 16 << 10
+	// maxBatchBytes is the limit of the insertion batch of mongorestore
+	maxBatchBytes = db.MAX_MESSAGE_SIZE_BYTES
 )
 
 var ExcludeFromRestore = []string{
 	defs.DB + "." + defs.CmdStreamCollection,
 	defs.DB + "." + defs.LogCollection,
 	defs.DB + "." + defs.ConfigCollection,
+	defs.DB + "." + defs.ConfigHistoryCollection,
 	defs.DB + "." + defs.BcpCollection,
 	defs.DB + "." + defs.RestoresCollection,
 	defs.DB + "." + defs.LockCollection,
 	defs.DB + "." + defs.LockOpCollection,
 	defs.DB + "." + defs.PITRChunksCollection,
 	defs.DB + "." + defs.PITRCollection,
+	defs.DB + "." + defs.PITRGapsCollection,
+	defs.DB + "." + defs.PITRVerifyCollection,
+	defs.DB + "." + defs.ScheduleRunsCollection,
+	defs.DB + "." + defs.StorageDriftCollection,
+	defs.DB + "." + defs.QueueCollection,
 	defs.DB + "." + defs.AgentsStatusCollection,
 	defs.DB + "." + defs.PBMOpLogCollection,
 	"admin.system.version",
@@ -41,7 +59,19 @@ var ExcludeFromRestore = []string{
 	defs.DB + ".pbmPITRChunks.old",
 }
 
-type restorer struct{ *mongorestore.MongoRestore }
+// MongosExcludeFromRestore are the namespaces of a shard (or the config
+// server) data that aren't restored through mongos.
+var MongosExcludeFromRestore = []string{
+	"config.*",
+	"local.*",
+}
+
+type restorer struct {
+	*mongorestore.MongoRestore
+	mem      *memory.Grant
+	failures *Failures
+	progress *NSProgress
+}
 
 // CloneNS contains clone from/to info for cloning NS use case.
 type CloneNS struct {
@@ -71,42 +101,50 @@ func NewRestore(uri string,
 	cloneNS CloneNS,
 	numParallelColls,
 	numInsertionWorkersPerCol int,
+	reservedBatches int,
 	excludeRouterCollections bool,
-) (io.ReaderFrom, error) {
-	topts := options.New("mongorestore",
-		"0.0.1",
-		"none",
-		"",
-		true,
-		options.EnabledOptions{
-			Auth:       true,
-			Connection: true,
-			Namespace:  true,
-			URI:        true,
-		})
-	var err error
-	topts.URI, err = options.NewURI(uri)
+	merge bool,
+	noDrop bool,
+	viaMongos bool,
+	failures *Failures,
+	nsProgress *NSProgress,
+) (_ io.ReaderFrom, err error) {
+	topts, err := toolOptions(uri)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse connection string")
+		return nil, err
 	}
 
-	err = topts.NormalizeOptionsAndURI()
-	if err != nil {
-		return nil, errors.Wrap(err, "parse opts")
-	}
-
-	topts.Direct = true
+	// mongos routers are balanced by the driver
+	topts.Direct = !viaMongos
 	topts.WriteConcern = writeconcern.Majority()
-
-	batchSize := batchSizeDefault
-	if cfg.Restore.BatchSize > 0 {
-		batchSize = cfg.Restore.BatchSize
+	if wc := cfg.WriteConcern(); wc != nil {
+		topts.WriteConcern = wc
 	}
 
+	batchSize := restoreBatchSize(cfg)
+
 	if numParallelColls < 1 {
 		numParallelColls = 1
 	}
 
+	// each insertion worker of a collection holds a batch. Fewer
+	// collections are restored at once if the memory budget is short.
+	// The reservedBatches are reserved by the caller already, a collection
+	// at a time is reserved here only if there are none.
+	reservedBatches = min(max(reservedBatches, 0), numParallelColls)
+	mem, err := memory.Default.Reserve(BatchMemory(cfg, numInsertionWorkersPerCol),
+		max(1-reservedBatches, 0), numParallelColls-reservedBatches)
+	if err != nil {
+		return nil, errors.Wrap(err, "reserve insertion batches")
+	}
+	// the grant is held by the restorer till the dump is restored
+	defer func() {
+		if err != nil {
+			mem.Release()
+		}
+	}()
+	numParallelColls = reservedBatches + mem.Units()
+
 	nsExclude := ExcludeFromRestore
 	if excludeRouterCollections {
 		configColls := []string{
@@ -148,6 +186,29 @@ func NewRestore(uri string,
 		mopts.PreserveUUID = false
 	}
 
+	// merge the data into the existing collections, they already have
+	// the UUIDs of the sharded collections
+	if merge {
+		mopts.Drop = false
+		mopts.PreserveUUID = false
+	}
+
+	// keep the existing collections and documents. The documents of
+	// the backup with the _id taken are skipped (duplicate key errors
+	// are ignored without StopOnError)
+	if noDrop {
+		mopts.Drop = false
+		mopts.PreserveUUID = false
+		mopts.StopOnError = false
+	}
+
+	// the collections are created by mongos with new UUIDs. The shard-local
+	// collections aren't restored through a router.
+	if viaMongos {
+		mopts.PreserveUUID = false
+		mopts.NSExclude = append(slices.Clone(mopts.NSExclude), MongosExcludeFromRestore...)
+	}
+
 	// mongorestore calls runtime.GOMAXPROCS(MaxProcs).
 	mopts.MaxProcs = runtime.GOMAXPROCS(0)
 
@@ -157,19 +218,139 @@ func NewRestore(uri string,
 	}
 	mr.SkipUsersAndRoles = true
 
-	return &restorer{mr}, nil
+	return &restorer{MongoRestore: mr, mem: mem, failures: failures, progress: nsProgress}, nil
+}
+
+func restoreBatchSize(cfg *config.Config) int {
+	if cfg.Restore != nil && cfg.Restore.BatchSize > 0 {
+		return cfg.Restore.BatchSize
+	}
+	return batchSizeDefault
+}
+
+// BatchMemory is the memory of the batches held by the insertion workers
+// of one collection being restored.
+func BatchMemory(cfg *config.Config, numInsertionWorkersPerCol int) int64 {
+	batch := min(int64(restoreBatchSize(cfg))*batchDocSize, maxBatchBytes)
+	return int64(max(numInsertionWorkersPerCol, 1)) * batch
 }
 
 func (r *restorer) ReadFrom(from io.Reader) (int64, error) {
 	defer r.Close()
+	defer r.mem.Release()
 
 	r.InputReader = from
 
+	if r.failures != nil {
+		tlog.subscribe(r.failures)
+		defer tlog.unsubscribe(r.failures)
+	}
+	if r.progress != nil {
+		// Close stops the progress bars of mongorestore
+		bars := r.ProgressManager
+		r.ProgressManager = &progressManager{ns: r.progress, next: bars}
+		defer func() { r.ProgressManager = bars }()
+	}
+
 	rdumpResult := r.Restore()
 	if rdumpResult.Err != nil {
+		r.failures.AddErr(rdumpResult.Err)
 		return 0, errors.Wrapf(rdumpResult.Err, "restore mongo dump (successes: %d / fails: %d)",
 			rdumpResult.Successes, rdumpResult.Failures)
 	}
 
 	return 0, nil
 }
+
+// toolOptions returns mongorestore connection options of the uri. The uri
+// is parsed the same way as the PBM connections (see connect.ParseURI),
+// so SRV, TLS and auth options work for both.
+func toolOptions(uri string) (*options.ToolOptions, error) {
+	cs, err := connect.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return connStringToolOptions(cs), nil
+}
+
+// connStringToolOptions sets the tool options after the parsed connection
+// string. Unlike options.ToolOptions.NormalizeOptionsAndURI, it never
+// prompts for passwords and doesn't take the database of the uri as
+// the database to restore (it is the auth database only).
+func connStringToolOptions(cs *connstring.ConnString) *options.ToolOptions {
+	topts := options.New("mongorestore",
+		"0.0.1",
+		"none",
+		"",
+		true,
+		options.EnabledOptions{
+			Auth:       true,
+			Connection: true,
+			Namespace:  true,
+			URI:        true,
+		})
+	topts.URI = &options.URI{ConnectionString: cs.Original, ConnString: cs}
+
+	topts.Host = strings.Join(cs.Hosts, ",")
+	if cs.ReplicaSet != "" {
+		topts.Host = cs.ReplicaSet + "/" + topts.Host
+	}
+	topts.ReplicaSetName = cs.ReplicaSet
+	topts.Direct = cs.DirectConnection || cs.Connect == connstring.SingleConnect
+	if cs.RetryWritesSet {
+		topts.RetryWrites = &cs.RetryWrites
+	}
+
+	// the tools take the timeouts in seconds
+	if cs.ConnectTimeoutSet {
+		topts.Timeout = toolTimeout(cs.ConnectTimeout)
+	}
+	if cs.SocketTimeoutSet {
+		topts.SocketTimeout = toolTimeout(cs.SocketTimeout)
+	}
+	if cs.ServerSelectionTimeoutSet {
+		topts.Connection.ServerSelectionTimeout = toolTimeout(cs.ServerSelectionTimeout)
+	}
+	topts.Compressors = "none"
+	if len(cs.Compressors) != 0 {
+		topts.Compressors = strings.Join(cs.Compressors, ",")
+	}
+
+	topts.Username = cs.Username
+	topts.Password = cs.Password
+	topts.Mechanism = cs.AuthMechanism
+	switch {
+	case cs.AuthSourceSet:
+		topts.Source = cs.AuthSource
+	case cs.Database != "" && !topts.Auth.RequiresExternalDB():
+		topts.Source = cs.Database
+	}
+	switch strings.ToUpper(cs.AuthMechanism) {
+	case "GSSAPI":
+		topts.Kerberos.Service = cs.AuthMechanismProperties["SERVICE_NAME"]
+	case "MONGODB-AWS":
+		topts.AWSSessionToken = cs.AuthMechanismProperties["AWS_SESSION_TOKEN"]
+	}
+
+	topts.UseSSL = cs.SSL
+	topts.SSLCAFile = cs.SSLCaFile
+	topts.SSLPEMKeyFile = cs.SSLClientCertificateKeyFile
+	if cs.SSLClientCertificateKeyPasswordSet && cs.SSLClientCertificateKeyPassword != nil {
+		topts.SSLPEMKeyPassword = cs.SSLClientCertificateKeyPassword()
+	}
+	topts.TLSInsecure = cs.SSLInsecure
+	topts.SSLAllowInvalidCert = cs.SSLInsecure
+	topts.SSLAllowInvalidHost = cs.SSLInsecure
+
+	return topts
+}
+
+// toolTimeout returns the timeout in seconds, at least 1 second
+func toolTimeout(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return max(int((d+time.Second-1)/time.Second), 1)
+}
@@ -6,7 +6,9 @@ import (
 	"io"
 	"maps"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -18,6 +20,7 @@ import (
 
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
@@ -40,6 +43,11 @@ type Config struct {
 	EndpointURLMap map[string]string `bson:"endpointUrlMap,omitempty" json:"endpointUrlMap,omitempty" yaml:"endpointUrlMap,omitempty"`
 	Prefix         string            `bson:"prefix" json:"prefix,omitempty" yaml:"prefix,omitempty"`
 	Credentials    Credentials       `bson:"credentials" json:"-" yaml:"credentials"`
+
+	// UploadStateDir is where the staged blocks of unfinished uploads are
+	// recorded, so an upload resumes after the agent restart. The
+	// temporary directory of the OS by default.
+	UploadStateDir string `bson:"uploadStateDir,omitempty" json:"uploadStateDir,omitempty" yaml:"uploadStateDir,omitempty"`
 }
 
 func (cfg *Config) Clone() *Config {
@@ -75,6 +83,9 @@ func (cfg *Config) Equal(other *Config) bool {
 	if cfg.Credentials.Key != other.Credentials.Key {
 		return false
 	}
+	if cfg.UploadStateDir != other.UploadStateDir {
+		return false
+	}
 
 	return true
 }
@@ -93,6 +104,14 @@ func (cfg *Config) resolveEndpointURL(node string) string {
 	return ep
 }
 
+// uploadStateDir returns the directory of the upload state files
+func (cfg *Config) uploadStateDir() string {
+	if cfg.UploadStateDir != "" {
+		return cfg.UploadStateDir
+	}
+	return filepath.Join(os.TempDir(), "pbm-azure-uploads")
+}
+
 type Credentials struct {
 	Key string `bson:"key" json:"key,omitempty" yaml:"key,omitempty"`
 }
@@ -137,25 +156,28 @@ func (b *Blob) Save(name string, data io.Reader, sizeb int64) error {
 		}
 	}
 
+	ctx := context.TODO()
+	u, err := b.newUpload(ctx, name, int64(bufsz))
+	if err != nil {
+		return err
+	}
+
 	cc := runtime.NumCPU() / 2
 	if cc == 0 {
 		cc = 1
 	}
+	// the upload buffers a block for each concurrent stage
+	mem, err := memory.Default.Reserve(u.state.BlockSize, 1, cc)
+	if err != nil {
+		return errors.Wrap(err, "reserve upload buffers")
+	}
+	defer mem.Release()
 
 	if b.log != nil {
-		b.log.Debug("BufferSize is set to %d (~%dMb) | %d", bufsz, bufsz>>20, sizeb)
+		b.log.Debug("BufferSize is set to %d (~%dMb) | %d", u.state.BlockSize, u.state.BlockSize>>20, sizeb)
 	}
 
-	_, err := b.c.UploadStream(context.TODO(),
-		b.opts.Container,
-		path.Join(b.opts.Prefix, name),
-		data,
-		&azblob.UploadStreamOptions{
-			BlockSize:   int64(bufsz),
-			Concurrency: cc,
-		})
-
-	return err
+	return u.run(ctx, data, mem.Units())
 }
 
 func (b *Blob) List(prefix, suffix string) ([]storage.FileInfo, error) {
@@ -194,10 +216,14 @@ func (b *Blob) List(prefix, suffix string) ([]storage.FileInfo, error) {
 			}
 
 			if strings.HasSuffix(f, suffix) {
-				files = append(files, storage.FileInfo{
+				fi := storage.FileInfo{
 					Name: f,
 					Size: sz,
-				})
+				}
+				if b.Properties.LastModified != nil {
+					fi.ModTime = *b.Properties.LastModified
+				}
+				files = append(files, fi)
 			}
 		}
 	}
@@ -223,6 +249,9 @@ func (b *Blob) FileStat(name string) (storage.FileInfo, error) {
 	if p.ContentLength != nil {
 		inf.Size = *p.ContentLength
 	}
+	if p.LastModified != nil {
+		inf.ModTime = *p.LastModified
+	}
 
 	if inf.Size == 0 {
 		return inf, storage.ErrEmpty
@@ -281,6 +310,10 @@ func (b *Blob) SourceReader(name string) (io.ReadCloser, error) {
 }
 
 func (b *Blob) Delete(name string) error {
+	// the uncommitted blocks of an unfinished upload are gone with
+	// the blob, so is the resume
+	(&upload{key: b.uploadKey(name), file: b.uploadStateFile(name), log: b.log}).dropState()
+
 	_, err := b.c.DeleteBlob(context.TODO(), b.opts.Container, path.Join(b.opts.Prefix, name), nil)
 	if err != nil {
 		if isNotFound(err) {
@@ -0,0 +1,299 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// uploadState are the blocks of the blob staged by the failed attempts
+// of its upload. The next attempt stages only the blocks that are
+// missing or have other data, then commits the block list.
+type uploadState struct {
+	BlockSize int64 `json:"blockSize"`
+	// Blocks are the staged blocks by their number
+	Blocks map[int]stagedBlock `json:"blocks"`
+}
+
+type stagedBlock struct {
+	Size int64 `json:"size"`
+	// MD5 is the base64 md5 of the block data
+	MD5 string `json:"md5"`
+}
+
+// uploads are the states of the unfinished uploads of the process by
+// the state key. So the retry of the upload with another Blob instance
+// doesn't need to read the state file.
+var uploads = struct {
+	sync.Mutex
+	m map[string]*uploadState
+}{m: make(map[string]*uploadState)}
+
+// upload is the staged block upload of the blob
+type upload struct {
+	bb   *blockblob.Client
+	log  log.LogEvent
+	key  string
+	file string
+
+	mu    sync.Mutex
+	state *uploadState
+	// onService are the uncommitted blocks of the blob on the service
+	onService map[string]bool
+	// sent is the number of blocks staged by the upload
+	sent int
+	// ver is the number of the state changes
+	ver int
+
+	// fileMu orders the writes of the state file, saved is the version
+	// of the state in it
+	fileMu sync.Mutex
+	saved  int
+}
+
+// blockID returns the id of the nth block. The ids of the blob blocks
+// must be of the same length.
+func blockID(n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("pbm-%08d", n)))
+}
+
+// uploadKey returns the key of the upload state of the blob name
+func (b *Blob) uploadKey(name string) string {
+	h := sha256.Sum256([]byte(path.Join(b.opts.Account, b.opts.Container, b.opts.Prefix, name)))
+	return hex.EncodeToString(h[:])
+}
+
+// uploadStateFile returns the state file of the upload of the blob name
+func (b *Blob) uploadStateFile(name string) string {
+	return filepath.Join(b.opts.uploadStateDir(), b.uploadKey(name)+".json")
+}
+
+// newUpload returns the upload of the blob name. It resumes the previous
+// attempt, if the state is in memory or in the state file and the blocks
+// are still on the service. Otherwise, the upload starts over with
+// blocks of blockSize.
+func (b *Blob) newUpload(ctx context.Context, name string, blockSize int64) (*upload, error) {
+	u := &upload{
+		bb: b.c.ServiceClient().
+			NewContainerClient(b.opts.Container).
+			NewBlockBlobClient(path.Join(b.opts.Prefix, name)),
+		log:  b.log,
+		key:  b.uploadKey(name),
+		file: b.uploadStateFile(name),
+	}
+
+	u.state = u.loadState()
+	if u.state == nil || u.state.BlockSize < blockSize {
+		u.state = &uploadState{BlockSize: blockSize, Blocks: make(map[int]stagedBlock)}
+		return u, nil
+	}
+	if len(u.state.Blocks) == 0 {
+		return u, nil
+	}
+
+	l, err := u.bb.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if err != nil && !isNotFound(err) {
+		return nil, errors.Wrap(err, "get uncommitted blocks")
+	}
+	u.onService = make(map[string]bool)
+	for _, b := range l.UncommittedBlocks {
+		if b.Name != nil {
+			u.onService[*b.Name] = true
+		}
+	}
+	u.log.Debug("resume upload of %s: %d of %d recorded blocks are staged",
+		name, len(u.onService), len(u.state.Blocks))
+
+	return u, nil
+}
+
+func (u *upload) loadState() *uploadState {
+	uploads.Lock()
+	s := uploads.m[u.key]
+	uploads.Unlock()
+	if s != nil {
+		return s
+	}
+
+	data, err := os.ReadFile(u.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			u.log.Warning("read upload state %s: %v", u.file, err)
+		}
+		return nil
+	}
+	s = &uploadState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		u.log.Warning("decode upload state %s: %v", u.file, err)
+		return nil
+	}
+	return s
+}
+
+// saveState writes the data of the state version ver to the state file.
+// The versions older than the one in the file are skipped, so the blocks
+// staged while the file is written are saved by one write. Failures are
+// only logged, the upload goes on without the resume.
+func (u *upload) saveState(ver int, data []byte) {
+	u.fileMu.Lock()
+	defer u.fileMu.Unlock()
+
+	if ver <= u.saved {
+		return
+	}
+	u.saved = ver
+
+	err := os.MkdirAll(filepath.Dir(u.file), 0o700)
+	if err != nil {
+		u.log.Warning("create upload state dir: %v", err)
+		return
+	}
+	tmp := u.file + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, u.file)
+	}
+	if err != nil {
+		u.log.Warning("write upload state %s: %v", u.file, err)
+	}
+}
+
+func (u *upload) dropState() {
+	uploads.Lock()
+	delete(uploads.m, u.key)
+	uploads.Unlock()
+
+	err := os.Remove(u.file)
+	if err != nil && !os.IsNotExist(err) {
+		u.log.Warning("remove upload state %s: %v", u.file, err)
+	}
+}
+
+// isStaged returns true if the nth block with the sum is already staged
+func (u *upload) isStaged(n int, size int64, sum string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	b, ok := u.state.Blocks[n]
+	return ok && b.Size == size && b.MD5 == sum && u.onService[blockID(n)]
+}
+
+// staged records the nth block. The state file is written outside of mu,
+// so the other blocks are staged meanwhile.
+func (u *upload) staged(n int, size int64, sum string) {
+	u.mu.Lock()
+	u.state.Blocks[n] = stagedBlock{Size: size, MD5: sum}
+	u.sent++
+	u.ver++
+	ver := u.ver
+	data, err := json.Marshal(u.state)
+	u.mu.Unlock()
+
+	uploads.Lock()
+	uploads.m[u.key] = u.state
+	uploads.Unlock()
+
+	if err != nil {
+		u.log.Warning("encode upload state: %v", err)
+		return
+	}
+	u.saveState(ver, data)
+}
+
+// run stages the blocks of data with up to cc blocks at once and commits
+// the blob. On failure the state of the staged blocks is kept for the
+// next attempt.
+func (u *upload) run(ctx context.Context, data io.Reader, cc int) error {
+	bufs := make(chan []byte, cc)
+	for range cc {
+		bufs <- make([]byte, u.state.BlockSize)
+	}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	var ids []string
+	for n := 0; ; n++ {
+		var buf []byte
+		select {
+		case buf = <-bufs:
+		case <-egCtx.Done():
+			return eg.Wait()
+		}
+
+		size, err := io.ReadFull(data, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			_ = eg.Wait()
+			return errors.Wrap(err, "read data")
+		}
+		if size == 0 {
+			break
+		}
+		if n >= maxBlocks {
+			_ = eg.Wait()
+			return errors.Errorf("the data exceeds %d blocks of %d bytes", maxBlocks, u.state.BlockSize)
+		}
+
+		ids = append(ids, blockID(n))
+		eg.Go(func() error {
+			defer func() { bufs <- buf }()
+			return u.stage(egCtx, n, buf[:size])
+		})
+
+		if size < len(buf) {
+			break
+		}
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	_, err := u.bb.CommitBlockList(ctx, ids, nil)
+	if err != nil {
+		return errors.Wrap(err, "commit block list")
+	}
+	u.dropState()
+	u.log.Debug("staged %d of %d blocks, the rest are resumed", u.sent, len(ids))
+
+	return nil
+}
+
+func (u *upload) stage(ctx context.Context, n int, data []byte) error {
+	h := md5.Sum(data)
+	sum := base64.StdEncoding.EncodeToString(h[:])
+	if u.isStaged(n, int64(len(data)), sum) {
+		return nil
+	}
+
+	_, err := u.bb.StageBlock(ctx, blockID(n), nopCloser{bytes.NewReader(data)},
+		&blockblob.StageBlockOptions{
+			TransactionalValidation: blob.TransferValidationTypeMD5(h[:]),
+		})
+	if err != nil {
+		return errors.Wrapf(err, "stage block %d", n)
+	}
+	u.staged(n, int64(len(data)), sum)
+
+	return nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,219 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// fakeBlobService is the subset of the Blob service API of the staged
+// block upload. It drops the connection of the block uploads once
+// failAfter blocks are staged, if failAfter is positive.
+type fakeBlobService struct {
+	mu          sync.Mutex
+	uncommitted map[string]map[string][]byte
+	blobs       map[string][]byte
+	staged      int
+	failAfter   int
+}
+
+func (s *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := r.URL.Path
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		if s.failAfter > 0 && s.staged >= s.failAfter {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if s.uncommitted[name] == nil {
+			s.uncommitted[name] = make(map[string][]byte)
+		}
+		s.uncommitted[name][q.Get("blockid")] = data
+		s.staged++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "blocklist":
+		if len(s.uncommitted[name]) == 0 && s.blobs[name] == nil {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList><UncommittedBlocks>`)
+		for id, data := range s.uncommitted[name] {
+			fmt.Fprintf(&b, "<Block><Name>%s</Name><Size>%d</Size></Block>", id, len(data))
+		}
+		b.WriteString(`</UncommittedBlocks></BlockList>`)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(b.String()))
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var l struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&l); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, id := range l.Latest {
+			b, ok := s.uncommitted[name][id]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "InvalidBlockList")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, b...)
+		}
+		s.blobs[name] = data
+		delete(s.uncommitted, name)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *fakeBlobService) setFailAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failAfter = n
+	s.staged = 0
+}
+
+func (s *fakeBlobService) stagedBlocks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.staged
+}
+
+func newTestBlob(t *testing.T, url, stateDir string) *Blob {
+	t.Helper()
+
+	cfg := &Config{
+		Account:        "pbm",
+		Container:      "backups",
+		Credentials:    Credentials{Key: "a2V5"},
+		UploadStateDir: stateDir,
+	}
+	cred, err := azblob.NewSharedKeyCredential(cfg.Account, cfg.Credentials.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := azblob.NewClientWithSharedKeyCredential(url, cred, &azblob.ClientOptions{
+		ClientOptions: policy.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Blob{opts: cfg, log: log.DiscardEvent, c: c}
+}
+
+func TestUploadResume(t *testing.T) {
+	svc := &fakeBlobService{
+		uncommitted: make(map[string]map[string][]byte),
+		blobs:       make(map[string][]byte),
+	}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	const blockSize, blocks = 1024, 10
+	data := make([]byte, blockSize*blocks-100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	upload := func(b *Blob, data []byte) error {
+		u, err := b.newUpload(ctx, "bcp/rs0/ns.gz", blockSize)
+		if err != nil {
+			return err
+		}
+		return u.run(ctx, bytes.NewReader(data), 1)
+	}
+
+	svc.setFailAfter(4)
+	if err := upload(newTestBlob(t, srv.URL, stateDir), data); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if n := svc.stagedBlocks(); n != 4 {
+		t.Fatalf("staged before the failure: want 4, got %d", n)
+	}
+
+	// the agent restart: the state is in the file only
+	uploads.Lock()
+	uploads.m = make(map[string]*uploadState)
+	uploads.Unlock()
+
+	svc.setFailAfter(0)
+	if err := upload(newTestBlob(t, srv.URL, stateDir), data); err != nil {
+		t.Fatal(err)
+	}
+	if n := svc.stagedBlocks(); n != blocks-4 {
+		t.Errorf("staged on resume: want %d, got %d", blocks-4, n)
+	}
+	if got := svc.blobs["/backups/bcp/rs0/ns.gz"]; !bytes.Equal(got, data) {
+		t.Errorf("committed blob differs from the data: %d of %d bytes", len(got), len(data))
+	}
+	if files, _ := os.ReadDir(stateDir); len(files) != 0 {
+		t.Errorf("upload state is left after the commit: %v", files)
+	}
+}
+
+func TestUploadResumeChangedData(t *testing.T) {
+	svc := &fakeBlobService{
+		uncommitted: make(map[string]map[string][]byte),
+		blobs:       make(map[string][]byte),
+	}
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	const blockSize = 1024
+	data := bytes.Repeat([]byte("a"), blockSize*6)
+	ctx := context.Background()
+	b := newTestBlob(t, srv.URL, t.TempDir())
+
+	svc.setFailAfter(3)
+	u, err := b.newUpload(ctx, "f", blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.run(ctx, bytes.NewReader(data), 1); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+
+	// the second block has other data on retry
+	changed := bytes.Clone(data)
+	changed[blockSize+1] = 'b'
+	svc.setFailAfter(0)
+	u, err = b.newUpload(ctx, "f", blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.run(ctx, bytes.NewReader(changed), 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := svc.stagedBlocks(); n != 4 {
+		t.Errorf("staged on retry: want 4, got %d", n)
+	}
+	if got := svc.blobs["/backups/f"]; !bytes.Equal(got, changed) {
+		t.Errorf("committed blob differs from the data")
+	}
+}
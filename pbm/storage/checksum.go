@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrChecksumMismatch is returned if the data of the file doesn't match
+// its recorded checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumRecorder keeps the SHA-256 checksums of the files saved to
+// the storage. See WithChecksums.
+type ChecksumRecorder struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+func NewChecksumRecorder() *ChecksumRecorder {
+	return &ChecksumRecorder{sums: make(map[string]string)}
+}
+
+// Sums returns the checksums of the files by the file name
+func (r *ChecksumRecorder) Sums() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rv := make(map[string]string, len(r.sums))
+	for k, v := range r.sums {
+		rv[k] = v
+	}
+	return rv
+}
+
+func (r *ChecksumRecorder) set(name, sum string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sums[name] = sum
+}
+
+func (r *ChecksumRecorder) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sums, name)
+}
+
+// WithChecksums wraps the storage to record the checksums of the data
+// the files are saved with into the recorder. The data is hashed as the
+// caller passes it, so the checksum is of what SourceReader of the same
+// storage returns. The failed save forgets the checksum of the file.
+func WithChecksums(stg Storage, rec *ChecksumRecorder) Storage {
+	if stg == nil || rec == nil {
+		return stg
+	}
+
+	s := &checksumStorage{Storage: stg, rec: rec}
+	if ul, ok := stg.(UploadsLister); ok {
+		return &checksumUploadsStorage{checksumStorage: s, UploadsLister: ul}
+	}
+	return s
+}
+
+type checksumStorage struct {
+	Storage
+	rec *ChecksumRecorder
+}
+
+func (s *checksumStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *checksumStorage) Save(name string, data io.Reader, size int64) error {
+	h := sha256.New()
+	err := s.Storage.Save(name, io.TeeReader(data, h), size)
+	if err != nil {
+		s.rec.remove(name)
+		return err
+	}
+
+	s.rec.set(name, hex.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+type checksumUploadsStorage struct {
+	*checksumStorage
+	UploadsLister
+}
+
+// Checksum reads the file and returns its SHA-256 checksum
+func Checksum(stg Storage, name string) (string, error) {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return "", errors.Wrap(err, "open")
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyChecksum returns ErrChecksumMismatch if the data of the file
+// doesn't match the checksum
+func VerifyChecksum(stg Storage, name, sum string) error {
+	got, err := Checksum(stg, name)
+	if err != nil {
+		return err
+	}
+	if got != sum {
+		return errors.Wrapf(ErrChecksumMismatch, "%s: got %s, expected %s", name, got, sum)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestWithChecksums(t *testing.T) {
+	clk := &fakeClock{t: time.Date(2024, 1, 1, 10, 59, 0, 0, time.UTC)}
+	fs := &clockStorage{clk: clk, files: make(map[string][]byte)}
+	rec := NewChecksumRecorder()
+	stg := WithChecksums(fs, rec)
+
+	data := bytes.Repeat([]byte("data"), 1<<10)
+	if err := stg.Save("bcp/rs0/app.users.s2", bytes.NewReader(data), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.Sum256(data)
+	want := hex.EncodeToString(h[:])
+	sums := rec.Sums()
+	if len(sums) != 1 || sums["bcp/rs0/app.users.s2"] != want {
+		t.Fatalf("got %v, want %s", sums, want)
+	}
+
+	if err := VerifyChecksum(stg, "bcp/rs0/app.users.s2", want); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+
+	fs.files["bcp/rs0/app.users.s2"][0] = 'x'
+	err := VerifyChecksum(stg, "bcp/rs0/app.users.s2", want)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("verify corrupted: got %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	// the failed save forgets the checksum
+	if err := stg.Save("bcp/rs0/app.users.s2", failingReader{}, -1); err == nil {
+		t.Fatal("expected error")
+	}
+	if sums := rec.Sums(); len(sums) != 0 {
+		t.Errorf("after failed save: got %v", sums)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
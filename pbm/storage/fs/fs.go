@@ -1,6 +1,11 @@
 package fs
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"os"
 	"path"
@@ -24,7 +29,23 @@ func IsRetryableError(err error) bool {
 	return errors.As(err, &e)
 }
 
-const tmpFileSuffix = ".tmp"
+const (
+	tmpFileSuffix = ".tmp"
+	// markerFileSuffix is the suffix of the integrity marker of the file
+	markerFileSuffix = ".pbm.ok"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// marker is the integrity record of the file. It's saved next to the file
+// (as name + markerFileSuffix) only after the file data is synced. So
+// the file written by Save without the marker or of another size is
+// possibly truncated (e.g. NFS has acknowledged the writes which never
+// reached the server).
+type marker struct {
+	Size   int64  `json:"size"`
+	CRC32C string `json:"crc32c"`
+}
 
 type Config struct {
 	Path string `bson:"path" json:"path" yaml:"path"`
@@ -94,18 +115,48 @@ func (*FS) Type() storage.Type {
 	return storage.Filesystem
 }
 
+// writeSync writes the file and then its integrity marker. The marker of
+// the overwritten file is removed before the new data is put in place,
+// so it never describes the new data.
+func writeSync(finalpath string, data io.Reader) error {
+	removeMarker := func() error {
+		err := os.Remove(finalpath + markerFileSuffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "remove stale marker")
+		}
+		return nil
+	}
+
+	h := crc32.New(crc32c)
+	n, err := writeFileSync(finalpath, io.TeeReader(data, h), removeMarker)
+	if err != nil {
+		return err
+	}
+
+	m, err := json.Marshal(marker{Size: n, CRC32C: fmt.Sprintf("%08x", h.Sum32())})
+	if err != nil {
+		return errors.Wrap(err, "encode marker")
+	}
+	_, err = writeFileSync(finalpath+markerFileSuffix, bytes.NewReader(m), nil)
+	return errors.Wrap(err, "write marker")
+}
+
+// writeFileSync writes the data to the temporary file and renames it
+// to finalpath once it's synced. beforeRename (if set) runs right
+// before the rename.
+//
 //nolint:nonamedreturns
-func writeSync(finalpath string, data io.Reader) (err error) {
+func writeFileSync(finalpath string, data io.Reader, beforeRename func() error) (n int64, err error) {
 	filepath := finalpath + tmpFileSuffix
 
 	err = os.MkdirAll(path.Dir(filepath), os.ModeDir|0o755)
 	if err != nil {
-		return errors.Wrapf(err, "create path %s", path.Dir(filepath))
+		return 0, errors.Wrapf(err, "create path %s", path.Dir(filepath))
 	}
 
 	fw, err := os.Create(filepath)
 	if err != nil {
-		return errors.Wrapf(err, "create destination file <%s>", filepath)
+		return 0, errors.Wrapf(err, "create destination file <%s>", filepath)
 	}
 	defer func() {
 		if err != nil {
@@ -124,50 +175,74 @@ func writeSync(finalpath string, data io.Reader) (err error) {
 
 	err = os.Chmod(filepath, 0o644)
 	if err != nil {
-		return errors.Wrapf(err, "change permissions for file <%s>", filepath)
+		return 0, errors.Wrapf(err, "change permissions for file <%s>", filepath)
 	}
 
-	_, err = io.Copy(fw, data)
+	n, err = io.Copy(fw, data)
 	if err != nil {
-		return errors.Wrapf(err, "copy file <%s>", filepath)
+		return 0, errors.Wrapf(err, "copy file <%s>", filepath)
 	}
 
 	err = fw.Sync()
 	if err != nil {
-		return errors.Wrapf(err, "sync file <%s>", filepath)
+		return 0, errors.Wrapf(err, "sync file <%s>", filepath)
 	}
 
 	err = fw.Close()
 	if err != nil {
-		return errors.Wrapf(err, "close file <%s>", filepath)
+		return 0, errors.Wrapf(err, "close file <%s>", filepath)
 	}
 	fw = nil
 
+	if beforeRename != nil {
+		err = beforeRename()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	err = os.Rename(filepath, finalpath)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return n, nil
 }
 
 func (fs *FS) Save(name string, data io.Reader, _ int64) error {
 	return writeSync(path.Join(fs.root, name), data)
 }
 
+// SourceReader opens the file. If the file has the integrity marker,
+// the reader verifies the size and the checksum of the data at the end
+// of the file and returns storage.ErrTruncated instead of io.EOF
+// on mismatch.
 func (fs *FS) SourceReader(name string) (io.ReadCloser, error) {
 	filepath := path.Join(fs.root, name)
 	fr, err := os.Open(filepath)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil, storage.ErrNotExist
 	}
-	return fr, errors.Wrapf(err, "open file '%s'", filepath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file '%s'", filepath)
+	}
+
+	m, err := readMarker(filepath)
+	if err != nil || m == nil {
+		// the file stays readable, the marker is checked by VerifyIntegrity
+		return fr, nil //nolint:nilerr
+	}
+
+	return &verifyingReader{f: fr, name: name, m: m, h: crc32.New(crc32c)}, nil
 }
 
+// FileStat returns storage.ErrTruncated if the size of the file doesn't
+// match its integrity marker
 func (fs *FS) FileStat(name string) (storage.FileInfo, error) {
 	inf := storage.FileInfo{}
 
-	f, err := os.Stat(path.Join(fs.root, name))
+	filepath := path.Join(fs.root, name)
+	f, err := os.Stat(filepath)
 	if errors.Is(err, os.ErrNotExist) {
 		return inf, storage.ErrNotExist
 	}
@@ -176,6 +251,17 @@ func (fs *FS) FileStat(name string) (storage.FileInfo, error) {
 	}
 
 	inf.Size = f.Size()
+	inf.ModTime = f.ModTime()
+
+	if !f.IsDir() {
+		m, err := readMarker(filepath)
+		if err != nil {
+			return inf, err
+		}
+		if m != nil && m.Size != inf.Size {
+			return inf, errors.Wrapf(storage.ErrTruncated, "size %d, expected %d", inf.Size, m.Size)
+		}
+	}
 
 	if inf.Size == 0 {
 		return inf, storage.ErrEmpty
@@ -184,6 +270,81 @@ func (fs *FS) FileStat(name string) (storage.FileInfo, error) {
 	return inf, nil
 }
 
+// VerifyIntegrity checks that the file has the integrity marker of its
+// size. The file without the marker is possibly truncated. The checksum
+// is verified on the read of the file.
+func (fs *FS) VerifyIntegrity(name string) error {
+	filepath := path.Join(fs.root, name)
+	m, err := readMarker(filepath)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return errors.Wrap(storage.ErrTruncated, "no integrity marker")
+	}
+
+	f, err := os.Stat(filepath)
+	if errors.Is(err, os.ErrNotExist) {
+		return storage.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if f.Size() != m.Size {
+		return errors.Wrapf(storage.ErrTruncated, "size %d, expected %d", f.Size(), m.Size)
+	}
+
+	return nil
+}
+
+// readMarker returns the integrity marker of the file. Nil if there is none.
+func readMarker(filepath string) (*marker, error) {
+	data, err := os.ReadFile(filepath + markerFileSuffix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read integrity marker")
+	}
+
+	m := &marker{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode integrity marker")
+	}
+
+	return m, nil
+}
+
+type verifyingReader struct {
+	f    *os.File
+	name string
+	m    *marker
+	h    hash.Hash32
+	n    int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.n += int64(n)
+	r.h.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if r.n != r.m.Size {
+			return n, errors.Wrapf(storage.ErrTruncated, "%s: read %d bytes, expected %d", r.name, r.n, r.m.Size)
+		}
+		if sum := fmt.Sprintf("%08x", r.h.Sum32()); sum != r.m.CRC32C {
+			return n, errors.Wrapf(storage.ErrTruncated, "%s: checksum %s, expected %s", r.name, sum, r.m.CRC32C)
+		}
+	}
+
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.f.Close()
+}
+
 func (fs *FS) List(prefix, suffix string) ([]storage.FileInfo, error) {
 	var files []storage.FileInfo
 
@@ -213,8 +374,11 @@ func (fs *FS) List(prefix, suffix string) ([]storage.FileInfo, error) {
 		if suffix == "" && strings.HasSuffix(f, tmpFileSuffix) {
 			return nil
 		}
+		if suffix != markerFileSuffix && strings.HasSuffix(f, markerFileSuffix) {
+			return nil
+		}
 		if strings.HasSuffix(f, suffix) {
-			files = append(files, storage.FileInfo{Name: f, Size: info.Size()})
+			files = append(files, storage.FileInfo{Name: f, Size: info.Size(), ModTime: info.ModTime()})
 		}
 		return nil
 	})
@@ -233,10 +397,40 @@ func (fs *FS) Copy(src, dst string) error {
 
 // Delete deletes given file from FS.
 // It returns storage.ErrNotExist if a file isn't exists
+// ListUploads returns temp files left by unfinished saves.
+func (fs *FS) ListUploads(prefix string) ([]storage.IncompleteUpload, error) {
+	files, err := fs.List(prefix, tmpFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]storage.IncompleteUpload, len(files))
+	for i, f := range files {
+		uploads[i] = storage.IncompleteUpload{Name: f.Name, Initiated: f.ModTime}
+	}
+
+	return uploads, nil
+}
+
+// AbortUpload deletes the temp file.
+func (fs *FS) AbortUpload(u storage.IncompleteUpload) error {
+	return fs.Delete(u.Name)
+}
+
+// Delete deletes the file (or the directory) with its integrity marker
 func (fs *FS) Delete(name string) error {
-	err := os.RemoveAll(path.Join(fs.root, name))
+	filepath := path.Join(fs.root, name)
+	err := os.RemoveAll(filepath)
 	if os.IsNotExist(err) {
 		return storage.ErrNotExist
 	}
-	return err
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath + markerFileSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "delete integrity marker")
+	}
+	return nil
 }
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"io"
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+)
+
+// prefetchBlockSize is the size of the reads of the prefetcher
+const prefetchBlockSize = 1 << 20
+
+var errPrefetchClosed = errors.New("read of closed prefetch reader")
+
+// Prefetch returns the reader of r which reads ahead up to size bytes in
+// the background: the next blocks are downloaded while the caller is busy
+// with the current one (e.g. decompresses and inserts it). The memory is
+// bounded by the size and reserved from the memory budget: the read-ahead
+// is shorter if the budget is short. Returns r if the size isn't positive
+// or there is no memory for a block.
+//
+// The read error of r is returned after all the data read before it, and
+// is annotated with its offset, so the callers fail at the same position
+// as without the prefetcher. Close stops the prefetch and closes r.
+func Prefetch(r io.ReadCloser, size int) io.ReadCloser {
+	if size <= 0 {
+		return r
+	}
+
+	bs := min(size, prefetchBlockSize)
+	// the read-ahead is optional, it doesn't wait for the memory
+	mem, _ := memory.Default.Reserve(int64(bs), 0, size/bs)
+	n := mem.Units()
+	if n == 0 {
+		mem.Release()
+		return r
+	}
+	p := &prefetchReader{
+		src:  r,
+		mem:  mem,
+		free: make(chan []byte, n),
+		full: make(chan prefetchBlock, n),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		p.free <- make([]byte, bs)
+	}
+
+	go p.fetch()
+	return p
+}
+
+type prefetchBlock struct {
+	data []byte
+	// err is the error after the data
+	err error
+}
+
+type prefetchReader struct {
+	src  io.ReadCloser
+	mem  *memory.Grant
+	free chan []byte
+	full chan prefetchBlock
+	done chan struct{}
+	once sync.Once
+
+	// buf is the block being read, cur is its unread part
+	buf []byte
+	cur []byte
+	err error
+}
+
+func (p *prefetchReader) fetch() {
+	var off int64
+	for {
+		var b []byte
+		select {
+		case b = <-p.free:
+		case <-p.done:
+			return
+		}
+
+		n, err := io.ReadFull(p.src, b)
+		off += int64(n)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			err = io.EOF
+		default:
+			err = errors.Wrapf(err, "read at offset %d", off)
+		}
+
+		select {
+		case p.full <- prefetchBlock{data: b[:n], err: err}:
+		case <-p.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *prefetchReader) Read(b []byte) (int, error) {
+	for len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.buf != nil {
+			p.free <- p.buf[:cap(p.buf)]
+			p.buf = nil
+		}
+
+		select {
+		case blk := <-p.full:
+			p.buf, p.cur, p.err = blk.data, blk.data, blk.err
+		case <-p.done:
+			return 0, errPrefetchClosed
+		}
+	}
+
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	return n, nil
+}
+
+// Close stops the prefetch and closes the source. A read of the source
+// in progress is left to fail on the closed source.
+func (p *prefetchReader) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.mem.Release()
+	})
+	return p.src.Close()
+}
@@ -0,0 +1,178 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// latencyFile is the file of the high-latency storage: each read takes
+// the latency and returns up to chunk bytes. It fails with err once
+// failAt bytes are read if err is set.
+type latencyFile struct {
+	data    []byte
+	off     int
+	chunk   int
+	latency time.Duration
+	failAt  int
+	err     error
+	closed  bool
+}
+
+func (r *latencyFile) Read(p []byte) (int, error) {
+	time.Sleep(r.latency)
+	if r.err != nil && r.off >= r.failAt {
+		return 0, r.err
+	}
+	if r.off >= len(r.data) {
+		return 0, io.EOF
+	}
+
+	end := min(r.off+r.chunk, len(r.data), r.off+len(p))
+	if r.err != nil {
+		end = min(end, r.failAt)
+	}
+	n := copy(p, r.data[r.off:end])
+	r.off += n
+	return n, nil
+}
+
+func (r *latencyFile) Close() error {
+	r.closed = true
+	return nil
+}
+
+func randData(t testing.TB, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.New(rand.NewSource(1)).Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPrefetch(t *testing.T) {
+	data := randData(t, 5<<20+123)
+
+	for _, size := range []int{0, 1 << 10, 1 << 20, 3 << 20, 64 << 20} {
+		src := &latencyFile{data: data, chunk: 100 << 10}
+		r := storage.Prefetch(src, size)
+
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: got %d bytes, want %d", size, len(got), len(data))
+		}
+		if err := r.Close(); err != nil || !src.closed {
+			t.Fatalf("size %d: close: %v, closed %v", size, err, src.closed)
+		}
+	}
+}
+
+func TestPrefetchMemoryBudget(t *testing.T) {
+	defer func(b *memory.Budget) { memory.Default = b }(memory.Default)
+	memory.Default = &memory.Budget{}
+	memory.Default.SetLimit(2 << 20)
+
+	data := randData(t, 5<<20)
+	src := &latencyFile{data: data, chunk: 100 << 10}
+	r := storage.Prefetch(src, 8<<20)
+	if u := memory.Default.Usage(); u.UsedBytes != 2<<20 {
+		t.Errorf("read-ahead is over the budget: %+v", u)
+	}
+
+	// no memory for the read-ahead of another file
+	other := &latencyFile{data: data, chunk: 100 << 10}
+	if r := storage.Prefetch(other, 8<<20); r != io.ReadCloser(other) {
+		t.Error("prefetch without memory")
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(data))
+	}
+	r.Close()
+	if u := memory.Default.Usage(); u.UsedBytes != 0 {
+		t.Errorf("memory isn't released: %+v", u)
+	}
+}
+
+func TestPrefetchError(t *testing.T) {
+	data := randData(t, 3<<20)
+	failAt := 2<<20 + 17
+	src := &latencyFile{data: data, chunk: 64 << 10, failAt: failAt, err: storage.ErrTimeout}
+
+	got, err := io.ReadAll(storage.Prefetch(src, 4<<20))
+	if !errors.Is(err, storage.ErrTimeout) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrTimeout)
+	}
+	if !strings.Contains(err.Error(), "offset 2097169") {
+		t.Errorf("error %q has no offset", err)
+	}
+	// the data before the error is returned
+	if !bytes.Equal(got, data[:failAt]) {
+		t.Errorf("got %d bytes before the error, want %d", len(got), failAt)
+	}
+}
+
+func TestPrefetchClose(t *testing.T) {
+	src := &latencyFile{data: randData(t, 4<<20), chunk: 64 << 10}
+	r := storage.Prefetch(src, 2<<20)
+
+	b := make([]byte, 1000)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("read after close: no error")
+	}
+}
+
+// BenchmarkPrefetch reads the file of the high-latency storage and spends
+// the same time on each read block (e.g. decompression and inserts).
+// The prefetch runs the download along with the processing.
+func BenchmarkPrefetch(b *testing.B) {
+	data := randData(b, 8<<20)
+	process := func(r io.Reader) {
+		buf := make([]byte, 256<<10)
+		for {
+			_, err := io.ReadFull(r, buf)
+			if err != nil {
+				return
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"direct", 0},
+		{"prefetch-8MB", 8 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				src := &latencyFile{data: data, chunk: 256 << 10, latency: 2 * time.Millisecond}
+				r := storage.Prefetch(src, bc.size)
+				process(r)
+				r.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrReadOnly is returned on attempts to change files of the read-only storage
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ReadOnly wraps the storage to refuse saving, copying and deleting files.
+// Writes that bypass Storage methods on the unwrapped storage (like
+// the server-side copy) have to check IsReadOnly.
+func ReadOnly(stg Storage) Storage {
+	if IsReadOnly(stg) {
+		return stg
+	}
+	return &readOnlyStorage{stg}
+}
+
+// IsReadOnly returns true if stg or any storage it wraps is read-only
+func IsReadOnly(stg Storage) bool {
+	return Find(stg, func(s Storage) bool {
+		_, ok := s.(*readOnlyStorage)
+		return ok
+	}) != nil
+}
+
+type readOnlyStorage struct {
+	Storage
+}
+
+func (s *readOnlyStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *readOnlyStorage) Save(name string, _ io.Reader, _ int64) error {
+	return errors.Wrapf(ErrReadOnly, "save %s", name)
+}
+
+func (s *readOnlyStorage) Delete(name string) error {
+	return errors.Wrapf(ErrReadOnly, "delete %s", name)
+}
+
+func (s *readOnlyStorage) Copy(src, dst string) error {
+	return errors.Wrapf(ErrReadOnly, "copy %s to %s", src, dst)
+}
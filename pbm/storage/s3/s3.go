@@ -29,6 +29,7 @@ import (
 
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/memory"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
@@ -383,6 +384,14 @@ func (s *S3) Save(name string, data io.Reader, sizeb int64) error {
 		}
 	}
 
+	// the uploader buffers a part for each concurrent upload
+	mem, err := memory.Default.Reserve(partSize, 1, cc)
+	if err != nil {
+		return errors.Wrap(err, "reserve upload buffers")
+	}
+	defer mem.Release()
+	cc = mem.Units()
+
 	if s.log != nil {
 		s.log.Debug("uploading %q [size hint: %v (%v); part size: %v (%v)]",
 			name,
@@ -441,8 +450,9 @@ func (s *S3) List(prefix, suffix string) ([]storage.FileInfo, error) {
 
 				if strings.HasSuffix(f, suffix) {
 					files = append(files, storage.FileInfo{
-						Name: f,
-						Size: aws.Int64Value(o.Size),
+						Name:    f,
+						Size:    aws.Int64Value(o.Size),
+						ModTime: aws.TimeValue(o.LastModified),
 					})
 				}
 			}
@@ -455,10 +465,95 @@ func (s *S3) List(prefix, suffix string) ([]storage.FileInfo, error) {
 	return files, nil
 }
 
+// ListUploads returns incomplete multipart uploads of files with prefix.
+func (s *S3) ListUploads(prefix string) ([]storage.IncompleteUpload, error) {
+	prfx := path.Join(s.opts.Prefix, prefix)
+	if prfx != "" && !strings.HasSuffix(prfx, "/") {
+		prfx += "/"
+	}
+
+	lparams := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.opts.Bucket),
+	}
+	if prfx != "" {
+		lparams.Prefix = aws.String(prfx)
+	}
+
+	var uploads []storage.IncompleteUpload
+	err := s.s3s.ListMultipartUploadsPages(lparams,
+		func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			for _, u := range page.Uploads {
+				f := strings.TrimPrefix(aws.StringValue(u.Key), prfx)
+				if len(f) == 0 {
+					continue
+				}
+				if f[0] == '/' {
+					f = f[1:]
+				}
+
+				uploads = append(uploads, storage.IncompleteUpload{
+					Name:      f,
+					ID:        aws.StringValue(u.UploadId),
+					Initiated: aws.TimeValue(u.Initiated),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "list multipart uploads")
+	}
+
+	return uploads, nil
+}
+
+// AbortUpload aborts the multipart upload and frees its parts.
+func (s *S3) AbortUpload(u storage.IncompleteUpload) error {
+	_, err := s.s3s.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.opts.Bucket),
+		Key:      aws.String(path.Join(s.opts.Prefix, u.Name)),
+		UploadId: aws.String(u.ID),
+	})
+	return errors.Wrap(err, "abort multipart upload")
+}
+
 func (s *S3) Copy(src, dst string) error {
+	return s.copyObject(path.Join(s.opts.Bucket, s.opts.Prefix, src), dst)
+}
+
+// maxCopyObjectSize is the max size of the object CopyObject can copy
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// CopyFrom copies the src file of another S3 storage with the server-side
+// copy. The storages have to be at the same endpoint with the same
+// credentials and server-side encryption. Files larger than 5GB are not
+// copied. storage.ErrCopyUnsupported is returned otherwise.
+func (s *S3) CopyFrom(from storage.Storage, src, dst string) error {
+	f, ok := from.(*S3)
+	if !ok ||
+		f.opts.Provider != s.opts.Provider ||
+		f.opts.Region != s.opts.Region ||
+		f.opts.resolveEndpointURL(f.node) != s.opts.resolveEndpointURL(s.node) ||
+		f.opts.Credentials != s.opts.Credentials ||
+		!reflect.DeepEqual(f.opts.ServerSideEncryption, s.opts.ServerSideEncryption) {
+		return storage.ErrCopyUnsupported
+	}
+
+	stat, err := f.FileStat(src)
+	if err != nil {
+		return errors.Wrap(err, "source file stat")
+	}
+	if stat.Size > maxCopyObjectSize {
+		return storage.ErrCopyUnsupported
+	}
+
+	return s.copyObject(path.Join(f.opts.Bucket, f.opts.Prefix, src), dst)
+}
+
+// copyObject copies the source object (with the bucket) under the dst name
+func (s *S3) copyObject(source, dst string) error {
 	copyOpts := &s3.CopyObjectInput{
 		Bucket:     aws.String(s.opts.Bucket),
-		CopySource: aws.String(path.Join(s.opts.Bucket, s.opts.Prefix, src)),
+		CopySource: aws.String(source),
 		Key:        aws.String(path.Join(s.opts.Prefix, dst)),
 	}
 
@@ -519,6 +614,7 @@ func (s *S3) FileStat(name string) (storage.FileInfo, error) {
 	}
 	inf.Name = name
 	inf.Size = aws.Int64Value(h.ContentLength)
+	inf.ModTime = aws.TimeValue(h.LastModified)
 
 	if inf.Size == 0 {
 		return inf, storage.ErrEmpty
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// MaxTransferStats is the max number of the files StatsRecorder reports.
+// The fastest of the others are reported as one aggregated record.
+const MaxTransferStats = 1000
+
+// LatencyBuckets are the upper bounds of the Histogram buckets.
+// The last bucket has the latencies above the last bound.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is the distribution of the latencies over LatencyBuckets.
+//
+//nolint:lll
+type Histogram struct {
+	// Counts[i] is the number of the latencies in (LatencyBuckets[i-1], LatencyBuckets[i]].
+	// The trailing empty buckets are omitted.
+	Counts []int64       `bson:"counts,omitempty" json:"counts,omitempty" yaml:"counts,omitempty"`
+	Count  int64         `bson:"count" json:"count" yaml:"count"`
+	Sum    time.Duration `bson:"sum" json:"sum" yaml:"sum"`
+	Max    time.Duration `bson:"max" json:"max" yaml:"max"`
+}
+
+// Observe adds the latency to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	if len(h.Counts) <= i {
+		h.Counts = append(h.Counts, make([]int64, i+1-len(h.Counts))...)
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	h.Max = max(h.Max, d)
+}
+
+// Merge adds the latencies of o to the histogram.
+func (h *Histogram) Merge(o Histogram) {
+	if len(h.Counts) < len(o.Counts) {
+		h.Counts = append(h.Counts, make([]int64, len(o.Counts)-len(h.Counts))...)
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+	h.Max = max(h.Max, o.Max)
+}
+
+// Mean returns the average latency.
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket with the q-quantile
+// (0 < q <= 1) of the latencies. It's never above the max latency.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(h.Count) + 0.5)
+	rank = min(max(rank, 1), h.Count)
+	var n int64
+	for i, c := range h.Counts {
+		n += c
+		if n < rank {
+			continue
+		}
+		if i < len(LatencyBuckets) {
+			return min(LatencyBuckets[i], h.Max)
+		}
+		break
+	}
+	return h.Max
+}
+
+// TransferStats is the statistics of the data transfer of a file.
+// The oplog chunks are aggregated per replset and hour.
+//
+//nolint:lll
+type TransferStats struct {
+	// Name is the file name or, for the aggregated files, their folder.
+	Name string `bson:"name" json:"name" yaml:"name"`
+	// Op is "upload" or "download".
+	Op string `bson:"op" json:"op" yaml:"op"`
+	// Files is the number of the files aggregated.
+	Files int `bson:"files,omitempty" json:"files,omitempty" yaml:"files,omitempty"`
+	// Hour is unix time of the hour the aggregated files were transferred.
+	Hour     int64 `bson:"hour,omitempty" json:"hour,omitempty" yaml:"hour,omitempty"`
+	StartTS  int64 `bson:"start_ts" json:"start_ts" yaml:"start_ts"`
+	FinishTS int64 `bson:"finish_ts,omitempty" json:"finish_ts,omitempty" yaml:"finish_ts,omitempty"`
+	// DurationMs is the time of the transfer. Of all files if aggregated.
+	DurationMs int64 `bson:"duration_ms" json:"duration_ms" yaml:"duration_ms"`
+	Size       int64 `bson:"size" json:"size" yaml:"size"`
+	// MBps is the average throughput.
+	MBps float64 `bson:"mbps" json:"mbps" yaml:"mbps"`
+	// Retries is how many times the transfer of the file was repeated.
+	Retries int `bson:"retries,omitempty" json:"retries,omitempty" yaml:"retries,omitempty"`
+	// Latency is the distribution of the storage operations: the reads of
+	// a download and the time the storage spends between the reads of
+	// the data for an upload.
+	Latency Histogram `bson:"latency" json:"latency" yaml:"latency"`
+	Err     string    `bson:"error,omitempty" json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func (s *TransferStats) merge(o *TransferStats) {
+	if s.StartTS == 0 || o.StartTS < s.StartTS {
+		s.StartTS = o.StartTS
+	}
+	s.FinishTS = max(s.FinishTS, o.FinishTS)
+	s.Files += max(o.Files, 1)
+	s.DurationMs += o.DurationMs
+	s.Size += o.Size
+	s.Retries += o.Retries
+	s.Latency.Merge(o.Latency)
+	if s.Err == "" {
+		s.Err = o.Err
+	}
+}
+
+// StatsRecorder records the transfers of the storage files.
+// See WithStats.
+type StatsRecorder struct {
+	mu    sync.Mutex
+	files map[string]*transfer
+	now   func() time.Time
+}
+
+func NewStatsRecorder() *StatsRecorder {
+	return &StatsRecorder{files: make(map[string]*transfer), now: time.Now}
+}
+
+// transfer is the transfer of a file in progress or done
+type transfer struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	stats   TransferStats
+	start   time.Time
+	end     time.Time
+	retries int
+}
+
+func (r *StatsRecorder) start(op, name string) *transfer {
+	t := &transfer{
+		now:   r.now,
+		start: r.now(),
+		stats: TransferStats{Name: name, Op: op},
+	}
+	t.stats.StartTS = t.start.Unix()
+
+	r.mu.Lock()
+	// the repeated transfer of the file replaces the previous one
+	if prev := r.files[op+" "+name]; prev != nil {
+		prev.mu.Lock()
+		t.retries = prev.retries + 1
+		prev.mu.Unlock()
+	}
+	r.files[op+" "+name] = t
+	r.mu.Unlock()
+
+	return t
+}
+
+// observe adds the storage operation of the latency d
+// which has transferred n bytes
+func (t *transfer) observe(d time.Duration, n int) {
+	t.mu.Lock()
+	t.stats.Latency.Observe(d)
+	t.stats.Size += int64(n)
+	t.mu.Unlock()
+}
+
+func (t *transfer) add(n int) {
+	t.mu.Lock()
+	t.stats.Size += int64(n)
+	t.mu.Unlock()
+}
+
+func (t *transfer) finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.end.IsZero() {
+		return
+	}
+	t.end = t.now()
+	if err != nil {
+		t.stats.Err = err.Error()
+	}
+}
+
+func (t *transfer) get() TransferStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rv := t.stats
+	rv.Latency.Counts = append([]int64(nil), t.stats.Latency.Counts...)
+	rv.Retries = t.retries
+	end := t.end
+	if end.IsZero() {
+		end = t.now()
+	} else {
+		rv.FinishTS = end.Unix()
+	}
+	rv.DurationMs = end.Sub(t.start).Milliseconds()
+	return rv
+}
+
+// Stats returns the statistics of the transfers ordered by the start.
+// The transfers in progress have no finish time.
+// The oplog chunks (of backups and PITR) are aggregated per folder and
+// hour. Only MaxTransferStats slowest records are returned, the rest
+// are aggregated into the "other" record.
+func (r *StatsRecorder) Stats() []TransferStats {
+	r.mu.Lock()
+	files := make([]*transfer, 0, len(r.files))
+	for _, t := range r.files {
+		files = append(files, t)
+	}
+	r.mu.Unlock()
+
+	var rv []TransferStats
+	hourly := make(map[string]*TransferStats)
+	for _, t := range files {
+		s := t.get()
+		dir, ok := chunkDir(s.Name)
+		if !ok {
+			rv = append(rv, s)
+			continue
+		}
+
+		hour := s.StartTS - s.StartTS%3600
+		key := s.Op + " " + dir + " " + time.Unix(hour, 0).UTC().Format(time.RFC3339)
+		a := hourly[key]
+		if a == nil {
+			a = &TransferStats{Name: dir, Op: s.Op, Hour: hour}
+			hourly[key] = a
+		}
+		a.merge(&s)
+	}
+	for _, a := range hourly {
+		rv = append(rv, *a)
+	}
+
+	if len(rv) > MaxTransferStats {
+		sort.Slice(rv, func(i, j int) bool { return rv[i].DurationMs > rv[j].DurationMs })
+		other := TransferStats{Name: "other", Op: rv[MaxTransferStats-1].Op}
+		for i := MaxTransferStats - 1; i < len(rv); i++ {
+			other.merge(&rv[i])
+		}
+		rv = append(rv[:MaxTransferStats-1], other)
+	}
+
+	for i := range rv {
+		if rv[i].DurationMs > 0 {
+			rv[i].MBps = float64(rv[i].Size) / (1 << 20) / (float64(rv[i].DurationMs) / 1000)
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		if rv[i].StartTS != rv[j].StartTS {
+			return rv[i].StartTS < rv[j].StartTS
+		}
+		return rv[i].Name < rv[j].Name
+	})
+
+	return rv
+}
+
+// chunkDir returns the folder of the oplog chunk of a backup
+// (`<backup>/<rs>/oplog/<chunk>`) or PITR (`pbmPitr/<rs>/<date>/<chunk>`).
+func chunkDir(name string) (string, bool) {
+	if strings.HasPrefix(name, defs.PITRfsPrefix+"/") {
+		if rs, _, ok := strings.Cut(strings.TrimPrefix(name, defs.PITRfsPrefix+"/"), "/"); ok {
+			return defs.PITRfsPrefix + "/" + rs, true
+		}
+		return "", false
+	}
+
+	dir := path.Dir(name)
+	if path.Base(dir) == "oplog" && strings.Count(name, "/") == 3 {
+		return dir, true
+	}
+	return "", false
+}
+
+// WithStats wraps the storage to record the transfers of files by Save
+// and SourceReader into the recorder.
+func WithStats(stg Storage, rec *StatsRecorder) Storage {
+	if stg == nil || rec == nil {
+		return stg
+	}
+
+	s := &statsStorage{Storage: stg, rec: rec}
+	if ul, ok := stg.(UploadsLister); ok {
+		return &statsUploadsStorage{statsStorage: s, UploadsLister: ul}
+	}
+	return s
+}
+
+type statsStorage struct {
+	Storage
+	rec *StatsRecorder
+}
+
+func (s *statsStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *statsStorage) Save(name string, data io.Reader, size int64) error {
+	t := s.rec.start("upload", name)
+	err := s.Storage.Save(name, &statsUploadReader{r: data, t: t}, size)
+	t.finish(err)
+	return err
+}
+
+func (s *statsStorage) SourceReader(name string) (io.ReadCloser, error) {
+	t := s.rec.start("download", name)
+	rc, err := s.Storage.SourceReader(name)
+	if err != nil {
+		t.finish(err)
+		return nil, err
+	}
+
+	return &statsReader{rc: rc, t: t}, nil
+}
+
+type statsUploadsStorage struct {
+	*statsStorage
+	UploadsLister
+}
+
+// statsUploadReader observes the time the storage spends between
+// the reads of the data (the upload of the data read before)
+type statsUploadReader struct {
+	r    io.Reader
+	t    *transfer
+	last time.Time
+}
+
+func (r *statsUploadReader) Read(p []byte) (int, error) {
+	ts := r.t.now()
+	n, err := r.r.Read(p)
+	if r.last.IsZero() {
+		r.t.add(n)
+	} else {
+		r.t.observe(ts.Sub(r.last), n)
+	}
+	r.last = r.t.now()
+	return n, err
+}
+
+type statsReader struct {
+	rc io.ReadCloser
+	t  *transfer
+}
+
+func (r *statsReader) Read(p []byte) (int, error) {
+	ts := r.t.now()
+	n, err := r.rc.Read(p)
+	r.t.observe(r.t.now().Sub(ts), n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			r.t.finish(nil)
+		} else {
+			r.t.finish(err)
+		}
+	}
+	return n, err
+}
+
+func (r *statsReader) Close() error {
+	r.t.finish(nil)
+	return r.rc.Close()
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestHistogram(t *testing.T) {
+	ms := func(f float64) time.Duration { return time.Duration(f * float64(time.Millisecond)) }
+
+	var h Histogram
+	for _, d := range []time.Duration{ms(0.5), ms(1), ms(3), ms(7), 20 * time.Second} {
+		h.Observe(d)
+	}
+
+	want := []int64{2, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	if len(h.Counts) != len(want) {
+		t.Fatalf("counts: got %v, want %v", h.Counts, want)
+	}
+	for i := range want {
+		if h.Counts[i] != want[i] {
+			t.Fatalf("counts: got %v, want %v", h.Counts, want)
+		}
+	}
+	if h.Count != 5 || h.Max != 20*time.Second {
+		t.Errorf("got count %d, max %v", h.Count, h.Max)
+	}
+	if m := h.Mean(); m != (ms(0.5)+ms(1)+ms(3)+ms(7)+20*time.Second)/5 {
+		t.Errorf("mean: got %v", m)
+	}
+
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.2, ms(1)},
+		{0.5, ms(5)},
+		{0.8, ms(10)},
+		{0.99, 20 * time.Second},
+		{1, 20 * time.Second},
+	} {
+		if got := h.Quantile(c.q); got != c.want {
+			t.Errorf("quantile %v: got %v, want %v", c.q, got, c.want)
+		}
+	}
+
+	// the quantile is capped by the max latency
+	var small Histogram
+	small.Observe(ms(2))
+	if got := small.Quantile(0.5); got != ms(2) {
+		t.Errorf("small quantile: got %v", got)
+	}
+
+	h.Merge(small)
+	if h.Count != 6 || h.Counts[1] != 2 || h.Max != 20*time.Second || len(h.Counts) != len(want) {
+		t.Errorf("merge: got %+v", h)
+	}
+	small.Merge(h)
+	if small.Count != 7 || len(small.Counts) != len(want) || small.Counts[12] != 1 {
+		t.Errorf("merge into shorter: got %+v", small)
+	}
+
+	var empty Histogram
+	if empty.Quantile(0.5) != 0 || empty.Mean() != 0 {
+		t.Error("empty histogram")
+	}
+}
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) sleep(d time.Duration) { c.t = c.t.Add(d) }
+
+// clockStorage transfers the data in 1KB blocks, each takes the latency
+type clockStorage struct {
+	Storage
+	clk     *fakeClock
+	latency time.Duration
+	files   map[string][]byte
+	readErr error
+}
+
+func (s *clockStorage) Save(name string, data io.Reader, _ int64) error {
+	var buf bytes.Buffer
+	b := make([]byte, 1<<10)
+	for {
+		n, err := data.Read(b)
+		buf.Write(b[:n])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.clk.sleep(s.latency)
+	}
+	s.files[name] = buf.Bytes()
+	return nil
+}
+
+func (s *clockStorage) SourceReader(name string) (io.ReadCloser, error) {
+	data, ok := s.files[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return io.NopCloser(&clockReader{s: s, data: data}), nil
+}
+
+type clockReader struct {
+	s    *clockStorage
+	data []byte
+}
+
+func (r *clockReader) Read(p []byte) (int, error) {
+	r.s.clk.sleep(r.s.latency)
+	if len(r.data) == 0 {
+		if r.s.readErr != nil {
+			return 0, r.s.readErr
+		}
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), 1<<10)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func newStatsTest(latency time.Duration) (*clockStorage, *StatsRecorder, Storage) {
+	clk := &fakeClock{t: time.Date(2024, 1, 1, 10, 59, 0, 0, time.UTC)}
+	fs := &clockStorage{clk: clk, latency: latency, files: make(map[string][]byte)}
+	rec := NewStatsRecorder()
+	rec.now = clk.now
+	return fs, rec, WithStats(fs, rec)
+}
+
+func TestStatsRecorder(t *testing.T) {
+	fs, rec, stg := newStatsTest(5 * time.Millisecond)
+	data := make([]byte, 10<<10)
+
+	if err := stg.Save("bcp/rs0/app.users.s2", bytes.NewReader(data), -1); err != nil {
+		t.Fatal(err)
+	}
+	// the repeated upload is the retry
+	if err := stg.Save("bcp/rs0/app.users.s2", bytes.NewReader(data), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := stg.SourceReader("bcp/rs0/app.users.s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// the oplog chunks of two hours
+	for i := 0; i < 4; i++ {
+		name := "pbmPitr/rs0/20240101/chunk" + strconv.Itoa(i)
+		if err := stg.Save(name, bytes.NewReader(data[:2<<10]), -1); err != nil {
+			t.Fatal(err)
+		}
+		fs.clk.sleep(20 * time.Second)
+	}
+
+	fs.readErr = errors.New("connection reset")
+	r, err = stg.SourceReader("bcp/rs0/app.users.s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err == nil {
+		t.Fatal("no read error")
+	}
+
+	got := rec.Stats()
+	if len(got) != 4 {
+		t.Fatalf("got %d records: %+v", len(got), got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].StartTS < got[i-1].StartTS {
+			t.Errorf("not ordered by the start: %+v", got)
+		}
+	}
+
+	// the pitr chunks are uploaded after the file and before the last download
+	up, h1, h2, down := got[0], got[1], got[2], got[3]
+	if up.Op != "upload" || up.Retries != 1 || up.Size != 10<<10 {
+		t.Errorf("upload: got %+v", up)
+	}
+	// 11 reads of the data, the storage works between them
+	if up.Latency.Count != 10 || up.Latency.Max != 5*time.Millisecond || up.DurationMs != 50 {
+		t.Errorf("upload latency: got %+v, %d ms", up.Latency, up.DurationMs)
+	}
+	if up.MBps != float64(10<<10)/(1<<20)/0.05 {
+		t.Errorf("upload throughput: got %v", up.MBps)
+	}
+
+	if down.Op != "download" || down.Retries != 1 || down.Err == "" || down.FinishTS == 0 {
+		t.Errorf("download: got %+v", down)
+	}
+	// 10 blocks and the failed read
+	if down.Latency.Count != 11 || down.Size != 10<<10 {
+		t.Errorf("download latency: got %+v", down.Latency)
+	}
+
+	hour := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC).Unix()
+	if h1.Name != "pbmPitr/rs0" || h1.Files != 3 || h1.Hour != hour-3600 || h1.Size != 6<<10 {
+		t.Errorf("first hour: got %+v", h1)
+	}
+	if h2.Name != "pbmPitr/rs0" || h2.Files != 1 || h2.Hour != hour {
+		t.Errorf("second hour: got %+v", h2)
+	}
+}
+
+func TestStatsRecorderLimit(t *testing.T) {
+	fs, rec, stg := newStatsTest(0)
+
+	for i := 0; i < MaxTransferStats+5; i++ {
+		fs.latency = time.Duration(i) * time.Millisecond
+		if err := stg.Save("bcp/rs0/f"+strconv.Itoa(i), bytes.NewReader(make([]byte, 2<<10)), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := rec.Stats()
+	if len(got) != MaxTransferStats {
+		t.Fatalf("got %d records", len(got))
+	}
+	var other *TransferStats
+	for i := range got {
+		if got[i].Name == "other" {
+			other = &got[i]
+		} else if got[i].DurationMs < 12 {
+			t.Errorf("fast file is kept: %+v", got[i])
+		}
+	}
+	// 2 blocks of each file, so 2x of the latency
+	if other == nil || other.Files != 6 || other.DurationMs != 2*(0+1+2+3+4+5) {
+		t.Errorf("other: got %+v", other)
+	}
+}
+
+func TestChunkDir(t *testing.T) {
+	for name, want := range map[string]string{
+		"pbmPitr/rs0/20240101/20240101102030-1.20240101103030-2.s2": "pbmPitr/rs0",
+		"bcp/rs0/oplog/20240101102030-1.20240101103030-2.s2":        "bcp/rs0/oplog",
+		"bcp/rs0/oplog":          "",
+		"bcp/rs0/app.oplog.s2":   "",
+		"bcp/rs0/files/oplog/wt": "",
+	} {
+		got, ok := chunkDir(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("%s: got %q, %v", name, got, ok)
+		}
+	}
+}
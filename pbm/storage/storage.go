@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
@@ -16,6 +17,11 @@ var (
 	ErrNotExist      = errors.New("no such file")
 	ErrEmpty         = errors.New("file is empty")
 	ErrUninitialized = errors.New("uninitialized")
+	// ErrTruncated is returned if the file doesn't match its integrity record
+	ErrTruncated = errors.New("possibly truncated")
+	// ErrCopyUnsupported is returned by ServerSideCopier if the file
+	// can't be copied between the storages without downloading it
+	ErrCopyUnsupported = errors.New("server-side copy is not supported")
 )
 
 // Type represents a type of the destination storage for backups
@@ -32,6 +38,8 @@ const (
 type FileInfo struct {
 	Name string // with path
 	Size int64
+	// ModTime is the last modification time. Zero if unknown.
+	ModTime time.Time
 }
 
 type Storage interface {
@@ -50,6 +58,77 @@ type Storage interface {
 	Copy(src, dst string) error
 }
 
+type wrapper interface {
+	Unwrap() Storage
+}
+
+// Unwrap returns the underlying storage if stg is a wrapper
+// (e.g. the metered one). Otherwise, it returns stg.
+func Unwrap(stg Storage) Storage {
+	return Find(stg, func(s Storage) bool {
+		_, ok := s.(wrapper)
+		return !ok
+	})
+}
+
+// Find returns the first of stg and the storages it wraps for which
+// match returns true. Nil if there is none.
+func Find(stg Storage, match func(Storage) bool) Storage {
+	for {
+		if match(stg) {
+			return stg
+		}
+
+		w, ok := stg.(wrapper)
+		if !ok {
+			return nil
+		}
+		stg = w.Unwrap()
+	}
+}
+
+// ServerSideCopier is implemented by storages that can copy files
+// from another storage without the data transfer through PBM.
+type ServerSideCopier interface {
+	// CopyFrom copies the src file of the from storage under the dst name.
+	// It returns ErrCopyUnsupported if the storages are not compatible.
+	CopyFrom(from Storage, src, dst string) error
+}
+
+// IntegrityVerifier is implemented by storages that keep the integrity
+// records of the saved files.
+type IntegrityVerifier interface {
+	// VerifyIntegrity returns ErrTruncated if the file has no integrity
+	// record or doesn't match it.
+	VerifyIntegrity(name string) error
+}
+
+// VerifyIntegrity checks the integrity record of the file.
+// It's no-op for storages that don't keep the records.
+func VerifyIntegrity(stg Storage, name string) error {
+	v, ok := Unwrap(stg).(IntegrityVerifier)
+	if !ok {
+		return nil
+	}
+	return v.VerifyIntegrity(name)
+}
+
+// IncompleteUpload is a not committed file upload.
+type IncompleteUpload struct {
+	Name      string    `json:"name"` // with path
+	ID        string    `json:"id,omitempty"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// UploadsLister is implemented by storages that can keep incomplete
+// uploads (like S3 multipart uploads) after an aborted or crashed upload.
+type UploadsLister interface {
+	// ListUploads returns incomplete uploads of files with given prefix.
+	ListUploads(prefix string) ([]IncompleteUpload, error)
+	// AbortUpload discards the upload and its data.
+	AbortUpload(u IncompleteUpload) error
+}
+
 // ParseType parses string and returns storage type
 func ParseType(s string) Type {
 	switch s {
@@ -92,12 +171,12 @@ func HasReadAccess(ctx context.Context, stg Storage) error {
 			return ErrUninitialized
 		}
 
-		return errors.Wrap(err, "file stat")
+		return errors.WithCode(errors.Wrap(err, "file stat"), errors.CodeStorageUnreachable)
 	}
 
 	r, err := stg.SourceReader(defs.StorInitFile)
 	if err != nil {
-		return errors.Wrap(err, "open file")
+		return errors.WithCode(errors.Wrap(err, "open file"), errors.CodeStorageUnreachable)
 	}
 	defer func() {
 		err := r.Close()
@@ -111,7 +190,7 @@ func HasReadAccess(ctx context.Context, stg Storage) error {
 	var buf [MaxCount]byte
 	n, err := r.Read(buf[:])
 	if err != nil && !errors.Is(err, io.EOF) {
-		return errors.Wrap(err, "read file")
+		return errors.WithCode(errors.Wrap(err, "read file"), errors.CodeStorageUnreachable)
 	}
 
 	expect := MaxCount
@@ -184,10 +263,24 @@ func Upload(
 	compressLevel *int,
 	fname string,
 	sizeb int64,
+) (int64, error) {
+	return UploadPool(ctx, src, dst, nil, compression, compressLevel, fname, sizeb)
+}
+
+// UploadPool is Upload with the data compressed by the pool
+func UploadPool(
+	ctx context.Context,
+	src Source,
+	dst Storage,
+	pool *compress.Pool,
+	compression compress.CompressionType,
+	compressLevel *int,
+	fname string,
+	sizeb int64,
 ) (int64, error) {
 	r, pw := io.Pipe()
 
-	w, err := compress.Compress(pw, compression, compressLevel)
+	w, err := pool.Compress(pw, compression, compressLevel)
 	if err != nil {
 		return 0, err
 	}
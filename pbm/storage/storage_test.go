@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// statStorage fails the stat of the files with the err
+type statStorage struct {
+	Storage
+	err error
+}
+
+func (s *statStorage) FileStat(string) (FileInfo, error) {
+	return FileInfo{}, s.err
+}
+
+func TestHasReadAccessCode(t *testing.T) {
+	err := HasReadAccess(context.Background(), &statStorage{err: errors.New("dial tcp: connection refused")})
+	err = errors.Wrap(errors.Wrap(err, "check read access"), "backup")
+	if code := errors.CodeOf(err); code != errors.CodeStorageUnreachable {
+		t.Errorf("want code %s, got %q: %v", errors.CodeStorageUnreachable, code, err)
+	}
+
+	err = HasReadAccess(context.Background(), &statStorage{err: ErrNotExist})
+	if !errors.Is(err, ErrUninitialized) || errors.CodeOf(err) != "" {
+		t.Errorf("uninitialized storage: want no code, got %q: %v", errors.CodeOf(err), err)
+	}
+}
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// ErrTimeout is returned if the storage operation hasn't completed
+// (or hasn't made progress) in time
+var ErrTimeout = errors.New("storage operation timeout")
+
+const (
+	DefaultMetadataTimeout = 2 * time.Minute
+	DefaultIdleTimeout     = 10 * time.Minute
+)
+
+// Timeouts are the limits of the storage operations
+type Timeouts struct {
+	// Metadata limits the operations without data transfer
+	// (stat, list, delete, opening and closing of a file).
+	Metadata time.Duration
+	// Idle limits the data transfer without progress: the time Save
+	// hasn't read the data or a read of the file hasn't returned.
+	// The time Save waits for the data from the caller isn't counted.
+	Idle time.Duration
+}
+
+// WithTimeouts wraps the storage to fail the operations that hang
+// (e.g. on a hung NFS mount) with ErrTimeout instead of blocking
+// the caller forever. The calls are made in goroutines watched by
+// the deadlines since the storages don't take a context. The call that
+// has timed out is left running until it returns on its own and
+// a save completed after the timeout is deleted unless the file has been
+// saved again since then (e.g. by a retry).
+//
+// Copy is limited by the idle timeout as a whole: its progress isn't
+// visible to the wrapper.
+func WithTimeouts(stg Storage, t Timeouts) Storage {
+	if stg == nil {
+		return nil
+	}
+
+	s := &timeoutStorage{stg: stg, t: t}
+	if ul, ok := stg.(UploadsLister); ok {
+		return &timeoutUploadsStorage{timeoutStorage: s, ul: ul}
+	}
+	return s
+}
+
+type timeoutStorage struct {
+	stg Storage
+	t   Timeouts
+
+	// mu serializes the start of the saves with the cleanup of the late
+	// ones. saves is the last save of each name being run.
+	mu    sync.Mutex
+	seq   uint64
+	saves map[string]uint64
+}
+
+func (s *timeoutStorage) Unwrap() Storage {
+	return s.stg
+}
+
+func (s *timeoutStorage) Type() Type {
+	return s.stg.Type()
+}
+
+func (s *timeoutStorage) Save(name string, data io.Reader, size int64) error {
+	if s.t.Idle <= 0 {
+		return s.stg.Save(name, data, size)
+	}
+
+	id := s.startSave(name)
+	r := &progressReader{r: data, last: time.Now()}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.stg.Save(name, r, size)
+	}()
+
+	tk := time.NewTicker(max(s.t.Idle/10, 10*time.Millisecond))
+	defer tk.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			s.endSave(name, id, false)
+			return err
+		case <-tk.C:
+			if r.idle() < s.t.Idle {
+				continue
+			}
+
+			// fail the following reads, so the save cleans up its
+			// temp files if it's still running
+			r.abort()
+			go func() {
+				err := <-done
+				s.endSave(name, id, err == nil)
+			}()
+			return errors.Wrapf(ErrTimeout, "save %s: no progress in %v", name, s.t.Idle)
+		}
+	}
+}
+
+// startSave registers the save of the name and returns its id
+func (s *timeoutStorage) startSave(name string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saves == nil {
+		s.saves = make(map[string]uint64)
+	}
+	s.seq++
+	s.saves[name] = s.seq
+	return s.seq
+}
+
+// endSave unregisters the save. The file saved after the timeout
+// (cleanup) is deleted only if no newer save of the name has started.
+// The delete is made under the lock, so a newer save waits for it
+// rather than being deleted.
+func (s *timeoutStorage) endSave(name string, id uint64, cleanup bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saves[name] != id {
+		return
+	}
+	delete(s.saves, name)
+
+	if cleanup {
+		_ = s.Delete(name)
+	}
+}
+
+func (s *timeoutStorage) SourceReader(name string) (io.ReadCloser, error) {
+	open := func() (io.ReadCloser, error) {
+		return s.stg.SourceReader(name)
+	}
+	// close the file if it's opened after the timeout
+	late := func(rc io.ReadCloser, err error) {
+		if err == nil {
+			rc.Close()
+		}
+	}
+	rc, err := callLate(s.t.Metadata, "open "+name, open, late)
+	if err != nil {
+		return nil, err
+	}
+
+	return &timeoutReader{rc: rc, name: name, t: s.t}, nil
+}
+
+func (s *timeoutStorage) FileStat(name string) (FileInfo, error) {
+	return call(s.t.Metadata, "stat "+name, func() (FileInfo, error) {
+		return s.stg.FileStat(name)
+	})
+}
+
+func (s *timeoutStorage) List(prefix, suffix string) ([]FileInfo, error) {
+	return call(s.t.Metadata, "list "+prefix, func() ([]FileInfo, error) {
+		return s.stg.List(prefix, suffix)
+	})
+}
+
+func (s *timeoutStorage) Delete(name string) error {
+	_, err := call(s.t.Metadata, "delete "+name, func() (struct{}, error) {
+		return struct{}{}, s.stg.Delete(name)
+	})
+	return err
+}
+
+func (s *timeoutStorage) Copy(src, dst string) error {
+	_, err := call(s.t.Idle, "copy "+src+" to "+dst, func() (struct{}, error) {
+		return struct{}{}, s.stg.Copy(src, dst)
+	})
+	return err
+}
+
+type timeoutUploadsStorage struct {
+	*timeoutStorage
+	ul UploadsLister
+}
+
+func (s *timeoutUploadsStorage) ListUploads(prefix string) ([]IncompleteUpload, error) {
+	return call(s.t.Metadata, "list uploads "+prefix, func() ([]IncompleteUpload, error) {
+		return s.ul.ListUploads(prefix)
+	})
+}
+
+func (s *timeoutUploadsStorage) AbortUpload(u IncompleteUpload) error {
+	_, err := call(s.t.Metadata, "abort upload "+u.Name, func() (struct{}, error) {
+		return struct{}{}, s.ul.AbortUpload(u)
+	})
+	return err
+}
+
+// call runs f and waits for it up to d. Zero d is no limit.
+func call[T any](d time.Duration, op string, f func() (T, error)) (T, error) {
+	return callLate(d, op, f, nil)
+}
+
+// callLate is call with the late callback for the result of f
+// returned after the timeout
+func callLate[T any](d time.Duration, op string, f func() (T, error), late func(T, error)) (T, error) {
+	if d <= 0 {
+		return f()
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := f()
+		done <- result{v, err}
+	}()
+
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-tm.C:
+		if late != nil {
+			go func() {
+				r := <-done
+				late(r.v, r.err)
+			}()
+		}
+		var zero T
+		return zero, errors.Wrapf(ErrTimeout, "%s: no response in %v", op, d)
+	}
+}
+
+// progressReader tracks the time since the storage has read the data
+type progressReader struct {
+	r io.Reader
+
+	mu      sync.Mutex
+	last    time.Time
+	reading bool
+	aborted bool
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	if r.aborted {
+		r.mu.Unlock()
+		return 0, ErrTimeout
+	}
+	r.reading = true
+	r.mu.Unlock()
+
+	n, err := r.r.Read(p)
+
+	r.mu.Lock()
+	r.reading = false
+	r.last = time.Now()
+	r.mu.Unlock()
+
+	return n, err
+}
+
+// idle returns the time the storage hasn't read the data.
+// It's zero while the data is being read from the caller.
+func (r *progressReader) idle() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reading {
+		return 0
+	}
+	return time.Since(r.last)
+}
+
+func (r *progressReader) abort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.aborted = true
+}
+
+// timeoutReader limits each read of the file by the idle timeout.
+// The data is read into the own buffer of the reader: a read that has
+// timed out may complete later, so the buffer is left to it and
+// the reader fails all further reads.
+type timeoutReader struct {
+	rc   io.ReadCloser
+	name string
+	t    Timeouts
+
+	buf []byte
+	err error
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.t.Idle <= 0 {
+		return r.rc.Read(p)
+	}
+
+	if len(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+
+	type result struct {
+		n   int
+		err error
+	}
+	res, err := call(r.t.Idle, "read "+r.name, func() (result, error) {
+		n, err := r.rc.Read(buf)
+		return result{n, err}, nil
+	})
+	if err != nil {
+		r.err = err
+		r.buf = nil
+		return 0, err
+	}
+
+	copy(p, buf[:res.n])
+	return res.n, res.err
+}
+
+func (r *timeoutReader) Close() error {
+	_, err := call(r.t.Metadata, "close "+r.name, func() (struct{}, error) {
+		return struct{}{}, r.rc.Close()
+	})
+	return err
+}
@@ -0,0 +1,235 @@
+package storage_test
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// blockingFS is the storage which hangs the operations until unblocked,
+// the way a hung NFS mount does
+type blockingFS struct {
+	block chan struct{}
+
+	mu      sync.Mutex
+	files   map[string][]byte
+	deleted []string
+}
+
+func newBlockingFS() *blockingFS {
+	return &blockingFS{block: make(chan struct{}), files: map[string][]byte{}}
+}
+
+func (s *blockingFS) unblock() { close(s.block) }
+
+func (s *blockingFS) Type() storage.Type { return storage.Filesystem }
+
+// Save hangs after the data is read (e.g. on fsync)
+func (s *blockingFS) Save(name string, data io.Reader, _ int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	<-s.block
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = b
+	return nil
+}
+
+func (s *blockingFS) SourceReader(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.files[name]
+	if !ok {
+		return nil, storage.ErrNotExist
+	}
+	return io.NopCloser(&blockingReader{r: bytes.NewReader(b), block: s.block}), nil
+}
+
+func (s *blockingFS) FileStat(name string) (storage.FileInfo, error) {
+	<-s.block
+	return storage.FileInfo{Name: name}, nil
+}
+
+func (s *blockingFS) List(string, string) ([]storage.FileInfo, error) {
+	<-s.block
+	return nil, nil
+}
+
+func (s *blockingFS) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, name)
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func (s *blockingFS) Copy(string, string) error { return nil }
+
+func (s *blockingFS) isDeleted(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.deleted {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+type blockingReader struct {
+	r     io.Reader
+	block chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.block
+	return r.r.Read(p)
+}
+
+// slowReader delivers the data after the delay
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
+
+var testTimeouts = storage.Timeouts{
+	Metadata: 50 * time.Millisecond,
+	Idle:     50 * time.Millisecond,
+}
+
+func TestTimeoutMetadata(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblock()
+	stg := storage.WithTimeouts(fs, testTimeouts)
+
+	if _, err := stg.FileStat("f"); !errors.Is(err, storage.ErrTimeout) {
+		t.Errorf("stat: expected ErrTimeout, got %v", err)
+	}
+	if _, err := stg.List("", ""); !errors.Is(err, storage.ErrTimeout) {
+		t.Errorf("list: expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestTimeoutSave(t *testing.T) {
+	fs := newBlockingFS()
+	stg := storage.WithTimeouts(fs, testTimeouts)
+
+	err := stg.Save("f", bytes.NewReader([]byte("data")), 4)
+	if !errors.Is(err, storage.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+
+	// the save completed after the timeout is cleaned up
+	fs.unblock()
+	deadline := time.Now().Add(time.Second)
+	for !fs.isDeleted("f") {
+		if time.Now().After(deadline) {
+			t.Fatal("the late save is not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// firstSaveBlockingFS hangs only the first save
+type firstSaveBlockingFS struct {
+	*blockingFS
+	saves atomic.Int32
+}
+
+func (s *firstSaveBlockingFS) Save(name string, data io.Reader, _ int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if s.saves.Add(1) == 1 {
+		<-s.block
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = b
+	return nil
+}
+
+// Copy hangs like the rest of the operations
+func (s *firstSaveBlockingFS) Copy(string, string) error {
+	<-s.block
+	return nil
+}
+
+func TestTimeoutSaveRetried(t *testing.T) {
+	fs := &firstSaveBlockingFS{blockingFS: newBlockingFS()}
+	stg := storage.WithTimeouts(fs, testTimeouts)
+
+	err := stg.Save("f", bytes.NewReader([]byte("data")), 4)
+	if !errors.Is(err, storage.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if err := stg.Copy("f", "g"); !errors.Is(err, storage.ErrTimeout) {
+		t.Errorf("copy: expected ErrTimeout, got %v", err)
+	}
+
+	// the retry is done before the timed out save completes
+	if err := stg.Save("f", bytes.NewReader([]byte("retry")), 5); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	fs.unblock()
+
+	time.Sleep(5 * testTimeouts.Metadata)
+	if fs.isDeleted("f") {
+		t.Error("the late save has deleted the retried file")
+	}
+}
+
+func TestTimeoutSaveSlowCaller(t *testing.T) {
+	fs := newBlockingFS()
+	fs.unblock()
+	stg := storage.WithTimeouts(fs, testTimeouts)
+
+	// waiting for the data from the caller is not the storage idle time
+	data := &slowReader{r: bytes.NewReader([]byte("data")), delay: 3 * testTimeouts.Idle}
+	if err := stg.Save("f", data, 4); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if fs.isDeleted("f") {
+		t.Error("the file is deleted")
+	}
+}
+
+func TestTimeoutRead(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblock()
+	fs.files["f"] = []byte("data")
+	stg := storage.WithTimeouts(fs, testTimeouts)
+
+	r, err := stg.SourceReader("f")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, storage.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, storage.ErrTimeout) {
+		t.Errorf("read after timeout: expected ErrTimeout, got %v", err)
+	}
+}
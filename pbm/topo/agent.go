@@ -4,6 +4,7 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -42,6 +43,9 @@ type AgentStat struct {
 	// Arbiter is true for argiter node.
 	Arbiter bool `bson:"arb"`
 
+	// Tags are the replset member tags of the node.
+	Tags map[string]string `bson:"tags,omitempty"`
+
 	// DelaySecs is the node configured replication delay (lag).
 	DelaySecs int32 `bson:"delay"`
 
@@ -71,6 +75,19 @@ type AgentStat struct {
 	// Heartbeat is agent's last seen cluster time.
 	Heartbeat primitive.Timestamp `bson:"hb"`
 
+	// OplogWindow is the oplog of the node. It's refreshed less often
+	// than the heartbeat. Nil for arbiters and older agents.
+	OplogWindow *OplogWindow `bson:"oplw,omitempty"`
+
+	// Privileges is the privileges check of the PBM user of the agent.
+	// Nil for older agents.
+	Privileges *Privileges `bson:"privs,omitempty"`
+
+	// ClockOffsetSec is the offset of the agent wall clock from the cluster
+	// time, positive if the agent clock is ahead. Nil for older agents and
+	// until the agent has read the cluster time.
+	ClockOffsetSec *int64 `bson:"clko,omitempty"`
+
 	// Err can be any error.
 	Err string `bson:"e"`
 }
@@ -84,6 +101,55 @@ type SubsysStatus struct {
 	Err string `bson:"e"`
 }
 
+// OplogWindow is the time range covered by the oplog of the node
+// and how fast the oplog grows.
+type OplogWindow struct {
+	First primitive.Timestamp `bson:"first" json:"first"`
+	Last  primitive.Timestamp `bson:"last" json:"last"`
+
+	// Size is the size (in bytes) of the oplog records
+	Size int64 `bson:"size" json:"size"`
+	// MaxSize is the configured size (in bytes) of the oplog
+	MaxSize int64 `bson:"maxSize" json:"maxSize"`
+	// Rate is the average growth (bytes per second) of the oplog
+	// over the window
+	Rate float64 `bson:"rate" json:"rate"`
+
+	// UpdatedAt is the time (unix) the window was read
+	UpdatedAt int64 `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Span returns the time between the first and the last oplog records.
+func (w *OplogWindow) Span() time.Duration {
+	if w.Last.T < w.First.T {
+		return 0
+	}
+	return time.Duration(w.Last.T-w.First.T) * time.Second
+}
+
+// Projected returns the window the oplog keeps at the current growth
+// rate once it reaches the max size. It's the current span if the oplog
+// is full (or can be bigger than the max size due to the min retention).
+func (w *OplogWindow) Projected() time.Duration {
+	span := w.Span()
+	if w.Rate <= 0 || w.MaxSize <= w.Size {
+		return span
+	}
+
+	return max(span, time.Duration(float64(w.MaxSize)/w.Rate*float64(time.Second)))
+}
+
+// ClockSkew returns the offset of the agent clock from the cluster time
+// and true if it's beyond limit. The offset is 0 if it's unknown.
+func (s *AgentStat) ClockSkew(limit time.Duration) (time.Duration, bool) {
+	if s.ClockOffsetSec == nil {
+		return 0, false
+	}
+
+	d := time.Duration(*s.ClockOffsetSec) * time.Second
+	return d, d > limit || d < -limit
+}
+
 // IsStale returns true if agent's heartbeat is steal for the give `t` cluster time.
 func (s *AgentStat) IsStale(t primitive.Timestamp) bool {
 	return s.Heartbeat.T+defs.StaleFrameSec < t.T
@@ -3,6 +3,7 @@ package topo
 import (
 	"context"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -171,6 +172,63 @@ func getShardMapImpl(ctx context.Context, m *mongo.Client) (map[ReplsetName]Shar
 	return shards, nil
 }
 
+// ActiveMongos returns hosts of mongos routers pinged the cluster
+// within the last since, the most recent first.
+func ActiveMongos(ctx context.Context, conn connect.Client, since time.Duration) ([]string, error) {
+	cur, err := conn.MongoClient().Database("config").Collection("mongos").
+		Find(ctx,
+			bson.D{{"ping", bson.D{{"$gte", time.Now().Add(-since)}}}},
+			options.Find().SetSort(bson.D{{"ping", -1}}).SetProjection(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var docs []struct {
+		Host string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	hosts := make([]string, 0, len(docs))
+	for _, d := range docs {
+		hosts = append(hosts, d.Host)
+	}
+	return hosts, nil
+}
+
+// ClusterID returns the id of the cluster. It's the clusterId of
+// config.version for the sharded cluster and the replicaSetId otherwise.
+func ClusterID(ctx context.Context, m *mongo.Client) (string, error) {
+	ver := struct {
+		ClusterID primitive.ObjectID `bson:"clusterId"`
+	}{}
+	err := m.Database("config").Collection("version").FindOne(ctx, bson.D{}).Decode(&ver)
+	if err == nil {
+		return ver.ClusterID.Hex(), nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", errors.Wrap(err, "get config.version")
+	}
+
+	res := m.Database("admin").RunCommand(ctx, bson.D{{"replSetGetConfig", 1}})
+	if err := res.Err(); err != nil {
+		return "", errors.Wrap(err, "get replset config")
+	}
+	val := struct {
+		Config struct {
+			Settings struct {
+				ReplicaSetID primitive.ObjectID `bson:"replicaSetId"`
+			} `bson:"settings"`
+		} `bson:"config"`
+	}{}
+	if err := res.Decode(&val); err != nil {
+		return "", errors.Wrap(err, "decode replset config")
+	}
+
+	return val.Config.Settings.ReplicaSetID.Hex(), nil
+}
+
 // HasConfigShard return true if configsvr is listened in shards list
 func HasConfigShard(ctx context.Context, conn connect.Client) (bool, error) {
 	err := conn.MongoClient().Database("config").Collection("shards").
@@ -268,3 +326,60 @@ func ListShardedTimeseries(ctx context.Context, conn connect.Client) ([]string,
 
 	return nss, nil
 }
+
+// ShardedColl is the sharding of the collection (config.collections)
+type ShardedColl struct {
+	NS     string `bson:"ns" json:"ns"`
+	Key    bson.D `bson:"key" json:"key"`
+	Unique bool   `bson:"unique,omitempty" json:"unique,omitempty"`
+	// Zones is the number of the zone ranges of the collection
+	Zones int `bson:"zones,omitempty" json:"zones,omitempty"`
+}
+
+// ListShardedColls returns the sharded collections of the cluster by
+// the config database of m (a config server or mongos). The dropped,
+// unsplittable (tracked unsharded) and config.* collections are skipped.
+func ListShardedColls(ctx context.Context, m *mongo.Client) ([]ShardedColl, error) {
+	cfg := m.Database("config")
+	cur, err := cfg.Collection("collections").Find(ctx, bson.D{{"dropped", bson.M{"$ne": true}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "find config.collections")
+	}
+	var docs []struct {
+		NS           string `bson:"_id"`
+		Key          bson.D `bson:"key"`
+		Unique       bool   `bson:"unique"`
+		Unsplittable bool   `bson:"unsplittable"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, errors.Wrap(err, "decode config.collections")
+	}
+
+	cur, err = cfg.Collection("tags").Aggregate(ctx, mongo.Pipeline{
+		{{"$group", bson.D{{"_id", "$ns"}, {"n", bson.D{{"$sum", 1}}}}}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "count config.tags")
+	}
+	var zones []struct {
+		NS string `bson:"_id"`
+		N  int    `bson:"n"`
+	}
+	if err := cur.All(ctx, &zones); err != nil {
+		return nil, errors.Wrap(err, "decode config.tags")
+	}
+	nz := make(map[string]int, len(zones))
+	for _, z := range zones {
+		nz[z.NS] = z.N
+	}
+
+	rv := make([]ShardedColl, 0, len(docs))
+	for _, d := range docs {
+		if d.Unsplittable || strings.HasPrefix(d.NS, "config.") {
+			continue
+		}
+		rv = append(rv, ShardedColl{NS: d.NS, Key: d.Key, Unique: d.Unique, Zones: nz[d.NS]})
+	}
+
+	return rv, nil
+}
@@ -85,6 +85,7 @@ type NodeInfo struct {
 	Secondary                    bool                 `bson:"secondary,omitempty"`
 	Hidden                       bool                 `bson:"hidden,omitempty"`
 	Passive                      bool                 `bson:"passive,omitempty"`
+	Tags                         map[string]string    `bson:"tags,omitempty"`
 	ArbiterOnly                  bool                 `bson:"arbiterOnly"`
 	SecondaryDelayOld            int32                `bson:"slaveDelay"`
 	SecondaryDelaySecs           int32                `bson:"secondaryDelaySecs"`
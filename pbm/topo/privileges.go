@@ -0,0 +1,308 @@
+package topo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// PrivilegeOp is the operation the privileges of the PBM user are checked for
+type PrivilegeOp string
+
+const (
+	// PrivilegeOpAgent is what every operation and the agent itself needs
+	PrivilegeOpAgent   PrivilegeOp = "agent"
+	PrivilegeOpBackup  PrivilegeOp = "backup"
+	PrivilegeOpRestore PrivilegeOp = "restore"
+	// PrivilegeOpReplay is the oplog replay of the PITR restore
+	// and `pbm oplog-replay`
+	PrivilegeOpReplay PrivilegeOp = "replay"
+	PrivilegeOpPITR   PrivilegeOp = "pitr"
+)
+
+// anyActionRole is the custom role of the PBM user granting any action
+const anyActionRole = "pbmAnyAction"
+
+// Resource is the resource of the privilege. The empty DB or Collection
+// is any database or any not system collection.
+type Resource struct {
+	DB          string `bson:"db,omitempty" json:"db,omitempty"`
+	Collection  string `bson:"collection,omitempty" json:"collection,omitempty"`
+	Cluster     bool   `bson:"cluster,omitempty" json:"cluster,omitempty"`
+	AnyResource bool   `bson:"anyResource,omitempty" json:"anyResource,omitempty"`
+}
+
+func (r Resource) String() string {
+	switch {
+	case r.AnyResource:
+		return "any resource"
+	case r.Cluster:
+		return "cluster"
+	case r.DB == "" && r.Collection == "":
+		return "any collection"
+	case r.Collection == "":
+		return "database " + r.DB
+	case r.DB == "":
+		return "collection " + r.Collection + " of any database"
+	}
+
+	return "collection " + r.DB + "." + r.Collection
+}
+
+// covers returns true if the privilege on r applies to the resource o
+func (r Resource) covers(o Resource) bool {
+	switch {
+	case r.AnyResource:
+		return true
+	case o.AnyResource || o.Cluster || r.Cluster:
+		return r.Cluster && o.Cluster
+	case r.DB != "" && r.DB != o.DB:
+		return false
+	case r.Collection == "":
+		return o.Collection == "" || !strings.HasPrefix(o.Collection, "system.")
+	}
+
+	return r.Collection == o.Collection
+}
+
+// Privilege is the actions allowed on the resource
+type Privilege struct {
+	Resource Resource `bson:"resource" json:"resource"`
+	Actions  []string `bson:"actions" json:"actions"`
+}
+
+// GrantRole is the role granting the privileges
+type GrantRole struct {
+	Role string `bson:"role" json:"role"`
+	DB   string `bson:"db" json:"db"`
+}
+
+func (r GrantRole) String() string {
+	return r.Role + "@" + r.DB
+}
+
+type privilegeReq struct {
+	ops      []PrivilegeOp
+	resource Resource
+	actions  []string
+	role     GrantRole
+	// lead is checked on the connection to the cluster leader (the PBM
+	// control collections). Others are checked on the node.
+	lead bool
+}
+
+// privilegeReqs are the privileges the operations need. They are granted
+// by the roles of the PBM user recommended by the docs.
+var privilegeReqs = []privilegeReq{
+	{
+		ops:      []PrivilegeOp{PrivilegeOpAgent},
+		resource: Resource{DB: defs.DB},
+		actions:  []string{"find", "insert", "update", "remove", "createCollection", "createIndex"},
+		role:     GrantRole{"readWrite", defs.DB},
+		lead:     true,
+	},
+	{
+		ops:      []PrivilegeOp{PrivilegeOpAgent},
+		resource: Resource{Cluster: true},
+		actions:  []string{"serverStatus", "replSetGetStatus", "listDatabases"},
+		role:     GrantRole{"clusterMonitor", defs.DB},
+	},
+	{
+		ops:      []PrivilegeOp{PrivilegeOpBackup},
+		resource: Resource{},
+		actions:  []string{"find", "listCollections", "listIndexes"},
+		role:     GrantRole{"backup", defs.DB},
+	},
+	{
+		ops:      []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpPITR},
+		resource: Resource{DB: "local", Collection: "oplog.rs"},
+		actions:  []string{"find"},
+		role:     GrantRole{"backup", defs.DB},
+	},
+	{
+		ops:      []PrivilegeOp{PrivilegeOpRestore, PrivilegeOpReplay},
+		resource: Resource{},
+		actions:  []string{"insert", "createCollection", "createIndex", "dropCollection"},
+		role:     GrantRole{"restore", defs.DB},
+	},
+	{
+		ops:      []PrivilegeOp{PrivilegeOpReplay},
+		resource: Resource{AnyResource: true},
+		actions:  []string{"anyAction"},
+		role:     GrantRole{anyActionRole, defs.DB},
+	},
+}
+
+// MissingPrivilege is the actions the PBM user lacks on the resource
+type MissingPrivilege struct {
+	Ops      []PrivilegeOp `bson:"ops" json:"ops"`
+	Resource Resource      `bson:"resource" json:"resource"`
+	Actions  []string      `bson:"actions" json:"actions"`
+	Role     GrantRole     `bson:"role" json:"role"`
+}
+
+func (p MissingPrivilege) String() string {
+	return fmt.Sprintf("%s on %s (%s)", strings.Join(p.Actions, ", "), p.Resource, p.Role)
+}
+
+// Privileges is the result of the privileges check of the PBM user.
+type Privileges struct {
+	// User is user@db. Empty if the authentication is disabled.
+	User    string             `bson:"user,omitempty" json:"user,omitempty"`
+	Missing []MissingPrivilege `bson:"missing,omitempty" json:"missing,omitempty"`
+}
+
+// MissingFor returns the privileges missed for the ops.
+func (p *Privileges) MissingFor(ops ...PrivilegeOp) []MissingPrivilege {
+	if p == nil {
+		return nil
+	}
+
+	var rv []MissingPrivilege
+	for _, m := range p.Missing {
+		if slices.Contains(m.Ops, PrivilegeOpAgent) ||
+			slices.ContainsFunc(ops, func(op PrivilegeOp) bool { return slices.Contains(m.Ops, op) }) {
+			rv = append(rv, m)
+		}
+	}
+	return rv
+}
+
+// Check returns the error with the missed privileges of the ops and
+// the commands granting them. Nil if the user has all of them.
+func (p *Privileges) Check(ops ...PrivilegeOp) error {
+	missing := p.MissingFor(ops...)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	s := make([]string, len(missing))
+	for i, m := range missing {
+		s[i] = m.String()
+	}
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+
+	err := errors.Errorf("user %s has insufficient privileges for %s: missing %s. Grant them with: %s",
+		p.User, strings.Join(names, ", "), strings.Join(s, "; "), strings.Join(p.GrantCommands(ops...), "; "))
+	return errors.WithCode(err, errors.CodeInsufficientPrivileges)
+}
+
+// GrantCommands returns mongo shell commands granting the missed
+// privileges of the ops.
+func (p *Privileges) GrantCommands(ops ...PrivilegeOp) []string {
+	user, db, _ := strings.Cut(p.User, "@")
+
+	var roles []GrantRole
+	for _, m := range p.MissingFor(ops...) {
+		if !slices.Contains(roles, m.Role) {
+			roles = append(roles, m.Role)
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	var rv []string
+	grants := make([]string, len(roles))
+	for i, r := range roles {
+		if r.Role == anyActionRole {
+			rv = append(rv, fmt.Sprintf(`db.getSiblingDB(%q).createRole({role: %q, `+
+				`privileges: [{resource: {anyResource: true}, actions: ["anyAction"]}], roles: []})`,
+				r.DB, r.Role))
+		}
+		grants[i] = fmt.Sprintf("{role: %q, db: %q}", r.Role, r.DB)
+	}
+	rv = append(rv, fmt.Sprintf("db.getSiblingDB(%q).grantRolesToUser(%q, [%s])",
+		db, user, strings.Join(grants, ", ")))
+
+	return rv
+}
+
+// CheckPrivileges checks the privileges of the PBM user on the node
+// and on the cluster leader (the PBM control collections).
+func CheckPrivileges(ctx context.Context, node, lead *mongo.Client) (*Privileges, error) {
+	nodeInfo, err := userPrivileges(ctx, node)
+	if err != nil {
+		return nil, errors.Wrap(err, "node")
+	}
+	leadInfo := nodeInfo
+	if lead != node {
+		leadInfo, err = userPrivileges(ctx, lead)
+		if err != nil {
+			return nil, errors.Wrap(err, "leader")
+		}
+	}
+
+	return checkPrivileges(nodeInfo, leadInfo), nil
+}
+
+func userPrivileges(ctx context.Context, m *mongo.Client) (*AuthInfo, error) {
+	c := &ConnectionStatus{}
+	err := m.Database(defs.DB).RunCommand(ctx,
+		bson.D{{"connectionStatus", 1}, {"showPrivileges", true}}).Decode(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "run mongo command connectionStatus")
+	}
+
+	return &c.AuthInfo, nil
+}
+
+func checkPrivileges(node, lead *AuthInfo) *Privileges {
+	p := &Privileges{}
+	// the authentication is disabled
+	if len(node.Users) == 0 {
+		return p
+	}
+	p.User = node.Users[0].User + "@" + node.Users[0].DB
+
+	for _, req := range privilegeReqs {
+		info := node
+		if req.lead {
+			info = lead
+		}
+		if len(info.Users) == 0 {
+			continue
+		}
+
+		var missing []string
+		for _, a := range req.actions {
+			if !info.allows(req.resource, a) {
+				missing = append(missing, a)
+			}
+		}
+		if len(missing) != 0 {
+			p.Missing = append(p.Missing, MissingPrivilege{
+				Ops:      req.ops,
+				Resource: req.resource,
+				Actions:  missing,
+				Role:     req.role,
+			})
+		}
+	}
+
+	return p
+}
+
+// allows returns true if the user privileges allow the action on the resource
+func (a *AuthInfo) allows(r Resource, action string) bool {
+	for _, p := range a.UserPrivileges {
+		if !p.Resource.covers(r) {
+			continue
+		}
+		if slices.Contains(p.Actions, action) || slices.Contains(p.Actions, "anyAction") {
+			return true
+		}
+	}
+
+	return false
+}
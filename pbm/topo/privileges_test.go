@@ -0,0 +1,109 @@
+package topo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	users := []AuthUser{{User: "pbm", DB: "admin"}}
+	pbmRoles := []Privilege{
+		{Resource{DB: "admin"}, []string{"find", "insert", "update", "remove", "createCollection", "createIndex"}},
+		{Resource{Cluster: true}, []string{"serverStatus", "replSetGetStatus", "listDatabases"}},
+		{Resource{}, []string{"find", "listCollections", "listIndexes", "insert", "createCollection",
+			"createIndex", "dropCollection"}},
+		{Resource{DB: "local", Collection: "oplog.rs"}, []string{"find"}},
+	}
+
+	cases := []struct {
+		name  string
+		info  AuthInfo
+		ok    []PrivilegeOp
+		fails []PrivilegeOp
+	}{
+		{
+			name: "auth disabled",
+			info: AuthInfo{},
+			ok:   []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpRestore, PrivilegeOpReplay, PrivilegeOpPITR},
+		},
+		{
+			name: "root",
+			info: AuthInfo{Users: users, UserPrivileges: []Privilege{
+				{Resource{AnyResource: true}, []string{"anyAction"}},
+			}},
+			ok: []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpRestore, PrivilegeOpReplay, PrivilegeOpPITR},
+		},
+		{
+			name:  "no pbmAnyAction",
+			info:  AuthInfo{Users: users, UserPrivileges: pbmRoles},
+			ok:    []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpRestore, PrivilegeOpPITR},
+			fails: []PrivilegeOp{PrivilegeOpReplay},
+		},
+		{
+			name: "system collections don't count",
+			info: AuthInfo{Users: users, UserPrivileges: append(pbmRoles[:2:2],
+				Privilege{Resource{Collection: "system.js"}, []string{"find", "listCollections", "listIndexes"}},
+				Privilege{Resource{DB: "local", Collection: "oplog.rs"}, []string{"find"}},
+			)},
+			ok:    []PrivilegeOp{PrivilegeOpPITR},
+			fails: []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpRestore},
+		},
+		{
+			name:  "no admin access fails all",
+			info:  AuthInfo{Users: users, UserPrivileges: pbmRoles[1:]},
+			fails: []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpRestore, PrivilegeOpPITR},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := checkPrivileges(&c.info, &c.info)
+			for _, op := range c.ok {
+				if err := p.Check(op); err != nil {
+					t.Errorf("%s: unexpected error: %v", op, err)
+				}
+			}
+			for _, op := range c.fails {
+				err := p.Check(op)
+				if err == nil {
+					t.Errorf("%s: expected error", op)
+					continue
+				}
+				code := errors.CodeOf(errors.Wrapf(err, "check %s", op))
+				if code != errors.CodeInsufficientPrivileges {
+					t.Errorf("%s: want code %s, got %q", op, errors.CodeInsufficientPrivileges, code)
+				}
+			}
+		})
+	}
+}
+
+func TestGrantCommands(t *testing.T) {
+	p := &Privileges{
+		User: "pbm@admin",
+		Missing: []MissingPrivilege{
+			{Ops: []PrivilegeOp{PrivilegeOpBackup}, Actions: []string{"find"}, Role: GrantRole{"backup", "admin"}},
+			{Ops: []PrivilegeOp{PrivilegeOpBackup, PrivilegeOpPITR}, Actions: []string{"find"},
+				Resource: Resource{DB: "local", Collection: "oplog.rs"}, Role: GrantRole{"backup", "admin"}},
+			{Ops: []PrivilegeOp{PrivilegeOpReplay}, Actions: []string{"anyAction"},
+				Resource: Resource{AnyResource: true}, Role: GrantRole{anyActionRole, "admin"}},
+		},
+	}
+
+	if c := p.GrantCommands(PrivilegeOpRestore); len(c) != 0 {
+		t.Errorf("restore: expected no commands, got %v", c)
+	}
+
+	c := p.GrantCommands(PrivilegeOpBackup)
+	want := `db.getSiblingDB("admin").grantRolesToUser("pbm", [{role: "backup", db: "admin"}])`
+	if len(c) != 1 || c[0] != want {
+		t.Errorf("backup: expected [%s], got %v", want, c)
+	}
+
+	c = p.GrantCommands(PrivilegeOpReplay)
+	if len(c) != 2 || !strings.Contains(c[0], "createRole") || !strings.Contains(c[1], anyActionRole) {
+		t.Errorf("replay: expected createRole and grant, got %v", c)
+	}
+}
@@ -3,6 +3,7 @@ package topo
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -22,6 +23,8 @@ type ConnectionStatus struct {
 type AuthInfo struct {
 	Users     []AuthUser      `bson:"authenticatedUsers" json:"authenticatedUsers"`
 	UserRoles []AuthUserRoles `bson:"authenticatedUserRoles" json:"authenticatedUserRoles"`
+	// UserPrivileges are set only with `showPrivileges`
+	UserPrivileges []Privilege `bson:"authenticatedUserPrivileges,omitempty" json:"authenticatedUserPrivileges,omitempty"`
 }
 
 type AuthUser struct {
@@ -34,11 +37,17 @@ type AuthUserRoles struct {
 	DB   string `bson:"db" json:"db"`
 }
 
-func CheckTopoForBackup(ctx context.Context, m connect.Client, type_ defs.BackupType) error {
+func CheckTopoForBackup(ctx context.Context, m connect.Client, type_ defs.BackupType, rs string) error {
 	members, err := ClusterMembers(ctx, m.MongoClient())
 	if err != nil {
 		return errors.Wrap(err, "get cluster members")
 	}
+	if rs != "" {
+		members = slices.DeleteFunc(members, func(s Shard) bool { return s.RS != rs })
+		if len(members) == 0 {
+			return errors.Errorf("replset %q is not found in the cluster", rs)
+		}
+	}
 
 	ts, err := GetClusterTime(ctx, m)
 	if err != nil {
@@ -0,0 +1,77 @@
+package topo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+// writeConcernFailedCode is the error code of the write concern
+// timeout (wtimeout)
+const writeConcernFailedCode = 64
+
+// IsWriteConcernTimeout returns true if the write hasn't been replicated
+// within the wtimeout of its write concern.
+func IsWriteConcernTimeout(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(writeConcernFailedCode)
+}
+
+// WriteConcernError returns the error of the op naming the members of
+// the replset of m which lag behind the primary, if err is the write
+// concern timeout. Other errors are returned as is.
+func WriteConcernError(ctx context.Context, m *mongo.Client, op string, err error) error {
+	if !IsWriteConcernTimeout(err) {
+		return err
+	}
+
+	s, serr := GetReplsetStatus(ctx, m)
+	if serr != nil {
+		return errors.Wrapf(err, "%s: write concern timed out (lagging members are unknown: %v)", op, serr)
+	}
+
+	lagging := "none"
+	if l := laggingMembers(s); len(l) != 0 {
+		lagging = strings.Join(l, ", ")
+	}
+	return errors.Wrapf(err, "%s: write concern timed out, lagging members of %s: %s. "+
+		"Check the replication of the members or adjust cluster.writeConcern", op, s.Set, lagging)
+}
+
+// laggingMembers returns the data bearing members which are not healthy
+// secondaries or are behind the primary.
+func laggingMembers(s *ReplsetStatus) []string {
+	var primary *NodeStatus
+	for i := range s.Members {
+		if s.Members[i].State == defs.NodeStatePrimary {
+			primary = &s.Members[i]
+			break
+		}
+	}
+
+	var rv []string
+	for i := range s.Members {
+		m := &s.Members[i]
+		if m == primary || m.State == defs.NodeStateArbiter {
+			continue
+		}
+
+		if m.Health != defs.NodeHealthUp || m.State != defs.NodeStateSecondary {
+			rv = append(rv, fmt.Sprintf("%s (%s)", m.Name, m.StateStr))
+			continue
+		}
+		if primary == nil || primary.Optime == nil || m.Optime == nil {
+			continue
+		}
+		if lag := int64(primary.Optime.TS.T) - int64(m.Optime.TS.T); lag > 0 {
+			rv = append(rv, fmt.Sprintf("%s (%ds behind the primary)", m.Name, lag))
+		}
+	}
+
+	return rv
+}
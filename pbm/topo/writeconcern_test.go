@@ -0,0 +1,66 @@
+package topo
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+)
+
+func TestLaggingMembers(t *testing.T) {
+	optime := func(t uint32) *OpTime { return &OpTime{TS: primitive.Timestamp{T: t}} }
+	s := &ReplsetStatus{
+		Set: "rs1",
+		Members: []NodeStatus{
+			{Name: "rs101:27017", Health: defs.NodeHealthUp, State: defs.NodeStatePrimary, StateStr: "PRIMARY",
+				Optime: optime(1000)},
+			{Name: "rs102:27017", Health: defs.NodeHealthUp, State: defs.NodeStateSecondary, StateStr: "SECONDARY",
+				Optime: optime(1000)},
+			{Name: "rs103:27017", Health: defs.NodeHealthUp, State: defs.NodeStateSecondary, StateStr: "SECONDARY",
+				Optime: optime(970)},
+			{Name: "rs104:27017", Health: defs.NodeHealthDown, State: defs.NodeStateDown,
+				StateStr: "(not reachable/healthy)"},
+			{Name: "rs105:27017", Health: defs.NodeHealthUp, State: defs.NodeStateArbiter, StateStr: "ARBITER"},
+			{Name: "rs106:27017", Health: defs.NodeHealthUp, State: defs.NodeStateStartup2, StateStr: "STARTUP2",
+				Optime: optime(10)},
+		},
+	}
+
+	want := []string{
+		"rs103:27017 (30s behind the primary)",
+		"rs104:27017 ((not reachable/healthy))",
+		"rs106:27017 (STARTUP2)",
+	}
+	if got := laggingMembers(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestIsWriteConcernTimeout(t *testing.T) {
+	wtimeout := mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{
+		Name:    "WriteConcernFailed",
+		Code:    64,
+		Message: "waiting for replication timed out",
+	}}
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"wtimeout", wtimeout, true},
+		{"wrapped", errors.Wrap(wtimeout, "set replset status"), true},
+		{"command", mongo.CommandError{Code: 64, Name: "WriteConcernFailed"}, true},
+		{"other", mongo.CommandError{Code: 11000}, false},
+		{"no server error", errors.New("connection reset"), false},
+	}
+	for _, tc := range cases {
+		if got := IsWriteConcernTimeout(tc.err); got != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/config"
+	"github.com/percona/percona-backup-mongodb/pbm/connect"
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/topo"
+)
+
+// ErrStorageOwned is returned when the storage is claimed by another cluster
+var ErrStorageOwned = errors.New("storage is used by another cluster")
+
+// StorageOwner is the identity of the cluster which uses the storage.
+// It is kept in the defs.StorOwnerFile in the storage root, so clusters
+// sharing the same storage (bucket and prefix) don't mix their backups.
+type StorageOwner struct {
+	// ClusterID is the id of the config server or the replset
+	ClusterID string `json:"clusterID" yaml:"clusterID"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	ClaimedAt int64  `json:"claimedAt,omitempty" yaml:"claimedAt,omitempty"`
+}
+
+func (o *StorageOwner) String() string {
+	if o == nil {
+		return "none"
+	}
+	if o.Name == "" {
+		return o.ClusterID
+	}
+	return fmt.Sprintf("%s (%s)", o.Name, o.ClusterID)
+}
+
+// StorageOwnerError is the mismatch of the storage owner and the cluster
+type StorageOwnerError struct {
+	Owner   StorageOwner
+	Cluster StorageOwner
+}
+
+func (e StorageOwnerError) Error() string {
+	return fmt.Sprintf("%s: owner %s, claimed at %s; this cluster %s. "+
+		"Check the storage config. To take the storage over intentionally, "+
+		"use --force-claim-storage",
+		ErrStorageOwned, e.Owner.String(),
+		time.Unix(e.Owner.ClaimedAt, 0).UTC().Format(time.RFC3339),
+		e.Cluster.String())
+}
+
+func (StorageOwnerError) Is(err error) bool {
+	return err == ErrStorageOwned //nolint:errorlint
+}
+
+// ClusterOwner returns the owner identity of the current cluster
+func ClusterOwner(ctx context.Context, m connect.Client, cfg *config.Config) (*StorageOwner, error) {
+	id, err := topo.ClusterID(ctx, m.MongoClient())
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster id")
+	}
+
+	return &StorageOwner{ClusterID: id, Name: cfg.ClusterName()}, nil
+}
+
+// ReadStorageOwner reads the owner marker of the storage.
+// Nil is returned if the storage has no owner.
+func ReadStorageOwner(stg storage.Storage) (*StorageOwner, error) {
+	_, err := stg.FileStat(defs.StorOwnerFile)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "file stat")
+	}
+
+	r, err := stg.SourceReader(defs.StorOwnerFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	owner := &StorageOwner{}
+	err = json.Unmarshal(data, owner)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	return owner, nil
+}
+
+// ClaimStorage writes the owner marker, it replaces the existing one
+func ClaimStorage(stg storage.Storage, owner *StorageOwner) error {
+	data, err := json.MarshalIndent(owner, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	err = RetryableWrite(stg, defs.StorOwnerFile, data)
+	return errors.Wrap(err, "write owner file")
+}
+
+// CheckStorageOwner verifies that the storage belongs to the cluster.
+// The storage without the marker is claimed by the cluster. The marker
+// of another cluster is StorageOwnerError unless force is set, then
+// the storage is claimed over.
+//
+// Read-only storages are not checked: they are meant for the backups
+// of other clusters and are never written.
+func CheckStorageOwner(stg storage.Storage, cluster *StorageOwner, force bool) error {
+	if storage.IsReadOnly(stg) {
+		return nil
+	}
+
+	cur, err := ReadStorageOwner(stg)
+	if err != nil {
+		return errors.Wrap(err, "read storage owner")
+	}
+
+	claim, err := checkOwner(cur, cluster, force)
+	if err != nil || !claim {
+		return err
+	}
+
+	owner := *cluster
+	owner.ClaimedAt = time.Now().Unix()
+	if cur != nil && cur.ClusterID == cluster.ClusterID {
+		// renamed cluster
+		owner.ClaimedAt = cur.ClaimedAt
+	}
+
+	return ClaimStorage(stg, &owner)
+}
+
+// checkOwner returns true if the marker has to be (re)written
+func checkOwner(cur, cluster *StorageOwner, force bool) (bool, error) {
+	switch {
+	case cur == nil:
+		return true, nil
+	case cur.ClusterID != cluster.ClusterID:
+		if !force {
+			return false, StorageOwnerError{Owner: *cur, Cluster: *cluster}
+		}
+		return true, nil
+	}
+
+	return cur.Name != cluster.Name, nil
+}
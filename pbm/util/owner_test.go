@@ -0,0 +1,70 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/errors"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/util"
+)
+
+func TestCheckStorageOwner(t *testing.T) {
+	stg, err := fs.New(&fs.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	self := &util.StorageOwner{ClusterID: "65f0", Name: "prod"}
+	other := &util.StorageOwner{ClusterID: "77aa", Name: "staging"}
+
+	owner := func() *util.StorageOwner {
+		t.Helper()
+		o, err := util.ReadStorageOwner(stg)
+		if err != nil {
+			t.Fatalf("read owner: %v", err)
+		}
+		return o
+	}
+
+	if o := owner(); o != nil {
+		t.Fatalf("expected no owner, got %v", o)
+	}
+
+	if err := util.CheckStorageOwner(stg, self, false); err != nil {
+		t.Fatalf("claim empty storage: %v", err)
+	}
+	o := owner()
+	if o == nil || o.ClusterID != self.ClusterID || o.Name != self.Name || o.ClaimedAt == 0 {
+		t.Fatalf("unexpected owner after claim: %+v", o)
+	}
+	claimedAt := o.ClaimedAt
+
+	err = util.CheckStorageOwner(stg, other, false)
+	if !errors.Is(err, util.ErrStorageOwned) {
+		t.Fatalf("expected ErrStorageOwned, got %v", err)
+	}
+	if o := owner(); o.ClusterID != self.ClusterID {
+		t.Fatalf("owner is changed on mismatch: %+v", o)
+	}
+
+	// read-only storages are never claimed
+	if err := util.CheckStorageOwner(storage.ReadOnly(stg), other, false); err != nil {
+		t.Fatalf("read-only storage: %v", err)
+	}
+
+	renamed := &util.StorageOwner{ClusterID: self.ClusterID, Name: "production"}
+	if err := util.CheckStorageOwner(stg, renamed, false); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if o := owner(); o.Name != renamed.Name || o.ClaimedAt != claimedAt {
+		t.Fatalf("unexpected owner after rename: %+v", o)
+	}
+
+	if err := util.CheckStorageOwner(stg, other, true); err != nil {
+		t.Fatalf("force claim: %v", err)
+	}
+	if o := owner(); o.ClusterID != other.ClusterID || o.Name != other.Name {
+		t.Fatalf("unexpected owner after force claim: %+v", o)
+	}
+}
@@ -77,6 +77,27 @@ func MakeSelectedPred(nss []string) archive.NSFilterFn {
 	}
 }
 
+// MakeNSTierFunc returns the tier of a namespace by the list of patterns
+// ("db.coll", "db.*" or "*.coll"): the index of the first matching pattern.
+// Namespaces that match none are in the last tier (len(patterns)).
+// Returns nil if there are no patterns.
+func MakeNSTierFunc(patterns []string) archive.NSTierFn {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	return func(ns string) int {
+		db, coll, _ := strings.Cut(ns, ".")
+		for i, p := range patterns {
+			pdb, pcoll := ParseNS(p)
+			if (pdb == "" || pdb == db) && (pcoll == "" || pcoll == coll) {
+				return i
+			}
+		}
+		return len(patterns)
+	}
+}
+
 type ChunkSelector interface {
 	Add(bson.Raw)
 	Selected(bson.Raw) bool
@@ -117,3 +117,23 @@ func TestContainsSpecifiedColl(t *testing.T) {
 		})
 	}
 }
+
+func TestNSTierFunc(t *testing.T) {
+	if util.MakeNSTierFunc(nil) != nil {
+		t.Fatal("expected nil for no patterns")
+	}
+
+	tier := util.MakeNSTierFunc([]string{"app.sessions", "*.accounts", "app.*"})
+	for ns, want := range map[string]int{
+		"app.sessions":   0,
+		"app.accounts":   1,
+		"shop.accounts":  1,
+		"app.orders":     2,
+		"shop.orders":    3,
+		"sessions.other": 3,
+	} {
+		if got := tier(ns); got != want {
+			t.Errorf("%s: expected tier %d, got %d", ns, want, got)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/config"
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
 	"github.com/percona/percona-backup-mongodb/pbm/defs"
+	"github.com/percona/percona-backup-mongodb/pbm/encrypt"
 	"github.com/percona/percona-backup-mongodb/pbm/errors"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/azure"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/blackhole"
@@ -22,7 +24,36 @@ var ErrStorageUndefined = errors.New("storage undefined")
 
 // StorageFromConfig creates and returns a storage object based on a given config and node name.
 // Node name is used for fetching endpoint url from config for specific cluster member (node).
+//
+// Secret references in the credentials (see package secret) are resolved
+// with the environment of the current process.
+//
+// The storage is wrapped to limit the time of the operations (see
+// storage.WithTimeouts), to collect metrics and, if configured, to encrypt
+// the files and refuse writes (read-only). Use storage.Unwrap to get
+// the particular storage type.
 func StorageFromConfig(cfg *config.StorageConf, node string, l log.LogEvent) (storage.Storage, error) {
+	cfg, err := cfg.Resolve()
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve secrets")
+	}
+
+	stg, err := newStorage(cfg, node, log.WithComponent(l, "storage"))
+	if err != nil {
+		return nil, err
+	}
+	stg = storage.WithTimeouts(stg, cfg.Timeouts.Timeouts())
+	if cfg.Encryption != nil {
+		stg = encrypt.Storage(stg, encrypt.NewKeyring(cfg.Encryption))
+	}
+	if cfg.ReadOnly {
+		stg = storage.ReadOnly(stg)
+	}
+
+	return metrics.Storage(stg), nil
+}
+
+func newStorage(cfg *config.StorageConf, node string, l log.LogEvent) (storage.Storage, error) {
 	switch cfg.Type {
 	case storage.S3:
 		return s3.New(cfg.S3, node, l)
@@ -50,6 +81,27 @@ func GetStorage(ctx context.Context, m connect.Client, node string, l log.LogEve
 	return StorageFromConfig(&c.Storage, node, l)
 }
 
+// GetProfileStorage creates and returns the storage of the config profile.
+// The main storage is returned if the profile name is empty.
+func GetProfileStorage(
+	ctx context.Context,
+	m connect.Client,
+	profile string,
+	node string,
+	l log.LogEvent,
+) (storage.Storage, error) {
+	if profile == "" {
+		return GetStorage(ctx, m, node, l)
+	}
+
+	p, err := config.GetProfile(ctx, m, profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get profile %q", profile)
+	}
+
+	return StorageFromConfig(&p.Storage, node, l)
+}
+
 // Initialize write current PBM version to PBM init file.
 //
 // It does not handle "file already exists" error.
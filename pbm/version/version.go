@@ -16,7 +16,7 @@ import (
 )
 
 // current PBM version
-const version = "2.8.0"
+const version = "2.9.0"
 
 var (
 	platform  string
@@ -175,6 +175,68 @@ var BreakingChangesMap = map[defs.BackupType][]string{
 	defs.PhysicalBackup:    {},
 }
 
+// RestoreFeature is a format feature of the backup which older PBM
+// can't restore.
+type RestoreFeature string
+
+const (
+	// FeatureEncryption is the client-side encryption of the backup files
+	FeatureEncryption RestoreFeature = "encryption"
+	// FeatureReplsetStorage is the replset data on its own storage
+	FeatureReplsetStorage RestoreFeature = "replsetStorage"
+	// FeatureExcludedDBs is the physical backup with excluded databases
+	FeatureExcludedDBs RestoreFeature = "excludedDatabases"
+	// FeatureIntegrityMarkers is the .pbm.ok markers of the files on the
+	// filesystem storage
+	FeatureIntegrityMarkers RestoreFeature = "integrityMarkers"
+	// FeatureArtifactChecksums is the checksums file of the replset files
+	FeatureArtifactChecksums RestoreFeature = "artifactChecksums"
+)
+
+// restoreFeaturesMap is the first PBM version restoring the feature
+var restoreFeaturesMap = map[RestoreFeature]string{
+	FeatureEncryption:        "2.9.0",
+	FeatureReplsetStorage:    "2.9.0",
+	FeatureExcludedDBs:       "2.9.0",
+	FeatureIntegrityMarkers:  "2.9.0",
+	FeatureArtifactChecksums: "2.9.0",
+}
+
+// MinRestoreVersion returns the lowest PBM version which restores the
+// backup of the type made by PBM of the version pbmVer with the features.
+// It's the last breaking change not newer than pbmVer (see
+// BreakingChangesMap) or the newest of the features' versions.
+// Empty if any PBM version restores it.
+func MinRestoreVersion(t defs.BackupType, pbmVer string, features []RestoreFeature) string {
+	rv := ""
+	if pbmVer != "" {
+		for _, v := range BreakingChangesMap[t] {
+			if semver.Compare(canonify(v), canonify(pbmVer)) <= 0 {
+				rv = v
+			}
+		}
+	}
+
+	for _, f := range features {
+		v := restoreFeaturesMap[f]
+		if v != "" && (rv == "" || semver.Compare(canonify(v), canonify(rv)) > 0) {
+			rv = v
+		}
+	}
+
+	return rv
+}
+
+// SatisfiesMin returns true if the version v isn't lower than minv.
+// Any version satisfies the empty minv.
+func SatisfiesMin(v, minv string) bool {
+	if minv == "" {
+		return true
+	}
+
+	return semver.Compare(canonify(v), canonify(minv)) >= 0
+}
+
 type MongoVersion struct {
 	PSMDBVersion  string `bson:"psmdbVersion,omitempty"`
 	VersionString string `bson:"version"`
@@ -2,6 +2,8 @@ package version
 
 import (
 	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/defs"
 )
 
 func TestCompatibility(t *testing.T) {
@@ -118,3 +120,50 @@ func TestHasPhysicalFilesMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestMinRestoreVersion(t *testing.T) {
+	cases := []struct {
+		typ      defs.BackupType
+		pbmVer   string
+		features []RestoreFeature
+		expect   string
+	}{
+		{defs.LogicalBackup, "", nil, ""},
+		{defs.LogicalBackup, "1.4.0", nil, ""},
+		{defs.LogicalBackup, "2.7.0", nil, "1.5.0"},
+		{defs.IncrementalBackup, "2.1.0", nil, "2.1.0"},
+		{defs.PhysicalBackup, "2.7.0", nil, ""},
+		{defs.PhysicalBackup, "2.8.0", []RestoreFeature{FeatureExcludedDBs}, "2.9.0"},
+		{defs.LogicalBackup, "2.9.0", []RestoreFeature{FeatureEncryption, FeatureReplsetStorage}, "2.9.0"},
+		{defs.LogicalBackup, "2.9.0", []RestoreFeature{FeatureIntegrityMarkers, FeatureArtifactChecksums}, "2.9.0"},
+		{defs.LogicalBackup, "2.8.0", []RestoreFeature{"unknown"}, "1.5.0"},
+	}
+
+	for _, c := range cases {
+		got := MinRestoreVersion(c.typ, c.pbmVer, c.features)
+		if got != c.expect {
+			t.Errorf("%s %q %v - expected %q, got %q", c.typ, c.pbmVer, c.features, c.expect, got)
+		}
+	}
+}
+
+func TestSatisfiesMin(t *testing.T) {
+	cases := []struct {
+		v      string
+		minv   string
+		expect bool
+	}{
+		{"2.7.0", "", true},
+		{"v2.8.0", "2.8.0", true},
+		{"2.9.1", "2.8.0", true},
+		{"v2.7.3", "2.8.0", false},
+		{"2.8.0-dev", "2.8.0", true},
+	}
+
+	for _, c := range cases {
+		got := SatisfiesMin(c.v, c.minv)
+		if got != c.expect {
+			t.Errorf("%q >= %q - expected %v, got %v", c.v, c.minv, c.expect, got)
+		}
+	}
+}
@@ -3,11 +3,12 @@ package cli
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm/connect"
@@ -43,6 +44,13 @@ type Node struct {
 	PrioBcp  float64
 	OK       bool
 	Errs     []error
+	// Privileges is the privileges check of the PBM user of the agent
+	Privileges *topo.Privileges
+	// ClockOffset is the offset of the agent clock from the cluster time.
+	// Nil if the agent doesn't report it.
+	ClockOffset *time.Duration
+	// ClockSkewed is true if ClockOffset is beyond `agent.clockSkew.maxSec`
+	ClockSkewed bool
 }
 
 func (n Node) IsAgentLost() bool {
@@ -144,6 +152,12 @@ func ClusterStatus(
 					node.Role = RSRole(agent.StateStr)
 				}
 
+				node.Privileges = agent.Privileges
+				if agent.ClockOffsetSec != nil {
+					d, skewed := agent.ClockSkew(cfg.Agent.MaxClockSkew())
+					node.ClockOffset = &d
+					node.ClockSkewed = skewed
+				}
 				if agent.IsStale(clusterTime) {
 					node.Errs = []error{LostAgentError{agent.Heartbeat}}
 					continue
@@ -166,31 +180,34 @@ func ClusterStatus(
 type RSConfGetter string
 
 func (g RSConfGetter) Get(ctx context.Context, host string) (*topo.RSConfig, error) {
+	conn, err := ConnectRS(ctx, string(g), host)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Disconnect(context.Background()) }()
+
+	return topo.GetReplSetConfig(ctx, conn)
+}
+
+// ConnectRS connects to the replset host (`rs/host:port,...`) with
+// the credentials and options of the cluster mongo-uri.
+func ConnectRS(ctx context.Context, uri, host string) (*mongo.Client, error) {
 	rsName, host, ok := strings.Cut(host, "/")
 	if !ok {
 		host = rsName
 	}
 
-	if !strings.HasPrefix(string(g), "mongodb://") {
-		g = "mongodb://" + g
-	}
-	curi, err := url.Parse(string(g))
-	if err != nil {
-		return nil, errors.Wrapf(err, "parse mongo-uri '%s'", g)
-	}
-
 	// Preserving the `replicaSet` parameter will cause an error
 	// while connecting to the ConfigServer (mismatched replicaset names)
-	query := curi.Query()
-	query.Del("replicaSet")
-	curi.RawQuery = query.Encode()
-	curi.Host = host
+	curi, err := connect.HostsURI(uri, []string{host}, "replicaSet")
+	if err != nil {
+		return nil, err
+	}
 
-	conn, err := connect.MongoConnect(ctx, curi.String(), connect.AppName("pbm-sdk"))
+	conn, err := connect.MongoConnect(ctx, curi, connect.AppName("pbm-sdk"))
 	if err != nil {
 		return nil, errors.Wrap(err, "connect")
 	}
-	defer func() { _ = conn.Disconnect(context.Background()) }()
 
-	return topo.GetReplSetConfig(ctx, conn)
+	return conn, nil
 }
@@ -32,6 +32,8 @@ var (
 	ErrNonIncrementalBackup = backup.ErrNonIncrementalBackup
 	ErrNotBaseIncrement     = backup.ErrNotBaseIncrement
 	ErrBaseForPITR          = backup.ErrBaseForPITR
+	ErrReadOnlyStorage      = backup.ErrReadOnlyStorage
+	ErrBackupHeld           = backup.ErrBackupHeld
 )
 
 type Client struct {
@@ -176,21 +178,35 @@ func (c *Client) getBackupHelper(
 }
 
 func (c *Client) fillFilelistForBackup(ctx context.Context, bcp *BackupMetadata) error {
-	var err error
-	var stg storage.Storage
-
 	eg, _ := errgroup.WithContext(ctx)
 	eg.SetLimit(runtime.NumCPU())
 
-	if version.HasFilelistFile(bcp.PBMVersion) {
-		stg, err = util.StorageFromConfig(&bcp.Store.StorageConf, c.node, log.LogEventFromContext(ctx))
+	// storages by the name. increments are stored
+	// on the same storages as the base backup
+	stgs := make(map[string]storage.Storage)
+	getStorage := func(bcp *backup.BackupMeta, rs string) (storage.Storage, error) {
+		st := bcp.RSStorage(rs)
+		if stg, ok := stgs[st.Name]; ok {
+			return stg, nil
+		}
+
+		stg, err := util.StorageFromConfig(&st.StorageConf, c.node, log.LogEventFromContext(ctx))
 		if err != nil {
-			return errors.Wrap(err, "get storage")
+			return nil, errors.Wrap(err, "get storage")
 		}
+		stgs[st.Name] = stg
+		return stg, nil
+	}
 
+	if version.HasFilelistFile(bcp.PBMVersion) {
 		for i := range bcp.Replsets {
 			rs := &bcp.Replsets[i]
 
+			stg, err := getStorage(bcp, rs.Name)
+			if err != nil {
+				return err
+			}
+
 			eg.Go(func() error {
 				filelist, err := backup.ReadFilelistForReplset(stg, bcp.Name, rs.Name)
 				if err != nil {
@@ -214,17 +230,14 @@ func (c *Client) fillFilelistForBackup(ctx context.Context, bcp *BackupMetadata)
 				continue
 			}
 
-			if stg == nil {
-				// in case if it is the first backup made with filelist file
-				stg, err = c.getStorageForRead(ctx, bcp)
-				if err != nil {
-					return errors.Wrap(err, "get storage")
-				}
-			}
-
 			for i := range bcp.Replsets {
 				rs := &bcp.Replsets[i]
 
+				stg, err := getStorage(bcp, rs.Name)
+				if err != nil {
+					return err
+				}
+
 				eg.Go(func() error {
 					filelist, err := backup.ReadFilelistForReplset(stg, bcp.Name, rs.Name)
 					if err != nil {
@@ -241,19 +254,6 @@ func (c *Client) fillFilelistForBackup(ctx context.Context, bcp *BackupMetadata)
 	return eg.Wait()
 }
 
-func (c *Client) getStorageForRead(ctx context.Context, bcp *backup.BackupMeta) (storage.Storage, error) {
-	stg, err := util.StorageFromConfig(&bcp.Store.StorageConf, c.node, log.LogEventFromContext(ctx))
-	if err != nil {
-		return nil, errors.Wrap(err, "get storage")
-	}
-	err = storage.HasReadAccess(ctx, stg)
-	if err != nil && !errors.Is(err, storage.ErrUninitialized) {
-		return nil, errors.Wrap(err, "check storage access")
-	}
-
-	return stg, nil
-}
-
 func (c *Client) GetRestoreByName(ctx context.Context, name string) (*RestoreMetadata, error) {
 	return restore.GetRestoreMeta(ctx, c.conn, name)
 }
@@ -267,6 +267,14 @@ func (c *Client) SyncFromStorage(ctx context.Context) (CommandID, error) {
 	return CommandID(opid.String()), err
 }
 
+// ClaimStorage resyncs the storage of the profile (the main storage
+// if the name is empty) and makes the cluster its owner
+// even if the storage is claimed by another cluster.
+func (c *Client) ClaimStorage(ctx context.Context, profile string) (CommandID, error) {
+	opid, err := ctrl.SendClaimStorage(ctx, c.conn, profile)
+	return CommandID(opid.String()), err
+}
+
 func (c *Client) SyncFromExternalStorage(ctx context.Context, name string) (CommandID, error) {
 	if name == "" {
 		return NoOpID, errors.New("name is not provided")
@@ -296,6 +304,16 @@ func (c *Client) ClearSyncFromAllExternalStorages(ctx context.Context) (CommandI
 }
 
 func (c *Client) DeleteBackupByName(ctx context.Context, name string) (CommandID, error) {
+	return c.deleteBackupByName(ctx, name, false)
+}
+
+// DeleteHeldBackup deletes the backup even if it (or a backup of its
+// incremental chain) is on hold.
+func (c *Client) DeleteHeldBackup(ctx context.Context, name string) (CommandID, error) {
+	return c.deleteBackupByName(ctx, name, true)
+}
+
+func (c *Client) deleteBackupByName(ctx context.Context, name string, overrideHold bool) (CommandID, error) {
 	opts := GetBackupByNameOptions{FetchIncrements: true}
 	bcp, err := c.GetBackupByName(ctx, name, opts)
 	if err != nil {
@@ -306,11 +324,11 @@ func (c *Client) DeleteBackupByName(ctx context.Context, name string) (CommandID
 	} else {
 		err = CanDeleteBackup(ctx, c, bcp)
 	}
-	if err != nil {
+	if err != nil && !(overrideHold && errors.Is(err, ErrBackupHeld)) {
 		return NoOpID, err
 	}
 
-	opid, err := ctrl.SendDeleteBackupByName(ctx, c.conn, name)
+	opid, err := ctrl.SendDeleteBackup(ctx, c.conn, ctrl.DeleteBackupCmd{Backup: name, OverrideHold: overrideHold})
 	return CommandID(opid.String()), err
 }
 
@@ -328,6 +346,34 @@ func (c *Client) DeleteOplogRange(ctx context.Context, until Timestamp) (Command
 	return CommandID(opid.String()), err
 }
 
+func (c *Client) DeleteOplog(ctx context.Context, options DeleteOplogOptions) (CommandID, error) {
+	cmd := ctrl.DeletePITRCmd{
+		OlderThan: int64(options.OlderThan.T),
+		RS:        options.RS,
+		Mode:      ctrl.DeletePITROlderThan,
+	}
+	if options.Range {
+		cmd = ctrl.DeletePITRCmd{
+			RS:   options.RS,
+			Mode: ctrl.DeletePITRRange,
+			From: options.From,
+			To:   options.To,
+		}
+	}
+	opid, err := ctrl.SendDeletePITR(ctx, c.conn, cmd)
+	return CommandID(opid.String()), err
+}
+
+func (c *Client) CompactOplogRange(
+	ctx context.Context,
+	until Timestamp,
+	maxSize int64,
+	maxSpan time.Duration,
+) (CommandID, error) {
+	opid, err := ctrl.SendPITRCompact(ctx, c.conn, until, maxSize, maxSpan)
+	return CommandID(opid.String()), err
+}
+
 func (c *Client) CleanupReport(ctx context.Context, beforeTS Timestamp) (CleanupReport, error) {
 	return backup.MakeCleanupInfo(ctx, c.conn, beforeTS)
 }
@@ -337,11 +383,59 @@ func (c *Client) RunCleanup(ctx context.Context, beforeTS Timestamp) (CommandID,
 	return CommandID(opid.String()), err
 }
 
+// OrphanReport lists orphaned storage files, dangling backups and chunks
+// metadata, and incomplete uploads older than grace.
+func (c *Client) OrphanReport(ctx context.Context, grace time.Duration) (*OrphanReport, error) {
+	stg, err := util.GetStorage(ctx, c.conn, c.node, log.LogEventFromContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+
+	return backup.FindOrphans(ctx, c.conn, stg, time.Now().Add(-grace))
+}
+
+func (c *Client) RunOrphanCleanup(ctx context.Context, grace time.Duration) (CommandID, error) {
+	opid, err := ctrl.SendCleanupOrphans(ctx, c.conn, grace)
+	return CommandID(opid.String()), err
+}
+
 func (c *Client) CancelBackup(ctx context.Context) (CommandID, error) {
 	opid, err := ctrl.SendCancelBackup(ctx, c.conn)
 	return CommandID(opid.String()), err
 }
 
+// Rebalance distributes chunks of the largest sharded collections
+// restored by the restore over the shards.
+func (c *Client) Rebalance(ctx context.Context, restore string, skew float64, collections int) (CommandID, error) {
+	opid, err := ctrl.SendRebalance(ctx, c.conn, ctrl.RebalanceCmd{
+		Restore:     restore,
+		Skew:        skew,
+		Collections: collections,
+	})
+	return CommandID(opid.String()), err
+}
+
+func (c *Client) CancelRebalance(ctx context.Context) (CommandID, error) {
+	opid, err := ctrl.SendCancelRebalance(ctx, c.conn)
+	return CommandID(opid.String()), err
+}
+
+// MigrateStorage moves backups and PITR chunks between the storages.
+// Only the backup is moved if it is set.
+func (c *Client) MigrateStorage(
+	ctx context.Context,
+	from, to, backup string,
+	deleteSource bool,
+) (CommandID, error) {
+	opid, err := ctrl.SendStorageMigrate(ctx, c.conn, ctrl.MigrateCmd{
+		From:         from,
+		To:           to,
+		Backup:       backup,
+		DeleteSource: deleteSource,
+	})
+	return CommandID(opid.String()), err
+}
+
 func (c *Client) RunLogicalBackup(ctx context.Context, options LogicalBackupOptions) (CommandID, error) {
 	return NoOpID, ErrNotImplemented
 }
@@ -361,7 +455,7 @@ func (c *Client) Restore(ctx context.Context, backupName string, clusterTS Times
 var ErrStaleHearbeat = errors.New("stale heartbeat")
 
 func (c *Client) OpLocks(ctx context.Context) ([]OpLock, error) {
-	locks, err := lock.GetLocks(ctx, c.conn, &lock.LockHeader{})
+	locks, err := lock.GetOperationLocks(ctx, c.conn, &lock.LockHeader{})
 	if err != nil {
 		return nil, errors.Wrap(err, "get locks")
 	}
@@ -375,6 +469,7 @@ func (c *Client) OpLocks(ctx context.Context) ([]OpLock, error) {
 		return nil, errors.Wrap(err, "get cluster time")
 	}
 
+	staleSec := lock.StaleSec(ctx, c.conn)
 	rv := make([]OpLock, len(locks))
 	for i := range locks {
 		rv[i].OpID = CommandID(locks[i].OPID)
@@ -382,8 +477,10 @@ func (c *Client) OpLocks(ctx context.Context) ([]OpLock, error) {
 		rv[i].Replset = locks[i].Replset
 		rv[i].Node = locks[i].Node
 		rv[i].Heartbeat = locks[i].Heartbeat
+		rv[i].Scope = locks[i].OpScope()
+		rv[i].Storage = locks[i].Storage
 
-		if rv[i].Heartbeat.T+defs.StaleFrameSec < clusterTime.T {
+		if locks[i].IsStale(clusterTime, staleSec) {
 			rv[i].err = ErrStaleHearbeat
 		}
 	}
@@ -392,6 +489,7 @@ func (c *Client) OpLocks(ctx context.Context) ([]OpLock, error) {
 
 // waitOp waits until operations which acquires a given lock are finished
 func waitOp(ctx context.Context, conn connect.Client, lck *lock.LockHeader) error {
+	staleSec := lock.StaleSec(ctx, conn)
 	tick := time.NewTicker(time.Second)
 	defer tick.Stop()
 
@@ -415,7 +513,7 @@ func waitOp(ctx context.Context, conn connect.Client, lck *lock.LockHeader) erro
 				return errors.Wrap(err, "read cluster time")
 			}
 
-			if clusterTime.T-lock.Heartbeat.T >= defs.StaleFrameSec {
+			if lock.IsStale(clusterTime, staleSec) {
 				return errors.Errorf("operation stale, last beat ts: %d", lock.Heartbeat.T)
 			}
 		}
@@ -43,10 +43,21 @@ const (
 	CmdDeleteBackup = ctrl.CmdDeleteBackup
 	CmdDeletePITR   = ctrl.CmdDeletePITR
 	CmdCleanup      = ctrl.CmdCleanup
+	CmdPITRCompact  = ctrl.CmdPITRCompact
+	CmdRebalance    = ctrl.CmdRebalance
+	CmdMigrate      = ctrl.CmdStorageMigrate
 )
 
 var NoOpID = CommandID(ctrl.NilOPID.String())
 
+type LockScope = lock.Scope
+
+const (
+	LockScopeCluster = lock.ScopeCluster
+	LockScopeReplset = lock.ScopeReplset
+	LockScopeRead    = lock.ScopeRead
+)
+
 type BackupType = defs.BackupType
 
 const (
@@ -75,9 +86,11 @@ const (
 type (
 	Config          = config.Config
 	BackupMetadata  = backup.BackupMeta
+	KeptBackup      = backup.KeptBackup
 	RestoreMetadata = restore.RestoreMeta
 	OplogChunk      = oplog.OplogChunk
 	CleanupReport   = backup.CleanupInfo
+	OrphanReport    = backup.OrphanReport
 )
 
 type LogicalBackupOptions struct {
@@ -110,6 +123,19 @@ type DeleteBackupBeforeOptions struct {
 	Type BackupType
 }
 
+// DeleteOplogOptions selects PITR chunks to delete: older than OlderThan
+// or, if it is not set, overlapping the [From, To] range.
+// If RS is set, only chunks of the replset are deleted.
+type DeleteOplogOptions struct {
+	OlderThan Timestamp
+	RS        string
+	// Range deletes the chunks within From - To instead of the ones
+	// older than OlderThan
+	Range bool
+	From  Timestamp
+	To    Timestamp
+}
+
 // OpLock represents internal PBM lock.
 //
 // Some commands can have many locks (one lock per replset).
@@ -124,6 +150,11 @@ type OpLock struct {
 	Node string `json:"node,omitempty"`
 	// Heartbeat is the last cluster time seen by an agent that acquired the lock.
 	Heartbeat primitive.Timestamp `json:"hb"`
+	// Scope defines which operations can run along with this one.
+	Scope LockScope `json:"scope,omitempty"`
+	// Storage is the config profile name the operation works with.
+	// Empty is for the main storage.
+	Storage string `json:"storage,omitempty"`
 
 	err error
 }
@@ -192,6 +223,21 @@ func WaitForDeleteOplogRange(ctx context.Context, client *Client) error {
 	return waitOp(ctx, client.conn, lck)
 }
 
+func WaitForCompactOplogRange(ctx context.Context, client *Client) error {
+	lck := &lock.LockHeader{Type: ctrl.CmdPITRCompact}
+	return waitOp(ctx, client.conn, lck)
+}
+
+func WaitForRebalance(ctx context.Context, client *Client) error {
+	lck := &lock.LockHeader{Type: ctrl.CmdRebalance}
+	return waitOp(ctx, client.conn, lck)
+}
+
+func WaitForStorageMigrate(ctx context.Context, client *Client) error {
+	lck := &lock.LockHeader{Type: ctrl.CmdStorageMigrate}
+	return waitOp(ctx, client.conn, lck)
+}
+
 func WaitForErrorLog(ctx context.Context, client *Client, cmd *Command) (string, error) {
 	return lastLogErr(ctx, client.conn, cmd.Cmd, cmd.TS)
 }
@@ -218,6 +264,17 @@ func ListDeleteBackupBefore(
 	return backup.ListDeleteBackupBefore(ctx, client.conn, ts, bcpType)
 }
 
+// ListKeptBackupsBefore returns backups created before ts that cannot be
+// deleted because the PITR window or incremental chains depend on them.
+func ListKeptBackupsBefore(
+	ctx context.Context,
+	client *Client,
+	ts primitive.Timestamp,
+	bcpType BackupType,
+) ([]KeptBackup, error) {
+	return backup.ListKeptBackupsBefore(ctx, client.conn, ts, bcpType)
+}
+
 func ListDeleteChunksBefore(
 	ctx context.Context,
 	client *Client,
@@ -227,6 +284,17 @@ func ListDeleteChunksBefore(
 	return r.Chunks, err
 }
 
+func ListDeleteChunks(
+	ctx context.Context,
+	client *Client,
+	options DeleteOplogOptions,
+) ([]OplogChunk, error) {
+	if options.Range {
+		return backup.ListPITRChunksInRange(ctx, client.conn, options.RS, options.From, options.To)
+	}
+	return backup.ListPITRChunksToDelete(ctx, client.conn, options.RS, options.OlderThan)
+}
+
 func ParseDeleteBackupType(s string) (BackupType, error) {
 	return backup.ParseDeleteBackupType(s)
 }